@@ -10,14 +10,15 @@ import (
 	"path"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/alecthomas/kingpin/v2"
 	"github.com/alecthomas/units"
 	extflag "github.com/efficientgo/tools/extkingpin"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/oklog/run"
+	"github.com/oklog/ulid/v2"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
@@ -26,13 +27,12 @@ import (
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb"
+	"gopkg.in/yaml.v2"
 
-	"github.com/thanos-io/objstore"
-	"github.com/thanos-io/objstore/client"
-	objstoretracing "github.com/thanos-io/objstore/tracing/opentracing"
-
+	"github.com/thanos-io/thanos/pkg/activetracker"
 	blocksAPI "github.com/thanos-io/thanos/pkg/api/blocks"
 	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/attestation"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/compact"
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
@@ -43,11 +43,13 @@ import (
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
 	"github.com/thanos-io/thanos/pkg/logging"
 	"github.com/thanos-io/thanos/pkg/logutil"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
 	"github.com/thanos-io/thanos/pkg/store"
 	"github.com/thanos-io/thanos/pkg/strutil"
+	"github.com/thanos-io/thanos/pkg/tenancy"
 	"github.com/thanos-io/thanos/pkg/tracing"
 	"github.com/thanos-io/thanos/pkg/ui"
 )
@@ -110,6 +112,8 @@ type compactMetrics struct {
 	blockCleanupFailures        prometheus.Counter
 	blocksMarked                *prometheus.CounterVec
 	garbageCollectedBlocks      prometheus.Counter
+	smallPlansSkipped           prometheus.Counter
+	storageClassTransitions     *prometheus.CounterVec
 }
 
 func newCompactMetrics(reg *prometheus.Registry, deleteDelay time.Duration) *compactMetrics {
@@ -158,11 +162,19 @@ func newCompactMetrics(reg *prometheus.Registry, deleteDelay time.Duration) *com
 	m.blocksMarked.WithLabelValues(metadata.NoCompactMarkFilename, metadata.OutOfOrderChunksNoCompactReason)
 	m.blocksMarked.WithLabelValues(metadata.NoCompactMarkFilename, metadata.IndexSizeExceedingNoCompactReason)
 	m.blocksMarked.WithLabelValues(metadata.DeletionMarkFilename, "")
+	m.storageClassTransitions = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_compact_storage_class_transitions_total",
+		Help: "Total number of blocks moved to a storage class.",
+	}, []string{"class"})
 
 	m.garbageCollectedBlocks = promauto.With(reg).NewCounter(prometheus.CounterOpts{
 		Name: "thanos_compact_garbage_collected_blocks_total",
 		Help: "Total number of blocks marked for deletion by compactor.",
 	})
+	m.smallPlansSkipped = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_compact_small_plans_skipped_total",
+		Help: "Total number of compaction plans skipped because their blocks are below --compact.min-plan-size.",
+	})
 	return m
 }
 
@@ -185,10 +197,16 @@ func runCompact(
 		prober.NewInstrumentation(component, logger, extprom.WrapRegistererWithPrefix("thanos_", reg)),
 	)
 
+	rbac, err := newRBAC(reg, conf.httpRBAC, tenancy.DefaultTenantHeader, tenancy.DefaultTenant, "")
+	if err != nil {
+		return err
+	}
 	srv := httpserver.New(logger, reg, component, httpProbe,
 		httpserver.WithListen(conf.http.bindAddress),
 		httpserver.WithGracePeriod(time.Duration(conf.http.gracePeriod)),
 		httpserver.WithTLSConfig(conf.http.tlsConfig),
+		httpserver.WithLogLevels(logLevels),
+		httpserver.WithRBAC(rbac),
 	)
 
 	g.Add(func() error {
@@ -206,12 +224,26 @@ func runCompact(
 	if err != nil {
 		return err
 	}
-
-	bkt, err := client.NewBucket(logger, confContentYaml, component.String(), nil)
+	encryptionConfContentYaml, err := conf.objStoreEncryption.Content()
+	if err != nil {
+		return err
+	}
+	attestationConfContentYaml, err := conf.blockAttestation.Content()
+	if err != nil {
+		return err
+	}
+	attestationConf, err := attestation.ParseConfig(attestationConfContentYaml)
+	if err != nil {
+		return err
+	}
+	resilienceConfContentYaml, err := conf.objStoreResilience.Content()
+	if err != nil {
+		return err
+	}
+	resilienceConf, err := objstoreutil.ParseResilienceConfig(resilienceConfContentYaml)
 	if err != nil {
 		return err
 	}
-	insBkt := objstoretracing.WrapWithTraces(objstore.WrapWithMetrics(bkt, extprom.WrapRegistererWithPrefix("thanos_", reg), bkt.Name()))
 
 	relabelContentYaml, err := conf.selectorRelabelConf.Content()
 	if err != nil {
@@ -223,36 +255,25 @@ func runCompact(
 		return err
 	}
 
-	// Ensure we close up everything properly.
-	defer func() {
-		if err != nil {
-			runutil.CloseWithLogOnErr(logger, insBkt, "bucket client")
+	bucketsContentYaml, err := conf.bucketsConf.Content()
+	if err != nil {
+		return errors.Wrap(err, "get content of compaction buckets configuration")
+	}
+	var bucketConfs []compactBucketConfig
+	if len(bucketsContentYaml) > 0 {
+		if bucketConfs, err = parseCompactBucketsConfig(bucketsContentYaml); err != nil {
+			return err
 		}
-	}()
-
-	// While fetching blocks, we filter out blocks that were marked for deletion by using IgnoreDeletionMarkFilter.
-	// The delay of deleteDelay/2 is added to ensure we fetch blocks that are meant to be deleted but do not have a replacement yet.
-	// This is to make sure compactor will not accidentally perform compactions with gap instead.
-	ignoreDeletionMarkFilter := block.NewIgnoreDeletionMarkFilter(logger, insBkt, deleteDelay/2, conf.blockMetaFetchConcurrency)
-	duplicateBlocksFilter := block.NewDeduplicateFilter(conf.blockMetaFetchConcurrency)
-	noCompactMarkerFilter := compact.NewGatherNoCompactionMarkFilter(logger, insBkt, conf.blockMetaFetchConcurrency)
-	noDownsampleMarkerFilter := downsample.NewGatherNoDownsampleMarkFilter(logger, insBkt, conf.blockMetaFetchConcurrency)
-	labelShardedMetaFilter := block.NewLabelShardedMetaFilter(relabelConfig)
-	consistencyDelayMetaFilter := block.NewConsistencyDelayMetaFilter(logger, conf.consistencyDelay, extprom.WrapRegistererWithPrefix("thanos_", reg))
-	timePartitionMetaFilter := block.NewTimePartitionMetaFilter(conf.filterConf.MinTime, conf.filterConf.MaxTime)
-
-	var blockLister block.Lister
-	switch syncStrategy(conf.blockListStrategy) {
-	case concurrentDiscovery:
-		blockLister = block.NewConcurrentLister(logger, insBkt)
-	case recursiveDiscovery:
-		blockLister = block.NewRecursiveLister(logger, insBkt)
-	default:
-		return errors.Errorf("unknown sync strategy %s", conf.blockListStrategy)
 	}
-	baseMetaFetcher, err := block.NewBaseFetcher(logger, conf.blockMetaFetchConcurrency, insBkt, blockLister, conf.dataDir, extprom.WrapRegistererWithPrefix("thanos_", reg))
+	storageClassesContentYaml, err := conf.storageClassesConf.Content()
 	if err != nil {
-		return errors.Wrap(err, "create meta fetcher")
+		return errors.Wrap(err, "get content of storage classes configuration")
+	}
+	var storageClassConfs []compact.StorageClassConfig
+	if len(storageClassesContentYaml) > 0 {
+		if storageClassConfs, err = compact.ParseStorageClassesConfig(storageClassesContentYaml); err != nil {
+			return err
+		}
 	}
 
 	enableVerticalCompaction := conf.enableVerticalCompaction
@@ -268,48 +289,19 @@ func runCompact(
 			"msg", "vertical compaction is enabled", "compact.enable-vertical-compaction", fmt.Sprintf("%v", conf.enableVerticalCompaction),
 		)
 	}
-	var (
-		api = blocksAPI.NewBlocksAPI(logger, conf.webConf.disableCORS, conf.label, flagsMap, insBkt)
-		sy  *compact.Syncer
-	)
-	{
-		filters := []block.MetadataFilter{
-			timePartitionMetaFilter,
-			labelShardedMetaFilter,
-			consistencyDelayMetaFilter,
-			ignoreDeletionMarkFilter,
-			block.NewReplicaLabelRemover(logger, dedupReplicaLabels),
-			duplicateBlocksFilter,
-			noCompactMarkerFilter,
-		}
-		if !conf.disableDownsampling {
-			filters = append(filters, noDownsampleMarkerFilter)
-		}
-		// Make sure all compactor meta syncs are done through Syncer.SyncMeta for readability.
-		cf := baseMetaFetcher.NewMetaFetcher(
-			extprom.WrapRegistererWithPrefix("thanos_", reg), filters)
-		cf.UpdateOnChange(func(blocks []metadata.Meta, err error) {
-			api.SetLoaded(blocks, err)
-		})
 
-		var syncMetasTimeout = conf.waitInterval
-		if !conf.wait {
-			syncMetasTimeout = 0
-		}
-		sy, err = compact.NewMetaSyncer(
-			logger,
-			reg,
-			insBkt,
-			cf,
-			duplicateBlocksFilter,
-			ignoreDeletionMarkFilter,
-			compactMetrics.blocksMarked.WithLabelValues(metadata.DeletionMarkFilename, ""),
-			compactMetrics.garbageCollectedBlocks,
-			syncMetasTimeout,
-		)
+	var labelMergePolicy *compact.LabelMergePolicy
+	if mergeIgnoreLabels := strutil.ParseFlagLabels(conf.mergeIgnoreLabels); len(mergeIgnoreLabels) > 0 {
+		mergeSetLabels, err := parseFlagLabels(conf.mergeSetLabels)
 		if err != nil {
-			return errors.Wrap(err, "create syncer")
+			return errors.Wrap(err, "parse merge set labels")
 		}
+		if labelMergePolicy, err = compact.NewLabelMergePolicy(mergeIgnoreLabels, mergeSetLabels); err != nil {
+			return errors.Wrap(err, "create label merge policy")
+		}
+		level.Info(logger).Log("msg", "merging blocks differing only in ignored labels", "ignoreLabels", strings.Join(mergeIgnoreLabels, ","), "setLabels", mergeSetLabels.String())
+	} else if len(conf.mergeSetLabels) > 0 {
+		return errors.New("--compact.merge-labels.set-label requires --compact.merge-labels.ignore-label")
 	}
 
 	levels, err := compactions.levels(conf.maxCompactionLevel)
@@ -330,15 +322,27 @@ func runCompact(
 		}
 	}()
 
+	hostname, err := os.Hostname()
+	if err != nil {
+		return errors.Wrap(err, "get hostname")
+	}
+
 	var mergeFunc storage.VerticalChunkSeriesMergeFunc
 	switch conf.dedupFunc {
 	case compact.DedupAlgorithmPenalty:
-		mergeFunc = dedup.NewChunkSeriesMerger()
+		var opts []dedup.ChunkSeriesMergerOption
+		if conf.dedupIgnoreCreatedTimestampZeros {
+			opts = append(opts, dedup.WithIgnoreCreatedTimestampZeros())
+		}
+		mergeFunc = dedup.NewChunkSeriesMerger(opts...)
 
 		if len(dedupReplicaLabels) == 0 {
 			return errors.New("penalty based deduplication needs at least one replica label specified")
 		}
 	case "":
+		if conf.dedupIgnoreCreatedTimestampZeros {
+			return errors.New("ignoring created timestamp zeros needs the penalty based deduplication")
+		}
 		mergeFunc = storage.NewCompactingChunkSeriesMerger(storage.ChainedSeriesMerge)
 
 	default:
@@ -352,233 +356,187 @@ func runCompact(
 		return errors.Wrap(err, "create compactor")
 	}
 
-	var (
-		compactDir      = path.Join(conf.dataDir, "compact")
-		downsamplingDir = path.Join(conf.dataDir, "downsample")
-	)
-
-	if err := os.MkdirAll(compactDir, os.ModePerm); err != nil {
-		return errors.Wrap(err, "create working compact directory")
+	conf.seriesValidation.UTF8Policy = compact.UTF8Policy(conf.seriesValidationUTF8Policy)
+	if err := conf.seriesValidation.Validate(); err != nil {
+		return errors.Wrap(err, "series validation")
 	}
 
-	if err := os.MkdirAll(downsamplingDir, os.ModePerm); err != nil {
-		return errors.Wrap(err, "create working downsample directory")
+	if conf.labelsBloom && (conf.labelsBloomFalsePositiveRate <= 0 || conf.labelsBloomFalsePositiveRate >= 1) {
+		return errors.New("--compact.labels-bloom-filter.false-positive-rate must be between 0 and 1")
 	}
 
-	grouper := compact.NewDefaultGrouper(
-		logger,
-		insBkt,
-		conf.acceptMalformedIndex,
-		enableVerticalCompaction,
-		reg,
-		compactMetrics.blocksMarked.WithLabelValues(metadata.DeletionMarkFilename, ""),
-		compactMetrics.garbageCollectedBlocks,
-		compactMetrics.blocksMarked.WithLabelValues(metadata.NoCompactMarkFilename, metadata.OutOfOrderChunksNoCompactReason),
-		metadata.HashFunc(conf.hashFunc),
-		conf.blockFilesConcurrency,
-		conf.compactBlocksFetchConcurrency,
-	)
-	var planner compact.Planner
-
-	tsdbPlanner := compact.NewPlanner(logger, levels, noCompactMarkerFilter)
-	largeIndexFilterPlanner := compact.WithLargeTotalIndexSizeFilter(
-		tsdbPlanner,
-		insBkt,
-		int64(conf.maxBlockIndexSize),
-		compactMetrics.blocksMarked.WithLabelValues(metadata.NoCompactMarkFilename, metadata.IndexSizeExceedingNoCompactReason),
-	)
-	if enableVerticalCompaction {
-		planner = compact.WithVerticalCompactionDownsampleFilter(largeIndexFilterPlanner, insBkt, compactMetrics.blocksMarked.WithLabelValues(metadata.NoCompactMarkFilename, metadata.DownsampleVerticalCompactionNoCompactReason))
-	} else {
-		planner = largeIndexFilterPlanner
-	}
-	blocksCleaner := compact.NewBlocksCleaner(logger, insBkt, ignoreDeletionMarkFilter, deleteDelay, compactMetrics.blocksCleaned, compactMetrics.blockCleanupFailures)
-	compactor, err := compact.NewBucketCompactor(
-		logger,
-		sy,
-		grouper,
-		planner,
-		comp,
-		compactDir,
-		insBkt,
-		conf.compactionConcurrency,
-		conf.skipBlockWithOutOfOrderChunks,
-	)
-	if err != nil {
-		return errors.Wrap(err, "create bucket compactor")
+	deps := compactDeps{
+		conf:                     conf,
+		compactMetrics:           compactMetrics,
+		downsampleMetrics:        downsampleMetrics,
+		comp:                     comp,
+		levels:                   levels,
+		enableVerticalCompaction: enableVerticalCompaction,
+		dedupReplicaLabels:       dedupReplicaLabels,
+		labelMergePolicy:         labelMergePolicy,
+		relabelConfig:            relabelConfig,
+		encryptionConfContent:    encryptionConfContentYaml,
+		attestationConf:          attestationConf,
+		resilienceConf:           resilienceConf,
+		hostname:                 hostname,
 	}
-
-	retentionByResolution := map[compact.ResolutionLevel]time.Duration{
-		compact.ResolutionLevelRaw: time.Duration(conf.retentionRaw),
-		compact.ResolutionLevel5m:  time.Duration(conf.retentionFiveMin),
-		compact.ResolutionLevel1h:  time.Duration(conf.retentionOneHr),
-	}
-
-	if retentionByResolution[compact.ResolutionLevelRaw].Milliseconds() != 0 {
-		// If downsampling is enabled, error if raw retention is not sufficient for downsampling to occur (upper bound 10 days for 1h resolution)
-		if !conf.disableDownsampling && retentionByResolution[compact.ResolutionLevelRaw].Milliseconds() < downsample.ResLevel1DownsampleRange {
-			return errors.New("raw resolution must be higher than the minimum block size after which 5m resolution downsampling will occur (40 hours)")
-		}
-		level.Info(logger).Log("msg", "retention policy of raw samples is enabled", "duration", retentionByResolution[compact.ResolutionLevelRaw])
+	if conf.adaptiveConcurrency {
+		deps.adaptiveConcurrency = compact.NewAdaptiveConcurrency(log.With(logger, "component", "compactor"), reg, compact.ConcurrencyLimits{
+			Concurrency:                   conf.compactionConcurrency,
+			BlockFilesConcurrency:         conf.blockFilesConcurrency,
+			CompactBlocksFetchConcurrency: conf.compactBlocksFetchConcurrency,
+		}, uint64(conf.adaptiveConcurrencyMemoryLimit))
+		g.Add(func() error {
+			return deps.adaptiveConcurrency.Run(ctx, conf.adaptiveConcurrencyInterval)
+		}, func(error) {
+			cancel()
+		})
 	}
-	if retentionByResolution[compact.ResolutionLevel5m].Milliseconds() != 0 {
-		// If retention is lower than minimum downsample range, then no downsampling at this resolution will be persisted
-		if !conf.disableDownsampling && retentionByResolution[compact.ResolutionLevel5m].Milliseconds() < downsample.ResLevel2DownsampleRange {
-			return errors.New("5m resolution retention must be higher than the minimum block size after which 1h resolution downsampling will occur (10 days)")
+	// The memory of compactions is sampled often enough to catch the peaks of short compactions.
+	deps.resourceModel = compact.NewResourceModel(log.With(logger, "component", "compactor"), reg, uint64(conf.memoryBudget))
+	g.Add(func() error {
+		return deps.resourceModel.Run(ctx, time.Second)
+	}, func(error) {
+		cancel()
+	})
+	if conf.activeCompactionDir != "" {
+		deps.activeTracker, err = activetracker.New(logger, conf.activeCompactionDir, "compactions.active", conf.compactionConcurrency)
+		if err != nil {
+			return errors.Wrap(err, "create active compaction tracker")
 		}
-		level.Info(logger).Log("msg", "retention policy of 5 min aggregated samples is enabled", "duration", retentionByResolution[compact.ResolutionLevel5m])
-	}
-	if retentionByResolution[compact.ResolutionLevel1h].Milliseconds() != 0 {
-		level.Info(logger).Log("msg", "retention policy of 1 hour aggregated samples is enabled", "duration", retentionByResolution[compact.ResolutionLevel1h])
 	}
 
-	var cleanMtx sync.Mutex
-	// TODO(GiedriusS): we could also apply retention policies here but the logic would be a bit more complex.
-	cleanPartialMarked := func() error {
-		cleanMtx.Lock()
-		defer cleanMtx.Unlock()
-
-		if err := sy.SyncMetas(ctx); err != nil {
-			return errors.Wrap(err, "syncing metas")
+	tenantDirectories := conf.tenantDirectories
+	for _, bc := range bucketConfs {
+		tenantDirectories = tenantDirectories || bc.TenantDirectories
+	}
+	// Metrics of all buckets and tenants have to have the same labels.
+	bucketReg := func(bucket, tenant string) prometheus.Registerer {
+		lbls := prometheus.Labels{}
+		if len(bucketConfs) > 0 {
+			lbls["compaction_bucket"] = bucket
 		}
-
-		compact.BestEffortCleanAbortedPartialUploads(ctx, logger, sy.Partial(), insBkt, compactMetrics.partialUploadDeleteAttempts, compactMetrics.blocksCleaned, compactMetrics.blockCleanupFailures)
-		if err := blocksCleaner.DeleteMarkedBlocks(ctx); err != nil {
-			return errors.Wrap(err, "cleaning marked blocks")
+		if tenantDirectories {
+			lbls["tenant"] = tenant
 		}
-		compactMetrics.cleanups.Inc()
-
-		return nil
+		if len(lbls) == 0 {
+			return reg
+		}
+		return prometheus.WrapRegistererWith(lbls, reg)
 	}
-
-	compactMainFn := func() error {
-		if err := compactor.Compact(ctx); err != nil {
-			return errors.Wrap(err, "compaction")
+	newRunner := func(logger log.Logger, bucket string, objStoreConf []byte, tenantDirectories bool, retentionByResolution map[compact.ResolutionLevel]time.Duration, storageClassConfs []compact.StorageClassConfig, dataDir string) (compactRunner, error) {
+		bkt, err := newCompactObjstoreBucket(logger, bucketReg(bucket, ""), deps, objStoreConf)
+		if err != nil {
+			return nil, err
 		}
-
-		if !conf.disableDownsampling {
-			// After all compactions are done, work down the downsampling backlog.
-			// We run two passes of this to ensure that the 1h downsampling is generated
-			// for 5m downsamplings created in the first run.
-			level.Info(logger).Log("msg", "start first pass of downsampling")
-			if err := sy.SyncMetas(ctx); err != nil {
-				return errors.Wrap(err, "sync before first pass of downsampling")
-			}
-
-			filteredMetas := sy.Metas()
-			noDownsampleBlocks := noDownsampleMarkerFilter.NoDownsampleMarkedBlocks()
-			for ul := range noDownsampleBlocks {
-				delete(filteredMetas, ul)
-			}
-
-			for _, meta := range filteredMetas {
-				resolutionLabel := meta.Thanos.ResolutionString()
-				downsampleMetrics.downsamples.WithLabelValues(resolutionLabel)
-				downsampleMetrics.downsampleFailures.WithLabelValues(resolutionLabel)
-			}
-
-			if err := downsampleBucket(
-				ctx,
-				logger,
-				downsampleMetrics,
-				insBkt,
-				filteredMetas,
-				downsamplingDir,
-				conf.downsampleConcurrency,
-				conf.blockFilesConcurrency,
-				metadata.HashFunc(conf.hashFunc),
-				conf.acceptMalformedIndex,
-			); err != nil {
-				return errors.Wrap(err, "first pass of downsampling failed")
-			}
-
-			level.Info(logger).Log("msg", "start second pass of downsampling")
-			if err := sy.SyncMetas(ctx); err != nil {
-				return errors.Wrap(err, "sync before second pass of downsampling")
-			}
-
-			// Regenerate the filtered list of blocks after the sync,
-			// to include the blocks created by the first pass.
-			filteredMetas = sy.Metas()
-			noDownsampleBlocks = noDownsampleMarkerFilter.NoDownsampleMarkedBlocks()
-			for ul := range noDownsampleBlocks {
-				delete(filteredMetas, ul)
-			}
-
-			if err := downsampleBucket(
-				ctx,
-				logger,
-				downsampleMetrics,
-				insBkt,
-				filteredMetas,
-				downsamplingDir,
-				conf.downsampleConcurrency,
-				conf.blockFilesConcurrency,
-				metadata.HashFunc(conf.hashFunc),
-				conf.acceptMalformedIndex,
-			); err != nil {
-				return errors.Wrap(err, "second pass of downsampling failed")
-			}
-
-			level.Info(logger).Log("msg", "downsampling iterations done")
+		storageClasses, err := newStorageClasses(ctx, logger, bucketReg(bucket, ""), storageClassConfs)
+		if err != nil {
+			runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+			return nil, err
+		}
+		var r compactRunner
+		if tenantDirectories {
+			r, err = newCompactTenants(logger, deps, bkt, retentionByResolution, storageClasses, dataDir, func(tenant string) prometheus.Registerer {
+				return bucketReg(bucket, tenant)
+			})
 		} else {
-			level.Info(logger).Log("msg", "downsampling was explicitly disabled")
+			r, err = newCompactBucket(ctx, logger, bucketReg(bucket, ""), deps, bkt, retentionByResolution, storageClasses, dataDir)
 		}
-
-		// TODO(bwplotka): Find a way to avoid syncing if no op was done.
-		if err := sy.SyncMetas(ctx); err != nil {
-			return errors.Wrap(err, "sync before retention")
+		if err != nil {
+			runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+			compact.CloseStorageClasses(logger, storageClasses)
+			return nil, err
 		}
+		return r, nil
+	}
 
-		if err := compact.ApplyRetentionPolicyByResolution(ctx, logger, insBkt, sy.Metas(), retentionByResolution, compactMetrics.blocksMarked.WithLabelValues(metadata.DeletionMarkFilename, "")); err != nil {
-			return errors.Wrap(err, "retention failed")
+	if len(bucketConfs) > 0 {
+		// The groups of all the buckets are compacted by the same workers.
+		deps.concurrencyPool = compact.NewConcurrencyPool(conf.compactionConcurrency)
+	}
+	var buckets []compactRunner
+	// Ensure we close up everything properly.
+	defer func() {
+		if rerr != nil {
+			for _, b := range buckets {
+				b.close()
+			}
 		}
+	}()
 
-		return cleanPartialMarked()
+	primary, err := newRunner(logger, "", confContentYaml, conf.tenantDirectories, map[compact.ResolutionLevel]time.Duration{
+		compact.ResolutionLevelRaw: time.Duration(conf.retentionRaw),
+		compact.ResolutionLevel5m:  time.Duration(conf.retentionFiveMin),
+		compact.ResolutionLevel1h:  time.Duration(conf.retentionOneHr),
+	}, storageClassConfs, conf.dataDir)
+	if err != nil {
+		return err
 	}
-
-	g.Add(func() error {
-		defer runutil.CloseWithLogOnErr(logger, insBkt, "bucket client")
-
-		if !conf.wait {
-			return compactMainFn()
+	buckets = append(buckets, primary)
+	for _, bc := range bucketConfs {
+		bucketLogger := log.With(logger, "compaction_bucket", bc.Name)
+		objStoreConf, err := yaml.Marshal(bc.Bucket)
+		if err != nil {
+			return errors.Wrapf(err, "marshal object storage configuration of bucket %s", bc.Name)
 		}
+		b, err := newRunner(bucketLogger, bc.Name, objStoreConf, bc.TenantDirectories, map[compact.ResolutionLevel]time.Duration{
+			compact.ResolutionLevelRaw: time.Duration(bc.RetentionRaw),
+			compact.ResolutionLevel5m:  time.Duration(bc.RetentionFiveMin),
+			compact.ResolutionLevel1h:  time.Duration(bc.RetentionOneHr),
+		}, bc.StorageClasses, path.Join(conf.dataDir, "buckets", bc.Name))
+		if err != nil {
+			return errors.Wrapf(err, "bucket %s", bc.Name)
+		}
+		buckets = append(buckets, b)
+	}
+	if len(bucketConfs) > 0 {
+		level.Info(logger).Log("msg", "compacting additional buckets", "buckets", len(bucketConfs))
+	}
 
-		// --wait=true is specified.
-		return runutil.Repeat(conf.waitInterval, ctx.Done(), func() error {
-			err := compactMainFn()
-			if err == nil {
-				compactMetrics.iterations.Inc()
-				return nil
-			}
-
-			// The HaltError type signals that we hit a critical bug and should block
-			// for investigation. You should alert on this being halted.
-			if compact.IsHaltError(err) {
-				if conf.haltOnError {
-					level.Error(logger).Log("msg", "critical error detected; halting", "err", err)
-					compactMetrics.halted.Set(1)
-					select {}
-				} else {
-					return errors.Wrap(err, "critical error detected")
-				}
-			}
-
-			// The RetryError signals that we hit an retriable error (transient error, no connection).
-			// You should alert on this being triggered too frequently.
-			if compact.IsRetryError(err) {
-				level.Error(logger).Log("msg", "retriable error", "err", err)
-				compactMetrics.retried.Inc()
-				// TODO(bplotka): use actual "retry()" here instead of waiting 5 minutes?
-				return nil
-			}
-
-			return errors.Wrap(err, "error executing compaction")
+	meter, err := conf.metering.meter(reg, component)
+	if err != nil {
+		return err
+	}
+	var (
+		api *blocksAPI.BlocksAPI
+		// baseMetaFetcher fetches the blocks of the global view of the web UI, if the primary bucket is not
+		// laid out in tenant directories.
+		baseMetaFetcher *block.BaseFetcher
+	)
+	switch p := primary.(type) {
+	case *compactBucket:
+		api = blocksAPI.NewBlocksAPI(logger, conf.webConf.disableCORS, conf.label, flagsMap, p.bkt)
+		p.metaFetcher.UpdateOnChange(func(blocks []metadata.Meta, err error) {
+			api.SetLoaded(blocks, err)
+			// The markers are gathered by the filters of the fetch.
+			api.SetLoadedMarks(p.ignoreDeletionMarkFilter.DeletionMarkBlocks(), p.noCompactMarkerFilter.NoCompactMarkedBlocks())
 		})
-	}, func(error) {
-		cancel()
+		if p.compactionProgress != nil {
+			p.compactionProgress.UpdateOnPlanned(api.SetPlannedCompactions)
+		}
+		baseMetaFetcher = p.baseMetaFetcher
+		p.meter = meter
+		conf.metering.addRecordWriter(g, logger, meter, p.bkt)
+	case *compactTenants:
+		api = blocksAPI.NewBlocksAPI(logger, conf.webConf.disableCORS, conf.label, flagsMap, p.bkt)
+		p.meter = meter
+		conf.metering.addRecordWriter(g, logger, meter, p.bkt)
+	}
+	// The groups are planned again in whichever bucket, or tenant, they are.
+	api.SetReplanner(func(groupKeys []string, ids []ulid.ULID) {
+		for _, b := range buckets {
+			b.requestReplan(groupKeys, ids)
+		}
 	})
 
+	for _, b := range buckets {
+		g.Add(func() error {
+			return b.run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
 	if conf.wait {
 		if !conf.disableWeb {
 			r := route.New()
@@ -595,116 +553,58 @@ func runCompact(
 			logMiddleware := logging.NewHTTPServerMiddleware(logger, opts...)
 			api.Register(r.WithPrefix("/api/v1"), tracer, logger, ins, logMiddleware)
 
-			// Separate fetcher for global view.
-			// TODO(bwplotka): Allow Bucket UI to visualize the state of the block as well.
-			f := baseMetaFetcher.NewMetaFetcher(extprom.WrapRegistererWithPrefix("thanos_bucket_ui", reg), nil, "component", "globalBucketUI")
-			f.UpdateOnChange(func(blocks []metadata.Meta, err error) {
-				api.SetGlobal(blocks, err)
-			})
-
 			srv.Handle("/", r)
 
-			g.Add(func() error {
-				iterCtx, iterCancel := context.WithTimeout(ctx, conf.blockViewerSyncBlockTimeout)
-				_, _, _ = f.Fetch(iterCtx)
-				iterCancel()
+			if baseMetaFetcher != nil {
+				// Separate fetcher for global view.
+				// TODO(bwplotka): Allow Bucket UI to visualize the state of the block as well.
+				f := baseMetaFetcher.NewMetaFetcher(extprom.WrapRegistererWithPrefix("thanos_bucket_ui", reg), nil, "component", "globalBucketUI")
+				f.UpdateOnChange(func(blocks []metadata.Meta, err error) {
+					api.SetGlobal(blocks, err)
+				})
 
-				// For /global state make sure to fetch periodically.
-				return runutil.Repeat(conf.blockViewerSyncBlockInterval, ctx.Done(), func() error {
-					return runutil.RetryWithLog(logger, time.Minute, ctx.Done(), func() error {
-						iterCtx, iterCancel := context.WithTimeout(ctx, conf.blockViewerSyncBlockTimeout)
-						defer iterCancel()
+				g.Add(func() error {
+					iterCtx, iterCancel := context.WithTimeout(ctx, conf.blockViewerSyncBlockTimeout)
+					_, _, _ = f.Fetch(iterCtx)
+					iterCancel()
 
-						_, _, err := f.Fetch(iterCtx)
-						return err
+					// For /global state make sure to fetch periodically.
+					return runutil.Repeat(conf.blockViewerSyncBlockInterval, ctx.Done(), func() error {
+						return runutil.RetryWithLog(logger, time.Minute, ctx.Done(), func() error {
+							iterCtx, iterCancel := context.WithTimeout(ctx, conf.blockViewerSyncBlockTimeout)
+							defer iterCancel()
+
+							_, _, err := f.Fetch(iterCtx)
+							return err
+						})
 					})
+				}, func(error) {
+					cancel()
 				})
-			}, func(error) {
-				cancel()
-			})
+			}
 		}
 
 		// Periodically remove partial blocks and blocks marked for deletion
 		// since one iteration potentially could take a long time.
 		if conf.cleanupBlocksInterval > 0 {
-			g.Add(func() error {
-				return runutil.Repeat(conf.cleanupBlocksInterval, ctx.Done(), func() error {
-					err := cleanPartialMarked()
-					if err != nil && compact.IsRetryError(err) {
-						// The RetryError signals that we hit an retriable error (transient error, no connection).
-						// You should alert on this being triggered too frequently.
-						level.Error(logger).Log("msg", "retriable error", "err", err)
-						compactMetrics.retried.Inc()
-
-						return nil
-					}
-
-					return err
+			for _, b := range buckets {
+				g.Add(func() error {
+					return b.runCleanup(ctx)
+				}, func(error) {
+					cancel()
 				})
-			}, func(error) {
-				cancel()
-			})
+			}
 		}
 
 		// Periodically calculate the progress of compaction, downsampling and retention.
 		if conf.progressCalculateInterval > 0 {
-			g.Add(func() error {
-				ps := compact.NewCompactionProgressCalculator(reg, tsdbPlanner)
-				rs := compact.NewRetentionProgressCalculator(reg, retentionByResolution)
-				var ds *compact.DownsampleProgressCalculator
-				if !conf.disableDownsampling {
-					ds = compact.NewDownsampleProgressCalculator(reg)
-				}
-
-				return runutil.Repeat(conf.progressCalculateInterval, ctx.Done(), func() error {
-
-					if err := sy.SyncMetas(ctx); err != nil {
-						// The RetryError signals that we hit an retriable error (transient error, no connection).
-						// You should alert on this being triggered too frequently.
-						if compact.IsRetryError(err) {
-							level.Error(logger).Log("msg", "retriable error", "err", err)
-							compactMetrics.retried.Inc()
-
-							return nil
-						}
-
-						return errors.Wrapf(err, "could not sync metas")
-					}
-
-					metas := sy.Metas()
-					groups, err := grouper.Groups(metas)
-					if err != nil {
-						return errors.Wrapf(err, "could not group metadata for compaction")
-					}
-
-					if err = ps.ProgressCalculate(ctx, groups); err != nil {
-						return errors.Wrapf(err, "could not calculate compaction progress")
-					}
-
-					retGroups, err := grouper.Groups(metas)
-					if err != nil {
-						return errors.Wrapf(err, "could not group metadata for retention")
-					}
-
-					if err = rs.ProgressCalculate(ctx, retGroups); err != nil {
-						return errors.Wrapf(err, "could not calculate retention progress")
-					}
-
-					if !conf.disableDownsampling {
-						groups, err = grouper.Groups(metas)
-						if err != nil {
-							return errors.Wrapf(err, "could not group metadata into downsample groups")
-						}
-						if err := ds.ProgressCalculate(ctx, groups); err != nil {
-							return errors.Wrapf(err, "could not calculate downsampling progress")
-						}
-					}
-
-					return nil
+			for _, b := range buckets {
+				g.Add(func() error {
+					return b.runProgressCalculation(ctx)
+				}, func(err error) {
+					cancel()
 				})
-			}, func(err error) {
-				cancel()
-			})
+			}
 		}
 	}
 
@@ -719,8 +619,19 @@ type compactConfig struct {
 	maxCompactionLevel                             int
 	http                                           httpConfig
 	dataDir                                        string
+	activeCompactionDir                            string
+	metering                                       meteringConfig
+	meteringTenantLabel                            string
 	objStore                                       extflag.PathOrContent
+	objStoreEncryption                             extflag.PathOrContent
+	blockAttestation                               extflag.PathOrContent
+	objStoreResilience                             extflag.PathOrContent
+	bucketsConf                                    extflag.PathOrContent
+	storageClassesConf                             extflag.PathOrContent
+	tenantDirectories                              bool
+	httpRBAC                                       *extflag.PathOrContent
 	consistencyDelay                               time.Duration
+	uploadCompletedConsistencyDelay                time.Duration
 	retentionRaw, retentionFiveMin, retentionOneHr model.Duration
 	wait                                           bool
 	waitInterval                                   time.Duration
@@ -734,17 +645,43 @@ type compactConfig struct {
 	compactionConcurrency                          int
 	downsampleConcurrency                          int
 	compactBlocksFetchConcurrency                  int
+	adaptiveConcurrency                            bool
+	adaptiveConcurrencyInterval                    time.Duration
+	adaptiveConcurrencyMemoryLimit                 units.Base2Bytes
+	memoryBudget                                   units.Base2Bytes
+	enableCheckpointing                            bool
+	enableStateSnapshot                            bool
+	stateSnapshotMaxAge                            model.Duration
+	exemplarsRetention                             time.Duration
 	deleteDelay                                    model.Duration
 	dedupReplicaLabels                             []string
+	mergeIgnoreLabels                              []string
+	mergeSetLabels                                 []string
 	selectorRelabelConf                            extflag.PathOrContent
 	disableWeb                                     bool
 	webConf                                        webConfig
 	label                                          string
 	maxBlockIndexSize                              units.Base2Bytes
+	shardLargeBlocks                               bool
+	minPlanSize                                    units.Base2Bytes
+	minPlanMaxSkips                                int
+	maxPlanBlocks                                  int
+	labelsBloom                                    bool
+	labelsBloomFalsePositiveRate                   float64
+	indexHeader                                    bool
+	seriesHashes                                   bool
+	seriesHashesWithoutLabels                      []string
 	hashFunc                                       string
 	enableVerticalCompaction                       bool
 	dedupFunc                                      string
+	dedupIgnoreCreatedTimestampZeros               bool
 	skipBlockWithOutOfOrderChunks                  bool
+	sortOutOfOrderChunks                           bool
+	seriesValidation                               compact.SeriesValidation
+	seriesValidationUTF8Policy                     string
+	enableFencing                                  bool
+	verifyReplacements                             bool
+	verifyReplacementsIndexHeader                  bool
 	progressCalculateInterval                      time.Duration
 	filterConf                                     *store.FilterConfig
 	disableAdminOperations                         bool
@@ -760,15 +697,35 @@ func (cc *compactConfig) registerFlag(cmd extkingpin.FlagClause) {
 		Hidden().Default(strconv.Itoa(compactions.maxLevel())).IntVar(&cc.maxCompactionLevel)
 
 	cc.http.registerFlag(cmd)
+	cc.httpRBAC = extkingpin.RegisterHTTPRBACFlags(cmd)
+	cc.metering = *cc.metering.registerFlag(cmd).registerRecordFlag(cmd)
+	cmd.Flag("metering.tenant-label-name", "External label of the blocks naming their tenant, to meter the bytes stored by tenant. Blocks without it are accounted to the default tenant.").
+		Default(tenancy.DefaultTenantLabel).StringVar(&cc.meteringTenantLabel)
 
 	cmd.Flag("data-dir", "Data directory in which to cache blocks and process compactions.").
 		Default("./data").StringVar(&cc.dataDir)
 
 	cc.objStore = *extkingpin.RegisterCommonObjStoreFlags(cmd, "", false)
+	cc.objStoreEncryption = *extkingpin.RegisterObjStoreEncryptionFlags(cmd)
+	cc.blockAttestation = *extkingpin.RegisterBlockAttestationFlags(cmd)
+	cc.objStoreResilience = *extkingpin.RegisterObjStoreResilienceFlags(cmd)
+	cc.bucketsConf = *extflag.RegisterPathOrContent(cmd, "compact.buckets-config",
+		"YAML file with the list of additional buckets to compact, each with a name, an object storage configuration and retentions, compacted by the same workers as the bucket of --objstore.config. See format details: https://thanos.io/tip/components/compact.md/#compacting-multiple-buckets",
+		extflag.WithEnvSubstitution(),
+	)
+	cc.storageClassesConf = *extflag.RegisterPathOrContent(cmd, "compact.storage-classes-config",
+		"YAML file with the list of storage classes of the blocks of the bucket of --objstore.config, each with the resolutions and the minimum age of its blocks and the object storage configuration uploading to it. The index and chunks of the blocks matching a class are uploaded again to it after the retention. See format details: https://thanos.io/tip/components/compact.md/#storage-classes",
+		extflag.WithEnvSubstitution(),
+	)
+	cmd.Flag("compact.tenant-directories", "Compact the bucket of --objstore.config in the tenant directory layout of Cortex and Mimir, <tenant>/<block>: the blocks of every top level directory are compacted separately, as the blocks of the tenant named after the directory. Set the prefix of the object storage configuration for tenant directories below a prefix. The tenants are discovered on every iteration.").
+		Default("false").BoolVar(&cc.tenantDirectories)
 
 	cmd.Flag("consistency-delay", fmt.Sprintf("Minimum age of fresh (non-compacted) blocks before they are being processed. Malformed blocks older than the maximum of consistency-delay and %v will be removed.", compact.PartialUploadThresholdAge)).
 		Default("30m").DurationVar(&cc.consistencyDelay)
 
+	cmd.Flag("upload-completed-consistency-delay", "If non-zero, fresh blocks with an upload-completed mark, uploaded by receivers with --shipper.upload-completed-mark, are processed once their upload was completed at least this long ago, instead of waiting for --consistency-delay. Only shorten it for object storages that are strongly consistent.").
+		Default("0s").DurationVar(&cc.uploadCompletedConsistencyDelay)
+
 	cmd.Flag("retention.resolution-raw",
 		"How long to retain raw samples in bucket. Setting this to 0d will retain samples of this resolution forever").
 		Default("0d").SetValue(&cc.retentionRaw)
@@ -805,8 +762,44 @@ func (cc *compactConfig) registerFlag(cmd extkingpin.FlagClause) {
 
 	cmd.Flag("compact.concurrency", "Number of goroutines to use when compacting groups.").
 		Default("1").IntVar(&cc.compactionConcurrency)
+	cmd.Flag("compact.active-compaction-path", "Directory to log currently active group compactions in the compactions.active file. The compactions which did not finish, e.g. because the process ran out of memory, are logged on the next start. It must be outside the compact and downsample work directories within the data directory, which are cleaned up.").
+		Default("").StringVar(&cc.activeCompactionDir)
 	cmd.Flag("compact.blocks-fetch-concurrency", "Number of goroutines to use when download block during compaction.").
 		Default("1").IntVar(&cc.compactBlocksFetchConcurrency)
+	cmd.Flag("compact.adaptive-concurrency", "Adjust the number of groups compacted concurrently, and the concurrency of their block downloads and file transfers at runtime, between 1 and --compact.concurrency, --compact.blocks-fetch-concurrency and --block-files-concurrency. The concurrency starts low, increases while the compactor is healthy and decreases on memory pressure, object storage errors or saturated throughput.").
+		Default("false").BoolVar(&cc.adaptiveConcurrency)
+	cmd.Flag("compact.adaptive-concurrency.interval", "Interval at which the adaptive concurrency is adjusted.").
+		Default("30s").DurationVar(&cc.adaptiveConcurrencyInterval)
+	cmd.Flag("compact.adaptive-concurrency.memory-limit", "Memory usage the adaptive concurrency keeps the compactor below. 0 uses the Go memory limit, e.g. set with GOMEMLIMIT or --enable-auto-gomemlimit; without either, memory usage is not considered.").
+		Default("0").BytesVar(&cc.adaptiveConcurrencyMemoryLimit)
+	cmd.Flag("compact.memory-budget", "Memory the compactions in flight are predicted to use together at most. A planned compaction waits for the compactions in flight to finish until its predicted memory fits in the budget, unless no other compaction is in flight. The memory of compactions is predicted from their number of samples once a few compactions of the same resolution were observed. 0 disables the budget.").
+		Default("0").BytesVar(&cc.memoryBudget)
+	cmd.Flag("compact.enable-checkpointing", "Checkpoint the progress of group compactions in their work directories within the data directory, so that a restarted compactor resumes the compaction of the same plan without downloading, verifying and compacting its blocks again. Requires a persistent data directory.").
+		Default("false").BoolVar(&cc.enableCheckpointing)
+	cmd.Flag("compact.enable-state-snapshot", "Save the state the compactor derives from the bucket, i.e. the synced blocks and their no compaction marks, the plans of the unfinished compactions, the verified replacements and the pending garbage collection decisions, to a file of the data directory on shutdown, and restore it on startup, so that a restarted compactor starts compacting without syncing the metas first. Requires a persistent data directory.").
+		Default("false").BoolVar(&cc.enableStateSnapshot)
+	cmd.Flag("compact.state-snapshot.max-age", "Maximum age of the state snapshot restored on startup. Older snapshots are discarded, as the bucket likely changed too much since.").
+		Default("1h").SetValue(&cc.stateSnapshotMaxAge)
+	cmd.Flag("compact.exemplars-retention", "How long the exemplars of compacted blocks are kept in the blocks they are compacted into, relative to the time of the compaction. 0 keeps all the exemplars within the time range of the compacted block.").
+		Default("0s").DurationVar(&cc.exemplarsRetention)
+	cmd.Flag("compact.min-plan-size", "Minimum total size of the blocks of a compaction plan. Smaller plans are merged with the adjacent plans their block would later be compacted with, and skipped while still smaller for up to --compact.min-plan-size.max-skips plannings of their group, to compact the blocks of low-volume groups straight into blocks of larger ranges. Every compaction iteration plans each group at least once. 0 disables merging and skipping.").
+		Default("0").BytesVar(&cc.minPlanSize)
+	cmd.Flag("compact.min-plan-size.max-skips", "Maximum number of consecutive plannings a group with a plan below --compact.min-plan-size is skipped for before it is compacted anyway.").
+		Default("10").IntVar(&cc.minPlanMaxSkips)
+	cmd.Flag("compact.max-plan-blocks", "Maximum number of blocks compacted at once, at least 2. Larger plans, e.g. of hundreds of small blocks produced by receivers, are compacted in batches of the blocks with the lowest min time. 0 disables the limit.").
+		Default("0").PreAction(func(*kingpin.ParseContext) error {
+		return compact.ValidateMaxPlanBlocks(cc.maxPlanBlocks)
+	}).IntVar(&cc.maxPlanBlocks)
+	cmd.Flag("compact.labels-bloom-filter", "Build the bloom filter of the label pairs of every compacted block and upload it next to the block as labels.bloom, for the store gateway to skip blocks without the label pairs of queries.").
+		Default("false").BoolVar(&cc.labelsBloom)
+	cmd.Flag("compact.labels-bloom-filter.false-positive-rate", "False positive rate of the labels bloom filters. Lower rates need larger filters.").
+		Default("0.01").Float64Var(&cc.labelsBloomFalsePositiveRate)
+	cmd.Flag("compact.index-header", "Build the index-header of every compacted block and upload it next to the block as index-header, for store gateways with --store.enable-prebuilt-index-headers to download it instead of building it from the index of the block.").
+		Default("false").BoolVar(&cc.indexHeader)
+	cmd.Flag("compact.series-hashes", "Compute the shard hashes of the series of every compacted block and upload them next to the block as series.hashes, for store gateways with --store.enable-precomputed-series-hashes not to hash the labels of the series of sharded queries.").
+		Default("false").BoolVar(&cc.seriesHashes)
+	cmd.Flag("compact.series-hashes.without-label", "External label to leave out of the series hashes (repeated flag), e.g. the replica labels queriers deduplicate series by, for the hashes to be used by deduplicated queries.").
+		StringsVar(&cc.seriesHashesWithoutLabels)
 	cmd.Flag("downsample.concurrency", "Number of goroutines to use when downsampling blocks.").
 		Default("1").IntVar(&cc.downsampleConcurrency)
 
@@ -827,6 +820,13 @@ func (cc *compactConfig) registerFlag(cmd extkingpin.FlagClause) {
 		"When set to penalty, penalty based deduplication algorithm will be used. At least one replica label has to be set via --deduplication.replica-label flag.").
 		Default("").EnumVar(&cc.dedupFunc, compact.DedupAlgorithmPenalty, "")
 
+	cmd.Flag("deduplication.ignore-created-timestamp-zeros", "Experimental. When set to true, the penalty based deduplication skips the zero samples of a replica it switches to while the last sample is positive, "+
+		"like the zeros injected at the created timestamp of counters by Prometheus with the created-timestamp-zero-ingestion feature. "+
+		"Otherwise such zeros are merged as counter resets, inflating rate() over the deduplicated blocks. "+
+		"Only counter series are affected, identified by their __type__ label or else by the _total, _count, _sum or _bucket suffix of their name. "+
+		"Requires --deduplication.func=penalty.").
+		Default("false").BoolVar(&cc.dedupIgnoreCreatedTimestampZeros)
+
 	cmd.Flag("deduplication.replica-label", "Experimental. Label to treat as a replica indicator of blocks that can be deduplicated (repeated flag). This will merge multiple replica blocks into one. This process is irreversible. "+
 		"Flag may be specified multiple times as well as a comma separated list of labels. "+
 		"When one or more labels are set, compactor will ignore the given labels so that vertical compaction can merge the blocks."+
@@ -834,6 +834,14 @@ func (cc *compactConfig) registerFlag(cmd extkingpin.FlagClause) {
 		"If you need a different deduplication algorithm (e.g one that works well with Prometheus replicas), please set it via --deduplication.func.").
 		StringsVar(&cc.dedupReplicaLabels)
 
+	cmd.Flag("compact.merge-labels.ignore-label", "Experimental. External label ignored when grouping blocks for compaction (repeated flag), e.g. a replica label changed in a migration. "+
+		"Blocks whose external labels differ only in these labels are compacted together into blocks without them. "+
+		"Flag may be specified multiple times as well as a comma separated list of labels. Blocks overlapping in time are only merged with vertical compaction. This process is irreversible.").
+		StringsVar(&cc.mergeIgnoreLabels)
+
+	cmd.Flag("compact.merge-labels.set-label", "Experimental. External label set on the blocks compacted from blocks merged by --compact.merge-labels.ignore-label (repeated flag). It has to be one of the ignored labels.").
+		PlaceHolder("<name>=\"<value>\"").StringsVar(&cc.mergeSetLabels)
+
 	// TODO(bwplotka): This is short term fix for https://github.com/thanos-io/thanos/issues/1424, replace with vertical block sharding https://github.com/thanos-io/thanos/pull/3390.
 	cmd.Flag("compact.block-max-index-size", "Maximum index size for the resulted block during any compaction. Note that"+
 		"total size is approximated in worst case. If the block that would be resulted from compaction is estimated to exceed this number, biggest source"+
@@ -841,9 +849,38 @@ func (cc *compactConfig) registerFlag(cmd extkingpin.FlagClause) {
 		"Default is due to https://github.com/thanos-io/thanos/issues/1424, but it's overall recommended to keeps block size to some reasonable size.").
 		Hidden().Default("64GB").BytesVar(&cc.maxBlockIndexSize)
 
+	cmd.Flag("compact.shard-large-blocks", "When set to true, compactions whose resulted block is estimated to exceed the maximum index size are split into blocks of shards of the series by their label hash, "+
+		"instead of marking the biggest source block for no compaction. Shards are compacted further with the blocks of the same shard only.").
+		Default("false").BoolVar(&cc.shardLargeBlocks)
+
 	cmd.Flag("compact.skip-block-with-out-of-order-chunks", "When set to true, mark blocks containing index with out-of-order chunks for no compact instead of halting the compaction").
 		Hidden().Default("false").BoolVar(&cc.skipBlockWithOutOfOrderChunks)
 
+	cmd.Flag("compact.sort-out-of-order-chunks", "When set to true, blocks containing out-of-order chunks, e.g. uploaded by Prometheus or receive with an out-of-order time window, are compacted with the chunks of each series sorted, and the overlapping chunks merged, "+
+		"instead of halting the compaction or marking the blocks for no compaction.").
+		Default("false").BoolVar(&cc.sortOutOfOrderChunks)
+
+	cmd.Flag("compact.series-validation.max-label-value-length", "Maximum length of the label values of the series of compacted blocks, in bytes. The series of the source blocks exceeding it are counted by thanos_compact_group_compaction_invalid_series_total. 0 disables the validation.").
+		Default("0").IntVar(&cc.seriesValidation.MaxLabelValueLength)
+	cmd.Flag("compact.series-validation.max-label-names", "Maximum number of labels, including the metric name, of the series of compacted blocks. The series of the source blocks exceeding it are counted by thanos_compact_group_compaction_invalid_series_total. 0 disables the validation.").
+		Default("0").IntVar(&cc.seriesValidation.MaxLabelNames)
+	cmd.Flag("compact.series-validation.utf8-policy", "Policy the label names and values of the series of compacted blocks are validated against: 'utf8' requires them to be valid UTF-8, 'legacy' also requires the metric and label names to match the legacy Prometheus character set. The series of the source blocks violating it are counted by thanos_compact_group_compaction_invalid_series_total.").
+		Default(string(compact.UTF8PolicyNone)).EnumVar(&cc.seriesValidationUTF8Policy, string(compact.UTF8PolicyNone), string(compact.UTF8PolicyUTF8), string(compact.UTF8PolicyLegacy))
+	cmd.Flag("compact.series-validation.drop-invalid-series", "When set to true, the series violating the series validation are dropped from the compacted blocks, instead of only being counted. Dropping series is irreversible.").
+		Default("false").BoolVar(&cc.seriesValidation.DropInvalidSeries)
+
+	cmd.Flag("compact.enable-fencing", "Acquire a fencing token in the bucket ("+compact.FencingTokenFile+") on startup and record it in written markers. "+
+		"The compactor halts instead of garbage collecting or deleting blocks once a compactor started later on the same bucket, to protect against two compactors accidentally running at the same time.").
+		Default("false").BoolVar(&cc.enableFencing)
+
+	cmd.Flag("compact.garbage-collection.verify-replacements", "Before marking a block compacted into other blocks for deletion, verify that the blocks it was compacted into still exist in the bucket with a complete meta and all their files. "+
+		"Duplicates with a missing or incomplete replacement are kept and counted by thanos_compact_garbage_collection_unverified_duplicates_total.").
+		Default("true").BoolVar(&cc.verifyReplacements)
+
+	cmd.Flag("compact.garbage-collection.verify-replacements.index-header", "Also build the index-header of the replacements of duplicates from their index before garbage collecting the duplicates, to detect corrupted indexes. "+
+		"Requires --compact.garbage-collection.verify-replacements.").
+		Default("false").BoolVar(&cc.verifyReplacementsIndexHeader)
+
 	cmd.Flag("hash-func", "Specify which hash function to use when calculating the hashes of produced files. If no function has been specified, it does not happen. This permits avoiding downloading some files twice albeit at some performance cost. Possible values are: \"\", \"SHA256\".").
 		Default("").EnumVar(&cc.hashFunc, "SHA256", "")
 
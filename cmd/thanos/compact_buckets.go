@@ -0,0 +1,739 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"context"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/client"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/activetracker"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/attestation"
+	"github.com/thanos-io/thanos/pkg/block/encryption"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+	"github.com/thanos-io/thanos/pkg/component"
+	"github.com/thanos-io/thanos/pkg/extprom"
+	"github.com/thanos-io/thanos/pkg/metering"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// compactBucketConfig configures an additional bucket compacted by the compactor, e.g. of another Thanos
+// installation. Its blocks are compacted, downsampled and retained independently of the blocks of other buckets,
+// by the same workers.
+type compactBucketConfig struct {
+	// Name identifies the bucket in the logs, in the compaction_bucket label of its metrics and in the data directory.
+	Name string `yaml:"name"`
+	// Bucket is the object storage configuration of the bucket, e.g. with a prefix to compact a part of a bucket.
+	Bucket client.BucketConfig `yaml:"bucket"`
+	// TenantDirectories compacts the blocks of every tenant directory of the bucket, <tenant>/<block>, separately.
+	TenantDirectories bool `yaml:"tenant_directories"`
+	// Retention of the samples of the bucket by resolution. Zero retains them forever.
+	RetentionRaw     model.Duration `yaml:"retention_resolution_raw"`
+	RetentionFiveMin model.Duration `yaml:"retention_resolution_5m"`
+	RetentionOneHr   model.Duration `yaml:"retention_resolution_1h"`
+	// StorageClasses of the blocks of the bucket, in order of precedence.
+	StorageClasses []compact.StorageClassConfig `yaml:"storage_classes"`
+}
+
+func parseCompactBucketsConfig(content []byte) ([]compactBucketConfig, error) {
+	var confs []compactBucketConfig
+	if err := yaml.UnmarshalStrict(content, &confs); err != nil {
+		return nil, errors.Wrap(err, "parsing compaction buckets config YAML")
+	}
+	names := map[string]struct{}{}
+	for i, c := range confs {
+		if c.Name == "" || c.Name == "." || c.Name == ".." || strings.ContainsAny(c.Name, `/\`) {
+			return nil, errors.Errorf("bucket %d has an invalid name %q", i, c.Name)
+		}
+		if _, ok := names[c.Name]; ok {
+			return nil, errors.Errorf("bucket name %q is not unique", c.Name)
+		}
+		names[c.Name] = struct{}{}
+		if c.Bucket.Type == "" {
+			return nil, errors.Errorf("bucket %q has no object storage configuration", c.Name)
+		}
+		if err := compact.ValidateStorageClasses(c.StorageClasses); err != nil {
+			return nil, errors.Wrapf(err, "bucket %q", c.Name)
+		}
+	}
+	return confs, nil
+}
+
+// compactDeps are the dependencies shared by the compaction of all the buckets of the compactor.
+type compactDeps struct {
+	conf                     compactConfig
+	compactMetrics           *compactMetrics
+	downsampleMetrics        *DownsampleMetrics
+	comp                     compact.Compactor
+	levels                   []int64
+	enableVerticalCompaction bool
+	dedupReplicaLabels       []string
+	labelMergePolicy         *compact.LabelMergePolicy
+	relabelConfig            []*relabel.Config
+	encryptionConfContent    []byte
+	attestationConf          *attestation.Config
+	resilienceConf           objstoreutil.ResilienceConfig
+	hostname                 string
+	adaptiveConcurrency      *compact.AdaptiveConcurrency
+	resourceModel            *compact.ResourceModel
+	activeTracker            *activetracker.Tracker
+	concurrencyPool          *compact.ConcurrencyPool
+}
+
+// compactRunner runs the compaction of a bucket, or of the tenants of a bucket.
+type compactRunner interface {
+	run(ctx context.Context) error
+	runCleanup(ctx context.Context) error
+	runProgressCalculation(ctx context.Context) error
+	// requestReplan requests the groups with the given keys, and the groups with any of the given blocks, to be
+	// planned again by the next compaction.
+	requestReplan(groupKeys []string, ids []ulid.ULID)
+	close()
+}
+
+// compactBucket compacts, downsamples and applies the retention to the blocks of a bucket.
+type compactBucket struct {
+	logger                   log.Logger
+	reg                      prometheus.Registerer
+	deps                     compactDeps
+	bkt                      objstore.InstrumentedBucket
+	baseMetaFetcher          *block.BaseFetcher
+	metaFetcher              *block.MetaFetcher
+	sy                       *compact.Syncer
+	grouper                  *compact.DefaultGrouper
+	compactionProgress       *compact.CompactionProgressCalculator
+	retentionProgress        *compact.RetentionProgressCalculator
+	downsampleProgress       *compact.DownsampleProgressCalculator
+	compactor                *compact.BucketCompactor
+	blocksCleaner            *compact.BlocksCleaner
+	ignoreDeletionMarkFilter *block.IgnoreDeletionMarkFilter
+	noCompactMarkerFilter    *compact.GatherNoCompactionMarkFilter
+	compactionDisabledFilter *compact.GatherCompactionDisabledMarkFilter
+	noDownsampleMarkerFilter *downsample.GatherNoDownsampleMarkFilter
+	markerWriter             *metadata.MarkerWriter
+	retentionByResolution    map[compact.ResolutionLevel]time.Duration
+	storageClasses           []compact.StorageClass
+	storageClassApplier      *compact.StorageClassApplier
+	downsamplingDir          string
+	// state, if set, is restored on creation and saved when the compaction stops.
+	state *compact.StateSnapshot
+	// meter, if set, is updated with the bytes stored in the bucket by tenant after every iteration.
+	meter *metering.Meter
+	// tenant is the tenant directory of the bucket compacted by compactTenants, if any.
+	tenant string
+
+	cleanMtx sync.Mutex
+}
+
+// newCompactObjstoreBucket returns the bucket of the object storage configuration, with the accounting of its
+// operations, and the encryption, the attestation and the resilience of the compactor.
+func newCompactObjstoreBucket(logger log.Logger, reg prometheus.Registerer, deps compactDeps, objStoreConfContent []byte) (objstore.Bucket, error) {
+	bkt, err := objstoreutil.NewBucket(logger, objStoreConfContent, component.Compact.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	bkt = objstoreutil.WrapWithAccounting(bkt, reg)
+	encBkt, err := encryption.WrapWithConfig(logger, bkt, deps.encryptionConfContent)
+	if err != nil {
+		runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+		return nil, err
+	}
+	attBkt := attestation.WrapWithConfig(logger, encBkt, deps.attestationConf)
+	return objstoreutil.WrapWithResilience(logger, attBkt, deps.resilienceConf, reg), nil
+}
+
+// newStorageClasses returns the storage classes of the configurations, with the accounting of the operations of
+// their buckets, and server side copiers for the buckets supporting them.
+func newStorageClasses(ctx context.Context, logger log.Logger, reg prometheus.Registerer, confs []compact.StorageClassConfig) (_ []compact.StorageClass, err error) {
+	classes := make([]compact.StorageClass, 0, len(confs))
+	defer func() {
+		if err != nil {
+			compact.CloseStorageClasses(logger, classes)
+		}
+	}()
+	for _, c := range confs {
+		objStoreConf, err := yaml.Marshal(c.Bucket)
+		if err != nil {
+			return nil, errors.Wrapf(err, "marshal object storage configuration of storage class %s", c.Name)
+		}
+		bkt, err := objstoreutil.NewBucket(logger, objStoreConf, component.Compact.String(), nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "storage class %s", c.Name)
+		}
+		copier, err := objstoreutil.NewServerSideCopier(ctx, logger, objStoreConf, component.Compact.String())
+		if err != nil {
+			runutil.CloseWithLogOnErr(logger, bkt, "storage class bucket client")
+			return nil, errors.Wrapf(err, "storage class %s", c.Name)
+		}
+		class, err := compact.NewStorageClass(c, objstoreutil.WrapWithAccounting(bkt, reg), copier)
+		if err != nil {
+			runutil.CloseWithLogOnErr(logger, bkt, "storage class bucket client")
+			if copier != nil {
+				runutil.CloseWithLogOnErr(logger, copier, "storage class server side copier")
+			}
+			return nil, errors.Wrapf(err, "storage class %s", c.Name)
+		}
+		classes = append(classes, class)
+	}
+	if len(classes) > 0 {
+		level.Info(logger).Log("msg", "storage classes of blocks are enabled", "classes", len(classes))
+	}
+	return classes, nil
+}
+
+// newCompactBucket returns the compaction of the bucket, with its work directories and caches in dataDir. The
+// bucket is closed by run.
+func newCompactBucket(
+	ctx context.Context,
+	logger log.Logger,
+	reg prometheus.Registerer,
+	deps compactDeps,
+	bkt objstore.Bucket,
+	retentionByResolution map[compact.ResolutionLevel]time.Duration,
+	storageClasses []compact.StorageClass,
+	dataDir string,
+) (*compactBucket, error) {
+	conf := deps.conf
+	if err := validateRetention(logger, conf.disableDownsampling, retentionByResolution); err != nil {
+		return nil, err
+	}
+	insBkt := objstoreutil.WrapWithTraces(objstore.WrapWithMetrics(bkt, extprom.WrapRegistererWithPrefix("thanos_", reg), bkt.Name()))
+
+	var err error
+	b := &compactBucket{
+		logger:                logger,
+		reg:                   reg,
+		deps:                  deps,
+		bkt:                   insBkt,
+		retentionByResolution: retentionByResolution,
+		storageClasses:        storageClasses,
+		downsamplingDir:       path.Join(dataDir, "downsample"),
+	}
+	deleteDelay := time.Duration(conf.deleteDelay)
+
+	// While fetching blocks, we filter out blocks that were marked for deletion by using IgnoreDeletionMarkFilter.
+	// The delay of deleteDelay/2 is added to ensure we fetch blocks that are meant to be deleted but do not have a replacement yet.
+	// This is to make sure compactor will not accidentally perform compactions with gap instead.
+	b.ignoreDeletionMarkFilter = block.NewIgnoreDeletionMarkFilter(logger, insBkt, deleteDelay/2, conf.blockMetaFetchConcurrency)
+	duplicateBlocksFilter := block.NewDeduplicateFilter(conf.blockMetaFetchConcurrency)
+	if deps.labelMergePolicy != nil {
+		// The blocks compacted from merged groups replace their sources, whose labels differ.
+		duplicateBlocksFilter.SetGroupKeyFunc(deps.labelMergePolicy.UnshardedGroupKey)
+	}
+	b.noCompactMarkerFilter = compact.NewGatherNoCompactionMarkFilter(logger, insBkt, conf.blockMetaFetchConcurrency)
+	b.noDownsampleMarkerFilter = downsample.NewGatherNoDownsampleMarkFilter(logger, insBkt, conf.blockMetaFetchConcurrency)
+	b.compactionDisabledFilter = compact.NewGatherCompactionDisabledMarkFilter(logger, insBkt)
+	labelShardedMetaFilter := block.NewLabelShardedMetaFilter(deps.relabelConfig)
+	consistencyDelayMetaFilter := block.NewConsistencyDelayMetaFilter(logger, conf.consistencyDelay, extprom.WrapRegistererWithPrefix("thanos_", reg))
+	consistencyDelayMetaFilter.SetUploadCompletedDelay(insBkt, conf.uploadCompletedConsistencyDelay)
+	timePartitionMetaFilter := block.NewTimePartitionMetaFilter(conf.filterConf.MinTime, conf.filterConf.MaxTime)
+
+	var blockLister block.Lister
+	switch syncStrategy(conf.blockListStrategy) {
+	case concurrentDiscovery:
+		blockLister = block.NewConcurrentLister(logger, insBkt)
+	case recursiveDiscovery:
+		blockLister = block.NewRecursiveLister(logger, insBkt)
+	default:
+		return nil, errors.Errorf("unknown sync strategy %s", conf.blockListStrategy)
+	}
+	b.baseMetaFetcher, err = block.NewBaseFetcher(logger, conf.blockMetaFetchConcurrency, insBkt, blockLister, dataDir, extprom.WrapRegistererWithPrefix("thanos_", reg))
+	if err != nil {
+		return nil, errors.Wrap(err, "create meta fetcher")
+	}
+
+	var replacementCheck *compact.ReplacementCheck
+	{
+		var filters []block.MetadataFilter
+		if f := attestation.NewFilter(logger, insBkt, deps.attestationConf, conf.blockMetaFetchConcurrency, reg); f != nil {
+			// Verify metas before any filter modifies them.
+			filters = append(filters, f)
+		}
+		filters = append(filters,
+			timePartitionMetaFilter,
+			labelShardedMetaFilter,
+			consistencyDelayMetaFilter,
+			b.ignoreDeletionMarkFilter,
+			block.NewReplicaLabelRemover(logger, deps.dedupReplicaLabels),
+			duplicateBlocksFilter,
+			b.noCompactMarkerFilter,
+			b.compactionDisabledFilter,
+		)
+		if !conf.disableDownsampling {
+			filters = append(filters, b.noDownsampleMarkerFilter)
+		}
+		// Make sure all compactor meta syncs are done through Syncer.SyncMeta for readability.
+		b.metaFetcher = b.baseMetaFetcher.NewMetaFetcher(
+			extprom.WrapRegistererWithPrefix("thanos_", reg), filters)
+
+		var syncMetasTimeout = conf.waitInterval
+		if !conf.wait {
+			syncMetasTimeout = 0
+		}
+		b.sy, err = compact.NewMetaSyncer(
+			logger,
+			reg,
+			insBkt,
+			b.metaFetcher,
+			duplicateBlocksFilter,
+			b.ignoreDeletionMarkFilter,
+			deps.compactMetrics.blocksMarked.WithLabelValues(metadata.DeletionMarkFilename, ""),
+			deps.compactMetrics.garbageCollectedBlocks,
+			syncMetasTimeout,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "create syncer")
+		}
+		if conf.verifyReplacements {
+			policy := compact.NewDuplicatesGarbagePolicy(duplicateBlocksFilter, b.ignoreDeletionMarkFilter)
+			replacementCheck = compact.NewReplacementCheck(logger, reg, insBkt, conf.verifyReplacementsIndexHeader)
+			policy.SetReplacementCheck(replacementCheck)
+			b.sy.SetGarbagePolicy(policy)
+		}
+	}
+
+	b.markerWriter, err = compact.NewMarkerWriter(ctx, logger, insBkt, deps.hostname, conf.enableFencing)
+	if err != nil {
+		return nil, errors.Wrap(err, "create marker writer")
+	}
+
+	compactDir := path.Join(dataDir, "compact")
+	if err := os.MkdirAll(compactDir, os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "create working compact directory")
+	}
+
+	if err := os.MkdirAll(b.downsamplingDir, os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "create working downsample directory")
+	}
+
+	if len(storageClasses) > 0 {
+		storageClassDir := path.Join(dataDir, "storage-class")
+		if err := os.MkdirAll(storageClassDir, os.ModePerm); err != nil {
+			return nil, errors.Wrap(err, "create working storage class directory")
+		}
+		b.storageClassApplier = compact.NewStorageClassApplier(logger, insBkt, storageClasses, storageClassDir, deps.compactMetrics.storageClassTransitions)
+	}
+
+	var grouperBkt objstore.Bucket = insBkt
+	if deps.adaptiveConcurrency != nil {
+		grouperBkt = deps.adaptiveConcurrency.WrapBucket(insBkt)
+	}
+	b.grouper = compact.NewDefaultGrouper(
+		log.With(logger, "component", "compactor"),
+		grouperBkt,
+		conf.acceptMalformedIndex,
+		deps.enableVerticalCompaction,
+		reg,
+		deps.compactMetrics.blocksMarked.WithLabelValues(metadata.DeletionMarkFilename, ""),
+		deps.compactMetrics.garbageCollectedBlocks,
+		deps.compactMetrics.blocksMarked.WithLabelValues(metadata.NoCompactMarkFilename, metadata.OutOfOrderChunksNoCompactReason),
+		metadata.HashFunc(conf.hashFunc),
+		conf.blockFilesConcurrency,
+		conf.compactBlocksFetchConcurrency,
+	)
+	if deps.labelMergePolicy != nil {
+		b.grouper.SetLabelMergePolicy(deps.labelMergePolicy)
+	}
+	b.grouper.SetCompactionDisabledMarks(b.compactionDisabledFilter)
+	var planner compact.Planner
+
+	tsdbPlanner := compact.NewPlanner(logger, deps.levels, b.noCompactMarkerFilter)
+	if conf.wait && conf.progressCalculateInterval > 0 {
+		b.compactionProgress = compact.NewCompactionProgressCalculator(reg, tsdbPlanner)
+		b.compactionProgress.SetResourceModel(deps.resourceModel)
+		b.retentionProgress = compact.NewRetentionProgressCalculator(reg, retentionByResolution)
+		if !conf.disableDownsampling {
+			b.downsampleProgress = compact.NewDownsampleProgressCalculator(reg)
+		}
+	}
+	maxPlanIndexSize := int64(conf.maxBlockIndexSize)
+	if conf.shardLargeBlocks {
+		// Compactions exceeding the maximum index size are sharded instead, so no block is marked for no compaction.
+		maxPlanIndexSize = math.MaxInt64
+	}
+	largeIndexFilterPlanner := compact.WithLargeTotalIndexSizeFilter(
+		tsdbPlanner,
+		insBkt,
+		maxPlanIndexSize,
+		deps.compactMetrics.blocksMarked.WithLabelValues(metadata.NoCompactMarkFilename, metadata.IndexSizeExceedingNoCompactReason),
+	)
+	if deps.enableVerticalCompaction {
+		planner = compact.WithVerticalCompactionDownsampleFilter(largeIndexFilterPlanner, insBkt, deps.compactMetrics.blocksMarked.WithLabelValues(metadata.NoCompactMarkFilename, metadata.DownsampleVerticalCompactionNoCompactReason))
+	} else {
+		planner = largeIndexFilterPlanner
+	}
+	if conf.minPlanSize > 0 {
+		planner = compact.WithSmallPlanFilter(planner, tsdbPlanner, logger, int64(conf.minPlanSize), conf.minPlanMaxSkips, deps.compactMetrics.smallPlansSkipped)
+	}
+	if conf.maxPlanBlocks > 0 {
+		// Merged small plans are limited too.
+		planner = compact.WithMaxPlanBlocksFilter(planner, logger, conf.maxPlanBlocks)
+	}
+	b.blocksCleaner = compact.NewBlocksCleaner(logger, insBkt, b.ignoreDeletionMarkFilter, deleteDelay, deps.compactMetrics.blocksCleaned, deps.compactMetrics.blockCleanupFailures)
+	var compactionLifecycleCallback compact.CompactionLifecycleCallback = compact.DefaultCompactionLifecycleCallback{}
+	if conf.labelsBloom {
+		compactionLifecycleCallback = compact.NewLabelsBloomCompactionLifecycleCallback(reg, insBkt, compactDir, conf.labelsBloomFalsePositiveRate)
+	}
+	if conf.indexHeader {
+		compactionLifecycleCallback = compact.NewIndexHeaderCompactionLifecycleCallback(compactionLifecycleCallback, reg, insBkt, compactDir)
+	}
+	if conf.seriesHashes {
+		compactionLifecycleCallback = compact.NewSeriesHashesCompactionLifecycleCallback(compactionLifecycleCallback, reg, insBkt, compactDir, conf.seriesHashesWithoutLabels)
+	}
+	b.compactor, err = compact.NewBucketCompactorWithCheckerAndCallback(
+		log.With(logger, "component", "compactor"),
+		b.sy,
+		b.grouper,
+		planner,
+		deps.comp,
+		compact.DefaultBlockDeletableChecker{},
+		compactionLifecycleCallback,
+		compactDir,
+		insBkt,
+		conf.compactionConcurrency,
+		conf.skipBlockWithOutOfOrderChunks,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "create bucket compactor")
+	}
+	if deps.activeTracker != nil {
+		b.compactor.SetActiveTracker(deps.activeTracker)
+	}
+	b.compactor.SetCheckpointing(conf.enableCheckpointing)
+	b.compactor.SetExemplarsRetention(conf.exemplarsRetention)
+	b.compactor.SetSortOutOfOrderChunks(conf.sortOutOfOrderChunks)
+	b.compactor.SetSeriesValidation(conf.seriesValidation)
+	if conf.shardLargeBlocks {
+		b.compactor.SetOutputSharding(int64(conf.maxBlockIndexSize))
+	}
+	if deps.adaptiveConcurrency != nil {
+		b.compactor.SetAdaptiveConcurrency(deps.adaptiveConcurrency)
+	}
+	b.compactor.SetConcurrencyPool(deps.concurrencyPool)
+	b.compactor.SetResourceModel(deps.resourceModel)
+
+	if conf.enableStateSnapshot {
+		components := []compact.StateComponent{b.sy, b.noCompactMarkerFilter, b.compactor}
+		if replacementCheck != nil {
+			components = append(components, replacementCheck)
+		}
+		b.state = compact.NewStateSnapshot(logger, path.Join(dataDir, compact.StateFilename), time.Duration(conf.stateSnapshotMaxAge), components...)
+		b.state.Restore()
+	}
+	return b, nil
+}
+
+func validateRetention(logger log.Logger, disableDownsampling bool, retentionByResolution map[compact.ResolutionLevel]time.Duration) error {
+	if retentionByResolution[compact.ResolutionLevelRaw].Milliseconds() != 0 {
+		// If downsampling is enabled, error if raw retention is not sufficient for downsampling to occur (upper bound 10 days for 1h resolution)
+		if !disableDownsampling && retentionByResolution[compact.ResolutionLevelRaw].Milliseconds() < downsample.ResLevel1DownsampleRange {
+			return errors.New("raw resolution must be higher than the minimum block size after which 5m resolution downsampling will occur (40 hours)")
+		}
+		level.Info(logger).Log("msg", "retention policy of raw samples is enabled", "duration", retentionByResolution[compact.ResolutionLevelRaw])
+	}
+	if retentionByResolution[compact.ResolutionLevel5m].Milliseconds() != 0 {
+		// If retention is lower than minimum downsample range, then no downsampling at this resolution will be persisted
+		if !disableDownsampling && retentionByResolution[compact.ResolutionLevel5m].Milliseconds() < downsample.ResLevel2DownsampleRange {
+			return errors.New("5m resolution retention must be higher than the minimum block size after which 1h resolution downsampling will occur (10 days)")
+		}
+		level.Info(logger).Log("msg", "retention policy of 5 min aggregated samples is enabled", "duration", retentionByResolution[compact.ResolutionLevel5m])
+	}
+	if retentionByResolution[compact.ResolutionLevel1h].Milliseconds() != 0 {
+		level.Info(logger).Log("msg", "retention policy of 1 hour aggregated samples is enabled", "duration", retentionByResolution[compact.ResolutionLevel1h])
+	}
+	return nil
+}
+
+// TODO(GiedriusS): we could also apply retention policies here but the logic would be a bit more complex.
+func (b *compactBucket) cleanPartialMarked(ctx context.Context) error {
+	b.cleanMtx.Lock()
+	defer b.cleanMtx.Unlock()
+	ctx = block.WithMarkerWriter(ctx, b.markerWriter)
+	m := b.deps.compactMetrics
+
+	if err := b.sy.SyncMetas(ctx); err != nil {
+		return errors.Wrap(err, "syncing metas")
+	}
+
+	compact.BestEffortCleanAbortedPartialUploads(ctx, b.logger, b.sy.Partial(), b.bkt, m.partialUploadDeleteAttempts, m.blocksCleaned, m.blockCleanupFailures)
+	if err := b.blocksCleaner.DeleteMarkedBlocks(ctx); err != nil {
+		return errors.Wrap(err, "cleaning marked blocks")
+	}
+	m.cleanups.Inc()
+
+	return nil
+}
+
+func (b *compactBucket) downsample(ctx context.Context, pass string) error {
+	if err := b.sy.SyncMetas(ctx); err != nil {
+		return errors.Wrapf(err, "sync before %s pass of downsampling", pass)
+	}
+
+	// The filtered list of blocks is regenerated after the sync,
+	// to include the blocks created by the first pass.
+	filteredMetas := b.sy.Metas()
+	noDownsampleBlocks := b.noDownsampleMarkerFilter.NoDownsampleMarkedBlocks()
+	for ul := range noDownsampleBlocks {
+		delete(filteredMetas, ul)
+	}
+
+	for _, meta := range filteredMetas {
+		resolutionLabel := meta.Thanos.ResolutionString()
+		b.deps.downsampleMetrics.downsamples.WithLabelValues(resolutionLabel)
+		b.deps.downsampleMetrics.downsampleFailures.WithLabelValues(resolutionLabel)
+		b.deps.downsampleMetrics.readBytes.WithLabelValues(resolutionLabel)
+		b.deps.downsampleMetrics.writtenBytes.WithLabelValues(resolutionLabel)
+	}
+
+	conf := b.deps.conf
+	if err := downsampleBucket(
+		ctx,
+		b.logger,
+		b.deps.downsampleMetrics,
+		b.bkt,
+		filteredMetas,
+		b.downsamplingDir,
+		conf.downsampleConcurrency,
+		conf.blockFilesConcurrency,
+		metadata.HashFunc(conf.hashFunc),
+		conf.acceptMalformedIndex,
+	); err != nil {
+		return errors.Wrapf(err, "%s pass of downsampling failed", pass)
+	}
+	return nil
+}
+
+// compact runs an iteration of the compaction, downsampling and retention of the bucket.
+func (b *compactBucket) compact(ctx context.Context) error {
+	ctx = block.WithMarkerWriter(ctx, b.markerWriter)
+	if err := b.compactor.Compact(ctx); err != nil {
+		return errors.Wrap(err, "compaction")
+	}
+
+	if !b.deps.conf.disableDownsampling {
+		// After all compactions are done, work down the downsampling backlog.
+		// We run two passes of this to ensure that the 1h downsampling is generated
+		// for 5m downsamplings created in the first run.
+		level.Info(b.logger).Log("msg", "start first pass of downsampling")
+		if err := b.downsample(ctx, "first"); err != nil {
+			return err
+		}
+
+		level.Info(b.logger).Log("msg", "start second pass of downsampling")
+		if err := b.downsample(ctx, "second"); err != nil {
+			return err
+		}
+
+		level.Info(b.logger).Log("msg", "downsampling iterations done")
+	} else {
+		level.Info(b.logger).Log("msg", "downsampling was explicitly disabled")
+	}
+
+	// TODO(bwplotka): Find a way to avoid syncing if no op was done.
+	if err := b.sy.SyncMetas(ctx); err != nil {
+		return errors.Wrap(err, "sync before retention")
+	}
+
+	if err := compact.ApplyRetentionPolicyByResolution(ctx, b.logger, b.bkt, b.sy.Metas(), b.retentionByResolution, b.deps.compactMetrics.blocksMarked.WithLabelValues(metadata.DeletionMarkFilename, "")); err != nil {
+		return errors.Wrap(err, "retention failed")
+	}
+	if b.storageClassApplier != nil {
+		if err := b.storageClassApplier.Apply(ctx, b.sy.Metas()); err != nil {
+			return errors.Wrap(err, "storage classes failed")
+		}
+	}
+	b.meter.SetStoredBytes(metering.StoredBytes(b.sy.Metas(), b.deps.conf.meteringTenantLabel))
+
+	return b.cleanPartialMarked(ctx)
+}
+
+// run compacts the bucket once, or every wait interval with --wait until the context is canceled.
+func (b *compactBucket) run(ctx context.Context) error {
+	defer runutil.CloseWithLogOnErr(b.logger, b.bkt, "bucket client")
+	defer compact.CloseStorageClasses(b.logger, b.storageClasses)
+	defer b.saveState()
+
+	return runCompactLoop(ctx, b.logger, b.deps, b.compact, b.compactor.ReplanRequested())
+}
+
+// saveState saves the state snapshot of the bucket, if enabled.
+func (b *compactBucket) saveState() {
+	if b.state == nil {
+		return
+	}
+	if err := b.state.Save(); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to save compactor state", "err", err)
+	}
+}
+
+func (b *compactBucket) requestReplan(groupKeys []string, ids []ulid.ULID) {
+	b.compactor.RequestReplan(groupKeys, ids)
+}
+
+func (b *compactBucket) close() {
+	runutil.CloseWithLogOnErr(b.logger, b.bkt, "bucket client")
+	compact.CloseStorageClasses(b.logger, b.storageClasses)
+}
+
+// runCompactLoop runs the compaction once, or every wait interval with --wait until the context is canceled. With
+// --wait, a value received from replan starts the next compaction without waiting for the rest of the interval.
+func runCompactLoop(ctx context.Context, logger log.Logger, deps compactDeps, compactFn func(context.Context) error, replan <-chan struct{}) error {
+	conf, m := deps.conf, deps.compactMetrics
+	if !conf.wait {
+		return compactFn(ctx)
+	}
+
+	// --wait=true is specified.
+	return repeatOrReplan(ctx, conf.waitInterval, replan, func() error {
+		err := compactFn(ctx)
+		if err == nil {
+			m.iterations.Inc()
+			return nil
+		}
+
+		// The HaltError type signals that we hit a critical bug and should block
+		// for investigation. You should alert on this being halted.
+		if compact.IsHaltError(err) {
+			if conf.haltOnError {
+				level.Error(logger).Log("msg", "critical error detected; halting", "err", err)
+				m.halted.Set(1)
+				select {}
+			} else {
+				return errors.Wrap(err, "critical error detected")
+			}
+		}
+
+		// The RetryError signals that we hit an retriable error (transient error, no connection).
+		// You should alert on this being triggered too frequently.
+		if compact.IsRetryError(err) {
+			level.Error(logger).Log("msg", "retriable error", "err", err)
+			m.retried.Inc()
+			// TODO(bplotka): use actual "retry()" here instead of waiting 5 minutes?
+			return nil
+		}
+
+		return errors.Wrap(err, "error executing compaction")
+	})
+}
+
+// repeatOrReplan executes f every interval seconds like runutil.Repeat, or as soon as a value is received from
+// replan, until the context is canceled or f returns an error.
+func repeatOrReplan(ctx context.Context, interval time.Duration, replan <-chan struct{}, f func() error) error {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		if err := f(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+		case <-replan:
+		}
+	}
+}
+
+// runCleanup periodically removes partial blocks and blocks marked for deletion, since one iteration potentially
+// could take a long time.
+func (b *compactBucket) runCleanup(ctx context.Context) error {
+	return runCleanupLoop(ctx, b.logger, b.deps, b.cleanPartialMarked)
+}
+
+func runCleanupLoop(ctx context.Context, logger log.Logger, deps compactDeps, cleanFn func(context.Context) error) error {
+	return runutil.Repeat(deps.conf.cleanupBlocksInterval, ctx.Done(), func() error {
+		err := cleanFn(ctx)
+		if err != nil && compact.IsRetryError(err) {
+			// The RetryError signals that we hit an retriable error (transient error, no connection).
+			// You should alert on this being triggered too frequently.
+			level.Error(logger).Log("msg", "retriable error", "err", err)
+			deps.compactMetrics.retried.Inc()
+
+			return nil
+		}
+
+		return err
+	})
+}
+
+// runProgressCalculation periodically calculates the progress of compaction, downsampling and retention.
+func (b *compactBucket) runProgressCalculation(ctx context.Context) error {
+	return runProgressLoop(ctx, b.logger, b.deps, b.calculateProgress)
+}
+
+func runProgressLoop(ctx context.Context, logger log.Logger, deps compactDeps, calculateFn func(context.Context) error) error {
+	return runutil.Repeat(deps.conf.progressCalculateInterval, ctx.Done(), func() error {
+		err := calculateFn(ctx)
+		// The RetryError signals that we hit an retriable error (transient error, no connection).
+		// You should alert on this being triggered too frequently.
+		if err != nil && compact.IsRetryError(err) {
+			level.Error(logger).Log("msg", "retriable error", "err", err)
+			deps.compactMetrics.retried.Inc()
+
+			return nil
+		}
+		return err
+	})
+}
+
+// calculateProgress calculates the progress of compaction, downsampling and retention of the bucket.
+func (b *compactBucket) calculateProgress(ctx context.Context) error {
+	if err := b.sy.SyncMetas(ctx); err != nil {
+		if compact.IsRetryError(err) {
+			return err
+		}
+		return errors.Wrapf(err, "could not sync metas")
+	}
+
+	metas := b.sy.Metas()
+	groups, err := b.grouper.Groups(metas)
+	if err != nil {
+		return errors.Wrapf(err, "could not group metadata for compaction")
+	}
+
+	if err = b.compactionProgress.ProgressCalculate(ctx, groups); err != nil {
+		return errors.Wrapf(err, "could not calculate compaction progress")
+	}
+
+	retGroups, err := b.grouper.Groups(metas)
+	if err != nil {
+		return errors.Wrapf(err, "could not group metadata for retention")
+	}
+
+	if err = b.retentionProgress.ProgressCalculate(ctx, retGroups); err != nil {
+		return errors.Wrapf(err, "could not calculate retention progress")
+	}
+
+	if b.downsampleProgress != nil {
+		groups, err = b.grouper.Groups(metas)
+		if err != nil {
+			return errors.Wrapf(err, "could not group metadata into downsample groups")
+		}
+		if err := b.downsampleProgress.ProgressCalculate(ctx, groups); err != nil {
+			return errors.Wrapf(err, "could not calculate downsampling progress")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore/client"
+)
+
+func Test_parseCompactBucketsConfig(t *testing.T) {
+	t.Parallel()
+
+	confs, err := parseCompactBucketsConfig([]byte(`
+- name: team-a
+  bucket:
+    type: FILESYSTEM
+    config:
+      directory: /tmp/a
+    prefix: tenant-a
+  retention_resolution_raw: 30d
+- name: team-b
+  bucket:
+    type: FILESYSTEM
+    config:
+      directory: /tmp/b
+  tenant_directories: true
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(confs))
+	testutil.Equals(t, "team-a", confs[0].Name)
+	testutil.Equals(t, client.FILESYSTEM, confs[0].Bucket.Type)
+	testutil.Equals(t, "tenant-a", confs[0].Bucket.Prefix)
+	testutil.Equals(t, model.Duration(30*24*time.Hour), confs[0].RetentionRaw)
+	testutil.Equals(t, model.Duration(0), confs[1].RetentionRaw)
+	testutil.Assert(t, !confs[0].TenantDirectories)
+	testutil.Assert(t, confs[1].TenantDirectories)
+
+	for _, tcase := range []string{
+		// Names have to be unique.
+		"[{name: a, bucket: {type: FILESYSTEM}}, {name: a, bucket: {type: FILESYSTEM}}]",
+		// Names are directories.
+		"[{name: a/b, bucket: {type: FILESYSTEM}}]",
+		"[{name: .., bucket: {type: FILESYSTEM}}]",
+		"[{bucket: {type: FILESYSTEM}}]",
+		"[{name: a}]",
+		"[{name: a, bucket: {type: FILESYSTEM}, unknown: 1}]",
+	} {
+		_, err := parseCompactBucketsConfig([]byte(tcase))
+		testutil.NotOk(t, err, tcase)
+	}
+}
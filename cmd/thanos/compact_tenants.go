@@ -0,0 +1,214 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"context"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/compact"
+	"github.com/thanos-io/thanos/pkg/errutil"
+	"github.com/thanos-io/thanos/pkg/metering"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// compactTenants compacts the blocks of every tenant directory of a bucket in a tenant directory layout,
+// <tenant>/<block>, as the bucket of the tenant. The tenants are discovered on every iteration and compacted one
+// after the other.
+type compactTenants struct {
+	logger                log.Logger
+	deps                  compactDeps
+	bkt                   objstore.Bucket
+	retentionByResolution map[compact.ResolutionLevel]time.Duration
+	storageClasses        []compact.StorageClass
+	dataDir               string
+	// tenantReg returns the registerer of the metrics of the compaction of the tenant.
+	tenantReg func(tenant string) prometheus.Registerer
+	// meter, if set, is updated with the bytes stored by tenant directory after every iteration.
+	meter *metering.Meter
+
+	mtx sync.Mutex
+	// tenants are all the tenants discovered so far, as their metrics cannot be unregistered.
+	tenants map[string]*compactBucket
+	// current are the tenants of the last discovery.
+	current []string
+	// replanRequested receives a value when groups of tenants were requested to be planned again.
+	replanRequested chan struct{}
+}
+
+func newCompactTenants(
+	logger log.Logger,
+	deps compactDeps,
+	bkt objstore.Bucket,
+	retentionByResolution map[compact.ResolutionLevel]time.Duration,
+	storageClasses []compact.StorageClass,
+	dataDir string,
+	tenantReg func(tenant string) prometheus.Registerer,
+) (*compactTenants, error) {
+	if err := validateRetention(logger, deps.conf.disableDownsampling, retentionByResolution); err != nil {
+		return nil, err
+	}
+	return &compactTenants{
+		logger:                logger,
+		deps:                  deps,
+		bkt:                   bkt,
+		retentionByResolution: retentionByResolution,
+		storageClasses:        storageClasses,
+		dataDir:               dataDir,
+		tenantReg:             tenantReg,
+		tenants:               map[string]*compactBucket{},
+		replanRequested:       make(chan struct{}, 1),
+	}, nil
+}
+
+// syncTenants discovers the tenants of the bucket, and returns the compaction of each of them.
+func (t *compactTenants) syncTenants(ctx context.Context) ([]*compactBucket, error) {
+	names, err := compact.ListTenants(ctx, t.bkt)
+	if err != nil {
+		return nil, compact.NewRetryError(err)
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	var added int
+	for _, name := range names {
+		if _, ok := t.tenants[name]; ok {
+			continue
+		}
+		logger := log.With(t.logger, "tenant", name)
+		// The storage classes of the tenant upload to the tenant directory of their buckets.
+		storageClasses := make([]compact.StorageClass, 0, len(t.storageClasses))
+		for _, c := range t.storageClasses {
+			storageClasses = append(storageClasses, c.WithPrefix(name))
+		}
+		b, err := newCompactBucket(ctx, logger, t.tenantReg(name), t.deps, objstore.NewPrefixedBucket(t.bkt, name), t.retentionByResolution, storageClasses, path.Join(t.dataDir, "tenants", name))
+		if err != nil {
+			return nil, errors.Wrapf(err, "tenant %s", name)
+		}
+		b.tenant = name
+		b.grouper.SetTenantPrefix(name)
+		t.tenants[name] = b
+		added++
+	}
+	if added > 0 {
+		level.Info(t.logger).Log("msg", "discovered tenants", "added", added, "tenants", len(names))
+	}
+	t.current = names
+	return t.currentLocked(), nil
+}
+
+func (t *compactTenants) currentTenants() []*compactBucket {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	return t.currentLocked()
+}
+
+func (t *compactTenants) currentLocked() []*compactBucket {
+	bs := make([]*compactBucket, 0, len(t.current))
+	for _, name := range t.current {
+		bs = append(bs, t.tenants[name])
+	}
+	return bs
+}
+
+// forEachTenant calls f for the compaction of every tenant in order, as long as the context is not canceled, and
+// returns the errors of all the tenants.
+func forEachTenant(ctx context.Context, bs []*compactBucket, f func(*compactBucket) error) error {
+	var errs errutil.MultiError
+	for _, b := range bs {
+		if ctx.Err() != nil {
+			errs.Add(ctx.Err())
+			break
+		}
+		if err := f(b); err != nil {
+			errs.Add(errors.Wrapf(err, "tenant %s", b.tenant))
+		}
+	}
+	return errs.Err()
+}
+
+// compact runs an iteration of the compaction, downsampling and retention of every tenant. A failing tenant does
+// not prevent the compaction of the other tenants.
+func (t *compactTenants) compact(ctx context.Context) error {
+	bs, err := t.syncTenants(ctx)
+	if err != nil {
+		return err
+	}
+	err = forEachTenant(ctx, bs, func(b *compactBucket) error {
+		return b.compact(ctx)
+	})
+
+	if t.meter != nil {
+		stored := map[string]int64{}
+		for _, b := range bs {
+			for _, bytes := range metering.StoredBytes(b.sy.Metas(), t.deps.conf.meteringTenantLabel) {
+				stored[b.tenant] += bytes
+			}
+		}
+		t.meter.SetStoredBytes(stored)
+	}
+	return err
+}
+
+func (t *compactTenants) cleanPartialMarked(ctx context.Context) error {
+	return forEachTenant(ctx, t.currentTenants(), func(b *compactBucket) error {
+		return b.cleanPartialMarked(ctx)
+	})
+}
+
+func (t *compactTenants) calculateProgress(ctx context.Context) error {
+	return forEachTenant(ctx, t.currentTenants(), func(b *compactBucket) error {
+		return b.calculateProgress(ctx)
+	})
+}
+
+func (t *compactTenants) run(ctx context.Context) error {
+	defer runutil.CloseWithLogOnErr(t.logger, t.bkt, "bucket client")
+	defer compact.CloseStorageClasses(t.logger, t.storageClasses)
+	defer func() {
+		t.mtx.Lock()
+		defer t.mtx.Unlock()
+		for _, b := range t.tenants {
+			b.saveState()
+		}
+	}()
+
+	return runCompactLoop(ctx, t.logger, t.deps, t.compact, t.replanRequested)
+}
+
+// requestReplan requests the groups to be planned again by the compactions of all the current tenants, as group keys
+// and blocks are only known by the compaction of their tenant.
+func (t *compactTenants) requestReplan(groupKeys []string, ids []ulid.ULID) {
+	for _, b := range t.currentTenants() {
+		b.requestReplan(groupKeys, ids)
+	}
+	select {
+	case t.replanRequested <- struct{}{}:
+	default:
+	}
+}
+
+func (t *compactTenants) runCleanup(ctx context.Context) error {
+	return runCleanupLoop(ctx, t.logger, t.deps, t.cleanPartialMarked)
+}
+
+func (t *compactTenants) runProgressCalculation(ctx context.Context) error {
+	return runProgressLoop(ctx, t.logger, t.deps, t.calculateProgress)
+}
+
+func (t *compactTenants) close() {
+	runutil.CloseWithLogOnErr(t.logger, t.bkt, "bucket client")
+	compact.CloseStorageClasses(t.logger, t.storageClasses)
+}
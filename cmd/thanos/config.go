@@ -7,14 +7,20 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/KimMachineGun/automemlimit/memlimit"
+	"github.com/alecthomas/units"
 	extflag "github.com/efficientgo/tools/extkingpin"
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/run"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
@@ -22,11 +28,23 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+	objstoretracing "github.com/thanos-io/objstore/tracing/opentracing"
 
+	apiv1 "github.com/thanos-io/thanos/pkg/api/query"
+	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/extgrpc"
 	"github.com/thanos-io/thanos/pkg/extgrpc/snappy"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/extprom"
+	"github.com/thanos-io/thanos/pkg/metering"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
+	"github.com/thanos-io/thanos/pkg/queryexport"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	grpcserver "github.com/thanos-io/thanos/pkg/server/grpc"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 	"github.com/thanos-io/thanos/pkg/shipper"
+	"github.com/thanos-io/thanos/pkg/walquota"
 )
 
 type grpcConfig struct {
@@ -37,6 +55,7 @@ type grpcConfig struct {
 	tlsMinVersion    string
 	gracePeriod      time.Duration
 	maxConnectionAge time.Duration
+	authConfig       *extflag.PathOrContent
 }
 
 func (gc *grpcConfig) registerFlag(cmd extkingpin.FlagClause) *grpcConfig {
@@ -60,10 +79,43 @@ func (gc *grpcConfig) registerFlag(cmd extkingpin.FlagClause) *grpcConfig {
 	cmd.Flag("grpc-grace-period",
 		"Time to wait after an interrupt received for GRPC Server.").
 		Default("2m").DurationVar(&gc.gracePeriod)
+	gc.authConfig = extflag.RegisterPathOrContent(cmd, "grpc-server-auth-config",
+		"YAML file with the policies allowing clients, identified by their verified TLS client certificates, to call gRPC methods. Requires --grpc-server-tls-client-ca. See format details: https://thanos.io/tip/operating/https.md/#grpc-authorization ",
+		extflag.WithEnvSubstitution(),
+	)
 
 	return gc
 }
 
+// newRBAC returns the RBAC middleware of HTTP endpoints, nil if no RBAC rules are configured.
+func newRBAC(reg prometheus.Registerer, rbacConfig *extflag.PathOrContent, tenantHeader, defaultTenantID, certTenantField string) (*middleware.RBAC, error) {
+	content, err := rbacConfig.Content()
+	if err != nil {
+		return nil, errors.Wrap(err, "get content of HTTP RBAC configuration")
+	}
+	conf, err := middleware.ParseRBACConfig(content)
+	if err != nil || conf == nil {
+		return nil, err
+	}
+	return middleware.NewRBAC(reg, *conf, tenantHeader, defaultTenantID, certTenantField), nil
+}
+
+// authorizer returns the authorizer of gRPC server requests, nil if no authorization policies are configured.
+func (gc *grpcConfig) authorizer(reg prometheus.Registerer) (*grpcserver.Authorizer, error) {
+	content, err := gc.authConfig.Content()
+	if err != nil {
+		return nil, errors.Wrap(err, "get content of gRPC server auth configuration")
+	}
+	conf, err := grpcserver.ParseAuthConfig(content)
+	if err != nil || conf == nil {
+		return nil, err
+	}
+	if gc.tlsSrvClientCA == "" {
+		return nil, errors.New("--grpc-server-auth-config requires --grpc-server-tls-client-ca to verify client certificates")
+	}
+	return grpcserver.NewAuthorizer(reg, *conf), nil
+}
+
 type grpcClientConfig struct {
 	secure            bool
 	skipVerify        bool
@@ -206,11 +258,16 @@ type shipperConfig struct {
 	skipCorruptedBlocks   bool
 	hashFunc              string
 	metaFileName          string
+	backfill              bool
+	uploadRateLimit       units.Base2Bytes
+	cluster               string
+	metaAnnotations       map[string]string
+	metaExtensions        string
 }
 
 func (sc *shipperConfig) registerFlag(cmd extkingpin.FlagClause) *shipperConfig {
 	cmd.Flag("shipper.upload-compacted",
-		"If true shipper will try to upload compacted blocks as well. Useful for migration purposes. Works only if compaction is disabled on Prometheus. Do it once and then disable the flag when done.").
+		"If true shipper will try to upload compacted blocks as well. Useful for migration purposes, or to keep Prometheus compaction enabled. Compacted blocks are only uploaded if they do not overlap with bucket blocks other than those they were compacted from, which the compactor then garbage collects.").
 		Default("false").BoolVar(&sc.uploadCompacted)
 	cmd.Flag("shipper.ignore-unequal-block-size",
 		"If true shipper will not require prometheus min and max block size flags to be set to the same value. Only use this if you want to keep long retention and compaction enabled on your Prometheus instance, as in the worst case it can result in ~2h data loss for your Thanos bucket storage.").
@@ -228,9 +285,36 @@ func (sc *shipperConfig) registerFlag(cmd extkingpin.FlagClause) *shipperConfig
 	cmd.Flag("hash-func", "Specify which hash function to use when calculating the hashes of produced files. If no function has been specified, it does not happen. This permits avoiding downloading some files twice albeit at some performance cost. Possible values are: \"\", \"SHA256\".").
 		Default("").EnumVar(&sc.hashFunc, "SHA256", "")
 	cmd.Flag("shipper.meta-file-name", "the file to store shipper metadata in").Default(shipper.DefaultMetaFilename).StringVar(&sc.metaFileName)
+	cmd.Flag("shipper.backfill",
+		"If true shipper will upload all blocks found in the data directory, including compacted ones, oldest first. Progress is tracked in the shipper meta file, so the backfill resumes after restarts and only runs once. Useful to migrate the history of an existing Prometheus.").
+		Default("false").BoolVar(&sc.backfill)
+	cmd.Flag("shipper.upload-rate-limit",
+		"Maximum bandwidth used to upload blocks, per second. A unit is required, supported units: B, KB, MB, GB, TB, PB, EB. Ex: \"16MB\". 0 disables the limit.").
+		Default("0").BytesVar(&sc.uploadRateLimit)
+	cmd.Flag("shipper.cluster",
+		"Name of the cluster this component runs in, recorded with the component and its version in the provenance of the uploaded blocks. The blocks compacted from them keep the provenance of all their sources.").
+		Default("").StringVar(&sc.cluster)
+	cmd.Flag("shipper.meta-annotation",
+		"Annotation to inject into the meta of the uploaded blocks, in the KEY=VALUE form, e.g. team=observability. Can be repeated. The blocks compacted from them keep the annotations all their sources agree on.").
+		PlaceHolder("<key>=<value>").StringMapVar(&sc.metaAnnotations)
+	cmd.Flag("shipper.meta-extensions",
+		"JSON object whose keys are injected into the extensions of the meta of the uploaded blocks, e.g. {\"environment\":\"prod\"}. The blocks compacted from them keep the keys all their sources agree on.").
+		Default("").StringVar(&sc.metaExtensions)
 	return sc
 }
 
+// parseMetaExtensions returns the extensions to inject into the meta of the uploaded blocks.
+func (sc *shipperConfig) parseMetaExtensions() (map[string]any, error) {
+	if sc.metaExtensions == "" {
+		return nil, nil
+	}
+	var ext map[string]any
+	if err := json.Unmarshal([]byte(sc.metaExtensions), &ext); err != nil {
+		return nil, errors.Wrap(err, "parse shipper meta extensions as a JSON object")
+	}
+	return ext, nil
+}
+
 type webConfig struct {
 	routePrefix      string
 	externalPrefix   string
@@ -293,6 +377,7 @@ type alertMgrConfig struct {
 	alertQueryURL          *string
 	alertRelabelConfigPath *extflag.PathOrContent
 	alertSourceTemplate    *string
+	alertHALeaseTTL        time.Duration
 }
 
 func (ac *alertMgrConfig) registerFlag(cmd extflag.FlagClause) *alertMgrConfig {
@@ -308,6 +393,8 @@ func (ac *alertMgrConfig) registerFlag(cmd extflag.FlagClause) *alertMgrConfig {
 		StringsVar(&ac.alertExcludeLabels)
 	ac.alertRelabelConfigPath = extflag.RegisterPathOrContent(cmd, "alert.relabel-config", "YAML file that contains alert relabelling configuration.", extflag.WithEnvSubstitution())
 	ac.alertSourceTemplate = cmd.Flag("alert.query-template", "Template to use in alerts source field. Need only include {{.Expr}} parameter").Default("/graph?g0.expr={{.Expr}}&g0.tab=1").String()
+	cmd.Flag("alert.ha-lease-ttl", "[EXPERIMENTAL] If set, replicas of the same HA group, identified by their labels without the '--alert.label-drop' ones, elect the replica sending alerts through a lease with this TTL stored in the object storage. Other replicas evaluate rules but do not send alerts. Requires an object storage and '--alert.label-drop' to be configured. 0 disables the lease and all replicas send alerts.").
+		Default("0s").DurationVar(&ac.alertHALeaseTTL)
 
 	return ac
 }
@@ -316,6 +403,19 @@ func parseFlagLabels(s []string) (labels.Labels, error) {
 	var lset labels.ScratchBuilder
 	for _, l := range s {
 		parts := strings.SplitN(l, "=", 2)
+		// UTF-8 label names can contain the separator, so they are quoted.
+		if strings.HasPrefix(l, `"`) {
+			quoted, err := strconv.QuotedPrefix(l)
+			if err != nil {
+				return labels.EmptyLabels(), errors.Wrapf(err, "unquote label name of %s", l)
+			}
+			name, _ := strconv.Unquote(quoted)
+			val, ok := strings.CutPrefix(l[len(quoted):], "=")
+			if !ok {
+				return labels.EmptyLabels(), errors.Errorf("unrecognized label %q", l)
+			}
+			parts = []string{name, val}
+		}
 		if len(parts) != 2 {
 			return labels.EmptyLabels(), errors.Errorf("unrecognized label %q", l)
 		}
@@ -332,6 +432,163 @@ func parseFlagLabels(s []string) (labels.Labels, error) {
 	return lset.Labels(), nil
 }
 
+type meteringConfig struct {
+	enabled        bool
+	recordInterval time.Duration
+}
+
+func (mc *meteringConfig) registerFlag(cmd extkingpin.FlagClause) *meteringConfig {
+	cmd.Flag("metering.enabled",
+		"Meter the usage of tenants and export it as thanos_metering_* metrics.").
+		Default("false").BoolVar(&mc.enabled)
+	return mc
+}
+
+func (mc *meteringConfig) registerRecordFlag(cmd extkingpin.FlagClause) *meteringConfig {
+	cmd.Flag("metering.record-interval",
+		"Interval of writing the usage of tenants as records to the usage/ directory of the bucket. 0 disables usage records. Requires --metering.enabled.").
+		Default("0s").DurationVar(&mc.recordInterval)
+	return mc
+}
+
+// meter returns the meter of the usage of tenants by the component, nil if metering is disabled.
+func (mc *meteringConfig) meter(reg prometheus.Registerer, comp component.Component) (*metering.Meter, error) {
+	if !mc.enabled {
+		if mc.recordInterval > 0 {
+			return nil, errors.New("--metering.record-interval requires --metering.enabled")
+		}
+		return nil, nil
+	}
+	instance, err := os.Hostname()
+	if err != nil {
+		return nil, errors.Wrap(err, "get hostname for metering")
+	}
+	return metering.NewMeter(reg, comp.String(), instance), nil
+}
+
+// addRecordWriter adds an actor writing the usage records of the meter to the bucket, if enabled.
+func (mc *meteringConfig) addRecordWriter(g *run.Group, logger log.Logger, meter *metering.Meter, bkt objstore.Bucket) {
+	if meter == nil || mc.recordInterval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	g.Add(func() error {
+		return meter.WriteRecords(ctx, logger, bkt, mc.recordInterval)
+	}, func(error) {
+		cancel()
+	})
+}
+
+type queryExportConfig struct {
+	objStoreConfig *extflag.PathOrContent
+	opts           queryexport.Options
+}
+
+func (qe *queryExportConfig) registerFlag(cmd extkingpin.FlagClause) *queryExportConfig {
+	qe.objStoreConfig = extkingpin.RegisterCommonObjStoreFlags(cmd, ".query-export", false,
+		"The results of query export jobs are written to this bucket, which must not store blocks. Query export jobs are disabled if not set.")
+	cmd.Flag("query-export.max-concurrent", "Maximum number of query export jobs run concurrently.").
+		Default("1").IntVar(&qe.opts.MaxConcurrent)
+	cmd.Flag("query-export.max-queued", "Maximum number of query export jobs waiting to be run. New jobs are rejected above it.").
+		Default("10").IntVar(&qe.opts.MaxQueued)
+	cmd.Flag("query-export.timeout", "Maximum duration of a query export job.").
+		Default("1h").DurationVar(&qe.opts.Timeout)
+	cmd.Flag("query-export.split-interval", "Split the range of query export jobs into queries of at most this interval, evaluated one after the other. 0 disables the split.").
+		Default("24h").DurationVar(&qe.opts.SplitInterval)
+	cmd.Flag("query-export.max-samples", "Maximum number of samples of the result of a query export job. Jobs exceeding it fail. 0 is no limit.").
+		Default("100000000").IntVar(&qe.opts.MaxSamples)
+	return qe
+}
+
+// manager returns the manager of the query export jobs running the queries with the query function, nil if query
+// export jobs are disabled, and adds an actor running the jobs.
+func (qe *queryExportConfig) manager(g *run.Group, logger log.Logger, reg prometheus.Registerer, query queryexport.QueryFunc) (*queryexport.Manager, error) {
+	confContentYaml, err := qe.objStoreConfig.Content()
+	if err != nil {
+		return nil, err
+	}
+	if len(confContentYaml) == 0 {
+		return nil, nil
+	}
+	bkt, err := objstoreutil.NewBucket(logger, confContentYaml, component.Query.String(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create query export bucket")
+	}
+	bkt = objstoretracing.WrapWithTraces(objstore.WrapWithMetrics(bkt, extprom.WrapRegistererWithPrefix("thanos_query_export_", reg), bkt.Name()))
+
+	m := queryexport.NewManager(log.With(logger, "component", "query-export"), reg, bkt, query, qe.opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	g.Add(func() error {
+		defer runutil.CloseWithLogOnErr(logger, bkt, "query export bucket client")
+		return m.Run(ctx)
+	}, func(error) {
+		cancel()
+	})
+	return m, nil
+}
+
+type queryRemoteReadConfig struct {
+	enabled bool
+	conf    apiv1.RemoteReadConfig
+}
+
+func (rr *queryRemoteReadConfig) registerFlag(cmd extkingpin.FlagClause) *queryRemoteReadConfig {
+	cmd.Flag("query.enable-remote-read", "Enable the Prometheus remote read endpoint /api/v1/read, serving the series of all the StoreAPIs. Both sampled and streamed XOR chunks responses are supported.").
+		Default("false").BoolVar(&rr.enabled)
+	cmd.Flag("query.remote-read.sample-limit", "Maximum number of samples of a query of sampled remote read responses. Streamed responses are not limited. 0 is no limit.").
+		Default("50000000").IntVar(&rr.conf.SampleLimit)
+	cmd.Flag("query.remote-read.concurrency-limit", "Maximum number of remote read requests processed concurrently.").
+		Default("10").IntVar(&rr.conf.ConcurrencyLimit)
+	cmd.Flag("query.remote-read.max-bytes-in-frame", "Maximum number of bytes of a frame of streamed remote read responses. A frame may exceed it by a single chunk.").
+		Default("1048576").IntVar(&rr.conf.MaxBytesInFrame)
+	return rr
+}
+
+type walQuotaConfig struct {
+	maxBytes      units.Base2Bytes
+	action        string
+	checkInterval time.Duration
+}
+
+func (wq *walQuotaConfig) registerFlag(cmd extkingpin.FlagClause) *walQuotaConfig {
+	cmd.Flag("tsdb.wal-quota.max-bytes",
+		"Maximum size of the WAL of a TSDB, per tenant for multi-tenant TSDBs. A unit is required, supported units: B, KB, MB, GB, TB, PB, EB. Ex: \"4GB\". 0 disables the quota.").
+		Default("0").BytesVar(&wq.maxBytes)
+	cmd.Flag("tsdb.wal-quota.action",
+		"Action taken on a TSDB whose WAL is above --tsdb.wal-quota.max-bytes. 'reject' compacts the head of the TSDB into a block, truncating its WAL, and rejects the writes to the TSDB until its WAL is below the quota again, 'drop-oldest' compacts the oldest half of its head into a block and drops it from the head and the WAL, without rejecting writes.").
+		Default(string(walquota.ActionReject)).EnumVar(&wq.action, string(walquota.ActionReject), string(walquota.ActionDropOldest))
+	cmd.Flag("tsdb.wal-quota.check-interval",
+		"Interval of the measurement of the WAL of TSDBs against --tsdb.wal-quota.max-bytes.").
+		Default("30s").DurationVar(&wq.checkInterval)
+	return wq
+}
+
+// quota returns the WAL disk quota, nil if disabled.
+func (wq *walQuotaConfig) quota(logger log.Logger, reg prometheus.Registerer) (*walquota.Quota, error) {
+	if wq.maxBytes == 0 {
+		return nil, nil
+	}
+	return walquota.New(log.With(logger, "component", "wal-quota"), reg, int64(wq.maxBytes), walquota.Action(wq.action))
+}
+
+// addChecker adds an actor running the check of the WAL disk quota periodically, if enabled.
+func (wq *walQuotaConfig) addChecker(g *run.Group, logger log.Logger, quota *walquota.Quota, check func() error) {
+	if quota == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	g.Add(func() error {
+		return runutil.Repeat(wq.checkInterval, ctx.Done(), func() error {
+			if err := check(); err != nil {
+				level.Warn(logger).Log("msg", "failed to check WAL disk quota", "err", err)
+			}
+			return nil
+		})
+	}, func(error) {
+		cancel()
+	})
+}
+
 type goMemLimitConfig struct {
 	enableAutoGoMemlimit bool
 	memlimitRatio        float64
@@ -24,10 +24,11 @@ import (
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
 
 	"github.com/thanos-io/objstore"
-	"github.com/thanos-io/objstore/client"
 	objstoretracing "github.com/thanos-io/objstore/tracing/opentracing"
 
 	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/attestation"
+	"github.com/thanos-io/thanos/pkg/block/encryption"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/compact"
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
@@ -35,6 +36,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/errutil"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	"github.com/thanos-io/thanos/pkg/logutil"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
@@ -44,6 +46,9 @@ type DownsampleMetrics struct {
 	downsamples        *prometheus.CounterVec
 	downsampleFailures *prometheus.CounterVec
 	downsampleDuration *prometheus.HistogramVec
+	downsamplePending  *prometheus.GaugeVec
+	readBytes          *prometheus.CounterVec
+	writtenBytes       *prometheus.CounterVec
 }
 
 func newDownsampleMetrics(reg *prometheus.Registry) *DownsampleMetrics {
@@ -62,6 +67,18 @@ func newDownsampleMetrics(reg *prometheus.Registry) *DownsampleMetrics {
 		Help:    "Duration of downsample runs",
 		Buckets: []float64{60, 300, 900, 1800, 3600, 7200, 14400}, // 1m, 5m, 15m, 30m, 60m, 120m, 240m
 	}, []string{"resolution"})
+	m.downsamplePending = promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thanos_compact_downsample_pending_blocks",
+		Help: "Number of blocks left to downsample by the current downsampling pass.",
+	}, []string{"resolution"})
+	m.readBytes = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_compact_downsample_read_bytes_total",
+		Help: "Total size of the blocks downsampled, by their resolution.",
+	}, []string{"resolution"})
+	m.writtenBytes = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_compact_downsample_written_bytes_total",
+		Help: "Total size of the downsampled blocks uploaded, by the resolution of the blocks they were downsampled from.",
+	}, []string{"resolution"})
 
 	return m
 }
@@ -78,26 +95,57 @@ func RunDownsample(
 	downsampleConcurrency int,
 	blockFilesConcurrency int,
 	objStoreConfig *extflag.PathOrContent,
+	encryptionConfig *extflag.PathOrContent,
+	attestationConfig *extflag.PathOrContent,
 	comp component.Component,
 	hashFunc metadata.HashFunc,
+	shardIndex, shardCount uint64,
 ) error {
+	shardFilter, err := block.NewGroupShardedMetaFilter(shardIndex, shardCount)
+	if err != nil {
+		return err
+	}
+
 	confContentYaml, err := objStoreConfig.Content()
 	if err != nil {
 		return err
 	}
 
-	bkt, err := client.NewBucket(logger, confContentYaml, component.Downsample.String(), nil)
+	bkt, err := objstoreutil.NewBucket(logger, confContentYaml, component.Downsample.String(), nil)
+	if err != nil {
+		return err
+	}
+	bkt = objstoreutil.WrapWithAccounting(bkt, reg)
+	encryptionConfContentYaml, err := encryptionConfig.Content()
+	if err != nil {
+		return err
+	}
+	bkt, err = encryption.WrapWithConfig(logger, bkt, encryptionConfContentYaml)
+	if err != nil {
+		return err
+	}
+	attestationConfContentYaml, err := attestationConfig.Content()
+	if err != nil {
+		return err
+	}
+	attestationConf, err := attestation.ParseConfig(attestationConfContentYaml)
 	if err != nil {
 		return err
 	}
+	bkt = attestation.WrapWithConfig(logger, bkt, attestationConf)
 	insBkt := objstoretracing.WrapWithTraces(objstore.WrapWithMetrics(bkt, extprom.WrapRegistererWithPrefix("thanos_", reg), bkt.Name()))
 
-	// While fetching blocks, filter out blocks that were marked for no downsample.
+	// While fetching blocks, filter out blocks of other shards and blocks that were marked for no downsample.
 	baseBlockIDsFetcher := block.NewConcurrentLister(logger, insBkt)
-	metaFetcher, err := block.NewMetaFetcher(logger, block.FetcherConcurrency, insBkt, baseBlockIDsFetcher, "", extprom.WrapRegistererWithPrefix("thanos_", reg), []block.MetadataFilter{
+	var filters []block.MetadataFilter
+	if f := attestation.NewFilter(logger, insBkt, attestationConf, block.FetcherConcurrency, reg); f != nil {
+		filters = append(filters, f)
+	}
+	metaFetcher, err := block.NewMetaFetcher(logger, block.FetcherConcurrency, insBkt, baseBlockIDsFetcher, "", extprom.WrapRegistererWithPrefix("thanos_", reg), append(filters,
+		shardFilter,
 		block.NewDeduplicateFilter(block.FetcherConcurrency),
 		downsample.NewGatherNoDownsampleMarkFilter(logger, insBkt, block.FetcherConcurrency),
-	})
+	))
 	if err != nil {
 		return errors.Wrap(err, "create meta fetcher")
 	}
@@ -116,6 +164,10 @@ func RunDownsample(
 	)
 
 	metrics := newDownsampleMetrics(reg)
+	lastSuccessfulRun := promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_downsample_last_successful_run_timestamp_seconds",
+		Help: "Timestamp of the last successful run of both downsampling passes.",
+	})
 	// Start cycle of syncing blocks from the bucket and garbage collecting the bucket.
 	{
 		ctx, cancel := context.WithCancel(context.Background())
@@ -148,6 +200,7 @@ func RunDownsample(
 				if err := downsampleBucket(ctx, logger, metrics, insBkt, metas, dataDir, downsampleConcurrency, blockFilesConcurrency, hashFunc, false); err != nil {
 					return errors.Wrap(err, "downsampling failed")
 				}
+				lastSuccessfulRun.SetToCurrentTime()
 				return nil
 			})
 		}, func(error) {
@@ -159,6 +212,7 @@ func RunDownsample(
 		httpserver.WithListen(httpBindAddr),
 		httpserver.WithGracePeriod(httpGracePeriod),
 		httpserver.WithTLSConfig(httpTLSConfig),
+		httpserver.WithLogLevels(logLevels),
 	)
 
 	g.Add(func() error {
@@ -172,10 +226,16 @@ func RunDownsample(
 		srv.Shutdown(err)
 	})
 
-	level.Info(logger).Log("msg", "starting downsample node")
+	level.Info(logger).Log("msg", "starting downsample node", "shard_index", shardIndex, "shard_count", shardCount)
 	return nil
 }
 
+// downsampleSource is a source block of the series of a shard.
+type downsampleSource struct {
+	id    ulid.ULID
+	shard metadata.Shard
+}
+
 func downsampleBucket(
 	ctx context.Context,
 	logger log.Logger,
@@ -204,9 +264,10 @@ func downsampleBucket(
 	}()
 
 	// mapping from a hash over all source IDs to blocks. We don't need to downsample a block
-	// if a downsampled version with the same hash already exists.
-	sources5m := map[ulid.ULID]struct{}{}
-	sources1h := map[ulid.ULID]struct{}{}
+	// if a downsampled version with the same hash already exists. Sources are per shard, as every
+	// shard of a block is downsampled separately.
+	sources5m := map[downsampleSource]struct{}{}
+	sources1h := map[downsampleSource]struct{}{}
 
 	for _, m := range metas {
 		switch m.Thanos.Downsample.Resolution {
@@ -214,11 +275,11 @@ func downsampleBucket(
 			continue
 		case downsample.ResLevel1:
 			for _, id := range m.Compaction.Sources {
-				sources5m[id] = struct{}{}
+				sources5m[downsampleSource{id: id, shard: m.Thanos.ShardOrAll()}] = struct{}{}
 			}
 		case downsample.ResLevel2:
 			for _, id := range m.Compaction.Sources {
-				sources1h[id] = struct{}{}
+				sources1h[downsampleSource{id: id, shard: m.Thanos.ShardOrAll()}] = struct{}{}
 			}
 		default:
 			return errors.Errorf("unexpected downsampling resolution %d", m.Thanos.Downsample.Resolution)
@@ -270,12 +331,13 @@ func downsampleBucket(
 
 				}
 				metrics.downsamples.WithLabelValues(m.Thanos.ResolutionString()).Inc()
+				metrics.downsamplePending.WithLabelValues(m.Thanos.ResolutionString()).Dec()
 			}
 		}()
 	}
 
-	// Workers scheduled, distribute blocks.
-metaSendLoop:
+	// Find the blocks missing a downsampled version.
+	var todo []*metadata.Meta
 	for _, mk := range metasULIDS {
 		m := metas[mk]
 
@@ -286,7 +348,7 @@ metaSendLoop:
 		case downsample.ResLevel0:
 			missing := false
 			for _, id := range m.Compaction.Sources {
-				if _, ok := sources5m[id]; !ok {
+				if _, ok := sources5m[downsampleSource{id: id, shard: m.Thanos.ShardOrAll()}]; !ok {
 					missing = true
 					break
 				}
@@ -304,7 +366,7 @@ metaSendLoop:
 		case downsample.ResLevel1:
 			missing := false
 			for _, id := range m.Compaction.Sources {
-				if _, ok := sources1h[id]; !ok {
+				if _, ok := sources1h[downsampleSource{id: id, shard: m.Thanos.ShardOrAll()}]; !ok {
 					missing = true
 					break
 				}
@@ -319,7 +381,17 @@ metaSendLoop:
 				continue
 			}
 		}
+		todo = append(todo, m)
+	}
+
+	metrics.downsamplePending.Reset()
+	for _, m := range todo {
+		metrics.downsamplePending.WithLabelValues(m.Thanos.ResolutionString()).Inc()
+	}
 
+	// Workers scheduled, distribute blocks.
+metaSendLoop:
+	for _, m := range todo {
 		select {
 		case <-workerCtx.Done():
 			downsampleErrs.Add(workerCtx.Err())
@@ -344,6 +416,16 @@ metaSendLoop:
 	return downsampleErrs.Err()
 }
 
+// countBlockBytes adds the size of the block in the directory to the counter.
+func countBlockBytes(logger log.Logger, c prometheus.Counter, dir string) {
+	size, err := block.DirSize(dir)
+	if err != nil {
+		level.Warn(logger).Log("msg", "failed to get the size of the block", "dir", dir, "err", err)
+		return
+	}
+	c.Add(float64(size))
+}
+
 func processDownsampling(
 	ctx context.Context,
 	logger log.Logger,
@@ -408,12 +490,7 @@ func processDownsampling(
 		return errors.Wrap(err, "read meta")
 	}
 
-	if stats.ChunkMaxSize > 0 {
-		meta.Thanos.IndexStats.ChunkMaxSize = stats.ChunkMaxSize
-	}
-	if stats.SeriesMaxSize > 0 {
-		meta.Thanos.IndexStats.SeriesMaxSize = stats.SeriesMaxSize
-	}
+	meta.Thanos.IndexStats = stats.IndexStats()
 	if err := meta.WriteToDir(logger, resdir); err != nil {
 		return errors.Wrap(err, "write meta")
 	}
@@ -426,6 +503,8 @@ func processDownsampling(
 	}
 
 	level.Info(logger).Log("msg", "uploaded block", "id", id, "duration", time.Since(begin), "duration_ms", time.Since(begin).Milliseconds())
+	countBlockBytes(logger, metrics.readBytes.WithLabelValues(m.Thanos.ResolutionString()), bdir)
+	countBlockBytes(logger, metrics.writtenBytes.WithLabelValues(m.Thanos.ResolutionString()), resdir)
 
 	// It is not harmful if these fails.
 	if err := os.RemoveAll(bdir); err != nil {
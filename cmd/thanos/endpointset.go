@@ -6,6 +6,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"sync"
 	"time"
 
@@ -17,9 +18,11 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/config"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/discovery"
 	"github.com/prometheus/prometheus/discovery/file"
+	promhttp "github.com/prometheus/prometheus/discovery/http"
 	"github.com/prometheus/prometheus/discovery/targetgroup"
 
 	"github.com/thanos-io/thanos/pkg/component"
@@ -110,6 +113,29 @@ func (er *endpointConfigProvider) addStaticEndpoints(cfg *EndpointConfig) {
 	}
 }
 
+const (
+	// httpSDGroupLabel is the label of the targets of the HTTP SD that are endpoint groups, when set to "true".
+	httpSDGroupLabel = "__thanos_endpoint_group"
+	// httpSDStrictLabel is the label of the targets of the HTTP SD that are strict endpoints, when set to "true".
+	httpSDStrictLabel = "__thanos_endpoint_strict"
+)
+
+// httpSDEndpoints returns the endpoint settings of the targets discovered through HTTP SD. Dynamically specified
+// endpoints are never strict.
+func httpSDEndpoints(tgs *cache.Cache) []endpointSettings {
+	targets := tgs.Targets()
+	res := make([]endpointSettings, 0, len(targets))
+	for _, t := range targets {
+		addr := string(t[model.AddressLabel])
+		res = append(res, endpointSettings{
+			Address: addr,
+			Group:   t[httpSDGroupLabel] == "true",
+			Strict:  t[httpSDStrictLabel] == "true" && !dns.IsDynamicNode(addr),
+		})
+	}
+	return res
+}
+
 func validateEndpointConfig(cfg EndpointConfig) error {
 	for _, ecfg := range cfg.Endpoints {
 		if dns.IsDynamicNode(ecfg.Address) && ecfg.Strict {
@@ -185,6 +211,8 @@ func setupEndpointSet(
 	configReloadInterval time.Duration,
 	legacyFileSDFiles []string,
 	legacyFileSDInterval time.Duration,
+	httpSDURLs []string,
+	httpSDInterval time.Duration,
 	legacyEndpoints []string,
 	legacyEndpointGroups []string,
 	legacyStrictEndpoints []string,
@@ -257,6 +285,34 @@ func setupEndpointSet(
 	}
 	legacyFileSDCache := cache.New()
 
+	httpSDs := make([]*promhttp.Discovery, 0, len(httpSDURLs))
+	for _, u := range httpSDURLs {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse http sd url %s", u)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return nil, errors.Newf("http sd url %s must use the http or https scheme", u)
+		}
+		conf := &promhttp.SDConfig{
+			HTTPClientConfig: config.DefaultHTTPClientConfig,
+			URL:              u,
+			RefreshInterval:  model.Duration(httpSDInterval),
+		}
+		httpSD, err := promhttp.NewDiscovery(conf, logutil.GoKitLogToSlog(logger), nil, conf.NewDiscovererMetrics(reg, discovery.NewRefreshMetrics(reg)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to create new http sd for %s", u)
+		}
+		httpSDs = append(httpSDs, httpSD)
+	}
+	httpSDCache := cache.New()
+
+	// endpoints returns the endpoints of the config and the ones discovered through HTTP SD.
+	endpoints := func() []endpointSettings {
+		endpointConfig := configProvider.config()
+		return append(endpointConfig.Endpoints, httpSDEndpoints(httpSDCache)...)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	if fileSD != nil {
@@ -288,16 +344,43 @@ func setupEndpointSet(
 		})
 	}
 
+	for _, httpSD := range httpSDs {
+		httpSDUpdates := make(chan []*targetgroup.Group)
+
+		g.Add(func() error {
+			httpSD.Run(ctx, httpSDUpdates)
+			return nil
+		}, func(err error) {
+			cancel()
+		})
+
+		g.Add(func() error {
+			for {
+				select {
+				case update := <-httpSDUpdates:
+					if update == nil {
+						continue
+					}
+					httpSDCache.Update(update)
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}, func(err error) {
+			cancel()
+		})
+	}
+
 	{
 		g.Add(func() error {
 			return runutil.Repeat(dnsSDInterval, ctx.Done(), func() error {
 				ctxUpdateIter, cancelUpdateIter := context.WithTimeout(ctx, dnsSDInterval)
 				defer cancelUpdateIter()
 
-				endpointConfig := configProvider.config()
+				settings := endpoints()
 
-				addresses := make([]string, 0, len(endpointConfig.Endpoints))
-				for _, ecfg := range endpointConfig.Endpoints {
+				addresses := make([]string, 0, len(settings))
+				for _, ecfg := range settings {
 					if addr := ecfg.Address; dns.IsDynamicNode(addr) && !ecfg.Group {
 						addresses = append(addresses, addr)
 					}
@@ -314,11 +397,9 @@ func setupEndpointSet(
 	}
 
 	endpointset := query.NewEndpointSet(time.Now, logger, reg, func() []*query.GRPCEndpointSpec {
-		endpointConfig := configProvider.config()
-
 		specs := make([]*query.GRPCEndpointSpec, 0)
 		// groups and non dynamic endpoints
-		for _, ecfg := range endpointConfig.Endpoints {
+		for _, ecfg := range endpoints() {
 			strict, group, addr := ecfg.Strict, ecfg.Group, ecfg.Address
 			if group {
 				specs = append(specs, query.NewGRPCEndpointSpec(fmt.Sprintf("thanos:///%s", addr), strict, append(dialOpts, extgrpc.EndpointGroupGRPCOpts()...)...))
@@ -0,0 +1,45 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+
+	"github.com/thanos-io/thanos/pkg/discovery/cache"
+)
+
+func Test_httpSDEndpoints(t *testing.T) {
+	t.Parallel()
+
+	tgs := cache.New()
+	tgs.Update([]*targetgroup.Group{
+		{
+			Source: "http://sd:0",
+			Targets: []model.LabelSet{
+				{model.AddressLabel: "store-0:10901"},
+				{model.AddressLabel: "store-1:10901", httpSDStrictLabel: "true"},
+				{model.AddressLabel: "dns+store-2:10901", httpSDStrictLabel: "true"},
+			},
+		},
+		{
+			Source:  "http://sd:1",
+			Targets: []model.LabelSet{{model.AddressLabel: "receive:10901"}},
+			Labels:  model.LabelSet{httpSDGroupLabel: "true"},
+		},
+	})
+
+	got := httpSDEndpoints(tgs)
+	sort.Slice(got, func(i, j int) bool { return got[i].Address < got[j].Address })
+	testutil.Equals(t, []endpointSettings{
+		{Address: "dns+store-2:10901"},
+		{Address: "receive:10901", Group: true},
+		{Address: "store-0:10901"},
+		{Address: "store-1:10901", Strict: true},
+	}, got)
+}
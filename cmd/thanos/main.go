@@ -24,6 +24,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	versioncollector "github.com/prometheus/client_golang/prometheus/collectors/version"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/version"
 	"go.uber.org/automaxprocs/maxprocs"
 
@@ -32,6 +33,10 @@ import (
 	"github.com/thanos-io/thanos/pkg/tracing/client"
 )
 
+// logLevels are the log levels of the command, which can be changed at runtime on the /-/log-level endpoint of
+// its HTTP server.
+var logLevels *logging.Levels
+
 func main() {
 	// We use mmaped resources in most of the components so hardcode PanicOnFault to true. This allows us to recover (if we can e.g if queries
 	// are temporarily accessing unmapped memory).
@@ -48,7 +53,11 @@ func main() {
 		Default("info").Enum("error", "warn", "info", "debug")
 	logFormat := app.Flag("log.format", "Log format to use. Possible options: logfmt or json.").
 		Default(logging.LogFormatLogfmt).Enum(logging.LogFormatLogfmt, logging.LogFormatJSON)
+	componentLogLevels := app.Flag("log.component-level", "Log filtering level of the lines of a component, as component=level, overriding --log.level. The component is the value of the component field of log lines. Levels can be changed at runtime on the /-/log-level HTTP endpoint. Repeatable.").
+		PlaceHolder("<component>=<level>").Strings()
 	tracingConfig := extkingpin.RegisterCommonTracingFlags(app)
+	nameValidationScheme := app.Flag("name-validation-scheme", "Validation scheme of metric and label names, e.g. in external labels, relabel configs, PromQL queries and APIs. 'utf8' accepts the UTF-8 names of Prometheus 3, which have to be quoted in PromQL and in label flags, e.g. --label='\"service.name\"=\"api\"'. 'legacy' only accepts names matching [a-zA-Z_:][a-zA-Z0-9_:]*.").
+		Default("utf8").Enum("utf8", "legacy")
 
 	goMemLimitConf := goMemLimitConfig{}
 
@@ -64,7 +73,19 @@ func main() {
 	registerQueryFrontend(app)
 
 	cmd, setup := app.Parse()
-	logger := logging.NewLogger(*logLevel, *logFormat, *debugName)
+	if *nameValidationScheme == "legacy" {
+		model.NameValidationScheme = model.LegacyValidation
+	}
+	levels, err := logging.NewLevels(*logLevel)
+	if err == nil {
+		err = levels.ParseComponentLevels(*componentLogLevels)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, errors.Wrap(err, "parse log levels"))
+		os.Exit(1)
+	}
+	logLevels = levels
+	logger := logging.NewLoggerWithLevels(levels, *logFormat, *debugName)
 
 	if err := configureGoAutoMemLimit(goMemLimitConf); err != nil {
 		level.Error(logger).Log("msg", "failed to configure Go runtime memory limits", "err", err)
@@ -217,6 +217,9 @@ func TestCleanupDownsampleCacheFolder(t *testing.T) {
 	testutil.Ok(t, err)
 	testutil.Ok(t, downsampleBucket(ctx, logger, metrics, bkt, metas, dir, 1, 1, metadata.NoneFunc, false))
 	testutil.Equals(t, 1.0, promtest.ToFloat64(metrics.downsamples.WithLabelValues(meta.Thanos.ResolutionString())))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(metrics.downsamplePending.WithLabelValues(meta.Thanos.ResolutionString())))
+	testutil.Assert(t, promtest.ToFloat64(metrics.readBytes.WithLabelValues(meta.Thanos.ResolutionString())) > 0)
+	testutil.Assert(t, promtest.ToFloat64(metrics.writtenBytes.WithLabelValues(meta.Thanos.ResolutionString())) > 0)
 
 	_, err = os.Stat(dir)
 	testutil.Assert(t, os.IsNotExist(err), "index cache dir should not exist at the end of execution")
@@ -6,6 +6,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"regexp"
 	"strings"
 	"time"
 
@@ -22,8 +23,10 @@ import (
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/promql/parser"
 
+	cardinalityAPI "github.com/thanos-io/thanos/pkg/api/cardinality"
 	apiv1 "github.com/thanos-io/thanos/pkg/api/query"
 	"github.com/thanos-io/thanos/pkg/api/query/querypb"
+	queryexportAPI "github.com/thanos-io/thanos/pkg/api/queryexport"
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/component"
@@ -39,11 +42,13 @@ import (
 	"github.com/thanos-io/thanos/pkg/logging"
 	"github.com/thanos-io/thanos/pkg/logutil"
 	"github.com/thanos-io/thanos/pkg/metadata"
+	"github.com/thanos-io/thanos/pkg/metering"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/query"
 	"github.com/thanos-io/thanos/pkg/rules"
 	grpcserver "github.com/thanos-io/thanos/pkg/server/grpc"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 	"github.com/thanos-io/thanos/pkg/store"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/strutil"
@@ -66,6 +71,18 @@ func registerQuery(app *extkingpin.App) {
 	cmd := app.Command(comp.String(), "Query node exposing PromQL enabled Query API with data retrieved from multiple store nodes.")
 
 	httpBindAddr, httpGracePeriod, httpTLSConfig := extkingpin.RegisterHTTPFlags(cmd)
+	httpRBACConfig := extkingpin.RegisterHTTPRBACFlags(cmd)
+
+	var meteringConf meteringConfig
+	meteringConf.registerFlag(cmd)
+
+	var queryExportConf queryExportConfig
+	queryExportConf.registerFlag(cmd)
+
+	var queryRemoteReadConf queryRemoteReadConfig
+	queryRemoteReadConf.registerFlag(cmd)
+
+	cardinalityEndpoints := cmd.Flag("cardinality.endpoint", "Base URL of the HTTP server of a Store Gateway or Receiver, e.g. http://store:10902, to fan out cardinality API requests to (repeatable).").PlaceHolder("<url>").Strings()
 
 	var grpcServerConfig grpcConfig
 	grpcServerConfig.registerFlag(cmd)
@@ -84,6 +101,8 @@ func registerQuery(app *extkingpin.App) {
 	defaultEngine := cmd.Flag("query.promql-engine", "Default PromQL engine to use.").Default(string(apiv1.PromqlEnginePrometheus)).
 		Enum(string(apiv1.PromqlEnginePrometheus), string(apiv1.PromqlEngineThanos))
 	disableQueryFallback := cmd.Flag("query.disable-fallback", "If set then thanos engine will throw an error if query falls back to prometheus engine").Hidden().Default("false").Bool()
+	promqlEngineShadowRatio := cmd.Flag("query.promql-engine-shadow-ratio", "Ratio of the queries also executed in the background by the other PromQL engine, to compare the results of both engines. The mismatches are logged and counted in the thanos_query_promql_engine_shadow_queries_total metric. 0 disables the shadow execution.").
+		Default("0").Float64()
 
 	extendedFunctionsEnabled := cmd.Flag("query.enable-x-functions", "Whether to enable extended rate functions (xrate, xincrease and xdelta). Only has effect when used with Thanos engine.").Default("false").Bool()
 	promqlQueryMode := cmd.Flag("query.mode", "PromQL query mode. One of: local, distributed.").
@@ -125,6 +144,12 @@ func registerQuery(app *extkingpin.App) {
 	enableAutodownsampling := cmd.Flag("query.auto-downsampling", "Enable automatic adjustment (step / 5) to what source of data should be used in store gateways if no max_source_resolution param is specified.").
 		Default("false").Bool()
 
+	enableDownsampleOnRead := cmd.Flag("query.downsample-on-read", "Experimental. Downsample to a 5m resolution the raw data returned for queries allowing downsampled data, if older than the downsampling delay of 40h, e.g. if the compactor falls behind on downsampling. Such queries get a warning.").
+		Default("false").Bool()
+
+	rawDataQueryRegex := cmd.Flag("query.raw-data-query-regex", "Regular expression matching the queries always evaluated on raw data only, regardless of the max_source_resolution and auto_downsampling params and of auto downsampling, for example the queries of SLO recording rules. Empty disables it.").
+		Default("").String()
+
 	enableQueryPartialResponse := cmd.Flag("query.partial-response", "Enable partial response for queries if no partial_response param is specified. --no-query.partial-response for disabling.").
 		Default("true").Bool()
 
@@ -134,6 +159,9 @@ func registerQuery(app *extkingpin.App) {
 	enableTargetPartialResponse := cmd.Flag("target.partial-response", "Enable partial response for targets endpoint. --no-target.partial-response for disabling.").
 		Hidden().Default("true").Bool()
 
+	targetsCacheTTL := cmd.Flag("target.cache-ttl", "Duration for which the responses of the targets and scrape configs endpoints are cached, so that refreshing them does not fan out the requests to every Prometheus each time. 0 disables caching.").
+		Default("0s").Duration()
+
 	enableMetricMetadataPartialResponse := cmd.Flag("metric-metadata.partial-response", "Enable partial response for metric metadata endpoint. --no-metric-metadata.partial-response for disabling.").
 		Hidden().Default("true").Bool()
 
@@ -193,6 +221,12 @@ func registerQuery(app *extkingpin.App) {
 	legacyFileSDInterval := extkingpin.ModelDuration(cmd.Flag("store.sd-interval", "(Deprecated) Refresh interval to re-read file SD files. It is used as a resync fallback.").
 		Default("5m"))
 
+	httpSDURLs := cmd.Flag("endpoint.sd-http-url", "URL of an HTTP SD API serving the Thanos API servers to query, in the format of the Prometheus HTTP SD (repeatable). Targets labeled with __thanos_endpoint_group=\"true\" are queried as endpoint groups, and the ones labeled with __thanos_endpoint_strict=\"true\" as strict endpoints.").
+		PlaceHolder("<url>").Strings()
+
+	httpSDInterval := extkingpin.ModelDuration(cmd.Flag("endpoint.sd-http-interval", "Interval between the requests to the HTTP SD APIs.").
+		Default("1m"))
+
 	endpoints := extkingpin.Addrs(cmd.Flag("endpoint", "(Deprecated): Addresses of statically configured Thanos API servers (repeatable). The scheme may be prefixed with 'dns+' or 'dnssrv+' to detect Thanos API servers through respective DNS lookups.").PlaceHolder("<endpoint>"))
 
 	endpointGroups := extkingpin.Addrs(cmd.Flag("endpoint-group", "(Deprecated, Experimental): DNS name of statically configured Thanos API server groups (repeatable). Targets resolved from the DNS name will be queried in a round-robin, instead of a fanout manner. This flag should be used when connecting a Thanos Query to HA groups of Thanos components.").PlaceHolder("<endpoint-group>"))
@@ -205,6 +239,20 @@ func registerQuery(app *extkingpin.App) {
 	lazyRetrievalMaxBufferedResponses := cmd.Flag("query.lazy-retrieval-max-buffered-responses", "The lazy retrieval strategy can buffer up to this number of responses. This is to limit the memory usage. This flag takes effect only when the lazy retrieval strategy is enabled.").
 		Default("20").Hidden().Int()
 
+	lazyRetrievalMaxBufferedBytes := cmd.Flag("query.lazy-retrieval-max-buffered-bytes", "The lazy retrieval strategy can buffer up to this size of responses for each store, on top of the number of responses. Reading from a store is paused while its buffer is full, so that fast stores do not fill the memory while slow ones are awaited. 0 disables the limit. This flag takes effect only when the lazy retrieval strategy is enabled.").
+		Default("0").Hidden().Bytes()
+
+	hedgedSeriesQuantile := cmd.Flag("query.hedged-series-requests.quantile", "Quantile of the recent first response latencies of an endpoint group after which its Series requests are sent again, being balanced to another replica, and the first response of either is used. 0 disables hedging.").
+		Default("0").Float64()
+	hedgedSeriesMinDelay := cmd.Flag("query.hedged-series-requests.min-delay", "Minimum delay after which the Series requests to an endpoint group are hedged.").
+		Default("100ms").Duration()
+
+	seriesFlowControlWindow := cmd.Flag("store.series-flow-control-window", "Size of the Series responses a store may send to the querier before the querier reads them. Series are then streamed through the SeriesStream API of the stores, which falls back to the Store API for stores without it. This bounds the memory used by fast stores while slow ones are awaited. The gRPC authorization policies of the stores have to allow the /thanos.SeriesStream/Series method. 0 disables the flow control.").
+		Default("0").Bytes()
+
+	seriesBatchSize := cmd.Flag("store.series-batch-size", "Maximum size of the series a store batches into a single Series frame, through the SeriesStream API of the stores like --store.series-flow-control-window. This reduces the number of frames of queries selecting many small series. 0 disables the batching.").
+		Default("0").Bytes()
+
 	var storeRateLimits store.SeriesSelectLimits
 	storeRateLimits.RegisterFlags(cmd)
 
@@ -214,6 +262,15 @@ func registerQuery(app *extkingpin.App) {
 			return errors.Wrap(err, "parse federation labels")
 		}
 
+		var maxSourceResolutionPolicy apiv1.MaxSourceResolutionPolicy
+		if *rawDataQueryRegex != "" {
+			re, err := regexp.Compile(*rawDataQueryRegex)
+			if err != nil {
+				return errors.Wrap(err, "parse raw data query regex")
+			}
+			maxSourceResolutionPolicy = apiv1.RawDataQueryPolicy(re)
+		}
+
 		for _, feature := range *featureList {
 			if feature == promqlExperimentalFunctions {
 				parser.EnableExperimentalFunctions = true
@@ -276,6 +333,8 @@ func registerQuery(app *extkingpin.App) {
 			time.Duration(*endpointSetConfigReloadInterval),
 			*legacyFileSDFiles,
 			time.Duration(*legacyFileSDInterval),
+			*httpSDURLs,
+			time.Duration(*httpSDInterval),
 			*endpoints,
 			*endpointGroups,
 			*strictEndpoints,
@@ -290,6 +349,16 @@ func registerQuery(app *extkingpin.App) {
 		if err != nil {
 			return err
 		}
+		endpointSet.SetSeriesFlowControl(int(*seriesFlowControlWindow), int(*seriesBatchSize))
+
+		rbac, err := newRBAC(reg, httpRBACConfig, *tenantHeader, *defaultTenant, *tenantCertField)
+		if err != nil {
+			return err
+		}
+		meter, err := meteringConf.meter(reg, comp)
+		if err != nil {
+			return err
+		}
 
 		return runQuery(
 			g,
@@ -304,6 +373,11 @@ func registerQuery(app *extkingpin.App) {
 			grpcServerConfig,
 			*httpBindAddr,
 			*httpTLSConfig,
+			rbac,
+			meter,
+			&queryExportConf,
+			&queryRemoteReadConf,
+			*cardinalityEndpoints,
 			time.Duration(*httpGracePeriod),
 			*webRoutePrefix,
 			*webExternalPrefix,
@@ -322,6 +396,8 @@ func registerQuery(app *extkingpin.App) {
 			selectorLset,
 			getFlagsMap(cmd.Flags()),
 			*enableAutodownsampling,
+			*enableDownsampleOnRead,
+			maxSourceResolutionPolicy,
 			*enableQueryPartialResponse,
 			*enableRulePartialResponse,
 			*enableTargetPartialResponse,
@@ -342,6 +418,7 @@ func registerQuery(app *extkingpin.App) {
 			apiv1.PromqlEngineType(*defaultEngine),
 			apiv1.PromqlQueryMode(*promqlQueryMode),
 			*disableQueryFallback,
+			*promqlEngineShadowRatio,
 			*tenantHeader,
 			*defaultTenant,
 			*tenantCertField,
@@ -349,6 +426,10 @@ func registerQuery(app *extkingpin.App) {
 			*tenantLabel,
 			*queryDistributedWithOverlappingInterval,
 			*lazyRetrievalMaxBufferedResponses,
+			int(*lazyRetrievalMaxBufferedBytes),
+			*hedgedSeriesQuantile,
+			*hedgedSeriesMinDelay,
+			*targetsCacheTTL,
 		)
 	})
 }
@@ -368,6 +449,11 @@ func runQuery(
 	grpcServerConfig grpcConfig,
 	httpBindAddr string,
 	httpTLSConfig string,
+	rbac *middleware.RBAC,
+	meter *metering.Meter,
+	queryExportConf *queryExportConfig,
+	queryRemoteReadConf *queryRemoteReadConfig,
+	cardinalityEndpoints []string,
 	httpGracePeriod time.Duration,
 	webRoutePrefix string,
 	webExternalPrefix string,
@@ -386,6 +472,8 @@ func runQuery(
 	selectorLset labels.Labels,
 	flagsMap map[string]string,
 	enableAutodownsampling bool,
+	enableDownsampleOnRead bool,
+	maxSourceResolutionPolicy apiv1.MaxSourceResolutionPolicy,
 	enableQueryPartialResponse bool,
 	enableRulePartialResponse bool,
 	enableTargetPartialResponse bool,
@@ -406,6 +494,7 @@ func runQuery(
 	defaultEngine apiv1.PromqlEngineType,
 	queryMode apiv1.PromqlQueryMode,
 	disableQueryFallback bool,
+	promqlEngineShadowRatio float64,
 	tenantHeader string,
 	defaultTenant string,
 	tenantCertField string,
@@ -413,6 +502,10 @@ func runQuery(
 	tenantLabel string,
 	queryDistributedWithOverlappingInterval bool,
 	lazyRetrievalMaxBufferedResponses int,
+	lazyRetrievalMaxBufferedBytes int,
+	hedgedSeriesQuantile float64,
+	hedgedSeriesMinDelay time.Duration,
+	targetsCacheTTL time.Duration,
 ) error {
 	comp := component.Query
 	if alertQueryURL == "" {
@@ -427,6 +520,14 @@ func runQuery(
 		store.WithTSDBSelector(tsdbSelector),
 		store.WithProxyStoreDebugLogging(debugLogging),
 		store.WithLazyRetrievalMaxBufferedResponsesForProxy(lazyRetrievalMaxBufferedResponses),
+		store.WithLazyRetrievalMaxBufferedBytesForProxy(lazyRetrievalMaxBufferedBytes),
+	}
+	if hedgedSeriesQuantile > 0 {
+		options = append(options, store.WithSeriesHedging(store.NewSeriesHedger(reg, hedgedSeriesQuantile, hedgedSeriesMinDelay, func(st store.Client) bool {
+			// Only the requests to endpoint groups are balanced to other replicas.
+			addr, _ := st.Addr()
+			return strings.HasPrefix(addr, "thanos:///")
+		})))
 	}
 
 	// Parse and sanitize the provided replica labels flags.
@@ -446,6 +547,7 @@ func runQuery(
 			maxConcurrentSelects,
 			queryTimeout,
 			deduplicationFunc,
+			enableDownsampleOnRead,
 		)
 		remoteEndpointsCreator = query.NewRemoteEndpointsCreator(
 			logger,
@@ -482,6 +584,7 @@ func runQuery(
 		activeQueryTracker,
 		queryMode,
 		disableQueryFallback,
+		promqlEngineShadowRatio,
 	)
 
 	lookbackDeltaCreator := LookbackDeltaFactory(lookbackDelta, dynamicLookbackDelta)
@@ -511,6 +614,21 @@ func runQuery(
 		// TODO(bplotka in PR #513 review): pass all flags, not only the flags needed by prefix rewriting.
 		ui.NewQueryUI(logger, endpointSet, webExternalPrefix, webPrefixHeaderName, alertQueryURL, tenantHeader, defaultTenant, enforceTenancy).Register(router, ins)
 
+		var statsAggregatorFactory store.SeriesQueryPerformanceMetricsAggregatorFactory = store.NewSeriesStatsAggregatorFactory(
+			reg,
+			queryTelemetryDurationQuantiles,
+			queryTelemetrySamplesQuantiles,
+			queryTelemetrySeriesQuantiles,
+		)
+		if meter != nil {
+			statsAggregatorFactory = store.NewMeteringSeriesStatsAggregatorFactory(statsAggregatorFactory, meter)
+		}
+
+		var targetsClient targets.UnaryClient = targets.NewGRPCClientWithDedup(targetsProxy, queryReplicaLabels)
+		if targetsCacheTTL > 0 {
+			targetsClient = targets.NewCachedClient(targetsClient, targetsCacheTTL)
+		}
+
 		api := apiv1.NewQueryAPI(
 			logger,
 			endpointSet.GetEndpointStatus,
@@ -521,7 +639,7 @@ func runQuery(
 			remoteEndpointsCreator,
 			// NOTE: Will share the same replica label as the query for now.
 			rules.NewGRPCClientWithDedup(rulesProxy, queryReplicaLabels),
-			targets.NewGRPCClientWithDedup(targetsProxy, queryReplicaLabels),
+			targetsClient,
 			metadata.NewGRPCClient(metadataProxy),
 			exemplars.NewGRPCClientWithDedup(exemplarsProxy, queryReplicaLabels),
 			enableAutodownsampling,
@@ -541,12 +659,7 @@ func runQuery(
 				maxConcurrentQueries,
 				gate.Queries,
 			),
-			store.NewSeriesStatsAggregatorFactory(
-				reg,
-				queryTelemetryDurationQuantiles,
-				queryTelemetrySamplesQuantiles,
-				queryTelemetrySeriesQuantiles,
-			),
+			statsAggregatorFactory,
 			reg,
 			tenantHeader,
 			defaultTenant,
@@ -555,12 +668,31 @@ func runQuery(
 			tenantLabel,
 		)
 
+		api.SetMaxSourceResolutionPolicy(maxSourceResolutionPolicy)
+		if queryRemoteReadConf.enabled {
+			api.EnableRemoteRead(reg, queryRemoteReadConf.conf)
+		}
 		api.Register(router.WithPrefix("/api/v1"), tracer, logger, ins, logMiddleware)
 
+		exports, err := queryExportConf.manager(g, logger, reg, api.RangeQuery)
+		if err != nil {
+			return err
+		}
+		if exports != nil {
+			queryexportAPI.NewQueryExportAPI(logger, exports, disableCORS, tenantHeader, defaultTenant, tenantCertField, enforceTenancy, tenantLabel).Register(router, tracer, logger, ins, logMiddleware)
+		}
+
+		if len(cardinalityEndpoints) > 0 {
+			stats := cardinalityAPI.NewRemoteStatsFunc(logger, &http.Client{Timeout: 5 * time.Minute}, cardinalityEndpoints, tenantHeader)
+			cardinalityAPI.NewCardinalityAPI(stats, tenantHeader, defaultTenant, tenantCertField).Register(router, tracer, logger, ins, logMiddleware)
+		}
+
 		srv := httpserver.New(logger, reg, comp, httpProbe,
 			httpserver.WithListen(httpBindAddr),
 			httpserver.WithGracePeriod(httpGracePeriod),
 			httpserver.WithTLSConfig(httpTLSConfig),
+			httpserver.WithLogLevels(logLevels),
+			httpserver.WithRBAC(rbac),
 		)
 		srv.Handle("/", router)
 
@@ -581,6 +713,10 @@ func runQuery(
 		if err != nil {
 			return errors.Wrap(err, "setup gRPC server")
 		}
+		authorizer, err := grpcServerConfig.authorizer(reg)
+		if err != nil {
+			return errors.Wrap(err, "setup gRPC server")
+		}
 
 		infoSrv := info.NewInfoServer(
 			component.Query.String(),
@@ -619,6 +755,7 @@ func runQuery(
 			grpcserver.WithGracePeriod(grpcServerConfig.gracePeriod),
 			grpcserver.WithMaxConnAge(grpcServerConfig.maxConnectionAge),
 			grpcserver.WithTLSConfig(tlsCfg),
+			grpcserver.WithAuthorizer(authorizer),
 		)
 
 		g.Add(func() error {
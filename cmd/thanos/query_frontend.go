@@ -169,6 +169,21 @@ func registerQueryFrontend(app *extkingpin.App) {
 
 	cmd.Flag("query-frontend.slow-query-logs-user-header", "Set the value of the field remote_user in the slow query logs to the value of the given HTTP header. Falls back to reading the user from the basic auth header.").PlaceHolder("<http-header-name>").Default("").StringVar(&cfg.CortexHandlerConfig.SlowQueryLogsUserHeader)
 
+	cmd.Flag("query-frontend.instant-subquery-split-min-range", "Split the instant queries of an *_over_time function over a subquery of at least this range, with a step and without @ modifier, e.g. max_over_time(rate(x[5m])[30d:1m]), into the range query of the subquery, split and cached like the other range queries, and the function applied to its result. 0 disables it.").
+		Default("0").DurationVar(&cfg.InstantSubquerySplitMinRange)
+
+	cmd.Flag("query-frontend.retry-budget-ratio", "Maximum ratio of retried to received requests, for each class of downstream error, on top of a burst of 10 retries. It prevents retries from multiplying the load of the downstream queriers during incidents. 0 disables the budget.").
+		Default("0.1").Float64Var(&cfg.RetryBudgetRatio)
+
+	cmd.Flag("query-frontend.queue.max-concurrent", "Maximum number of requests sent to the downstream queriers at the same time. Requests over the limit are queued, by priority class and fairly between tenants. 0 disables the queue.").
+		Default("0").IntVar(&cfg.QueueConfig.MaxConcurrent)
+
+	cmd.Flag("query-frontend.queue.max-outstanding-per-tenant", "Maximum number of queued downstream requests of a tenant, beyond which its requests are rejected with 429. 0 for no limit.").
+		Default("0").IntVar(&cfg.QueueConfig.MaxOutstandingPerTenant)
+
+	cmd.Flag("query-frontend.queue.priority-header", "HTTP header the priority class of the queries is read from, either 'interactive' or 'batch'. The queued requests of interactive queries are sent before the ones of batch queries. Queries without the header are interactive.").
+		Default(queryfrontend.DefaultQueryPriorityHeader).StringVar(&cfg.QueueConfig.PriorityHeader)
+
 	reqLogConfig := extkingpin.RegisterRequestLoggingFlags(cmd)
 
 	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ <-chan struct{}, _ bool) error {
@@ -334,8 +349,14 @@ func runQueryFrontend(
 		return errors.Wrap(err, "setup downstream roundtripper")
 	}
 
+	// Queue the downstream requests, except the readiness checks.
+	queuedRT := downstreamRT
+	if cfg.QueueConfig.MaxConcurrent > 0 {
+		queuedRT = queryfrontend.NewQueueRoundTripper(cfg.QueueConfig, reg, downstreamRT)
+	}
+
 	// Wrap the downstream RoundTripper into query frontend Tripperware.
-	roundTripper := tripperWare(downstreamRT)
+	roundTripper := tripperWare(queuedRT)
 
 	// Create the query frontend transport.
 	handler := transport.NewHandler(*cfg.CortexHandlerConfig, roundTripper, logger, nil)
@@ -359,11 +380,14 @@ func runQueryFrontend(
 			httpserver.WithListen(cfg.http.bindAddress),
 			httpserver.WithGracePeriod(time.Duration(cfg.http.gracePeriod)),
 			httpserver.WithTLSConfig(cfg.http.tlsConfig),
+			httpserver.WithLogLevels(logLevels),
 		)
 
 		instr := func(f http.HandlerFunc) http.HandlerFunc {
 			hf := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				orgId := extractOrgId(cfg, r)
+				ctx := user.InjectOrgID(r.Context(), orgId)
+				ctx = queryfrontend.ContextWithQueryPriority(ctx, queryfrontend.ParseQueryPriority(r.Header.Get(cfg.QueueConfig.PriorityHeader)))
 				name := "query-frontend"
 				if !cfg.webDisableCORS {
 					api.SetCORS(w)
@@ -379,7 +403,7 @@ func runQueryFrontend(
 						),
 						// Cortex frontend middlewares require orgID.
 					),
-				).ServeHTTP(w, r.WithContext(user.InjectOrgID(r.Context(), orgId)))
+				).ServeHTTP(w, r.WithContext(ctx))
 			})
 			return hf
 		}
@@ -28,26 +28,30 @@ import (
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/prometheus/prometheus/tsdb/wlog"
 	"github.com/thanos-io/objstore"
-	"github.com/thanos-io/objstore/client"
 	objstoretracing "github.com/thanos-io/objstore/tracing/opentracing"
 	"google.golang.org/grpc"
 	"gopkg.in/yaml.v2"
 
+	"github.com/thanos-io/thanos/pkg/block/attestation"
+	"github.com/thanos-io/thanos/pkg/block/encryption"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/exemplars"
 	"github.com/thanos-io/thanos/pkg/extgrpc"
 	"github.com/thanos-io/thanos/pkg/extgrpc/snappy"
+	"github.com/thanos-io/thanos/pkg/extgrpc/zstd"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	"github.com/thanos-io/thanos/pkg/info"
 	"github.com/thanos-io/thanos/pkg/info/infopb"
 	"github.com/thanos-io/thanos/pkg/logging"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/receive"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	grpcserver "github.com/thanos-io/thanos/pkg/server/grpc"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
+	"github.com/thanos-io/thanos/pkg/shipper"
 	"github.com/thanos-io/thanos/pkg/store"
 	storecache "github.com/thanos-io/thanos/pkg/store/cache"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
@@ -78,6 +82,9 @@ func registerReceive(app *extkingpin.App) {
 		if lset.Len() == 0 {
 			return errors.New("no external labels configured for receive, uniquely identifying external labels must be configured (ideally with `receive_` prefix); see https://thanos.io/tip/thanos/storage.md#external-labels for details.")
 		}
+		if conf.writeQuorum > max(conf.replicationFactor, 1) {
+			return errors.Errorf("--receive.write-quorum (%d) must not be greater than --receive.replication-factor (%d)", conf.writeQuorum, conf.replicationFactor)
+		}
 
 		grpcLogOpts, logFilterMethods, err := logging.ParsegRPCOptions(conf.reqLogConfig)
 
@@ -144,6 +151,17 @@ func runReceive(
 	multiTSDBOptions := []receive.MultiTSDBOption{
 		receive.WithHeadExpandedPostingsCacheSize(conf.headExpandedPostingsCacheSize),
 		receive.WithBlockExpandedPostingsCacheSize(conf.compactedBlocksExpandedPostingsCacheSize),
+		receive.WithShipperOptions(
+			shipper.WithUploadCompletedMark(conf.shipperUploadCompletedMark),
+			shipper.WithUploadJitter(conf.shipperUploadJitter),
+			shipper.WithCluster(conf.shipperCluster),
+		),
+	}
+	if conf.shipperUploadExemplars && conf.tsdbMaxExemplars > 0 {
+		multiTSDBOptions = append(multiTSDBOptions, receive.WithShippedExemplars())
+	}
+	if d := time.Duration(*conf.tsdbFastRecoveryMaxDataLoss); d > 0 {
+		multiTSDBOptions = append(multiTSDBOptions, receive.WithFastRecovery(d))
 	}
 	for _, feature := range *conf.featureList {
 		if feature == metricNamesFilter {
@@ -188,7 +206,21 @@ func runReceive(
 	// Has this thanos receive instance been configured to ingest metrics into a local TSDB?
 	enableIngestion := receiveMode == receive.IngestorOnly || receiveMode == receive.RouterIngestor
 
+	bucketRoutingContentYaml, err := conf.bucketRoutingConf.Content()
+	if err != nil {
+		return errors.Wrap(err, "get content of bucket routing configuration")
+	}
+	var bucketRoutingConfs []receive.BucketRoutingConfig
+	if len(bucketRoutingContentYaml) > 0 {
+		if bucketRoutingConfs, err = receive.ParseBucketRoutingConfig(bucketRoutingContentYaml); err != nil {
+			return err
+		}
+	}
+
 	upload := len(confContentYaml) > 0
+	if len(bucketRoutingConfs) > 0 && !upload {
+		return errors.New("bucket routing requires --objstore.config")
+	}
 	if enableIngestion {
 		if upload {
 			if tsdbOpts.MinBlockDuration != tsdbOpts.MaxBlockDuration {
@@ -198,13 +230,56 @@ func runReceive(
 				}
 				level.Warn(logger).Log("msg", "flag to ignore min/max block duration flags differing is being used. If the upload of a 2h block fails and a tsdb compaction happens that block may be missing from your Thanos bucket storage.")
 			}
+			encryptionConfContentYaml, err := conf.objStoreEncryption.Content()
+			if err != nil {
+				return err
+			}
+			attestationConfContentYaml, err := conf.blockAttestation.Content()
+			if err != nil {
+				return err
+			}
+			attestationConf, err := attestation.ParseConfig(attestationConfContentYaml)
+			if err != nil {
+				return err
+			}
+			newBucket := func(confContentYaml []byte, reg prometheus.Registerer) (objstore.Bucket, error) {
+				bkt, err := objstoreutil.NewBucket(logger, confContentYaml, comp.String(), nil)
+				if err != nil {
+					return nil, err
+				}
+				bkt = objstoreutil.WrapWithAccounting(bkt, reg)
+				bkt, err = encryption.WrapWithConfig(logger, bkt, encryptionConfContentYaml)
+				if err != nil {
+					return nil, err
+				}
+				bkt = attestation.WrapWithConfig(logger, bkt, attestationConf)
+				return objstoretracing.WrapWithTraces(objstore.WrapWithMetrics(bkt, extprom.WrapRegistererWithPrefix("thanos_", reg), bkt.Name())), nil
+			}
 			// The background shipper continuously scans the data directory and uploads
 			// new blocks to object storage service.
-			bkt, err = client.NewBucket(logger, confContentYaml, comp.String(), nil)
+			bkt, err = newBucket(confContentYaml, reg)
 			if err != nil {
 				return err
 			}
-			bkt = objstoretracing.WrapWithTraces(objstore.WrapWithMetrics(bkt, extprom.WrapRegistererWithPrefix("thanos_", reg), bkt.Name()))
+
+			routes := make([]receive.BucketRoute, 0, len(bucketRoutingConfs))
+			for _, rc := range bucketRoutingConfs {
+				routeBkt := bkt
+				if rc.Bucket.Type != "" {
+					routeConfContentYaml, err := yaml.Marshal(rc.Bucket)
+					if err != nil {
+						return errors.Wrapf(err, "marshal the bucket configuration of routing domain %q", rc.Name)
+					}
+					routeBkt, err = newBucket(routeConfContentYaml, prometheus.WrapRegistererWith(prometheus.Labels{"routing_domain": rc.Name}, reg))
+					if err != nil {
+						return errors.Wrapf(err, "create the bucket of routing domain %q", rc.Name)
+					}
+				}
+				routes = append(routes, receive.NewBucketRoute(rc, routeBkt))
+			}
+			if len(routes) > 0 {
+				multiTSDBOptions = append(multiTSDBOptions, receive.WithBucketRoutes(routes...))
+			}
 		} else {
 			level.Info(logger).Log("msg", "no supported bucket was configured, uploads will be disabled")
 		}
@@ -233,6 +308,14 @@ func runReceive(
 		multiTSDBOptions = append(multiTSDBOptions, receive.WithMatchersCache(cache))
 	}
 
+	walQuota, err := conf.walQuota.quota(logger, reg)
+	if err != nil {
+		return errors.Wrap(err, "create WAL disk quota")
+	}
+	if walQuota != nil {
+		multiTSDBOptions = append(multiTSDBOptions, receive.WithWALQuota(walQuota))
+	}
+
 	dbs := receive.NewMultiTSDB(
 		conf.dataDir,
 		logger,
@@ -267,6 +350,30 @@ func runReceive(
 		return errors.Wrap(err, "creating limiter")
 	}
 
+	rbac, err := newRBAC(reg, conf.httpRBAC, conf.tenantHeader, conf.defaultTenantID, conf.tenantField)
+	if err != nil {
+		return err
+	}
+
+	meter, err := conf.metering.meter(reg, comp)
+	if err != nil {
+		return err
+	}
+	if meter != nil && conf.metering.recordInterval > 0 {
+		// Routers do not upload blocks, so they have no bucket for the usage records yet.
+		recordsBkt := bkt
+		if recordsBkt == nil {
+			if !upload {
+				return errors.New("--metering.record-interval requires an object storage configuration")
+			}
+			recordsBkt, err = objstoreutil.NewBucket(logger, confContentYaml, comp.String(), nil)
+			if err != nil {
+				return err
+			}
+		}
+		conf.metering.addRecordWriter(g, logger, meter, recordsBkt)
+	}
+
 	webHandler := receive.NewHandler(log.With(logger, "component", "receive-handler"), &receive.Options{
 		Writer:               writer,
 		ListenAddress:        conf.rwAddress,
@@ -277,10 +384,13 @@ func runReceive(
 		DefaultTenantID:      conf.defaultTenantID,
 		ReplicaHeader:        conf.replicaHeader,
 		ReplicationFactor:    conf.replicationFactor,
+		WriteQuorum:          conf.writeQuorum,
 		RelabelConfigs:       relabelConfig,
 		ReceiverMode:         receiveMode,
 		Tracer:               tracer,
 		TLSConfig:            rwTLSConfig,
+		RBAC:                 rbac,
+		Meter:                meter,
 		SplitTenantLabelName: conf.splitTenantLabelName,
 		DialOpts:             dialOpts,
 		ForwardTimeout:       time.Duration(*conf.forwardTimeout),
@@ -292,6 +402,19 @@ func runReceive(
 		ReplicationProtocol:     receive.ReplicationProtocol(conf.replicationProtocol),
 		OtlpEnableTargetInfo:    conf.otlpEnableTargetInfo,
 		OtlpResourceAttributes:  conf.otlpResourceAttributes,
+
+		ReplicationRepair: receive.ReplicationRepairOptions{
+			QueueSize:   conf.replicationRepairQueueSize,
+			Workers:     conf.replicationRepairWorkers,
+			MaxAttempts: conf.replicationRepairMaxAttempts,
+			Timeout:     time.Duration(*conf.forwardTimeout),
+			MaxBackoff:  time.Duration(*conf.maxBackoff),
+		},
+		OtlpDeltaToCumulative: receive.OTLPDeltaToCumulativeOptions{
+			Enabled:    conf.otlpDeltaToCumulative,
+			MaxStale:   time.Duration(*conf.otlpDeltaMaxStale),
+			MaxStreams: conf.otlpDeltaMaxStreams,
+		},
 	})
 
 	grpcProbe := prober.NewGRPC()
@@ -335,6 +458,8 @@ func runReceive(
 			httpserver.WithListen(*conf.httpBindAddr),
 			httpserver.WithGracePeriod(time.Duration(*conf.httpGracePeriod)),
 			httpserver.WithTLSConfig(*conf.httpTLSConfig),
+			httpserver.WithLogLevels(logLevels),
+			httpserver.WithRBAC(rbac),
 		)
 		g.Add(func() error {
 			statusProber.Healthy()
@@ -353,6 +478,10 @@ func runReceive(
 		if err != nil {
 			return errors.Wrap(err, "setup gRPC server")
 		}
+		authorizer, err := conf.grpcConfig.authorizer(reg)
+		if err != nil {
+			return errors.Wrap(err, "setup gRPC server")
+		}
 
 		if conf.lazyRetrievalMaxBufferedResponses <= 0 {
 			return errors.New("--receive.lazy-retrieval-max-buffered-responses must be > 0")
@@ -402,12 +531,14 @@ func runReceive(
 		srv := grpcserver.New(logger, receive.NewUnRegisterer(reg), tracer, grpcLogOpts, logFilterMethods, comp, grpcProbe,
 			grpcserver.WithServer(store.RegisterStoreServer(rw, logger)),
 			grpcserver.WithServer(store.RegisterWritableStoreServer(rw)),
+			grpcserver.WithServer(store.RegisterWritableStoreV2Server(webHandler.WriteableStoreV2())),
 			grpcserver.WithServer(exemplars.RegisterExemplarsServer(exemplars.NewMultiTSDB(dbs.TSDBExemplars))),
 			grpcserver.WithServer(info.RegisterInfoServer(infoSrv)),
 			grpcserver.WithListen(conf.grpcConfig.bindAddress),
 			grpcserver.WithGracePeriod(conf.grpcConfig.gracePeriod),
 			grpcserver.WithMaxConnAge(conf.grpcConfig.maxConnectionAge),
 			grpcserver.WithTLSConfig(tlsCfg),
+			grpcserver.WithAuthorizer(authorizer),
 		)
 
 		g.Add(
@@ -475,6 +606,9 @@ func runReceive(
 		})
 	}
 
+	level.Debug(logger).Log("msg", "setting up periodic WAL disk quota check")
+	conf.walQuota.addChecker(g, logger, walQuota, dbs.CheckWALQuota)
+
 	{
 		if limiter.CanReload() {
 			ctx, cancel := context.WithCancel(context.Background())
@@ -825,8 +959,11 @@ type receiveConfig struct {
 	httpBindAddr    *string
 	httpGracePeriod *model.Duration
 	httpTLSConfig   *string
+	httpRBAC        *extflag.PathOrContent
 
 	grpcConfig grpcConfig
+	metering   meteringConfig
+	walQuota   walQuotaConfig
 
 	replicationAddr       string
 	rwAddress             string
@@ -844,8 +981,11 @@ type receiveConfig struct {
 	dataDir   string
 	labelStrs []string
 
-	objStoreConfig *extflag.PathOrContent
-	retention      *model.Duration
+	objStoreConfig     *extflag.PathOrContent
+	objStoreEncryption *extflag.PathOrContent
+	blockAttestation   *extflag.PathOrContent
+	bucketRoutingConf  *extflag.PathOrContent
+	retention          *model.Duration
 
 	hashringsFilePath    string
 	hashringsFileContent string
@@ -859,6 +999,7 @@ type receiveConfig struct {
 	defaultTenantID     string
 	replicaHeader       string
 	replicationFactor   uint64
+	writeQuorum         uint64
 	forwardTimeout      *model.Duration
 	maxBackoff          *model.Duration
 	compression         string
@@ -876,6 +1017,12 @@ type receiveConfig struct {
 	tsdbWriteQueueSize           int64
 	tsdbMemorySnapshotOnShutdown bool
 	tsdbEnableNativeHistograms   bool
+	tsdbFastRecoveryMaxDataLoss  *model.Duration
+
+	shipperUploadCompletedMark bool
+	shipperUploadJitter        time.Duration
+	shipperUploadExemplars     bool
+	shipperCluster             string
 
 	walCompression       bool
 	noLockFile           bool
@@ -897,6 +1044,10 @@ type receiveConfig struct {
 
 	asyncForwardWorkerCount uint
 
+	replicationRepairQueueSize   int
+	replicationRepairWorkers     int
+	replicationRepairMaxAttempts int
+
 	matcherCacheSize int
 
 	lazyRetrievalMaxBufferedResponses int
@@ -907,10 +1058,15 @@ type receiveConfig struct {
 	compactedBlocksExpandedPostingsCacheSize uint64
 	otlpEnableTargetInfo                     bool
 	otlpResourceAttributes                   []string
+	otlpDeltaToCumulative                    bool
+	otlpDeltaMaxStale                        *model.Duration
+	otlpDeltaMaxStreams                      int
 }
 
 func (rc *receiveConfig) registerFlag(cmd extkingpin.FlagClause) {
 	rc.httpBindAddr, rc.httpGracePeriod, rc.httpTLSConfig = extkingpin.RegisterHTTPFlags(cmd)
+	rc.httpRBAC = extkingpin.RegisterHTTPRBACFlags(cmd)
+	rc.metering = *rc.metering.registerFlag(cmd).registerRecordFlag(cmd)
 	rc.grpcConfig.registerFlag(cmd)
 	rc.storeRateLimits.RegisterFlags(cmd)
 
@@ -943,6 +1099,8 @@ func (rc *receiveConfig) registerFlag(cmd extkingpin.FlagClause) {
 	cmd.Flag("label", "External labels to announce. This flag will be removed in the future when handling multiple tsdb instances is added.").PlaceHolder("key=\"value\"").StringsVar(&rc.labelStrs)
 
 	rc.objStoreConfig = extkingpin.RegisterCommonObjStoreFlags(cmd, "", false)
+	rc.objStoreEncryption = extkingpin.RegisterObjStoreEncryptionFlags(cmd)
+	rc.blockAttestation = extkingpin.RegisterBlockAttestationFlags(cmd)
 
 	rc.retention = extkingpin.ModelDuration(cmd.Flag("tsdb.retention", "How long to retain raw samples on local storage. 0d - disables the retention policy (i.e. infinite retention). For more details on how retention is enforced for individual tenants, please refer to the Tenant lifecycle management section in the Receive documentation: https://thanos.io/tip/components/receive.md/#tenant-lifecycle-management").Default("15d"))
 
@@ -973,12 +1131,20 @@ func (rc *receiveConfig) registerFlag(cmd extkingpin.FlagClause) {
 	cmd.Flag("receive.replica-header", "HTTP header specifying the replica number of a write request.").Default(receive.DefaultReplicaHeader).StringVar(&rc.replicaHeader)
 
 	cmd.Flag("receive.forward.async-workers", "Number of concurrent workers processing forwarding of remote-write requests.").Default("5").UintVar(&rc.asyncForwardWorkerCount)
-	compressionOptions := strings.Join([]string{snappy.Name, compressionNone}, ", ")
-	cmd.Flag("receive.grpc-compression", "Compression algorithm to use for gRPC requests to other receivers. Must be one of: "+compressionOptions).Default(snappy.Name).EnumVar(&rc.compression, snappy.Name, compressionNone)
+	compressionOptions := strings.Join([]string{snappy.Name, zstd.Name, compressionNone}, ", ")
+	cmd.Flag("receive.grpc-compression", "Compression algorithm to use for gRPC requests to other receivers. Must be one of: "+compressionOptions).Default(snappy.Name).EnumVar(&rc.compression, snappy.Name, zstd.Name, compressionNone)
 
 	cmd.Flag("receive.replication-factor", "How many times to replicate incoming write requests.").Default("1").Uint64Var(&rc.replicationFactor)
 
-	replicationProtocols := []string{string(receive.ProtobufReplication), string(receive.CapNProtoReplication)}
+	cmd.Flag("receive.write-quorum", "[EXPERIMENTAL] How many replicas have to acknowledge a write request before it is considered successful. 0 means a majority of the replication factor (or 1 with replication factor 2). Lower values trade durability for latency, consider enabling replication repair.").Default("0").Uint64Var(&rc.writeQuorum)
+
+	cmd.Flag("receive.replication-repair.queue-size", "[EXPERIMENTAL] Size of the queue of replica writes that failed after the write quorum was reached and are retried in the background. 0 disables replication repair.").Default("0").IntVar(&rc.replicationRepairQueueSize)
+
+	cmd.Flag("receive.replication-repair.workers", "[EXPERIMENTAL] Number of concurrent workers repairing failed replica writes.").Default("1").IntVar(&rc.replicationRepairWorkers)
+
+	cmd.Flag("receive.replication-repair.max-attempts", "[EXPERIMENTAL] Maximum number of attempts to repair a single failed replica write before dropping it.").Default("5").IntVar(&rc.replicationRepairMaxAttempts)
+
+	replicationProtocols := []string{string(receive.ProtobufReplication), string(receive.ProtobufV2Replication), string(receive.CapNProtoReplication)}
 	cmd.Flag("receive.replication-protocol", "The protocol to use for replicating remote-write requests. One of "+strings.Join(replicationProtocols, ", ")).
 		Default(string(receive.ProtobufReplication)).
 		EnumVar(&rc.replicationProtocol, replicationProtocols...)
@@ -1007,6 +1173,11 @@ func (rc *receiveConfig) registerFlag(cmd extkingpin.FlagClause) {
 			"Please note if you enable this option and you use compactor, make sure you have the --compact.enable-vertical-compaction flag enabled, otherwise you might risk compactor halt.",
 	).Default("0s"))
 
+	rc.tsdbFastRecoveryMaxDataLoss = extkingpin.ModelDuration(cmd.Flag("tsdb.fast-recovery.max-data-loss",
+		"[EXPERIMENTAL] If non-zero, the WAL of a tenant is not replayed on start if its newest block on disk ends at most this duration ago, "+
+			"losing the samples past that block instead of replaying huge WALs for hours. Use it only with replicated hashrings, where the other replicas hold these samples.",
+	).Default("0s"))
+
 	cmd.Flag("tsdb.out-of-order.cap-max",
 		"[EXPERIMENTAL] Configures the maximum capacity for out-of-order chunks (in samples). If set to <=0, default value 32 is assumed.",
 	).Default("0").Int64Var(&rc.tsdbOutOfOrderCapMax)
@@ -1041,6 +1212,8 @@ func (rc *receiveConfig) registerFlag(cmd extkingpin.FlagClause) {
 		"[EXPERIMENTAL] Enables the ingestion of native histograms.").
 		Default("false").BoolVar(&rc.tsdbEnableNativeHistograms)
 
+	rc.walQuota.registerFlag(cmd)
+
 	cmd.Flag("writer.intern",
 		"[EXPERIMENTAL] Enables string interning in receive writer, for more optimized memory usage.").
 		Default("false").Hidden().BoolVar(&rc.writerInterning)
@@ -1050,6 +1223,22 @@ func (rc *receiveConfig) registerFlag(cmd extkingpin.FlagClause) {
 
 	cmd.Flag("shipper.ignore-unequal-block-size", "If true receive will not require min and max block size flags to be set to the same value. Only use this if you want to keep long retention and compaction enabled, as in the worst case it can result in ~2h data loss for your Thanos bucket storage.").Default("false").Hidden().BoolVar(&rc.ignoreBlockSize)
 
+	cmd.Flag("shipper.upload-completed-mark", "If true receive uploads an upload-completed mark into each block after all its files were uploaded and verified, so that compactors with --upload-completed-consistency-delay process the block before their consistency delay.").
+		Default("false").BoolVar(&rc.shipperUploadCompletedMark)
+
+	cmd.Flag("shipper.upload-exemplars", "If true receive persists the exemplars of each tenant, within the time range of each uploaded block, into an exemplars file of the block, so that they outlive the exemplar storage. Requires --tsdb.max-exemplars.").
+		Default("false").BoolVar(&rc.shipperUploadExemplars)
+
+	cmd.Flag("shipper.upload-jitter", "Maximum random delay before the upload of each block, so that receivers cutting their blocks at the same time do not upload them all at once. 0 disables the jitter.").
+		Default("0s").DurationVar(&rc.shipperUploadJitter)
+
+	cmd.Flag("shipper.cluster", "Name of the cluster receive runs in, recorded with the component and its version in the provenance of the uploaded blocks. The blocks compacted from them keep the provenance of all their sources.").
+		Default("").StringVar(&rc.shipperCluster)
+
+	rc.bucketRoutingConf = extflag.RegisterPathOrContent(cmd, "shipper.bucket-routing-config",
+		"YAML file with the list of routing domains, each with a name, tenants and the object storage configuration or prefix the blocks of its tenants are uploaded to instead of the bucket of --objstore.config, e.g. for data residency. The routing domain is recorded in the meta of the blocks. See format details: https://thanos.io/tip/components/receive.md/#bucket-routing",
+		extflag.WithEnvSubstitution())
+
 	cmd.Flag("shipper.allow-out-of-order-uploads",
 		"If true, shipper will skip failed block uploads in the given iteration and retry later. This means that some newer blocks might be uploaded sooner than older blocks."+
 			"This can trigger compaction without those blocks and as a result will create an overlap situation. Set it to true if you have vertical compaction enabled and wish to upload blocks as soon as possible without caring"+
@@ -1073,6 +1262,16 @@ func (rc *receiveConfig) registerFlag(cmd extkingpin.FlagClause) {
 	cmd.Flag("receive.otlp-enable-target-info", "Enables target information in OTLP metrics ingested by Receive. If enabled, it converts the resource to the target info metric").Default("true").BoolVar(&rc.otlpEnableTargetInfo)
 	cmd.Flag("receive.otlp-promote-resource-attributes", "(Repeatable) Resource attributes to include in OTLP metrics ingested by Receive.").Default("").StringsVar(&rc.otlpResourceAttributes)
 
+	cmd.Flag("receive.otlp-delta-to-cumulative", "[EXPERIMENTAL] Translates the OTLP sums and histograms with delta temporality to cumulative ones, accumulating the data points of every stream, instead of dropping them. "+
+		"The accumulated state is local to the receiver, so the data points of a stream have to be sent to the same receiver. Exponential histograms are not translated.").
+		Default("false").BoolVar(&rc.otlpDeltaToCumulative)
+
+	rc.otlpDeltaMaxStale = extkingpin.ModelDuration(cmd.Flag("receive.otlp-delta-to-cumulative.max-stale", "[EXPERIMENTAL] Duration after which a delta stream not written to is evicted. Its accumulation starts anew from its next data point.").
+		Default("5m"))
+
+	cmd.Flag("receive.otlp-delta-to-cumulative.max-streams", "[EXPERIMENTAL] Maximum number of delta streams accumulated. The data points of new streams are dropped above it. 0 means no limit.").
+		Default("0").IntVar(&rc.otlpDeltaMaxStreams)
+
 	rc.featureList = cmd.Flag("enable-feature", "Comma separated experimental feature names to enable. The current list of features is "+metricNamesFilter+".").Default("").Strings()
 
 	cmd.Flag("receive.lazy-retrieval-max-buffered-responses", "The lazy retrieval strategy can buffer up to this number of responses. This is to limit the memory usage. This flag takes effect only when the lazy retrieval strategy is enabled.").
@@ -51,6 +51,8 @@ import (
 
 	"github.com/thanos-io/thanos/pkg/alert"
 	v1 "github.com/thanos-io/thanos/pkg/api/rule"
+	"github.com/thanos-io/thanos/pkg/block/attestation"
+	"github.com/thanos-io/thanos/pkg/block/encryption"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/clientconfig"
 	"github.com/thanos-io/thanos/pkg/component"
@@ -66,6 +68,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/info/infopb"
 	"github.com/thanos-io/thanos/pkg/logging"
 	"github.com/thanos-io/thanos/pkg/logutil"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/promclient"
 	"github.com/thanos-io/thanos/pkg/query"
@@ -77,16 +80,19 @@ import (
 	"github.com/thanos-io/thanos/pkg/store"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/tenancy"
 	"github.com/thanos-io/thanos/pkg/tls"
 	"github.com/thanos-io/thanos/pkg/tracing"
 	"github.com/thanos-io/thanos/pkg/ui"
 )
 
 type ruleConfig struct {
-	http    httpConfig
-	grpc    grpcConfig
-	web     webConfig
-	shipper shipperConfig
+	http     httpConfig
+	grpc     grpcConfig
+	web      webConfig
+	shipper  shipperConfig
+	walQuota walQuotaConfig
+	rbac     *extflag.PathOrContent
 
 	query              queryConfig
 	queryConfigYAML    []byte
@@ -97,7 +103,11 @@ type ruleConfig struct {
 	alertQueryURL          *url.URL
 	alertRelabelConfigYAML []byte
 
-	rwConfig *extflag.PathOrContent
+	rwConfig       *extflag.PathOrContent
+	rwTenantLabel  string
+	rwTenantHeader string
+
+	groupShard thanosrules.GroupShard
 
 	resendDelay        time.Duration
 	evalInterval       time.Duration
@@ -106,6 +116,8 @@ type ruleConfig struct {
 	forGracePeriod     time.Duration
 	ruleFiles          []string
 	objStoreConfig     *extflag.PathOrContent
+	objStoreEncryption *extflag.PathOrContent
+	blockAttestation   *extflag.PathOrContent
 	dataDir            string
 	lset               labels.Labels
 	ignoredLabelNames  []string
@@ -126,6 +138,7 @@ func (rc *ruleConfig) registerFlag(cmd extkingpin.FlagClause) {
 	rc.grpc.registerFlag(cmd)
 	rc.web.registerFlag(cmd)
 	rc.shipper.registerFlag(cmd)
+	rc.rbac = extkingpin.RegisterHTTPRBACFlags(cmd)
 	rc.query.registerFlag(cmd)
 	rc.alertmgr.registerFlag(cmd)
 	rc.storeRateLimits.RegisterFlags(cmd)
@@ -174,10 +187,23 @@ func registerRule(app *extkingpin.App) {
 	cmd.Flag("tsdb.enable-native-histograms",
 		"[EXPERIMENTAL] Enables the ingestion of native histograms.").
 		Default("false").BoolVar(&conf.tsdbEnableNativeHistograms)
+	conf.walQuota.registerFlag(cmd)
 
 	conf.rwConfig = extflag.RegisterPathOrContent(cmd, "remote-write.config", "YAML config for the remote-write configurations, that specify servers where samples should be sent to (see https://prometheus.io/docs/prometheus/latest/configuration/configuration/#remote_write). This automatically enables stateless mode for ruler and no series will be stored in the ruler's TSDB. If an empty config (or file) is provided, the flag is ignored and ruler is run with its own TSDB.", extflag.WithEnvSubstitution())
 
+	cmd.Flag("remote-write.tenant-label", "[EXPERIMENTAL] Label name identifying the tenant of evaluation results in stateless mode. Results of rules with this label, set on the rule or its rule group, are remote written with the tenant in the header configured by --remote-write.tenant-header. Other results are remote written without a tenant header.").
+		Default("").StringVar(&conf.rwTenantLabel)
+	cmd.Flag("remote-write.tenant-header", "[EXPERIMENTAL] HTTP header used to send the tenant of evaluation results in stateless mode.").
+		Default(tenancy.DefaultTenantHeader).StringVar(&conf.rwTenantHeader)
+
+	cmd.Flag("rule.shard-count", "[EXPERIMENTAL] Number of shards rule groups are split into across ruler replicas. Each replica only evaluates the rule groups whose name hashes to its --rule.shard-index. Zero or one disables sharding.").
+		Default("0").Uint64Var(&conf.groupShard.Count)
+	cmd.Flag("rule.shard-index", "[EXPERIMENTAL] Index of the rule group shard evaluated by this replica, in the range [0, --rule.shard-count).").
+		Default("0").Uint64Var(&conf.groupShard.Index)
+
 	conf.objStoreConfig = extkingpin.RegisterCommonObjStoreFlags(cmd, "", false)
+	conf.objStoreEncryption = extkingpin.RegisterObjStoreEncryptionFlags(cmd)
+	conf.blockAttestation = extkingpin.RegisterBlockAttestationFlags(cmd)
 
 	reqLogConfig := extkingpin.RegisterRequestLoggingFlags(cmd)
 
@@ -193,6 +219,10 @@ func registerRule(app *extkingpin.App) {
 			return errors.Wrap(err, "parse alert query url")
 		}
 
+		if err := conf.groupShard.Validate(); err != nil {
+			return errors.Wrap(err, "invalid rule group sharding")
+		}
+
 		tsdbOpts := &tsdb.Options{
 			MinBlockDuration:       int64(time.Duration(*tsdbBlockDuration) / time.Millisecond),
 			MaxBlockDuration:       int64(time.Duration(*tsdbBlockDuration) / time.Millisecond),
@@ -440,6 +470,8 @@ func runRule(
 			1*time.Minute,
 			nil,
 			1*time.Minute,
+			nil,
+			1*time.Minute,
 			grpcEndpoints,
 			nil,
 			nil,
@@ -460,6 +492,8 @@ func runRule(
 		queryable  storage.Queryable
 		tsdbDB     *tsdb.DB
 		agentDB    *agent.DB
+
+		updateRemoteWriteTenants func(tenants []string) error
 	)
 
 	rwCfgYAML, err := conf.rwConfig.Content()
@@ -480,12 +514,15 @@ func runRule(
 		remoteStore := remote.NewStorage(slogger, reg, func() (int64, error) {
 			return 0, nil
 		}, conf.dataDir, 1*time.Minute, &readyScrapeManager{})
-		if err := remoteStore.ApplyConfig(&config.Config{
-			GlobalConfig: config.GlobalConfig{
-				ExternalLabels: labelsTSDBToProm(conf.lset),
-			},
-			RemoteWriteConfigs: rwCfg.RemoteWriteConfigs,
-		}); err != nil {
+		updateRemoteWriteTenants = func(tenants []string) error {
+			return remoteStore.ApplyConfig(&config.Config{
+				GlobalConfig: config.GlobalConfig{
+					ExternalLabels: labelsTSDBToProm(conf.lset),
+				},
+				RemoteWriteConfigs: thanosrules.TenantRemoteWriteConfigs(rwCfg.RemoteWriteConfigs, conf.rwTenantLabel, conf.rwTenantHeader, tenants),
+			})
+		}
+		if err := updateRemoteWriteTenants(nil); err != nil {
 			return errors.Wrap(err, "applying config to remote storage")
 		}
 
@@ -500,6 +537,13 @@ func runRule(
 		// query. However, remote read is not implemented in Thanos Receiver.
 		queryable = thanosrules.NewPromClientsQueryable(logger, queryClients, promClients, conf.query.httpMethod, conf.query.step, conf.ignoredLabelNames)
 	} else {
+		if conf.rwTenantLabel != "" {
+			return errors.New("--remote-write.tenant-label requires stateless mode enabled by --remote-write.config")
+		}
+		walQuota, err := conf.walQuota.quota(logger, reg)
+		if err != nil {
+			return errors.Wrap(err, "create WAL disk quota")
+		}
 		tsdbDB, err = tsdb.Open(conf.dataDir, logutil.GoKitLogToSlog(log.With(logger, "component", "tsdb")), reg, tsdbOpts, nil)
 		if err != nil {
 			return errors.Wrap(err, "open TSDB")
@@ -519,8 +563,10 @@ func runRule(
 				close(done)
 			})
 		}
-		appendable = tsdbDB
+		// The ruler has a single TSDB, so its WAL disk quota has no tenant.
+		appendable = walQuota.Appendable("", tsdbDB)
 		queryable = tsdbDB
+		conf.walQuota.addChecker(g, logger, walQuota, func() error { return walQuota.Check("", tsdbDB) })
 	}
 
 	// Build the Alertmanager clients.
@@ -577,7 +623,9 @@ func runRule(
 		// Discover and resolve Alertmanager addresses.
 		addDiscoveryGroups(g, amClient, conf.alertmgr.alertmgrsDNSSDInterval, logger)
 
-		alertmgrs = append(alertmgrs, alert.NewAlertmanager(logger, amClient, time.Duration(cfg.Timeout), cfg.APIVersion))
+		alertmgrs = append(alertmgrs, alert.NewAlertmanager(logger, amClient, time.Duration(cfg.Timeout), cfg.APIVersion,
+			alert.WithRetries(cfg.MaxRetries, time.Duration(cfg.MinBackoff), time.Duration(cfg.MaxBackoff)),
+		))
 	}
 
 	var (
@@ -657,6 +705,7 @@ func runRule(
 			// --web.external-url points to it i.e. it points at something where the user
 			// could execute the alert or recording rule's expression and get results.
 			conf.alertQueryURL.String(),
+			thanosrules.WithGroupSharding(conf.groupShard),
 		)
 
 		// Schedule rule manager that evaluates rules.
@@ -672,7 +721,16 @@ func runRule(
 	}
 	// Run the alert sender.
 	{
-		sdr := alert.NewSender(logger, reg, alertmgrs)
+		var senderOpts []alert.SenderOption
+		if conf.alertmgr.alertHALeaseTTL > 0 {
+			lease, err := newAlertLease(g, logger, reg, &conf)
+			if err != nil {
+				return errors.Wrap(err, "create alerting lease")
+			}
+			senderOpts = append(senderOpts, alert.WithLeader(lease))
+		}
+
+		sdr := alert.NewSender(logger, reg, alertmgrs, senderOpts...)
 		ctx, cancel := context.WithCancel(context.Background())
 		ctx = tracing.ContextWithTracer(ctx, tracer)
 
@@ -696,21 +754,36 @@ func runRule(
 	// Handle reload and termination interrupts.
 	reloadWebhandler := make(chan chan error)
 	{
+		reload := func() error {
+			err := reloadRules(logger, conf.ruleFiles, ruleMgr, conf.evalInterval, metrics)
+			if updateRemoteWriteTenants == nil || conf.rwTenantLabel == "" {
+				return err
+			}
+			// Tenants are derived from the loaded rules, so remote write queues have to follow rule reloads.
+			if terr := updateRemoteWriteTenants(ruleMgr.Tenants(conf.rwTenantLabel)); terr != nil {
+				if err == nil {
+					return errors.Wrap(terr, "applying tenant remote write configs")
+				}
+				level.Error(logger).Log("msg", "applying tenant remote write configs failed", "err", terr)
+			}
+			return err
+		}
+
 		ctx, cancel := context.WithCancel(context.Background())
 		g.Add(func() error {
 			// Initialize rules.
-			if err := reloadRules(logger, conf.ruleFiles, ruleMgr, conf.evalInterval, metrics); err != nil {
+			if err := reload(); err != nil {
 				level.Error(logger).Log("msg", "initialize rules failed", "err", err)
 				return err
 			}
 			for {
 				select {
 				case <-reloadSignal:
-					if err := reloadRules(logger, conf.ruleFiles, ruleMgr, conf.evalInterval, metrics); err != nil {
+					if err := reload(); err != nil {
 						level.Error(logger).Log("msg", "reload rules by sighup failed", "err", err)
 					}
 				case reloadMsg := <-reloadWebhandler:
-					err := reloadRules(logger, conf.ruleFiles, ruleMgr, conf.evalInterval, metrics)
+					err := reload()
 					if err != nil {
 						level.Error(logger).Log("msg", "reload rules by webhandler failed", "err", err)
 					}
@@ -737,6 +810,10 @@ func runRule(
 	if err != nil {
 		return errors.Wrap(err, "setup gRPC server")
 	}
+	authorizer, err := conf.grpc.authorizer(reg)
+	if err != nil {
+		return errors.Wrap(err, "setup gRPC server")
+	}
 
 	options := []grpcserver.Option{
 		grpcserver.WithServer(thanosrules.RegisterRulesServer(ruleMgr)),
@@ -744,6 +821,7 @@ func runRule(
 		grpcserver.WithGracePeriod(conf.grpc.gracePeriod),
 		grpcserver.WithGracePeriod(conf.grpc.maxConnectionAge),
 		grpcserver.WithTLSConfig(tlsCfg),
+		grpcserver.WithAuthorizer(authorizer),
 	}
 	infoOptions := []info.ServerOptionFunc{info.WithRulesInfoFunc()}
 	if tsdbDB != nil {
@@ -818,10 +896,16 @@ func runRule(
 		api := v1.NewRuleAPI(logger, reg, thanosrules.NewGRPCClient(ruleMgr), ruleMgr, conf.web.disableCORS, flagsMap)
 		api.Register(router.WithPrefix("/api/v1"), tracer, logger, ins, logMiddleware)
 
+		rbac, err := newRBAC(reg, conf.rbac, tenancy.DefaultTenantHeader, tenancy.DefaultTenant, "")
+		if err != nil {
+			return err
+		}
 		srv := httpserver.New(logger, reg, comp, httpProbe,
 			httpserver.WithListen(conf.http.bindAddress),
 			httpserver.WithGracePeriod(time.Duration(conf.http.gracePeriod)),
 			httpserver.WithTLSConfig(conf.http.tlsConfig),
+			httpserver.WithLogLevels(logLevels),
+			httpserver.WithRBAC(rbac),
 		)
 		srv.Handle("/", router)
 
@@ -845,10 +929,28 @@ func runRule(
 	if len(confContentYaml) > 0 {
 		// The background shipper continuously scans the data directory and uploads
 		// new blocks to Google Cloud Storage or an S3-compatible storage service.
-		bkt, err := client.NewBucket(logger, confContentYaml, component.Rule.String(), nil)
+		bkt, err := objstoreutil.NewBucket(logger, confContentYaml, component.Rule.String(), nil)
 		if err != nil {
 			return err
 		}
+		bkt = objstoreutil.WrapWithAccounting(bkt, reg)
+		encryptionConfContentYaml, err := conf.objStoreEncryption.Content()
+		if err != nil {
+			return err
+		}
+		bkt, err = encryption.WrapWithConfig(logger, bkt, encryptionConfContentYaml)
+		if err != nil {
+			return err
+		}
+		attestationConfContentYaml, err := conf.blockAttestation.Content()
+		if err != nil {
+			return err
+		}
+		attestationConf, err := attestation.ParseConfig(attestationConfContentYaml)
+		if err != nil {
+			return err
+		}
+		bkt = attestation.WrapWithConfig(logger, bkt, attestationConf)
 		bkt = objstoretracing.WrapWithTraces(objstore.WrapWithMetrics(bkt, extprom.WrapRegistererWithPrefix("thanos_", reg), bkt.Name()))
 
 		// Ensure we close up everything properly.
@@ -858,6 +960,11 @@ func runRule(
 			}
 		}()
 
+		metaExtensions, err := conf.shipper.parseMetaExtensions()
+		if err != nil {
+			return err
+		}
+
 		s := shipper.New(
 			bkt,
 			conf.dataDir,
@@ -869,6 +976,11 @@ func runRule(
 			shipper.WithLabels(func() labels.Labels { return conf.lset }),
 			shipper.WithAllowOutOfOrderUploads(conf.shipper.allowOutOfOrderUpload),
 			shipper.WithSkipCorruptedBlocks(conf.shipper.skipCorruptedBlocks),
+			shipper.WithBackfill(conf.shipper.backfill),
+			shipper.WithUploadRateLimit(int64(conf.shipper.uploadRateLimit)),
+			shipper.WithCluster(conf.shipper.cluster),
+			shipper.WithMetaAnnotations(conf.shipper.metaAnnotations),
+			shipper.WithMetaExtensions(metaExtensions),
 		)
 
 		ctx, cancel := context.WithCancel(context.Background())
@@ -1026,6 +1138,36 @@ func addDiscoveryGroups(g *run.Group, c *clientconfig.HTTPClient, interval time.
 	})
 }
 
+// newAlertLease creates the lease electing the replica of the HA group that sends alerts and schedules its renewal.
+func newAlertLease(g *run.Group, logger log.Logger, reg prometheus.Registerer, conf *ruleConfig) (*alert.BucketLease, error) {
+	if len(conf.alertmgr.alertExcludeLabels) == 0 {
+		return nil, errors.New("--alert.ha-lease-ttl requires --alert.label-drop to identify the replica labels")
+	}
+	confContentYaml, err := conf.objStoreConfig.Content()
+	if err != nil {
+		return nil, err
+	}
+	if len(confContentYaml) == 0 {
+		return nil, errors.New("--alert.ha-lease-ttl requires an object storage configuration")
+	}
+	bkt, err := client.NewBucket(logger, confContentYaml, component.Rule.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	group := labels.NewBuilder(conf.lset).Del(conf.alertmgr.alertExcludeLabels...).Labels()
+	lease := alert.NewBucketLease(logger, reg, bkt, group, conf.lset.String(), conf.alertmgr.alertHALeaseTTL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g.Add(func() error {
+		defer runutil.CloseWithLogOnErr(logger, bkt, "alerting lease bucket client")
+		return lease.Run(ctx)
+	}, func(error) {
+		cancel()
+	})
+	return lease, nil
+}
+
 func reloadRules(logger log.Logger,
 	ruleFiles []string,
 	ruleMgr *thanosrules.Manager,
@@ -34,11 +34,28 @@ func Test_parseFlagLabels(t *testing.T) {
 			s:         []string{`label_name=LabelVal`}, // Missing quotes invalid syntax.
 			expectErr: true,
 		},
+		{
+			s:         []string{`"service.name"="LabelVal"`, `"a=b"="LabelVal"`}, // Quoted UTF-8 names.
+			expectErr: false,
+		},
+		{
+			s:         []string{`"service.name="LabelVal"`}, // Unterminated quoted name.
+			expectErr: true,
+		},
+		{
+			s:         []string{`"service.name""LabelVal"`}, // Missing "=" separator after quoted name.
+			expectErr: true,
+		},
 	}
 	for _, td := range tData {
 		_, err := parseFlagLabels(td.s)
 		testutil.Equals(t, err != nil, td.expectErr)
 	}
+
+	lset, err := parseFlagLabels([]string{`"service.name"="api"`})
+	testutil.Ok(t, err)
+	testutil.Equals(t, "api", lset.Get("service.name"))
+	testutil.Equals(t, `{"service.name"="api"}`, lset.String())
 }
 
 func Test_validateTemplate(t *testing.T) {
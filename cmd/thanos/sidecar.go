@@ -25,20 +25,24 @@ import (
 	"github.com/prometheus/prometheus/model/labels"
 
 	"github.com/thanos-io/objstore"
-	"github.com/thanos-io/objstore/client"
 	objstoretracing "github.com/thanos-io/objstore/tracing/opentracing"
 
+	"github.com/thanos-io/thanos/pkg/block/attestation"
+	"github.com/thanos-io/thanos/pkg/block/encryption"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/clientconfig"
 	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/exemplars"
+	"github.com/thanos-io/thanos/pkg/exemplars/exemplarspb"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	"github.com/thanos-io/thanos/pkg/info"
 	"github.com/thanos-io/thanos/pkg/info/infopb"
 	"github.com/thanos-io/thanos/pkg/logging"
 	meta "github.com/thanos-io/thanos/pkg/metadata"
+	"github.com/thanos-io/thanos/pkg/metadata/metadatapb"
 	thanosmodel "github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/promclient"
 	"github.com/thanos-io/thanos/pkg/reloader"
@@ -154,6 +158,7 @@ func runSidecar(
 			httpserver.WithListen(conf.http.bindAddress),
 			httpserver.WithGracePeriod(time.Duration(conf.http.gracePeriod)),
 			httpserver.WithTLSConfig(conf.http.tlsConfig),
+			httpserver.WithLogLevels(logLevels),
 		)
 
 		g.Add(func() error {
@@ -189,7 +194,7 @@ func runSidecar(
 					iterCtx, iterCancel := context.WithTimeout(context.Background(), conf.prometheus.getConfigTimeout)
 					defer iterCancel()
 
-					if err := validatePrometheus(iterCtx, m.client, logger, conf.shipper.ignoreBlockSize, m); err != nil {
+					if err := validatePrometheus(iterCtx, m.client, logger, conf.shipper.ignoreBlockSize, conf.shipper.uploadCompacted, m); err != nil {
 						level.Warn(logger).Log(
 							"msg", "failed to validate prometheus flags. Is Prometheus running? Retrying",
 							"err", err,
@@ -320,6 +325,10 @@ func runSidecar(
 		if err != nil {
 			return errors.Wrap(err, "setup gRPC server")
 		}
+		authorizer, err := conf.grpc.authorizer(reg)
+		if err != nil {
+			return errors.Wrap(err, "setup gRPC server")
+		}
 
 		exemplarSrv := exemplars.NewPrometheus(conf.prometheus.url, c, m.Labels)
 
@@ -359,6 +368,7 @@ func runSidecar(
 			grpcserver.WithGracePeriod(conf.grpc.gracePeriod),
 			grpcserver.WithMaxConnAge(conf.grpc.maxConnectionAge),
 			grpcserver.WithTLSConfig(tlsCfg),
+			grpcserver.WithAuthorizer(authorizer),
 		)
 
 		ctx, cancel := context.WithCancel(context.Background())
@@ -380,10 +390,28 @@ func runSidecar(
 	if uploads {
 		// The background shipper continuously scans the data directory and uploads
 		// new blocks to Google Cloud Storage or an S3-compatible storage service.
-		bkt, err := client.NewBucket(logger, confContentYaml, component.Sidecar.String(), nil)
+		bkt, err := objstoreutil.NewBucket(logger, confContentYaml, component.Sidecar.String(), nil)
+		if err != nil {
+			return err
+		}
+		bkt = objstoreutil.WrapWithAccounting(bkt, reg)
+		encryptionConfContentYaml, err := conf.objStoreEncryption.Content()
+		if err != nil {
+			return err
+		}
+		bkt, err = encryption.WrapWithConfig(logger, bkt, encryptionConfContentYaml)
+		if err != nil {
+			return err
+		}
+		attestationConfContentYaml, err := conf.blockAttestation.Content()
 		if err != nil {
 			return err
 		}
+		attestationConf, err := attestation.ParseConfig(attestationConfContentYaml)
+		if err != nil {
+			return err
+		}
+		bkt = attestation.WrapWithConfig(logger, bkt, attestationConf)
 		bkt = objstoretracing.WrapWithTraces(objstore.WrapWithMetrics(bkt, extprom.WrapRegistererWithPrefix("thanos_", reg), bkt.Name()))
 
 		// Ensure we close up everything properly.
@@ -393,6 +421,11 @@ func runSidecar(
 			}
 		}()
 
+		metaExtensions, err := conf.shipper.parseMetaExtensions()
+		if err != nil {
+			return err
+		}
+
 		if err := promclient.IsWALDirAccessible(conf.tsdb.path); err != nil {
 			level.Error(logger).Log("err", err)
 		}
@@ -414,9 +447,7 @@ func runSidecar(
 				return errors.Wrapf(err, "aborting as no external labels found after waiting %s", promReadyTimeout)
 			}
 
-			s := shipper.New(
-				bkt,
-				conf.tsdb.path,
+			shipperOpts := []shipper.Option{
 				shipper.WithLogger(logger),
 				shipper.WithRegisterer(reg),
 				shipper.WithSource(metadata.SidecarSource),
@@ -426,7 +457,24 @@ func runSidecar(
 				shipper.WithUploadCompacted(conf.shipper.uploadCompacted),
 				shipper.WithAllowOutOfOrderUploads(conf.shipper.allowOutOfOrderUpload),
 				shipper.WithSkipCorruptedBlocks(conf.shipper.skipCorruptedBlocks),
-			)
+				shipper.WithBackfill(conf.shipper.backfill),
+				shipper.WithUploadRateLimit(int64(conf.shipper.uploadRateLimit)),
+				shipper.WithCluster(conf.shipper.cluster),
+				shipper.WithMetaAnnotations(conf.shipper.metaAnnotations),
+				shipper.WithMetaExtensions(metaExtensions),
+			}
+			if conf.uploadExemplars {
+				shipperOpts = append(shipperOpts, shipper.WithExemplars(func(ctx context.Context, mint, maxt int64) ([]*exemplarspb.ExemplarData, error) {
+					// The exemplars API selects the exemplars of a closed interval.
+					return m.client.ExemplarsInGRPC(ctx, conf.prometheus.url, `{__name__=~".+"}`, mint, maxt-1)
+				}))
+			}
+			if conf.uploadMetadata {
+				shipperOpts = append(shipperOpts, shipper.WithMetricMetadata(func(ctx context.Context) (map[string][]metadatapb.Meta, error) {
+					return m.client.MetricMetadataInGRPC(ctx, conf.prometheus.url, "", -1)
+				}))
+			}
+			s := shipper.New(bkt, conf.tsdb.path, shipperOpts...)
 
 			return runutil.Repeat(30*time.Second, ctx.Done(), func() error {
 				if uploaded, err := s.Sync(ctx); err != nil {
@@ -443,7 +491,7 @@ func runSidecar(
 	return nil
 }
 
-func validatePrometheus(ctx context.Context, client *promclient.Client, logger log.Logger, ignoreBlockSize bool, m *promMetadata) error {
+func validatePrometheus(ctx context.Context, client *promclient.Client, logger log.Logger, ignoreBlockSize, uploadCompacted bool, m *promMetadata) error {
 	var (
 		flagErr error
 		flags   promclient.Flags
@@ -464,13 +512,17 @@ func validatePrometheus(ctx context.Context, client *promclient.Client, logger l
 		return nil
 	}
 
-	// Check if compaction is disabled.
+	// Check if compaction is disabled, unless compacted blocks are shipped as well.
 	if flags.TSDBMinTime != flags.TSDBMaxTime {
-		if !ignoreBlockSize {
+		switch {
+		case uploadCompacted:
+			level.Info(logger).Log("msg", "Prometheus compaction is enabled, compacted blocks will be uploaded once checked for overlaps with the blocks in the bucket.", "min-block-duration", flags.TSDBMinTime, "max-block-duration", flags.TSDBMaxTime)
+		case !ignoreBlockSize:
 			return errors.Errorf("found that TSDB Max time is %s and Min time is %s. "+
 				"Compaction needs to be disabled (storage.tsdb.min-block-duration = storage.tsdb.max-block-duration)", flags.TSDBMaxTime, flags.TSDBMinTime)
+		default:
+			level.Warn(logger).Log("msg", "flag to ignore Prometheus min/max block duration flags differing is being used. If the upload of a 2h block fails and a Prometheus compaction happens that block may be missing from your Thanos bucket storage.")
 		}
-		level.Warn(logger).Log("msg", "flag to ignore Prometheus min/max block duration flags differing is being used. If the upload of a 2h block fails and a Prometheus compaction happens that block may be missing from your Thanos bucket storage.")
 	}
 	// Check if block time is 2h.
 	if flags.TSDBMinTime != model.Duration(2*time.Hour) {
@@ -556,16 +608,20 @@ func (s *promMetadata) Version() string {
 }
 
 type sidecarConfig struct {
-	http            httpConfig
-	grpc            grpcConfig
-	prometheus      prometheusConfig
-	tsdb            tsdbConfig
-	reloader        reloaderConfig
-	reqLogConfig    *extflag.PathOrContent
-	objStore        extflag.PathOrContent
-	shipper         shipperConfig
-	limitMinTime    thanosmodel.TimeOrDurationValue
-	storeRateLimits store.SeriesSelectLimits
+	http               httpConfig
+	grpc               grpcConfig
+	prometheus         prometheusConfig
+	tsdb               tsdbConfig
+	reloader           reloaderConfig
+	reqLogConfig       *extflag.PathOrContent
+	objStore           extflag.PathOrContent
+	objStoreEncryption extflag.PathOrContent
+	blockAttestation   extflag.PathOrContent
+	shipper            shipperConfig
+	uploadExemplars    bool
+	uploadMetadata     bool
+	limitMinTime       thanosmodel.TimeOrDurationValue
+	storeRateLimits    store.SeriesSelectLimits
 }
 
 func (sc *sidecarConfig) registerFlag(cmd extkingpin.FlagClause) {
@@ -576,7 +632,13 @@ func (sc *sidecarConfig) registerFlag(cmd extkingpin.FlagClause) {
 	sc.reloader.registerFlag(cmd)
 	sc.reqLogConfig = extkingpin.RegisterRequestLoggingFlags(cmd)
 	sc.objStore = *extkingpin.RegisterCommonObjStoreFlags(cmd, "", false)
+	sc.objStoreEncryption = *extkingpin.RegisterObjStoreEncryptionFlags(cmd)
+	sc.blockAttestation = *extkingpin.RegisterBlockAttestationFlags(cmd)
 	sc.shipper.registerFlag(cmd)
+	cmd.Flag("shipper.upload-exemplars", "If true sidecar persists the exemplars of Prometheus, within the time range of each uploaded block, into an exemplars file of the block, so that they outlive the exemplar storage of Prometheus.").
+		Default("false").BoolVar(&sc.uploadExemplars)
+	cmd.Flag("shipper.upload-metric-metadata", "If true sidecar persists the metric metadata of Prometheus at the time of the upload of each block into a metric metadata file of the block, so that it can be served by Store Gateway once the sidecar is gone.").
+		Default("false").BoolVar(&sc.uploadMetadata)
 	sc.storeRateLimits.RegisterFlags(cmd)
 	cmd.Flag("min-time", "Start of time range limit to serve. Thanos sidecar will serve only metrics, which happened later than this value. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y.").
 		Default("0000-01-01T00:00:00Z").SetValue(&sc.limitMinTime)
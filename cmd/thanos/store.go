@@ -21,35 +21,45 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	commonmodel "github.com/prometheus/common/model"
 	"github.com/prometheus/common/route"
+	"github.com/prometheus/prometheus/model/labels"
 	"gopkg.in/yaml.v2"
 
 	"github.com/thanos-io/objstore"
-	"github.com/thanos-io/objstore/client"
 	objstoretracing "github.com/thanos-io/objstore/tracing/opentracing"
 
+	"github.com/thanos-io/thanos/pkg/activetracker"
 	blocksAPI "github.com/thanos-io/thanos/pkg/api/blocks"
+	cardinalityAPI "github.com/thanos-io/thanos/pkg/api/cardinality"
 	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/attestation"
+	"github.com/thanos-io/thanos/pkg/block/encryption"
 	"github.com/thanos-io/thanos/pkg/block/indexheader"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/cardinality"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/component"
+	"github.com/thanos-io/thanos/pkg/exemplars"
 	hidden "github.com/thanos-io/thanos/pkg/extflag"
 	"github.com/thanos-io/thanos/pkg/exthttp"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
+	"github.com/thanos-io/thanos/pkg/extpromql"
 	"github.com/thanos-io/thanos/pkg/gate"
 	"github.com/thanos-io/thanos/pkg/info"
 	"github.com/thanos-io/thanos/pkg/info/infopb"
 	"github.com/thanos-io/thanos/pkg/logging"
+	meta "github.com/thanos-io/thanos/pkg/metadata"
 	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	grpcserver "github.com/thanos-io/thanos/pkg/server/grpc"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
-	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 	"github.com/thanos-io/thanos/pkg/store"
 	storecache "github.com/thanos-io/thanos/pkg/store/cache"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/tenancy"
 	"github.com/thanos-io/thanos/pkg/tls"
 	"github.com/thanos-io/thanos/pkg/ui"
 )
@@ -69,10 +79,14 @@ const (
 type storeConfig struct {
 	indexCacheConfigs             extflag.PathOrContent
 	objStoreConfig                extflag.PathOrContent
+	objStoreEncryption            extflag.PathOrContent
+	blockAttestation              extflag.PathOrContent
+	objStoreResilience            extflag.PathOrContent
 	dataDir                       string
 	cacheIndexHeader              bool
 	grpcConfig                    grpcConfig
 	httpConfig                    httpConfig
+	httpRBAC                      *extflag.PathOrContent
 	indexCacheSizeBytes           units.Base2Bytes
 	chunkPoolSize                 units.Base2Bytes
 	estimatedMaxSeriesSize        uint64
@@ -81,6 +95,9 @@ type storeConfig struct {
 	storeRateLimits               store.SeriesSelectLimits
 	maxDownloadedBytes            units.Base2Bytes
 	maxConcurrency                int
+	activeQueryDir                string
+	metering                      meteringConfig
+	tenantLabelName               string
 	component                     component.StoreAPI
 	debugLogging                  bool
 	syncInterval                  time.Duration
@@ -89,9 +106,13 @@ type storeConfig struct {
 	blockMetaFetchConcurrency     int
 	filterConf                    *store.FilterConfig
 	selectorRelabelConf           extflag.PathOrContent
+	selectorMatchers              []string
 	advertiseCompatibilityLabel   bool
 	consistencyDelay              commonmodel.Duration
 	ignoreDeletionMarksDelay      commonmodel.Duration
+	retentionRaw                  commonmodel.Duration
+	retentionFiveMin              commonmodel.Duration
+	retentionOneHr                commonmodel.Duration
 	disableWeb                    bool
 	webConfig                     webConfig
 	label                         string
@@ -101,6 +122,12 @@ type storeConfig struct {
 	lazyIndexReaderEnabled        bool
 	lazyIndexReaderIdleTimeout    time.Duration
 	lazyExpandedPostingsEnabled   bool
+	labelsBloomEnabled            bool
+	prebuiltIndexHeadersEnabled   bool
+	seriesHashCacheMaxItems       uint64
+	seriesHashesEnabled           bool
+	exemplarsEnabled              bool
+	metricMetadataEnabled         bool
 	postingGroupMaxKeySeriesRatio float64
 
 	indexHeaderLazyDownloadStrategy string
@@ -111,6 +138,10 @@ type storeConfig struct {
 
 func (sc *storeConfig) registerFlag(cmd extkingpin.FlagClause) {
 	sc.httpConfig = *sc.httpConfig.registerFlag(cmd)
+	sc.httpRBAC = extkingpin.RegisterHTTPRBACFlags(cmd)
+	sc.metering = *sc.metering.registerFlag(cmd).registerRecordFlag(cmd)
+	cmd.Flag("store.tenant-label-name", "External label of the blocks naming their tenant, for the cardinality API. Blocks without it belong to the default tenant.").
+		Default(tenancy.DefaultTenantLabel).StringVar(&sc.tenantLabelName)
 	sc.grpcConfig = *sc.grpcConfig.registerFlag(cmd)
 	sc.storeRateLimits.RegisterFlags(cmd)
 
@@ -145,9 +176,14 @@ func (sc *storeConfig) registerFlag(cmd extkingpin.FlagClause) {
 
 	cmd.Flag("store.grpc.series-max-concurrency", "Maximum number of concurrent Series calls.").Default("20").IntVar(&sc.maxConcurrency)
 
+	cmd.Flag("store.active-query-path", "Directory to log currently active Series, LabelNames and LabelValues calls in the requests.active file. The calls which did not finish, e.g. because the process ran out of memory, are logged on the next start.").Default("").StringVar(&sc.activeQueryDir)
+
 	sc.component = component.Store
 
 	sc.objStoreConfig = *extkingpin.RegisterCommonObjStoreFlags(cmd, "", true)
+	sc.objStoreEncryption = *extkingpin.RegisterObjStoreEncryptionFlags(cmd)
+	sc.blockAttestation = *extkingpin.RegisterBlockAttestationFlags(cmd)
+	sc.objStoreResilience = *extkingpin.RegisterObjStoreResilienceFlags(cmd)
 
 	cmd.Flag("sync-block-duration", "Repeat interval for syncing the blocks between local and remote view.").
 		Default("15m").DurationVar(&sc.syncInterval)
@@ -184,6 +220,9 @@ func (sc *storeConfig) registerFlag(cmd extkingpin.FlagClause) {
 
 	sc.selectorRelabelConf = *extkingpin.RegisterSelectorRelabelFlags(cmd)
 
+	cmd.Flag("selector.matchers", "Selector of the blocks to serve by their external labels, e.g. '{tenant=~\"premium-.*\"}' (repeatable). Blocks matching any of the selectors are served, all blocks are served if none are given.").
+		PlaceHolder("<selector>").StringsVar(&sc.selectorMatchers)
+
 	cmd.Flag("store.index-header-posting-offsets-in-mem-sampling", "Controls what is the ratio of postings offsets store will hold in memory. "+
 		"Larger value will keep less offsets, which will increase CPU cycles needed for query touching those postings. It's meant for setups that want low baseline memory pressure and where less traffic is expected. "+
 		"On the contrary, smaller value will increase baseline memory usage, but improve latency slightly. 1 will keep all in memory. Default value is the same as in Prometheus which gives a good balance.").
@@ -199,6 +238,13 @@ func (sc *storeConfig) registerFlag(cmd extkingpin.FlagClause) {
 		"Default is 24h, half of the default value for --delete-delay on compactor.").
 		Default("24h").SetValue(&sc.ignoreDeletionMarksDelay)
 
+	cmd.Flag("store.retention.resolution-raw", "Retention of raw samples, as configured on the compactor. Blocks past it are not loaded, as the compactor is about to delete them. Setting this to 0d loads the blocks of this resolution regardless of their age.").
+		Default("0d").SetValue(&sc.retentionRaw)
+	cmd.Flag("store.retention.resolution-5m", "Retention of samples of resolution 1 (5 minutes), as configured on the compactor. Blocks past it are not loaded, as the compactor is about to delete them. Setting this to 0d loads the blocks of this resolution regardless of their age.").
+		Default("0d").SetValue(&sc.retentionFiveMin)
+	cmd.Flag("store.retention.resolution-1h", "Retention of samples of resolution 2 (1 hour), as configured on the compactor. Blocks past it are not loaded, as the compactor is about to delete them. Setting this to 0d loads the blocks of this resolution regardless of their age.").
+		Default("0d").SetValue(&sc.retentionOneHr)
+
 	cmd.Flag("store.enable-index-header-lazy-reader", "If true, Store Gateway will lazy memory map index-header only once the block is required by a query.").
 		Default("false").BoolVar(&sc.lazyIndexReaderEnabled)
 
@@ -208,6 +254,24 @@ func (sc *storeConfig) registerFlag(cmd extkingpin.FlagClause) {
 	cmd.Flag("store.enable-lazy-expanded-postings", "If true, Store Gateway will estimate postings size and try to lazily expand postings if it downloads less data than expanding all postings.").
 		Default("false").BoolVar(&sc.lazyExpandedPostingsEnabled)
 
+	cmd.Flag("store.enable-labels-bloom-filter", "If true, Store Gateway will load the labels bloom filters of blocks, built by Compactor with --compact.labels-bloom-filter, and skip blocks without the label pairs of the equality and set matchers of requests.").
+		Default("false").BoolVar(&sc.labelsBloomEnabled)
+
+	cmd.Flag("store.enable-prebuilt-index-headers", "If true, Store Gateway will download the index-headers of blocks built by Compactor with --compact.index-header, instead of building them from the index of the blocks. The index-headers of blocks without one are still built.").
+		Default("false").BoolVar(&sc.prebuiltIndexHeadersEnabled)
+
+	cmd.Flag("store.series-hash-cache.max-items", "Maximum number of shard hashes of series held in the in-memory series hash cache, for sharded queries not to hash the labels of the same series again. 0 disables the cache.").
+		Default("0").Uint64Var(&sc.seriesHashCacheMaxItems)
+
+	cmd.Flag("store.enable-precomputed-series-hashes", "If true, Store Gateway will load the series hashes of blocks, computed by Compactor with --compact.series-hashes, and use them for sharded queries instead of hashing the labels of the series.").
+		Default("false").BoolVar(&sc.seriesHashesEnabled)
+
+	cmd.Flag("store.enable-exemplars", "If true, Store Gateway serves the Exemplars API from the exemplars files of blocks, persisted by Sidecar and Receive with --shipper.upload-exemplars and merged by Compactor.").
+		Default("false").BoolVar(&sc.exemplarsEnabled)
+
+	cmd.Flag("store.enable-metric-metadata", "If true, Store Gateway serves the Metadata API from the metric metadata files of blocks, persisted by Sidecar with --shipper.upload-metric-metadata and merged by Compactor.").
+		Default("false").BoolVar(&sc.metricMetadataEnabled)
+
 	cmd.Flag("store.posting-group-max-key-series-ratio", "Mark posting group as lazy if it fetches more keys than R * max series the query should fetch. With R set to 100, a posting group which fetches 100K keys will be marked as lazy if the current query only fetches 1000 series. thanos_bucket_store_lazy_expanded_posting_groups_total shows lazy expanded postings groups with reasons and you can tune this config accordingly. This config is only valid if lazy expanded posting is enabled. 0 disables the limit.").
 		Default("100").Float64Var(&sc.postingGroupMaxKeySeriesRatio)
 
@@ -299,11 +363,17 @@ func runStore(
 		prober.NewInstrumentation(conf.component, logger, extprom.WrapRegistererWithPrefix("thanos_", reg)),
 	)
 
+	rbac, err := newRBAC(reg, conf.httpRBAC, tenancy.DefaultTenantHeader, tenancy.DefaultTenant, "")
+	if err != nil {
+		return err
+	}
 	srv := httpserver.New(logger, reg, conf.component, httpProbe,
 		httpserver.WithListen(conf.httpConfig.bindAddress),
 		httpserver.WithGracePeriod(time.Duration(conf.httpConfig.gracePeriod)),
 		httpserver.WithTLSConfig(conf.httpConfig.tlsConfig),
+		httpserver.WithLogLevels(logLevels),
 		httpserver.WithEnableH2C(true), // For groupcache.
+		httpserver.WithRBAC(rbac),
 	)
 
 	g.Add(func() error {
@@ -325,10 +395,36 @@ func runStore(
 	if err := yaml.Unmarshal(confContentYaml, &customBktConfig); err != nil {
 		return errors.Wrap(err, "parsing config YAML file")
 	}
-	bkt, err := client.NewBucket(logger, confContentYaml, conf.component.String(), exthttp.CreateHedgedTransportWithConfig(customBktConfig))
+	bkt, err := objstoreutil.NewBucket(logger, confContentYaml, conf.component.String(), exthttp.CreateHedgedTransportWithConfig(customBktConfig))
+	if err != nil {
+		return err
+	}
+	bkt = objstoreutil.WrapWithAccounting(bkt, reg)
+	encryptionConfContentYaml, err := conf.objStoreEncryption.Content()
+	if err != nil {
+		return err
+	}
+	bkt, err = encryption.WrapWithConfig(logger, bkt, encryptionConfContentYaml)
+	if err != nil {
+		return err
+	}
+	attestationConfContentYaml, err := conf.blockAttestation.Content()
+	if err != nil {
+		return err
+	}
+	attestationConf, err := attestation.ParseConfig(attestationConfContentYaml)
+	if err != nil {
+		return err
+	}
+	resilienceConfContentYaml, err := conf.objStoreResilience.Content()
+	if err != nil {
+		return err
+	}
+	resilienceConf, err := objstoreutil.ParseResilienceConfig(resilienceConfContentYaml)
 	if err != nil {
 		return err
 	}
+	bkt = objstoreutil.WrapWithResilience(logger, bkt, resilienceConf, reg)
 	insBkt := objstoretracing.WrapWithTraces(objstore.WrapWithMetrics(bkt, extprom.WrapRegistererWithPrefix("thanos_", reg), bkt.Name()))
 
 	cachingBucketConfigYaml, err := conf.cachingBucketConfig.Content()
@@ -355,6 +451,15 @@ func runStore(
 		return err
 	}
 
+	selectorMatchers := make([][]*labels.Matcher, 0, len(conf.selectorMatchers))
+	for _, selector := range conf.selectorMatchers {
+		matchers, err := extpromql.ParseMetricSelector(selector)
+		if err != nil {
+			return errors.Wrapf(err, "parse selector %s", selector)
+		}
+		selectorMatchers = append(selectorMatchers, matchers)
+	}
+
 	indexCacheContentYaml, err := conf.indexCacheConfigs.Content()
 	if err != nil {
 		return errors.Wrap(err, "get content of index cache configuration")
@@ -393,14 +498,25 @@ func runStore(
 		return errors.Errorf("unknown sync strategy %s", conf.blockListStrategy)
 	}
 	ignoreDeletionMarkFilter := block.NewIgnoreDeletionMarkFilter(logger, insBkt, time.Duration(conf.ignoreDeletionMarksDelay), conf.blockMetaFetchConcurrency)
+	var metaFilters []block.MetadataFilter
+	if attestationFilter := attestation.NewFilter(logger, insBkt, attestationConf, conf.blockMetaFetchConcurrency, reg); attestationFilter != nil {
+		// Verify metas before any filter modifies them.
+		metaFilters = append(metaFilters, attestationFilter)
+	}
 	metaFetcher, err := block.NewMetaFetcher(logger, conf.blockMetaFetchConcurrency, insBkt, blockLister, dataDir, extprom.WrapRegistererWithPrefix("thanos_", reg),
-		[]block.MetadataFilter{
+		append(metaFilters,
 			block.NewTimePartitionMetaFilter(conf.filterConf.MinTime, conf.filterConf.MaxTime),
+			block.NewRetentionMetaFilter(logger, map[int64]time.Duration{
+				downsample.ResLevel0: time.Duration(conf.retentionRaw),
+				downsample.ResLevel1: time.Duration(conf.retentionFiveMin),
+				downsample.ResLevel2: time.Duration(conf.retentionOneHr),
+			}),
 			block.NewLabelShardedMetaFilter(relabelConfig),
+			block.NewLabelMatcherMetaFilter(selectorMatchers),
 			block.NewConsistencyDelayMetaFilter(logger, time.Duration(conf.consistencyDelay), extprom.WrapRegistererWithPrefix("thanos_", reg)),
 			ignoreDeletionMarkFilter,
 			block.NewDeduplicateFilter(conf.blockMetaFetchConcurrency),
-		})
+		))
 	if err != nil {
 		return errors.Wrap(err, "meta fetcher")
 	}
@@ -417,19 +533,31 @@ func runStore(
 		return errors.Wrap(err, "create chunk pool")
 	}
 
+	var activeTracker *activetracker.Tracker
+	if conf.activeQueryDir != "" {
+		// Only Series calls are limited by the gate, so leave room for the label calls. Calls beyond the
+		// number of slots are not recorded. The file stays open for the lifetime of the process.
+		activeTracker, err = activetracker.New(logger, conf.activeQueryDir, "requests.active", 2*max(conf.maxConcurrency, 20))
+		if err != nil {
+			return errors.Wrap(err, "create active request tracker")
+		}
+	}
+
+	meter, err := conf.metering.meter(reg, conf.component)
+	if err != nil {
+		return err
+	}
+	conf.metering.addRecordWriter(g, logger, meter, insBkt)
+
 	options := []store.BucketStoreOption{
 		store.WithLogger(logger),
-		store.WithRequestLoggerFunc(func(ctx context.Context, logger log.Logger) log.Logger {
-			reqID, ok := middleware.RequestIDFromContext(ctx)
-			if ok {
-				return log.With(logger, "request-id", reqID)
-			}
-			return logger
-		}),
+		store.WithRequestLoggerFunc(logging.WithRequestID),
 		store.WithRegistry(reg),
 		store.WithIndexCache(indexCache),
 		store.WithMatchersCache(matchersCache),
 		store.WithQueryGate(queriesGate),
+		store.WithActiveTracker(activeTracker),
+		store.WithMeter(meter),
 		store.WithChunkPool(chunkPool),
 		store.WithFilterConfig(conf.filterConf),
 		store.WithChunkHashCalculation(true),
@@ -449,6 +577,9 @@ func runStore(
 			return conf.estimatedMaxChunkSize
 		}),
 		store.WithLazyExpandedPostings(conf.lazyExpandedPostingsEnabled),
+		store.WithLabelsBloom(conf.labelsBloomEnabled),
+		store.WithPrebuiltIndexHeaders(conf.prebuiltIndexHeadersEnabled),
+		store.WithPrecomputedSeriesHashes(conf.seriesHashesEnabled),
 		store.WithPostingGroupMaxKeySeriesRatio(conf.postingGroupMaxKeySeriesRatio),
 		store.WithSeriesMatchRatio(0.5), // TODO: expose series match ratio as config.
 		store.WithIndexHeaderLazyDownloadStrategy(
@@ -459,6 +590,9 @@ func runStore(
 	if conf.debugLogging {
 		options = append(options, store.WithDebugLogging())
 	}
+	if conf.seriesHashCacheMaxItems > 0 {
+		options = append(options, store.WithSeriesHashCache(store.NewSeriesHashCache(reg, conf.seriesHashCacheMaxItems)))
+	}
 
 	bs, err := store.NewBucketStore(
 		insBkt,
@@ -521,8 +655,7 @@ func runStore(
 		})
 	}
 
-	infoSrv := info.NewInfoServer(
-		component.Store.String(),
+	infoOpts := []info.ServerOptionFunc{
 		info.WithLabelSetFunc(func() []labelpb.ZLabelSet {
 			return bs.LabelSet()
 		}),
@@ -539,7 +672,17 @@ func runStore(
 			}
 			return nil, errors.New("Not ready")
 		}),
-	)
+	}
+	if conf.exemplarsEnabled {
+		infoOpts = append(infoOpts, info.WithExemplarsInfoFunc(func() *infopb.ExemplarsInfo {
+			mint, maxt := bs.TimeRange()
+			return &infopb.ExemplarsInfo{MinTime: mint, MaxTime: maxt}
+		}))
+	}
+	if conf.metricMetadataEnabled {
+		infoOpts = append(infoOpts, info.WithMetricMetadataInfoFunc())
+	}
+	infoSrv := info.NewInfoServer(component.Store.String(), infoOpts...)
 
 	// Start query (proxy) gRPC StoreAPI.
 	{
@@ -547,16 +690,28 @@ func runStore(
 		if err != nil {
 			return errors.Wrap(err, "setup gRPC server")
 		}
+		authorizer, err := conf.grpcConfig.authorizer(reg)
+		if err != nil {
+			return errors.Wrap(err, "setup gRPC server")
+		}
 
 		storeServer := store.NewInstrumentedStoreServer(reg, bs)
-		s := grpcserver.New(logger, reg, tracer, grpcLogOpts, logFilterMethods, conf.component, grpcProbe,
+		grpcOpts := []grpcserver.Option{
 			grpcserver.WithServer(store.RegisterStoreServer(storeServer, logger)),
 			grpcserver.WithServer(info.RegisterInfoServer(infoSrv)),
 			grpcserver.WithListen(conf.grpcConfig.bindAddress),
 			grpcserver.WithGracePeriod(conf.grpcConfig.gracePeriod),
 			grpcserver.WithMaxConnAge(conf.grpcConfig.maxConnectionAge),
 			grpcserver.WithTLSConfig(tlsCfg),
-		)
+			grpcserver.WithAuthorizer(authorizer),
+		}
+		if conf.exemplarsEnabled {
+			grpcOpts = append(grpcOpts, grpcserver.WithServer(exemplars.RegisterExemplarsServer(bs)))
+		}
+		if conf.metricMetadataEnabled {
+			grpcOpts = append(grpcOpts, grpcserver.WithServer(meta.RegisterMetadataServer(bs)))
+		}
+		s := grpcserver.New(logger, reg, tracer, grpcLogOpts, logFilterMethods, conf.component, grpcProbe, grpcOpts...)
 
 		g.Add(func() error {
 			<-bucketStoreReady
@@ -586,6 +741,11 @@ func runStore(
 			})
 		}
 
+		capi := cardinalityAPI.NewCardinalityAPI(func(ctx context.Context, tenant string, mint, maxt int64, limit int) (cardinality.Stats, error) {
+			return bs.Cardinality(ctx, conf.tenantLabelName, tenant, mint, maxt, limit)
+		}, tenancy.DefaultTenantHeader, tenancy.DefaultTenant, "")
+		capi.Register(r, tracer, logger, ins, logging.NewHTTPServerMiddleware(logger, httpLogOpts...))
+
 		srv.Handle("/", r)
 	}
 
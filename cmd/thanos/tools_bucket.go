@@ -10,8 +10,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -30,10 +32,14 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	config_util "github.com/prometheus/common/config"
 	prommodel "github.com/prometheus/common/model"
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage/remote"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"golang.org/x/text/language"
@@ -44,8 +50,14 @@ import (
 	"github.com/thanos-io/objstore/client"
 	objstoretracing "github.com/thanos-io/objstore/tracing/opentracing"
 
+	"github.com/thanos-io/thanos/pkg/alert"
 	v1 "github.com/thanos-io/thanos/pkg/api/blocks"
 	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/attestation"
+	"github.com/thanos-io/thanos/pkg/block/blockgen"
+	"github.com/thanos-io/thanos/pkg/block/encryption"
+	"github.com/thanos-io/thanos/pkg/block/exporter"
+	"github.com/thanos-io/thanos/pkg/block/importer"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/compact"
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
@@ -56,14 +68,18 @@ import (
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
 	"github.com/thanos-io/thanos/pkg/logging"
 	"github.com/thanos-io/thanos/pkg/logutil"
+	"github.com/thanos-io/thanos/pkg/metering"
 	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/promclient"
 	"github.com/thanos-io/thanos/pkg/replicate"
+	thanosrules "github.com/thanos-io/thanos/pkg/rules"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
 	"github.com/thanos-io/thanos/pkg/shipper"
 	"github.com/thanos-io/thanos/pkg/store"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/thanos-io/thanos/pkg/ui"
 	"github.com/thanos-io/thanos/pkg/verifier"
 )
@@ -72,14 +88,14 @@ const extpromPrefix = "thanos_bucket_"
 
 var (
 	issuesVerifiersRegistry = verifier.Registry{
-		Verifiers: []verifier.Verifier{verifier.OverlappedBlocksIssue{}},
+		Verifiers: []verifier.Verifier{verifier.OverlappedBlocksIssue{}, verifier.DownsampleSourcesIssue{}},
 		VerifierRepairers: []verifier.VerifierRepairer{
 			verifier.IndexKnownIssues{},
 			verifier.DuplicatedCompactionBlocks{},
 		},
 	}
 	inspectColumns = []string{"ULID", "FROM", "UNTIL", "RANGE", "UNTIL-DOWN", "#SERIES", "#SAMPLES", "#CHUNKS", "COMP-LEVEL", "COMP-FAILED", "LABELS", "RESOLUTION", "SOURCE"}
-	outputTypes    = []string{"table", "tsv", "csv"}
+	outputTypes    = []string{"table", "tsv", "csv", "json"}
 )
 
 type outputType string
@@ -88,6 +104,7 @@ const (
 	TABLE outputType = "table"
 	CSV   outputType = "csv"
 	TSV   outputType = "tsv"
+	JSON  outputType = "json"
 )
 
 type bucketRewriteConfig struct {
@@ -99,15 +116,19 @@ type bucketRewriteConfig struct {
 }
 
 type bucketInspectConfig struct {
-	selector []string
-	sortBy   []string
-	timeout  time.Duration
+	selector         []string
+	sortBy           []string
+	timeout          time.Duration
+	exporterInterval time.Duration
 }
 
 type bucketVerifyConfig struct {
 	repair         bool
 	ids            []string
 	issuesToVerify []string
+	concurrency    int
+	output         string
+	repairPlan     string
 }
 
 type bucketLsConfig struct {
@@ -116,6 +137,8 @@ type bucketLsConfig struct {
 	selectorRelabelConf extflag.PathOrContent
 	filterConf          *store.FilterConfig
 	timeout             time.Duration
+	orphaned            bool
+	ignoredPrefixes     []string
 }
 
 type bucketWebConfig struct {
@@ -130,12 +153,14 @@ type bucketWebConfig struct {
 }
 
 type bucketReplicateConfig struct {
-	resolutions []time.Duration
-	compactMin  int
-	compactMax  int
-	compactions []int
-	matcherStrs string
-	singleRun   bool
+	resolutions   []time.Duration
+	compactMin    int
+	compactMax    int
+	compactions   []int
+	matcherStrs   string
+	singleRun     bool
+	waitInterval  time.Duration
+	rewriteLabels []string
 }
 
 type bucketDownsampleConfig struct {
@@ -144,12 +169,16 @@ type bucketDownsampleConfig struct {
 	blockFilesConcurrency int
 	dataDir               string
 	hashFunc              string
+	shardIndex            uint64
+	shardCount            uint64
 }
 
 type bucketCleanupConfig struct {
-	consistencyDelay     time.Duration
-	blockSyncConcurrency int
-	deleteDelay          time.Duration
+	consistencyDelay      time.Duration
+	blockSyncConcurrency  int
+	deleteDelay           time.Duration
+	deleteOrphanedObjects bool
+	ignoredPrefixes       []string
 }
 
 type bucketRetentionConfig struct {
@@ -158,11 +187,25 @@ type bucketRetentionConfig struct {
 	deleteDelay          time.Duration
 }
 
+type bucketRelabelConfig struct {
+	blockIDs             []string
+	dryRun               bool
+	blockSyncConcurrency int
+}
+
 type bucketMarkBlockConfig struct {
 	details      string
 	marker       string
 	blockIDs     []string
 	removeMarker bool
+
+	matcherStrs      string
+	minTime          *model.TimeOrDurationValue
+	maxTime          *model.TimeOrDurationValue
+	resolutions      []time.Duration
+	compactionLevels []int
+	dryRun           bool
+	timeout          time.Duration
 }
 
 type bucketUploadBlocksConfig struct {
@@ -181,6 +224,13 @@ func (tbc *bucketVerifyConfig) registerBucketVerifyFlag(cmd extkingpin.FlagClaus
 
 	cmd.Flag("id", "Block IDs to verify (and optionally repair) only. "+
 		"If none is specified, all blocks will be verified. Repeated field").StringsVar(&tbc.ids)
+	cmd.Flag("concurrency", "Number of blocks verified concurrently, for issues verified block by block.").
+		Default("1").IntVar(&tbc.concurrency)
+	cmd.Flag("output", "Optional format in which to print the findings and the repair plan. Options are 'json'.").
+		Default("").EnumVar(&tbc.output, "", "json")
+	cmd.Flag("repair-plan", "Path of a repair plan file. Without --repair, the plan to repair the found issues is written to it. "+
+		"With --repair, the plan is read from it and only its repairs are applied, without verifying other blocks.").
+		Default("").StringVar(&tbc.repairPlan)
 	return tbc
 }
 
@@ -197,6 +247,9 @@ func (tbc *bucketLsConfig) registerBucketLsFlag(cmd extkingpin.FlagClause) *buck
 	cmd.Flag("max-time", "End of time range limit to list. Thanos Tools will list only blocks, which were created earlier than this value. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y.").
 		Default("9999-12-31T23:59:59Z").SetValue(&tbc.filterConf.MaxTime)
 	cmd.Flag("timeout", "Timeout to download metadata from remote storage").Default("5m").DurationVar(&tbc.timeout)
+	cmd.Flag("orphaned", "List the objects that do not belong to any block, e.g. stray debug files, unknown files in block directories or invalid markers, with their size and why they are orphaned, instead of blocks.").
+		Default("false").BoolVar(&tbc.orphaned)
+	cmd.Flag("orphaned.ignore-prefix", "Prefix of objects written by other systems sharing the bucket, never reported as orphaned (repeated).").StringsVar(&tbc.ignoredPrefixes)
 	return tbc
 }
 
@@ -206,6 +259,8 @@ func (tbc *bucketInspectConfig) registerBucketInspectFlag(cmd extkingpin.FlagCla
 	cmd.Flag("sort-by", "Sort by columns. It's also possible to sort by multiple columns, e.g. '--sort-by FROM --sort-by UNTIL'. I.e., if the 'FROM' value is equal the rows are then further sorted by the 'UNTIL' value.").
 		Default("FROM", "UNTIL").EnumsVar(&tbc.sortBy, inspectColumns...)
 	cmd.Flag("timeout", "Timeout to download metadata from remote storage").Default("5m").DurationVar(&tbc.timeout)
+	cmd.Flag("exporter.interval", "If non zero, inspect the bucket at this interval and expose the statistics of every compaction group as Prometheus metrics on the HTTP endpoint, instead of printing the blocks once.").
+		Default("0s").DurationVar(&tbc.exporterInterval)
 
 	return tbc
 }
@@ -242,6 +297,10 @@ func (tbc *bucketReplicateConfig) registerBucketReplicateFlag(cmd extkingpin.Fla
 
 	cmd.Flag("single-run", "Run replication only one time, then exit.").Default("false").BoolVar(&tbc.singleRun)
 
+	cmd.Flag("wait-interval", "Wait interval between replication runs. Every run only replicates the blocks not replicated by previous runs.").Default("1m").DurationVar(&tbc.waitInterval)
+
+	cmd.Flag("rewrite-label", "External label to set in the replicated blocks, replacing the value of the origin block if any (repeated). An empty value removes the label. Blocks are selected by their origin labels.").PlaceHolder("key=\"value\"").StringsVar(&tbc.rewriteLabels)
+
 	return tbc
 }
 
@@ -266,15 +325,28 @@ func (tbc *bucketDownsampleConfig) registerBucketDownsampleFlag(cmd extkingpin.F
 		Default("./data").StringVar(&tbc.dataDir)
 	cmd.Flag("hash-func", "Specify which hash function to use when calculating the hashes of produced files. If no function has been specified, it does not happen. This permits avoiding downloading some files twice albeit at some performance cost. Possible values are: \"\", \"SHA256\".").
 		Default("").EnumVar(&tbc.hashFunc, "SHA256", "")
+	cmd.Flag("shard-count", "Number of shards compaction groups are split into across downsample replicas. Each replica only downsamples the blocks whose external labels hash to its --shard-index. Zero or one disables sharding.").
+		Default("0").Uint64Var(&tbc.shardCount)
+	cmd.Flag("shard-index", "Index of the shard downsampled by this replica, in the range [0, --shard-count).").
+		Default("0").Uint64Var(&tbc.shardIndex)
 
 	return tbc
 }
 
 func (tbc *bucketMarkBlockConfig) registerBucketMarkBlockFlag(cmd extkingpin.FlagClause) *bucketMarkBlockConfig {
-	cmd.Flag("id", "ID (ULID) of the blocks to be marked for deletion (repeated flag)").Required().StringsVar(&tbc.blockIDs)
+	cmd.Flag("id", "ID (ULID) of the blocks to be marked for deletion (repeated flag). Either --id or --matcher is required.").StringsVar(&tbc.blockIDs)
 	cmd.Flag("marker", "Marker to be put.").Required().EnumVar(&tbc.marker, metadata.DeletionMarkFilename, metadata.NoCompactMarkFilename, metadata.NoDownsampleMarkFilename)
 	cmd.Flag("details", "Human readable details to be put into marker.").StringVar(&tbc.details)
 	cmd.Flag("remove", "Remove the marker.").Default("false").BoolVar(&tbc.removeMarker)
+	cmd.Flag("matcher", "Mark the blocks whose external labels match this matcher, instead of the blocks given by --id. All Prometheus matchers are supported, including =, !=, =~ and !~. The blocks can be further selected by --min-time, --max-time, --resolution and --compaction-level.").StringVar(&tbc.matcherStrs)
+	tbc.minTime = model.TimeOrDuration(cmd.Flag("min-time", "Only mark blocks with --matcher that have data later than this value. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y.").
+		Default("0000-01-01T00:00:00Z"))
+	tbc.maxTime = model.TimeOrDuration(cmd.Flag("max-time", "Only mark blocks with --matcher that have data earlier than this value. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y.").
+		Default("9999-12-31T23:59:59Z"))
+	cmd.Flag("resolution", "Only mark blocks with --matcher that have one of these resolutions (repeated flag). All resolutions if not set.").HintAction(listResLevel).DurationListVar(&tbc.resolutions)
+	cmd.Flag("compaction-level", "Only mark blocks with --matcher that have one of these compaction levels (repeated flag). All levels if not set.").IntsVar(&tbc.compactionLevels)
+	cmd.Flag("dry-run", "Print the blocks that would be marked, or unmarked with --remove, and whether they already are, without changing anything.").Default("false").BoolVar(&tbc.dryRun)
+	cmd.Flag("timeout", "Timeout of the whole operation.").Default("2m").DurationVar(&tbc.timeout)
 	return tbc
 }
 
@@ -284,6 +356,9 @@ func (tbc *bucketCleanupConfig) registerBucketCleanupFlag(cmd extkingpin.FlagCla
 		Default("30m").DurationVar(&tbc.consistencyDelay)
 	cmd.Flag("block-sync-concurrency", "Number of goroutines to use when syncing block metadata from object storage.").
 		Default("20").IntVar(&tbc.blockSyncConcurrency)
+	cmd.Flag("delete-orphaned-objects", "Also delete the objects that do not belong to any block, as listed by 'tools bucket ls --orphaned', once not modified for delete-delay.").
+		Default("false").BoolVar(&tbc.deleteOrphanedObjects)
+	cmd.Flag("orphaned.ignore-prefix", "Prefix of objects written by other systems sharing the bucket, never deleted as orphaned (repeated).").StringsVar(&tbc.ignoredPrefixes)
 	return tbc
 }
 
@@ -297,6 +372,15 @@ func (tbc *bucketRetentionConfig) registerBucketRetentionFlag(cmd extkingpin.Fla
 	return tbc
 }
 
+func (tbc *bucketRelabelConfig) registerBucketRelabelFlag(cmd extkingpin.FlagClause) *bucketRelabelConfig {
+	cmd.Flag("id", "ID (ULID) of the blocks to relabel (repeated flag). If none is given, all blocks are relabeled.").StringsVar(&tbc.blockIDs)
+	cmd.Flag("dry-run", "Prints the blocks to relabel and their new labels instead of relabeling them. Defaults to true, for user to double check. Pass --no-dry-run to relabel.").Default("true").BoolVar(&tbc.dryRun)
+	cmd.Flag("block-sync-concurrency", "Number of goroutines to use when syncing block metadata from object storage.").
+		Default("20").IntVar(&tbc.blockSyncConcurrency)
+
+	return tbc
+}
+
 func (tbc *bucketUploadBlocksConfig) registerBucketUploadBlocksFlag(cmd extkingpin.FlagClause) *bucketUploadBlocksConfig {
 	cmd.Flag("path", "Path to the directory containing blocks to upload.").Default("./data").StringVar(&tbc.path)
 	cmd.Flag("label", "External labels to add to the uploaded blocks (repeated).").PlaceHolder("key=\"value\"").StringsVar(&tbc.labels)
@@ -317,8 +401,13 @@ func registerBucket(app extkingpin.AppClause) {
 	registerBucketCleanup(cmd, objStoreConfig)
 	registerBucketMarkBlock(cmd, objStoreConfig)
 	registerBucketRewrite(cmd, objStoreConfig)
+	registerBucketRelabel(cmd, objStoreConfig)
 	registerBucketRetention(cmd, objStoreConfig)
 	registerBucketUploadBlocks(cmd, objStoreConfig)
+	registerBucketRulesBackfill(cmd, objStoreConfig)
+	registerBucketImport(cmd, objStoreConfig)
+	registerBucketGenerate(cmd, objStoreConfig)
+	registerBucketExport(cmd, objStoreConfig)
 }
 
 func registerBucketVerify(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
@@ -371,6 +460,21 @@ func registerBucketVerify(app extkingpin.AppClause, objStoreConfig *extflag.Path
 		// Dummy actor to immediately kill the group after the run function returns.
 		g.Add(func() error { return nil }, func(error) {})
 
+		var plan *verifier.RepairPlan
+		if tbc.repair && tbc.repairPlan != "" {
+			f, err := os.Open(tbc.repairPlan)
+			if err != nil {
+				return errors.Wrap(err, "open repair plan")
+			}
+			defer runutil.CloseWithLogOnErr(logger, f, "repair plan")
+			p, err := verifier.ReadRepairPlan(f)
+			if err != nil {
+				return err
+			}
+			plan = &p
+			tbc.issuesToVerify = plan.Issues()
+		}
+
 		r, err := issuesVerifiersRegistry.SubstractByIDs(tbc.issuesToVerify, tbc.repair)
 		if err != nil {
 			return err
@@ -403,12 +507,37 @@ func registerBucketVerify(app extkingpin.AppClause, objStoreConfig *extflag.Path
 			}
 		}
 
-		v := verifier.NewManager(reg, logger, insBkt, backupBkt, fetcher, time.Duration(*deleteDelay), r)
-		if tbc.repair {
-			return v.VerifyAndRepair(context.Background(), idMatcher)
+		v := verifier.NewManager(reg, logger, insBkt, backupBkt, fetcher, time.Duration(*deleteDelay), tbc.concurrency, r)
+
+		var report verifier.Report
+		switch {
+		case plan != nil:
+			report, err = v.ApplyRepairPlan(context.Background(), *plan)
+		case tbc.repair:
+			report, err = v.VerifyAndRepair(context.Background(), idMatcher)
+		default:
+			report, err = v.Verify(context.Background(), idMatcher)
+		}
+		if err != nil {
+			return err
 		}
 
-		return v.Verify(context.Background(), idMatcher)
+		if !tbc.repair && tbc.repairPlan != "" {
+			b, err := json.MarshalIndent(report.RepairPlan, "", "  ")
+			if err != nil {
+				return errors.Wrap(err, "encode repair plan")
+			}
+			if err := os.WriteFile(tbc.repairPlan, b, 0600); err != nil {
+				return errors.Wrap(err, "write repair plan")
+			}
+			level.Info(logger).Log("msg", "wrote repair plan", "file", tbc.repairPlan, "steps", len(report.RepairPlan.Steps))
+		}
+		if tbc.output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(report)
+		}
+		return nil
 	})
 }
 
@@ -468,6 +597,22 @@ func registerBucketLs(app extkingpin.AppClause, objStoreConfig *extflag.PathOrCo
 		ctx, cancel := context.WithTimeout(context.Background(), tbc.timeout)
 		defer cancel()
 
+		if tbc.orphaned {
+			tenants, err := compact.ListTenants(ctx, insBkt)
+			if err != nil {
+				return err
+			}
+			objs, err := block.FindOrphanedObjects(ctx, insBkt, tenants, orphanIgnoredPrefixes(tbc.ignoredPrefixes)...)
+			if err != nil {
+				return errors.Wrap(err, "find orphaned objects")
+			}
+			if err := printOrphanedObjects(os.Stdout, tbc.output, objs); err != nil {
+				return err
+			}
+			level.Info(logger).Log("msg", "ls done", "orphaned_objects", len(objs))
+			return nil
+		}
+
 		var (
 			format     = tbc.output
 			objects    = 0
@@ -529,13 +674,50 @@ func registerBucketLs(app extkingpin.AppClause, objStoreConfig *extflag.PathOrCo
 	})
 }
 
+// orphanIgnoredPrefixes returns the prefixes of objects not written by blocks but by other Thanos components.
+func orphanIgnoredPrefixes(extra []string) []string {
+	return append([]string{alert.LeaseDir + "/", compact.FencingTokenFile, metering.RecordsDir + "/"}, extra...)
+}
+
+func printOrphanedObjects(w io.Writer, format string, objs []block.OrphanedObject) error {
+	switch format {
+	case "", "wide":
+		for _, o := range objs {
+			if _, err := fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", o.Name, o.Size, o.LastModified.Format(time.RFC3339), o.Reason); err != nil {
+				return err
+			}
+		}
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		for _, o := range objs {
+			if err := enc.Encode(&o); err != nil {
+				return err
+			}
+		}
+	default:
+		tmpl, err := template.New("").Parse(format)
+		if err != nil {
+			return errors.Wrap(err, "invalid template")
+		}
+		for _, o := range objs {
+			if err := tmpl.Execute(w, &o); err != nil {
+				return errors.Wrap(err, "execute template")
+			}
+			fmt.Fprintln(w, "")
+		}
+	}
+	return nil
+}
+
 func registerBucketInspect(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
 	cmd := app.Command("inspect", "Inspect all blocks in the bucket in detailed, table-like way.")
+	httpBindAddr, httpGracePeriod, httpTLSConfig := extkingpin.RegisterHTTPFlags(cmd)
 
 	tbc := &bucketInspectConfig{}
 	tbc.registerBucketInspectFlag(cmd)
 
-	output := cmd.Flag("output", "Output format for result. Currently supports table, csv, tsv.").Default("table").Enum(outputTypes...)
+	output := cmd.Flag("output", "Output format for result. Currently supports table, csv, tsv, json. The json output also includes the statistics of every compaction group.").Default("table").Enum(outputTypes...)
 
 	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
 
@@ -556,31 +738,50 @@ func registerBucketInspect(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 		}
 		insBkt := objstoretracing.WrapWithTraces(objstore.WrapWithMetrics(bkt, extprom.WrapRegistererWithPrefix("thanos_", reg), bkt.Name()))
 
+		// Blocks marked for deletion are kept, the filter is only used to gather the deletion marks.
+		deletionMarkFilter := block.NewIgnoreDeletionMarkFilter(logger, insBkt, time.Duration(math.MaxInt64), block.FetcherConcurrency)
 		baseBlockIDsFetcher := block.NewConcurrentLister(logger, insBkt)
-		fetcher, err := block.NewMetaFetcher(logger, block.FetcherConcurrency, insBkt, baseBlockIDsFetcher, "", extprom.WrapRegistererWithPrefix(extpromPrefix, reg), nil)
+		fetcher, err := block.NewMetaFetcher(logger, block.FetcherConcurrency, insBkt, baseBlockIDsFetcher, "", extprom.WrapRegistererWithPrefix(extpromPrefix, reg), []block.MetadataFilter{deletionMarkFilter})
 		if err != nil {
 			return err
 		}
 
+		fetch := func(ctx context.Context) ([]*metadata.Meta, []block.GroupStats, error) {
+			ctx, cancel := context.WithTimeout(ctx, tbc.timeout)
+			defer cancel()
+
+			metas, _, err := fetcher.Fetch(ctx)
+			if err != nil {
+				return nil, nil, err
+			}
+			for id, meta := range metas {
+				if !matchesSelector(meta, selectorLabels) {
+					delete(metas, id)
+				}
+			}
+
+			blockMetas := make([]*metadata.Meta, 0, len(metas))
+			for _, meta := range metas {
+				blockMetas = append(blockMetas, meta)
+			}
+			return blockMetas, block.ComputeGroupStats(metas, deletionMarkFilter.DeletionMarkBlocks()), nil
+		}
+
+		if tbc.exporterInterval > 0 {
+			return runBucketInspectExporter(g, logger, reg, insBkt, *httpBindAddr, *httpTLSConfig, time.Duration(*httpGracePeriod), tbc.exporterInterval, fetch)
+		}
+
 		// Dummy actor to immediately kill the group after the run function returns.
 		g.Add(func() error { return nil }, func(error) {})
 
 		defer runutil.CloseWithLogOnErr(logger, insBkt, "bucket client")
 
-		ctx, cancel := context.WithTimeout(context.Background(), tbc.timeout)
-		defer cancel()
-
 		// Getting Metas.
-		metas, _, err := fetcher.Fetch(ctx)
+		blockMetas, stats, err := fetch(context.Background())
 		if err != nil {
 			return err
 		}
 
-		blockMetas := make([]*metadata.Meta, 0, len(metas))
-		for _, meta := range metas {
-			blockMetas = append(blockMetas, meta)
-		}
-
 		var opPrinter tablePrinter
 		op := outputType(*output)
 		switch op {
@@ -590,11 +791,100 @@ func registerBucketInspect(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 			opPrinter = printTSV
 		case CSV:
 			opPrinter = printCSV
+		case JSON:
+			return printInspectJSON(os.Stdout, blockMetas, stats)
 		}
 		return printBlockData(blockMetas, selectorLabels, tbc.sortBy, opPrinter)
 	})
 }
 
+// runBucketInspectExporter periodically inspects the bucket and exposes the statistics of every compaction group as metrics.
+func runBucketInspectExporter(
+	g *run.Group,
+	logger log.Logger,
+	reg *prometheus.Registry,
+	bkt objstore.Bucket,
+	httpBindAddr string,
+	httpTLSConfig string,
+	httpGracePeriod time.Duration,
+	interval time.Duration,
+	fetch func(context.Context) ([]*metadata.Meta, []block.GroupStats, error),
+) error {
+	httpProbe := prober.NewHTTP()
+	statusProber := prober.Combine(
+		httpProbe,
+		prober.NewInstrumentation(component.Bucket, logger, extprom.WrapRegistererWithPrefix("thanos_", reg)),
+	)
+
+	metrics := block.NewGroupStatsMetrics(reg)
+	lastRefresh := promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_bucket_inspect_last_successful_refresh_timestamp_seconds",
+		Help: "Timestamp of the last successful inspection of the bucket.",
+	})
+	refreshFailures := promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_bucket_inspect_refresh_failures_total",
+		Help: "Total number of failed inspections of the bucket.",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g.Add(func() error {
+		defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+		statusProber.Ready()
+
+		return runutil.Repeat(interval, ctx.Done(), func() error {
+			_, stats, err := fetch(ctx)
+			if err != nil {
+				// Keep exposing the last known statistics, the next inspection might succeed.
+				refreshFailures.Inc()
+				level.Warn(logger).Log("msg", "failed to inspect bucket", "err", err)
+				return nil
+			}
+			metrics.Update(stats)
+			lastRefresh.SetToCurrentTime()
+			level.Debug(logger).Log("msg", "inspected bucket", "groups", len(stats))
+			return nil
+		})
+	}, func(error) {
+		cancel()
+	})
+
+	srv := httpserver.New(logger, reg, component.Bucket, httpProbe,
+		httpserver.WithListen(httpBindAddr),
+		httpserver.WithGracePeriod(httpGracePeriod),
+		httpserver.WithTLSConfig(httpTLSConfig),
+		httpserver.WithLogLevels(logLevels),
+	)
+	g.Add(func() error {
+		statusProber.Healthy()
+
+		return srv.ListenAndServe()
+	}, func(err error) {
+		statusProber.NotReady(err)
+		defer statusProber.NotHealthy(err)
+
+		srv.Shutdown(err)
+	})
+
+	level.Info(logger).Log("msg", "starting bucket inspect exporter", "interval", interval)
+	return nil
+}
+
+func printInspectJSON(w io.Writer, blockMetas []*metadata.Meta, stats []block.GroupStats) error {
+	sort.Slice(blockMetas, func(i, j int) bool {
+		if blockMetas[i].MinTime != blockMetas[j].MinTime {
+			return blockMetas[i].MinTime < blockMetas[j].MinTime
+		}
+		return blockMetas[i].ULID.Compare(blockMetas[j].ULID) < 0
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Groups []block.GroupStats `json:"groups"`
+		Blocks []*metadata.Meta   `json:"blocks"`
+	}{Groups: stats, Blocks: blockMetas})
+}
+
 // registerBucketWeb exposes a web interface for the state of remote store like `pprof web`.
 func registerBucketWeb(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
 	cmd := app.Command("web", "Web interface for remote storage bucket.")
@@ -622,6 +912,7 @@ func registerBucketWeb(app extkingpin.AppClause, objStoreConfig *extflag.PathOrC
 			httpserver.WithListen(*httpBindAddr),
 			httpserver.WithGracePeriod(time.Duration(*httpGracePeriod)),
 			httpserver.WithTLSConfig(*httpTLSConfig),
+			httpserver.WithLogLevels(logLevels),
 		)
 
 		if tbc.webRoutePrefix == "" {
@@ -758,6 +1049,7 @@ func registerBucketReplicate(app extkingpin.AppClause, objStoreConfig *extflag.P
 	cmd := app.Command("replicate", fmt.Sprintf("Replicate data from one object storage to another. NOTE: Currently it works only with Thanos blocks (%v has to have Thanos metadata).", block.MetaFilename))
 	httpBindAddr, httpGracePeriod, httpTLSConfig := extkingpin.RegisterHTTPFlags(cmd)
 	toObjStoreConfig := extkingpin.RegisterCommonObjStoreFlags(cmd, "-to", false, "The object storage which replicate data to.")
+	attestationConfig := extkingpin.RegisterBlockAttestationFlags(cmd)
 
 	tbc := &bucketReplicateConfig{}
 	tbc.registerBucketReplicateFlag(cmd)
@@ -790,6 +1082,11 @@ func registerBucketReplicate(app extkingpin.AppClause, objStoreConfig *extflag.P
 			}
 		}
 
+		rewriteLabels, err := parseFlagLabels(tbc.rewriteLabels)
+		if err != nil {
+			return errors.Wrap(err, "parse rewrite labels")
+		}
+
 		blockIDs := make([]ulid.ULID, 0, len(*ids))
 		for _, id := range *ids {
 			bid, err := ulid.Parse(id)
@@ -812,11 +1109,14 @@ func registerBucketReplicate(app extkingpin.AppClause, objStoreConfig *extflag.P
 			tbc.compactions,
 			objStoreConfig,
 			toObjStoreConfig,
+			attestationConfig,
 			tbc.singleRun,
 			minTime,
 			maxTime,
 			blockIDs,
 			*ignoreMarkedForDeletion,
+			rewriteLabels,
+			tbc.waitInterval,
 		)
 	})
 }
@@ -827,10 +1127,12 @@ func registerBucketDownsample(app extkingpin.AppClause, objStoreConfig *extflag.
 
 	tbc := &bucketDownsampleConfig{}
 	tbc.registerBucketDownsampleFlag(cmd)
+	encryptionConfig := extkingpin.RegisterObjStoreEncryptionFlags(cmd)
+	attestationConfig := extkingpin.RegisterBlockAttestationFlags(cmd)
 
 	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ <-chan struct{}, _ bool) error {
 		return RunDownsample(g, logger, reg, *httpAddr, *httpTLSConfig, time.Duration(*httpGracePeriod), tbc.dataDir,
-			tbc.waitInterval, tbc.downsampleConcurrency, tbc.blockFilesConcurrency, objStoreConfig, component.Downsample, metadata.HashFunc(tbc.hashFunc))
+			tbc.waitInterval, tbc.downsampleConcurrency, tbc.blockFilesConcurrency, objStoreConfig, encryptionConfig, attestationConfig, component.Downsample, metadata.HashFunc(tbc.hashFunc), tbc.shardIndex, tbc.shardCount)
 	})
 }
 
@@ -920,6 +1222,22 @@ func registerBucketCleanup(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 			return errors.Wrap(err, "error cleaning blocks")
 		}
 
+		if tbc.deleteOrphanedObjects {
+			tenants, err := compact.ListTenants(ctx, insBkt)
+			if err != nil {
+				return err
+			}
+			objs, err := block.FindOrphanedObjects(ctx, insBkt, tenants, orphanIgnoredPrefixes(tbc.ignoredPrefixes)...)
+			if err != nil {
+				return errors.Wrap(err, "find orphaned objects")
+			}
+			deleted, err := block.DeleteOrphanedObjects(ctx, logger, insBkt, objs, tbc.deleteDelay)
+			if err != nil {
+				return errors.Wrap(err, "delete orphaned objects")
+			}
+			level.Info(logger).Log("msg", "deleted orphaned objects", "deleted", deleted, "orphaned", len(objs))
+		}
+
 		level.Info(logger).Log("msg", "cleanup done")
 		return nil
 	})
@@ -993,7 +1311,11 @@ func printBlockData(blockMetas []*metadata.Meta, selectorLabels labels.Labels, s
 		}
 		var labels []string
 		for _, key := range getKeysAlphabetically(blockMeta.Thanos.Labels) {
-			labels = append(labels, fmt.Sprintf("%s=%s", key, blockMeta.Thanos.Labels[key]))
+			name := key
+			if !prommodel.LabelName(key).IsValidLegacy() {
+				name = strconv.Quote(key)
+			}
+			labels = append(labels, fmt.Sprintf("%s=%s", name, blockMeta.Thanos.Labels[key]))
 		}
 
 		var line []string
@@ -1130,12 +1452,43 @@ func registerBucketMarkBlock(app extkingpin.AppClause, objStoreConfig *extflag.P
 			ids = append(ids, u)
 		}
 
-		if !tbc.removeMarker && tbc.details == "" {
+		var matchers labels.Selector
+		switch {
+		case len(ids) > 0 && tbc.matcherStrs != "":
+			return errors.New("--id and --matcher are mutually exclusive")
+		case len(ids) == 0 && tbc.matcherStrs == "":
+			return errors.New("either --id or --matcher is required")
+		case tbc.matcherStrs != "":
+			matchers, err = replicate.ParseFlagMatchers(tbc.matcherStrs)
+			if err != nil {
+				return errors.Wrap(err, "parse block label matchers")
+			}
+		}
+
+		if !tbc.removeMarker && !tbc.dryRun && tbc.details == "" {
 			return errors.Errorf("required flag --details not provided")
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		ctx, cancel := context.WithTimeout(context.Background(), tbc.timeout)
 		g.Add(func() error {
+			metas := map[ulid.ULID]*metadata.Meta{}
+			if len(matchers) > 0 {
+				selected, err := selectBlocksToMark(ctx, logger, insBkt, matchers, tbc)
+				if err != nil {
+					return err
+				}
+				metas = selected
+				ids = ids[:0]
+				for id := range metas {
+					ids = append(ids, id)
+				}
+				sort.Slice(ids, func(i, j int) bool { return ids[i].Compare(ids[j]) < 0 })
+			}
+
+			if tbc.dryRun {
+				return printMarkDiff(ctx, os.Stdout, insBkt, ids, metas, tbc.marker, tbc.removeMarker)
+			}
+
 			for _, id := range ids {
 				if tbc.removeMarker {
 					err := block.RemoveMark(ctx, logger, insBkt, id, promauto.With(nil).NewCounter(prometheus.CounterOpts{}), tbc.marker)
@@ -1161,7 +1514,7 @@ func registerBucketMarkBlock(app extkingpin.AppClause, objStoreConfig *extflag.P
 					return errors.Errorf("not supported marker %v", tbc.marker)
 				}
 			}
-			level.Info(logger).Log("msg", "marking done", "marker", tbc.marker, "IDs", strings.Join(tbc.blockIDs, ","))
+			level.Info(logger).Log("msg", "marking done", "marker", tbc.marker, "blocks", len(ids))
 			return nil
 		}, func(err error) {
 			cancel()
@@ -1170,6 +1523,83 @@ func registerBucketMarkBlock(app extkingpin.AppClause, objStoreConfig *extflag.P
 	})
 }
 
+// selectBlocksToMark returns the blocks matching the selection flags of the mark command.
+func selectBlocksToMark(ctx context.Context, logger log.Logger, bkt objstore.InstrumentedBucket, matchers labels.Selector, tbc *bucketMarkBlockConfig) (map[ulid.ULID]*metadata.Meta, error) {
+	baseBlockIDsFetcher := block.NewConcurrentLister(logger, bkt)
+	fetcher, err := block.NewMetaFetcher(logger, block.FetcherConcurrency, bkt, baseBlockIDsFetcher, "", nil, []block.MetadataFilter{
+		block.NewTimePartitionMetaFilter(*tbc.minTime, *tbc.maxTime),
+	})
+	if err != nil {
+		return nil, err
+	}
+	metas, _, err := fetcher.Fetch(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch metas")
+	}
+
+	resolutions := map[int64]struct{}{}
+	for _, r := range tbc.resolutions {
+		resolutions[r.Milliseconds()] = struct{}{}
+	}
+	levels := map[int]struct{}{}
+	for _, l := range tbc.compactionLevels {
+		levels[l] = struct{}{}
+	}
+	for id, m := range metas {
+		if !matchers.Matches(labels.FromMap(m.Thanos.Labels)) {
+			delete(metas, id)
+			continue
+		}
+		if _, ok := resolutions[m.Thanos.Downsample.Resolution]; len(resolutions) > 0 && !ok {
+			delete(metas, id)
+			continue
+		}
+		if _, ok := levels[m.Compaction.Level]; len(levels) > 0 && !ok {
+			delete(metas, id)
+		}
+	}
+	return metas, nil
+}
+
+// printMarkDiff prints, for every block, whether it would be marked ("+"), unmarked ("-") or is left
+// unchanged ("=") because it is already in the requested state.
+func printMarkDiff(ctx context.Context, w io.Writer, bkt objstore.Bucket, ids []ulid.ULID, metas map[ulid.ULID]*metadata.Meta, marker string, remove bool) error {
+	var changed int
+	for _, id := range ids {
+		marked, err := bkt.Exists(ctx, path.Join(id.String(), marker))
+		if err != nil {
+			return errors.Wrapf(err, "check %v of %v", marker, id)
+		}
+
+		op := "="
+		switch {
+		case remove && marked:
+			op = "-"
+		case !remove && !marked:
+			op = "+"
+		}
+		if op != "=" {
+			changed++
+		}
+
+		line := fmt.Sprintf("%s %s", op, id)
+		if m, ok := metas[id]; ok {
+			line += fmt.Sprintf(" %s %s - %s level=%d resolution=%s",
+				labels.FromMap(m.Thanos.Labels),
+				time.UnixMilli(m.MinTime).UTC().Format(time.RFC3339),
+				time.UnixMilli(m.MaxTime).UTC().Format(time.RFC3339),
+				m.Compaction.Level,
+				time.Duration(m.Thanos.Downsample.Resolution)*time.Millisecond,
+			)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d of %d blocks would change %s\n", changed, len(ids), marker)
+	return err
+}
+
 func registerBucketRewrite(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
 	cmd := app.Command(component.Rewrite.String(), "Rewrite chosen blocks in the bucket, while deleting or modifying series "+
 		"Resulted block has modified stats in meta.json. Additionally compaction.sources are altered to not confuse readers of meta.json. "+
@@ -1353,6 +1783,136 @@ func registerBucketRewrite(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 	})
 }
 
+func registerBucketRelabel(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
+	cmd := app.Command("relabel", "Relabel the external labels of the blocks in the bucket, e.g. to rename a tenant or fix the labels of a producer. "+
+		"Every changed block is replaced by a copy with the new labels and a new ID: the meta.json of the copy is uploaded last, then the block is marked for no compaction and for deletion. "+
+		"Blocks dropped by the relabel configs are left as they are. Running it again resumes an interrupted relabeling. "+
+		"NOTE: The compactor has to be stopped while doing this operation. Readers see both the block and its copy until the block is deleted.")
+
+	tbc := &bucketRelabelConfig{}
+	tbc.registerBucketRelabelFlag(cmd)
+
+	relabelConf := extflag.RegisterPathOrContent(cmd, "relabel-config", "YAML file that contains relabel configs applied to the external labels of the blocks.", extflag.WithEnvSubstitution(), extflag.WithRequired())
+	encryptionConfig := extkingpin.RegisterObjStoreEncryptionFlags(cmd)
+	attestationConfig := extkingpin.RegisterBlockAttestationFlags(cmd)
+	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		confContentYaml, err := objStoreConfig.Content()
+		if err != nil {
+			return err
+		}
+
+		relabelContentYaml, err := relabelConf.Content()
+		if err != nil {
+			return errors.Wrap(err, "get content of relabel configuration")
+		}
+		relabelConfig, err := block.ParseRelabelConfig(relabelContentYaml, nil)
+		if err != nil {
+			return err
+		}
+
+		ids := map[ulid.ULID]struct{}{}
+		for _, id := range tbc.blockIDs {
+			u, err := ulid.Parse(id)
+			if err != nil {
+				return errors.Errorf("id is not a valid block ULID, got: %v", id)
+			}
+			ids[u] = struct{}{}
+		}
+
+		bkt, err := client.NewBucket(logger, confContentYaml, component.Rewrite.String(), nil)
+		if err != nil {
+			return err
+		}
+		// Files are copied through the decryption and encryption of the bucket, and copies are attested again.
+		encryptionConfContentYaml, err := encryptionConfig.Content()
+		if err != nil {
+			return err
+		}
+		bkt, err = encryption.WrapWithConfig(logger, bkt, encryptionConfContentYaml)
+		if err != nil {
+			return err
+		}
+		attestationConfContentYaml, err := attestationConfig.Content()
+		if err != nil {
+			return err
+		}
+		attestationConf, err := attestation.ParseConfig(attestationConfContentYaml)
+		if err != nil {
+			return err
+		}
+		bkt = attestation.WrapWithConfig(logger, bkt, attestationConf)
+		insBkt := objstoretracing.WrapWithTraces(objstore.WrapWithMetrics(bkt, extprom.WrapRegistererWithPrefix("thanos_", reg), bkt.Name()))
+
+		// Files are copied on the server side when the bucket supports it. Encrypted files stay valid under the new
+		// block, but server side copies bypass the attesting bucket.
+		copier, err := objstoreutil.NewServerSideCopier(context.Background(), logger, confContentYaml, component.Rewrite.String())
+		if err != nil {
+			return err
+		}
+		if copier != nil && attestationConf != nil && attestationConf.SigningKey != "" {
+			level.Info(logger).Log("msg", "not copying files on the server side, as copies are attested")
+			runutil.CloseWithLogOnErr(logger, copier, "server side copier")
+			copier = nil
+		}
+
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		defer runutil.CloseWithLogOnErr(logger, insBkt, "bucket client")
+		if copier != nil {
+			defer runutil.CloseWithLogOnErr(logger, copier, "server side copier")
+		}
+
+		// Blocks marked for deletion, like the blocks already relabeled, are not relabeled.
+		fetcher, err := block.NewMetaFetcher(logger, tbc.blockSyncConcurrency, insBkt, block.NewConcurrentLister(logger, insBkt), "", extprom.WrapRegistererWithPrefix(extpromPrefix, reg), []block.MetadataFilter{
+			block.NewIgnoreDeletionMarkFilter(logger, insBkt, 0, tbc.blockSyncConcurrency),
+		})
+		if err != nil {
+			return errors.Wrap(err, "create meta fetcher")
+		}
+
+		ctx := context.Background()
+		metas, _, err := fetcher.Fetch(ctx)
+		if err != nil {
+			return errors.Wrap(err, "fetch metas")
+		}
+		metaList := make([]*metadata.Meta, 0, len(metas))
+		for id, meta := range metas {
+			if _, ok := ids[id]; len(ids) > 0 && !ok {
+				continue
+			}
+			metaList = append(metaList, meta)
+		}
+		sort.Slice(metaList, func(i, j int) bool {
+			return metaList[i].ULID.Compare(metaList[j].ULID) < 0
+		})
+
+		level.Warn(logger).Log("msg", "GLOBAL COMPACTOR SHOULD __NOT__ BE RUNNING ON THE SAME BUCKET")
+
+		stubCounter := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+		var relabeled int
+		for _, meta := range metaList {
+			lset, ok := block.RelabeledExternalLabels(meta, relabelConfig)
+			if !ok {
+				continue
+			}
+			if tbc.dryRun {
+				level.Info(logger).Log("msg", "would relabel block", "block", meta.ULID, "labels", labels.FromMap(meta.Thanos.Labels).String(), "new_labels", lset.String())
+				relabeled++
+				continue
+			}
+			newID, err := block.RelabelExternalLabels(ctx, logger, insBkt, copier, meta.ULID, relabelConfig, stubCounter, stubCounter)
+			if err != nil {
+				return errors.Wrapf(err, "relabel block %s", meta.ULID)
+			}
+			level.Info(logger).Log("msg", "relabeled block", "block", meta.ULID, "new", newID, "new_labels", lset.String())
+			relabeled++
+		}
+		level.Info(logger).Log("msg", "relabel done", "relabeled", relabeled, "blocks", len(metaList), "dry_run", tbc.dryRun)
+		return nil
+	})
+}
+
 func registerBucketRetention(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
 	var (
 		retentionRaw, retentionFiveMin, retentionOneHr prommodel.Duration
@@ -1526,3 +2086,320 @@ func registerBucketUploadBlocks(app extkingpin.AppClause, objStoreConfig *extfla
 		return nil
 	})
 }
+
+func registerBucketRulesBackfill(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
+	cmd := app.Command("rules-backfill", "Backfill recording rules over historical data by evaluating them against a Querier and uploading the results as blocks to the object storage. Alerting rules are ignored. Recording rules depending on other new recording rules are evaluated without their results, so backfill those first.")
+
+	ruleFiles := cmd.Flag("rule-file", "Rule files to backfill. Can be in glob format (repeated).").Required().Strings()
+	queryURL := cmd.Flag("query", "URL of the Thanos Querier HTTP API used to evaluate the rules.").Required().URL()
+	start := model.TimeOrDuration(cmd.Flag("start", "Start of the time range to backfill. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y.").
+		Required())
+	end := model.TimeOrDuration(cmd.Flag("end", "End of the time range to backfill. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y. Usually the time the rules were first evaluated by the Ruler.").
+		Required())
+	evalInterval := extkingpin.ModelDuration(cmd.Flag("eval-interval", "The default evaluation interval of rule groups without an interval.").Default("1m"))
+	blockDuration := extkingpin.ModelDuration(cmd.Flag("block-duration", "Duration of the uploaded blocks.").Default("2h"))
+	labelStrs := cmd.Flag("label", "External labels of the uploaded blocks (repeated). They have to match the labels of the Ruler evaluating the rules, so that the backfilled data is compacted together with the evaluated one.").
+		PlaceHolder("key=\"value\"").Strings()
+	tmpDir := cmd.Flag("tmp-dir", "Directory to write blocks to before uploading them.").Default(os.TempDir()).String()
+	partialResponse := cmd.Flag("query.partial-response", "Allow partial responses from the Querier for rule groups with the warn partial response strategy.").Default("false").Bool()
+
+	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		lset, err := parseFlagLabels(*labelStrs)
+		if err != nil {
+			return errors.Wrap(err, "unable to parse external labels")
+		}
+
+		var files []string
+		for _, pat := range *ruleFiles {
+			fs, err := filepath.Glob(pat)
+			if err != nil {
+				return errors.Wrapf(err, "retrieving rule files failed, pattern %s", pat)
+			}
+			files = append(files, fs...)
+		}
+		if len(files) == 0 {
+			return errors.New("no rule files found")
+		}
+
+		confContentYaml, err := objStoreConfig.Content()
+		if err != nil {
+			return errors.Wrap(err, "unable to parse objstore config")
+		}
+
+		bkt, err := client.NewBucket(logger, confContentYaml, component.Rule.String(), nil)
+		if err != nil {
+			return errors.Wrap(err, "unable to create bucket")
+		}
+		insBkt := objstoretracing.WrapWithTraces(objstore.WrapWithMetrics(bkt, extprom.WrapRegistererWithPrefix("thanos_", reg), bkt.Name()))
+
+		promClient := promclient.NewDefaultClient()
+		query := func(ctx context.Context, q string, start, end time.Time, step time.Duration, strategy storepb.PartialResponseStrategy) (prommodel.Matrix, error) {
+			m, warns, _, err := promClient.QueryRange(ctx, *queryURL, q, timestamp.FromTime(start), timestamp.FromTime(end), int64(step/time.Second), promclient.QueryOptions{
+				Deduplicate:             true,
+				PartialResponseStrategy: partialResponseStrategy(strategy, *partialResponse),
+			})
+			if err != nil {
+				return nil, err
+			}
+			if len(warns) > 0 {
+				level.Warn(logger).Log("msg", "rule query returned warnings", "query", q, "warnings", strings.Join(warns, ", "))
+			}
+			return m, nil
+		}
+
+		b, err := thanosrules.NewBackfiller(logger, insBkt, query, thanosrules.BackfillOptions{
+			Start:          timestamp.Time(start.PrometheusTimestamp()),
+			End:            timestamp.Time(end.PrometheusTimestamp()),
+			EvalInterval:   time.Duration(*evalInterval),
+			BlockDuration:  time.Duration(*blockDuration),
+			ExternalLabels: lset,
+			TmpDir:         *tmpDir,
+		})
+		if err != nil {
+			runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+
+			metas, err := b.Backfill(ctx, files)
+			level.Info(logger).Log("msg", "backfilled recording rules", "blocks", len(metas))
+			return err
+		}, func(error) {
+			cancel()
+		})
+		return nil
+	})
+}
+
+func registerBucketImport(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
+	cmd := app.Command("import", "Import metrics exported from other systems as OpenMetrics or CSV into time aligned blocks and upload them to the object storage. Samples of every series have to be sorted by time.")
+
+	inputFile := cmd.Flag("input", "File to import.").Required().ExistingFile()
+	format := cmd.Flag("format", "Format of the input. With csv, the header has to contain a \"timestamp\" column, in Unix milliseconds or RFC3339, and a \"value\" column. Every other column is a label, \"__name__\" being the metric name.").
+		Default(string(importer.FormatOpenMetrics)).Enum(string(importer.FormatOpenMetrics), string(importer.FormatCSV))
+	blockDuration := extkingpin.ModelDuration(cmd.Flag("block-duration", "Maximum duration of the uploaded blocks. Rounded down to a compaction range, e.g. 2h, 8h, 2d or 14d.").Default("2h"))
+	labelStrs := cmd.Flag("label", "External labels of the uploaded blocks (repeated).").PlaceHolder("key=\"value\"").Strings()
+	tmpDir := cmd.Flag("tmp-dir", "Directory to write blocks to before uploading them.").Default(os.TempDir()).String()
+
+	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		lset, err := parseFlagLabels(*labelStrs)
+		if err != nil {
+			return errors.Wrap(err, "unable to parse external labels")
+		}
+
+		input, err := os.ReadFile(*inputFile)
+		if err != nil {
+			return errors.Wrap(err, "read input")
+		}
+
+		confContentYaml, err := objStoreConfig.Content()
+		if err != nil {
+			return errors.Wrap(err, "unable to parse objstore config")
+		}
+
+		bkt, err := client.NewBucket(logger, confContentYaml, component.Bucket.String(), nil)
+		if err != nil {
+			return errors.Wrap(err, "unable to create bucket")
+		}
+		insBkt := objstoretracing.WrapWithTraces(objstore.WrapWithMetrics(bkt, extprom.WrapRegistererWithPrefix("thanos_", reg), bkt.Name()))
+
+		i, err := importer.New(logger, insBkt, importer.Options{
+			Format:         importer.Format(*format),
+			BlockDuration:  time.Duration(*blockDuration),
+			ExternalLabels: lset,
+			TmpDir:         *tmpDir,
+		})
+		if err != nil {
+			runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+
+			metas, err := i.Import(ctx, input)
+			level.Info(logger).Log("msg", "imported blocks", "blocks", len(metas))
+			return err
+		}, func(error) {
+			cancel()
+		})
+		return nil
+	})
+}
+
+func registerBucketGenerate(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
+	cmd := app.Command("generate", "Generate synthetic blocks and upload them to the object storage, e.g. to benchmark compaction, downsampling or store gateways at scale without production data.")
+
+	series := cmd.Flag("series", "Number of series scraped at any time.").Default("1000").Int()
+	metrics := cmd.Flag("metrics", "Number of metric names the series are spread over. Every target exposes all the metrics.").Default("100").Int()
+	extraLabels := cmd.Flag("extra-labels", "Number of additional labels of every series, with a few values each.").Default("2").Int()
+	scrapeInterval := extkingpin.ModelDuration(cmd.Flag("scrape-interval", "Interval between the raw samples of a series.").Default("15s"))
+	churnRatio := cmd.Flag("churn-ratio", "Ratio of the targets whose series are replaced by new ones every --churn-interval, between 0 and 1.").Default("0").Float64()
+	churnInterval := extkingpin.ModelDuration(cmd.Flag("churn-interval", "Interval at which the series of churning targets are replaced.").Default("1h"))
+	nativeHistograms := cmd.Flag("native-histograms", "Ratio of the metrics that are native histograms, between 0 and 1.").Default("0").Float64()
+	resolution := cmd.Flag("resolution", "Downsampling resolution of the uploaded blocks. Raw blocks are generated and downsampled if not 0s.").
+		Default("0s").HintAction(listResLevel).Duration()
+	minTime := model.TimeOrDuration(cmd.Flag("min-time", "Start of the time range of the generated samples. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y.").
+		Default("-1d"))
+	maxTime := model.TimeOrDuration(cmd.Flag("max-time", "End of the time range of the generated samples. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y.").
+		Default("0s"))
+	blockDuration := extkingpin.ModelDuration(cmd.Flag("block-duration", "Maximum duration of the uploaded blocks. Rounded down to a compaction range, e.g. 2h, 8h, 2d or 14d.").Default("2h"))
+	labelStrs := cmd.Flag("label", "External labels of the uploaded blocks (repeated).").PlaceHolder("key=\"value\"").Strings()
+	seed := cmd.Flag("seed", "Seed of the generated sample values.").Default("0").Int64()
+	tmpDir := cmd.Flag("tmp-dir", "Directory to write blocks to before uploading them.").Default(os.TempDir()).String()
+
+	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		lset, err := parseFlagLabels(*labelStrs)
+		if err != nil {
+			return errors.Wrap(err, "unable to parse external labels")
+		}
+
+		confContentYaml, err := objStoreConfig.Content()
+		if err != nil {
+			return errors.Wrap(err, "unable to parse objstore config")
+		}
+
+		bkt, err := client.NewBucket(logger, confContentYaml, component.Bucket.String(), nil)
+		if err != nil {
+			return errors.Wrap(err, "unable to create bucket")
+		}
+		insBkt := objstoretracing.WrapWithTraces(objstore.WrapWithMetrics(bkt, extprom.WrapRegistererWithPrefix("thanos_", reg), bkt.Name()))
+
+		gen, err := blockgen.New(logger, insBkt, blockgen.Options{
+			Series:           *series,
+			Metrics:          *metrics,
+			ExtraLabels:      *extraLabels,
+			ScrapeInterval:   time.Duration(*scrapeInterval),
+			ChurnRatio:       *churnRatio,
+			ChurnInterval:    time.Duration(*churnInterval),
+			NativeHistograms: *nativeHistograms,
+			Resolution:       resolution.Milliseconds(),
+			BlockDuration:    time.Duration(*blockDuration),
+			MinTime:          timestamp.Time(minTime.PrometheusTimestamp()),
+			MaxTime:          timestamp.Time(maxTime.PrometheusTimestamp()),
+			ExternalLabels:   lset,
+			TmpDir:           *tmpDir,
+			Seed:             *seed,
+		})
+		if err != nil {
+			runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+
+			metas, err := gen.Generate(ctx)
+			level.Info(logger).Log("msg", "generated blocks", "blocks", len(metas))
+			return err
+		}, func(error) {
+			cancel()
+		})
+		return nil
+	})
+}
+
+func registerBucketExport(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
+	cmd := app.Command("export", "Export the series of raw blocks from the object storage as OpenMetrics or to a remote write endpoint, e.g. to migrate part of the data to another system. External labels of the blocks are added to the series. Native histograms are not exported.")
+
+	matcherStrs := cmd.Flag("matcher", "Only export blocks whose external labels match this matcher. All Prometheus matchers are supported, including =, !=, =~ and !~.").String()
+	seriesSelector := cmd.Flag("series-selector", "Only export series matching this selector, e.g. '{job=\"node\"}'. All series if not set.").String()
+	minTime := model.TimeOrDuration(cmd.Flag("min-time", "Start of time range limit to export. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y.").
+		Default("0000-01-01T00:00:00Z"))
+	maxTime := model.TimeOrDuration(cmd.Flag("max-time", "End of time range limit to export. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y.").
+		Default("9999-12-31T23:59:59Z"))
+	output := cmd.Flag("output", "File to write the OpenMetrics export to, - for stdout. Ignored with --remote-write.url.").Default("-").String()
+	remoteWriteURL := cmd.Flag("remote-write.url", "Remote write endpoint to send the series to instead of writing OpenMetrics.").URL()
+	remoteWriteTimeout := extkingpin.ModelDuration(cmd.Flag("remote-write.timeout", "Timeout of a remote write request.").Default("30s"))
+	remoteWriteBatchSize := cmd.Flag("remote-write.batch-size", "Maximum number of samples sent in a remote write request.").Default("2000").Int()
+	tmpDir := cmd.Flag("tmp-dir", "Directory to download blocks to.").Default(os.TempDir()).String()
+
+	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		blockMatchers, err := replicate.ParseFlagMatchers(*matcherStrs)
+		if err != nil {
+			return errors.Wrap(err, "parse block label matchers")
+		}
+		var seriesMatchers []*labels.Matcher
+		if *seriesSelector != "" {
+			seriesMatchers, err = parser.ParseMetricSelector(*seriesSelector)
+			if err != nil {
+				return errors.Wrap(err, "parse series selector")
+			}
+		}
+		if *remoteWriteBatchSize <= 0 {
+			return errors.New("remote write batch size has to be positive")
+		}
+
+		var (
+			w       exporter.SeriesWriter
+			closeFn = func() error { return nil }
+		)
+		switch {
+		case *remoteWriteURL != nil:
+			c, err := remote.NewWriteClient("export", &remote.ClientConfig{
+				URL:     &config_util.URL{URL: *remoteWriteURL},
+				Timeout: *remoteWriteTimeout,
+			})
+			if err != nil {
+				return errors.Wrap(err, "create remote write client")
+			}
+			w = exporter.NewRemoteWriter(c, *remoteWriteBatchSize)
+		case *output == "-":
+			w = exporter.NewOpenMetricsWriter(os.Stdout)
+		default:
+			f, err := os.Create(*output)
+			if err != nil {
+				return errors.Wrap(err, "create output file")
+			}
+			w, closeFn = exporter.NewOpenMetricsWriter(f), f.Close
+		}
+
+		confContentYaml, err := objStoreConfig.Content()
+		if err != nil {
+			return errors.Wrap(err, "unable to parse objstore config")
+		}
+
+		bkt, err := client.NewBucket(logger, confContentYaml, component.Bucket.String(), nil)
+		if err != nil {
+			return errors.Wrap(err, "unable to create bucket")
+		}
+		insBkt := objstoretracing.WrapWithTraces(objstore.WrapWithMetrics(bkt, extprom.WrapRegistererWithPrefix("thanos_", reg), bkt.Name()))
+
+		e := exporter.New(logger, insBkt, exporter.Options{
+			BlockMatchers:  blockMatchers,
+			SeriesMatchers: seriesMatchers,
+			MinTime:        *minTime,
+			MaxTime:        *maxTime,
+			TmpDir:         *tmpDir,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+			stats, err := e.Export(ctx, w)
+			level.Info(logger).Log("msg", "exported blocks", "blocks", stats.Blocks, "series", stats.Series, "samples", stats.Samples, "skipped_histogram_samples", stats.SkippedHistogramSamples)
+			if err != nil {
+				_ = closeFn()
+				return err
+			}
+			return errors.Wrap(closeFn(), "close output")
+		}, func(error) {
+			cancel()
+		})
+		return nil
+	})
+}
+
+// partialResponseStrategy returns the strategy used to query the rules of a group. Partial responses
+// are only allowed when explicitly enabled, as gaps in backfilled data are not re-evaluated later.
+func partialResponseStrategy(s storepb.PartialResponseStrategy, allowPartialResponse bool) storepb.PartialResponseStrategy {
+	if s == storepb.PartialResponseStrategy_WARN && allowPartialResponse {
+		return storepb.PartialResponseStrategy_WARN
+	}
+	return storepb.PartialResponseStrategy_ABORT
+}
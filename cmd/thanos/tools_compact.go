@@ -0,0 +1,154 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+
+	extflag "github.com/efficientgo/tools/extkingpin"
+	"github.com/go-kit/log"
+	"github.com/oklog/run"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/compact"
+	"github.com/thanos-io/thanos/pkg/extkingpin"
+)
+
+// planBenchConfig is a configuration of the grouping and planning of compactions, with the same options as the
+// compactor flags.
+type planBenchConfig struct {
+	Name string `yaml:"name"`
+	// MaxCompactionLevel is the maximum compaction level, the default level if 0.
+	MaxCompactionLevel int               `yaml:"max_compaction_level"`
+	MaxPlanBlocks      int               `yaml:"max_plan_blocks"`
+	MinPlanSizeBytes   int64             `yaml:"min_plan_size_bytes"`
+	MinPlanMaxSkips    int               `yaml:"min_plan_max_skips"`
+	MergeIgnoreLabels  []string          `yaml:"merge_ignore_labels"`
+	MergeSetLabels     map[string]string `yaml:"merge_set_labels"`
+}
+
+var planBenchColumns = []string{"NAME", "GROUPS", "COMPACTIONS", "ITERATIONS", "COMPACTED-BLOCKS", "BLOCKS", "READ-BYTES", "WRITTEN-BYTES", "UNKNOWN-SIZE-BLOCKS", "PLANNING-DURATION"}
+
+func registerCompactPlanBench(app extkingpin.AppClause) {
+	cmd := app.Command("compact-plan-bench", "Replay the blocks of a compactor state snapshot or of the JSON output of 'tools bucket inspect' through grouping and planning configurations, and report the compactions, compactor iterations and estimated bytes each would take. Nothing is read from or written to the object storage.")
+
+	snapshot := cmd.Flag("snapshot", "Compactor state snapshot, e.g. compactor-state.json in the data directory of the compactor, or JSON output of 'tools bucket inspect'.").Required().ExistingFile()
+	configs := extflag.RegisterPathOrContent(cmd, "config", "YAML file with the list of grouping and planning configurations to compare. The default compactor configuration is used if not set.")
+	output := cmd.Flag("output", "Output format for result. Currently supports table, csv, tsv, json.").Default("table").Enum(outputTypes...)
+
+	cmd.Setup(func(g *run.Group, _ log.Logger, _ *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		confContentYaml, err := configs.Content()
+		if err != nil {
+			return errors.Wrap(err, "read configurations")
+		}
+		confs := []planBenchConfig{{Name: "default"}}
+		if len(confContentYaml) > 0 {
+			confs = nil
+			if err := yaml.UnmarshalStrict(confContentYaml, &confs); err != nil {
+				return errors.Wrap(err, "parse configurations")
+			}
+		}
+
+		bench, err := compact.ReadPlanBenchmark(*snapshot)
+		if err != nil {
+			return err
+		}
+		benchConfs := make([]compact.PlanBenchmarkConfig, 0, len(confs))
+		for _, c := range confs {
+			bc, err := c.benchmarkConfig(bench)
+			if err != nil {
+				return errors.Wrapf(err, "configuration %s", c.Name)
+			}
+			benchConfs = append(benchConfs, bc)
+		}
+		results, err := bench.Run(context.Background(), benchConfs...)
+		if err != nil {
+			return err
+		}
+
+		var opPrinter tablePrinter
+		switch outputType(*output) {
+		case TABLE:
+			opPrinter = printTable
+		case TSV:
+			opPrinter = printTSV
+		case CSV:
+			opPrinter = printCSV
+		case JSON:
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(results)
+		}
+		t := Table{Header: planBenchColumns}
+		for _, r := range results {
+			t.Lines = append(t.Lines, []string{
+				r.Name,
+				strconv.Itoa(r.Groups),
+				strconv.Itoa(r.Compactions),
+				strconv.Itoa(r.Iterations),
+				strconv.Itoa(r.CompactedBlocks),
+				strconv.Itoa(r.Blocks),
+				strconv.FormatInt(r.ReadBytes, 10),
+				strconv.FormatInt(r.WrittenBytes, 10),
+				strconv.Itoa(r.UnknownSizeBlocks),
+				r.PlanningDuration.String(),
+			})
+		}
+		return opPrinter(os.Stdout, t)
+	})
+}
+
+// benchmarkConfig returns the grouper and planner of the configuration, built as the compactor builds them. The
+// planner does not filter plans by index size, which requires reading the indexes of the blocks.
+func (c planBenchConfig) benchmarkConfig(bench *compact.PlanBenchmark) (compact.PlanBenchmarkConfig, error) {
+	// Planners log the plans they change, which is too verbose for simulated compactions.
+	logger := log.NewNopLogger()
+
+	maxLevel := c.MaxCompactionLevel
+	if maxLevel == 0 {
+		maxLevel = compactions.maxLevel()
+	}
+	levels, err := compactions.levels(maxLevel)
+	if err != nil {
+		return compact.PlanBenchmarkConfig{}, errors.Wrap(err, "get compaction levels")
+	}
+
+	stubCounter := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+	grouper := compact.NewDefaultGrouper(logger, nil, false, false, nil, stubCounter, stubCounter, stubCounter, "", 1, 1)
+	if len(c.MergeIgnoreLabels) > 0 {
+		p, err := compact.NewLabelMergePolicy(c.MergeIgnoreLabels, labels.FromMap(c.MergeSetLabels))
+		if err != nil {
+			return compact.PlanBenchmarkConfig{}, errors.Wrap(err, "create label merge policy")
+		}
+		grouper.SetLabelMergePolicy(p)
+	}
+
+	if err := compact.ValidateMaxPlanBlocks(c.MaxPlanBlocks); err != nil {
+		return compact.PlanBenchmarkConfig{}, err
+	}
+	tsdbPlanner := compact.NewPlanner(logger, levels, bench.NoCompactMarkFilter())
+	var planner compact.Planner = tsdbPlanner
+	if c.MinPlanSizeBytes > 0 {
+		maxSkips := c.MinPlanMaxSkips
+		if maxSkips == 0 {
+			maxSkips = 10
+		}
+		planner = compact.WithSmallPlanFilter(planner, tsdbPlanner, logger, c.MinPlanSizeBytes, maxSkips, stubCounter)
+	}
+	if c.MaxPlanBlocks > 0 {
+		planner = compact.WithMaxPlanBlocksFilter(planner, logger, c.MaxPlanBlocks)
+	}
+	return compact.PlanBenchmarkConfig{Name: c.Name, Grouper: grouper, Planner: planner}, nil
+}
@@ -4,13 +4,23 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/objstore"
 
 	"github.com/efficientgo/core/testutil"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	thanosmodel "github.com/thanos-io/thanos/pkg/model"
 )
 
 func Test_CheckRules(t *testing.T) {
@@ -57,3 +67,58 @@ func Test_CheckRules_Glob(t *testing.T) {
 	files = &[]string{filename}
 	testutil.NotOk(t, checkRulesFiles(logger, files), "expected err for file %s", files)
 }
+
+func Test_SelectBlocksToMark(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+
+	upload := func(id uint64, tenant string, level int, resolution, minTime, maxTime int64) ulid.ULID {
+		m := metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{
+				ULID:       ulid.MustNew(id, nil),
+				MinTime:    minTime,
+				MaxTime:    maxTime,
+				Version:    metadata.TSDBVersion1,
+				Compaction: tsdb.BlockMetaCompaction{Level: level},
+			},
+			Thanos: metadata.Thanos{
+				Version:    metadata.ThanosVersion1,
+				Labels:     map[string]string{"tenant": tenant},
+				Downsample: metadata.ThanosDownsample{Resolution: resolution},
+			},
+		}
+		var buf bytes.Buffer
+		testutil.Ok(t, m.Write(&buf))
+		testutil.Ok(t, bkt.Upload(ctx, path.Join(m.ULID.String(), metadata.MetaFilename), &buf))
+		return m.ULID
+	}
+	a1 := upload(1, "a", 1, 0, 0, 1000)
+	a2 := upload(2, "a", 2, 0, 1000, 2000)
+	a3 := upload(3, "a", 2, 300000, 0, 2000)
+	upload(4, "b", 1, 0, 0, 1000)
+
+	minTime, maxTime := thanosmodel.TimeOrDurationValue{}, thanosmodel.TimeOrDurationValue{}
+	testutil.Ok(t, minTime.Set("0000-01-01T00:00:00Z"))
+	testutil.Ok(t, maxTime.Set("9999-12-31T23:59:59Z"))
+	tbc := &bucketMarkBlockConfig{minTime: &minTime, maxTime: &maxTime}
+	matchers := labels.Selector{labels.MustNewMatcher(labels.MatchEqual, "tenant", "a")}
+
+	insBkt := objstore.WithNoopInstr(bkt)
+	metas, err := selectBlocksToMark(ctx, log.NewNopLogger(), insBkt, matchers, tbc)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, len(metas))
+
+	tbc.compactionLevels = []int{2}
+	tbc.resolutions = []time.Duration{0}
+	metas, err = selectBlocksToMark(ctx, log.NewNopLogger(), insBkt, matchers, tbc)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(metas))
+	_, ok := metas[a2]
+	testutil.Assert(t, ok, "expected block %v to be selected", a2)
+
+	// The diff tells which blocks already have the mark.
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(a1.String(), metadata.NoCompactMarkFilename), bytes.NewReader(nil)))
+	var out bytes.Buffer
+	testutil.Ok(t, printMarkDiff(ctx, &out, bkt, []ulid.ULID{a1, a2, a3}, nil, metadata.NoCompactMarkFilename, false))
+	testutil.Equals(t, "= "+a1.String()+"\n+ "+a2.String()+"\n+ "+a3.String()+"\n2 of 3 blocks would change no-compact-mark.json\n", out.String())
+}
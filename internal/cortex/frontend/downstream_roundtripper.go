@@ -9,6 +9,8 @@ import (
 	"path"
 
 	"github.com/opentracing/opentracing-go"
+
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 )
 
 // RoundTripper that forwards requests to downstream URL.
@@ -36,6 +38,11 @@ func (d downstreamRoundTripper) RoundTrip(r *http.Request) (*http.Response, erro
 		}
 	}
 
+	// Pass the request ID to the downstream querier, so that all requests split from a query have the ID of the query.
+	if reqID, ok := middleware.RequestIDFromContext(r.Context()); ok && r.Header.Get(middleware.RequestIDHeader) == "" {
+		r.Header.Set(middleware.RequestIDHeader, reqID)
+	}
+
 	r.URL.Scheme = d.downstreamURL.Scheme
 	r.URL.Host = d.downstreamURL.Host
 	r.URL.Path = path.Join(d.downstreamURL.Path, r.URL.Path)
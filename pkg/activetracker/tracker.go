@@ -0,0 +1,147 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package activetracker records the operations in flight, like queries or compactions, in a file, so that the
+// operations that were in flight when the process was killed, e.g. due to running out of memory, are logged
+// on the next start. It is like the active query tracker of the Prometheus PromQL engine.
+package activetracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
+)
+
+// entrySize is the size of the slot of each operation in the file.
+const entrySize = 1024
+
+type entry struct {
+	Operation string `json:"operation"`
+	RequestID string `json:"request_id,omitempty"`
+	Timestamp int64  `json:"timestamp_sec"`
+}
+
+// Tracker records up to a maximum number of operations in flight in a file. A nil Tracker records nothing.
+type Tracker struct {
+	logger log.Logger
+	f      *os.File
+
+	mtx  sync.Mutex
+	free []int
+}
+
+// New returns a tracker recording up to maxConcurrent operations in flight in the file of the directory. It
+// logs the operations recorded in the file by the previous run first, which did not finish.
+func New(logger log.Logger, dir, file string, maxConcurrent int) (*Tracker, error) {
+	if maxConcurrent <= 0 {
+		return nil, errors.Errorf("invalid number of concurrent operations %d", maxConcurrent)
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, errors.Wrap(err, "create active tracker directory")
+	}
+	path := filepath.Join(dir, file)
+	logUnfinished(logger, path)
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0640)
+	if err != nil {
+		return nil, errors.Wrap(err, "open active tracker file")
+	}
+	if err := f.Truncate(int64(maxConcurrent * entrySize)); err != nil {
+		_ = f.Close()
+		return nil, errors.Wrap(err, "size active tracker file")
+	}
+
+	free := make([]int, 0, maxConcurrent)
+	for i := maxConcurrent - 1; i >= 0; i-- {
+		free = append(free, i)
+	}
+	return &Tracker{logger: logger, f: f, free: free}, nil
+}
+
+func logUnfinished(logger log.Logger, path string) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		level.Warn(logger).Log("msg", "failed to read operations of the last run", "file", path, "err", err)
+		return
+	}
+	for off := 0; off+entrySize <= len(b); off += entrySize {
+		slot := bytes.TrimRight(b[off:off+entrySize], "\x00")
+		if len(slot) == 0 {
+			continue
+		}
+		var e entry
+		if err := json.Unmarshal(slot, &e); err != nil {
+			continue
+		}
+		level.Warn(logger).Log("msg", "operation did not finish in the last run", "operation", e.Operation, "request_id", e.RequestID, "started", time.Unix(e.Timestamp, 0).UTC())
+	}
+}
+
+// Insert records the operation as in flight until the returned function is called, with the request ID of the
+// context if any. If the maximum number of operations are in flight already, the operation is not recorded.
+func (t *Tracker) Insert(ctx context.Context, operation string) (done func()) {
+	if t == nil {
+		return func() {}
+	}
+	t.mtx.Lock()
+	if len(t.free) == 0 {
+		t.mtx.Unlock()
+		return func() {}
+	}
+	i := t.free[len(t.free)-1]
+	t.free = t.free[:len(t.free)-1]
+	t.mtx.Unlock()
+
+	reqID, _ := middleware.RequestIDFromContext(ctx)
+	t.write(i, encode(entry{Operation: operation, RequestID: reqID, Timestamp: time.Now().Unix()}))
+	return func() {
+		t.write(i, make([]byte, entrySize))
+		t.mtx.Lock()
+		t.free = append(t.free, i)
+		t.mtx.Unlock()
+	}
+}
+
+// encode returns the JSON of the entry, with the operation truncated to fit the slot.
+func encode(e entry) []byte {
+	for {
+		b, err := json.Marshal(e)
+		if err != nil || len(b) <= entrySize {
+			return b
+		}
+		cut := len(b) - entrySize + len("...")
+		if cut >= len(e.Operation) {
+			e.Operation = ""
+			continue
+		}
+		e.Operation = e.Operation[:len(e.Operation)-cut] + "..."
+	}
+}
+
+// write writes the slot. The write reaches the page cache of the kernel, which keeps it when the process is killed.
+func (t *Tracker) write(i int, b []byte) {
+	if _, err := t.f.WriteAt(b, int64(i*entrySize)); err != nil {
+		level.Warn(t.logger).Log("msg", "failed to record active operation", "err", err)
+	}
+}
+
+// Close closes the file of the tracker.
+func (t *Tracker) Close() error {
+	if t == nil {
+		return nil
+	}
+	return t.f.Close()
+}
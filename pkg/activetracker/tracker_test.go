@@ -0,0 +1,51 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package activetracker
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
+)
+
+func TestTracker(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	tracker, err := New(log.NewNopLogger(), dir, "ops.active", 2)
+	testutil.Ok(t, err)
+
+	done := tracker.Insert(context.Background(), "first")
+	tracker.Insert(middleware.NewContextWithRequestID(context.Background(), "01ABC"), "second")
+	// Operations beyond the maximum are not recorded.
+	tracker.Insert(context.Background(), "third")()
+	done()
+	tracker.Insert(context.Background(), strings.Repeat("long", 1000))
+	testutil.Ok(t, tracker.Close())
+
+	// The next run logs the operations that did not finish.
+	buf := &bytes.Buffer{}
+	tracker, err = New(log.NewLogfmtLogger(buf), dir, "ops.active", 2)
+	testutil.Ok(t, err)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	testutil.Equals(t, 2, len(lines))
+	testutil.Assert(t, strings.Contains(lines[0], "operation=longlong") && strings.Contains(lines[0], "... request_id="), lines[0])
+	testutil.Assert(t, strings.Contains(lines[1], "operation=second request_id=01ABC"), lines[1])
+	testutil.Ok(t, tracker.Close())
+
+	buf.Reset()
+	_, err = New(log.NewLogfmtLogger(buf), dir, "ops.active", 2)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "", buf.String())
+
+	var nilTracker *Tracker
+	nilTracker.Insert(context.Background(), "op")()
+	testutil.Ok(t, nilTracker.Close())
+}
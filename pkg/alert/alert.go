@@ -10,15 +10,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"path"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/go-openapi/strfmt"
+	"github.com/jpillora/backoff"
 	"github.com/pkg/errors"
 	"github.com/prometheus/alertmanager/api/v2/models"
 	"github.com/prometheus/client_golang/prometheus"
@@ -35,6 +38,9 @@ import (
 const (
 	defaultAlertmanagerPort = 9093
 	contentTypeJSON         = "application/json"
+
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 5 * time.Second
 )
 
 // Queue is a queue of alert notifications waiting to be sent. The queue is consumed in batches
@@ -204,11 +210,25 @@ type Sender struct {
 	logger        log.Logger
 	alertmanagers []*Alertmanager
 	versions      []APIVersion
+	leader        Leader
+
+	sent         *prometheus.CounterVec
+	errs         *prometheus.CounterVec
+	retries      *prometheus.CounterVec
+	dropped      prometheus.Counter
+	deduplicated prometheus.Counter
+	latency      *prometheus.HistogramVec
+}
 
-	sent    *prometheus.CounterVec
-	errs    *prometheus.CounterVec
-	dropped prometheus.Counter
-	latency *prometheus.HistogramVec
+// SenderOption configures optional behaviour of the Sender.
+type SenderOption func(*Sender)
+
+// WithLeader makes the Sender only send alerts while the given Leader reports this replica as the
+// leader of its HA group. Alerts are dropped otherwise, as another replica is sending them.
+func WithLeader(l Leader) SenderOption {
+	return func(s *Sender) {
+		s.leader = l
+	}
 }
 
 // NewSender returns a new sender. On each call to Send the entire alert batch is sent
@@ -217,6 +237,7 @@ func NewSender(
 	logger log.Logger,
 	reg prometheus.Registerer,
 	alertmanagers []*Alertmanager,
+	opts ...SenderOption,
 ) *Sender {
 	if logger == nil {
 		logger = log.NewNopLogger()
@@ -246,16 +267,29 @@ func NewSender(
 			Help: "Total number of errors while sending alerts to alertmanager.",
 		}, []string{"alertmanager"}),
 
+		retries: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_alert_sender_retries_total",
+			Help: "Total number of retries while sending alerts to alertmanager.",
+		}, []string{"alertmanager"}),
+
 		dropped: promauto.With(reg).NewCounter(prometheus.CounterOpts{
 			Name: "thanos_alert_sender_alerts_dropped_total",
 			Help: "Total number of alerts dropped in case of all sends to alertmanagers failed.",
 		}),
 
+		deduplicated: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_alert_sender_alerts_deduplicated_total",
+			Help: "Total number of alerts not sent because another replica of the HA group is the leader.",
+		}),
+
 		latency: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
 			Name: "thanos_alert_sender_latency_seconds",
 			Help: "Latency for sending alert notifications (not including dropped notifications).",
 		}, []string{"alertmanager"}),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
 	return s
 }
 
@@ -273,6 +307,11 @@ func (s *Sender) Send(ctx context.Context, alerts []*notifier.Alert) {
 	if len(alerts) == 0 {
 		return
 	}
+	if s.leader != nil && !s.leader.IsLeader() {
+		s.deduplicated.Add(float64(len(alerts)))
+		level.Debug(s.logger).Log("msg", "not the HA group leader, skipping sending alerts", "numAlerts", len(alerts))
+		return
+	}
 
 	payload := make(map[APIVersion][]byte)
 	for _, version := range s.versions {
@@ -322,7 +361,9 @@ func (s *Sender) Send(ctx context.Context, alerts []*notifier.Alert) {
 				u.Path = path.Join(u.Path, fmt.Sprintf("/api/%s/alerts", string(am.version)))
 
 				tracing.DoInSpan(ctx, "post_alerts HTTP[client]", func(ctx context.Context) {
-					if err := am.postAlerts(ctx, u, bytes.NewReader(payload[am.version])); err != nil {
+					if err := am.postAlertsWithRetries(ctx, u, payload[am.version], func() {
+						s.retries.WithLabelValues(u.Host).Inc()
+					}); err != nil {
 						level.Warn(s.logger).Log(
 							"msg", "sending alerts failed",
 							"alertmanager", u.Host,
@@ -363,20 +404,105 @@ type Alertmanager struct {
 	dispatcher Dispatcher
 	timeout    time.Duration
 	version    APIVersion
+	maxRetries int
+	backoff    backoff.Backoff
+}
+
+// AlertmanagerOption configures optional behaviour of the Alertmanager client.
+type AlertmanagerOption func(*Alertmanager)
+
+// WithRetries makes the client retry failed sends to an endpoint up to maxRetries times, waiting with
+// an exponential backoff between minBackoff and maxBackoff. Only transient errors are retried: timeouts,
+// refused or reset connections, and 429, 500, 502, 503 and 504 responses. The timeout of the client applies to each attempt.
+func WithRetries(maxRetries int, minBackoff, maxBackoff time.Duration) AlertmanagerOption {
+	return func(a *Alertmanager) {
+		a.maxRetries = maxRetries
+		if minBackoff > 0 {
+			a.backoff.Min = minBackoff
+		}
+		if maxBackoff > 0 {
+			a.backoff.Max = maxBackoff
+		}
+	}
 }
 
 // NewAlertmanager returns a new Alertmanager client.
-func NewAlertmanager(logger log.Logger, dispatcher Dispatcher, timeout time.Duration, version APIVersion) *Alertmanager {
+func NewAlertmanager(logger log.Logger, dispatcher Dispatcher, timeout time.Duration, version APIVersion, opts ...AlertmanagerOption) *Alertmanager {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
 
-	return &Alertmanager{
+	a := &Alertmanager{
 		logger:     logger,
 		dispatcher: dispatcher,
 		timeout:    timeout,
 		version:    version,
+		backoff: backoff.Backoff{
+			Factor: 2,
+			Min:    defaultMinBackoff,
+			Max:    defaultMaxBackoff,
+			Jitter: true,
+		},
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// postAlertsWithRetries posts the payload to the given endpoint, retrying retryable errors.
+// onRetry is called before every retry.
+func (a *Alertmanager) postAlertsWithRetries(ctx context.Context, u url.URL, payload []byte, onRetry func()) error {
+	for attempt := 0; ; attempt++ {
+		err := a.postAlerts(ctx, u, bytes.NewReader(payload))
+		if err == nil || attempt >= a.maxRetries || !isRetryable(err) {
+			return err
+		}
+		level.Debug(a.logger).Log("msg", "retrying sending alerts", "alertmanager", u.Host, "attempt", attempt+1, "err", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(a.backoff.ForAttempt(float64(attempt))):
+		}
+		onRetry()
+	}
+}
+
+// statusError is returned for non 2xx responses from Alertmanager.
+type statusError struct {
+	code   int
+	status string
+	url    string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("bad response status %v from %q", e.status, e.url)
+}
+
+// isRetryable returns true if the error is transient, so sending the request again may succeed: timeouts, refused or
+// reset connections, and 429, 500, 502, 503 and 504 responses.
+func isRetryable(err error) bool {
+	var serr *statusError
+	if errors.As(err, &serr) {
+		switch serr.code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
 	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
 }
 
 func (a *Alertmanager) postAlerts(ctx context.Context, u url.URL, r io.Reader) error {
@@ -396,7 +522,7 @@ func (a *Alertmanager) postAlerts(ctx context.Context, u url.URL, r io.Reader) e
 	defer runutil.ExhaustCloseWithLogOnErr(a.logger, resp.Body, "send one alert")
 
 	if resp.StatusCode/100 != 2 {
-		return errors.Errorf("bad response status %v from %q", resp.Status, u.String())
+		return &statusError{code: resp.StatusCode, status: resp.Status, url: u.String()}
 	}
 	return nil
 }
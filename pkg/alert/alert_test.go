@@ -5,10 +5,13 @@ package alert
 
 import (
 	"context"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -215,3 +218,94 @@ func TestSenderSendsAllFail(t *testing.T) {
 	testutil.Equals(t, 1, int(promtestutil.ToFloat64(s.errs.WithLabelValues(poster.urls[1].Host))))
 	testutil.Equals(t, 2, int(promtestutil.ToFloat64(s.dropped)))
 }
+
+func TestSenderRetries(t *testing.T) {
+	var attempts int
+	poster := &fakeClient{
+		urls: []*url.URL{{Host: "am1:9090"}},
+		dof: func(u *url.URL) (*http.Response, error) {
+			attempts++
+			rec := httptest.NewRecorder()
+			if attempts < 3 {
+				rec.WriteHeader(http.StatusServiceUnavailable)
+			} else {
+				rec.WriteHeader(http.StatusOK)
+			}
+			return rec.Result(), nil
+		},
+	}
+	s := NewSender(nil, nil, []*Alertmanager{NewAlertmanager(nil, poster, time.Minute, APIv2, WithRetries(3, time.Millisecond, time.Millisecond))})
+
+	s.Send(context.Background(), []*notifier.Alert{{}, {}})
+
+	testutil.Equals(t, 3, attempts)
+	testutil.Equals(t, 2, int(promtestutil.ToFloat64(s.sent.WithLabelValues(poster.urls[0].Host))))
+	testutil.Equals(t, 2, int(promtestutil.ToFloat64(s.retries.WithLabelValues(poster.urls[0].Host))))
+	testutil.Equals(t, 0, int(promtestutil.ToFloat64(s.errs.WithLabelValues(poster.urls[0].Host))))
+}
+
+func TestSenderDoesNotRetryBadRequests(t *testing.T) {
+	var attempts int
+	poster := &fakeClient{
+		urls: []*url.URL{{Host: "am1:9090"}},
+		dof: func(u *url.URL) (*http.Response, error) {
+			attempts++
+			rec := httptest.NewRecorder()
+			rec.WriteHeader(http.StatusBadRequest)
+			return rec.Result(), nil
+		},
+	}
+	s := NewSender(nil, nil, []*Alertmanager{NewAlertmanager(nil, poster, time.Minute, APIv2, WithRetries(3, time.Millisecond, time.Millisecond))})
+
+	s.Send(context.Background(), []*notifier.Alert{{}})
+
+	testutil.Equals(t, 1, attempts)
+	testutil.Equals(t, 1, int(promtestutil.ToFloat64(s.errs.WithLabelValues(poster.urls[0].Host))))
+	testutil.Equals(t, 1, int(promtestutil.ToFloat64(s.dropped)))
+}
+
+func TestIsRetryable(t *testing.T) {
+	for _, tcase := range []struct {
+		err       error
+		retryable bool
+	}{
+		{err: &statusError{code: http.StatusTooManyRequests}, retryable: true},
+		{err: &statusError{code: http.StatusInternalServerError}, retryable: true},
+		{err: &statusError{code: http.StatusBadGateway}, retryable: true},
+		{err: &statusError{code: http.StatusServiceUnavailable}, retryable: true},
+		{err: &statusError{code: http.StatusGatewayTimeout}, retryable: true},
+		{err: &statusError{code: http.StatusNotImplemented}},
+		{err: &statusError{code: http.StatusBadRequest}},
+		{err: &statusError{code: http.StatusUnauthorized}},
+		{err: errors.Wrap(&url.Error{Op: "Post", Err: syscall.ECONNREFUSED}, "send request"), retryable: true},
+		{err: errors.Wrap(&url.Error{Op: "Post", Err: syscall.ECONNRESET}, "send request"), retryable: true},
+		{err: errors.Wrap(&url.Error{Op: "Post", Err: io.EOF}, "send request"), retryable: true},
+		{err: errors.Wrap(&url.Error{Op: "Post", Err: context.DeadlineExceeded}, "send request"), retryable: true},
+		{err: errors.Wrap(&url.Error{Op: "Post", Err: &net.DNSError{Err: "timeout", IsTimeout: true}}, "send request"), retryable: true},
+		{err: errors.Wrap(&url.Error{Op: "Post", Err: &net.DNSError{Err: "no such host", IsNotFound: true}}, "send request")},
+		{err: errors.Wrap(&url.Error{Op: "Post", Err: context.Canceled}, "send request")},
+		{err: errors.Wrap(&url.Error{Op: "Post", Err: errors.New("tls: failed to verify certificate")}, "send request")},
+		{err: errors.New("unsupported protocol scheme")},
+	} {
+		t.Run(tcase.err.Error(), func(t *testing.T) {
+			testutil.Equals(t, tcase.retryable, isRetryable(tcase.err))
+		})
+	}
+}
+
+type fakeLeader bool
+
+func (l fakeLeader) IsLeader() bool { return bool(l) }
+
+func TestSenderSkipsWhenNotLeader(t *testing.T) {
+	poster := &fakeClient{
+		urls: []*url.URL{{Host: "am1:9090"}},
+	}
+	s := NewSender(nil, nil, []*Alertmanager{NewAlertmanager(nil, poster, time.Minute, APIv2)}, WithLeader(fakeLeader(false)))
+
+	s.Send(context.Background(), []*notifier.Alert{{}, {}})
+
+	testutil.Equals(t, 0, len(poster.seen))
+	testutil.Equals(t, 2, int(promtestutil.ToFloat64(s.deduplicated)))
+	testutil.Equals(t, 0, int(promtestutil.ToFloat64(s.dropped)))
+}
@@ -29,6 +29,9 @@ type AlertmanagerConfig struct {
 	EndpointsConfig  clientconfig.HTTPEndpointsConfig `yaml:",inline"`
 	Timeout          model.Duration                   `yaml:"timeout"`
 	APIVersion       APIVersion                       `yaml:"api_version"`
+	MaxRetries       int                              `yaml:"max_retries"`
+	MinBackoff       model.Duration                   `yaml:"min_backoff"`
+	MaxBackoff       model.Duration                   `yaml:"max_backoff"`
 }
 
 // APIVersion represents the API version of the Alertmanager endpoint.
@@ -68,6 +71,8 @@ func DefaultAlertmanagerConfig() AlertmanagerConfig {
 		},
 		Timeout:    model.Duration(time.Second * 10),
 		APIVersion: APIv2,
+		MinBackoff: model.Duration(defaultMinBackoff),
+		MaxBackoff: model.Duration(defaultMaxBackoff),
 	}
 }
 
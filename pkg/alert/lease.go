@@ -0,0 +1,216 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// LeaseDir is the directory in the bucket holding the alerting leases of ruler HA groups.
+const LeaseDir = "alert-leases"
+
+// Leader tells whether this replica is responsible for sending the alerts of its HA group.
+type Leader interface {
+	IsLeader() bool
+}
+
+// leaseRecord is the content of a lease object.
+type leaseRecord struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// BucketLease is a Leader backed by a lease object in the object storage, shared by all replicas of an HA group.
+// The holder renews the lease every third of its TTL, and other replicas only take it over once it expired.
+// A replica stops considering itself the leader half way through the TTL if it could not renew the lease,
+// so that two replicas are not leaders at the same time unless their clocks are skewed by more than that.
+// Concurrent takeovers are resolved by reading the lease back after writing it, which is best effort: in the
+// worst case two replicas send the same alerts for one renewal period, which Alertmanager deduplicates.
+// The lease fails open: a replica which could not renew the lease for a whole TTL, e.g. because the object storage is
+// unavailable, considers itself the leader until it can again, so that the alerts of the group are still sent, by all
+// of its replicas at worst.
+type BucketLease struct {
+	logger  log.Logger
+	bkt     objstore.Bucket
+	name    string
+	replica string
+	ttl     time.Duration
+	now     func() time.Time
+
+	mtx         sync.Mutex
+	leaderUntil time.Time
+	// failingSince is the time of the first of the consecutive failed renewals, if the last one failed.
+	failingSince time.Time
+
+	leader    prometheus.Gauge
+	renewals  prometheus.Counter
+	failures  prometheus.Counter
+	takeovers prometheus.Counter
+}
+
+// NewBucketLease returns a lease for the HA group identified by the given labels, held on behalf of the given replica.
+func NewBucketLease(logger log.Logger, reg prometheus.Registerer, bkt objstore.Bucket, group labels.Labels, replica string, ttl time.Duration) *BucketLease {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &BucketLease{
+		logger:  logger,
+		bkt:     bkt,
+		name:    path.Join(LeaseDir, fmt.Sprintf("%x.json", group.Hash())),
+		replica: replica,
+		ttl:     ttl,
+		now:     time.Now,
+
+		leader: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_alert_lease_leader",
+			Help: "Whether this replica holds the alerting lease of its HA group and sends alerts.",
+		}),
+		renewals: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_alert_lease_renewals_total",
+			Help: "Total number of attempts to acquire or renew the alerting lease.",
+		}),
+		failures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_alert_lease_renewal_failures_total",
+			Help: "Total number of failed attempts to acquire or renew the alerting lease.",
+		}),
+		takeovers: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_alert_lease_takeovers_total",
+			Help: "Total number of times this replica took over an expired alerting lease.",
+		}),
+	}
+}
+
+// IsLeader returns true if this replica currently holds the lease, or failed to renew it for longer than its TTL.
+func (l *BucketLease) IsLeader() bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	now := l.now()
+	return now.Before(l.leaderUntil) || l.failingOpen(now)
+}
+
+// failingOpen returns true if the renewals of the lease failed for longer than its TTL.
+// NB: The caller must hold the mtx lock.
+func (l *BucketLease) failingOpen(now time.Time) bool {
+	return !l.failingSince.IsZero() && !now.Before(l.failingSince.Add(l.ttl))
+}
+
+// Run renews the lease until the context is canceled.
+func (l *BucketLease) Run(ctx context.Context) error {
+	return runutil.Repeat(l.ttl/3, ctx.Done(), func() error {
+		if err := l.renew(ctx); err != nil {
+			l.failures.Inc()
+			level.Warn(l.logger).Log("msg", "failed to renew alerting lease", "lease", l.name, "err", err)
+
+			l.mtx.Lock()
+			failingOpen := l.failingOpen(l.now())
+			l.mtx.Unlock()
+			if failingOpen {
+				level.Warn(l.logger).Log("msg", "failed to renew alerting lease for longer than its TTL, sending alerts regardless of the lease", "lease", l.name)
+			}
+		}
+		if l.IsLeader() {
+			l.leader.Set(1)
+		} else {
+			l.leader.Set(0)
+		}
+		return nil
+	})
+}
+
+// renew acquires the lease if it is free or expired, or extends it if this replica holds it already.
+func (l *BucketLease) renew(ctx context.Context) error {
+	l.renewals.Inc()
+
+	start := l.now()
+	err := l.acquire(ctx, start)
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if err == nil {
+		l.failingSince = time.Time{}
+	} else if l.failingSince.IsZero() {
+		l.failingSince = start
+	}
+	return err
+}
+
+func (l *BucketLease) acquire(ctx context.Context, start time.Time) error {
+	rec, err := l.read(ctx)
+	if err != nil {
+		return err
+	}
+	if rec != nil && rec.Holder != l.replica && start.Before(rec.Expires) {
+		l.setLeaderUntil(time.Time{})
+		return nil
+	}
+
+	b, err := json.Marshal(leaseRecord{Holder: l.replica, Expires: start.Add(l.ttl)})
+	if err != nil {
+		return errors.Wrap(err, "encode lease")
+	}
+	if err := l.bkt.Upload(ctx, l.name, bytes.NewReader(b)); err != nil {
+		return errors.Wrap(err, "upload lease")
+	}
+
+	// Read the lease back, another replica might have taken it over concurrently.
+	rec, err = l.read(ctx)
+	if err != nil {
+		return err
+	}
+	if rec == nil || rec.Holder != l.replica {
+		l.setLeaderUntil(time.Time{})
+		return nil
+	}
+	if !l.IsLeader() {
+		l.takeovers.Inc()
+		level.Info(l.logger).Log("msg", "acquired alerting lease", "lease", l.name, "replica", l.replica)
+	}
+	l.setLeaderUntil(start.Add(l.ttl / 2))
+	return nil
+}
+
+func (l *BucketLease) setLeaderUntil(t time.Time) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.leaderUntil = t
+}
+
+// read returns the current lease, or nil if there is none.
+func (l *BucketLease) read(ctx context.Context) (*leaseRecord, error) {
+	r, err := l.bkt.Get(ctx, l.name)
+	if err != nil {
+		if l.bkt.IsObjNotFoundErr(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "get lease")
+	}
+	defer runutil.CloseWithLogOnErr(l.logger, r, "lease reader")
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read lease")
+	}
+	var rec leaseRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, errors.Wrap(err, "decode lease")
+	}
+	return &rec, nil
+}
@@ -0,0 +1,122 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package alert
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+)
+
+func TestBucketLease(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx   = context.Background()
+		bkt   = objstore.NewInMemBucket()
+		group = labels.FromStrings("cluster", "eu1")
+		now   = time.Unix(1000, 0)
+		clock = func() time.Time { return now }
+	)
+	a := NewBucketLease(nil, nil, bkt, group, `{cluster="eu1", replica="A"}`, time.Minute)
+	b := NewBucketLease(nil, nil, bkt, group, `{cluster="eu1", replica="B"}`, time.Minute)
+	a.now, b.now = clock, clock
+
+	testutil.Assert(t, !a.IsLeader())
+
+	// A acquires the free lease, B has to wait.
+	testutil.Ok(t, a.renew(ctx))
+	testutil.Ok(t, b.renew(ctx))
+	testutil.Assert(t, a.IsLeader())
+	testutil.Assert(t, !b.IsLeader())
+
+	// A renews the lease before it expires.
+	now = now.Add(20 * time.Second)
+	testutil.Ok(t, a.renew(ctx))
+	testutil.Ok(t, b.renew(ctx))
+	testutil.Assert(t, a.IsLeader())
+	testutil.Assert(t, !b.IsLeader())
+
+	// A fails to renew the lease and stops sending before B can take over.
+	now = now.Add(40 * time.Second)
+	testutil.Assert(t, !a.IsLeader())
+	testutil.Ok(t, b.renew(ctx))
+	testutil.Assert(t, !b.IsLeader())
+
+	// Once the lease expired, B takes over.
+	now = now.Add(21 * time.Second)
+	testutil.Ok(t, b.renew(ctx))
+	testutil.Assert(t, b.IsLeader())
+	testutil.Ok(t, a.renew(ctx))
+	testutil.Assert(t, !a.IsLeader())
+
+	// Other HA groups use their own lease.
+	c := NewBucketLease(nil, nil, bkt, labels.FromStrings("cluster", "us1"), `{cluster="us1", replica="A"}`, time.Minute)
+	c.now = clock
+	testutil.Ok(t, c.renew(ctx))
+	testutil.Assert(t, c.IsLeader())
+}
+
+type failingBucket struct {
+	objstore.Bucket
+
+	err error
+}
+
+func (b *failingBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.Bucket.Get(ctx, name)
+}
+
+func TestBucketLease_FailsOpen(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx   = context.Background()
+		bkt   = &failingBucket{Bucket: objstore.NewInMemBucket()}
+		group = labels.FromStrings("cluster", "eu1")
+		now   = time.Unix(1000, 0)
+	)
+	a := NewBucketLease(nil, nil, bkt, group, `{cluster="eu1", replica="A"}`, time.Minute)
+	b := NewBucketLease(nil, nil, bkt, group, `{cluster="eu1", replica="B"}`, time.Minute)
+	a.now = func() time.Time { return now }
+	b.now = a.now
+
+	testutil.Ok(t, a.renew(ctx))
+	testutil.Ok(t, b.renew(ctx))
+	testutil.Assert(t, a.IsLeader())
+	testutil.Assert(t, !b.IsLeader())
+
+	// While the bucket is unavailable for less than the TTL, only A sends.
+	bkt.err = errors.New("unavailable")
+	now = now.Add(20 * time.Second)
+	testutil.NotOk(t, a.renew(ctx))
+	testutil.NotOk(t, b.renew(ctx))
+	now = now.Add(41 * time.Second)
+	testutil.NotOk(t, a.renew(ctx))
+	testutil.NotOk(t, b.renew(ctx))
+	testutil.Assert(t, !a.IsLeader())
+	testutil.Assert(t, !b.IsLeader())
+
+	// Once the bucket is unavailable for the TTL, both send.
+	now = now.Add(time.Minute)
+	testutil.NotOk(t, a.renew(ctx))
+	testutil.Assert(t, a.IsLeader())
+	testutil.Assert(t, b.IsLeader())
+
+	// Once the bucket is available again, the lease is used again.
+	bkt.err = nil
+	testutil.Ok(t, b.renew(ctx))
+	testutil.Ok(t, a.renew(ctx))
+	testutil.Assert(t, b.IsLeader())
+	testutil.Assert(t, !a.IsLeader())
+}
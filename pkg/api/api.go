@@ -20,6 +20,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -134,6 +135,16 @@ func SetCORS(w http.ResponseWriter) {
 
 type ApiFunc func(r *http.Request) (interface{}, []error, *ApiError, func())
 
+type responseHeaderKey struct{}
+
+// SetResponseHeader sets the header of the response to the request with the context, for API functions to report
+// how the request was served. It does nothing for requests not served by an instrumented API function.
+func SetResponseHeader(ctx context.Context, key, value string) {
+	if h, ok := ctx.Value(responseHeaderKey{}).(http.Header); ok {
+		h.Set(key, value)
+	}
+}
+
 type BaseAPI struct {
 	logger      log.Logger
 	flagsMap    map[string]string
@@ -220,7 +231,12 @@ func GetInstr(
 			if !disableCORS {
 				SetCORS(w)
 			}
+			r = r.WithContext(context.WithValue(r.Context(), responseHeaderKey{}, w.Header()))
 			if data, warnings, err, releaseResources := f(r); err != nil {
+				// Include the request ID, logged by all components serving the request, in error messages.
+				if reqID, ok := middleware.RequestIDFromContext(r.Context()); ok {
+					err = &ApiError{Typ: err.Typ, Err: fmt.Errorf("request %s: %w", reqID, err.Err)}
+				}
 				RespondError(w, err, data, logger)
 				releaseResources()
 			} else if data != nil {
@@ -17,6 +17,7 @@
 package api
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -146,6 +147,38 @@ func TestRespondError(t *testing.T) {
 	}
 }
 
+func TestRequestIDInErrors(t *testing.T) {
+	instr := GetInstr(&opentracing.NoopTracer{}, log.NewNopLogger(), extpromhttp.NewNopInstrumentationMiddleware(), logging.NewHTTPServerMiddleware(log.NewNopLogger()), false)
+	h := instr("test", func(*http.Request) (interface{}, []error, *ApiError, func()) {
+		return nil, nil, &ApiError{ErrorExec, errors.New("message")}, func() {}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-ID", "01ABC")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	testutil.Equals(t, "01ABC", rec.Header().Get("X-Request-ID"))
+	var res response
+	testutil.Ok(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	testutil.Equals(t, "request 01ABC: message", res.Error)
+}
+
+func TestSetResponseHeader(t *testing.T) {
+	instr := GetInstr(&opentracing.NoopTracer{}, log.NewNopLogger(), extpromhttp.NewNopInstrumentationMiddleware(), logging.NewHTTPServerMiddleware(log.NewNopLogger()), false)
+	h := instr("test", func(r *http.Request) (interface{}, []error, *ApiError, func()) {
+		SetResponseHeader(r.Context(), "X-Test", "value")
+		return "test", nil, nil, func() {}
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	testutil.Equals(t, "value", rec.Header().Get("X-Test"))
+
+	// Setting headers outside of API functions does nothing.
+	SetResponseHeader(context.Background(), "X-Test", "value")
+}
+
 func TestOptionsMethod(t *testing.T) {
 	r := route.New()
 	api := &BaseAPI{}
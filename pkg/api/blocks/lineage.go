@@ -0,0 +1,125 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+
+	"github.com/thanos-io/thanos/pkg/api"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// BlockLineage is the compaction lineage of a block.
+type BlockLineage struct {
+	Block metadata.Meta `json:"block"`
+	// Ancestors are the blocks the block was compacted from, recursively, in breadth-first order.
+	Ancestors []Ancestor `json:"ancestors"`
+	// Descendants are the blocks holding the data of the block, compacted or downsampled from it, sorted by
+	// resolution, compaction level and min time.
+	Descendants []metadata.Meta `json:"descendants"`
+}
+
+// Ancestor is a block the data of a block was compacted from.
+type Ancestor struct {
+	ULID    ulid.ULID `json:"ulid"`
+	MinTime int64     `json:"minTime"`
+	MaxTime int64     `json:"maxTime"`
+	// Child is the block compacted from the ancestor.
+	Child ulid.ULID `json:"child"`
+	// Meta is the metadata of the ancestor, if it still exists.
+	Meta *metadata.Meta `json:"meta,omitempty"`
+}
+
+func (bapi *BlocksAPI) lineage(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
+	idParam := r.URL.Query().Get("id")
+	if idParam == "" {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: errors.New("ID cannot be empty")}, func() {}
+	}
+	id, err := ulid.Parse(idParam)
+	if err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: errors.Errorf("ULID %q is not valid: %v", idParam, err)}, func() {}
+	}
+
+	var blocks []metadata.Meta
+	if r.URL.Query().Get("view") == "loaded" {
+		bapi.loadedLock.Lock()
+		blocks = bapi.loadedBlocksInfo.Blocks
+		bapi.loadedLock.Unlock()
+	} else {
+		bapi.globalLock.Lock()
+		blocks = bapi.globalBlocksInfo.Blocks
+		bapi.globalLock.Unlock()
+	}
+
+	l, ok := blockLineage(id, blocks)
+	if !ok {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: errors.Errorf("block %s not found", id)}, func() {}
+	}
+	return l, nil, nil, func() {}
+}
+
+// blockLineage returns the lineage of the block from the metas of the blocks, and false if the block is not among
+// them. The ancestors are found from the parents of the blocks that still exist, and the descendants are the other
+// blocks whose sources include all the sources of the block.
+func blockLineage(id ulid.ULID, blocks []metadata.Meta) (*BlockLineage, bool) {
+	metas := make(map[ulid.ULID]*metadata.Meta, len(blocks))
+	for i := range blocks {
+		metas[blocks[i].ULID] = &blocks[i]
+	}
+	m, ok := metas[id]
+	if !ok {
+		return nil, false
+	}
+	l := &BlockLineage{Block: *m, Ancestors: []Ancestor{}, Descendants: []metadata.Meta{}}
+
+	seen := map[ulid.ULID]struct{}{id: {}}
+	for queue := []*metadata.Meta{m}; len(queue) > 0; queue = queue[1:] {
+		for _, p := range queue[0].Compaction.Parents {
+			if _, ok := seen[p.ULID]; ok {
+				continue
+			}
+			seen[p.ULID] = struct{}{}
+
+			a := Ancestor{ULID: p.ULID, MinTime: p.MinTime, MaxTime: p.MaxTime, Child: queue[0].ULID, Meta: metas[p.ULID]}
+			l.Ancestors = append(l.Ancestors, a)
+			if a.Meta != nil {
+				queue = append(queue, a.Meta)
+			}
+		}
+	}
+
+	sources := make(map[ulid.ULID]struct{}, len(m.Compaction.Sources))
+	for _, s := range m.Compaction.Sources {
+		sources[s] = struct{}{}
+	}
+	for _, b := range blocks {
+		if b.ULID == id || len(sources) == 0 {
+			continue
+		}
+		found := 0
+		for _, s := range b.Compaction.Sources {
+			if _, ok := sources[s]; ok {
+				found++
+			}
+		}
+		if found == len(sources) {
+			l.Descendants = append(l.Descendants, b)
+		}
+	}
+	sort.Slice(l.Descendants, func(i, j int) bool {
+		a, b := l.Descendants[i], l.Descendants[j]
+		if a.Thanos.Downsample.Resolution != b.Thanos.Downsample.Resolution {
+			return a.Thanos.Downsample.Resolution < b.Thanos.Downsample.Resolution
+		}
+		if a.Compaction.Level != b.Compaction.Level {
+			return a.Compaction.Level < b.Compaction.Level
+		}
+		return a.MinTime < b.MinTime
+	})
+	return l, true
+}
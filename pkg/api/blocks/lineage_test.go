@@ -0,0 +1,100 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/objstore"
+
+	baseAPI "github.com/thanos-io/thanos/pkg/api"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+func lineageMeta(id ulid.ULID, level int, resolution int64, sources []ulid.ULID, parents ...ulid.ULID) metadata.Meta {
+	m := metadata.Meta{BlockMeta: tsdb.BlockMeta{
+		ULID:       id,
+		MinTime:    int64(id.Time()),
+		MaxTime:    int64(id.Time()) + 1,
+		Compaction: tsdb.BlockMetaCompaction{Level: level, Sources: sources},
+	}}
+	m.Thanos.Downsample.Resolution = resolution
+	for _, p := range parents {
+		m.Compaction.Parents = append(m.Compaction.Parents, tsdb.BlockDesc{ULID: p})
+	}
+	return m
+}
+
+func TestBlockLineage(t *testing.T) {
+	t.Parallel()
+
+	var (
+		s1, s2, s3, other = ulid.MustNew(1, nil), ulid.MustNew(2, nil), ulid.MustNew(3, nil), ulid.MustNew(4, nil)
+		c1, c2, d         = ulid.MustNew(5, nil), ulid.MustNew(6, nil), ulid.MustNew(7, nil)
+	)
+	blocks := []metadata.Meta{
+		// s1 was deleted once compacted into c1.
+		lineageMeta(s2, 1, 0, []ulid.ULID{s2}),
+		lineageMeta(s3, 1, 0, []ulid.ULID{s3}),
+		lineageMeta(other, 1, 0, []ulid.ULID{other}),
+		lineageMeta(c1, 2, 0, []ulid.ULID{s1, s2}, s1, s2),
+		lineageMeta(c2, 3, 0, []ulid.ULID{s1, s2, s3}, c1, s3),
+		lineageMeta(d, 3, 300000, []ulid.ULID{s1, s2, s3}),
+	}
+
+	l, ok := blockLineage(c2, blocks)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, c2, l.Block.ULID)
+	testutil.Equals(t, []Ancestor{
+		{ULID: c1, Child: c2, Meta: &blocks[3]},
+		{ULID: s3, Child: c2, Meta: &blocks[1]},
+		{ULID: s1, Child: c1},
+		{ULID: s2, Child: c1, Meta: &blocks[0]},
+	}, l.Ancestors)
+	testutil.Equals(t, []metadata.Meta{blocks[5]}, l.Descendants)
+
+	l, ok = blockLineage(s2, blocks)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, []Ancestor{}, l.Ancestors)
+	testutil.Equals(t, []metadata.Meta{blocks[3], blocks[4], blocks[5]}, l.Descendants)
+
+	_, ok = blockLineage(s1, blocks)
+	testutil.Assert(t, !ok)
+}
+
+func TestLineageEndpoint(t *testing.T) {
+	t.Parallel()
+
+	api := NewBlocksAPI(log.NewNopLogger(), true, "", map[string]string{}, objstore.NewInMemBucket())
+	id := ulid.MustNew(1, nil)
+	api.SetGlobal([]metadata.Meta{lineageMeta(id, 1, 0, []ulid.ULID{id})}, nil)
+
+	for _, tc := range []struct {
+		query   string
+		errType baseAPI.ErrorType
+	}{
+		{query: "id=" + id.String()},
+		{query: "", errType: baseAPI.ErrorBadData},
+		{query: "id=invalid", errType: baseAPI.ErrorBadData},
+		{query: "id=" + ulid.MustNew(2, nil).String(), errType: baseAPI.ErrorBadData},
+		// The loaded view has no blocks.
+		{query: "view=loaded&id=" + id.String(), errType: baseAPI.ErrorBadData},
+	} {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com?"+tc.query, nil)
+		testutil.Ok(t, err)
+		resp, _, apiErr, _ := api.lineage(req)
+		if tc.errType != baseAPI.ErrorNone {
+			testutil.Assert(t, apiErr != nil, tc.query)
+			testutil.Equals(t, tc.errType, apiErr.Typ, tc.query)
+			continue
+		}
+		testutil.Assert(t, apiErr == nil, tc.query)
+		testutil.Equals(t, id, resp.(*BlockLineage).Block.ULID)
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/thanos-io/thanos/pkg/api"
+	"github.com/thanos-io/thanos/pkg/compact"
+)
+
+// PlannedCompactions are the compactions to be done by the compactor, with their predicted usage.
+type PlannedCompactions struct {
+	Compactions []compact.PlannedCompaction `json:"compactions"`
+	RefreshedAt time.Time                   `json:"refreshedAt"`
+}
+
+func (bapi *BlocksAPI) plannedCompactions(_ *http.Request) (interface{}, []error, *api.ApiError, func()) {
+	bapi.plannedLock.Lock()
+	defer bapi.plannedLock.Unlock()
+
+	return bapi.planned, nil, nil, func() {}
+}
+
+// SetPlannedCompactions updates the compactions to be done in the API.
+func (bapi *BlocksAPI) SetPlannedCompactions(compactions []compact.PlannedCompaction) {
+	bapi.plannedLock.Lock()
+	defer bapi.plannedLock.Unlock()
+
+	bapi.planned = &PlannedCompactions{Compactions: compactions, RefreshedAt: time.Now()}
+}
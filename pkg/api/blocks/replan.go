@@ -0,0 +1,56 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"net/http"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+
+	"github.com/thanos-io/thanos/pkg/api"
+)
+
+// Replanner requests the groups with the given keys, and the groups with any of the given blocks, to be planned
+// again before the other groups.
+type Replanner func(groupKeys []string, ids []ulid.ULID)
+
+func (bapi *BlocksAPI) replanCompactions(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
+	if bapi.disableAdminOperations {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: errors.New("Admin operations are disabled")}, func() {}
+	}
+	bapi.replanLock.Lock()
+	replan := bapi.replan
+	bapi.replanLock.Unlock()
+	if replan == nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: errors.New("Replanning is not supported")}, func() {}
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: errors.Wrap(err, "parse form")}, func() {}
+	}
+	groupKeys := r.Form["group"]
+	ids := make([]ulid.ULID, 0, len(r.Form["id"]))
+	for _, idParam := range r.Form["id"] {
+		id, err := ulid.Parse(idParam)
+		if err != nil {
+			return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: errors.Errorf("ULID %q is not valid: %v", idParam, err)}, func() {}
+		}
+		ids = append(ids, id)
+	}
+	if len(groupKeys) == 0 && len(ids) == 0 {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: errors.New("Groups and IDs cannot both be empty")}, func() {}
+	}
+
+	replan(groupKeys, ids)
+	return nil, nil, nil, func() {}
+}
+
+// SetReplanner sets the function requesting compaction groups to be planned again by the API.
+func (bapi *BlocksAPI) SetReplanner(replan Replanner) {
+	bapi.replanLock.Lock()
+	defer bapi.replanLock.Unlock()
+
+	bapi.replan = replan
+}
@@ -0,0 +1,72 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/thanos-io/objstore"
+
+	baseAPI "github.com/thanos-io/thanos/pkg/api"
+)
+
+func TestReplanCompactionsEndpoint(t *testing.T) {
+	api := NewBlocksAPI(log.NewNopLogger(), true, "foo", map[string]string{}, objstore.NewInMemBucket())
+	id := ulid.MustNew(1, nil)
+
+	// Components without compactor do not support replanning.
+	testEndpoint(t, endpointTestCase{
+		endpoint: api.replanCompactions,
+		query:    url.Values{"group": []string{"0@1"}},
+		method:   http.MethodPost,
+		errType:  baseAPI.ErrorBadData,
+	}, "no replanner", func(_, _ interface{}) bool { return true })
+
+	var (
+		groupKeys []string
+		ids       []ulid.ULID
+	)
+	api.SetReplanner(func(k []string, i []ulid.ULID) {
+		groupKeys, ids = k, i
+	})
+	for i, test := range []endpointTestCase{
+		// Empty groups and IDs.
+		{
+			endpoint: api.replanCompactions,
+			method:   http.MethodPost,
+			errType:  baseAPI.ErrorBadData,
+		},
+		// Invalid ULID.
+		{
+			endpoint: api.replanCompactions,
+			query:    url.Values{"id": []string{"invalid_id"}},
+			method:   http.MethodPost,
+			errType:  baseAPI.ErrorBadData,
+		},
+		{
+			endpoint: api.replanCompactions,
+			query:    url.Values{"group": []string{"0@1", "0@2"}, "id": []string{id.String()}},
+			method:   http.MethodPost,
+		},
+	} {
+		testEndpoint(t, test, fmt.Sprintf("#%d %s", i, test.query.Encode()), func(_, _ interface{}) bool { return true })
+	}
+	testutil.Equals(t, []string{"0@1", "0@2"}, groupKeys)
+	testutil.Equals(t, []ulid.ULID{id}, ids)
+
+	api = NewBlocksAPI(log.NewNopLogger(), true, "foo", map[string]string{"disable-admin-operations": "true"}, objstore.NewInMemBucket())
+	api.SetReplanner(func([]string, []ulid.ULID) { t.Fatal("unexpected replan with disabled admin operations") })
+	testEndpoint(t, endpointTestCase{
+		endpoint: api.replanCompactions,
+		query:    url.Values{"group": []string{"0@1"}},
+		method:   http.MethodPost,
+		errType:  baseAPI.ErrorBadData,
+	}, "disabled admin operations", func(_, _ interface{}) bool { return true })
+}
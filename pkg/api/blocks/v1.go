@@ -21,6 +21,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/api"
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
 	"github.com/thanos-io/thanos/pkg/logging"
 )
@@ -33,6 +34,10 @@ type BlocksAPI struct {
 	loadedBlocksInfo *BlocksInfo
 
 	globalLock, loadedLock sync.Mutex
+	plannedLock            sync.Mutex
+	planned                *PlannedCompactions
+	replanLock             sync.Mutex
+	replan                 Replanner
 	disableCORS            bool
 	bkt                    objstore.Bucket
 	disableAdminOperations bool
@@ -43,6 +48,11 @@ type BlocksInfo struct {
 	Blocks      []metadata.Meta `json:"blocks"`
 	RefreshedAt time.Time       `json:"refreshedAt"`
 	Err         error           `json:"err"`
+
+	// DeletionMarks and NoCompactMarks are the markers of the blocks by ULID, if known by the component. The blocks
+	// marked for deletion can be missing from the blocks once they are about to be deleted.
+	DeletionMarks  map[ulid.ULID]*metadata.DeletionMark  `json:"deletionMarks,omitempty"`
+	NoCompactMarks map[ulid.ULID]*metadata.NoCompactMark `json:"noCompactMarks,omitempty"`
 }
 
 type ActionType int32
@@ -78,6 +88,7 @@ func NewBlocksAPI(logger log.Logger, disableCORS bool, label string, flagsMap ma
 			Blocks: []metadata.Meta{},
 			Label:  label,
 		},
+		planned:                &PlannedCompactions{Compactions: []compact.PlannedCompaction{}},
 		disableCORS:            disableCORS,
 		bkt:                    bkt,
 		disableAdminOperations: disableAdminOperations,
@@ -90,7 +101,10 @@ func (bapi *BlocksAPI) Register(r *route.Router, tracer opentracing.Tracer, logg
 	instr := api.GetInstr(tracer, logger, ins, logMiddleware, bapi.disableCORS)
 
 	r.Get("/blocks", instr("blocks", bapi.blocks))
+	r.Get("/blocks/lineage", instr("blocks_lineage", bapi.lineage))
+	r.Get("/compactions/planned", instr("compactions_planned", bapi.plannedCompactions))
 	r.Post("/blocks/mark", instr("blocks_mark", bapi.markBlock))
+	r.Post("/compactions/replan", instr("compactions_replan", bapi.replanCompactions))
 }
 
 func (bapi *BlocksAPI) markBlock(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
@@ -175,3 +189,12 @@ func (bapi *BlocksAPI) SetLoaded(blocks []metadata.Meta, err error) {
 
 	bapi.loadedBlocksInfo.set(blocks, err)
 }
+
+// SetLoadedMarks updates the markers of the local blocks in the API.
+func (bapi *BlocksAPI) SetLoadedMarks(deletionMarks map[ulid.ULID]*metadata.DeletionMark, noCompactMarks map[ulid.ULID]*metadata.NoCompactMark) {
+	bapi.loadedLock.Lock()
+	defer bapi.loadedLock.Unlock()
+
+	bapi.loadedBlocksInfo.DeletionMarks = deletionMarks
+	bapi.loadedBlocksInfo.NoCompactMarks = noCompactMarks
+}
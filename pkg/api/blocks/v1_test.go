@@ -5,6 +5,7 @@ package v1
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -21,6 +22,7 @@ import (
 
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
 	"github.com/thanos-io/objstore"
 
 	"github.com/efficientgo/core/testutil"
@@ -187,3 +189,38 @@ func TestMarkBlockEndpoint(t *testing.T) {
 	_, err = os.Stat(file)
 	testutil.Ok(t, err)
 }
+
+func TestBlocksEndpoint_LoadedMarks(t *testing.T) {
+	api := NewBlocksAPI(log.NewNopLogger(), true, "foo", map[string]string{}, objstore.NewInMemBucket())
+
+	id1, id2 := ulid.MustNew(1, nil), ulid.MustNew(2, nil)
+	api.SetLoaded([]metadata.Meta{{BlockMeta: tsdb.BlockMeta{ULID: id1}}}, nil)
+	api.SetLoadedMarks(
+		map[ulid.ULID]*metadata.DeletionMark{id2: {ID: id2, Version: metadata.DeletionMarkVersion1, Details: "compacted", DeletionTime: 10}},
+		map[ulid.ULID]*metadata.NoCompactMark{id1: {ID: id1, Version: metadata.NoCompactMarkVersion1, Reason: metadata.OutOfOrderChunksNoCompactReason, NoCompactTime: 20}},
+	)
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com?view=loaded", nil)
+	testutil.Ok(t, err)
+	resp, _, apiErr, _ := api.blocks(req)
+	testutil.Assert(t, apiErr == nil)
+
+	b, err := json.Marshal(resp)
+	testutil.Ok(t, err)
+	var info struct {
+		Blocks         []metadata.Meta                   `json:"blocks"`
+		DeletionMarks  map[string]metadata.DeletionMark  `json:"deletionMarks"`
+		NoCompactMarks map[string]metadata.NoCompactMark `json:"noCompactMarks"`
+	}
+	testutil.Ok(t, json.Unmarshal(b, &info))
+	testutil.Equals(t, 1, len(info.Blocks))
+	testutil.Equals(t, "compacted", info.DeletionMarks[id2.String()].Details)
+	testutil.Equals(t, metadata.NoCompactReason(metadata.OutOfOrderChunksNoCompactReason), info.NoCompactMarks[id1.String()].Reason)
+
+	// The global view has no markers.
+	req, err = http.NewRequest(http.MethodGet, "http://example.com", nil)
+	testutil.Ok(t, err)
+	resp, _, apiErr, _ = api.blocks(req)
+	testutil.Assert(t, apiErr == nil)
+	testutil.Equals(t, 0, len(resp.(*BlocksInfo).DeletionMarks))
+}
@@ -0,0 +1,173 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/route"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/thanos-io/thanos/pkg/api"
+	"github.com/thanos-io/thanos/pkg/cardinality"
+	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
+	"github.com/thanos-io/thanos/pkg/logging"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+const (
+	// Path is the path of the cardinality API.
+	Path = "/api/v1/status/cardinality"
+
+	defaultLimit = 10
+	maxLimit     = 10000
+	defaultRange = 24 * time.Hour
+)
+
+// StatsFunc returns the cardinality of the series of the tenant between mint and maxt in milliseconds, with the
+// limit stats with the highest values of each kind.
+type StatsFunc func(ctx context.Context, tenant string, mint, maxt int64, limit int) (cardinality.Stats, error)
+
+// CardinalityAPI serves the cardinality of the series of tenants.
+type CardinalityAPI struct {
+	stats           StatsFunc
+	tenantHeader    string
+	defaultTenant   string
+	certTenantField string
+	now             func() time.Time
+}
+
+// NewCardinalityAPI returns an API serving the cardinality returned by the stats function for the tenant of
+// the request.
+func NewCardinalityAPI(stats StatsFunc, tenantHeader, defaultTenant, certTenantField string) *CardinalityAPI {
+	return &CardinalityAPI{
+		stats:           stats,
+		tenantHeader:    tenantHeader,
+		defaultTenant:   defaultTenant,
+		certTenantField: certTenantField,
+		now:             time.Now,
+	}
+}
+
+// Register registers the cardinality API.
+func (c *CardinalityAPI) Register(r *route.Router, tracer opentracing.Tracer, logger log.Logger, ins extpromhttp.InstrumentationMiddleware, logMiddleware *logging.HTTPServerMiddleware) {
+	instr := api.GetInstr(tracer, logger, ins, logMiddleware, false)
+	r.Get(Path, instr("cardinality", c.serve))
+}
+
+func (c *CardinalityAPI) serve(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
+	tenant, err := tenancy.GetTenantFromHTTP(r, c.tenantHeader, c.defaultTenant, c.certTenantField)
+	if err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
+	}
+	end, err := parseTimeParam(r, "end", c.now())
+	if err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
+	}
+	start, err := parseTimeParam(r, "start", end.Add(-defaultRange))
+	if err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
+	}
+	if end.Before(start) {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: errors.New("end timestamp must not be before start time")}, func() {}
+	}
+	limit := defaultLimit
+	if s := r.FormValue("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 || limit > maxLimit {
+			return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: errors.Errorf("limit must be a number between 1 and %d", maxLimit)}, func() {}
+		}
+	}
+
+	stats, err := c.stats(r.Context(), tenant, start.UnixMilli(), end.UnixMilli(), limit)
+	if err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorInternal, Err: err}, func() {}
+	}
+	return stats, nil, nil, func() {}
+}
+
+func parseTimeParam(r *http.Request, name string, def time.Time) (time.Time, error) {
+	s := r.FormValue(name)
+	if s == "" {
+		return def, nil
+	}
+	if t, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, ns := math.Modf(t)
+		return time.Unix(int64(sec), int64(math.Round(ns*1000)/1000*float64(time.Second))), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.Errorf("invalid time value for '%s': cannot parse %q to a valid timestamp", name, s)
+}
+
+// NewRemoteStatsFunc returns a stats function merging the cardinality from the cardinality APIs of the
+// endpoints, e.g. of Store Gateways and Receivers, which are base URLs like http://store:10902.
+func NewRemoteStatsFunc(logger log.Logger, client *http.Client, endpoints []string, tenantHeader string) StatsFunc {
+	return func(ctx context.Context, tenant string, mint, maxt int64, limit int) (cardinality.Stats, error) {
+		stats := make([]cardinality.Stats, len(endpoints))
+		g, gctx := errgroup.WithContext(ctx)
+		for i, endpoint := range endpoints {
+			g.Go(func() error {
+				s, err := fetchStats(gctx, logger, client, endpoint, tenantHeader, tenant, mint, maxt, limit)
+				if err != nil {
+					return errors.Wrapf(err, "fetch cardinality from %s", endpoint)
+				}
+				stats[i] = s
+				return nil
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return cardinality.Stats{}, err
+		}
+		return cardinality.Merge(tenant, limit, stats...), nil
+	}
+}
+
+func fetchStats(ctx context.Context, logger log.Logger, client *http.Client, endpoint, tenantHeader, tenant string, mint, maxt int64, limit int) (cardinality.Stats, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return cardinality.Stats{}, err
+	}
+	u = u.JoinPath(Path)
+	u.RawQuery = url.Values{
+		"start": []string{time.UnixMilli(mint).UTC().Format(time.RFC3339Nano)},
+		"end":   []string{time.UnixMilli(maxt).UTC().Format(time.RFC3339Nano)},
+		"limit": []string{strconv.Itoa(limit)},
+	}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return cardinality.Stats{}, err
+	}
+	req.Header.Set(tenantHeader, tenant)
+	resp, err := client.Do(req)
+	if err != nil {
+		return cardinality.Stats{}, err
+	}
+	defer runutil.ExhaustCloseWithLogOnErr(logger, resp.Body, "cardinality response")
+
+	var body struct {
+		Status string            `json:"status"`
+		Error  string            `json:"error"`
+		Data   cardinality.Stats `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return cardinality.Stats{}, errors.Wrapf(err, "decode response with status %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return cardinality.Stats{}, errors.Errorf("status %s: %s", resp.Status, body.Error)
+	}
+	return body.Data, nil
+}
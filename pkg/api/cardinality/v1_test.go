@@ -0,0 +1,104 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/common/route"
+
+	"github.com/thanos-io/thanos/pkg/cardinality"
+	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
+	"github.com/thanos-io/thanos/pkg/logging"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+func newServer(t *testing.T, stats StatsFunc) *httptest.Server {
+	r := route.New()
+	NewCardinalityAPI(stats, tenancy.DefaultTenantHeader, tenancy.DefaultTenant, "").
+		Register(r, opentracing.NoopTracer{}, log.NewNopLogger(), extpromhttp.NewNopInstrumentationMiddleware(), logging.NewHTTPServerMiddleware(log.NewNopLogger()))
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRemoteStatsFunc(t *testing.T) {
+	t.Parallel()
+
+	type call struct {
+		tenant     string
+		mint, maxt int64
+		limit      int
+	}
+	calls := make(chan call, 2)
+	component := func(numSeries uint64, metrics ...cardinality.Stat) *httptest.Server {
+		return newServer(t, func(_ context.Context, tenant string, mint, maxt int64, limit int) (cardinality.Stats, error) {
+			calls <- call{tenant: tenant, mint: mint, maxt: maxt, limit: limit}
+			return cardinality.Stats{Tenant: tenant, Blocks: 1, NumSeries: numSeries, SeriesCountByMetricName: metrics}, nil
+		})
+	}
+	store := component(10, cardinality.Stat{Name: "up", Value: 10})
+	receive := component(4, cardinality.Stat{Name: "up", Value: 3}, cardinality.Stat{Name: "http_requests_total", Value: 1})
+
+	stats := NewRemoteStatsFunc(log.NewNopLogger(), http.DefaultClient, []string{store.URL, receive.URL}, tenancy.DefaultTenantHeader)
+	s, err := stats(context.Background(), "team-a", 1000, 2000, 5)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, s.Blocks)
+	testutil.Equals(t, uint64(10), s.NumSeries)
+	testutil.Equals(t, []cardinality.Stat{{Name: "up", Value: 10}, {Name: "http_requests_total", Value: 1}}, s.SeriesCountByMetricName)
+	for range 2 {
+		testutil.Equals(t, call{tenant: "team-a", mint: 1000, maxt: 2000, limit: 5}, <-calls)
+	}
+
+	failing := newServer(t, func(context.Context, string, int64, int64, int) (cardinality.Stats, error) {
+		return cardinality.Stats{}, context.DeadlineExceeded
+	})
+	_, err = NewRemoteStatsFunc(log.NewNopLogger(), http.DefaultClient, []string{store.URL, failing.URL}, tenancy.DefaultTenantHeader)(context.Background(), "team-a", 1000, 2000, 5)
+	testutil.NotOk(t, err)
+}
+
+func TestServeParams(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(100000, 0)
+	var got [2]int64
+	api := NewCardinalityAPI(func(_ context.Context, _ string, mint, maxt int64, _ int) (cardinality.Stats, error) {
+		got = [2]int64{mint, maxt}
+		return cardinality.Stats{}, nil
+	}, tenancy.DefaultTenantHeader, tenancy.DefaultTenant, "")
+	api.now = func() time.Time { return now }
+
+	for _, tc := range []struct {
+		query     string
+		mint      int64
+		maxt      int64
+		expectErr bool
+	}{
+		{query: "", mint: now.Add(-defaultRange).UnixMilli(), maxt: now.UnixMilli()},
+		{query: "start=10&end=20.5", mint: 10000, maxt: 20500},
+		{query: "start=1970-01-01T00:00:10Z&end=1970-01-01T00:00:20Z", mint: 10000, maxt: 20000},
+		{query: "start=20&end=10", expectErr: true},
+		{query: "limit=0", expectErr: true},
+		{query: "limit=10001", expectErr: true},
+		{query: "start=yesterday", expectErr: true},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, Path+"?"+tc.query, nil)
+			_, _, apiErr, _ := api.serve(req)
+			if tc.expectErr {
+				testutil.Assert(t, apiErr != nil)
+				return
+			}
+			testutil.Assert(t, apiErr == nil)
+			testutil.Equals(t, [2]int64{tc.mint, tc.maxt}, got)
+		})
+	}
+}
@@ -26,6 +26,7 @@ import (
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/storage"
 
@@ -74,14 +75,24 @@ type queryCreator interface {
 }
 
 type QueryFactory struct {
+	logger          log.Logger
 	mode            PromqlQueryMode
 	disableFallback bool
+	shadowRatio     float64
+	shadowGate      chan struct{}
 
 	prometheus        *promql.Engine
 	thanosLocal       *engine.Engine
 	thanosDistributed *engine.DistributedEngine
+
+	fallbacks     prometheus.Counter
+	shadowQueries *prometheus.CounterVec
 }
 
+// NewQueryFactory returns a factory of the queries of the engines. The queries of the Thanos engine fall back to the
+// Prometheus engine for the expressions it does not support, unless the fallback is disabled. A ratio of the queries,
+// given by the shadow ratio, is also executed in the background by the other engine, and the results of both
+// engines are compared.
 func NewQueryFactory(
 	reg *prometheus.Registry,
 	logger log.Logger,
@@ -92,6 +103,7 @@ func NewQueryFactory(
 	activeQueryTracker *promql.ActiveQueryTracker,
 	mode PromqlQueryMode,
 	disableFallback bool,
+	shadowRatio float64,
 ) *QueryFactory {
 	makeOpts := func(registry prometheus.Registerer) engine.Opts {
 		opts := engine.Opts{
@@ -107,6 +119,7 @@ func NewQueryFactory(
 				},
 				EnableNegativeOffset: true,
 				EnableAtModifier:     true,
+				EnablePerStepStats:   true,
 			},
 			EnableXFunctions: enableXFunctions,
 			EnableAnalysis:   true,
@@ -131,13 +144,30 @@ func NewQueryFactory(
 			"mode":   string(PromqlQueryModeDistributed),
 			"engine": string(PromqlEngineThanos)}, reg)))
 
-	return &QueryFactory{
+	f := &QueryFactory{
+		logger:            logger,
 		mode:              mode,
 		prometheus:        promEngine,
 		thanosLocal:       thanosLocal,
 		thanosDistributed: thanosDistributed,
 		disableFallback:   disableFallback,
+		shadowRatio:       shadowRatio,
+		shadowGate:        make(chan struct{}, maxConcurrentShadowQueries),
+		fallbacks: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_query_promql_engine_fallbacks_total",
+			Help: "Total number of queries of the Thanos engine that fell back to the Prometheus engine.",
+		}),
+		shadowQueries: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_query_promql_engine_shadow_queries_total",
+			Help: "Total number of queries executed by the other engine in the background, by result of the comparison of their results.",
+		}, []string{"engine", "result"}),
+	}
+	for _, e := range []PromqlEngineType{PromqlEnginePrometheus, PromqlEngineThanos} {
+		for _, result := range []string{shadowResultMatch, shadowResultMismatch, shadowResultError} {
+			f.shadowQueries.WithLabelValues(string(e), result)
+		}
 	}
+	return f
 }
 
 // Always has query, sometimes already has a plan.
@@ -146,6 +176,12 @@ type planOrQuery struct {
 	plan  logicalplan.Node
 }
 
+// fallback returns true if the query of the Thanos engine failed to be created with the error, and should fall back
+// to the Prometheus engine.
+func (f *QueryFactory) fallback(err error) bool {
+	return engine.IsUnimplemented(err) && !f.disableFallback
+}
+
 func (f *QueryFactory) makeInstantQuery(
 	ctx context.Context,
 	t PromqlEngineType,
@@ -154,21 +190,34 @@ func (f *QueryFactory) makeInstantQuery(
 	qry planOrQuery,
 	opts *engine.QueryOpts,
 	ts time.Time,
-) (res promql.Query, err error) {
+) (promql.Query, error) {
+	res, used, err := f.newInstantQuery(ctx, t, q, e, qry, opts, ts)
+	if err != nil {
+		return nil, err
+	}
+	if used != t {
+		f.fallbacks.Inc()
+	}
+	return f.shadowed(res, used, func(ctx context.Context, t PromqlEngineType) (promql.Query, PromqlEngineType, error) {
+		return f.newInstantQuery(ctx, t, q, e, qry, opts, ts)
+	}), nil
+}
+
+func (f *QueryFactory) newInstantQuery(
+	ctx context.Context,
+	t PromqlEngineType,
+	q storage.Queryable,
+	e api.RemoteEndpoints,
+	qry planOrQuery,
+	opts *engine.QueryOpts,
+	ts time.Time,
+) (res promql.Query, used PromqlEngineType, err error) {
 	if t == PromqlEngineThanos && f.mode == PromqlQueryModeLocal {
 		if qry.plan != nil {
 			res, err = f.thanosLocal.MakeInstantQueryFromPlan(ctx, q, opts, qry.plan, ts)
 		} else {
 			res, err = f.thanosLocal.MakeInstantQuery(ctx, q, opts, qry.query, ts)
 		}
-		if err != nil {
-			if engine.IsUnimplemented(err) && !f.disableFallback {
-				// fallback to prometheus
-				return f.prometheus.NewInstantQuery(ctx, q, opts, qry.query, ts)
-			}
-			return nil, err
-		}
-		return res, nil
 	}
 	if t == PromqlEngineThanos && f.mode == PromqlQueryModeDistributed {
 		if qry.plan != nil {
@@ -176,16 +225,17 @@ func (f *QueryFactory) makeInstantQuery(
 		} else {
 			res, err = f.thanosDistributed.MakeInstantQuery(ctx, q, e, opts, qry.query, ts)
 		}
-		if err != nil {
-			if engine.IsUnimplemented(err) {
-				// fallback to prometheus
-				return f.prometheus.NewInstantQuery(ctx, q, opts, qry.query, ts)
-			}
-			return nil, err
+	}
+	if t == PromqlEngineThanos {
+		if err == nil {
+			return res, PromqlEngineThanos, nil
+		}
+		if !f.fallback(err) {
+			return nil, "", err
 		}
-		return res, nil
 	}
-	return f.prometheus.NewInstantQuery(ctx, q, opts, qry.query, ts)
+	res, err = f.prometheus.NewInstantQuery(ctx, q, opts, qry.query, ts)
+	return res, PromqlEnginePrometheus, err
 }
 
 func (f *QueryFactory) makeRangeQuery(
@@ -198,21 +248,36 @@ func (f *QueryFactory) makeRangeQuery(
 	start time.Time,
 	end time.Time,
 	step time.Duration,
-) (res promql.Query, err error) {
+) (promql.Query, error) {
+	res, used, err := f.newRangeQuery(ctx, t, q, e, qry, opts, start, end, step)
+	if err != nil {
+		return nil, err
+	}
+	if used != t {
+		f.fallbacks.Inc()
+	}
+	return f.shadowed(res, used, func(ctx context.Context, t PromqlEngineType) (promql.Query, PromqlEngineType, error) {
+		return f.newRangeQuery(ctx, t, q, e, qry, opts, start, end, step)
+	}), nil
+}
+
+func (f *QueryFactory) newRangeQuery(
+	ctx context.Context,
+	t PromqlEngineType,
+	q storage.Queryable,
+	e api.RemoteEndpoints,
+	qry planOrQuery,
+	opts *engine.QueryOpts,
+	start time.Time,
+	end time.Time,
+	step time.Duration,
+) (res promql.Query, used PromqlEngineType, err error) {
 	if t == PromqlEngineThanos && f.mode == PromqlQueryModeLocal {
 		if qry.plan != nil {
 			res, err = f.thanosLocal.MakeRangeQueryFromPlan(ctx, q, opts, qry.plan, start, end, step)
 		} else {
 			res, err = f.thanosLocal.MakeRangeQuery(ctx, q, opts, qry.query, start, end, step)
 		}
-		if err != nil {
-			if engine.IsUnimplemented(err) {
-				// fallback to prometheus
-				return f.prometheus.NewRangeQuery(ctx, q, opts, qry.query, start, end, step)
-			}
-			return nil, err
-		}
-		return res, nil
 	}
 	if t == PromqlEngineThanos && f.mode == PromqlQueryModeDistributed {
 		if qry.plan != nil {
@@ -220,14 +285,15 @@ func (f *QueryFactory) makeRangeQuery(
 		} else {
 			res, err = f.thanosDistributed.MakeRangeQuery(ctx, q, e, opts, qry.query, start, end, step)
 		}
-		if err != nil {
-			if engine.IsUnimplemented(err) {
-				// fallback to prometheus
-				return f.prometheus.NewRangeQuery(ctx, q, opts, qry.query, start, end, step)
-			}
-			return nil, err
+	}
+	if t == PromqlEngineThanos {
+		if err == nil {
+			return res, PromqlEngineThanos, nil
+		}
+		if !f.fallback(err) {
+			return nil, "", err
 		}
-		return res, nil
 	}
-	return f.prometheus.NewRangeQuery(ctx, q, opts, qry.query, start, end, step)
+	res, err = f.prometheus.NewRangeQuery(ctx, q, opts, qry.query, start, end, step)
+	return res, PromqlEnginePrometheus, err
 }
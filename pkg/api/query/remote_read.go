@@ -0,0 +1,198 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/util/annotations"
+
+	"github.com/thanos-io/thanos/pkg/api"
+	"github.com/thanos-io/thanos/pkg/logutil"
+	"github.com/thanos-io/thanos/pkg/query"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+// RemoteReadConfig configures the Prometheus remote read endpoint of the query API.
+type RemoteReadConfig struct {
+	// SampleLimit is the maximum number of samples of a query of sampled responses, 0 means no limit.
+	SampleLimit int
+	// ConcurrencyLimit is the maximum number of concurrent remote read requests, it has to be positive.
+	ConcurrencyLimit int
+	// MaxBytesInFrame is the maximum size of a frame of streamed responses, i.e. of XOR chunks.
+	MaxBytesInFrame int
+}
+
+// EnableRemoteRead enables the Prometheus remote read endpoint, reading series from all the StoreAPIs of the querier,
+// with both the sampled and the streamed chunks response types.
+func (qapi *QueryAPI) EnableRemoteRead(reg prometheus.Registerer, conf RemoteReadConfig) {
+	qapi.remoteRead = remote.NewReadHandler(
+		logutil.GoKitLogToSlog(qapi.logger),
+		reg,
+		&remoteReadQueryable{qapi: qapi},
+		// External labels of the StoreAPIs are part of the labels of their series already.
+		func() config.Config { return config.Config{} },
+		conf.SampleLimit,
+		conf.ConcurrencyLimit,
+		conf.MaxBytesInFrame,
+	)
+}
+
+type remoteReadParamsKey struct{}
+
+// remoteReadParams are the parameters of a remote read request, passed to the queryable in the request context as
+// the remote read handler of Prometheus knows nothing about them.
+type remoteReadParams struct {
+	enableDedup         bool
+	replicaLabels       []string
+	maxResolutionMillis int64
+	partialResponse     bool
+	tenant              string
+}
+
+func (qapi *QueryAPI) remoteReadHandler(w http.ResponseWriter, r *http.Request) {
+	params, apiErr := qapi.parseRemoteReadParams(r)
+	if apiErr != nil {
+		http.Error(w, apiErr.Err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx := context.WithValue(r.Context(), tenancy.TenantKey, params.tenant)
+	ctx = context.WithValue(ctx, remoteReadParamsKey{}, params)
+	qapi.remoteRead.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// parseRemoteReadParams returns the parameters of the URL of a remote read request. The series of remote read
+// requests are raw data, unless a max source resolution is requested, and partial responses are disabled by
+// default, as remote read responses have no warnings.
+func (qapi *QueryAPI) parseRemoteReadParams(r *http.Request) (p remoteReadParams, apiErr *api.ApiError) {
+	tenant, err := tenancy.GetTenantFromHTTP(r, qapi.tenantHeader, qapi.defaultTenant, qapi.tenantCertField)
+	if err != nil {
+		return p, &api.ApiError{Typ: api.ErrorBadData, Err: err}
+	}
+	p.tenant = tenant
+	if p.enableDedup, apiErr = qapi.parseEnableDedupParam(r); apiErr != nil {
+		return p, apiErr
+	}
+	if p.replicaLabels, apiErr = qapi.parseReplicaLabelsParam(r); apiErr != nil {
+		return p, apiErr
+	}
+	if p.maxResolutionMillis, apiErr = qapi.parseDownsamplingParamMillis(r, 0); apiErr != nil {
+		return p, apiErr
+	}
+	if p.partialResponse, apiErr = qapi.parsePartialResponseParam(r, false); apiErr != nil {
+		return p, apiErr
+	}
+	return p, nil
+}
+
+type remoteReadQueryable struct {
+	qapi *QueryAPI
+}
+
+func (q *remoteReadQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	return &remoteReadQuerier{qapi: q.qapi, mint: mint, maxt: maxt}, nil
+}
+
+func (q *remoteReadQueryable) ChunkQuerier(mint, maxt int64) (storage.ChunkQuerier, error) {
+	return &remoteReadChunkQuerier{remoteReadQuerier{qapi: q.qapi, mint: mint, maxt: maxt}}, nil
+}
+
+// remoteReadQuerier is a querier of the StoreAPIs with the parameters of the remote read request of the context. It
+// is created before the context is known, so it creates the querier of the StoreAPIs on first use.
+type remoteReadQuerier struct {
+	qapi       *QueryAPI
+	mint, maxt int64
+
+	once    sync.Once
+	querier storage.Querier
+	err     error
+}
+
+func (q *remoteReadQuerier) init(ctx context.Context) (storage.Querier, error) {
+	q.once.Do(func() {
+		p, ok := ctx.Value(remoteReadParamsKey{}).(remoteReadParams)
+		if !ok {
+			q.err = errors.New("no remote read parameters in context")
+			return
+		}
+		q.querier, q.err = q.qapi.queryableCreate(
+			p.enableDedup,
+			p.replicaLabels,
+			nil,
+			p.maxResolutionMillis,
+			p.partialResponse,
+			false,
+			nil,
+			query.NoopSeriesStatsReporter,
+		).Querier(q.mint, q.maxt)
+	})
+	return q.querier, q.err
+}
+
+// enforceTenancy returns the matchers with the matcher of the tenant of the context, if tenancy is enforced.
+func (q *remoteReadQuerier) enforceTenancy(ctx context.Context, matchers []*labels.Matcher) ([]*labels.Matcher, error) {
+	if !q.qapi.enforceTenancy {
+		return matchers, nil
+	}
+	tenant, _ := ctx.Value(tenancy.TenantKey).(string)
+	return tenancy.EnforceMatchersTenancy(q.qapi.tenantLabel, tenant, matchers)
+}
+
+func (q *remoteReadQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	querier, err := q.init(ctx)
+	if err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+	if matchers, err = q.enforceTenancy(ctx, matchers); err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+	return querier.Select(ctx, sortSeries, hints, matchers...)
+}
+
+func (q *remoteReadQuerier) LabelValues(ctx context.Context, name string, hints *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	querier, err := q.init(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if matchers, err = q.enforceTenancy(ctx, matchers); err != nil {
+		return nil, nil, err
+	}
+	return querier.LabelValues(ctx, name, hints, matchers...)
+}
+
+func (q *remoteReadQuerier) LabelNames(ctx context.Context, hints *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	querier, err := q.init(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if matchers, err = q.enforceTenancy(ctx, matchers); err != nil {
+		return nil, nil, err
+	}
+	return querier.LabelNames(ctx, hints, matchers...)
+}
+
+func (q *remoteReadQuerier) Close() error {
+	if q.querier == nil {
+		return nil
+	}
+	return q.querier.Close()
+}
+
+// remoteReadChunkQuerier encodes the samples of the series of the StoreAPIs into chunks, as series of the StoreAPIs
+// are deduplicated and merged sample by sample.
+type remoteReadChunkQuerier struct {
+	remoteReadQuerier
+}
+
+func (q *remoteReadChunkQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.ChunkSeriesSet {
+	return storage.NewSeriesSetToChunkSet(q.remoteReadQuerier.Select(ctx, sortSeries, hints, matchers...))
+}
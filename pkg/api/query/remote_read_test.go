@@ -0,0 +1,131 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+
+	"github.com/thanos-io/thanos/pkg/dedup"
+	"github.com/thanos-io/thanos/pkg/query"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestRemoteRead(t *testing.T) {
+	db, err := e2eutil.NewTSDB()
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, db.Close()) }()
+
+	app := db.Appender(context.Background())
+	for _, lset := range []labels.Labels{
+		labels.FromStrings("__name__", "up", "foo", "bar", "replica", "a"),
+		labels.FromStrings("__name__", "up", "foo", "bar", "replica", "b"),
+		labels.FromStrings("__name__", "up", "foo", "baz", "replica", "a"),
+	} {
+		for i := int64(0); i < 10; i++ {
+			_, err := app.Append(0, lset, i*60000, float64(i))
+			testutil.Ok(t, err)
+		}
+	}
+	testutil.Ok(t, app.Commit())
+
+	qapi := &QueryAPI{
+		logger:          log.NewNopLogger(),
+		queryableCreate: query.NewQueryableCreator(nil, nil, newProxyStoreWithTSDBStore(db), 2, 100*time.Second, dedup.AlgorithmPenalty, false),
+		replicaLabels:   []string{"replica"},
+		tenantHeader:    "thanos-tenant",
+		defaultTenant:   "default-tenant",
+		tenantLabel:     "foo",
+	}
+	qapi.EnableRemoteRead(nil, RemoteReadConfig{SampleLimit: 1000, ConcurrencyLimit: 1, MaxBytesInFrame: 1024})
+
+	read := func(params string, header http.Header, responseType prompb.ReadRequest_ResponseType) *http.Response {
+		q, err := remote.ToQuery(0, 600000, []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")}, nil)
+		testutil.Ok(t, err)
+		buf, err := proto.Marshal(&prompb.ReadRequest{Queries: []*prompb.Query{q}, AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{responseType}})
+		testutil.Ok(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/read?"+params, bytes.NewReader(snappy.Encode(nil, buf)))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		qapi.remoteReadHandler(rec, req)
+		return rec.Result()
+	}
+	// streamed returns the number of samples of the series of the streamed chunks response.
+	streamed := func(params string, header http.Header) map[string]int {
+		resp := read(params, header, prompb.ReadRequest_STREAMED_XOR_CHUNKS)
+		testutil.Equals(t, http.StatusOK, resp.StatusCode)
+
+		series := map[string]int{}
+		r := remote.NewChunkedReader(resp.Body, config.DefaultChunkedReadLimit, nil)
+		for {
+			res := &prompb.ChunkedReadResponse{}
+			err := r.NextProto(res)
+			if err == io.EOF {
+				break
+			}
+			testutil.Ok(t, err)
+			for _, s := range res.ChunkedSeries {
+				lset := labels.NewScratchBuilder(len(s.Labels))
+				for _, l := range s.Labels {
+					lset.Add(l.Name, l.Value)
+				}
+				for _, c := range s.Chunks {
+					chk, err := chunkenc.FromData(chunkenc.EncXOR, c.Data)
+					testutil.Ok(t, err)
+					series[lset.Labels().String()] += chk.NumSamples()
+				}
+			}
+		}
+		return series
+	}
+
+	// Series are deduplicated by the replica labels of the querier by default.
+	testutil.Equals(t, map[string]int{
+		`{__name__="up", foo="bar"}`: 10,
+		`{__name__="up", foo="baz"}`: 10,
+	}, streamed("", nil))
+	testutil.Equals(t, map[string]int{
+		`{__name__="up", foo="bar", replica="a"}`: 10,
+		`{__name__="up", foo="bar", replica="b"}`: 10,
+		`{__name__="up", foo="baz", replica="a"}`: 10,
+	}, streamed("dedup=false", nil))
+
+	// Sampled responses are supported too.
+	resp := read("", nil, prompb.ReadRequest_SAMPLES)
+	testutil.Equals(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	testutil.Ok(t, err)
+	body, err = snappy.Decode(nil, body)
+	testutil.Ok(t, err)
+	var res prompb.ReadResponse
+	testutil.Ok(t, proto.Unmarshal(body, &res))
+	testutil.Equals(t, 1, len(res.Results))
+	testutil.Equals(t, 2, len(res.Results[0].Timeseries))
+
+	// Series of other tenants are not read when tenancy is enforced.
+	qapi.enforceTenancy = true
+	testutil.Equals(t, map[string]int{
+		`{__name__="up", foo="baz"}`: 10,
+	}, streamed("", http.Header{"Thanos-Tenant": []string{"baz"}}))
+
+	testutil.Equals(t, http.StatusBadRequest, read("dedup=maybe", nil, prompb.ReadRequest_STREAMED_XOR_CHUNKS).StatusCode)
+}
@@ -0,0 +1,190 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"slices"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+const (
+	// maxConcurrentShadowQueries is the maximum number of shadow queries executed at the same time, beyond which the
+	// queries are not shadowed.
+	maxConcurrentShadowQueries = 4
+	// shadowTolerance is the relative difference of the values of the engines above which their results mismatch, as
+	// the engines may not add up values in the same order.
+	shadowTolerance = 1e-6
+
+	shadowResultMatch    = "match"
+	shadowResultMismatch = "mismatch"
+	shadowResultError    = "error"
+)
+
+type makeShadowQuery func(ctx context.Context, t PromqlEngineType) (promql.Query, PromqlEngineType, error)
+
+// shadowed returns the query of the engine, executed in the background by the other engine too for a ratio of the
+// queries.
+func (f *QueryFactory) shadowed(q promql.Query, used PromqlEngineType, makeShadow makeShadowQuery) promql.Query {
+	if f.shadowRatio <= 0 || rand.Float64() >= f.shadowRatio {
+		return q
+	}
+	shadow := PromqlEngineThanos
+	if used == PromqlEngineThanos {
+		shadow = PromqlEnginePrometheus
+	}
+	return &shadowedQuery{Query: q, f: f, engine: shadow, makeShadow: makeShadow}
+}
+
+// shadowedQuery is a query whose result is compared to the one of the other engine, once executed.
+type shadowedQuery struct {
+	promql.Query
+
+	f          *QueryFactory
+	engine     PromqlEngineType
+	makeShadow makeShadowQuery
+}
+
+func (q *shadowedQuery) Exec(ctx context.Context) *promql.Result {
+	res := q.Query.Exec(ctx)
+	if res.Err != nil {
+		return res
+	}
+	select {
+	case q.f.shadowGate <- struct{}{}:
+	default:
+		// Too many shadow queries, the result is not compared.
+		return res
+	}
+
+	// The result is only valid until the query is closed.
+	expected := copyValue(res.Value)
+	go func() {
+		defer func() { <-q.f.shadowGate }()
+		q.execShadow(context.WithoutCancel(ctx), expected)
+	}()
+	return res
+}
+
+func (q *shadowedQuery) execShadow(ctx context.Context, expected parser.Value) {
+	shadow, used, err := q.makeShadow(ctx, q.engine)
+	if err != nil {
+		q.f.shadowQueries.WithLabelValues(string(q.engine), shadowResultError).Inc()
+		return
+	}
+	defer shadow.Close()
+	if used != q.engine {
+		// The shadow query fell back to the engine of the query.
+		return
+	}
+
+	res := shadow.Exec(ctx)
+	switch {
+	case res.Err != nil:
+		q.f.shadowQueries.WithLabelValues(string(q.engine), shadowResultError).Inc()
+		level.Warn(q.f.logger).Log("msg", "shadow query failed", "engine", q.engine, "query", q.String(), "err", res.Err)
+	case !equalValues(expected, res.Value):
+		q.f.shadowQueries.WithLabelValues(string(q.engine), shadowResultMismatch).Inc()
+		level.Warn(q.f.logger).Log("msg", "shadow query result mismatch", "engine", q.engine, "query", q.String())
+	default:
+		q.f.shadowQueries.WithLabelValues(string(q.engine), shadowResultMatch).Inc()
+	}
+}
+
+func copyValue(v parser.Value) parser.Value {
+	switch v := v.(type) {
+	case promql.Matrix:
+		m := make(promql.Matrix, 0, len(v))
+		for _, s := range v {
+			s.Floats = slices.Clone(s.Floats)
+			s.Histograms = slices.Clone(s.Histograms)
+			for i := range s.Histograms {
+				s.Histograms[i].H = s.Histograms[i].H.Copy()
+			}
+			m = append(m, s)
+		}
+		return m
+	case promql.Vector:
+		vec := slices.Clone(v)
+		for i := range vec {
+			if vec[i].H != nil {
+				vec[i].H = vec[i].H.Copy()
+			}
+		}
+		return vec
+	default:
+		return v
+	}
+}
+
+// equalValues returns true if the results are the same, regardless of the order of their series.
+func equalValues(a, b parser.Value) bool {
+	if a.Type() != b.Type() {
+		return false
+	}
+	switch a := a.(type) {
+	case promql.Matrix:
+		b := b.(promql.Matrix)
+		if len(a) != len(b) {
+			return false
+		}
+		a, b = slices.Clone(a), slices.Clone(b)
+		slices.SortFunc(a, func(x, y promql.Series) int { return labels.Compare(x.Metric, y.Metric) })
+		slices.SortFunc(b, func(x, y promql.Series) int { return labels.Compare(x.Metric, y.Metric) })
+		for i := range a {
+			if !labels.Equal(a[i].Metric, b[i].Metric) || len(a[i].Floats) != len(b[i].Floats) || len(a[i].Histograms) != len(b[i].Histograms) {
+				return false
+			}
+			for j := range a[i].Floats {
+				if a[i].Floats[j].T != b[i].Floats[j].T || !equalFloats(a[i].Floats[j].F, b[i].Floats[j].F) {
+					return false
+				}
+			}
+			for j := range a[i].Histograms {
+				if a[i].Histograms[j].T != b[i].Histograms[j].T || !a[i].Histograms[j].H.Equals(b[i].Histograms[j].H) {
+					return false
+				}
+			}
+		}
+		return true
+	case promql.Vector:
+		b := b.(promql.Vector)
+		if len(a) != len(b) {
+			return false
+		}
+		a, b = slices.Clone(a), slices.Clone(b)
+		slices.SortFunc(a, func(x, y promql.Sample) int { return labels.Compare(x.Metric, y.Metric) })
+		slices.SortFunc(b, func(x, y promql.Sample) int { return labels.Compare(x.Metric, y.Metric) })
+		for i := range a {
+			if !labels.Equal(a[i].Metric, b[i].Metric) || a[i].T != b[i].T || (a[i].H == nil) != (b[i].H == nil) {
+				return false
+			}
+			if a[i].H != nil && !a[i].H.Equals(b[i].H) || a[i].H == nil && !equalFloats(a[i].F, b[i].F) {
+				return false
+			}
+		}
+		return true
+	case promql.Scalar:
+		b := b.(promql.Scalar)
+		return a.T == b.T && equalFloats(a.V, b.V)
+	default:
+		return a.String() == b.String()
+	}
+}
+
+func equalFloats(a, b float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	if a == b {
+		return true
+	}
+	return math.Abs(a-b) <= shadowTolerance*math.Max(math.Abs(a), math.Abs(b))
+}
@@ -0,0 +1,108 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/thanos-io/promql-engine/engine"
+
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestEqualValues(t *testing.T) {
+	t.Parallel()
+
+	a, b := labels.FromStrings("job", "a"), labels.FromStrings("job", "b")
+	for _, tc := range []struct {
+		name     string
+		a, b     parser.Value
+		expected bool
+	}{
+		{
+			name:     "vectors in any order",
+			a:        promql.Vector{{Metric: a, F: 1}, {Metric: b, F: 2}},
+			b:        promql.Vector{{Metric: b, F: 2}, {Metric: a, F: 1}},
+			expected: true,
+		},
+		{
+			name:     "values within the tolerance",
+			a:        promql.Vector{{Metric: a, F: 0.1 + 0.2}},
+			b:        promql.Vector{{Metric: a, F: 0.3}},
+			expected: true,
+		},
+		{
+			name: "different values",
+			a:    promql.Vector{{Metric: a, F: 1}},
+			b:    promql.Vector{{Metric: a, F: 1.1}},
+		},
+		{
+			name: "different labels",
+			a:    promql.Vector{{Metric: a, F: 1}},
+			b:    promql.Vector{{Metric: b, F: 1}},
+		},
+		{
+			name:     "NaN",
+			a:        promql.Matrix{{Metric: a, Floats: []promql.FPoint{{T: 1, F: math.NaN()}}}},
+			b:        promql.Matrix{{Metric: a, Floats: []promql.FPoint{{T: 1, F: math.NaN()}}}},
+			expected: true,
+		},
+		{
+			name: "different timestamps",
+			a:    promql.Matrix{{Metric: a, Floats: []promql.FPoint{{T: 1, F: 1}}}},
+			b:    promql.Matrix{{Metric: a, Floats: []promql.FPoint{{T: 2, F: 1}}}},
+		},
+		{
+			name: "different types",
+			a:    promql.Scalar{T: 1, V: 1},
+			b:    promql.Vector{{Metric: a, T: 1, F: 1}},
+		},
+	} {
+		testutil.Equals(t, tc.expected, equalValues(tc.a, tc.b), tc.name)
+	}
+}
+
+func TestShadowedQuery(t *testing.T) {
+	t.Parallel()
+
+	db, err := e2eutil.NewTSDB()
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, db.Close()) }()
+
+	app := db.Appender(context.Background())
+	for _, job := range []string{"a", "b"} {
+		for i := int64(0); i < 10; i++ {
+			_, err := app.Append(0, labels.FromStrings(labels.MetricName, "up", "job", job), i*60000, float64(i))
+			testutil.Ok(t, err)
+		}
+	}
+	testutil.Ok(t, app.Commit())
+
+	f := NewQueryFactory(prometheus.NewRegistry(), log.NewNopLogger(), time.Minute, 5*time.Minute, 30*time.Second, false, nil, PromqlQueryModeLocal, false, 1)
+	q, err := f.makeRangeQuery(context.Background(), PromqlEnginePrometheus, db, nil, planOrQuery{query: "sum by (job) (rate(up[5m]))"}, &engine.QueryOpts{}, time.Unix(0, 0), time.Unix(600, 0), time.Minute)
+	testutil.Ok(t, err)
+	res := q.Exec(context.Background())
+	testutil.Ok(t, res.Err)
+	// The result of the query stays valid until it is closed, regardless of the shadow query.
+	testutil.Equals(t, 2, len(res.Value.(promql.Matrix)))
+	q.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for promtest.ToFloat64(f.shadowQueries.WithLabelValues(string(PromqlEngineThanos), shadowResultMatch)) != 1 {
+		testutil.Assert(t, time.Now().Before(deadline), "shadow query was not compared")
+		time.Sleep(10 * time.Millisecond)
+	}
+	testutil.Equals(t, 0.0, promtest.ToFloat64(f.shadowQueries.WithLabelValues(string(PromqlEngineThanos), shadowResultMismatch)))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(f.fallbacks))
+}
@@ -24,6 +24,7 @@ import (
 	"encoding/json"
 	"math"
 	"net/http"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -71,6 +72,7 @@ const (
 	DedupParam               = "dedup"
 	PartialResponseParam     = "partial_response"
 	MaxSourceResolutionParam = "max_source_resolution"
+	AutoDownsamplingParam    = "auto_downsampling"
 	ReplicaLabelsParam       = "replicaLabels[]"
 	MatcherParam             = "match[]"
 	StoreMatcherParam        = "storeMatch[]"
@@ -85,6 +87,24 @@ const (
 	FileParam                = "file[]"
 )
 
+// MaxSourceResolutionHeader is the response header reporting the max source resolution a query was evaluated with.
+const MaxSourceResolutionHeader = "X-Thanos-Max-Source-Resolution"
+
+// MaxSourceResolutionPolicy returns the max source resolution of the query of the request, given the one selected from
+// the request parameters and the auto downsampling configuration. It lets operators override the selection, for
+// example to always evaluate the queries of SLO recording rules on raw data.
+type MaxSourceResolutionPolicy func(r *http.Request, maxSourceResolution time.Duration) time.Duration
+
+// RawDataQueryPolicy returns the policy evaluating the queries matching the regular expression on raw data only.
+func RawDataQueryPolicy(re *regexp.Regexp) MaxSourceResolutionPolicy {
+	return func(r *http.Request, maxSourceResolution time.Duration) time.Duration {
+		if re.MatchString(r.FormValue(QueryParam)) {
+			return 0
+		}
+		return maxSourceResolution
+	}
+}
+
 // QueryAPI is an API used by Thanos Querier.
 type QueryAPI struct {
 	baseAPI               *api.BaseAPI
@@ -101,6 +121,7 @@ type QueryAPI struct {
 	exemplars             exemplars.UnaryClient
 
 	enableAutodownsampling              bool
+	maxSourceResolutionPolicy           MaxSourceResolutionPolicy
 	enableQueryPartialResponse          bool
 	enableRulePartialResponse           bool
 	enableTargetPartialResponse         bool
@@ -124,6 +145,9 @@ type QueryAPI struct {
 	tenantCertField string
 	enforceTenancy  bool
 	tenantLabel     string
+
+	// remoteRead is the Prometheus remote read handler, if enabled.
+	remoteRead http.Handler
 }
 
 // NewQueryAPI returns an initialized QueryAPI type.
@@ -235,11 +259,16 @@ func (qapi *QueryAPI) Register(r *route.Router, tracer opentracing.Tracer, logge
 	r.Get("/rules", instr("rules", NewRulesHandler(qapi.ruleGroups, qapi.enableRulePartialResponse)))
 
 	r.Get("/targets", instr("targets", NewTargetsHandler(qapi.targets, qapi.enableTargetPartialResponse)))
+	r.Get("/scrape_configs", instr("scrape_configs", NewScrapeConfigsHandler(qapi.targets, qapi.enableTargetPartialResponse)))
 
 	r.Get("/metadata", instr("metadata", NewMetricMetadataHandler(qapi.metadatas, qapi.enableMetricMetadataPartialResponse)))
 
 	r.Get("/query_exemplars", instr("exemplars", NewExemplarsHandler(qapi.exemplars, qapi.enableExemplarPartialResponse)))
 	r.Post("/query_exemplars", instr("exemplars", NewExemplarsHandler(qapi.exemplars, qapi.enableExemplarPartialResponse)))
+
+	if qapi.remoteRead != nil {
+		r.Post("/read", ins.NewHandler("remote_read", logMiddleware.HTTPMiddleware("remote_read", http.HandlerFunc(qapi.remoteReadHandler))))
+	}
 }
 
 type queryData struct {
@@ -251,6 +280,22 @@ type queryData struct {
 	Warnings      []error        `json:"warnings,omitempty"`
 }
 
+// queryStats are the query stats of the engine, with the statistics of the StoreAPIs queried and of the
+// deduplication of their series.
+type queryStats struct {
+	stats.BuiltinStats
+	Stores        []store.StoreStats        `json:"stores,omitempty"`
+	Deduplication *store.DeduplicationStats `json:"deduplication,omitempty"`
+}
+
+func newQueryStats(qry promql.Query, c *store.SeriesStatsCollector) stats.QueryStats {
+	return &queryStats{
+		BuiltinStats:  stats.NewQueryStats(qry.Stats()).Builtin(),
+		Stores:        c.Stores(),
+		Deduplication: c.Deduplication(),
+	}
+}
+
 type queryTelemetry struct {
 	// TODO(saswatamcode): Replace with engine.TrackedTelemetry once it has exported fields.
 	// TODO(saswatamcode): Add aggregate fields to enrich data.
@@ -335,11 +380,28 @@ func (qapi *QueryAPI) parseLookbackDeltaParam(r *http.Request) (time.Duration, *
 	return time.Duration(0), nil
 }
 
+// SetMaxSourceResolutionPolicy sets the policy overriding the max source resolution selected for each query.
+func (qapi *QueryAPI) SetMaxSourceResolutionPolicy(policy MaxSourceResolutionPolicy) {
+	qapi.maxSourceResolutionPolicy = policy
+}
+
+// parseDownsamplingParamMillis returns the max source resolution of the query of the request, and reports it in the
+// response header.
 func (qapi *QueryAPI) parseDownsamplingParamMillis(r *http.Request, defaultVal time.Duration) (maxResolutionMillis int64, _ *api.ApiError) {
 	maxSourceResolution := 0 * time.Second
 
+	enableAutodownsampling := qapi.enableAutodownsampling
+	// Overwrite the cli flag when provided as a query parameter.
+	if val := r.FormValue(AutoDownsamplingParam); val != "" {
+		var err error
+		enableAutodownsampling, err = strconv.ParseBool(val)
+		if err != nil {
+			return 0, &api.ApiError{Typ: api.ErrorBadData, Err: errors.Wrapf(err, "'%s' parameter", AutoDownsamplingParam)}
+		}
+	}
+
 	val := r.FormValue(MaxSourceResolutionParam)
-	if qapi.enableAutodownsampling || (val == "auto") {
+	if enableAutodownsampling || (val == "auto") {
 		maxSourceResolution = defaultVal
 	}
 	if val != "" && val != "auto" {
@@ -354,6 +416,11 @@ func (qapi *QueryAPI) parseDownsamplingParamMillis(r *http.Request, defaultVal t
 		return 0, &api.ApiError{Typ: api.ErrorBadData, Err: errors.Errorf("negative '%s' is not accepted. Try a positive integer", MaxSourceResolutionParam)}
 	}
 
+	if qapi.maxSourceResolutionPolicy != nil {
+		maxSourceResolution = qapi.maxSourceResolutionPolicy(r, maxSourceResolution)
+	}
+	api.SetResponseHeader(r.Context(), MaxSourceResolutionHeader, model.Duration(maxSourceResolution).String())
+
 	return int64(maxSourceResolution / time.Millisecond), nil
 }
 
@@ -644,7 +711,8 @@ func (qapi *QueryAPI) query(r *http.Request) (interface{}, []error, *api.ApiErro
 			enablePartialResponse,
 		)
 		queryOpts := &engine.QueryOpts{
-			LookbackDeltaParam: lookbackDelta,
+			LookbackDeltaParam:      lookbackDelta,
+			EnablePerStepStatsParam: r.FormValue(Stats) == "all",
 		}
 
 		var qErr error
@@ -654,6 +722,12 @@ func (qapi *QueryAPI) query(r *http.Request) (interface{}, []error, *api.ApiErro
 		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
 	}
 
+	var statsCollector *store.SeriesStatsCollector
+	if r.FormValue(Stats) != "" {
+		statsCollector = store.NewSeriesStatsCollector()
+		ctx = context.WithValue(ctx, store.SeriesStatsCollectorKey, statsCollector)
+	}
+
 	if err := tracing.DoInSpanWithErr(ctx, "query_gate_ismyturn", qapi.gate.Start); err != nil {
 		return nil, nil, &api.ApiError{Typ: api.ErrorExec, Err: err}, qry.Close
 	}
@@ -694,7 +768,7 @@ func (qapi *QueryAPI) query(r *http.Request) (interface{}, []error, *api.ApiErro
 	// Optional stats field in response if parameter "stats" is not empty.
 	var qs stats.QueryStats
 	if r.FormValue(Stats) != "" {
-		qs = stats.NewQueryStats(qry.Stats())
+		qs = newQueryStats(qry, statsCollector)
 	}
 	return &queryData{
 		ResultType:    res.Value.Type(),
@@ -704,6 +778,47 @@ func (qapi *QueryAPI) query(r *http.Request) (interface{}, []error, *api.ApiErro
 	}, warnings, nil, qry.Close
 }
 
+// RangeQuery evaluates the range query for the tenant with the default engine, deduplicated with the replica labels
+// of the querier and without partial responses, and calls f with its result. The result is only valid during the
+// call. It is the query function of the query export jobs.
+func (qapi *QueryAPI) RangeQuery(ctx context.Context, tenant, queryStr string, start, end time.Time, step time.Duration, f func(promql.Matrix) error) error {
+	ctx = context.WithValue(ctx, tenancy.TenantKey, tenant)
+
+	var maxSourceResolution int64
+	if qapi.enableAutodownsampling {
+		maxSourceResolution = (step / 5).Milliseconds()
+	}
+	queryable := qapi.queryableCreate(
+		true,
+		qapi.replicaLabels,
+		nil,
+		maxSourceResolution,
+		false,
+		false,
+		nil,
+		query.NoopSeriesStatsReporter,
+	)
+	remoteEndpoints := qapi.remoteEndpointsCreate(qapi.replicaLabels, false)
+	queryOpts := &engine.QueryOpts{
+		LookbackDeltaParam: qapi.lookbackDeltaCreate(maxSourceResolution),
+	}
+	qry, err := qapi.queryCreate.makeRangeQuery(ctx, qapi.defaultEngine, queryable, remoteEndpoints, planOrQuery{query: queryStr}, queryOpts, start, end, step)
+	if err != nil {
+		return err
+	}
+	defer qry.Close()
+
+	res := qry.Exec(ctx)
+	if res.Err != nil {
+		return res.Err
+	}
+	matrix, err := res.Matrix()
+	if err != nil {
+		return err
+	}
+	return f(matrix)
+}
+
 func (qapi *QueryAPI) queryRangeExplain(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
 	engineParam, apiErr := qapi.parseEngineParam(r)
 	if apiErr != nil {
@@ -955,7 +1070,8 @@ func (qapi *QueryAPI) queryRange(r *http.Request) (interface{}, []error, *api.Ap
 			enablePartialResponse,
 		)
 		queryOpts := &engine.QueryOpts{
-			LookbackDeltaParam: lookbackDelta,
+			LookbackDeltaParam:      lookbackDelta,
+			EnablePerStepStatsParam: r.FormValue(Stats) == "all",
 		}
 
 		var qErr error
@@ -965,6 +1081,12 @@ func (qapi *QueryAPI) queryRange(r *http.Request) (interface{}, []error, *api.Ap
 		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
 	}
 
+	var statsCollector *store.SeriesStatsCollector
+	if r.FormValue(Stats) != "" {
+		statsCollector = store.NewSeriesStatsCollector()
+		ctx = context.WithValue(ctx, store.SeriesStatsCollectorKey, statsCollector)
+	}
+
 	if err := tracing.DoInSpanWithErr(ctx, "query_gate_ismyturn", qapi.gate.Start); err != nil {
 		return nil, nil, &api.ApiError{Typ: api.ErrorExec, Err: err}, qry.Close
 	}
@@ -1003,7 +1125,7 @@ func (qapi *QueryAPI) queryRange(r *http.Request) (interface{}, []error, *api.Ap
 	// Optional stats field in response if parameter "stats" is not empty.
 	var qs stats.QueryStats
 	if r.FormValue(Stats) != "" {
-		qs = stats.NewQueryStats(qry.Stats())
+		qs = newQueryStats(qry, statsCollector)
 	}
 	return &queryData{
 		ResultType:    res.Value.Type(),
@@ -1016,8 +1138,12 @@ func (qapi *QueryAPI) queryRange(r *http.Request) (interface{}, []error, *api.Ap
 func (qapi *QueryAPI) labelValues(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
 	ctx := r.Context()
 	name := route.Param(ctx, "name")
+	// UTF-8 label names which cannot be in URL paths are escaped, like in the Prometheus API.
+	if strings.HasPrefix(name, "U__") {
+		name = model.UnescapeName(name, model.ValueEncodingEscaping)
+	}
 
-	if !model.LabelNameRE.MatchString(name) {
+	if !model.LabelName(name).IsValid() {
 		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: errors.Errorf("invalid label name: %q", name)}, func() {}
 	}
 
@@ -1318,6 +1444,15 @@ func NewTargetsHandler(client targets.UnaryClient, enablePartialResponse bool) f
 			state = int32(targetspb.TargetsRequest_ANY)
 		}
 
+		limit, err := parseLimitParam(r.FormValue("limit"))
+		if err != nil {
+			return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
+		}
+		offset, err := parseOffsetParam(r.FormValue("offset"))
+		if err != nil {
+			return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
+		}
+
 		req := &targetspb.TargetsRequest{
 			State:                   targetspb.TargetsRequest_State(state),
 			PartialResponseStrategy: ps,
@@ -1328,10 +1463,52 @@ func NewTargetsHandler(client targets.UnaryClient, enablePartialResponse bool) f
 			return nil, nil, &api.ApiError{Typ: api.ErrorInternal, Err: errors.Wrap(err, "retrieving targets")}, func() {}
 		}
 
+		// Targets are sorted, so that they can be paginated with the offset and the limit, which apply to the active and
+		// the dropped targets separately. The targets can be cached, so they are not modified.
+		if limit > 0 || offset > 0 {
+			t = &targetspb.TargetDiscovery{
+				ActiveTargets:  paginate(t.ActiveTargets, offset, limit),
+				DroppedTargets: paginate(t.DroppedTargets, offset, limit),
+			}
+		}
+
 		return t, warnings.AsErrors(), nil, func() {}
 	}
 }
 
+// NewScrapeConfigsHandler created handler serving the scrape configs of all the Prometheus servers at HTTP
+// /api/v1/scrape_configs, which uses gRPC Unary Targets API.
+func NewScrapeConfigsHandler(client targets.UnaryClient, enablePartialResponse bool) func(*http.Request) (interface{}, []error, *api.ApiError, func()) {
+	ps := storepb.PartialResponseStrategy_ABORT
+	if enablePartialResponse {
+		ps = storepb.PartialResponseStrategy_WARN
+	}
+
+	return func(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
+		req := &targetspb.ScrapeConfigsRequest{
+			PartialResponseStrategy: ps,
+		}
+
+		c, warnings, err := client.ScrapeConfigs(r.Context(), req)
+		if err != nil {
+			return nil, nil, &api.ApiError{Typ: api.ErrorInternal, Err: errors.Wrap(err, "retrieving scrape configs")}, func() {}
+		}
+
+		return c, warnings.AsErrors(), nil, func() {}
+	}
+}
+
+func paginate[T any](s []T, offset, limit int) []T {
+	if offset >= len(s) {
+		return s[:0:0]
+	}
+	s = s[offset:]
+	if limit > 0 && len(s) > limit {
+		s = s[:limit]
+	}
+	return s
+}
+
 // NewAlertsHandler created handler compatible with HTTP /api/v1/alerts https://prometheus.io/docs/prometheus/latest/querying/api/#alerts
 // which uses gRPC Unary Rules API (Rules API works for both /alerts and /rules).
 func NewAlertsHandler(client rules.UnaryClient, enablePartialResponse bool) func(*http.Request) (interface{}, []error, *api.ApiError, func()) {
@@ -1563,6 +1740,23 @@ func parseLimitParam(s string) (int, error) {
 	return limit, nil
 }
 
+// parseOffsetParam returning 0 means no offset is to be applied.
+func parseOffsetParam(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	offset, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.Errorf("cannot parse %q to a valid offset", s)
+	}
+	if offset < 0 {
+		return 0, errors.New("offset must be non-negative")
+	}
+
+	return offset, nil
+}
+
 // toHintLimit increases the API limit, as returned by parseLimitParam, by 1.
 // This allows for emitting warnings when the results are truncated.
 func toHintLimit(limit int) int {
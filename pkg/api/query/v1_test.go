@@ -26,6 +26,7 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -100,6 +101,7 @@ var (
 		nil,
 		PromqlQueryModeLocal,
 		false,
+		0,
 	)
 
 	emptyRemoteEndpointsCreate = query.NewRemoteEndpointsCreator(
@@ -215,7 +217,7 @@ func TestQueryEndpoints(t *testing.T) {
 		baseAPI: &baseAPI.BaseAPI{
 			Now: func() time.Time { return now },
 		},
-		queryableCreate:       query.NewQueryableCreator(nil, nil, newProxyStoreWithTSDBStore(db), 2, timeout, dedup.AlgorithmPenalty),
+		queryableCreate:       query.NewQueryableCreator(nil, nil, newProxyStoreWithTSDBStore(db), 2, timeout, dedup.AlgorithmPenalty, false),
 		remoteEndpointsCreate: emptyRemoteEndpointsCreate,
 		queryCreate:           queryFactory,
 		lookbackDeltaCreate:   func(m int64) time.Duration { return time.Duration(0) },
@@ -650,6 +652,33 @@ func TestQueryEndpoints(t *testing.T) {
 			return
 		}
 	}
+
+	// The stats break down the series fetched by StoreAPI, and report their deduplication and the samples per step.
+	req, err := http.NewRequest(http.MethodGet, "http://example.com?"+url.Values{
+		"query":           []string{"test_metric_replica1"},
+		"start":           []string{"0"},
+		"end":             []string{"120"},
+		"step":            []string{"60"},
+		"replicaLabels[]": []string{"replica"},
+		"stats":           []string{"all"},
+	}.Encode(), nil)
+	testutil.Ok(t, err)
+	res, _, apiErr, release := api.queryRange(req)
+	defer release()
+	testutil.Assert(t, apiErr == nil, "unexpected error %v", apiErr)
+
+	qStats := res.(*queryData).Stats.(*queryStats)
+	testutil.Equals(t, 1, len(qStats.Stores))
+	testutil.Equals(t, 4, qStats.Stores[0].Series)
+	testutil.Equals(t, &store.DeduplicationStats{InputSeries: 4, OutputSeries: 3}, qStats.Deduplication)
+	testutil.Equals(t, 3, len(qStats.Samples.TotalQueryableSamplesPerStep))
+
+	// The range queries of the query export jobs are deduplicated with the replica labels of the querier, none here.
+	testutil.Ok(t, api.RangeQuery(context.Background(), "default-tenant", "test_metric_replica1", time.Unix(0, 0), time.Unix(120, 0), time.Minute, func(m promql.Matrix) error {
+		testutil.Equals(t, 4, len(m))
+		testutil.Equals(t, 3, len(m[0].Floats))
+		return nil
+	}))
 }
 
 func TestQueryExplainEndpoints(t *testing.T) {
@@ -663,7 +692,7 @@ func TestQueryExplainEndpoints(t *testing.T) {
 		baseAPI: &baseAPI.BaseAPI{
 			Now: func() time.Time { return now },
 		},
-		queryableCreate:       query.NewQueryableCreator(nil, nil, newProxyStoreWithTSDBStore(db), 2, timeout, dedup.AlgorithmPenalty),
+		queryableCreate:       query.NewQueryableCreator(nil, nil, newProxyStoreWithTSDBStore(db), 2, timeout, dedup.AlgorithmPenalty, false),
 		remoteEndpointsCreate: emptyRemoteEndpointsCreate,
 		queryCreate:           queryFactory,
 		defaultEngine:         PromqlEnginePrometheus,
@@ -727,7 +756,7 @@ func TestQueryAnalyzeEndpoints(t *testing.T) {
 		baseAPI: &baseAPI.BaseAPI{
 			Now: func() time.Time { return now },
 		},
-		queryableCreate:       query.NewQueryableCreator(nil, nil, newProxyStoreWithTSDBStore(db), 2, timeout, dedup.AlgorithmPenalty),
+		queryableCreate:       query.NewQueryableCreator(nil, nil, newProxyStoreWithTSDBStore(db), 2, timeout, dedup.AlgorithmPenalty, false),
 		remoteEndpointsCreate: emptyRemoteEndpointsCreate,
 		queryCreate:           queryFactory,
 		defaultEngine:         PromqlEnginePrometheus,
@@ -896,7 +925,7 @@ func TestMetadataEndpoints(t *testing.T) {
 		baseAPI: &baseAPI.BaseAPI{
 			Now: func() time.Time { return now },
 		},
-		queryableCreate:       query.NewQueryableCreator(nil, nil, newProxyStoreWithTSDBStore(db), 2, timeout, dedup.AlgorithmPenalty),
+		queryableCreate:       query.NewQueryableCreator(nil, nil, newProxyStoreWithTSDBStore(db), 2, timeout, dedup.AlgorithmPenalty, false),
 		remoteEndpointsCreate: emptyRemoteEndpointsCreate,
 		queryCreate:           queryFactory,
 		defaultEngine:         PromqlEnginePrometheus,
@@ -913,7 +942,7 @@ func TestMetadataEndpoints(t *testing.T) {
 		baseAPI: &baseAPI.BaseAPI{
 			Now: func() time.Time { return now },
 		},
-		queryableCreate:          query.NewQueryableCreator(nil, nil, newProxyStoreWithTSDBStore(db), 2, timeout, dedup.AlgorithmPenalty),
+		queryableCreate:          query.NewQueryableCreator(nil, nil, newProxyStoreWithTSDBStore(db), 2, timeout, dedup.AlgorithmPenalty, false),
 		remoteEndpointsCreate:    emptyRemoteEndpointsCreate,
 		queryCreate:              queryFactory,
 		defaultEngine:            PromqlEnginePrometheus,
@@ -1107,11 +1136,22 @@ func TestMetadataEndpoints(t *testing.T) {
 			},
 			response: []string{"a"},
 		},
+		// Escaped UTF-8 name parameter.
+		{
+			endpoint: api.labelValues,
+			query: url.Values{
+				"match[]": []string{`test_metric_replica2`},
+			},
+			params: map[string]string{
+				"name": "U__replica1",
+			},
+			response: []string{"a"},
+		},
 		// Bad name parameter.
 		{
 			endpoint: api.labelValues,
 			params: map[string]string{
-				"name": "not!!!allowed",
+				"name": "not\xffallowed",
 			},
 			errType: baseAPI.ErrorBadData,
 		},
@@ -1701,6 +1741,40 @@ func TestParseDownsamplingParamMillis(t *testing.T) {
 	}
 }
 
+func TestParseDownsamplingParamMillis_AutoDownsamplingAndPolicy(t *testing.T) {
+	api := QueryAPI{enableAutodownsampling: true}
+	newRequest := func(v url.Values) *http.Request {
+		return &http.Request{PostForm: v}
+	}
+
+	maxResMillis, apiErr := api.parseDownsamplingParamMillis(newRequest(url.Values{AutoDownsamplingParam: []string{"false"}}), time.Minute)
+	testutil.Assert(t, apiErr == nil, "unexpected error %v", apiErr)
+	testutil.Equals(t, int64(0), maxResMillis)
+
+	api.enableAutodownsampling = false
+	maxResMillis, apiErr = api.parseDownsamplingParamMillis(newRequest(url.Values{AutoDownsamplingParam: []string{"true"}}), time.Minute)
+	testutil.Assert(t, apiErr == nil, "unexpected error %v", apiErr)
+	testutil.Equals(t, int64(time.Minute/time.Millisecond), maxResMillis)
+
+	_, apiErr = api.parseDownsamplingParamMillis(newRequest(url.Values{AutoDownsamplingParam: []string{"maybe"}}), time.Minute)
+	testutil.Assert(t, apiErr != nil, "expected error")
+
+	api.SetMaxSourceResolutionPolicy(RawDataQueryPolicy(regexp.MustCompile("slo:")))
+	maxResMillis, apiErr = api.parseDownsamplingParamMillis(newRequest(url.Values{
+		QueryParam:               []string{"sum(rate(slo:errors[5m]))"},
+		MaxSourceResolutionParam: []string{"1h"},
+	}), time.Minute)
+	testutil.Assert(t, apiErr == nil, "unexpected error %v", apiErr)
+	testutil.Equals(t, int64(0), maxResMillis)
+
+	maxResMillis, apiErr = api.parseDownsamplingParamMillis(newRequest(url.Values{
+		QueryParam:               []string{"sum(rate(http_requests_total[5m]))"},
+		MaxSourceResolutionParam: []string{"1h"},
+	}), time.Minute)
+	testutil.Assert(t, apiErr == nil, "unexpected error %v", apiErr)
+	testutil.Equals(t, int64(time.Hour/time.Millisecond), maxResMillis)
+}
+
 func TestParseStoreDebugMatchersParam(t *testing.T) {
 	for i, tc := range []struct {
 		storeMatchers string
@@ -0,0 +1,179 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/route"
+
+	"github.com/thanos-io/thanos/pkg/api"
+	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
+	"github.com/thanos-io/thanos/pkg/logging"
+	"github.com/thanos-io/thanos/pkg/queryexport"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+// Path is the path of the query export API.
+const Path = "/api/v1/query_export"
+
+// QueryExportAPI submits the export jobs of range queries, and serves their status and results.
+type QueryExportAPI struct {
+	logger          log.Logger
+	exports         *queryexport.Manager
+	disableCORS     bool
+	tenantHeader    string
+	defaultTenant   string
+	certTenantField string
+	enforceTenancy  bool
+	tenantLabel     string
+}
+
+// NewQueryExportAPI returns an API of the export jobs of the manager, for the tenant of the requests.
+func NewQueryExportAPI(logger log.Logger, exports *queryexport.Manager, disableCORS bool, tenantHeader, defaultTenant, certTenantField string, enforceTenancy bool, tenantLabel string) *QueryExportAPI {
+	return &QueryExportAPI{
+		logger:          logger,
+		exports:         exports,
+		disableCORS:     disableCORS,
+		tenantHeader:    tenantHeader,
+		defaultTenant:   defaultTenant,
+		certTenantField: certTenantField,
+		enforceTenancy:  enforceTenancy,
+		tenantLabel:     tenantLabel,
+	}
+}
+
+// Register registers the query export API.
+func (e *QueryExportAPI) Register(r *route.Router, tracer opentracing.Tracer, logger log.Logger, ins extpromhttp.InstrumentationMiddleware, logMiddleware *logging.HTTPServerMiddleware) {
+	instr := api.GetInstr(tracer, logger, ins, logMiddleware, e.disableCORS)
+
+	r.Post(Path, instr("query_export", e.submit))
+	r.Get(Path+"/:id", instr("query_export_job", e.job))
+	r.Get(Path+"/:id/result", ins.NewHandler("query_export_result", logMiddleware.HTTPMiddleware("query_export_result", http.HandlerFunc(e.result))))
+}
+
+func (e *QueryExportAPI) submit(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
+	tenant, err := tenancy.GetTenantFromHTTP(r, e.tenantHeader, e.defaultTenant, e.certTenantField)
+	if err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
+	}
+	query := r.FormValue("query")
+	if query == "" {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: errors.New("query must not be empty")}, func() {}
+	}
+	if e.enforceTenancy {
+		if query, err = tenancy.EnforceQueryTenancy(e.tenantLabel, tenant, query); err != nil {
+			return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
+		}
+	}
+	start, err := parseTime(r.FormValue("start"))
+	if err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: errors.Wrap(err, "start")}, func() {}
+	}
+	end, err := parseTime(r.FormValue("end"))
+	if err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: errors.Wrap(err, "end")}, func() {}
+	}
+	step, err := parseDuration(r.FormValue("step"))
+	if err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: errors.Wrap(err, "step")}, func() {}
+	}
+
+	req := queryexport.Request{
+		Tenant: tenant,
+		Query:  query,
+		Start:  start,
+		End:    end,
+		Step:   step,
+		Format: queryexport.Format(r.FormValue("format")),
+	}
+	if err := req.Validate(); err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
+	}
+	job, err := e.exports.Submit(r.Context(), req)
+	if errors.Is(err, queryexport.ErrTooManyJobs) {
+		return nil, nil, &api.ApiError{Typ: api.ErrorExec, Err: err}, func() {}
+	}
+	if err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorInternal, Err: err}, func() {}
+	}
+	return job, nil, nil, func() {}
+}
+
+func (e *QueryExportAPI) job(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
+	tenant, err := tenancy.GetTenantFromHTTP(r, e.tenantHeader, e.defaultTenant, e.certTenantField)
+	if err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
+	}
+	job, err := e.exports.Job(r.Context(), tenant, route.Param(r.Context(), "id"))
+	if errors.Is(err, queryexport.ErrJobNotFound) {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
+	}
+	if err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorInternal, Err: err}, func() {}
+	}
+	return job, nil, nil, func() {}
+}
+
+// result streams the result of the job as a file download.
+func (e *QueryExportAPI) result(w http.ResponseWriter, r *http.Request) {
+	if !e.disableCORS {
+		api.SetCORS(w)
+	}
+	tenant, err := tenancy.GetTenantFromHTTP(r, e.tenantHeader, e.defaultTenant, e.certTenantField)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	job, rc, err := e.exports.Result(r.Context(), tenant, route.Param(r.Context(), "id"))
+	switch {
+	case errors.Is(err, queryexport.ErrJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, queryexport.ErrJobNotSucceeded):
+		http.Error(w, errors.Wrapf(err, "status %s", job.Status).Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer runutil.CloseWithLogOnErr(e.logger, rc, "export job result reader")
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+job.ID+"."+string(job.Format)+`"`)
+	if _, err := io.Copy(w, rc); err != nil {
+		level.Warn(e.logger).Log("msg", "failed to write export job result", "id", job.ID, "err", err)
+	}
+}
+
+func parseTime(s string) (time.Time, error) {
+	if t, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, ns := math.Modf(t)
+		return time.Unix(int64(sec), int64(math.Round(ns*1000)/1000*float64(time.Second))), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.Errorf("cannot parse %q to a valid timestamp", s)
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if d, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(d * float64(time.Second)), nil
+	}
+	if d, err := model.ParseDuration(s); err == nil {
+		return time.Duration(d), nil
+	}
+	return 0, errors.Errorf("cannot parse %q to a valid duration", s)
+}
@@ -0,0 +1,100 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/route"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/thanos-io/objstore"
+
+	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
+	"github.com/thanos-io/thanos/pkg/logging"
+	"github.com/thanos-io/thanos/pkg/queryexport"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+func TestQueryExportAPI(t *testing.T) {
+	t.Parallel()
+
+	queries := make(chan string, 1)
+	m := queryexport.NewManager(log.NewNopLogger(), nil, objstore.NewInMemBucket(), func(_ context.Context, _, query string, start, _ time.Time, _ time.Duration, f func(promql.Matrix) error) error {
+		queries <- query
+		return f(promql.Matrix{{Metric: labels.FromStrings("a", "1"), Floats: []promql.FPoint{{T: start.UnixMilli(), F: 1}}}})
+	}, queryexport.Options{MaxQueued: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = m.Run(ctx) }()
+
+	r := route.New()
+	NewQueryExportAPI(log.NewNopLogger(), m, false, tenancy.DefaultTenantHeader, tenancy.DefaultTenant, "", true, tenancy.DefaultTenantLabel).
+		Register(r, opentracing.NoopTracer{}, log.NewNopLogger(), extpromhttp.NewNopInstrumentationMiddleware(), logging.NewHTTPServerMiddleware(log.NewNopLogger()))
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	get := func(path string) (int, []byte) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		testutil.Ok(t, err)
+		req.Header.Set(tenancy.DefaultTenantHeader, "team-a")
+		resp, err := http.DefaultClient.Do(req)
+		testutil.Ok(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		testutil.Ok(t, err)
+		return resp.StatusCode, b
+	}
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+Path, strings.NewReader(url.Values{
+		"query": []string{"up"},
+		"start": []string{"0"},
+		"end":   []string{"2020-01-01T00:00:00Z"},
+		"step":  []string{"1h"},
+	}.Encode()))
+	testutil.Ok(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(tenancy.DefaultTenantHeader, "team-a")
+	resp, err := http.DefaultClient.Do(req)
+	testutil.Ok(t, err)
+	var body struct {
+		Data queryexport.Job `json:"data"`
+	}
+	testutil.Ok(t, json.NewDecoder(resp.Body).Decode(&body))
+	testutil.Ok(t, resp.Body.Close())
+	testutil.Equals(t, http.StatusOK, resp.StatusCode)
+	testutil.Equals(t, "team-a", body.Data.Tenant)
+	testutil.Equals(t, 3600.0, body.Data.StepSeconds)
+
+	// The tenancy is enforced on the query.
+	testutil.Equals(t, `up{tenant_id="team-a"}`, <-queries)
+
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, ctx.Done(), func() error {
+		code, b := get(Path + "/" + body.Data.ID)
+		if code != http.StatusOK || !strings.Contains(string(b), `"status":"succeeded"`) {
+			return errors.Errorf("job not succeeded: %d %s", code, b)
+		}
+		return nil
+	}))
+
+	code, b := get(Path + "/" + body.Data.ID + "/result")
+	testutil.Equals(t, http.StatusOK, code)
+	testutil.Equals(t, "series,timestamp,value\n\"{a=\"\"1\"\"}\",0,1\n", string(b))
+
+	code, _ = get(Path + "/01JB0000000000000000000000/result")
+	testutil.Equals(t, http.StatusNotFound, code)
+}
@@ -0,0 +1,292 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package attestation signs the blocks uploaded to the object storage, and verifies them when they are synced, so
+// that tampered or truncated blocks are quarantined instead of being served or compacted.
+//
+// The attestation of a block is uploaded to <block>/attestation.json before its meta.json. It is a manifest of the
+// digest of the meta of the block and of the sizes and digests of its files, signed with Ed25519 with the key of
+// the recorded ID. Digests are computed from the contents of files before client side encryption, so attestations
+// are verified through the decrypting bucket.
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+const (
+	// Filename is the name of the attestation of a block in its directory.
+	Filename = block.AttestationFilename
+
+	manifestVersion1 = 1
+)
+
+// Attestation is the signed manifest of a block.
+type Attestation struct {
+	// KeyID is the ID of the key signing the manifest.
+	KeyID string `json:"key_id"`
+	// Manifest is the JSON encoded manifest, signed as is.
+	Manifest []byte `json:"manifest"`
+	// Signature is the Ed25519 signature of the manifest.
+	Signature []byte `json:"signature"`
+}
+
+// Manifest describes the contents of a block when it was uploaded.
+type Manifest struct {
+	Version int       `json:"version"`
+	BlockID ulid.ULID `json:"block_id"`
+	// MetaSHA256 is the hex encoded SHA-256 digest of the meta of the block, see MetaDigest.
+	MetaSHA256 string `json:"meta_sha256"`
+	Files      []File `json:"files"`
+}
+
+// File describes a file of a block.
+type File struct {
+	RelPath   string `json:"rel_path"`
+	SizeBytes int64  `json:"size_bytes"`
+	// SHA256 is the hex encoded SHA-256 digest of the content of the file. It is empty for files uploaded by
+	// another process, e.g. before a restart of the shipper, whose sizes only are attested.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// MetaDigest returns the hex encoded SHA-256 digest of the meta, encoded as a meta.json without the client side
+// encryption key ID, which is recorded below the attesting bucket.
+func MetaDigest(m *metadata.Meta) (string, error) {
+	c := *m
+	c.Thanos.Encryption = nil
+	if c.Thanos.Labels == nil {
+		c.Thanos.Labels = map[string]string{}
+	}
+	h := sha256.New()
+	if err := c.Write(h); err != nil {
+		return "", errors.Wrap(err, "encode meta")
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Sign returns the attestation of the manifest signed with the key of the given ID.
+func Sign(keyID string, key ed25519.PrivateKey, m Manifest) (*Attestation, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode manifest")
+	}
+	return &Attestation{KeyID: keyID, Manifest: b, Signature: ed25519.Sign(key, b)}, nil
+}
+
+// Verify verifies the signature of the attestation with the public keys by ID, and returns its manifest.
+func (a *Attestation) Verify(keys map[string]ed25519.PublicKey) (*Manifest, error) {
+	key, ok := keys[a.KeyID]
+	if !ok {
+		return nil, errors.Errorf("unknown key ID %q", a.KeyID)
+	}
+	if !ed25519.Verify(key, a.Manifest, a.Signature) {
+		return nil, errors.Errorf("invalid signature with key %q", a.KeyID)
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(a.Manifest, m); err != nil {
+		return nil, errors.Wrap(err, "decode manifest")
+	}
+	if m.Version != manifestVersion1 {
+		return nil, errors.Errorf("unexpected manifest version %d", m.Version)
+	}
+	return m, nil
+}
+
+// Bucket is an objstore.Bucket uploading the attestations of the blocks uploaded through it. It records the sizes
+// and digests of the files of blocks while they are uploaded, and uploads the attestation of a block when its
+// meta.json is uploaded.
+type Bucket struct {
+	objstore.Bucket
+
+	logger log.Logger
+	keyID  string
+	key    ed25519.PrivateKey
+
+	mtx   sync.Mutex
+	files map[string]map[string]File
+}
+
+// NewBucket wraps the bucket to sign the attestations of uploaded blocks with the key of the given ID.
+func NewBucket(logger log.Logger, bkt objstore.Bucket, keyID string, key ed25519.PrivateKey) *Bucket {
+	return &Bucket{Bucket: bkt, logger: logger, keyID: keyID, key: key, files: map[string]map[string]File{}}
+}
+
+// WrapWithConfig wraps the bucket to attest uploaded blocks if the configuration has a signing key. The bucket is
+// returned as is otherwise.
+func WrapWithConfig(logger log.Logger, bkt objstore.Bucket, conf *Config) objstore.Bucket {
+	if conf == nil || conf.signingKey == nil {
+		return bkt
+	}
+	level.Info(logger).Log("msg", "attestation of uploaded blocks enabled", "key_id", conf.SigningKeyID)
+	return NewBucket(logger, bkt, conf.SigningKeyID, conf.signingKey)
+}
+
+// blockFile returns the block directory, e.g. below the prefix of a tenant, and the path in it of the object, false
+// if the object is not in a block directory.
+func blockFile(name string) (string, string, bool) {
+	parts := strings.Split(name, "/")
+	for i, p := range parts[:len(parts)-1] {
+		if _, err := ulid.Parse(p); err == nil {
+			return path.Join(parts[:i+1]...), path.Join(parts[i+1:]...), true
+		}
+	}
+	return "", "", false
+}
+
+// Upload uploads the object, recording the size and digest of block files, and uploads the attestation of a block
+// before its meta.json.
+func (b *Bucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	dir, rel, ok := blockFile(name)
+	if ok && rel == metadata.MetaFilename {
+		return b.uploadMeta(ctx, dir, name, r)
+	}
+	if !ok || path.Ext(rel) == ".json" {
+		// Markers and attestations are not part of blocks.
+		return b.Bucket.Upload(ctx, name, r)
+	}
+
+	var (
+		h            = sha256.New()
+		cr           = &countingReader{r: r, h: h}
+		in io.Reader = cr
+	)
+	if rs, ok := r.(io.ReadSeeker); ok {
+		// Hash seekable readers, typically files, beforehand to upload them as they are, e.g. with their size.
+		off, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return errors.Wrapf(err, "seek %s", name)
+		}
+		if _, err := io.Copy(io.Discard, cr); err != nil {
+			return errors.Wrapf(err, "hash %s", name)
+		}
+		if _, err := rs.Seek(off, io.SeekStart); err != nil {
+			return errors.Wrapf(err, "rewind %s", name)
+		}
+		in = r
+	}
+	if err := b.Bucket.Upload(ctx, name, in); err != nil {
+		return err
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.files[dir] == nil {
+		b.files[dir] = map[string]File{}
+	}
+	b.files[dir][rel] = File{RelPath: rel, SizeBytes: cr.n, SHA256: hex.EncodeToString(h.Sum(nil))}
+	return nil
+}
+
+func (b *Bucket) uploadMeta(ctx context.Context, dir, name string, r io.Reader) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "read meta")
+	}
+	meta, err := metadata.Read(io.NopCloser(bytes.NewReader(content)))
+	if err != nil {
+		// Not a meta we know, upload it as is.
+		level.Warn(b.logger).Log("msg", "failed to decode uploaded block meta, not attesting block", "name", name, "err", err)
+		return b.Bucket.Upload(ctx, name, bytes.NewReader(content))
+	}
+
+	b.mtx.Lock()
+	recorded := make(map[string]File, len(b.files[dir]))
+	for rel, f := range b.files[dir] {
+		recorded[rel] = f
+	}
+	b.mtx.Unlock()
+
+	manifest, err := newManifest(meta, recorded)
+	if err != nil {
+		return err
+	}
+	a, err := Sign(b.keyID, b.key, manifest)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(a)
+	if err != nil {
+		return errors.Wrap(err, "encode attestation")
+	}
+	if err := b.Bucket.Upload(ctx, path.Join(dir, Filename), bytes.NewReader(encoded)); err != nil {
+		return errors.Wrap(err, "upload attestation")
+	}
+	if err := b.Bucket.Upload(ctx, name, bytes.NewReader(content)); err != nil {
+		return err
+	}
+
+	b.mtx.Lock()
+	delete(b.files, dir)
+	b.mtx.Unlock()
+	return nil
+}
+
+// newManifest returns the manifest of the block with the given meta. The recorded files are attested with their
+// digests, the other files of the meta with their sizes only.
+func newManifest(meta *metadata.Meta, recorded map[string]File) (Manifest, error) {
+	digest, err := MetaDigest(meta)
+	if err != nil {
+		return Manifest{}, err
+	}
+	m := Manifest{Version: manifestVersion1, BlockID: meta.ULID, MetaSHA256: digest}
+	for _, f := range recorded {
+		m.Files = append(m.Files, f)
+	}
+	for _, f := range meta.Thanos.Files {
+		rel := filepath.ToSlash(f.RelPath)
+		if _, ok := recorded[rel]; ok || rel == metadata.MetaFilename {
+			continue
+		}
+		m.Files = append(m.Files, File{RelPath: rel, SizeBytes: f.SizeBytes})
+	}
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].RelPath < m.Files[j].RelPath })
+	return m, nil
+}
+
+// Delete deletes the object, and forgets the recorded files of a block when its directory is deleted, e.g. after
+// a failed upload.
+func (b *Bucket) Delete(ctx context.Context, name string) error {
+	if dir, rel, ok := blockFile(name); ok {
+		b.mtx.Lock()
+		delete(b.files[dir], rel)
+		if len(b.files[dir]) == 0 {
+			delete(b.files, dir)
+		}
+		b.mtx.Unlock()
+	}
+	return b.Bucket.Delete(ctx, name)
+}
+
+type countingReader struct {
+	r io.Reader
+	h hash.Hash
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	_, _ = c.h.Write(p[:n])
+	return n, err
+}
@@ -0,0 +1,199 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/encryption"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/extprom"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func testSeed(b byte) []byte {
+	return bytes.Repeat([]byte{b}, ed25519.SeedSize)
+}
+
+func TestParseConfig(t *testing.T) {
+	t.Parallel()
+
+	conf, err := ParseConfig(nil)
+	testutil.Ok(t, err)
+	testutil.Assert(t, conf == nil)
+
+	seed := base64.StdEncoding.EncodeToString(testSeed(1))
+	pub := base64.StdEncoding.EncodeToString(ed25519.NewKeyFromSeed(testSeed(2)).Public().(ed25519.PublicKey))
+	conf, err = ParseConfig([]byte(fmt.Sprintf("signing_key_id: new\nsigning_key: %s\nverification_keys: {old: %s}\n", seed, pub)))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(conf.publicKeys))
+
+	for _, tcase := range []struct {
+		conf string
+		err  string
+	}{
+		{conf: "require_attestations: true", err: "no signing nor verification key"},
+		{conf: "signing_key: " + seed, err: "signing key without ID"},
+		{conf: "signing_key_id: a\nsigning_key: " + pub[:8], err: "signing key has"},
+		{conf: "signing_key_id: a\nsigning_key: " + seed + "\nverification_keys: {a: " + pub + "}", err: "not the public key of the signing key"},
+		{conf: "verification_keys: {a: '!'}", err: "decode verification key a"},
+		{conf: "unknown: true", err: "parsing block attestation config YAML"},
+	} {
+		_, err := ParseConfig([]byte(tcase.conf))
+		testutil.NotOk(t, err)
+		testutil.Assert(t, strings.Contains(err.Error(), tcase.err), "%s: %v", tcase.conf, err)
+	}
+}
+
+func TestAttestation(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	conf, err := ParseConfig([]byte(fmt.Sprintf(`
+signing_key_id: test
+signing_key: %s
+require_attestations: true
+verify_file_contents: true
+`, base64.StdEncoding.EncodeToString(testSeed(1)))))
+	testutil.Ok(t, err)
+
+	keys, err := encryption.NewStaticKeyProvider(encryption.StaticConfig{
+		CurrentKeyID: "kek",
+		Keys:         map[string]string{"kek": base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))},
+	})
+	testutil.Ok(t, err)
+	var (
+		inner  = objstore.NewInMemBucket()
+		encBkt = encryption.NewBucket(logger, inner, keys, "kek")
+		bkt    = WrapWithConfig(logger, encBkt, conf)
+		dir    = t.TempDir()
+	)
+
+	upload := func(bkt objstore.Bucket) ulid.ULID {
+		t.Helper()
+
+		id, err := e2eutil.CreateBlock(ctx, dir, []labels.Labels{labels.FromStrings("a", "1"), labels.FromStrings("a", "2")},
+			100, 0, 1000, labels.FromStrings("ext", "1"), 0, metadata.NoneFunc, nil)
+		testutil.Ok(t, err)
+		testutil.Ok(t, block.Upload(ctx, logger, bkt, filepath.Join(dir, id.String()), metadata.NoneFunc))
+		return id
+	}
+	var (
+		attested   = upload(bkt)
+		unattested = upload(encBkt)
+		truncated  = upload(bkt)
+		corrupted  = upload(bkt)
+		relabeled  = upload(bkt)
+	)
+	ok, err := inner.Exists(ctx, path.Join(unattested.String(), Filename))
+	testutil.Ok(t, err)
+	testutil.Assert(t, !ok)
+
+	rewrite := func(id ulid.ULID, update func([]byte) []byte) {
+		t.Helper()
+
+		name := path.Join(id.String(), "chunks", "000001")
+		rc, err := inner.Get(ctx, name)
+		testutil.Ok(t, err)
+		b, err := io.ReadAll(rc)
+		testutil.Ok(t, err)
+		testutil.Ok(t, rc.Close())
+		testutil.Ok(t, inner.Upload(ctx, name, bytes.NewReader(update(b))))
+	}
+	rewrite(truncated, func(b []byte) []byte { return b[:len(b)-1] })
+	rewrite(corrupted, func(b []byte) []byte { b[len(b)-1] ^= 1; return b })
+
+	metas := map[ulid.ULID]*metadata.Meta{}
+	for _, id := range []ulid.ULID{attested, unattested, truncated, corrupted, relabeled} {
+		m, err := block.DownloadMeta(ctx, logger, bkt, id)
+		testutil.Ok(t, err)
+		metas[id] = &m
+	}
+	testutil.Equals(t, "kek", metas[attested].Thanos.Encryption.KeyID)
+	metas[relabeled].Thanos.Labels["ext"] = "2"
+
+	f := NewFilter(logger, bkt, conf, 2, prometheus.NewRegistry())
+	synced := extprom.NewTxGaugeVec(nil, prometheus.GaugeOpts{}, []string{"state"})
+	testutil.Ok(t, f.Filter(ctx, metas, synced, nil))
+	testutil.Equals(t, 1, len(metas))
+	testutil.Assert(t, metas[attested] != nil)
+	testutil.Equals(t, 4.0, promtest.ToFloat64(f.failures))
+
+	// Verified blocks are not verified again.
+	testutil.Ok(t, inner.Delete(ctx, path.Join(attested.String(), Filename)))
+	testutil.Ok(t, f.Filter(ctx, metas, synced, nil))
+	testutil.Equals(t, 1, len(metas))
+
+	// Unattested blocks are served unless attestations are required.
+	c := *conf
+	c.RequireAttestations = false
+	metas = map[ulid.ULID]*metadata.Meta{attested: metas[attested]}
+	testutil.Ok(t, NewFilter(logger, bkt, &c, 1, prometheus.NewRegistry()).Filter(ctx, metas, synced, nil))
+	testutil.Equals(t, 1, len(metas))
+
+	// Copies of blocks with new labels are attested again by the attesting bucket.
+	stubCounter := prometheus.NewCounter(prometheus.CounterOpts{})
+	relabelConfig, err := block.ParseRelabelConfig([]byte("- {target_label: ext, replacement: '2'}"), nil)
+	testutil.Ok(t, err)
+	relabeledID, err := block.RelabelExternalLabels(ctx, logger, bkt, nil, attested, relabelConfig, stubCounter, stubCounter)
+	testutil.Ok(t, err)
+	m, err := block.DownloadMeta(ctx, logger, bkt, relabeledID)
+	testutil.Ok(t, err)
+	metas = map[ulid.ULID]*metadata.Meta{relabeledID: &m}
+	testutil.Ok(t, NewFilter(logger, bkt, conf, 1, prometheus.NewRegistry()).Filter(ctx, metas, synced, nil))
+	testutil.Equals(t, 1, len(metas))
+
+	// Errors reading files are retried instead of quarantining blocks.
+	metas = map[ulid.ULID]*metadata.Meta{relabeledID: &m}
+	testutil.NotOk(t, NewFilter(logger, failingReadBucket{bkt}, conf, 1, prometheus.NewRegistry()).Filter(ctx, metas, synced, nil))
+	testutil.Equals(t, 1, len(metas))
+
+	// Blocks below the prefix of a tenant are attested too.
+	tenantBkt := objstore.NewPrefixedBucket(bkt, "tenant")
+	tenant := upload(tenantBkt)
+	m, err = block.DownloadMeta(ctx, logger, tenantBkt, tenant)
+	testutil.Ok(t, err)
+	metas = map[ulid.ULID]*metadata.Meta{tenant: &m}
+	testutil.Ok(t, NewFilter(logger, tenantBkt, conf, 1, prometheus.NewRegistry()).Filter(ctx, metas, synced, nil))
+	testutil.Equals(t, 1, len(metas))
+}
+
+// failingReadBucket is a bucket failing to read the content of chunk files.
+type failingReadBucket struct {
+	objstore.Bucket
+}
+
+func (b failingReadBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, err := b.Bucket.Get(ctx, name)
+	if err != nil || !strings.Contains(name, block.ChunksDirname) {
+		return rc, err
+	}
+	return failingReader{rc}, nil
+}
+
+type failingReader struct {
+	io.ReadCloser
+}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
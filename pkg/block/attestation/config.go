@@ -0,0 +1,82 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package attestation
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the block attestation configuration.
+type Config struct {
+	// SigningKeyID is the ID of the key signing the attestations of uploaded blocks, recorded in the attestations.
+	SigningKeyID string `yaml:"signing_key_id"`
+	// SigningKey is the base64 encoded 32 bytes Ed25519 seed of the signing key. Blocks are uploaded without
+	// attestation if empty.
+	SigningKey string `yaml:"signing_key"`
+	// VerificationKeys are base64 encoded Ed25519 public keys by ID, verifying the attestations of blocks. The public
+	// key of the signing key is added to them. Keys not signing anymore are kept to verify older blocks.
+	VerificationKeys map[string]string `yaml:"verification_keys"`
+	// RequireAttestations quarantines the blocks without attestation, instead of only the blocks with an invalid one.
+	RequireAttestations bool `yaml:"require_attestations"`
+	// VerifyFileContents verifies the digests of the contents of block files in addition to their sizes, downloading
+	// every block once when it is first synced.
+	VerifyFileContents bool `yaml:"verify_file_contents"`
+
+	signingKey ed25519.PrivateKey
+	publicKeys map[string]ed25519.PublicKey
+}
+
+// ParseConfig parses the block attestation configuration. It returns nil if the configuration is empty.
+func ParseConfig(confContentYaml []byte) (*Config, error) {
+	if len(bytes.TrimSpace(confContentYaml)) == 0 {
+		return nil, nil
+	}
+	conf := &Config{}
+	if err := yaml.UnmarshalStrict(confContentYaml, conf); err != nil {
+		return nil, errors.Wrap(err, "parsing block attestation config YAML")
+	}
+
+	conf.publicKeys = make(map[string]ed25519.PublicKey, len(conf.VerificationKeys)+1)
+	for id, k := range conf.VerificationKeys {
+		if id == "" {
+			return nil, errors.New("verification key with empty ID")
+		}
+		b, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode verification key %s", id)
+		}
+		if len(b) != ed25519.PublicKeySize {
+			return nil, errors.Errorf("verification key %s has %d bytes, expected %d", id, len(b), ed25519.PublicKeySize)
+		}
+		conf.publicKeys[id] = b
+	}
+
+	if conf.SigningKey != "" {
+		if conf.SigningKeyID == "" {
+			return nil, errors.New("signing key without ID")
+		}
+		b, err := base64.StdEncoding.DecodeString(conf.SigningKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "decode signing key")
+		}
+		if len(b) != ed25519.SeedSize {
+			return nil, errors.Errorf("signing key has %d bytes, expected %d", len(b), ed25519.SeedSize)
+		}
+		conf.signingKey = ed25519.NewKeyFromSeed(b)
+		pub := conf.signingKey.Public().(ed25519.PublicKey)
+		if k, ok := conf.publicKeys[conf.SigningKeyID]; ok && !k.Equal(pub) {
+			return nil, errors.Errorf("verification key %s is not the public key of the signing key", conf.SigningKeyID)
+		}
+		conf.publicKeys[conf.SigningKeyID] = pub
+	}
+	if len(conf.publicKeys) == 0 {
+		return nil, errors.New("no signing nor verification key")
+	}
+	return conf, nil
+}
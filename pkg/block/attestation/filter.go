@@ -0,0 +1,210 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package attestation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"path"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/encryption"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// errInvalid is the cause of the errors of blocks failing their verification.
+var errInvalid = errors.New("invalid block attestation")
+
+// Filter is a block.MetadataFilter quarantining the blocks whose attestation fails verification, and the blocks
+// without attestation if attestations are required. Verified blocks are remembered, so that every block is
+// verified once.
+type Filter struct {
+	logger      log.Logger
+	bkt         objstore.BucketReader
+	conf        *Config
+	concurrency int
+
+	failures prometheus.Counter
+
+	mtx      sync.Mutex
+	verified map[ulid.ULID]struct{}
+}
+
+// NewFilter returns the filter verifying the attestations of blocks read from the bucket, nil if the configuration
+// is nil.
+func NewFilter(logger log.Logger, bkt objstore.BucketReader, conf *Config, concurrency int, reg prometheus.Registerer) *Filter {
+	if conf == nil {
+		return nil
+	}
+	return &Filter{
+		logger:      logger,
+		bkt:         bkt,
+		conf:        conf,
+		concurrency: concurrency,
+		failures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_block_attestation_failures_total",
+			Help: "Total number of blocks quarantined because their attestation is missing or fails verification.",
+		}),
+		verified: map[ulid.ULID]struct{}{},
+	}
+}
+
+// Filter removes the blocks failing verification from the metas.
+func (f *Filter) Filter(ctx context.Context, metas map[ulid.ULID]*metadata.Meta, synced block.GaugeVec, _ block.GaugeVec) error {
+	f.mtx.Lock()
+	for id := range f.verified {
+		if _, ok := metas[id]; !ok {
+			delete(f.verified, id)
+		}
+	}
+	toVerify := make(map[ulid.ULID]*metadata.Meta, len(metas))
+	for id, m := range metas {
+		if _, ok := f.verified[id]; !ok {
+			toVerify[id] = m
+		}
+	}
+	f.mtx.Unlock()
+
+	var (
+		eg  errgroup.Group
+		ch  = make(chan ulid.ULID, f.concurrency)
+		mtx sync.Mutex
+	)
+	for i := 0; i < f.concurrency; i++ {
+		eg.Go(func() error {
+			var lastErr error
+			for id := range ch {
+				err := f.verify(ctx, toVerify[id])
+				if err == nil {
+					f.mtx.Lock()
+					f.verified[id] = struct{}{}
+					f.mtx.Unlock()
+					continue
+				}
+				if errors.Cause(err) != errInvalid {
+					// Remember the last error and continue to drain the channel.
+					lastErr = err
+					continue
+				}
+				level.Warn(f.logger).Log("msg", "quarantining block failing attestation verification", "block", id, "err", err)
+				f.failures.Inc()
+				mtx.Lock()
+				synced.WithLabelValues(block.QuarantinedMeta).Inc()
+				delete(metas, id)
+				mtx.Unlock()
+			}
+			return lastErr
+		})
+	}
+
+	eg.Go(func() error {
+		defer close(ch)
+
+		for id := range toVerify {
+			select {
+			case ch <- id:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	if err := eg.Wait(); err != nil {
+		return errors.Wrap(err, "verify block attestations")
+	}
+	return nil
+}
+
+// verify verifies the attestation of the block. The error has errInvalid as cause if the block fails verification.
+func (f *Filter) verify(ctx context.Context, meta *metadata.Meta) error {
+	dir := meta.ULID.String()
+	rc, err := f.bkt.Get(ctx, path.Join(dir, Filename))
+	if f.bkt.IsObjNotFoundErr(err) {
+		if f.conf.RequireAttestations {
+			return errors.Wrap(errInvalid, "no attestation")
+		}
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "get attestation of %s", dir)
+	}
+	a := &Attestation{}
+	err = json.NewDecoder(rc).Decode(a)
+	runutil.ExhaustCloseWithLogOnErr(f.logger, rc, "close attestation")
+	if err != nil {
+		return errors.Wrapf(errInvalid, "decode attestation: %s", err)
+	}
+	m, err := a.Verify(f.conf.publicKeys)
+	if err != nil {
+		return errors.Wrap(errInvalid, err.Error())
+	}
+	if m.BlockID != meta.ULID {
+		return errors.Wrapf(errInvalid, "attestation of block %s", m.BlockID)
+	}
+	digest, err := MetaDigest(meta)
+	if err != nil {
+		return err
+	}
+	if digest != m.MetaSHA256 {
+		return errors.Wrap(errInvalid, "meta does not match attested digest")
+	}
+
+	for _, file := range m.Files {
+		name := path.Join(dir, file.RelPath)
+		attrs, err := f.bkt.Attributes(ctx, name)
+		if f.bkt.IsObjNotFoundErr(err) {
+			return errors.Wrapf(errInvalid, "attested file %s not found", file.RelPath)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "attributes of %s", name)
+		}
+		if attrs.Size != file.SizeBytes {
+			return errors.Wrapf(errInvalid, "file %s has %d bytes, attested %d", file.RelPath, attrs.Size, file.SizeBytes)
+		}
+		if !f.conf.VerifyFileContents || file.SHA256 == "" {
+			continue
+		}
+		if err := f.verifyContent(ctx, name, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Filter) verifyContent(ctx context.Context, name string, file File) error {
+	rc, err := f.bkt.Get(ctx, name)
+	if err != nil {
+		return errors.Wrapf(err, "get %s", name)
+	}
+	defer runutil.CloseWithLogOnErr(f.logger, rc, "close %s", name)
+
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		// Tampered encrypted files fail to decrypt. Other read errors may be transient, and are retried on the next
+		// sync instead of quarantining the block.
+		if errors.Is(err, encryption.ErrDecrypt) {
+			return errors.Wrapf(errInvalid, "read file %s: %s", file.RelPath, err)
+		}
+		return errors.Wrapf(err, "read %s", name)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != file.SHA256 {
+		return errors.Wrapf(errInvalid, "content of file %s does not match attested digest", file.RelPath)
+	}
+	return nil
+}
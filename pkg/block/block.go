@@ -25,6 +25,7 @@ import (
 	"github.com/thanos-io/objstore"
 
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
 	"github.com/thanos-io/thanos/pkg/runutil"
 )
 
@@ -34,6 +35,7 @@ const (
 	// IndexFilename is the known index file for block index.
 	IndexFilename = "index"
 	// IndexHeaderFilename is the canonical name for binary index header file that stores essential information.
+	// It is also the name of the optional index-header uploaded next to the block by the compactor.
 	IndexHeaderFilename = "index-header"
 	// ChunksDirname is the known dir name for chunks with compressed samples.
 	ChunksDirname = "chunks"
@@ -42,10 +44,24 @@ const (
 	DebugMetas = "debug/metas"
 )
 
+// optionalFiles are the files of a block that are uploaded with it if present.
+var optionalFiles = []string{ExemplarsFilename, MetricMetadataFilename}
+
+// hasFile returns true if the files of the block listed by its meta include the file.
+func hasFile(meta *metadata.Meta, name string) bool {
+	for _, f := range meta.Thanos.Files {
+		if f.RelPath == name {
+			return true
+		}
+	}
+	return false
+}
+
 // Download downloads directory that is mean to be block directory. If any of the files
 // have a hash calculated in the meta file and it matches with what is in the destination path then
 // we do not download it. We always re-download the meta file.
 func Download(ctx context.Context, logger log.Logger, bucket objstore.Bucket, id ulid.ULID, dst string, options ...objstore.DownloadOption) error {
+	ctx = objstoreutil.WithCaller(ctx, objstoreutil.CallerDownloader)
 	if err := os.MkdirAll(dst, 0750); err != nil {
 		return errors.Wrap(err, "create dir")
 	}
@@ -78,6 +94,20 @@ func Download(ctx context.Context, logger log.Logger, bucket objstore.Bucket, id
 		return err
 	}
 
+	// Object storages may return truncated objects without error, which would be taken for corrupted blocks.
+	for _, fl := range m.Thanos.Files {
+		if fl.RelPath == "" || fl.RelPath == MetaFilename {
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(dst, fl.RelPath))
+		if err != nil {
+			return errors.Wrapf(err, "stat downloaded %s", fl.RelPath)
+		}
+		if fi.Size() != fl.SizeBytes {
+			return errors.Errorf("downloaded %s has %d bytes, expected %d", fl.RelPath, fi.Size(), fl.SizeBytes)
+		}
+	}
+
 	chunksDir := filepath.Join(dst, ChunksDirname)
 	_, err = os.Stat(chunksDir)
 	if os.IsNotExist(err) {
@@ -109,6 +139,7 @@ func UploadPromBlock(ctx context.Context, logger log.Logger, bkt objstore.Bucket
 // TODO(bplotka): Ensure bucket operations have reasonable backoff retries.
 // NOTE: Upload updates `meta.Thanos.File` section.
 func upload(ctx context.Context, logger log.Logger, bkt objstore.Bucket, bdir string, hf metadata.HashFunc, checkExternalLabels bool, options ...objstore.UploadOption) error {
+	ctx = objstoreutil.WithCaller(ctx, objstoreutil.CallerUploader)
 	df, err := os.Stat(bdir)
 	if err != nil {
 		return err
@@ -128,6 +159,7 @@ func upload(ctx context.Context, logger log.Logger, bkt objstore.Bucket, bdir st
 		// No meta or broken meta file.
 		return errors.Wrap(err, "read meta")
 	}
+	ctx = objstoreutil.WithExternalLabels(ctx, meta.Thanos.Labels)
 
 	if checkExternalLabels {
 		if len(meta.Thanos.Labels) == 0 {
@@ -145,12 +177,12 @@ func upload(ctx context.Context, logger log.Logger, bkt objstore.Bucket, bdir st
 		return errors.Wrap(err, "encode meta file")
 	}
 
-	if err := objstore.UploadDir(ctx, logger, bkt, filepath.Join(bdir, ChunksDirname), path.Join(id.String(), ChunksDirname), options...); err != nil {
-		return cleanUp(logger, bkt, id, errors.Wrap(err, "upload chunks"))
-	}
-
-	if err := objstore.UploadFile(ctx, logger, bkt, filepath.Join(bdir, IndexFilename), path.Join(id.String(), IndexFilename)); err != nil {
-		return cleanUp(logger, bkt, id, errors.Wrap(err, "upload index"))
+	if m := uploadManifestFromContext(ctx); m != nil {
+		if err := uploadFilesWithManifest(ctx, logger, bkt, bdir, meta, m); err != nil {
+			return err
+		}
+	} else if err := uploadFiles(ctx, logger, bkt, bdir, meta, options...); err != nil {
+		return err
 	}
 
 	// Meta.json always need to be uploaded as a last item. This will allow to assume block directories without meta file to be pending uploads.
@@ -165,6 +197,118 @@ func upload(ctx context.Context, logger log.Logger, bkt objstore.Bucket, bdir st
 	return nil
 }
 
+// uploadFiles uploads the files of the block but meta.json, cleaning up the block on errors.
+func uploadFiles(ctx context.Context, logger log.Logger, bkt objstore.Bucket, bdir string, meta *metadata.Meta, options ...objstore.UploadOption) error {
+	id := meta.ULID
+	if err := objstore.UploadDir(ctx, logger, bkt, filepath.Join(bdir, ChunksDirname), path.Join(id.String(), ChunksDirname), options...); err != nil {
+		return cleanUp(logger, bkt, id, errors.Wrap(err, "upload chunks"))
+	}
+
+	if err := objstore.UploadFile(ctx, logger, bkt, filepath.Join(bdir, IndexFilename), path.Join(id.String(), IndexFilename)); err != nil {
+		return cleanUp(logger, bkt, id, errors.Wrap(err, "upload index"))
+	}
+
+	for _, name := range optionalFiles {
+		if !hasFile(meta, name) {
+			continue
+		}
+		if err := objstore.UploadFile(ctx, logger, bkt, filepath.Join(bdir, name), path.Join(id.String(), name)); err != nil {
+			return cleanUp(logger, bkt, id, errors.Wrapf(err, "upload %s", name))
+		}
+	}
+	return nil
+}
+
+// uploadFilesWithManifest uploads the files of the block but meta.json, skipping the files the manifest records as
+// uploaded already. The remote size of every file, and the remote hash of the files hashed in the meta, are verified,
+// so that meta.json is uploaded only once the block is complete. On errors, uploaded files are kept to resume on the
+// next attempt.
+func uploadFilesWithManifest(ctx context.Context, logger log.Logger, bkt objstore.Bucket, bdir string, meta *metadata.Meta, m UploadManifest) error {
+	for _, f := range meta.Thanos.Files {
+		if f.RelPath == MetaFilename {
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(bdir, f.RelPath))
+		if err != nil {
+			return errors.Wrapf(err, "stat %s", f.RelPath)
+		}
+		if fi.IsDir() {
+			continue
+		}
+		name := path.Join(meta.ULID.String(), filepath.ToSlash(f.RelPath))
+		if m.Uploaded(ctx, f, fi) {
+			continue
+		}
+		if err := objstore.UploadFile(ctx, logger, bkt, filepath.Join(bdir, f.RelPath), name); err != nil {
+			return errors.Wrapf(err, "upload %s", f.RelPath)
+		}
+		m.Add(f, fi)
+	}
+
+	for _, f := range meta.Thanos.Files {
+		if f.RelPath == MetaFilename {
+			continue
+		}
+		name := path.Join(meta.ULID.String(), filepath.ToSlash(f.RelPath))
+		attrs, err := bkt.Attributes(ctx, name)
+		if err != nil {
+			return errors.Wrapf(err, "verify %s", name)
+		}
+		if attrs.Size != f.SizeBytes {
+			m.Reset()
+			return errors.Errorf("verify %s: remote size %d does not match local size %d", name, attrs.Size, f.SizeBytes)
+		}
+		if f.Hash == nil || f.Hash.Func == metadata.NoneFunc {
+			continue
+		}
+		h, err := objectHash(ctx, logger, bkt, name, f.Hash.Func)
+		if err != nil {
+			return errors.Wrapf(err, "verify %s", name)
+		}
+		if !f.Hash.Equal(&h) {
+			m.Reset()
+			return errors.Errorf("verify %s: remote hash %s does not match local hash %s", name, h.Value, f.Hash.Value)
+		}
+	}
+	return nil
+}
+
+// objectHash returns the hash of the given type of the content of the object.
+func objectHash(ctx context.Context, logger log.Logger, bkt objstore.BucketReader, name string, hf metadata.HashFunc) (metadata.ObjectHash, error) {
+	rc, err := bkt.Get(ctx, name)
+	if err != nil {
+		return metadata.ObjectHash{}, err
+	}
+	defer runutil.CloseWithLogOnErr(logger, rc, "close %s", name)
+	return metadata.CalculateReaderHash(rc, hf)
+}
+
+// UploadManifest records the files of a block uploaded so far, so that an upload interrupted, e.g. by a restart,
+// resumes without uploading them again.
+type UploadManifest interface {
+	// Uploaded returns true if the local file of the block was uploaded already and does not have to be uploaded
+	// again.
+	Uploaded(ctx context.Context, f metadata.File, fi os.FileInfo) bool
+	// Add records the local file of the block as uploaded.
+	Add(f metadata.File, fi os.FileInfo)
+	// Reset forgets the uploaded files, so that the next attempt uploads the whole block again.
+	Reset()
+}
+
+type uploadManifestKey struct{}
+
+// WithUploadManifest returns a context making the uploads of blocks made with it resume from the given manifest.
+// These uploads verify the remote size of every file, and the remote hash of the files hashed with the hash function
+// of the upload, before uploading meta.json, and keep the uploaded files on errors instead of cleaning up the block.
+func WithUploadManifest(ctx context.Context, m UploadManifest) context.Context {
+	return context.WithValue(ctx, uploadManifestKey{}, m)
+}
+
+func uploadManifestFromContext(ctx context.Context) UploadManifest {
+	m, _ := ctx.Value(uploadManifestKey{}).(UploadManifest)
+	return m
+}
+
 func cleanUp(logger log.Logger, bkt objstore.Bucket, id ulid.ULID, err error) error {
 	// Cleanup the dir with an uncancelable context.
 	cleanErr := Delete(context.Background(), logger, bkt, id)
@@ -174,6 +318,19 @@ func cleanUp(logger log.Logger, bkt objstore.Bucket, id ulid.ULID, err error) er
 	return err
 }
 
+type markerWriterKey struct{}
+
+// WithMarkerWriter returns a context making the deletion and no-compact markers written with it record the given writer.
+func WithMarkerWriter(ctx context.Context, w *metadata.MarkerWriter) context.Context {
+	return context.WithValue(ctx, markerWriterKey{}, w)
+}
+
+// MarkerWriterFromContext returns the writer recorded in markers written with the context, nil if none.
+func MarkerWriterFromContext(ctx context.Context) *metadata.MarkerWriter {
+	w, _ := ctx.Value(markerWriterKey{}).(*metadata.MarkerWriter)
+	return w
+}
+
 // MarkForDeletion creates a file which stores information about when the block was marked for deletion.
 func MarkForDeletion(ctx context.Context, logger log.Logger, bkt objstore.Bucket, id ulid.ULID, details string, markedForDeletion prometheus.Counter) error {
 	deletionMarkFile := path.Join(id.String(), metadata.DeletionMarkFilename)
@@ -191,6 +348,7 @@ func MarkForDeletion(ctx context.Context, logger log.Logger, bkt objstore.Bucket
 		DeletionTime: time.Now().Unix(),
 		Version:      metadata.DeletionMarkVersion1,
 		Details:      details,
+		Writer:       MarkerWriterFromContext(ctx),
 	})
 	if err != nil {
 		return errors.Wrap(err, "json encode deletion mark")
@@ -211,6 +369,7 @@ func MarkForDeletion(ctx context.Context, logger log.Logger, bkt objstore.Bucket
 //     only if they don't have meta.json. If meta.json is present Thanos assumes valid block.
 //   - This avoids deleting empty dir (whole bucket) by mistake.
 func Delete(ctx context.Context, logger log.Logger, bkt objstore.Bucket, id ulid.ULID) error {
+	ctx = objstoreutil.WithCaller(ctx, objstoreutil.CallerDeleter)
 	metaFile := path.Join(id.String(), MetaFilename)
 	deletionMarkFile := path.Join(id.String(), metadata.DeletionMarkFilename)
 
@@ -320,6 +479,19 @@ func GetSegmentFiles(blockDir string) []string {
 	return result
 }
 
+// DirSize returns the total size of the files of the TSDB block in the directory which are uploaded with the block.
+func DirSize(blockDir string) (int64, error) {
+	files, err := GatherFileStats(blockDir, metadata.NoneFunc, nil)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, f := range files {
+		size += f.SizeBytes
+	}
+	return size, nil
+}
+
 // GatherFileStats returns metadata.File entry for files inside TSDB block (index, chunks, meta.json).
 func GatherFileStats(blockDir string, hf metadata.HashFunc, logger log.Logger) (res []metadata.File, _ error) {
 	files, err := os.ReadDir(filepath.Join(blockDir, ChunksDirname))
@@ -363,6 +535,28 @@ func GatherFileStats(blockDir string, hf metadata.HashFunc, logger log.Logger) (
 	}
 	res = append(res, mf)
 
+	for _, name := range optionalFiles {
+		f, err := os.Stat(filepath.Join(blockDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "stat %v", filepath.Join(blockDir, name))
+		}
+		mf := metadata.File{
+			RelPath:   f.Name(),
+			SizeBytes: f.Size(),
+		}
+		if hf != metadata.NoneFunc {
+			h, err := metadata.CalculateHash(filepath.Join(blockDir, name), hf, logger)
+			if err != nil {
+				return nil, errors.Wrapf(err, "calculate hash %v", f.Name())
+			}
+			mf.Hash = &h
+		}
+		res = append(res, mf)
+	}
+
 	metaFile, err := os.Stat(filepath.Join(blockDir, MetaFilename))
 	if err != nil {
 		return nil, errors.Wrapf(err, "stat %v", filepath.Join(blockDir, MetaFilename))
@@ -383,8 +577,20 @@ func MarkForNoCompact(ctx context.Context, logger log.Logger, bkt objstore.Bucke
 		return errors.Wrapf(err, "check exists %s in bucket", m)
 	}
 	if noCompactMarkExists {
-		level.Warn(logger).Log("msg", "requested to mark for no compaction, but file already exists; this should not happen; investigate", "err", errors.Errorf("file %s already exists in bucket", m))
-		return nil
+		// The reasons of MarkForNoCompact apply to the whole block, so that a mark scoped to a time range is replaced.
+		existing := metadata.NoCompactMark{}
+		err := metadata.ReadMarker(ctx, logger, objstore.WithNoopInstr(bkt), id.String(), &existing)
+		switch {
+		case err == nil && existing.TimeRange == nil:
+			level.Warn(logger).Log("msg", "requested to mark for no compaction, but file already exists; this should not happen; investigate", "err", errors.Errorf("file %s already exists in bucket", m))
+			return nil
+		case err == nil:
+			level.Info(logger).Log("msg", "replacing no-compact mark scoped to a time range with a mark of the whole block", "block", id, "min_time", existing.TimeRange.MinTime, "max_time", existing.TimeRange.MaxTime, "reason", reason)
+		case errors.Cause(err) == metadata.ErrorUnmarshalMarker:
+			level.Warn(logger).Log("msg", "replacing partial no-compact mark", "block", id, "err", err)
+		case errors.Cause(err) != metadata.ErrorMarkerNotFound:
+			return errors.Wrapf(err, "read %s", m)
+		}
 	}
 
 	noCompactMark, err := json.Marshal(metadata.NoCompactMark{
@@ -394,6 +600,7 @@ func MarkForNoCompact(ctx context.Context, logger log.Logger, bkt objstore.Bucke
 		NoCompactTime: time.Now().Unix(),
 		Reason:        reason,
 		Details:       details,
+		Writer:        MarkerWriterFromContext(ctx),
 	})
 	if err != nil {
 		return errors.Wrap(err, "json encode no compact mark")
@@ -456,3 +663,19 @@ func RemoveMark(ctx context.Context, logger log.Logger, bkt objstore.Bucket, id
 	level.Info(logger).Log("msg", "mark has been removed from the block", "block", id)
 	return nil
 }
+
+// compactionRanges are the default ranges of blocks produced by the compactor.
+var compactionRanges = []time.Duration{2 * time.Hour, 8 * time.Hour, 2 * 24 * time.Hour, 14 * 24 * time.Hour}
+
+// CompatibleBlockDuration returns, in milliseconds, the longest default compaction range not longer than d, so that
+// blocks written with it align with the blocks produced by the compactor.
+func CompatibleBlockDuration(d time.Duration) int64 {
+	res := compactionRanges[0]
+	for _, r := range compactionRanges {
+		if r > d {
+			break
+		}
+		res = r
+	}
+	return res.Milliseconds()
+}
@@ -29,6 +29,7 @@ import (
 
 	"github.com/efficientgo/core/testutil"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
 	"github.com/thanos-io/thanos/pkg/testutil/custom"
 	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
 )
@@ -279,6 +280,47 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestUploadDownloadDelete_Layout(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+	ctx := context.Background()
+
+	tmpDir, bktDir := t.TempDir(), t.TempDir()
+	bkt, err := objstoreutil.NewBucket(log.NewNopLogger(), []byte(`type: LAYOUT
+config:
+  index_prefix: hot
+  chunks_prefix: cold
+  bucket:
+    type: FILESYSTEM
+    config:
+      directory: `+bktDir), "test", nil)
+	testutil.Ok(t, err)
+
+	b1, err := e2eutil.CreateBlock(ctx, tmpDir, []labels.Labels{
+		labels.New(labels.Label{Name: "a", Value: "1"}),
+		labels.New(labels.Label{Name: "a", Value: "2"}),
+	}, 100, 0, 1000, labels.New(labels.Label{Name: "ext1", Value: "val1"}), 124, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+	testutil.Ok(t, Upload(ctx, log.NewNopLogger(), bkt, path.Join(tmpDir, b1.String()), metadata.NoneFunc))
+
+	for _, name := range []string{path.Join("hot", b1.String(), IndexFilename), path.Join("cold", b1.String(), ChunksDirname, "000001"), path.Join(b1.String(), MetaFilename)} {
+		_, err := os.Stat(path.Join(bktDir, name))
+		testutil.Ok(t, err)
+	}
+
+	dst := path.Join(t.TempDir(), b1.String())
+	testutil.Ok(t, Download(ctx, log.NewNopLogger(), bkt, b1, dst))
+	for _, name := range []string{IndexFilename, path.Join(ChunksDirname, "000001"), MetaFilename} {
+		_, err := os.Stat(path.Join(dst, name))
+		testutil.Ok(t, err)
+	}
+
+	testutil.Ok(t, Delete(ctx, log.NewNopLogger(), bkt, b1))
+	for _, name := range []string{path.Join("hot", b1.String(), IndexFilename), path.Join("cold", b1.String(), ChunksDirname, "000001"), path.Join(b1.String(), MetaFilename)} {
+		_, err := os.Stat(path.Join(bktDir, name))
+		testutil.Assert(t, os.IsNotExist(err), name)
+	}
+}
+
 func TestMarkForDeletion(t *testing.T) {
 	defer custom.TolerantVerifyLeak(t)
 	ctx := context.Background()
@@ -363,6 +405,21 @@ func TestMarkForNoCompact(t *testing.T) {
 			},
 			blocksMarked: 0,
 		},
+		{
+			name: "block with no-compact mark of a time range, expected mark of the whole block",
+			preUpload: func(t testing.TB, id ulid.ULID, bkt objstore.Bucket) {
+				m, err := json.Marshal(metadata.NoCompactMark{
+					ID:            id,
+					NoCompactTime: time.Now().Unix(),
+					Version:       metadata.NoCompactMarkVersion1,
+					Reason:        metadata.ManualNoCompactReason,
+					TimeRange:     &metadata.NoCompactTimeRange{MinTime: 0, MaxTime: 500},
+				})
+				testutil.Ok(t, err)
+				testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), metadata.NoCompactMarkFilename), bytes.NewReader(m)))
+			},
+			blocksMarked: 1,
+		},
 	} {
 		t.Run(tcase.name, func(t *testing.T) {
 			bkt := objstore.NewInMemBucket()
@@ -380,9 +437,15 @@ func TestMarkForNoCompact(t *testing.T) {
 			testutil.Ok(t, Upload(ctx, log.NewNopLogger(), bkt, path.Join(tmpDir, id.String()), metadata.NoneFunc))
 
 			c := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
-			err = MarkForNoCompact(ctx, log.NewNopLogger(), bkt, id, metadata.ManualNoCompactReason, "", c)
+			err = MarkForNoCompact(ctx, log.NewNopLogger(), bkt, id, metadata.OutOfOrderChunksNoCompactReason, "", c)
 			testutil.Ok(t, err)
 			testutil.Equals(t, float64(tcase.blocksMarked), promtest.ToFloat64(c))
+
+			// The block is excluded from all compactions.
+			mark := metadata.NoCompactMark{}
+			testutil.Ok(t, metadata.ReadMarker(ctx, log.NewNopLogger(), objstore.WithNoopInstr(bkt), id.String(), &mark))
+			testutil.Assert(t, mark.TimeRange == nil, "mark scoped to a time range")
+			testutil.Assert(t, mark.Excludes(0, 1000))
 		})
 	}
 }
@@ -740,3 +803,16 @@ func TestRemoveMmarkForNoDownsample(t *testing.T) {
 		})
 	}
 }
+
+func TestCompatibleBlockDuration(t *testing.T) {
+	t.Parallel()
+
+	for d, expected := range map[time.Duration]time.Duration{
+		2 * time.Hour:       2 * time.Hour,
+		7 * time.Hour:       2 * time.Hour,
+		24 * time.Hour:      8 * time.Hour,
+		30 * 24 * time.Hour: 14 * 24 * time.Hour,
+	} {
+		testutil.Equals(t, expected.Milliseconds(), CompatibleBlockDuration(d))
+	}
+}
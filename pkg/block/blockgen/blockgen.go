@@ -0,0 +1,399 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package blockgen generates synthetic blocks in a bucket, e.g. to benchmark compaction, downsampling or the store
+// gateway at scale without production data.
+package blockgen
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+	"github.com/thanos-io/thanos/pkg/logutil"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+const (
+	// maxSamplesInAppender bounds the number of samples kept in memory before committing them.
+	maxSamplesInAppender = 5000
+	// histogramBuckets is the number of positive buckets of generated native histograms.
+	histogramBuckets = 8
+)
+
+// Options configures the generated blocks.
+type Options struct {
+	// Series is the number of series scraped at any time.
+	Series int
+	// Metrics is the number of metric names the series are spread over. Every target exposes all the metrics, so
+	// that there are Series/Metrics targets.
+	Metrics int
+	// ExtraLabels is the number of additional labels of every series, with a few values each, e.g. to mimic
+	// zones or environments.
+	ExtraLabels int
+	// ScrapeInterval is the interval between the raw samples of a series.
+	ScrapeInterval time.Duration
+	// ChurnRatio is the ratio of the targets whose series are replaced by new ones every ChurnInterval, e.g.
+	// to mimic restarting pods.
+	ChurnRatio    float64
+	ChurnInterval time.Duration
+	// NativeHistograms is the ratio of the metrics that are native histograms. Half of the other metrics are
+	// counters, the other half gauges.
+	NativeHistograms float64
+	// Resolution is the downsampling resolution of the uploaded blocks, in milliseconds. Raw blocks are
+	// generated and downsampled with the compactor's algorithm if it is not 0.
+	Resolution int64
+	// BlockDuration is the maximum time range of a generated block. It is rounded down to a range the
+	// compactor compacts to, so that generated blocks are aligned with compacted ones.
+	BlockDuration time.Duration
+	// MinTime and MaxTime bound the samples of the generated blocks.
+	MinTime, MaxTime time.Time
+	// ExternalLabels are set as Thanos external labels of the generated blocks.
+	ExternalLabels labels.Labels
+	// TmpDir is the directory blocks are written to before being uploaded.
+	TmpDir string
+	// Seed seeds the generated sample values, the same options generating the same samples.
+	Seed int64
+}
+
+// Generator writes synthetic samples into time aligned blocks and uploads them to the bucket.
+type Generator struct {
+	logger log.Logger
+	bkt    objstore.Bucket
+	opts   Options
+
+	rng    *rand.Rand
+	series []series
+}
+
+// New creates a new Generator.
+func New(logger log.Logger, bkt objstore.Bucket, opts Options) (*Generator, error) {
+	switch {
+	case opts.Series <= 0:
+		return nil, errors.New("number of series has to be positive")
+	case opts.Metrics <= 0 || opts.Metrics > opts.Series:
+		return nil, errors.New("number of metrics has to be positive and not greater than the number of series")
+	case opts.ExtraLabels < 0:
+		return nil, errors.New("number of extra labels cannot be negative")
+	case opts.ScrapeInterval < time.Millisecond:
+		return nil, errors.New("scrape interval has to be at least 1ms")
+	case opts.ChurnRatio < 0 || opts.ChurnRatio > 1:
+		return nil, errors.New("churn ratio has to be between 0 and 1")
+	case opts.ChurnRatio > 0 && opts.ChurnInterval < opts.ScrapeInterval:
+		return nil, errors.New("churn interval cannot be shorter than the scrape interval")
+	case opts.NativeHistograms < 0 || opts.NativeHistograms > 1:
+		return nil, errors.New("native histograms ratio has to be between 0 and 1")
+	case opts.Resolution != downsample.ResLevel0 && opts.Resolution != downsample.ResLevel1 && opts.Resolution != downsample.ResLevel2:
+		return nil, errors.Errorf("unsupported resolution %d", opts.Resolution)
+	case opts.BlockDuration < 2*time.Hour:
+		return nil, errors.New("block duration has to be at least 2h")
+	case !opts.MaxTime.After(opts.MinTime):
+		return nil, errors.New("max time has to be after min time")
+	case opts.ExternalLabels.IsEmpty():
+		return nil, errors.New("empty external labels are not allowed for Thanos block")
+	}
+
+	g := &Generator{
+		logger: logger,
+		bkt:    bkt,
+		opts:   opts,
+		rng:    rand.New(rand.NewSource(opts.Seed)),
+		series: make([]series, opts.Series),
+	}
+	var (
+		targets        = (opts.Series + opts.Metrics - 1) / opts.Metrics
+		churnedTargets = int(math.Round(opts.ChurnRatio * float64(targets)))
+		histograms     = int(math.Round(opts.NativeHistograms * float64(opts.Metrics)))
+	)
+	for i := range g.series {
+		s := &g.series[i]
+		s.metric, s.target = i%opts.Metrics, i/opts.Metrics
+		switch {
+		case s.metric < histograms:
+			s.kind = kindHistogram
+		case (s.metric-histograms)%2 == 0:
+			s.kind = kindCounter
+		default:
+			s.kind = kindGauge
+		}
+		s.churns = s.target < churnedTargets
+		s.generation = math.MinInt64
+		// Targets are scraped at different offsets within the scrape interval.
+		s.offset = int64(s.target*7919) % opts.ScrapeInterval.Milliseconds()
+		s.rate = 1 + float64(i%100)
+	}
+	return g, nil
+}
+
+// Generate writes and uploads the blocks. It returns the metas of the uploaded blocks.
+func (g *Generator) Generate(ctx context.Context) ([]metadata.Meta, error) {
+	var (
+		metas []metadata.Meta
+		mint  = g.opts.MinTime.UnixMilli()
+		maxt  = g.opts.MaxTime.UnixMilli()
+		bd    = block.CompatibleBlockDuration(g.opts.BlockDuration)
+	)
+	for start := mint / bd * bd; start < maxt; start += bd {
+		meta, err := g.generateWindow(ctx, max(start, mint), min(start+bd, maxt))
+		if err != nil {
+			return metas, errors.Wrapf(err, "generate %v - %v", time.UnixMilli(start).UTC(), time.UnixMilli(start+bd).UTC())
+		}
+		if meta != nil {
+			metas = append(metas, *meta)
+		}
+	}
+	return metas, nil
+}
+
+// generateWindow writes and uploads a single block with the samples in [start, end). It returns a nil meta if
+// there is no sample in the window.
+func (g *Generator) generateWindow(ctx context.Context, start, end int64) (*metadata.Meta, error) {
+	dir, err := os.MkdirTemp(g.opts.TmpDir, "generate")
+	if err != nil {
+		return nil, errors.Wrap(err, "create tmp dir")
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			level.Warn(g.logger).Log("msg", "failed to remove tmp dir", "dir", dir, "err", err)
+		}
+	}()
+
+	// Pretend the block is twice as large, as in the importer, for the block writer to accept the samples of
+	// series scraped at different offsets.
+	w, err := tsdb.NewBlockWriter(logutil.GoKitLogToSlog(g.logger), dir, 2*(end-start))
+	if err != nil {
+		return nil, errors.Wrap(err, "create block writer")
+	}
+	defer runutil.CloseWithLogOnErr(g.logger, w, "block writer")
+
+	// References are only valid for the head of the current block writer.
+	for i := range g.series {
+		g.series[i].ref = 0
+	}
+	var (
+		samples, pending int
+		interval         = g.opts.ScrapeInterval.Milliseconds()
+		app              = w.Appender(ctx)
+	)
+	for t := start - start%interval; t < end; t += interval {
+		if err := ctx.Err(); err != nil {
+			_ = app.Rollback()
+			return nil, err
+		}
+		for i := range g.series {
+			s := &g.series[i]
+			ts := t + s.offset
+			if ts < start || ts >= end {
+				continue
+			}
+			if err := g.append(app, s, ts); err != nil {
+				_ = app.Rollback()
+				return nil, err
+			}
+			samples++
+			if pending++; pending >= maxSamplesInAppender {
+				if err := app.Commit(); err != nil {
+					return nil, errors.Wrap(err, "commit")
+				}
+				app, pending = w.Appender(ctx), 0
+			}
+		}
+	}
+	if err := app.Commit(); err != nil {
+		return nil, errors.Wrap(err, "commit")
+	}
+	if samples == 0 {
+		return nil, nil
+	}
+
+	id, err := w.Flush(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "flush block")
+	}
+	bdir := filepath.Join(dir, id.String())
+	meta, err := metadata.InjectThanos(g.logger, bdir, metadata.Thanos{
+		Labels:     g.opts.ExternalLabels.Map(),
+		Downsample: metadata.ThanosDownsample{Resolution: downsample.ResLevel0},
+		Source:     metadata.BucketGenerateSource,
+	}, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "inject thanos meta")
+	}
+
+	// Downsample to 5m first to downsample to 1h, as the compactor does.
+	for _, res := range []int64{downsample.ResLevel1, downsample.ResLevel2} {
+		if res > g.opts.Resolution {
+			break
+		}
+		dmeta, err := g.downsample(ctx, dir, meta, res)
+		if err != nil {
+			return nil, errors.Wrapf(err, "downsample block %s to %d", meta.ULID, res)
+		}
+		meta, bdir = dmeta, filepath.Join(dir, dmeta.ULID.String())
+	}
+
+	if err := block.Upload(ctx, g.logger, g.bkt, bdir, metadata.NoneFunc); err != nil {
+		return nil, errors.Wrapf(err, "upload block %s", meta.ULID)
+	}
+	level.Info(g.logger).Log("msg", "uploaded generated block", "block", meta.ULID, "mint", meta.MinTime, "maxt", meta.MaxTime,
+		"resolution", meta.Thanos.Downsample.Resolution, "series", meta.Stats.NumSeries, "samples", samples)
+	return meta, nil
+}
+
+// downsample downsamples the block of the meta in dir to the resolution and returns the meta of the new block.
+func (g *Generator) downsample(ctx context.Context, dir string, meta *metadata.Meta, resolution int64) (*metadata.Meta, error) {
+	pool := chunkenc.NewPool()
+	if meta.Thanos.Downsample.Resolution > 0 {
+		pool = downsample.NewPool()
+	}
+	b, err := tsdb.OpenBlock(logutil.GoKitLogToSlog(g.logger), filepath.Join(dir, meta.ULID.String()), pool, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "open block")
+	}
+	defer runutil.CloseWithLogOnErr(g.logger, b, "tsdb reader")
+
+	id, err := downsample.Downsample(ctx, g.logger, meta, b, dir, resolution)
+	if err != nil {
+		return nil, err
+	}
+	bdir := filepath.Join(dir, id.String())
+	m, err := metadata.ReadFromDir(bdir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read meta")
+	}
+	// Downsample marks the new block as written by the compactor.
+	m.Thanos.Source = metadata.BucketGenerateSource
+	if err := m.WriteToDir(g.logger, bdir); err != nil {
+		return nil, errors.Wrap(err, "write meta")
+	}
+	return m, nil
+}
+
+type kind int
+
+const (
+	kindCounter kind = iota
+	kindGauge
+	kindHistogram
+)
+
+// series is the state of a generated series. The series of churning targets are replaced at every churn
+// interval, the state being reset.
+type series struct {
+	metric, target int
+	kind           kind
+	churns         bool
+	offset         int64
+	rate           float64
+
+	generation int64
+	lset       labels.Labels
+	ref        storage.SeriesRef
+
+	value   float64
+	buckets [histogramBuckets]uint64
+	zero    uint64
+}
+
+func (g *Generator) append(app storage.Appender, s *series, t int64) error {
+	gen := int64(0)
+	if s.churns {
+		gen = t / g.opts.ChurnInterval.Milliseconds()
+	}
+	if gen != s.generation {
+		s.generation, s.lset, s.ref = gen, g.labels(s, gen), 0
+		s.value, s.buckets, s.zero = 0, [histogramBuckets]uint64{}, 0
+		if s.kind == kindGauge {
+			s.value = 100 * s.rate
+		}
+	}
+
+	var err error
+	switch s.kind {
+	case kindCounter:
+		s.value += g.rng.Float64() * s.rate
+		s.ref, err = app.Append(s.ref, s.lset, t, s.value)
+	case kindGauge:
+		s.value = max(0, s.value+g.rng.NormFloat64()*s.rate)
+		s.ref, err = app.Append(s.ref, s.lset, t, s.value)
+	case kindHistogram:
+		s.ref, err = app.AppendHistogram(s.ref, s.lset, t, g.observe(s), nil)
+	}
+	return errors.Wrapf(err, "append %s", s.lset)
+}
+
+// observe adds observations to the histogram of the series and returns it. Observations are exponentially
+// distributed over the buckets.
+func (g *Generator) observe(s *series) *histogram.Histogram {
+	for n := g.rng.Intn(int(s.rate)) + 1; n > 0; n-- {
+		b := int(g.rng.ExpFloat64())
+		if b >= histogramBuckets {
+			b = histogramBuckets - 1
+		}
+		if g.rng.Intn(20) == 0 {
+			s.zero++
+			continue
+		}
+		s.buckets[b]++
+		// Buckets of schema 0 are (2^(b-1), 2^b].
+		s.value += math.Ldexp(1+g.rng.Float64(), b-1)
+	}
+
+	h := &histogram.Histogram{
+		Schema:          0,
+		ZeroThreshold:   1e-3,
+		ZeroCount:       s.zero,
+		Count:           s.zero,
+		Sum:             s.value,
+		PositiveSpans:   []histogram.Span{{Offset: 0, Length: histogramBuckets}},
+		PositiveBuckets: make([]int64, histogramBuckets),
+	}
+	prev := int64(0)
+	for i, c := range s.buckets {
+		h.Count += c
+		h.PositiveBuckets[i] = int64(c) - prev
+		prev = int64(c)
+	}
+	return h
+}
+
+func (g *Generator) labels(s *series, gen int64) labels.Labels {
+	var name string
+	switch s.kind {
+	case kindCounter:
+		name = fmt.Sprintf("synthetic_counter_%d_total", s.metric)
+	case kindGauge:
+		name = fmt.Sprintf("synthetic_gauge_%d", s.metric)
+	case kindHistogram:
+		name = fmt.Sprintf("synthetic_histogram_%d_seconds", s.metric)
+	}
+
+	b := labels.NewScratchBuilder(4 + g.opts.ExtraLabels)
+	b.Add(labels.MetricName, name)
+	b.Add("job", "synthetic")
+	b.Add("instance", "target-"+strconv.Itoa(s.target))
+	b.Add("pod", fmt.Sprintf("pod-%d-%d", s.target, gen))
+	for i := 0; i < g.opts.ExtraLabels; i++ {
+		b.Add("label_"+strconv.Itoa(i), "value-"+strconv.Itoa((s.target>>i)%4))
+	}
+	b.Sort()
+	return b.Labels()
+}
@@ -0,0 +1,84 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package blockgen
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+)
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	opts := Options{
+		Series:           20,
+		Metrics:          4,
+		ExtraLabels:      2,
+		ScrapeInterval:   30 * time.Second,
+		ChurnRatio:       0.5,
+		ChurnInterval:    time.Hour,
+		NativeHistograms: 0.25,
+		BlockDuration:    2 * time.Hour,
+		MinTime:          time.UnixMilli(0),
+		MaxTime:          time.UnixMilli(0).Add(4 * time.Hour),
+		ExternalLabels:   labels.FromStrings("ext", "1"),
+		TmpDir:           t.TempDir(),
+	}
+
+	bkt := objstore.NewInMemBucket()
+	g, err := New(log.NewNopLogger(), bkt, opts)
+	testutil.Ok(t, err)
+	metas, err := g.Generate(ctx)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(metas))
+
+	for i, m := range metas {
+		testutil.Equals(t, int64(i)*2*time.Hour.Milliseconds(), m.MinTime)
+		testutil.Equals(t, metadata.BucketGenerateSource, m.Thanos.Source)
+		testutil.Equals(t, map[string]string{"ext": "1"}, m.Thanos.Labels)
+		// 3 of the 5 targets churn every hour: 8 stable series and 12 series for every hour.
+		testutil.Equals(t, uint64(8+2*12), m.Stats.NumSeries)
+		testutil.Equals(t, uint64(20*240), m.Stats.NumSamples)
+
+		downloaded, err := block.DownloadMeta(ctx, log.NewNopLogger(), bkt, m.ULID)
+		testutil.Ok(t, err)
+		testutil.Equals(t, m.ULID, downloaded.ULID)
+	}
+
+	// The same options generate the same samples.
+	g, err = New(log.NewNopLogger(), objstore.NewInMemBucket(), opts)
+	testutil.Ok(t, err)
+	again, err := g.Generate(ctx)
+	testutil.Ok(t, err)
+	for i := range metas {
+		testutil.Equals(t, metas[i].Stats, again[i].Stats)
+	}
+
+	// Downsampled blocks.
+	opts.Resolution = downsample.ResLevel2
+	g, err = New(log.NewNopLogger(), bkt, opts)
+	testutil.Ok(t, err)
+	metas, err = g.Generate(ctx)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(metas))
+	for _, m := range metas {
+		testutil.Equals(t, downsample.ResLevel2, m.Thanos.Downsample.Resolution)
+		testutil.Equals(t, metadata.BucketGenerateSource, m.Thanos.Source)
+	}
+
+	opts.Resolution = time.Minute.Milliseconds()
+	_, err = New(log.NewNopLogger(), bkt, opts)
+	testutil.NotOk(t, err)
+}
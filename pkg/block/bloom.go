@@ -0,0 +1,137 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/index"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+const (
+	// LabelsBloomFilename is the file of a block with the bloom filter of its label pairs. It is optional, so
+	// readers have to assume it may contain any label pair if the file is missing.
+	LabelsBloomFilename = "labels.bloom"
+
+	// LabelsBloomVersion1 represents 1 version of the labels bloom filter.
+	LabelsBloomVersion1 = 1
+
+	labelsBloomHeaderLen = 2
+)
+
+// LabelsBloom is a bloom filter of the label pairs of the series of a block, which tells that a block has no series
+// with a label pair, e.g. to skip blocks irrelevant for the equality matchers of a query, without reading its index.
+type LabelsBloom struct {
+	k    uint8
+	bits []uint64
+}
+
+// NewLabelsBloom returns an empty bloom filter sized for n label pairs with the given false positive rate.
+func NewLabelsBloom(n uint64, falsePositiveRate float64) *LabelsBloom {
+	n = max(1, n)
+	// Bit positions are 32 bits.
+	m := min(math.MaxUint32-63, math.Ceil(-float64(n)*math.Log(falsePositiveRate)/(math.Ln2*math.Ln2)))
+	k := math.Round(m / float64(n) * math.Ln2)
+	return &LabelsBloom{
+		k:    uint8(min(255, max(1, k))),
+		bits: make([]uint64, (uint64(m)+63)/64),
+	}
+}
+
+func labelsBloomHash(name, value string) (uint32, uint32) {
+	d := xxhash.New()
+	_, _ = d.WriteString(name)
+	_, _ = d.Write([]byte{0xff})
+	_, _ = d.WriteString(value)
+	h := d.Sum64()
+	return uint32(h), uint32(h >> 32)
+}
+
+// Add adds the label pair to the filter.
+func (b *LabelsBloom) Add(name, value string) {
+	h1, h2 := labelsBloomHash(name, value)
+	m := uint32(len(b.bits) * 64)
+	for i := uint32(0); i < uint32(b.k); i++ {
+		bit := (h1 + i*h2) % m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// MayContain returns false if the block has no series with the label pair.
+func (b *LabelsBloom) MayContain(name, value string) bool {
+	h1, h2 := labelsBloomHash(name, value)
+	m := uint32(len(b.bits) * 64)
+	for i := uint32(0); i < uint32(b.k); i++ {
+		bit := (h1 + i*h2) % m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Encode returns the filter as its version, number of hashes and bits in little endian.
+func (b *LabelsBloom) Encode() []byte {
+	buf := make([]byte, labelsBloomHeaderLen, labelsBloomHeaderLen+8*len(b.bits))
+	buf[0], buf[1] = LabelsBloomVersion1, b.k
+	for _, w := range b.bits {
+		buf = binary.LittleEndian.AppendUint64(buf, w)
+	}
+	return buf
+}
+
+// DecodeLabelsBloom decodes a filter encoded with Encode.
+func DecodeLabelsBloom(buf []byte) (*LabelsBloom, error) {
+	if len(buf) < labelsBloomHeaderLen {
+		return nil, errors.New("labels bloom filter too short")
+	}
+	if buf[0] != LabelsBloomVersion1 {
+		return nil, errors.Errorf("unexpected labels bloom filter version %d", buf[0])
+	}
+	words := buf[labelsBloomHeaderLen:]
+	if buf[1] == 0 || len(words) == 0 || len(words)%8 != 0 {
+		return nil, errors.New("malformed labels bloom filter")
+	}
+	b := &LabelsBloom{k: buf[1], bits: make([]uint64, len(words)/8)}
+	for i := range b.bits {
+		b.bits[i] = binary.LittleEndian.Uint64(words[i*8:])
+	}
+	return b, nil
+}
+
+// BuildLabelsBloom returns the bloom filter of the label pairs of the index file.
+func BuildLabelsBloom(ctx context.Context, indexFn string, falsePositiveRate float64) (_ *LabelsBloom, err error) {
+	r, err := index.NewFileReader(indexFn, index.DecodePostingsRaw)
+	if err != nil {
+		return nil, errors.Wrap(err, "open index file")
+	}
+	defer runutil.CloseWithErrCapture(&err, r, "labels bloom index reader")
+
+	names, err := r.LabelNames(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "label names")
+	}
+	values := make([][]string, len(names))
+	var n uint64
+	for i, name := range names {
+		if values[i], err = r.LabelValues(ctx, name); err != nil {
+			return nil, errors.Wrapf(err, "label values of %s", name)
+		}
+		n += uint64(len(values[i]))
+	}
+
+	b := NewLabelsBloom(n, falsePositiveRate)
+	for i, name := range names {
+		for _, v := range values[i] {
+			b.Add(name, v)
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,51 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestLabelsBloom(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+
+	var series []labels.Labels
+	for i := range 100 {
+		series = append(series, labels.FromStrings("__name__", "up", "pod", fmt.Sprintf("pod-%d", i)))
+	}
+	id, err := e2eutil.CreateBlock(ctx, tmpDir, series, 10, 0, 1000, labels.EmptyLabels(), 124, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+
+	b, err := BuildLabelsBloom(ctx, filepath.Join(tmpDir, id.String(), IndexFilename), 0.01)
+	testutil.Ok(t, err)
+
+	b, err = DecodeLabelsBloom(b.Encode())
+	testutil.Ok(t, err)
+	testutil.Assert(t, b.MayContain("__name__", "up"))
+	for i := range 100 {
+		testutil.Assert(t, b.MayContain("pod", fmt.Sprintf("pod-%d", i)))
+	}
+	falsePositives := 0
+	for i := range 1000 {
+		if b.MayContain("pod", fmt.Sprintf("other-%d", i)) {
+			falsePositives++
+		}
+	}
+	testutil.Assert(t, falsePositives < 50, "too many false positives: %d", falsePositives)
+
+	_, err = DecodeLabelsBloom([]byte{2, 1, 0, 0, 0, 0, 0, 0, 0, 0})
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,553 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package encryption encrypts the files of blocks on the client side before they are uploaded to the object
+// storage, and decrypts them transparently when they are read.
+//
+// Every encrypted object has its own random data key, wrapped by a KeyProvider and stored in the object header
+// with the ID of the key encryption key. The content is split in segments encrypted with AES-GCM, so that
+// ranges can be read without downloading the whole object. As in the STREAM construction, the nonce of a segment
+// is made of a random prefix, the index of the segment and a bit marking the last segment, so that truncated
+// objects fail to decrypt. Objects without the header are read as they are, so that blocks uploaded before
+// enabling encryption stay readable.
+//
+// Segments are authenticated with the path of the object within its block directory, e.g. chunks/000001, so
+// that objects cannot be swapped within a block. Blocks can still be copied to another block ID, including with
+// server side copies, but a file cannot be renamed within a block.
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+const (
+	magic = "THANOSE1"
+
+	dataKeySize      = 32
+	maxKeyIDLen      = 255
+	maxWrappedKeyLen = 1024
+	noncePrefixLen   = 8
+	maxHeaderLen     = len(magic) + 1 + maxKeyIDLen + 2 + maxWrappedKeyLen + noncePrefixLen
+
+	segmentSize          = 64 * 1024
+	tagSize              = 16
+	encryptedSegmentSize = segmentSize + tagSize
+	// lastSegmentBit marks the last segment in the 32 bits index of the nonce, which leaves 2^31 segments.
+	lastSegmentBit = 1 << 31
+
+	cacheSize = 4096
+)
+
+// header is the header of an encrypted object.
+type header struct {
+	keyID       string
+	wrappedKey  []byte
+	noncePrefix [noncePrefixLen]byte
+}
+
+func (h header) len() int64 {
+	return int64(len(magic) + 1 + len(h.keyID) + 2 + len(h.wrappedKey) + noncePrefixLen)
+}
+
+func (h header) marshal() []byte {
+	b := make([]byte, 0, h.len())
+	b = append(b, magic...)
+	b = append(b, byte(len(h.keyID)))
+	b = append(b, h.keyID...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(h.wrappedKey)))
+	b = append(b, h.wrappedKey...)
+	return append(b, h.noncePrefix[:]...)
+}
+
+// parseHeader parses the header at the beginning of b. It returns false if b does not start with a header.
+func parseHeader(b []byte) (header, bool, error) {
+	var h header
+	if !bytes.HasPrefix(b, []byte(magic)) {
+		return h, false, nil
+	}
+	b = b[len(magic):]
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return h, true, errors.New("truncated key ID")
+	}
+	h.keyID, b = string(b[1:1+int(b[0])]), b[1+int(b[0]):]
+	if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+		return h, true, errors.New("truncated wrapped key")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	h.wrappedKey, b = bytes.Clone(b[2:2+n]), b[2+n:]
+	if len(b) < noncePrefixLen {
+		return h, true, errors.New("truncated nonce")
+	}
+	copy(h.noncePrefix[:], b)
+	return h, true, nil
+}
+
+func (h header) nonce(segment uint64, last bool) []byte {
+	n := make([]byte, noncePrefixLen+4)
+	copy(n, h.noncePrefix[:])
+	idx := uint32(segment)
+	if last {
+		idx |= lastSegmentBit
+	}
+	binary.BigEndian.PutUint32(n[noncePrefixLen:], idx)
+	return n
+}
+
+// plaintextSize returns the size of the content of an encrypted object, from the size of the encrypted segments.
+// Empty objects have a single empty segment.
+func plaintextSize(encrypted int64) int64 {
+	if encrypted <= 0 {
+		return 0
+	}
+	segments := (encrypted + encryptedSegmentSize - 1) / encryptedSegmentSize
+	return encrypted - segments*tagSize
+}
+
+// IsEncrypted returns whether objects of the given name are encrypted: all files of block directories except
+// meta.json and markers, which have to stay readable to tools unaware of encryption.
+func IsEncrypted(name string) bool {
+	_, rel, ok := blockFile(name)
+	return ok && path.Ext(rel) != ".json"
+}
+
+func isBlockMeta(name string) bool {
+	_, rel, ok := blockFile(name)
+	return ok && rel == metadata.MetaFilename
+}
+
+// blockRelPath returns the path of the object within its block directory, which segments are authenticated with.
+func blockRelPath(name string) []byte {
+	_, rel, _ := blockFile(name)
+	return []byte(rel)
+}
+
+// blockFile returns the block directory of the object and the path of the object within it. Block directories are
+// found at any depth, e.g. under the tenant prefixes of a prefixed bucket wrapping this one.
+func blockFile(name string) (string, string, bool) {
+	parts := strings.Split(name, "/")
+	for i, p := range parts[:len(parts)-1] {
+		if _, err := ulid.Parse(p); err == nil {
+			return path.Join(parts[:i+1]...), path.Join(parts[i+1:]...), true
+		}
+	}
+	return "", "", false
+}
+
+// Bucket is an objstore.Bucket encrypting the files of blocks on upload and decrypting them on read. It records
+// the ID of the current key encryption key in the meta.json of uploaded blocks, so that blocks still encrypted
+// with a rotated key can be found.
+type Bucket struct {
+	objstore.Bucket
+
+	logger   log.Logger
+	keys     KeyProvider
+	keyID    string
+	headers  *lru.Cache[string, header]
+	dataKeys *lru.Cache[string, cipher.AEAD]
+}
+
+// NewBucket wraps the bucket to encrypt block files with data keys wrapped by the given key provider.
+// The currentKeyID is recorded in the meta.json of uploaded blocks.
+func NewBucket(logger log.Logger, bkt objstore.Bucket, keys KeyProvider, currentKeyID string) *Bucket {
+	headers, _ := lru.New[string, header](cacheSize)
+	dataKeys, _ := lru.New[string, cipher.AEAD](cacheSize)
+	return &Bucket{Bucket: bkt, logger: logger, keys: keys, keyID: currentKeyID, headers: headers, dataKeys: dataKeys}
+}
+
+// WrapWithConfig wraps the bucket with the client side encryption configured by the given YAML. The bucket is
+// returned as is if the configuration is empty.
+func WrapWithConfig(logger log.Logger, bkt objstore.Bucket, confContentYaml []byte) (objstore.Bucket, error) {
+	if len(bytes.TrimSpace(confContentYaml)) == 0 {
+		return bkt, nil
+	}
+	keys, err := NewKeyProvider(confContentYaml)
+	if err != nil {
+		return nil, err
+	}
+	// Learn the ID of the current key, recorded in block metas.
+	keyID, _, err := keys.WrapKey(context.Background(), make([]byte, dataKeySize))
+	if err != nil {
+		return nil, errors.Wrap(err, "wrap test data key")
+	}
+	level.Info(logger).Log("msg", "client side encryption of block files enabled", "key_id", keyID)
+	return NewBucket(logger, bkt, keys, keyID), nil
+}
+
+// Upload encrypts block files, and records the current key ID in block metas.
+func (b *Bucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if isBlockMeta(name) {
+		return b.uploadMeta(ctx, name, r)
+	}
+	if !IsEncrypted(name) {
+		return b.Bucket.Upload(ctx, name, r)
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return errors.Wrap(err, "generate data key")
+	}
+	var (
+		h   header
+		err error
+	)
+	h.keyID, h.wrappedKey, err = b.keys.WrapKey(ctx, dataKey)
+	if err != nil {
+		return errors.Wrap(err, "wrap data key")
+	}
+	if len(h.keyID) > maxKeyIDLen || len(h.wrappedKey) > maxWrappedKeyLen {
+		return errors.Errorf("key ID or wrapped data key too long: %d, %d bytes", len(h.keyID), len(h.wrappedKey))
+	}
+	if _, err := rand.Read(h.noncePrefix[:]); err != nil {
+		return errors.Wrap(err, "generate nonce")
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+
+	if err := b.Bucket.Upload(ctx, name, newEncryptingReader(r, aead, h, name)); err != nil {
+		return err
+	}
+	b.headers.Add(name, h)
+	return nil
+}
+
+func (b *Bucket) uploadMeta(ctx context.Context, name string, r io.Reader) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "read meta")
+	}
+	m, err := metadata.Read(io.NopCloser(bytes.NewReader(content)))
+	if err != nil {
+		// Not a meta we know, upload it as is.
+		level.Warn(b.logger).Log("msg", "failed to decode uploaded block meta, not recording encryption key", "name", name, "err", err)
+		return b.Bucket.Upload(ctx, name, bytes.NewReader(content))
+	}
+
+	m.Thanos.Encryption = &metadata.Encryption{KeyID: b.keyID}
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		return errors.Wrap(err, "encode meta")
+	}
+	return b.Bucket.Upload(ctx, name, &buf)
+}
+
+// Get returns a reader of the decrypted content of the object.
+func (b *Bucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, err := b.Bucket.Get(ctx, name)
+	if err != nil || !IsEncrypted(name) {
+		return rc, err
+	}
+
+	br := bufio.NewReaderSize(rc, maxHeaderLen)
+	// Peek fails if the object is shorter than the maximum header, which is fine.
+	peeked, _ := br.Peek(maxHeaderLen)
+	h, ok, err := parseHeader(peeked)
+	if err != nil {
+		_ = rc.Close()
+		return nil, errors.Wrapf(err, "parse header of %s", name)
+	}
+	if !ok {
+		return readCloser{Reader: br, Closer: rc}, nil
+	}
+	aead, err := b.dataKey(ctx, h)
+	if err != nil {
+		_ = rc.Close()
+		return nil, errors.Wrapf(err, "data key of %s", name)
+	}
+	if _, err := br.Discard(int(h.len())); err != nil {
+		_ = rc.Close()
+		return nil, errors.Wrapf(err, "skip header of %s", name)
+	}
+
+	dr := readCloser{Reader: newDecryptingReader(br, aead, h, name, 0, 0, true), Closer: rc}
+	size, err := objstore.TryToGetSize(rc)
+	if err != nil {
+		return dr, nil
+	}
+	return objstore.ObjectSizerReadCloser{
+		ReadCloser: dr,
+		Size:       func() (int64, error) { return plaintextSize(size - h.len()), nil },
+	}, nil
+}
+
+// GetRange returns a reader of the given range of the decrypted content of the object.
+func (b *Bucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	if !IsEncrypted(name) {
+		return b.Bucket.GetRange(ctx, name, off, length)
+	}
+	h, ok, err := b.header(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return b.Bucket.GetRange(ctx, name, off, length)
+	}
+	aead, err := b.dataKey(ctx, h)
+	if err != nil {
+		return nil, errors.Wrapf(err, "data key of %s", name)
+	}
+
+	first := off / segmentSize
+	encOff, encLength := h.len()+first*encryptedSegmentSize, int64(-1)
+	if length >= 0 {
+		last := (off + max(length, 1) - 1) / segmentSize
+		encLength = (last - first + 1) * encryptedSegmentSize
+	}
+	rc, err := b.Bucket.GetRange(ctx, name, encOff, encLength)
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader = newDecryptingReader(rc, aead, h, name, uint64(first), int(off-first*segmentSize), length < 0)
+	if length >= 0 {
+		r = io.LimitReader(r, length)
+	}
+	return readCloser{Reader: r, Closer: rc}, nil
+}
+
+// Attributes returns the attributes of the object, with the size of its decrypted content.
+func (b *Bucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	attrs, err := b.Bucket.Attributes(ctx, name)
+	if err != nil || !IsEncrypted(name) {
+		return attrs, err
+	}
+	h, ok, err := b.header(ctx, name)
+	if err != nil {
+		return attrs, err
+	}
+	if ok {
+		attrs.Size = plaintextSize(attrs.Size - h.len())
+	}
+	return attrs, nil
+}
+
+// Delete deletes the object.
+func (b *Bucket) Delete(ctx context.Context, name string) error {
+	b.headers.Remove(name)
+	return b.Bucket.Delete(ctx, name)
+}
+
+// header returns the header of an object, false if it is not encrypted.
+func (b *Bucket) header(ctx context.Context, name string) (header, bool, error) {
+	if h, ok := b.headers.Get(name); ok {
+		return h, true, nil
+	}
+	rc, err := b.Bucket.GetRange(ctx, name, 0, int64(maxHeaderLen))
+	if err != nil {
+		return header{}, false, err
+	}
+	defer func() { _ = rc.Close() }()
+
+	peeked, err := io.ReadAll(rc)
+	if err != nil {
+		return header{}, false, errors.Wrapf(err, "read header of %s", name)
+	}
+	h, ok, err := parseHeader(peeked)
+	if err != nil {
+		return header{}, false, errors.Wrapf(err, "parse header of %s", name)
+	}
+	if ok {
+		// Objects are immutable, apart from upload retries which also update the cache.
+		b.headers.Add(name, h)
+	}
+	return h, ok, nil
+}
+
+func (b *Bucket) dataKey(ctx context.Context, h header) (cipher.AEAD, error) {
+	cacheKey := h.keyID + "\x00" + string(h.wrappedKey)
+	if aead, ok := b.dataKeys.Get(cacheKey); ok {
+		return aead, nil
+	}
+	k, err := b.keys.UnwrapKey(ctx, h.keyID, h.wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(k)
+	if err != nil {
+		return nil, err
+	}
+	b.dataKeys.Add(cacheKey, aead)
+	return aead, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// segmentReader reads an object segment by segment. It reads the first byte of the next segment ahead, to tell
+// whether a segment is the last one.
+type segmentReader struct {
+	r     io.Reader
+	buf   []byte
+	ahead int
+}
+
+func newSegmentReader(r io.Reader, size int) *segmentReader {
+	return &segmentReader{r: r, buf: make([]byte, size+1)}
+}
+
+// next returns the next segment, which is only valid until the next call, and whether it is the last one.
+func (s *segmentReader) next() ([]byte, bool, error) {
+	if s.ahead > 0 {
+		s.buf[0] = s.buf[len(s.buf)-1]
+	}
+	n, err := io.ReadFull(s.r, s.buf[s.ahead:])
+	n += s.ahead
+	switch {
+	case err == nil:
+		s.ahead = 1
+		return s.buf[:n-1], false, nil
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		s.ahead = 0
+		return s.buf[:n], true, nil
+	default:
+		return nil, false, err
+	}
+}
+
+type encryptingReader struct {
+	r    io.Reader
+	in   *segmentReader
+	aead cipher.AEAD
+	h    header
+	aad  []byte
+
+	segment uint64
+	out     []byte
+	pending []byte
+	eof     bool
+}
+
+func newEncryptingReader(r io.Reader, aead cipher.AEAD, h header, name string) *encryptingReader {
+	return &encryptingReader{
+		r:       r,
+		in:      newSegmentReader(r, segmentSize),
+		aead:    aead,
+		h:       h,
+		aad:     blockRelPath(name),
+		out:     make([]byte, 0, encryptedSegmentSize),
+		pending: h.marshal(),
+	}
+}
+
+func (e *encryptingReader) Read(p []byte) (int, error) {
+	for len(e.pending) == 0 {
+		if e.eof {
+			return 0, io.EOF
+		}
+		if e.segment >= lastSegmentBit {
+			return 0, errors.Errorf("object larger than %d segments", uint64(lastSegmentBit))
+		}
+		// Empty objects still have an empty last segment, so that truncating an object to its header is detected.
+		plain, last, err := e.in.next()
+		if err != nil {
+			return 0, err
+		}
+		e.pending = e.aead.Seal(e.out[:0], e.h.nonce(e.segment, last), plain, e.aad)
+		e.segment++
+		e.eof = last
+	}
+	n := copy(p, e.pending)
+	e.pending = e.pending[n:]
+	return n, nil
+}
+
+// ObjectSize returns the size of the encrypted object, if the size of the content is known.
+func (e *encryptingReader) ObjectSize() (int64, error) {
+	size, err := objstore.TryToGetSize(e.r)
+	if err != nil {
+		return 0, err
+	}
+	segments := max((size+segmentSize-1)/segmentSize, 1)
+	return e.h.len() + size + segments*tagSize, nil
+}
+
+type decryptingReader struct {
+	in   *segmentReader
+	aead cipher.AEAD
+	h    header
+	aad  []byte
+	// toEnd is true if the reader reads up to the end of the object, which then has to end with the last segment.
+	toEnd bool
+
+	segment uint64
+	skip    int
+	out     []byte
+	pending []byte
+	err     error
+}
+
+// ErrDecrypt is the cause of the errors reading encrypted objects whose content fails authentication, e.g. because
+// it was tampered with or truncated.
+var ErrDecrypt = errors.New("decrypt object")
+
+func newDecryptingReader(r io.Reader, aead cipher.AEAD, h header, name string, segment uint64, skip int, toEnd bool) *decryptingReader {
+	return &decryptingReader{
+		in:      newSegmentReader(r, encryptedSegmentSize),
+		aead:    aead,
+		h:       h,
+		aad:     blockRelPath(name),
+		toEnd:   toEnd,
+		segment: segment,
+		skip:    skip,
+		out:     make([]byte, 0, segmentSize),
+	}
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		sealed, end, err := d.in.next()
+		if err != nil {
+			d.err = err
+			return 0, err
+		}
+		if end {
+			d.err = io.EOF
+		}
+		if len(sealed) == 0 {
+			// Ranges starting at the end of the object have no segment, while whole objects have at least one.
+			if d.toEnd && d.segment == 0 {
+				d.err = errors.Wrap(ErrDecrypt, "no segment")
+			}
+			continue
+		}
+
+		// The end of a range which does not read up to the end of the object may or may not be its last segment.
+		plain, oerr := d.aead.Open(d.out[:0], d.h.nonce(d.segment, end && d.toEnd), sealed, d.aad)
+		if oerr != nil && end && !d.toEnd {
+			plain, oerr = d.aead.Open(d.out[:0], d.h.nonce(d.segment, true), sealed, d.aad)
+		}
+		if oerr != nil {
+			d.err = errors.Wrapf(ErrDecrypt, "segment %d: %s", d.segment, oerr)
+			return 0, d.err
+		}
+		d.pending = plain[min(d.skip, len(plain)):]
+		d.skip = 0
+		d.segment++
+	}
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
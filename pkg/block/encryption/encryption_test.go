@@ -0,0 +1,359 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, dataKeySize))
+}
+
+func testBucket(t *testing.T, inner objstore.Bucket, current string) *Bucket {
+	t.Helper()
+
+	keys, err := NewStaticKeyProvider(StaticConfig{CurrentKeyID: current, Keys: map[string]string{"old": testKey(1), "new": testKey(2)}})
+	testutil.Ok(t, err)
+	return NewBucket(log.NewNopLogger(), inner, keys, current)
+}
+
+// readAll returns a function reading all of the readers returned by Get and GetRange.
+func readAll(t *testing.T) func(io.ReadCloser, error) []byte {
+	return func(rc io.ReadCloser, err error) []byte {
+		t.Helper()
+
+		testutil.Ok(t, err)
+		defer func() { testutil.Ok(t, rc.Close()) }()
+		b, err := io.ReadAll(rc)
+		testutil.Ok(t, err)
+		return b
+	}
+}
+
+func TestBucket(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	inner := objstore.NewInMemBucket()
+	bkt := testBucket(t, inner, "old")
+	read := readAll(t)
+
+	id := ulid.MustNew(1, nil).String()
+	content := make([]byte, 3*segmentSize+123)
+	rand.New(rand.NewSource(1)).Read(content)
+	name := path.Join(id, "chunks", "000001")
+	testutil.Ok(t, bkt.Upload(ctx, name, bytes.NewReader(content)))
+
+	// Stored encrypted.
+	stored := read(inner.Get(ctx, name))
+	testutil.Assert(t, bytes.HasPrefix(stored, []byte(magic)))
+	testutil.Assert(t, !bytes.Contains(stored, content[:64]))
+
+	testutil.Equals(t, content, read(bkt.Get(ctx, name)))
+	attrs, err := bkt.Attributes(ctx, name)
+	testutil.Ok(t, err)
+	testutil.Equals(t, int64(len(content)), attrs.Size)
+
+	for _, r := range []struct{ off, length int64 }{
+		{0, 10},
+		{segmentSize - 5, 10},
+		{segmentSize, segmentSize},
+		{10, 2*segmentSize + 100},
+		{3 * segmentSize, -1},
+		{100, 0},
+	} {
+		end := int64(len(content))
+		if r.length >= 0 {
+			end = r.off + r.length
+		}
+		// Without the header cached, as on restarts.
+		bkt.headers.Purge()
+		testutil.Equals(t, content[r.off:end], read(bkt.GetRange(ctx, name, r.off, r.length)))
+	}
+
+	// Rotated keys still decrypt older objects.
+	rotated := testBucket(t, inner, "new")
+	testutil.Equals(t, content, read(rotated.Get(ctx, name)))
+
+	// Tampered objects fail to decrypt.
+	stored[len(stored)-1] ^= 1
+	testutil.Ok(t, inner.Upload(ctx, name, bytes.NewReader(stored)))
+	rc, err := testBucket(t, inner, "old").GetRange(ctx, name, 3*segmentSize, -1)
+	testutil.Ok(t, err)
+	_, err = io.ReadAll(rc)
+	testutil.NotOk(t, err)
+	testutil.Ok(t, rc.Close())
+}
+
+func TestBucket_Plaintext(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	inner := objstore.NewInMemBucket()
+	bkt := testBucket(t, inner, "old")
+	read := readAll(t)
+
+	id := ulid.MustNew(1, nil).String()
+	// Objects uploaded before enabling encryption, and objects never encrypted.
+	for _, name := range []string{path.Join(id, "index"), path.Join(id, metadata.DeletionMarkFilename), "debug/metas/x.json"} {
+		testutil.Ok(t, inner.Upload(ctx, name, strings.NewReader("plaintext")))
+		testutil.Equals(t, "plaintext", string(read(bkt.Get(ctx, name))))
+		testutil.Equals(t, "text", string(read(bkt.GetRange(ctx, name, 5, 4))))
+	}
+
+	testutil.Ok(t, bkt.Upload(ctx, "debug/metas/y.json", strings.NewReader("{}")))
+	testutil.Equals(t, "{}", string(read(inner.Get(ctx, "debug/metas/y.json"))))
+
+	// Block metas stay plaintext, with the current key recorded.
+	var buf bytes.Buffer
+	meta := metadata.Meta{Thanos: metadata.Thanos{Version: metadata.ThanosVersion1, Source: metadata.CompactorSource}}
+	meta.Version = metadata.TSDBVersion1
+	testutil.Ok(t, meta.Write(&buf))
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(id, metadata.MetaFilename), &buf))
+	m, err := metadata.Read(io.NopCloser(bytes.NewReader(read(inner.Get(ctx, path.Join(id, metadata.MetaFilename))))))
+	testutil.Ok(t, err)
+	testutil.Equals(t, &metadata.Encryption{KeyID: "old"}, m.Thanos.Encryption)
+	testutil.Equals(t, metadata.CompactorSource, m.Thanos.Source)
+}
+
+func TestNewKeyProvider(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewKeyProvider([]byte(`type: STATIC
+config:
+  current_key_id: missing
+  keys:
+    k1: ` + testKey(1)))
+	testutil.NotOk(t, err)
+
+	p, err := NewKeyProvider([]byte(`type: static
+config:
+  current_key_id: k1
+  keys:
+    k1: ` + testKey(1)))
+	testutil.Ok(t, err)
+	keyID, wrapped, err := p.WrapKey(context.Background(), []byte("data key"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, "k1", keyID)
+	k, err := p.UnwrapKey(context.Background(), keyID, wrapped)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "data key", string(k))
+}
+
+// fakeVaultTransit is a fake transit secrets engine, whose ciphertexts are the base64 encoded plaintexts prefixed
+// with the key name and version.
+type fakeVaultTransit struct {
+	mtx     sync.Mutex
+	token   string
+	version int
+}
+
+func (v *fakeVaultTransit) set(token string, version int) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	v.token, v.version = token, version
+}
+
+func (v *fakeVaultTransit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	if r.Header.Get("X-Vault-Token") != v.token {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+		return
+	}
+	var req map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	op, key := path.Split(strings.TrimPrefix(r.URL.Path, "/v1/secret-transit/"))
+	var resp map[string]string
+	switch op {
+	case "encrypt/":
+		resp = map[string]string{"ciphertext": fmt.Sprintf("vault:%s:v%d:%s", key, v.version, req["plaintext"])}
+	case "decrypt/":
+		parts := strings.Split(req["ciphertext"], ":")
+		if len(parts) != 4 || parts[1] != key {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["invalid ciphertext"]}`))
+			return
+		}
+		resp = map[string]string{"plaintext": parts[3]}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": resp})
+}
+
+func TestVaultTransitKeyProvider(t *testing.T) {
+	t.Parallel()
+
+	vault := &fakeVaultTransit{token: "token", version: 1}
+	srv := httptest.NewServer(vault)
+	t.Cleanup(srv.Close)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	testutil.Ok(t, os.WriteFile(tokenFile, []byte("token\n"), 0600))
+
+	p, err := NewKeyProvider([]byte(`type: VAULT_TRANSIT
+config:
+  address: ` + srv.URL + `
+  token_file: ` + tokenFile + `
+  mount_path: /secret-transit/
+  key_name: thanos`))
+	testutil.Ok(t, err)
+
+	keyID, wrapped, err := p.WrapKey(context.Background(), []byte("data key"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, "thanos", keyID)
+	testutil.Equals(t, "vault:thanos:v1:"+base64.StdEncoding.EncodeToString([]byte("data key")), string(wrapped))
+
+	// Data keys wrapped with older versions of the transit key are still unwrapped after a rotation in Vault.
+	vault.set("token", 2)
+	k, err := p.UnwrapKey(context.Background(), keyID, wrapped)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "data key", string(k))
+
+	_, err = p.UnwrapKey(context.Background(), "other", wrapped)
+	testutil.NotOk(t, err)
+	testutil.Assert(t, strings.Contains(err.Error(), "invalid ciphertext"), "unexpected error %v", err)
+
+	// The token is read from the token file on every request.
+	testutil.Ok(t, os.WriteFile(tokenFile, []byte("expired"), 0600))
+	_, _, err = p.WrapKey(context.Background(), []byte("data key"))
+	testutil.NotOk(t, err)
+	testutil.Assert(t, strings.Contains(err.Error(), "permission denied"), "unexpected error %v", err)
+
+	vault.set("expired", 2)
+	bkt := NewBucket(log.NewNopLogger(), objstore.NewInMemBucket(), p, keyID)
+	name := path.Join(ulid.MustNew(1, nil).String(), "chunks", "000001")
+	testutil.Ok(t, bkt.Upload(context.Background(), name, strings.NewReader("chunks")))
+	testutil.Equals(t, "chunks", string(readAll(t)(bkt.Get(context.Background(), name))))
+
+	_, err = NewKeyProvider([]byte(`type: VAULT_TRANSIT
+config:
+  address: ` + srv.URL + `
+  token: token`))
+	testutil.NotOk(t, err)
+}
+
+func TestBucket_TruncatedAndCopied(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	inner := objstore.NewInMemBucket()
+	bkt := testBucket(t, inner, "old")
+	read := readAll(t)
+
+	id, otherID := ulid.MustNew(1, nil).String(), ulid.MustNew(2, nil).String()
+	for _, size := range []int{0, 10, segmentSize, 2 * segmentSize} {
+		content := make([]byte, size)
+		rand.New(rand.NewSource(1)).Read(content)
+		name := path.Join(id, "chunks", "000001")
+		testutil.Ok(t, bkt.Upload(ctx, name, bytes.NewReader(content)))
+		testutil.Equals(t, content, read(bkt.Get(ctx, name)))
+		attrs, err := bkt.Attributes(ctx, name)
+		testutil.Ok(t, err)
+		testutil.Equals(t, int64(size), attrs.Size)
+
+		stored := read(inner.Get(ctx, name))
+		h, _, err := parseHeader(stored)
+		testutil.Ok(t, err)
+		objectSize, err := newEncryptingReader(bytes.NewReader(content), nil, h, name).ObjectSize()
+		testutil.Ok(t, err)
+		testutil.Equals(t, int64(len(stored)), objectSize)
+
+		// Objects truncated at a segment boundary fail to decrypt.
+		for _, truncated := range []int{int(h.len()), int(h.len()) + encryptedSegmentSize} {
+			if truncated >= len(stored) {
+				continue
+			}
+			testutil.Ok(t, inner.Upload(ctx, name, bytes.NewReader(stored[:truncated])))
+			bkt.headers.Purge()
+			rc, err := bkt.Get(ctx, name)
+			testutil.Ok(t, err)
+			_, err = io.ReadAll(rc)
+			testutil.Assert(t, errors.Is(err, ErrDecrypt), "truncated object decrypted: %v", err)
+			testutil.Ok(t, rc.Close())
+		}
+
+		// Objects copied to another block, e.g. by server side copies, still decrypt.
+		testutil.Ok(t, inner.Upload(ctx, path.Join(otherID, "chunks", "000001"), bytes.NewReader(stored)))
+		testutil.Equals(t, content, read(bkt.Get(ctx, path.Join(otherID, "chunks", "000001"))))
+
+		// Objects renamed within a block fail to decrypt.
+		testutil.Ok(t, inner.Upload(ctx, path.Join(id, "chunks", "000002"), bytes.NewReader(stored)))
+		rc, err := bkt.Get(ctx, path.Join(id, "chunks", "000002"))
+		testutil.Ok(t, err)
+		_, err = io.ReadAll(rc)
+		testutil.Assert(t, errors.Is(err, ErrDecrypt), "renamed object decrypted: %v", err)
+		testutil.Ok(t, rc.Close())
+	}
+}
+
+func TestBucket_Prefixed(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	inner := objstore.NewInMemBucket()
+	bkt := testBucket(t, inner, "old")
+	// Like the tenant directories compacted with --compact.tenant-directories.
+	tenant := objstore.NewPrefixedBucket(bkt, "tenant-a")
+	read := readAll(t)
+
+	id := ulid.MustNew(1, nil).String()
+	content := make([]byte, segmentSize+10)
+	rand.New(rand.NewSource(1)).Read(content)
+	name := path.Join(id, "chunks", "000001")
+	testutil.Ok(t, tenant.Upload(ctx, name, bytes.NewReader(content)))
+
+	stored := read(inner.Get(ctx, path.Join("tenant-a", name)))
+	testutil.Assert(t, bytes.HasPrefix(stored, []byte(magic)))
+	testutil.Assert(t, !bytes.Contains(stored, content[:64]))
+	testutil.Equals(t, content, read(tenant.Get(ctx, name)))
+	testutil.Equals(t, content[10:20], read(tenant.GetRange(ctx, name, 10, 10)))
+
+	// Segments are authenticated with the path within the block, so the block can be moved out of the tenant directory.
+	testutil.Ok(t, inner.Upload(ctx, name, bytes.NewReader(stored)))
+	testutil.Equals(t, content, read(bkt.Get(ctx, name)))
+
+	var buf bytes.Buffer
+	meta := metadata.Meta{Thanos: metadata.Thanos{Version: metadata.ThanosVersion1}}
+	meta.Version = metadata.TSDBVersion1
+	testutil.Ok(t, meta.Write(&buf))
+	testutil.Ok(t, tenant.Upload(ctx, path.Join(id, metadata.MetaFilename), &buf))
+	m, err := metadata.Read(io.NopCloser(bytes.NewReader(read(inner.Get(ctx, path.Join("tenant-a", id, metadata.MetaFilename))))))
+	testutil.Ok(t, err)
+	testutil.Equals(t, &metadata.Encryption{KeyID: "old"}, m.Thanos.Encryption)
+
+	// Objects in directories that are not block directories are not encrypted.
+	testutil.Ok(t, tenant.Upload(ctx, "debug/metas/x.json", strings.NewReader("{}")))
+	testutil.Equals(t, "{}", string(read(inner.Get(ctx, "tenant-a/debug/metas/x.json"))))
+}
@@ -0,0 +1,292 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/clientconfig"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// KeyProvider wraps and unwraps the data keys encrypting objects with key encryption keys, typically held by a KMS.
+type KeyProvider interface {
+	// WrapKey encrypts the data key with the current key encryption key, and returns the ID of that key.
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey decrypts a data key wrapped with the key encryption key of the given ID.
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// ProviderType is the type of a key provider.
+type ProviderType string
+
+const (
+	// Static is a key provider with key encryption keys given in the configuration.
+	Static ProviderType = "STATIC"
+	// VaultTransit is a key provider wrapping data keys with the transit secrets engine of Vault, so that key
+	// encryption keys never leave Vault.
+	VaultTransit ProviderType = "VAULT_TRANSIT"
+)
+
+// Config is the client side encryption configuration.
+type Config struct {
+	Type   ProviderType `yaml:"type"`
+	Config interface{}  `yaml:"config"`
+}
+
+// StaticConfig configures the static key provider.
+type StaticConfig struct {
+	// CurrentKeyID is the ID of the key wrapping the data keys of new objects.
+	CurrentKeyID string `yaml:"current_key_id"`
+	// Keys are base64 encoded 256 bits keys by ID. Keys not current anymore are kept to decrypt older objects.
+	Keys map[string]string `yaml:"keys"`
+}
+
+// VaultTransitConfig configures the Vault transit key provider.
+type VaultTransitConfig struct {
+	// Address is the URL of the Vault server, e.g. https://vault:8200.
+	Address string `yaml:"address"`
+	// Token is the Vault token, with the permission to encrypt and decrypt with the transit keys.
+	Token string `yaml:"token"`
+	// TokenFile is a file the Vault token is read from on every request, so that renewed tokens are picked up.
+	TokenFile string `yaml:"token_file"`
+	// Namespace is the Vault Enterprise namespace of the transit secrets engine, if any.
+	Namespace string `yaml:"namespace"`
+	// MountPath is the path the transit secrets engine is mounted at. Defaults to "transit".
+	MountPath string `yaml:"mount_path"`
+	// KeyName is the name of the transit key wrapping the data keys of new objects.
+	KeyName string `yaml:"key_name"`
+	// TLSConfig configures the TLS connections to Vault.
+	TLSConfig clientconfig.TLSConfig `yaml:"tls_config"`
+}
+
+// NewKeyProvider creates the key provider of the configuration.
+func NewKeyProvider(confContentYaml []byte) (KeyProvider, error) {
+	conf := &Config{}
+	if err := yaml.UnmarshalStrict(confContentYaml, conf); err != nil {
+		return nil, errors.Wrap(err, "parsing encryption config YAML")
+	}
+	config, err := yaml.Marshal(conf.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal content of encryption configuration")
+	}
+
+	switch ProviderType(strings.ToUpper(string(conf.Type))) {
+	case Static:
+		var sc StaticConfig
+		if err := yaml.UnmarshalStrict(config, &sc); err != nil {
+			return nil, errors.Wrap(err, "parsing static key provider config")
+		}
+		return NewStaticKeyProvider(sc)
+	case VaultTransit:
+		var vc VaultTransitConfig
+		if err := yaml.UnmarshalStrict(config, &vc); err != nil {
+			return nil, errors.Wrap(err, "parsing Vault transit key provider config")
+		}
+		return NewVaultTransitKeyProvider(vc)
+	default:
+		return nil, errors.Errorf("encryption key provider with type %s is not supported", conf.Type)
+	}
+}
+
+type staticKeyProvider struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewStaticKeyProvider returns a key provider wrapping data keys with AES-GCM, using the given keys.
+func NewStaticKeyProvider(conf StaticConfig) (KeyProvider, error) {
+	p := &staticKeyProvider{current: conf.CurrentKeyID, keys: make(map[string]cipher.AEAD, len(conf.Keys))}
+	for id, k := range conf.Keys {
+		if len(id) > maxKeyIDLen {
+			return nil, errors.Errorf("key ID %q longer than %d bytes", id, maxKeyIDLen)
+		}
+		b, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode key %s", id)
+		}
+		if len(b) != dataKeySize {
+			return nil, errors.Errorf("key %s has %d bytes, expected %d", id, len(b), dataKeySize)
+		}
+		aead, err := newAEAD(b)
+		if err != nil {
+			return nil, errors.Wrapf(err, "key %s", id)
+		}
+		p.keys[id] = aead
+	}
+	if _, ok := p.keys[p.current]; !ok {
+		return nil, errors.Errorf("current key %q not found in keys", p.current)
+	}
+	return p, nil
+}
+
+func (p *staticKeyProvider) WrapKey(_ context.Context, dataKey []byte) (string, []byte, error) {
+	aead := p.keys[p.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, errors.Wrap(err, "generate nonce")
+	}
+	return p.current, aead.Seal(nonce, nonce, dataKey, []byte(p.current)), nil
+}
+
+func (p *staticKeyProvider) UnwrapKey(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, errors.Errorf("unknown key %q", keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+	k, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(keyID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unwrap data key with key %s", keyID)
+	}
+	return k, nil
+}
+
+type vaultTransitKeyProvider struct {
+	client    *http.Client
+	address   string
+	token     string
+	tokenFile string
+	namespace string
+	mountPath string
+	keyName   string
+}
+
+// NewVaultTransitKeyProvider returns a key provider wrapping data keys with the transit secrets engine of Vault.
+// The key ID of wrapped data keys is the name of the transit key, and the version of the transit key is part of
+// the wrapped key, so transit keys can be rotated in Vault.
+func NewVaultTransitKeyProvider(conf VaultTransitConfig) (KeyProvider, error) {
+	if conf.Address == "" {
+		return nil, errors.New("no Vault address")
+	}
+	if conf.Token == "" && conf.TokenFile == "" {
+		return nil, errors.New("no Vault token or token file")
+	}
+	if conf.KeyName == "" {
+		return nil, errors.New("no transit key name")
+	}
+	if len(conf.KeyName) > maxKeyIDLen {
+		return nil, errors.Errorf("transit key name %q longer than %d bytes", conf.KeyName, maxKeyIDLen)
+	}
+	if conf.MountPath == "" {
+		conf.MountPath = "transit"
+	}
+	httpConf := clientconfig.NewDefaultHTTPClientConfig()
+	httpConf.TLSConfig = conf.TLSConfig
+	client, err := clientconfig.NewHTTPClient(httpConf, "vault-transit")
+	if err != nil {
+		return nil, errors.Wrap(err, "create Vault client")
+	}
+	return &vaultTransitKeyProvider{
+		client:    client,
+		address:   strings.TrimSuffix(conf.Address, "/"),
+		token:     conf.Token,
+		tokenFile: conf.TokenFile,
+		namespace: conf.Namespace,
+		mountPath: strings.Trim(conf.MountPath, "/"),
+		keyName:   conf.KeyName,
+	}, nil
+}
+
+func (p *vaultTransitKeyProvider) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	var resp struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	if err := p.do(ctx, "encrypt", p.keyName, map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}, &resp); err != nil {
+		return "", nil, err
+	}
+	if resp.Ciphertext == "" {
+		return "", nil, errors.New("no ciphertext in Vault encrypt response")
+	}
+	return p.keyName, []byte(resp.Ciphertext), nil
+}
+
+func (p *vaultTransitKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := p.do(ctx, "decrypt", keyID, map[string]string{"ciphertext": string(wrapped)}, &resp); err != nil {
+		return nil, err
+	}
+	k, err := base64.StdEncoding.DecodeString(resp.Plaintext)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode plaintext of Vault decrypt response")
+	}
+	return k, nil
+}
+
+// do calls the encrypt or decrypt endpoint of the transit key.
+func (p *vaultTransitKeyProvider) do(ctx context.Context, op, keyName string, in, out interface{}) (err error) {
+	token := p.token
+	if p.tokenFile != "" {
+		b, err := os.ReadFile(p.tokenFile)
+		if err != nil {
+			return errors.Wrap(err, "read Vault token file")
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	u := p.address + "/v1/" + path.Join(p.mountPath, op, url.PathEscape(keyName))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", token)
+	if p.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.namespace)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "Vault transit %s with key %s", op, keyName)
+	}
+	defer runutil.ExhaustCloseWithErrCapture(&err, resp.Body, "close Vault transit %s response body", op)
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return errors.Wrapf(err, "read Vault transit %s response", op)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var r struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.Unmarshal(b, &r)
+		return errors.Errorf("Vault transit %s with key %s: %s: %s", op, keyName, resp.Status, strings.Join(r.Errors, "; "))
+	}
+	r := struct {
+		Data interface{} `json:"data"`
+	}{Data: out}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return errors.Wrapf(err, "decode Vault transit %s response", op)
+	}
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
@@ -0,0 +1,194 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/exemplars/exemplarspb"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// ExemplarsFilename is the known file of a block holding the exemplars of its series, if any.
+const ExemplarsFilename = "exemplars"
+
+const (
+	exemplarsMagic    = 0x45584D50
+	exemplarsVersion1 = 1
+)
+
+// HasExemplars returns true if the files of the block listed by its meta include an exemplars file.
+func HasExemplars(meta *metadata.Meta) bool {
+	return hasFile(meta, ExemplarsFilename)
+}
+
+// WriteExemplarsFile writes the exemplars into the exemplars file of the block in dir, sorted by their series labels.
+// No file is written without exemplars.
+func WriteExemplarsFile(dir string, data []*exemplarspb.ExemplarData) error {
+	if len(data) == 0 {
+		return nil
+	}
+	data = slices.Clone(data)
+	slices.SortFunc(data, func(a, b *exemplarspb.ExemplarData) int { return a.Compare(b) })
+
+	tmp := filepath.Join(dir, ExemplarsFilename+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrap(err, "create exemplars file")
+	}
+	if err := writeExemplars(f, data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrap(err, "close exemplars file")
+	}
+	return os.Rename(tmp, filepath.Join(dir, ExemplarsFilename))
+}
+
+func writeExemplars(f *os.File, data []*exemplarspb.ExemplarData) error {
+	w := bufio.NewWriter(f)
+	var buf [binary.MaxVarintLen64]byte
+	binary.BigEndian.PutUint32(buf[:4], exemplarsMagic)
+	buf[4] = exemplarsVersion1
+	if _, err := w.Write(buf[:5]); err != nil {
+		return errors.Wrap(err, "write exemplars header")
+	}
+	for _, d := range data {
+		b, err := d.Marshal()
+		if err != nil {
+			return errors.Wrap(err, "encode exemplars")
+		}
+		n := binary.PutUvarint(buf[:], uint64(len(b)))
+		if _, err := w.Write(buf[:n]); err != nil {
+			return errors.Wrap(err, "write exemplars")
+		}
+		if _, err := w.Write(b); err != nil {
+			return errors.Wrap(err, "write exemplars")
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return errors.Wrap(err, "flush exemplars file")
+	}
+	return errors.Wrap(f.Sync(), "sync exemplars file")
+}
+
+// ReadExemplarsFile reads the exemplars of an exemplars file.
+func ReadExemplarsFile(r io.Reader) ([]*exemplarspb.ExemplarData, error) {
+	br := bufio.NewReader(r)
+	var header [5]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, errors.Wrap(err, "read exemplars header")
+	}
+	if binary.BigEndian.Uint32(header[:4]) != exemplarsMagic {
+		return nil, errors.New("invalid exemplars file magic")
+	}
+	if header[4] != exemplarsVersion1 {
+		return nil, errors.Errorf("unexpected exemplars file version %d, expected %d", header[4], exemplarsVersion1)
+	}
+
+	var data []*exemplarspb.ExemplarData
+	for {
+		n, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "read exemplars")
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return nil, errors.Wrap(err, "read exemplars")
+		}
+		d := &exemplarspb.ExemplarData{}
+		if err := d.Unmarshal(b); err != nil {
+			return nil, errors.Wrap(err, "decode exemplars")
+		}
+		data = append(data, d)
+	}
+}
+
+// ReadExemplarsFromDir reads the exemplars file of the block in dir, and returns no exemplars if the block has none.
+func ReadExemplarsFromDir(dir string) (_ []*exemplarspb.ExemplarData, err error) {
+	f, err := os.Open(filepath.Join(dir, ExemplarsFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "open exemplars file")
+	}
+	defer runutil.CloseWithErrCapture(&err, f, "close exemplars file")
+
+	return ReadExemplarsFile(f)
+}
+
+// MergeExemplars merges the exemplars of the same series, dropping duplicates and the exemplars outside of
+// [mint, maxt). Only the series kept by keep are returned, if not nil. Series left without exemplars are dropped.
+func MergeExemplars(sets [][]*exemplarspb.ExemplarData, mint, maxt int64, keep func(labels.Labels) bool) []*exemplarspb.ExemplarData {
+	series := map[uint64][]*exemplarspb.ExemplarData{}
+	var res []*exemplarspb.ExemplarData
+	for _, set := range sets {
+		for _, d := range set {
+			lset := d.SeriesLabels.PromLabels()
+			if keep != nil && !keep(lset) {
+				continue
+			}
+
+			var merged *exemplarspb.ExemplarData
+			h := lset.Hash()
+			for _, s := range series[h] {
+				if labels.Equal(s.SeriesLabels.PromLabels(), lset) {
+					merged = s
+					break
+				}
+			}
+			if merged == nil {
+				merged = &exemplarspb.ExemplarData{SeriesLabels: d.SeriesLabels}
+				series[h] = append(series[h], merged)
+				res = append(res, merged)
+			}
+			for _, e := range d.Exemplars {
+				if e.Ts >= mint && e.Ts < maxt {
+					merged.Exemplars = append(merged.Exemplars, e)
+				}
+			}
+		}
+	}
+
+	out := res[:0]
+	for _, d := range res {
+		if len(d.Exemplars) == 0 {
+			continue
+		}
+		slices.SortFunc(d.Exemplars, func(a, b *exemplarspb.Exemplar) int {
+			if c := cmp.Compare(a.Ts, b.Ts); c != 0 {
+				return c
+			}
+			if c := labels.Compare(a.Labels.PromLabels(), b.Labels.PromLabels()); c != 0 {
+				return c
+			}
+			return cmp.Compare(math.Float64bits(a.Value), math.Float64bits(b.Value))
+		})
+		d.Exemplars = slices.CompactFunc(d.Exemplars, func(a, b *exemplarspb.Exemplar) bool {
+			return a.Ts == b.Ts && math.Float64bits(a.Value) == math.Float64bits(b.Value) &&
+				labels.Equal(a.Labels.PromLabels(), b.Labels.PromLabels())
+		})
+		out = append(out, d)
+	}
+	slices.SortFunc(out, func(a, b *exemplarspb.ExemplarData) int { return a.Compare(b) })
+	return out
+}
@@ -0,0 +1,83 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/exemplars/exemplarspb"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+)
+
+func exemplarData(lset labels.Labels, exemplars ...*exemplarspb.Exemplar) *exemplarspb.ExemplarData {
+	return &exemplarspb.ExemplarData{
+		SeriesLabels: labelpb.ZLabelSet{Labels: labelpb.ZLabelsFromPromLabels(lset)},
+		Exemplars:    exemplars,
+	}
+}
+
+func exemplar(traceID string, value float64, ts int64) *exemplarspb.Exemplar {
+	return &exemplarspb.Exemplar{
+		Labels: labelpb.ZLabelSet{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("trace_id", traceID))},
+		Value:  value,
+		Ts:     ts,
+	}
+}
+
+func TestExemplarsFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	data, err := ReadExemplarsFromDir(dir)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(data))
+
+	// No file is written without exemplars.
+	testutil.Ok(t, WriteExemplarsFile(dir, nil))
+	_, err = os.Stat(filepath.Join(dir, ExemplarsFilename))
+	testutil.Assert(t, os.IsNotExist(err))
+
+	b := exemplarData(labels.FromStrings("__name__", "b"), exemplar("2", math.NaN(), 20))
+	a := exemplarData(labels.FromStrings("__name__", "a"), exemplar("1", 1, 10), exemplar("3", 3, 30))
+	testutil.Ok(t, WriteExemplarsFile(dir, []*exemplarspb.ExemplarData{b, a}))
+
+	data, err = ReadExemplarsFromDir(dir)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(data))
+	testutil.Equals(t, a, data[0])
+	testutil.Equals(t, b.SeriesLabels, data[1].SeriesLabels)
+	testutil.Assert(t, math.IsNaN(data[1].Exemplars[0].Value))
+}
+
+func TestMergeExemplars(t *testing.T) {
+	t.Parallel()
+
+	a, b := labels.FromStrings("__name__", "a"), labels.FromStrings("__name__", "b")
+	merged := MergeExemplars([][]*exemplarspb.ExemplarData{
+		{
+			exemplarData(a, exemplar("1", 1, 10), exemplar("3", 3, 30)),
+			exemplarData(b, exemplar("5", 5, 5)),
+		},
+		{
+			// Duplicates of the exemplars of replicas are dropped.
+			exemplarData(a, exemplar("2", 2, 20), exemplar("1", 1, 10), exemplar("4", 4, 40)),
+			exemplarData(b, exemplar("6", 6, 60)),
+		},
+	}, 10, 40, nil)
+	testutil.Equals(t, []*exemplarspb.ExemplarData{
+		exemplarData(a, exemplar("1", 1, 10), exemplar("2", 2, 20), exemplar("3", 3, 30)),
+	}, merged)
+
+	merged = MergeExemplars([][]*exemplarspb.ExemplarData{{
+		exemplarData(a, exemplar("1", 1, 10)),
+		exemplarData(b, exemplar("2", 2, 20)),
+	}}, 0, 100, func(lset labels.Labels) bool { return labels.Equal(lset, b) })
+	testutil.Equals(t, []*exemplarspb.ExemplarData{exemplarData(b, exemplar("2", 2, 20))}, merged)
+}
@@ -0,0 +1,206 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package exporter streams the series of blocks in the bucket out to other systems.
+package exporter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/logutil"
+	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// SeriesWriter receives the exported series.
+type SeriesWriter interface {
+	// WriteSeries writes the samples of a series of a block, sorted by time. The samples are reused after it returns.
+	WriteSeries(ctx context.Context, lset labels.Labels, samples []Sample) error
+	// Flush is called once all series are written.
+	Flush(ctx context.Context) error
+}
+
+// Sample is a float sample of a series.
+type Sample struct {
+	T int64
+	V float64
+}
+
+// Options configures an export.
+type Options struct {
+	// BlockMatchers select the blocks to export by their external labels.
+	BlockMatchers []*labels.Matcher
+	// SeriesMatchers select the series to export.
+	SeriesMatchers []*labels.Matcher
+	// MinTime and MaxTime are the time range to export.
+	MinTime, MaxTime model.TimeOrDurationValue
+	// TmpDir is the directory blocks are downloaded to.
+	TmpDir string
+}
+
+// Stats are the statistics of an export.
+type Stats struct {
+	Blocks, Series, Samples int
+	// SkippedHistogramSamples is the number of native histogram samples, which are not exported.
+	SkippedHistogramSamples int
+}
+
+// Exporter exports raw blocks of the bucket. Blocks whose data is also in other blocks, e.g. sources of a
+// compacted block not deleted yet, are skipped to not export the same samples twice. The external labels
+// of a block are added to its series, overriding series labels with the same name.
+type Exporter struct {
+	logger log.Logger
+	bkt    objstore.InstrumentedBucket
+	opts   Options
+}
+
+// New creates a new Exporter. All series are exported if no series matcher is given.
+func New(logger log.Logger, bkt objstore.InstrumentedBucket, opts Options) *Exporter {
+	if len(opts.SeriesMatchers) == 0 {
+		opts.SeriesMatchers = []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+")}
+	}
+	return &Exporter{logger: logger, bkt: bkt, opts: opts}
+}
+
+// Export writes the selected series of all selected blocks, ordered by block min time.
+func (e *Exporter) Export(ctx context.Context, w SeriesWriter) (Stats, error) {
+	var stats Stats
+
+	metas, err := e.selectBlocks(ctx)
+	if err != nil {
+		return stats, err
+	}
+	for _, m := range metas {
+		if err := e.exportBlock(ctx, m, w, &stats); err != nil {
+			return stats, errors.Wrapf(err, "export block %s", m.ULID)
+		}
+		stats.Blocks++
+	}
+	if err := w.Flush(ctx); err != nil {
+		return stats, errors.Wrap(err, "flush")
+	}
+	return stats, nil
+}
+
+func (e *Exporter) selectBlocks(ctx context.Context) ([]*metadata.Meta, error) {
+	baseBlockIDsFetcher := block.NewConcurrentLister(e.logger, e.bkt)
+	fetcher, err := block.NewMetaFetcher(e.logger, block.FetcherConcurrency, e.bkt, baseBlockIDsFetcher, "", nil, []block.MetadataFilter{
+		block.NewTimePartitionMetaFilter(e.opts.MinTime, e.opts.MaxTime),
+		block.NewDeduplicateFilter(block.FetcherConcurrency),
+	})
+	if err != nil {
+		return nil, err
+	}
+	metas, _, err := fetcher.Fetch(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch metas")
+	}
+
+	res := make([]*metadata.Meta, 0, len(metas))
+	for _, m := range metas {
+		// Downsampled blocks hold aggregates, not samples.
+		if m.Thanos.Downsample.Resolution != 0 {
+			continue
+		}
+		if !matches(e.opts.BlockMatchers, labels.FromMap(m.Thanos.Labels)) {
+			continue
+		}
+		res = append(res, m)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].MinTime != res[j].MinTime {
+			return res[i].MinTime < res[j].MinTime
+		}
+		return res[i].ULID.Compare(res[j].ULID) < 0
+	})
+	return res, nil
+}
+
+func (e *Exporter) exportBlock(ctx context.Context, m *metadata.Meta, w SeriesWriter, stats *Stats) error {
+	dir := filepath.Join(e.opts.TmpDir, m.ULID.String())
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			level.Warn(e.logger).Log("msg", "failed to remove downloaded block", "dir", dir, "err", err)
+		}
+	}()
+	if err := block.Download(ctx, e.logger, e.bkt, m.ULID, dir); err != nil {
+		return errors.Wrap(err, "download")
+	}
+
+	b, err := tsdb.OpenBlock(logutil.GoKitLogToSlog(e.logger), dir, chunkenc.NewPool(), nil)
+	if err != nil {
+		return errors.Wrap(err, "open block")
+	}
+	defer runutil.CloseWithLogOnErr(e.logger, b, "block")
+
+	q, err := tsdb.NewBlockQuerier(b, e.opts.MinTime.PrometheusTimestamp(), e.opts.MaxTime.PrometheusTimestamp())
+	if err != nil {
+		return errors.Wrap(err, "create querier")
+	}
+	defer runutil.CloseWithLogOnErr(e.logger, q, "block querier")
+
+	var (
+		series, exported = stats.Series, stats.Samples
+		lb               = labels.NewBuilder(labels.EmptyLabels())
+		it               chunkenc.Iterator
+		samples          []Sample
+		ss               = q.Select(ctx, false, nil, e.opts.SeriesMatchers...)
+	)
+	for ss.Next() {
+		s := ss.At()
+		lb.Reset(s.Labels())
+		for k, v := range m.Thanos.Labels {
+			lb.Set(k, v)
+		}
+
+		samples = samples[:0]
+		it = s.Iterator(it)
+		for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+			if vt != chunkenc.ValFloat {
+				stats.SkippedHistogramSamples++
+				continue
+			}
+			t, v := it.At()
+			samples = append(samples, Sample{T: t, V: v})
+		}
+		if err := it.Err(); err != nil {
+			return errors.Wrapf(err, "iterate series %s", s.Labels())
+		}
+		if len(samples) == 0 {
+			continue
+		}
+
+		if err := w.WriteSeries(ctx, lb.Labels(), samples); err != nil {
+			return errors.Wrap(err, "write series")
+		}
+		stats.Series++
+		stats.Samples += len(samples)
+	}
+	if err := ss.Err(); err != nil {
+		return errors.Wrap(err, "select series")
+	}
+	level.Info(e.logger).Log("msg", "exported block", "block", m.ULID, "series", stats.Series-series, "samples", stats.Samples-exported)
+	return nil
+}
+
+func matches(ms []*labels.Matcher, lset labels.Labels) bool {
+	for _, m := range ms {
+		if !m.Matches(lset.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestExporter_OpenMetrics(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
+
+	series := []labels.Labels{
+		labels.FromStrings(labels.MetricName, "up", "job", "a"),
+		labels.FromStrings(labels.MetricName, "up", "job", "b"),
+	}
+	for _, cluster := range []string{"a", "b"} {
+		id, err := e2eutil.CreateBlock(ctx, tmpDir, series, 10, 0, 10000, labels.FromStrings("cluster", cluster), 0, metadata.NoneFunc, nil)
+		testutil.Ok(t, err)
+		testutil.Ok(t, block.Upload(ctx, log.NewNopLogger(), bkt, filepath.Join(tmpDir, id.String()), metadata.NoneFunc))
+	}
+
+	var buf bytes.Buffer
+	stats, err := New(log.NewNopLogger(), bkt, Options{
+		BlockMatchers:  []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "cluster", "a")},
+		SeriesMatchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "a")},
+		MinTime:        model.TimeOrDurationValue{Time: &[]time.Time{time.UnixMilli(0)}[0]},
+		MaxTime:        model.TimeOrDurationValue{Time: &[]time.Time{time.UnixMilli(10000)}[0]},
+		TmpDir:         t.TempDir(),
+	}).Export(ctx, NewOpenMetricsWriter(&buf))
+	testutil.Ok(t, err)
+	testutil.Equals(t, Stats{Blocks: 1, Series: 1, Samples: 10}, stats)
+
+	var (
+		p       = textparse.NewOpenMetricsParser(buf.Bytes(), labels.NewSymbolTable())
+		samples int
+	)
+	for {
+		e, err := p.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		testutil.Ok(t, err)
+		if e != textparse.EntrySeries {
+			continue
+		}
+		var lset labels.Labels
+		p.Labels(&lset)
+		testutil.Equals(t, labels.FromStrings(labels.MetricName, "up", "cluster", "a", "job", "a"), lset)
+		_, ts, _ := p.Series()
+		testutil.Assert(t, ts != nil && *ts >= 0 && *ts <= 10000)
+		samples++
+	}
+	testutil.Equals(t, 10, samples)
+}
+
+type fakeWriteClient struct {
+	reqs     []prompb.WriteRequest
+	failures int
+}
+
+func (c *fakeWriteClient) Store(_ context.Context, b []byte, _ int) (remote.WriteResponseStats, error) {
+	if c.failures > 0 {
+		c.failures--
+		return remote.WriteResponseStats{}, remote.RecoverableError{}
+	}
+	b, err := snappy.Decode(nil, b)
+	if err != nil {
+		return remote.WriteResponseStats{}, err
+	}
+	var req prompb.WriteRequest
+	if err := req.Unmarshal(b); err != nil {
+		return remote.WriteResponseStats{}, err
+	}
+	c.reqs = append(c.reqs, req)
+	return remote.WriteResponseStats{}, nil
+}
+
+func (c *fakeWriteClient) Name() string     { return "fake" }
+func (c *fakeWriteClient) Endpoint() string { return "fake" }
+
+func TestRemoteWriter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := &fakeWriteClient{failures: 2}
+	w := NewRemoteWriter(c, 3)
+
+	lset := labels.FromStrings(labels.MetricName, "up", "job", "a")
+	testutil.Ok(t, w.WriteSeries(ctx, lset, []Sample{{T: 1, V: 1}, {T: 2, V: 2}}))
+	testutil.Ok(t, w.WriteSeries(ctx, lset, []Sample{{T: 3, V: 3}, {T: 4, V: 4}, {T: 5, V: 5}}))
+	testutil.Ok(t, w.Flush(ctx))
+
+	testutil.Equals(t, 2, len(c.reqs))
+	var samples []prompb.Sample
+	for _, req := range c.reqs {
+		for _, ts := range req.Timeseries {
+			testutil.Equals(t, lset, labelpb.ZLabelsToPromLabels(ts.Labels))
+			samples = append(samples, ts.Samples...)
+		}
+	}
+	testutil.Equals(t, []prompb.Sample{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}, {Timestamp: 3, Value: 3}, {Timestamp: 4, Value: 4}, {Timestamp: 5, Value: 5}}, samples)
+}
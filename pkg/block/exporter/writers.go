@@ -0,0 +1,154 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package exporter
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage/remote"
+
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+type openMetricsWriter struct {
+	w *bufio.Writer
+}
+
+// NewOpenMetricsWriter returns a SeriesWriter writing samples in the OpenMetrics text format, with their
+// timestamps. Metric metadata is not known and not written. Series of the same metric can be written
+// once per exported block, which strict OpenMetrics parsers might reject, Prometheus and the importer do not.
+func NewOpenMetricsWriter(w io.Writer) SeriesWriter {
+	return &openMetricsWriter{w: bufio.NewWriter(w)}
+}
+
+func (w *openMetricsWriter) WriteSeries(_ context.Context, lset labels.Labels, samples []Sample) error {
+	var sb strings.Builder
+	sb.WriteString(lset.Get(labels.MetricName))
+	sb.WriteByte('{')
+	first := true
+	lset.Range(func(l labels.Label) {
+		if l.Name == labels.MetricName {
+			return
+		}
+		if !first {
+			sb.WriteByte(',')
+		}
+		first = false
+		sb.WriteString(l.Name)
+		sb.WriteString(`="`)
+		sb.WriteString(labelValueEscaper.Replace(l.Value))
+		sb.WriteByte('"')
+	})
+	sb.WriteString("} ")
+	series := sb.String()
+
+	for _, s := range samples {
+		if _, err := w.w.WriteString(series); err != nil {
+			return err
+		}
+		if _, err := w.w.WriteString(strconv.FormatFloat(s.V, 'g', -1, 64) + " " + strconv.FormatFloat(float64(s.T)/1000, 'f', -1, 64) + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *openMetricsWriter) Flush(context.Context) error {
+	if _, err := w.w.WriteString("# EOF\n"); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+const (
+	remoteWriteMaxRetries = 5
+	remoteWriteMinBackoff = 100 * time.Millisecond
+)
+
+type remoteWriter struct {
+	client    remote.WriteClient
+	batchSize int
+
+	pending []prompb.TimeSeries
+	samples int
+}
+
+// NewRemoteWriter returns a SeriesWriter sending samples with the given remote write client, in requests
+// of about batchSize samples. Recoverable errors are retried with backoff.
+func NewRemoteWriter(client remote.WriteClient, batchSize int) SeriesWriter {
+	return &remoteWriter{client: client, batchSize: batchSize}
+}
+
+func (w *remoteWriter) WriteSeries(ctx context.Context, lset labels.Labels, samples []Sample) error {
+	// Labels might reference memory of the block, which is closed before the batch is sent.
+	zlset := make([]labelpb.ZLabel, 0, lset.Len())
+	lset.Range(func(l labels.Label) {
+		zlset = append(zlset, labelpb.ZLabel{Name: strings.Clone(l.Name), Value: strings.Clone(l.Value)})
+	})
+
+	for len(samples) > 0 {
+		n := min(len(samples), max(w.batchSize-w.samples, 1))
+		ts := prompb.TimeSeries{Labels: zlset, Samples: make([]prompb.Sample, 0, n)}
+		for _, s := range samples[:n] {
+			ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: s.T, Value: s.V})
+		}
+		w.pending = append(w.pending, ts)
+		w.samples += n
+		samples = samples[n:]
+
+		if w.samples >= w.batchSize {
+			if err := w.send(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *remoteWriter) Flush(ctx context.Context) error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	return w.send(ctx)
+}
+
+func (w *remoteWriter) send(ctx context.Context) error {
+	req := &prompb.WriteRequest{Timeseries: w.pending}
+	b, err := req.Marshal()
+	if err != nil {
+		return errors.Wrap(err, "marshal write request")
+	}
+	b = snappy.Encode(nil, b)
+
+	backoff := remoteWriteMinBackoff
+	for attempt := 0; ; attempt++ {
+		_, err = w.client.Store(ctx, b, attempt)
+		if err == nil {
+			break
+		}
+		if !errors.As(err, &remote.RecoverableError{}) || attempt >= remoteWriteMaxRetries {
+			return errors.Wrapf(err, "send %d samples to %s", w.samples, w.client.Endpoint())
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	w.pending, w.samples = w.pending[:0], 0
+	return nil
+}
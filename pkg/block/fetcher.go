@@ -6,10 +6,12 @@ package block
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -33,6 +35,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/errutil"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
 	"github.com/thanos-io/thanos/pkg/runutil"
 )
 
@@ -82,6 +85,9 @@ const (
 	timeExcludedMeta  = "time-excluded"
 	tooFreshMeta      = "too-fresh"
 	duplicateMeta     = "duplicate"
+	// RetentionExceededMeta is label for blocks past the retention of their resolution, filtered out before the
+	// compactor deletes them.
+	RetentionExceededMeta = "retention-exceeded"
 	// Blocks that are marked for deletion can be loaded as well. This is done to make sure that we load blocks that are meant to be deleted,
 	// but don't have a replacement block yet.
 	MarkedForDeletionMeta = "marked-for-deletion"
@@ -89,6 +95,13 @@ const (
 	// MarkedForNoCompactionMeta is label for blocks which are loaded but also marked for no compaction. This label is also counted in `loaded` label metric.
 	MarkedForNoCompactionMeta = "marked-for-no-compact"
 
+	// CompactionDisabledMeta is label for blocks which are loaded but whose group has its compaction disabled by a
+	// compaction-disabled marker. This label is also counted in `loaded` label metric.
+	CompactionDisabledMeta = "compaction-disabled"
+
+	// QuarantinedMeta is label for blocks failing the verification of their attestation.
+	QuarantinedMeta = "quarantined"
+
 	// MarkedForNoDownsampleMeta is label for blocks which are loaded but also marked for no downsample. This label is also counted in `loaded` label metric.
 	MarkedForNoDownsampleMeta = "marked-for-no-downsample"
 
@@ -160,8 +173,11 @@ func DefaultSyncedStateLabelValues() [][]string {
 		{labelExcludedMeta},
 		{timeExcludedMeta},
 		{duplicateMeta},
+		{RetentionExceededMeta},
 		{MarkedForDeletionMeta},
 		{MarkedForNoCompactionMeta},
+		{CompactionDisabledMeta},
+		{QuarantinedMeta},
 	}
 }
 
@@ -468,6 +484,7 @@ type response struct {
 
 func (f *BaseFetcher) fetchMetadata(ctx context.Context) (interface{}, error) {
 	f.syncs.Inc()
+	ctx = objstoreutil.WithCaller(ctx, objstoreutil.CallerSyncer)
 
 	var (
 		resp = response{
@@ -582,7 +599,8 @@ func (f *BaseFetcher) fetchMetadata(ctx context.Context) (interface{}, error) {
 	return resp, nil
 }
 
-func (f *BaseFetcher) fetch(ctx context.Context, metrics *FetcherMetrics, filters []MetadataFilter) (_ map[ulid.ULID]*metadata.Meta, _ map[ulid.ULID]error, err error) {
+// fetch fetches the metas and applies the filters, counting the blocks excluded by every filter in filtered, if set.
+func (f *BaseFetcher) fetch(ctx context.Context, metrics *FetcherMetrics, filters []MetadataFilter, filtered map[string]int) (_ map[ulid.ULID]*metadata.Meta, _ map[ulid.ULID]error, err error) {
 	start := time.Now()
 	defer func() {
 		metrics.SyncDuration.Observe(time.Since(start).Seconds())
@@ -613,10 +631,14 @@ func (f *BaseFetcher) fetch(ctx context.Context, metrics *FetcherMetrics, filter
 	metrics.Synced.WithLabelValues(CorruptedMeta).Set(resp.corruptedMetas)
 
 	for _, filter := range filters {
+		before := len(metas)
 		// NOTE: filter can update synced metric accordingly to the reason of the exclude.
 		if err := filter.Filter(ctx, metas, metrics.Synced, metrics.Modified); err != nil {
 			return nil, nil, errors.Wrap(err, "filter metas")
 		}
+		if filtered != nil {
+			filtered[filterName(filter)] += before - len(metas)
+		}
 	}
 
 	metrics.Synced.WithLabelValues(LoadedMeta).Set(float64(len(metas)))
@@ -636,6 +658,11 @@ func (f *BaseFetcher) countCached() int {
 	return len(f.cached)
 }
 
+// filterName returns the name of the filter, its type.
+func filterName(filter MetadataFilter) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", filter), "*")
+}
+
 type MetaFetcher struct {
 	wrapped *BaseFetcher
 	metrics *FetcherMetrics
@@ -645,6 +672,9 @@ type MetaFetcher struct {
 	listener func([]metadata.Meta, error)
 
 	logger log.Logger
+
+	mtx      sync.Mutex
+	filtered map[string]int
 }
 
 // Fetch returns all block metas as well as partial blocks (blocks without or with corrupted meta file) from the bucket.
@@ -655,7 +685,13 @@ func (f *MetaFetcher) Fetch(ctx context.Context) (metas map[ulid.ULID]*metadata.
 	f.metrics.Syncs.Inc()
 	f.metrics.ResetTx()
 
-	metas, partial, err = f.wrapped.fetch(ctx, f.metrics, f.filters)
+	filtered := make(map[string]int, len(f.filters))
+	metas, partial, err = f.wrapped.fetch(ctx, f.metrics, f.filters, filtered)
+	if err == nil {
+		f.mtx.Lock()
+		f.filtered = filtered
+		f.mtx.Unlock()
+	}
 	if f.listener != nil {
 		blocks := make([]metadata.Meta, 0, len(metas))
 		for _, meta := range metas {
@@ -668,6 +704,19 @@ func (f *MetaFetcher) Fetch(ctx context.Context) (metas map[ulid.ULID]*metadata.
 	return metas, partial, err
 }
 
+// FilteredBlocks returns the number of blocks excluded by every filter in the last successful fetch, by the type of
+// the filter.
+func (f *MetaFetcher) FilteredBlocks() map[string]int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	filtered := make(map[string]int, len(f.filtered))
+	for name, n := range f.filtered {
+		filtered[name] = n
+	}
+	return filtered
+}
+
 // UpdateOnChange allows to add listener that will be update on every change.
 func (f *MetaFetcher) UpdateOnChange(listener func([]metadata.Meta, error)) {
 	f.listener = listener
@@ -733,8 +782,85 @@ func (f *LabelShardedMetaFilter) Filter(_ context.Context, metas map[ulid.ULID]*
 	return nil
 }
 
+var _ MetadataFilter = &LabelMatcherMetaFilter{}
+
+// LabelMatcherMetaFilter is a BaseFetcher filter that keeps the blocks whose external labels match all the matchers of
+// any of the selectors, so that instances can serve a subset of the tenants or replicas of a bucket.
+// Not go-routine safe.
+type LabelMatcherMetaFilter struct {
+	selectors [][]*labels.Matcher
+}
+
+// NewLabelMatcherMetaFilter creates LabelMatcherMetaFilter. All blocks are kept when there are no selectors.
+func NewLabelMatcherMetaFilter(selectors [][]*labels.Matcher) *LabelMatcherMetaFilter {
+	return &LabelMatcherMetaFilter{selectors: selectors}
+}
+
+// Filter filters out blocks whose external labels match none of the selectors.
+func (f *LabelMatcherMetaFilter) Filter(_ context.Context, metas map[ulid.ULID]*metadata.Meta, synced GaugeVec, modified GaugeVec) error {
+	if len(f.selectors) == 0 {
+		return nil
+	}
+	for id, m := range metas {
+		if !f.matches(m.Thanos.Labels) {
+			synced.WithLabelValues(labelExcludedMeta).Inc()
+			delete(metas, id)
+		}
+	}
+	return nil
+}
+
+func (f *LabelMatcherMetaFilter) matches(lset map[string]string) bool {
+Selectors:
+	for _, matchers := range f.selectors {
+		for _, m := range matchers {
+			// Missing labels match as empty values, as they do in PromQL.
+			if !m.Matches(lset[m.Name]) {
+				continue Selectors
+			}
+		}
+		return true
+	}
+	return false
+}
+
+var _ MetadataFilter = &GroupShardedMetaFilter{}
+
+// GroupShardedMetaFilter keeps the blocks whose external labels hash to the given shard, so that
+// replicas configured with the same shard count and distinct indexes process disjoint sets of groups.
+// Resolution is not hashed, so all resolutions of the same external labels belong to the same shard.
+type GroupShardedMetaFilter struct {
+	index, count uint64
+}
+
+// NewGroupShardedMetaFilter creates GroupShardedMetaFilter keeping the blocks of shard index out of count shards.
+func NewGroupShardedMetaFilter(index, count uint64) (*GroupShardedMetaFilter, error) {
+	if count > 1 && index >= count {
+		return nil, errors.Errorf("shard index %d must be lower than shard count %d", index, count)
+	}
+	return &GroupShardedMetaFilter{index: index, count: count}, nil
+}
+
+// Filter filters out blocks that belong to other shards.
+func (f *GroupShardedMetaFilter) Filter(_ context.Context, metas map[ulid.ULID]*metadata.Meta, synced GaugeVec, modified GaugeVec) error {
+	if f.count <= 1 {
+		return nil
+	}
+	for id, m := range metas {
+		if labels.FromMap(m.Thanos.Labels).Hash()%f.count != f.index {
+			synced.WithLabelValues(labelExcludedMeta).Inc()
+			delete(metas, id)
+		}
+	}
+	return nil
+}
+
 var _ MetadataFilter = &DefaultDeduplicateFilter{}
 
+// maxShardsCoverCount is the maximum shard count up to which shards of different counts are checked to cover a
+// block. Blocks are not considered duplicates of shards of higher counts.
+const maxShardsCoverCount = 1 << 12
+
 type DeduplicateFilter interface {
 	DuplicateIDs() []ulid.ULID
 }
@@ -743,8 +869,10 @@ type DeduplicateFilter interface {
 // Not go-routine safe.
 type DefaultDeduplicateFilter struct {
 	duplicateIDs []ulid.ULID
+	replacements map[ulid.ULID][]ulid.ULID
 	concurrency  int
 	mu           sync.Mutex
+	groupKey     func(m *metadata.Thanos) string
 }
 
 // NewDeduplicateFilter creates DefaultDeduplicateFilter.
@@ -756,6 +884,7 @@ func NewDeduplicateFilter(concurrency int) *DefaultDeduplicateFilter {
 // from two or more overlapping blocks that fully submatches the source blocks of the older blocks.
 func (f *DefaultDeduplicateFilter) Filter(_ context.Context, metas map[ulid.ULID]*metadata.Meta, synced GaugeVec, modified GaugeVec) error {
 	f.duplicateIDs = f.duplicateIDs[:0]
+	f.replacements = map[ulid.ULID][]ulid.ULID{}
 
 	var wg sync.WaitGroup
 	var groupChan = make(chan []*metadata.Meta)
@@ -772,9 +901,13 @@ func (f *DefaultDeduplicateFilter) Filter(_ context.Context, metas map[ulid.ULID
 	}
 
 	// We need only look within a compaction group for duplicates, so splitting by group key gives us parallelizable streams.
+	// Shards of the same group may be duplicates of the blocks they were compacted from, so they are grouped together.
 	metasByCompactionGroup := make(map[string][]*metadata.Meta)
 	for _, meta := range metas {
-		groupKey := meta.Thanos.GroupKey()
+		groupKey := meta.Thanos.UnshardedGroupKey()
+		if f.groupKey != nil {
+			groupKey = f.groupKey(&meta.Thanos)
+		}
 		metasByCompactionGroup[groupKey] = append(metasByCompactionGroup[groupKey], meta)
 	}
 	for _, group := range metasByCompactionGroup {
@@ -800,19 +933,29 @@ func (f *DefaultDeduplicateFilter) filterGroup(metaSlice []*metadata.Meta, metas
 
 	var coveringSet []*metadata.Meta
 	var duplicates []ulid.ULID
-childLoop:
+	replacements := map[ulid.ULID][]ulid.ULID{}
 	for _, child := range metaSlice {
 		childSources := child.Compaction.Sources
+		var (
+			parents      []ulid.ULID
+			parentShards []metadata.Shard
+		)
 		for _, parent := range coveringSet {
 			parentSources := parent.Compaction.Sources
 
-			// child's sources are present in parent's sources, filter it out.
 			if contains(parentSources, childSources) {
-				duplicates = append(duplicates, child.ULID)
-				continue childLoop
+				parents = append(parents, parent.ULID)
+				parentShards = append(parentShards, parent.Thanos.ShardOrAll())
 			}
 		}
 
+		// child's sources are present in parent's sources, and its series in their shards, filter it out.
+		if shardsCover(parentShards, child.Thanos.ShardOrAll()) {
+			duplicates = append(duplicates, child.ULID)
+			replacements[child.ULID] = parents
+			continue
+		}
+
 		// Child's sources not covered by any member of coveringSet, add it to coveringSet.
 		coveringSet = append(coveringSet, child)
 	}
@@ -821,6 +964,7 @@ childLoop:
 	for _, duplicate := range duplicates {
 		if metas[duplicate] != nil {
 			f.duplicateIDs = append(f.duplicateIDs, duplicate)
+			f.replacements[duplicate] = replacements[duplicate]
 		}
 		synced.WithLabelValues(duplicateMeta).Inc()
 		delete(metas, duplicate)
@@ -833,6 +977,51 @@ func (f *DefaultDeduplicateFilter) DuplicateIDs() []ulid.ULID {
 	return f.duplicateIDs
 }
 
+// SetGroupKeyFunc sets the function returning the key of the compaction group of a block, regardless of its shard,
+// for compactors grouping blocks by other keys than their labels and resolution. Only the blocks of the same group are
+// duplicates of each other.
+func (f *DefaultDeduplicateFilter) SetGroupKeyFunc(groupKey func(m *metadata.Thanos) string) {
+	f.groupKey = groupKey
+}
+
+// Replacements returns the blocks superseding every block filtered out by DefaultDeduplicateFilter, the blocks
+// whose sources include the sources of the duplicate.
+func (f *DefaultDeduplicateFilter) Replacements() map[ulid.ULID][]ulid.ULID {
+	return f.replacements
+}
+
+// shardsCover returns true if the series of the shard are in the shards.
+func shardsCover(shards []metadata.Shard, shard metadata.Shard) bool {
+	var within []metadata.Shard
+	lcm := shard.Count
+	for _, s := range shards {
+		if s.Contains(shard) {
+			return true
+		}
+		if shard.Contains(s) {
+			within = append(within, s)
+			lcm = lcm / gcd(lcm, s.Count) * s.Count
+		}
+	}
+	if len(within) == 0 || lcm > maxShardsCoverCount {
+		return false
+	}
+	// Every shard of the least common multiple count within the shard has to be in one of the shards.
+	for i := shard.Index; i < lcm; i += shard.Count {
+		if !slices.ContainsFunc(within, func(s metadata.Shard) bool { return i%s.Count == s.Index }) {
+			return false
+		}
+	}
+	return true
+}
+
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
 func contains(s1, s2 []ulid.ULID) bool {
 	for _, a := range s2 {
 		found := false
@@ -903,6 +1092,9 @@ func (r *ReplicaLabelRemover) Filter(_ context.Context, metas map[ulid.ULID]*met
 type ConsistencyDelayMetaFilter struct {
 	logger           log.Logger
 	consistencyDelay time.Duration
+
+	bkt                  objstore.InstrumentedBucketReader
+	uploadCompletedDelay time.Duration
 }
 
 // NewConsistencyDelayMetaFilter creates ConsistencyDelayMetaFilter.
@@ -929,8 +1121,15 @@ func NewConsistencyDelayMetaFilterWithoutMetrics(logger log.Logger, consistencyD
 	}
 }
 
+// SetUploadCompletedDelay makes the filter keep the fresh blocks with an upload-completed mark as soon as their upload
+// was completed at least the given delay ago, read from the bucket. Zero disables it.
+func (f *ConsistencyDelayMetaFilter) SetUploadCompletedDelay(bkt objstore.InstrumentedBucketReader, delay time.Duration) {
+	f.bkt = bkt
+	f.uploadCompletedDelay = delay
+}
+
 // Filter filters out blocks that filters blocks that have are created before a specified consistency delay.
-func (f *ConsistencyDelayMetaFilter) Filter(_ context.Context, metas map[ulid.ULID]*metadata.Meta, synced GaugeVec, modified GaugeVec) error {
+func (f *ConsistencyDelayMetaFilter) Filter(ctx context.Context, metas map[ulid.ULID]*metadata.Meta, synced GaugeVec, modified GaugeVec) error {
 	for id, meta := range metas {
 		// TODO(khyatisoneji): Remove the checks about Thanos Source
 		//  by implementing delete delay to fetch metas.
@@ -938,7 +1137,8 @@ func (f *ConsistencyDelayMetaFilter) Filter(_ context.Context, metas map[ulid.UL
 		if ulid.Now()-id.Time() < uint64(f.consistencyDelay/time.Millisecond) &&
 			meta.Thanos.Source != metadata.BucketRepairSource &&
 			meta.Thanos.Source != metadata.CompactorSource &&
-			meta.Thanos.Source != metadata.CompactorRepairSource {
+			meta.Thanos.Source != metadata.CompactorRepairSource &&
+			!f.uploadCompleted(ctx, id) {
 
 			level.Debug(f.logger).Log("msg", "block is too fresh for now", "block", id)
 			synced.WithLabelValues(tooFreshMeta).Inc()
@@ -949,6 +1149,58 @@ func (f *ConsistencyDelayMetaFilter) Filter(_ context.Context, metas map[ulid.UL
 	return nil
 }
 
+// uploadCompleted returns true if the block has an upload-completed mark older than the upload completed delay.
+func (f *ConsistencyDelayMetaFilter) uploadCompleted(ctx context.Context, id ulid.ULID) bool {
+	if f.uploadCompletedDelay <= 0 {
+		return false
+	}
+	m := &metadata.UploadCompletedMark{}
+	if err := metadata.ReadMarker(ctx, f.logger, f.bkt, id.String(), m); err != nil {
+		if !errors.Is(err, metadata.ErrorMarkerNotFound) {
+			level.Warn(f.logger).Log("msg", "failed to read upload-completed mark", "block", id, "err", err)
+		}
+		return false
+	}
+	return time.Since(time.Unix(m.UploadCompletedTime, 0)) >= f.uploadCompletedDelay
+}
+
+// RetentionMetaFilter is a BaseFetcher filter that filters out the blocks past the retention of their resolution, which
+// the compactor applying the same retention marks for deletion, so that they are not loaded while about to be deleted.
+// Blocks are filtered by their max time, so that replicas with the same retention filter the same blocks.
+// Not go-routine safe.
+type RetentionMetaFilter struct {
+	logger                log.Logger
+	retentionByResolution map[int64]time.Duration
+	now                   func() time.Time
+}
+
+// NewRetentionMetaFilter creates RetentionMetaFilter with the retentions by resolution in milliseconds. Blocks
+// of resolutions with no or zero retention are retained forever.
+func NewRetentionMetaFilter(logger log.Logger, retentionByResolution map[int64]time.Duration) *RetentionMetaFilter {
+	return &RetentionMetaFilter{
+		logger:                logger,
+		retentionByResolution: retentionByResolution,
+		now:                   time.Now,
+	}
+}
+
+// Filter filters out blocks past the retention of their resolution.
+func (f *RetentionMetaFilter) Filter(_ context.Context, metas map[ulid.ULID]*metadata.Meta, synced GaugeVec, modified GaugeVec) error {
+	for id, m := range metas {
+		retention := f.retentionByResolution[m.Thanos.Downsample.Resolution]
+		if retention <= 0 {
+			continue
+		}
+		maxTime := time.Unix(m.MaxTime/1000, 0)
+		if f.now().After(maxTime.Add(retention)) {
+			level.Debug(f.logger).Log("msg", "block is past retention", "block", id, "maxTime", maxTime.String())
+			synced.WithLabelValues(RetentionExceededMeta).Inc()
+			delete(metas, id)
+		}
+	}
+	return nil
+}
+
 // IgnoreDeletionMarkFilter is a filter that filters out the blocks that are marked for deletion after a given delay.
 // The delay duration is to make sure that the replacement block can be fetched before we filter out the old block.
 // Delay is not considered when computing DeletionMarkBlocks map.
@@ -23,6 +23,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/thanos-io/objstore"
 	"github.com/thanos-io/objstore/objtesting"
@@ -291,6 +292,9 @@ func TestMetaFetcher_Fetch(t *testing.T) {
 				testutil.Equals(t, 0.0, promtest.ToFloat64(fetcher.metrics.Synced.WithLabelValues(timeExcludedMeta)))
 				testutil.Equals(t, float64(expectedFailures), promtest.ToFloat64(fetcher.metrics.Synced.WithLabelValues(FailedMeta)))
 				testutil.Equals(t, 0.0, promtest.ToFloat64(fetcher.metrics.Synced.WithLabelValues(tooFreshMeta)))
+				if tcase.expectedMetaErr == nil {
+					testutil.Equals(t, map[string]int{"block.ulidFilter": tcase.expectedFiltered}, fetcher.FilteredBlocks())
+				}
 			}); !ok {
 				return
 			}
@@ -525,9 +529,118 @@ func TestTimePartitionMetaFilter_Filter(t *testing.T) {
 
 }
 
+func TestRetentionMetaFilter_Filter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1000000, 0)
+	f := NewRetentionMetaFilter(log.NewNopLogger(), map[int64]time.Duration{
+		0:      time.Hour,
+		300000: 0,
+	})
+	f.now = func() time.Time { return now }
+
+	newMeta := func(maxTime time.Time, resolution int64) *metadata.Meta {
+		m := &metadata.Meta{}
+		m.MaxTime = maxTime.UnixMilli()
+		m.Thanos.Downsample.Resolution = resolution
+		return m
+	}
+	input := map[ulid.ULID]*metadata.Meta{
+		ULID(1): newMeta(now.Add(-30*time.Minute), 0),
+		ULID(2): newMeta(now.Add(-2*time.Hour), 0),
+		ULID(3): newMeta(now.Add(-2*time.Hour), 300000),
+		ULID(4): newMeta(now.Add(-2*time.Hour), 3600000),
+	}
+	expected := map[ulid.ULID]*metadata.Meta{
+		ULID(1): input[ULID(1)],
+		ULID(3): input[ULID(3)],
+		ULID(4): input[ULID(4)],
+	}
+
+	m := newTestFetcherMetrics()
+	testutil.Ok(t, f.Filter(context.Background(), input, m.Synced, nil))
+
+	testutil.Equals(t, 1.0, promtest.ToFloat64(m.Synced.WithLabelValues(RetentionExceededMeta)))
+	testutil.Equals(t, expected, input)
+}
+
+func TestLabelMatcherMetaFilter_Filter(t *testing.T) {
+	t.Parallel()
+
+	f := NewLabelMatcherMetaFilter([][]*labels.Matcher{
+		{labels.MustNewMatcher(labels.MatchRegexp, "tenant", "premium-.*")},
+		{labels.MustNewMatcher(labels.MatchEqual, "tenant", "free"), labels.MustNewMatcher(labels.MatchEqual, "replica", "")},
+	})
+
+	input := map[ulid.ULID]*metadata.Meta{
+		ULID(1): {Thanos: metadata.Thanos{Labels: map[string]string{"tenant": "premium-a"}}},
+		ULID(2): {Thanos: metadata.Thanos{Labels: map[string]string{"tenant": "premium-b", "replica": "1"}}},
+		ULID(3): {Thanos: metadata.Thanos{Labels: map[string]string{"tenant": "free"}}},
+		ULID(4): {Thanos: metadata.Thanos{Labels: map[string]string{"tenant": "free", "replica": "1"}}},
+		ULID(5): {Thanos: metadata.Thanos{Labels: map[string]string{"tenant": "other"}}},
+		ULID(6): {},
+	}
+	expected := map[ulid.ULID]*metadata.Meta{
+		ULID(1): input[ULID(1)],
+		ULID(2): input[ULID(2)],
+		ULID(3): input[ULID(3)],
+	}
+
+	m := newTestFetcherMetrics()
+	testutil.Ok(t, f.Filter(context.Background(), input, m.Synced, nil))
+
+	testutil.Equals(t, 3.0, promtest.ToFloat64(m.Synced.WithLabelValues(labelExcludedMeta)))
+	testutil.Equals(t, expected, input)
+}
+
 type sourcesAndResolution struct {
 	sources    []ulid.ULID
 	resolution int64
+	shard      *metadata.Shard
+}
+
+func TestGroupShardedMetaFilter_Filter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	_, err := NewGroupShardedMetaFilter(3, 3)
+	testutil.NotOk(t, err)
+
+	newInput := func() map[ulid.ULID]*metadata.Meta {
+		input := map[ulid.ULID]*metadata.Meta{}
+		for i := 0; i < 20; i++ {
+			input[ULID(2*i)] = &metadata.Meta{Thanos: metadata.Thanos{Labels: map[string]string{"cluster": fmt.Sprintf("%d", i)}}}
+			// All resolutions of the same labels belong to the same shard.
+			input[ULID(2*i+1)] = &metadata.Meta{Thanos: metadata.Thanos{Labels: map[string]string{"cluster": fmt.Sprintf("%d", i)}, Downsample: metadata.ThanosDownsample{Resolution: 300000}}}
+		}
+		return input
+	}
+
+	seen := map[ulid.ULID]struct{}{}
+	for i := uint64(0); i < 3; i++ {
+		f, err := NewGroupShardedMetaFilter(i, 3)
+		testutil.Ok(t, err)
+
+		input := newInput()
+		testutil.Ok(t, f.Filter(ctx, input, newTestFetcherMetrics().Synced, nil))
+		for id := range input {
+			_, ok := seen[id]
+			testutil.Assert(t, !ok, "block %v in more than one shard", id)
+			seen[id] = struct{}{}
+
+			_, ok = input[ULID(int(id.Time())^1)]
+			testutil.Assert(t, ok, "resolutions of block %v in different shards", id)
+		}
+	}
+	testutil.Equals(t, 40, len(seen))
+
+	// Sharding is disabled with a single shard.
+	f, err := NewGroupShardedMetaFilter(0, 1)
+	testutil.Ok(t, err)
+	input := newInput()
+	testutil.Ok(t, f.Filter(ctx, input, newTestFetcherMetrics().Synced, nil))
+	testutil.Equals(t, 40, len(input))
 }
 
 func TestDeduplicateFilter_Filter(t *testing.T) {
@@ -848,6 +961,97 @@ func TestDeduplicateFilter_Filter(t *testing.T) {
 				ULID(12),
 			},
 		},
+		{
+			name: "compacted blocks sharded into all shards",
+			input: map[ulid.ULID]*sourcesAndResolution{
+				ULID(1): {
+					sources:    []ulid.ULID{ULID(1)},
+					resolution: 0,
+				},
+				ULID(2): {
+					sources:    []ulid.ULID{ULID(2)},
+					resolution: 0,
+				},
+				ULID(3): {
+					sources:    []ulid.ULID{ULID(1), ULID(2)},
+					resolution: 0,
+					shard:      &metadata.Shard{Index: 0, Count: 2},
+				},
+				ULID(4): {
+					sources:    []ulid.ULID{ULID(1), ULID(2)},
+					resolution: 0,
+					shard:      &metadata.Shard{Index: 1, Count: 2},
+				},
+			},
+			expected: []ulid.ULID{
+				ULID(3),
+				ULID(4),
+			},
+		},
+		{
+			name: "compacted blocks sharded into shards of different counts",
+			input: map[ulid.ULID]*sourcesAndResolution{
+				ULID(1): {
+					sources:    []ulid.ULID{ULID(1)},
+					resolution: 0,
+				},
+				ULID(2): {
+					sources:    []ulid.ULID{ULID(1), ULID(2)},
+					resolution: 0,
+					shard:      &metadata.Shard{Index: 1, Count: 2},
+				},
+				ULID(3): {
+					sources:    []ulid.ULID{ULID(1), ULID(2)},
+					resolution: 0,
+					shard:      &metadata.Shard{Index: 0, Count: 4},
+				},
+				ULID(4): {
+					sources:    []ulid.ULID{ULID(1), ULID(2)},
+					resolution: 0,
+					shard:      &metadata.Shard{Index: 2, Count: 4},
+				},
+			},
+			expected: []ulid.ULID{
+				ULID(2),
+				ULID(3),
+				ULID(4),
+			},
+		},
+		{
+			name: "compacted blocks sharded into some of the shards",
+			input: map[ulid.ULID]*sourcesAndResolution{
+				ULID(1): {
+					sources:    []ulid.ULID{ULID(1)},
+					resolution: 0,
+				},
+				ULID(2): {
+					sources:    []ulid.ULID{ULID(2)},
+					resolution: 0,
+				},
+				ULID(3): {
+					sources:    []ulid.ULID{ULID(1), ULID(2)},
+					resolution: 0,
+					shard:      &metadata.Shard{Index: 1, Count: 2},
+				},
+				ULID(4): {
+					sources:    []ulid.ULID{ULID(1), ULID(2)},
+					resolution: 0,
+					shard:      &metadata.Shard{Index: 0, Count: 4},
+				},
+				ULID(5): {
+					sources:    []ulid.ULID{ULID(1), ULID(2), ULID(6)},
+					resolution: 0,
+					shard:      &metadata.Shard{Index: 3, Count: 4},
+				},
+			},
+			expected: []ulid.ULID{
+				ULID(1),
+				ULID(2),
+				ULID(3),
+				ULID(4),
+				ULID(5),
+			},
+		},
 	} {
 		f := NewDeduplicateFilter(1)
 		if ok := t.Run(tcase.name, func(t *testing.T) {
@@ -866,12 +1070,22 @@ func TestDeduplicateFilter_Filter(t *testing.T) {
 						Downsample: metadata.ThanosDownsample{
 							Resolution: metaInfo.resolution,
 						},
+						Shard: metaInfo.shard,
 					},
 				}
 			}
 			testutil.Ok(t, f.Filter(ctx, metas, m.Synced, nil))
 			compareSliceWithMapKeys(t, metas, tcase.expected)
 			testutil.Equals(t, float64(inputLen-len(tcase.expected)), promtest.ToFloat64(m.Synced.WithLabelValues(duplicateMeta)))
+			// The replacements of every duplicate are kept and include its sources.
+			testutil.Equals(t, len(f.DuplicateIDs()), len(f.Replacements()))
+			for _, id := range f.DuplicateIDs() {
+				testutil.Assert(t, len(f.Replacements()[id]) > 0, "duplicate %s without replacement", id)
+				for _, r := range f.Replacements()[id] {
+					testutil.Assert(t, metas[r] != nil, "replacement %s of %s filtered", r, id)
+					testutil.Assert(t, contains(tcase.input[r].sources, tcase.input[id].sources), "replacement %s without the sources of %s", r, id)
+				}
+			}
 		}); !ok {
 			return
 		}
@@ -1075,6 +1289,43 @@ func TestConsistencyDelayMetaFilter_Filter_0(t *testing.T) {
 	})
 }
 
+func TestConsistencyDelayMetaFilter_UploadCompleted(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	now := time.Now()
+
+	u := &ulidBuilder{}
+	completed := u.ULID(now.Add(-10 * time.Minute))
+	completedRecently := u.ULID(now.Add(-10 * time.Minute))
+	_ = u.ULID(now.Add(-10 * time.Minute)) // Without upload-completed mark.
+	for id, uploadCompletedTime := range map[ulid.ULID]time.Time{
+		completed:         now.Add(-5 * time.Minute),
+		completedRecently: now.Add(-30 * time.Second),
+	} {
+		b, err := json.Marshal(metadata.UploadCompletedMark{
+			ID:                  id,
+			Version:             metadata.UploadCompletedMarkVersion1,
+			UploadCompletedTime: uploadCompletedTime.Unix(),
+		})
+		testutil.Ok(t, err)
+		testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), metadata.UploadCompletedMarkFilename), bytes.NewReader(b)))
+	}
+
+	input := map[ulid.ULID]*metadata.Meta{}
+	for _, id := range u.created {
+		input[id] = &metadata.Meta{Thanos: metadata.Thanos{Source: metadata.ReceiveSource}}
+	}
+
+	m := newTestFetcherMetrics()
+	f := NewConsistencyDelayMetaFilterWithoutMetrics(log.NewNopLogger(), 30*time.Minute)
+	f.SetUploadCompletedDelay(objstore.WithNoopInstr(bkt), 2*time.Minute)
+	testutil.Ok(t, f.Filter(ctx, input, m.Synced, nil))
+	testutil.Equals(t, map[ulid.ULID]*metadata.Meta{completed: input[completed]}, input)
+	testutil.Equals(t, 2.0, promtest.ToFloat64(m.Synced.WithLabelValues(tooFreshMeta)))
+}
+
 func TestIgnoreDeletionMarkFilter_Filter(t *testing.T) {
 	objtesting.ForeachStore(t, func(t *testing.T, bkt objstore.Bucket) {
 		ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
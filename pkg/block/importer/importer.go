@@ -0,0 +1,340 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package importer converts metrics exported by other systems into Thanos blocks.
+package importer
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/logutil"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// Format is the format of the imported data.
+type Format string
+
+const (
+	// FormatOpenMetrics is the OpenMetrics text format. Every sample needs a timestamp.
+	FormatOpenMetrics Format = "openmetrics"
+	// FormatCSV is a CSV file with a header. The "timestamp" column holds a Unix
+	// timestamp in milliseconds or an RFC3339 time and the "value" column holds the
+	// sample value. Every other column is a label, "__name__" being the metric name.
+	// Empty label values are ignored.
+	FormatCSV Format = "csv"
+)
+
+const (
+	csvTimestampColumn = "timestamp"
+	csvValueColumn     = "value"
+
+	// maxSamplesInAppender bounds the number of samples kept in memory before committing them.
+	maxSamplesInAppender = 5000
+)
+
+// Options configures an import.
+type Options struct {
+	Format Format
+	// BlockDuration is the maximum time range of a produced block. It is rounded down to a
+	// range the compactor compacts to, so that produced blocks are aligned with compacted ones.
+	BlockDuration time.Duration
+	// ExternalLabels are set as Thanos external labels of the produced blocks.
+	ExternalLabels labels.Labels
+	// TmpDir is the directory blocks are written to before being uploaded.
+	TmpDir string
+}
+
+// Importer writes imported samples into time aligned blocks and uploads them to the bucket.
+// Samples of every series have to be sorted by time.
+type Importer struct {
+	logger log.Logger
+	bkt    objstore.Bucket
+	opts   Options
+}
+
+// New creates a new Importer.
+func New(logger log.Logger, bkt objstore.Bucket, opts Options) (*Importer, error) {
+	if opts.Format != FormatOpenMetrics && opts.Format != FormatCSV {
+		return nil, errors.Errorf("unsupported format %q", opts.Format)
+	}
+	if opts.BlockDuration < 2*time.Hour {
+		return nil, errors.New("block duration has to be at least 2h")
+	}
+	if opts.ExternalLabels.IsEmpty() {
+		return nil, errors.New("empty external labels are not allowed for Thanos block")
+	}
+	return &Importer{logger: logger, bkt: bkt, opts: opts}, nil
+}
+
+// Import converts the input into blocks and uploads them. It returns the metas of the uploaded blocks.
+// The input is parsed once, every sample being appended to the block writer of its window of the block duration.
+func (i *Importer) Import(ctx context.Context, input []byte) ([]metadata.Meta, error) {
+	dir, err := os.MkdirTemp(i.opts.TmpDir, "import")
+	if err != nil {
+		return nil, errors.Wrap(err, "create tmp dir")
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			level.Warn(i.logger).Log("msg", "failed to remove tmp dir", "dir", dir, "err", err)
+		}
+	}()
+
+	var (
+		bd      = block.CompatibleBlockDuration(i.opts.BlockDuration)
+		windows = map[int64]*window{}
+	)
+	defer func() {
+		for _, w := range windows {
+			w.close(i.logger)
+		}
+	}()
+	if err := i.forEachSample(input, func(lset labels.Labels, t int64, v float64) error {
+		start := t - t%bd
+		if t%bd < 0 {
+			start -= bd
+		}
+		w, ok := windows[start]
+		if !ok {
+			var err error
+			if w, err = newWindow(ctx, i.logger, dir, start, start+bd); err != nil {
+				return errors.Wrapf(err, "window %v - %v", time.UnixMilli(start).UTC(), time.UnixMilli(start+bd).UTC())
+			}
+			windows[start] = w
+		}
+		return w.append(ctx, lset, t, v)
+	}); err != nil {
+		return nil, err
+	}
+	if len(windows) == 0 {
+		level.Info(i.logger).Log("msg", "no samples to import")
+		return nil, nil
+	}
+
+	starts := make([]int64, 0, len(windows))
+	for start := range windows {
+		starts = append(starts, start)
+	}
+	slices.Sort(starts)
+
+	var metas []metadata.Meta
+	for _, start := range starts {
+		w := windows[start]
+		meta, err := i.upload(ctx, dir, w)
+		if err != nil {
+			return metas, errors.Wrapf(err, "import %v - %v", time.UnixMilli(w.start).UTC(), time.UnixMilli(w.end).UTC())
+		}
+		// Release the memory of the window as soon as it is uploaded.
+		w.close(i.logger)
+		delete(windows, start)
+		metas = append(metas, *meta)
+	}
+	return metas, nil
+}
+
+// upload writes the block of the window into dir and uploads it.
+func (i *Importer) upload(ctx context.Context, dir string, w *window) (*metadata.Meta, error) {
+	if err := w.app.Commit(); err != nil {
+		return nil, errors.Wrap(err, "commit")
+	}
+	w.app = nil
+
+	id, err := w.w.Flush(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "flush block")
+	}
+	bdir := filepath.Join(dir, id.String())
+	defer func() {
+		if err := os.RemoveAll(bdir); err != nil {
+			level.Warn(i.logger).Log("msg", "failed to remove block dir", "dir", bdir, "err", err)
+		}
+	}()
+	meta, err := metadata.InjectThanos(i.logger, bdir, metadata.Thanos{
+		Labels:     i.opts.ExternalLabels.Map(),
+		Downsample: metadata.ThanosDownsample{Resolution: 0},
+		Source:     metadata.BucketImportSource,
+	}, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "inject thanos meta")
+	}
+	if err := block.Upload(ctx, i.logger, i.bkt, bdir, metadata.NoneFunc); err != nil {
+		return nil, errors.Wrapf(err, "upload block %s", id)
+	}
+	level.Info(i.logger).Log("msg", "uploaded imported block", "block", id, "mint", meta.MinTime, "maxt", meta.MaxTime, "samples", w.samples)
+	return meta, nil
+}
+
+// window is the block writer of the samples in [start, end).
+type window struct {
+	start, end int64
+
+	w                *tsdb.BlockWriter
+	app              storage.Appender
+	samples, pending int
+}
+
+func newWindow(ctx context.Context, logger log.Logger, dir string, start, end int64) (*window, error) {
+	// The block writer only accepts samples up to half its block range older than the newest one, pretend
+	// the block is twice as large to accept samples over the whole window, as compaction never happens.
+	w, err := tsdb.NewBlockWriter(logutil.GoKitLogToSlog(logger), dir, 2*(end-start))
+	if err != nil {
+		return nil, errors.Wrap(err, "create block writer")
+	}
+	return &window{start: start, end: end, w: w, app: w.Appender(ctx)}, nil
+}
+
+func (w *window) append(ctx context.Context, lset labels.Labels, t int64, v float64) error {
+	if _, err := w.app.Append(0, lset, t, v); err != nil {
+		return errors.Wrapf(err, "append %s", lset)
+	}
+	w.samples++
+	if w.pending++; w.pending >= maxSamplesInAppender {
+		if err := w.app.Commit(); err != nil {
+			return errors.Wrap(err, "commit")
+		}
+		w.app, w.pending = w.w.Appender(ctx), 0
+	}
+	return nil
+}
+
+// close releases the block writer, rolling back the samples not committed yet.
+func (w *window) close(logger log.Logger) {
+	if w.app != nil {
+		_ = w.app.Rollback()
+		w.app = nil
+	}
+	runutil.CloseWithLogOnErr(logger, w.w, "block writer")
+}
+
+type sampleFunc func(lset labels.Labels, t int64, v float64) error
+
+func (i *Importer) forEachSample(input []byte, f sampleFunc) error {
+	switch i.opts.Format {
+	case FormatCSV:
+		return forEachCSVSample(input, f)
+	default:
+		return forEachOpenMetricsSample(input, f)
+	}
+}
+
+func forEachOpenMetricsSample(input []byte, f sampleFunc) error {
+	p := textparse.NewOpenMetricsParser(input, labels.NewSymbolTable())
+	for {
+		e, err := p.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "parse")
+		}
+		if e != textparse.EntrySeries {
+			continue
+		}
+
+		var lset labels.Labels
+		p.Labels(&lset)
+		_, ts, v := p.Series()
+		if ts == nil {
+			return errors.Errorf("expected timestamp for series %s, got none", lset)
+		}
+		if err := f(lset, *ts, v); err != nil {
+			return err
+		}
+	}
+}
+
+func forEachCSVSample(input []byte, f sampleFunc) error {
+	r := csv.NewReader(bytes.NewReader(input))
+	r.ReuseRecord = true
+
+	header, err := r.Read()
+	if err != nil {
+		return errors.Wrap(err, "read header")
+	}
+	var (
+		names         = make([]string, len(header))
+		tsCol, valCol = -1, -1
+	)
+	for col, name := range header {
+		name = strings.TrimSpace(name)
+		switch name {
+		case csvTimestampColumn:
+			tsCol = col
+		case csvValueColumn:
+			valCol = col
+		}
+		names[col] = name
+	}
+	if tsCol < 0 || valCol < 0 {
+		return errors.Errorf("header has to contain the %q and %q columns", csvTimestampColumn, csvValueColumn)
+	}
+
+	b := labels.NewScratchBuilder(len(header))
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "read record")
+		}
+		line, _ := r.FieldPos(0)
+
+		t, err := parseCSVTimestamp(rec[tsCol])
+		if err != nil {
+			return errors.Wrapf(err, "line %d: parse timestamp", line)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(rec[valCol]), 64)
+		if err != nil {
+			return errors.Wrapf(err, "line %d: parse value", line)
+		}
+
+		b.Reset()
+		for col, val := range rec {
+			if col == tsCol || col == valCol || val == "" {
+				continue
+			}
+			b.Add(names[col], val)
+		}
+		b.Sort()
+		lset := b.Labels()
+		if lset.Get(labels.MetricName) == "" {
+			return errors.Errorf("line %d: missing metric name", line)
+		}
+		if err := f(lset, t, v); err != nil {
+			return err
+		}
+	}
+}
+
+// parseCSVTimestamp parses a Unix timestamp in milliseconds or an RFC3339 time.
+func parseCSVTimestamp(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if t, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixMilli(), nil
+}
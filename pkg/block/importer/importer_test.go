@@ -0,0 +1,135 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package importer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+func TestImporter(t *testing.T) {
+	t.Parallel()
+
+	var om, csv strings.Builder
+	csv.WriteString("__name__,job,timestamp,value\n")
+	// One sample every 10 minutes over 5 hours, starting 1 hour into the first 2h block.
+	for ts := int64(3600); ts < 6*3600; ts += 600 {
+		fmt.Fprintf(&om, "up{job=\"a\"} 1 %d\n", ts)
+		fmt.Fprintf(&csv, "up,a,%d,1\n", ts*1000)
+	}
+	om.WriteString("# EOF\n")
+
+	for _, tcase := range []struct {
+		format Format
+		input  string
+	}{
+		{format: FormatOpenMetrics, input: om.String()},
+		{format: FormatCSV, input: csv.String()},
+	} {
+		t.Run(string(tcase.format), func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			bkt := objstore.NewInMemBucket()
+			i, err := New(log.NewNopLogger(), bkt, Options{
+				Format:         tcase.format,
+				BlockDuration:  2 * time.Hour,
+				ExternalLabels: labels.FromStrings("cluster", "a"),
+				TmpDir:         t.TempDir(),
+			})
+			testutil.Ok(t, err)
+
+			metas, err := i.Import(ctx, []byte(tcase.input))
+			testutil.Ok(t, err)
+			testutil.Equals(t, 3, len(metas))
+
+			var (
+				samples uint64
+				bd      = (2 * time.Hour).Milliseconds()
+			)
+			for n, m := range metas {
+				// Blocks are aligned to the block duration.
+				testutil.Equals(t, int64(n)*bd, m.MinTime-m.MinTime%bd)
+				testutil.Assert(t, m.MaxTime <= int64(n+1)*bd)
+				testutil.Equals(t, metadata.BucketImportSource, m.Thanos.Source)
+				testutil.Equals(t, map[string]string{"cluster": "a"}, m.Thanos.Labels)
+
+				got, err := block.DownloadMeta(ctx, log.NewNopLogger(), bkt, m.ULID)
+				testutil.Ok(t, err)
+				testutil.Equals(t, m.ULID, got.ULID)
+				samples += m.Stats.NumSamples
+			}
+			testutil.Equals(t, uint64(30), samples)
+		})
+	}
+}
+
+func TestImporter_SeriesAcrossWindows(t *testing.T) {
+	t.Parallel()
+
+	// Series are not sorted by window: b has samples in the last window before those of a in the first one.
+	input := "up{job=\"b\"} 1 3600\nup{job=\"b\"} 1 18000\nup{job=\"a\"} 1 60\nup{job=\"a\"} 1 7300\n# EOF\n"
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	i, err := New(log.NewNopLogger(), bkt, Options{
+		Format:         FormatOpenMetrics,
+		BlockDuration:  2 * time.Hour,
+		ExternalLabels: labels.FromStrings("cluster", "a"),
+		TmpDir:         t.TempDir(),
+	})
+	testutil.Ok(t, err)
+
+	metas, err := i.Import(ctx, []byte(input))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, len(metas))
+	for n, expected := range []struct {
+		mint, maxt int64
+		series     uint64
+	}{
+		{mint: 60000, maxt: 3600001, series: 2},
+		{mint: 7300000, maxt: 7300001, series: 1},
+		{mint: 18000000, maxt: 18000001, series: 1},
+	} {
+		testutil.Equals(t, expected.mint, metas[n].MinTime)
+		testutil.Equals(t, expected.maxt, metas[n].MaxTime)
+		testutil.Equals(t, expected.series, metas[n].Stats.NumSeries)
+	}
+}
+
+func TestImporterCSVErrors(t *testing.T) {
+	t.Parallel()
+
+	i, err := New(log.NewNopLogger(), objstore.NewInMemBucket(), Options{
+		Format:         FormatCSV,
+		BlockDuration:  2 * time.Hour,
+		ExternalLabels: labels.FromStrings("cluster", "a"),
+		TmpDir:         t.TempDir(),
+	})
+	testutil.Ok(t, err)
+
+	for _, input := range []string{
+		"__name__,value\nup,1\n",
+		"__name__,timestamp,value\nup,yesterday,1\n",
+		"__name__,timestamp,value\nup,1000,one\n",
+		"job,timestamp,value\na,1000,1\n",
+	} {
+		_, err := i.Import(context.Background(), []byte(input))
+		testutil.NotOk(t, err, input)
+	}
+
+	_, err = New(log.NewNopLogger(), objstore.NewInMemBucket(), Options{Format: FormatCSV, BlockDuration: time.Hour})
+	testutil.NotOk(t, err)
+}
@@ -86,10 +86,14 @@ type HealthStats struct {
 	ChunkMinSize int64
 	ChunkAvgSize int64
 	ChunkMaxSize int64
+	// ChunkTotalSize is estimated from the average size of the chunks.
+	ChunkTotalSize int64
 
 	SeriesMinSize int64
 	SeriesAvgSize int64
 	SeriesMaxSize int64
+	// SeriesTotalSize is estimated from the average size of the series.
+	SeriesTotalSize int64
 
 	SingleSampleSeries int64
 	SingleSampleChunks int64
@@ -178,6 +182,20 @@ func (i HealthStats) AnyErr() error {
 	return nil
 }
 
+// IndexStats returns the stats to record in the meta of the block.
+func (i HealthStats) IndexStats() metadata.IndexStats {
+	return metadata.IndexStats{
+		// Max sizes are negative if there is no series or chunk to measure.
+		SeriesMaxSize:   max(0, i.SeriesMaxSize),
+		ChunkMaxSize:    max(0, i.ChunkMaxSize),
+		SeriesCount:     i.TotalSeries,
+		ChunkCount:      i.TotalChunks,
+		LabelNamesCount: i.LabelNamesCount,
+		SeriesTotalSize: i.SeriesTotalSize,
+		ChunkTotalSize:  i.ChunkTotalSize,
+	}
+}
+
 type minMaxSumInt64 struct {
 	sum int64
 	min int64
@@ -392,10 +410,12 @@ func GatherIndexHealthStats(ctx context.Context, logger log.Logger, fn string, m
 	stats.ChunkMaxSize = chunkSize.max
 	stats.ChunkAvgSize = chunkSize.Avg()
 	stats.ChunkMinSize = chunkSize.min
+	stats.ChunkTotalSize = stats.ChunkAvgSize * stats.TotalChunks
 
 	stats.SeriesMaxSize = seriesSize.max
 	stats.SeriesAvgSize = seriesSize.Avg()
 	stats.SeriesMinSize = seriesSize.min
+	stats.SeriesTotalSize = stats.SeriesAvgSize * stats.TotalSeries
 
 	stats.ChunkMaxDuration = time.Duration(chunkDuration.max) * time.Millisecond
 	stats.ChunkAvgDuration = time.Duration(chunkDuration.Avg()) * time.Millisecond
@@ -96,3 +96,31 @@ func TestGatherIndexHealthStatsReturnsOutOfOrderChunksErr(t *testing.T) {
 	testutil.Equals(t, 1, stats.OutOfOrderChunks)
 	testutil.NotOk(t, stats.OutOfOrderChunksErr())
 }
+
+func TestHealthStats_IndexStats(t *testing.T) {
+	ctx := context.Background()
+
+	tmpDir := t.TempDir()
+
+	b, err := e2eutil.CreateBlock(ctx, tmpDir, []labels.Labels{
+		labels.FromStrings("a", "1"),
+		labels.FromStrings("a", "2"),
+		labels.FromStrings("a", "3", "b", "1"),
+	}, 300, 0, 1000, labels.EmptyLabels(), 124, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+
+	stats, err := GatherIndexHealthStats(ctx, log.NewNopLogger(), filepath.Join(tmpDir, b.String(), IndexFilename), 0, 1000)
+	testutil.Ok(t, err)
+
+	s := stats.IndexStats()
+	testutil.Equals(t, int64(3), s.SeriesCount)
+	testutil.Equals(t, stats.TotalChunks, s.ChunkCount)
+	testutil.Equals(t, int64(2), s.LabelNamesCount)
+	testutil.Equals(t, stats.SeriesMaxSize, s.SeriesMaxSize)
+	testutil.Equals(t, stats.SeriesAvgSize*3, s.SeriesTotalSize)
+	testutil.Equals(t, stats.SeriesAvgSize, s.SeriesAvgSize())
+	testutil.Assert(t, s.SeriesTotalSize > 0)
+
+	// Max sizes of empty indexes are not recorded.
+	testutil.Equals(t, metadata.IndexStats{}, HealthStats{SeriesMaxSize: math.MinInt64, ChunkMaxSize: math.MinInt64}.IndexStats())
+}
@@ -37,9 +37,14 @@ import (
 const (
 	// BinaryFormatV1 represents first version of index-header file.
 	BinaryFormatV1 = 1
+	// BinaryFormatV2 represents the version of index-header file with the checksum of its content in its TOC, so that
+	// corrupted index-headers persisted on disk are detected.
+	BinaryFormatV2 = 2
 
 	indexTOCLen  = 6*8 + crc32.Size
 	binaryTOCLen = 2*8 + crc32.Size
+	// binaryTOCV2Len is the length of the TOC of the V2 format, which holds the checksum of the content before it.
+	binaryTOCV2Len = binaryTOCLen + crc32.Size
 	// headerLen represents number of bytes reserved of index header for header.
 	headerLen = 4 + 1 + 1 + 8
 
@@ -66,10 +71,17 @@ func newCRC32() hash.Hash32 {
 	return crc32.New(castagnoliTable)
 }
 
+const (
+	rebuildReasonCorrupted = "corrupted"
+	rebuildReasonVersion   = "version"
+)
+
 // BinaryReaderMetrics holds metrics tracked by BinaryReader.
 type BinaryReaderMetrics struct {
-	downloadDuration prometheus.Histogram
-	loadDuration     prometheus.Histogram
+	downloadDuration  prometheus.Histogram
+	loadDuration      prometheus.Histogram
+	rebuilds          *prometheus.CounterVec
+	prebuiltDownloads *prometheus.CounterVec
 }
 
 // NewBinaryReaderMetrics makes new BinaryReaderMetrics.
@@ -89,6 +101,14 @@ func NewBinaryReaderMetrics(reg prometheus.Registerer) *BinaryReaderMetrics {
 			NativeHistogramMaxBucketNumber: 256,
 			NativeHistogramBucketFactor:    1.1,
 		}),
+		rebuilds: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "indexheader_rebuilds_total",
+			Help: "Total number of index-headers persisted on disk rebuilt, by reason: corrupted or version.",
+		}, []string{"reason"}),
+		prebuiltDownloads: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "indexheader_prebuilt_downloads_total",
+			Help: "Total number of attempts to download the index-headers built next to the blocks, by result: downloaded, missing or failed.",
+		}, []string{"result"}),
 	}
 }
 
@@ -287,6 +307,8 @@ type binaryWriter struct {
 	buf encoding.Encbuf
 
 	crc32 hash.Hash
+	// contentCRC32 is the checksum of all the bytes written before the TOC.
+	contentCRC32 hash.Hash32
 }
 
 func newBinaryWriter(id ulid.ULID, cacheFilename string, buf []byte) (w *binaryWriter, err error) {
@@ -328,15 +350,16 @@ func newBinaryWriter(id ulid.ULID, cacheFilename string, buf []byte) (w *binaryW
 		writer: binWriter,
 
 		// Reusable memory.
-		buf:   encoding.Encbuf{B: buf},
-		crc32: newCRC32(),
+		buf:          encoding.Encbuf{B: buf},
+		crc32:        newCRC32(),
+		contentCRC32: newCRC32(),
 	}
 
 	w.buf.Reset()
 	w.buf.PutBE32(MagicIndex)
-	w.buf.PutByte(BinaryFormatV1)
+	w.buf.PutByte(BinaryFormatV2)
 
-	return w, w.writer.Write(w.buf.Get())
+	return w, w.write(w.buf.Get())
 }
 
 type PosWriterWithBuffer interface {
@@ -473,7 +496,7 @@ func (w *binaryWriter) AddIndexMeta(indexVersion int, indexPostingOffsetTable ui
 	w.buf.Reset()
 	w.buf.PutByte(byte(indexVersion))
 	w.buf.PutBE64(indexPostingOffsetTable)
-	return w.writer.Write(w.buf.Get())
+	return w.write(w.buf.Get())
 }
 
 func (w *binaryWriter) SymbolsWriter() io.Writer {
@@ -491,6 +514,7 @@ func (w *binaryWriter) WriteTOC() error {
 
 	w.buf.PutBE64(w.toc.Symbols)
 	w.buf.PutBE64(w.toc.PostingsOffsetTable)
+	w.buf.PutBE32(w.contentCRC32.Sum32())
 
 	w.buf.PutHash(w.crc32)
 
@@ -499,10 +523,16 @@ func (w *binaryWriter) WriteTOC() error {
 
 func (w *binaryWriter) Write(p []byte) (int, error) {
 	n := w.writer.Pos()
-	err := w.writer.Write(p)
+	err := w.write(p)
 	return int(w.writer.Pos() - n), err
 }
 
+// write writes the bytes of the content of the index-header, before its TOC.
+func (w *binaryWriter) write(p []byte) error {
+	_, _ = w.contentCRC32.Write(p)
+	return w.writer.Write(p)
+}
+
 func (w *binaryWriter) Buffer() []byte {
 	pwb, ok := w.writer.(PosWriterWithBuffer)
 	if ok {
@@ -566,26 +596,42 @@ type BinaryReader struct {
 	metrics *BinaryReaderMetrics
 }
 
-// NewBinaryReader loads or builds new index-header if not present on disk.
+// NewBinaryReader loads or builds new index-header if not present on disk. The index-header on disk is rebuilt if
+// its checksum does not match its content or if it is of an older version.
 func NewBinaryReader(ctx context.Context, logger log.Logger, bkt objstore.BucketReader, dir string, id ulid.ULID, postingOffsetsInMemSampling int, metrics *BinaryReaderMetrics) (*BinaryReader, error) {
+	return newBinaryReader(ctx, logger, bkt, dir, id, postingOffsetsInMemSampling, metrics, true, false)
+}
+
+// newBinaryReader is NewBinaryReader, verifying the checksum of the index-header on disk only if verify is true, e.g.
+// not when it was verified when loaded before, and downloading the index-header built next to the block, if any,
+// instead of building it if prebuilt is true.
+func newBinaryReader(ctx context.Context, logger log.Logger, bkt objstore.BucketReader, dir string, id ulid.ULID, postingOffsetsInMemSampling int, metrics *BinaryReaderMetrics, verify, prebuilt bool) (*BinaryReader, error) {
 	if dir != "" {
 		binfn := filepath.Join(dir, id.String(), block.IndexHeaderFilename)
-		br, err := newFileBinaryReader(binfn, postingOffsetsInMemSampling, metrics)
-		if err == nil {
+		br, err := newFileBinaryReader(binfn, postingOffsetsInMemSampling, metrics, verify)
+		switch {
+		case err == nil && br.version == BinaryFormatV2:
 			return br, nil
+		case err == nil:
+			runutil.CloseWithLogOnErr(logger, br, "close index-header of older version")
+			level.Info(logger).Log("msg", "index-header on disk is of an older version; recreating", "path", binfn, "version", br.version)
+			metrics.rebuilds.WithLabelValues(rebuildReasonVersion).Inc()
+		case errors.Is(err, os.ErrNotExist):
+			level.Debug(logger).Log("msg", "index-header doesn't exist on disk; creating", "path", binfn)
+		default:
+			level.Warn(logger).Log("msg", "failed to read index-header from disk; recreating", "path", binfn, "err", err)
+			metrics.rebuilds.WithLabelValues(rebuildReasonCorrupted).Inc()
 		}
 
-		level.Debug(logger).Log("msg", "failed to read index-header from disk; recreating", "path", binfn, "err", err)
-
 		start := time.Now()
-		if _, err := WriteBinary(ctx, bkt, id, binfn, metrics.downloadDuration); err != nil {
+		if _, err := buildBinary(ctx, logger, bkt, id, binfn, metrics, prebuilt); err != nil {
 			return nil, errors.Wrap(err, "write index header")
 		}
 
 		level.Debug(logger).Log("msg", "built index-header file", "path", binfn, "elapsed", time.Since(start))
-		return newFileBinaryReader(binfn, postingOffsetsInMemSampling, metrics)
+		return newFileBinaryReader(binfn, postingOffsetsInMemSampling, metrics, false)
 	} else {
-		buf, err := WriteBinary(ctx, bkt, id, "", metrics.downloadDuration)
+		buf, err := buildBinary(ctx, logger, bkt, id, "", metrics, prebuilt)
 		if err != nil {
 			return nil, errors.Wrap(err, "generate index header")
 		}
@@ -603,14 +649,16 @@ func newMemoryBinaryReader(buf []byte, postingOffsetsInMemSampling int, metrics
 		metrics:                     metrics,
 	}
 
-	if err := r.init(); err != nil {
+	if err := r.init(false); err != nil {
 		return nil, err
 	}
 
 	return r, nil
 }
 
-func newFileBinaryReader(path string, postingOffsetsInMemSampling int, metrics *BinaryReaderMetrics) (bw *BinaryReader, err error) {
+// newFileBinaryReader memory-maps the index-header file, verifying that its checksum matches its content if verify is
+// true.
+func newFileBinaryReader(path string, postingOffsetsInMemSampling int, metrics *BinaryReaderMetrics, verify bool) (bw *BinaryReader, err error) {
 	f, err := fileutil.OpenMmapFile(path)
 	if err != nil {
 		return nil, err
@@ -629,38 +677,47 @@ func newFileBinaryReader(path string, postingOffsetsInMemSampling int, metrics *
 		metrics:                     metrics,
 	}
 
-	if err := r.init(); err != nil {
+	if err := r.init(verify); err != nil {
 		return nil, err
 	}
 
 	return r, nil
 }
 
-// newBinaryTOCFromByteSlice return parsed TOC from given index header byte slice.
-func newBinaryTOCFromByteSlice(bs index.ByteSlice) (*BinaryTOC, error) {
-	if bs.Len() < binaryTOCLen {
-		return nil, encoding.ErrInvalidSize
+// newBinaryTOCFromByteSlice return parsed TOC from given index header byte slice of the given version, and the
+// checksum of the content before the TOC for the V2 format.
+func newBinaryTOCFromByteSlice(bs index.ByteSlice, version int) (*BinaryTOC, uint32, error) {
+	tocLen := binaryTOCLen
+	if version == BinaryFormatV2 {
+		tocLen = binaryTOCV2Len
 	}
-	b := bs.Range(bs.Len()-binaryTOCLen, bs.Len())
+	if bs.Len() < tocLen {
+		return nil, 0, encoding.ErrInvalidSize
+	}
+	b := bs.Range(bs.Len()-tocLen, bs.Len())
 
 	expCRC := binary.BigEndian.Uint32(b[len(b)-4:])
 	d := encoding.Decbuf{B: b[:len(b)-4]}
 
 	if d.Crc32(castagnoliTable) != expCRC {
-		return nil, errors.Wrap(encoding.ErrInvalidChecksum, "read index header TOC")
+		return nil, 0, errors.Wrap(encoding.ErrInvalidChecksum, "read index header TOC")
 	}
 
-	if err := d.Err(); err != nil {
-		return nil, err
-	}
-
-	return &BinaryTOC{
+	toc := &BinaryTOC{
 		Symbols:             d.Be64(),
 		PostingsOffsetTable: d.Be64(),
-	}, nil
+	}
+	var contentCRC uint32
+	if version == BinaryFormatV2 {
+		contentCRC = d.Be32()
+	}
+	if err := d.Err(); err != nil {
+		return nil, 0, err
+	}
+	return toc, contentCRC, nil
 }
 
-func (r *BinaryReader) init() (err error) {
+func (r *BinaryReader) init(verify bool) (err error) {
 	start := time.Now()
 
 	defer func() {
@@ -678,14 +735,20 @@ func (r *BinaryReader) init() (err error) {
 
 	r.indexLastPostingEnd = int64(binary.BigEndian.Uint64(r.b.Range(6, headerLen)))
 
-	if r.version != BinaryFormatV1 {
+	if r.version != BinaryFormatV1 && r.version != BinaryFormatV2 {
 		return errors.Errorf("unknown index header file version %d", r.version)
 	}
 
-	r.toc, err = newBinaryTOCFromByteSlice(r.b)
+	var contentCRC uint32
+	r.toc, contentCRC, err = newBinaryTOCFromByteSlice(r.b, r.version)
 	if err != nil {
 		return errors.Wrap(err, "read index header TOC")
 	}
+	if verify && r.version == BinaryFormatV2 {
+		if err := verifyContent(r.b, contentCRC); err != nil {
+			return err
+		}
+	}
 
 	// TODO(bwplotka): Consider contributing to Prometheus to allow specifying custom number for symbolsFactor.
 	r.symbols, err = index.NewSymbols(r.b, r.indexVersion, int(r.toc.Symbols))
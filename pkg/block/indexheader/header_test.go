@@ -4,10 +4,12 @@
 package indexheader
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"math"
 	"math/rand"
+	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -19,6 +21,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/tsdb/encoding"
 	"github.com/prometheus/prometheus/tsdb/fileutil"
@@ -121,7 +124,7 @@ func TestReaders(t *testing.T) {
 				defer func() { testutil.Ok(t, br.Close()) }()
 
 				if id == id1 {
-					testutil.Equals(t, 1, br.version)
+					testutil.Equals(t, BinaryFormatV2, br.version)
 					testutil.Equals(t, 2, br.indexVersion)
 					testutil.Equals(t, &BinaryTOC{Symbols: headerLen, PostingsOffsetTable: 114}, br.toc)
 					testutil.Equals(t, int64(905), br.indexLastPostingEnd)
@@ -353,6 +356,62 @@ func compareIndexToHeader(t *testing.T, indexByteSlice index.ByteSlice, headerRe
 	testutil.Equals(t, expRanges[labels.Label{Name: "", Value: ""}].End, ptr.End)
 }
 
+func TestBinaryReader_RebuildsCorruptedAndOlderVersions(t *testing.T) {
+	ctx := context.Background()
+
+	tmpDir := t.TempDir()
+
+	bkt, err := filesystem.NewBucket(filepath.Join(tmpDir, "bkt"))
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, bkt.Close()) }()
+
+	m := prepareIndexV2Block(t, tmpDir, bkt)
+	fn := filepath.Join(tmpDir, m.ULID.String(), block.IndexHeaderFilename)
+	_, err = WriteBinary(ctx, bkt, m.ULID, fn, dummyHistogram)
+	testutil.Ok(t, err)
+	built, err := os.ReadFile(fn)
+	testutil.Ok(t, err)
+
+	metrics := NewBinaryReaderMetrics(nil)
+	newReader := func() {
+		br, err := NewBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, m.ULID, 3, metrics)
+		testutil.Ok(t, err)
+		testutil.Equals(t, BinaryFormatV2, br.version)
+		testutil.Ok(t, br.Close())
+
+		rebuilt, err := os.ReadFile(fn)
+		testutil.Ok(t, err)
+		testutil.Equals(t, built, rebuilt)
+	}
+
+	// A valid index-header is loaded as is.
+	newReader()
+	testutil.Equals(t, float64(0), promtestutil.ToFloat64(metrics.rebuilds.WithLabelValues(rebuildReasonCorrupted)))
+
+	// A corrupted index-header is detected by its checksum and rebuilt.
+	corrupted := bytes.Clone(built)
+	corrupted[len(corrupted)/2] ^= 0xff
+	testutil.Ok(t, os.WriteFile(fn, corrupted, 0600))
+	_, err = newFileBinaryReader(fn, 3, metrics, true)
+	testutil.NotOk(t, err)
+	newReader()
+	testutil.Equals(t, float64(1), promtestutil.ToFloat64(metrics.rebuilds.WithLabelValues(rebuildReasonCorrupted)))
+
+	// An index-header of the V1 format, without checksum of its content, is still readable, but rebuilt.
+	content := bytes.Clone(built[:len(built)-binaryTOCV2Len])
+	content[4] = BinaryFormatV1
+	toc := encoding.Encbuf{}
+	toc.PutBytes(built[len(built)-binaryTOCV2Len : len(built)-binaryTOCV2Len+2*8])
+	toc.PutHash(newCRC32())
+	testutil.Ok(t, os.WriteFile(fn, append(content, toc.Get()...), 0600))
+	br, err := newFileBinaryReader(fn, 3, metrics, true)
+	testutil.Ok(t, err)
+	testutil.Equals(t, BinaryFormatV1, br.version)
+	testutil.Ok(t, br.Close())
+	newReader()
+	testutil.Equals(t, float64(1), promtestutil.ToFloat64(metrics.rebuilds.WithLabelValues(rebuildReasonVersion)))
+}
+
 func prepareIndexV2Block(t testing.TB, tmpDir string, bkt objstore.Bucket) *metadata.Meta {
 	/* Copy index 6MB block index version 2. It was generated via thanosbench. Meta.json:
 		{
@@ -432,7 +491,7 @@ func BenchmarkBinaryReader(t *testing.B) {
 
 	t.ResetTimer()
 	for i := 0; i < t.N; i++ {
-		br, err := newFileBinaryReader(fn, 32, NewBinaryReaderMetrics(nil))
+		br, err := newFileBinaryReader(fn, 32, NewBinaryReaderMetrics(nil), true)
 		testutil.Ok(t, err)
 		testutil.Ok(t, br.Close())
 	}
@@ -81,12 +81,17 @@ type LazyBinaryReader struct {
 	readerMx  sync.RWMutex
 	reader    *BinaryReader
 	readerErr error
+	// verified is true once the index-header on disk was verified by a load, so that it is not read in full again
+	// when reloaded after being unloaded.
+	verified bool
 
 	// Keep track of the last time it was used.
 	usedAt *atomic.Int64
 
 	// If true, index header will be downloaded at query time rather than initialization time.
 	lazyDownload bool
+	// If true, the index header built next to the block is downloaded, if any, instead of being built.
+	prebuilt bool
 }
 
 // NewLazyBinaryReader makes a new LazyBinaryReader. If the index-header does not exist
@@ -104,6 +109,24 @@ func NewLazyBinaryReader(
 	binaryReaderMetrics *BinaryReaderMetrics,
 	onClosed func(*LazyBinaryReader),
 	lazyDownload bool,
+) (*LazyBinaryReader, error) {
+	return newLazyBinaryReader(ctx, logger, bkt, dir, id, postingOffsetsInMemSampling, metrics, binaryReaderMetrics, onClosed, lazyDownload, false)
+}
+
+// newLazyBinaryReader is NewLazyBinaryReader, downloading the index-header built next to the block, if any, instead
+// of building it if prebuilt is true.
+func newLazyBinaryReader(
+	ctx context.Context,
+	logger log.Logger,
+	bkt objstore.BucketReader,
+	dir string,
+	id ulid.ULID,
+	postingOffsetsInMemSampling int,
+	metrics *LazyBinaryReaderMetrics,
+	binaryReaderMetrics *BinaryReaderMetrics,
+	onClosed func(*LazyBinaryReader),
+	lazyDownload bool,
+	prebuilt bool,
 ) (*LazyBinaryReader, error) {
 	if dir != "" && !lazyDownload {
 		indexHeaderFile := filepath.Join(dir, id.String(), block.IndexHeaderFilename)
@@ -116,7 +139,7 @@ func NewLazyBinaryReader(
 			level.Debug(logger).Log("msg", "the index-header doesn't exist on disk; recreating", "path", indexHeaderFile)
 
 			start := time.Now()
-			if _, err := WriteBinary(ctx, bkt, id, indexHeaderFile, binaryReaderMetrics.downloadDuration); err != nil {
+			if _, err := buildBinary(ctx, logger, bkt, id, indexHeaderFile, binaryReaderMetrics, prebuilt); err != nil {
 				return nil, errors.Wrap(err, "write index header")
 			}
 
@@ -136,6 +159,7 @@ func NewLazyBinaryReader(
 		usedAt:                      atomic.NewInt64(time.Now().UnixNano()),
 		onClosed:                    onClosed,
 		lazyDownload:                lazyDownload,
+		prebuilt:                    prebuilt,
 	}, nil
 }
 
@@ -266,7 +290,7 @@ func (r *LazyBinaryReader) load() (returnErr error) {
 	r.metrics.loadCount.Inc()
 	startTime := time.Now()
 
-	reader, err := NewBinaryReader(r.ctx, r.logger, r.bkt, r.dir, r.id, r.postingOffsetsInMemSampling, r.binaryReaderMetrics)
+	reader, err := newBinaryReader(r.ctx, r.logger, r.bkt, r.dir, r.id, r.postingOffsetsInMemSampling, r.binaryReaderMetrics, !r.verified, r.prebuilt)
 	if err != nil {
 		r.metrics.loadFailedCount.Inc()
 		r.readerErr = err
@@ -274,6 +298,7 @@ func (r *LazyBinaryReader) load() (returnErr error) {
 	}
 
 	r.reader = reader
+	r.verified = true
 	level.Debug(r.logger).Log("msg", "lazy loaded index-header", "block", r.id, "elapsed", time.Since(startTime))
 	r.metrics.loadDuration.Observe(time.Since(startTime).Seconds())
 
@@ -0,0 +1,142 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package indexheader
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/encoding"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+const (
+	prebuiltResultDownloaded = "downloaded"
+	prebuiltResultMissing    = "missing"
+	prebuiltResultFailed     = "failed"
+)
+
+// buildBinary is WriteBinary, downloading the index-header built by the compactor next to the block instead if
+// prebuilt is true and the block has a valid one.
+func buildBinary(ctx context.Context, logger log.Logger, bkt objstore.BucketReader, id ulid.ULID, filename string, metrics *BinaryReaderMetrics, prebuilt bool) ([]byte, error) {
+	if prebuilt {
+		buf, err := downloadBinary(ctx, bkt, id, filename, metrics)
+		switch {
+		case err == nil:
+			metrics.prebuiltDownloads.WithLabelValues(prebuiltResultDownloaded).Inc()
+			return buf, nil
+		case bkt.IsObjNotFoundErr(errors.Cause(err)):
+			metrics.prebuiltDownloads.WithLabelValues(prebuiltResultMissing).Inc()
+		default:
+			metrics.prebuiltDownloads.WithLabelValues(prebuiltResultFailed).Inc()
+			level.Warn(logger).Log("msg", "failed to download pre-built index-header; building it", "block", id, "err", err)
+		}
+	}
+	return WriteBinary(ctx, bkt, id, filename, metrics.downloadDuration)
+}
+
+// downloadBinary downloads the index-header built next to the block and writes it to the file, or returns it if
+// filename is empty. The index-header is verified before being written to the file.
+func downloadBinary(ctx context.Context, bkt objstore.BucketReader, id ulid.ULID, filename string, metrics *BinaryReaderMetrics) (_ []byte, err error) {
+	start := time.Now()
+
+	r, err := bkt.Get(ctx, path.Join(id.String(), block.IndexHeaderFilename))
+	if err != nil {
+		return nil, errors.Wrap(err, "get index-header")
+	}
+	defer runutil.CloseWithErrCapture(&err, r, "close index-header reader")
+
+	if filename == "" {
+		buf, err := io.ReadAll(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "read index-header")
+		}
+		if err := verifyBinary(realByteSlice(buf)); err != nil {
+			return nil, err
+		}
+		metrics.downloadDuration.Observe(time.Since(start).Seconds())
+		return buf, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "create index-header dir")
+	}
+	tmpFilename := filename + ".tmp"
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpFilename)
+		}
+	}()
+	if err := writeFile(tmpFilename, r); err != nil {
+		return nil, err
+	}
+	f, err := fileutil.OpenMmapFile(tmpFilename)
+	if err != nil {
+		return nil, err
+	}
+	verr := verifyBinary(realByteSlice(f.Bytes()))
+	if err := f.Close(); err != nil {
+		return nil, errors.Wrap(err, "close downloaded index-header")
+	}
+	if verr != nil {
+		return nil, verr
+	}
+	metrics.downloadDuration.Observe(time.Since(start).Seconds())
+	// Create index-header in atomic way, to avoid partial writes (e.g during restart or crash of store GW).
+	return nil, os.Rename(tmpFilename, filename)
+}
+
+func writeFile(filename string, r io.Reader) (err error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrap(err, "create index-header file")
+	}
+	defer runutil.CloseWithErrCapture(&err, f, "close index-header file")
+
+	if _, err := io.Copy(f, r); err != nil {
+		return errors.Wrap(err, "download index-header")
+	}
+	return f.Sync()
+}
+
+// verifyBinary verifies that the index-header is of the current version and that its checksums match its content.
+func verifyBinary(bs index.ByteSlice) error {
+	if bs.Len() < headerLen {
+		return errors.Wrap(encoding.ErrInvalidSize, "index header's header")
+	}
+	if m := binary.BigEndian.Uint32(bs.Range(0, 4)); m != MagicIndex {
+		return errors.Errorf("invalid magic number %x", m)
+	}
+	if v := int(bs.Range(4, 5)[0]); v != BinaryFormatV2 {
+		return errors.Errorf("unexpected index header file version %d", v)
+	}
+	_, contentCRC, err := newBinaryTOCFromByteSlice(bs, BinaryFormatV2)
+	if err != nil {
+		return errors.Wrap(err, "read index header TOC")
+	}
+	return verifyContent(bs, contentCRC)
+}
+
+// verifyContent verifies the checksum of the content of the index-header of the V2 format, before its TOC.
+func verifyContent(bs index.ByteSlice, contentCRC uint32) error {
+	if crc32.Checksum(bs.Range(0, bs.Len()-binaryTOCV2Len), castagnoliTable) != contentCRC {
+		return errors.Wrap(encoding.ErrInvalidChecksum, "verify index header content")
+	}
+	return nil
+}
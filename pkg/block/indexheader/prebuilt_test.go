@@ -0,0 +1,71 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package indexheader
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/thanos-io/objstore/providers/filesystem"
+
+	"github.com/thanos-io/thanos/pkg/block"
+)
+
+func TestReaderPool_PrebuiltIndexHeaders(t *testing.T) {
+	ctx := context.Background()
+
+	tmpDir := t.TempDir()
+
+	bkt, err := filesystem.NewBucket(filepath.Join(tmpDir, "bkt"))
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, bkt.Close()) }()
+
+	m := prepareIndexV2Block(t, tmpDir, bkt)
+	built, err := WriteBinary(ctx, bkt, m.ULID, "", dummyHistogram)
+	testutil.Ok(t, err)
+	name := path.Join(m.ULID.String(), block.IndexHeaderFilename)
+
+	for _, lazy := range []bool{false, true} {
+		metrics := NewReaderPoolMetrics(nil)
+		pool := NewReaderPool(log.NewNopLogger(), lazy, 0, metrics, AlwaysEagerDownloadIndexHeader)
+		pool.SetPrebuiltIndexHeaders(true)
+		newReader := func() {
+			dir := t.TempDir()
+			r, err := pool.NewBinaryReader(ctx, log.NewNopLogger(), bkt, dir, m.ULID, 32, m)
+			testutil.Ok(t, err)
+			_, err = r.LabelNames()
+			testutil.Ok(t, err)
+			testutil.Ok(t, r.Close())
+
+			b, err := os.ReadFile(filepath.Join(dir, m.ULID.String(), block.IndexHeaderFilename))
+			testutil.Ok(t, err)
+			testutil.Equals(t, built, b)
+		}
+
+		// Blocks without a pre-built index-header have it built.
+		newReader()
+		testutil.Equals(t, float64(1), promtestutil.ToFloat64(metrics.binaryReader.prebuiltDownloads.WithLabelValues(prebuiltResultMissing)))
+
+		testutil.Ok(t, bkt.Upload(ctx, name, bytes.NewReader(built)))
+		newReader()
+		testutil.Equals(t, float64(1), promtestutil.ToFloat64(metrics.binaryReader.prebuiltDownloads.WithLabelValues(prebuiltResultDownloaded)))
+
+		// Corrupted pre-built index-headers are not used.
+		corrupted := bytes.Clone(built)
+		corrupted[len(corrupted)/2] ^= 0xff
+		testutil.Ok(t, bkt.Upload(ctx, name, bytes.NewReader(corrupted)))
+		newReader()
+		testutil.Equals(t, float64(1), promtestutil.ToFloat64(metrics.binaryReader.prebuiltDownloads.WithLabelValues(prebuiltResultFailed)))
+
+		testutil.Ok(t, bkt.Delete(ctx, name))
+		pool.Close()
+	}
+}
@@ -55,6 +55,7 @@ type ReaderPool struct {
 	lazyReadersSF xsync.Group
 
 	lazyDownloadFunc LazyDownloadIndexHeaderFunc
+	prebuilt         bool
 }
 
 // IndexHeaderLazyDownloadStrategy specifies how to download index headers
@@ -123,17 +124,24 @@ func NewReaderPool(logger log.Logger, lazyReaderEnabled bool, lazyReaderIdleTime
 	return p
 }
 
+// SetPrebuiltIndexHeaders sets whether the readers download the index-headers built next to the blocks, e.g. by the
+// compactor, instead of building them from the index of the blocks. Blocks without a valid index-header next to them
+// still have their index-header built.
+func (p *ReaderPool) SetPrebuiltIndexHeaders(enabled bool) {
+	p.prebuilt = enabled
+}
+
 // NewBinaryReader creates and returns a new binary reader. If the pool has been configured
 // with lazy reader enabled, this function will return a lazy reader. The returned lazy reader
 // is tracked by the pool and automatically closed once the idle timeout expires.
 func (p *ReaderPool) NewBinaryReader(ctx context.Context, logger log.Logger, bkt objstore.BucketReader, dir string, id ulid.ULID, postingOffsetsInMemSampling int, meta *metadata.Meta) (Reader, error) {
 	if !p.lazyReaderEnabled {
-		return NewBinaryReader(ctx, logger, bkt, dir, id, postingOffsetsInMemSampling, p.metrics.binaryReader)
+		return newBinaryReader(ctx, logger, bkt, dir, id, postingOffsetsInMemSampling, p.metrics.binaryReader, true, p.prebuilt)
 	}
 
 	idBytes := id.Bytes()
 	lazyReader, err, _ := p.lazyReadersSF.Do(*(*string)(unsafe.Pointer(&idBytes)), func() (interface{}, error) {
-		return NewLazyBinaryReader(ctx, logger, bkt, dir, id, postingOffsetsInMemSampling, p.metrics.lazyReader, p.metrics.binaryReader, p.onLazyReaderClosed, p.lazyDownloadFunc(meta))
+		return newLazyBinaryReader(ctx, logger, bkt, dir, id, postingOffsetsInMemSampling, p.metrics.lazyReader, p.metrics.binaryReader, p.onLazyReaderClosed, p.lazyDownloadFunc(meta), p.prebuilt)
 	})
 
 	reader := lazyReader.(Reader)
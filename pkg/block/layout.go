@@ -0,0 +1,70 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// AttestationFilename is the name of the signed attestation of a block in its directory. It attests the ID and the
+// meta of the block, so that copies of the block with another ID or meta must not keep it.
+const AttestationFilename = "attestation.json"
+
+// The registry of the objects of block directories and of the other objects of the bucket. Every file written into
+// block directories, and every top level directory of markers, has to be registered here, as tools working on the
+// whole bucket, e.g. the cleanup of orphaned objects, consider unregistered objects unknown.
+var (
+	// blockFiles are the names of the files of a block, relative to its directory, apart from chunk segment files.
+	blockFiles = map[string]struct{}{
+		MetaFilename:           {},
+		IndexFilename:          {},
+		IndexHeaderFilename:    {},
+		LabelsBloomFilename:    {},
+		SeriesHashesFilename:   {},
+		ExemplarsFilename:      {},
+		MetricMetadataFilename: {},
+		AttestationFilename:    {},
+	}
+	// blockMarkers are the names of the JSON markers of a block, relative to its directory.
+	blockMarkers = map[string]struct{}{
+		metadata.DeletionMarkFilename:        {},
+		metadata.NoCompactMarkFilename:       {},
+		metadata.NoDownsampleMarkFilename:    {},
+		metadata.UploadCompletedMarkFilename: {},
+		metadata.StorageClassMarkFilename:    {},
+	}
+	// bucketDirs are the top level directories of the bucket, or of tenant directories, holding markers which are not
+	// specific to a block.
+	bucketDirs = map[string]struct{}{
+		metadata.CompactionDisabledMarksDir: {},
+	}
+)
+
+var segmentFileRegexp = regexp.MustCompile(`^\d{6}$`)
+
+// IsBlockFile returns true if the name, relative to a block directory, is a registered file or chunk segment file of
+// the block.
+func IsBlockFile(name string) bool {
+	if _, ok := blockFiles[name]; ok {
+		return true
+	}
+	dir, file := path.Split(name)
+	return strings.TrimSuffix(dir, "/") == ChunksDirname && segmentFileRegexp.MatchString(file)
+}
+
+// IsBlockMarker returns true if the name, relative to a block directory, is a registered marker of the block.
+func IsBlockMarker(name string) bool {
+	_, ok := blockMarkers[name]
+	return ok
+}
+
+// IsBucketDir returns true if the top level directory is a registered directory of markers.
+func IsBucketDir(dir string) bool {
+	_, ok := bucketDirs[dir]
+	return ok
+}
@@ -40,17 +40,25 @@ func (oh *ObjectHash) Equal(other *ObjectHash) bool {
 
 // CalculateHash calculates the hash of the given type.
 func CalculateHash(p string, hf HashFunc, logger log.Logger) (ObjectHash, error) {
+	if hf != SHA256Func {
+		return ObjectHash{}, fmt.Errorf("hash function %v is not supported", hf)
+	}
+	f, err := os.Open(filepath.Clean(p))
+	if err != nil {
+		return ObjectHash{}, errors.Wrap(err, "opening file")
+	}
+	defer runutil.CloseWithLogOnErr(logger, f, "closing %s", p)
+
+	return CalculateReaderHash(f, hf)
+}
+
+// CalculateReaderHash calculates the hash of the given type of the content of the reader, e.g. of an object.
+func CalculateReaderHash(r io.Reader, hf HashFunc) (ObjectHash, error) {
 	switch hf {
 	case SHA256Func:
-		f, err := os.Open(filepath.Clean(p))
-		if err != nil {
-			return ObjectHash{}, errors.Wrap(err, "opening file")
-		}
-		defer runutil.CloseWithLogOnErr(logger, f, "closing %s", p)
-
 		h := sha256.New()
 
-		if _, err := io.Copy(h, f); err != nil {
+		if _, err := io.Copy(h, r); err != nil {
 			return ObjectHash{}, errors.Wrap(err, "copying")
 		}
 
@@ -60,5 +68,4 @@ func CalculateHash(p string, hf HashFunc, logger log.Logger) (ObjectHash, error)
 		}, nil
 	}
 	return ObjectHash{}, fmt.Errorf("hash function %v is not supported", hf)
-
 }
@@ -27,12 +27,25 @@ const (
 	// NoDownsampleMarkFilename is the known json filenanme for optional file storing details about why block has to be excluded from downsampling.
 	// If such file is present in block dir, it means the block has to be excluded from downsampling.
 	NoDownsampleMarkFilename = "no-downsample-mark.json"
+	// UploadCompletedMarkFilename is the known json filename for optional file storing details about when the upload of block was completed.
+	// If such file is present in block dir, it means all the files of the block were uploaded and verified before.
+	UploadCompletedMarkFilename = "upload-completed-mark.json"
+	// StorageClassMarkFilename is the known json filename for optional file storing the storage class the files of block were moved to.
+	// If such file is present in block dir, it means the index and chunks of the block were uploaded again to that class.
+	StorageClassMarkFilename = "storage-class-mark.json"
+	// CompactionDisabledMarksDir is the known directory of the bucket storing the markers disabling the compaction of groups of blocks.
+	// If a marker in this directory matches the external labels of a group, the blocks of the group are not compacted.
+	CompactionDisabledMarksDir = "compaction-disabled"
 	// DeletionMarkVersion1 is the version of deletion-mark file supported by Thanos.
 	DeletionMarkVersion1 = 1
 	// NoCompactMarkVersion1 is the version of no-compact-mark file supported by Thanos.
 	NoCompactMarkVersion1 = 1
 	// NoDownsampleVersion1 is the version of no-downsample-mark file supported by Thanos.
 	NoDownsampleMarkVersion1 = 1
+	// UploadCompletedMarkVersion1 is the version of upload-completed-mark file supported by Thanos.
+	UploadCompletedMarkVersion1 = 1
+	// StorageClassMarkVersion1 is the version of storage-class-mark file supported by Thanos.
+	StorageClassMarkVersion1 = 1
 )
 
 var (
@@ -48,6 +61,16 @@ type Marker interface {
 	markerFilename() string
 }
 
+// MarkerWriter identifies the process that wrote a marker.
+type MarkerWriter struct {
+	// Identity is the hostname of the process.
+	Identity string `json:"identity"`
+	// RunID is unique for every run of the process.
+	RunID string `json:"run_id"`
+	// FencingToken increases with every run of the compactors sharing the bucket, if fencing is enabled. Zero otherwise.
+	FencingToken uint64 `json:"fencing_token,omitempty"`
+}
+
 // DeletionMark stores block id and when block was marked for deletion.
 type DeletionMark struct {
 	// ID of the tsdb block.
@@ -59,6 +82,8 @@ type DeletionMark struct {
 
 	// DeletionTime is a unix timestamp of when the block was marked to be deleted.
 	DeletionTime int64 `json:"deletion_time"`
+	// Writer is the process that marked the block, if known.
+	Writer *MarkerWriter `json:"writer,omitempty"`
 }
 
 func (m *DeletionMark) markerFilename() string { return DeletionMarkFilename }
@@ -81,6 +106,8 @@ const (
 	OutOfOrderChunksNoCompactReason = "block-index-out-of-order-chunk"
 	// DownsampleVerticalCompactionNoCompactReason is a reason to not compact overlapping downsampled blocks as it does not make sense e.g. how to vertically compact the average.
 	DownsampleVerticalCompactionNoCompactReason = "downsample-vertical-compaction"
+	// RelabeledNoCompactReason is a reason to not compact a block replaced by a copy with relabeled external labels, until it is deleted.
+	RelabeledNoCompactReason = "relabeled"
 )
 
 // NoCompactMark marker stores reason of block being excluded from compaction if needed.
@@ -95,10 +122,27 @@ type NoCompactMark struct {
 	// NoCompactTime is a unix timestamp of when the block was marked for no compact.
 	NoCompactTime int64           `json:"no_compact_time"`
 	Reason        NoCompactReason `json:"reason"`
+	// Writer is the process that marked the block, if known.
+	Writer *MarkerWriter `json:"writer,omitempty"`
+	// TimeRange is the time range of the compactions the block is excluded from. If not set, the block is excluded from
+	// all compactions.
+	TimeRange *NoCompactTimeRange `json:"time_range,omitempty"`
+}
+
+// NoCompactTimeRange is the time range of a no-compact mark, in milliseconds. MaxTime is exclusive.
+type NoCompactTimeRange struct {
+	MinTime int64 `json:"min_time"`
+	MaxTime int64 `json:"max_time"`
 }
 
 func (n *NoCompactMark) markerFilename() string { return NoCompactMarkFilename }
 
+// Excludes returns true if the block is excluded from compactions into a block of the given time range, in milliseconds
+// with exclusive maxt.
+func (n *NoCompactMark) Excludes(mint, maxt int64) bool {
+	return n.TimeRange == nil || (n.TimeRange.MinTime < maxt && mint < n.TimeRange.MaxTime)
+}
+
 // NoDownsampleMark marker stores reason of block being excluded from downsample if needed.
 type NoDownsampleMark struct {
 	// ID of the tsdb block.
@@ -115,6 +159,34 @@ type NoDownsampleMark struct {
 
 func (n *NoDownsampleMark) markerFilename() string { return NoDownsampleMarkFilename }
 
+// UploadCompletedMark marker stores when the upload of block was completed.
+type UploadCompletedMark struct {
+	// ID of the tsdb block.
+	ID ulid.ULID `json:"id"`
+	// Version of the file.
+	Version int `json:"version"`
+
+	// UploadCompletedTime is a unix timestamp of when the upload of the block was completed.
+	UploadCompletedTime int64 `json:"upload_completed_time"`
+}
+
+func (u *UploadCompletedMark) markerFilename() string { return UploadCompletedMarkFilename }
+
+// StorageClassMark marker stores the storage class the files of block were moved to.
+type StorageClassMark struct {
+	// ID of the tsdb block.
+	ID ulid.ULID `json:"id"`
+	// Version of the file.
+	Version int `json:"version"`
+
+	// StorageClass is the name of the storage class of the compactor configuration.
+	StorageClass string `json:"storage_class"`
+	// MoveTime is a unix timestamp of when the files of the block were moved to the storage class.
+	MoveTime int64 `json:"move_time"`
+}
+
+func (s *StorageClassMark) markerFilename() string { return StorageClassMarkFilename }
+
 // ReadMarker reads the given mark file from <dir>/<marker filename>.json in bucket.
 func ReadMarker(ctx context.Context, logger log.Logger, bkt objstore.InstrumentedBucketReader, dir string, marker Marker) error {
 	markerFile := path.Join(dir, marker.markerFilename())
@@ -148,6 +220,14 @@ func ReadMarker(ctx context.Context, logger log.Logger, bkt objstore.Instrumente
 		if version := marker.(*DeletionMark).Version; version != DeletionMarkVersion1 {
 			return errors.Errorf("unexpected deletion-mark file version %d, expected %d", version, DeletionMarkVersion1)
 		}
+	case UploadCompletedMarkFilename:
+		if version := marker.(*UploadCompletedMark).Version; version != UploadCompletedMarkVersion1 {
+			return errors.Errorf("unexpected upload-completed-mark file version %d, expected %d", version, UploadCompletedMarkVersion1)
+		}
+	case StorageClassMarkFilename:
+		if version := marker.(*StorageClassMark).Version; version != StorageClassMarkVersion1 {
+			return errors.Errorf("unexpected storage-class-mark file version %d, expected %d", version, StorageClassMarkVersion1)
+		}
 	}
 	return nil
 }
@@ -110,3 +110,17 @@ func TestReadMarker(t *testing.T) {
 		testutil.Equals(t, *expected, n)
 	})
 }
+
+func TestNoCompactMark_Excludes(t *testing.T) {
+	unscoped := &NoCompactMark{}
+	testutil.Assert(t, unscoped.Excludes(0, 10))
+	testutil.Assert(t, unscoped.Excludes(100, 200))
+
+	scoped := &NoCompactMark{TimeRange: &NoCompactTimeRange{MinTime: 10, MaxTime: 20}}
+	testutil.Assert(t, !scoped.Excludes(0, 10))
+	testutil.Assert(t, scoped.Excludes(0, 11))
+	testutil.Assert(t, scoped.Excludes(12, 15))
+	testutil.Assert(t, scoped.Excludes(0, 100))
+	testutil.Assert(t, scoped.Excludes(19, 100))
+	testutil.Assert(t, !scoped.Excludes(20, 100))
+}
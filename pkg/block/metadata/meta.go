@@ -9,11 +9,14 @@ package metadata
 // this package.
 
 import (
+	"cmp"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 
 	"github.com/go-kit/log"
 	"github.com/oklog/ulid/v2"
@@ -43,6 +46,9 @@ const (
 	BucketRepairSource    SourceType = "bucket.repair"
 	BucketRewriteSource   SourceType = "bucket.rewrite"
 	BucketUploadSource    SourceType = "bucket.upload"
+	BucketImportSource    SourceType = "bucket.import"
+	BucketGenerateSource  SourceType = "bucket.generate"
+	BucketRelabelSource   SourceType = "bucket.relabel"
 	TestSource            SourceType = "test"
 )
 
@@ -96,13 +102,204 @@ type Thanos struct {
 	// IndexStats contains stats info related to block index.
 	IndexStats IndexStats `json:"index_stats,omitempty"`
 
+	// Encryption is present when the files of the block were encrypted on the client side. Optional.
+	Encryption *Encryption `json:"encryption,omitempty"`
+
+	// Shard is present when the block holds only the series of its sources in a shard of their label hashes, e.g.
+	// because compacting them into a single block would exceed the maximum index size. Optional, added in v0.40.0.
+	Shard *Shard `json:"shard,omitempty"`
+
+	// Provenance is the sorted list of the systems which produced the data of this block. Compacted blocks have the
+	// provenance of all the blocks they were compacted from. Optional, added in v0.40.0.
+	Provenance []Provenance `json:"provenance,omitempty"`
+
+	// RoutingDomain is the routing domain the write path routed the data of the block to, e.g. for the data residency
+	// of its tenant. Blocks of different routing domains are never compacted together. Optional, added in v0.40.0.
+	RoutingDomain string `json:"routing_domain,omitempty"`
+
+	// Annotations are arbitrary static key-value pairs describing the block, e.g. its environment or team, injected
+	// by the shipper at upload time. Compacted blocks keep the annotations their sources agree on. Unlike labels,
+	// annotations do not identify the series of the block. Optional, added in v0.40.0.
+	Annotations map[string]string `json:"annotations,omitempty"`
+
 	// Extensions are used for plugin any arbitrary additional information for block. Optional.
 	Extensions any `json:"extensions,omitempty"`
 }
 
+// Provenance identifies a system which produced data, i.e. uploaded a block.
+type Provenance struct {
+	// Component is the component which uploaded the block, e.g. sidecar, receive or ruler.
+	Component SourceType `json:"component"`
+	// Version is the Thanos version of the component.
+	Version string `json:"version,omitempty"`
+	// Cluster is the cluster the component runs in, if configured.
+	Cluster string `json:"cluster,omitempty"`
+}
+
+func compareProvenance(a, b Provenance) int {
+	return cmp.Or(cmp.Compare(a.Component, b.Component), cmp.Compare(a.Cluster, b.Cluster), cmp.Compare(a.Version, b.Version))
+}
+
+// MergeProvenance returns the provenance of a block compacted from the given blocks, i.e. the sorted union of their
+// provenance. Blocks uploaded before the provenance was recorded contribute their source, unless they were produced by
+// a compaction or repair, as the systems which contributed to them are unknown then.
+func MergeProvenance(metas []*Meta) []Provenance {
+	var res []Provenance
+	for _, m := range metas {
+		if len(m.Thanos.Provenance) > 0 {
+			res = append(res, m.Thanos.Provenance...)
+			continue
+		}
+		switch m.Thanos.Source {
+		case UnknownSource, CompactorSource, CompactorRepairSource, BucketRepairSource, BucketRewriteSource, BucketRelabelSource:
+		default:
+			res = append(res, Provenance{Component: m.Thanos.Source})
+		}
+	}
+	slices.SortFunc(res, compareProvenance)
+	return slices.Compact(res)
+}
+
+// MergeAnnotations returns the annotations of a block compacted from the given blocks: the annotations of the blocks
+// with the same value in all the blocks having them. Annotations with differing values are dropped.
+func MergeAnnotations(metas []*Meta) map[string]string {
+	var (
+		res        map[string]string
+		conflicted map[string]struct{}
+	)
+	for _, m := range metas {
+		for k, v := range m.Thanos.Annotations {
+			if _, ok := conflicted[k]; ok {
+				continue
+			}
+			prev, ok := res[k]
+			if !ok {
+				if res == nil {
+					res = map[string]string{}
+				}
+				res[k] = v
+				continue
+			}
+			if prev != v {
+				if conflicted == nil {
+					conflicted = map[string]struct{}{}
+				}
+				conflicted[k] = struct{}{}
+				delete(res, k)
+			}
+		}
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
+
+// MergeExtensions returns the extensions of a block compacted from the given blocks. If the extensions of all the
+// blocks having them are JSON objects, it returns the keys with the same value in all the objects having them, like
+// MergeAnnotations. Otherwise, it returns the extensions if they are the same in all the blocks, or nil.
+func MergeExtensions(metas []*Meta) (any, error) {
+	var (
+		exts    []any
+		objects = true
+	)
+	for _, m := range metas {
+		if m.Thanos.Extensions == nil {
+			continue
+		}
+		// Round-trip through JSON, so that extensions read from meta files and set by plugins compare equal.
+		var ext any
+		if _, err := ConvertExtensions(m.Thanos.Extensions, &ext); err != nil {
+			return nil, errors.Wrapf(err, "convert extensions of block %s", m.ULID)
+		}
+		_, ok := ext.(map[string]any)
+		objects = objects && ok
+		exts = append(exts, ext)
+	}
+	if len(exts) == 0 {
+		return nil, nil
+	}
+
+	if !objects {
+		for _, ext := range exts[1:] {
+			if !reflect.DeepEqual(exts[0], ext) {
+				return nil, nil
+			}
+		}
+		return exts[0], nil
+	}
+	res := map[string]any{}
+	conflicted := map[string]struct{}{}
+	for _, ext := range exts {
+		for k, v := range ext.(map[string]any) {
+			if _, ok := conflicted[k]; ok {
+				continue
+			}
+			prev, ok := res[k]
+			if !ok {
+				res[k] = v
+				continue
+			}
+			if !reflect.DeepEqual(prev, v) {
+				conflicted[k] = struct{}{}
+				delete(res, k)
+			}
+		}
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	return res, nil
+}
+
+// Encryption describes the client side encryption of the files of a block.
+type Encryption struct {
+	// KeyID is the ID of the key encrypting the data keys of the files when the block was uploaded.
+	KeyID string `json:"key_id"`
+}
+
+// Shard identifies the series whose label hash modulo Count is Index.
+type Shard struct {
+	Index uint64 `json:"index"`
+	Count uint64 `json:"count"`
+}
+
+func (s Shard) String() string {
+	return fmt.Sprintf("%d_of_%d", s.Index, s.Count)
+}
+
+// Contains returns true if all the series of the other shard are in the shard.
+func (s Shard) Contains(o Shard) bool {
+	return o.Count%s.Count == 0 && o.Index%s.Count == s.Index
+}
+
+// Split returns the n shards the series of the shard are split into.
+func (s Shard) Split(n uint64) []Shard {
+	shards := make([]Shard, 0, n)
+	for i := uint64(0); i < n; i++ {
+		shards = append(shards, Shard{Index: s.Index + i*s.Count, Count: s.Count * n})
+	}
+	return shards
+}
+
 type IndexStats struct {
 	SeriesMaxSize int64 `json:"series_max_size,omitempty"`
 	ChunkMaxSize  int64 `json:"chunk_max_size,omitempty"`
+
+	// Optional, added in v0.40.0. Sizes are estimated from the offsets of the series and chunks in the index.
+	SeriesCount     int64 `json:"series_count,omitempty"`
+	ChunkCount      int64 `json:"chunk_count,omitempty"`
+	LabelNamesCount int64 `json:"label_names_count,omitempty"`
+	SeriesTotalSize int64 `json:"series_total_size,omitempty"`
+	ChunkTotalSize  int64 `json:"chunk_total_size,omitempty"`
+}
+
+// SeriesAvgSize returns the average size of the series of the block in the index, or 0 if it is unknown.
+func (s IndexStats) SeriesAvgSize() int64 {
+	if s.SeriesCount == 0 {
+		return 0
+	}
+	return s.SeriesTotalSize / s.SeriesCount
 }
 
 func (m *Thanos) ParseExtensions(v any) (any, error) {
@@ -132,6 +329,8 @@ type Rewrite struct {
 	DeletionsApplied []DeletionRequest `json:"deletions_applied,omitempty"`
 	// Relabels if applied.
 	RelabelsApplied []*relabel.Config `json:"relabels_applied,omitempty"`
+	// Labels are the external labels of the block before they were relabeled, if relabeled.
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 type Matchers []*labels.Matcher
@@ -185,11 +384,30 @@ func InjectThanos(logger log.Logger, bdir string, meta Thanos, downsampledMeta *
 }
 
 // GroupKey returns a unique identifier for the compaction group the block belongs to.
-// It considers the downsampling resolution and the block's labels.
+// It considers the downsampling resolution, the block's labels, its routing domain and its shard.
 func (m *Thanos) GroupKey() string {
+	if m.Shard != nil {
+		return fmt.Sprintf("%s@%s", m.UnshardedGroupKey(), m.Shard)
+	}
+	return m.UnshardedGroupKey()
+}
+
+// UnshardedGroupKey returns the key of the compaction group of the block, regardless of its shard.
+func (m *Thanos) UnshardedGroupKey() string {
+	if m.RoutingDomain != "" {
+		return fmt.Sprintf("%d@%v@%s", m.Downsample.Resolution, labels.FromMap(m.Labels).Hash(), m.RoutingDomain)
+	}
 	return fmt.Sprintf("%d@%v", m.Downsample.Resolution, labels.FromMap(m.Labels).Hash())
 }
 
+// ShardOrAll returns the shard of the block, or the shard of all series if the block is not sharded.
+func (m *Thanos) ShardOrAll() Shard {
+	if m.Shard != nil {
+		return *m.Shard
+	}
+	return Shard{Index: 0, Count: 1}
+}
+
 // ResolutionString returns a the block's resolution as a string.
 func (m *Thanos) ResolutionString() string {
 	return fmt.Sprintf("%d", m.Downsample.Resolution)
@@ -11,6 +11,7 @@ import (
 	"github.com/efficientgo/core/testutil"
 	"github.com/oklog/ulid/v2"
 
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/tsdb"
 )
 
@@ -346,3 +347,103 @@ type TestExtensions struct {
 	Field1 int    `json:"field1"`
 	Field2 string `json:"field2"`
 }
+
+func TestShard(t *testing.T) {
+	t.Parallel()
+
+	m := Thanos{Labels: map[string]string{"a": "1"}}
+	all := m.ShardOrAll()
+	testutil.Equals(t, Shard{Index: 0, Count: 1}, all)
+
+	shards := all.Split(2)
+	testutil.Equals(t, []Shard{{Index: 0, Count: 2}, {Index: 1, Count: 2}}, shards)
+	testutil.Equals(t, []Shard{{Index: 1, Count: 6}, {Index: 3, Count: 6}, {Index: 5, Count: 6}}, shards[1].Split(3))
+
+	testutil.Assert(t, all.Contains(shards[1]))
+	testutil.Assert(t, shards[1].Contains(Shard{Index: 3, Count: 6}))
+	testutil.Assert(t, !shards[1].Contains(Shard{Index: 2, Count: 6}))
+	testutil.Assert(t, !shards[1].Contains(Shard{Index: 1, Count: 3}))
+	testutil.Assert(t, !shards[1].Contains(all))
+
+	unsharded := m.GroupKey()
+	m.Shard = &shards[1]
+	testutil.Equals(t, unsharded+"@1_of_2", m.GroupKey())
+	testutil.Equals(t, unsharded, m.UnshardedGroupKey())
+}
+
+func TestMergeProvenance(t *testing.T) {
+	t.Parallel()
+
+	sidecar := Provenance{Component: SidecarSource, Version: "0.40.0", Cluster: "eu-1"}
+	receive := Provenance{Component: ReceiveSource, Version: "0.40.0", Cluster: "us-1"}
+	metas := []*Meta{
+		{Thanos: Thanos{Source: SidecarSource, Provenance: []Provenance{sidecar}}},
+		{Thanos: Thanos{Source: CompactorSource, Provenance: []Provenance{receive, sidecar}}},
+		// Blocks without provenance contribute their source, if it is known.
+		{Thanos: Thanos{Source: RulerSource}},
+		{Thanos: Thanos{Source: CompactorSource}},
+	}
+	testutil.Equals(t, []Provenance{{Component: ReceiveSource, Version: "0.40.0", Cluster: "us-1"}, {Component: RulerSource}, sidecar}, MergeProvenance(metas))
+	testutil.Equals(t, 0, len(MergeProvenance(metas[3:])))
+}
+
+func TestMergeAnnotations(t *testing.T) {
+	t.Parallel()
+
+	metas := []*Meta{
+		{Thanos: Thanos{Annotations: map[string]string{"team": "a", "environment": "prod"}}},
+		{Thanos: Thanos{Annotations: map[string]string{"team": "b", "environment": "prod", "owner": "c"}}},
+		{Thanos: Thanos{}},
+	}
+	testutil.Equals(t, map[string]string{"environment": "prod", "owner": "c"}, MergeAnnotations(metas))
+	testutil.Equals(t, 0, len(MergeAnnotations(metas[2:])))
+	// A key conflicting once is dropped, even if it agrees with other blocks.
+	testutil.Equals(t, map[string]string{"environment": "prod", "owner": "c"}, MergeAnnotations(append(metas, metas[0])))
+}
+
+func TestMergeExtensions(t *testing.T) {
+	t.Parallel()
+
+	type ext struct {
+		Version string `json:"version"`
+		Team    string `json:"team"`
+	}
+	metas := []*Meta{
+		{Thanos: Thanos{Extensions: ext{Version: "v1", Team: "a"}}},
+		{Thanos: Thanos{Extensions: map[string]any{"version": "v1", "team": "b", "tier": "hot"}}},
+		{Thanos: Thanos{}},
+	}
+	res, err := MergeExtensions(metas)
+	testutil.Ok(t, err)
+	testutil.Equals(t, map[string]any{"version": "v1", "tier": "hot"}, res)
+
+	res, err = MergeExtensions(metas[2:])
+	testutil.Ok(t, err)
+	testutil.Equals(t, nil, res)
+
+	// Extensions that are not JSON objects are kept only if they are the same.
+	res, err = MergeExtensions([]*Meta{{Thanos: Thanos{Extensions: []any{"a"}}}, {Thanos: Thanos{Extensions: []any{"a"}}}})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []any{"a"}, res)
+
+	res, err = MergeExtensions([]*Meta{{Thanos: Thanos{Extensions: []any{"a"}}}, {Thanos: Thanos{Extensions: map[string]any{"a": "b"}}}})
+	testutil.Ok(t, err)
+	testutil.Equals(t, nil, res)
+}
+
+func TestMeta_UTF8Labels(t *testing.T) {
+	t.Parallel()
+
+	m := Meta{BlockMeta: tsdb.BlockMeta{Version: TSDBVersion1}, Thanos: Thanos{Version: ThanosVersion1, Labels: map[string]string{"service.name": "api", "a=b": "1"}}}
+	b := bytes.Buffer{}
+	testutil.Ok(t, m.Write(&b))
+	read, err := Read(io.NopCloser(&b))
+	testutil.Ok(t, err)
+	testutil.Equals(t, m.Thanos.Labels, read.Thanos.Labels)
+	testutil.Equals(t, m.Thanos.GroupKey(), read.Thanos.GroupKey())
+	testutil.Equals(t, `{"a=b"="1", "service.name"="api"}`, labels.FromMap(read.Thanos.Labels).String())
+
+	// The escaped names are different labels, in a different group.
+	escaped := Thanos{Labels: map[string]string{"service_name": "api", "a_b": "1"}}
+	testutil.Assert(t, m.Thanos.GroupKey() != escaped.GroupKey())
+}
@@ -0,0 +1,83 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/pkg/errors"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/metadata/metadatapb"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// MetricMetadataFilename is the known JSON file of a block holding the metadata of its metrics, if any.
+const MetricMetadataFilename = "metric_metadata.json"
+
+// HasMetricMetadata returns true if the files of the block listed by its meta include a metric metadata file.
+func HasMetricMetadata(meta *metadata.Meta) bool {
+	return hasFile(meta, MetricMetadataFilename)
+}
+
+// WriteMetricMetadataFile writes the metadata of the metrics into the metric metadata file of the block in dir. No file
+// is written without metadata.
+func WriteMetricMetadataFile(dir string, md map[string][]metadatapb.Meta) error {
+	if len(md) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(md)
+	if err != nil {
+		return errors.Wrap(err, "encode metric metadata")
+	}
+	tmp := filepath.Join(dir, MetricMetadataFilename+".tmp")
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "write metric metadata file")
+	}
+	return os.Rename(tmp, filepath.Join(dir, MetricMetadataFilename))
+}
+
+// ReadMetricMetadataFile reads the metadata of the metrics of a metric metadata file.
+func ReadMetricMetadataFile(r io.Reader) (map[string][]metadatapb.Meta, error) {
+	var md map[string][]metadatapb.Meta
+	if err := json.NewDecoder(r).Decode(&md); err != nil {
+		return nil, errors.Wrap(err, "decode metric metadata")
+	}
+	return md, nil
+}
+
+// ReadMetricMetadataFromDir reads the metric metadata file of the block in dir, and returns no metadata if the block
+// has none.
+func ReadMetricMetadataFromDir(dir string) (_ map[string][]metadatapb.Meta, err error) {
+	f, err := os.Open(filepath.Join(dir, MetricMetadataFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "open metric metadata file")
+	}
+	defer runutil.CloseWithErrCapture(&err, f, "close metric metadata file")
+
+	return ReadMetricMetadataFile(f)
+}
+
+// MergeMetricMetadata merges the metadata of the same metrics, dropping duplicates. Metrics can have different
+// metadata, e.g. if their HELP changed over time, in which case all of them are kept in the order they are merged.
+func MergeMetricMetadata(mds ...map[string][]metadatapb.Meta) map[string][]metadatapb.Meta {
+	res := map[string][]metadatapb.Meta{}
+	for _, md := range mds {
+		for metric, metas := range md {
+			for _, m := range metas {
+				if !slices.Contains(res[metric], m) {
+					res[metric] = append(res[metric], m)
+				}
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,57 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+
+	"github.com/thanos-io/thanos/pkg/metadata/metadatapb"
+)
+
+func TestMetricMetadataFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	md, err := ReadMetricMetadataFromDir(dir)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(md))
+
+	// No file is written without metadata.
+	testutil.Ok(t, WriteMetricMetadataFile(dir, nil))
+	_, err = os.Stat(filepath.Join(dir, MetricMetadataFilename))
+	testutil.Assert(t, os.IsNotExist(err))
+
+	expected := map[string][]metadatapb.Meta{
+		"up":                  {{Type: "gauge", Help: "Up."}},
+		"http_requests_total": {{Type: "counter", Help: "Requests.", Unit: "requests"}},
+	}
+	testutil.Ok(t, WriteMetricMetadataFile(dir, expected))
+	md, err = ReadMetricMetadataFromDir(dir)
+	testutil.Ok(t, err)
+	testutil.Equals(t, expected, md)
+}
+
+func TestMergeMetricMetadata(t *testing.T) {
+	t.Parallel()
+
+	testutil.Equals(t, map[string][]metadatapb.Meta{
+		"up":                  {{Type: "gauge", Help: "Up."}, {Type: "gauge", Help: "Target up."}},
+		"http_requests_total": {{Type: "counter", Help: "Requests."}},
+	}, MergeMetricMetadata(
+		map[string][]metadatapb.Meta{
+			"up":                  {{Type: "gauge", Help: "Up."}},
+			"http_requests_total": {{Type: "counter", Help: "Requests."}},
+		},
+		nil,
+		map[string][]metadatapb.Meta{
+			// Duplicates, e.g. of replicas, are dropped.
+			"up":                  {{Type: "gauge", Help: "Up."}, {Type: "gauge", Help: "Target up."}},
+			"http_requests_total": {{Type: "counter", Help: "Requests."}},
+		},
+	))
+}
@@ -0,0 +1,185 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+const (
+	// OrphanOutsideBlock is the reason of objects at the top level of the bucket that are not in a block directory.
+	OrphanOutsideBlock = "outside of block directories"
+	// OrphanUnknownDirectory is the reason of objects in top level directories of the bucket that are neither block
+	// directories nor known to Thanos, e.g. written by other systems or in another bucket layout.
+	OrphanUnknownDirectory = "in unknown directory"
+	// OrphanOutsideTenantBlock is the reason of objects of a tenant directory that are not in a block directory of the
+	// tenant. They are reported only, as tenant directories hold other objects, e.g. bucket indexes of Cortex and Mimir.
+	OrphanOutsideTenantBlock = "outside of block directories of tenant"
+	// OrphanUnknownBlockFile is the reason of objects in a block directory that are not part of the block layout.
+	// They are reported only, as they may be written by newer versions.
+	OrphanUnknownBlockFile = "unknown file in block directory"
+	// OrphanInvalidMarker is the reason of markers that are not valid JSON, e.g. because their upload was interrupted.
+	OrphanInvalidMarker = "invalid marker"
+	// OrphanDebugFile is the reason of debug files which are not written anymore.
+	OrphanDebugFile = "debug file"
+)
+
+// OrphanedObject is an object of the bucket that does not belong to the layout of any block.
+type OrphanedObject struct {
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	Reason       string    `json:"reason"`
+}
+
+// Deletable returns true if the object can be deleted as orphaned. Unknown files of block directories are reported
+// only, as the block may be written by a newer version, and so are the other objects of tenant directories.
+func (o OrphanedObject) Deletable() bool {
+	return o.Reason != OrphanUnknownBlockFile && o.Reason != OrphanOutsideTenantBlock
+}
+
+// FindOrphanedObjects walks the whole bucket and returns, sorted by name, the objects that are neither files
+// of a block nor markers. The directories of the given tenants are walked as buckets of their own, as in the
+// <tenant>/<block> layout of Cortex and Mimir. Objects under one of the ignored prefixes, e.g. written by other
+// systems sharing the bucket, are not reported. Blocks missing files are not reported either, they are handled as
+// partial uploads.
+func FindOrphanedObjects(ctx context.Context, bkt objstore.BucketReader, tenants []string, ignoredPrefixes ...string) ([]OrphanedObject, error) {
+	var res []OrphanedObject
+	if err := bkt.Iter(ctx, "", func(name string) error {
+		for _, p := range ignoredPrefixes {
+			if strings.HasPrefix(name, p) {
+				return nil
+			}
+		}
+
+		reason, err := tenantOrphanReason(ctx, bkt, name, tenants)
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			return nil
+		}
+		attrs, err := bkt.Attributes(ctx, name)
+		if err != nil {
+			if bkt.IsObjNotFoundErr(err) {
+				// Deleted while iterating.
+				return nil
+			}
+			return errors.Wrapf(err, "get attributes of %s", name)
+		}
+		res = append(res, OrphanedObject{Name: name, Size: attrs.Size, LastModified: attrs.LastModified, Reason: reason})
+		return nil
+	}, objstore.WithRecursiveIter()); err != nil {
+		return nil, errors.Wrap(err, "iter bucket")
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// tenantOrphanReason returns why the object is orphaned, relative to the directory of its tenant if any, or an empty
+// string if it is part of a block.
+func tenantOrphanReason(ctx context.Context, bkt objstore.BucketReader, name string, tenants []string) (string, error) {
+	for _, t := range tenants {
+		rel, ok := strings.CutPrefix(name, t+"/")
+		if !ok {
+			continue
+		}
+		reason, err := orphanReason(ctx, bkt, name, rel)
+		if reason == OrphanOutsideBlock || reason == OrphanUnknownDirectory {
+			reason = OrphanOutsideTenantBlock
+		}
+		return reason, err
+	}
+	return orphanReason(ctx, bkt, name, name)
+}
+
+// orphanReason returns why the object is orphaned, or an empty string if it is part of a block. The name relative to
+// the directory of the blocks of the object is used to classify it.
+func orphanReason(ctx context.Context, bkt objstore.BucketReader, name, rel string) (string, error) {
+	dir, file, ok := strings.Cut(rel, "/")
+	if !ok {
+		return OrphanOutsideBlock, nil
+	}
+	if _, err := ulid.Parse(dir); err != nil {
+		if IsBucketDir(dir) {
+			return "", nil
+		}
+		if strings.HasPrefix(rel, DebugMetas+"/") {
+			return OrphanDebugFile, nil
+		}
+		return OrphanUnknownDirectory, nil
+	}
+
+	switch {
+	case IsBlockFile(file):
+		return "", nil
+	case IsBlockMarker(file):
+		ok, err := validJSON(ctx, bkt, name)
+		if err != nil || ok {
+			return "", err
+		}
+		return OrphanInvalidMarker, nil
+	}
+	return OrphanUnknownBlockFile, nil
+}
+
+func validJSON(ctx context.Context, bkt objstore.BucketReader, name string) (_ bool, err error) {
+	r, err := bkt.Get(ctx, name)
+	if err != nil {
+		if bkt.IsObjNotFoundErr(err) {
+			return true, nil
+		}
+		return false, errors.Wrapf(err, "get %s", name)
+	}
+	defer runutil.CloseWithErrCapture(&err, r, "close marker reader")
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return false, errors.Wrapf(err, "read %s", name)
+	}
+	return json.Valid(b), nil
+}
+
+// DeleteOrphanedObjects deletes the deletable orphaned objects not modified for the given delay, so that objects being
+// written, e.g. by a newer version, are not deleted. It refuses to delete anything if objects are in unknown
+// directories, as the bucket is then shared or not in the layout of Thanos. It returns the number of deleted objects.
+func DeleteOrphanedObjects(ctx context.Context, logger log.Logger, bkt objstore.Bucket, objs []OrphanedObject, delay time.Duration) (int, error) {
+	for _, o := range objs {
+		if o.Reason == OrphanUnknownDirectory {
+			return 0, errors.Errorf("refusing to delete orphaned objects, as the bucket has directories which are neither blocks nor known to Thanos, e.g. of %s: ignore them with an ignored prefix if they are not part of blocks", o.Name)
+		}
+	}
+
+	deleted := 0
+	for _, o := range objs {
+		if !o.Deletable() {
+			level.Info(logger).Log("msg", "not deleting orphaned object which may be part of a block", "object", o.Name, "reason", o.Reason)
+			continue
+		}
+		if time.Since(o.LastModified) < delay {
+			level.Debug(logger).Log("msg", "skipping recently modified orphaned object", "object", o.Name, "last_modified", o.LastModified)
+			continue
+		}
+		if err := bkt.Delete(ctx, o.Name); err != nil && !bkt.IsObjNotFoundErr(err) {
+			return deleted, errors.Wrapf(err, "delete %s", o.Name)
+		}
+		level.Info(logger).Log("msg", "deleted orphaned object", "object", o.Name, "size", o.Size, "reason", o.Reason)
+		deleted++
+	}
+	return deleted, nil
+}
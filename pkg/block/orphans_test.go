@@ -0,0 +1,135 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+func TestFindOrphanedObjects(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	id := ulid.MustNew(1, nil).String()
+	for name, content := range map[string]string{
+		path.Join(id, MetaFilename):                                   "{}",
+		path.Join(id, IndexFilename):                                  "index",
+		path.Join(id, LabelsBloomFilename):                            "bloom",
+		path.Join(id, IndexHeaderFilename):                            "header",
+		path.Join(id, metadata.UploadCompletedMarkFilename):           "{}",
+		path.Join(id, ExemplarsFilename):                              "{}",
+		path.Join(id, MetricMetadataFilename):                         "{}",
+		path.Join(id, metadata.StorageClassMarkFilename):              "{}",
+		path.Join(id, SeriesHashesFilename):                           "hashes",
+		path.Join(id, AttestationFilename):                            "{}",
+		path.Join(metadata.CompactionDisabledMarksDir, "tenant.json"): "{}",
+		path.Join(id, ChunksDirname, "000001"):                        "chunks",
+		path.Join(id, metadata.DeletionMarkFilename):                  "{}",
+		path.Join(id, metadata.NoCompactMarkFilename):                 `{"id":`,
+		path.Join(id, "index.cache.json"):                             "{}",
+		path.Join(id, ChunksDirname, "000001.tmp"):                    "chunks",
+		path.Join(DebugMetas, id+".json"):                             "{}",
+		"stray.txt":                                                   "stray",
+		"other-system/data":                                           "data",
+	} {
+		testutil.Ok(t, bkt.Upload(ctx, name, strings.NewReader(content)))
+	}
+
+	objs, err := FindOrphanedObjects(ctx, bkt, nil, "other-system/")
+	testutil.Ok(t, err)
+
+	got := map[string]string{}
+	for _, o := range objs {
+		got[o.Name] = o.Reason
+		testutil.Assert(t, o.Size > 0)
+	}
+	testutil.Equals(t, map[string]string{
+		path.Join(id, metadata.NoCompactMarkFilename): OrphanInvalidMarker,
+		path.Join(id, "index.cache.json"):             OrphanUnknownBlockFile,
+		path.Join(id, ChunksDirname, "000001.tmp"):    OrphanUnknownBlockFile,
+		path.Join(DebugMetas, id+".json"):             OrphanDebugFile,
+		"stray.txt":                                   OrphanOutsideBlock,
+	}, got)
+
+	// Nothing was modified long enough ago.
+	deleted, err := DeleteOrphanedObjects(ctx, log.NewNopLogger(), bkt, objs, time.Hour)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, deleted)
+
+	// Unknown files of block directories are reported only.
+	deleted, err = DeleteOrphanedObjects(ctx, log.NewNopLogger(), bkt, objs, 0)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, deleted)
+
+	objs, err = FindOrphanedObjects(ctx, bkt, nil, "other-system/")
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(objs))
+	for _, o := range objs {
+		testutil.Equals(t, OrphanUnknownBlockFile, o.Reason)
+	}
+	testutil.Equals(t, 16, len(bkt.Objects()))
+
+	// Nothing is deleted from buckets with unknown directories, which may be in another layout.
+	testutil.Ok(t, bkt.Upload(ctx, "other-system-2/data", strings.NewReader("data")))
+	testutil.Ok(t, bkt.Upload(ctx, "stray.txt", strings.NewReader("stray")))
+	objs, err = FindOrphanedObjects(ctx, bkt, nil, "other-system/")
+	testutil.Ok(t, err)
+	testutil.Equals(t, 4, len(objs))
+	_, err = DeleteOrphanedObjects(ctx, log.NewNopLogger(), bkt, objs, 0)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 18, len(bkt.Objects()))
+}
+
+func TestFindOrphanedObjects_Tenants(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	id := ulid.MustNew(1, nil).String()
+	for _, name := range []string{
+		path.Join(id, MetaFilename),
+		path.Join("tenant-1", id, MetaFilename),
+		path.Join("tenant-1", id, IndexFilename),
+		path.Join("tenant-1", id, ChunksDirname, "000001"),
+		path.Join("tenant-1", id, "index.cache.json"),
+		path.Join("tenant-1", "bucket-index.json.gz"),
+		path.Join("tenant-1", "markers", id+"-deletion-mark.json"),
+		path.Join("tenant-1", DebugMetas, id+".json"),
+		path.Join("tenant-1", metadata.CompactionDisabledMarksDir, "all.json"),
+	} {
+		testutil.Ok(t, bkt.Upload(ctx, name, strings.NewReader("{}")))
+	}
+
+	objs, err := FindOrphanedObjects(ctx, bkt, []string{"tenant-1"})
+	testutil.Ok(t, err)
+
+	got := map[string]string{}
+	for _, o := range objs {
+		got[o.Name] = o.Reason
+	}
+	testutil.Equals(t, map[string]string{
+		path.Join("tenant-1", id, "index.cache.json"):              OrphanUnknownBlockFile,
+		path.Join("tenant-1", "bucket-index.json.gz"):              OrphanOutsideTenantBlock,
+		path.Join("tenant-1", "markers", id+"-deletion-mark.json"): OrphanOutsideTenantBlock,
+		path.Join("tenant-1", DebugMetas, id+".json"):              OrphanDebugFile,
+	}, got)
+
+	// Only the debug file is deleted, the blocks and other objects of tenants are kept.
+	deleted, err := DeleteOrphanedObjects(ctx, log.NewNopLogger(), bkt, objs, 0)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, deleted)
+	testutil.Equals(t, 8, len(bkt.Objects()))
+}
@@ -0,0 +1,131 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
+)
+
+// RelabeledExternalLabels returns the external labels of the block relabeled by the relabel configs, and false if
+// the block keeps its labels, as the configs drop it or do not change its labels.
+func RelabeledExternalLabels(meta *metadata.Meta, relabelConfigs []*relabel.Config) (labels.Labels, bool) {
+	lset := labels.FromMap(meta.Thanos.Labels)
+	relabeled, keep := relabel.Process(lset, relabelConfigs...)
+	if !keep || labels.Equal(lset, relabeled) {
+		return lset, false
+	}
+	return relabeled, true
+}
+
+// RelabeledBlockID returns the ID of the block relabeled from the block with the external labels. It is the same
+// for every run relabeling the block to the same labels, so that an interrupted relabeling is resumed, and sorts
+// like the block.
+func RelabeledBlockID(id ulid.ULID, lset labels.Labels) ulid.ULID {
+	entropy := sha256.Sum256([]byte(id.String() + lset.String()))
+	return ulid.MustNew(id.Time(), bytes.NewReader(entropy[:]))
+}
+
+// RelabelExternalLabels replaces the block in the bucket with a copy of it with the external labels relabeled by the
+// relabel configs, and returns the ID of the copy. Blocks the configs drop or do not change are left as they are, and a
+// zero ID is returned.
+//
+// The meta.json of the copy is uploaded after all its other files, so readers never load a partial copy. The block is
+// only marked for no compaction and deletion once the copy is complete: first for no compaction, so that the
+// compactor does not compact it in the meantime, then for deletion. Readers see both blocks until the block is
+// deleted. Running the relabeling again after a failure resumes it, as the copy has the same ID.
+//
+// Files are copied on the server side by the copier of the bucket if it is not nil, and streamed through the process
+// otherwise.
+func RelabelExternalLabels(
+	ctx context.Context,
+	logger log.Logger,
+	bkt objstore.Bucket,
+	copier objstoreutil.ServerSideCopier,
+	id ulid.ULID,
+	relabelConfigs []*relabel.Config,
+	markedForNoCompact prometheus.Counter,
+	markedForDeletion prometheus.Counter,
+) (ulid.ULID, error) {
+	meta, err := DownloadMeta(ctx, logger, bkt, id)
+	if err != nil {
+		return ulid.ULID{}, err
+	}
+	lset, ok := RelabeledExternalLabels(&meta, relabelConfigs)
+	if !ok {
+		return ulid.ULID{}, nil
+	}
+	if lset.IsEmpty() {
+		return ulid.ULID{}, errors.Errorf("block %s has no external labels left after relabeling", id)
+	}
+	newID := RelabeledBlockID(id, lset)
+	logger = log.With(logger, "block", id, "new", newID, "labels", lset.String())
+
+	newMetaFile := path.Join(newID.String(), MetaFilename)
+	ok, err = bkt.Exists(ctx, newMetaFile)
+	if err != nil {
+		return ulid.ULID{}, errors.Wrapf(err, "check exists %s", newMetaFile)
+	}
+	if ok {
+		level.Info(logger).Log("msg", "block already relabeled, resuming")
+	} else {
+		if err := copyBlockFiles(ctx, logger, bkt, copier, id, newID); err != nil {
+			return ulid.ULID{}, err
+		}
+
+		newMeta := meta
+		newMeta.ULID = newID
+		newMeta.Thanos.Labels = lset.Map()
+		newMeta.Thanos.Source = metadata.BucketRelabelSource
+		newMeta.Thanos.Rewrites = append(newMeta.Thanos.Rewrites, metadata.Rewrite{
+			Sources: meta.Compaction.Sources,
+			Labels:  meta.Thanos.Labels,
+		})
+		b, err := json.MarshalIndent(&newMeta, "", "\t")
+		if err != nil {
+			return ulid.ULID{}, errors.Wrap(err, "encode meta")
+		}
+		if err := bkt.Upload(ctx, newMetaFile, bytes.NewReader(b)); err != nil {
+			return ulid.ULID{}, errors.Wrapf(err, "upload %s", newMetaFile)
+		}
+		level.Info(logger).Log("msg", "uploaded relabeled block")
+	}
+
+	if err := MarkForNoCompact(ctx, logger, bkt, id, metadata.RelabeledNoCompactReason, "block relabeled to "+newID.String(), markedForNoCompact); err != nil {
+		return ulid.ULID{}, err
+	}
+	if err := MarkForDeletion(ctx, logger, bkt, id, "block relabeled to "+newID.String(), markedForDeletion); err != nil {
+		return ulid.ULID{}, err
+	}
+	return newID, nil
+}
+
+// copyBlockFiles copies the files of the block to the block with the new ID, except its meta, its markers and its
+// attestation, which is only valid for the block and is signed again for the copy by attesting buckets.
+func copyBlockFiles(ctx context.Context, logger log.Logger, bkt objstore.Bucket, copier objstoreutil.ServerSideCopier, id, newID ulid.ULID) error {
+	prefix := id.String() + objstore.DirDelim
+	return bkt.Iter(ctx, prefix, func(name string) error {
+		rel := strings.TrimPrefix(name, prefix)
+		if rel == MetaFilename || rel == AttestationFilename || IsBlockMarker(rel) {
+			return nil
+		}
+		return objstoreutil.CopyObject(ctx, logger, bkt, copier, bkt, copier, name, path.Join(newID.String(), rel))
+	}, objstore.WithRecursiveIter())
+}
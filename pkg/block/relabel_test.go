@@ -0,0 +1,145 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+// bucketCopier copies objects within an in-memory bucket, recording the copied objects.
+type bucketCopier struct {
+	bkt    objstore.Bucket
+	copies []string
+}
+
+func (c *bucketCopier) Copy(ctx context.Context, _ objstoreutil.ServerSideCopier, src, dst string) error {
+	c.copies = append(c.copies, src)
+	r, err := c.bkt.Get(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return c.bkt.Upload(ctx, dst, r)
+}
+
+func (c *bucketCopier) Close() error { return nil }
+
+func TestRelabelExternalLabels(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	bkt := objstore.NewInMemBucket()
+	id, err := e2eutil.CreateBlock(ctx, dir, []labels.Labels{labels.FromStrings("a", "1")}, 10, 0, 1000, labels.FromStrings("tenant", "a", "replica", "0"), 0, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+	testutil.Ok(t, Upload(ctx, log.NewNopLogger(), bkt, filepath.Join(dir, id.String()), metadata.NoneFunc))
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), AttestationFilename), strings.NewReader("{}")))
+
+	relabelConfig, err := ParseRelabelConfig([]byte(`
+- source_labels: [tenant]
+  regex: a
+  target_label: tenant
+  replacement: b
+`), nil)
+	testutil.Ok(t, err)
+	markedForNoCompact := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+	markedForDeletion := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+
+	newID, err := RelabelExternalLabels(ctx, log.NewNopLogger(), bkt, nil, id, relabelConfig, markedForNoCompact, markedForDeletion)
+	testutil.Ok(t, err)
+	testutil.Equals(t, RelabeledBlockID(id, labels.FromStrings("replica", "0", "tenant", "b")), newID)
+	testutil.Equals(t, id.Time(), newID.Time())
+
+	meta, err := DownloadMeta(ctx, log.NewNopLogger(), bkt, newID)
+	testutil.Ok(t, err)
+	testutil.Equals(t, map[string]string{"tenant": "b", "replica": "0"}, meta.Thanos.Labels)
+	testutil.Equals(t, metadata.BucketRelabelSource, meta.Thanos.Source)
+	testutil.Equals(t, []ulid.ULID{id}, meta.Compaction.Sources)
+	testutil.Equals(t, map[string]string{"tenant": "a", "replica": "0"}, meta.Thanos.Rewrites[0].Labels)
+	for _, f := range meta.Thanos.Files {
+		ok, err := bkt.Exists(ctx, path.Join(newID.String(), f.RelPath))
+		testutil.Ok(t, err)
+		testutil.Assert(t, ok, "file %s not copied", f.RelPath)
+	}
+	// The attestation of the block does not attest the copy.
+	ok, err := bkt.Exists(ctx, path.Join(newID.String(), AttestationFilename))
+	testutil.Ok(t, err)
+	testutil.Assert(t, !ok, "copy with the attestation of the block")
+	for _, marker := range []string{metadata.NoCompactMarkFilename, metadata.DeletionMarkFilename} {
+		ok, err := bkt.Exists(ctx, path.Join(id.String(), marker))
+		testutil.Ok(t, err)
+		testutil.Assert(t, ok, "relabeled block without %s", marker)
+		ok, err = bkt.Exists(ctx, path.Join(newID.String(), marker))
+		testutil.Ok(t, err)
+		testutil.Assert(t, !ok, "copy with %s", marker)
+	}
+	testutil.Equals(t, 1.0, promtest.ToFloat64(markedForNoCompact))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(markedForDeletion))
+
+	// Relabeling again resumes with the same copy.
+	resumedID, err := RelabelExternalLabels(ctx, log.NewNopLogger(), bkt, nil, id, relabelConfig, markedForNoCompact, markedForDeletion)
+	testutil.Ok(t, err)
+	testutil.Equals(t, newID, resumedID)
+	testutil.Equals(t, 1.0, promtest.ToFloat64(markedForDeletion))
+
+	// The copy is left as it is.
+	unchangedID, err := RelabelExternalLabels(ctx, log.NewNopLogger(), bkt, nil, newID, relabelConfig, markedForNoCompact, markedForDeletion)
+	testutil.Ok(t, err)
+	testutil.Equals(t, ulid.ULID{}, unchangedID)
+
+	dropAll, err := ParseRelabelConfig([]byte(`
+- regex: tenant|replica
+  action: labeldrop
+`), nil)
+	testutil.Ok(t, err)
+	_, err = RelabelExternalLabels(ctx, log.NewNopLogger(), bkt, nil, newID, dropAll, markedForNoCompact, markedForDeletion)
+	testutil.NotOk(t, err)
+}
+
+func TestRelabelExternalLabels_ServerSideCopy(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	bkt := objstore.NewInMemBucket()
+	id, err := e2eutil.CreateBlock(ctx, dir, []labels.Labels{labels.FromStrings("a", "1")}, 10, 0, 1000, labels.FromStrings("tenant", "a"), 0, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+	testutil.Ok(t, Upload(ctx, log.NewNopLogger(), bkt, filepath.Join(dir, id.String()), metadata.NoneFunc))
+
+	relabelConfig, err := ParseRelabelConfig([]byte("- {target_label: tenant, replacement: b}"), nil)
+	testutil.Ok(t, err)
+	stubCounter := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+
+	copier := &bucketCopier{bkt: bkt}
+	newID, err := RelabelExternalLabels(ctx, log.NewNopLogger(), bkt, copier, id, relabelConfig, stubCounter, stubCounter)
+	testutil.Ok(t, err)
+
+	// All files but the meta are copied on the server side.
+	meta, err := DownloadMeta(ctx, log.NewNopLogger(), bkt, newID)
+	testutil.Ok(t, err)
+	var copied []string
+	for _, f := range meta.Thanos.Files {
+		if f.RelPath != MetaFilename {
+			copied = append(copied, path.Join(id.String(), f.RelPath))
+		}
+	}
+	testutil.Equals(t, copied, copier.copies)
+}
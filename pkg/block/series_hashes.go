@@ -0,0 +1,175 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"context"
+	"encoding/binary"
+	"slices"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/index"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+const (
+	// SeriesHashesFilename is the file of a block with the shard hashes of its series. It is optional, so readers
+	// have to hash the labels of the series if the file is missing.
+	SeriesHashesFilename = "series.hashes"
+
+	// SeriesHashesVersion1 represents 1 version of the series hashes.
+	SeriesHashesVersion1 = 1
+)
+
+// SeriesHashes are the hashes of the series of a block for the shard matchers of storepb.AllLabelsShardKey, with
+// the external labels of the block, so that store gateways don't hash the labels of the series of sharded requests.
+// Series are referenced by their offset in the index file, as they are by store gateways.
+type SeriesHashes struct {
+	// ExtLabelNames are the names of the external labels of the block included in the hashes, sorted.
+	ExtLabelNames []string
+
+	refs   []storage.SeriesRef
+	hashes []uint64
+}
+
+// Len returns the number of series.
+func (h *SeriesHashes) Len() int {
+	return len(h.refs)
+}
+
+// Get returns the hash of the series, and false if the block has no such series.
+func (h *SeriesHashes) Get(ref storage.SeriesRef) (uint64, bool) {
+	i, ok := slices.BinarySearch(h.refs, ref)
+	if !ok {
+		return 0, false
+	}
+	return h.hashes[i], true
+}
+
+// Encode returns the version, the external label names and the series refs, delta encoded as uvarints, each followed
+// by its hash in little endian.
+func (h *SeriesHashes) Encode() []byte {
+	buf := []byte{SeriesHashesVersion1}
+	buf = binary.AppendUvarint(buf, uint64(len(h.ExtLabelNames)))
+	for _, n := range h.ExtLabelNames {
+		buf = binary.AppendUvarint(buf, uint64(len(n)))
+		buf = append(buf, n...)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(h.refs)))
+	prev := storage.SeriesRef(0)
+	for i, ref := range h.refs {
+		buf = binary.AppendUvarint(buf, uint64(ref-prev))
+		buf = binary.LittleEndian.AppendUint64(buf, h.hashes[i])
+		prev = ref
+	}
+	return buf
+}
+
+// DecodeSeriesHashes decodes series hashes encoded with Encode.
+func DecodeSeriesHashes(buf []byte) (*SeriesHashes, error) {
+	if len(buf) < 1 {
+		return nil, errors.New("series hashes too short")
+	}
+	if buf[0] != SeriesHashesVersion1 {
+		return nil, errors.Errorf("unexpected series hashes version %d", buf[0])
+	}
+	buf = buf[1:]
+
+	uvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return 0, errors.New("malformed series hashes")
+		}
+		buf = buf[n:]
+		return v, nil
+	}
+	names, err := uvarint()
+	if err != nil {
+		return nil, err
+	}
+	h := &SeriesHashes{}
+	for i := uint64(0); i < names; i++ {
+		l, err := uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(buf)) < l {
+			return nil, errors.New("malformed series hashes")
+		}
+		h.ExtLabelNames = append(h.ExtLabelNames, string(buf[:l]))
+		buf = buf[l:]
+	}
+	series, err := uvarint()
+	if err != nil {
+		return nil, err
+	}
+	// Every series takes at least 9 bytes.
+	if series > uint64(len(buf))/9 {
+		return nil, errors.New("malformed series hashes")
+	}
+	h.refs = make([]storage.SeriesRef, 0, series)
+	h.hashes = make([]uint64, 0, series)
+	ref := storage.SeriesRef(0)
+	for i := uint64(0); i < series; i++ {
+		d, err := uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if len(buf) < 8 {
+			return nil, errors.New("malformed series hashes")
+		}
+		ref += storage.SeriesRef(d)
+		h.refs = append(h.refs, ref)
+		h.hashes = append(h.hashes, binary.LittleEndian.Uint64(buf))
+		buf = buf[8:]
+	}
+	return h, nil
+}
+
+// BuildSeriesHashes returns the hashes of the series of the index file, with the external labels.
+func BuildSeriesHashes(ctx context.Context, indexFn string, extLset labels.Labels) (_ *SeriesHashes, err error) {
+	r, err := index.NewFileReader(indexFn, index.DecodePostingsRaw)
+	if err != nil {
+		return nil, errors.Wrap(err, "open index file")
+	}
+	defer runutil.CloseWithErrCapture(&err, r, "series hashes index reader")
+
+	key, value := index.AllPostingsKey()
+	p, err := r.Postings(ctx, key, value)
+	if err != nil {
+		return nil, errors.Wrap(err, "get all postings")
+	}
+
+	h := &SeriesHashes{ExtLabelNames: []string{}}
+	extLset.Range(func(l labels.Label) { h.ExtLabelNames = append(h.ExtLabelNames, l.Name) })
+	sort.Strings(h.ExtLabelNames)
+
+	var builder labels.ScratchBuilder
+	for p.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := r.Series(p.At(), &builder, nil); err != nil {
+			return nil, errors.Wrap(err, "read series")
+		}
+		ref := p.At()
+		// As of version two all series entries are 16 byte padded, and postings are their offsets divided by 16.
+		if r.Version() >= 2 {
+			ref *= 16
+		}
+		h.refs = append(h.refs, ref)
+		// Labels of the series are overridden by the external labels, as they are by the store gateway.
+		h.hashes = append(h.hashes, storepb.AllLabelsShardHash(labelpb.ExtendSortedLabels(builder.Labels(), extLset)))
+	}
+	if err := p.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterate postings")
+	}
+	return h, nil
+}
@@ -0,0 +1,65 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/index"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestSeriesHashes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+
+	var series []labels.Labels
+	for i := range 100 {
+		series = append(series, labels.FromStrings("__name__", "up", "pod", fmt.Sprintf("pod-%d", i)))
+	}
+	id, err := e2eutil.CreateBlock(ctx, tmpDir, series, 10, 0, 1000, labels.EmptyLabels(), 124, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+	indexFn := filepath.Join(tmpDir, id.String(), IndexFilename)
+
+	extLset := labels.FromStrings("region", "eu", "cluster", "a")
+	h, err := BuildSeriesHashes(ctx, indexFn, extLset)
+	testutil.Ok(t, err)
+	h, err = DecodeSeriesHashes(h.Encode())
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"cluster", "region"}, h.ExtLabelNames)
+	testutil.Equals(t, 100, h.Len())
+
+	r, err := index.NewFileReader(indexFn, index.DecodePostingsRaw)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, r.Close()) }()
+	key, value := index.AllPostingsKey()
+	p, err := r.Postings(ctx, key, value)
+	testutil.Ok(t, err)
+	var builder labels.ScratchBuilder
+	for p.Next() {
+		testutil.Ok(t, r.Series(p.At(), &builder, nil))
+		lset := labels.NewBuilder(builder.Labels()).Set("region", "eu").Set("cluster", "a").Labels()
+		hash, ok := h.Get(p.At() * 16)
+		testutil.Assert(t, ok)
+		testutil.Equals(t, storepb.AllLabelsShardHash(lset), hash)
+	}
+	testutil.Ok(t, p.Err())
+	_, ok := h.Get(0)
+	testutil.Assert(t, !ok)
+
+	_, err = DecodeSeriesHashes([]byte{2, 0, 0})
+	testutil.NotOk(t, err)
+	_, err = DecodeSeriesHashes([]byte{SeriesHashesVersion1, 0, 1, 1, 0})
+	testutil.NotOk(t, err)
+}
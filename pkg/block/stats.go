@@ -0,0 +1,142 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// GroupStats summarizes the blocks of a compaction group, i.e. blocks with the same external labels and resolution.
+type GroupStats struct {
+	Labels     labels.Labels `json:"labels"`
+	Resolution int64         `json:"resolution"`
+
+	Blocks int `json:"blocks"`
+	// BlocksByLevel is the number of blocks per compaction level.
+	BlocksByLevel map[int]int `json:"blocks_by_level"`
+	// MarkedForDeletion is the number of blocks with a deletion mark, not deleted yet.
+	MarkedForDeletion int    `json:"marked_for_deletion"`
+	SizeBytes         int64  `json:"size_bytes"`
+	Series            uint64 `json:"series"`
+	Samples           uint64 `json:"samples"`
+	// MinTime and MaxTime are the timestamps, in milliseconds, of the oldest and newest data of the group.
+	MinTime int64 `json:"min_time"`
+	MaxTime int64 `json:"max_time"`
+}
+
+// ComputeGroupStats returns the statistics of every compaction group of the given blocks, sorted by labels and resolution.
+func ComputeGroupStats(metas map[ulid.ULID]*metadata.Meta, deletionMarks map[ulid.ULID]*metadata.DeletionMark) []GroupStats {
+	groups := map[string]*GroupStats{}
+	for id, m := range metas {
+		g, ok := groups[m.Thanos.GroupKey()]
+		if !ok {
+			g = &GroupStats{
+				Labels:        labels.FromMap(m.Thanos.Labels),
+				Resolution:    m.Thanos.Downsample.Resolution,
+				BlocksByLevel: map[int]int{},
+				MinTime:       m.MinTime,
+				MaxTime:       m.MaxTime,
+			}
+			groups[m.Thanos.GroupKey()] = g
+		}
+
+		g.Blocks++
+		g.BlocksByLevel[m.Compaction.Level]++
+		if _, ok := deletionMarks[id]; ok {
+			g.MarkedForDeletion++
+		}
+		for _, f := range m.Thanos.Files {
+			g.SizeBytes += f.SizeBytes
+		}
+		g.Series += m.Stats.NumSeries
+		g.Samples += m.Stats.NumSamples
+		g.MinTime = min(g.MinTime, m.MinTime)
+		g.MaxTime = max(g.MaxTime, m.MaxTime)
+	}
+
+	res := make([]GroupStats, 0, len(groups))
+	for _, g := range groups {
+		res = append(res, *g)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if c := labels.Compare(res[i].Labels, res[j].Labels); c != 0 {
+			return c < 0
+		}
+		return res[i].Resolution < res[j].Resolution
+	})
+	return res
+}
+
+// GroupStatsMetrics exposes group statistics as Prometheus metrics.
+type GroupStatsMetrics struct {
+	blocks            *prometheus.GaugeVec
+	markedForDeletion *prometheus.GaugeVec
+	sizeBytes         *prometheus.GaugeVec
+	series            *prometheus.GaugeVec
+	samples           *prometheus.GaugeVec
+	minTime           *prometheus.GaugeVec
+	maxTime           *prometheus.GaugeVec
+}
+
+// NewGroupStatsMetrics registers the group statistics metrics.
+func NewGroupStatsMetrics(reg prometheus.Registerer) *GroupStatsMetrics {
+	groupLabels := []string{"group", "resolution"}
+	return &GroupStatsMetrics{
+		blocks: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_bucket_group_blocks",
+			Help: "Number of blocks in the bucket per compaction group and compaction level.",
+		}, append(groupLabels, "level")),
+		markedForDeletion: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_bucket_group_blocks_marked_for_deletion",
+			Help: "Number of blocks marked for deletion and not deleted yet per compaction group.",
+		}, groupLabels),
+		sizeBytes: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_bucket_group_size_bytes",
+			Help: "Total size of the blocks per compaction group.",
+		}, groupLabels),
+		series: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_bucket_group_series",
+			Help: "Total number of series of the blocks per compaction group.",
+		}, groupLabels),
+		samples: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_bucket_group_samples",
+			Help: "Total number of samples of the blocks per compaction group.",
+		}, groupLabels),
+		minTime: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_bucket_group_min_time_seconds",
+			Help: "Timestamp of the oldest data per compaction group.",
+		}, groupLabels),
+		maxTime: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_bucket_group_max_time_seconds",
+			Help: "Timestamp of the newest data per compaction group.",
+		}, groupLabels),
+	}
+}
+
+// Update replaces the exposed statistics with the given ones, so that groups that disappeared are not exposed anymore.
+func (m *GroupStatsMetrics) Update(stats []GroupStats) {
+	for _, v := range []*prometheus.GaugeVec{m.blocks, m.markedForDeletion, m.sizeBytes, m.series, m.samples, m.minTime, m.maxTime} {
+		v.Reset()
+	}
+	for _, g := range stats {
+		lset, res := g.Labels.String(), strconv.FormatInt(g.Resolution, 10)
+		for level, n := range g.BlocksByLevel {
+			m.blocks.WithLabelValues(lset, res, strconv.Itoa(level)).Set(float64(n))
+		}
+		m.markedForDeletion.WithLabelValues(lset, res).Set(float64(g.MarkedForDeletion))
+		m.sizeBytes.WithLabelValues(lset, res).Set(float64(g.SizeBytes))
+		m.series.WithLabelValues(lset, res).Set(float64(g.Series))
+		m.samples.WithLabelValues(lset, res).Set(float64(g.Samples))
+		m.minTime.WithLabelValues(lset, res).Set(float64(g.MinTime) / 1000)
+		m.maxTime.WithLabelValues(lset, res).Set(float64(g.MaxTime) / 1000)
+	}
+}
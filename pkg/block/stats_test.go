@@ -0,0 +1,82 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+func TestComputeGroupStats(t *testing.T) {
+	t.Parallel()
+
+	newMeta := func(id uint64, lset map[string]string, resolution int64, level int, minTime, maxTime int64, size int64) *metadata.Meta {
+		return &metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{
+				ULID:       ulid.MustNew(id, nil),
+				MinTime:    minTime,
+				MaxTime:    maxTime,
+				Stats:      tsdb.BlockStats{NumSeries: 10, NumSamples: 100},
+				Compaction: tsdb.BlockMetaCompaction{Level: level},
+			},
+			Thanos: metadata.Thanos{
+				Labels:     lset,
+				Downsample: metadata.ThanosDownsample{Resolution: resolution},
+				Files:      []metadata.File{{RelPath: "index", SizeBytes: size}},
+			},
+		}
+	}
+
+	a, b := map[string]string{"cluster": "a"}, map[string]string{"cluster": "b"}
+	metas := map[ulid.ULID]*metadata.Meta{}
+	for _, m := range []*metadata.Meta{
+		newMeta(1, b, 0, 1, 0, 10, 5),
+		newMeta(2, a, 0, 1, 10, 20, 1),
+		newMeta(3, a, 0, 2, 0, 10, 2),
+		newMeta(4, a, 300000, 1, 0, 20, 3),
+	} {
+		metas[m.ULID] = m
+	}
+	marks := map[ulid.ULID]*metadata.DeletionMark{ulid.MustNew(3, nil): {ID: ulid.MustNew(3, nil)}}
+
+	stats := ComputeGroupStats(metas, marks)
+	testutil.Equals(t, []GroupStats{
+		{
+			Labels: labels.FromStrings("cluster", "a"), Resolution: 0,
+			Blocks: 2, BlocksByLevel: map[int]int{1: 1, 2: 1}, MarkedForDeletion: 1,
+			SizeBytes: 3, Series: 20, Samples: 200, MinTime: 0, MaxTime: 20,
+		},
+		{
+			Labels: labels.FromStrings("cluster", "a"), Resolution: 300000,
+			Blocks: 1, BlocksByLevel: map[int]int{1: 1},
+			SizeBytes: 3, Series: 10, Samples: 100, MinTime: 0, MaxTime: 20,
+		},
+		{
+			Labels: labels.FromStrings("cluster", "b"), Resolution: 0,
+			Blocks: 1, BlocksByLevel: map[int]int{1: 1},
+			SizeBytes: 5, Series: 10, Samples: 100, MinTime: 0, MaxTime: 10,
+		},
+	}, stats)
+
+	reg := prometheus.NewRegistry()
+	m := NewGroupStatsMetrics(reg)
+	m.Update(stats)
+	testutil.Equals(t, 1.0, promtest.ToFloat64(m.blocks.WithLabelValues(`{cluster="a"}`, "0", "2")))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(m.markedForDeletion.WithLabelValues(`{cluster="a"}`, "0")))
+	testutil.Equals(t, 0.02, promtest.ToFloat64(m.maxTime.WithLabelValues(`{cluster="a"}`, "300000")))
+	testutil.Equals(t, 3, promtest.CollectAndCount(m.sizeBytes))
+
+	// Groups that disappeared are not exposed anymore.
+	m.Update(stats[2:])
+	testutil.Equals(t, 1, promtest.CollectAndCount(m.sizeBytes))
+	testutil.Equals(t, 1, promtest.CollectAndCount(m.blocks))
+}
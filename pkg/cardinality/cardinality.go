@@ -0,0 +1,243 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package cardinality analyzes the cardinality of the series of tenants from the indexes of blocks and heads,
+// to find the label names and values behind cardinality explosions without downloading blocks.
+package cardinality
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+
+	"github.com/thanos-io/thanos/pkg/block/indexheader"
+)
+
+// Stat is the number of series or label values of a label name, value or pair.
+type Stat struct {
+	Name  string `json:"name"`
+	Value uint64 `json:"value"`
+}
+
+// Stats is the cardinality of the series of a tenant. The counts are the highest of the blocks and heads
+// analyzed, as the same series are in consecutive blocks and in replicas, so they approximate the number of
+// series at any point of time rather than over the whole range.
+type Stats struct {
+	Tenant string `json:"tenant"`
+	// Blocks is the number of blocks and heads analyzed.
+	Blocks                      int    `json:"blocks"`
+	NumSeries                   uint64 `json:"numSeries"`
+	SeriesCountByMetricName     []Stat `json:"seriesCountByMetricName"`
+	SeriesCountByLabelName      []Stat `json:"seriesCountByLabelName"`
+	LabelValueCountByLabelName  []Stat `json:"labelValueCountByLabelName"`
+	SeriesCountByLabelValuePair []Stat `json:"seriesCountByLabelValuePair"`
+}
+
+// Index is the index of a block or head.
+type Index interface {
+	NumSeries() uint64
+	// LabelNames returns the label names of the index.
+	LabelNames(ctx context.Context) ([]string, error)
+	// LabelValues returns the values of the label name.
+	LabelValues(ctx context.Context, name string) ([]string, error)
+	// SeriesCount returns the number of series with the label name and value.
+	SeriesCount(ctx context.Context, name, value string) (uint64, error)
+}
+
+// Analyze returns the cardinality of the index, with the limit stats with the highest values of each kind.
+func Analyze(ctx context.Context, tenant string, idx Index, limit int) (Stats, error) {
+	var (
+		metrics      = newTop(limit)
+		seriesByName = newTop(limit)
+		valuesByName = newTop(limit)
+		pairs        = newTop(limit)
+	)
+	names, err := idx.LabelNames(ctx)
+	if err != nil {
+		return Stats{}, errors.Wrap(err, "label names")
+	}
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return Stats{}, err
+		}
+		values, err := idx.LabelValues(ctx, name)
+		if err != nil {
+			return Stats{}, errors.Wrapf(err, "label values of %s", name)
+		}
+		valuesByName.push(Stat{Name: name, Value: uint64(len(values))})
+
+		var series uint64
+		for _, value := range values {
+			n, err := idx.SeriesCount(ctx, name, value)
+			if err != nil {
+				return Stats{}, errors.Wrapf(err, "series count of %s=%s", name, value)
+			}
+			// Every series has a single value of the label name.
+			series += n
+			pairs.push(Stat{Name: name + "=" + value, Value: n})
+			if name == labels.MetricName {
+				metrics.push(Stat{Name: value, Value: n})
+			}
+		}
+		seriesByName.push(Stat{Name: name, Value: series})
+	}
+	return Stats{
+		Tenant:                      tenant,
+		Blocks:                      1,
+		NumSeries:                   idx.NumSeries(),
+		SeriesCountByMetricName:     metrics.sorted(),
+		SeriesCountByLabelName:      seriesByName.sorted(),
+		LabelValueCountByLabelName:  valuesByName.sorted(),
+		SeriesCountByLabelValuePair: pairs.sorted(),
+	}, nil
+}
+
+// Merge merges the stats of several blocks, heads or components of a tenant, keeping the highest value of
+// each name and the limit stats with the highest values of each kind.
+func Merge(tenant string, limit int, stats ...Stats) Stats {
+	merged := Stats{Tenant: tenant}
+	var metrics, seriesByName, valuesByName, pairs []Stat
+	for _, s := range stats {
+		merged.Blocks += s.Blocks
+		merged.NumSeries = max(merged.NumSeries, s.NumSeries)
+		metrics = append(metrics, s.SeriesCountByMetricName...)
+		seriesByName = append(seriesByName, s.SeriesCountByLabelName...)
+		valuesByName = append(valuesByName, s.LabelValueCountByLabelName...)
+		pairs = append(pairs, s.SeriesCountByLabelValuePair...)
+	}
+	merged.SeriesCountByMetricName = mergeMax(limit, metrics)
+	merged.SeriesCountByLabelName = mergeMax(limit, seriesByName)
+	merged.LabelValueCountByLabelName = mergeMax(limit, valuesByName)
+	merged.SeriesCountByLabelValuePair = mergeMax(limit, pairs)
+	return merged
+}
+
+func mergeMax(limit int, stats []Stat) []Stat {
+	byName := make(map[string]uint64, len(stats))
+	for _, s := range stats {
+		byName[s.Name] = max(byName[s.Name], s.Value)
+	}
+	t := newTop(limit)
+	for name, v := range byName {
+		t.push(Stat{Name: name, Value: v})
+	}
+	return t.sorted()
+}
+
+// top keeps the limit stats with the highest values.
+type top struct {
+	limit int
+	h     statHeap
+}
+
+func newTop(limit int) *top {
+	return &top{limit: limit}
+}
+
+func (t *top) push(s Stat) {
+	if len(t.h) < t.limit {
+		heap.Push(&t.h, s)
+		return
+	}
+	if t.limit > 0 && less(t.h[0], s) {
+		t.h[0] = s
+		heap.Fix(&t.h, 0)
+	}
+}
+
+// sorted returns the stats by value in descending order, and by name for equal values.
+func (t *top) sorted() []Stat {
+	res := make([]Stat, len(t.h))
+	copy(res, t.h)
+	sort.Slice(res, func(i, j int) bool { return less(res[j], res[i]) })
+	return res
+}
+
+func less(a, b Stat) bool {
+	if a.Value != b.Value {
+		return a.Value < b.Value
+	}
+	return a.Name > b.Name
+}
+
+// statHeap is a min-heap of stats.
+type statHeap []Stat
+
+func (h statHeap) Len() int           { return len(h) }
+func (h statHeap) Less(i, j int) bool { return less(h[i], h[j]) }
+func (h statHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *statHeap) Push(x any)        { *h = append(*h, x.(Stat)) }
+func (h *statHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+type headerIndex struct {
+	r         indexheader.Reader
+	numSeries uint64
+}
+
+// NewIndexHeaderIndex returns the index of a block from its index header, which has the number of series of
+// the label values without reading the postings.
+func NewIndexHeaderIndex(r indexheader.Reader, numSeries uint64) Index {
+	return headerIndex{r: r, numSeries: numSeries}
+}
+
+func (h headerIndex) NumSeries() uint64 { return h.numSeries }
+
+func (h headerIndex) LabelNames(context.Context) ([]string, error) { return h.r.LabelNames() }
+
+func (h headerIndex) LabelValues(_ context.Context, name string) ([]string, error) {
+	return h.r.LabelValues(name)
+}
+
+func (h headerIndex) SeriesCount(_ context.Context, name, value string) (uint64, error) {
+	rng, err := h.r.PostingsOffset(name, value)
+	if err != nil {
+		if errors.Is(err, indexheader.NotFoundRangeErr) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	// The postings are their length followed by 4 bytes per series.
+	if rng.End-rng.Start < 4 {
+		return 0, nil
+	}
+	return uint64((rng.End - rng.Start - 4) / 4), nil
+}
+
+type tsdbIndex struct {
+	ir        tsdb.IndexReader
+	numSeries uint64
+}
+
+// NewTSDBIndex returns the index of a head or TSDB block from its index reader.
+func NewTSDBIndex(ir tsdb.IndexReader, numSeries uint64) Index {
+	return tsdbIndex{ir: ir, numSeries: numSeries}
+}
+
+func (t tsdbIndex) NumSeries() uint64 { return t.numSeries }
+
+func (t tsdbIndex) LabelNames(ctx context.Context) ([]string, error) { return t.ir.LabelNames(ctx) }
+
+func (t tsdbIndex) LabelValues(ctx context.Context, name string) ([]string, error) {
+	return t.ir.SortedLabelValues(ctx, name)
+}
+
+func (t tsdbIndex) SeriesCount(ctx context.Context, name, value string) (uint64, error) {
+	p, err := t.ir.Postings(ctx, name, value)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for p.Next() {
+		n++
+	}
+	return n, p.Err()
+}
@@ -0,0 +1,97 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package cardinality
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+// fakeIndex is an index of label name, value pairs and the number of their series.
+type fakeIndex struct {
+	numSeries uint64
+	series    map[string]map[string]uint64
+}
+
+func (f fakeIndex) NumSeries() uint64 { return f.numSeries }
+
+func (f fakeIndex) LabelNames(context.Context) ([]string, error) {
+	var names []string
+	for n := range f.series {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (f fakeIndex) LabelValues(_ context.Context, name string) ([]string, error) {
+	var values []string
+	for v := range f.series[name] {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+func (f fakeIndex) SeriesCount(_ context.Context, name, value string) (uint64, error) {
+	return f.series[name][value], nil
+}
+
+func TestAnalyze(t *testing.T) {
+	t.Parallel()
+
+	idx := fakeIndex{
+		numSeries: 6,
+		series: map[string]map[string]uint64{
+			"__name__": {"up": 2, "http_requests_total": 4},
+			"job":      {"api": 5, "db": 1},
+			"pod":      {"a": 1, "b": 1, "c": 1},
+		},
+	}
+	s, err := Analyze(context.Background(), "team-a", idx, 2)
+	testutil.Ok(t, err)
+	testutil.Equals(t, Stats{
+		Tenant:                      "team-a",
+		Blocks:                      1,
+		NumSeries:                   6,
+		SeriesCountByMetricName:     []Stat{{Name: "http_requests_total", Value: 4}, {Name: "up", Value: 2}},
+		SeriesCountByLabelName:      []Stat{{Name: "__name__", Value: 6}, {Name: "job", Value: 6}},
+		LabelValueCountByLabelName:  []Stat{{Name: "pod", Value: 3}, {Name: "__name__", Value: 2}},
+		SeriesCountByLabelValuePair: []Stat{{Name: "job=api", Value: 5}, {Name: "__name__=http_requests_total", Value: 4}},
+	}, s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Analyze(ctx, "team-a", idx, 2)
+	testutil.NotOk(t, err)
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+
+	merged := Merge("team-a", 2,
+		Stats{
+			Blocks:                  1,
+			NumSeries:               10,
+			SeriesCountByMetricName: []Stat{{Name: "up", Value: 7}, {Name: "a", Value: 3}},
+		},
+		Stats{
+			Blocks:                  2,
+			NumSeries:               12,
+			SeriesCountByMetricName: []Stat{{Name: "b", Value: 8}, {Name: "up", Value: 5}},
+		},
+	)
+	testutil.Equals(t, Stats{
+		Tenant:                      "team-a",
+		Blocks:                      3,
+		NumSeries:                   12,
+		SeriesCountByMetricName:     []Stat{{Name: "b", Value: 8}, {Name: "up", Value: 7}},
+		SeriesCountByLabelName:      []Stat{},
+		LabelValueCountByLabelName:  []Stat{},
+		SeriesCountByLabelValuePair: []Stat{},
+	}, merged)
+}
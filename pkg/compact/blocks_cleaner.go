@@ -43,8 +43,17 @@ func NewBlocksCleaner(logger log.Logger, bkt objstore.Bucket, ignoreDeletionMark
 func (s *BlocksCleaner) DeleteMarkedBlocks(ctx context.Context) error {
 	level.Info(s.logger).Log("msg", "started cleaning of blocks marked for deletion")
 
+	if err := checkFenced(ctx, s.logger, s.bkt); err != nil {
+		return err
+	}
+
 	deletionMarkMap := s.ignoreDeletionMarkFilter.DeletionMarkBlocks()
 	for _, deletionMark := range deletionMarkMap {
+		if markedByNewerRun(ctx, deletionMark.Writer) {
+			// The newer run deletes it once it is due.
+			level.Warn(s.logger).Log("msg", "not deleting block marked for deletion by a newer compactor run", "block", deletionMark.ID, "marked_by", deletionMark.Writer.Identity, "fencing_token", deletionMark.Writer.FencingToken)
+			continue
+		}
 		if time.Since(time.Unix(deletionMark.DeletionTime, 0)).Seconds() > s.deleteDelay.Seconds() {
 			if err := block.Delete(ctx, s.logger, s.bkt, deletionMark.ID); err != nil {
 				s.blockCleanupFailures.Inc()
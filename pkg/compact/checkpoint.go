@@ -0,0 +1,182 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+const (
+	// CheckpointVersion1 represents 1 version of the compaction checkpoint.
+	CheckpointVersion1 = 1
+
+	checkpointDirname  = "checkpoint"
+	checkpointFilename = "checkpoint.json"
+)
+
+// Checkpoint defines the format of the checkpoint of the compaction of a plan of a group, which is kept in the
+// work directory of the group, so that a compactor restarted in the middle of a long compaction resumes the same
+// plan without downloading, verifying and compacting its blocks again.
+type Checkpoint struct {
+	Version int `json:"version"`
+	// Plan is the blocks to compact. A checkpoint of another plan is discarded.
+	Plan []ulid.ULID `json:"plan"`
+	// Verified is the blocks of the plan downloaded and verified.
+	Verified []ulid.ULID `json:"verified,omitempty"`
+	// Compacted is the blocks written by the compaction of the plan. Blocks are written to a temporary directory
+	// first, so a compaction interrupted before it is done starts over. The compacted blocks keep their ULIDs
+	// when resumed, so an interrupted upload is completed rather than the same data uploaded as another block.
+	Compacted []ulid.ULID `json:"compacted,omitempty"`
+	// Uploaded is the compacted blocks uploaded.
+	Uploaded []ulid.ULID `json:"uploaded,omitempty"`
+}
+
+// checkpointer persists the checkpoint of the compaction of a group. A nil checkpointer persists nothing.
+type checkpointer struct {
+	dir string
+
+	mtx sync.Mutex
+	cp  Checkpoint
+}
+
+func checkpointPath(groupDir string) string {
+	return filepath.Join(groupDir, checkpointDirname, checkpointFilename)
+}
+
+// readCheckpoint returns the checkpointer of the plan in the group work directory, resumed from its checkpoint
+// if it is of the same plan.
+func readCheckpoint(logger log.Logger, groupDir string, plan []*metadata.Meta) *checkpointer {
+	ids := make([]ulid.ULID, 0, len(plan))
+	for _, m := range plan {
+		ids = append(ids, m.ULID)
+	}
+	c := &checkpointer{dir: groupDir, cp: Checkpoint{Version: CheckpointVersion1, Plan: ids}}
+
+	b, err := os.ReadFile(checkpointPath(groupDir))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			level.Warn(logger).Log("msg", "failed to read compaction checkpoint, compacting from scratch", "err", err)
+		}
+		return c
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(b, &cp); err != nil || cp.Version != CheckpointVersion1 {
+		level.Warn(logger).Log("msg", "failed to parse compaction checkpoint, compacting from scratch", "err", err)
+		return c
+	}
+	if !slices.Equal(cp.Plan, ids) {
+		level.Info(logger).Log("msg", "compaction checkpoint is of another plan, compacting from scratch", "checkpoint_plan", fmt.Sprintf("%v", cp.Plan))
+		return c
+	}
+	level.Info(logger).Log("msg", "resuming compaction from checkpoint", "verified", len(cp.Verified), "compacted", fmt.Sprintf("%v", cp.Compacted), "uploaded", len(cp.Uploaded))
+	c.cp = cp
+	return c
+}
+
+// verified returns whether the block was downloaded and verified, and is still in the work directory.
+func (c *checkpointer) verified(id ulid.ULID) bool {
+	if c == nil {
+		return false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return slices.Contains(c.cp.Verified, id) && blockDirComplete(filepath.Join(c.dir, id.String()))
+}
+
+// compacted returns the compacted blocks of the plan, if they are all still in the work directory.
+func (c *checkpointer) compacted() []ulid.ULID {
+	if c == nil {
+		return nil
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, id := range c.cp.Compacted {
+		if !blockDirComplete(filepath.Join(c.dir, id.String())) {
+			return nil
+		}
+	}
+	return c.cp.Compacted
+}
+
+func (c *checkpointer) uploaded(id ulid.ULID) bool {
+	if c == nil {
+		return false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return slices.Contains(c.cp.Uploaded, id)
+}
+
+func (c *checkpointer) markVerified(id ulid.ULID) error {
+	return c.update(func(cp *Checkpoint) { cp.Verified = append(cp.Verified, id) })
+}
+
+func (c *checkpointer) markCompacted(ids []ulid.ULID) error {
+	return c.update(func(cp *Checkpoint) { cp.Compacted = ids })
+}
+
+func (c *checkpointer) markUploaded(id ulid.ULID) error {
+	return c.update(func(cp *Checkpoint) { cp.Uploaded = append(cp.Uploaded, id) })
+}
+
+func (c *checkpointer) update(f func(cp *Checkpoint)) error {
+	if c == nil {
+		return nil
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	f(&c.cp)
+
+	if err := os.MkdirAll(filepath.Join(c.dir, checkpointDirname), 0750); err != nil {
+		return errors.Wrap(err, "create checkpoint dir")
+	}
+	b, err := json.Marshal(c.cp)
+	if err != nil {
+		return errors.Wrap(err, "marshal checkpoint")
+	}
+	tmp := checkpointPath(c.dir) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "write checkpoint")
+	}
+	return errors.Wrap(os.Rename(tmp, checkpointPath(c.dir)), "rename checkpoint")
+}
+
+// checkpointIgnoreDirs returns the directories of the checkpoint of the group and of its compacted blocks,
+// relative to the compaction work directory, to keep them on its cleanup.
+func checkpointIgnoreDirs(logger log.Logger, compactDir, groupKey string) []string {
+	groupDir := filepath.Join(compactDir, groupKey)
+	b, err := os.ReadFile(checkpointPath(groupDir))
+	if err != nil {
+		return nil
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		level.Warn(logger).Log("msg", "failed to parse compaction checkpoint", "group", groupKey, "err", err)
+		return nil
+	}
+	dirs := []string{filepath.Join(groupKey, checkpointDirname)}
+	for _, id := range cp.Compacted {
+		dirs = append(dirs, filepath.Join(groupKey, id.String()))
+	}
+	return dirs
+}
+
+func blockDirComplete(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, block.MetaFilename))
+	return err == nil
+}
@@ -0,0 +1,68 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/prometheus/tsdb"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+func TestCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	var (
+		compactDir = t.TempDir()
+		groupDir   = filepath.Join(compactDir, "0@123")
+		a, b, out  = ulid.MustNew(1, nil), ulid.MustNew(2, nil), ulid.MustNew(3, nil)
+		plan       = []*metadata.Meta{{BlockMeta: tsdb.BlockMeta{ULID: a}}, {BlockMeta: tsdb.BlockMeta{ULID: b}}}
+	)
+	writeMeta := func(id ulid.ULID) {
+		testutil.Ok(t, os.MkdirAll(filepath.Join(groupDir, id.String()), 0750))
+		testutil.Ok(t, os.WriteFile(filepath.Join(groupDir, id.String(), block.MetaFilename), []byte("{}"), 0600))
+	}
+
+	cp := readCheckpoint(log.NewNopLogger(), groupDir, plan)
+	writeMeta(a)
+	writeMeta(b)
+	testutil.Ok(t, cp.markVerified(a))
+	testutil.Ok(t, cp.markCompacted([]ulid.ULID{out}))
+
+	// The restarted compaction resumes the verified blocks, but compacts again until the compacted block is
+	// written.
+	cp = readCheckpoint(log.NewNopLogger(), groupDir, plan)
+	testutil.Assert(t, cp.verified(a))
+	testutil.Assert(t, !cp.verified(b))
+	testutil.Equals(t, 0, len(cp.compacted()))
+
+	writeMeta(out)
+	testutil.Equals(t, []ulid.ULID{out}, cp.compacted())
+	testutil.Ok(t, cp.markUploaded(out))
+	testutil.Assert(t, readCheckpoint(log.NewNopLogger(), groupDir, plan).uploaded(out))
+
+	// The cleanup of the work directory keeps the checkpoint and the compacted blocks.
+	ignoreDirs := append(checkpointIgnoreDirs(log.NewNopLogger(), compactDir, "0@123"), filepath.Join("0@123", a.String()), filepath.Join("0@123", b.String()))
+	testutil.Ok(t, runutil.DeleteAll(compactDir, ignoreDirs...))
+	cp = readCheckpoint(log.NewNopLogger(), groupDir, plan)
+	testutil.Assert(t, cp.verified(a))
+	testutil.Equals(t, []ulid.ULID{out}, cp.compacted())
+
+	// A checkpoint of another plan is discarded.
+	cp = readCheckpoint(log.NewNopLogger(), groupDir, plan[:1])
+	testutil.Assert(t, !cp.verified(a))
+	testutil.Equals(t, 0, len(cp.compacted()))
+
+	var nilCheckpointer *checkpointer
+	testutil.Assert(t, !nilCheckpointer.verified(a))
+	testutil.Ok(t, nilCheckpointer.markVerified(a))
+}
@@ -9,9 +9,11 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-kit/log"
@@ -27,11 +29,14 @@ import (
 	"github.com/thanos-io/objstore"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/thanos-io/thanos/pkg/activetracker"
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/errutil"
+	"github.com/thanos-io/thanos/pkg/logging"
 	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 	"github.com/thanos-io/thanos/pkg/tracing"
 )
 
@@ -49,19 +54,29 @@ const (
 	DedupAlgorithmPenalty = "penalty"
 )
 
+// Downloading and verifying the blocks of a plan logs once per block, at most blockLogSampleBurst lines with
+// the same message are logged per blockLogSampleInterval.
+const (
+	blockLogSampleInterval = time.Minute
+	blockLogSampleBurst    = 10
+)
+
 // Syncer synchronizes block metas from a bucket into a local directory.
 // It sorts them into compaction groups based on equal label sets.
 type Syncer struct {
-	logger                   log.Logger
-	bkt                      objstore.Bucket
-	fetcher                  block.MetadataFetcher
-	mtx                      sync.Mutex
-	blocks                   map[ulid.ULID]*metadata.Meta
-	partial                  map[ulid.ULID]error
-	metrics                  *SyncerMetrics
-	duplicateBlocksFilter    block.DeduplicateFilter
-	ignoreDeletionMarkFilter *block.IgnoreDeletionMarkFilter
-	syncMetasTimeout         time.Duration
+	logger           log.Logger
+	bkt              objstore.Bucket
+	fetcher          block.MetadataFetcher
+	mtx              sync.Mutex
+	blocks           map[ulid.ULID]*metadata.Meta
+	partial          map[ulid.ULID]error
+	metrics          *SyncerMetrics
+	garbagePolicy    GarbagePolicy
+	syncMetasTimeout time.Duration
+	// restored is set while the blocks are the ones restored from a state snapshot, until the next meta sync.
+	restored bool
+	// pendingGarbage is the blocks the garbage policy selected that were not marked for deletion yet.
+	pendingGarbage []ulid.ULID
 
 	g singleflight.Group
 }
@@ -75,6 +90,18 @@ type SyncerMetrics struct {
 	GarbageCollectionFailures prometheus.Counter
 	GarbageCollectionDuration prometheus.Observer
 	BlocksMarkedForDeletion   prometheus.Counter
+
+	// The health of the meta syncs, to tell a compactor with nothing to do from one failing to list the bucket.
+	// They are optional.
+	MetaSyncs        prometheus.Counter
+	MetaSyncFailures prometheus.Counter
+	Metas            prometheus.Gauge
+	PartialBlocks    *prometheus.GaugeVec
+	FilteredBlocks   *prometheus.GaugeVec
+
+	// lastSuccessfulMetaSync is the Unix time in nanoseconds of the last successful meta sync, or of the creation
+	// of the metrics before the first one.
+	lastSuccessfulMetaSync atomic.Int64
 }
 
 func NewSyncerMetrics(reg prometheus.Registerer, blocksMarkedForDeletion, garbageCollectedBlocks prometheus.Counter) *SyncerMetrics {
@@ -97,9 +124,79 @@ func NewSyncerMetrics(reg prometheus.Registerer, blocksMarkedForDeletion, garbag
 
 	m.BlocksMarkedForDeletion = blocksMarkedForDeletion
 
+	m.MetaSyncs = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_compact_syncer_meta_syncs_total",
+		Help: "Total number of meta syncs of the compactor.",
+	})
+	m.MetaSyncFailures = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_compact_syncer_meta_sync_failures_total",
+		Help: "Total number of failed meta syncs of the compactor.",
+	})
+	m.lastSuccessfulMetaSync.Store(time.Now().UnixNano())
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "thanos_compact_syncer_seconds_since_last_successful_meta_sync",
+		Help: "Seconds since the last successful meta sync of the compactor, or since its start if none succeeded yet.",
+	}, func() float64 {
+		return time.Since(time.Unix(0, m.lastSuccessfulMetaSync.Load())).Seconds()
+	})
+	m.Metas = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_compact_syncer_metas",
+		Help: "Number of block metas the compactor works with after the last successful meta sync.",
+	})
+	m.PartialBlocks = promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thanos_compact_syncer_partial_blocks",
+		Help: "Number of partial blocks found by the last successful meta sync of the compactor, by whether their meta.json is missing or corrupted.",
+	}, []string{"reason"})
+	m.FilteredBlocks = promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thanos_compact_syncer_filtered_blocks",
+		Help: "Number of blocks excluded by every filter of the fetcher in the last successful meta sync of the compactor.",
+	}, []string{"filter"})
+	for _, reason := range []string{block.NoMeta, block.CorruptedMeta} {
+		m.PartialBlocks.WithLabelValues(reason)
+	}
+
 	return &m
 }
 
+// filteredBlocksFetcher is a block.MetadataFetcher reporting the blocks excluded by its filters, like
+// block.MetaFetcher.
+type filteredBlocksFetcher interface {
+	FilteredBlocks() map[string]int
+}
+
+// observeMetaSync updates the metrics of the meta syncs with the result of a sync.
+func (m *SyncerMetrics) observeMetaSync(fetcher block.MetadataFetcher, metas map[ulid.ULID]*metadata.Meta, partial map[ulid.ULID]error, err error) {
+	if m.MetaSyncs != nil {
+		m.MetaSyncs.Inc()
+	}
+	if err != nil {
+		if m.MetaSyncFailures != nil {
+			m.MetaSyncFailures.Inc()
+		}
+		return
+	}
+	m.lastSuccessfulMetaSync.Store(time.Now().UnixNano())
+	if m.Metas != nil {
+		m.Metas.Set(float64(len(metas)))
+	}
+	if m.PartialBlocks != nil {
+		var corrupted int
+		for _, err := range partial {
+			if errors.Cause(err) == block.ErrorSyncMetaCorrupted {
+				corrupted++
+			}
+		}
+		m.PartialBlocks.WithLabelValues(block.NoMeta).Set(float64(len(partial) - corrupted))
+		m.PartialBlocks.WithLabelValues(block.CorruptedMeta).Set(float64(corrupted))
+	}
+	if f, ok := fetcher.(filteredBlocksFetcher); ok && m.FilteredBlocks != nil {
+		m.FilteredBlocks.Reset()
+		for name, n := range f.FilteredBlocks() {
+			m.FilteredBlocks.WithLabelValues(name).Set(float64(n))
+		}
+	}
+}
+
 // NewMetaSyncer returns a new Syncer for the given Bucket and directory.
 // Blocks must be at least as old as the sync delay for being considered.
 func NewMetaSyncer(logger log.Logger, reg prometheus.Registerer, bkt objstore.Bucket, fetcher block.MetadataFetcher, duplicateBlocksFilter block.DeduplicateFilter, ignoreDeletionMarkFilter *block.IgnoreDeletionMarkFilter, blocksMarkedForDeletion, garbageCollectedBlocks prometheus.Counter, syncMetasTimeout time.Duration) (*Syncer, error) {
@@ -118,17 +215,22 @@ func NewMetaSyncerWithMetrics(logger log.Logger, metrics *SyncerMetrics, bkt obj
 		logger = log.NewNopLogger()
 	}
 	return &Syncer{
-		syncMetasTimeout:         syncMetasTimeout,
-		logger:                   logger,
-		bkt:                      bkt,
-		fetcher:                  fetcher,
-		blocks:                   map[ulid.ULID]*metadata.Meta{},
-		metrics:                  metrics,
-		duplicateBlocksFilter:    duplicateBlocksFilter,
-		ignoreDeletionMarkFilter: ignoreDeletionMarkFilter,
+		syncMetasTimeout: syncMetasTimeout,
+		logger:           logger,
+		bkt:              bkt,
+		fetcher:          fetcher,
+		blocks:           map[ulid.ULID]*metadata.Meta{},
+		metrics:          metrics,
+		garbagePolicy:    NewDuplicatesGarbagePolicy(duplicateBlocksFilter, ignoreDeletionMarkFilter),
 	}, nil
 }
 
+// SetGarbagePolicy sets the policy selecting the blocks marked for deletion on garbage collection, instead of the
+// duplicates found by the deduplicate filter.
+func (s *Syncer) SetGarbagePolicy(p GarbagePolicy) {
+	s.garbagePolicy = p
+}
+
 // UntilNextDownsampling calculates how long it will take until the next downsampling operation.
 // Returns an error if there will be no downsampling.
 func UntilNextDownsampling(m *metadata.Meta) (time.Duration, error) {
@@ -159,7 +261,15 @@ func (s *Syncer) SyncMetas(ctx context.Context) error {
 	}
 
 	container, err := s.g.Do("", func() (interface{}, error) {
-		metas, partial, err := s.fetcher.Fetch(ctx)
+		var (
+			metas   map[ulid.ULID]*metadata.Meta
+			partial map[ulid.ULID]error
+		)
+		err := tracing.DoInSpanWithErr(ctx, "compaction_sync_metas", func(ctx context.Context) (err error) {
+			metas, partial, err = s.fetcher.Fetch(ctx)
+			return err
+		})
+		s.metrics.observeMetaSync(s.fetcher, metas, partial, err)
 		return metasContainer{metas, partial}, err
 	})
 	if err != nil {
@@ -168,10 +278,25 @@ func (s *Syncer) SyncMetas(ctx context.Context) error {
 	s.mtx.Lock()
 	s.blocks = container.(metasContainer).metas
 	s.partial = container.(metasContainer).partial
+	s.restored = false
 	s.mtx.Unlock()
 	return nil
 }
 
+// syncMetasUnlessRestored syncs the metas, unless they were restored from a state snapshot and not synced since, to
+// start compacting right after a restart.
+func (s *Syncer) syncMetasUnlessRestored(ctx context.Context) error {
+	s.mtx.Lock()
+	restored := s.restored
+	s.restored = false
+	s.mtx.Unlock()
+	if restored {
+		level.Info(s.logger).Log("msg", "skipping sync of metas restored from the compactor state", "blocks", len(s.Metas()))
+		return nil
+	}
+	return s.SyncMetas(ctx)
+}
+
 // Partial returns partial blocks since last sync.
 func (s *Syncer) Partial() map[ulid.ULID]error {
 	s.mtx.Lock()
@@ -193,25 +318,30 @@ func (s *Syncer) Metas() map[ulid.ULID]*metadata.Meta {
 	return metas
 }
 
-// GarbageCollect marks blocks for deletion from bucket if their data is available as part of a
-// block with a higher compaction level.
+// GarbageCollect marks the blocks selected by the garbage policy for deletion from bucket, by default the blocks
+// whose data is available as part of a block with a higher compaction level.
 // Call to SyncMetas function is required to populate duplicateIDs in duplicateBlocksFilter.
 func (s *Syncer) GarbageCollect(ctx context.Context) error {
 	begin := time.Now()
 
-	// Ignore filter exists before deduplicate filter.
-	deletionMarkMap := s.ignoreDeletionMarkFilter.DeletionMarkBlocks()
-	duplicateIDs := s.duplicateBlocksFilter.DuplicateIDs()
+	if err := checkFenced(ctx, s.logger, s.bkt); err != nil {
+		return err
+	}
 
-	// GarbageIDs contains the duplicateIDs, since these blocks can be replaced with other blocks.
-	// We also remove ids present in deletionMarkMap since these blocks are already marked for deletion.
-	garbageIDs := []ulid.ULID{}
-	for _, id := range duplicateIDs {
-		if _, exists := deletionMarkMap[id]; exists {
-			continue
+	garbageIDs, err := s.garbagePolicy.GarbageBlocks(ctx, s.Metas())
+	if err != nil {
+		s.metrics.GarbageCollectionFailures.Inc()
+		return retry(errors.Wrap(err, "select garbage blocks"))
+	}
+	// The blocks selected by an interrupted garbage collection, e.g. before a restart, are marked first.
+	s.mtx.Lock()
+	for _, id := range garbageIDs {
+		if !slices.Contains(s.pendingGarbage, id) {
+			s.pendingGarbage = append(s.pendingGarbage, id)
 		}
-		garbageIDs = append(garbageIDs, id)
 	}
+	garbageIDs = slices.Clone(s.pendingGarbage)
+	s.mtx.Unlock()
 
 	for _, id := range garbageIDs {
 		if ctx.Err() != nil {
@@ -219,10 +349,12 @@ func (s *Syncer) GarbageCollect(ctx context.Context) error {
 		}
 
 		// Spawn a new context so we always mark a block for deletion in full on shutdown.
-		delCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		delCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Minute)
 
 		level.Info(s.logger).Log("msg", "marking outdated block for deletion", "block", id)
-		err := block.MarkForDeletion(delCtx, s.logger, s.bkt, id, "outdated block", s.metrics.BlocksMarkedForDeletion)
+		err := tracing.DoInSpanWithErr(delCtx, "compaction_garbage_collect_block", func(ctx context.Context) error {
+			return block.MarkForDeletion(ctx, s.logger, s.bkt, id, "outdated block", s.metrics.BlocksMarkedForDeletion)
+		}, opentracing.Tags{"block.id": id})
 		cancel()
 		if err != nil {
 			s.metrics.GarbageCollectionFailures.Inc()
@@ -233,6 +365,7 @@ func (s *Syncer) GarbageCollect(ctx context.Context) error {
 		// after running garbage collection.
 		s.mtx.Lock()
 		delete(s.blocks, id)
+		s.pendingGarbage = slices.DeleteFunc(s.pendingGarbage, func(p ulid.ULID) bool { return p == id })
 		s.mtx.Unlock()
 		s.metrics.GarbageCollectedBlocks.Inc()
 	}
@@ -261,12 +394,18 @@ type DefaultGrouper struct {
 	compactionRunsCompleted       *prometheus.CounterVec
 	compactionFailures            *prometheus.CounterVec
 	verticalCompactions           *prometheus.CounterVec
+	readBytes                     *prometheus.CounterVec
+	writtenBytes                  *prometheus.CounterVec
+	invalidSeries                 *prometheus.CounterVec
 	garbageCollectedBlocks        prometheus.Counter
 	blocksMarkedForDeletion       prometheus.Counter
 	blocksMarkedForNoCompact      prometheus.Counter
 	hashFunc                      metadata.HashFunc
 	blockFilesConcurrency         int
 	compactBlocksFetchConcurrency int
+	tenant                        string
+	labelMergePolicy              *LabelMergePolicy
+	compactionDisabledMarks       *GatherCompactionDisabledMarkFilter
 }
 
 // NewDefaultGrouper makes a new DefaultGrouper.
@@ -308,6 +447,18 @@ func NewDefaultGrouper(
 			Name: "thanos_compact_group_vertical_compactions_total",
 			Help: "Total number of group compaction attempts that resulted in a new block based on overlapping blocks.",
 		}, []string{"resolution"}),
+		readBytes: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_compact_group_compaction_read_bytes_total",
+			Help: "Total size of the blocks compacted by group compactions.",
+		}, []string{"resolution"}),
+		writtenBytes: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_compact_group_compaction_written_bytes_total",
+			Help: "Total size of the blocks written and uploaded by group compactions.",
+		}, []string{"resolution"}),
+		invalidSeries: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_compact_group_compaction_invalid_series_total",
+			Help: "Total number of series of the source blocks of group compactions violating the series validation, by reason.",
+		}, []string{"resolution", "reason"}),
 		blocksMarkedForNoCompact:      blocksMarkedForNoCompact,
 		garbageCollectedBlocks:        garbageCollectedBlocks,
 		blocksMarkedForDeletion:       blocksMarkedForDeletion,
@@ -354,18 +505,54 @@ func NewDefaultGrouperWithMetrics(
 	}
 }
 
+// SetTenantPrefix sets the object prefix of the tenant directory of the blocks in a tenant directory layout,
+// <tenant>/<block>. The groups belong to the tenant derived from the prefix.
+func (g *DefaultGrouper) SetTenantPrefix(prefix string) {
+	g.tenant = TenantFromPrefix(prefix)
+}
+
+// SetLabelMergePolicy makes the grouper group the blocks whose external labels differ only in the labels ignorable
+// by the policy, with the labels of the group rewritten by the policy.
+func (g *DefaultGrouper) SetLabelMergePolicy(p *LabelMergePolicy) {
+	g.labelMergePolicy = p
+}
+
+// SetCompactionDisabledMarks makes the grouper leave out the blocks whose external labels match the
+// compaction-disabled markers gathered by the filter, so that their groups are not compacted.
+func (g *DefaultGrouper) SetCompactionDisabledMarks(f *GatherCompactionDisabledMarkFilter) {
+	g.compactionDisabledMarks = f
+}
+
 // Groups returns the compaction groups for all blocks currently known to the syncer.
 // It creates all groups from the scratch on every call.
 func (g *DefaultGrouper) Groups(blocks map[ulid.ULID]*metadata.Meta) (res []*Group, err error) {
 	groups := map[string]*Group{}
+	disabled := map[string]struct{}{}
 	for _, m := range blocks {
 		groupKey := m.Thanos.GroupKey()
+		if g.compactionDisabledMarks != nil {
+			if _, ok := g.compactionDisabledMarks.CompactionDisabled(m.Thanos.Labels); ok {
+				disabled[groupKey] = struct{}{}
+				continue
+			}
+		}
+		lbls := labels.FromMap(m.Thanos.Labels)
+		if g.labelMergePolicy != nil {
+			groupKey = g.labelMergePolicy.GroupKey(&m.Thanos)
+			lbls = labels.FromMap(g.labelMergePolicy.Labels(m.Thanos.Labels))
+			if lbls.IsEmpty() {
+				return nil, errors.Errorf("block %s has no external labels left after merging labels", m.ULID)
+			}
+		}
 		group, ok := groups[groupKey]
 		if !ok {
-			lbls := labels.FromMap(m.Thanos.Labels)
 			resolutionLabel := m.Thanos.ResolutionString()
+			groupLogger := log.With(g.logger, "group", fmt.Sprintf("%s@%v", resolutionLabel, lbls.String()), "groupKey", groupKey)
+			if g.tenant != "" {
+				groupLogger = log.With(groupLogger, "tenant", g.tenant)
+			}
 			group, err = NewGroup(
-				log.With(g.logger, "group", fmt.Sprintf("%s@%v", resolutionLabel, lbls.String()), "groupKey", groupKey),
+				groupLogger,
 				g.bkt,
 				groupKey,
 				lbls,
@@ -387,6 +574,15 @@ func (g *DefaultGrouper) Groups(blocks map[ulid.ULID]*metadata.Meta) (res []*Gro
 			if err != nil {
 				return nil, errors.Wrap(err, "create compaction group")
 			}
+			group.tenant = g.tenant
+			group.labelMergePolicy = g.labelMergePolicy
+			if g.readBytes != nil {
+				group.readBytes = g.readBytes.WithLabelValues(resolutionLabel)
+				group.writtenBytes = g.writtenBytes.WithLabelValues(resolutionLabel)
+			}
+			if g.invalidSeries != nil {
+				group.invalidSeries = g.invalidSeries.MustCurryWith(prometheus.Labels{"resolution": resolutionLabel})
+			}
 			groups[groupKey] = group
 			res = append(res, group)
 		}
@@ -394,6 +590,9 @@ func (g *DefaultGrouper) Groups(blocks map[ulid.ULID]*metadata.Meta) (res []*Gro
 			return nil, errors.Wrap(err, "add compaction group")
 		}
 	}
+	if len(disabled) > 0 {
+		level.Info(g.logger).Log("msg", "compaction of groups disabled by compaction-disabled markers", "groups", len(disabled))
+	}
 	sort.Slice(res, func(i, j int) bool {
 		return res[i].Key() < res[j].Key()
 	})
@@ -404,6 +603,8 @@ func (g *DefaultGrouper) Groups(blocks map[ulid.ULID]*metadata.Meta) (res []*Gro
 // Those blocks generally contain the same series and can thus efficiently be compacted.
 type Group struct {
 	logger                        log.Logger
+	blockLogger                   log.Logger
+	jobID                         string
 	bkt                           objstore.Bucket
 	key                           string
 	labels                        labels.Labels
@@ -424,6 +625,24 @@ type Group struct {
 	blockFilesConcurrency         int
 	compactBlocksFetchConcurrency int
 	extensions                    any
+	checkpointing                 bool
+	shard                         metadata.Shard
+	routingDomain                 string
+	maxIndexSizeBytes             int64
+	exemplarsRetention            time.Duration
+	sortOutOfOrderChunks          bool
+	tenant                        string
+	labelMergePolicy              *LabelMergePolicy
+	// plan is the last plan of the compaction of the group, if any.
+	plan []ulid.ULID
+	// readBytes and writtenBytes count the size of the blocks compacted and uploaded, if set.
+	readBytes    prometheus.Counter
+	writtenBytes prometheus.Counter
+	// seriesValidation is the validation of the series of the compacted blocks, counted by invalidSeries if set.
+	seriesValidation SeriesValidation
+	invalidSeries    *prometheus.CounterVec
+	// resourceModel admits the compactions of the group and is fitted to their usage, if set.
+	resourceModel *ResourceModel
 }
 
 // NewGroup returns a new compaction group.
@@ -455,8 +674,15 @@ func NewGroup(
 		return nil, errors.Errorf("invalid concurrency level (%d), blockFilesConcurrency level must be > 0", blockFilesConcurrency)
 	}
 
+	// Groups are built for each compaction iteration, the ID identifies the compaction job of the group in logs,
+	// traces and errors.
+	jobID := middleware.NewRequestID()
+	logger = log.With(logger, logging.JobIDKey, jobID)
+
 	g := &Group{
 		logger:                        logger,
+		blockLogger:                   logging.NewSampledLogger(logger, blockLogSampleInterval, blockLogSampleBurst),
+		jobID:                         jobID,
 		bkt:                           bkt,
 		key:                           key,
 		labels:                        lset,
@@ -474,6 +700,7 @@ func NewGroup(
 		hashFunc:                      hashFunc,
 		blockFilesConcurrency:         blockFilesConcurrency,
 		compactBlocksFetchConcurrency: compactBlocksFetchConcurrency,
+		shard:                         metadata.Shard{Index: 0, Count: 1},
 	}
 	return g, nil
 }
@@ -483,17 +710,14 @@ func (cg *Group) Key() string {
 	return cg.key
 }
 
-func (cg *Group) deleteFromGroup(target map[ulid.ULID]struct{}) {
-	cg.mtx.Lock()
-	defer cg.mtx.Unlock()
-	var newGroupMeta []*metadata.Meta
-	for _, meta := range cg.metasByMinTime {
-		if _, found := target[meta.BlockMeta.ULID]; !found {
-			newGroupMeta = append(newGroupMeta, meta)
-		}
-	}
+// Tenant returns the tenant of the blocks of the group in a tenant directory layout, if any.
+func (cg *Group) Tenant() string {
+	return cg.tenant
+}
 
-	cg.metasByMinTime = newGroupMeta
+// JobID returns the unique ID of the compaction job of the group.
+func (cg *Group) JobID() string {
+	return cg.jobID
 }
 
 // AppendMeta the block with the given meta to the group.
@@ -501,12 +725,24 @@ func (cg *Group) AppendMeta(meta *metadata.Meta) error {
 	cg.mtx.Lock()
 	defer cg.mtx.Unlock()
 
-	if !labels.Equal(cg.labels, labels.FromMap(meta.Thanos.Labels)) {
+	lset := meta.Thanos.Labels
+	if cg.labelMergePolicy != nil {
+		lset = cg.labelMergePolicy.Labels(lset)
+	}
+	if !labels.Equal(cg.labels, labels.FromMap(lset)) {
 		return errors.New("block and group labels do not match")
 	}
 	if cg.resolution != meta.Thanos.Downsample.Resolution {
 		return errors.New("block and group resolution do not match")
 	}
+	if len(cg.metasByMinTime) == 0 {
+		cg.shard = meta.Thanos.ShardOrAll()
+		cg.routingDomain = meta.Thanos.RoutingDomain
+	} else if cg.shard != meta.Thanos.ShardOrAll() {
+		return errors.New("block and group shard do not match")
+	} else if cg.routingDomain != meta.Thanos.RoutingDomain {
+		return errors.New("block and group routing domain do not match")
+	}
 
 	cg.metasByMinTime = append(cg.metasByMinTime, meta)
 	sort.Slice(cg.metasByMinTime, func(i, j int) bool {
@@ -554,7 +790,8 @@ func (cg *Group) MaxTime() int64 {
 	return max
 }
 
-// Labels returns the labels that all blocks in the group share.
+// Labels returns the labels that all blocks in the group share, rewritten by the label merge policy of the grouper
+// if any. The blocks compacted by the group get these labels.
 func (cg *Group) Labels() labels.Labels {
 	return cg.labels
 }
@@ -583,16 +820,54 @@ type ProgressCalculator interface {
 	ProgressCalculate(ctx context.Context, groups []*Group) error
 }
 
-// CompactionProgressCalculator contains a planner and ProgressMetrics, which are updated during the compaction simulation process.
+// GroupOwnerFunc returns true if the compactor owns the group, when the groups are sharded across compactor replicas.
+type GroupOwnerFunc func(g *Group) bool
+
+// groupOwnership restricts the progress reported by a progress calculator to the groups owned by the compactor, so
+// that sharded compactors do not report the progress of the same groups.
+type groupOwnership struct {
+	owner GroupOwnerFunc
+}
+
+// SetGroupOwner makes the calculator report only the progress of the groups owned by the compactor. By default, the
+// progress of all groups is reported.
+func (o *groupOwnership) SetGroupOwner(owner GroupOwnerFunc) {
+	o.owner = owner
+}
+
+func (o *groupOwnership) owns(g *Group) bool {
+	return o.owner == nil || o.owner(g)
+}
+
+// PlannedCompaction is a compaction to be done, as simulated by the CompactionProgressCalculator.
+type PlannedCompaction struct {
+	Group string `json:"group"`
+	// Blocks are the blocks to compact, including the blocks to be produced by earlier planned compactions.
+	Blocks  []ulid.ULID `json:"blocks"`
+	Samples uint64      `json:"samples"`
+	// Round is the compactor iteration, counted from the next one, the compaction would be done in.
+	Round int `json:"round"`
+	// Prediction is the predicted usage of the compaction, nil until enough compactions of its resolution were
+	// observed.
+	Prediction *ResourcePrediction `json:"prediction,omitempty"`
+}
+
+// CompactionProgressCalculator contains a PlanSimulator and ProgressMetrics, which are updated during the compaction simulation process.
 type CompactionProgressCalculator struct {
-	planner Planner
+	simulator *PlanSimulator
 	*CompactProgressMetrics
+	groupOwnership
+
+	resourceModel      *ResourceModel
+	predictedDuration  prometheus.Gauge
+	predictedMaxMemory prometheus.Gauge
+	onPlanned          func([]PlannedCompaction)
 }
 
 // NewCompactProgressCalculator creates a new CompactionProgressCalculator.
 func NewCompactionProgressCalculator(reg prometheus.Registerer, planner *tsdbBasedPlanner) *CompactionProgressCalculator {
 	return &CompactionProgressCalculator{
-		planner: planner,
+		simulator: NewPlanSimulator(planner),
 		CompactProgressMetrics: &CompactProgressMetrics{
 			NumberOfCompactionRuns: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
 				Name: "thanos_compact_todo_compactions",
@@ -603,51 +878,45 @@ func NewCompactionProgressCalculator(reg prometheus.Registerer, planner *tsdbBas
 				Help: "number of blocks planned to be compacted",
 			}),
 		},
+		predictedDuration: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_compact_todo_compactions_predicted_duration_seconds",
+			Help: "Predicted duration of the compactions to be done, of those whose usage can be predicted.",
+		}),
+		predictedMaxMemory: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_compact_todo_compactions_predicted_max_memory_bytes",
+			Help: "Largest predicted memory of the compactions to be done, of those whose usage can be predicted.",
+		}),
 	}
 }
 
-// ProgressCalculate calculates the number of blocks and compaction runs in the planning process of the given groups.
-func (ps *CompactionProgressCalculator) ProgressCalculate(ctx context.Context, groups []*Group) error {
-	groupCompactions := make(map[string]int, len(groups))
-	groupBlocks := make(map[string]int, len(groups))
-
-	for len(groups) > 0 {
-		tmpGroups := make([]*Group, 0, len(groups))
-		for _, g := range groups {
-			if len(g.IDs()) == 1 {
-				continue
-			}
-			plan, err := ps.planner.Plan(ctx, g.metasByMinTime, nil, g.extensions)
-			if err != nil {
-				return errors.Wrapf(err, "could not plan")
-			}
-			if len(plan) == 0 {
-				continue
-			}
-			groupCompactions[g.key]++
-
-			toRemove := make(map[ulid.ULID]struct{}, len(plan))
-			metas := make([]*tsdb.BlockMeta, 0, len(plan))
-			for _, p := range plan {
-				metas = append(metas, &p.BlockMeta)
-				toRemove[p.BlockMeta.ULID] = struct{}{}
-			}
-			g.deleteFromGroup(toRemove)
-
-			groupBlocks[g.key] += len(plan)
+// SetResourceModel sets the model predicting the usage of the compactions to be done.
+func (ps *CompactionProgressCalculator) SetResourceModel(m *ResourceModel) {
+	ps.resourceModel = m
+}
 
-			if len(g.metasByMinTime) == 0 {
-				continue
-			}
+// UpdateOnPlanned registers a function called with the compactions to be done every time they are calculated.
+func (ps *CompactionProgressCalculator) UpdateOnPlanned(f func([]PlannedCompaction)) {
+	ps.onPlanned = f
+}
 
-			newMeta := tsdb.CompactBlockMetas(ulid.MustNew(uint64(time.Now().Unix()), nil), metas...)
-			if err := g.AppendMeta(&metadata.Meta{BlockMeta: *newMeta, Thanos: metadata.Thanos{Downsample: metadata.ThanosDownsample{Resolution: g.Resolution()}, Labels: g.Labels().Map()}}); err != nil {
-				return errors.Wrapf(err, "append meta")
-			}
-			tmpGroups = append(tmpGroups, g)
+// ProgressCalculate calculates the number of blocks and compaction runs in the planning process of the given groups.
+func (ps *CompactionProgressCalculator) ProgressCalculate(ctx context.Context, groups []*Group) error {
+	owned := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		if ps.owns(g) {
+			owned = append(owned, g)
 		}
+	}
+	compactions, err := ps.simulator.Simulate(ctx, owned)
+	if err != nil {
+		return errors.Wrapf(err, "could not plan")
+	}
 
-		groups = tmpGroups
+	groupCompactions := make(map[string]int, len(groups))
+	groupBlocks := make(map[string]int, len(groups))
+	for _, c := range compactions {
+		groupCompactions[c.Group]++
+		groupBlocks[c.Group] += len(c.Blocks)
 	}
 
 	ps.CompactProgressMetrics.NumberOfCompactionRuns.Set(0)
@@ -658,6 +927,29 @@ func (ps *CompactionProgressCalculator) ProgressCalculate(ctx context.Context, g
 		ps.CompactProgressMetrics.NumberOfCompactionBlocks.Add(float64(groupBlocks[key]))
 	}
 
+	var (
+		planned   = make([]PlannedCompaction, 0, len(compactions))
+		duration  time.Duration
+		maxMemory uint64
+	)
+	for _, c := range compactions {
+		pc := PlannedCompaction{Group: c.Group, Blocks: make([]ulid.ULID, 0, len(c.Blocks)), Round: c.Round}
+		for _, m := range c.Blocks {
+			pc.Blocks = append(pc.Blocks, m.ULID)
+			pc.Samples += m.Stats.NumSamples
+		}
+		if pred, ok := ps.resourceModel.Predict(c.Result.Thanos.Downsample.Resolution, pc.Samples); ok {
+			pc.Prediction = &pred
+			duration += pred.Duration
+			maxMemory = max(maxMemory, pred.MemoryBytes)
+		}
+		planned = append(planned, pc)
+	}
+	ps.predictedDuration.Set(duration.Seconds())
+	ps.predictedMaxMemory.Set(float64(maxMemory))
+	if ps.onPlanned != nil {
+		ps.onPlanned(planned)
+	}
 	return nil
 }
 
@@ -669,6 +961,7 @@ type DownsampleProgressMetrics struct {
 // DownsampleProgressCalculator contains DownsampleMetrics, which are updated during the downsampling simulation process.
 type DownsampleProgressCalculator struct {
 	*DownsampleProgressMetrics
+	groupOwnership
 }
 
 // NewDownsampleProgressCalculator creates a new DownsampleProgressCalculator.
@@ -683,7 +976,8 @@ func NewDownsampleProgressCalculator(reg prometheus.Registerer) *DownsampleProgr
 	}
 }
 
-// ProgressCalculate calculates the number of blocks to be downsampled for the given groups.
+// ProgressCalculate calculates the number of blocks to be downsampled for the given groups. The blocks already
+// downsampled are known from the downsampled blocks of all groups, including the groups not owned by the compactor.
 func (ds *DownsampleProgressCalculator) ProgressCalculate(ctx context.Context, groups []*Group) error {
 	sources5m := map[ulid.ULID]struct{}{}
 	sources1h := map[ulid.ULID]struct{}{}
@@ -710,6 +1004,9 @@ func (ds *DownsampleProgressCalculator) ProgressCalculate(ctx context.Context, g
 	}
 
 	for _, group := range groups {
+		if !ds.owns(group) {
+			continue
+		}
 		for _, m := range group.metasByMinTime {
 			switch m.Thanos.Downsample.Resolution {
 			case downsample.ResLevel0:
@@ -764,6 +1061,7 @@ type RetentionProgressMetrics struct {
 // RetentionProgressCalculator contains RetentionProgressMetrics, which are updated during the retention simulation process.
 type RetentionProgressCalculator struct {
 	*RetentionProgressMetrics
+	groupOwnership
 	retentionByResolution map[ResolutionLevel]time.Duration
 }
 
@@ -785,6 +1083,9 @@ func (rs *RetentionProgressCalculator) ProgressCalculate(ctx context.Context, gr
 	groupBlocks := make(map[string]int, len(groups))
 
 	for _, group := range groups {
+		if !rs.owns(group) {
+			continue
+		}
 		for _, m := range group.metasByMinTime {
 			retentionDuration := rs.retentionByResolution[ResolutionLevel(m.Thanos.Downsample.Resolution)]
 			if retentionDuration.Seconds() == 0 {
@@ -917,7 +1218,7 @@ func (cg *Group) Compact(ctx context.Context, dir string, planner Planner, comp
 	err := tracing.DoInSpanWithErr(ctx, "compaction_group", func(ctx context.Context) (err error) {
 		shouldRerun, compIDs, err = cg.compact(ctx, subDir, planner, comp, blockDeletableChecker, compactionLifecycleCallback, errChan)
 		return err
-	}, opentracing.Tags{"group.key": cg.Key()})
+	}, opentracing.Tags{"group.key": cg.Key(), "group.tenant": cg.tenant, "job.id": cg.jobID})
 	errChan <- err
 	close(errChan)
 	if err != nil {
@@ -1080,7 +1381,7 @@ func RepairIssue347(ctx context.Context, logger log.Logger, bkt objstore.Bucket,
 		return errors.Errorf("Given error is not an issue347 error: %v", issue347Err)
 	}
 
-	level.Info(logger).Log("msg", "Repairing block broken by https://github.com/prometheus/tsdb/issues/347", "id", ie.id, "err", issue347Err)
+	level.Info(logger).Log("msg", "Repairing block broken by https://github.com/prometheus/tsdb/issues/347", "block", ie.id, "err", issue347Err)
 
 	tmpdir, err := os.MkdirTemp("", fmt.Sprintf("repair-issue-347-id-%s-", ie.id))
 	if err != nil {
@@ -1113,15 +1414,15 @@ func RepairIssue347(ctx context.Context, logger log.Logger, bkt objstore.Bucket,
 		return errors.Wrapf(err, "repaired block is invalid %s", resid)
 	}
 
-	level.Info(logger).Log("msg", "uploading repaired block", "newID", resid)
+	level.Info(logger).Log("msg", "uploading repaired block", "block", ie.id, "result_block", resid)
 	if err = block.Upload(ctx, logger, bkt, filepath.Join(tmpdir, resid.String()), metadata.NoneFunc); err != nil {
 		return retry(errors.Wrapf(err, "upload of %s failed", resid))
 	}
 
-	level.Info(logger).Log("msg", "deleting broken block", "id", ie.id)
+	level.Info(logger).Log("msg", "deleting broken block", "block", ie.id)
 
 	// Spawn a new context so we always mark a block for deletion in full on shutdown.
-	delCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	delCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Minute)
 	defer cancel()
 
 	// TODO(bplotka): Issue with this will introduce overlap that will halt compactor. Automate that (fix duplicate overlaps caused by this).
@@ -1160,6 +1461,10 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 	}
 
 	level.Info(cg.logger).Log("msg", "compaction available and planned", "plan", fmt.Sprintf("%v", toCompact))
+	cg.plan = make([]ulid.ULID, 0, len(toCompact))
+	for _, m := range toCompact {
+		cg.plan = append(cg.plan, m.ULID)
+	}
 
 	// Once we have a plan we need to download the actual data.
 	groupCompactionBegin := time.Now()
@@ -1170,6 +1475,11 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 	}
 	level.Info(cg.logger).Log("msg", "finished running pre compaction callback; downloading blocks", "duration", time.Since(begin), "duration_ms", time.Since(begin).Milliseconds(), "plan", fmt.Sprintf("%v", toCompact))
 
+	var cp *checkpointer
+	if cg.checkpointing {
+		cp = readCheckpoint(cg.logger, dir, toCompact)
+	}
+
 	begin = time.Now()
 	g, errCtx := errgroup.WithContext(ctx)
 	g.SetLimit(cg.compactBlocksFetchConcurrency)
@@ -1179,13 +1489,17 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 		bdir := filepath.Join(dir, m.ULID.String())
 		func(ctx context.Context, meta *metadata.Meta) {
 			g.Go(func() error {
+				if cp.verified(meta.ULID) {
+					level.Debug(cg.blockLogger).Log("msg", "block verified before restart, skipping download", "block", meta.ULID.String())
+					return nil
+				}
 				start := time.Now()
 				if err := tracing.DoInSpanWithErr(ctx, "compaction_block_download", func(ctx context.Context) error {
-					return block.Download(ctx, cg.logger, cg.bkt, meta.ULID, bdir, objstore.WithFetchConcurrency(cg.blockFilesConcurrency))
+					return block.Download(ctx, cg.blockLogger, cg.bkt, meta.ULID, bdir, objstore.WithFetchConcurrency(cg.blockFilesConcurrency))
 				}, opentracing.Tags{"block.id": meta.ULID}); err != nil {
 					return retry(errors.Wrapf(err, "download block %s", meta.ULID))
 				}
-				level.Debug(cg.logger).Log("msg", "downloaded block", "block", meta.ULID.String(), "duration", time.Since(start), "duration_ms", time.Since(start).Milliseconds())
+				level.Debug(cg.blockLogger).Log("msg", "downloaded block", "block", meta.ULID.String(), "duration", time.Since(start), "duration_ms", time.Since(start).Milliseconds())
 
 				start = time.Now()
 				// Ensure all input blocks are valid.
@@ -1201,7 +1515,9 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 					return halt(errors.Wrapf(err, "block with not healthy index found %s; Compaction level %v; Labels: %v", bdir, meta.Compaction.Level, meta.Thanos.Labels))
 				}
 
-				if err := stats.OutOfOrderChunksErr(); err != nil {
+				if err := stats.OutOfOrderChunksErr(); err != nil && cg.sortOutOfOrderChunks {
+					level.Info(cg.logger).Log("msg", "block has out-of-order chunks, sorting them during compaction", "block", meta.ULID, "series", stats.OutOfOrderSeries, "chunks", stats.OutOfOrderChunks)
+				} else if err != nil {
 					return outOfOrderChunkError(errors.Wrapf(err, "blocks with out-of-order chunks are dropped from compaction:  %s", bdir), meta.ULID)
 				}
 
@@ -1213,8 +1529,8 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 					return errors.Wrapf(err,
 						"block id %s, try running with --debug.accept-malformed-index", meta.ULID)
 				}
-				level.Debug(cg.logger).Log("msg", "verified block", "block", meta.ULID.String(), "duration", time.Since(start), "duration_ms", time.Since(start).Milliseconds())
-				return nil
+				level.Debug(cg.blockLogger).Log("msg", "verified block", "block", meta.ULID.String(), "duration", time.Since(start), "duration_ms", time.Since(start).Milliseconds())
+				return errors.Wrapf(cp.markVerified(meta.ULID), "checkpoint verified block %s", meta.ULID)
 			})
 		}(errCtx, m)
 
@@ -1229,23 +1545,58 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 	level.Info(cg.logger).Log("msg", "downloaded and verified blocks; compacting blocks", "duration", time.Since(begin), "duration_ms", time.Since(begin).Milliseconds(), "plan", sourceBlockStr)
 
 	begin = time.Now()
-	var compIDs []ulid.ULID
-	if err := tracing.DoInSpanWithErr(ctx, "compaction", func(ctx context.Context) (e error) {
-		populateBlockFunc, e := compactionLifecycleCallback.GetBlockPopulator(ctx, cg.logger, cg)
-		if e != nil {
+	compIDs := cp.compacted()
+	resumed := len(compIDs) > 0
+	if resumed {
+		level.Info(cg.logger).Log("msg", "blocks compacted before restart, skipping compaction", "new", fmt.Sprintf("%v", compIDs))
+	} else {
+		tracked, err := cg.resourceModel.admit(ctx, cg.resolution, toCompact)
+		if err != nil {
+			return false, nil, errors.Wrap(err, "admit compaction")
+		}
+		begin = time.Now()
+		err = tracing.DoInSpanWithErr(ctx, "compaction", func(ctx context.Context) (e error) {
+			populateBlockFunc, e := compactionLifecycleCallback.GetBlockPopulator(ctx, cg.logger, cg)
+			if e != nil {
+				return e
+			}
+			if cg.sortOutOfOrderChunks {
+				populateBlockFunc = NewSortingBlockPopulator(populateBlockFunc)
+			}
+			if cg.seriesValidation.enabled() {
+				validator := newSeriesValidator(cg.seriesValidation, cg.invalidSeries)
+				defer validator.logViolations(cg.logger)
+				populateBlockFunc = validatingBlockPopulator{BlockPopulator: populateBlockFunc, v: validator}
+			}
+			shards, e := outputShards(toCompactDirs, cg.maxIndexSizeBytes)
+			if e != nil {
+				return e
+			}
+			if shards > 1 {
+				level.Info(cg.logger).Log("msg", "compacted block could exceed the maximum index size, sharding it by series", "shards", shards, "max_index_size", cg.maxIndexSizeBytes)
+				compIDs, e = cg.compactShards(dir, toCompactDirs, comp, populateBlockFunc, shards)
+				return e
+			}
+			compIDs, e = comp.CompactWithBlockPopulator(dir, toCompactDirs, nil, populateBlockFunc)
 			return e
+		})
+		tracked.done(err == nil)
+		if err != nil {
+			return false, nil, halt(errors.Wrapf(err, "compact blocks %v", toCompactDirs))
+		}
+		if err := cp.markCompacted(compIDs); err != nil {
+			return false, nil, errors.Wrap(err, "checkpoint compacted blocks")
+		}
+		for _, d := range toCompactDirs {
+			cg.countBlockBytes(cg.readBytes, d)
 		}
-		compIDs, e = comp.CompactWithBlockPopulator(dir, toCompactDirs, nil, populateBlockFunc)
-		return e
-	}); err != nil {
-		return false, nil, halt(errors.Wrapf(err, "compact blocks %v", toCompactDirs))
 	}
 	if len(compIDs) == 0 {
 		// No compacted blocks means all compacted blocks are of no sample.
 		level.Info(cg.logger).Log("msg", "no compacted blocks, deleting source blocks", "blocks", sourceBlockStr)
 		for _, meta := range toCompact {
 			if meta.Stats.NumSamples == 0 {
-				if err := cg.deleteBlock(meta.ULID, filepath.Join(dir, meta.ULID.String()), blockDeletableChecker); err != nil {
+				if err := cg.deleteBlock(ctx, meta.ULID, filepath.Join(dir, meta.ULID.String()), blockDeletableChecker); err != nil {
 					level.Warn(cg.logger).Log("msg", "failed to mark for deletion an empty block found during compaction", "block", meta.ULID)
 				}
 			}
@@ -1266,10 +1617,14 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 		"duration", time.Since(begin), "duration_ms", time.Since(begin).Milliseconds(), "overlapping_blocks", overlappingBlocks, "blocks", sourceBlockStr)
 
 	for _, compID := range compIDs {
+		if cp.uploaded(compID) {
+			continue
+		}
 		bdir := filepath.Join(dir, compID.String())
 		index := filepath.Join(bdir, block.IndexFilename)
 
-		if err := os.Remove(filepath.Join(bdir, "tombstones")); err != nil {
+		// Resumed blocks have no tombstones anymore.
+		if err := os.Remove(filepath.Join(bdir, "tombstones")); err != nil && !(resumed && os.IsNotExist(err)) {
 			return false, nil, errors.Wrap(err, "remove tombstones")
 		}
 
@@ -1291,18 +1646,35 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 			return false, nil, halt(errors.Wrapf(err, "invalid result block %s", bdir))
 		}
 
+		// The extensions of the group, set by custom groupers, take precedence over the ones of the compacted blocks.
+		extensions := cg.extensions
+		if extensions == nil {
+			extensions, err = metadata.MergeExtensions(toCompact)
+			if err != nil {
+				return false, nil, errors.Wrap(err, "merge extensions")
+			}
+		}
 		thanosMeta := metadata.Thanos{
 			Labels:       cg.labels.Map(),
 			Downsample:   metadata.ThanosDownsample{Resolution: cg.resolution},
 			Source:       metadata.CompactorSource,
 			SegmentFiles: block.GetSegmentFiles(bdir),
-			Extensions:   cg.extensions,
+			Annotations:  metadata.MergeAnnotations(toCompact),
+			Extensions:   extensions,
+			IndexStats:   stats.IndexStats(),
+			// Blocks compacted into shards have their shard recorded already.
+			Shard:         newMeta.Thanos.Shard,
+			Provenance:    metadata.MergeProvenance(toCompact),
+			RoutingDomain: cg.routingDomain,
 		}
-		if stats.ChunkMaxSize > 0 {
-			thanosMeta.IndexStats.ChunkMaxSize = stats.ChunkMaxSize
+		if thanosMeta.Shard == nil && cg.shard.Count > 1 {
+			thanosMeta.Shard = &cg.shard
 		}
-		if stats.SeriesMaxSize > 0 {
-			thanosMeta.IndexStats.SeriesMaxSize = stats.SeriesMaxSize
+		if err := cg.mergeExemplars(dir, bdir, toCompact, newMeta, thanosMeta.Shard); err != nil {
+			return false, nil, errors.Wrapf(err, "merge exemplars into the block %s", bdir)
+		}
+		if err := mergeMetricMetadata(dir, bdir, toCompact); err != nil {
+			return false, nil, errors.Wrapf(err, "merge metric metadata into the block %s", bdir)
 		}
 		newMeta, err = metadata.InjectThanos(cg.logger, bdir, thanosMeta, nil)
 		if err != nil {
@@ -1320,16 +1692,20 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 
 		err = tracing.DoInSpanWithErr(ctx, "compaction_block_upload", func(ctx context.Context) error {
 			return block.Upload(ctx, cg.logger, cg.bkt, bdir, cg.hashFunc, objstore.WithUploadConcurrency(cg.blockFilesConcurrency))
-		})
+		}, opentracing.Tags{"block.id": compID})
 		if err != nil {
 			return false, nil, retry(errors.Wrapf(err, "upload of %s failed", compID))
 		}
 		level.Info(cg.logger).Log("msg", "uploaded block", "result_block", compID, "duration", time.Since(begin), "duration_ms", time.Since(begin).Milliseconds())
+		cg.countBlockBytes(cg.writtenBytes, bdir)
 		level.Info(cg.logger).Log("msg", "running post compaction callback", "result_block", compID)
 		if err := compactionLifecycleCallback.PostCompactionCallback(ctx, cg.logger, cg, compID); err != nil {
 			return false, nil, retry(errors.Wrapf(err, "failed to run post compaction callback for result block %s", compID))
 		}
 		level.Info(cg.logger).Log("msg", "finished running post compaction callback", "result_block", compID)
+		if err := cp.markUploaded(compID); err != nil {
+			return false, nil, errors.Wrapf(err, "checkpoint uploaded block %s", compID)
+		}
 	}
 
 	// Mark for deletion the blocks we just compacted from the group and bucket so they do not get included
@@ -1337,7 +1713,7 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 	// Eventually the block we just uploaded should get synced into the group again (including sync-delay).
 	for _, meta := range toCompact {
 		if err := tracing.DoInSpanWithErr(ctx, "compaction_block_delete", func(ctx context.Context) error {
-			return cg.deleteBlock(meta.ULID, filepath.Join(dir, meta.ULID.String()), blockDeletableChecker)
+			return cg.deleteBlock(ctx, meta.ULID, filepath.Join(dir, meta.ULID.String()), blockDeletableChecker)
 		}, opentracing.Tags{"block.id": meta.ULID}); err != nil {
 			return false, nil, retry(errors.Wrapf(err, "mark old block for deletion from bucket"))
 		}
@@ -1349,15 +1725,40 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 	return true, compIDs, nil
 }
 
-func (cg *Group) deleteBlock(id ulid.ULID, bdir string, blockDeletableChecker BlockDeletableChecker) error {
+// countBlockBytes adds the size of the block in the directory to the counter, if set.
+func (cg *Group) countBlockBytes(c prometheus.Counter, dir string) {
+	if c == nil {
+		return
+	}
+	size, err := block.DirSize(dir)
+	if err != nil {
+		level.Warn(cg.logger).Log("msg", "failed to get the size of the block", "dir", dir, "err", err)
+		return
+	}
+	c.Add(float64(size))
+}
+
+func (cg *Group) deleteBlock(ctx context.Context, id ulid.ULID, bdir string, blockDeletableChecker BlockDeletableChecker) error {
 	if err := os.RemoveAll(bdir); err != nil {
 		return errors.Wrapf(err, "remove old block dir %s", id)
 	}
 
 	if blockDeletableChecker.CanDelete(cg, id) {
 		// Spawn a new context so we always mark a block for deletion in full on shutdown.
-		delCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		delCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Minute)
 		defer cancel()
+
+		// Do not act on the decisions of a newer compactor run, e.g. started while this one still runs.
+		if err := checkFenced(delCtx, cg.logger, cg.bkt); err != nil {
+			return err
+		}
+		newer, err := noCompactMarkedByNewerRun(delCtx, cg.logger, cg.bkt, id)
+		if err != nil {
+			return err
+		}
+		if newer {
+			return halt(errors.Wrapf(ErrFenced, "block %s marked for no compaction by a newer compactor run", id))
+		}
 		level.Info(cg.logger).Log("msg", "marking compacted block for deletion", "old_block", id)
 		if err := block.MarkForDeletion(delCtx, cg.logger, cg.bkt, id, "source of compacted block", cg.blocksMarkedForDeletion); err != nil {
 			return errors.Wrapf(err, "mark block %s for deletion from bucket", id)
@@ -1379,6 +1780,26 @@ type BucketCompactor struct {
 	bkt                            objstore.Bucket
 	concurrency                    int
 	skipBlocksWithOutOfOrderChunks bool
+	activeTracker                  *activetracker.Tracker
+	adaptiveConcurrency            *AdaptiveConcurrency
+	checkpointing                  bool
+	maxIndexSizeBytes              int64
+	exemplarsRetention             time.Duration
+	sortOutOfOrderChunks           bool
+	seriesValidation               SeriesValidation
+	concurrencyPool                *ConcurrencyPool
+	resourceModel                  *ResourceModel
+
+	plansMtx sync.Mutex
+	// plans is the last plans of the groups whose compaction did not finish, by group key.
+	plans map[string][]ulid.ULID
+
+	replanMtx sync.Mutex
+	// replanGroups and replanBlocks are the keys of the groups and the blocks of the groups requested to be planned
+	// first by the next compaction pass.
+	replanGroups    map[string]struct{}
+	replanBlocks    map[ulid.ULID]struct{}
+	replanRequested chan struct{}
 }
 
 // NewBucketCompactor creates a new bucket compactor.
@@ -1439,9 +1860,67 @@ func NewBucketCompactorWithCheckerAndCallback(
 		bkt:                            bkt,
 		concurrency:                    concurrency,
 		skipBlocksWithOutOfOrderChunks: skipBlocksWithOutOfOrderChunks,
+		replanRequested:                make(chan struct{}, 1),
 	}, nil
 }
 
+// SetActiveTracker sets a tracker recording the group compactions in flight, to log the compactions that did
+// not finish, e.g. because the compactor ran out of memory, on the next start.
+func (c *BucketCompactor) SetActiveTracker(tracker *activetracker.Tracker) {
+	c.activeTracker = tracker
+}
+
+// SetAdaptiveConcurrency sets a controller adjusting the number of groups compacted concurrently, up to the
+// concurrency of the compactor, and the concurrency of their downloads and uploads at runtime. Its bucket must
+// wrap the bucket of the Grouper.
+func (c *BucketCompactor) SetAdaptiveConcurrency(a *AdaptiveConcurrency) {
+	c.adaptiveConcurrency = a
+}
+
+// SetCheckpointing sets whether the progress of group compactions is checkpointed in their work directories, so
+// that a restarted compactor resumes the compaction of the same plan instead of starting over.
+func (c *BucketCompactor) SetCheckpointing(enabled bool) {
+	c.checkpointing = enabled
+}
+
+// SetOutputSharding sets the maximum index size of compacted blocks, above which the compaction of a plan is split
+// into blocks of shards of its series by their label hash instead. Zero disables the sharding.
+func (c *BucketCompactor) SetOutputSharding(maxIndexSizeBytes int64) {
+	c.maxIndexSizeBytes = maxIndexSizeBytes
+}
+
+// SetExemplarsRetention sets how long the exemplars merged into compacted blocks are kept, relative to the time of
+// the compaction. Zero keeps all the exemplars of the compacted blocks.
+func (c *BucketCompactor) SetExemplarsRetention(retention time.Duration) {
+	c.exemplarsRetention = retention
+}
+
+// SetSortOutOfOrderChunks sets whether blocks with out-of-order chunks, e.g. written by TSDBs with an out-of-order
+// time window, are compacted with their chunks sorted by a SortingBlockPopulator, instead of halting the compaction or
+// being marked for no compaction.
+func (c *BucketCompactor) SetSortOutOfOrderChunks(enabled bool) {
+	c.sortOutOfOrderChunks = enabled
+}
+
+// SetSeriesValidation sets the constraints the series of the source blocks of compactions are validated against. The
+// violating series are counted by thanos_compact_group_compaction_invalid_series_total, and dropped from the
+// compacted blocks if configured to.
+func (c *BucketCompactor) SetSeriesValidation(v SeriesValidation) {
+	c.seriesValidation = v
+}
+
+// SetConcurrencyPool sets a pool limiting the number of groups compacted concurrently together with the other
+// compactors sharing it, e.g. compacting other buckets.
+func (c *BucketCompactor) SetConcurrencyPool(p *ConcurrencyPool) {
+	c.concurrencyPool = p
+}
+
+// SetResourceModel sets the model fitted to the usage of the compactions of the groups, admitting them once planned
+// within its memory budget.
+func (c *BucketCompactor) SetResourceModel(m *ResourceModel) {
+	c.resourceModel = m
+}
+
 // Compact runs compaction over bucket.
 func (c *BucketCompactor) Compact(ctx context.Context) (rerr error) {
 	defer func() {
@@ -1475,7 +1954,23 @@ func (c *BucketCompactor) Compact(ctx context.Context) (rerr error) {
 			go func() {
 				defer wg.Done()
 				for g := range groupChan {
+					releasePool, err := c.concurrencyPool.acquire(workCtx)
+					if err != nil {
+						errChan <- errors.Wrapf(err, "group %s, job %s", g.Key(), g.JobID())
+						return
+					}
+					release, err := c.adaptiveConcurrency.acquire(workCtx, g)
+					if err != nil {
+						releasePool()
+						errChan <- errors.Wrapf(err, "group %s, job %s", g.Key(), g.JobID())
+						return
+					}
+					done := c.activeTracker.Insert(workCtx, fmt.Sprintf("group=%s job=%s blocks=%v", g.Key(), g.JobID(), g.IDs()))
 					shouldRerunGroup, _, err := g.Compact(workCtx, c.compactDir, c.planner, c.comp, c.blockDeletableChecker, c.compactionLifecycleCallback)
+					done()
+					release()
+					releasePool()
+					c.recordPlan(g, err)
 					if err == nil {
 						if shouldRerunGroup {
 							mtx.Lock()
@@ -1510,14 +2005,14 @@ func (c *BucketCompactor) Compact(ctx context.Context) (rerr error) {
 							continue
 						}
 					}
-					errChan <- errors.Wrapf(err, "group %s", g.Key())
+					errChan <- errors.Wrapf(err, "group %s, job %s", g.Key(), g.JobID())
 					return
 				}
 			}()
 		}
 
 		level.Info(c.logger).Log("msg", "start sync of metas")
-		if err := c.sy.SyncMetas(ctx); err != nil {
+		if err := c.sy.syncMetasUnlessRestored(ctx); err != nil {
 			return errors.Wrap(err, "sync")
 		}
 
@@ -1538,8 +2033,20 @@ func (c *BucketCompactor) Compact(ctx context.Context) (rerr error) {
 			for _, grID := range gr.IDs() {
 				ignoreDirs = append(ignoreDirs, filepath.Join(gr.Key(), grID.String()))
 			}
+			gr.maxIndexSizeBytes = c.maxIndexSizeBytes
+			gr.exemplarsRetention = c.exemplarsRetention
+			gr.sortOutOfOrderChunks = c.sortOutOfOrderChunks
+			gr.seriesValidation = c.seriesValidation
+			gr.resourceModel = c.resourceModel
+			if c.checkpointing {
+				gr.checkpointing = true
+				ignoreDirs = append(ignoreDirs, checkpointIgnoreDirs(c.logger, c.compactDir, gr.Key())...)
+			}
 		}
 
+		c.unfinishedPlansFirst(groups)
+		c.requestedFirst(groups)
+
 		if err := runutil.DeleteAll(c.compactDir, ignoreDirs...); err != nil {
 			level.Warn(c.logger).Log("msg", "failed deleting non-compaction group directories/files, some disk space usage might have leaked. Continuing", "err", err, "dir", c.compactDir)
 		}
@@ -1576,7 +2083,7 @@ func (c *BucketCompactor) Compact(ctx context.Context) (rerr error) {
 			return groupErrs.Err()
 		}
 
-		if finishedAllGroups {
+		if finishedAllGroups && !c.replanPending() {
 			break
 		}
 	}
@@ -1584,6 +2091,138 @@ func (c *BucketCompactor) Compact(ctx context.Context) (rerr error) {
 	return nil
 }
 
+// recordPlan records the last plan of the group if its compaction failed, e.g. because the compactor shut down, and
+// forgets it otherwise.
+func (c *BucketCompactor) recordPlan(g *Group, err error) {
+	c.plansMtx.Lock()
+	defer c.plansMtx.Unlock()
+	if err == nil || len(g.plan) == 0 {
+		delete(c.plans, g.Key())
+		return
+	}
+	if c.plans == nil {
+		c.plans = map[string][]ulid.ULID{}
+	}
+	c.plans[g.Key()] = g.plan
+}
+
+// RequestReplan requests the groups with the given keys, and the groups with any of the given blocks, to be planned
+// again before the other groups by the next compaction pass, e.g. after repairing their blocks or removing their
+// markers. A compaction in progress runs another pass for them, and the compactor waiting for its next iteration is
+// notified by ReplanRequested.
+func (c *BucketCompactor) RequestReplan(groupKeys []string, ids []ulid.ULID) {
+	c.replanMtx.Lock()
+	if c.replanGroups == nil {
+		c.replanGroups = map[string]struct{}{}
+	}
+	if c.replanBlocks == nil {
+		c.replanBlocks = map[ulid.ULID]struct{}{}
+	}
+	for _, k := range groupKeys {
+		c.replanGroups[k] = struct{}{}
+	}
+	for _, id := range ids {
+		c.replanBlocks[id] = struct{}{}
+	}
+	c.replanMtx.Unlock()
+
+	select {
+	case c.replanRequested <- struct{}{}:
+	default:
+	}
+}
+
+// ReplanRequested returns a channel receiving a value when groups were requested to be planned again, to start the
+// next compaction without waiting.
+func (c *BucketCompactor) ReplanRequested() <-chan struct{} {
+	return c.replanRequested
+}
+
+func (c *BucketCompactor) replanPending() bool {
+	c.replanMtx.Lock()
+	defer c.replanMtx.Unlock()
+	return len(c.replanGroups) > 0 || len(c.replanBlocks) > 0
+}
+
+// requestedFirst moves the groups requested to be planned again to the front, keeping the order of the groups
+// otherwise, and forgets the requests.
+func (c *BucketCompactor) requestedFirst(groups []*Group) {
+	c.replanMtx.Lock()
+	keys, ids := c.replanGroups, c.replanBlocks
+	c.replanGroups, c.replanBlocks = nil, nil
+	c.replanMtx.Unlock()
+	// The requests are handled by this pass.
+	select {
+	case <-c.replanRequested:
+	default:
+	}
+	if len(keys) == 0 && len(ids) == 0 {
+		return
+	}
+
+	requested := func(g *Group) bool {
+		if _, ok := keys[g.Key()]; ok {
+			return true
+		}
+		for _, id := range g.IDs() {
+			if _, ok := ids[id]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	var n int
+	for _, g := range groups {
+		if requested(g) {
+			n++
+		}
+	}
+	level.Info(c.logger).Log("msg", "planning the groups requested to be planned again first", "groups", n, "requested_groups", len(keys), "requested_blocks", len(ids))
+	slices.SortStableFunc(groups, func(a, b *Group) int {
+		ra, rb := requested(a), requested(b)
+		switch {
+		case ra && !rb:
+			return -1
+		case !ra && rb:
+			return 1
+		}
+		return 0
+	})
+}
+
+// unfinishedPlansFirst moves the groups with all the blocks of their unfinished plan to the front, keeping the order
+// of the groups otherwise, so that the interrupted compactions are resumed first.
+func (c *BucketCompactor) unfinishedPlansFirst(groups []*Group) {
+	c.plansMtx.Lock()
+	defer c.plansMtx.Unlock()
+	if len(c.plans) == 0 {
+		return
+	}
+	unfinished := func(g *Group) bool {
+		plan, ok := c.plans[g.Key()]
+		if !ok {
+			return false
+		}
+		ids := g.IDs()
+		for _, id := range plan {
+			if !slices.Contains(ids, id) {
+				return false
+			}
+		}
+		return true
+	}
+	slices.SortStableFunc(groups, func(a, b *Group) int {
+		ua, ub := unfinished(a), unfinished(b)
+		switch {
+		case ua && !ub:
+			return -1
+		case !ua && ub:
+			return 1
+		}
+		return 0
+	})
+}
+
 var _ block.MetadataFilter = &GatherNoCompactionMarkFilter{}
 
 // GatherNoCompactionMarkFilter is a block.Fetcher filter that passes all metas. While doing it, it gathers all no-compact-mark.json markers.
@@ -1655,6 +2294,11 @@ func (f *GatherNoCompactionMarkFilter) Filter(ctx context.Context, metas map[uli
 					continue
 				}
 
+				if tr := m.TimeRange; tr != nil && tr.MinTime >= tr.MaxTime {
+					level.Warn(f.logger).Log("msg", "found no-compact-mark.json with an empty time range; excluding the block from all compactions", "block", id, "min_time", tr.MinTime, "max_time", tr.MaxTime)
+					m.TimeRange = nil
+				}
+
 				localNoCompactMapMtx.Lock()
 				noCompactMarkedMap[id] = m
 				localNoCompactMapMtx.Unlock()
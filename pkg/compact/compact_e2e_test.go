@@ -34,6 +34,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/dedup"
 	"github.com/thanos-io/thanos/pkg/logutil"
 	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+	"github.com/thanos-io/thanos/pkg/testutil/faultbucket"
 )
 
 const fetcherConcurrency = 32
@@ -332,6 +333,8 @@ func testGroupCompactE2e(t *testing.T, mergeFunc storage.VerticalChunkSeriesMerg
 		testutil.Equals(t, 2, MetricCount(grouper.compactionFailures))
 		testutil.Equals(t, 0.0, promtest.ToFloat64(grouper.compactionFailures.WithLabelValues(metas[0].Thanos.ResolutionString())))
 		testutil.Equals(t, 0.0, promtest.ToFloat64(grouper.compactionFailures.WithLabelValues(metas[5].Thanos.ResolutionString())))
+		testutil.Assert(t, promtest.ToFloat64(grouper.readBytes.WithLabelValues(metas[0].Thanos.ResolutionString())) > 0)
+		testutil.Assert(t, promtest.ToFloat64(grouper.writtenBytes.WithLabelValues(metas[0].Thanos.ResolutionString())) > 0)
 
 		_, err = os.Stat(dir)
 		testutil.Assert(t, os.IsNotExist(err), "dir %s should be remove after compaction.", dir)
@@ -403,10 +406,119 @@ func testGroupCompactE2e(t *testing.T, mergeFunc storage.VerticalChunkSeriesMerg
 			testutil.Assert(t, len(meta.Thanos.SegmentFiles) > 0, "compacted blocks have segment files set")
 			// Only one chunk will be generated in that block, so we won't set chunk size.
 			testutil.Assert(t, meta.Thanos.IndexStats.SeriesMaxSize > 0, "compacted blocks have index stats series max size set")
+			testutil.Equals(t, int64(meta.Stats.NumSeries), meta.Thanos.IndexStats.SeriesCount)
+			testutil.Equals(t, int64(meta.Stats.NumChunks), meta.Thanos.IndexStats.ChunkCount)
 		}
 	})
 }
 
+func TestGroupCompactWithFaults(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	logger := log.NewNopLogger()
+	bkt := faultbucket.NewBucket(objstore.NewInMemBucket(), faultbucket.WithSeed(1))
+	insBkt := objstore.WithNoopInstr(bkt)
+
+	ignoreDeletionMarkFilter := block.NewIgnoreDeletionMarkFilter(logger, insBkt, 48*time.Hour, fetcherConcurrency)
+	duplicateBlocksFilter := block.NewDeduplicateFilter(fetcherConcurrency)
+	metaFetcher, err := block.NewMetaFetcher(nil, 32, insBkt, block.NewConcurrentLister(logger, insBkt), "", nil, []block.MetadataFilter{
+		ignoreDeletionMarkFilter,
+		duplicateBlocksFilter,
+	})
+	testutil.Ok(t, err)
+
+	blocksMarkedForDeletion := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+	sy, err := NewMetaSyncer(nil, nil, bkt, metaFetcher, duplicateBlocksFilter, ignoreDeletionMarkFilter, blocksMarkedForDeletion, promauto.With(nil).NewCounter(prometheus.CounterOpts{}), 0)
+	testutil.Ok(t, err)
+	comp, err := tsdb.NewLeveledCompactor(ctx, nil, logutil.GoKitLogToSlog(logger), []int64{1000, 3000}, nil, nil)
+	testutil.Ok(t, err)
+	grouper := NewDefaultGrouper(logger, bkt, false, false, nil, blocksMarkedForDeletion, promauto.With(nil).NewCounter(prometheus.CounterOpts{}), promauto.With(nil).NewCounter(prometheus.CounterOpts{}), metadata.NoneFunc, 10, 10)
+	planner := NewPlanner(logger, []int64{1000, 3000}, NewGatherNoCompactionMarkFilter(logger, insBkt, 2))
+	bComp, err := NewBucketCompactor(logger, sy, grouper, planner, comp, t.TempDir(), bkt, 1, true)
+	testutil.Ok(t, err)
+
+	extLset := labels.FromStrings("e1", "1")
+	metas := createAndUpload(t, bkt, []blockgenSpec{
+		{numSamples: 100, mint: 0, maxt: 1000, extLset: extLset, res: 124, series: []labels.Labels{labels.FromStrings("a", "1")}},
+		{numSamples: 100, mint: 1000, maxt: 2000, extLset: extLset, res: 124, series: []labels.Labels{labels.FromStrings("a", "2")}},
+		{numSamples: 100, mint: 2000, maxt: 3000, extLset: extLset, res: 124, series: []labels.Labels{labels.FromStrings("a", "3")}},
+		{numSamples: 100, mint: 3000, maxt: 4000, extLset: extLset, res: 124, series: []labels.Labels{labels.FromStrings("a", "4")}},
+	})
+	countBlocks := func() int {
+		t.Helper()
+
+		n := 0
+		testutil.Ok(t, bkt.Iter(ctx, "", func(name string) error {
+			if _, ok := block.IsBlockDir(name); ok {
+				n++
+			}
+			return nil
+		}))
+		return n
+	}
+
+	for _, tcase := range []struct {
+		name  string
+		fault faultbucket.Fault
+	}{
+		{
+			name:  "upload of the compacted block fails",
+			fault: faultbucket.FailUploadsAfter(faultbucket.MatchSuffix("/index"), 100),
+		},
+		{
+			name:  "upload of the compacted block fails and leaves a partial object",
+			fault: faultbucket.Fault{Ops: []string{objstore.OpUpload}, Match: faultbucket.MatchSuffix("/index"), PartialUpload: &faultbucket.PartialUpload{AfterBytes: 100, Keep: true}},
+		},
+		{
+			name:  "download of a source block fails",
+			fault: faultbucket.FailReadsAfter(faultbucket.MatchSuffix("/chunks/000001"), 10),
+		},
+		{
+			name:  "download of a source block is silently truncated",
+			fault: faultbucket.Fault{Ops: []string{objstore.OpGet}, Match: faultbucket.MatchSuffix("/index"), PartialRead: &faultbucket.PartialRead{AfterBytes: 100}},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			bkt.Reset()
+			bkt.Inject(tcase.fault)
+
+			err := bComp.Compact(ctx)
+			testutil.NotOk(t, err)
+			testutil.Assert(t, bkt.Triggered() > 0, "fault not injected")
+			testutil.Assert(t, IsRetryError(err), "not a retry error: %v", err)
+			testutil.Assert(t, !IsHaltError(err), "halt error: %v", err)
+
+			// The sources are still there, and no compacted block is visible.
+			rem, err := listBlocksMarkedForDeletion(ctx, bkt)
+			testutil.Ok(t, err)
+			testutil.Equals(t, 0, len(rem))
+			testutil.Ok(t, sy.SyncMetas(ctx))
+			for _, m := range metas {
+				_, ok := sy.Metas()[m.ULID]
+				testutil.Assert(t, ok, "source block %s not synced", m.ULID)
+			}
+		})
+	}
+
+	// The sources of an uploaded compacted block are marked for deletion by the next compaction.
+	bkt.Reset()
+	bkt.Inject(faultbucket.FailOps(faultbucket.MatchSuffix(metadata.DeletionMarkFilename), objstore.OpUpload))
+	err = bComp.Compact(ctx)
+	testutil.NotOk(t, err)
+	testutil.Assert(t, IsRetryError(err), "not a retry error: %v", err)
+
+	// Once faults are gone, the compaction succeeds.
+	bkt.Reset()
+	testutil.Ok(t, bComp.Compact(ctx))
+	rem, err := listBlocksMarkedForDeletion(ctx, bkt)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, len(rem))
+	testutil.Assert(t, countBlocks() > len(metas), "compacted block not uploaded")
+}
+
 type blockgenSpec struct {
 	mint, maxt int64
 	series     []labels.Labels
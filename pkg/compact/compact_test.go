@@ -30,6 +30,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/errutil"
 	"github.com/thanos-io/thanos/pkg/extprom"
+	"github.com/thanos-io/thanos/pkg/model"
 )
 
 func TestHaltError(t *testing.T) {
@@ -132,6 +133,23 @@ func TestGroupKey(t *testing.T) {
 			},
 			expected: "0@16590761456214576373",
 		},
+		{
+			input: metadata.Thanos{
+				Labels:        map[string]string{"foo": "bar", "foo1": "bar2"},
+				Downsample:    metadata.ThanosDownsample{Resolution: 0},
+				RoutingDomain: "eu",
+			},
+			expected: "0@2124638872457683483@eu",
+		},
+		{
+			input: metadata.Thanos{
+				Labels:        map[string]string{"foo": "bar", "foo1": "bar2"},
+				Downsample:    metadata.ThanosDownsample{Resolution: 0},
+				Shard:         &metadata.Shard{Index: 1, Count: 2},
+				RoutingDomain: "eu",
+			},
+			expected: "0@2124638872457683483@eu@1_of_2",
+		},
 	} {
 		if ok := t.Run("", func(t *testing.T) {
 			testutil.Equals(t, tcase.expected, tcase.input.GroupKey())
@@ -369,6 +387,8 @@ func TestCompactProgressCalculate(t *testing.T) {
 	}
 
 	ps := NewCompactionProgressCalculator(reg, planner)
+	var planned []PlannedCompaction
+	ps.UpdateOnPlanned(func(p []PlannedCompaction) { planned = p })
 
 	var bkt objstore.Bucket
 	temp := promauto.With(reg).NewCounter(prometheus.CounterOpts{Name: "test_metric_for_group", Help: "this is a test metric for compact progress tests"})
@@ -443,6 +463,15 @@ func TestCompactProgressCalculate(t *testing.T) {
 			testutil.Ok(t, err)
 			testutil.Equals(t, tcase.expected.compactionBlocks, promtestutil.ToFloat64(metrics.NumberOfCompactionBlocks))
 			testutil.Equals(t, tcase.expected.compactionRuns, promtestutil.ToFloat64(metrics.NumberOfCompactionRuns))
+
+			// Without a resource model, the planned compactions are reported without prediction.
+			testutil.Equals(t, int(tcase.expected.compactionRuns), len(planned))
+			plannedBlocks := 0
+			for _, c := range planned {
+				testutil.Assert(t, c.Prediction == nil, "unexpected prediction")
+				plannedBlocks += len(c.Blocks)
+			}
+			testutil.Equals(t, int(tcase.expected.compactionBlocks), plannedBlocks)
 		}); !ok {
 			return
 		}
@@ -550,6 +579,74 @@ func TestDownsampleProgressCalculate(t *testing.T) {
 	}
 }
 
+func TestProgressCalculators_SetGroupOwner(t *testing.T) {
+	t.Parallel()
+
+	temp := promauto.With(nil).NewCounter(prometheus.CounterOpts{Name: "test_metric_for_group_owner"})
+	grouper := NewDefaultGrouper(log.NewNopLogger(), nil, false, false, nil, temp, temp, temp, "", 1, 1)
+	input := []*metadata.Meta{
+		createBlockMeta(1, 0, downsample.ResLevel1DownsampleRange, map[string]string{"a": "1"}, downsample.ResLevel0, []uint64{10}),
+		// Already downsampled into the 5m block of a group of the other replica.
+		createBlockMeta(2, 0, downsample.ResLevel1DownsampleRange, map[string]string{"b": "2"}, downsample.ResLevel0, []uint64{11}),
+		createBlockMeta(3, 0, downsample.ResLevel2DownsampleRange, map[string]string{"b": "2"}, downsample.ResLevel1, []uint64{11}),
+	}
+	blocks := make(map[ulid.ULID]*metadata.Meta, len(input))
+	for _, meta := range input {
+		blocks[meta.ULID] = meta
+	}
+
+	// Every replica reports the progress of its groups only, and the replicas report the progress of all groups.
+	for _, tcase := range []struct {
+		owner              GroupOwnerFunc
+		expectedDownsample float64
+		expectedRetention  float64
+	}{
+		{owner: nil, expectedDownsample: 2, expectedRetention: 2},
+		{owner: func(g *Group) bool { return g.Resolution() == downsample.ResLevel0 }, expectedDownsample: 1, expectedRetention: 2},
+		{owner: func(g *Group) bool { return g.Resolution() != downsample.ResLevel0 }, expectedDownsample: 1, expectedRetention: 0},
+	} {
+		ds := NewDownsampleProgressCalculator(nil)
+		ds.SetGroupOwner(tcase.owner)
+		groups, err := grouper.Groups(blocks)
+		testutil.Ok(t, err)
+		testutil.Ok(t, ds.ProgressCalculate(context.Background(), groups))
+		testutil.Equals(t, tcase.expectedDownsample, promtestutil.ToFloat64(ds.NumberOfBlocksDownsampled))
+
+		rs := NewRetentionProgressCalculator(nil, map[ResolutionLevel]time.Duration{ResolutionLevelRaw: time.Millisecond})
+		rs.SetGroupOwner(tcase.owner)
+		groups, err = grouper.Groups(blocks)
+		testutil.Ok(t, err)
+		testutil.Ok(t, rs.ProgressCalculate(context.Background(), groups))
+		testutil.Equals(t, tcase.expectedRetention, promtestutil.ToFloat64(rs.NumberOfBlocksToDelete))
+	}
+
+	// The groups not owned are not planned.
+	hours := func(h int64) int64 { return h * int64(time.Hour/time.Millisecond) }
+	blocks = map[ulid.ULID]*metadata.Meta{}
+	for i, lset := range []map[string]string{{"a": "1"}, {"b": "2"}} {
+		for j := range int64(3) {
+			meta := createBlockMeta(uint64(3*i)+uint64(j), hours(2*j), hours(2*j+2), lset, 0, []uint64{})
+			blocks[meta.ULID] = meta
+		}
+	}
+	planner := NewTSDBBasedPlanner(log.NewNopLogger(), []int64{hours(1), hours(4)})
+	for _, tcase := range []struct {
+		owner    GroupOwnerFunc
+		expected float64
+	}{
+		{owner: nil, expected: 2},
+		{owner: func(g *Group) bool { return g.Labels().Get("a") == "1" }, expected: 1},
+	} {
+		ps := NewCompactionProgressCalculator(nil, planner)
+		ps.SetGroupOwner(tcase.owner)
+		groups, err := grouper.Groups(blocks)
+		testutil.Ok(t, err)
+		testutil.Ok(t, ps.ProgressCalculate(context.Background(), groups))
+		testutil.Equals(t, tcase.expected, promtestutil.ToFloat64(ps.NumberOfCompactionRuns))
+		testutil.Equals(t, 2*tcase.expected, promtestutil.ToFloat64(ps.NumberOfCompactionBlocks))
+	}
+}
+
 func TestNoMarkFilterAtomic(t *testing.T) {
 	t.Parallel()
 
@@ -627,3 +724,110 @@ func TestNoMarkFilterAtomic(t *testing.T) {
 	})
 	testutil.Ok(t, g.Run())
 }
+
+func TestSyncer_SyncMetasMetrics(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	for i := range 3 {
+		var m metadata.Meta
+		m.Version = 1
+		m.ULID = ulid.MustNew(uint64(i+1), nil)
+		m.MinTime, m.MaxTime = int64(i)*1000, int64(i+1)*1000
+		var buf bytes.Buffer
+		testutil.Ok(t, json.NewEncoder(&buf).Encode(&m))
+		testutil.Ok(t, bkt.Upload(ctx, path.Join(m.ULID.String(), metadata.MetaFilename), &buf))
+	}
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(ulid.MustNew(4, nil).String(), block.IndexFilename), bytes.NewBufferString("index")))
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(ulid.MustNew(5, nil).String(), metadata.MetaFilename), bytes.NewBufferString("{ not a json")))
+
+	insBkt := objstore.WithNoopInstr(bkt)
+	// The first block ends before the minimum time.
+	mint, maxt := time.UnixMilli(1001), time.UnixMilli(5000)
+	timeFilter := block.NewTimePartitionMetaFilter(model.TimeOrDurationValue{Time: &mint}, model.TimeOrDurationValue{Time: &maxt})
+	metaFetcher, err := block.NewMetaFetcher(nil, 1, insBkt, block.NewConcurrentLister(nil, insBkt), "", nil, []block.MetadataFilter{timeFilter})
+	testutil.Ok(t, err)
+
+	reg := prometheus.NewRegistry()
+	counter := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+	sy, err := NewMetaSyncer(nil, reg, bkt, metaFetcher, block.NewDeduplicateFilter(1), block.NewIgnoreDeletionMarkFilter(nil, nil, 48*time.Hour, 1), counter, counter, 0)
+	testutil.Ok(t, err)
+
+	testutil.Ok(t, sy.SyncMetas(ctx))
+	m := sy.metrics
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(m.MetaSyncs))
+	testutil.Equals(t, 0.0, promtestutil.ToFloat64(m.MetaSyncFailures))
+	testutil.Equals(t, 2.0, promtestutil.ToFloat64(m.Metas))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(m.PartialBlocks.WithLabelValues(block.NoMeta)))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(m.PartialBlocks.WithLabelValues(block.CorruptedMeta)))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(m.FilteredBlocks.WithLabelValues("block.TimePartitionMetaFilter")))
+	mfs, err := reg.Gather()
+	testutil.Ok(t, err)
+	var staleness *float64
+	for _, mf := range mfs {
+		if mf.GetName() == "thanos_compact_syncer_seconds_since_last_successful_meta_sync" {
+			staleness = mf.GetMetric()[0].GetGauge().Value
+		}
+	}
+	testutil.Assert(t, staleness != nil && *staleness >= 0 && *staleness < 60, "staleness %v", staleness)
+
+	// A failed sync keeps the metrics of the last successful one.
+	last := m.lastSuccessfulMetaSync.Load()
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	testutil.NotOk(t, sy.SyncMetas(cancelCtx))
+	testutil.Equals(t, 2.0, promtestutil.ToFloat64(m.MetaSyncs))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(m.MetaSyncFailures))
+	testutil.Equals(t, 2.0, promtestutil.ToFloat64(m.Metas))
+	testutil.Equals(t, last, m.lastSuccessfulMetaSync.Load())
+}
+
+func TestBucketCompactor_RequestReplan(t *testing.T) {
+	t.Parallel()
+
+	newGroup := func(key string, ids ...ulid.ULID) *Group {
+		g := &Group{key: key}
+		for _, id := range ids {
+			g.metasByMinTime = append(g.metasByMinTime, &metadata.Meta{BlockMeta: tsdb.BlockMeta{ULID: id}})
+		}
+		return g
+	}
+	a, b, c := ulid.MustNew(1, nil), ulid.MustNew(2, nil), ulid.MustNew(3, nil)
+	bc, err := NewBucketCompactor(log.NewNopLogger(), nil, nil, nil, nil, "", nil, 1, false)
+	testutil.Ok(t, err)
+	testutil.Assert(t, !bc.replanPending())
+
+	bc.RequestReplan([]string{"0@4"}, nil)
+	bc.RequestReplan(nil, []ulid.ULID{b})
+	testutil.Assert(t, bc.replanPending())
+	select {
+	case <-bc.ReplanRequested():
+	default:
+		t.Fatal("expected replan to be requested")
+	}
+	bc.RequestReplan(nil, []ulid.ULID{b})
+
+	groups := []*Group{newGroup("0@1", a), newGroup("0@2", b), newGroup("0@3", a, c), newGroup("0@4", c)}
+	bc.requestedFirst(groups)
+
+	var keys []string
+	for _, g := range groups {
+		keys = append(keys, g.Key())
+	}
+	testutil.Equals(t, []string{"0@2", "0@4", "0@1", "0@3"}, keys)
+
+	// The requests are forgotten once handled.
+	testutil.Assert(t, !bc.replanPending())
+	select {
+	case <-bc.ReplanRequested():
+		t.Fatal("expected handled replan request to be drained")
+	default:
+	}
+	bc.requestedFirst(groups)
+	keys = keys[:0]
+	for _, g := range groups {
+		keys = append(keys, g.Key())
+	}
+	testutil.Equals(t, []string{"0@2", "0@4", "0@1", "0@3"}, keys)
+}
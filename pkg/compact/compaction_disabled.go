@@ -0,0 +1,170 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+const (
+	// CompactionDisabledMarksDir is the directory of the bucket, or of the tenant directory in a tenant directory
+	// layout, holding the compaction-disabled markers of groups of blocks, as <name>.json files.
+	CompactionDisabledMarksDir = metadata.CompactionDisabledMarksDir
+	// CompactionDisabledMarkVersion1 is the version of compaction-disabled markers supported by Thanos.
+	CompactionDisabledMarkVersion1 = 1
+)
+
+// CompactionDisabledMark marker disables the compaction of the groups of blocks whose external labels include all
+// its labels, e.g. of a tenant during an investigation, without marking their blocks one by one.
+type CompactionDisabledMark struct {
+	// Version of the file.
+	Version int `json:"version"`
+	// Labels are the external labels the groups must have. If empty, the compaction of all groups is disabled.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Details is a human readable string giving details of reason.
+	Details string `json:"details,omitempty"`
+	// DisabledTime is a unix timestamp of when the compaction was disabled.
+	DisabledTime int64 `json:"disabled_time"`
+}
+
+// Matches returns true if the external labels include all the labels of the marker.
+func (m *CompactionDisabledMark) Matches(lset map[string]string) bool {
+	for n, v := range m.Labels {
+		if lv, ok := lset[n]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+var _ block.MetadataFilter = &GatherCompactionDisabledMarkFilter{}
+
+// GatherCompactionDisabledMarkFilter is a block.Fetcher filter that passes all metas. While doing it, it gathers the
+// compaction-disabled markers of the bucket, for the grouper to leave out the blocks of the disabled groups.
+type GatherCompactionDisabledMarkFilter struct {
+	logger log.Logger
+	bkt    objstore.InstrumentedBucketReader
+
+	mtx   sync.Mutex
+	marks map[string]*CompactionDisabledMark
+}
+
+// NewGatherCompactionDisabledMarkFilter creates GatherCompactionDisabledMarkFilter.
+func NewGatherCompactionDisabledMarkFilter(logger log.Logger, bkt objstore.InstrumentedBucketReader) *GatherCompactionDisabledMarkFilter {
+	return &GatherCompactionDisabledMarkFilter{
+		logger: logger,
+		bkt:    bkt,
+	}
+}
+
+// CompactionDisabledMarks returns the compaction-disabled markers by object name.
+func (f *GatherCompactionDisabledMarkFilter) CompactionDisabledMarks() map[string]*CompactionDisabledMark {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	marks := make(map[string]*CompactionDisabledMark, len(f.marks))
+	for k, v := range f.marks {
+		marks[k] = v
+	}
+	return marks
+}
+
+// CompactionDisabled returns the name of the first marker disabling the compaction of the blocks with the external
+// labels, if any.
+func (f *GatherCompactionDisabledMarkFilter) CompactionDisabled(lset map[string]string) (string, bool) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	names := make([]string, 0, len(f.marks))
+	for name, m := range f.marks {
+		if m.Matches(lset) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+	sort.Strings(names)
+	return names[0], true
+}
+
+// Filter passes all metas, while gathering compaction-disabled markers.
+func (f *GatherCompactionDisabledMarkFilter) Filter(ctx context.Context, metas map[ulid.ULID]*metadata.Meta, synced block.GaugeVec, _ block.GaugeVec) error {
+	marks := map[string]*CompactionDisabledMark{}
+	if err := f.bkt.Iter(ctx, CompactionDisabledMarksDir, func(name string) error {
+		if !strings.HasSuffix(name, ".json") {
+			return nil
+		}
+		m, err := f.readMark(ctx, name)
+		if err != nil {
+			if errors.Cause(err) == metadata.ErrorUnmarshalMarker {
+				level.Warn(f.logger).Log("msg", "found partial compaction-disabled marker; if we will see it happening often for the same marker, consider manually deleting it from the object storage", "marker", name, "err", err)
+				return nil
+			}
+			return err
+		}
+		if m != nil {
+			marks[path.Base(name)] = m
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "gather compaction-disabled markers")
+	}
+
+	f.mtx.Lock()
+	f.marks = marks
+	f.mtx.Unlock()
+
+	if len(marks) == 0 {
+		return nil
+	}
+	for _, m := range metas {
+		if _, disabled := f.CompactionDisabled(m.Thanos.Labels); disabled {
+			synced.WithLabelValues(block.CompactionDisabledMeta).Inc()
+		}
+	}
+	return nil
+}
+
+// readMark reads the marker, or returns nil if it was removed.
+func (f *GatherCompactionDisabledMarkFilter) readMark(ctx context.Context, name string) (*CompactionDisabledMark, error) {
+	r, err := f.bkt.ReaderWithExpectedErrs(f.bkt.IsObjNotFoundErr).Get(ctx, name)
+	if err != nil {
+		if f.bkt.IsObjNotFoundErr(err) {
+			// Removed since listed.
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "get file: %s", name)
+	}
+	defer runutil.CloseWithLogOnErr(f.logger, r, "close compaction-disabled marker reader")
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read file: %s", name)
+	}
+	m := &CompactionDisabledMark{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, errors.Wrapf(metadata.ErrorUnmarshalMarker, "file: %s; err: %v", name, err.Error())
+	}
+	if m.Version != CompactionDisabledMarkVersion1 {
+		return nil, errors.Errorf("unexpected compaction-disabled marker %s version %d, expected %d", name, m.Version, CompactionDisabledMarkVersion1)
+	}
+	return m, nil
+}
@@ -0,0 +1,92 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/extprom"
+)
+
+func TestCompactionDisabledMark_Matches(t *testing.T) {
+	t.Parallel()
+
+	all := &CompactionDisabledMark{}
+	testutil.Assert(t, all.Matches(nil))
+	testutil.Assert(t, all.Matches(map[string]string{"tenant": "a"}))
+
+	tenant := &CompactionDisabledMark{Labels: map[string]string{"tenant": "a"}}
+	testutil.Assert(t, tenant.Matches(map[string]string{"tenant": "a", "replica": "r0"}))
+	testutil.Assert(t, !tenant.Matches(map[string]string{"tenant": "b"}))
+	testutil.Assert(t, !tenant.Matches(map[string]string{"replica": "r0"}))
+}
+
+func TestGatherCompactionDisabledMarkFilter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	upload := func(name string, m any) {
+		b, err := json.Marshal(m)
+		testutil.Ok(t, err)
+		testutil.Ok(t, bkt.Upload(ctx, path.Join(CompactionDisabledMarksDir, name), bytes.NewReader(b)))
+	}
+	upload("tenant-a.json", CompactionDisabledMark{Version: CompactionDisabledMarkVersion1, Labels: map[string]string{"tenant": "a"}, Details: "investigation"})
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(CompactionDisabledMarksDir, "partial.json"), bytes.NewReader([]byte("{"))))
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(CompactionDisabledMarksDir, "README"), bytes.NewReader(nil)))
+
+	newMeta := func(id uint64, lset map[string]string) *metadata.Meta {
+		m := &metadata.Meta{}
+		m.ULID = ulid.MustNew(id, nil)
+		m.Compaction.Sources = []ulid.ULID{m.ULID}
+		m.Thanos.Labels = lset
+		return m
+	}
+	a1, a2 := newMeta(1, map[string]string{"tenant": "a"}), newMeta(2, map[string]string{"tenant": "a"})
+	b1, b2 := newMeta(3, map[string]string{"tenant": "b"}), newMeta(4, map[string]string{"tenant": "b"})
+	metas := map[ulid.ULID]*metadata.Meta{a1.ULID: a1, a2.ULID: a2, b1.ULID: b1, b2.ULID: b2}
+
+	f := NewGatherCompactionDisabledMarkFilter(log.NewNopLogger(), objstore.WithNoopInstr(bkt))
+	synced := extprom.NewTxGaugeVec(nil, prometheus.GaugeOpts{}, []string{"state"})
+	testutil.Ok(t, f.Filter(ctx, metas, synced, nil))
+	// All metas are passed.
+	testutil.Equals(t, 4, len(metas))
+	testutil.Equals(t, 2.0, promtestutil.ToFloat64(synced.WithLabelValues(block.CompactionDisabledMeta)))
+	marks := f.CompactionDisabledMarks()
+	testutil.Equals(t, 1, len(marks))
+	testutil.Equals(t, "investigation", marks["tenant-a.json"].Details)
+
+	temp := promauto.With(nil).NewCounter(prometheus.CounterOpts{Name: "test_metric_for_compaction_disabled"})
+	grouper := NewDefaultGrouper(log.NewNopLogger(), bkt, false, false, nil, temp, temp, temp, "", 1, 1)
+	grouper.SetCompactionDisabledMarks(f)
+	groups, err := grouper.Groups(metas)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(groups))
+	testutil.Equals(t, []ulid.ULID{b1.ULID, b2.ULID}, groups[0].IDs())
+
+	// The groups are compacted again once the markers are removed.
+	testutil.Ok(t, bkt.Delete(ctx, path.Join(CompactionDisabledMarksDir, "tenant-a.json")))
+	testutil.Ok(t, f.Filter(ctx, metas, synced, nil))
+	groups, err = grouper.Groups(metas)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(groups))
+
+	// Markers of unknown versions fail the sync.
+	upload("v2.json", CompactionDisabledMark{Version: 2})
+	testutil.NotOk(t, f.Filter(ctx, metas, synced, nil))
+}
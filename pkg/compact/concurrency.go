@@ -0,0 +1,323 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"io"
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+const (
+	// concurrencySteps is the number of steps between no and the maximum concurrency. A step is added after
+	// every healthy interval.
+	concurrencySteps = 10
+	// memoryHighWatermark is the fraction of the memory limit above which the concurrency is halved, and
+	// memoryTargetWatermark the one above which it is not increased anymore.
+	memoryHighWatermark   = 0.9
+	memoryTargetWatermark = 0.75
+	// maxObjstoreErrorRatio is the ratio of failed object storage operations above which the concurrency is halved,
+	// once there have been at least minObjstoreOperations in the interval.
+	maxObjstoreErrorRatio = 0.05
+	minObjstoreOperations = 20
+	// minThroughputRatio is the ratio of the previous throughput below which an increase of the concurrency is
+	// taken back.
+	minThroughputRatio = 0.95
+)
+
+// ConcurrencyLimits are the numbers of goroutines compacting groups, and downloading the blocks of a group and
+// fetching and uploading the files of a block.
+type ConcurrencyLimits struct {
+	Concurrency                   int
+	BlockFilesConcurrency         int
+	CompactBlocksFetchConcurrency int
+}
+
+// scaled returns the limits scaled to the step, rounded up.
+func (l ConcurrencyLimits) scaled(step int) ConcurrencyLimits {
+	scale := func(n int) int {
+		return max(1, (n*step+concurrencySteps-1)/concurrencySteps)
+	}
+	return ConcurrencyLimits{
+		Concurrency:                   scale(l.Concurrency),
+		BlockFilesConcurrency:         scale(l.BlockFilesConcurrency),
+		CompactBlocksFetchConcurrency: scale(l.CompactBlocksFetchConcurrency),
+	}
+}
+
+// AdaptiveConcurrency adjusts the concurrency of the group compactions of a BucketCompactor between one and
+// the configured maximum, based on the memory usage, the throughput of the block files transferred between the
+// object storage and the compaction directory, and the error rate of the object storage. It starts low and
+// increases the concurrency additively while the compactor is healthy, halves it on memory pressure or object
+// storage errors, and steps back when more concurrency did not increase the throughput, e.g. because the disk
+// or network is saturated.
+type AdaptiveConcurrency struct {
+	logger      log.Logger
+	max         ConcurrencyLimits
+	memoryLimit uint64
+	memoryUsage func() uint64
+
+	ops, errs, bytes atomic.Int64
+
+	mtx            sync.Mutex
+	step           int
+	limits         ConcurrencyLimits
+	inFlight       int
+	wake           chan struct{}
+	increased      bool
+	lastThroughput float64
+	lastUpdate     time.Time
+
+	limit *prometheus.GaugeVec
+}
+
+// NewAdaptiveConcurrency returns a controller adjusting the concurrency up to max. A memoryLimit of 0 uses the Go
+// memory limit, e.g. set by GOMEMLIMIT or --enable-auto-gomemlimit. Without either the memory usage is not
+// considered.
+func NewAdaptiveConcurrency(logger log.Logger, reg prometheus.Registerer, max ConcurrencyLimits, memoryLimit uint64) *AdaptiveConcurrency {
+	if memoryLimit == 0 {
+		if l := debug.SetMemoryLimit(-1); l != math.MaxInt64 {
+			memoryLimit = uint64(l)
+		}
+	}
+	c := &AdaptiveConcurrency{
+		logger:      logger,
+		max:         max,
+		memoryLimit: memoryLimit,
+		memoryUsage: goMemoryUsage,
+		step:        1,
+		wake:        make(chan struct{}),
+		lastUpdate:  time.Now(),
+		limit: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_compact_adaptive_concurrency",
+			Help: "Concurrency set by the adaptive concurrency controller, by limit: concurrency, blocks_fetch or block_files.",
+		}, []string{"limit"}),
+	}
+	c.setLimits(max.scaled(c.step))
+	return c
+}
+
+// Limits returns the current limits.
+func (c *AdaptiveConcurrency) Limits() ConcurrencyLimits {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.limits
+}
+
+// setLimits must be called with the mutex held, or before the controller is used.
+func (c *AdaptiveConcurrency) setLimits(l ConcurrencyLimits) {
+	c.limits = l
+	c.limit.WithLabelValues("concurrency").Set(float64(l.Concurrency))
+	c.limit.WithLabelValues("blocks_fetch").Set(float64(l.CompactBlocksFetchConcurrency))
+	c.limit.WithLabelValues("block_files").Set(float64(l.BlockFilesConcurrency))
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
+
+// acquire waits until another group can be compacted and sets the concurrency of the group to the current
+// limits. The returned function must be called once the group compaction is done. A nil controller does not
+// limit anything.
+func (c *AdaptiveConcurrency) acquire(ctx context.Context, g *Group) (func(), error) {
+	if c == nil {
+		return func() {}, nil
+	}
+	for {
+		c.mtx.Lock()
+		if c.inFlight < c.limits.Concurrency {
+			c.inFlight++
+			g.blockFilesConcurrency = c.limits.BlockFilesConcurrency
+			g.compactBlocksFetchConcurrency = c.limits.CompactBlocksFetchConcurrency
+			c.mtx.Unlock()
+			return c.release, nil
+		}
+		wake := c.wake
+		c.mtx.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *AdaptiveConcurrency) release() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.inFlight--
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
+
+// Run adjusts the concurrency every interval until the context is canceled.
+func (c *AdaptiveConcurrency) Run(ctx context.Context, interval time.Duration) error {
+	return runutil.Repeat(interval, ctx.Done(), func() error {
+		c.update(time.Now())
+		return nil
+	})
+}
+
+func (c *AdaptiveConcurrency) update(now time.Time) {
+	var (
+		ops        = c.ops.Swap(0)
+		errs       = c.errs.Swap(0)
+		bytes      = c.bytes.Swap(0)
+		memory     = c.memoryUsage()
+		memoryUsed float64
+	)
+	if c.memoryLimit > 0 {
+		memoryUsed = float64(memory) / float64(c.memoryLimit)
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	throughput := float64(bytes) / now.Sub(c.lastUpdate).Seconds()
+	c.lastUpdate = now
+
+	step, reason := c.step, ""
+	switch {
+	case memoryUsed > memoryHighWatermark:
+		step, reason = step/2, "memory pressure"
+	case ops >= minObjstoreOperations && float64(errs)/float64(ops) > maxObjstoreErrorRatio:
+		step, reason = step/2, "object storage errors"
+	case c.increased && c.inFlight > 0 && throughput < c.lastThroughput*minThroughputRatio:
+		step, reason = step-1, "throughput saturated"
+	case memoryUsed < memoryTargetWatermark && c.inFlight >= c.limits.Concurrency:
+		// Only increase when all the allowed groups are compacted, as it would not be observed otherwise.
+		step, reason = step+1, "healthy"
+	}
+	step = min(concurrencySteps, max(1, step))
+	c.increased = step > c.step
+	c.lastThroughput = throughput
+	if step == c.step {
+		return
+	}
+	c.step = step
+
+	limits := c.max.scaled(step)
+	if limits == c.limits {
+		return
+	}
+	level.Info(c.logger).Log("msg", "adjusting compaction concurrency", "reason", reason, "concurrency", limits.Concurrency,
+		"blocks_fetch_concurrency", limits.CompactBlocksFetchConcurrency, "block_files_concurrency", limits.BlockFilesConcurrency,
+		"memory_bytes", memory, "objstore_operations", ops, "objstore_errors", errs, "throughput_bytes_per_second", int64(throughput))
+	c.setLimits(limits)
+}
+
+func goMemoryUsage() uint64 {
+	// The same memory as the Go memory limit accounts for.
+	samples := []metrics.Sample{{Name: "/memory/classes/total:bytes"}, {Name: "/memory/classes/heap/released:bytes"}}
+	metrics.Read(samples)
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
+
+// WrapBucket returns the bucket observing the object storage errors and the bytes transferred by the group
+// compactions. It must wrap the bucket of the Grouper.
+func (c *AdaptiveConcurrency) WrapBucket(bkt objstore.Bucket) objstore.Bucket {
+	return &observedBucket{Bucket: bkt, c: c}
+}
+
+func (c *AdaptiveConcurrency) observe(bkt objstore.BucketReader, err error) {
+	c.ops.Add(1)
+	if err != nil && !bkt.IsObjNotFoundErr(err) && !errors.Is(err, context.Canceled) {
+		c.errs.Add(1)
+	}
+}
+
+type observedBucket struct {
+	objstore.Bucket
+	c *AdaptiveConcurrency
+}
+
+func (b *observedBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, err := b.Bucket.Get(ctx, name)
+	b.c.observe(b.Bucket, err)
+	if err != nil {
+		return nil, err
+	}
+	return &countingReadCloser{ReadCloser: rc, bytes: &b.c.bytes}, nil
+}
+
+func (b *observedBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	rc, err := b.Bucket.GetRange(ctx, name, off, length)
+	b.c.observe(b.Bucket, err)
+	if err != nil {
+		return nil, err
+	}
+	return &countingReadCloser{ReadCloser: rc, bytes: &b.c.bytes}, nil
+}
+
+func (b *observedBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	// The reader is not wrapped, as providers upload files of known size differently.
+	size, _ := objstore.TryToGetSize(r)
+	err := b.Bucket.Upload(ctx, name, r)
+	b.c.observe(b.Bucket, err)
+	if err == nil {
+		b.c.bytes.Add(size)
+	}
+	return err
+}
+
+func (b *observedBucket) Exists(ctx context.Context, name string) (bool, error) {
+	ok, err := b.Bucket.Exists(ctx, name)
+	b.c.observe(b.Bucket, err)
+	return ok, err
+}
+
+func (b *observedBucket) Delete(ctx context.Context, name string) error {
+	err := b.Bucket.Delete(ctx, name)
+	b.c.observe(b.Bucket, err)
+	return err
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	bytes *atomic.Int64
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.bytes.Add(int64(n))
+	return n, err
+}
+
+// ConcurrencyPool limits the number of groups compacted concurrently by all the BucketCompactors sharing it, e.g.
+// compacting the blocks of different buckets, as if they were compacted by a single pool of workers.
+type ConcurrencyPool struct {
+	sem chan struct{}
+}
+
+// NewConcurrencyPool returns a pool compacting up to concurrency groups at a time.
+func NewConcurrencyPool(concurrency int) *ConcurrencyPool {
+	return &ConcurrencyPool{sem: make(chan struct{}, max(1, concurrency))}
+}
+
+// acquire waits until another group can be compacted. The returned function must be called once the group
+// compaction is done. A nil pool does not limit anything.
+func (p *ConcurrencyPool) acquire(ctx context.Context) (func(), error) {
+	if p == nil {
+		return func() {}, nil
+	}
+	select {
+	case p.sem <- struct{}{}:
+		return func() { <-p.sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thanos-io/objstore"
+)
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	t.Parallel()
+
+	c := NewAdaptiveConcurrency(log.NewNopLogger(), prometheus.NewRegistry(), ConcurrencyLimits{
+		Concurrency:                   10,
+		BlockFilesConcurrency:         20,
+		CompactBlocksFetchConcurrency: 5,
+	}, 1000)
+	var memory uint64 = 100
+	c.memoryUsage = func() uint64 { return memory }
+
+	// It starts low.
+	testutil.Equals(t, ConcurrencyLimits{Concurrency: 1, BlockFilesConcurrency: 2, CompactBlocksFetchConcurrency: 1}, c.Limits())
+
+	// Without compactions using all the concurrency, it is not increased.
+	now := time.Now()
+	c.update(now.Add(time.Second))
+	testutil.Equals(t, 1, c.Limits().Concurrency)
+
+	g := &Group{}
+	release, err := c.acquire(context.Background(), g)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, g.blockFilesConcurrency)
+	testutil.Equals(t, 1, g.compactBlocksFetchConcurrency)
+
+	// Further compactions wait until the concurrency allows them.
+	acquired := make(chan func())
+	go func() {
+		r, err := c.acquire(context.Background(), &Group{})
+		testutil.Ok(t, err)
+		acquired <- r
+	}()
+
+	c.bytes.Add(1000)
+	c.update(now.Add(2 * time.Second))
+	testutil.Equals(t, ConcurrencyLimits{Concurrency: 2, BlockFilesConcurrency: 4, CompactBlocksFetchConcurrency: 1}, c.Limits())
+	release2 := <-acquired
+
+	// A throughput lower than before the increase takes it back.
+	c.bytes.Add(100)
+	c.update(now.Add(3 * time.Second))
+	testutil.Equals(t, 1, c.Limits().Concurrency)
+
+	// Object storage errors halve it.
+	c.step = 8
+	for range minObjstoreOperations {
+		c.observe(objstore.NewInMemBucket(), io.ErrUnexpectedEOF)
+	}
+	c.update(now.Add(4 * time.Second))
+	testutil.Equals(t, ConcurrencyLimits{Concurrency: 4, BlockFilesConcurrency: 8, CompactBlocksFetchConcurrency: 2}, c.Limits())
+
+	// Memory pressure halves it as well.
+	memory = 950
+	c.update(now.Add(5 * time.Second))
+	testutil.Equals(t, ConcurrencyLimits{Concurrency: 2, BlockFilesConcurrency: 4, CompactBlocksFetchConcurrency: 1}, c.Limits())
+
+	release()
+	release2()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.limits.Concurrency = 0
+	_, err = c.acquire(ctx, &Group{})
+	testutil.NotOk(t, err)
+
+	var nilController *AdaptiveConcurrency
+	release, err = nilController.acquire(context.Background(), &Group{})
+	testutil.Ok(t, err)
+	release()
+}
+
+func TestAdaptiveConcurrencyBucket(t *testing.T) {
+	t.Parallel()
+
+	c := NewAdaptiveConcurrency(log.NewNopLogger(), prometheus.NewRegistry(), ConcurrencyLimits{Concurrency: 1, BlockFilesConcurrency: 1, CompactBlocksFetchConcurrency: 1}, 0)
+	bkt := c.WrapBucket(objstore.NewInMemBucket())
+	ctx := context.Background()
+
+	testutil.Ok(t, bkt.Upload(ctx, "a", bytes.NewReader([]byte("abcd"))))
+	rc, err := bkt.Get(ctx, "a")
+	testutil.Ok(t, err)
+	_, err = io.ReadAll(rc)
+	testutil.Ok(t, err)
+	testutil.Ok(t, rc.Close())
+
+	// Missing objects are not errors of the object storage.
+	_, err = bkt.Get(ctx, "missing")
+	testutil.NotOk(t, err)
+
+	testutil.Equals(t, int64(3), c.ops.Load())
+	testutil.Equals(t, int64(0), c.errs.Load())
+	testutil.Equals(t, int64(8), c.bytes.Load())
+}
+
+func TestConcurrencyPool(t *testing.T) {
+	t.Parallel()
+
+	p := NewConcurrencyPool(1)
+	release, err := p.acquire(context.Background())
+	testutil.Ok(t, err)
+
+	// Compactors sharing the pool wait for the group compacted by another one.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = p.acquire(ctx)
+	testutil.NotOk(t, err)
+
+	release()
+	release, err = p.acquire(context.Background())
+	testutil.Ok(t, err)
+	release()
+
+	var nilPool *ConcurrencyPool
+	release, err = nilPool.acquire(context.Background())
+	testutil.Ok(t, err)
+	release()
+}
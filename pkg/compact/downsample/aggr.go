@@ -61,6 +61,16 @@ func (c AggrChunk) NumSamples() int {
 	return x.NumSamples()
 }
 
+// IsHistogram returns true if the chunk aggregates native histograms, which only have the count, sum and counter
+// aggregates.
+func (c AggrChunk) IsHistogram() bool {
+	cntr, err := c.Get(AggrCounter)
+	if err != nil {
+		return false
+	}
+	return cntr.Encoding() == chunkenc.EncHistogram || cntr.Encoding() == chunkenc.EncFloatHistogram
+}
+
 // ErrAggrNotExist is returned if a requested aggregation is not present in an AggrChunk.
 var ErrAggrNotExist = errors.New("aggregate does not exist")
 
@@ -230,7 +230,7 @@ func Downsample(
 			// Downsample a block that contains aggregated chunks already.
 			for i, c := range fixedChks {
 				ac := c.Chunk.(*AggrChunk)
-				if i > 0 && previousIsHistogram != isHistogramAggrChunk(ac) {
+				if i > 0 && previousIsHistogram != ac.IsHistogram() {
 					err := downsampleAggr(
 						aggrChunks,
 						&all,
@@ -243,7 +243,7 @@ func Downsample(
 					if err != nil {
 						return id, errors.Wrapf(err, "downsample aggregate block, series: %d", postings.At())
 					}
-					previousIsHistogram = isHistogramAggrChunk(ac)
+					previousIsHistogram = ac.IsHistogram()
 					aggrChunks = aggrChunks[:0]
 					mint = c.MinTime
 				}
@@ -251,7 +251,7 @@ func Downsample(
 
 				if i == 0 {
 					mint = c.MinTime
-					previousIsHistogram = isHistogramAggrChunk(ac)
+					previousIsHistogram = ac.IsHistogram()
 				}
 				maxt = c.MaxTime
 			}
@@ -649,6 +649,21 @@ func DownsampleRaw(data []sample, resolution int64) []chunks.Meta {
 	return chks
 }
 
+// DownsampleRawXORChunks creates a series of aggregation chunks for the samples of the given raw XOR chunks, which
+// must be ordered by time and must not overlap.
+func DownsampleRawXORChunks(chks []chunkenc.Chunk, resolution int64) ([]chunks.Meta, error) {
+	var data []sample
+	for _, c := range chks {
+		if c.Encoding() != chunkenc.EncXOR {
+			return nil, errors.Errorf("unexpected chunk encoding %d %s", c.Encoding(), c.Encoding())
+		}
+		if err := expandXorChunkIterator(c.Iterator(nil), &data); err != nil {
+			return nil, err
+		}
+	}
+	return DownsampleRaw(data, resolution), nil
+}
+
 func downsampleRawLoop(
 	data []sample,
 	resolution int64,
@@ -852,15 +867,6 @@ func downsampleBatch(data []sample, resolution int64, aggr sampleAggregator, add
 	return nextT
 }
 
-func isHistogramAggrChunk(c *AggrChunk) bool {
-	// If it is an aggregated chunk histogram chunk, the counter will be of the type histogram.
-	cntr, err := c.Get(AggrCounter)
-	if err != nil {
-		return false
-	}
-	return cntr.Encoding() == chunkenc.EncHistogram || cntr.Encoding() == chunkenc.EncFloatHistogram
-}
-
 // downsampleAggr downsamples a sequence of aggregation chunks to the given resolution.
 func downsampleAggr(
 	chks []*AggrChunk,
@@ -872,7 +878,7 @@ func downsampleAggr(
 	var numSamples int
 
 	for _, c := range chks {
-		if isHistogramAggrChunk(c) {
+		if c.IsHistogram() {
 			hChks = append(hChks, c)
 			numSamples += c.NumSamples()
 		} else {
@@ -1127,6 +1133,13 @@ type sample struct {
 // value of the later chunk ensures that counter resets between chunks are
 // recognized and that the correct value delta is calculated.
 //
+// Native histograms of counters are handled likewise, compared at the lowest
+// of their schemas as aggregation chunks of different batches may have
+// different schemas. As their aggregation chunks have no last raw value, a
+// counter reset is assumed whenever a histogram is lower than the previous one.
+// Counter histograms are generated as float histograms, gauge histograms are
+// left untouched.
+//
 // It handles overlapped chunks (removes overlaps).
 // NOTE: It is important to deduplicate with care ensuring that you don't hit
 // issue https://github.com/thanos-io/thanos/issues/2401#issuecomment-621958839.
@@ -1139,18 +1152,23 @@ type ApplyCounterResetsSeriesIterator struct {
 	lastV       float64 // Value of the last sample.
 	totalV      float64 // Total counter state since beginning of series.
 	lastValType chunkenc.ValueType
+
+	lastFH   *histogram.FloatHistogram // Value of the last histogram sample.
+	resetsFH *histogram.FloatHistogram // Sum of the histogram values before counter resets.
+	totalFH  *histogram.FloatHistogram // Total counter state of the current histogram sample, nil for gauge histograms.
+	err      error
 }
 
 func NewApplyCounterResetsIterator(chks ...chunkenc.Iterator) *ApplyCounterResetsSeriesIterator {
 	return &ApplyCounterResetsSeriesIterator{chks: chks}
 }
 
-// TODO(rabenhorst): Native histogram support needs to be added, float type is hardcoded.
 func (it *ApplyCounterResetsSeriesIterator) Next() chunkenc.ValueType {
 	for {
-		if it.i >= len(it.chks) {
+		if it.i >= len(it.chks) || it.err != nil {
 			return chunkenc.ValNone
 		}
+		it.totalFH = nil
 		it.lastValType = it.chks[it.i].Next()
 		if it.lastValType == chunkenc.ValNone {
 			it.i++
@@ -1159,7 +1177,14 @@ func (it *ApplyCounterResetsSeriesIterator) Next() chunkenc.ValueType {
 			// to the next timestamp.
 			return it.Seek(it.lastT + 1)
 		}
-		// Counter resets do not need to be handled for non-float sample types.
+		if it.lastValType == chunkenc.ValHistogram || it.lastValType == chunkenc.ValFloatHistogram {
+			if vt, ok := it.nextHistogram(); ok {
+				it.lastValType = vt
+				return vt
+			}
+			continue
+		}
+		// Counter resets do not need to be handled for other sample types.
 		if it.lastValType != chunkenc.ValFloat {
 			it.lastT = it.chks[it.i].AtT()
 			return it.lastValType
@@ -1196,6 +1221,62 @@ func (it *ApplyCounterResetsSeriesIterator) Next() chunkenc.ValueType {
 	}
 }
 
+// nextHistogram handles the current histogram sample of the current chunk, returning false if it has to be skipped.
+func (it *ApplyCounterResetsSeriesIterator) nextHistogram() (chunkenc.ValueType, bool) {
+	t, fh := it.chks[it.i].AtFloatHistogram(nil)
+	if value.IsStaleNaN(fh.Sum) {
+		return chunkenc.ValNone, false
+	}
+	if fh.CounterResetHint == histogram.GaugeType {
+		it.lastT = t
+		return it.lastValType, true
+	}
+	// First sample sets the initial counter state.
+	if it.lastFH == nil {
+		it.lastT, it.lastFH, it.totalFH = t, fh, fh
+		return chunkenc.ValFloatHistogram, true
+	}
+	if t == it.lastT {
+		it.lastFH = fh
+	}
+	if t <= it.lastT {
+		return chunkenc.ValNone, false
+	}
+
+	if isHistogramCounterReset(fh, it.lastFH) {
+		if it.resetsFH == nil {
+			it.resetsFH = it.lastFH.Copy()
+		} else if _, err := it.resetsFH.Add(it.lastFH); err != nil {
+			it.err = errors.Wrap(err, "add histogram before counter reset")
+			return chunkenc.ValNone, false
+		}
+	}
+	it.lastT, it.lastFH = t, fh
+
+	it.totalFH = fh.Copy()
+	if it.resetsFH != nil {
+		if _, err := it.totalFH.Add(it.resetsFH); err != nil {
+			it.err = errors.Wrap(err, "add histograms before counter resets")
+			return chunkenc.ValNone, false
+		}
+	}
+	// Resets are accounted for already, so PromQL must not detect them again, e.g. on schema changes.
+	it.totalFH.CounterResetHint = histogram.NotCounterReset
+	return chunkenc.ValFloatHistogram, true
+}
+
+// isHistogramCounterReset returns true if the histogram is lower than the previous one. Counter reset hints are
+// ignored, as they are only meaningful within a chunk, and histograms are compared at the lowest of their schemas.
+func isHistogramCounterReset(curr, prev *histogram.FloatHistogram) bool {
+	if curr.Schema > prev.Schema && !curr.UsesCustomBuckets() && !prev.UsesCustomBuckets() {
+		curr = curr.CopyToSchema(prev.Schema)
+	} else {
+		curr = curr.Copy()
+	}
+	curr.CounterResetHint = histogram.UnknownCounterReset
+	return curr.DetectReset(prev)
+}
+
 func (it *ApplyCounterResetsSeriesIterator) At() (t int64, v float64) {
 	return it.lastT, it.totalV
 }
@@ -1205,7 +1286,14 @@ func (it *ApplyCounterResetsSeriesIterator) AtHistogram(h *histogram.Histogram)
 }
 
 func (it *ApplyCounterResetsSeriesIterator) AtFloatHistogram(fh *histogram.FloatHistogram) (int64, *histogram.FloatHistogram) {
-	return it.chks[it.i].AtFloatHistogram(fh)
+	if it.totalFH == nil {
+		return it.chks[it.i].AtFloatHistogram(fh)
+	}
+	if fh == nil {
+		return it.lastT, it.totalFH.Copy()
+	}
+	it.totalFH.CopyTo(fh)
+	return it.lastT, fh
 }
 
 func (it *ApplyCounterResetsSeriesIterator) AtT() int64 {
@@ -1226,6 +1314,9 @@ func (it *ApplyCounterResetsSeriesIterator) Seek(x int64) chunkenc.ValueType {
 }
 
 func (it *ApplyCounterResetsSeriesIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
 	if it.i >= len(it.chks) {
 		return nil
 	}
@@ -1980,6 +1980,53 @@ func TestApplyCounterResetsIteratorHistograms(t *testing.T) {
 
 }
 
+func TestApplyCounterResetsIteratorFloatHistogramResets(t *testing.T) {
+	fh := func(i int, schema int32) *histogram.FloatHistogram {
+		return tsdbutil.GenerateTestFloatHistogram(int64(i)).CopyToSchema(schema)
+	}
+	chunk := func(mint int64, fhs ...*histogram.FloatHistogram) chunkenc.Iterator {
+		c := chunkenc.NewFloatHistogramChunk()
+		app, err := c.Appender()
+		testutil.Ok(t, err)
+		for i, h := range fhs {
+			_, _, app, err = app.AppendFloatHistogram(nil, mint+int64(i)*100, h, true)
+			testutil.Ok(t, err)
+		}
+		return c.Iterator(nil)
+	}
+	sum := func(a, b *histogram.FloatHistogram) *histogram.FloatHistogram {
+		res, err := a.Copy().Add(b)
+		testutil.Ok(t, err)
+		res.CounterResetHint = histogram.NotCounterReset
+		return res
+	}
+
+	// Aggregation chunks of different batches have different schemas, and the histogram of the third chunk is lower
+	// than the last one of the second chunk.
+	it := NewApplyCounterResetsIterator(
+		chunk(0, fh(1, 0), fh(2, 0), fh(3, 0)),
+		chunk(300, fh(4, 1)),
+		chunk(400, fh(1, 1), fh(2, 1)),
+	)
+	var res []*histogram.FloatHistogram
+	for it.Next() != chunkenc.ValNone {
+		_, h := it.AtFloatHistogram(nil)
+		res = append(res, h)
+	}
+	testutil.Ok(t, it.Err())
+	testutil.Equals(t, 6, len(res))
+
+	// A higher schema is not a counter reset.
+	notReset := fh(4, 1)
+	notReset.CounterResetHint = histogram.NotCounterReset
+	testutil.Equals(t, notReset, res[3])
+	testutil.Equals(t, sum(fh(1, 1), fh(4, 1)), res[4])
+	testutil.Equals(t, sum(fh(2, 1), fh(4, 1)), res[5])
+	for i := range res[1:] {
+		testutil.Assert(t, !isHistogramCounterReset(res[i+1], res[i]), "histogram %d lower than the previous one", i+1)
+	}
+}
+
 func TestCounterSeriesIteratorSeek(t *testing.T) {
 	chunks := [][]sample{
 		{{t: 100, v: 10}, {t: 200, v: 20}, {t: 300, v: 10}, {t: 400, v: 20}, {t: 400, v: 5}},
@@ -0,0 +1,46 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/exemplars/exemplarspb"
+)
+
+// mergeExemplars writes the exemplars of the blocks compacted from the directories in dir into the exemplars file of
+// the compacted block in bdir. Only the exemplars within the time range of the compacted block and the exemplars
+// retention, and of the series of its shard if any, are kept.
+func (cg *Group) mergeExemplars(dir, bdir string, compacted []*metadata.Meta, meta *metadata.Meta, shard *metadata.Shard) error {
+	var sets [][]*exemplarspb.ExemplarData
+	for _, m := range compacted {
+		if !block.HasExemplars(m) {
+			continue
+		}
+		data, err := block.ReadExemplarsFromDir(filepath.Join(dir, m.ULID.String()))
+		if err != nil {
+			return errors.Wrapf(err, "read exemplars of block %s", m.ULID)
+		}
+		sets = append(sets, data)
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+
+	mint := meta.MinTime
+	if cg.exemplarsRetention > 0 {
+		mint = max(mint, time.Now().Add(-cg.exemplarsRetention).UnixMilli())
+	}
+	var keep func(labels.Labels) bool
+	if shard != nil {
+		keep = func(lset labels.Labels) bool { return labels.StableHash(lset)%shard.Count == shard.Index }
+	}
+	return block.WriteExemplarsFile(bdir, block.MergeExemplars(sets, mint, meta.MaxTime, keep))
+}
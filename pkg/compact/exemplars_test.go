@@ -0,0 +1,80 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/exemplars/exemplarspb"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+)
+
+func TestGroup_mergeExemplars(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().UnixMilli()
+	a := labelpb.ZLabelSet{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "a"))}
+	b := labelpb.ZLabelSet{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "b"))}
+
+	dir := t.TempDir()
+	var compacted []*metadata.Meta
+	for i, data := range [][]*exemplarspb.ExemplarData{
+		{{SeriesLabels: a, Exemplars: []*exemplarspb.Exemplar{{Value: 1, Ts: now - 2*time.Hour.Milliseconds()}, {Value: 2, Ts: now - 1000}}}},
+		{{SeriesLabels: b, Exemplars: []*exemplarspb.Exemplar{{Value: 3, Ts: now - 2000}}}},
+		nil,
+	} {
+		id := ulid.MustNew(uint64(i+1), nil)
+		bdir := filepath.Join(dir, id.String())
+		testutil.Ok(t, os.MkdirAll(bdir, os.ModePerm))
+		testutil.Ok(t, block.WriteExemplarsFile(bdir, data))
+		m := &metadata.Meta{BlockMeta: tsdb.BlockMeta{ULID: id}}
+		if len(data) > 0 {
+			m.Thanos.Files = []metadata.File{{RelPath: block.ExemplarsFilename}}
+		}
+		compacted = append(compacted, m)
+	}
+	meta := &metadata.Meta{BlockMeta: tsdb.BlockMeta{MinTime: now - 3*time.Hour.Milliseconds(), MaxTime: now}}
+
+	t.Run("all the exemplars within the time range of the block are merged", func(t *testing.T) {
+		bdir := t.TempDir()
+		testutil.Ok(t, (&Group{}).mergeExemplars(dir, bdir, compacted, meta, nil))
+		data, err := block.ReadExemplarsFromDir(bdir)
+		testutil.Ok(t, err)
+		testutil.Equals(t, []*exemplarspb.ExemplarData{
+			{SeriesLabels: a, Exemplars: []*exemplarspb.Exemplar{{Value: 1, Ts: now - 2*time.Hour.Milliseconds()}, {Value: 2, Ts: now - 1000}}},
+			{SeriesLabels: b, Exemplars: []*exemplarspb.Exemplar{{Value: 3, Ts: now - 2000}}},
+		}, data)
+	})
+	t.Run("exemplars past the retention are dropped", func(t *testing.T) {
+		bdir := t.TempDir()
+		testutil.Ok(t, (&Group{exemplarsRetention: time.Hour}).mergeExemplars(dir, bdir, compacted, meta, nil))
+		data, err := block.ReadExemplarsFromDir(bdir)
+		testutil.Ok(t, err)
+		testutil.Equals(t, []*exemplarspb.ExemplarData{
+			{SeriesLabels: a, Exemplars: []*exemplarspb.Exemplar{{Value: 2, Ts: now - 1000}}},
+			{SeriesLabels: b, Exemplars: []*exemplarspb.Exemplar{{Value: 3, Ts: now - 2000}}},
+		}, data)
+	})
+	t.Run("only the exemplars of the series of the shard are kept", func(t *testing.T) {
+		bdir := t.TempDir()
+		shard := &metadata.Shard{Index: labels.StableHash(b.PromLabels()) % 16, Count: 16}
+		testutil.Assert(t, labels.StableHash(a.PromLabels())%16 != shard.Index, "series must be in different shards")
+		testutil.Ok(t, (&Group{}).mergeExemplars(dir, bdir, compacted, meta, shard))
+		data, err := block.ReadExemplarsFromDir(bdir)
+		testutil.Ok(t, err)
+		testutil.Equals(t, []*exemplarspb.ExemplarData{
+			{SeriesLabels: b, Exemplars: []*exemplarspb.Exemplar{{Value: 3, Ts: now - 2000}}},
+		}, data)
+	})
+}
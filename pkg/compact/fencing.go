@@ -0,0 +1,134 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// FencingTokenFile is the object in the bucket holding the fencing token of the latest compactor run.
+const FencingTokenFile = "compactor-fencing-token.json"
+
+// ErrFenced is returned when a compactor run with a newer fencing token started on the same bucket.
+var ErrFenced = errors.New("fenced by a newer compactor")
+
+type fencingTokenRecord struct {
+	metadata.MarkerWriter
+	AcquireTime int64 `json:"acquire_time"`
+}
+
+// NewMarkerWriter returns the identity of this compactor run, to be recorded in the markers it writes with
+// block.WithMarkerWriter. If fencing is enabled, it acquires a new fencing token, greater than the tokens of
+// all compactor runs that started before on the same bucket. Older runs then refuse to garbage collect or
+// delete blocks, and to act on blocks marked by a newer run, so that two compactors accidentally running at
+// the same time do not delete the results of each other. Acquiring a token is best effort: runs starting at
+// the same time can both fail, to be restarted.
+func NewMarkerWriter(ctx context.Context, logger log.Logger, bkt objstore.Bucket, identity string, fencing bool) (*metadata.MarkerWriter, error) {
+	w := &metadata.MarkerWriter{Identity: identity, RunID: ulid.Make().String()}
+	if !fencing {
+		return w, nil
+	}
+
+	prev, err := readFencingToken(ctx, logger, bkt)
+	if err != nil {
+		return nil, err
+	}
+	w.FencingToken = prev.FencingToken + 1
+
+	b, err := json.Marshal(fencingTokenRecord{MarkerWriter: *w, AcquireTime: time.Now().Unix()})
+	if err != nil {
+		return nil, errors.Wrap(err, "encode fencing token")
+	}
+	if err := bkt.Upload(ctx, FencingTokenFile, bytes.NewReader(b)); err != nil {
+		return nil, errors.Wrap(err, "upload fencing token")
+	}
+
+	// Read the token back, to detect another run acquiring the same token.
+	cur, err := readFencingToken(ctx, logger, bkt)
+	if err != nil {
+		return nil, err
+	}
+	if cur.RunID != w.RunID {
+		return nil, errors.Errorf("fencing token %d concurrently acquired by %s (run %s)", w.FencingToken, cur.Identity, cur.RunID)
+	}
+	level.Info(logger).Log("msg", "acquired fencing token", "token", w.FencingToken, "previous_holder", prev.Identity)
+	return w, nil
+}
+
+func readFencingToken(ctx context.Context, logger log.Logger, bkt objstore.BucketReader) (fencingTokenRecord, error) {
+	var rec fencingTokenRecord
+	r, err := bkt.Get(ctx, FencingTokenFile)
+	if err != nil {
+		if bkt.IsObjNotFoundErr(err) {
+			return rec, nil
+		}
+		return rec, errors.Wrap(err, "get fencing token")
+	}
+	defer runutil.CloseWithLogOnErr(logger, r, "fencing token reader")
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return rec, errors.Wrap(err, "read fencing token")
+	}
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return rec, errors.Wrap(err, "decode fencing token")
+	}
+	return rec, nil
+}
+
+// checkFenced returns a halt error if the fencing token of the compactor run writing markers with ctx is
+// not the latest one anymore.
+func checkFenced(ctx context.Context, logger log.Logger, bkt objstore.BucketReader) error {
+	w := block.MarkerWriterFromContext(ctx)
+	if w == nil || w.FencingToken == 0 {
+		return nil
+	}
+	cur, err := readFencingToken(ctx, logger, bkt)
+	if err != nil {
+		return err
+	}
+	if cur.FencingToken > w.FencingToken {
+		return halt(errors.Wrapf(ErrFenced, "token %d acquired by %s (run %s), ours is %d", cur.FencingToken, cur.Identity, cur.RunID, w.FencingToken))
+	}
+	return nil
+}
+
+// markedByNewerRun returns whether the marker writer has a newer fencing token than the run writing markers with ctx.
+func markedByNewerRun(ctx context.Context, marker *metadata.MarkerWriter) bool {
+	w := block.MarkerWriterFromContext(ctx)
+	if w == nil || w.FencingToken == 0 || marker == nil {
+		return false
+	}
+	return marker.FencingToken > w.FencingToken
+}
+
+// noCompactMarkedByNewerRun returns whether the block has a no-compact mark written by a newer compactor run.
+func noCompactMarkedByNewerRun(ctx context.Context, logger log.Logger, bkt objstore.Bucket, id ulid.ULID) (bool, error) {
+	if w := block.MarkerWriterFromContext(ctx); w == nil || w.FencingToken == 0 {
+		return false, nil
+	}
+	var m metadata.NoCompactMark
+	err := metadata.ReadMarker(ctx, logger, objstore.WithNoopInstr(bkt), id.String(), &m)
+	if errors.Is(err, metadata.ErrorMarkerNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "read no-compact mark of %s", id)
+	}
+	return markedByNewerRun(ctx, m.Writer), nil
+}
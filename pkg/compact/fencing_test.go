@@ -0,0 +1,78 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+func TestFencing(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
+
+	unfenced, err := NewMarkerWriter(ctx, logger, bkt, "host-0", false)
+	testutil.Ok(t, err)
+	testutil.Equals(t, uint64(0), unfenced.FencingToken)
+	exists, err := bkt.Exists(ctx, FencingTokenFile)
+	testutil.Ok(t, err)
+	testutil.Assert(t, !exists)
+
+	older, err := NewMarkerWriter(ctx, logger, bkt, "host-1", true)
+	testutil.Ok(t, err)
+	testutil.Equals(t, uint64(1), older.FencingToken)
+	newer, err := NewMarkerWriter(ctx, logger, bkt, "host-2", true)
+	testutil.Ok(t, err)
+	testutil.Equals(t, uint64(2), newer.FencingToken)
+	testutil.Assert(t, older.RunID != newer.RunID)
+
+	olderCtx, newerCtx := block.WithMarkerWriter(ctx, older), block.WithMarkerWriter(ctx, newer)
+
+	// Markers record their writer.
+	id := ulid.MustNew(1, nil)
+	testutil.Ok(t, block.MarkForDeletion(newerCtx, logger, bkt, id, "test", promauto.With(nil).NewCounter(prometheus.CounterOpts{})))
+	var m metadata.DeletionMark
+	testutil.Ok(t, metadata.ReadMarker(ctx, logger, bkt, id.String(), &m))
+	testutil.Equals(t, newer, m.Writer)
+
+	// Only the older run is fenced.
+	err = checkFenced(olderCtx, logger, bkt)
+	testutil.Assert(t, IsHaltError(err))
+	testutil.Assert(t, errors.Is(err.(HaltError).err, ErrFenced))
+	testutil.Ok(t, checkFenced(newerCtx, logger, bkt))
+	testutil.Ok(t, checkFenced(block.WithMarkerWriter(ctx, unfenced), logger, bkt))
+	testutil.Ok(t, checkFenced(ctx, logger, bkt))
+
+	testutil.Assert(t, markedByNewerRun(olderCtx, m.Writer))
+	testutil.Assert(t, !markedByNewerRun(newerCtx, older))
+	testutil.Assert(t, !markedByNewerRun(ctx, m.Writer))
+
+	testutil.Ok(t, block.MarkForNoCompact(newerCtx, logger, bkt, id, metadata.ManualNoCompactReason, "", promauto.With(nil).NewCounter(prometheus.CounterOpts{})))
+	marked, err := noCompactMarkedByNewerRun(olderCtx, logger, bkt, id)
+	testutil.Ok(t, err)
+	testutil.Assert(t, marked)
+	marked, err = noCompactMarkedByNewerRun(olderCtx, logger, bkt, ulid.MustNew(2, nil))
+	testutil.Ok(t, err)
+	testutil.Assert(t, !marked)
+
+	// Older runs do not delete blocks once fenced.
+	testutil.Ok(t, bkt.Delete(ctx, path.Join(id.String(), metadata.NoCompactMarkFilename)))
+	cleaner := NewBlocksCleaner(logger, bkt, block.NewIgnoreDeletionMarkFilter(logger, bkt, 0, 1), 0, promauto.With(nil).NewCounter(prometheus.CounterOpts{}), promauto.With(nil).NewCounter(prometheus.CounterOpts{}))
+	testutil.Assert(t, IsHaltError(cleaner.DeleteMarkedBlocks(olderCtx)))
+}
@@ -0,0 +1,226 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/indexheader"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// GarbagePolicy selects the blocks the Syncer marks for deletion on garbage collection, as their data is
+// available in other blocks.
+type GarbagePolicy interface {
+	// GarbageBlocks returns the blocks to mark for deletion, given the metas of the last sync of the Syncer. The
+	// blocks already marked for deletion do not have to be returned again.
+	GarbageBlocks(ctx context.Context, metas map[ulid.ULID]*metadata.Meta) ([]ulid.ULID, error)
+}
+
+// DuplicatesGarbagePolicy is the default GarbagePolicy of the Syncer. It collects the blocks the deduplicate filter
+// of the last sync found to be compacted into other blocks, except the ones already marked for deletion.
+type DuplicatesGarbagePolicy struct {
+	duplicateBlocksFilter    block.DeduplicateFilter
+	ignoreDeletionMarkFilter *block.IgnoreDeletionMarkFilter
+	replacementCheck         *ReplacementCheck
+}
+
+// NewDuplicatesGarbagePolicy returns the policy collecting the duplicates found by the filters of the fetcher of
+// the Syncer.
+func NewDuplicatesGarbagePolicy(duplicateBlocksFilter block.DeduplicateFilter, ignoreDeletionMarkFilter *block.IgnoreDeletionMarkFilter) *DuplicatesGarbagePolicy {
+	return &DuplicatesGarbagePolicy{
+		duplicateBlocksFilter:    duplicateBlocksFilter,
+		ignoreDeletionMarkFilter: ignoreDeletionMarkFilter,
+	}
+}
+
+// SetReplacementCheck makes the policy only collect the duplicates whose replacements, the blocks they were compacted
+// into, pass the check. The deduplicate filter has to report the replacements of the duplicates for any duplicate to
+// be collected.
+func (p *DuplicatesGarbagePolicy) SetReplacementCheck(c *ReplacementCheck) {
+	p.replacementCheck = c
+}
+
+// replacementsFilter is implemented by the deduplicate filters reporting the blocks every duplicate was compacted into.
+type replacementsFilter interface {
+	Replacements() map[ulid.ULID][]ulid.ULID
+}
+
+func (p *DuplicatesGarbagePolicy) GarbageBlocks(ctx context.Context, _ map[ulid.ULID]*metadata.Meta) ([]ulid.ULID, error) {
+	// Ignore filter exists before deduplicate filter.
+	deletionMarkMap := p.ignoreDeletionMarkFilter.DeletionMarkBlocks()
+	duplicateIDs := p.duplicateBlocksFilter.DuplicateIDs()
+
+	// GarbageIDs contains the duplicateIDs, since these blocks can be replaced with other blocks.
+	// We also remove ids present in deletionMarkMap since these blocks are already marked for deletion.
+	garbageIDs := []ulid.ULID{}
+	for _, id := range duplicateIDs {
+		if _, exists := deletionMarkMap[id]; exists {
+			continue
+		}
+		garbageIDs = append(garbageIDs, id)
+	}
+	if p.replacementCheck == nil || len(garbageIDs) == 0 {
+		return garbageIDs, nil
+	}
+	return p.verifiedGarbage(ctx, garbageIDs)
+}
+
+// verifiedGarbage returns the duplicates whose replacements pass the replacement check. Every replacement is checked
+// once, however many duplicates it replaces.
+func (p *DuplicatesGarbagePolicy) verifiedGarbage(ctx context.Context, duplicateIDs []ulid.ULID) ([]ulid.ULID, error) {
+	c := p.replacementCheck
+	f, ok := p.duplicateBlocksFilter.(replacementsFilter)
+	if !ok {
+		level.Warn(c.logger).Log("msg", "deduplicate filter does not report the replacements of duplicates, not garbage collecting any block", "duplicates", len(duplicateIDs))
+		c.unverified.Add(float64(len(duplicateIDs)))
+		return nil, nil
+	}
+	replacements := f.Replacements()
+
+	verified := map[ulid.ULID]error{}
+	garbageIDs := make([]ulid.ULID, 0, len(duplicateIDs))
+	for _, id := range duplicateIDs {
+		rs := replacements[id]
+		if len(rs) == 0 {
+			level.Warn(c.logger).Log("msg", "not garbage collecting duplicate block without replacement", "block", id)
+			c.unverified.Inc()
+			continue
+		}
+		var err error
+		for _, r := range rs {
+			verr, ok := verified[r]
+			if !ok {
+				verr = c.Verify(ctx, r)
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				verified[r] = verr
+			}
+			if verr != nil {
+				err = verr
+				break
+			}
+		}
+		if err != nil {
+			level.Warn(c.logger).Log("msg", "not garbage collecting duplicate block with unverified replacement", "block", id, "err", err)
+			c.unverified.Inc()
+			continue
+		}
+		garbageIDs = append(garbageIDs, id)
+	}
+	return garbageIDs, nil
+}
+
+// ReplacementCheck verifies a block is healthy in the bucket before the duplicates it replaces are deleted, so that a
+// block removed or left partially uploaded after the last sync does not lose the data of its sources.
+type ReplacementCheck struct {
+	logger      log.Logger
+	bkt         objstore.BucketReader
+	indexHeader bool
+
+	mtx sync.Mutex
+	// indexHeaderVerified is the blocks whose index-header was built, which is not built again as blocks are
+	// immutable.
+	indexHeaderVerified map[ulid.ULID]struct{}
+
+	unverified          prometheus.Counter
+	indexHeaderDuration prometheus.Histogram
+}
+
+// NewReplacementCheck returns the check of the replacement blocks in the bucket. If indexHeader is true, the
+// index-header of every replacement is also built from its index, without being written to disk.
+func NewReplacementCheck(logger log.Logger, reg prometheus.Registerer, bkt objstore.BucketReader, indexHeader bool) *ReplacementCheck {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &ReplacementCheck{
+		logger:      logger,
+		bkt:         bkt,
+		indexHeader: indexHeader,
+
+		indexHeaderVerified: map[ulid.ULID]struct{}{},
+		unverified: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_compact_garbage_collection_unverified_duplicates_total",
+			Help: "Total number of duplicate blocks not garbage collected as their replacement could not be verified.",
+		}),
+		indexHeaderDuration: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Name:    "thanos_compact_garbage_collection_replacement_index_header_duration_seconds",
+			Help:    "Duration of building the index-header of the replacements of duplicate blocks.",
+			Buckets: []float64{0.1, 0.3, 1, 3, 10, 30, 60, 120},
+		}),
+	}
+}
+
+// Verify returns an error if the block does not exist in the bucket, its meta is not complete or any of its files
+// is missing.
+func (c *ReplacementCheck) Verify(ctx context.Context, id ulid.ULID) error {
+	rc, err := c.bkt.Get(ctx, path.Join(id.String(), metadata.MetaFilename))
+	if err != nil {
+		return errors.Wrapf(err, "get meta of %s", id)
+	}
+	meta, err := metadata.Read(rc)
+	if err != nil {
+		return errors.Wrapf(err, "read meta of %s", id)
+	}
+	if meta.ULID != id {
+		return errors.Errorf("meta of %s is of block %s", id, meta.ULID)
+	}
+
+	expected := map[string]struct{}{}
+	for _, f := range meta.Thanos.Files {
+		expected[f.RelPath] = struct{}{}
+	}
+	if len(expected) == 0 {
+		// Blocks uploaded before the files were recorded in the meta.
+		expected[block.IndexFilename] = struct{}{}
+	}
+	delete(expected, metadata.MetaFilename)
+	var chunks bool
+	prefix := id.String() + objstore.DirDelim
+	if err := c.bkt.Iter(ctx, prefix, func(name string) error {
+		rel := strings.TrimPrefix(name, prefix)
+		if strings.HasPrefix(rel, block.ChunksDirname+objstore.DirDelim) {
+			chunks = true
+		}
+		delete(expected, rel)
+		return nil
+	}, objstore.WithRecursiveIter()); err != nil {
+		return errors.Wrapf(err, "iter files of %s", id)
+	}
+	if len(meta.Thanos.Files) == 0 && !chunks {
+		return errors.Errorf("block %s has no chunks", id)
+	}
+	for f := range expected {
+		return errors.Errorf("block %s misses file %s", id, f)
+	}
+
+	if c.indexHeader {
+		c.mtx.Lock()
+		_, ok := c.indexHeaderVerified[id]
+		c.mtx.Unlock()
+		if ok {
+			return nil
+		}
+		if _, err := indexheader.WriteBinary(ctx, c.bkt, id, "", c.indexHeaderDuration); err != nil {
+			return errors.Wrapf(err, "build index-header of %s", id)
+		}
+		c.mtx.Lock()
+		c.indexHeaderVerified[id] = struct{}{}
+		c.mtx.Unlock()
+	}
+	return nil
+}
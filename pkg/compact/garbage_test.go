@@ -0,0 +1,156 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+type garbagePolicyFunc func(ctx context.Context, metas map[ulid.ULID]*metadata.Meta) ([]ulid.ULID, error)
+
+func (f garbagePolicyFunc) GarbageBlocks(ctx context.Context, metas map[ulid.ULID]*metadata.Meta) ([]ulid.ULID, error) {
+	return f(ctx, metas)
+}
+
+func TestSyncer_SetGarbagePolicy(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	var ids []ulid.ULID
+	for i := range 3 {
+		var m metadata.Meta
+		m.Version = 1
+		m.ULID = ulid.MustNew(uint64(i+1), nil)
+		m.Compaction.Level = 1
+		m.Compaction.Sources = []ulid.ULID{m.ULID}
+		var buf bytes.Buffer
+		testutil.Ok(t, json.NewEncoder(&buf).Encode(&m))
+		testutil.Ok(t, bkt.Upload(ctx, path.Join(m.ULID.String(), metadata.MetaFilename), &buf))
+		ids = append(ids, m.ULID)
+	}
+
+	insBkt := objstore.WithNoopInstr(bkt)
+	duplicateBlocksFilter := block.NewDeduplicateFilter(1)
+	ignoreDeletionMarkFilter := block.NewIgnoreDeletionMarkFilter(nil, insBkt, 48*time.Hour, 1)
+	metaFetcher, err := block.NewMetaFetcher(nil, 1, insBkt, block.NewConcurrentLister(nil, insBkt), "", nil, []block.MetadataFilter{
+		ignoreDeletionMarkFilter,
+		duplicateBlocksFilter,
+	})
+	testutil.Ok(t, err)
+	garbageCollectedBlocks := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+	sy, err := NewMetaSyncer(nil, nil, bkt, metaFetcher, duplicateBlocksFilter, ignoreDeletionMarkFilter, promauto.With(nil).NewCounter(prometheus.CounterOpts{}), garbageCollectedBlocks, 0)
+	testutil.Ok(t, err)
+	testutil.Ok(t, sy.SyncMetas(ctx))
+
+	// None of the blocks is a duplicate, but the policy decides.
+	var seen int
+	sy.SetGarbagePolicy(garbagePolicyFunc(func(_ context.Context, metas map[ulid.ULID]*metadata.Meta) ([]ulid.ULID, error) {
+		seen = len(metas)
+		return ids[:1], nil
+	}))
+	testutil.Ok(t, sy.GarbageCollect(ctx))
+	testutil.Equals(t, 3, seen)
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(garbageCollectedBlocks))
+	ok, err := bkt.Exists(ctx, path.Join(ids[0].String(), metadata.DeletionMarkFilename))
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok, "block selected by the policy not marked for deletion")
+	_, ok = sy.Metas()[ids[0]]
+	testutil.Assert(t, !ok, "block marked for deletion still synced")
+
+	sy.SetGarbagePolicy(garbagePolicyFunc(func(context.Context, map[ulid.ULID]*metadata.Meta) ([]ulid.ULID, error) {
+		return nil, errors.New("not verified yet")
+	}))
+	err = sy.GarbageCollect(ctx)
+	testutil.NotOk(t, err)
+	testutil.Assert(t, IsRetryError(err), "policy failure not retriable: %v", err)
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(sy.metrics.GarbageCollectionFailures))
+}
+
+func TestDuplicatesGarbagePolicy_SetReplacementCheck(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+
+	// The duplicate has only its meta, as only the replacement is checked.
+	var dup metadata.Meta
+	dup.Version = 1
+	dup.ULID = ulid.MustNew(1, nil)
+	dup.Compaction.Level = 1
+	dup.Compaction.Sources = []ulid.ULID{dup.ULID}
+	dup.Thanos.Labels = map[string]string{"ext": "1"}
+	var buf bytes.Buffer
+	testutil.Ok(t, json.NewEncoder(&buf).Encode(&dup))
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(dup.ULID.String(), metadata.MetaFilename), &buf))
+
+	dir := t.TempDir()
+	id, err := e2eutil.CreateBlock(ctx, dir, []labels.Labels{labels.FromStrings("a", "1")}, 10, 0, 1000, labels.FromStrings("ext", "1"), 0, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+	bdir := filepath.Join(dir, id.String())
+	meta, err := metadata.ReadFromDir(bdir)
+	testutil.Ok(t, err)
+	meta.Compaction.Level = 2
+	meta.Compaction.Sources = []ulid.ULID{dup.ULID, id}
+	testutil.Ok(t, meta.WriteToDir(log.NewNopLogger(), bdir))
+	testutil.Ok(t, block.Upload(ctx, log.NewNopLogger(), bkt, bdir, metadata.NoneFunc))
+
+	insBkt := objstore.WithNoopInstr(bkt)
+	duplicateBlocksFilter := block.NewDeduplicateFilter(1)
+	ignoreDeletionMarkFilter := block.NewIgnoreDeletionMarkFilter(nil, insBkt, 48*time.Hour, 1)
+	metaFetcher, err := block.NewMetaFetcher(nil, 1, insBkt, block.NewConcurrentLister(nil, insBkt), "", nil, []block.MetadataFilter{
+		ignoreDeletionMarkFilter,
+		duplicateBlocksFilter,
+	})
+	testutil.Ok(t, err)
+	garbageCollectedBlocks := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+	sy, err := NewMetaSyncer(nil, nil, bkt, metaFetcher, duplicateBlocksFilter, ignoreDeletionMarkFilter, promauto.With(nil).NewCounter(prometheus.CounterOpts{}), garbageCollectedBlocks, 0)
+	testutil.Ok(t, err)
+	check := NewReplacementCheck(nil, nil, insBkt, true)
+	policy := NewDuplicatesGarbagePolicy(duplicateBlocksFilter, ignoreDeletionMarkFilter)
+	policy.SetReplacementCheck(check)
+	sy.SetGarbagePolicy(policy)
+	testutil.Ok(t, sy.SyncMetas(ctx))
+	testutil.Equals(t, []ulid.ULID{dup.ULID}, duplicateBlocksFilter.DuplicateIDs())
+
+	// The index of the replacement is gone since the sync, so the duplicate is kept.
+	index, err := bkt.Get(ctx, path.Join(id.String(), block.IndexFilename))
+	testutil.Ok(t, err)
+	testutil.Ok(t, bkt.Delete(ctx, path.Join(id.String(), block.IndexFilename)))
+	testutil.Ok(t, sy.GarbageCollect(ctx))
+	testutil.Equals(t, 0.0, promtestutil.ToFloat64(garbageCollectedBlocks))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(check.unverified))
+	ok, err := bkt.Exists(ctx, path.Join(dup.ULID.String(), metadata.DeletionMarkFilename))
+	testutil.Ok(t, err)
+	testutil.Assert(t, !ok, "duplicate with missing replacement marked for deletion")
+
+	// Once the replacement is complete again, the duplicate is collected.
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), block.IndexFilename), index))
+	testutil.Ok(t, sy.GarbageCollect(ctx))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(garbageCollectedBlocks))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(check.unverified))
+	ok, err = bkt.Exists(ctx, path.Join(dup.ULID.String(), metadata.DeletionMarkFilename))
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok, "duplicate with verified replacement not marked for deletion")
+}
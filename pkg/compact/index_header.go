@@ -0,0 +1,84 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/providers/filesystem"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/indexheader"
+)
+
+// IndexHeaderCompactionLifecycleCallback is a CompactionLifecycleCallback that builds the index-header of every
+// compacted block from its index, once the callback it wraps is done, and uploads it next to the block, for the store
+// gateway to download it instead of building it from the index in the bucket. The index-header is optional, so
+// failing to build or upload it is not failing the compaction.
+type IndexHeaderCompactionLifecycleCallback struct {
+	CompactionLifecycleCallback
+
+	bkt           objstore.Bucket
+	compactDir    string
+	buildDuration prometheus.Histogram
+	failures      prometheus.Counter
+}
+
+// NewIndexHeaderCompactionLifecycleCallback returns a callback wrapping the callback and building the index-headers
+// of the blocks compacted by a BucketCompactor with the compactDir work directory.
+func NewIndexHeaderCompactionLifecycleCallback(callback CompactionLifecycleCallback, reg prometheus.Registerer, bkt objstore.Bucket, compactDir string) *IndexHeaderCompactionLifecycleCallback {
+	return &IndexHeaderCompactionLifecycleCallback{
+		CompactionLifecycleCallback: callback,
+		bkt:                         bkt,
+		compactDir:                  compactDir,
+		buildDuration: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Name:    "thanos_compact_index_header_build_duration_seconds",
+			Help:    "Duration of the building of the index-headers of compacted blocks from their local index.",
+			Buckets: []float64{0.01, 0.1, 0.5, 1, 5, 15, 30, 60, 120},
+		}),
+		failures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_compact_index_header_failures_total",
+			Help: "Total number of compacted blocks for which building or uploading the index-header failed.",
+		}),
+	}
+}
+
+func (c *IndexHeaderCompactionLifecycleCallback) PostCompactionCallback(ctx context.Context, logger log.Logger, cg *Group, id ulid.ULID) error {
+	if err := c.CompactionLifecycleCallback.PostCompactionCallback(ctx, logger, cg, id); err != nil {
+		return err
+	}
+
+	begin := time.Now()
+	// The compacted block is still in the work directory of the group until the group compaction is done, so that the
+	// index-header is built from its local index.
+	groupDir := filepath.Join(c.compactDir, cg.Key())
+	localBkt, err := filesystem.NewBucket(groupDir)
+	if err != nil {
+		c.failures.Inc()
+		level.Warn(logger).Log("msg", "failed to open compacted block to build index-header", "block", id, "err", err)
+		return nil
+	}
+	fn := filepath.Join(groupDir, id.String(), block.IndexHeaderFilename)
+	if _, err := indexheader.WriteBinary(ctx, localBkt, id, fn, c.buildDuration); err != nil {
+		c.failures.Inc()
+		level.Warn(logger).Log("msg", "failed to build index-header", "block", id, "err", err)
+		return nil
+	}
+	if err := objstore.UploadFile(ctx, logger, c.bkt, fn, path.Join(id.String(), block.IndexHeaderFilename)); err != nil {
+		c.failures.Inc()
+		level.Warn(logger).Log("msg", "failed to upload index-header", "block", id, "err", err)
+		return nil
+	}
+	level.Debug(logger).Log("msg", "uploaded index-header", "block", id, "duration", time.Since(begin), "duration_ms", time.Since(begin).Milliseconds())
+	return nil
+}
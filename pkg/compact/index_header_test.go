@@ -0,0 +1,59 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/indexheader"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestIndexHeaderCompactionLifecycleCallback(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	compactDir := t.TempDir()
+	g := &Group{key: "0@123"}
+	id, err := e2eutil.CreateBlock(ctx, filepath.Join(compactDir, g.Key()), []labels.Labels{
+		labels.FromStrings("a", "1"),
+		labels.FromStrings("a", "2", "b", "1"),
+	}, 10, 0, 1000, labels.EmptyLabels(), 124, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+
+	bkt := objstore.NewInMemBucket()
+	c := NewIndexHeaderCompactionLifecycleCallback(DefaultCompactionLifecycleCallback{}, prometheus.NewRegistry(), bkt, compactDir)
+	testutil.Ok(t, c.PostCompactionCallback(ctx, log.NewNopLogger(), g, id))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(c.failures))
+
+	// The uploaded index-header is read without the index of the block.
+	ok, err := bkt.Exists(ctx, path.Join(id.String(), block.IndexHeaderFilename))
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok, "index-header not uploaded")
+	pool := indexheader.NewReaderPool(log.NewNopLogger(), false, 0, indexheader.NewReaderPoolMetrics(nil), indexheader.AlwaysEagerDownloadIndexHeader)
+	pool.SetPrebuiltIndexHeaders(true)
+	r, err := pool.NewBinaryReader(ctx, log.NewNopLogger(), bkt, t.TempDir(), id, 32, nil)
+	testutil.Ok(t, err)
+	names, err := r.LabelNames()
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"a", "b"}, names)
+	testutil.Ok(t, r.Close())
+
+	// A missing index does not fail the compaction.
+	testutil.Ok(t, c.PostCompactionCallback(ctx, log.NewNopLogger(), g, ulid.MustNew(1, nil)))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(c.failures))
+}
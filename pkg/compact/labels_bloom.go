@@ -0,0 +1,68 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+)
+
+// LabelsBloomCompactionLifecycleCallback is a CompactionLifecycleCallback that builds the bloom filter of the label
+// pairs of every compacted block from its index and uploads it next to the block, for the store gateway to skip
+// blocks without the label pairs of queries. The bloom filter is optional, so failing to build or upload it is not
+// failing the compaction.
+type LabelsBloomCompactionLifecycleCallback struct {
+	DefaultCompactionLifecycleCallback
+
+	bkt               objstore.Bucket
+	compactDir        string
+	falsePositiveRate float64
+	failures          prometheus.Counter
+}
+
+// NewLabelsBloomCompactionLifecycleCallback returns a callback building the bloom filters of the blocks compacted by
+// a BucketCompactor with the compactDir work directory.
+func NewLabelsBloomCompactionLifecycleCallback(reg prometheus.Registerer, bkt objstore.Bucket, compactDir string, falsePositiveRate float64) *LabelsBloomCompactionLifecycleCallback {
+	return &LabelsBloomCompactionLifecycleCallback{
+		bkt:               bkt,
+		compactDir:        compactDir,
+		falsePositiveRate: falsePositiveRate,
+		failures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_compact_labels_bloom_failures_total",
+			Help: "Total number of compacted blocks for which building or uploading the labels bloom filter failed.",
+		}),
+	}
+}
+
+func (c *LabelsBloomCompactionLifecycleCallback) PostCompactionCallback(ctx context.Context, logger log.Logger, cg *Group, id ulid.ULID) error {
+	begin := time.Now()
+	// The compacted block is still in the work directory of the group until the group compaction is done.
+	indexFn := filepath.Join(c.compactDir, cg.Key(), id.String(), block.IndexFilename)
+	b, err := block.BuildLabelsBloom(ctx, indexFn, c.falsePositiveRate)
+	if err != nil {
+		c.failures.Inc()
+		level.Warn(logger).Log("msg", "failed to build labels bloom filter", "block", id, "err", err)
+		return nil
+	}
+	buf := b.Encode()
+	if err := c.bkt.Upload(ctx, path.Join(id.String(), block.LabelsBloomFilename), bytes.NewReader(buf)); err != nil {
+		c.failures.Inc()
+		level.Warn(logger).Log("msg", "failed to upload labels bloom filter", "block", id, "err", err)
+		return nil
+	}
+	level.Debug(logger).Log("msg", "uploaded labels bloom filter", "block", id, "size", len(buf), "duration", time.Since(begin), "duration_ms", time.Since(begin).Milliseconds())
+	return nil
+}
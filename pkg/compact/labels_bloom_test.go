@@ -0,0 +1,57 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"io"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestLabelsBloomCompactionLifecycleCallback(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	compactDir := t.TempDir()
+	g := &Group{key: "0@123"}
+	id, err := e2eutil.CreateBlock(ctx, filepath.Join(compactDir, g.Key()), []labels.Labels{
+		labels.FromStrings("a", "1"),
+		labels.FromStrings("a", "2", "b", "1"),
+	}, 10, 0, 1000, labels.EmptyLabels(), 124, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+
+	bkt := objstore.NewInMemBucket()
+	c := NewLabelsBloomCompactionLifecycleCallback(prometheus.NewRegistry(), bkt, compactDir, 0.01)
+	testutil.Ok(t, c.PostCompactionCallback(ctx, log.NewNopLogger(), g, id))
+
+	rc, err := bkt.Get(ctx, path.Join(id.String(), block.LabelsBloomFilename))
+	testutil.Ok(t, err)
+	buf, err := io.ReadAll(rc)
+	testutil.Ok(t, err)
+	testutil.Ok(t, rc.Close())
+	b, err := block.DecodeLabelsBloom(buf)
+	testutil.Ok(t, err)
+	testutil.Assert(t, b.MayContain("a", "1"))
+	testutil.Assert(t, b.MayContain("a", "2"))
+	testutil.Assert(t, b.MayContain("b", "1"))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(c.failures))
+
+	// A missing index does not fail the compaction.
+	testutil.Ok(t, c.PostCompactionCallback(ctx, log.NewNopLogger(), g, ulid.MustNew(1, nil)))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(c.failures))
+}
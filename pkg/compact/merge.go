@@ -0,0 +1,77 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// LabelMergePolicy lets the DefaultGrouper group the blocks whose external labels differ only in ignorable labels,
+// for example a replica label renamed or changed in a migration. The blocks of a merged group are compacted into
+// blocks with the labels of the group rewritten by the policy: the ignorable labels are removed and the labels to
+// set are added.
+//
+// The blocks overlapping in time, like the blocks of replicas of the same data, can only be merged with vertical
+// compaction enabled.
+type LabelMergePolicy struct {
+	ignorable map[string]struct{}
+	set       labels.Labels
+}
+
+// NewLabelMergePolicy returns the policy merging the blocks differing only in the ignorable labels, and setting
+// the labels to set on the blocks compacted from them. The labels to set have to be ignorable, so that the compacted
+// blocks are merged with the blocks they were compacted from.
+func NewLabelMergePolicy(ignorable []string, set labels.Labels) (*LabelMergePolicy, error) {
+	p := &LabelMergePolicy{ignorable: map[string]struct{}{}, set: set}
+	for _, name := range ignorable {
+		if !model.LabelName(name).IsValid() {
+			return nil, errors.Errorf("invalid ignorable label name %q", name)
+		}
+		p.ignorable[name] = struct{}{}
+	}
+	var err error
+	set.Range(func(l labels.Label) {
+		if _, ok := p.ignorable[l.Name]; !ok && err == nil {
+			err = errors.Errorf("label %s to set is not ignorable", l.Name)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Labels returns the external labels of the blocks compacted from blocks with the external labels.
+func (p *LabelMergePolicy) Labels(lset map[string]string) map[string]string {
+	merged := make(map[string]string, len(lset))
+	for n, v := range lset {
+		if _, ok := p.ignorable[n]; !ok {
+			merged[n] = v
+		}
+	}
+	p.set.Range(func(l labels.Label) {
+		merged[l.Name] = l.Value
+	})
+	return merged
+}
+
+// GroupKey returns the key of the compaction group of the block with the policy.
+func (p *LabelMergePolicy) GroupKey(m *metadata.Thanos) string {
+	merged := *m
+	merged.Labels = p.Labels(m.Labels)
+	return merged.GroupKey()
+}
+
+// UnshardedGroupKey returns the key of the compaction group of the block with the policy, regardless of its shard.
+// The deduplicate filter has to group the blocks by it, for the blocks compacted from a merged group to replace
+// their sources.
+func (p *LabelMergePolicy) UnshardedGroupKey(m *metadata.Thanos) string {
+	merged := *m
+	merged.Labels = p.Labels(m.Labels)
+	return merged.UnshardedGroupKey()
+}
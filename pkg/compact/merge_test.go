@@ -0,0 +1,78 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/extprom"
+)
+
+func TestDefaultGrouper_SetLabelMergePolicy(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewLabelMergePolicy([]string{"replica"}, labels.FromStrings("a", "1"))
+	testutil.NotOk(t, err)
+	_, err = NewLabelMergePolicy([]string{""}, labels.EmptyLabels())
+	testutil.NotOk(t, err)
+	policy, err := NewLabelMergePolicy([]string{"replica", "prometheus_replica"}, labels.FromStrings("replica", "merged"))
+	testutil.Ok(t, err)
+
+	newMeta := func(id uint64, lset map[string]string, sources ...ulid.ULID) *metadata.Meta {
+		m := &metadata.Meta{}
+		m.ULID = ulid.MustNew(id, nil)
+		m.Compaction.Sources = append(sources, m.ULID)
+		m.Thanos.Labels = lset
+		return m
+	}
+	before := newMeta(1, map[string]string{"a": "1", "prometheus_replica": "r0"})
+	after := newMeta(2, map[string]string{"a": "1", "replica": "r0"})
+	other := newMeta(3, map[string]string{"a": "2", "replica": "r0"})
+	metas := map[ulid.ULID]*metadata.Meta{before.ULID: before, after.ULID: after, other.ULID: other}
+
+	temp := promauto.With(nil).NewCounter(prometheus.CounterOpts{Name: "test_metric_for_label_merge"})
+	grouper := NewDefaultGrouper(log.NewNopLogger(), objstore.NewInMemBucket(), false, false, nil, temp, temp, temp, "", 1, 1)
+	groups, err := grouper.Groups(metas)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, len(groups))
+
+	grouper.SetLabelMergePolicy(policy)
+	groups, err = grouper.Groups(metas)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(groups))
+	byKey := map[string]*Group{}
+	for _, g := range groups {
+		byKey[g.Key()] = g
+	}
+	merged := byKey[policy.GroupKey(&before.Thanos)]
+	testutil.Assert(t, merged != nil, "no merged group")
+	testutil.Equals(t, policy.GroupKey(&after.Thanos), merged.Key())
+	testutil.Equals(t, []ulid.ULID{before.ULID, after.ULID}, merged.IDs())
+	testutil.Equals(t, labels.FromStrings("a", "1", "replica", "merged"), merged.Labels())
+	testutil.Equals(t, []ulid.ULID{other.ULID}, byKey[policy.GroupKey(&other.Thanos)].IDs())
+	testutil.NotOk(t, merged.AppendMeta(other))
+
+	// The blocks compacted from a merged group replace their sources.
+	compacted := newMeta(4, map[string]string{"a": "1", "replica": "merged"}, before.ULID, after.ULID)
+	compacted.Compaction.Level = 2
+	metas[compacted.ULID] = compacted
+	f := block.NewDeduplicateFilter(1)
+	f.SetGroupKeyFunc(policy.UnshardedGroupKey)
+	synced := extprom.NewTxGaugeVec(nil, prometheus.GaugeOpts{}, []string{"state"})
+	testutil.Ok(t, f.Filter(context.Background(), metas, synced, nil))
+	testutil.Equals(t, 2, len(metas))
+	testutil.Equals(t, 2, len(f.DuplicateIDs()))
+	testutil.Assert(t, metas[compacted.ULID] != nil, "compacted block filtered")
+}
@@ -0,0 +1,31 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/metadata/metadatapb"
+)
+
+// mergeMetricMetadata writes the metric metadata of the blocks compacted from the directories in dir into the metric
+// metadata file of the compacted block in bdir. Shards keep the metadata of all the metrics, as it is small.
+func mergeMetricMetadata(dir, bdir string, compacted []*metadata.Meta) error {
+	var mds []map[string][]metadatapb.Meta
+	for _, m := range compacted {
+		if !block.HasMetricMetadata(m) {
+			continue
+		}
+		md, err := block.ReadMetricMetadataFromDir(filepath.Join(dir, m.ULID.String()))
+		if err != nil {
+			return errors.Wrapf(err, "read metric metadata of block %s", m.ULID)
+		}
+		mds = append(mds, md)
+	}
+	return block.WriteMetricMetadataFile(bdir, block.MergeMetricMetadata(mds...))
+}
@@ -0,0 +1,155 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+)
+
+// SortingBlockPopulator populates blocks like its BlockPopulator, but tolerates blocks with out-of-order chunks, e.g.
+// written by TSDBs with an out-of-order time window. The chunks of every series are sorted by time, and the chunks
+// overlapping each other are merged into chunks without duplicated samples, so that the populated block has no
+// out-of-order chunks.
+type SortingBlockPopulator struct {
+	tsdb.BlockPopulator
+}
+
+// NewSortingBlockPopulator returns the populator sorting the out-of-order chunks of the blocks populated by p.
+func NewSortingBlockPopulator(p tsdb.BlockPopulator) SortingBlockPopulator {
+	return SortingBlockPopulator{BlockPopulator: p}
+}
+
+func (p SortingBlockPopulator) PopulateBlock(ctx context.Context, metrics *tsdb.CompactorMetrics, logger *slog.Logger, chunkPool chunkenc.Pool, mergeFunc storage.VerticalChunkSeriesMergeFunc, blocks []tsdb.BlockReader, meta *tsdb.BlockMeta, indexw tsdb.IndexWriter, chunkw tsdb.ChunkWriter, postingsFunc tsdb.IndexReaderPostingsFunc) error {
+	sorting := make([]tsdb.BlockReader, 0, len(blocks))
+	for _, b := range blocks {
+		sorting = append(sorting, &sortingBlockReader{BlockReader: b, merged: map[chunks.ChunkRef][]chunks.Meta{}})
+	}
+	return p.BlockPopulator.PopulateBlock(ctx, metrics, logger, chunkPool, mergeFunc, sorting, meta, indexw, chunkw, postingsFunc)
+}
+
+// sortingBlockReader reads the chunks of the series of the block sorted by time. The chunks overlapping each other
+// are read as a single chunk, which refers to the first of them, and whose samples are merged from all of them.
+type sortingBlockReader struct {
+	tsdb.BlockReader
+
+	mtx sync.Mutex
+	// merged is the overlapping chunks of the series read so far whose samples were not read yet, by the
+	// reference of the chunk they are read as.
+	merged map[chunks.ChunkRef][]chunks.Meta
+}
+
+func (b *sortingBlockReader) Index() (tsdb.IndexReader, error) {
+	ir, err := b.BlockReader.Index()
+	if err != nil {
+		return nil, err
+	}
+	return sortingIndexReader{IndexReader: ir, b: b}, nil
+}
+
+func (b *sortingBlockReader) Chunks() (tsdb.ChunkReader, error) {
+	cr, err := b.BlockReader.Chunks()
+	if err != nil {
+		return nil, err
+	}
+	return sortingChunkReader{ChunkReader: cr, b: b}, nil
+}
+
+type sortingIndexReader struct {
+	tsdb.IndexReader
+	b *sortingBlockReader
+}
+
+func (r sortingIndexReader) Series(ref storage.SeriesRef, builder *labels.ScratchBuilder, chks *[]chunks.Meta) error {
+	if err := r.IndexReader.Series(ref, builder, chks); err != nil {
+		return err
+	}
+	if len(*chks) < 2 || chunksInOrder(*chks) {
+		return nil
+	}
+
+	sorted := slices.Clone(*chks)
+	slices.SortStableFunc(sorted, func(a, b chunks.Meta) int {
+		return cmp.Or(cmp.Compare(a.MinTime, b.MinTime), cmp.Compare(a.MaxTime, b.MaxTime))
+	})
+
+	res := (*chks)[:0]
+	r.b.mtx.Lock()
+	defer r.b.mtx.Unlock()
+	for i := 0; i < len(sorted); {
+		run := sorted[i : i+1]
+		maxt := sorted[i].MaxTime
+		for j := i + 1; j < len(sorted) && sorted[j].MinTime <= maxt; j++ {
+			run = sorted[i : j+1]
+			maxt = max(maxt, sorted[j].MaxTime)
+		}
+		c := run[0]
+		if len(run) > 1 {
+			c.MaxTime = maxt
+			r.b.merged[c.Ref] = slices.Clone(run)
+		}
+		res = append(res, c)
+		i += len(run)
+	}
+	*chks = res
+	return nil
+}
+
+// chunksInOrder returns true if every chunk starts after the previous one ends.
+func chunksInOrder(chks []chunks.Meta) bool {
+	for i := 1; i < len(chks); i++ {
+		if chks[i].MinTime <= chks[i-1].MaxTime {
+			return false
+		}
+	}
+	return true
+}
+
+type sortingChunkReader struct {
+	tsdb.ChunkReader
+	b *sortingBlockReader
+}
+
+func (r sortingChunkReader) ChunkOrIterable(meta chunks.Meta) (chunkenc.Chunk, chunkenc.Iterable, error) {
+	r.b.mtx.Lock()
+	run, ok := r.b.merged[meta.Ref]
+	delete(r.b.merged, meta.Ref)
+	r.b.mtx.Unlock()
+	if !ok {
+		return r.ChunkReader.ChunkOrIterable(meta)
+	}
+
+	merged := make(mergedIterable, 0, len(run))
+	for _, m := range run {
+		chk, iterable, err := r.ChunkReader.ChunkOrIterable(m)
+		if err != nil {
+			return nil, nil, err
+		}
+		if chk != nil {
+			iterable = chk
+		}
+		merged = append(merged, iterable)
+	}
+	return nil, merged, nil
+}
+
+// mergedIterable iterates the samples of overlapping chunks in time order, without duplicated samples.
+type mergedIterable []chunkenc.Iterable
+
+func (m mergedIterable) Iterator(it chunkenc.Iterator) chunkenc.Iterator {
+	its := make([]chunkenc.Iterator, 0, len(m))
+	for _, c := range m {
+		its = append(its, c.Iterator(nil))
+	}
+	return storage.ChainSampleIteratorFromIterators(it, its)
+}
@@ -0,0 +1,114 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/promslog"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+// outOfOrderBlockReader reads the chunks of every series in reverse order, with the last chunk duplicated.
+type outOfOrderBlockReader struct {
+	tsdb.BlockReader
+}
+
+func (b outOfOrderBlockReader) Index() (tsdb.IndexReader, error) {
+	ir, err := b.BlockReader.Index()
+	return outOfOrderIndexReader{IndexReader: ir}, err
+}
+
+type outOfOrderIndexReader struct {
+	tsdb.IndexReader
+}
+
+func (r outOfOrderIndexReader) Series(ref storage.SeriesRef, builder *labels.ScratchBuilder, chks *[]chunks.Meta) error {
+	if err := r.IndexReader.Series(ref, builder, chks); err != nil {
+		return err
+	}
+	slices.Reverse(*chks)
+	*chks = append(*chks, (*chks)[0])
+	return nil
+}
+
+func TestSortingBlockPopulator(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	series := []labels.Labels{labels.FromStrings("a", "1"), labels.FromStrings("a", "2")}
+	id, err := e2eutil.CreateBlock(ctx, dir, series, 300, 0, 1000, labels.EmptyLabels(), 0, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+	b, err := tsdb.OpenBlock(promslog.NewNopLogger(), filepath.Join(dir, id.String()), nil, nil)
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, b.Close()) })
+
+	populate := func(p tsdb.BlockPopulator, out string) error {
+		indexw, err := index.NewWriter(ctx, filepath.Join(out, block.IndexFilename))
+		testutil.Ok(t, err)
+		chunkw, err := chunks.NewWriter(filepath.Join(out, block.ChunksDirname))
+		testutil.Ok(t, err)
+		defer func() {
+			testutil.Ok(t, chunkw.Close())
+			_ = indexw.Close()
+		}()
+		meta := b.Meta()
+		return p.PopulateBlock(ctx, tsdb.NewCompactorMetrics(nil), promslog.NewNopLogger(), chunkenc.NewPool(),
+			storage.NewCompactingChunkSeriesMerger(storage.ChainedSeriesMerge), []tsdb.BlockReader{outOfOrderBlockReader{BlockReader: b}},
+			&meta, indexw, chunkw, tsdb.AllSortedPostings)
+	}
+
+	// The out-of-order chunks cannot be written into a block as they are.
+	testutil.NotOk(t, populate(tsdb.DefaultBlockPopulator{}, t.TempDir()))
+
+	out := t.TempDir()
+	testutil.Ok(t, populate(NewSortingBlockPopulator(tsdb.DefaultBlockPopulator{}), out))
+
+	stats, err := block.GatherIndexHealthStats(ctx, log.NewNopLogger(), filepath.Join(out, block.IndexFilename), 0, math.MaxInt64)
+	testutil.Ok(t, err)
+	testutil.Ok(t, stats.AnyErr())
+	testutil.Equals(t, int64(2), stats.TotalSeries)
+	testutil.Equals(t, 0, stats.OutOfOrderChunks+stats.DuplicatedChunks)
+
+	ir, err := index.NewFileReader(filepath.Join(out, block.IndexFilename), index.DecodePostingsRaw)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, ir.Close()) }()
+	cr, err := chunks.NewDirReader(filepath.Join(out, block.ChunksDirname), nil)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, cr.Close()) }()
+
+	p := tsdb.AllSortedPostings(ctx, ir)
+	for p.Next() {
+		var (
+			builder labels.ScratchBuilder
+			chks    []chunks.Meta
+		)
+		testutil.Ok(t, ir.Series(p.At(), &builder, &chks))
+		samples := 0
+		for _, c := range chks {
+			chk, _, err := cr.ChunkOrIterable(c)
+			testutil.Ok(t, err)
+			samples += chk.NumSamples()
+		}
+		// No sample is lost or duplicated.
+		testutil.Equals(t, 300, samples)
+	}
+	testutil.Ok(t, p.Err())
+}
@@ -0,0 +1,159 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// PlanBenchmarkConfig is a configuration of the grouping and planning of compactions replayed by a PlanBenchmark.
+type PlanBenchmarkConfig struct {
+	Name    string
+	Grouper Grouper
+	// Planner plans the compactions of the groups. Planners keeping state across plannings, e.g. the small plan
+	// filter, should not be shared by configurations.
+	Planner Planner
+}
+
+// PlanBenchmarkResult is the outcome of the simulated compactions of the blocks of a snapshot with a configuration.
+type PlanBenchmarkResult struct {
+	Name string `json:"name"`
+	// Groups is the number of compaction groups of the blocks.
+	Groups int `json:"groups"`
+	// Compactions is the number of compactions planned until there is nothing left to compact.
+	Compactions int `json:"compactions"`
+	// Iterations is the number of compactor iterations the compactions take, every iteration compacting a plan of
+	// every group.
+	Iterations int `json:"iterations"`
+	// CompactedBlocks is the number of blocks of all plans, including the blocks produced by earlier compactions.
+	CompactedBlocks int `json:"compacted_blocks"`
+	// Blocks is the number of blocks left once compacted.
+	Blocks int `json:"blocks"`
+	// ReadBytes and WrittenBytes estimate the bytes downloaded and uploaded by the compactions. A compacted block is
+	// estimated to be as large as its blocks together, ignoring the series and samples deduplicated by vertical
+	// compactions.
+	ReadBytes    int64 `json:"read_bytes"`
+	WrittenBytes int64 `json:"written_bytes"`
+	// UnknownSizeBlocks is the number of compacted blocks of the snapshot whose size is unknown, left out of the
+	// estimated bytes.
+	UnknownSizeBlocks int `json:"unknown_size_blocks"`
+	// PlanningDuration is the time taken by grouping and planning.
+	PlanningDuration time.Duration `json:"planning_duration"`
+}
+
+// PlanBenchmark replays the blocks of a snapshot of a bucket through grouping and planning configurations, so that
+// planner changes can be evaluated offline against the blocks of real buckets.
+type PlanBenchmark struct {
+	metas          map[ulid.ULID]*metadata.Meta
+	noCompactMarks *GatherNoCompactionMarkFilter
+	savedAt        time.Time
+}
+
+// planBenchmarkSnapshot is the union of the formats of the snapshots a PlanBenchmark reads: the compactor state
+// snapshot and the JSON output of the bucket inspect tool.
+type planBenchmarkSnapshot struct {
+	SavedAt        time.Time                 `json:"saved_at"`
+	Metas          []*metadata.Meta          `json:"metas"`
+	Blocks         []*metadata.Meta          `json:"blocks"`
+	NoCompactMarks []*metadata.NoCompactMark `json:"no_compact_marks"`
+}
+
+// NewPlanBenchmark returns the benchmark of the blocks of the compactor state, not compacting the blocks with no
+// compaction marks.
+func NewPlanBenchmark(st *State) *PlanBenchmark {
+	b := &PlanBenchmark{
+		metas:          make(map[ulid.ULID]*metadata.Meta, len(st.Metas)),
+		noCompactMarks: NewGatherNoCompactionMarkFilter(log.NewNopLogger(), nil, 1),
+		savedAt:        st.SavedAt,
+	}
+	for _, m := range st.Metas {
+		b.metas[m.ULID] = m
+	}
+	b.noCompactMarks.restoreState(st)
+	return b
+}
+
+// ReadPlanBenchmark returns the benchmark of the blocks of the snapshot in the file, either a compactor state
+// snapshot or the JSON output of the bucket inspect tool.
+func ReadPlanBenchmark(path string) (*PlanBenchmark, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read snapshot")
+	}
+	var s planBenchmarkSnapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, errors.Wrap(err, "parse snapshot")
+	}
+	metas := append(s.Metas, s.Blocks...)
+	if len(metas) == 0 {
+		return nil, errors.Errorf("no blocks in snapshot %s", path)
+	}
+	return NewPlanBenchmark(&State{SavedAt: s.SavedAt, Metas: metas, NoCompactMarks: s.NoCompactMarks}), nil
+}
+
+// NoCompactMarkFilter returns the filter holding the no compaction marks of the snapshot, for NewPlanner.
+func (b *PlanBenchmark) NoCompactMarkFilter() *GatherNoCompactionMarkFilter {
+	return b.noCompactMarks
+}
+
+// Run simulates the compactions of the blocks with every configuration and returns their results in the same order.
+// Compacted blocks are dated at the time the snapshot was saved, if known, so that runs are reproducible.
+func (b *PlanBenchmark) Run(ctx context.Context, configs ...PlanBenchmarkConfig) ([]PlanBenchmarkResult, error) {
+	now := time.Now
+	if !b.savedAt.IsZero() {
+		now = func() time.Time { return b.savedAt }
+	}
+
+	results := make([]PlanBenchmarkResult, 0, len(configs))
+	for _, c := range configs {
+		begin := time.Now()
+		groups, err := c.Grouper.Groups(b.metas)
+		if err != nil {
+			return nil, errors.Wrapf(err, "group blocks of %s", c.Name)
+		}
+		sim := NewPlanSimulator(c.Planner)
+		sim.SetClock(now)
+		compactions, err := sim.Simulate(ctx, groups)
+		if err != nil {
+			return nil, errors.Wrapf(err, "simulate compactions of %s", c.Name)
+		}
+
+		r := PlanBenchmarkResult{
+			Name:             c.Name,
+			Groups:           len(groups),
+			Compactions:      len(compactions),
+			PlanningDuration: time.Since(begin),
+		}
+		sizes := make(map[ulid.ULID]int64, len(compactions))
+		for _, sc := range compactions {
+			var size int64
+			for _, m := range sc.Blocks {
+				s, ok := sizes[m.ULID]
+				if !ok {
+					if s, ok = planSize([]*metadata.Meta{m}); !ok {
+						r.UnknownSizeBlocks++
+					}
+				}
+				size += s
+			}
+			sizes[sc.Result.ULID] = size
+			r.ReadBytes += size
+			r.WrittenBytes += size
+			r.CompactedBlocks += len(sc.Blocks)
+			r.Iterations = sc.Round + 1
+		}
+		r.Blocks = len(b.metas) + r.Compactions - r.CompactedBlocks
+		results = append(results, r)
+	}
+	return results, nil
+}
@@ -0,0 +1,79 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+func TestPlanBenchmark(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+	st := State{Version: StateVersion1, SavedAt: time.Unix(1600000000, 0)}
+	for i := uint64(0); i < 8; i++ {
+		m := createBlockMeta(i, int64(i)*int64(2*time.Hour/time.Millisecond), int64(i+1)*int64(2*time.Hour/time.Millisecond), map[string]string{"a": "1"}, 0, []uint64{i})
+		m.Thanos.Files = []metadata.File{{RelPath: "index", SizeBytes: 100}}
+		st.Metas = append(st.Metas, m)
+	}
+	// The last block is marked for no compaction.
+	st.NoCompactMarks = []*metadata.NoCompactMark{{ID: ulid.MustNew(7, nil), Reason: metadata.ManualNoCompactReason}}
+	b, err := json.Marshal(st)
+	testutil.Ok(t, err)
+	path := filepath.Join(t.TempDir(), StateFilename)
+	testutil.Ok(t, os.WriteFile(path, b, 0600))
+
+	bench, err := ReadPlanBenchmark(path)
+	testutil.Ok(t, err)
+
+	ranges := []int64{int64(2 * time.Hour / time.Millisecond), int64(8 * time.Hour / time.Millisecond)}
+	temp := promauto.With(nil).NewCounter(prometheus.CounterOpts{Name: "test_metric_for_plan_benchmark"})
+	newGrouper := func() Grouper {
+		return NewDefaultGrouper(logger, nil, false, false, nil, temp, temp, temp, "", 1, 1)
+	}
+	results, err := bench.Run(context.Background(),
+		PlanBenchmarkConfig{Name: "default", Grouper: newGrouper(), Planner: NewPlanner(logger, ranges, bench.NoCompactMarkFilter())},
+		PlanBenchmarkConfig{Name: "batches", Grouper: newGrouper(), Planner: WithMaxPlanBlocksFilter(NewPlanner(logger, ranges, bench.NoCompactMarkFilter()), logger, 2)},
+	)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(results))
+
+	def, batches := results[0], results[1]
+	testutil.Equals(t, "default", def.Name)
+	testutil.Equals(t, 1, def.Groups)
+	// The blocks of the first 8h are compacted at once, the blocks of the next 8h are not as the last is marked.
+	testutil.Equals(t, 1, def.Compactions)
+	testutil.Equals(t, 1, def.Iterations)
+	testutil.Equals(t, 4, def.CompactedBlocks)
+	testutil.Equals(t, 5, def.Blocks)
+	testutil.Equals(t, int64(400), def.ReadBytes)
+	testutil.Equals(t, int64(400), def.WrittenBytes)
+	testutil.Equals(t, 0, def.UnknownSizeBlocks)
+
+	// Batches of 2 blocks take more compactions and iterations, reading and writing the first blocks again.
+	testutil.Equals(t, "batches", batches.Name)
+	testutil.Equals(t, 3, batches.Compactions)
+	testutil.Equals(t, 3, batches.Iterations)
+	testutil.Equals(t, 6, batches.CompactedBlocks)
+	testutil.Equals(t, 5, batches.Blocks)
+	testutil.Equals(t, int64(200+300+400), batches.ReadBytes)
+
+	// Snapshots without blocks are rejected.
+	testutil.Ok(t, os.WriteFile(path, []byte(`{"blocks": []}`), 0600))
+	_, err = ReadPlanBenchmark(path)
+	testutil.NotOk(t, err)
+}
@@ -5,14 +5,20 @@ package compact
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
 	"math"
 	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
 
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/oklog/ulid/v2"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/tsdb"
 	"github.com/thanos-io/objstore"
 
 	"github.com/thanos-io/thanos/pkg/block"
@@ -56,13 +62,13 @@ func (p *tsdbBasedPlanner) Plan(_ context.Context, metasByMinTime []*metadata.Me
 func (p *tsdbBasedPlanner) plan(noCompactMarked map[ulid.ULID]*metadata.NoCompactMark, metasByMinTime []*metadata.Meta) ([]*metadata.Meta, error) {
 	notExcludedMetasByMinTime := make([]*metadata.Meta, 0, len(metasByMinTime))
 	for _, meta := range metasByMinTime {
-		if _, excluded := noCompactMarked[meta.ULID]; excluded {
+		if excludedFromAll(noCompactMarked, meta.ULID) {
 			continue
 		}
 		notExcludedMetasByMinTime = append(notExcludedMetasByMinTime, meta)
 	}
 
-	res := selectOverlappingMetas(notExcludedMetasByMinTime)
+	res := selectNotExcludedOverlappingMetas(noCompactMarked, notExcludedMetasByMinTime)
 	if len(res) > 0 {
 		return res, nil
 	}
@@ -70,7 +76,7 @@ func (p *tsdbBasedPlanner) plan(noCompactMarked map[ulid.ULID]*metadata.NoCompac
 
 	// We do not include a recently produced block with max(minTime), so the block which was just uploaded to bucket.
 	// This gives users a window of a full block size maintenance if needed.
-	if _, excluded := noCompactMarked[metasByMinTime[len(metasByMinTime)-1].ULID]; !excluded {
+	if !excludedFromAll(noCompactMarked, metasByMinTime[len(metasByMinTime)-1].ULID) {
 		notExcludedMetasByMinTime = notExcludedMetasByMinTime[:len(notExcludedMetasByMinTime)-1]
 	}
 	metasByMinTime = metasByMinTime[:len(metasByMinTime)-1]
@@ -85,6 +91,9 @@ func (p *tsdbBasedPlanner) plan(noCompactMarked map[ulid.ULID]*metadata.NoCompac
 		if meta.MaxTime-meta.MinTime < p.ranges[len(p.ranges)/2] {
 			break
 		}
+		if excluded(noCompactMarked, meta.ULID, meta.MinTime, meta.MaxTime) {
+			continue
+		}
 		if float64(meta.Stats.NumTombstones)/float64(meta.Stats.NumSeries+1) > 0.05 {
 			return []*metadata.Meta{notExcludedMetasByMinTime[i]}, nil
 		}
@@ -135,7 +144,7 @@ func selectMetas(ranges []int64, noCompactMarked map[ulid.ULID]*metadata.NoCompa
 			// This is meant as short-term workaround to create ability for marking some blocks to not be touched for compaction.
 			lastExcluded := 0
 			for i, id := range p {
-				if _, excluded := noCompactMarked[id.ULID]; !excluded {
+				if !excluded(noCompactMarked, id.ULID, mint, maxt) {
 					continue
 				}
 				if len(p[lastExcluded:i]) > 1 {
@@ -152,6 +161,51 @@ func selectMetas(ranges []int64, noCompactMarked map[ulid.ULID]*metadata.NoCompa
 	return nil
 }
 
+// excluded returns true if the block is marked for no compaction into a block of the given time range.
+func excluded(noCompactMarked map[ulid.ULID]*metadata.NoCompactMark, id ulid.ULID, mint, maxt int64) bool {
+	m, ok := noCompactMarked[id]
+	return ok && m.Excludes(mint, maxt)
+}
+
+// excludedFromAll returns true if the block is marked for no compaction regardless of the time range.
+func excludedFromAll(noCompactMarked map[ulid.ULID]*metadata.NoCompactMark, id ulid.ULID) bool {
+	m, ok := noCompactMarked[id]
+	return ok && m.TimeRange == nil
+}
+
+// selectNotExcludedOverlappingMetas returns the overlapping metas like selectOverlappingMetas, without the blocks
+// marked for no compaction into a block of the time range of the overlapping metas.
+func selectNotExcludedOverlappingMetas(noCompactMarked map[ulid.ULID]*metadata.NoCompactMark, metasByMinTime []*metadata.Meta) []*metadata.Meta {
+	for {
+		overlapping := selectOverlappingMetas(metasByMinTime)
+		if len(overlapping) == 0 {
+			return nil
+		}
+		mint, maxt := overlapping[0].MinTime, overlapping[0].MaxTime
+		for _, m := range overlapping[1:] {
+			maxt = max(maxt, m.MaxTime)
+		}
+
+		excludedIDs := map[ulid.ULID]struct{}{}
+		for _, m := range overlapping {
+			if excluded(noCompactMarked, m.ULID, mint, maxt) {
+				excludedIDs[m.ULID] = struct{}{}
+			}
+		}
+		if len(excludedIDs) == 0 {
+			return overlapping
+		}
+		// Look for overlapping blocks again without the excluded ones, which may not overlap anymore.
+		notExcluded := make([]*metadata.Meta, 0, len(metasByMinTime)-len(excludedIDs))
+		for _, m := range metasByMinTime {
+			if _, ok := excludedIDs[m.ULID]; !ok {
+				notExcluded = append(notExcluded, m)
+			}
+		}
+		metasByMinTime = notExcluded
+	}
+}
+
 // selectOverlappingMetas returns all dirs with overlapping time ranges.
 // It expects sorted input by mint and returns the overlapping dirs in the same order as received.
 // Copied and adjusted from https://github.com/prometheus/prometheus/blob/3d8826a3d42566684283a9b7f7e812e412c24407/tsdb/compact.go#L268.
@@ -371,3 +425,229 @@ PlanLoop:
 func (t *largeTotalIndexSizeFilter) Plan(ctx context.Context, metasByMinTime []*metadata.Meta, _ chan error, _ any) ([]*metadata.Meta, error) {
 	return t.plan(ctx, nil, metasByMinTime)
 }
+
+type smallPlanFilter struct {
+	Planner
+
+	tsdbPlanner   *tsdbBasedPlanner
+	logger        log.Logger
+	minPlanBytes  int64
+	maxSkips      int
+	skippedGroups prometheus.Counter
+
+	mtx   sync.Mutex
+	skips map[string]int
+}
+
+var _ Planner = &smallPlanFilter{}
+
+// WithSmallPlanFilter wraps Planner with smallPlanFilter that merges plans whose blocks total less than minPlanBytes
+// with the adjacent plans the TSDB planner would compact their block with, and skips the merged plans still below
+// minPlanBytes for at most maxSkips consecutive plannings of their group. The blocks of low-volume groups are then
+// compacted straight into the blocks of the largest ranges completed meanwhile, rather than compacting nearly empty
+// blocks level after level.
+// NOTE: Plans of overlapping blocks are neither merged nor skipped. The size of blocks whose meta neither lists the
+// sizes of their files nor records the index stats of their series and chunks is unknown, and the plans with them are
+// never merged nor skipped.
+func WithSmallPlanFilter(with Planner, tsdbPlanner *tsdbBasedPlanner, logger log.Logger, minPlanBytes int64, maxSkips int, skippedGroups prometheus.Counter) Planner {
+	return &smallPlanFilter{
+		Planner:       with,
+		tsdbPlanner:   tsdbPlanner,
+		logger:        logger,
+		minPlanBytes:  minPlanBytes,
+		maxSkips:      maxSkips,
+		skippedGroups: skippedGroups,
+		skips:         map[string]int{},
+	}
+}
+
+func (s *smallPlanFilter) Plan(ctx context.Context, metasByMinTime []*metadata.Meta, errChan chan error, extensions any) ([]*metadata.Meta, error) {
+	plan, err := s.Planner.Plan(ctx, metasByMinTime, errChan, extensions)
+	if err != nil || len(plan) == 0 {
+		return plan, err
+	}
+	groupKey := plan[0].Thanos.GroupKey()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	size, known := planSize(plan)
+	if !known || size >= s.minPlanBytes || len(selectOverlappingMetas(plan)) > 0 {
+		delete(s.skips, groupKey)
+		return plan, nil
+	}
+
+	merged, err := s.merge(ctx, plan, metasByMinTime, errChan, extensions)
+	if err != nil {
+		return nil, errors.Wrap(err, "merge small plan")
+	}
+	mergedSize, _ := planSize(merged)
+	if mergedSize >= s.minPlanBytes || s.skips[groupKey] >= s.maxSkips {
+		delete(s.skips, groupKey)
+		if len(merged) > len(plan) {
+			level.Info(s.logger).Log("msg", "merged small plan with adjacent blocks", "group", groupKey,
+				"plan", fmt.Sprintf("%v", plan), "merged", fmt.Sprintf("%v", merged), "size_bytes", mergedSize)
+		}
+		return merged, nil
+	}
+	s.skips[groupKey]++
+	s.skippedGroups.Inc()
+	level.Info(s.logger).Log("msg", "skipping compaction of small plan to batch it with later blocks", "group", groupKey,
+		"plan", fmt.Sprintf("%v", merged), "size_bytes", mergedSize, "skips", s.skips[groupKey], "max_skips", s.maxSkips)
+	return nil, nil
+}
+
+// merge returns the largest plan of the blocks the TSDB planner would eventually compact the blocks of the plan with,
+// as long as they total less than the minimum size, or the first one reaching it. It plans the group again and again
+// as if each plan was compacted into a virtual block, until a plan merges the virtual block of the plan, whose blocks
+// are then all compacted into the same block as by the plans leading to it.
+func (s *smallPlanFilter) merge(ctx context.Context, plan, metasByMinTime []*metadata.Meta, errChan chan error, extensions any) ([]*metadata.Meta, error) {
+	var (
+		merged  = plan
+		sources = map[ulid.ULID][]*metadata.Meta{}
+		metas   = metasByMinTime
+		next    = plan
+	)
+	for range metasByMinTime {
+		// The blocks the next plan compacts, with the blocks of its virtual blocks.
+		var blocks []*metadata.Meta
+		for _, m := range next {
+			if src, ok := sources[m.ULID]; ok {
+				blocks = append(blocks, src...)
+				continue
+			}
+			blocks = append(blocks, m)
+		}
+		sort.Slice(blocks, func(i, j int) bool {
+			return blocks[i].MinTime < blocks[j].MinTime
+		})
+		if containsBlocks(blocks, merged) {
+			size, known := planSize(blocks)
+			if !known {
+				break
+			}
+			merged = blocks
+			if size >= s.minPlanBytes {
+				break
+			}
+		}
+
+		virtual := compactedMeta(next)
+		sources[virtual.ULID] = blocks
+		metas = replaceBlocks(metas, next, virtual)
+
+		var err error
+		next, err = s.tsdbPlanner.Plan(ctx, metas, errChan, extensions)
+		if err != nil {
+			return nil, err
+		}
+		if len(next) == 0 {
+			break
+		}
+	}
+	return merged, nil
+}
+
+// containsBlocks returns true if all the blocks of sub are in blocks.
+func containsBlocks(blocks, sub []*metadata.Meta) bool {
+	ids := make(map[ulid.ULID]struct{}, len(blocks))
+	for _, m := range blocks {
+		ids[m.ULID] = struct{}{}
+	}
+	for _, m := range sub {
+		if _, ok := ids[m.ULID]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// compactedMeta returns the meta of a virtual block compacted from the blocks of the plan.
+func compactedMeta(plan []*metadata.Meta) *metadata.Meta {
+	m := &metadata.Meta{BlockMeta: tsdb.BlockMeta{ULID: ulid.MustNew(ulid.Now(), rand.Reader), MinTime: plan[0].MinTime, MaxTime: plan[0].MaxTime}, Thanos: plan[0].Thanos}
+	for _, p := range plan[1:] {
+		m.MinTime = min(m.MinTime, p.MinTime)
+		m.MaxTime = max(m.MaxTime, p.MaxTime)
+	}
+	return m
+}
+
+// replaceBlocks returns the metas, sorted by min time, with the blocks of the plan replaced by the block.
+func replaceBlocks(metasByMinTime, plan []*metadata.Meta, compacted *metadata.Meta) []*metadata.Meta {
+	res := make([]*metadata.Meta, 0, len(metasByMinTime)-len(plan)+1)
+	for _, m := range metasByMinTime {
+		if !containsBlocks(plan, []*metadata.Meta{m}) {
+			res = append(res, m)
+		}
+	}
+	res = append(res, compacted)
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].MinTime < res[j].MinTime
+	})
+	return res
+}
+
+// planSize returns the total size of the blocks of the plan, and whether all of them are known. Blocks whose meta
+// does not list their files are estimated from the sizes of their series and chunks in the index stats.
+func planSize(plan []*metadata.Meta) (int64, bool) {
+	var size int64
+	for _, m := range plan {
+		if len(m.Thanos.Files) == 0 {
+			s := m.Thanos.IndexStats
+			if s.SeriesTotalSize+s.ChunkTotalSize == 0 {
+				return 0, false
+			}
+			size += s.SeriesTotalSize + s.ChunkTotalSize
+			continue
+		}
+		for _, f := range m.Thanos.Files {
+			size += f.SizeBytes
+		}
+	}
+	return size, true
+}
+
+type maxPlanBlocksFilter struct {
+	Planner
+
+	logger    log.Logger
+	maxBlocks int
+}
+
+var _ Planner = &maxPlanBlocksFilter{}
+
+// WithMaxPlanBlocksFilter wraps Planner with maxPlanBlocksFilter that limits plans to batches of at most maxBlocks
+// blocks, the ones with the lowest min time, and ULID for equal min times, so that the same batch is selected on
+// every planning. Groups with many small blocks, e.g. of receivers producing hundreds of 2h blocks per tenant and day,
+// are then compacted in several bounded compactions, each of the next batches with the block compacted from the
+// previous ones, rather than downloading all the blocks at once.
+func WithMaxPlanBlocksFilter(with Planner, logger log.Logger, maxBlocks int) Planner {
+	return &maxPlanBlocksFilter{Planner: with, logger: logger, maxBlocks: maxBlocks}
+}
+
+// ValidateMaxPlanBlocks returns an error if plans limited to maxBlocks blocks cannot make progress, as batches of a
+// single block would compact it again on every planning. 0 disables the limit.
+func ValidateMaxPlanBlocks(maxBlocks int) error {
+	if maxBlocks != 0 && maxBlocks < 2 {
+		return errors.Errorf("maximum number of blocks of compaction plans has to be 0 or at least 2, got %d", maxBlocks)
+	}
+	return nil
+}
+
+func (m *maxPlanBlocksFilter) Plan(ctx context.Context, metasByMinTime []*metadata.Meta, errChan chan error, extensions any) ([]*metadata.Meta, error) {
+	plan, err := m.Planner.Plan(ctx, metasByMinTime, errChan, extensions)
+	if err != nil || len(plan) <= m.maxBlocks {
+		return plan, err
+	}
+	batch := slices.Clone(plan)
+	sort.Slice(batch, func(i, j int) bool {
+		if batch[i].MinTime != batch[j].MinTime {
+			return batch[i].MinTime < batch[j].MinTime
+		}
+		return batch[i].ULID.Compare(batch[j].ULID) < 0
+	})
+	batch = batch[:m.maxBlocks]
+	level.Info(m.logger).Log("msg", "limiting compaction plan to a batch of blocks", "group", plan[0].Thanos.GroupKey(),
+		"planned_blocks", len(plan), "batch", fmt.Sprintf("%v", batch))
+	return batch, nil
+}
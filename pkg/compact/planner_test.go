@@ -620,6 +620,107 @@ func TestTSDBBasedPlanner_PlanWithNoCompactMarks(t *testing.T) {
 				ulid.MustNew(1, nil): {},
 			},
 		},
+		{
+			name: "Blocks to fill the entire parent, but with second one excluded from another time range.",
+			metas: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(1, nil), MinTime: 0, MaxTime: 20}},
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(2, nil), MinTime: 20, MaxTime: 40}},
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(3, nil), MinTime: 40, MaxTime: 60}},
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(4, nil), MinTime: 60, MaxTime: 80}},
+			},
+			noCompactMarks: map[ulid.ULID]*metadata.NoCompactMark{
+				ulid.MustNew(2, nil): {TimeRange: &metadata.NoCompactTimeRange{MinTime: 60, MaxTime: 180}},
+			},
+			expected: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(1, nil), MinTime: 0, MaxTime: 20}},
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(2, nil), MinTime: 20, MaxTime: 40}},
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(3, nil), MinTime: 40, MaxTime: 60}},
+			},
+		},
+		{
+			name: "Blocks to fill the entire parent, but with first one excluded from a time range of the parent.",
+			metas: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(1, nil), MinTime: 0, MaxTime: 20}},
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(2, nil), MinTime: 20, MaxTime: 40}},
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(3, nil), MinTime: 40, MaxTime: 60}},
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(4, nil), MinTime: 60, MaxTime: 80}},
+			},
+			noCompactMarks: map[ulid.ULID]*metadata.NoCompactMark{
+				ulid.MustNew(1, nil): {TimeRange: &metadata.NoCompactTimeRange{MinTime: 50, MaxTime: 55}},
+			},
+			expected: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(2, nil), MinTime: 20, MaxTime: 40}},
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(3, nil), MinTime: 40, MaxTime: 60}},
+			},
+		},
+		{
+			name: "Select large blocks that have many tombstones when fresh appears and are excluded from another time range",
+			metas: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(1, nil), MinTime: 0, MaxTime: 540, Stats: tsdb.BlockStats{
+					NumSeries:     10,
+					NumTombstones: 3,
+				}}},
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(2, nil), MinTime: 540, MaxTime: 560}},
+			},
+			noCompactMarks: map[ulid.ULID]*metadata.NoCompactMark{
+				ulid.MustNew(1, nil): {TimeRange: &metadata.NoCompactTimeRange{MinTime: 540, MaxTime: 1620}},
+			},
+			expected: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(1, nil), MinTime: 0, MaxTime: 540, Stats: tsdb.BlockStats{
+					NumSeries:     10,
+					NumTombstones: 3,
+				}}},
+			},
+		},
+		{
+			name: "Do not select large blocks that have many tombstones when fresh appears but are excluded from their time range",
+			metas: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(1, nil), MinTime: 0, MaxTime: 540, Stats: tsdb.BlockStats{
+					NumSeries:     10,
+					NumTombstones: 3,
+				}}},
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(2, nil), MinTime: 540, MaxTime: 560}},
+			},
+			noCompactMarks: map[ulid.ULID]*metadata.NoCompactMark{
+				ulid.MustNew(1, nil): {TimeRange: &metadata.NoCompactTimeRange{MinTime: 100, MaxTime: 200}},
+			},
+		},
+		// |--------------|
+		//               |----------------|
+		//                                |--------------|
+		{
+			name: "Overlapping blocks 1, but one is excluded from another time range",
+			metas: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(1, nil), MinTime: 0, MaxTime: 20}},
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(2, nil), MinTime: 19, MaxTime: 40}},
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(3, nil), MinTime: 40, MaxTime: 60}},
+			},
+			noCompactMarks: map[ulid.ULID]*metadata.NoCompactMark{
+				ulid.MustNew(1, nil): {TimeRange: &metadata.NoCompactTimeRange{MinTime: 40, MaxTime: 60}},
+			},
+			expected: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(1, nil), MinTime: 0, MaxTime: 20}},
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(2, nil), MinTime: 19, MaxTime: 40}},
+			},
+		},
+		// |--------------|
+		//               |----------------|
+		//               |----------------|
+		{
+			name: "Overlapping blocks 2, but one is excluded from their time range",
+			metas: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(1, nil), MinTime: 0, MaxTime: 20}},
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(2, nil), MinTime: 19, MaxTime: 40}},
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(3, nil), MinTime: 19, MaxTime: 40}},
+			},
+			noCompactMarks: map[ulid.ULID]*metadata.NoCompactMark{
+				ulid.MustNew(1, nil): {TimeRange: &metadata.NoCompactTimeRange{MinTime: 10, MaxTime: 15}},
+			},
+			expected: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(2, nil), MinTime: 19, MaxTime: 40}},
+				{BlockMeta: tsdb.BlockMeta{Version: 1, ULID: ulid.MustNew(3, nil), MinTime: 19, MaxTime: 40}},
+			},
+		},
 		// |--------------|
 		//               |----------------|
 		//                                |--------------|
@@ -868,3 +969,125 @@ func TestLargeTotalIndexSizeFilter_Plan(t *testing.T) {
 		}
 	}
 }
+
+type staticPlanner []*metadata.Meta
+
+func (p staticPlanner) Plan(context.Context, []*metadata.Meta, chan error, any) ([]*metadata.Meta, error) {
+	return p, nil
+}
+
+func TestSmallPlanFilter_Plan(t *testing.T) {
+	t.Parallel()
+
+	meta := func(id uint64, minTime, maxTime, size int64) *metadata.Meta {
+		m := &metadata.Meta{BlockMeta: tsdb.BlockMeta{ULID: ulid.MustNew(id, nil), MinTime: minTime, MaxTime: maxTime}}
+		if size > 0 {
+			m.Thanos.Files = []metadata.File{{RelPath: block.IndexFilename, SizeBytes: size}}
+		}
+		return m
+	}
+	// Blocks of 20 of three complete ranges of 60, making a complete range of 180, and the most recent block.
+	blocks := func(size int64) []*metadata.Meta {
+		var metas []*metadata.Meta
+		for i := range 10 {
+			metas = append(metas, meta(uint64(i+1), int64(i*20), int64(i*20+20), size))
+		}
+		return metas
+	}
+	tsdbPlanner := NewTSDBBasedPlanner(log.NewNopLogger(), []int64{20, 60, 180})
+	skipped := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+	newFilter := func(minPlanBytes int64) Planner {
+		return WithSmallPlanFilter(tsdbPlanner, tsdbPlanner, log.NewNopLogger(), minPlanBytes, 2, skipped)
+	}
+
+	// Without the filter, the blocks of 20 are compacted into blocks of 60 first.
+	plan, err := tsdbPlanner.Plan(context.Background(), blocks(10), nil, nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, blocks(10)[:3], plan)
+
+	// Small plans are merged with the adjacent plans, up to the range of 180, and skipped while still small.
+	small := newFilter(1000)
+	for range 2 {
+		plan, err := small.Plan(context.Background(), blocks(10), nil, nil)
+		testutil.Ok(t, err)
+		testutil.Equals(t, 0, len(plan))
+	}
+	// It is compacted after the maximum skips, and skipped again afterwards.
+	plan, err = small.Plan(context.Background(), blocks(10), nil, nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, blocks(10)[:9], plan)
+	plan, err = small.Plan(context.Background(), blocks(10), nil, nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(plan))
+	testutil.Equals(t, 3.0, promtest.ToFloat64(skipped))
+
+	// Merged plans reaching the minimum size are compacted right away.
+	plan, err = newFilter(500).Plan(context.Background(), blocks(100), nil, nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, blocks(100)[:9], plan)
+
+	// Plans reaching the minimum size are not merged.
+	plan, err = newFilter(300).Plan(context.Background(), blocks(100), nil, nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, blocks(100)[:3], plan)
+
+	// Plans are not merged into incomplete ranges.
+	incomplete := blocks(10)[:5]
+	plan, err = WithSmallPlanFilter(tsdbPlanner, tsdbPlanner, log.NewNopLogger(), 1000, 0, skipped).Plan(context.Background(), incomplete, nil, nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, incomplete[:3], plan)
+
+	// Plans with blocks of unknown size are neither merged nor skipped.
+	unknown := blocks(10)
+	unknown[1] = meta(2, 20, 40, 0)
+	plan, err = newFilter(1000).Plan(context.Background(), unknown, nil, nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, unknown[:3], plan)
+
+	// Blocks without files are sized by their index stats.
+	withStats := blocks(10)
+	withStats[1] = meta(2, 20, 40, 0)
+	withStats[1].Thanos.IndexStats = metadata.IndexStats{SeriesTotalSize: 20, ChunkTotalSize: 30}
+	plan, err = newFilter(1000).Plan(context.Background(), withStats, nil, nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(plan))
+
+	// Plans of overlapping blocks are neither merged nor skipped.
+	overlapping := append(blocks(10), meta(11, 10, 30, 10))
+	sort.Slice(overlapping, func(i, j int) bool { return overlapping[i].MinTime < overlapping[j].MinTime })
+	plan, err = newFilter(1000).Plan(context.Background(), overlapping, nil, nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, len(plan))
+}
+
+func TestMaxPlanBlocksFilter_Plan(t *testing.T) {
+	t.Parallel()
+
+	meta := func(id uint64, minTime int64) *metadata.Meta {
+		return &metadata.Meta{BlockMeta: tsdb.BlockMeta{ULID: ulid.MustNew(id, nil), MinTime: minTime, MaxTime: minTime + 10}}
+	}
+	plan := staticPlanner{meta(5, 0), meta(4, 20), meta(3, 0), meta(2, 10), meta(1, 30)}
+
+	batch, err := WithMaxPlanBlocksFilter(plan, log.NewNopLogger(), 3).Plan(context.Background(), nil, nil, nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []*metadata.Meta{meta(3, 0), meta(5, 0), meta(2, 10)}, batch)
+
+	batch, err = WithMaxPlanBlocksFilter(plan, log.NewNopLogger(), 5).Plan(context.Background(), nil, nil, nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []*metadata.Meta(plan), batch)
+
+	// The smallest limit still compacts blocks together.
+	testutil.Ok(t, ValidateMaxPlanBlocks(2))
+	batch, err = WithMaxPlanBlocksFilter(plan, log.NewNopLogger(), 2).Plan(context.Background(), nil, nil, nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []*metadata.Meta{meta(3, 0), meta(5, 0)}, batch)
+
+	batch, err = WithMaxPlanBlocksFilter(plan[:2], log.NewNopLogger(), 2).Plan(context.Background(), nil, nil, nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []*metadata.Meta(plan[:2]), batch)
+
+	// A limit of a single block would compact it alone again and again.
+	testutil.Ok(t, ValidateMaxPlanBlocks(0))
+	testutil.NotOk(t, ValidateMaxPlanBlocks(1))
+	testutil.NotOk(t, ValidateMaxPlanBlocks(-1))
+}
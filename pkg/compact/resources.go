@@ -0,0 +1,271 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+const (
+	// resourceModelDecay is the weight of the past compactions kept at every new compaction, so that the models
+	// follow changes of the blocks or of the resources of the compactor.
+	resourceModelDecay = 0.95
+	// minResourceObservations is the number of compactions of a resolution before its usage is predicted.
+	minResourceObservations = 3
+)
+
+// ResourcePrediction is the predicted usage of the compaction of the blocks of a plan, without their download and
+// upload.
+type ResourcePrediction struct {
+	Duration    time.Duration `json:"duration"`
+	MemoryBytes uint64        `json:"memoryBytes"`
+}
+
+// linearFit is a least squares fit of y = a + b*x, with exponentially decaying weights of the observations.
+type linearFit struct {
+	n, sx, sy, sxx, sxy float64
+}
+
+func (f *linearFit) observe(x, y float64) {
+	f.n = f.n*resourceModelDecay + 1
+	f.sx = f.sx*resourceModelDecay + x
+	f.sy = f.sy*resourceModelDecay + y
+	f.sxx = f.sxx*resourceModelDecay + x*x
+	f.sxy = f.sxy*resourceModelDecay + x*y
+}
+
+func (f *linearFit) predict(x float64) float64 {
+	var (
+		d = f.n*f.sxx - f.sx*f.sx
+		y float64
+	)
+	if d <= 1e-9*f.n*f.sxx {
+		// All the observations are of about the same x, the usage is assumed proportional to it.
+		if f.sx == 0 {
+			return f.sy / f.n
+		}
+		y = f.sy / f.sx * x
+	} else {
+		b := (f.n*f.sxy - f.sx*f.sy) / d
+		y = (f.sy-b*f.sx)/f.n + b*x
+	}
+	return max(0, y)
+}
+
+// resourceFits are the models of the compactions of a resolution.
+type resourceFits struct {
+	observations  int
+	duration, mem linearFit
+}
+
+// ResourceModel predicts the duration and the memory of the compaction of the blocks of a plan from their number
+// of samples, with linear models fitted online to the past compactions of the same resolution. The memory of a
+// compaction is estimated from the memory used by the compactor above its memory while idle, shared equally by
+// the compactions in flight.
+//
+// With a memory budget, the model is also the admission controller of compactions: a planned compaction waits
+// until its predicted memory fits in the budget together with the predicted memory of the compactions in flight.
+// A compaction is always admitted if no other one is in flight, and compactions are admitted without prediction
+// until enough compactions of their resolution were observed.
+type ResourceModel struct {
+	logger      log.Logger
+	budget      uint64
+	memoryUsage func() uint64
+
+	mtx        sync.Mutex
+	fits       map[int64]*resourceFits
+	inFlight   map[*trackedCompaction]struct{}
+	reserved   uint64
+	idleMemory uint64
+	wake       chan struct{}
+
+	ratio          *prometheus.HistogramVec
+	reservedMemory prometheus.Gauge
+	waits          prometheus.Counter
+}
+
+// NewResourceModel returns a model of the resource usage of compactions. A memoryBudget of 0 admits all the
+// compactions.
+func NewResourceModel(logger log.Logger, reg prometheus.Registerer, memoryBudget uint64) *ResourceModel {
+	return &ResourceModel{
+		logger:      logger,
+		budget:      memoryBudget,
+		memoryUsage: goMemoryUsage,
+		fits:        map[int64]*resourceFits{},
+		inFlight:    map[*trackedCompaction]struct{}{},
+		idleMemory:  goMemoryUsage(),
+		wake:        make(chan struct{}),
+		ratio: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "thanos_compact_compaction_actual_to_predicted_ratio",
+			Help:    "Ratio of the actual to the predicted usage of compactions, by resource: duration or memory.",
+			Buckets: prometheus.ExponentialBuckets(0.125, 2, 7),
+		}, []string{"resource"}),
+		reservedMemory: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_compact_memory_budget_reserved_bytes",
+			Help: "Predicted memory of the compactions in flight, reserved in the memory budget.",
+		}),
+		waits: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_compact_memory_budget_waits_total",
+			Help: "Total number of compactions which waited for the memory budget before being admitted.",
+		}),
+	}
+}
+
+// Predict returns the predicted usage of the compaction of samples samples of the resolution, and false if not
+// enough compactions of the resolution were observed yet. A nil model predicts nothing.
+func (m *ResourceModel) Predict(resolution int64, samples uint64) (ResourcePrediction, bool) {
+	if m == nil {
+		return ResourcePrediction{}, false
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.predict(resolution, samples)
+}
+
+// predict must be called with the mutex held.
+func (m *ResourceModel) predict(resolution int64, samples uint64) (ResourcePrediction, bool) {
+	f, ok := m.fits[resolution]
+	if !ok || f.observations < minResourceObservations {
+		return ResourcePrediction{}, false
+	}
+	return ResourcePrediction{
+		Duration:    time.Duration(f.duration.predict(float64(samples))),
+		MemoryBytes: uint64(f.mem.predict(float64(samples))),
+	}, true
+}
+
+// Run samples the memory used by the compactions in flight every interval until the context is canceled.
+func (m *ResourceModel) Run(ctx context.Context, interval time.Duration) error {
+	return runutil.Repeat(interval, ctx.Done(), func() error {
+		m.sample()
+		return nil
+	})
+}
+
+func (m *ResourceModel) sample() {
+	usage := m.memoryUsage()
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.observeMemory(usage)
+}
+
+// observeMemory must be called with the mutex held.
+func (m *ResourceModel) observeMemory(usage uint64) {
+	if len(m.inFlight) == 0 {
+		m.idleMemory = usage
+		return
+	}
+	if usage <= m.idleMemory {
+		return
+	}
+	share := (usage - m.idleMemory) / uint64(len(m.inFlight))
+	for c := range m.inFlight {
+		c.peakMemory = max(c.peakMemory, share)
+	}
+}
+
+// trackedCompaction is an admitted compaction whose usage is observed once done.
+type trackedCompaction struct {
+	m          *ResourceModel
+	resolution int64
+	samples    uint64
+	begin      time.Time
+	predicted  ResourcePrediction
+	known      bool
+	peakMemory uint64
+}
+
+// admit waits until the compaction of the plan fits in the memory budget and returns it tracked. done must be called
+// on the returned compaction once the compaction is done. A nil model admits and tracks nothing.
+func (m *ResourceModel) admit(ctx context.Context, resolution int64, plan []*metadata.Meta) (*trackedCompaction, error) {
+	if m == nil {
+		return nil, nil
+	}
+	c := &trackedCompaction{m: m, resolution: resolution}
+	for _, b := range plan {
+		c.samples += b.Stats.NumSamples
+	}
+
+	waited := false
+	for {
+		m.mtx.Lock()
+		c.predicted, c.known = m.predict(resolution, c.samples)
+		if m.budget == 0 || len(m.inFlight) == 0 || m.reserved+c.predicted.MemoryBytes <= m.budget {
+			if len(m.inFlight) == 0 {
+				m.idleMemory = m.memoryUsage()
+			}
+			m.inFlight[c] = struct{}{}
+			m.reserved += c.predicted.MemoryBytes
+			m.reservedMemory.Set(float64(m.reserved))
+			c.begin = time.Now()
+			m.mtx.Unlock()
+			return c, nil
+		}
+		wake := m.wake
+		if !waited {
+			waited = true
+			m.waits.Inc()
+			level.Info(m.logger).Log("msg", "waiting for the memory budget to compact", "samples", c.samples,
+				"predicted_memory_bytes", c.predicted.MemoryBytes, "reserved_bytes", m.reserved, "budget_bytes", m.budget)
+		}
+		m.mtx.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// done releases the memory reserved by the compaction and, if it succeeded, fits the models to its usage.
+func (c *trackedCompaction) done(success bool) {
+	if c == nil {
+		return
+	}
+	m := c.m
+	duration := time.Since(c.begin)
+	// Compactions shorter than the sampling interval are sampled at least once.
+	usage := m.memoryUsage()
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.observeMemory(usage)
+	delete(m.inFlight, c)
+	m.reserved -= c.predicted.MemoryBytes
+	m.reservedMemory.Set(float64(m.reserved))
+	close(m.wake)
+	m.wake = make(chan struct{})
+	if !success {
+		return
+	}
+
+	if c.known {
+		if c.predicted.Duration > 0 {
+			m.ratio.WithLabelValues("duration").Observe(float64(duration) / float64(c.predicted.Duration))
+		}
+		if c.predicted.MemoryBytes > 0 {
+			m.ratio.WithLabelValues("memory").Observe(float64(c.peakMemory) / float64(c.predicted.MemoryBytes))
+		}
+	}
+	f, ok := m.fits[c.resolution]
+	if !ok {
+		f = &resourceFits{}
+		m.fits[c.resolution] = f
+	}
+	f.observations++
+	f.duration.observe(float64(c.samples), float64(duration))
+	f.mem.observe(float64(c.samples), float64(c.peakMemory))
+}
@@ -0,0 +1,80 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/tsdb"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+)
+
+func TestResourceModel(t *testing.T) {
+	t.Parallel()
+
+	m := NewResourceModel(log.NewNopLogger(), prometheus.NewRegistry(), 1000)
+	var memory atomic.Uint64
+	memory.Store(100)
+	m.memoryUsage = memory.Load
+	plan := func(samples uint64) []*metadata.Meta {
+		return []*metadata.Meta{{BlockMeta: tsdb.BlockMeta{Stats: tsdb.BlockStats{NumSamples: samples}}}}
+	}
+	ctx := context.Background()
+
+	// Compactions use 10 bytes per sample above the idle memory of the compactor.
+	for i, samples := range []uint64{10, 20, 30} {
+		_, ok := m.Predict(0, 40)
+		testutil.Assert(t, !ok, "unexpected prediction after %d compactions", i)
+
+		c, err := m.admit(ctx, 0, plan(samples))
+		testutil.Ok(t, err)
+		memory.Store(100 + 10*samples)
+		c.done(true)
+		memory.Store(100)
+	}
+	pred, ok := m.Predict(0, 40)
+	testutil.Assert(t, ok, "expected prediction")
+	testutil.Assert(t, pred.MemoryBytes >= 399 && pred.MemoryBytes <= 401, "unexpected predicted memory %d", pred.MemoryBytes)
+	// Other resolutions are not predicted.
+	_, ok = m.Predict(downsample.ResLevel1, 40)
+	testutil.Assert(t, !ok, "unexpected prediction of other resolution")
+
+	first, err := m.admit(ctx, 0, plan(60))
+	testutil.Ok(t, err)
+
+	// Compactions exceeding the budget together with the compactions in flight wait for them.
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = m.admit(timeoutCtx, 0, plan(50))
+	testutil.NotOk(t, err)
+
+	admitted := make(chan *trackedCompaction)
+	go func() {
+		c, err := m.admit(ctx, 0, plan(50))
+		testutil.Ok(t, err)
+		admitted <- c
+	}()
+	// Compactions whose usage is unknown are admitted.
+	unknown, err := m.admit(ctx, downsample.ResLevel1, plan(1000))
+	testutil.Ok(t, err)
+	unknown.done(false)
+
+	first.done(false)
+	second := <-admitted
+	second.done(false)
+
+	// A nil model admits everything.
+	var nilModel *ResourceModel
+	c, err := nilModel.admit(ctx, 0, plan(1000))
+	testutil.Ok(t, err)
+	c.done(true)
+}
@@ -0,0 +1,75 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+)
+
+// SeriesHashesCompactionLifecycleCallback is a CompactionLifecycleCallback that computes the shard hashes of the
+// series of every compacted block from its index, once the callback it wraps is done, and uploads them next to the
+// block, for the store gateway not to hash the labels of the series of sharded requests. The series hashes are
+// optional, so failing to build or upload them is not failing the compaction.
+type SeriesHashesCompactionLifecycleCallback struct {
+	CompactionLifecycleCallback
+
+	bkt           objstore.Bucket
+	compactDir    string
+	withoutLabels []string
+	failures      prometheus.Counter
+}
+
+// NewSeriesHashesCompactionLifecycleCallback returns a callback wrapping the callback and computing the series hashes
+// of the blocks compacted by a BucketCompactor with the compactDir work directory. The hashes leave out the external
+// labels withoutLabels, e.g. the replica labels the series are deduplicated by at query time.
+func NewSeriesHashesCompactionLifecycleCallback(callback CompactionLifecycleCallback, reg prometheus.Registerer, bkt objstore.Bucket, compactDir string, withoutLabels []string) *SeriesHashesCompactionLifecycleCallback {
+	return &SeriesHashesCompactionLifecycleCallback{
+		CompactionLifecycleCallback: callback,
+		bkt:                         bkt,
+		compactDir:                  compactDir,
+		withoutLabels:               withoutLabels,
+		failures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_compact_series_hashes_failures_total",
+			Help: "Total number of compacted blocks for which building or uploading the series hashes failed.",
+		}),
+	}
+}
+
+func (c *SeriesHashesCompactionLifecycleCallback) PostCompactionCallback(ctx context.Context, logger log.Logger, cg *Group, id ulid.ULID) error {
+	if err := c.CompactionLifecycleCallback.PostCompactionCallback(ctx, logger, cg, id); err != nil {
+		return err
+	}
+
+	begin := time.Now()
+	// The compacted block is still in the work directory of the group until the group compaction is done.
+	indexFn := filepath.Join(c.compactDir, cg.Key(), id.String(), block.IndexFilename)
+	h, err := block.BuildSeriesHashes(ctx, indexFn, labels.NewBuilder(cg.Labels()).Del(c.withoutLabels...).Labels())
+	if err != nil {
+		c.failures.Inc()
+		level.Warn(logger).Log("msg", "failed to build series hashes", "block", id, "err", err)
+		return nil
+	}
+	buf := h.Encode()
+	if err := c.bkt.Upload(ctx, path.Join(id.String(), block.SeriesHashesFilename), bytes.NewReader(buf)); err != nil {
+		c.failures.Inc()
+		level.Warn(logger).Log("msg", "failed to upload series hashes", "block", id, "err", err)
+		return nil
+	}
+	level.Debug(logger).Log("msg", "uploaded series hashes", "block", id, "series", h.Len(), "size", len(buf), "duration", time.Since(begin), "duration_ms", time.Since(begin).Milliseconds())
+	return nil
+}
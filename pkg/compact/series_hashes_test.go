@@ -0,0 +1,55 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"io"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestSeriesHashesCompactionLifecycleCallback(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	compactDir := t.TempDir()
+	g := &Group{key: "0@123", labels: labels.FromStrings("cluster", "a", "replica", "r1")}
+	id, err := e2eutil.CreateBlock(ctx, filepath.Join(compactDir, g.Key()), []labels.Labels{
+		labels.FromStrings("a", "1"),
+		labels.FromStrings("a", "2", "b", "1"),
+	}, 10, 0, 1000, labels.EmptyLabels(), 124, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+
+	bkt := objstore.NewInMemBucket()
+	c := NewSeriesHashesCompactionLifecycleCallback(DefaultCompactionLifecycleCallback{}, prometheus.NewRegistry(), bkt, compactDir, []string{"replica"})
+	testutil.Ok(t, c.PostCompactionCallback(ctx, log.NewNopLogger(), g, id))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(c.failures))
+
+	r, err := bkt.Get(ctx, path.Join(id.String(), block.SeriesHashesFilename))
+	testutil.Ok(t, err)
+	buf, err := io.ReadAll(r)
+	testutil.Ok(t, err)
+	h, err := block.DecodeSeriesHashes(buf)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, h.Len())
+	testutil.Equals(t, []string{"cluster"}, h.ExtLabelNames)
+
+	// A missing index does not fail the compaction.
+	testutil.Ok(t, c.PostCompactionCallback(ctx, log.NewNopLogger(), g, ulid.MustNew(1, nil)))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(c.failures))
+}
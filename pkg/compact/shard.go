@@ -0,0 +1,78 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/index"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// outputShards returns the number of shards the compaction of the blocks in the directories has to be split
+// into for the index of every shard to stay below the maximum index size. Like largeTotalIndexSizeFilter, it assumes
+// the indexes share no bytes and leaves 15% headroom for index compaction bloat.
+func outputShards(dirs []string, maxIndexSizeBytes int64) (uint64, error) {
+	if maxIndexSizeBytes <= 0 {
+		return 1, nil
+	}
+	var total int64
+	for _, dir := range dirs {
+		fi, err := os.Stat(filepath.Join(dir, block.IndexFilename))
+		if err != nil {
+			return 0, errors.Wrapf(err, "stat index of %s", dir)
+		}
+		total += fi.Size()
+	}
+	limit := max(1, int64(float64(maxIndexSizeBytes)*0.85))
+	return uint64(max(1, (total+limit-1)/limit)), nil
+}
+
+// compactShards compacts the blocks in the directories into a block for every shard the series of the group
+// shard are split into, and records the shard in their meta.
+func (cg *Group) compactShards(dir string, dirs []string, comp Compactor, populator tsdb.BlockPopulator, n uint64) ([]ulid.ULID, error) {
+	var ids []ulid.ULID
+	for _, shard := range cg.shard.Split(n) {
+		compIDs, err := comp.CompactWithBlockPopulator(dir, dirs, nil, shardBlockPopulator{BlockPopulator: populator, shard: shard})
+		if err != nil {
+			return nil, errors.Wrapf(err, "compact shard %s", shard)
+		}
+		for _, id := range compIDs {
+			bdir := filepath.Join(dir, id.String())
+			meta, err := metadata.ReadFromDir(bdir)
+			if err != nil {
+				return nil, errors.Wrapf(err, "read meta of shard %s", shard)
+			}
+			meta.Thanos.Shard = &shard
+			if err := meta.WriteToDir(cg.logger, bdir); err != nil {
+				return nil, errors.Wrapf(err, "write meta of shard %s", shard)
+			}
+		}
+		ids = append(ids, compIDs...)
+	}
+	return ids, nil
+}
+
+// shardBlockPopulator populates the block only with the series of the blocks in the shard.
+type shardBlockPopulator struct {
+	tsdb.BlockPopulator
+	shard metadata.Shard
+}
+
+func (p shardBlockPopulator) PopulateBlock(ctx context.Context, metrics *tsdb.CompactorMetrics, logger *slog.Logger, chunkPool chunkenc.Pool, mergeFunc storage.VerticalChunkSeriesMergeFunc, blocks []tsdb.BlockReader, meta *tsdb.BlockMeta, indexw tsdb.IndexWriter, chunkw tsdb.ChunkWriter, postingsFunc tsdb.IndexReaderPostingsFunc) error {
+	shardPostingsFunc := func(ctx context.Context, r tsdb.IndexReader) index.Postings {
+		return r.ShardedPostings(postingsFunc(ctx, r), p.shard.Index, p.shard.Count)
+	}
+	return p.BlockPopulator.PopulateBlock(ctx, metrics, logger, chunkPool, mergeFunc, blocks, meta, indexw, chunkw, shardPostingsFunc)
+}
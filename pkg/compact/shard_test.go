@@ -0,0 +1,90 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/index"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestGroup_compactShards(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	var series []labels.Labels
+	for i := range 20 {
+		series = append(series, labels.FromStrings("a", fmt.Sprintf("%d", i)))
+	}
+	var dirs []string
+	for _, mint := range []int64{0, 1000} {
+		id, err := e2eutil.CreateBlock(ctx, dir, series, 10, mint, mint+1000, labels.EmptyLabels(), 0, metadata.NoneFunc, nil)
+		testutil.Ok(t, err)
+		dirs = append(dirs, filepath.Join(dir, id.String()))
+	}
+
+	fi, err := os.Stat(filepath.Join(dirs[0], block.IndexFilename))
+	testutil.Ok(t, err)
+	n, err := outputShards(dirs, 0)
+	testutil.Ok(t, err)
+	testutil.Equals(t, uint64(1), n)
+	n, err = outputShards(dirs, 2*fi.Size())
+	testutil.Ok(t, err)
+	testutil.Equals(t, uint64(2), n)
+
+	comp, err := tsdb.NewLeveledCompactor(ctx, nil, nil, []int64{1000, 3000}, nil, nil)
+	testutil.Ok(t, err)
+	g := &Group{logger: log.NewNopLogger(), shard: metadata.Shard{Index: 1, Count: 2}}
+	ids, err := g.compactShards(dir, dirs, comp, tsdb.DefaultBlockPopulator{}, 2)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(ids))
+
+	seen := map[string]struct{}{}
+	for i, id := range ids {
+		meta, err := metadata.ReadFromDir(filepath.Join(dir, id.String()))
+		testutil.Ok(t, err)
+		shard := metadata.Shard{Index: 1 + 2*uint64(i), Count: 4}
+		testutil.Equals(t, &shard, meta.Thanos.Shard)
+
+		r, err := index.NewFileReader(filepath.Join(dir, id.String(), block.IndexFilename), index.DecodePostingsRaw)
+		testutil.Ok(t, err)
+		k, v := index.AllPostingsKey()
+		p, err := r.Postings(ctx, k, v)
+		testutil.Ok(t, err)
+		var builder labels.ScratchBuilder
+		for p.Next() {
+			testutil.Ok(t, r.Series(p.At(), &builder, nil))
+			lset := builder.Labels()
+			testutil.Equals(t, shard.Index, labels.StableHash(lset)%shard.Count)
+			_, ok := seen[lset.String()]
+			testutil.Assert(t, !ok, "series %s in more than one shard", lset)
+			seen[lset.String()] = struct{}{}
+		}
+		testutil.Ok(t, p.Err())
+		testutil.Ok(t, r.Close())
+	}
+
+	// Only the series of the shard of the group are compacted.
+	var expected int
+	for _, lset := range series {
+		if labels.StableHash(lset)%2 == 1 {
+			expected++
+		}
+	}
+	testutil.Assert(t, expected > 0)
+	testutil.Equals(t, expected, len(seen))
+}
@@ -0,0 +1,138 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"encoding/binary"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// SimulatedCompaction is a compaction of a group planned by a PlanSimulator.
+type SimulatedCompaction struct {
+	// Group is the key of the group.
+	Group string
+	// Blocks are the blocks the planner planned to compact, including the blocks of earlier simulated compactions.
+	Blocks []*metadata.Meta
+	// Result is the meta of the block the compaction would produce.
+	Result *metadata.Meta
+	// Round is the round over all the groups the compaction is planned in, starting at 0. A compactor iteration
+	// compacts a plan of every group, so that the compactions of a round would be done in the same iteration.
+	Round int
+}
+
+// PlanSimulator simulates the compactions of groups by planning them with the planner over and over, adding the
+// blocks the compactions would produce to the groups, until there is nothing left to compact. The blocks produced
+// get ULIDs with the time of the clock of the simulator and a sequence number as entropy, so that the same groups
+// are simulated the same way at the same time.
+type PlanSimulator struct {
+	planner Planner
+	now     func() time.Time
+}
+
+// NewPlanSimulator returns a simulator of the compactions planned by the planner.
+func NewPlanSimulator(planner Planner) *PlanSimulator {
+	return &PlanSimulator{planner: planner, now: time.Now}
+}
+
+// SetClock sets the clock dating the blocks produced by the simulated compactions.
+func (s *PlanSimulator) SetClock(now func() time.Time) {
+	s.now = now
+}
+
+// Simulate returns the compactions of the groups in the order they would be planned, round after round over all the
+// groups. The groups are not modified.
+func (s *PlanSimulator) Simulate(ctx context.Context, groups []*Group) ([]SimulatedCompaction, error) {
+	type simulatedGroup struct {
+		*Group
+		metasByMinTime []*metadata.Meta
+	}
+	simulated := make([]simulatedGroup, 0, len(groups))
+	for _, g := range groups {
+		g.mtx.Lock()
+		simulated = append(simulated, simulatedGroup{Group: g, metasByMinTime: slices.Clone(g.metasByMinTime)})
+		g.mtx.Unlock()
+	}
+
+	var (
+		compactions []SimulatedCompaction
+		seq         uint64
+	)
+	for round := 0; len(simulated) > 0; round++ {
+		next := simulated[:0]
+		for _, g := range simulated {
+			if len(g.metasByMinTime) <= 1 {
+				continue
+			}
+			plan, err := s.planner.Plan(ctx, g.metasByMinTime, nil, g.extensions)
+			if err != nil {
+				return nil, errors.Wrapf(err, "plan group %s", g.key)
+			}
+			if len(plan) == 0 {
+				continue
+			}
+
+			planned := make(map[ulid.ULID]struct{}, len(plan))
+			metas := make([]*tsdb.BlockMeta, 0, len(plan))
+			for _, p := range plan {
+				planned[p.ULID] = struct{}{}
+				metas = append(metas, &p.BlockMeta)
+			}
+			seq++
+			result := &metadata.Meta{
+				BlockMeta: *tsdb.CompactBlockMetas(s.newULID(seq), metas...),
+				Thanos: metadata.Thanos{
+					Labels:     g.Labels().Map(),
+					Downsample: metadata.ThanosDownsample{Resolution: g.Resolution()},
+					Shard:      plan[0].Thanos.Shard,
+				},
+			}
+			// The samples and chunks of the blocks are assumed not to be deduplicated.
+			for _, p := range plan {
+				result.Stats.NumSamples += p.Stats.NumSamples
+				result.Stats.NumChunks += p.Stats.NumChunks
+			}
+			compactions = append(compactions, SimulatedCompaction{Group: g.key, Blocks: plan, Result: result, Round: round})
+
+			remaining := make([]*metadata.Meta, 0, len(g.metasByMinTime)-len(plan)+1)
+			for _, m := range g.metasByMinTime {
+				if _, ok := planned[m.ULID]; !ok {
+					remaining = append(remaining, m)
+				}
+			}
+			if len(remaining) == 0 {
+				continue
+			}
+			g.metasByMinTime = append(remaining, result)
+			sort.Slice(g.metasByMinTime, func(i, j int) bool {
+				return g.metasByMinTime[i].MinTime < g.metasByMinTime[j].MinTime
+			})
+			next = append(next, g)
+		}
+		simulated = next
+	}
+	return compactions, nil
+}
+
+// newULID returns the ULID of the block of the compaction with the sequence number.
+func (s *PlanSimulator) newULID(seq uint64) ulid.ULID {
+	var id ulid.ULID
+	if err := id.SetTime(ulid.Timestamp(s.now())); err != nil {
+		panic(err)
+	}
+	var entropy [10]byte
+	binary.BigEndian.PutUint64(entropy[2:], seq)
+	if err := id.SetEntropy(entropy[:]); err != nil {
+		panic(err)
+	}
+	return id
+}
@@ -0,0 +1,67 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+func TestPlanSimulator_Simulate(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+	planner := NewTSDBBasedPlanner(logger, []int64{
+		int64(2 * time.Hour / time.Millisecond),
+		int64(4 * time.Hour / time.Millisecond),
+		int64(8 * time.Hour / time.Millisecond),
+	})
+	temp := promauto.With(nil).NewCounter(prometheus.CounterOpts{Name: "test_metric_for_plan_simulator"})
+	grouper := NewDefaultGrouper(logger, nil, false, false, nil, temp, temp, temp, "", 1, 1)
+
+	blocks := map[ulid.ULID]*metadata.Meta{}
+	for i := uint64(0); i < 5; i++ {
+		m := createBlockMeta(i, int64(i)*int64(2*time.Hour/time.Millisecond), int64(i+1)*int64(2*time.Hour/time.Millisecond), map[string]string{"a": "1"}, 0, []uint64{})
+		blocks[m.ULID] = m
+	}
+	groups, err := grouper.Groups(blocks)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(groups))
+
+	now := time.Unix(1600000000, 0)
+	s := NewPlanSimulator(planner)
+	s.SetClock(func() time.Time { return now })
+	compactions, err := s.Simulate(context.Background(), groups)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, len(compactions))
+
+	ids := map[ulid.ULID]struct{}{}
+	for i, c := range compactions {
+		testutil.Equals(t, groups[0].Key(), c.Group)
+		// A single group is compacted once per round.
+		testutil.Equals(t, i, c.Round)
+		testutil.Equals(t, ulid.Timestamp(now), c.Result.ULID.Time())
+		testutil.Equals(t, groups[0].Labels().Map(), c.Result.Thanos.Labels)
+		ids[c.Result.ULID] = struct{}{}
+	}
+	testutil.Equals(t, 3, len(ids))
+	// The last compaction compacts the blocks produced by the others.
+	testutil.Equals(t, []*metadata.Meta{compactions[0].Result, compactions[1].Result}, compactions[2].Blocks)
+	testutil.Equals(t, compactions[0].Result.Compaction.Level+1, compactions[2].Result.Compaction.Level)
+
+	// The groups are left as they are, and are simulated the same way again.
+	testutil.Equals(t, 5, len(groups[0].IDs()))
+	again, err := s.Simulate(context.Background(), groups)
+	testutil.Ok(t, err)
+	testutil.Equals(t, compactions, again)
+}
@@ -0,0 +1,198 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+const (
+	// StateVersion1 represents 1 version of the compactor state snapshot.
+	StateVersion1 = 1
+
+	// StateFilename is the name of the state snapshot file in the data directory of a compacted bucket.
+	StateFilename = "compactor-state.json"
+)
+
+// State defines the format of the snapshot of the state the compactor derives from the bucket, which a restarted
+// compactor restores to compact before its first meta sync.
+type State struct {
+	Version int       `json:"version"`
+	SavedAt time.Time `json:"saved_at"`
+	// Metas is the blocks of the last meta sync, grouped again on restore.
+	Metas []*metadata.Meta `json:"metas,omitempty"`
+	// NoCompactMarks is the no compaction marks of the blocks of the last meta sync.
+	NoCompactMarks []*metadata.NoCompactMark `json:"no_compact_marks,omitempty"`
+	// Plans is the last plans of the groups whose compaction did not finish, by group key.
+	Plans map[string][]ulid.ULID `json:"plans,omitempty"`
+	// VerifiedReplacements is the replacement blocks whose index-header was built by the replacement check.
+	VerifiedReplacements []ulid.ULID `json:"verified_replacements,omitempty"`
+	// Garbage is the blocks the last garbage collection decided to mark for deletion, but did not mark yet.
+	Garbage []ulid.ULID `json:"garbage,omitempty"`
+}
+
+// StateComponent is a part of the compactor whose state is included in the state snapshots.
+type StateComponent interface {
+	saveState(s *State)
+	restoreState(s *State)
+}
+
+// StateSnapshot saves the state of the components of the compaction of a bucket to a local file, and restores it.
+type StateSnapshot struct {
+	logger     log.Logger
+	path       string
+	maxAge     time.Duration
+	components []StateComponent
+}
+
+// NewStateSnapshot returns the snapshot of the state of the components in the file at path. Snapshots older than
+// maxAge are not restored, as the bucket likely changed too much since.
+func NewStateSnapshot(logger log.Logger, path string, maxAge time.Duration, components ...StateComponent) *StateSnapshot {
+	return &StateSnapshot{logger: logger, path: path, maxAge: maxAge, components: components}
+}
+
+// Save writes the state of the components to the snapshot file.
+func (s *StateSnapshot) Save() error {
+	st := State{Version: StateVersion1, SavedAt: time.Now()}
+	for _, c := range s.components {
+		c.saveState(&st)
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return errors.Wrap(err, "marshal compactor state")
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0750); err != nil {
+		return errors.Wrap(err, "create compactor state dir")
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "write compactor state")
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return errors.Wrap(err, "rename compactor state")
+	}
+	level.Info(s.logger).Log("msg", "saved compactor state", "blocks", len(st.Metas), "unfinished_plans", len(st.Plans), "path", s.path)
+	return nil
+}
+
+// Restore restores the state of the components from the snapshot file, if it is recent enough, and returns whether
+// it did. The file is removed, so that the state of a compactor which does not save it again, e.g. because it
+// crashed, is not restored after the compactions it did since.
+func (s *StateSnapshot) Restore() bool {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			level.Warn(s.logger).Log("msg", "failed to read compactor state, starting from scratch", "err", err)
+		}
+		return false
+	}
+	if err := os.Remove(s.path); err != nil {
+		level.Warn(s.logger).Log("msg", "failed to remove compactor state, starting from scratch", "err", err)
+		return false
+	}
+
+	var st State
+	if err := json.Unmarshal(b, &st); err != nil || st.Version != StateVersion1 {
+		level.Warn(s.logger).Log("msg", "failed to parse compactor state, starting from scratch", "err", err)
+		return false
+	}
+	if age := time.Since(st.SavedAt); age > s.maxAge {
+		level.Info(s.logger).Log("msg", "compactor state is too old, starting from scratch", "age", age, "max_age", s.maxAge)
+		return false
+	}
+	for _, c := range s.components {
+		c.restoreState(&st)
+	}
+	level.Info(s.logger).Log("msg", "restored compactor state", "saved_at", st.SavedAt, "blocks", len(st.Metas), "unfinished_plans", len(st.Plans), "garbage", len(st.Garbage))
+	return true
+}
+
+func sortedULIDs(ids []ulid.ULID) []ulid.ULID {
+	slices.SortFunc(ids, func(a, b ulid.ULID) int { return a.Compare(b) })
+	return ids
+}
+
+func (s *Syncer) saveState(st *State) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, m := range s.blocks {
+		st.Metas = append(st.Metas, m)
+	}
+	slices.SortFunc(st.Metas, func(a, b *metadata.Meta) int { return a.ULID.Compare(b.ULID) })
+	st.Garbage = sortedULIDs(slices.Clone(s.pendingGarbage))
+}
+
+func (s *Syncer) restoreState(st *State) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.blocks = make(map[ulid.ULID]*metadata.Meta, len(st.Metas))
+	for _, m := range st.Metas {
+		s.blocks[m.ULID] = m
+	}
+	s.pendingGarbage = st.Garbage
+	s.restored = true
+}
+
+func (f *GatherNoCompactionMarkFilter) saveState(st *State) {
+	for _, m := range f.NoCompactMarkedBlocks() {
+		st.NoCompactMarks = append(st.NoCompactMarks, m)
+	}
+	slices.SortFunc(st.NoCompactMarks, func(a, b *metadata.NoCompactMark) int { return a.ID.Compare(b.ID) })
+}
+
+func (f *GatherNoCompactionMarkFilter) restoreState(st *State) {
+	marks := make(map[ulid.ULID]*metadata.NoCompactMark, len(st.NoCompactMarks))
+	for _, m := range st.NoCompactMarks {
+		marks[m.ID] = m
+	}
+	f.mtx.Lock()
+	f.noCompactMarkedMap = marks
+	f.mtx.Unlock()
+}
+
+func (c *BucketCompactor) saveState(st *State) {
+	c.plansMtx.Lock()
+	defer c.plansMtx.Unlock()
+	if len(c.plans) > 0 {
+		st.Plans = make(map[string][]ulid.ULID, len(c.plans))
+		for k, p := range c.plans {
+			st.Plans[k] = p
+		}
+	}
+}
+
+func (c *BucketCompactor) restoreState(st *State) {
+	c.plansMtx.Lock()
+	defer c.plansMtx.Unlock()
+	c.plans = st.Plans
+}
+
+func (c *ReplacementCheck) saveState(st *State) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for id := range c.indexHeaderVerified {
+		st.VerifiedReplacements = append(st.VerifiedReplacements, id)
+	}
+	sortedULIDs(st.VerifiedReplacements)
+}
+
+func (c *ReplacementCheck) restoreState(st *State) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, id := range st.VerifiedReplacements {
+		c.indexHeaderVerified[id] = struct{}{}
+	}
+}
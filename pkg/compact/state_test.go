@@ -0,0 +1,95 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/prometheus/tsdb"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+func TestStateSnapshot(t *testing.T) {
+	t.Parallel()
+
+	var (
+		path    = filepath.Join(t.TempDir(), StateFilename)
+		a, b, c = ulid.MustNew(1, nil), ulid.MustNew(2, nil), ulid.MustNew(3, nil)
+	)
+	newComponents := func() (*Syncer, *GatherNoCompactionMarkFilter, *BucketCompactor, *ReplacementCheck) {
+		return &Syncer{logger: log.NewNopLogger()},
+			NewGatherNoCompactionMarkFilter(log.NewNopLogger(), nil, 1),
+			&BucketCompactor{},
+			NewReplacementCheck(nil, nil, nil, true)
+	}
+
+	sy, f, bc, rc := newComponents()
+	sy.blocks = map[ulid.ULID]*metadata.Meta{
+		a: {BlockMeta: tsdb.BlockMeta{ULID: a}},
+		b: {BlockMeta: tsdb.BlockMeta{ULID: b}},
+	}
+	sy.pendingGarbage = []ulid.ULID{a}
+	f.noCompactMarkedMap = map[ulid.ULID]*metadata.NoCompactMark{b: {ID: b, Reason: metadata.ManualNoCompactReason}}
+	bc.recordPlan(&Group{key: "0@1", plan: []ulid.ULID{a, b}}, context.Canceled)
+	bc.recordPlan(&Group{key: "0@2", plan: []ulid.ULID{c}}, nil)
+	rc.indexHeaderVerified[c] = struct{}{}
+	testutil.Ok(t, NewStateSnapshot(log.NewNopLogger(), path, time.Hour, sy, f, bc, rc).Save())
+
+	sy, f, bc, rc = newComponents()
+	testutil.Assert(t, NewStateSnapshot(log.NewNopLogger(), path, time.Hour, sy, f, bc, rc).Restore())
+	testutil.Equals(t, 2, len(sy.Metas()))
+	testutil.Equals(t, []ulid.ULID{a}, sy.pendingGarbage)
+	testutil.Equals(t, metadata.ManualNoCompactReason, f.NoCompactMarkedBlocks()[b].Reason)
+	testutil.Equals(t, map[string][]ulid.ULID{"0@1": {a, b}}, bc.plans)
+	testutil.Equals(t, map[ulid.ULID]struct{}{c: {}}, rc.indexHeaderVerified)
+
+	// The restored metas are not synced again, the following syncs are done.
+	testutil.Ok(t, sy.syncMetasUnlessRestored(context.Background()))
+	testutil.Assert(t, !sy.restored)
+
+	// The snapshot is only restored once.
+	_, err := os.Stat(path)
+	testutil.Assert(t, os.IsNotExist(err))
+	testutil.Assert(t, !NewStateSnapshot(log.NewNopLogger(), path, time.Hour, sy).Restore())
+
+	// Old snapshots are not restored.
+	testutil.Ok(t, NewStateSnapshot(log.NewNopLogger(), path, time.Hour, sy).Save())
+	sy, _, _, _ = newComponents()
+	testutil.Assert(t, !NewStateSnapshot(log.NewNopLogger(), path, 0, sy).Restore())
+	testutil.Equals(t, 0, len(sy.Metas()))
+}
+
+func TestBucketCompactor_UnfinishedPlansFirst(t *testing.T) {
+	t.Parallel()
+
+	newGroup := func(key string, ids ...ulid.ULID) *Group {
+		g := &Group{key: key}
+		for _, id := range ids {
+			g.metasByMinTime = append(g.metasByMinTime, &metadata.Meta{BlockMeta: tsdb.BlockMeta{ULID: id}})
+		}
+		return g
+	}
+	a, b, c := ulid.MustNew(1, nil), ulid.MustNew(2, nil), ulid.MustNew(3, nil)
+	bc := &BucketCompactor{plans: map[string][]ulid.ULID{
+		"0@3": {a, b},
+		// The blocks of the plan are not all in the group anymore.
+		"0@2": {c},
+	}}
+	groups := []*Group{newGroup("0@1", a), newGroup("0@2", b), newGroup("0@3", a, b), newGroup("0@4", c)}
+	bc.unfinishedPlansFirst(groups)
+
+	var keys []string
+	for _, g := range groups {
+		keys = append(keys, g.Key())
+	}
+	testutil.Equals(t, []string{"0@3", "0@1", "0@2", "0@4"}, keys)
+}
@@ -0,0 +1,377 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/client"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// StorageClassConfig configures a storage class of the blocks of a bucket.
+type StorageClassConfig struct {
+	// Name identifies the class in the markers of the blocks moved to it.
+	Name string `yaml:"name"`
+	// Resolutions are the resolutions of the blocks of the class, among raw, 5m and 1h. Empty matches all of them.
+	Resolutions []string `yaml:"resolutions"`
+	// MinAge is the minimum age of the blocks of the class, from their max time.
+	MinAge model.Duration `yaml:"min_age"`
+	// Downsampled restricts the class to the raw and 5m blocks whose data exists in blocks of the next resolution.
+	Downsampled bool `yaml:"downsampled"`
+	// Bucket is the object storage configuration of the same bucket, uploading objects to the class, e.g. with the
+	// X-Amz-Storage-Class header in the put_user_metadata of S3.
+	Bucket client.BucketConfig `yaml:"bucket"`
+}
+
+// ParseStorageClassesConfig parses the storage classes of a bucket, in order of precedence.
+func ParseStorageClassesConfig(content []byte) ([]StorageClassConfig, error) {
+	var confs []StorageClassConfig
+	if err := yaml.UnmarshalStrict(content, &confs); err != nil {
+		return nil, errors.Wrap(err, "parsing storage classes config YAML")
+	}
+	if err := ValidateStorageClasses(confs); err != nil {
+		return nil, err
+	}
+	return confs, nil
+}
+
+// ValidateStorageClasses returns an error if the storage classes are not valid.
+func ValidateStorageClasses(confs []StorageClassConfig) error {
+	names := map[string]struct{}{}
+	for i, c := range confs {
+		if c.Name == "" {
+			return errors.Errorf("storage class %d has no name", i)
+		}
+		if _, ok := names[c.Name]; ok {
+			return errors.Errorf("storage class name %q is not unique", c.Name)
+		}
+		names[c.Name] = struct{}{}
+		if _, err := parseResolutions(c.Resolutions); err != nil {
+			return errors.Wrapf(err, "storage class %q", c.Name)
+		}
+		if c.Bucket.Type == "" {
+			return errors.Errorf("storage class %q has no object storage configuration", c.Name)
+		}
+	}
+	return nil
+}
+
+func parseResolutions(rs []string) (map[ResolutionLevel]struct{}, error) {
+	levels := make(map[ResolutionLevel]struct{}, len(rs))
+	for _, r := range rs {
+		switch strings.ToLower(r) {
+		case "raw":
+			levels[ResolutionLevelRaw] = struct{}{}
+		case "5m":
+			levels[ResolutionLevel5m] = struct{}{}
+		case "1h":
+			levels[ResolutionLevel1h] = struct{}{}
+		default:
+			return nil, errors.Errorf("unknown resolution %q, expected raw, 5m or 1h", r)
+		}
+	}
+	return levels, nil
+}
+
+// StorageClass is a storage class of the blocks of a bucket, with the bucket uploading objects to it.
+type StorageClass struct {
+	name        string
+	resolutions map[ResolutionLevel]struct{}
+	minAge      time.Duration
+	downsampled bool
+	bkt         objstore.Bucket
+	copier      objstoreutil.ServerSideCopier
+}
+
+// NewStorageClass returns the storage class of the configuration, uploading objects with bkt. Objects are copied
+// in place with copier instead when it is not nil and supports it.
+func NewStorageClass(conf StorageClassConfig, bkt objstore.Bucket, copier objstoreutil.ServerSideCopier) (StorageClass, error) {
+	resolutions, err := parseResolutions(conf.Resolutions)
+	if err != nil {
+		return StorageClass{}, err
+	}
+	return StorageClass{
+		name:        conf.Name,
+		resolutions: resolutions,
+		minAge:      time.Duration(conf.MinAge),
+		downsampled: conf.Downsampled,
+		bkt:         bkt,
+		copier:      copier,
+	}, nil
+}
+
+// Name returns the name of the storage class.
+func (c StorageClass) Name() string { return c.name }
+
+// Bucket returns the bucket uploading objects to the storage class.
+func (c StorageClass) Bucket() objstore.Bucket { return c.bkt }
+
+// WithPrefix returns the storage class of the objects under the given prefix of its bucket.
+func (c StorageClass) WithPrefix(prefix string) StorageClass {
+	c.bkt = objstore.NewPrefixedBucket(c.bkt, prefix)
+	c.copier = objstoreutil.NewPrefixedServerSideCopier(c.copier, prefix)
+	return c
+}
+
+func (c StorageClass) matches(m *metadata.Meta, now time.Time, downsampled bool) bool {
+	if _, ok := c.resolutions[ResolutionLevel(m.Thanos.Downsample.Resolution)]; len(c.resolutions) > 0 && !ok {
+		return false
+	}
+	if now.Sub(time.UnixMilli(m.MaxTime)) < c.minAge {
+		return false
+	}
+	return !c.downsampled || downsampled
+}
+
+// nextResolution returns the resolution the blocks of the resolution are downsampled to, and false for the last one.
+func nextResolution(r ResolutionLevel) (ResolutionLevel, bool) {
+	switch r {
+	case ResolutionLevelRaw:
+		return ResolutionLevel5m, true
+	case ResolutionLevel5m:
+		return ResolutionLevel1h, true
+	default:
+		return 0, false
+	}
+}
+
+// downsampledBlocks returns the blocks whose sources are all in a block of the next resolution.
+func downsampledBlocks(metas map[ulid.ULID]*metadata.Meta) map[ulid.ULID]struct{} {
+	// The blocks of every resolution by source.
+	bySource := map[ResolutionLevel]map[ulid.ULID][]*metadata.Meta{}
+	for _, m := range metas {
+		r := ResolutionLevel(m.Thanos.Downsample.Resolution)
+		if bySource[r] == nil {
+			bySource[r] = map[ulid.ULID][]*metadata.Meta{}
+		}
+		for _, s := range m.Compaction.Sources {
+			bySource[r][s] = append(bySource[r][s], m)
+		}
+	}
+
+	downsampled := map[ulid.ULID]struct{}{}
+	for id, m := range metas {
+		next, ok := nextResolution(ResolutionLevel(m.Thanos.Downsample.Resolution))
+		if !ok {
+			// There is nothing to wait for.
+			downsampled[id] = struct{}{}
+			continue
+		}
+		if len(m.Compaction.Sources) == 0 {
+			continue
+		}
+	candidates:
+		for _, c := range bySource[next][m.Compaction.Sources[0]] {
+			for _, s := range m.Compaction.Sources[1:] {
+				if !containsSource(c, s) {
+					continue candidates
+				}
+			}
+			downsampled[id] = struct{}{}
+			break
+		}
+	}
+	return downsampled
+}
+
+func containsSource(m *metadata.Meta, id ulid.ULID) bool {
+	for _, s := range m.Compaction.Sources {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
+// StorageClassApplier moves the index and chunks of the blocks of a bucket to the first storage class they match,
+// by copying them in place on the server side or uploading them again with the bucket of the class, and records the class in a marker. Blocks which do not
+// match any class, or already are in the class they match, are left as they are.
+type StorageClassApplier struct {
+	logger      log.Logger
+	bkt         objstore.InstrumentedBucket
+	classes     []StorageClass
+	dir         string
+	transitions *prometheus.CounterVec
+
+	// current are the storage classes of the blocks, as read from or written to their markers.
+	current map[ulid.ULID]string
+}
+
+// NewStorageClassApplier returns the applier of the storage classes of bkt, downloading the files of the blocks
+// to dir before uploading them to their storage class.
+func NewStorageClassApplier(logger log.Logger, bkt objstore.InstrumentedBucket, classes []StorageClass, dir string, transitions *prometheus.CounterVec) *StorageClassApplier {
+	return &StorageClassApplier{
+		logger:      logger,
+		bkt:         bkt,
+		classes:     classes,
+		dir:         dir,
+		transitions: transitions,
+		current:     map[ulid.ULID]string{},
+	}
+}
+
+// Apply moves the blocks to the storage classes they match.
+func (a *StorageClassApplier) Apply(ctx context.Context, metas map[ulid.ULID]*metadata.Meta) error {
+	if len(a.classes) == 0 {
+		return nil
+	}
+	level.Info(a.logger).Log("msg", "start applying storage classes")
+
+	for id := range a.current {
+		if _, ok := metas[id]; !ok {
+			delete(a.current, id)
+		}
+	}
+	ids := make([]ulid.ULID, 0, len(metas))
+	for id := range metas {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Compare(ids[j]) < 0 })
+
+	var (
+		now         = time.Now()
+		downsampled = downsampledBlocks(metas)
+		moved       int
+	)
+	for _, id := range ids {
+		_, isDownsampled := downsampled[id]
+		for _, c := range a.classes {
+			if !c.matches(metas[id], now, isDownsampled) {
+				continue
+			}
+			current, err := a.currentClass(ctx, id)
+			if err != nil {
+				return err
+			}
+			if current != c.name {
+				ok, err := a.move(ctx, id, c)
+				if err != nil {
+					return errors.Wrapf(err, "move block %s to storage class %s", id, c.name)
+				}
+				if ok {
+					a.transitions.WithLabelValues(c.name).Inc()
+					moved++
+				}
+			}
+			break
+		}
+	}
+	level.Info(a.logger).Log("msg", "storage classes apply done", "moved", moved)
+	return nil
+}
+
+func (a *StorageClassApplier) currentClass(ctx context.Context, id ulid.ULID) (string, error) {
+	if c, ok := a.current[id]; ok {
+		return c, nil
+	}
+	m := &metadata.StorageClassMark{}
+	if err := metadata.ReadMarker(ctx, a.logger, a.bkt, id.String(), m); err != nil && !errors.Is(err, metadata.ErrorMarkerNotFound) {
+		return "", errors.Wrapf(err, "read storage class mark of block %s", id)
+	}
+	a.current[id] = m.StorageClass
+	return m.StorageClass, nil
+}
+
+// move copies the index and chunks of the block in place with the copier of the class, or uploads them again with
+// its bucket, and returns false if the block is marked for deletion.
+func (a *StorageClassApplier) move(ctx context.Context, id ulid.ULID, c StorageClass) (bool, error) {
+	// Blocks marked for deletion, e.g. by the retention, are not worth moving.
+	marked, err := a.bkt.Exists(ctx, path.Join(id.String(), metadata.DeletionMarkFilename))
+	if err != nil {
+		return false, errors.Wrap(err, "check deletion mark")
+	}
+	if marked {
+		return false, nil
+	}
+
+	// The files are copied as they are stored, e.g. encrypted, with the bucket of the class.
+	var names []string
+	if err := c.bkt.Iter(ctx, id.String(), func(name string) error {
+		rel := strings.TrimPrefix(name, id.String()+objstore.DirDelim)
+		if rel == block.IndexFilename || strings.HasPrefix(rel, block.ChunksDirname+objstore.DirDelim) {
+			names = append(names, name)
+		}
+		return nil
+	}, objstore.WithRecursiveIter()); err != nil {
+		return false, errors.Wrap(err, "list block files")
+	}
+
+	blockDir := filepath.Join(a.dir, id.String())
+	defer func() {
+		if err := os.RemoveAll(blockDir); err != nil {
+			level.Warn(a.logger).Log("msg", "failed to remove storage class work directory", "dir", blockDir, "err", err)
+		}
+	}()
+	for _, name := range names {
+		if c.copier != nil {
+			err := c.copier.Copy(ctx, c.copier, name, name)
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, objstoreutil.ErrServerSideCopyUnsupported) {
+				level.Warn(a.logger).Log("msg", "server side copy failed, uploading the file again", "name", name, "class", c.name, "err", err)
+			}
+		}
+		dst := filepath.Join(a.dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+			return false, errors.Wrap(err, "create work directory")
+		}
+		if err := objstore.DownloadFile(ctx, a.logger, c.bkt, name, dst); err != nil {
+			return false, err
+		}
+		if err := objstore.UploadFile(ctx, a.logger, c.bkt, dst, name); err != nil {
+			return false, err
+		}
+		if err := os.Remove(dst); err != nil {
+			return false, errors.Wrap(err, "remove downloaded file")
+		}
+	}
+
+	mark, err := json.Marshal(metadata.StorageClassMark{
+		ID:           id,
+		Version:      metadata.StorageClassMarkVersion1,
+		StorageClass: c.name,
+		MoveTime:     time.Now().Unix(),
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "json encode storage class mark")
+	}
+	if err := a.bkt.Upload(ctx, path.Join(id.String(), metadata.StorageClassMarkFilename), bytes.NewReader(mark)); err != nil {
+		return false, errors.Wrap(err, "upload storage class mark")
+	}
+	a.current[id] = c.name
+	level.Info(a.logger).Log("msg", "moved block to storage class", "id", id, "class", c.name, "files", len(names))
+	return true, nil
+}
+
+// CloseStorageClasses closes the buckets and copiers of the storage classes.
+func CloseStorageClasses(logger log.Logger, classes []StorageClass) {
+	for _, c := range classes {
+		runutil.CloseWithLogOnErr(logger, c.bkt, "storage class bucket client")
+		if c.copier != nil {
+			runutil.CloseWithLogOnErr(logger, c.copier, "storage class server side copier")
+		}
+	}
+}
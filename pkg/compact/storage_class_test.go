@@ -0,0 +1,223 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/client"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
+)
+
+// uploadRecordingBucket records the names of the objects uploaded with it.
+type uploadRecordingBucket struct {
+	objstore.Bucket
+
+	mtx     sync.Mutex
+	uploads []string
+}
+
+func (b *uploadRecordingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	b.mtx.Lock()
+	b.uploads = append(b.uploads, name)
+	b.mtx.Unlock()
+	return b.Bucket.Upload(ctx, name, r)
+}
+
+func (b *uploadRecordingBucket) Close() error { return nil }
+
+// inPlaceCopier records the objects copied in place with it, without copying them.
+type inPlaceCopier struct {
+	copies []string
+}
+
+func (c *inPlaceCopier) Copy(_ context.Context, from objstoreutil.ServerSideCopier, src, dst string) error {
+	if from != objstoreutil.ServerSideCopier(c) || src != dst {
+		return objstoreutil.ErrServerSideCopyUnsupported
+	}
+	c.copies = append(c.copies, dst)
+	return nil
+}
+
+func (c *inPlaceCopier) Close() error { return nil }
+
+func storageClassMeta(id ulid.ULID, maxTime time.Time, resolution int64, sources ...ulid.ULID) *metadata.Meta {
+	m := &metadata.Meta{BlockMeta: tsdb.BlockMeta{
+		ULID:       id,
+		MaxTime:    maxTime.UnixMilli(),
+		Compaction: tsdb.BlockMetaCompaction{Sources: sources},
+	}}
+	m.Thanos.Downsample.Resolution = resolution
+	return m
+}
+
+func TestStorageClassApplier(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	inner := objstore.NewInMemBucket()
+	bkt := objstore.WithNoopInstr(inner)
+	cold := &uploadRecordingBucket{Bucket: inner}
+	archive := &uploadRecordingBucket{Bucket: inner}
+
+	var (
+		old         = time.Now().Add(-60 * 24 * time.Hour)
+		recent      = time.Now().Add(-time.Hour)
+		rawDone     = ulid.MustNew(1, nil)
+		rawPending  = ulid.MustNew(2, nil)
+		rawRecent   = ulid.MustNew(3, nil)
+		fiveMin     = ulid.MustNew(4, nil)
+		oneHour     = ulid.MustNew(5, nil)
+		rawDeleted  = ulid.MustNew(6, nil)
+		fiveMinDone = ulid.MustNew(7, nil)
+	)
+	metas := map[ulid.ULID]*metadata.Meta{
+		rawDone:    storageClassMeta(rawDone, old, int64(ResolutionLevelRaw), rawDone),
+		rawPending: storageClassMeta(rawPending, old, int64(ResolutionLevelRaw), rawPending),
+		rawRecent:  storageClassMeta(rawRecent, recent, int64(ResolutionLevelRaw), rawRecent),
+		rawDeleted: storageClassMeta(rawDeleted, old, int64(ResolutionLevelRaw), rawDeleted),
+		// The raw blocks downsampled to 5m, and then to 1h. rawPending and rawRecent are not downsampled yet.
+		fiveMinDone: storageClassMeta(fiveMinDone, old, int64(ResolutionLevel5m), rawDone, rawDeleted),
+		fiveMin:     storageClassMeta(fiveMin, recent, int64(ResolutionLevel5m), rawRecent),
+		oneHour:     storageClassMeta(oneHour, old, int64(ResolutionLevel1h), rawDone, rawDeleted),
+	}
+	for id := range metas {
+		for _, name := range []string{block.IndexFilename, block.MetaFilename, path.Join(block.ChunksDirname, "000001")} {
+			testutil.Ok(t, inner.Upload(ctx, path.Join(id.String(), name), strings.NewReader(name)))
+		}
+	}
+	testutil.Ok(t, inner.Upload(ctx, path.Join(rawDeleted.String(), metadata.DeletionMarkFilename), strings.NewReader("{}")))
+
+	archiveClass, err := NewStorageClass(StorageClassConfig{Name: "archive", Resolutions: []string{"1h"}, MinAge: model.Duration(30 * 24 * time.Hour)}, archive, nil)
+	testutil.Ok(t, err)
+	coldClass, err := NewStorageClass(StorageClassConfig{Name: "cold", Resolutions: []string{"raw", "5m"}, MinAge: model.Duration(24 * time.Hour), Downsampled: true}, cold, nil)
+	testutil.Ok(t, err)
+
+	transitions := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test"}, []string{"class"})
+	a := NewStorageClassApplier(log.NewNopLogger(), bkt, []StorageClass{archiveClass, coldClass}, t.TempDir(), transitions)
+	testutil.Ok(t, a.Apply(ctx, metas))
+
+	uploads := func(b *uploadRecordingBucket) []string {
+		names := append([]string(nil), b.uploads...)
+		sort.Strings(names)
+		return names
+	}
+	testutil.Equals(t, []string{
+		path.Join(rawDone.String(), block.ChunksDirname, "000001"),
+		path.Join(rawDone.String(), block.IndexFilename),
+		path.Join(fiveMinDone.String(), block.ChunksDirname, "000001"),
+		path.Join(fiveMinDone.String(), block.IndexFilename),
+	}, uploads(cold))
+	testutil.Equals(t, []string{
+		path.Join(oneHour.String(), block.ChunksDirname, "000001"),
+		path.Join(oneHour.String(), block.IndexFilename),
+	}, uploads(archive))
+	testutil.Equals(t, 2.0, promtest.ToFloat64(transitions.WithLabelValues("cold")))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(transitions.WithLabelValues("archive")))
+
+	// The files are unchanged.
+	rc, err := inner.Get(ctx, path.Join(rawDone.String(), block.IndexFilename))
+	testutil.Ok(t, err)
+	b, err := io.ReadAll(rc)
+	testutil.Ok(t, err)
+	testutil.Ok(t, rc.Close())
+	testutil.Equals(t, block.IndexFilename, string(b))
+
+	m := &metadata.StorageClassMark{}
+	testutil.Ok(t, metadata.ReadMarker(ctx, log.NewNopLogger(), bkt, rawDone.String(), m))
+	testutil.Equals(t, "cold", m.StorageClass)
+
+	// Another applier finds the blocks already moved from their markers.
+	cold.uploads, archive.uploads = nil, nil
+	a = NewStorageClassApplier(log.NewNopLogger(), bkt, []StorageClass{archiveClass, coldClass}, t.TempDir(), transitions)
+	testutil.Ok(t, a.Apply(ctx, metas))
+	testutil.Equals(t, 0, len(cold.uploads))
+	testutil.Equals(t, 0, len(archive.uploads))
+}
+
+func TestStorageClassApplier_ServerSideCopy(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	inner := objstore.NewInMemBucket()
+	bkt := objstore.WithNoopInstr(inner)
+	cold := &uploadRecordingBucket{Bucket: inner}
+
+	id := ulid.MustNew(1, nil)
+	for _, name := range []string{block.IndexFilename, block.MetaFilename, path.Join(block.ChunksDirname, "000001")} {
+		testutil.Ok(t, inner.Upload(ctx, path.Join(id.String(), name), strings.NewReader(name)))
+	}
+	metas := map[ulid.ULID]*metadata.Meta{id: storageClassMeta(id, time.Now().Add(-48*time.Hour), int64(ResolutionLevelRaw), id)}
+
+	copier := &inPlaceCopier{}
+	coldClass, err := NewStorageClass(StorageClassConfig{Name: "cold", Resolutions: []string{"raw"}, MinAge: model.Duration(24 * time.Hour)}, cold, copier)
+	testutil.Ok(t, err)
+	transitions := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test"}, []string{"class"})
+	testutil.Ok(t, NewStorageClassApplier(log.NewNopLogger(), bkt, []StorageClass{coldClass}, t.TempDir(), transitions).Apply(ctx, metas))
+
+	sort.Strings(copier.copies)
+	testutil.Equals(t, []string{
+		path.Join(id.String(), block.ChunksDirname, "000001"),
+		path.Join(id.String(), block.IndexFilename),
+	}, copier.copies)
+	testutil.Equals(t, 0, len(cold.uploads))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(transitions.WithLabelValues("cold")))
+}
+
+func TestParseStorageClassesConfig(t *testing.T) {
+	t.Parallel()
+
+	confs, err := ParseStorageClassesConfig([]byte(`
+- name: cold
+  resolutions: [raw]
+  min_age: 30d
+  downsampled: true
+  bucket:
+    type: FILESYSTEM
+    config:
+      directory: /tmp
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, []StorageClassConfig{{
+		Name:        "cold",
+		Resolutions: []string{"raw"},
+		MinAge:      model.Duration(30 * 24 * time.Hour),
+		Downsampled: true,
+		Bucket:      client.BucketConfig{Type: client.FILESYSTEM, Config: map[interface{}]interface{}{"directory": "/tmp"}},
+	}}, confs)
+
+	for _, conf := range []string{
+		`- resolutions: [raw]
+  bucket: {type: FILESYSTEM}`,
+		`- name: cold
+  resolutions: [2h]
+  bucket: {type: FILESYSTEM}`,
+		`- name: cold`,
+		`- name: cold
+  bucket: {type: FILESYSTEM}
+- name: cold
+  bucket: {type: FILESYSTEM}`,
+	} {
+		_, err := ParseStorageClassesConfig([]byte(conf))
+		testutil.NotOk(t, err, conf)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/metering"
+)
+
+// ListTenants returns the tenants of a bucket in a tenant directory layout, <tenant>/<block>, as Cortex and Mimir
+// lay out their buckets: the top level directories of the bucket which are neither blocks, debug files, usage
+// records nor compaction-disabled markers.
+func ListTenants(ctx context.Context, bkt objstore.BucketReader) ([]string, error) {
+	var tenants []string
+	if err := bkt.Iter(ctx, "", func(name string) error {
+		if !strings.HasSuffix(name, objstore.DirDelim) {
+			return nil
+		}
+		dir := strings.TrimSuffix(name, objstore.DirDelim)
+		if _, ok := block.IsBlockDir(dir); ok || dir == path.Dir(block.DebugMetas) || dir == metering.RecordsDir || block.IsBucketDir(dir) {
+			return nil
+		}
+		tenants = append(tenants, dir)
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "list tenant directories")
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+// TenantFromPrefix returns the tenant of the blocks with the object prefix in a tenant directory layout, the last
+// directory of the prefix.
+func TenantFromPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, objstore.DirDelim)
+	if prefix == "" {
+		return ""
+	}
+	return path.Base(prefix)
+}
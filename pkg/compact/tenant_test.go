@@ -0,0 +1,64 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+func TestListTenants(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	id := ulid.MustNew(1, nil)
+	for _, name := range []string{
+		"team-b/" + id.String() + "/meta.json",
+		"team-a/" + id.String() + "/meta.json",
+		"team-a/markers/x",
+		id.String() + "/meta.json",
+		"debug/metas/" + id.String() + ".json",
+		"bucket-index.json",
+		"usage/compact/" + id.String() + ".json",
+		"compaction-disabled/freeze.json",
+		"team-b/compaction-disabled/freeze.json",
+	} {
+		testutil.Ok(t, bkt.Upload(ctx, name, bytes.NewReader(nil)))
+	}
+
+	tenants, err := ListTenants(ctx, bkt)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"team-a", "team-b"}, tenants)
+}
+
+func TestDefaultGrouper_SetTenantPrefix(t *testing.T) {
+	t.Parallel()
+
+	testutil.Equals(t, "team-a", TenantFromPrefix("team-a"))
+	testutil.Equals(t, "team-a", TenantFromPrefix("/tenants/team-a/"))
+	testutil.Equals(t, "", TenantFromPrefix("/"))
+
+	temp := promauto.With(nil).NewCounter(prometheus.CounterOpts{Name: "test_metric_for_tenant"})
+	grouper := NewDefaultGrouper(log.NewNopLogger(), objstore.NewInMemBucket(), false, false, nil, temp, temp, temp, "", 1, 1)
+	grouper.SetTenantPrefix("tenants/team-a/")
+	m := &metadata.Meta{}
+	m.ULID = ulid.MustNew(1, nil)
+	m.Thanos.Labels = map[string]string{"a": "1"}
+	groups, err := grouper.Groups(map[ulid.ULID]*metadata.Meta{m.ULID: m})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(groups))
+	testutil.Equals(t, "team-a", groups[0].Tenant())
+	testutil.Equals(t, m.Thanos.GroupKey(), groups[0].Key())
+}
@@ -0,0 +1,197 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/index"
+)
+
+// UTF8Policy is the policy the label names and values of series are validated against.
+type UTF8Policy string
+
+const (
+	// UTF8PolicyNone does not validate the encoding of the labels.
+	UTF8PolicyNone UTF8Policy = "none"
+	// UTF8PolicyUTF8 requires the label names and values to be valid UTF-8.
+	UTF8PolicyUTF8 UTF8Policy = "utf8"
+	// UTF8PolicyLegacy requires the metric and label names to match the legacy Prometheus character set, and the
+	// label values to be valid UTF-8.
+	UTF8PolicyLegacy UTF8Policy = "legacy"
+)
+
+// Reasons of the series violating the series validation.
+const (
+	LabelValueTooLongReason = "label_value_too_long"
+	TooManyLabelsReason     = "too_many_labels"
+	InvalidUTF8Reason       = "invalid_utf8"
+	InvalidLabelNameReason  = "invalid_label_name"
+)
+
+// SeriesValidation is the constraints the series of the compacted blocks are validated against. A zero constraint is
+// not validated.
+type SeriesValidation struct {
+	// MaxLabelValueLength is the maximum length of the label values, in bytes.
+	MaxLabelValueLength int
+	// MaxLabelNames is the maximum number of labels of a series, including the metric name.
+	MaxLabelNames int
+	// UTF8Policy is the policy the label names and values are validated against.
+	UTF8Policy UTF8Policy
+	// DropInvalidSeries drops the violating series from the compacted blocks, instead of only reporting them.
+	DropInvalidSeries bool
+}
+
+func (v SeriesValidation) enabled() bool {
+	return v.MaxLabelValueLength > 0 || v.MaxLabelNames > 0 || (v.UTF8Policy != "" && v.UTF8Policy != UTF8PolicyNone)
+}
+
+// Validate returns an error if the series validation is misconfigured.
+func (v SeriesValidation) Validate() error {
+	switch v.UTF8Policy {
+	case "", UTF8PolicyNone, UTF8PolicyUTF8, UTF8PolicyLegacy:
+	default:
+		return errors.Errorf("unknown UTF-8 policy %q", v.UTF8Policy)
+	}
+	if v.MaxLabelValueLength < 0 || v.MaxLabelNames < 0 {
+		return errors.New("series validation limits have to be positive, or zero to disable them")
+	}
+	return nil
+}
+
+// violation returns the reason the series violates the validation, or an empty string if it does not.
+func (v SeriesValidation) violation(lset labels.Labels) string {
+	if v.MaxLabelNames > 0 && lset.Len() > v.MaxLabelNames {
+		return TooManyLabelsReason
+	}
+	reason := ""
+	lset.Range(func(l labels.Label) {
+		if reason != "" {
+			return
+		}
+		switch {
+		case v.MaxLabelValueLength > 0 && len(l.Value) > v.MaxLabelValueLength:
+			reason = LabelValueTooLongReason
+		case v.UTF8Policy == UTF8PolicyLegacy && l.Name == labels.MetricName && !model.IsValidLegacyMetricName(l.Value):
+			reason = InvalidLabelNameReason
+		case v.UTF8Policy == UTF8PolicyLegacy && !model.LabelName(l.Name).IsValidLegacy():
+			reason = InvalidLabelNameReason
+		case (v.UTF8Policy == UTF8PolicyUTF8 || v.UTF8Policy == UTF8PolicyLegacy) && (!utf8.ValidString(l.Name) || !utf8.ValidString(l.Value)):
+			reason = InvalidUTF8Reason
+		}
+	})
+	return reason
+}
+
+// seriesValidator validates the series of the source blocks of a compaction, counting the violating series of every
+// source block, and dropping them from the compacted block if configured to.
+type seriesValidator struct {
+	validation    SeriesValidation
+	invalidSeries *prometheus.CounterVec
+
+	mtx        sync.Mutex
+	violations map[string]int
+}
+
+func newSeriesValidator(validation SeriesValidation, invalidSeries *prometheus.CounterVec) *seriesValidator {
+	return &seriesValidator{validation: validation, invalidSeries: invalidSeries, violations: map[string]int{}}
+}
+
+func (v *seriesValidator) valid(lset labels.Labels) bool {
+	reason := v.validation.violation(lset)
+	if reason == "" {
+		return true
+	}
+	v.mtx.Lock()
+	v.violations[reason]++
+	v.mtx.Unlock()
+	if v.invalidSeries != nil {
+		v.invalidSeries.WithLabelValues(reason).Inc()
+	}
+	return !v.validation.DropInvalidSeries
+}
+
+// logViolations logs the number of violating series of the compaction by reason, if any.
+func (v *seriesValidator) logViolations(logger log.Logger) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	if len(v.violations) == 0 {
+		return
+	}
+	kvs := []any{"msg", "source blocks have series violating the series validation", "dropped", v.validation.DropInvalidSeries}
+	for _, reason := range []string{LabelValueTooLongReason, TooManyLabelsReason, InvalidUTF8Reason, InvalidLabelNameReason} {
+		if n, ok := v.violations[reason]; ok {
+			kvs = append(kvs, reason, n)
+		}
+	}
+	level.Warn(logger).Log(kvs...)
+}
+
+// validatingBlockPopulator populates the block with the series of the blocks validated by its validator.
+type validatingBlockPopulator struct {
+	tsdb.BlockPopulator
+	v *seriesValidator
+}
+
+func (p validatingBlockPopulator) PopulateBlock(ctx context.Context, metrics *tsdb.CompactorMetrics, logger *slog.Logger, chunkPool chunkenc.Pool, mergeFunc storage.VerticalChunkSeriesMergeFunc, blocks []tsdb.BlockReader, meta *tsdb.BlockMeta, indexw tsdb.IndexWriter, chunkw tsdb.ChunkWriter, postingsFunc tsdb.IndexReaderPostingsFunc) error {
+	validPostingsFunc := func(ctx context.Context, r tsdb.IndexReader) index.Postings {
+		return &validPostings{Postings: postingsFunc(ctx, r), r: r, v: p.v}
+	}
+	return p.BlockPopulator.PopulateBlock(ctx, metrics, logger, chunkPool, mergeFunc, blocks, meta, indexw, chunkw, validPostingsFunc)
+}
+
+// validPostings are the postings of the series validated by the validator.
+type validPostings struct {
+	index.Postings
+	r       tsdb.IndexReader
+	v       *seriesValidator
+	builder labels.ScratchBuilder
+	err     error
+}
+
+func (p *validPostings) Next() bool {
+	for p.err == nil && p.Postings.Next() {
+		if p.keep() {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *validPostings) Seek(v storage.SeriesRef) bool {
+	if !p.Postings.Seek(v) {
+		return false
+	}
+	return p.keep() || p.Next()
+}
+
+func (p *validPostings) keep() bool {
+	if p.err != nil {
+		return false
+	}
+	if err := p.r.Series(p.At(), &p.builder, nil); err != nil {
+		p.err = errors.Wrapf(err, "read series %d", p.At())
+		return false
+	}
+	return p.v.valid(p.builder.Labels())
+}
+
+func (p *validPostings) Err() error {
+	if p.err != nil {
+		return p.err
+	}
+	return p.Postings.Err()
+}
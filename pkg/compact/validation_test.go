@@ -0,0 +1,106 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestSeriesValidation_Violation(t *testing.T) {
+	t.Parallel()
+
+	v := SeriesValidation{MaxLabelValueLength: 5, MaxLabelNames: 2, UTF8Policy: UTF8PolicyLegacy}
+	for _, c := range []struct {
+		lset   labels.Labels
+		reason string
+	}{
+		{lset: labels.FromStrings("__name__", "up", "a", "1")},
+		{lset: labels.FromStrings("__name__", "up", "a", "1", "b", "2"), reason: TooManyLabelsReason},
+		{lset: labels.FromStrings("__name__", "up", "a", "123456"), reason: LabelValueTooLongReason},
+		{lset: labels.FromStrings("__name__", "up.1"), reason: InvalidLabelNameReason},
+		{lset: labels.FromStrings("__name__", "up", "a.b", "1"), reason: InvalidLabelNameReason},
+		{lset: labels.FromStrings("__name__", "up", "a", "\xff"), reason: InvalidUTF8Reason},
+	} {
+		testutil.Equals(t, c.reason, v.violation(c.lset), c.lset.String())
+	}
+
+	// Dotted names are valid UTF-8.
+	testutil.Equals(t, "", SeriesValidation{UTF8Policy: UTF8PolicyUTF8}.violation(labels.FromStrings("__name__", "up.1")))
+	testutil.Assert(t, !SeriesValidation{UTF8Policy: UTF8PolicyNone}.enabled())
+	testutil.NotOk(t, SeriesValidation{UTF8Policy: "ascii"}.Validate())
+}
+
+func TestValidatingBlockPopulator(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	series := []labels.Labels{
+		labels.FromStrings("__name__", "up", "a", "1"),
+		labels.FromStrings("__name__", "up", "a", "123456"),
+		labels.FromStrings("__name__", "up", "a", "\xff"),
+	}
+	id, err := e2eutil.CreateBlock(ctx, dir, series, 10, 0, 1000, labels.EmptyLabels(), 0, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+	b, err := tsdb.OpenBlock(promslog.NewNopLogger(), filepath.Join(dir, id.String()), nil, nil)
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, b.Close()) })
+
+	for _, drop := range []bool{false, true} {
+		invalidSeries := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "invalid_series_total"}, []string{"reason"})
+		v := newSeriesValidator(SeriesValidation{MaxLabelValueLength: 5, UTF8Policy: UTF8PolicyUTF8, DropInvalidSeries: drop}, invalidSeries)
+
+		out := t.TempDir()
+		indexw, err := index.NewWriter(ctx, filepath.Join(out, block.IndexFilename))
+		testutil.Ok(t, err)
+		chunkw, err := chunks.NewWriter(filepath.Join(out, block.ChunksDirname))
+		testutil.Ok(t, err)
+		meta := b.Meta()
+		testutil.Ok(t, validatingBlockPopulator{BlockPopulator: tsdb.DefaultBlockPopulator{}, v: v}.PopulateBlock(ctx, tsdb.NewCompactorMetrics(nil), promslog.NewNopLogger(), chunkenc.NewPool(),
+			storage.NewCompactingChunkSeriesMerger(storage.ChainedSeriesMerge), []tsdb.BlockReader{b}, &meta, indexw, chunkw, tsdb.AllSortedPostings))
+		testutil.Ok(t, chunkw.Close())
+		testutil.Ok(t, indexw.Close())
+
+		testutil.Equals(t, 1.0, promtest.ToFloat64(invalidSeries.WithLabelValues(LabelValueTooLongReason)))
+		testutil.Equals(t, 1.0, promtest.ToFloat64(invalidSeries.WithLabelValues(InvalidUTF8Reason)))
+
+		ir, err := index.NewFileReader(filepath.Join(out, block.IndexFilename), index.DecodePostingsRaw)
+		testutil.Ok(t, err)
+		var (
+			builder labels.ScratchBuilder
+			got     []string
+		)
+		p := tsdb.AllSortedPostings(ctx, ir)
+		for p.Next() {
+			testutil.Ok(t, ir.Series(p.At(), &builder, nil))
+			got = append(got, builder.Labels().Get("a"))
+		}
+		testutil.Ok(t, p.Err())
+		testutil.Ok(t, ir.Close())
+
+		if drop {
+			testutil.Equals(t, "1", strings.Join(got, ","))
+		} else {
+			testutil.Equals(t, 3, len(got))
+		}
+	}
+}
@@ -6,7 +6,10 @@ package dedup
 import (
 	"bytes"
 	"container/heap"
+	"strings"
 
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"github.com/prometheus/prometheus/tsdb/chunks"
@@ -14,9 +17,45 @@ import (
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
 )
 
+// ChunkSeriesMergerOption configures the chunk series merger.
+type ChunkSeriesMergerOption func(*dedupChunksIterator)
+
+// WithIgnoreCreatedTimestampZeros skips the zero samples of a replica that the deduplication switches to while the
+// last sample is positive, like the zeros Prometheus injects at the created timestamp of counters, instead of merging
+// them as counter resets. Only the samples of counter series are skipped, see isCounterSeries.
+func WithIgnoreCreatedTimestampZeros() ChunkSeriesMergerOption {
+	return func(d *dedupChunksIterator) {
+		d.ignoreCreatedTimestampZeros = true
+	}
+}
+
+// counterSuffixes are the suffixes of the names of the series Prometheus injects created timestamp zeros in: counters,
+// and the counts, sums and buckets of classic histograms and summaries.
+var counterSuffixes = []string{"_total", "_count", "_sum", "_bucket"}
+
+// isCounterSeries returns true if the series is a counter, from its __type__ label if set, like with the
+// type-and-unit-labels feature of Prometheus, or else from the suffix of its metric name. The zeros of other series,
+// like gauges, are never created timestamp zeros.
+func isCounterSeries(lset labels.Labels) bool {
+	switch lset.Get("__type__") {
+	case "counter", "histogram", "summary":
+		return true
+	case "":
+	default:
+		return false
+	}
+	name := lset.Get(labels.MetricName)
+	for _, suffix := range counterSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
 // NewChunkSeriesMerger merges several chunk series into one.
 // Deduplication is based on penalty based deduplication algorithm without handling counter reset.
-func NewChunkSeriesMerger() storage.VerticalChunkSeriesMergeFunc {
+func NewChunkSeriesMerger(opts ...ChunkSeriesMergerOption) storage.VerticalChunkSeriesMergeFunc {
 	return func(series ...storage.ChunkSeries) storage.ChunkSeries {
 		if len(series) == 0 {
 			return nil
@@ -28,9 +67,14 @@ func NewChunkSeriesMerger() storage.VerticalChunkSeriesMergeFunc {
 				for _, s := range series {
 					iterators = append(iterators, s.Iterator(nil))
 				}
-				return &dedupChunksIterator{
+				d := &dedupChunksIterator{
 					iterators: iterators,
 				}
+				for _, opt := range opts {
+					opt(d)
+				}
+				d.ignoreCreatedTimestampZeros = d.ignoreCreatedTimestampZeros && isCounterSeries(series[0].Labels())
+				return d
 			},
 		}
 	}
@@ -42,6 +86,8 @@ type dedupChunksIterator struct {
 
 	err  error
 	curr chunks.Meta
+
+	ignoreCreatedTimestampZeros bool
 }
 
 func (d *dedupChunksIterator) At() chunks.Meta {
@@ -69,7 +115,7 @@ func (d *dedupChunksIterator) Next() bool {
 	}
 
 	var (
-		om       = newOverlappingMerger()
+		om       = newOverlappingMerger(d.ignoreCreatedTimestampZeros)
 		oMaxTime = d.curr.MaxTime
 		prev     = d.curr
 	)
@@ -159,13 +205,15 @@ type overlappingMerger struct {
 	samplesMergeFunc func(a, b chunkenc.Iterator) chunkenc.Iterator
 }
 
-func newOverlappingMerger() *overlappingMerger {
+func newOverlappingMerger(ignoreCreatedTimestampZeros bool) *overlappingMerger {
 	return &overlappingMerger{
 		samplesMergeFunc: func(a, b chunkenc.Iterator) chunkenc.Iterator {
-			return newDedupSeriesIterator(
+			it := newDedupSeriesIterator(
 				noopAdjustableSeriesIterator{a},
 				noopAdjustableSeriesIterator{b},
 			)
+			it.ignoreCreatedTimestampZeros = ignoreCreatedTimestampZeros
+			return it
 		},
 	}
 }
@@ -331,19 +379,29 @@ func (a *aggrChunkIterator) toChunk(at downsample.AggrType, minTime, maxTime int
 	if a.iters[at] == nil {
 		return nil, nil
 	}
+	it := NewBoundedSeriesIterator(a.iters[at], minTime, maxTime)
+
+	vt := it.Next()
+	if vt == chunkenc.ValNone {
+		// No sample in the required time range.
+		return nil, it.Err()
+	}
+	if vt == chunkenc.ValFloatHistogram {
+		// Aggregates of native histograms, i.e. their sum and counter, are float histograms.
+		return toFloatHistogramChunk(it, minTime, maxTime)
+	}
+
 	c := chunkenc.NewXORChunk()
 	appender, err := c.Appender()
 	if err != nil {
 		return nil, err
 	}
 
-	it := NewBoundedSeriesIterator(a.iters[at], minTime, maxTime)
-
 	var (
 		lastT int64
 		lastV float64
 	)
-	for it.Next() != chunkenc.ValNone {
+	for ; vt != chunkenc.ValNone; vt = it.Next() {
 		lastT, lastV = it.At()
 		appender.Append(lastT, lastV)
 	}
@@ -351,11 +409,6 @@ func (a *aggrChunkIterator) toChunk(at downsample.AggrType, minTime, maxTime int
 		return nil, err
 	}
 
-	// No sample in the required time range.
-	if lastT == 0 && lastV == 0 {
-		return nil, nil
-	}
-
 	// Encode last sample for AggrCounter.
 	if at == downsample.AggrCounter {
 		appender.Append(lastT, lastV)
@@ -367,3 +420,35 @@ func (a *aggrChunkIterator) toChunk(at downsample.AggrType, minTime, maxTime int
 		Chunk:   c,
 	}, nil
 }
+
+// toFloatHistogramChunk encodes the float histograms of the iterator, positioned at its first sample, into a chunk.
+// Unlike float counters, counters of native histograms don't encode their last sample twice.
+func toFloatHistogramChunk(it chunkenc.Iterator, minTime, maxTime int64) (*chunks.Meta, error) {
+	var c chunkenc.Chunk = chunkenc.NewFloatHistogramChunk()
+	appender, err := c.Appender()
+	if err != nil {
+		return nil, err
+	}
+	for vt := chunkenc.ValFloatHistogram; vt != chunkenc.ValNone; vt = it.Next() {
+		if vt != chunkenc.ValFloatHistogram {
+			return nil, errors.Errorf("unexpected value type %v in aggregate of native histograms", vt)
+		}
+		t, fh := it.AtFloatHistogram(nil)
+		newChk, _, newApp, err := appender.AppendFloatHistogram(appender.(*chunkenc.FloatHistogramAppender), t, fh, false)
+		if err != nil {
+			return nil, err
+		}
+		if newChk != nil {
+			c = newChk
+		}
+		appender = newApp
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return &chunks.Meta{
+		MinTime: minTime,
+		MaxTime: maxTime,
+		Chunk:   c,
+	}, nil
+}
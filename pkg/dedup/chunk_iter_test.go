@@ -12,6 +12,7 @@ import (
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
 
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
 )
@@ -146,6 +147,62 @@ func TestDedupChunkSeriesMerger(t *testing.T) {
 	}
 }
 
+func TestDedupChunkSeriesMerger_IgnoreCreatedTimestampZeros(t *testing.T) {
+	t.Parallel()
+
+	var (
+		aSamples     = []chunks.Sample{sample{10000, 1}, sample{20000, 2}, sample{30000, 3}, sample{60000, 6}, sample{70000, 7}}
+		bSamples     = []chunks.Sample{sample{10100, 1}, sample{20100, 2}, sample{30100, 3}, sample{40100, 4}, sample{50100, 0}, sample{60100, 6}}
+		merged       = []chunks.Sample{sample{10000, 1}, sample{20000, 2}, sample{30000, 3}, sample{50100, 0}, sample{60100, 6}}
+		zerosSkipped = aSamples
+	)
+	for _, tc := range []struct {
+		lset     labels.Labels
+		merger   storage.VerticalChunkSeriesMergeFunc
+		expected []chunks.Sample
+	}{
+		{
+			lset:     labels.FromStrings("__name__", "requests_total"),
+			merger:   NewChunkSeriesMerger(),
+			expected: merged,
+		},
+		{
+			lset:     labels.FromStrings("__name__", "requests_total"),
+			merger:   NewChunkSeriesMerger(WithIgnoreCreatedTimestampZeros()),
+			expected: zerosSkipped,
+		},
+		{
+			lset:     labels.FromStrings("__name__", "request_duration_seconds_bucket", "le", "1"),
+			merger:   NewChunkSeriesMerger(WithIgnoreCreatedTimestampZeros()),
+			expected: zerosSkipped,
+		},
+		{
+			lset:     labels.FromStrings("__name__", "requests", "__type__", "counter"),
+			merger:   NewChunkSeriesMerger(WithIgnoreCreatedTimestampZeros()),
+			expected: zerosSkipped,
+		},
+		{
+			// The zeros of gauges are genuine.
+			lset:     labels.FromStrings("__name__", "queue_length"),
+			merger:   NewChunkSeriesMerger(WithIgnoreCreatedTimestampZeros()),
+			expected: merged,
+		},
+		{
+			lset:     labels.FromStrings("__name__", "queue_total", "__type__", "gauge"),
+			merger:   NewChunkSeriesMerger(WithIgnoreCreatedTimestampZeros()),
+			expected: merged,
+		},
+	} {
+		a := storage.NewListChunkSeriesFromSamples(tc.lset, aSamples)
+		b := storage.NewListChunkSeriesFromSamples(tc.lset, bSamples)
+		actChks, actErr := storage.ExpandChunks(tc.merger(a, b).Iterator(nil))
+		testutil.Ok(t, actErr)
+		expChks, expErr := storage.ExpandChunks(storage.NewListChunkSeriesFromSamples(tc.lset, tc.expected).Iterator(nil))
+		testutil.Ok(t, expErr)
+		testutil.Equals(t, expChks, actChks)
+	}
+}
+
 func TestDedupChunkSeriesMergerDownsampledChunks(t *testing.T) {
 	m := NewChunkSeriesMerger()
 
@@ -323,6 +380,74 @@ func TestDedupChunkSeriesMergerDownsampledChunks(t *testing.T) {
 	}
 }
 
+func TestDedupChunkSeriesMergerDownsampledHistogramChunks(t *testing.T) {
+	// aggrChunk returns a downsampled chunk of native histograms, with their sum and counter.
+	aggrChunk := func(ts []int64, counters []int64) chunks.Meta {
+		var count, sum, counter []chunks.Sample
+		for i, t := range ts {
+			count = append(count, sample{t: t, f: 1})
+			sum = append(sum, histoSample{t: t, fh: tsdbutil.GenerateTestFloatHistogram(1)})
+			counter = append(counter, histoSample{t: t, fh: tsdbutil.GenerateTestFloatHistogram(counters[i])})
+		}
+		var chks [5]chunkenc.Chunk
+		for i, s := range map[downsample.AggrType][]chunks.Sample{downsample.AggrCount: count, downsample.AggrSum: sum, downsample.AggrCounter: counter} {
+			chk, err := chunks.ChunkFromSamples(s)
+			testutil.Ok(t, err)
+			chks[i] = chk.Chunk
+		}
+		return chunks.Meta{MinTime: ts[0], MaxTime: ts[len(ts)-1], Chunk: downsample.EncodeAggrChunk(chks)}
+	}
+
+	m := NewChunkSeriesMerger()
+	merged := m(
+		&storage.ChunkSeriesEntry{
+			Lset: labels.FromStrings("bar", "baz"),
+			ChunkIteratorFn: func(chunks.Iterator) chunks.Iterator {
+				return storage.NewListChunkSeriesIterator(aggrChunk([]int64{300000, 600000}, []int64{1, 2}))
+			},
+		},
+		&storage.ChunkSeriesEntry{
+			Lset: labels.FromStrings("bar", "baz"),
+			ChunkIteratorFn: func(chunks.Iterator) chunks.Iterator {
+				return storage.NewListChunkSeriesIterator(aggrChunk([]int64{600000, 900000}, []int64{2, 3}))
+			},
+		},
+	)
+	actChks, err := storage.ExpandChunks(merged.Iterator(nil))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(actChks))
+
+	// Counter reset hints of the chunks may differ, so compare their samples.
+	expChk := aggrChunk([]int64{300000, 600000, 900000}, []int64{1, 2, 3})
+	testutil.Equals(t, expChk.MinTime, actChks[0].MinTime)
+	testutil.Equals(t, expChk.MaxTime, actChks[0].MaxTime)
+	for _, at := range []downsample.AggrType{downsample.AggrCount, downsample.AggrSum, downsample.AggrCounter} {
+		exp, err := expChk.Chunk.(*downsample.AggrChunk).Get(at)
+		testutil.Ok(t, err)
+		act, err := actChks[0].Chunk.(*downsample.AggrChunk).Get(at)
+		testutil.Ok(t, err)
+		testutil.Equals(t, exp.Encoding(), act.Encoding())
+		testutil.Equals(t, expandChunkSamples(t, exp), expandChunkSamples(t, act))
+	}
+}
+
+// expandChunkSamples returns the samples of the chunk, without their counter reset hints.
+func expandChunkSamples(t *testing.T, c chunkenc.Chunk) (res []chunks.Sample) {
+	it := c.Iterator(nil)
+	for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+		if vt == chunkenc.ValFloatHistogram {
+			ts, fh := it.AtFloatHistogram(nil)
+			fh.CounterResetHint = histogram.UnknownCounterReset
+			res = append(res, histoSample{t: ts, fh: fh})
+			continue
+		}
+		ts, f := it.At()
+		res = append(res, sample{t: ts, f: f})
+	}
+	testutil.Ok(t, it.Err())
+	return res
+}
+
 type histoSample struct {
 	t  int64
 	f  float64
@@ -227,6 +227,9 @@ type adjustableSeriesIterator interface {
 	// adjustAtValue allows to adjust value by implementation if needed knowing the last value. This is used by counter
 	// implementation which can adjust for obsolete counter value.
 	adjustAtValue(lastFloatValue float64)
+
+	// adjustAtFloatHistogram is adjustAtValue for float histograms, e.g. of downsampled native histogram counters.
+	adjustAtFloatHistogram(lastFloatHistogram *histogram.FloatHistogram)
 }
 
 type noopAdjustableSeriesIterator struct {
@@ -235,6 +238,8 @@ type noopAdjustableSeriesIterator struct {
 
 func (it noopAdjustableSeriesIterator) adjustAtValue(float64) {}
 
+func (it noopAdjustableSeriesIterator) adjustAtFloatHistogram(*histogram.FloatHistogram) {}
+
 // counterErrAdjustSeriesIterator is extendedSeriesIterator used when we deduplicate counter.
 // It makes sure we always adjust for the latest seen last counter value for all replicas.
 // Let's consider following example:
@@ -254,13 +259,16 @@ func (it noopAdjustableSeriesIterator) adjustAtValue(float64) {}
 // We mitigate this by taking allowing invoking AdjustAtValue which adjust the value in case of last value being larger than current at.
 // (Counter cannot go down)
 //
+// Float histograms are adjusted likewise, by the difference with the last histogram if it has a larger count.
+//
 // This is to mitigate https://github.com/thanos-io/thanos/issues/2401.
 // TODO(bwplotka): Find better deduplication algorithm that does not require knowledge if the given
 // series is counter or not: https://github.com/thanos-io/thanos/issues/2547.
 type counterErrAdjustSeriesIterator struct {
 	chunkenc.Iterator
 
-	errAdjust float64
+	errAdjust   float64
+	errAdjustFH *histogram.FloatHistogram
 }
 
 func (it *counterErrAdjustSeriesIterator) adjustAtValue(lastFloatValue float64) {
@@ -276,6 +284,40 @@ func (it *counterErrAdjustSeriesIterator) At() (int64, float64) {
 	return t, v + it.errAdjust
 }
 
+func (it *counterErrAdjustSeriesIterator) adjustAtFloatHistogram(lastFloatHistogram *histogram.FloatHistogram) {
+	_, fh := it.AtFloatHistogram(nil)
+	if lastFloatHistogram.Count <= fh.Count {
+		return
+	}
+	// This replica has obsolete value. Adjust.
+	diff, err := lastFloatHistogram.Copy().Sub(fh)
+	if err != nil {
+		// Histograms with incompatible custom buckets can't be adjusted.
+		return
+	}
+	if it.errAdjustFH == nil {
+		it.errAdjustFH = diff
+		return
+	}
+	if _, err := it.errAdjustFH.Add(diff); err != nil {
+		it.errAdjustFH = diff
+	}
+}
+
+func (it *counterErrAdjustSeriesIterator) AtFloatHistogram(fh *histogram.FloatHistogram) (int64, *histogram.FloatHistogram) {
+	t, res := it.Iterator.AtFloatHistogram(fh)
+	if it.errAdjustFH == nil || res.CounterResetHint == histogram.GaugeType {
+		return t, res
+	}
+	if fh == nil {
+		// The histogram is owned by the underlying iterator.
+		res = res.Copy()
+	}
+	// Histograms with incompatible custom buckets are not adjusted.
+	_, _ = res.Add(it.errAdjustFH)
+	return t, res
+}
+
 type dedupSeriesIterator struct {
 	a, b adjustableSeriesIterator
 
@@ -288,6 +330,9 @@ type dedupSeriesIterator struct {
 
 	penA, penB int64
 	useA       bool
+
+	// ignoreCreatedTimestampZeros skips the zero samples of a replica switched to, see skipCreatedTimestampZero.
+	ignoreCreatedTimestampZeros bool
 }
 
 func newDedupSeriesIterator(a, b adjustableSeriesIterator) *dedupSeriesIterator {
@@ -303,15 +348,57 @@ func newDedupSeriesIterator(a, b adjustableSeriesIterator) *dedupSeriesIterator
 }
 
 func (it *dedupSeriesIterator) Next() chunkenc.ValueType {
+	for {
+		var (
+			lastT, penA, penB  = it.lastT, it.penA, it.penB
+			lastIter, lastUseA = it.lastIter, it.useA
+		)
+		lastFloatVal, isFloatVal := it.lastFloatVal()
+		vt := it.next()
+		if !it.skipCreatedTimestampZero(vt, lastT, lastUseA, lastFloatVal, isFloatVal) {
+			return vt
+		}
+		// Go back to the last sample, and seek the replica switched to past its zero.
+		t := it.AtT()
+		it.lastT, it.penA, it.penB, it.lastIter, it.useA = lastT, penA, penB, lastIter, lastUseA
+		if lastUseA {
+			it.penB = t - lastT
+		} else {
+			it.penA = t - lastT
+		}
+	}
+}
+
+// skipCreatedTimestampZero returns true if the sample picked is a zero of the replica switched to while the last
+// sample is positive. Such zeros are injected at the created timestamp of counters by Prometheus (the
+// created-timestamp-zero-ingestion feature), e.g. by a replica restarted after the other one has scraped the counter.
+// Picking them would read as a counter reset, inflating rate() over the deduplicated series; a genuine reset is still
+// read from the next samples of the replica. Callers only enable it for counter series, as the zeros of gauges are
+// genuine.
+func (it *dedupSeriesIterator) skipCreatedTimestampZero(vt chunkenc.ValueType, lastT int64, lastUseA bool, lastFloatVal float64, isFloatVal bool) bool {
+	if !it.ignoreCreatedTimestampZeros || vt != chunkenc.ValFloat || !isFloatVal || lastT == math.MinInt64 || it.useA == lastUseA {
+		return false
+	}
+	_, v := it.lastIter.At()
+	return v == 0 && lastFloatVal > 0
+}
+
+func (it *dedupSeriesIterator) next() chunkenc.ValueType {
 	lastFloatVal, isFloatVal := it.lastFloatVal()
+	lastFloatHistogram := it.lastFloatHistogram()
 	lastUseA := it.useA
 	defer func() {
-		if it.useA != lastUseA && isFloatVal {
-			// We switched replicas.
-			// Ensure values are correct bases on value before At.
-			// TODO(rabenhorst): Investigate if we also need to implement adjusting histograms here.
+		if it.useA == lastUseA {
+			return
+		}
+		// We switched replicas.
+		// Ensure values are correct bases on value before At.
+		if isFloatVal {
 			it.adjustAtValue(lastFloatVal)
 		}
+		if lastFloatHistogram != nil {
+			it.adjustAtFloatHistogram(lastFloatHistogram)
+		}
 	}()
 
 	// Advance both iterators to at least the next highest timestamp plus the potential penalty.
@@ -392,6 +479,16 @@ func (it *dedupSeriesIterator) lastFloatVal() (float64, bool) {
 	return 0, false
 }
 
+// lastFloatHistogram returns the last float histogram, e.g. of downsampled native histograms, or nil if the last
+// value is not a float histogram.
+func (it *dedupSeriesIterator) lastFloatHistogram() *histogram.FloatHistogram {
+	if it.useA && it.aval == chunkenc.ValFloatHistogram || !it.useA && it.bval == chunkenc.ValFloatHistogram {
+		_, fh := it.lastIter.AtFloatHistogram(nil)
+		return fh
+	}
+	return nil
+}
+
 func (it *dedupSeriesIterator) adjustAtValue(lastFloatValue float64) {
 	if it.aval == chunkenc.ValFloat {
 		it.a.adjustAtValue(lastFloatValue)
@@ -401,6 +498,15 @@ func (it *dedupSeriesIterator) adjustAtValue(lastFloatValue float64) {
 	}
 }
 
+func (it *dedupSeriesIterator) adjustAtFloatHistogram(lastFloatHistogram *histogram.FloatHistogram) {
+	if it.aval == chunkenc.ValFloatHistogram {
+		it.a.adjustAtFloatHistogram(lastFloatHistogram)
+	}
+	if it.bval == chunkenc.ValFloatHistogram {
+		it.b.adjustAtFloatHistogram(lastFloatHistogram)
+	}
+}
+
 func (it *dedupSeriesIterator) Seek(t int64) chunkenc.ValueType {
 	// Don't use underlying Seek, but iterate over next to not miss gaps.
 	for {
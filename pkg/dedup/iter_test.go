@@ -749,6 +749,35 @@ func TestDedupSeriesIterator(t *testing.T) {
 	}
 }
 
+func TestDedupSeriesIterator_CreatedTimestampZeros(t *testing.T) {
+	t.Parallel()
+
+	// The counter of b restarted from its created timestamp zero, before a's gap.
+	a := []sample{{10000, 1}, {20000, 2}, {30000, 3}, {60000, 6}, {70000, 7}}
+	b := []sample{{10100, 1}, {20100, 2}, {30100, 3}, {40100, 4}, {50100, 0}, {60100, 6}}
+
+	it := newDedupSeriesIterator(
+		noopAdjustableSeriesIterator{newMockedSeriesIterator(a)},
+		noopAdjustableSeriesIterator{newMockedSeriesIterator(b)},
+	)
+	testutil.Equals(t, []sample{{10000, 1}, {20000, 2}, {30000, 3}, {50100, 0}, {60100, 6}}, expandSeries(t, noopAdjustableSeriesIterator{it}))
+
+	it = newDedupSeriesIterator(
+		noopAdjustableSeriesIterator{newMockedSeriesIterator(a)},
+		noopAdjustableSeriesIterator{newMockedSeriesIterator(b)},
+	)
+	it.ignoreCreatedTimestampZeros = true
+	testutil.Equals(t, []sample{{10000, 1}, {20000, 2}, {30000, 3}, {60000, 6}, {70000, 7}}, expandSeries(t, noopAdjustableSeriesIterator{it}))
+
+	// Zeros of the replica followed are genuine resets.
+	it = newDedupSeriesIterator(
+		noopAdjustableSeriesIterator{newMockedSeriesIterator([]sample{{10000, 1}, {20000, 0}, {30000, 1}})},
+		noopAdjustableSeriesIterator{newMockedSeriesIterator([]sample{{10100, 1}, {20100, 0}, {30100, 1}})},
+	)
+	it.ignoreCreatedTimestampZeros = true
+	testutil.Equals(t, []sample{{10000, 1}, {20000, 0}, {30000, 1}}, expandSeries(t, noopAdjustableSeriesIterator{it}))
+}
+
 func TestDedupSeriesIterator_NativeHistograms(t *testing.T) {
 	hs := tsdbutil.GenerateTestHistograms(1)
 
@@ -785,6 +814,53 @@ func TestDedupSeriesIterator_NativeHistograms(t *testing.T) {
 	}
 }
 
+func TestDedupSeriesIterator_FloatHistogramCounters(t *testing.T) {
+	t.Parallel()
+
+	// Replica b restarted, so its downsampled counter is behind a's when a's data ends.
+	fhSamples := func(ts []int64, is []int64) chunks.SampleSlice {
+		var res chunks.SampleSlice
+		for j := range ts {
+			res = append(res, histoSample{t: ts[j], fh: tsdbutil.GenerateTestFloatHistogram(is[j])})
+		}
+		return res
+	}
+	a := fhSamples([]int64{10000, 20000, 30000}, []int64{1, 2, 3})
+	b := fhSamples([]int64{10100, 20100, 30100, 40100, 50100, 60100}, []int64{1, 2, 3, 1, 2, 3})
+
+	expand := func(it chunkenc.Iterator) (ts []int64, counts []float64) {
+		var last *histogram.FloatHistogram
+		for it.Next() != chunkenc.ValNone {
+			ts0, fh := it.AtFloatHistogram(nil)
+			if last != nil {
+				testutil.Assert(t, !fh.DetectReset(last), "unexpected counter reset at %d", ts0)
+			}
+			ts, counts, last = append(ts, ts0), append(counts, fh.Count), fh
+		}
+		return ts, counts
+	}
+
+	it := newDedupSeriesIterator(
+		&counterErrAdjustSeriesIterator{Iterator: storage.NewListSeriesIterator(a)},
+		&counterErrAdjustSeriesIterator{Iterator: storage.NewListSeriesIterator(b)},
+	)
+	ts, counts := expand(it)
+	testutil.Equals(t, []int64{10000, 20000, 30000, 50100, 60100}, ts)
+	testutil.Equals(t, []float64{21, 30, 39, 39, 48}, counts)
+
+	// Gauges are not adjusted.
+	it = newDedupSeriesIterator(
+		noopAdjustableSeriesIterator{storage.NewListSeriesIterator(a)},
+		noopAdjustableSeriesIterator{storage.NewListSeriesIterator(b)},
+	)
+	var res []float64
+	for it.Next() != chunkenc.ValNone {
+		_, fh := it.AtFloatHistogram(nil)
+		res = append(res, fh.Count)
+	}
+	testutil.Equals(t, []float64{21, 30, 39, 30, 39}, res)
+}
+
 func BenchmarkDedupSeriesIterator(b *testing.B) {
 	run := func(b *testing.B, s1, s2 []sample) {
 		it := newDedupSeriesIterator(
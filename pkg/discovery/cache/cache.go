@@ -60,3 +60,25 @@ func (c *Cache) Addresses() []string {
 	}
 	return addresses
 }
+
+// Targets returns all the targets from all target groups present in the Cache, with the labels of their groups. The
+// labels of a target take precedence over the labels of its group. Only the first target with an address is returned.
+func (c *Cache) Targets() []model.LabelSet {
+	var targets []model.LabelSet
+
+	c.Lock()
+	defer c.Unlock()
+
+	unique := make(map[model.LabelValue]struct{})
+	for _, group := range c.tgs {
+		for _, target := range group.Targets {
+			addr := target[model.AddressLabel]
+			if _, ok := unique[addr]; ok {
+				continue
+			}
+			targets = append(targets, group.Labels.Merge(target))
+			unique[addr] = struct{}{}
+		}
+	}
+	return targets
+}
@@ -44,3 +44,27 @@ func TestCacheAddresses(t *testing.T) {
 		t.Errorf("expected %v, want %v", got, expected)
 	}
 }
+
+func TestCacheTargets(t *testing.T) {
+	tgs := make(map[string]*targetgroup.Group)
+	tgs["g1"] = &targetgroup.Group{
+		Targets: []model.LabelSet{
+			{model.AddressLabel: "localhost:9090"},
+			{model.AddressLabel: "localhost:9091", "a": "target"},
+		},
+		Labels: model.LabelSet{"a": "group"},
+	}
+
+	c := &Cache{tgs: tgs}
+
+	expected := []model.LabelSet{
+		{model.AddressLabel: "localhost:9090", "a": "group"},
+		{model.AddressLabel: "localhost:9091", "a": "target"},
+	}
+
+	got := c.Targets()
+	sort.Slice(got, func(i, j int) bool { return got[i][model.AddressLabel] < got[j][model.AddressLabel] })
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, want %v", got, expected)
+	}
+}
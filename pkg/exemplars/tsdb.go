@@ -4,6 +4,7 @@
 package exemplars
 
 import (
+	"context"
 	"sync"
 
 	"github.com/gogo/status"
@@ -82,6 +83,28 @@ func (t *TSDB) Exemplars(matchers [][]*labels.Matcher, start, end int64, s exemp
 	return nil
 }
 
+// SelectAll returns all the exemplars of the queryable in [mint, maxt), e.g. to persist them into the block of that time
+// range.
+func SelectAll(ctx context.Context, db storage.ExemplarQueryable, mint, maxt int64) ([]*exemplarspb.ExemplarData, error) {
+	eq, err := db.ExemplarQuerier(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The exemplar querier selects the exemplars of a closed interval.
+	res, err := eq.Select(mint, maxt-1, []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+")})
+	if err != nil {
+		return nil, err
+	}
+	data := make([]*exemplarspb.ExemplarData, 0, len(res))
+	for _, e := range res {
+		data = append(data, &exemplarspb.ExemplarData{
+			SeriesLabels: labelpb.ZLabelSet{Labels: labelpb.ZLabelsFromPromLabels(e.SeriesLabels)},
+			Exemplars:    exemplarspb.ExemplarsFromPromExemplars(e.Exemplars),
+		})
+	}
+	return data, nil
+}
+
 // selectorsMatchesExternalLabels returns false if none of the selectors matches the external labels.
 // If true, it also returns an array of non-empty Prometheus matchers.
 func selectorsMatchesExternalLabels(selectors [][]*labels.Matcher, externalLabels labels.Labels) (bool, [][]*labels.Matcher) {
@@ -86,6 +86,48 @@ func RegisterCommonObjStoreFlags(cmd FlagClause, suffix string, required bool, e
 	return extflag.RegisterPathOrContent(cmd, fmt.Sprintf("objstore%s.config", suffix), help, opts...)
 }
 
+// RegisterObjStoreEncryptionFlags registers flags to pass a client side encryption configuration of block files.
+func RegisterObjStoreEncryptionFlags(cmd FlagClause) *extflag.PathOrContent {
+	return extflag.RegisterPathOrContent(
+		cmd,
+		"objstore.encryption-config",
+		"YAML file with client side encryption configuration of block files. See format details: https://thanos.io/tip/thanos/storage.md/#client-side-encryption ",
+		extflag.WithEnvSubstitution(),
+	)
+}
+
+// RegisterBlockAttestationFlags registers flags to pass the configuration of the signing and verification of block
+// attestations.
+func RegisterBlockAttestationFlags(cmd FlagClause) *extflag.PathOrContent {
+	return extflag.RegisterPathOrContent(
+		cmd,
+		"block.attestation-config",
+		"YAML file with the signing and verification keys of block attestations. See format details: https://thanos.io/tip/thanos/storage.md/#block-attestations ",
+		extflag.WithEnvSubstitution(),
+	)
+}
+
+// RegisterObjStoreResilienceFlags registers flags to pass the deadlines, retries and circuit breaker of the
+// operations of the object storage.
+func RegisterObjStoreResilienceFlags(cmd FlagClause) *extflag.PathOrContent {
+	return extflag.RegisterPathOrContent(
+		cmd,
+		"objstore.resilience-config",
+		"YAML file with the deadlines, retries and circuit breaker of object storage operations. See format details: https://thanos.io/tip/thanos/storage.md/#deadlines-retries-and-circuit-breaking ",
+		extflag.WithEnvSubstitution(),
+	)
+}
+
+// RegisterHTTPRBACFlags registers flags to pass the RBAC configuration of HTTP endpoints.
+func RegisterHTTPRBACFlags(cmd FlagClause) *extflag.PathOrContent {
+	return extflag.RegisterPathOrContent(
+		cmd,
+		"http.rbac-config",
+		"YAML file with the rules allowing identified HTTP clients to access tenants and administrative endpoints. See format details: https://thanos.io/tip/operating/https.md/#tenant-scoped-rbac ",
+		extflag.WithEnvSubstitution(),
+	)
+}
+
 // RegisterCommonTracingFlags registers flags to pass a tracing configuration to be used with OpenTracing.
 func RegisterCommonTracingFlags(app FlagClause) *extflag.PathOrContent {
 	return extflag.RegisterPathOrContent(
@@ -0,0 +1,147 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// ComponentKey is the key of the log field naming the component logging a line, which per-component levels
+// apply to.
+const ComponentKey = "component"
+
+var levelOrder = map[string]int{
+	"debug": 0,
+	"info":  1,
+	"warn":  2,
+	"error": 3,
+}
+
+// Levels holds the log levels of the process, a default level and per-component overrides, which can be
+// changed at runtime.
+type Levels struct {
+	mtx        sync.RWMutex
+	def        string
+	components map[string]string
+}
+
+// NewLevels returns log levels with the given default level. The level must be error, warn, info or debug.
+func NewLevels(defaultLevel string) (*Levels, error) {
+	if _, ok := levelOrder[defaultLevel]; !ok {
+		return nil, errors.Errorf("unexpected log level %q", defaultLevel)
+	}
+	return &Levels{def: defaultLevel, components: map[string]string{}}, nil
+}
+
+// Set sets the level of the component, or the default level if the component is empty. An empty level
+// removes the override of the component.
+func (l *Levels) Set(component, lvl string) error {
+	if lvl == "" && component != "" {
+		l.mtx.Lock()
+		delete(l.components, component)
+		l.mtx.Unlock()
+		return nil
+	}
+	if _, ok := levelOrder[lvl]; !ok {
+		return errors.Errorf("unexpected log level %q", lvl)
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if component == "" {
+		l.def = lvl
+		return nil
+	}
+	l.components[component] = lvl
+	return nil
+}
+
+// ParseComponentLevels sets the levels of the components from component=level pairs.
+func (l *Levels) ParseComponentLevels(pairs []string) error {
+	for _, p := range pairs {
+		component, lvl, ok := strings.Cut(p, "=")
+		if !ok || component == "" {
+			return errors.Errorf("invalid component log level %q, expected component=level", p)
+		}
+		if err := l.Set(component, lvl); err != nil {
+			return errors.Wrapf(err, "component %s", component)
+		}
+	}
+	return nil
+}
+
+func (l *Levels) allows(component, lvl string) bool {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+	min, ok := l.components[component]
+	if !ok {
+		min = l.def
+	}
+	return levelOrder[lvl] >= levelOrder[min]
+}
+
+type levelsResponse struct {
+	Default    string            `json:"default"`
+	Components map[string]string `json:"components"`
+}
+
+func (l *Levels) response() levelsResponse {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+	resp := levelsResponse{Default: l.def, Components: make(map[string]string, len(l.components))}
+	for c, lvl := range l.components {
+		resp.Components[c] = lvl
+	}
+	return resp
+}
+
+// ServeHTTP returns the levels as JSON. PUT and POST requests set the level of the component query parameter,
+// or the default level without it, to the level query parameter.
+func (l *Levels) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		if err := l.Set(r.FormValue("component"), r.FormValue("level")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(l.response())
+}
+
+// levelFilter drops the log lines below the level of their component. Lines without a level are kept.
+type levelFilter struct {
+	next   log.Logger
+	levels *Levels
+}
+
+func (f levelFilter) Log(keyvals ...interface{}) error {
+	var lvl, component string
+	for i := 1; i < len(keyvals); i += 2 {
+		switch keyvals[i-1] {
+		case level.Key():
+			if v, ok := keyvals[i].(level.Value); ok {
+				lvl = v.String()
+			}
+		case ComponentKey:
+			// The component added last, the most specific one, wins.
+			component = fmt.Sprint(keyvals[i])
+		}
+	}
+	if lvl != "" && !f.levels.allows(component, lvl) {
+		return nil
+	}
+	return f.next.Log(keyvals...)
+}
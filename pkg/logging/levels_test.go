@@ -0,0 +1,82 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package logging
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+func TestLevels(t *testing.T) {
+	t.Parallel()
+
+	levels, err := NewLevels("info")
+	testutil.Ok(t, err)
+	testutil.Ok(t, levels.ParseComponentLevels([]string{"compactor=debug", "tsdb=error"}))
+	testutil.NotOk(t, levels.ParseComponentLevels([]string{"compactor"}))
+	testutil.NotOk(t, levels.ParseComponentLevels([]string{"compactor=trace"}))
+
+	buf := &bytes.Buffer{}
+	logger := levelFilter{next: log.NewLogfmtLogger(buf), levels: levels}
+	compactor := log.With(logger, ComponentKey, "compactor")
+
+	level.Debug(logger).Log("msg", "a")
+	level.Info(logger).Log("msg", "b")
+	level.Debug(compactor).Log("msg", "c")
+	level.Warn(log.With(logger, ComponentKey, "tsdb")).Log("msg", "d")
+	level.Debug(log.With(compactor, ComponentKey, "tsdb")).Log("msg", "e")
+	logger.Log("msg", "f")
+	testutil.Equals(t, []string{"level=info msg=b", "level=debug component=compactor msg=c", "msg=f"}, strings.Split(strings.TrimSpace(buf.String()), "\n"))
+
+	// Change the levels at runtime.
+	for _, u := range []string{"/-/log-level?level=debug", "/-/log-level?component=compactor&level="} {
+		rec := httptest.NewRecorder()
+		levels.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, u, nil))
+		testutil.Equals(t, http.StatusOK, rec.Code)
+	}
+	rec := httptest.NewRecorder()
+	levels.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/-/log-level?level=trace", nil))
+	testutil.Equals(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	levels.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/log-level", nil))
+	testutil.Equals(t, `{"default":"debug","components":{"tsdb":"error"}}`, strings.TrimSpace(rec.Body.String()))
+
+	buf.Reset()
+	level.Debug(logger).Log("msg", "a")
+	testutil.Equals(t, "level=debug msg=a\n", buf.String())
+}
+
+func TestSampledLogger(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	now := time.Unix(0, 0)
+	logger := NewSampledLogger(log.NewLogfmtLogger(buf), time.Minute, 2)
+	logger.(*sampledLogger).now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		level.Debug(logger).Log("msg", "downloaded block", "i", i)
+	}
+	level.Debug(logger).Log("msg", "verified block")
+	level.Warn(logger).Log("msg", "downloaded block", "i", 4)
+	now = now.Add(time.Minute)
+	level.Debug(logger).Log("msg", "downloaded block", "i", 5)
+
+	testutil.Equals(t, []string{
+		`level=debug msg="downloaded block" i=0`,
+		`level=debug msg="downloaded block" i=1`,
+		`level=debug msg="verified block"`,
+		`level=warn msg="downloaded block" i=4`,
+		`level=debug msg="downloaded block" i=5 sampled_dropped=2`,
+	}, strings.Split(strings.TrimSpace(buf.String()), "\n"))
+}
@@ -4,10 +4,12 @@
 package logging
 
 import (
+	"context"
 	"os"
 
 	"github.com/go-kit/log"
-	"github.com/go-kit/log/level"
+
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 )
 
 const (
@@ -15,9 +17,18 @@ const (
 	LogFormatJSON   = "json"
 )
 
+const (
+	// RequestIDKey is the key of the log field of request IDs, which are passed from Query Frontend and
+	// Querier to the StoreAPIs they query.
+	RequestIDKey = "request_id"
+	// JobIDKey is the key of the log field of the IDs of background jobs, like compactions.
+	JobIDKey = "job_id"
+)
+
 type LevelLogger struct {
 	log.Logger
 	LogLevel string
+	Levels   *Levels
 }
 
 // NewLogger returns a log.Logger that prints in the provided format at the
@@ -26,25 +37,19 @@ type LevelLogger struct {
 // if the log level is not error, warn, info or debug. Log level is expected to
 // be validated before passed to this function.
 func NewLogger(logLevel, logFormat, debugName string) log.Logger {
-	var (
-		logger log.Logger
-		lvl    level.Option
-	)
-
-	switch logLevel {
-	case "error":
-		lvl = level.AllowError()
-	case "warn":
-		lvl = level.AllowWarn()
-	case "info":
-		lvl = level.AllowInfo()
-	case "debug":
-		lvl = level.AllowDebug()
-	default:
+	levels, err := NewLevels(logLevel)
+	if err != nil {
 		// This enum is already checked and enforced by flag validations, so
 		// this should never happen.
 		panic("unexpected log level")
 	}
+	return NewLoggerWithLevels(levels, logFormat, debugName)
+}
+
+// NewLoggerWithLevels is like NewLogger, filtering log lines by the levels of their component, which can be
+// changed while the logger is used.
+func NewLoggerWithLevels(levels *Levels, logFormat, debugName string) log.Logger {
+	var logger log.Logger
 
 	logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
 	if logFormat == LogFormatJSON {
@@ -54,7 +59,7 @@ func NewLogger(logLevel, logFormat, debugName string) log.Logger {
 	// Sort the logger chain to avoid expensive log.Valuer evaluation for disallowed level.
 	// Ref: https://github.com/go-kit/log/issues/14#issuecomment-945038252
 	logger = log.With(logger, "ts", log.DefaultTimestampUTC, "caller", log.Caller(5))
-	logger = level.NewFilter(logger, lvl)
+	logger = levelFilter{next: logger, levels: levels}
 
 	if debugName != "" {
 		logger = log.With(logger, "name", debugName)
@@ -62,6 +67,15 @@ func NewLogger(logLevel, logFormat, debugName string) log.Logger {
 
 	return LevelLogger{
 		Logger:   logger,
-		LogLevel: logLevel,
+		LogLevel: levels.response().Default,
+		Levels:   levels,
+	}
+}
+
+// WithRequestID returns the logger with the request ID of the context, if any.
+func WithRequestID(ctx context.Context, logger log.Logger) log.Logger {
+	if reqID, ok := middleware.RequestIDFromContext(ctx); ok {
+		return log.With(logger, RequestIDKey, reqID)
 	}
+	return logger
 }
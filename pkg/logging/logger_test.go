@@ -4,9 +4,15 @@
 package logging
 
 import (
+	"bytes"
+	"context"
 	"testing"
 
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 )
 
 func BenchmarkDisallowedLogLevels(b *testing.B) {
@@ -17,3 +23,14 @@ func BenchmarkDisallowedLogLevels(b *testing.B) {
 		level.Debug(logger).Log("hello", "world", "number", i)
 	}
 }
+
+func TestWithRequestID(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	logger := log.NewLogfmtLogger(buf)
+
+	WithRequestID(context.Background(), logger).Log("msg", "a")
+	WithRequestID(middleware.NewContextWithRequestID(context.Background(), "01ABC"), logger).Log("msg", "b")
+	testutil.Equals(t, "msg=a\nrequest_id=01ABC msg=b\n", buf.String())
+}
@@ -6,11 +6,8 @@ package logging
 import (
 	"context"
 	"fmt"
-	"math/rand"
 	"time"
 
-	"github.com/oklog/ulid/v2"
-
 	"github.com/opentracing/opentracing-go"
 	"go.opentelemetry.io/otel/trace"
 
@@ -175,20 +172,10 @@ func GetTraceIDAndRequestIDAsField(ctx context.Context) grpc_logging.Fields {
 	if span := trace.SpanContextFromContext(ctx); span.IsSampled() {
 		logFields = logFields.AppendUnique(grpc_logging.Fields{"traceID", TraceID})
 	}
-	//get requestID from context
-	reqID, ok := middleware.RequestIDFromContext(ctx)
-	if ok {
-		logFields = logFields.AppendUnique(grpc_logging.Fields{"requestID", reqID})
-		return logFields
+	// The request ID interceptor of the server sets the request ID of all requests.
+	if reqID, ok := middleware.RequestIDFromContext(ctx); ok {
+		logFields = logFields.AppendUnique(grpc_logging.Fields{RequestIDKey, reqID})
 	}
-
-	entropy := ulid.Monotonic(rand.New(rand.NewSource(time.Now().UnixNano())), 0)
-	newReqID := ulid.MustNew(ulid.Timestamp(time.Now()), entropy).String()
-	logFields = logFields.AppendUnique(grpc_logging.Fields{"requestID", newReqID})
-
-	// Insert into context (Note: This updated context isn't being used later, so this might be redundant)
-	_ = middleware.NewContextWithRequestID(ctx, newReqID)
-
 	return logFields
 
 }
@@ -0,0 +1,87 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package logging
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// SampledDroppedKey is the key of the field counting the lines with the same message dropped by a sampled
+// logger since the previous line.
+const SampledDroppedKey = "sampled_dropped"
+
+type sampleWindow struct {
+	start   time.Time
+	logged  int
+	dropped int
+}
+
+// sampledLogger logs at most burst lines with the same message per interval. Warning and error lines are
+// never dropped.
+type sampledLogger struct {
+	next     log.Logger
+	interval time.Duration
+	burst    int
+	now      func() time.Time
+
+	mtx     sync.Mutex
+	windows map[string]*sampleWindow
+}
+
+// NewSampledLogger returns a logger for chatty paths, e.g. logging once per block, which logs at most burst
+// debug and info lines with the same message per interval. The first line logged after lines were dropped
+// carries their number in the sampled_dropped field.
+func NewSampledLogger(logger log.Logger, interval time.Duration, burst int) log.Logger {
+	return &sampledLogger{
+		next:     logger,
+		interval: interval,
+		burst:    burst,
+		now:      time.Now,
+		windows:  map[string]*sampleWindow{},
+	}
+}
+
+func (s *sampledLogger) Log(keyvals ...interface{}) error {
+	var msg string
+	for i := 1; i < len(keyvals); i += 2 {
+		switch keyvals[i-1] {
+		case level.Key():
+			if v, ok := keyvals[i].(level.Value); ok && (v == level.WarnValue() || v == level.ErrorValue()) {
+				return s.next.Log(keyvals...)
+			}
+		case "msg":
+			msg = fmt.Sprint(keyvals[i])
+		}
+	}
+
+	s.mtx.Lock()
+	w, ok := s.windows[msg]
+	if !ok {
+		w = &sampleWindow{}
+		s.windows[msg] = w
+	}
+	now := s.now()
+	if now.Sub(w.start) >= s.interval {
+		w.start, w.logged = now, 0
+	}
+	if w.logged >= s.burst {
+		w.dropped++
+		s.mtx.Unlock()
+		return nil
+	}
+	w.logged++
+	dropped := w.dropped
+	w.dropped = 0
+	s.mtx.Unlock()
+
+	if dropped > 0 {
+		keyvals = append(keyvals[:len(keyvals):len(keyvals)], SampledDroppedKey, dropped)
+	}
+	return s.next.Log(keyvals...)
+}
@@ -0,0 +1,240 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package metering aggregates the usage of tenants, samples ingested by Receive, bytes stored in blocks and
+// bytes and series queried, exports it as metrics and optionally writes it as periodic usage records to the
+// bucket, e.g. for billing.
+package metering
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+// RecordsDir is the directory of the bucket the usage records are written to, in a subdirectory per component.
+const RecordsDir = "usage"
+
+// Usage is the usage of a tenant.
+type Usage struct {
+	IngestedSamples int64 `json:"ingested_samples,omitempty"`
+	// StoredBytes is the size of the blocks of the tenant in the bucket at the end of the period.
+	StoredBytes   int64 `json:"stored_bytes,omitempty"`
+	QueriedBytes  int64 `json:"queried_bytes,omitempty"`
+	QueriedSeries int64 `json:"queried_series,omitempty"`
+}
+
+// Record is the usage of the tenants metered by an instance of a component over a period.
+type Record struct {
+	Component string           `json:"component"`
+	Instance  string           `json:"instance"`
+	Start     time.Time        `json:"start"`
+	End       time.Time        `json:"end"`
+	Tenants   map[string]Usage `json:"tenants"`
+}
+
+// Meter aggregates the usage of tenants. A nil Meter meters nothing.
+type Meter struct {
+	component string
+	instance  string
+
+	ingestedSamples *prometheus.CounterVec
+	storedBytes     *prometheus.GaugeVec
+	queriedBytes    *prometheus.CounterVec
+	queriedSeries   *prometheus.CounterVec
+
+	mtx    sync.Mutex
+	start  time.Time
+	usage  map[string]*Usage
+	stored map[string]int64
+}
+
+// NewMeter returns a meter of the usage of tenants by the instance of the component.
+func NewMeter(reg prometheus.Registerer, component, instance string) *Meter {
+	return &Meter{
+		component: component,
+		instance:  instance,
+		ingestedSamples: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_metering_ingested_samples_total",
+			Help: "Total number of samples ingested by tenant.",
+		}, []string{tenancy.MetricLabel}),
+		storedBytes: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_metering_stored_bytes",
+			Help: "Size of the blocks in the bucket by tenant.",
+		}, []string{tenancy.MetricLabel}),
+		queriedBytes: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_metering_queried_bytes_total",
+			Help: "Total number of bytes queried by tenant.",
+		}, []string{tenancy.MetricLabel}),
+		queriedSeries: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_metering_queried_series_total",
+			Help: "Total number of series queried by tenant.",
+		}, []string{tenancy.MetricLabel}),
+		start:  time.Now(),
+		usage:  map[string]*Usage{},
+		stored: map[string]int64{},
+	}
+}
+
+func (m *Meter) tenantUsage(tenant string) *Usage {
+	u, ok := m.usage[tenant]
+	if !ok {
+		u = &Usage{}
+		m.usage[tenant] = u
+	}
+	return u
+}
+
+// AddIngestedSamples meters samples ingested for the tenant.
+func (m *Meter) AddIngestedSamples(tenant string, samples int) {
+	if m == nil || samples <= 0 {
+		return
+	}
+	m.ingestedSamples.WithLabelValues(tenant).Add(float64(samples))
+
+	m.mtx.Lock()
+	m.tenantUsage(tenant).IngestedSamples += int64(samples)
+	m.mtx.Unlock()
+}
+
+// AddQueried meters bytes and series queried by the tenant.
+func (m *Meter) AddQueried(tenant string, bytes, series int) {
+	if m == nil || (bytes <= 0 && series <= 0) {
+		return
+	}
+	m.queriedBytes.WithLabelValues(tenant).Add(float64(bytes))
+	m.queriedSeries.WithLabelValues(tenant).Add(float64(series))
+
+	m.mtx.Lock()
+	u := m.tenantUsage(tenant)
+	u.QueriedBytes += int64(bytes)
+	u.QueriedSeries += int64(series)
+	m.mtx.Unlock()
+}
+
+// SetStoredBytes sets the bytes stored by tenant, replacing the previous ones.
+func (m *Meter) SetStoredBytes(stored map[string]int64) {
+	if m == nil {
+		return
+	}
+	m.storedBytes.Reset()
+	for tenant, b := range stored {
+		m.storedBytes.WithLabelValues(tenant).Set(float64(b))
+	}
+
+	m.mtx.Lock()
+	m.stored = stored
+	m.mtx.Unlock()
+}
+
+// StoredBytes returns the size of the blocks by the tenant of their tenantLabel external label. Blocks without
+// the label are accounted to the default tenant. Blocks whose meta does not list the sizes of their files, which
+// Thanos versions before v0.22 did not, are not accounted.
+func StoredBytes(metas map[ulid.ULID]*metadata.Meta, tenantLabel string) map[string]int64 {
+	stored := map[string]int64{}
+	for _, m := range metas {
+		tenant, ok := m.Thanos.Labels[tenantLabel]
+		if !ok {
+			tenant = tenancy.DefaultTenant
+		}
+		for _, f := range m.Thanos.Files {
+			stored[tenant] += f.SizeBytes
+		}
+	}
+	return stored
+}
+
+// Flush returns the usage since the previous flush, and starts a new period. The stored bytes of the record
+// are the current ones.
+func (m *Meter) Flush() Record {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	now := time.Now()
+	r := Record{Component: m.component, Instance: m.instance, Start: m.start, End: now, Tenants: map[string]Usage{}}
+	for tenant, u := range m.usage {
+		r.Tenants[tenant] = *u
+	}
+	for tenant, b := range m.stored {
+		u := r.Tenants[tenant]
+		u.StoredBytes = b
+		r.Tenants[tenant] = u
+	}
+	m.start = now
+	m.usage = map[string]*Usage{}
+	return r
+}
+
+// WriteRecords writes the usage at every interval as a record to the usage/<component>/<ULID>.json object of
+// the bucket, until the context is canceled. The usage since the last record is written on cancellation.
+// Periods without any usage, stored bytes included, are not written.
+func (m *Meter) WriteRecords(ctx context.Context, logger log.Logger, bkt objstore.Bucket, interval time.Duration) error {
+	err := runutil.Repeat(interval, ctx.Done(), func() error {
+		if err := m.write(ctx, bkt); err != nil {
+			level.Warn(logger).Log("msg", "failed to write usage record", "err", err)
+		}
+		return nil
+	})
+	// Flush the usage of the last period, with a context of its own as ours is canceled.
+	writeCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if werr := m.write(writeCtx, bkt); werr != nil {
+		level.Warn(logger).Log("msg", "failed to write usage record", "err", werr)
+	}
+	return err
+}
+
+// write writes the usage since the previous record. The usage of records failing to be written is merged back into
+// the next period, so that it is written with the next record instead of being lost.
+func (m *Meter) write(ctx context.Context, bkt objstore.Bucket) error {
+	r := m.Flush()
+	if len(r.Tenants) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		m.restore(r)
+		return errors.Wrap(err, "marshal usage record")
+	}
+	id := ulid.MustNew(ulid.Timestamp(r.End), rand.Reader)
+	name := path.Join(RecordsDir, m.component, id.String()+".json")
+	if err := bkt.Upload(ctx, name, bytes.NewReader(b)); err != nil {
+		m.restore(r)
+		return errors.Wrapf(err, "upload usage record %s", name)
+	}
+	return nil
+}
+
+// restore merges the usage of the flushed record back into the current period, which then starts with the period
+// of the record. The stored bytes are not merged, as the current ones replace them.
+func (m *Meter) restore(r Record) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.start = r.Start
+	for tenant, u := range r.Tenants {
+		if u.IngestedSamples == 0 && u.QueriedBytes == 0 && u.QueriedSeries == 0 {
+			continue
+		}
+		cur := m.tenantUsage(tenant)
+		cur.IngestedSamples += u.IngestedSamples
+		cur.QueriedBytes += u.QueriedBytes
+		cur.QueriedSeries += u.QueriedSeries
+	}
+}
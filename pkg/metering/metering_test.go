@@ -0,0 +1,109 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package metering
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/faultbucket"
+)
+
+func TestMeter(t *testing.T) {
+	t.Parallel()
+
+	reg := prometheus.NewRegistry()
+	m := NewMeter(reg, "receive", "receive-0")
+
+	m.AddIngestedSamples("a", 10)
+	m.AddIngestedSamples("a", 5)
+	m.AddQueried("b", 100, 2)
+	m.SetStoredBytes(StoredBytes(map[ulid.ULID]*metadata.Meta{
+		ulid.MustNew(1, nil): {Thanos: metadata.Thanos{Labels: map[string]string{"tenant_id": "a"}, Files: []metadata.File{{RelPath: "index", SizeBytes: 30}, {RelPath: "meta.json"}}}},
+		ulid.MustNew(2, nil): {Thanos: metadata.Thanos{Labels: map[string]string{"tenant_id": "a"}, Files: []metadata.File{{RelPath: "index", SizeBytes: 12}}}},
+		ulid.MustNew(3, nil): {Thanos: metadata.Thanos{Files: []metadata.File{{RelPath: "index", SizeBytes: 7}}}},
+	}, "tenant_id"))
+
+	testutil.Equals(t, 15.0, promtest.ToFloat64(m.ingestedSamples.WithLabelValues("a")))
+	testutil.Equals(t, 42.0, promtest.ToFloat64(m.storedBytes.WithLabelValues("a")))
+	testutil.Equals(t, 7.0, promtest.ToFloat64(m.storedBytes.WithLabelValues("default-tenant")))
+
+	bkt := objstore.NewInMemBucket()
+	testutil.Ok(t, m.write(context.Background(), bkt))
+
+	var names []string
+	testutil.Ok(t, bkt.Iter(context.Background(), "usage/receive/", func(name string) error {
+		names = append(names, name)
+		return nil
+	}))
+	testutil.Equals(t, 1, len(names))
+	testutil.Assert(t, strings.HasSuffix(names[0], ".json"), names[0])
+
+	rc, err := bkt.Get(context.Background(), names[0])
+	testutil.Ok(t, err)
+	b, err := io.ReadAll(rc)
+	testutil.Ok(t, err)
+	var r Record
+	testutil.Ok(t, json.Unmarshal(b, &r))
+	testutil.Equals(t, "receive-0", r.Instance)
+	testutil.Equals(t, map[string]Usage{
+		"a":              {IngestedSamples: 15, StoredBytes: 42},
+		"b":              {QueriedBytes: 100, QueriedSeries: 2},
+		"default-tenant": {StoredBytes: 7},
+	}, r.Tenants)
+
+	// The next period starts without the usage of the previous one, but the stored bytes are kept.
+	r = m.Flush()
+	testutil.Equals(t, map[string]Usage{"a": {StoredBytes: 42}, "default-tenant": {StoredBytes: 7}}, r.Tenants)
+
+	var nilMeter *Meter
+	nilMeter.AddIngestedSamples("a", 1)
+	nilMeter.AddQueried("a", 1, 1)
+	nilMeter.SetStoredBytes(nil)
+}
+
+func TestMeter_WriteFailure(t *testing.T) {
+	t.Parallel()
+
+	m := NewMeter(prometheus.NewRegistry(), "receive", "receive-0")
+	bkt := faultbucket.NewBucket(objstore.NewInMemBucket())
+	bkt.Inject(faultbucket.FailOps(faultbucket.MatchPrefix(RecordsDir), objstore.OpUpload))
+
+	m.AddIngestedSamples("a", 10)
+	m.AddQueried("b", 100, 2)
+	start := m.start
+	testutil.NotOk(t, m.write(context.Background(), bkt))
+
+	// The usage of the failed record is written with the next one, over both periods.
+	bkt.Reset()
+	m.AddIngestedSamples("a", 5)
+	testutil.Ok(t, m.write(context.Background(), bkt))
+
+	var records []Record
+	testutil.Ok(t, bkt.Iter(context.Background(), "usage/receive/", func(name string) error {
+		rc, err := bkt.Get(context.Background(), name)
+		testutil.Ok(t, err)
+		defer func() { testutil.Ok(t, rc.Close()) }()
+		var r Record
+		testutil.Ok(t, json.NewDecoder(rc).Decode(&r))
+		records = append(records, r)
+		return nil
+	}))
+	testutil.Equals(t, 1, len(records))
+	testutil.Assert(t, records[0].Start.Equal(start), "record starts at %v, not at %v", records[0].Start, start)
+	testutil.Equals(t, map[string]Usage{
+		"a": {IngestedSamples: 15},
+		"b": {QueriedBytes: 100, QueriedSeries: 2},
+	}, records[0].Tenants)
+}
@@ -0,0 +1,167 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package objstoreutil contains helpers around object storage buckets.
+package objstoreutil
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+)
+
+// Callers of bucket operations, used to attribute requests and bytes in the accounting metrics.
+const (
+	CallerSyncer       = "syncer"
+	CallerDownloader   = "downloader"
+	CallerUploader     = "uploader"
+	CallerDeleter      = "deleter"
+	CallerStoreGateway = "store_gateway"
+	CallerIndexHeader  = "index_header"
+	// CallerOther is the caller of operations with a context not tagged with WithCaller.
+	CallerOther = "other"
+)
+
+type callerKey struct{}
+
+// WithCaller returns a context tagging the bucket operations made with it with the given caller.
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFromContext returns the caller the context was tagged with, CallerOther if none.
+func CallerFromContext(ctx context.Context) string {
+	if c, ok := ctx.Value(callerKey{}).(string); ok {
+		return c
+	}
+	return CallerOther
+}
+
+type accountingMetrics struct {
+	requests *prometheus.CounterVec
+	bytes    *prometheus.CounterVec
+}
+
+// accountingBucket counts requests and transferred bytes by caller and operation, so that users of object
+// storages billing by request can attribute their costs.
+type accountingBucket struct {
+	objstore.Bucket
+
+	metrics accountingMetrics
+}
+
+// WrapWithAccounting returns a bucket counting requests and bytes transferred by each operation, by the
+// caller of the operation tagged in the context with WithCaller.
+func WrapWithAccounting(bkt objstore.Bucket, reg prometheus.Registerer) objstore.Bucket {
+	return &accountingBucket{
+		Bucket: bkt,
+		metrics: accountingMetrics{
+			requests: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+				Name: "thanos_objstore_caller_requests_total",
+				Help: "Total number of requests made to the object storage, by caller and operation. Iterations count as one request, regardless of the number of pages listed.",
+			}, []string{"caller", "operation"}),
+			bytes: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+				Name: "thanos_objstore_caller_transferred_bytes_total",
+				Help: "Total number of bytes downloaded from and uploaded to the object storage, by caller and operation.",
+			}, []string{"caller", "operation"}),
+		},
+	}
+}
+
+func (b *accountingBucket) request(ctx context.Context, op string) string {
+	caller := CallerFromContext(ctx)
+	b.metrics.requests.WithLabelValues(caller, op).Inc()
+	return caller
+}
+
+func (b *accountingBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	b.request(ctx, objstore.OpIter)
+	return b.Bucket.Iter(ctx, dir, f, options...)
+}
+
+func (b *accountingBucket) IterWithAttributes(ctx context.Context, dir string, f func(objstore.IterObjectAttributes) error, options ...objstore.IterOption) error {
+	b.request(ctx, objstore.OpIter)
+	return b.Bucket.IterWithAttributes(ctx, dir, f, options...)
+}
+
+func (b *accountingBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	caller := b.request(ctx, objstore.OpGet)
+	rc, err := b.Bucket.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return b.countingReadCloser(rc, caller, objstore.OpGet), nil
+}
+
+func (b *accountingBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	caller := b.request(ctx, objstore.OpGetRange)
+	rc, err := b.Bucket.GetRange(ctx, name, off, length)
+	if err != nil {
+		return nil, err
+	}
+	return b.countingReadCloser(rc, caller, objstore.OpGetRange), nil
+}
+
+func (b *accountingBucket) Exists(ctx context.Context, name string) (bool, error) {
+	b.request(ctx, objstore.OpExists)
+	return b.Bucket.Exists(ctx, name)
+}
+
+func (b *accountingBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	b.request(ctx, objstore.OpAttributes)
+	return b.Bucket.Attributes(ctx, name)
+}
+
+func (b *accountingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	caller := b.request(ctx, objstore.OpUpload)
+	cr := &countingReader{Reader: r}
+	defer func() { b.metrics.bytes.WithLabelValues(caller, objstore.OpUpload).Add(float64(cr.n.Load())) }()
+	return b.Bucket.Upload(ctx, name, cr)
+}
+
+func (b *accountingBucket) Delete(ctx context.Context, name string) error {
+	b.request(ctx, objstore.OpDelete)
+	return b.Bucket.Delete(ctx, name)
+}
+
+func (b *accountingBucket) countingReadCloser(rc io.ReadCloser, caller, op string) io.ReadCloser {
+	return &countingReadCloser{
+		countingReader: countingReader{Reader: rc},
+		closer:         rc,
+		done:           b.metrics.bytes.WithLabelValues(caller, op),
+	}
+}
+
+// countingReader counts the bytes read, and keeps the size of the wrapped reader available, e.g. to the objstore
+// providers using it to choose how to upload.
+type countingReader struct {
+	io.Reader
+
+	n atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+func (r *countingReader) ObjectSize() (int64, error) {
+	return objstore.TryToGetSize(r.Reader)
+}
+
+type countingReadCloser struct {
+	countingReader
+
+	closer io.Closer
+	done   prometheus.Counter
+}
+
+func (r *countingReadCloser) Close() error {
+	r.done.Add(float64(r.n.Swap(0)))
+	return r.closer.Close()
+}
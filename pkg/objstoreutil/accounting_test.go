@@ -0,0 +1,52 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package objstoreutil
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/thanos-io/objstore"
+)
+
+func TestWrapWithAccounting(t *testing.T) {
+	t.Parallel()
+
+	reg := prometheus.NewRegistry()
+	bkt := WrapWithAccounting(objstore.NewInMemBucket(), reg)
+	ctx := context.Background()
+	uploader, syncer := WithCaller(ctx, CallerUploader), WithCaller(ctx, CallerSyncer)
+
+	testutil.Ok(t, bkt.Upload(uploader, "dir/obj", strings.NewReader("0123456789")))
+	testutil.Ok(t, bkt.Iter(syncer, "", func(string) error { return nil }))
+
+	rc, err := bkt.Get(syncer, "dir/obj")
+	testutil.Ok(t, err)
+	size, err := objstore.TryToGetSize(rc)
+	testutil.Ok(t, err)
+	testutil.Equals(t, int64(10), size)
+	_, err = io.ReadAll(rc)
+	testutil.Ok(t, err)
+	testutil.Ok(t, rc.Close())
+
+	rc, err = bkt.GetRange(ctx, "dir/obj", 2, 3)
+	testutil.Ok(t, err)
+	_, err = io.ReadAll(rc)
+	testutil.Ok(t, err)
+	testutil.Ok(t, rc.Close())
+
+	m := bkt.(*accountingBucket).metrics
+	testutil.Equals(t, 1.0, promtest.ToFloat64(m.requests.WithLabelValues(CallerUploader, objstore.OpUpload)))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(m.requests.WithLabelValues(CallerSyncer, objstore.OpIter)))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(m.requests.WithLabelValues(CallerSyncer, objstore.OpGet)))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(m.requests.WithLabelValues(CallerOther, objstore.OpGetRange)))
+	testutil.Equals(t, 10.0, promtest.ToFloat64(m.bytes.WithLabelValues(CallerUploader, objstore.OpUpload)))
+	testutil.Equals(t, 10.0, promtest.ToFloat64(m.bytes.WithLabelValues(CallerSyncer, objstore.OpGet)))
+	testutil.Equals(t, 3.0, promtest.ToFloat64(m.bytes.WithLabelValues(CallerOther, objstore.OpGetRange)))
+}
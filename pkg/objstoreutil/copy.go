@@ -0,0 +1,112 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package objstoreutil
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/client"
+	"gopkg.in/yaml.v2"
+)
+
+// ErrServerSideCopyUnsupported is returned by server side copiers which cannot copy objects from the other copier,
+// e.g. of another provider or storage account.
+var ErrServerSideCopyUnsupported = errors.New("server side copy is not supported between the buckets")
+
+// ServerSideCopier copies objects to a bucket on the server side, without transferring them through the process.
+type ServerSideCopier interface {
+	// Copy copies the object src of the bucket of from to the object dst of the bucket of the copier. It returns
+	// ErrServerSideCopyUnsupported if the copier cannot copy objects from the other copier.
+	Copy(ctx context.Context, from ServerSideCopier, src, dst string) error
+	// Close closes the clients of the copier.
+	Close() error
+}
+
+// NewServerSideCopier returns the server side copier of the bucket of the given object storage configuration, and
+// nil if its provider does not support server side copies. S3, GCS and Azure buckets are supported.
+func NewServerSideCopier(ctx context.Context, logger log.Logger, confContentYaml []byte, component string) (ServerSideCopier, error) {
+	bucketConf := &client.BucketConfig{}
+	if err := yaml.UnmarshalStrict(confContentYaml, bucketConf); err != nil {
+		return nil, errors.Wrap(err, "parsing config YAML file")
+	}
+	config, err := yaml.Marshal(bucketConf.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal content of bucket configuration")
+	}
+
+	var c ServerSideCopier
+	switch client.ObjProvider(strings.ToUpper(string(bucketConf.Type))) {
+	case client.S3:
+		c, err = newS3Copier(logger, config, component)
+	case client.GCS:
+		c, err = newGCSCopier(ctx, logger, config, component)
+	case client.AZURE:
+		c, err = newAzureCopier(config)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "create %s server side copier", bucketConf.Type)
+	}
+	return NewPrefixedServerSideCopier(c, bucketConf.Prefix), nil
+}
+
+// NewPrefixedServerSideCopier returns the copier of the objects under the given prefix of the bucket of c, like
+// objstore.NewPrefixedBucket does for buckets.
+func NewPrefixedServerSideCopier(c ServerSideCopier, prefix string) ServerSideCopier {
+	prefix = strings.Trim(prefix, objstore.DirDelim)
+	if c == nil || prefix == "" {
+		return c
+	}
+	return &prefixedCopier{ServerSideCopier: c, prefix: prefix}
+}
+
+type prefixedCopier struct {
+	ServerSideCopier
+	prefix string
+}
+
+func (c *prefixedCopier) Copy(ctx context.Context, from ServerSideCopier, src, dst string) error {
+	for {
+		p, ok := from.(*prefixedCopier)
+		if !ok {
+			break
+		}
+		from, src = p.ServerSideCopier, path.Join(p.prefix, src)
+	}
+	return c.ServerSideCopier.Copy(ctx, from, src, path.Join(c.prefix, dst))
+}
+
+// CopyObject copies the object src of from to the object dst of to. The object is copied on the server side if
+// both buckets have a copier and the copier of to supports it, and streamed through the process otherwise.
+func CopyObject(ctx context.Context, logger log.Logger, from objstore.BucketReader, fromCopier ServerSideCopier, to objstore.Bucket, toCopier ServerSideCopier, src, dst string) error {
+	if fromCopier != nil && toCopier != nil {
+		err := toCopier.Copy(ctx, fromCopier, src, dst)
+		if err == nil {
+			return nil
+		}
+		// The copy can fail for reasons like credentials not allowed to read the source, which do not prevent
+		// streaming the object.
+		if !errors.Is(err, ErrServerSideCopyUnsupported) {
+			level.Warn(logger).Log("msg", "server side copy failed, copying through the process", "src", src, "dst", dst, "err", err)
+		}
+	}
+
+	r, err := from.Get(ctx, src)
+	if err != nil {
+		return errors.Wrapf(err, "get %s", src)
+	}
+	defer r.Close()
+
+	if err := to.Upload(ctx, dst, r); err != nil {
+		return errors.Wrapf(err, "upload %s", dst)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package objstoreutil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore/exthttp"
+	"github.com/thanos-io/objstore/providers/azure"
+	"gopkg.in/yaml.v2"
+)
+
+// azureCopyPollInterval is the interval of the checks of the status of pending copies.
+const azureCopyPollInterval = time.Second
+
+// azureCopier copies blobs with Copy Blob requests, and waits for the copies to complete. Blobs can only be copied
+// within a storage account, as copying from another account requires the source to be public or signed.
+type azureCopier struct {
+	container *container.Client
+	account   string
+}
+
+// newAzureCopier returns the copier of the Azure container of the configuration, with the same credentials as the
+// objstore Azure client.
+func newAzureCopier(conf []byte) (*azureCopier, error) {
+	config := azure.DefaultConfig
+	if err := yaml.UnmarshalStrict(conf, &config); err != nil {
+		return nil, errors.Wrap(err, "parsing azure config YAML")
+	}
+	if config.MaxRetries > 0 && config.PipelineConfig.MaxTries == 0 {
+		config.PipelineConfig.MaxTries = int32(config.MaxRetries)
+	}
+	if config.StorageAccountName == "" || config.ContainerName == "" {
+		return nil, errors.New("no azure storage account or container specified")
+	}
+
+	tpt, err := exthttp.DefaultTransport(config.HTTPConfig)
+	if err != nil {
+		return nil, err
+	}
+	opt := &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{
+				MaxRetries:    config.PipelineConfig.MaxTries,
+				TryTimeout:    time.Duration(config.PipelineConfig.TryTimeout),
+				RetryDelay:    time.Duration(config.PipelineConfig.RetryDelay),
+				MaxRetryDelay: time.Duration(config.PipelineConfig.MaxRetryDelay),
+			},
+			Telemetry: policy.TelemetryOptions{ApplicationID: "Thanos"},
+			Transport: &http.Client{Transport: tpt},
+		},
+	}
+
+	var c *container.Client
+	containerURL := fmt.Sprintf("https://%s.%s/%s", config.StorageAccountName, config.Endpoint, config.ContainerName)
+	switch {
+	case config.StorageConnectionString != "":
+		c, err = container.NewClientFromConnectionString(config.StorageConnectionString, config.ContainerName, opt)
+	case config.StorageAccountKey != "":
+		var cred *container.SharedKeyCredential
+		if cred, err = container.NewSharedKeyCredential(config.StorageAccountName, config.StorageAccountKey); err == nil {
+			c, err = container.NewClientWithSharedKeyCredential(containerURL, cred, opt)
+		}
+	default:
+		var cred azcore.TokenCredential
+		if config.UserAssignedID != "" {
+			msiOpt := &azidentity.ManagedIdentityCredentialOptions{}
+			msiOpt.ID = azidentity.ClientID(config.UserAssignedID)
+			cred, err = azidentity.NewManagedIdentityCredential(msiOpt)
+		} else {
+			cred, err = azidentity.NewDefaultAzureCredential(nil)
+		}
+		if err == nil {
+			c, err = container.NewClient(containerURL, cred, opt)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(c.URL())
+	if err != nil {
+		return nil, errors.Wrap(err, "parse container URL")
+	}
+	return &azureCopier{container: c, account: u.Host}, nil
+}
+
+func (c *azureCopier) Copy(ctx context.Context, from ServerSideCopier, src, dst string) error {
+	f, ok := from.(*azureCopier)
+	if !ok || f.account != c.account {
+		return ErrServerSideCopyUnsupported
+	}
+
+	dstBlob := c.container.NewBlobClient(dst)
+	resp, err := dstBlob.StartCopyFromURL(ctx, f.container.NewBlobClient(src).URL(), nil)
+	if err != nil {
+		return errors.Wrapf(err, "start copy of azure blob %s to %s", src, dst)
+	}
+	status, description := resp.CopyStatus, (*string)(nil)
+	for status != nil && *status == blob.CopyStatusTypePending {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(azureCopyPollInterval):
+		}
+		props, err := dstBlob.GetProperties(ctx, nil)
+		if err != nil {
+			return errors.Wrapf(err, "get copy status of azure blob %s", dst)
+		}
+		status, description = props.CopyStatus, props.CopyStatusDescription
+	}
+	if status != nil && *status != blob.CopyStatusTypeSuccess {
+		msg := ""
+		if description != nil {
+			msg = *description
+		}
+		return errors.Errorf("copy of azure blob %s to %s %s: %s", src, dst, *status, msg)
+	}
+	return nil
+}
+
+func (c *azureCopier) Close() error { return nil }
@@ -0,0 +1,38 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package objstoreutil
+
+import (
+	"context"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore/providers/gcs"
+)
+
+// gcsCopier copies objects with rewrite requests, which GCS splits in as many requests as needed for large objects.
+type gcsCopier struct {
+	bkt *gcs.Bucket
+}
+
+func newGCSCopier(ctx context.Context, logger log.Logger, conf []byte, component string) (*gcsCopier, error) {
+	bkt, err := gcs.NewBucket(ctx, logger, conf, component, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsCopier{bkt: bkt}, nil
+}
+
+func (c *gcsCopier) Copy(ctx context.Context, from ServerSideCopier, src, dst string) error {
+	f, ok := from.(*gcsCopier)
+	if !ok {
+		return ErrServerSideCopyUnsupported
+	}
+	if _, err := c.bkt.Handle().Object(dst).CopierFrom(f.bkt.Handle().Object(src)).Run(ctx); err != nil {
+		return errors.Wrapf(err, "copy gcs object %s/%s to %s/%s", f.bkt.Name(), src, c.bkt.Name(), dst)
+	}
+	return nil
+}
+
+func (c *gcsCopier) Close() error { return c.bkt.Close() }
@@ -0,0 +1,192 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package objstoreutil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/version"
+	"github.com/thanos-io/objstore/exthttp"
+	"github.com/thanos-io/objstore/providers/s3"
+	"gopkg.in/yaml.v2"
+)
+
+// s3MaxCopyObjectSize is the size of the largest objects S3 copies with a single CopyObject request.
+const s3MaxCopyObjectSize = 5 * 1024 * 1024 * 1024
+
+// s3Copier copies objects with CopyObject requests, or UploadPartCopy requests for objects larger than 5GiB.
+type s3Copier struct {
+	client       *minio.Client
+	endpoint     string
+	bucket       string
+	sse          encrypt.ServerSide
+	userMetadata map[string]string
+}
+
+// newS3Copier returns the copier of the S3 bucket of the configuration, with the same credentials, encryption and
+// metadata of uploads as the objstore S3 client.
+func newS3Copier(logger log.Logger, conf []byte, component string) (*s3Copier, error) {
+	config := s3.DefaultConfig
+	if err := yaml.UnmarshalStrict(conf, &config); err != nil {
+		return nil, errors.Wrap(err, "parsing s3 config YAML")
+	}
+	if config.Bucket == "" || config.Endpoint == "" {
+		return nil, errors.New("no s3 bucket or endpoint specified")
+	}
+
+	wrapCredentialsProvider := func(p credentials.Provider) credentials.Provider { return p }
+	if config.SignatureV2 {
+		wrapCredentialsProvider = func(p credentials.Provider) credentials.Provider {
+			return &signatureV2Provider{Provider: p}
+		}
+	}
+	var chain []credentials.Provider
+	switch {
+	case config.AWSSDKAuth:
+		chain = []credentials.Provider{wrapCredentialsProvider(&s3.AWSSDKAuth{Region: config.Region})}
+	case config.AccessKey != "":
+		chain = []credentials.Provider{wrapCredentialsProvider(&credentials.Static{
+			Value: credentials.Value{
+				AccessKeyID:     config.AccessKey,
+				SecretAccessKey: config.SecretKey,
+				SessionToken:    config.SessionToken,
+				SignerType:      credentials.SignatureV4,
+			},
+		})}
+	default:
+		chain = []credentials.Provider{
+			wrapCredentialsProvider(&credentials.EnvAWS{}),
+			wrapCredentialsProvider(&credentials.FileAWSCredentials{}),
+			wrapCredentialsProvider(&credentials.IAM{
+				Client:   &http.Client{Transport: http.DefaultTransport},
+				Endpoint: config.STSEndpoint,
+			}),
+		}
+	}
+
+	tpt, err := exthttp.DefaultTransport(config.HTTPConfig)
+	if err != nil {
+		return nil, err
+	}
+	c, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:        credentials.NewChainCredentials(chain),
+		Secure:       !config.Insecure,
+		Region:       config.Region,
+		Transport:    tpt,
+		BucketLookup: config.BucketLookupType.MinioType(),
+		MaxRetries:   config.MaxRetries,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "initialize s3 client")
+	}
+	c.SetAppInfo(fmt.Sprintf("thanos-%s", component), fmt.Sprintf("%s (%s)", version.Version, runtime.Version()))
+	if config.DisableDualstack {
+		c.SetS3EnableDualstack(false)
+	}
+	if config.TraceConfig.Enable {
+		c.TraceOn(log.NewStdlibAdapter(level.Debug(logger), log.MessageKey("s3TraceMsg")))
+	}
+
+	var sse encrypt.ServerSide
+	switch config.SSEConfig.Type {
+	case "":
+	case s3.SSEKMS:
+		if config.SSEConfig.KMSEncryptionContext == nil {
+			config.SSEConfig.KMSEncryptionContext = map[string]string{}
+		}
+		if sse, err = encrypt.NewSSEKMS(config.SSEConfig.KMSKeyID, config.SSEConfig.KMSEncryptionContext); err != nil {
+			return nil, errors.Wrap(err, "initialize s3 client SSE-KMS")
+		}
+	case s3.SSEC:
+		key, err := os.ReadFile(config.SSEConfig.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		if sse, err = encrypt.NewSSEC(key); err != nil {
+			return nil, errors.Wrap(err, "initialize s3 client SSE-C")
+		}
+	case s3.SSES3:
+		sse = encrypt.NewSSE()
+	default:
+		return nil, errors.Errorf("unsupported SSE type %q, supported types are SSE-S3, SSE-KMS, SSE-C", config.SSEConfig.Type)
+	}
+
+	return &s3Copier{
+		client:       c,
+		endpoint:     config.Endpoint,
+		bucket:       config.Bucket,
+		sse:          sse,
+		userMetadata: config.PutUserMetadata,
+	}, nil
+}
+
+func (c *s3Copier) Copy(ctx context.Context, from ServerSideCopier, src, dst string) error {
+	f, ok := from.(*s3Copier)
+	if !ok || f.endpoint != c.endpoint {
+		return ErrServerSideCopyUnsupported
+	}
+
+	srcOpts := minio.CopySrcOptions{
+		Bucket: f.bucket,
+		Object: src,
+	}
+	// Objects encrypted with a customer key can only be read with the key.
+	if f.sse != nil && f.sse.Type() == encrypt.SSEC {
+		srcOpts.Encryption = f.sse
+	}
+	dstOpts := minio.CopyDestOptions{
+		Bucket:     c.bucket,
+		Object:     dst,
+		Encryption: c.sse,
+	}
+	// The metadata of uploads, like the storage class, replaces the one of the source object.
+	if len(c.userMetadata) > 0 {
+		dstOpts.UserMetadata = make(map[string]string, len(c.userMetadata))
+		for k, v := range c.userMetadata {
+			dstOpts.UserMetadata[k] = v
+		}
+		dstOpts.ReplaceMetadata = true
+	}
+	info, err := c.client.StatObject(ctx, f.bucket, src, minio.StatObjectOptions{ServerSideEncryption: encrypt.SSE(srcOpts.Encryption)})
+	if err != nil {
+		return errors.Wrapf(err, "stat s3 object %s/%s", f.bucket, src)
+	}
+	if info.Size <= s3MaxCopyObjectSize {
+		_, err = c.client.CopyObject(ctx, dstOpts, srcOpts)
+	} else {
+		_, err = c.client.ComposeObject(ctx, dstOpts, srcOpts)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "copy s3 object %s/%s to %s/%s", f.bucket, src, c.bucket, dst)
+	}
+	return nil
+}
+
+func (c *s3Copier) Close() error { return nil }
+
+// signatureV2Provider signs requests with the signature V2 of the credentials of the provider.
+type signatureV2Provider struct {
+	credentials.Provider
+}
+
+func (p *signatureV2Provider) Retrieve() (credentials.Value, error) {
+	v, err := p.Provider.Retrieve()
+	if err != nil {
+		return v, err
+	}
+	if !v.SignerType.IsAnonymous() {
+		v.SignerType = credentials.SignatureV2
+	}
+	return v, nil
+}
@@ -0,0 +1,201 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package objstoreutil
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/thanos-io/objstore"
+)
+
+// bucketCopier copies objects between in-memory buckets, recording the copies.
+type bucketCopier struct {
+	bkt    objstore.Bucket
+	copies []string
+}
+
+func (c *bucketCopier) Copy(ctx context.Context, from ServerSideCopier, src, dst string) error {
+	f, ok := from.(*bucketCopier)
+	if !ok {
+		return ErrServerSideCopyUnsupported
+	}
+	c.copies = append(c.copies, src+" "+dst)
+	r, err := f.bkt.Get(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return c.bkt.Upload(ctx, dst, r)
+}
+
+func (c *bucketCopier) Close() error { return nil }
+
+// unsupportedCopier does not support copies from any copier.
+type unsupportedCopier struct{}
+
+func (unsupportedCopier) Copy(context.Context, ServerSideCopier, string, string) error {
+	return ErrServerSideCopyUnsupported
+}
+
+func (unsupportedCopier) Close() error { return nil }
+
+func TestCopyObject(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name       string
+		fromCopier func(objstore.Bucket) ServerSideCopier
+		toCopier   func(objstore.Bucket) ServerSideCopier
+		serverSide bool
+	}{
+		{name: "no copiers"},
+		{
+			name:       "server side",
+			fromCopier: func(b objstore.Bucket) ServerSideCopier { return &bucketCopier{bkt: b} },
+			toCopier:   func(b objstore.Bucket) ServerSideCopier { return &bucketCopier{bkt: b} },
+			serverSide: true,
+		},
+		{
+			name:       "unsupported",
+			fromCopier: func(b objstore.Bucket) ServerSideCopier { return &bucketCopier{bkt: b} },
+			toCopier:   func(objstore.Bucket) ServerSideCopier { return unsupportedCopier{} },
+		},
+		{
+			name:     "no origin copier",
+			toCopier: func(b objstore.Bucket) ServerSideCopier { return &bucketCopier{bkt: b} },
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			from, to := objstore.NewInMemBucket(), objstore.NewInMemBucket()
+			testutil.Ok(t, from.Upload(ctx, "a/index", strings.NewReader("index")))
+
+			var fromCopier, toCopier ServerSideCopier
+			if tc.fromCopier != nil {
+				fromCopier = tc.fromCopier(from)
+			}
+			if tc.toCopier != nil {
+				toCopier = tc.toCopier(to)
+			}
+			testutil.Ok(t, CopyObject(ctx, log.NewNopLogger(), from, fromCopier, to, toCopier, "a/index", "b/index"))
+			testutil.Equals(t, map[string][]byte{"b/index": []byte("index")}, to.Objects())
+
+			if c, ok := toCopier.(*bucketCopier); ok {
+				testutil.Equals(t, tc.serverSide, len(c.copies) == 1)
+			}
+			testutil.NotOk(t, CopyObject(ctx, log.NewNopLogger(), from, fromCopier, to, toCopier, "missing", "missing"))
+		})
+	}
+}
+
+func TestPrefixedServerSideCopier(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	from, to := objstore.NewInMemBucket(), objstore.NewInMemBucket()
+	testutil.Ok(t, from.Upload(ctx, "x/tenant-a/index", strings.NewReader("index")))
+
+	toCopier := &bucketCopier{bkt: to}
+	fromCopier := NewPrefixedServerSideCopier(NewPrefixedServerSideCopier(&bucketCopier{bkt: from}, "x"), "/tenant-a/")
+	testutil.Ok(t, NewPrefixedServerSideCopier(toCopier, "tenant-b").Copy(ctx, fromCopier, "index", "y/index"))
+	testutil.Equals(t, []string{"x/tenant-a/index tenant-b/y/index"}, toCopier.copies)
+
+	testutil.Equals(t, ServerSideCopier(toCopier), NewPrefixedServerSideCopier(toCopier, "/"))
+	testutil.Equals(t, nil, NewPrefixedServerSideCopier(nil, "tenant-a"))
+}
+
+func TestNewServerSideCopier(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c, err := NewServerSideCopier(ctx, log.NewNopLogger(), []byte(`type: FILESYSTEM
+config:
+  directory: `+t.TempDir()), "test")
+	testutil.Ok(t, err)
+	testutil.Equals(t, nil, c)
+
+	c, err = NewServerSideCopier(ctx, log.NewNopLogger(), []byte(`type: S3
+prefix: tenant-a
+config:
+  bucket: test
+  endpoint: localhost:9000`), "test")
+	testutil.Ok(t, err)
+	p, ok := c.(*prefixedCopier)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, "tenant-a", p.prefix)
+
+	_, err = NewServerSideCopier(ctx, log.NewNopLogger(), []byte(`type: S3
+config:
+  endpoint: localhost:9000`), "test")
+	testutil.NotOk(t, err)
+}
+
+func TestS3Copier(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mtx  sync.Mutex
+		puts []http.Header
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("Content-Length", "5")
+			w.Header().Set("ETag", `"etag"`)
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		case http.MethodPut:
+			_, _ = io.Copy(io.Discard, r.Body)
+			mtx.Lock()
+			puts = append(puts, r.Header.Clone())
+			mtx.Unlock()
+			_, _ = io.WriteString(w, `<CopyObjectResult><ETag>"etag"</ETag><LastModified>2006-01-02T15:04:05.000Z</LastModified></CopyObjectResult>`)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	testutil.Ok(t, err)
+
+	newCopier := func(bucket, extra string) ServerSideCopier {
+		c, err := NewServerSideCopier(context.Background(), log.NewNopLogger(), []byte(`type: S3
+config:
+  bucket: `+bucket+`
+  endpoint: `+u.Host+`
+  region: us-east-1
+  insecure: true
+  access_key: key
+  secret_key: secret
+`+extra), "test")
+		testutil.Ok(t, err)
+		return c
+	}
+	hot := newCopier("hot", "")
+	cold := newCopier("cold", `  put_user_metadata:
+    X-Amz-Storage-Class: GLACIER_IR`)
+
+	testutil.Ok(t, cold.Copy(context.Background(), hot, "a/index", "a/index"))
+	testutil.Equals(t, 1, len(puts))
+	testutil.Equals(t, "hot/a/index", puts[0].Get("X-Amz-Copy-Source"))
+	testutil.Equals(t, "GLACIER_IR", puts[0].Get("X-Amz-Storage-Class"))
+	testutil.Equals(t, "REPLACE", puts[0].Get("X-Amz-Metadata-Directive"))
+
+	// Buckets of other endpoints cannot be copied from.
+	other, err := NewServerSideCopier(context.Background(), log.NewNopLogger(), []byte(`type: S3
+config:
+  bucket: hot
+  endpoint: localhost:1`), "test")
+	testutil.Ok(t, err)
+	testutil.Equals(t, ErrServerSideCopyUnsupported, cold.Copy(context.Background(), other, "a/index", "a/index"))
+	testutil.Equals(t, ErrServerSideCopyUnsupported, cold.Copy(context.Background(), unsupportedCopier{}, "a/index", "a/index"))
+}
@@ -0,0 +1,302 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package objstoreutil
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/client"
+	"gopkg.in/yaml.v2"
+)
+
+// LAYOUT is the type of bucket configurations storing the index and the chunks of blocks under their own prefixes.
+const LAYOUT client.ObjProvider = "LAYOUT"
+
+const (
+	indexFilename = "index"
+	chunksDirname = "chunks"
+
+	// layoutCacheSize is the number of blocks whose location is cached by layout buckets.
+	layoutCacheSize = 4096
+)
+
+// LayoutConfig configures a bucket storing the index and the chunks of blocks under their own prefixes, e.g. to
+// apply different storage classes to them with lifecycle policies.
+type LayoutConfig struct {
+	// IndexPrefix is the prefix of the index files of blocks. Empty keeps them in the block directories.
+	IndexPrefix string `yaml:"index_prefix"`
+	// ChunksPrefix is the prefix of the chunks directories of blocks. Empty keeps them in the block directories.
+	ChunksPrefix string `yaml:"chunks_prefix"`
+	// Bucket is the object storage configuration of the bucket.
+	Bucket client.BucketConfig `yaml:"bucket"`
+}
+
+func (c LayoutConfig) validate() error {
+	if strings.Trim(c.IndexPrefix, objstore.DirDelim) == "" && strings.Trim(c.ChunksPrefix, objstore.DirDelim) == "" {
+		return errors.New("layout bucket requires an index or a chunks prefix")
+	}
+	for _, p := range []string{c.IndexPrefix, c.ChunksPrefix} {
+		p = strings.Trim(p, objstore.DirDelim)
+		if p == "" {
+			continue
+		}
+		for _, elem := range strings.Split(p, objstore.DirDelim) {
+			if elem == "" || elem == "." || elem == ".." {
+				return errors.Errorf("prefix %q is not a clean relative path", p)
+			}
+			if _, err := ulid.Parse(elem); err == nil {
+				return errors.Errorf("prefix %q cannot contain a block ID", p)
+			}
+		}
+	}
+	if t := client.ObjProvider(strings.ToUpper(string(c.Bucket.Type))); t == LAYOUT || t == ROUTING {
+		return errors.Errorf("layout bucket cannot use a %s bucket", t)
+	}
+	return nil
+}
+
+func newLayoutBucketFromConfig(logger log.Logger, bucketConf *client.BucketConfig, component string, wrapRoundtripper func(http.RoundTripper) http.RoundTripper) (objstore.Bucket, error) {
+	config, err := yaml.Marshal(bucketConf.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal content of layout bucket configuration")
+	}
+	var conf LayoutConfig
+	if err := yaml.UnmarshalStrict(config, &conf); err != nil {
+		return nil, errors.Wrap(err, "parsing layout bucket config YAML")
+	}
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	b, err := yaml.Marshal(conf.Bucket)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal layout bucket configuration")
+	}
+	bkt, err := client.NewBucket(logger, b, component, wrapRoundtripper)
+	if err != nil {
+		return nil, err
+	}
+	level.Info(logger).Log("msg", "storing the index and chunks of blocks under their own prefixes", "index_prefix", conf.IndexPrefix, "chunks_prefix", conf.ChunksPrefix)
+	return newLayoutBucket(bkt, conf.IndexPrefix, conf.ChunksPrefix), nil
+}
+
+// layoutBucket stores the index and the chunks of blocks under their own prefixes, keeping the other objects of
+// blocks, like meta.json and markers, in the block directories. Objects are always uploaded with the layout, and
+// read from the default location if the block is not found with it, so that blocks uploaded before the layout was
+// configured keep working. Listing a block directory lists its objects in both locations, with their default
+// names, so the layout is transparent to its callers.
+type layoutBucket struct {
+	bkt          objstore.Bucket
+	indexPrefix  string
+	chunksPrefix string
+	prefixes     []string
+
+	// legacy is true for the blocks found in the default location, and false for the ones found with the layout.
+	// Blocks are evicted when their objects are deleted, and the least recently used ones when it is full.
+	legacy *lru.Cache[string, bool]
+}
+
+// newLayoutBucket returns a bucket storing the index and the chunks of blocks under the given prefixes of bkt.
+func newLayoutBucket(bkt objstore.Bucket, indexPrefix, chunksPrefix string) objstore.Bucket {
+	b := &layoutBucket{
+		bkt:          bkt,
+		indexPrefix:  strings.Trim(indexPrefix, objstore.DirDelim),
+		chunksPrefix: strings.Trim(chunksPrefix, objstore.DirDelim),
+	}
+	b.legacy, _ = lru.New[string, bool](layoutCacheSize)
+	for _, p := range []string{b.indexPrefix, b.chunksPrefix} {
+		if p != "" && (len(b.prefixes) == 0 || b.prefixes[0] != p) {
+			b.prefixes = append(b.prefixes, p)
+		}
+	}
+	return b
+}
+
+// prefixOf returns the prefix of the object with the layout, and false if the object is not moved by the layout.
+func (b *layoutBucket) prefixOf(name string) (string, bool) {
+	dir, ok := blockDir(name)
+	if !ok {
+		return "", false
+	}
+	rest := strings.TrimPrefix(strings.TrimPrefix(name, objstore.DirDelim), dir+objstore.DirDelim)
+	var p string
+	switch {
+	case rest == indexFilename:
+		p = b.indexPrefix
+	case rest == chunksDirname, strings.HasPrefix(rest, chunksDirname+objstore.DirDelim):
+		p = b.chunksPrefix
+	}
+	return p, p != ""
+}
+
+// nameFor returns the name of the object in the bucket. Objects of blocks whose location is not known yet are
+// probed with the layout first and then in the default location.
+func (b *layoutBucket) nameFor(ctx context.Context, name string) (string, error) {
+	p, ok := b.prefixOf(name)
+	if !ok {
+		return name, nil
+	}
+	dir, _ := blockDir(name)
+	prefixed := path.Join(p, name)
+	if legacy, ok := b.legacy.Get(dir); ok {
+		if legacy {
+			return name, nil
+		}
+		return prefixed, nil
+	}
+
+	for _, n := range []string{prefixed, name} {
+		ok, err := b.bkt.Exists(ctx, n)
+		if err != nil {
+			return "", errors.Wrapf(err, "find location of %s", name)
+		}
+		if ok {
+			b.legacy.Add(dir, n == name)
+			return n, nil
+		}
+	}
+	return prefixed, nil
+}
+
+func (b *layoutBucket) Name() string { return b.bkt.Name() }
+
+func (b *layoutBucket) Close() error { return b.bkt.Close() }
+
+func (b *layoutBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	p, ok := b.prefixOf(name)
+	if !ok {
+		return b.bkt.Upload(ctx, name, r)
+	}
+	dir, _ := blockDir(name)
+	b.legacy.Add(dir, false)
+	return b.bkt.Upload(ctx, path.Join(p, name), r)
+}
+
+func (b *layoutBucket) Delete(ctx context.Context, name string) error {
+	n, err := b.nameFor(ctx, name)
+	if err != nil {
+		return err
+	}
+	// Deleted blocks, e.g. from their meta.json on, do not stay in the cache; their remaining objects are found again.
+	if dir, ok := blockDir(name); ok {
+		defer b.legacy.Remove(dir)
+	}
+	return b.bkt.Delete(ctx, n)
+}
+
+func (b *layoutBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	return b.IterWithAttributes(ctx, dir, func(attrs objstore.IterObjectAttributes) error { return f(attrs.Name) }, options...)
+}
+
+func (b *layoutBucket) IterWithAttributes(ctx context.Context, dir string, f func(objstore.IterObjectAttributes) error, options ...objstore.IterOption) error {
+	if strings.Trim(dir, objstore.DirDelim) != "" {
+		return b.iterDir(ctx, dir, f, options...)
+	}
+
+	// Hide the prefixes from the root, and list the objects under them with their default names if recursive.
+	hidden := make(map[string]struct{}, len(b.prefixes))
+	for _, p := range b.prefixes {
+		first, _, _ := strings.Cut(p, objstore.DirDelim)
+		hidden[first+objstore.DirDelim] = struct{}{}
+	}
+	return b.bkt.IterWithAttributes(ctx, dir, func(attrs objstore.IterObjectAttributes) error {
+		if _, ok := hidden[attrs.Name]; ok {
+			return nil
+		}
+		for _, p := range b.prefixes {
+			if !strings.HasPrefix(attrs.Name, p+objstore.DirDelim) {
+				continue
+			}
+			attrs.Name = strings.TrimPrefix(attrs.Name, p+objstore.DirDelim)
+			if mp, ok := b.prefixOf(attrs.Name); !ok || mp != p {
+				return nil
+			}
+			return f(attrs)
+		}
+		return f(attrs)
+	}, options...)
+}
+
+// iterDir merges the listings of the directory in the default location and under the prefixes, e.g. of a block
+// directory, or of a directory with block directories like tenant directories.
+func (b *layoutBucket) iterDir(ctx context.Context, dir string, f func(objstore.IterObjectAttributes) error, options ...objstore.IterOption) error {
+	seen := map[string]struct{}{}
+	visit := func(attrs objstore.IterObjectAttributes) error {
+		if _, ok := seen[attrs.Name]; ok {
+			return nil
+		}
+		seen[attrs.Name] = struct{}{}
+		return f(attrs)
+	}
+
+	if err := b.bkt.IterWithAttributes(ctx, dir, visit, options...); err != nil {
+		return err
+	}
+	for _, p := range b.prefixes {
+		prefixed := path.Join(p, dir) + objstore.DirDelim
+		if err := b.bkt.IterWithAttributes(ctx, prefixed, func(attrs objstore.IterObjectAttributes) error {
+			attrs.Name = strings.TrimPrefix(attrs.Name, p+objstore.DirDelim)
+			if mp, ok := b.prefixOf(attrs.Name); !ok || mp != p {
+				return nil
+			}
+			return visit(attrs)
+		}, options...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *layoutBucket) SupportedIterOptions() []objstore.IterOptionType {
+	return b.bkt.SupportedIterOptions()
+}
+
+func (b *layoutBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	n, err := b.nameFor(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return b.bkt.Get(ctx, n)
+}
+
+func (b *layoutBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	n, err := b.nameFor(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return b.bkt.GetRange(ctx, n, off, length)
+}
+
+func (b *layoutBucket) Exists(ctx context.Context, name string) (bool, error) {
+	n, err := b.nameFor(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	return b.bkt.Exists(ctx, n)
+}
+
+func (b *layoutBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	n, err := b.nameFor(ctx, name)
+	if err != nil {
+		return objstore.ObjectAttributes{}, err
+	}
+	return b.bkt.Attributes(ctx, n)
+}
+
+func (b *layoutBucket) IsObjNotFoundErr(err error) bool {
+	return b.bkt.IsObjNotFoundErr(err)
+}
+
+func (b *layoutBucket) IsAccessDeniedErr(err error) bool {
+	return b.bkt.IsAccessDeniedErr(err)
+}
@@ -0,0 +1,175 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package objstoreutil
+
+import (
+	"context"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/thanos-io/objstore"
+)
+
+func TestLayoutBucket(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	inner := objstore.NewInMemBucket()
+	bkt := newLayoutBucket(inner, "hot/", "cold")
+
+	id, legacy := ulid.MustNew(1, nil).String(), ulid.MustNew(2, nil).String()
+	for _, name := range []string{path.Join(id, "chunks", "000001"), path.Join(id, "index"), path.Join(id, metaFilename)} {
+		testutil.Ok(t, bkt.Upload(ctx, name, strings.NewReader(name)))
+	}
+	// A block uploaded before the layout was configured.
+	for _, name := range []string{path.Join(legacy, "chunks", "000001"), path.Join(legacy, "index"), path.Join(legacy, metaFilename)} {
+		testutil.Ok(t, inner.Upload(ctx, name, strings.NewReader(name)))
+	}
+	testutil.Ok(t, bkt.Upload(ctx, "debug/metas/x.json", strings.NewReader("{}")))
+
+	testutil.Equals(t, []string{
+		path.Join(id, metaFilename),
+		path.Join(legacy, "chunks", "000001"),
+		path.Join(legacy, "index"),
+		path.Join(legacy, metaFilename),
+		path.Join("cold", id, "chunks", "000001"),
+		"debug/metas/x.json",
+		path.Join("hot", id, "index"),
+	}, sortedKeys(inner.Objects()))
+
+	// Another instance resolves the locations of the blocks.
+	bkt = newLayoutBucket(inner, "hot", "cold")
+	for _, name := range []string{path.Join(id, "index"), path.Join(legacy, "index"), path.Join(id, "chunks", "000001"), path.Join(legacy, "chunks", "000001")} {
+		rc, err := bkt.GetRange(ctx, name, 0, int64(len(name)))
+		testutil.Ok(t, err)
+		b, err := io.ReadAll(rc)
+		testutil.Ok(t, err)
+		testutil.Ok(t, rc.Close())
+		testutil.Equals(t, name, string(b))
+
+		exists, err := bkt.Exists(ctx, name)
+		testutil.Ok(t, err)
+		testutil.Assert(t, exists, name)
+	}
+	_, err := bkt.Get(ctx, path.Join(ulid.MustNew(3, nil).String(), "index"))
+	testutil.Assert(t, bkt.IsObjNotFoundErr(err))
+
+	iter := func(dir string, options ...objstore.IterOption) []string {
+		var names []string
+		testutil.Ok(t, bkt.Iter(ctx, dir, func(name string) error {
+			names = append(names, name)
+			return nil
+		}, options...))
+		sort.Strings(names)
+		return names
+	}
+	testutil.Equals(t, []string{id + "/", legacy + "/", "debug/"}, iter(""))
+	testutil.Equals(t, []string{id + "/chunks/", path.Join(id, "index"), path.Join(id, metaFilename)}, iter(id))
+	testutil.Equals(t, []string{legacy + "/chunks/", path.Join(legacy, "index"), path.Join(legacy, metaFilename)}, iter(legacy+"/"))
+	testutil.Equals(t, []string{path.Join(id, "chunks", "000001")}, iter(path.Join(id, "chunks")))
+	testutil.Equals(t, []string{
+		path.Join(id, "chunks", "000001"),
+		path.Join(id, "index"),
+		path.Join(id, metaFilename),
+		path.Join(legacy, "chunks", "000001"),
+		path.Join(legacy, "index"),
+		path.Join(legacy, metaFilename),
+		"debug/metas/x.json",
+	}, iter("", objstore.WithRecursiveIter()))
+
+	for _, name := range iter(id, objstore.WithRecursiveIter()) {
+		testutil.Ok(t, bkt.Delete(ctx, name))
+	}
+	for _, name := range iter(legacy, objstore.WithRecursiveIter()) {
+		testutil.Ok(t, bkt.Delete(ctx, name))
+	}
+	testutil.Equals(t, []string{"debug/metas/x.json"}, sortedKeys(inner.Objects()))
+	// The locations of deleted blocks are not cached anymore.
+	testutil.Equals(t, 0, bkt.(*layoutBucket).legacy.Len())
+
+	// Blocks in tenant directories, e.g. compacted with --compact.tenant-directories, are stored with the layout too.
+	tenant := objstore.NewPrefixedBucket(bkt, "tenant-a")
+	for _, name := range []string{path.Join(id, "chunks", "000001"), path.Join(id, "index"), path.Join(id, metaFilename)} {
+		testutil.Ok(t, tenant.Upload(ctx, name, strings.NewReader(name)))
+	}
+	testutil.Equals(t, []string{
+		path.Join("cold", "tenant-a", id, "chunks", "000001"),
+		"debug/metas/x.json",
+		path.Join("hot", "tenant-a", id, "index"),
+		path.Join("tenant-a", id, metaFilename),
+	}, sortedKeys(inner.Objects()))
+
+	var names []string
+	testutil.Ok(t, tenant.Iter(ctx, "", func(name string) error {
+		names = append(names, name)
+		return nil
+	}, objstore.WithRecursiveIter()))
+	sort.Strings(names)
+	testutil.Equals(t, []string{path.Join(id, "chunks", "000001"), path.Join(id, "index"), path.Join(id, metaFilename)}, names)
+	testutil.Equals(t, []string{"tenant-a/" + id + "/"}, iter("tenant-a"))
+	rc, err := tenant.Get(ctx, path.Join(id, "index"))
+	testutil.Ok(t, err)
+	b, err := io.ReadAll(rc)
+	testutil.Ok(t, err)
+	testutil.Ok(t, rc.Close())
+	testutil.Equals(t, path.Join(id, "index"), string(b))
+}
+
+func TestNewBucket_Layout(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	bkt, err := NewBucket(log.NewNopLogger(), []byte(`type: LAYOUT
+config:
+  index_prefix: index-store
+  chunks_prefix: chunks-store
+  bucket:
+    type: FILESYSTEM
+    config:
+      directory: `+dir), "test", nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "fs: "+dir, bkt.Name())
+	testutil.Ok(t, bkt.Close())
+
+	for _, conf := range []string{
+		// No prefix.
+		`type: LAYOUT
+config:
+  bucket:
+    type: FILESYSTEM
+    config:
+      directory: ` + dir,
+		// Prefix clashing with block directories.
+		`type: LAYOUT
+config:
+  index_prefix: "` + ulid.MustNew(1, nil).String() + `"
+  bucket:
+    type: FILESYSTEM
+    config:
+      directory: ` + dir,
+		`type: LAYOUT
+config:
+  chunks_prefix: a/../b
+  bucket:
+    type: FILESYSTEM
+    config:
+      directory: ` + dir,
+		// Nested virtual buckets.
+		`type: LAYOUT
+config:
+  index_prefix: index-store
+  bucket:
+    type: ROUTING
+    config: {}`,
+	} {
+		_, err := NewBucket(log.NewNopLogger(), []byte(conf), "test", nil)
+		testutil.NotOk(t, err, conf)
+	}
+}
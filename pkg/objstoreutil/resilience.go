@@ -0,0 +1,336 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package objstoreutil
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/jpillora/backoff"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/sony/gobreaker"
+	"github.com/thanos-io/objstore"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultResilienceConfig is the resilience configuration of buckets, with no deadlines, retries or circuit breaker.
+var DefaultResilienceConfig = ResilienceConfig{
+	Retries: RetryConfig{
+		MinBackoff: model.Duration(100 * time.Millisecond),
+		MaxBackoff: model.Duration(5 * time.Second),
+	},
+	CircuitBreaker: CircuitBreakerConfig{
+		ConsecutiveFailures: 5,
+		OpenDuration:        model.Duration(10 * time.Second),
+		HalfOpenMaxRequests: 1,
+	},
+}
+
+// ResilienceConfig configures the deadlines, retries and circuit breaker of the operations of a bucket, so that a
+// degraded object storage fails operations instead of blocking their callers.
+type ResilienceConfig struct {
+	Timeouts       OperationTimeouts    `yaml:"timeouts"`
+	Retries        RetryConfig          `yaml:"retries"`
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+}
+
+// OperationTimeouts are the deadlines of every attempt of the operations, zero for no deadline. The deadlines of
+// Get and GetRange include reading the object, and the one of Iter includes the calls of its function.
+type OperationTimeouts struct {
+	Get        model.Duration `yaml:"get"`
+	GetRange   model.Duration `yaml:"get_range"`
+	Exists     model.Duration `yaml:"exists"`
+	Attributes model.Duration `yaml:"attributes"`
+	Upload     model.Duration `yaml:"upload"`
+	Delete     model.Duration `yaml:"delete"`
+	Iter       model.Duration `yaml:"iter"`
+}
+
+// RetryConfig configures the retries of failed operations, with an exponential backoff with jitter. Operations
+// failing because objects do not exist or access is denied are not retried, nor are uploads of readers which cannot
+// be rewound and listings which already returned objects.
+type RetryConfig struct {
+	// MaxRetries is the number of retries of failed operations, zero disables retries.
+	MaxRetries int            `yaml:"max_retries"`
+	MinBackoff model.Duration `yaml:"min_backoff"`
+	MaxBackoff model.Duration `yaml:"max_backoff"`
+}
+
+// CircuitBreakerConfig configures the circuit breaker of the bucket, failing operations without requests to the
+// object storage after consecutive failures.
+type CircuitBreakerConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// ConsecutiveFailures is the number of consecutive failed requests opening the circuit breaker.
+	ConsecutiveFailures uint32 `yaml:"consecutive_failures"`
+	// OpenDuration is the period of the open state, after which requests are allowed to check the object storage.
+	OpenDuration model.Duration `yaml:"open_duration"`
+	// HalfOpenMaxRequests is the number of requests checking the object storage when the circuit breaker is
+	// half-open, all of which have to succeed to close it.
+	HalfOpenMaxRequests uint32 `yaml:"half_open_max_requests"`
+}
+
+// ParseResilienceConfig parses the resilience configuration of buckets, applying the defaults.
+func ParseResilienceConfig(content []byte) (ResilienceConfig, error) {
+	conf := DefaultResilienceConfig
+	if err := yaml.UnmarshalStrict(content, &conf); err != nil {
+		return ResilienceConfig{}, errors.Wrap(err, "parsing object storage resilience config YAML")
+	}
+	if conf.Retries.MaxRetries < 0 {
+		return ResilienceConfig{}, errors.New("max_retries cannot be negative")
+	}
+	if conf.Retries.MinBackoff <= 0 || conf.Retries.MaxBackoff < conf.Retries.MinBackoff {
+		return ResilienceConfig{}, errors.New("min_backoff has to be positive and at most max_backoff")
+	}
+	if conf.CircuitBreaker.Enabled && conf.CircuitBreaker.ConsecutiveFailures == 0 {
+		return ResilienceConfig{}, errors.New("circuit breaker consecutive_failures has to be positive")
+	}
+	return conf, nil
+}
+
+func (c ResilienceConfig) enabled() bool {
+	return c.Timeouts != (OperationTimeouts{}) || c.Retries.MaxRetries > 0 || c.CircuitBreaker.Enabled
+}
+
+func (c ResilienceConfig) timeout(op string) time.Duration {
+	switch op {
+	case objstore.OpGet:
+		return time.Duration(c.Timeouts.Get)
+	case objstore.OpGetRange:
+		return time.Duration(c.Timeouts.GetRange)
+	case objstore.OpExists:
+		return time.Duration(c.Timeouts.Exists)
+	case objstore.OpAttributes:
+		return time.Duration(c.Timeouts.Attributes)
+	case objstore.OpUpload:
+		return time.Duration(c.Timeouts.Upload)
+	case objstore.OpDelete:
+		return time.Duration(c.Timeouts.Delete)
+	case objstore.OpIter:
+		return time.Duration(c.Timeouts.Iter)
+	}
+	return 0
+}
+
+// callerError is an error returned by a function of the caller of an operation, which is neither a failure of the
+// object storage nor retried.
+type callerError struct {
+	err error
+}
+
+func (e callerError) Error() string { return e.err.Error() }
+
+// resilientBucket applies the deadlines, retries and circuit breaker of its configuration to the operations of a
+// bucket.
+type resilientBucket struct {
+	objstore.Bucket
+
+	logger  log.Logger
+	conf    ResilienceConfig
+	breaker *gobreaker.CircuitBreaker
+	retries *prometheus.CounterVec
+}
+
+// WrapWithResilience returns the bucket applying the deadlines, retries and circuit breaker of the configuration to
+// the operations of bkt, and bkt itself if the configuration enables none of them.
+func WrapWithResilience(logger log.Logger, bkt objstore.Bucket, conf ResilienceConfig, reg prometheus.Registerer) objstore.Bucket {
+	if !conf.enabled() {
+		return bkt
+	}
+	b := &resilientBucket{
+		Bucket: bkt,
+		logger: logger,
+		conf:   conf,
+		retries: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_objstore_operation_retries_total",
+			Help: "Total number of retries of failed object storage operations, by operation.",
+		}, []string{"operation"}),
+	}
+	if conf.CircuitBreaker.Enabled {
+		state := promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_objstore_circuit_breaker_state",
+			Help: "State of the circuit breaker of the object storage: 0 closed, 1 half-open and 2 open.",
+		})
+		b.breaker = gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:        bkt.Name(),
+			MaxRequests: conf.CircuitBreaker.HalfOpenMaxRequests,
+			Timeout:     time.Duration(conf.CircuitBreaker.OpenDuration),
+			ReadyToTrip: func(counts gobreaker.Counts) bool {
+				return counts.ConsecutiveFailures >= conf.CircuitBreaker.ConsecutiveFailures
+			},
+			OnStateChange: func(name string, from, to gobreaker.State) {
+				state.Set(float64(to))
+				level.Warn(logger).Log("msg", "object storage circuit breaker state changed", "bucket", name, "from", from, "to", to)
+			},
+			IsSuccessful: b.isSuccessful,
+		})
+	}
+	return b
+}
+
+// isSuccessful returns true for the errors which are not failures of the object storage.
+func (b *resilientBucket) isSuccessful(err error) bool {
+	return err == nil || errors.As(err, &callerError{}) || errors.Is(err, context.Canceled) ||
+		b.Bucket.IsObjNotFoundErr(err) || b.Bucket.IsAccessDeniedErr(err)
+}
+
+// do calls f with the deadline of op and the circuit breaker, retrying it while it fails and canRetry, if not nil,
+// returns true. It returns the cancel function of the context of the successful attempt, which outlives the call
+// for the readers returned by f.
+func (b *resilientBucket) do(ctx context.Context, op string, canRetry func() bool, f func(context.Context) error) (context.CancelFunc, error) {
+	bo := &backoff.Backoff{
+		Factor: 2,
+		Min:    time.Duration(b.conf.Retries.MinBackoff),
+		Max:    time.Duration(b.conf.Retries.MaxBackoff),
+		Jitter: true,
+	}
+	for i := 0; ; i++ {
+		cancel, err := b.attempt(ctx, op, f)
+		if err == nil {
+			return cancel, nil
+		}
+		cancel()
+
+		var ce callerError
+		if errors.As(err, &ce) {
+			return func() {}, ce.err
+		}
+		if i >= b.conf.Retries.MaxRetries || ctx.Err() != nil || b.isSuccessful(err) ||
+			errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) ||
+			(canRetry != nil && !canRetry()) {
+			return func() {}, err
+		}
+		b.retries.WithLabelValues(op).Inc()
+		d := bo.Duration()
+		level.Debug(b.logger).Log("msg", "retrying failed object storage operation", "operation", op, "backoff", d, "err", err)
+		select {
+		case <-ctx.Done():
+			return func() {}, err
+		case <-time.After(d):
+		}
+	}
+}
+
+func (b *resilientBucket) attempt(ctx context.Context, op string, f func(context.Context) error) (context.CancelFunc, error) {
+	countAttempt(ctx)
+	var cancel context.CancelFunc
+	if t := b.conf.timeout(op); t > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	if b.breaker == nil {
+		return cancel, f(ctx)
+	}
+	_, err := b.breaker.Execute(func() (any, error) { return nil, f(ctx) })
+	if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		err = errors.Wrapf(err, "object storage %s", op)
+	}
+	return cancel, err
+}
+
+// run calls f like do, for the operations whose results do not outlive the call.
+func (b *resilientBucket) run(ctx context.Context, op string, canRetry func() bool, f func(context.Context) error) error {
+	cancel, err := b.do(ctx, op, canRetry, f)
+	cancel()
+	return err
+}
+
+func (b *resilientBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	return b.IterWithAttributes(ctx, dir, func(attrs objstore.IterObjectAttributes) error { return f(attrs.Name) }, options...)
+}
+
+func (b *resilientBucket) IterWithAttributes(ctx context.Context, dir string, f func(objstore.IterObjectAttributes) error, options ...objstore.IterOption) error {
+	// Listings are only retried until they return objects, so that f is not called twice for an object.
+	var called bool
+	return b.run(ctx, objstore.OpIter, func() bool { return !called }, func(ctx context.Context) error {
+		return b.Bucket.IterWithAttributes(ctx, dir, func(attrs objstore.IterObjectAttributes) error {
+			called = true
+			if err := f(attrs); err != nil {
+				return callerError{err: err}
+			}
+			return nil
+		}, options...)
+	})
+}
+
+func (b *resilientBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.get(ctx, objstore.OpGet, func(ctx context.Context) (io.ReadCloser, error) { return b.Bucket.Get(ctx, name) })
+}
+
+func (b *resilientBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return b.get(ctx, objstore.OpGetRange, func(ctx context.Context) (io.ReadCloser, error) {
+		return b.Bucket.GetRange(ctx, name, off, length)
+	})
+}
+
+func (b *resilientBucket) get(ctx context.Context, op string, get func(context.Context) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	cancel, err := b.do(ctx, op, nil, func(ctx context.Context) (err error) {
+		rc, err = get(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cancelingReadCloser{ReadCloser: rc, cancel: cancel}, nil
+}
+
+func (b *resilientBucket) Exists(ctx context.Context, name string) (exists bool, err error) {
+	err = b.run(ctx, objstore.OpExists, nil, func(ctx context.Context) (err error) {
+		exists, err = b.Bucket.Exists(ctx, name)
+		return err
+	})
+	return exists, err
+}
+
+func (b *resilientBucket) Attributes(ctx context.Context, name string) (attrs objstore.ObjectAttributes, err error) {
+	err = b.run(ctx, objstore.OpAttributes, nil, func(ctx context.Context) (err error) {
+		attrs, err = b.Bucket.Attributes(ctx, name)
+		return err
+	})
+	return attrs, err
+}
+
+func (b *resilientBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	// Uploads are retried from the current offset of readers which can be rewound, like files.
+	canRetry := func() bool { return false }
+	if s, ok := r.(io.Seeker); ok {
+		if off, err := s.Seek(0, io.SeekCurrent); err == nil {
+			canRetry = func() bool {
+				_, err := s.Seek(off, io.SeekStart)
+				return err == nil
+			}
+		}
+	}
+	return b.run(ctx, objstore.OpUpload, canRetry, func(ctx context.Context) error {
+		return b.Bucket.Upload(ctx, name, r)
+	})
+}
+
+func (b *resilientBucket) Delete(ctx context.Context, name string) error {
+	return b.run(ctx, objstore.OpDelete, nil, func(ctx context.Context) error {
+		return b.Bucket.Delete(ctx, name)
+	})
+}
+
+// cancelingReadCloser cancels the context of the operation returning the reader once closed.
+type cancelingReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReadCloser) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+func (r *cancelingReadCloser) ObjectSize() (int64, error) {
+	return objstore.TryToGetSize(r.ReadCloser)
+}
@@ -0,0 +1,239 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package objstoreutil
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/sony/gobreaker"
+	"github.com/thanos-io/objstore"
+)
+
+var errUnavailable = errors.New("unavailable")
+
+// flakyBucket fails the requests while failures is positive, decrementing it, and blocks the requests until their
+// context is done while block is set.
+type flakyBucket struct {
+	objstore.Bucket
+
+	failures atomic.Int64
+	block    atomic.Bool
+	requests atomic.Int64
+}
+
+func (b *flakyBucket) fail(ctx context.Context) error {
+	b.requests.Add(1)
+	if b.block.Load() {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if b.failures.Add(-1) >= 0 {
+		return errUnavailable
+	}
+	return nil
+}
+
+func (b *flakyBucket) Exists(ctx context.Context, name string) (bool, error) {
+	if err := b.fail(ctx); err != nil {
+		return false, err
+	}
+	return b.Bucket.Exists(ctx, name)
+}
+
+func (b *flakyBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := b.fail(ctx); err != nil {
+		return nil, err
+	}
+	return b.Bucket.Get(ctx, name)
+}
+
+func (b *flakyBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	// The failed uploads consume the reader.
+	if err := b.fail(ctx); err != nil {
+		_, _ = io.Copy(io.Discard, r)
+		return err
+	}
+	return b.Bucket.Upload(ctx, name, r)
+}
+
+func (b *flakyBucket) IterWithAttributes(ctx context.Context, dir string, f func(objstore.IterObjectAttributes) error, options ...objstore.IterOption) error {
+	// The failed listings return objects before failing.
+	if b.failures.Load() > 0 {
+		if err := f(objstore.IterObjectAttributes{Name: "a"}); err != nil {
+			return err
+		}
+	}
+	if err := b.fail(ctx); err != nil {
+		return err
+	}
+	return b.Bucket.IterWithAttributes(ctx, dir, f, options...)
+}
+
+func resilienceConfig(retries int) ResilienceConfig {
+	conf := DefaultResilienceConfig
+	conf.Retries = RetryConfig{MaxRetries: retries, MinBackoff: model.Duration(time.Millisecond), MaxBackoff: model.Duration(time.Millisecond)}
+	return conf
+}
+
+func TestResilientBucket_Retries(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	inner := &flakyBucket{Bucket: objstore.NewInMemBucket()}
+	testutil.Ok(t, inner.Bucket.Upload(ctx, "a", strings.NewReader("a")))
+	reg := prometheus.NewRegistry()
+	bkt := WrapWithResilience(log.NewNopLogger(), inner, resilienceConfig(2), reg).(*resilientBucket)
+
+	inner.failures.Store(2)
+	exists, err := bkt.Exists(ctx, "a")
+	testutil.Ok(t, err)
+	testutil.Assert(t, exists)
+	testutil.Equals(t, 2.0, promtest.ToFloat64(bkt.retries.WithLabelValues(objstore.OpExists)))
+
+	inner.failures.Store(3)
+	_, err = bkt.Exists(ctx, "a")
+	testutil.Assert(t, errors.Is(err, errUnavailable))
+
+	// Missing objects are not retried.
+	inner.requests.Store(0)
+	_, err = bkt.Get(ctx, "missing")
+	testutil.Assert(t, bkt.IsObjNotFoundErr(err))
+	testutil.Equals(t, int64(1), inner.requests.Load())
+
+	// Uploads of readers which can be rewound are retried from their offset.
+	inner.failures.Store(1)
+	r := strings.NewReader("skipped content")
+	_, err = r.Seek(int64(len("skipped ")), io.SeekStart)
+	testutil.Ok(t, err)
+	testutil.Ok(t, bkt.Upload(ctx, "b", r))
+	rc, err := bkt.Get(ctx, "b")
+	testutil.Ok(t, err)
+	b, err := io.ReadAll(rc)
+	testutil.Ok(t, err)
+	testutil.Ok(t, rc.Close())
+	testutil.Equals(t, "content", string(b))
+
+	inner.failures.Store(1)
+	testutil.Assert(t, errors.Is(bkt.Upload(ctx, "c", io.MultiReader(strings.NewReader("c"))), errUnavailable))
+
+	// Listings are not retried once they returned objects, and the errors of their functions are returned as they are.
+	inner.failures.Store(1)
+	var names []string
+	testutil.Assert(t, errors.Is(bkt.Iter(ctx, "", func(name string) error {
+		names = append(names, name)
+		return nil
+	}), errUnavailable))
+	testutil.Equals(t, []string{"a"}, names)
+
+	errStop := errors.New("stop")
+	testutil.Equals(t, errStop, bkt.Iter(ctx, "", func(string) error { return errStop }))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(bkt.retries.WithLabelValues(objstore.OpIter)))
+}
+
+func TestResilientBucket_Timeouts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	inner := &flakyBucket{Bucket: objstore.NewInMemBucket()}
+	testutil.Ok(t, inner.Bucket.Upload(ctx, "a", strings.NewReader("a")))
+	conf := resilienceConfig(1)
+	conf.Timeouts = OperationTimeouts{Exists: model.Duration(10 * time.Millisecond), Get: model.Duration(time.Minute)}
+	bkt := WrapWithResilience(log.NewNopLogger(), inner, conf, nil)
+
+	inner.block.Store(true)
+	_, err := bkt.Exists(ctx, "a")
+	testutil.Assert(t, errors.Is(err, context.DeadlineExceeded))
+	testutil.Equals(t, int64(2), inner.requests.Load())
+	inner.block.Store(false)
+
+	// The readers are read after the operation returned.
+	rc, err := bkt.Get(ctx, "a")
+	testutil.Ok(t, err)
+	b, err := io.ReadAll(rc)
+	testutil.Ok(t, err)
+	testutil.Ok(t, rc.Close())
+	testutil.Equals(t, "a", string(b))
+}
+
+func TestResilientBucket_CircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	inner := &flakyBucket{Bucket: objstore.NewInMemBucket()}
+	conf := resilienceConfig(0)
+	conf.CircuitBreaker = CircuitBreakerConfig{Enabled: true, ConsecutiveFailures: 2, OpenDuration: model.Duration(time.Hour), HalfOpenMaxRequests: 1}
+	reg := prometheus.NewRegistry()
+	bkt := WrapWithResilience(log.NewNopLogger(), inner, conf, reg)
+
+	// Missing objects are not failures.
+	for i := 0; i < 3; i++ {
+		_, err := bkt.Get(ctx, "missing")
+		testutil.Assert(t, bkt.IsObjNotFoundErr(err))
+	}
+
+	inner.failures.Store(2)
+	for i := 0; i < 2; i++ {
+		_, err := bkt.Exists(ctx, "a")
+		testutil.Assert(t, errors.Is(err, errUnavailable))
+	}
+	inner.requests.Store(0)
+	_, err := bkt.Exists(ctx, "a")
+	testutil.Assert(t, errors.Is(err, gobreaker.ErrOpenState))
+	testutil.Equals(t, int64(0), inner.requests.Load())
+
+	mfs, err := reg.Gather()
+	testutil.Ok(t, err)
+	var state float64 = -1
+	for _, mf := range mfs {
+		if mf.GetName() == "thanos_objstore_circuit_breaker_state" {
+			state = mf.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	testutil.Equals(t, float64(gobreaker.StateOpen), state)
+}
+
+func TestParseResilienceConfig(t *testing.T) {
+	t.Parallel()
+
+	conf, err := ParseResilienceConfig(nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, DefaultResilienceConfig, conf)
+	testutil.Assert(t, !conf.enabled())
+
+	conf, err = ParseResilienceConfig([]byte(`
+timeouts:
+  get_range: 30s
+retries:
+  max_retries: 3
+circuit_breaker:
+  enabled: true
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, model.Duration(30*time.Second), conf.Timeouts.GetRange)
+	testutil.Equals(t, 3, conf.Retries.MaxRetries)
+	testutil.Equals(t, DefaultResilienceConfig.Retries.MaxBackoff, conf.Retries.MaxBackoff)
+	testutil.Equals(t, uint32(5), conf.CircuitBreaker.ConsecutiveFailures)
+	testutil.Assert(t, conf.enabled())
+
+	for _, c := range []string{
+		`retries: {max_retries: -1}`,
+		`retries: {min_backoff: 10s, max_backoff: 1s}`,
+		`circuit_breaker: {enabled: true, consecutive_failures: 0}`,
+		`unknown: true`,
+	} {
+		_, err := ParseResilienceConfig([]byte(c))
+		testutil.NotOk(t, err, c)
+	}
+}
@@ -0,0 +1,369 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package objstoreutil
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/client"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/errutil"
+)
+
+// ROUTING is the type of bucket configurations routing blocks to different buckets by their external labels.
+const ROUTING client.ObjProvider = "ROUTING"
+
+const metaFilename = "meta.json"
+
+// RoutingConfig configures a bucket routing blocks to different buckets, e.g. of different teams.
+type RoutingConfig struct {
+	// Default is the bucket of blocks not matching any route, and of the objects outside block directories.
+	Default RouteConfig `yaml:"default"`
+	// Routes are checked in order, the first route matching the external labels of a block is used.
+	Routes []RouteConfig `yaml:"routes"`
+}
+
+// RouteConfig configures a bucket of the routing bucket.
+type RouteConfig struct {
+	// ExternalLabels selects the blocks with all of these external labels. Ignored for the default route.
+	ExternalLabels map[string]string `yaml:"external_labels"`
+	// RequesterPays sets the header making the requester pay for requests to S3 buckets.
+	RequesterPays bool `yaml:"requester_pays"`
+	// Bucket is the object storage configuration of the route, with its own credentials.
+	Bucket client.BucketConfig `yaml:"bucket"`
+}
+
+// NewBucket returns the bucket of the given object storage configuration. It supports the ROUTING and LAYOUT
+// types in addition to the types of the objstore client.
+func NewBucket(logger log.Logger, confContentYaml []byte, component string, wrapRoundtripper func(http.RoundTripper) http.RoundTripper) (objstore.Bucket, error) {
+	bucketConf := &client.BucketConfig{}
+	if err := yaml.UnmarshalStrict(confContentYaml, bucketConf); err != nil {
+		return client.NewBucket(logger, confContentYaml, component, wrapRoundtripper)
+	}
+	switch client.ObjProvider(strings.ToUpper(string(bucketConf.Type))) {
+	case ROUTING:
+		return newRoutingBucketFromConfig(logger, bucketConf, component, wrapRoundtripper)
+	case LAYOUT:
+		return newLayoutBucketFromConfig(logger, bucketConf, component, wrapRoundtripper)
+	default:
+		return client.NewBucket(logger, confContentYaml, component, wrapRoundtripper)
+	}
+}
+
+func newRoutingBucketFromConfig(logger log.Logger, bucketConf *client.BucketConfig, component string, wrapRoundtripper func(http.RoundTripper) http.RoundTripper) (objstore.Bucket, error) {
+	config, err := yaml.Marshal(bucketConf.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal content of routing bucket configuration")
+	}
+	var conf RoutingConfig
+	if err := yaml.UnmarshalStrict(config, &conf); err != nil {
+		return nil, errors.Wrap(err, "parsing routing bucket config YAML")
+	}
+	if conf.Default.Bucket.Type == "" {
+		return nil, errors.New("routing bucket requires a default bucket")
+	}
+
+	newRouteBucket := func(rc RouteConfig) (objstore.Bucket, error) {
+		if client.ObjProvider(strings.ToUpper(string(rc.Bucket.Type))) == ROUTING {
+			return nil, errors.New("routing buckets cannot be nested")
+		}
+		b, err := yaml.Marshal(rc.Bucket)
+		if err != nil {
+			return nil, errors.Wrap(err, "marshal route bucket configuration")
+		}
+		wrap := wrapRoundtripper
+		if rc.RequesterPays {
+			wrap = func(rt http.RoundTripper) http.RoundTripper {
+				if wrapRoundtripper != nil {
+					rt = wrapRoundtripper(rt)
+				}
+				return requesterPaysRoundTripper{rt: rt}
+			}
+		}
+		return client.NewBucket(logger, b, component, wrap)
+	}
+
+	def, err := newRouteBucket(conf.Default)
+	if err != nil {
+		return nil, errors.Wrap(err, "default route")
+	}
+	routes := make([]route, 0, len(conf.Routes))
+	for i, rc := range conf.Routes {
+		if len(rc.ExternalLabels) == 0 {
+			return nil, errors.Errorf("route %d has no external labels", i)
+		}
+		bkt, err := newRouteBucket(rc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "route %d", i)
+		}
+		routes = append(routes, route{externalLabels: rc.ExternalLabels, bkt: bkt})
+	}
+	level.Info(logger).Log("msg", "routing blocks to buckets by external labels", "routes", len(routes))
+	return newRoutingBucket(def, routes...), nil
+}
+
+type requesterPaysRoundTripper struct {
+	rt http.RoundTripper
+}
+
+func (r requesterPaysRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("x-amz-request-payer", "requester")
+	rt := r.rt
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return rt.RoundTrip(req)
+}
+
+type externalLabelsKey struct{}
+
+// WithExternalLabels returns a context routing the uploads made with it to the bucket of blocks with the given
+// external labels.
+func WithExternalLabels(ctx context.Context, lset map[string]string) context.Context {
+	return context.WithValue(ctx, externalLabelsKey{}, lset)
+}
+
+func externalLabelsFromContext(ctx context.Context) (map[string]string, bool) {
+	lset, ok := ctx.Value(externalLabelsKey{}).(map[string]string)
+	return lset, ok
+}
+
+type route struct {
+	externalLabels map[string]string
+	bkt            objstore.Bucket
+}
+
+func (r route) matches(lset map[string]string) bool {
+	for n, v := range r.externalLabels {
+		if lset[n] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// routingBucket is a single bucket facade over the buckets of several routes. Listing the root, or any other
+// directory than a block directory, lists the block directories of all buckets. A block uploaded with the external labels in the context goes to the
+// bucket of the first route matching them, and other objects of block directories are read from and written
+// to the bucket the block was listed in or found in.
+type routingBucket struct {
+	def    objstore.Bucket
+	routes []route
+	all    []objstore.Bucket
+
+	mtx    sync.RWMutex
+	blocks map[string]objstore.Bucket
+}
+
+// newRoutingBucket returns a bucket routing blocks to the bucket of the first route matching their external
+// labels, and to def otherwise.
+func newRoutingBucket(def objstore.Bucket, routes ...route) objstore.Bucket {
+	all := []objstore.Bucket{def}
+	for _, r := range routes {
+		all = append(all, r.bkt)
+	}
+	return &routingBucket{def: def, routes: routes, all: all, blocks: map[string]objstore.Bucket{}}
+}
+
+// blockDir returns the block directory of the object or directory name. Block directories are found at any depth,
+// e.g. under the tenant prefixes of a prefixed bucket wrapping this one.
+func blockDir(name string) (string, bool) {
+	parts := strings.Split(strings.TrimPrefix(name, objstore.DirDelim), objstore.DirDelim)
+	for i, p := range parts {
+		if _, err := ulid.Parse(p); err == nil {
+			return path.Join(parts[:i+1]...), true
+		}
+	}
+	return "", false
+}
+
+func (b *routingBucket) cached(dir string) (objstore.Bucket, bool) {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	bkt, ok := b.blocks[dir]
+	return bkt, ok
+}
+
+func (b *routingBucket) cache(dir string, bkt objstore.Bucket) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.blocks[dir] = bkt
+}
+
+// bucketFor returns the bucket of the object, probing the buckets for the given existing object if the block
+// directory of the object was not seen yet.
+func (b *routingBucket) bucketFor(ctx context.Context, name, probe string) (objstore.Bucket, error) {
+	dir, ok := blockDir(name)
+	if !ok {
+		return b.def, nil
+	}
+	if bkt, ok := b.cached(dir); ok {
+		return bkt, nil
+	}
+	for _, bkt := range b.all {
+		ok, err := bkt.Exists(ctx, probe)
+		if err != nil {
+			return nil, errors.Wrapf(err, "find bucket of %s", name)
+		}
+		if ok {
+			b.cache(dir, bkt)
+			return bkt, nil
+		}
+	}
+	return b.def, nil
+}
+
+func (b *routingBucket) Name() string { return "routing" }
+
+func (b *routingBucket) Close() error {
+	errs := errutil.MultiError{}
+	for _, bkt := range b.all {
+		errs.Add(bkt.Close())
+	}
+	return errs.Err()
+}
+
+func (b *routingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	dir, ok := blockDir(name)
+	if !ok {
+		return b.def.Upload(ctx, name, r)
+	}
+	if lset, ok := externalLabelsFromContext(ctx); ok {
+		bkt := b.def
+		for _, rt := range b.routes {
+			if rt.matches(lset) {
+				bkt = rt.bkt
+				break
+			}
+		}
+		b.cache(dir, bkt)
+		return bkt.Upload(ctx, name, r)
+	}
+	bkt, err := b.bucketFor(ctx, name, path.Join(dir, metaFilename))
+	if err != nil {
+		return err
+	}
+	return bkt.Upload(ctx, name, r)
+}
+
+func (b *routingBucket) Delete(ctx context.Context, name string) error {
+	bkt, err := b.bucketFor(ctx, name, name)
+	if err != nil {
+		return err
+	}
+	return bkt.Delete(ctx, name)
+}
+
+func (b *routingBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	return b.IterWithAttributes(ctx, dir, func(attrs objstore.IterObjectAttributes) error { return f(attrs.Name) }, options...)
+}
+
+func (b *routingBucket) IterWithAttributes(ctx context.Context, dir string, f func(objstore.IterObjectAttributes) error, options ...objstore.IterOption) error {
+	if d, ok := blockDir(dir); ok {
+		bkt, err := b.bucketFor(ctx, dir, path.Join(d, metaFilename))
+		if err != nil {
+			return err
+		}
+		return bkt.IterWithAttributes(ctx, dir, f, options...)
+	}
+
+	// Merge the listings of all buckets, e.g. of the root or of a directory with block directories like tenant
+	// directories.
+	seen := map[string]struct{}{}
+	for _, bkt := range b.all {
+		if err := bkt.IterWithAttributes(ctx, dir, func(attrs objstore.IterObjectAttributes) error {
+			if _, ok := seen[attrs.Name]; ok {
+				return nil
+			}
+			seen[attrs.Name] = struct{}{}
+			if d, ok := blockDir(attrs.Name); ok {
+				b.cache(d, bkt)
+			}
+			return f(attrs)
+		}, options...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *routingBucket) SupportedIterOptions() []objstore.IterOptionType {
+	supported := b.def.SupportedIterOptions()
+	for _, bkt := range b.all[1:] {
+		other := map[objstore.IterOptionType]struct{}{}
+		for _, o := range bkt.SupportedIterOptions() {
+			other[o] = struct{}{}
+		}
+		common := supported[:0:0]
+		for _, o := range supported {
+			if _, ok := other[o]; ok {
+				common = append(common, o)
+			}
+		}
+		supported = common
+	}
+	return supported
+}
+
+func (b *routingBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	bkt, err := b.bucketFor(ctx, name, name)
+	if err != nil {
+		return nil, err
+	}
+	return bkt.Get(ctx, name)
+}
+
+func (b *routingBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	bkt, err := b.bucketFor(ctx, name, name)
+	if err != nil {
+		return nil, err
+	}
+	return bkt.GetRange(ctx, name, off, length)
+}
+
+func (b *routingBucket) Exists(ctx context.Context, name string) (bool, error) {
+	bkt, err := b.bucketFor(ctx, name, name)
+	if err != nil {
+		return false, err
+	}
+	return bkt.Exists(ctx, name)
+}
+
+func (b *routingBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	bkt, err := b.bucketFor(ctx, name, name)
+	if err != nil {
+		return objstore.ObjectAttributes{}, err
+	}
+	return bkt.Attributes(ctx, name)
+}
+
+func (b *routingBucket) IsObjNotFoundErr(err error) bool {
+	for _, bkt := range b.all {
+		if bkt.IsObjNotFoundErr(err) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *routingBucket) IsAccessDeniedErr(err error) bool {
+	for _, bkt := range b.all {
+		if bkt.IsAccessDeniedErr(err) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package objstoreutil
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/thanos-io/objstore"
+)
+
+func TestRoutingBucket(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	def, teamA := objstore.NewInMemBucket(), objstore.NewInMemBucket()
+	bkt := newRoutingBucket(def, route{externalLabels: map[string]string{"team": "a"}, bkt: teamA})
+
+	idA, idB := ulid.MustNew(1, nil).String(), ulid.MustNew(2, nil).String()
+	for id, lset := range map[string]map[string]string{idA: {"team": "a", "replica": "0"}, idB: {"team": "b"}} {
+		uctx := WithExternalLabels(ctx, lset)
+		testutil.Ok(t, bkt.Upload(uctx, path.Join(id, "index"), strings.NewReader("index")))
+		testutil.Ok(t, bkt.Upload(uctx, path.Join(id, metaFilename), strings.NewReader("{}")))
+	}
+	testutil.Ok(t, bkt.Upload(ctx, "debug/metas/x.json", strings.NewReader("{}")))
+
+	testutil.Equals(t, []string{path.Join(idA, "index"), path.Join(idA, metaFilename)}, sortedKeys(teamA.Objects()))
+	testutil.Equals(t, []string{path.Join(idB, "index"), path.Join(idB, metaFilename), "debug/metas/x.json"}, sortedKeys(def.Objects()))
+
+	// Another instance finds blocks in the buckets they were uploaded to.
+	bkt = newRoutingBucket(def, route{externalLabels: map[string]string{"team": "a"}, bkt: teamA})
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(idA, "deletion-mark.json"), strings.NewReader("{}")))
+	exists, err := teamA.Exists(ctx, path.Join(idA, "deletion-mark.json"))
+	testutil.Ok(t, err)
+	testutil.Assert(t, exists)
+
+	rc, err := bkt.GetRange(ctx, path.Join(idA, "index"), 1, 2)
+	testutil.Ok(t, err)
+	b, err := io.ReadAll(rc)
+	testutil.Ok(t, err)
+	testutil.Ok(t, rc.Close())
+	testutil.Equals(t, "nd", string(b))
+
+	_, err = bkt.Get(ctx, path.Join(ulid.MustNew(3, nil).String(), "index"))
+	testutil.Assert(t, bkt.IsObjNotFoundErr(err))
+
+	var names []string
+	testutil.Ok(t, bkt.Iter(ctx, "", func(name string) error {
+		names = append(names, name)
+		return nil
+	}))
+	sort.Strings(names)
+	testutil.Equals(t, []string{idA + "/", idB + "/", "debug/"}, names)
+
+	names = names[:0]
+	testutil.Ok(t, bkt.Iter(ctx, idA, func(name string) error {
+		names = append(names, name)
+		return nil
+	}))
+	testutil.Equals(t, []string{path.Join(idA, "deletion-mark.json"), path.Join(idA, "index"), path.Join(idA, metaFilename)}, names)
+
+	// Blocks in tenant directories, e.g. compacted with --compact.tenant-directories, are routed and listed like the others.
+	tenant := objstore.NewPrefixedBucket(bkt, "tenant-a")
+	testutil.Ok(t, tenant.Upload(WithExternalLabels(ctx, map[string]string{"team": "a"}), path.Join(idB, "index"), strings.NewReader("index")))
+	testutil.Ok(t, tenant.Upload(WithExternalLabels(ctx, map[string]string{"team": "b"}), path.Join(idA, "index"), strings.NewReader("index")))
+	testutil.Equals(t, []string{path.Join(idA, "deletion-mark.json"), path.Join(idA, "index"), path.Join(idA, metaFilename), path.Join("tenant-a", idB, "index")}, sortedKeys(teamA.Objects()))
+
+	names = names[:0]
+	testutil.Ok(t, tenant.Iter(ctx, "", func(name string) error {
+		names = append(names, name)
+		return nil
+	}))
+	sort.Strings(names)
+	testutil.Equals(t, []string{idA + "/", idB + "/"}, names)
+
+	// Another instance finds the bucket of tenant blocks.
+	tenant = objstore.NewPrefixedBucket(newRoutingBucket(def, route{externalLabels: map[string]string{"team": "a"}, bkt: teamA}), "tenant-a")
+	rc, err = tenant.Get(ctx, path.Join(idB, "index"))
+	testutil.Ok(t, err)
+	b, err = io.ReadAll(rc)
+	testutil.Ok(t, err)
+	testutil.Ok(t, rc.Close())
+	testutil.Equals(t, "index", string(b))
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestNewBucket_Routing(t *testing.T) {
+	t.Parallel()
+
+	defDir, teamDir := t.TempDir(), t.TempDir()
+	bkt, err := NewBucket(log.NewNopLogger(), []byte(`type: ROUTING
+config:
+  default:
+    bucket:
+      type: FILESYSTEM
+      config:
+        directory: `+defDir+`
+  routes:
+    - external_labels:
+        team: a
+      requester_pays: true
+      bucket:
+        type: FILESYSTEM
+        config:
+          directory: `+teamDir), "test", nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "routing", bkt.Name())
+	testutil.Ok(t, bkt.Close())
+
+	_, err = NewBucket(log.NewNopLogger(), []byte(`type: ROUTING
+config:
+  routes: []`), "test", nil)
+	testutil.NotOk(t, err)
+
+	bkt, err = NewBucket(log.NewNopLogger(), []byte(`type: FILESYSTEM
+config:
+  directory: `+defDir), "test", nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "fs: "+defDir, bkt.Name())
+}
+
+func TestRequesterPaysRoundTripper(t *testing.T) {
+	t.Parallel()
+
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("x-amz-request-payer")
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	testutil.Ok(t, err)
+	resp, err := requesterPaysRoundTripper{}.RoundTrip(req)
+	testutil.Ok(t, err)
+	testutil.Ok(t, resp.Body.Close())
+	testutil.Equals(t, "requester", got)
+	testutil.Equals(t, "", req.Header.Get("x-amz-request-payer"))
+}
@@ -0,0 +1,221 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package objstoreutil
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/tracing"
+)
+
+// attemptsKey is the context key of the number of attempts of an operation, counted by the resilient bucket for the
+// tracing bucket.
+type attemptsKey struct{}
+
+// countAttempt counts an attempt of the operation of the context, if it is traced.
+func countAttempt(ctx context.Context) {
+	if n, ok := ctx.Value(attemptsKey{}).(*int); ok {
+		*n++
+	}
+}
+
+// tracingBucket includes the operations of the bucket in the traces of their contexts, as children of the spans of
+// the contexts. Unlike the objstore tracing bucket, it uses the tracer propagated with tracing.ContextWithTracer, and
+// tags the spans with the block, file and size of the objects and the number of attempts of the operations.
+type tracingBucket struct {
+	bkt objstore.Bucket
+}
+
+// WrapWithTraces returns the bucket which traces the operations of bkt. The retries of a resilient bucket wrapped by
+// bkt are counted in the attempts of the operations.
+func WrapWithTraces(bkt objstore.Bucket) objstore.InstrumentedBucket {
+	return tracingBucket{bkt: bkt}
+}
+
+// startSpan starts the span of the operation on the object or directory name, returning the context of the operation
+// and the function finishing the span.
+func startSpan(ctx context.Context, op, name string, tags opentracing.Tags) (tracing.Span, context.Context, func(error)) {
+	if tags == nil {
+		tags = opentracing.Tags{}
+	}
+	tags["objstore.operation"] = op
+	if dir, ok := blockDir(name); ok {
+		tags["block.id"] = dir
+		name = strings.TrimPrefix(strings.TrimPrefix(name, objstore.DirDelim), dir+objstore.DirDelim)
+	}
+	tags["objstore.file"] = name
+
+	span, ctx := tracing.StartSpan(ctx, "objstore_"+op, tags)
+	attempts := 0
+	return span, context.WithValue(ctx, attemptsKey{}, &attempts), func(err error) {
+		span.SetTag("objstore.attempts", max(attempts, 1))
+		if err != nil {
+			ext.LogError(span, err)
+		}
+		span.Finish()
+	}
+}
+
+func (t tracingBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	span, ctx, finish := startSpan(ctx, objstore.OpIter, dir, nil)
+	objects := 0
+	err := t.bkt.Iter(ctx, dir, func(name string) error {
+		objects++
+		return f(name)
+	}, options...)
+	span.SetTag("objstore.objects", objects)
+	finish(err)
+	return err
+}
+
+func (t tracingBucket) IterWithAttributes(ctx context.Context, dir string, f func(objstore.IterObjectAttributes) error, options ...objstore.IterOption) error {
+	span, ctx, finish := startSpan(ctx, objstore.OpIter, dir, nil)
+	objects := 0
+	err := t.bkt.IterWithAttributes(ctx, dir, func(attrs objstore.IterObjectAttributes) error {
+		objects++
+		return f(attrs)
+	}, options...)
+	span.SetTag("objstore.objects", objects)
+	finish(err)
+	return err
+}
+
+func (t tracingBucket) SupportedIterOptions() []objstore.IterOptionType {
+	return t.bkt.SupportedIterOptions()
+}
+
+func (t tracingBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	span, ctx, finish := startSpan(ctx, objstore.OpGet, name, nil)
+	r, err := t.bkt.Get(ctx, name)
+	if err != nil {
+		finish(err)
+		return nil, err
+	}
+	return newTracingReadCloser(r, span, finish), nil
+}
+
+func (t tracingBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	span, ctx, finish := startSpan(ctx, objstore.OpGetRange, name, opentracing.Tags{"objstore.offset": off, "objstore.length": length})
+	r, err := t.bkt.GetRange(ctx, name, off, length)
+	if err != nil {
+		finish(err)
+		return nil, err
+	}
+	return newTracingReadCloser(r, span, finish), nil
+}
+
+func (t tracingBucket) Exists(ctx context.Context, name string) (bool, error) {
+	_, ctx, finish := startSpan(ctx, objstore.OpExists, name, nil)
+	exists, err := t.bkt.Exists(ctx, name)
+	finish(err)
+	return exists, err
+}
+
+func (t tracingBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	span, ctx, finish := startSpan(ctx, objstore.OpAttributes, name, nil)
+	attrs, err := t.bkt.Attributes(ctx, name)
+	if err == nil {
+		span.SetTag("objstore.size", attrs.Size)
+	}
+	finish(err)
+	return attrs, err
+}
+
+func (t tracingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	span, ctx, finish := startSpan(ctx, objstore.OpUpload, name, nil)
+	if size, err := objstore.TryToGetSize(r); err == nil {
+		span.SetTag("objstore.size", size)
+	}
+	err := t.bkt.Upload(ctx, name, r)
+	finish(err)
+	return err
+}
+
+func (t tracingBucket) Delete(ctx context.Context, name string) error {
+	_, ctx, finish := startSpan(ctx, objstore.OpDelete, name, nil)
+	err := t.bkt.Delete(ctx, name)
+	finish(err)
+	return err
+}
+
+func (t tracingBucket) Name() string {
+	return "tracing: " + t.bkt.Name()
+}
+
+func (t tracingBucket) Close() error {
+	return t.bkt.Close()
+}
+
+func (t tracingBucket) IsObjNotFoundErr(err error) bool {
+	return t.bkt.IsObjNotFoundErr(err)
+}
+
+func (t tracingBucket) IsAccessDeniedErr(err error) bool {
+	return t.bkt.IsAccessDeniedErr(err)
+}
+
+func (t tracingBucket) WithExpectedErrs(fn objstore.IsOpFailureExpectedFunc) objstore.Bucket {
+	if ib, ok := t.bkt.(objstore.InstrumentedBucket); ok {
+		return tracingBucket{bkt: ib.WithExpectedErrs(fn)}
+	}
+	return t
+}
+
+func (t tracingBucket) ReaderWithExpectedErrs(fn objstore.IsOpFailureExpectedFunc) objstore.BucketReader {
+	return t.WithExpectedErrs(fn)
+}
+
+// tracingReadCloser finishes the span of the read object when it is closed, tagging it with the bytes read.
+type tracingReadCloser struct {
+	io.ReadCloser
+
+	span   tracing.Span
+	finish func(error)
+
+	objSize    int64
+	objSizeErr error
+	read       int64
+	err        error
+}
+
+func newTracingReadCloser(r io.ReadCloser, span tracing.Span, finish func(error)) *tracingReadCloser {
+	// The size of the readers can only be reliably returned before they are read.
+	objSize, objSizeErr := objstore.TryToGetSize(r)
+	if objSizeErr == nil {
+		span.SetTag("objstore.size", objSize)
+	}
+	return &tracingReadCloser{ReadCloser: r, span: span, finish: finish, objSize: objSize, objSizeErr: objSizeErr}
+}
+
+func (r *tracingReadCloser) ObjectSize() (int64, error) {
+	return r.objSize, r.objSizeErr
+}
+
+func (r *tracingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *tracingReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	if r.finish != nil {
+		r.span.SetTag("objstore.read_bytes", r.read)
+		if r.err == nil {
+			r.err = err
+		}
+		r.finish(r.err)
+		r.finish = nil
+	}
+	return err
+}
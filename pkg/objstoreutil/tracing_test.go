@@ -0,0 +1,66 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package objstoreutil
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/tracing"
+)
+
+func TestTracingBucket(t *testing.T) {
+	t.Parallel()
+
+	tracer := mocktracer.New()
+	ctx := tracing.ContextWithTracer(context.Background(), tracer)
+	inner := &flakyBucket{Bucket: objstore.NewInMemBucket()}
+	bkt := WrapWithTraces(WrapWithResilience(log.NewNopLogger(), inner, resilienceConfig(2), nil))
+
+	id := ulid.MustNew(1, nil).String()
+	inner.failures.Store(2)
+	testutil.Ok(t, bkt.Upload(ctx, id+"/chunks/000001", strings.NewReader("chunks")))
+
+	rc, err := bkt.GetRange(ctx, id+"/chunks/000001", 1, 3)
+	testutil.Ok(t, err)
+	_, err = io.ReadAll(rc)
+	testutil.Ok(t, err)
+	testutil.Ok(t, rc.Close())
+
+	testutil.Ok(t, bkt.Iter(ctx, "", func(string) error { return nil }, objstore.WithRecursiveIter()))
+	_, err = bkt.Get(ctx, "missing")
+	testutil.Assert(t, bkt.IsObjNotFoundErr(err))
+
+	spans := tracer.FinishedSpans()
+	testutil.Equals(t, 4, len(spans))
+
+	upload := spans[0]
+	testutil.Equals(t, "objstore_upload", upload.OperationName)
+	testutil.Equals(t, id, upload.Tag("block.id"))
+	testutil.Equals(t, "chunks/000001", upload.Tag("objstore.file"))
+	testutil.Equals(t, int64(6), upload.Tag("objstore.size"))
+	testutil.Equals(t, 3, upload.Tag("objstore.attempts"))
+
+	getRange := spans[1]
+	testutil.Equals(t, "objstore_get_range", getRange.OperationName)
+	testutil.Equals(t, int64(3), getRange.Tag("objstore.read_bytes"))
+	testutil.Equals(t, 1, getRange.Tag("objstore.attempts"))
+
+	iter := spans[2]
+	testutil.Equals(t, "objstore_iter", iter.OperationName)
+	testutil.Equals(t, nil, iter.Tag("block.id"))
+	testutil.Equals(t, 1, iter.Tag("objstore.objects"))
+
+	get := spans[3]
+	testutil.Equals(t, "missing", get.Tag("objstore.file"))
+	testutil.Equals(t, true, get.Tag("error"))
+}
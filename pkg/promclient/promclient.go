@@ -954,3 +954,43 @@ func (c *Client) TargetsInGRPC(ctx context.Context, base *url.URL, stateTargets
 	}
 	return v.Data, c.get2xxResultWithGRPCErrors(ctx, "/prom_targets HTTP[client]", &u, &v)
 }
+
+// ScrapeConfigsInGRPC returns the scrape configs from the /api/v1/status/config Prometheus endpoint, in YAML with their
+// secrets hidden by Prometheus. It uses gRPC errors.
+func (c *Client) ScrapeConfigsInGRPC(ctx context.Context, base *url.URL) ([]*targetspb.ScrapeConfig, error) {
+	u := *base
+	u.Path = path.Join(u.Path, "/api/v1/status/config")
+
+	var v struct {
+		Data struct {
+			YAML string `json:"yaml"`
+		} `json:"data"`
+	}
+	if err := c.get2xxResultWithGRPCErrors(ctx, "/prom_scrape_configs HTTP[client]", &u, &v); err != nil {
+		return nil, err
+	}
+
+	// Scrape configs are kept as YAML map slices to keep the order of their fields.
+	var cfg struct {
+		ScrapeConfigs []yaml.MapSlice `yaml:"scrape_configs"`
+	}
+	if err := yaml.Unmarshal([]byte(v.Data.YAML), &cfg); err != nil {
+		return nil, status.Error(codes.Internal, errors.Wrap(err, "parse Prometheus config").Error())
+	}
+
+	scrapeConfigs := make([]*targetspb.ScrapeConfig, 0, len(cfg.ScrapeConfigs))
+	for _, sc := range cfg.ScrapeConfigs {
+		b, err := yaml.Marshal(sc)
+		if err != nil {
+			return nil, status.Error(codes.Internal, errors.Wrap(err, "encode scrape config").Error())
+		}
+		scrapeConfig := &targetspb.ScrapeConfig{Config: string(b)}
+		for _, item := range sc {
+			if item.Key == "job_name" {
+				scrapeConfig.JobName = fmt.Sprint(item.Value)
+			}
+		}
+		scrapeConfigs = append(scrapeConfigs, scrapeConfig)
+	}
+	return scrapeConfigs, nil
+}
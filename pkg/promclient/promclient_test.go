@@ -14,6 +14,8 @@ import (
 	"testing"
 
 	"github.com/efficientgo/core/testutil"
+
+	"github.com/thanos-io/thanos/pkg/targets/targetspb"
 )
 
 func TestExternalLabels(t *testing.T) {
@@ -65,3 +67,21 @@ func TestExternalLabels(t *testing.T) {
 		})
 	}
 }
+
+func TestScrapeConfigsInGRPC(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Equals(t, "/api/v1/status/config", r.URL.Path)
+		fmt.Fprintln(w, `{"status":"success","data":{"yaml":"global:\n  scrape_interval: 1m\nscrape_configs:\n- job_name: prometheus\n  scrape_interval: 15s\n  static_configs:\n  - targets:\n    - localhost:9090\n- job_name: node\n  basic_auth:\n    username: user\n    password: <secret>\n"}}`)
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	testutil.Ok(t, err)
+
+	scrapeConfigs, err := NewDefaultClient().ScrapeConfigsInGRPC(context.Background(), u)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []*targetspb.ScrapeConfig{
+		{JobName: "prometheus", Config: "job_name: prometheus\nscrape_interval: 15s\nstatic_configs:\n- targets:\n  - localhost:9090\n"},
+		{JobName: "node", Config: "job_name: node\nbasic_auth:\n  username: user\n  password: <secret>\n"},
+	}, scrapeConfigs)
+}
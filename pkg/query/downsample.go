@@ -0,0 +1,137 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package query
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// downsampleOnReadWarning is the warning of the queries with raw data downsampled on read.
+const downsampleOnReadWarning = "no downsampled data for some series older than the downsampling delay of 40h; " +
+	"their raw data was downsampled to a 5m resolution on read, and results may differ from downsampled data"
+
+// rawNativeHistogramsWarning is the warning of the queries allowing downsampled data which evaluated raw data of
+// native histograms, e.g. as the compactor has not downsampled them yet.
+const rawNativeHistogramsWarning = "no downsampled data for some native histogram series older than the downsampling delay of 40h; " +
+	"their raw data was evaluated instead"
+
+// nativeHistogramsMinMaxWarning is the warning of the min and max queries of downsampled native histograms.
+const nativeHistogramsMinMaxWarning = "downsampled native histograms have no min and max aggregates; " +
+	"the average of their downsampled samples was evaluated instead"
+
+// nativeHistogramFallbacks returns whether the series has raw native histogram chunks ending before rawBefore, if not
+// zero, and whether the min or max aggregates of its downsampled native histograms are replaced by their average.
+func nativeHistogramFallbacks(s *storepb.Series, rawBefore int64, aggrs []storepb.Aggr) (raw, averaged bool) {
+	minOrMax := len(aggrs) == 1 && (aggrs[0] == storepb.Aggr_MIN || aggrs[0] == storepb.Aggr_MAX)
+	for _, c := range s.Chunks {
+		if c.Raw != nil {
+			isHistogram := c.Raw.Type == storepb.Chunk_HISTOGRAM || c.Raw.Type == storepb.Chunk_FLOAT_HISTOGRAM
+			raw = raw || isHistogram && rawBefore != 0 && c.MaxTime < rawBefore
+			continue
+		}
+		averaged = averaged || minOrMax && c.Min == nil && c.Max == nil && c.Sum != nil && c.Count != nil
+	}
+	return raw, averaged
+}
+
+// downsampleRawChunks replaces the raw float chunks of the series ending before maxt with 5m resolution chunks of
+// the given aggregates, like the compactor would downsample them, so that queries allowing downsampled data do not
+// evaluate raw data when the logic of the compactor falls behind. Overlapping chunks, e.g. of replicas, are
+// downsampled separately so they are still deduplicated. It returns whether any chunk was downsampled.
+func downsampleRawChunks(s *storepb.Series, maxt int64, aggrs []storepb.Aggr) (bool, error) {
+	var (
+		out       = make([]storepb.AggrChunk, 0, len(s.Chunks))
+		run       []chunkenc.Chunk
+		runMaxt   int64
+		converted bool
+	)
+	flush := func() error {
+		if len(run) == 0 {
+			return nil
+		}
+		chks, err := downsample.DownsampleRawXORChunks(run, downsample.ResLevel1)
+		if err != nil {
+			return err
+		}
+		for _, c := range chks {
+			ac, err := aggrChunk(c.Chunk, aggrs)
+			if err != nil {
+				return err
+			}
+			ac.MinTime, ac.MaxTime = c.MinTime, c.MaxTime
+			out = append(out, ac)
+		}
+		run = run[:0]
+		converted = true
+		return nil
+	}
+
+	for _, c := range s.Chunks {
+		if c.Raw == nil || c.Raw.Type != storepb.Chunk_XOR || c.MaxTime >= maxt {
+			if err := flush(); err != nil {
+				return false, err
+			}
+			out = append(out, c)
+			continue
+		}
+		if len(run) > 0 && c.MinTime <= runMaxt {
+			if err := flush(); err != nil {
+				return false, err
+			}
+		}
+		chk, err := chunkenc.FromData(chunkenc.EncXOR, c.Raw.Data)
+		if err != nil {
+			return false, errors.Wrap(err, "decode raw chunk")
+		}
+		run = append(run, chk)
+		runMaxt = c.MaxTime
+	}
+	if err := flush(); err != nil {
+		return false, err
+	}
+	if converted {
+		s.Chunks = out
+	}
+	return converted, nil
+}
+
+// aggrChunk returns the given aggregates of the aggregation chunk.
+func aggrChunk(c chunkenc.Chunk, aggrs []storepb.Aggr) (storepb.AggrChunk, error) {
+	ac, ok := c.(*downsample.AggrChunk)
+	if !ok {
+		return storepb.AggrChunk{}, errors.Errorf("unexpected chunk encoding %d", c.Encoding())
+	}
+
+	var out storepb.AggrChunk
+	for _, a := range aggrs {
+		var (
+			t  downsample.AggrType
+			to **storepb.Chunk
+		)
+		switch a {
+		case storepb.Aggr_COUNT:
+			t, to = downsample.AggrCount, &out.Count
+		case storepb.Aggr_SUM:
+			t, to = downsample.AggrSum, &out.Sum
+		case storepb.Aggr_MIN:
+			t, to = downsample.AggrMin, &out.Min
+		case storepb.Aggr_MAX:
+			t, to = downsample.AggrMax, &out.Max
+		case storepb.Aggr_COUNTER:
+			t, to = downsample.AggrCounter, &out.Counter
+		default:
+			return storepb.AggrChunk{}, errors.Errorf("unexpected aggregate %s", a)
+		}
+		x, err := ac.Get(t)
+		if err != nil {
+			return storepb.AggrChunk{}, errors.Wrapf(err, "aggregate %s", t)
+		}
+		*to = &storepb.Chunk{Type: storepb.Chunk_XOR, Data: x.Bytes()}
+	}
+	return out, nil
+}
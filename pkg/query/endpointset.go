@@ -220,6 +220,10 @@ type EndpointSet struct {
 	endpointsMtx    sync.RWMutex
 	endpoints       map[string]*endpointRef
 	endpointsMetric *endpointSetNodeCollector
+
+	// The window and batch size of the Series streams of the store clients. See storepb.NewFlowControlledStoreClient.
+	seriesWindowBytes   int
+	seriesMaxBatchBytes int
 }
 
 // nowFunc is a function that returns time.Time.
@@ -268,6 +272,17 @@ func NewEndpointSet(
 	}
 }
 
+// SetSeriesFlowControl makes the store clients returned from now on stream series with flow control, granting stores
+// a window of windowBytes and requesting series batched into frames of up to maxBatchBytes. Zero values disable the
+// flow control and the batching respectively.
+func (e *EndpointSet) SetSeriesFlowControl(windowBytes, maxBatchBytes int) {
+	e.endpointsMtx.Lock()
+	defer e.endpointsMtx.Unlock()
+
+	e.seriesWindowBytes = windowBytes
+	e.seriesMaxBatchBytes = maxBatchBytes
+}
+
 // Update updates the endpoint set. It fetches current list of endpoint specs from function and updates the fresh metadata
 // from all endpoints. Keeps around statically defined nodes that were defined with the strict mode.
 func (e *EndpointSet) Update(ctx context.Context) {
@@ -438,7 +453,7 @@ func (e *EndpointSet) GetStoreClients() []store.Client {
 			er.mtx.RLock()
 			// Make a new endpointRef with store client.
 			stores = append(stores, &endpointRef{
-				StoreClient: storepb.NewStoreClient(er.cc),
+				StoreClient: e.newStoreClient(er.cc),
 				addr:        er.addr,
 				metadata:    er.metadata,
 				status:      er.status,
@@ -449,6 +464,17 @@ func (e *EndpointSet) GetStoreClients() []store.Client {
 	return stores
 }
 
+func (e *EndpointSet) newStoreClient(cc *grpc.ClientConn) storepb.StoreClient {
+	e.endpointsMtx.RLock()
+	windowBytes, maxBatchBytes := e.seriesWindowBytes, e.seriesMaxBatchBytes
+	e.endpointsMtx.RUnlock()
+
+	if windowBytes <= 0 && maxBatchBytes <= 0 {
+		return storepb.NewStoreClient(cc)
+	}
+	return storepb.NewFlowControlledStoreClient(cc, windowBytes, maxBatchBytes)
+}
+
 // GetQueryAPIClients returns a list of all active query API clients.
 func (e *EndpointSet) GetQueryAPIClients() []Client {
 	endpoints := e.getQueryableRefs()
@@ -127,12 +127,12 @@ func (s *chunkSeries) Iterator(_ chunkenc.Iterator) chunkenc.Iterator {
 			sit = newChunkSeriesIterator(its)
 		case storepb.Aggr_MIN:
 			for _, c := range s.chunks {
-				its = append(its, getFirstIterator(c.Min, c.Raw))
+				its = append(its, getMinMaxIterator(c, c.Min))
 			}
 			sit = newChunkSeriesIterator(its)
 		case storepb.Aggr_MAX:
 			for _, c := range s.chunks {
-				its = append(its, getFirstIterator(c.Max, c.Raw))
+				its = append(its, getMinMaxIterator(c, c.Max))
 			}
 			sit = newChunkSeriesIterator(its)
 		case storepb.Aggr_COUNTER:
@@ -170,6 +170,15 @@ func (s *chunkSeries) Iterator(_ chunkenc.Iterator) chunkenc.Iterator {
 	return dedup.NewBoundedSeriesIterator(sit, s.mint, s.maxt)
 }
 
+// getMinMaxIterator returns the iterator of the min or max aggregate of the chunk, or of the average of downsampled
+// native histograms, which have no min and max aggregates.
+func getMinMaxIterator(c storepb.AggrChunk, minOrMax *storepb.Chunk) chunkenc.Iterator {
+	if minOrMax == nil && c.Raw == nil && c.Sum != nil && c.Count != nil {
+		return downsample.NewAverageChunkIterator(getFirstIterator(c.Count), getFirstIterator(c.Sum))
+	}
+	return getFirstIterator(minOrMax, c.Raw)
+}
+
 func getFirstIterator(cs ...*storepb.Chunk) chunkenc.Iterator {
 	for _, c := range cs {
 		if c == nil {
@@ -18,6 +18,7 @@ import (
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/util/annotations"
 
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/dedup"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	"github.com/thanos-io/thanos/pkg/gate"
@@ -71,6 +72,8 @@ type QueryableCreator func(
 
 // NewQueryableCreator creates QueryableCreator.
 // NOTE(bwplotka): Proxy assumes to be replica_aware, see thanos.store.info.StoreInfo.replica_aware field.
+// When downsampleOnRead is enabled, the raw data returned for selections allowing 5m downsampled data is downsampled
+// by the querier if it is older than the downsampling delay, as the compactor lags behind.
 func NewQueryableCreator(
 	logger log.Logger,
 	reg prometheus.Registerer,
@@ -78,6 +81,7 @@ func NewQueryableCreator(
 	maxConcurrentSelects int,
 	selectTimeout time.Duration,
 	deduplicationFunc string,
+	downsampleOnRead bool,
 ) QueryableCreator {
 	gf := gate.NewGateFactory(extprom.WrapRegistererWithPrefix("concurrent_selects_", reg), maxConcurrentSelects, gate.Selects)
 
@@ -108,6 +112,7 @@ func NewQueryableCreator(
 			selectTimeout:        selectTimeout,
 			shardInfo:            shardInfo,
 			seriesStatsReporter:  seriesStatsReporter,
+			downsampleOnRead:     downsampleOnRead,
 		}
 	}
 }
@@ -127,11 +132,12 @@ type queryable struct {
 	selectTimeout        time.Duration
 	shardInfo            *storepb.ShardInfo
 	seriesStatsReporter  seriesStatsReporter
+	downsampleOnRead     bool
 }
 
 // Querier returns a new storage querier against the underlying proxy store API.
 func (q *queryable) Querier(mint, maxt int64) (storage.Querier, error) {
-	return newQuerier(q.logger, mint, maxt, q.deduplicationFunc, q.replicaLabels, q.storeDebugMatchers, q.proxy, q.deduplicate, q.maxResolutionMillis, q.partialResponse, q.skipChunks, q.gateProviderFn(), q.selectTimeout, q.shardInfo, q.seriesStatsReporter), nil
+	return newQuerier(q.logger, mint, maxt, q.deduplicationFunc, q.replicaLabels, q.storeDebugMatchers, q.proxy, q.deduplicate, q.maxResolutionMillis, q.partialResponse, q.skipChunks, q.gateProviderFn(), q.selectTimeout, q.shardInfo, q.seriesStatsReporter, q.downsampleOnRead), nil
 }
 
 type querier struct {
@@ -149,6 +155,7 @@ type querier struct {
 	selectTimeout           time.Duration
 	shardInfo               *storepb.ShardInfo
 	seriesStatsReporter     seriesStatsReporter
+	downsampleOnRead        bool
 }
 
 // newQuerier creates implementation of storage.Querier that fetches data from the proxy
@@ -169,6 +176,7 @@ func newQuerier(
 	selectTimeout time.Duration,
 	shardInfo *storepb.ShardInfo,
 	seriesStatsReporter seriesStatsReporter,
+	downsampleOnRead bool,
 ) *querier {
 	if logger == nil {
 		logger = log.NewNopLogger()
@@ -199,6 +207,7 @@ func newQuerier(
 		skipChunks:              skipChunks,
 		shardInfo:               shardInfo,
 		seriesStatsReporter:     seriesStatsReporter,
+		downsampleOnRead:        downsampleOnRead,
 	}
 }
 
@@ -214,6 +223,16 @@ type seriesServer struct {
 	seriesSet      []storepb.Series
 	seriesSetStats storepb.SeriesStatsCounter
 	warnings       annotations.Annotations
+
+	// downsampleBefore is the time before which raw chunks are downsampled to the aggregates, if not zero.
+	downsampleBefore int64
+	aggrs            []storepb.Aggr
+	downsampled      bool
+
+	// rawHistogramsBefore is the time before which raw native histogram chunks are warned about, if not zero.
+	rawHistogramsBefore int64
+	rawHistograms       bool
+	averagedHistograms  bool
 }
 
 func (s *seriesServer) Send(r *storepb.SeriesResponse) error {
@@ -223,8 +242,28 @@ func (s *seriesServer) Send(r *storepb.SeriesResponse) error {
 	}
 
 	if r.GetSeries() != nil {
-		s.seriesSet = append(s.seriesSet, *r.GetSeries())
 		s.seriesSetStats.Count(r.GetSeries())
+		series := *r.GetSeries()
+		if s.downsampleBefore != 0 {
+			ok, err := downsampleRawChunks(&series, s.downsampleBefore, s.aggrs)
+			if err != nil {
+				return errors.Wrap(err, "downsample raw chunks")
+			}
+			if ok && !s.downsampled {
+				s.downsampled = true
+				s.warnings.Add(errors.New(downsampleOnReadWarning))
+			}
+		}
+		raw, averaged := nativeHistogramFallbacks(&series, s.rawHistogramsBefore, s.aggrs)
+		if raw && !s.rawHistograms {
+			s.rawHistograms = true
+			s.warnings.Add(errors.New(rawNativeHistogramsWarning))
+		}
+		if averaged && !s.averagedHistograms {
+			s.averagedHistograms = true
+			s.warnings.Add(errors.New(nativeHistogramsMinMaxWarning))
+		}
+		s.seriesSet = append(s.seriesSet, series)
 		return nil
 	}
 
@@ -259,6 +298,25 @@ func aggrsFromFunc(f string) []storepb.Aggr {
 	return []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM}
 }
 
+// storeHintsFromPromHints returns the query hints sent to the stores for the PromQL hints of a series selection, so
+// that stores can push down the function of the selection, like picking the aggregates of downsampled data.
+func storeHintsFromPromHints(hints *storage.SelectHints) *storepb.QueryHints {
+	if hints.Func == "" {
+		return nil
+	}
+	queryHints := &storepb.QueryHints{
+		StepMillis: hints.Step,
+		Func:       &storepb.Func{Name: hints.Func},
+	}
+	if hints.Range > 0 {
+		queryHints.Range = &storepb.Range{Millis: hints.Range}
+	}
+	if len(hints.Grouping) > 0 {
+		queryHints.Grouping = &storepb.Grouping{By: hints.By, Labels: hints.Grouping}
+	}
+	return queryHints
+}
+
 func (q *querier) Select(ctx context.Context, _ bool, hints *storage.SelectHints, ms ...*labels.Matcher) storage.SeriesSet {
 	if hints == nil {
 		hints = &storage.SelectHints{
@@ -279,11 +337,13 @@ func (q *querier) Select(ctx context.Context, _ bool, hints *storage.SelectHints
 		matchers[i] = m.String()
 	}
 	tenant := ctx.Value(tenancy.TenantKey)
+	parentCtx := ctx
 	// The context gets canceled as soon as query evaluation is completed by the engine.
 	// We want to prevent this from happening for the async store API calls we make while preserving tracing context.
 	// TODO(bwplotka): Does the above still is true? It feels weird to leave unfinished calls behind query API.
 	ctx = tracing.CopyTraceContext(context.Background(), ctx)
 	ctx = context.WithValue(ctx, tenancy.TenantKey, tenant)
+	ctx = context.WithValue(ctx, store.SeriesStatsCollectorKey, store.SeriesStatsCollectorFromContext(parentCtx))
 	ctx, cancel := context.WithTimeout(ctx, q.selectTimeout)
 	span, ctx := tracing.StartSpan(ctx, "querier_select", opentracing.Tags{
 		"minTime":  hints.Start,
@@ -346,7 +406,13 @@ func (q *querier) selectFn(ctx context.Context, hints *storage.SelectHints, ms .
 	// TODO(bwplotka): Use inprocess gRPC when we want to stream responses.
 	// Currently streaming won't help due to nature of the both PromQL engine which
 	// pulls all series before computations anyway.
-	resp := &seriesServer{ctx: ctx}
+	resp := &seriesServer{ctx: ctx, aggrs: aggrs}
+	if maxResolutionMillis >= downsample.ResLevel1 && !q.skipChunks {
+		resp.rawHistogramsBefore = time.Now().UnixMilli() - downsample.ResLevel1DownsampleRange
+		if q.downsampleOnRead {
+			resp.downsampleBefore = resp.rawHistogramsBefore
+		}
+	}
 	req := storepb.SeriesRequest{
 		MinTime:                 hints.Start,
 		MaxTime:                 hints.End,
@@ -357,6 +423,7 @@ func (q *querier) selectFn(ctx context.Context, hints *storage.SelectHints, ms .
 		ShardInfo:               q.shardInfo,
 		PartialResponseStrategy: q.partialResponseStrategy,
 		SkipChunks:              q.skipChunks,
+		QueryHints:              storeHintsFromPromHints(hints),
 	}
 	if q.isDedupEnabled() {
 		// Soft ask to sort without replica labels and push them at the end of labelset.
@@ -389,7 +456,32 @@ func (q *querier) selectFn(ctx context.Context, hints *storage.SelectHints, ms .
 		warns,
 	)
 
-	return dedup.NewSeriesSet(set, hints.Func, q.deduplicationFunc), resp.seriesSetStats, nil
+	dedupSet := dedup.NewSeriesSet(set, hints.Func, q.deduplicationFunc)
+	if c := store.SeriesStatsCollectorFromContext(ctx); c != nil {
+		dedupSet = &dedupStatsSeriesSet{SeriesSet: dedupSet, c: c}
+	}
+	return dedupSet, resp.seriesSetStats, nil
+}
+
+// dedupStatsSeriesSet counts the series of the deduplicated series set, reporting them to the collector once the set
+// is exhausted.
+type dedupStatsSeriesSet struct {
+	storage.SeriesSet
+	c      *store.SeriesStatsCollector
+	series int
+	done   bool
+}
+
+func (s *dedupStatsSeriesSet) Next() bool {
+	if s.SeriesSet.Next() {
+		s.series++
+		return true
+	}
+	if !s.done {
+		s.done = true
+		s.c.AddDeduplicatedSeries(s.series)
+	}
+	return false
 }
 
 // LabelValues returns all potential values for a label name.
@@ -50,7 +50,7 @@ func TestQueryableCreator_MaxResolution(t *testing.T) {
 	t.Parallel()
 
 	testProxy := &testStoreServer{resps: []*storepb.SeriesResponse{}}
-	queryableCreator := NewQueryableCreator(nil, nil, newProxyStore(testProxy), 2, 5*time.Second, dedup.AlgorithmPenalty)
+	queryableCreator := NewQueryableCreator(nil, nil, newProxyStore(testProxy), 2, 5*time.Second, dedup.AlgorithmPenalty, false)
 
 	oneHourMillis := int64(1*time.Hour) / int64(time.Millisecond)
 	queryable := queryableCreator(
@@ -98,6 +98,7 @@ func TestQuerier_DownsampledData(t *testing.T) {
 		2,
 		timeout,
 		dedup.AlgorithmPenalty,
+		false,
 	)(false,
 		nil,
 		nil,
@@ -173,6 +174,143 @@ func TestQuerier_DownsampledData(t *testing.T) {
 	}
 }
 
+func TestQuerier_DownsampleOnRead(t *testing.T) {
+	t.Parallel()
+
+	// A sample per minute for 20 minutes, in two chunks, from two stores overlapping in the first chunk.
+	var first, second []sample
+	for i := 0; i < 20; i++ {
+		if i < 10 {
+			first = append(first, sample{t: int64(i) * time.Minute.Milliseconds(), v: float64(i)})
+			continue
+		}
+		second = append(second, sample{t: int64(i) * time.Minute.Milliseconds(), v: float64(i)})
+	}
+	testProxy := &testStoreServer{
+		resps: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("__name__", "a"), first, second),
+			storeSeriesResponse(t, labels.FromStrings("__name__", "a"), first),
+		},
+	}
+	hints := &storage.SelectHints{Start: 0, End: 20 * time.Minute.Milliseconds(), Func: "max_over_time", Range: 10 * time.Minute.Milliseconds()}
+
+	for _, tcase := range []struct {
+		name                string
+		downsampleOnRead    bool
+		maxResolutionMillis int64
+		expected            []sample
+	}{
+		{
+			name:                "disabled",
+			maxResolutionMillis: time.Hour.Milliseconds(),
+			expected:            append(append([]sample{}, first...), second...),
+		},
+		{
+			name:                "raw data requested",
+			downsampleOnRead:    true,
+			maxResolutionMillis: time.Minute.Milliseconds(),
+			expected:            append(append([]sample{}, first...), second...),
+		},
+		{
+			name:                "enabled",
+			downsampleOnRead:    true,
+			maxResolutionMillis: time.Hour.Milliseconds(),
+			expected:            []sample{{t: 299999, v: 4}, {t: 599999, v: 9}, {t: 899999, v: 14}, {t: 1140000, v: 19}},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			q := newQuerier(nil, hints.Start, hints.End, dedup.AlgorithmPenalty, nil, nil, newProxyStore(testProxy), false, tcase.maxResolutionMillis, false, false, gate.New(1), 10*time.Second, nil, NoopSeriesStatsReporter, tcase.downsampleOnRead)
+			t.Cleanup(func() { testutil.Ok(t, q.Close()) })
+
+			res := q.Select(context.Background(), false, hints, labels.MustNewMatcher(labels.MatchEqual, "__name__", "a"))
+			testutil.Assert(t, res.Next(), "expected a series")
+			testutil.Equals(t, tcase.expected, expandSeries(t, res.At().Iterator(nil)))
+			testutil.Assert(t, !res.Next(), "expected a single series")
+			testutil.Ok(t, res.Err())
+
+			if tcase.name == "enabled" {
+				warns, _ := res.Warnings().AsStrings("", 0, 0)
+				testutil.Equals(t, []string{downsampleOnReadWarning}, warns)
+			} else {
+				testutil.Equals(t, 0, len(res.Warnings()))
+			}
+		})
+	}
+}
+
+func TestQuerier_NativeHistogramFallbacks(t *testing.T) {
+	t.Parallel()
+
+	fhChunk := func(ts ...int64) []byte {
+		c := chunkenc.NewFloatHistogramChunk()
+		app, err := c.Appender()
+		testutil.Ok(t, err)
+		for _, t0 := range ts {
+			_, _, app, err = app.AppendFloatHistogram(nil, t0, &histogram.FloatHistogram{Count: 4, Sum: 8}, true)
+			testutil.Ok(t, err)
+		}
+		return c.Bytes()
+	}
+	xorChunk := func(t0 int64, v float64) []byte {
+		c := chunkenc.NewXORChunk()
+		app, err := c.Appender()
+		testutil.Ok(t, err)
+		app.Append(t0, v)
+		return c.Bytes()
+	}
+	series := func(name string, chks ...storepb.AggrChunk) *storepb.SeriesResponse {
+		return storepb.NewSeriesResponse(&storepb.Series{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", name)), Chunks: chks})
+	}
+	testProxy := &testStoreServer{
+		resps: []*storepb.SeriesResponse{
+			// Downsampled native histograms, without min and max aggregates.
+			series("a", storepb.AggrChunk{
+				MinTime: 299999,
+				MaxTime: 299999,
+				Count:   &storepb.Chunk{Type: storepb.Chunk_XOR, Data: xorChunk(299999, 2)},
+				Sum:     &storepb.Chunk{Type: storepb.Chunk_FLOAT_HISTOGRAM, Data: fhChunk(299999)},
+			}),
+			// Raw native histograms.
+			series("b", storepb.AggrChunk{
+				MinTime: 0,
+				MaxTime: 60000,
+				Raw:     &storepb.Chunk{Type: storepb.Chunk_FLOAT_HISTOGRAM, Data: fhChunk(0, 60000)},
+			}),
+		},
+	}
+	hints := &storage.SelectHints{Start: 0, End: 10 * time.Minute.Milliseconds(), Func: "max_over_time", Range: 10 * time.Minute.Milliseconds()}
+
+	q := newQuerier(nil, hints.Start, hints.End, dedup.AlgorithmPenalty, nil, nil, newProxyStore(testProxy), false, time.Hour.Milliseconds(), false, false, gate.New(1), 10*time.Second, nil, NoopSeriesStatsReporter, false)
+	t.Cleanup(func() { testutil.Ok(t, q.Close()) })
+
+	res := q.Select(context.Background(), false, hints, labels.MustNewMatcher(labels.MatchRegexp, "__name__", "a|b"))
+	testutil.Assert(t, res.Next(), "expected a series")
+	it := res.At().Iterator(nil)
+	testutil.Equals(t, chunkenc.ValFloatHistogram, it.Next())
+	_, fh := it.AtFloatHistogram(nil)
+	testutil.Equals(t, &histogram.FloatHistogram{Count: 2, Sum: 4}, fh)
+	testutil.Equals(t, chunkenc.ValNone, it.Next())
+	testutil.Ok(t, it.Err())
+	testutil.Assert(t, res.Next(), "expected a second series")
+	testutil.Assert(t, !res.Next(), "expected two series")
+	testutil.Ok(t, res.Err())
+
+	warns, _ := res.Warnings().AsStrings("", 0, 0)
+	sort.Strings(warns)
+	testutil.Equals(t, []string{nativeHistogramsMinMaxWarning, rawNativeHistogramsWarning}, warns)
+
+	// Raw data is not warned about when requested.
+	hints.Func = "rate"
+	q = newQuerier(nil, hints.Start, hints.End, dedup.AlgorithmPenalty, nil, nil, newProxyStore(testProxy), false, 0, false, false, gate.New(1), 10*time.Second, nil, NoopSeriesStatsReporter, false)
+	t.Cleanup(func() { testutil.Ok(t, q.Close()) })
+	res = q.Select(context.Background(), false, hints, labels.MustNewMatcher(labels.MatchRegexp, "__name__", "a|b"))
+	for res.Next() {
+	}
+	testutil.Ok(t, res.Err())
+	warns, _ = res.Warnings().AsStrings("", 0, 0)
+	testutil.Equals(t, 0, len(warns))
+}
+
 var (
 	realSeriesWithStaleMarkerMint             int64 = 1587690000000 // 04/24/2020 01:00:00 GMT.
 	realSeriesWithStaleMarkerMaxt             int64 = 1587693600000 // 04/24/2020 02:00:00 GMT.
@@ -403,7 +541,7 @@ func TestQuerier_Select_AfterPromQL(t *testing.T) {
 						g := gate.New(2)
 						mq := &mockedQueryable{
 							Creator: func(mint, maxt int64) storage.Querier {
-								return newQuerier(nil, mint, maxt, dedup.AlgorithmPenalty, tcase.replicaLabels, nil, tcase.storeAPI, sc.dedup, 0, true, false, g, timeout, nil, NoopSeriesStatsReporter)
+								return newQuerier(nil, mint, maxt, dedup.AlgorithmPenalty, tcase.replicaLabels, nil, tcase.storeAPI, sc.dedup, 0, true, false, g, timeout, nil, NoopSeriesStatsReporter, false)
 							},
 						}
 						t.Cleanup(func() {
@@ -799,6 +937,7 @@ func TestQuerier_Select(t *testing.T) {
 					timeout,
 					nil,
 					NoopSeriesStatsReporter,
+					false,
 				)
 				t.Cleanup(func() { testutil.Ok(t, q.Close()) })
 
@@ -1090,7 +1229,7 @@ func TestQuerierWithDedupUnderstoodByPromQL_Rate(t *testing.T) {
 
 		timeout := 100 * time.Second
 		g := gate.New(2)
-		q := newQuerier(logger, realSeriesWithStaleMarkerMint, realSeriesWithStaleMarkerMaxt, dedup.AlgorithmPenalty, []string{"replica"}, nil, newProxyStore(s), false, 0, true, false, g, timeout, nil, NoopSeriesStatsReporter)
+		q := newQuerier(logger, realSeriesWithStaleMarkerMint, realSeriesWithStaleMarkerMaxt, dedup.AlgorithmPenalty, []string{"replica"}, nil, newProxyStore(s), false, 0, true, false, g, timeout, nil, NoopSeriesStatsReporter, false)
 		t.Cleanup(func() {
 			testutil.Ok(t, q.Close())
 		})
@@ -1160,7 +1299,7 @@ func TestQuerierWithDedupUnderstoodByPromQL_Rate(t *testing.T) {
 
 		timeout := 5 * time.Second
 		g := gate.New(2)
-		q := newQuerier(logger, realSeriesWithStaleMarkerMint, realSeriesWithStaleMarkerMaxt, dedup.AlgorithmPenalty, []string{"replica"}, nil, newProxyStore(s), true, 0, true, false, g, timeout, nil, NoopSeriesStatsReporter)
+		q := newQuerier(logger, realSeriesWithStaleMarkerMint, realSeriesWithStaleMarkerMaxt, dedup.AlgorithmPenalty, []string{"replica"}, nil, newProxyStore(s), true, 0, true, false, g, timeout, nil, NoopSeriesStatsReporter, false)
 		t.Cleanup(func() {
 			testutil.Ok(t, q.Close())
 		})
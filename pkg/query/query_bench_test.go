@@ -102,6 +102,7 @@ func benchQuerySelect(t testutil.TB, totalSamples, totalSeries int, dedup bool)
 		10*time.Second,
 		nil,
 		NoopSeriesStatsReporter,
+		false,
 	)
 	testSelect(t, q, expectedSeries)
 }
@@ -75,6 +75,7 @@ func TestQuerier_Proxy(t *testing.T) {
 			1000000,
 			5*time.Minute,
 			dedup.AlgorithmPenalty,
+			false,
 		)
 
 		createQueryableFn := func(stores []*testStore) storage.Queryable {
@@ -0,0 +1,525 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package queryexport runs PromQL range queries asynchronously as export jobs, writing their results to a bucket to
+// be downloaded once finished. It is meant for large extracts that would time out as synchronous queries.
+package queryexport
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// Status is the status of an export job.
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// Format is the format of the result of an export job.
+type Format string
+
+// FormatCSV writes a row of the series, the timestamp in seconds and the value of every sample.
+const FormatCSV Format = "csv"
+
+const (
+	jobFilename    = "job.json"
+	resultFilename = "result"
+
+	// jobHeartbeatInterval is the interval at which the queued and running jobs are written to the bucket again.
+	jobHeartbeatInterval = time.Minute
+	// jobLostHeartbeats is the number of heartbeats missed by queued or running jobs after which they are reported as
+	// failed, e.g. as their querier restarted.
+	jobLostHeartbeats = 5
+)
+
+var (
+	// ErrJobNotFound is returned for jobs that do not exist, or of another tenant.
+	ErrJobNotFound = errors.New("export job not found")
+	// ErrJobNotSucceeded is returned for the results of jobs that did not succeed, or not yet.
+	ErrJobNotSucceeded = errors.New("export job has not succeeded")
+	// ErrTooManyJobs is returned when the queue of the jobs is full.
+	ErrTooManyJobs = errors.New("too many export jobs queued")
+)
+
+// Request is the range query of an export job.
+type Request struct {
+	Tenant string
+	Query  string
+	Start  time.Time
+	End    time.Time
+	Step   time.Duration
+	Format Format
+}
+
+// Validate returns an error if the request cannot be run as an export job.
+func (r Request) Validate() error {
+	if r.Format != "" && r.Format != FormatCSV {
+		return errors.Errorf("unsupported export format %q", r.Format)
+	}
+	if r.Step <= 0 {
+		return errors.New("step has to be positive")
+	}
+	if r.End.Before(r.Start) {
+		return errors.New("end timestamp must not be before start time")
+	}
+	return nil
+}
+
+// Job is an export job.
+type Job struct {
+	ID          string     `json:"id"`
+	Tenant      string     `json:"tenant"`
+	Query       string     `json:"query"`
+	Start       time.Time  `json:"start"`
+	End         time.Time  `json:"end"`
+	StepSeconds float64    `json:"stepSeconds"`
+	Format      Format     `json:"format"`
+	Status      Status     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	SubmittedAt time.Time  `json:"submittedAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+	// UpdatedAt is the last time the job was written to the bucket, at least every heartbeat until it finishes.
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Series is the number of series of the result, counted once per query of the split range.
+	Series  int `json:"series"`
+	Samples int `json:"samples"`
+}
+
+func (j Job) step() time.Duration { return time.Duration(j.StepSeconds * float64(time.Second)) }
+
+// QueryFunc evaluates the range query for the tenant, and calls f with its result. The result is only valid during
+// the call.
+type QueryFunc func(ctx context.Context, tenant, query string, start, end time.Time, step time.Duration, f func(promql.Matrix) error) error
+
+// Options are the resource limits of the export jobs.
+type Options struct {
+	// MaxConcurrent is the number of jobs run concurrently.
+	MaxConcurrent int
+	// MaxQueued is the number of jobs waiting to be run above which new jobs are rejected. With zero, jobs are only
+	// accepted if they can be run right away.
+	MaxQueued int
+	// Timeout is the maximum duration of a job.
+	Timeout time.Duration
+	// SplitInterval splits the range of the jobs into queries of at most this interval, so every query is bounded.
+	// Zero does not split the range.
+	SplitInterval time.Duration
+	// MaxSamples is the maximum number of samples of the result of a job. Zero is no limit.
+	MaxSamples int
+}
+
+// Manager queues and runs the export jobs, and serves their status and results.
+type Manager struct {
+	logger log.Logger
+	bkt    objstore.Bucket
+	query  QueryFunc
+	opts   Options
+	now    func() time.Time
+
+	queue     chan *Job
+	heartbeat time.Duration
+
+	mtx sync.Mutex
+	// jobs are the jobs queued or run by the manager until their final status is written to the bucket.
+	jobs map[string]*Job
+	// writeMtx orders the writes of the jobs, so that a heartbeat never overwrites the final status of a job.
+	writeMtx sync.Mutex
+
+	jobsActive   *prometheus.GaugeVec
+	jobsFinished *prometheus.CounterVec
+}
+
+// NewManager returns a manager of the export jobs evaluating the queries with the query function, and writing the
+// jobs and their results to the bucket, so that every manager of the bucket serves them. The writing of the results
+// is not retried, so the bucket should be configured with retries.
+func NewManager(logger log.Logger, reg prometheus.Registerer, bkt objstore.Bucket, query QueryFunc, opts Options) *Manager {
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = 1
+	}
+	m := &Manager{
+		logger:    logger,
+		bkt:       bkt,
+		query:     query,
+		opts:      opts,
+		now:       time.Now,
+		queue:     make(chan *Job, max(opts.MaxQueued, 0)),
+		heartbeat: jobHeartbeatInterval,
+		jobs:      map[string]*Job{},
+		jobsActive: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_query_export_jobs",
+			Help: "The number of query export jobs queued or running, by status.",
+		}, []string{"status"}),
+		jobsFinished: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_query_export_jobs_finished_total",
+			Help: "The number of query export jobs finished, by status.",
+		}, []string{"status"}),
+	}
+	for _, s := range []Status{StatusQueued, StatusRunning} {
+		m.jobsActive.WithLabelValues(string(s))
+	}
+	for _, s := range []Status{StatusSucceeded, StatusFailed} {
+		m.jobsFinished.WithLabelValues(string(s))
+	}
+	return m
+}
+
+// Submit writes the export job of the request to the bucket and queues it, returning it with its ID.
+func (m *Manager) Submit(ctx context.Context, req Request) (Job, error) {
+	if err := req.Validate(); err != nil {
+		return Job{}, err
+	}
+	if req.Format == "" {
+		req.Format = FormatCSV
+	}
+
+	now := m.now()
+	job := &Job{
+		ID:          ulid.MustNew(ulid.Timestamp(now), ulid.DefaultEntropy()).String(),
+		Tenant:      req.Tenant,
+		Query:       req.Query,
+		Start:       req.Start,
+		End:         req.End,
+		StepSeconds: req.Step.Seconds(),
+		Format:      req.Format,
+		Status:      StatusQueued,
+		SubmittedAt: now,
+		UpdatedAt:   now,
+	}
+	if err := m.writeJob(ctx, *job); err != nil {
+		return Job{}, err
+	}
+
+	queued := *job
+	m.mtx.Lock()
+	select {
+	case m.queue <- job:
+		m.jobs[job.ID] = job
+		m.jobsActive.WithLabelValues(string(StatusQueued)).Inc()
+		m.mtx.Unlock()
+	default:
+		m.mtx.Unlock()
+		// Best effort cleanup of the rejected job.
+		_ = m.bkt.Delete(context.WithoutCancel(ctx), path.Join(job.ID, jobFilename))
+		return Job{}, ErrTooManyJobs
+	}
+	return queued, nil
+}
+
+// Run runs the queued jobs, and writes them to the bucket every heartbeat, until the context is canceled. Jobs still
+// queued then are written as failed.
+func (m *Manager) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(m.heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.writeJobs(ctx)
+			}
+		}
+	}()
+	for i := 0; i < m.opts.MaxConcurrent; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-m.queue:
+					m.run(ctx, job)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	m.mtx.Lock()
+	for _, job := range m.jobs {
+		if job.Status == StatusQueued {
+			job.Status = StatusFailed
+			job.Error = "the querier stopped before running the export job"
+			m.jobsActive.WithLabelValues(string(StatusQueued)).Dec()
+			m.jobsFinished.WithLabelValues(string(StatusFailed)).Inc()
+		}
+	}
+	m.mtx.Unlock()
+	m.writeJobs(context.WithoutCancel(ctx))
+	return nil
+}
+
+// writeJobs writes the jobs of the manager to the bucket.
+func (m *Manager) writeJobs(ctx context.Context) {
+	m.mtx.Lock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	m.mtx.Unlock()
+
+	for _, job := range jobs {
+		if err := m.persist(ctx, job); err != nil {
+			level.Warn(m.logger).Log("msg", "failed to write query export job", "id", job.ID, "err", err)
+		}
+	}
+}
+
+// persist writes the job of the manager to the bucket, and forgets it once its final status is written.
+func (m *Manager) persist(ctx context.Context, job *Job) error {
+	m.writeMtx.Lock()
+	defer m.writeMtx.Unlock()
+
+	m.mtx.Lock()
+	if _, ok := m.jobs[job.ID]; !ok {
+		m.mtx.Unlock()
+		return nil
+	}
+	job.UpdatedAt = m.now()
+	j := *job
+	m.mtx.Unlock()
+
+	if err := m.writeJob(ctx, j); err != nil {
+		return err
+	}
+	if j.Status == StatusSucceeded || j.Status == StatusFailed {
+		m.mtx.Lock()
+		delete(m.jobs, j.ID)
+		m.mtx.Unlock()
+	}
+	return nil
+}
+
+func (m *Manager) writeJob(ctx context.Context, job Job) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return errors.Wrapf(err, "encode export job %s", job.ID)
+	}
+	if err := m.bkt.Upload(ctx, path.Join(job.ID, jobFilename), bytes.NewReader(b)); err != nil {
+		return errors.Wrapf(err, "write export job %s", job.ID)
+	}
+	return nil
+}
+
+// Job returns the job of the tenant, queued, running or finished. Queued and running jobs of other managers which
+// were not written for jobLostHeartbeats heartbeats are returned as failed.
+func (m *Manager) Job(ctx context.Context, tenant, id string) (Job, error) {
+	m.mtx.Lock()
+	job, ok := m.jobs[id]
+	if ok {
+		j := *job
+		m.mtx.Unlock()
+		if j.Tenant != tenant {
+			return Job{}, ErrJobNotFound
+		}
+		return j, nil
+	}
+	m.mtx.Unlock()
+
+	if _, err := ulid.Parse(id); err != nil {
+		return Job{}, ErrJobNotFound
+	}
+	r, err := m.bkt.Get(ctx, path.Join(id, jobFilename))
+	if m.bkt.IsObjNotFoundErr(err) {
+		return Job{}, ErrJobNotFound
+	}
+	if err != nil {
+		return Job{}, errors.Wrapf(err, "get export job %s", id)
+	}
+	defer runutil.CloseWithLogOnErr(m.logger, r, "export job reader")
+
+	var j Job
+	if err := json.NewDecoder(r).Decode(&j); err != nil {
+		return Job{}, errors.Wrapf(err, "decode export job %s", id)
+	}
+	if j.Tenant != tenant {
+		return Job{}, ErrJobNotFound
+	}
+	if (j.Status == StatusQueued || j.Status == StatusRunning) && m.now().Sub(j.UpdatedAt) > jobLostHeartbeats*m.heartbeat {
+		j.Status = StatusFailed
+		j.Error = "the export job was lost, e.g. as the querier running it restarted"
+	}
+	return j, nil
+}
+
+// Result returns the job of the tenant, and a reader of its result if it succeeded.
+func (m *Manager) Result(ctx context.Context, tenant, id string) (Job, io.ReadCloser, error) {
+	job, err := m.Job(ctx, tenant, id)
+	if err != nil {
+		return Job{}, nil, err
+	}
+	if job.Status != StatusSucceeded {
+		return job, nil, ErrJobNotSucceeded
+	}
+	r, err := m.bkt.Get(ctx, resultName(job))
+	if err != nil {
+		return job, nil, errors.Wrapf(err, "get export job result %s", id)
+	}
+	return job, r, nil
+}
+
+func resultName(job Job) string {
+	return path.Join(job.ID, resultFilename+"."+string(job.Format))
+}
+
+// run runs the job, writes its result and then the job itself to the bucket, and forgets it.
+func (m *Manager) run(ctx context.Context, job *Job) {
+	started := m.now()
+	m.update(job, func(j *Job) {
+		j.Status = StatusRunning
+		j.StartedAt = &started
+	})
+	if err := m.persist(ctx, job); err != nil {
+		level.Warn(m.logger).Log("msg", "failed to write query export job", "id", job.ID, "err", err)
+	}
+	m.jobsActive.WithLabelValues(string(StatusQueued)).Dec()
+	m.jobsActive.WithLabelValues(string(StatusRunning)).Inc()
+	defer m.jobsActive.WithLabelValues(string(StatusRunning)).Dec()
+
+	jobCtx := ctx
+	if m.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		jobCtx, cancel = context.WithTimeout(ctx, m.opts.Timeout)
+		defer cancel()
+	}
+
+	m.mtx.Lock()
+	j := *job
+	m.mtx.Unlock()
+
+	err := m.export(jobCtx, &j)
+	finished := m.now()
+	j.FinishedAt = &finished
+	j.Status = StatusSucceeded
+	if err != nil {
+		j.Status = StatusFailed
+		j.Error = err.Error()
+		level.Warn(m.logger).Log("msg", "query export job failed", "id", j.ID, "tenant", j.Tenant, "err", err)
+	} else {
+		level.Info(m.logger).Log("msg", "query export job succeeded", "id", j.ID, "tenant", j.Tenant, "series", j.Series, "samples", j.Samples, "duration", finished.Sub(started))
+	}
+	m.jobsFinished.WithLabelValues(string(j.Status)).Inc()
+
+	// The job is written even if the context is canceled, so its status is not lost, and by the next heartbeat if
+	// writing it fails.
+	m.update(job, func(jj *Job) { *jj = j })
+	if err := m.persist(context.WithoutCancel(ctx), job); err != nil {
+		level.Error(m.logger).Log("msg", "failed to write query export job", "id", j.ID, "err", err)
+	}
+}
+
+func (m *Manager) update(job *Job, f func(*Job)) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	f(job)
+}
+
+// export evaluates the query of the job over its range split by the split interval, and streams the result to the
+// bucket.
+func (m *Manager) export(ctx context.Context, job *Job) error {
+	name := resultName(*job)
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := m.bkt.Upload(ctx, name, pr)
+		// Unblocks the writer if the upload failed before reading everything.
+		_ = pr.CloseWithError(err)
+		done <- err
+	}()
+
+	err := m.write(ctx, job, pw)
+	_ = pw.CloseWithError(err)
+	if uploadErr := <-done; err == nil && uploadErr != nil {
+		err = errors.Wrap(uploadErr, "upload result")
+	}
+	if err != nil {
+		// Best effort cleanup of a partial result.
+		_ = m.bkt.Delete(context.WithoutCancel(ctx), name)
+	}
+	return err
+}
+
+func (m *Manager) write(ctx context.Context, job *Job, w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"series", "timestamp", "value"}); err != nil {
+		return err
+	}
+
+	step := job.step()
+	for start := job.Start; !start.After(job.End); {
+		end := job.End
+		if m.opts.SplitInterval > 0 {
+			// Splits on steps, so the timestamps of the split queries are the ones of the whole range.
+			steps := max(m.opts.SplitInterval/step, 1)
+			if e := start.Add((steps - 1) * step); e.Before(end) {
+				end = e
+			}
+		}
+
+		if err := m.query(ctx, job.Tenant, job.Query, start, end, step, func(matrix promql.Matrix) error {
+			return writeMatrix(cw, job, matrix, m.opts.MaxSamples)
+		}); err != nil {
+			return errors.Wrapf(err, "query range %s to %s", start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339))
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+		start = end.Add(step)
+	}
+	return nil
+}
+
+// writeMatrix writes the samples of the matrix to the CSV writer, counting them in the job.
+func writeMatrix(cw *csv.Writer, job *Job, matrix promql.Matrix, maxSamples int) error {
+	for _, s := range matrix {
+		job.Series++
+		job.Samples += len(s.Floats) + len(s.Histograms)
+		if maxSamples > 0 && job.Samples > maxSamples {
+			return errors.Errorf("the result exceeds the limit of %d samples", maxSamples)
+		}
+		series := s.Metric.String()
+		for _, p := range s.Floats {
+			if err := cw.Write([]string{series, formatTimestamp(p.T), strconv.FormatFloat(p.F, 'f', -1, 64)}); err != nil {
+				return err
+			}
+		}
+		for _, p := range s.Histograms {
+			if err := cw.Write([]string{series, formatTimestamp(p.T), p.H.String()}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func formatTimestamp(t int64) string {
+	return strconv.FormatFloat(float64(t)/1000, 'f', -1, 64)
+}
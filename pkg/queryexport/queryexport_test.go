@@ -0,0 +1,232 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryexport
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+type rangeQuery struct {
+	tenant     string
+	start, end time.Time
+}
+
+// seriesQueryFunc returns a query function returning a series with the value of every step as its timestamp in
+// seconds, recording the queries.
+func seriesQueryFunc(mtx *sync.Mutex, queries *[]rangeQuery) QueryFunc {
+	return func(_ context.Context, tenant, _ string, start, end time.Time, step time.Duration, f func(promql.Matrix) error) error {
+		mtx.Lock()
+		*queries = append(*queries, rangeQuery{tenant: tenant, start: start, end: end})
+		mtx.Unlock()
+
+		s := promql.Series{Metric: labels.FromStrings("__name__", "up", "job", "a")}
+		for t := start; !t.After(end); t = t.Add(step) {
+			s.Floats = append(s.Floats, promql.FPoint{T: t.UnixMilli(), F: float64(t.Unix())})
+		}
+		return f(promql.Matrix{s})
+	}
+}
+
+// bufferedBucket reads objects before uploading them, as the in-memory bucket is locked while reading them, e.g. the
+// results of running jobs.
+type bufferedBucket struct {
+	*objstore.InMemBucket
+}
+
+func (b bufferedBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return b.InMemBucket.Upload(ctx, name, bytes.NewReader(body))
+}
+
+func waitFinished(t *testing.T, m *Manager, tenant, id string) Job {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var job Job
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, ctx.Done(), func() (err error) {
+		job, err = m.Job(ctx, tenant, id)
+		if err == nil && job.Status != StatusSucceeded && job.Status != StatusFailed {
+			return errors.Errorf("job is %s", job.Status)
+		}
+		return err
+	}))
+	return job
+}
+
+func TestManager_Export(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mtx     sync.Mutex
+		queries []rangeQuery
+	)
+	bkt := objstore.NewInMemBucket()
+	m := NewManager(log.NewNopLogger(), nil, bkt, seriesQueryFunc(&mtx, &queries), Options{MaxQueued: 1, SplitInterval: 2 * time.Minute})
+
+	_, err := m.Submit(context.Background(), Request{Tenant: "a", Query: "up", Start: time.Unix(0, 0), End: time.Unix(240, 0), Step: time.Minute, Format: "parquet"})
+	testutil.NotOk(t, err)
+
+	job, err := m.Submit(context.Background(), Request{Tenant: "a", Query: "up", Start: time.Unix(0, 0), End: time.Unix(240, 0), Step: time.Minute})
+	testutil.Ok(t, err)
+	testutil.Equals(t, StatusQueued, job.Status)
+	testutil.Equals(t, FormatCSV, job.Format)
+
+	// The queue is full until the jobs are run.
+	_, err = m.Submit(context.Background(), Request{Tenant: "a", Query: "up", Start: time.Unix(0, 0), End: time.Unix(240, 0), Step: time.Minute})
+	testutil.Equals(t, ErrTooManyJobs, err)
+
+	_, err = m.Job(context.Background(), "b", job.ID)
+	testutil.Equals(t, ErrJobNotFound, err)
+	_, _, err = m.Result(context.Background(), "a", job.ID)
+	testutil.Equals(t, ErrJobNotSucceeded, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = m.Run(ctx) }()
+
+	job = waitFinished(t, m, "a", job.ID)
+	testutil.Equals(t, StatusSucceeded, job.Status)
+	testutil.Equals(t, 5, job.Samples)
+	testutil.Equals(t, 3, job.Series)
+
+	// The range is split on steps.
+	testutil.Equals(t, []rangeQuery{
+		{tenant: "a", start: time.Unix(0, 0), end: time.Unix(60, 0)},
+		{tenant: "a", start: time.Unix(120, 0), end: time.Unix(180, 0)},
+		{tenant: "a", start: time.Unix(240, 0), end: time.Unix(240, 0)},
+	}, queries)
+
+	_, r, err := m.Result(context.Background(), "a", job.ID)
+	testutil.Ok(t, err)
+	b, err := io.ReadAll(r)
+	testutil.Ok(t, err)
+	testutil.Ok(t, r.Close())
+	testutil.Equals(t, strings.Join([]string{
+		"series,timestamp,value",
+		`"{__name__=""up"", job=""a""}",0,0`,
+		`"{__name__=""up"", job=""a""}",60,60`,
+		`"{__name__=""up"", job=""a""}",120,120`,
+		`"{__name__=""up"", job=""a""}",180,180`,
+		`"{__name__=""up"", job=""a""}",240,240`,
+		"",
+	}, "\n"), string(b))
+
+	// Finished jobs are served from the bucket, e.g. by other queriers.
+	other := NewManager(log.NewNopLogger(), nil, bkt, nil, Options{})
+	got, err := other.Job(context.Background(), "a", job.ID)
+	testutil.Ok(t, err)
+	testutil.Equals(t, job.Samples, got.Samples)
+	_, err = other.Job(context.Background(), "b", job.ID)
+	testutil.Equals(t, ErrJobNotFound, err)
+	_, err = other.Job(context.Background(), "a", "../"+job.ID)
+	testutil.Equals(t, ErrJobNotFound, err)
+}
+
+func TestManager_ExportLimits(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mtx     sync.Mutex
+		queries []rangeQuery
+	)
+	bkt := objstore.NewInMemBucket()
+	m := NewManager(log.NewNopLogger(), nil, bkt, seriesQueryFunc(&mtx, &queries), Options{MaxQueued: 1, MaxSamples: 3})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = m.Run(ctx) }()
+
+	job, err := m.Submit(context.Background(), Request{Tenant: "a", Query: "up", Start: time.Unix(0, 0), End: time.Unix(240, 0), Step: time.Minute})
+	testutil.Ok(t, err)
+	job = waitFinished(t, m, "a", job.ID)
+	testutil.Equals(t, StatusFailed, job.Status)
+	testutil.Assert(t, strings.Contains(job.Error, "exceeds the limit of 3 samples"), job.Error)
+
+	// Partial results are removed.
+	testutil.Equals(t, 1, len(bkt.Objects()))
+	_, _, err = m.Result(context.Background(), "a", job.ID)
+	testutil.Equals(t, ErrJobNotSucceeded, err)
+}
+
+func TestManager_PersistedJobs(t *testing.T) {
+	t.Parallel()
+
+	bkt := bufferedBucket{objstore.NewInMemBucket()}
+	// Jobs run until the manager stops.
+	m := NewManager(log.NewNopLogger(), nil, bkt, func(ctx context.Context, _, _ string, _, _ time.Time, _ time.Duration, _ func(promql.Matrix) error) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, Options{MaxQueued: 1})
+	m.heartbeat = 10 * time.Millisecond
+	other := NewManager(log.NewNopLogger(), nil, bkt, nil, Options{})
+	other.heartbeat = m.heartbeat
+
+	// Queued jobs are served by other managers of the bucket.
+	job, err := m.Submit(context.Background(), Request{Tenant: "a", Query: "up", Start: time.Unix(0, 0), End: time.Unix(60, 0), Step: time.Minute})
+	testutil.Ok(t, err)
+	got, err := other.Job(context.Background(), "a", job.ID)
+	testutil.Ok(t, err)
+	testutil.Equals(t, StatusQueued, got.Status)
+
+	// Rejected jobs are not written.
+	_, err = m.Submit(context.Background(), Request{Tenant: "a", Query: "up", Start: time.Unix(0, 0), End: time.Unix(60, 0), Step: time.Minute})
+	testutil.Equals(t, ErrTooManyJobs, err)
+	testutil.Equals(t, 1, len(bkt.Objects()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = m.Run(ctx)
+	}()
+
+	// Running jobs are written every heartbeat, so they are not reported as lost.
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel2()
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, ctx2.Done(), func() error {
+		got, err = other.Job(ctx2, "a", job.ID)
+		if err == nil && (got.Status != StatusRunning || !got.UpdatedAt.After(*got.StartedAt)) {
+			return errors.Errorf("job is %s, updated at %s", got.Status, got.UpdatedAt)
+		}
+		return err
+	}))
+	other.now = func() time.Time { return got.UpdatedAt.Add(jobLostHeartbeats * other.heartbeat) }
+	got, err = other.Job(context.Background(), "a", job.ID)
+	testutil.Ok(t, err)
+	testutil.Equals(t, StatusRunning, got.Status)
+
+	// Jobs whose manager stopped writing them are reported as failed.
+	other.now = func() time.Time { return got.UpdatedAt.Add(jobLostHeartbeats*other.heartbeat + time.Second) }
+	got, err = other.Job(context.Background(), "a", job.ID)
+	testutil.Ok(t, err)
+	testutil.Equals(t, StatusFailed, got.Status)
+
+	// Jobs interrupted by the shutdown of their manager are written as failed.
+	cancel()
+	<-done
+	other.now = time.Now
+	got, err = other.Job(context.Background(), "a", job.ID)
+	testutil.Ok(t, err)
+	testutil.Equals(t, StatusFailed, got.Status)
+	testutil.Assert(t, strings.Contains(got.Error, "context canceled"), got.Error)
+}
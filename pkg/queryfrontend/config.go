@@ -201,6 +201,7 @@ type Config struct {
 	QueryRangeConfig
 	LabelsConfig
 	DownstreamTripperConfig
+	QueueConfig
 
 	CortexHandlerConfig    *transport.HandlerConfig
 	CompressResponses      bool
@@ -209,11 +210,15 @@ type Config struct {
 	DownstreamURL          string
 	ForwardHeaders         []string
 	NumShards              int
+	RetryBudgetRatio       float64
 	TenantHeader           string
 	DefaultTenant          string
 	TenantCertField        string
 	EnableXFunctions       bool
 	EnableFeatures         []string
+
+	// InstantSubquerySplitMinRange is the min range of the subqueries the instant queries are split for, 0 disables it.
+	InstantSubquerySplitMinRange time.Duration
 }
 
 // QueryRangeConfig holds the config for query range tripperware.
@@ -267,6 +272,14 @@ func (cfg *Config) Validate() error {
 		}
 	}
 
+	if cfg.RetryBudgetRatio < 0 {
+		return errors.New("retry budget ratio cannot be negative")
+	}
+
+	if cfg.QueueConfig.MaxConcurrent < 0 || cfg.QueueConfig.MaxOutstandingPerTenant < 0 {
+		return errors.New("queue max concurrent and max outstanding requests per tenant cannot be negative")
+	}
+
 	if cfg.isDynamicSplitSet() && cfg.isStaticSplitSet() {
 		return errors.New("split queries interval and dynamic query split interval cannot be set at the same time")
 	}
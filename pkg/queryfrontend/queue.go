@@ -0,0 +1,300 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+)
+
+// QueryPriority is the priority class of a query, deciding which queued downstream requests are sent first.
+type QueryPriority int
+
+const (
+	// PriorityInteractive is the priority of the queries of users waiting for their result, e.g. dashboards.
+	PriorityInteractive QueryPriority = iota
+	// PriorityBatch is the priority of the queries nobody is waiting for, e.g. reports, sent once no interactive
+	// request is queued.
+	PriorityBatch
+
+	numQueryPriorities
+)
+
+// DefaultQueryPriorityHeader is the default HTTP header the priority class of a query is read from.
+const DefaultQueryPriorityHeader = "X-Thanos-Query-Priority"
+
+func (p QueryPriority) String() string {
+	if p == PriorityBatch {
+		return "batch"
+	}
+	return "interactive"
+}
+
+// ParseQueryPriority parses the priority class of a query. Unknown or empty classes are interactive.
+func ParseQueryPriority(s string) QueryPriority {
+	if strings.EqualFold(strings.TrimSpace(s), PriorityBatch.String()) {
+		return PriorityBatch
+	}
+	return PriorityInteractive
+}
+
+type queryPriorityKey struct{}
+
+// ContextWithQueryPriority returns a context holding the priority class of the query, applied to all the downstream
+// requests of the query.
+func ContextWithQueryPriority(ctx context.Context, p QueryPriority) context.Context {
+	return context.WithValue(ctx, queryPriorityKey{}, p)
+}
+
+// QueryPriorityFromContext returns the priority class of the query of the context, interactive if none.
+func QueryPriorityFromContext(ctx context.Context) QueryPriority {
+	p, _ := ctx.Value(queryPriorityKey{}).(QueryPriority)
+	return p
+}
+
+// QueueConfig holds the config of the queue of the downstream requests.
+type QueueConfig struct {
+	// MaxConcurrent is the maximum number of downstream requests sent at the same time, 0 disables the queue.
+	MaxConcurrent int
+	// MaxOutstandingPerTenant is the maximum number of queued downstream requests of a tenant, 0 for no limit.
+	MaxOutstandingPerTenant int
+	// PriorityHeader is the HTTP header the priority class of the queries is read from.
+	PriorityHeader string
+}
+
+// queuedRequest is a downstream request waiting for its turn.
+type queuedRequest struct {
+	tenant   string
+	priority QueryPriority
+	ready    chan struct{}
+	// dispatched is true once the request left the queue and holds a slot.
+	dispatched bool
+}
+
+// tenantQueues holds the queued requests of one priority class, per tenant.
+type tenantQueues struct {
+	// tenants are the tenants with queued requests, in their round-robin order.
+	tenants []string
+	queues  map[string][]*queuedRequest
+}
+
+func (q *tenantQueues) push(r *queuedRequest) {
+	if len(q.queues[r.tenant]) == 0 {
+		q.tenants = append(q.tenants, r.tenant)
+	}
+	q.queues[r.tenant] = append(q.queues[r.tenant], r)
+}
+
+// pop returns the oldest request of the next tenant, which then moves to the back of the round-robin order.
+func (q *tenantQueues) pop() *queuedRequest {
+	if len(q.tenants) == 0 {
+		return nil
+	}
+	tenant := q.tenants[0]
+	q.tenants = q.tenants[1:]
+	r := q.queues[tenant][0]
+	q.queues[tenant] = q.queues[tenant][1:]
+	if len(q.queues[tenant]) == 0 {
+		delete(q.queues, tenant)
+	} else {
+		q.tenants = append(q.tenants, tenant)
+	}
+	return r
+}
+
+func (q *tenantQueues) remove(r *queuedRequest) {
+	queue := q.queues[r.tenant]
+	for i, queued := range queue {
+		if queued != r {
+			continue
+		}
+		queue = append(queue[:i], queue[i+1:]...)
+		break
+	}
+	if len(queue) > 0 {
+		q.queues[r.tenant] = queue
+		return
+	}
+	delete(q.queues, r.tenant)
+	for i, tenant := range q.tenants {
+		if tenant == r.tenant {
+			q.tenants = append(q.tenants[:i], q.tenants[i+1:]...)
+			break
+		}
+	}
+}
+
+// requestQueue limits the number of downstream requests sent at the same time. Requests over the limit are queued
+// and sent by strict priority between the priority classes, round-robin between the tenants of a class so that a
+// tenant sending many requests does not delay the others, and in order for a tenant.
+type requestQueue struct {
+	maxConcurrent           int
+	maxOutstandingPerTenant int
+
+	mtx         sync.Mutex
+	inflight    int
+	outstanding map[string]int
+	classes     [numQueryPriorities]*tenantQueues
+
+	queueLength    *prometheus.GaugeVec
+	queueDuration  *prometheus.HistogramVec
+	discardedTotal *prometheus.CounterVec
+}
+
+func newRequestQueue(cfg QueueConfig, reg prometheus.Registerer) *requestQueue {
+	q := &requestQueue{
+		maxConcurrent:           cfg.MaxConcurrent,
+		maxOutstandingPerTenant: cfg.MaxOutstandingPerTenant,
+		outstanding:             map[string]int{},
+		queueLength: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_query_frontend_queue_length",
+			Help: "Number of downstream requests waiting in the queue.",
+		}, []string{"tenant", "priority"}),
+		queueDuration: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "thanos_query_frontend_queue_duration_seconds",
+			Help:    "Time spent by the downstream requests in the queue.",
+			Buckets: []float64{0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"priority"}),
+		discardedTotal: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_query_frontend_queue_discarded_requests_total",
+			Help: "Total number of downstream requests rejected because their tenant had too many queued requests.",
+		}, []string{"tenant"}),
+	}
+	for i := range q.classes {
+		q.classes[i] = &tenantQueues{queues: map[string][]*queuedRequest{}}
+	}
+	return q
+}
+
+func (q *requestQueue) queuedLocked() bool {
+	for _, c := range q.classes {
+		if len(c.tenants) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire waits for a slot to send the request of the tenant. The slot must be released once the request is done.
+func (q *requestQueue) acquire(ctx context.Context, tenant string, priority QueryPriority) error {
+	q.mtx.Lock()
+	if q.inflight < q.maxConcurrent && !q.queuedLocked() {
+		q.inflight++
+		q.mtx.Unlock()
+		q.queueDuration.WithLabelValues(priority.String()).Observe(0)
+		return nil
+	}
+	if q.maxOutstandingPerTenant > 0 && q.outstanding[tenant] >= q.maxOutstandingPerTenant {
+		q.mtx.Unlock()
+		q.discardedTotal.WithLabelValues(tenant).Inc()
+		return httpgrpc.Errorf(http.StatusTooManyRequests, "too many outstanding requests of tenant %s", tenant)
+	}
+	r := &queuedRequest{tenant: tenant, priority: priority, ready: make(chan struct{})}
+	q.classes[priority].push(r)
+	q.outstanding[tenant]++
+	q.queueLength.WithLabelValues(tenant, priority.String()).Inc()
+	q.mtx.Unlock()
+
+	start := time.Now()
+	select {
+	case <-r.ready:
+		q.queueDuration.WithLabelValues(priority.String()).Observe(time.Since(start).Seconds())
+		return nil
+	case <-ctx.Done():
+	}
+
+	q.mtx.Lock()
+	if !r.dispatched {
+		q.classes[priority].remove(r)
+		q.dequeuedLocked(r)
+		q.mtx.Unlock()
+		return ctx.Err()
+	}
+	q.mtx.Unlock()
+	// The request got its slot while being canceled.
+	q.release()
+	return ctx.Err()
+}
+
+// release releases a slot, handing it to the next queued request if any.
+func (q *requestQueue) release() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.inflight--
+	for q.inflight < q.maxConcurrent {
+		var r *queuedRequest
+		for _, c := range q.classes {
+			if r = c.pop(); r != nil {
+				break
+			}
+		}
+		if r == nil {
+			return
+		}
+		q.dequeuedLocked(r)
+		r.dispatched = true
+		q.inflight++
+		close(r.ready)
+	}
+}
+
+func (q *requestQueue) dequeuedLocked(r *queuedRequest) {
+	q.queueLength.WithLabelValues(r.tenant, r.priority.String()).Dec()
+	if q.outstanding[r.tenant]--; q.outstanding[r.tenant] == 0 {
+		delete(q.outstanding, r.tenant)
+	}
+}
+
+type queueRoundTripper struct {
+	next  http.RoundTripper
+	queue *requestQueue
+}
+
+// NewQueueRoundTripper returns a round tripper queuing the downstream requests over the max concurrent requests of
+// the config. The tenant of the requests is their org ID, and their priority class the one of their context.
+func NewQueueRoundTripper(cfg QueueConfig, reg prometheus.Registerer, next http.RoundTripper) http.RoundTripper {
+	return &queueRoundTripper{next: next, queue: newRequestQueue(cfg, reg)}
+}
+
+func (rt *queueRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	tenant, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		tenant = r.Header.Get(user.OrgIDHeaderName)
+	}
+	if err := rt.queue.acquire(ctx, tenant, QueryPriorityFromContext(ctx)); err != nil {
+		return nil, err
+	}
+
+	resp, err := rt.next.RoundTrip(r)
+	if err != nil {
+		rt.queue.release()
+		return nil, err
+	}
+	// The slot is held until the response is read.
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: rt.queue.release}
+	return resp, nil
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,169 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+)
+
+func TestParseQueryPriority(t *testing.T) {
+	t.Parallel()
+
+	testutil.Equals(t, PriorityInteractive, ParseQueryPriority(""))
+	testutil.Equals(t, PriorityInteractive, ParseQueryPriority("interactive"))
+	testutil.Equals(t, PriorityInteractive, ParseQueryPriority("unknown"))
+	testutil.Equals(t, PriorityBatch, ParseQueryPriority(" Batch "))
+
+	testutil.Equals(t, PriorityInteractive, QueryPriorityFromContext(context.Background()))
+	testutil.Equals(t, PriorityBatch, QueryPriorityFromContext(ContextWithQueryPriority(context.Background(), PriorityBatch)))
+}
+
+func TestRequestQueue_Order(t *testing.T) {
+	t.Parallel()
+
+	q := newRequestQueue(QueueConfig{MaxConcurrent: 1}, prometheus.NewRegistry())
+	testutil.Ok(t, q.acquire(context.Background(), "a", PriorityInteractive))
+
+	var (
+		mtx     sync.Mutex
+		order   []string
+		wg      sync.WaitGroup
+		enqueue = func(name, tenant string, p QueryPriority) {
+			n := queued(q)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				testutil.Ok(t, q.acquire(context.Background(), tenant, p))
+				mtx.Lock()
+				order = append(order, name)
+				mtx.Unlock()
+				q.release()
+			}()
+			// Wait for the request to be queued to enqueue the requests in order.
+			testutil.Ok(t, waitFor(func() bool { return queued(q) == n+1 }))
+		}
+	)
+	enqueue("b", "busy", PriorityBatch)
+	enqueue("a1", "busy", PriorityInteractive)
+	enqueue("a2", "busy", PriorityInteractive)
+	enqueue("a3", "busy", PriorityInteractive)
+	enqueue("c1", "quiet", PriorityInteractive)
+
+	q.release()
+	wg.Wait()
+	// Interactive requests first, round-robin between the tenants.
+	testutil.Equals(t, []string{"a1", "c1", "a2", "a3", "b"}, order)
+	testutil.Equals(t, 0, q.inflight)
+	testutil.Equals(t, 0, len(q.outstanding))
+}
+
+func TestRequestQueue_Limits(t *testing.T) {
+	t.Parallel()
+
+	q := newRequestQueue(QueueConfig{MaxConcurrent: 1, MaxOutstandingPerTenant: 1}, prometheus.NewRegistry())
+	testutil.Ok(t, q.acquire(context.Background(), "a", PriorityInteractive))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- q.acquire(ctx, "a", PriorityInteractive) }()
+	testutil.Ok(t, waitFor(func() bool { return queued(q) == 1 }))
+
+	// The tenant has too many queued requests, unlike another tenant.
+	err := q.acquire(context.Background(), "a", PriorityInteractive)
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, int32(http.StatusTooManyRequests), resp.Code)
+	testutil.Equals(t, 1.0, promtest.ToFloat64(q.discardedTotal.WithLabelValues("a")))
+
+	go func() { _ = q.acquire(context.Background(), "b", PriorityInteractive) }()
+	testutil.Ok(t, waitFor(func() bool { return queued(q) == 2 }))
+
+	// A canceled request leaves the queue.
+	cancel()
+	testutil.Equals(t, context.Canceled, <-errs)
+	testutil.Equals(t, 1, queued(q))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(q.queueLength.WithLabelValues("a", "interactive")))
+
+	q.release()
+	testutil.Ok(t, waitFor(func() bool { return queued(q) == 0 }))
+	testutil.Equals(t, 1, q.inflight)
+}
+
+func TestQueueRoundTripper(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mtx              sync.Mutex
+		inflight, peakRT int
+	)
+	next := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		mtx.Lock()
+		inflight++
+		peakRT = max(peakRT, inflight)
+		mtx.Unlock()
+		time.Sleep(time.Millisecond)
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("ok"))}, nil
+	})
+	rt := NewQueueRoundTripper(QueueConfig{MaxConcurrent: 2}, prometheus.NewRegistry(), next)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := user.InjectOrgID(context.Background(), "tenant")
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/api/v1/query", nil)
+			testutil.Ok(t, err)
+			resp, err := rt.RoundTrip(req)
+			testutil.Ok(t, err)
+			_, err = io.ReadAll(resp.Body)
+			testutil.Ok(t, err)
+
+			// The slot is held until the response is closed.
+			mtx.Lock()
+			inflight--
+			mtx.Unlock()
+			testutil.Ok(t, resp.Body.Close())
+		}()
+	}
+	wg.Wait()
+	testutil.Assert(t, peakRT <= 2, "peak of %d concurrent requests", peakRT)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func queued(q *requestQueue) int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	n := 0
+	for _, c := range q.outstanding {
+		n += c
+	}
+	return n
+}
+
+func waitFor(cond func() bool) error {
+	for i := 0; i < 1000; i++ {
+		if cond() {
+			return nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return context.DeadlineExceeded
+}
@@ -0,0 +1,285 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	util_log "github.com/thanos-io/thanos/internal/cortex/util/log"
+)
+
+const (
+	// ErrorClassHeader is the response header holding the class of the downstream error a request failed with.
+	ErrorClassHeader = "X-Thanos-Query-Error-Class"
+	// RetriesHeader is the response header holding the number of retries of the downstream request that failed.
+	RetriesHeader = "X-Thanos-Query-Retries"
+
+	// retryBudgetCapacity is the maximum number of retries of a class a retry budget can save up.
+	retryBudgetCapacity = 10.0
+)
+
+// ErrorClass is the class of a downstream error, deciding whether and how many times the request is retried.
+type ErrorClass int
+
+const (
+	// ErrorClassInternal is the class of the unknown server errors, retried.
+	ErrorClassInternal ErrorClass = iota
+	// ErrorClassTimeout is the class of the queries that timed out, retried at most once as they are likely to time
+	// out again.
+	ErrorClassTimeout
+	// ErrorClassStoreUnavailable is the class of the errors of unreachable queriers or stores, retried.
+	ErrorClassStoreUnavailable
+	// ErrorClassLimitExceeded is the class of the queries exceeding a limit, never retried.
+	ErrorClassLimitExceeded
+	// ErrorClassPromQL is the class of the invalid queries or of the queries failing to evaluate, never retried.
+	ErrorClassPromQL
+	// ErrorClassCanceled is the class of the canceled queries, never retried.
+	ErrorClassCanceled
+
+	numErrorClasses
+)
+
+var errorClassNames = [numErrorClasses]string{
+	ErrorClassInternal:         "internal",
+	ErrorClassTimeout:          "timeout",
+	ErrorClassStoreUnavailable: "store_unavailable",
+	ErrorClassLimitExceeded:    "limit_exceeded",
+	ErrorClassPromQL:           "promql",
+	ErrorClassCanceled:         "canceled",
+}
+
+func (c ErrorClass) String() string { return errorClassNames[c] }
+
+// maxTries returns the maximum number of tries of the requests failing with an error of the class.
+func (c ErrorClass) maxTries(maxRetries int) int {
+	switch c {
+	case ErrorClassTimeout:
+		return min(maxRetries, 2)
+	case ErrorClassLimitExceeded, ErrorClassPromQL, ErrorClassCanceled:
+		return 1
+	default:
+		return maxRetries
+	}
+}
+
+// Messages of the errors of the stores, as returned by the queriers.
+var (
+	timeoutMessages          = []string{"deadline exceeded", "DeadlineExceeded"}
+	limitExceededMessages    = []string{"violated", "exceeded", "too many", "ResourceExhausted"}
+	storeUnavailableMessages = []string{"No StoreAPIs matched", "code = Unavailable", "connection refused", "no such host"}
+)
+
+// ClassifyError returns the class of a downstream error, from its HTTP status code and the type and message of its
+// Prometheus API error body.
+func ClassifyError(err error) ErrorClass {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorClassTimeout
+	}
+	if errors.Is(err, context.Canceled) {
+		return ErrorClassCanceled
+	}
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	if !ok {
+		// Not an HTTP response, the querier could not be reached.
+		return ErrorClassStoreUnavailable
+	}
+
+	var body struct {
+		ErrorType string `json:"errorType"`
+		Error     string `json:"error"`
+	}
+	if json.Unmarshal(resp.Body, &body) != nil {
+		body.Error = string(resp.Body)
+	}
+	switch {
+	case body.ErrorType == "timeout" || resp.Code == http.StatusGatewayTimeout || containsAny(body.Error, timeoutMessages):
+		return ErrorClassTimeout
+	case body.ErrorType == "canceled":
+		return ErrorClassCanceled
+	case resp.Code == http.StatusTooManyRequests || resp.Code == http.StatusRequestEntityTooLarge || containsAny(body.Error, limitExceededMessages):
+		return ErrorClassLimitExceeded
+	case body.ErrorType == "unavailable" || resp.Code == http.StatusBadGateway || resp.Code == http.StatusServiceUnavailable ||
+		containsAny(body.Error, storeUnavailableMessages):
+		return ErrorClassStoreUnavailable
+	case resp.Code/100 == 4:
+		return ErrorClassPromQL
+	default:
+		return ErrorClassInternal
+	}
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryBudget limits the retries of each error class to a ratio of the requests, so that retries do not multiply the
+// load of the downstream queriers during incidents. Each request saves up the ratio of a retry for every class.
+type retryBudget struct {
+	ratio float64
+
+	mtx    sync.Mutex
+	tokens [numErrorClasses]float64
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	b := &retryBudget{ratio: ratio}
+	for i := range b.tokens {
+		b.tokens[i] = retryBudgetCapacity
+	}
+	return b
+}
+
+func (b *retryBudget) deposit() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	for i := range b.tokens {
+		b.tokens[i] = min(b.tokens[i]+b.ratio, retryBudgetCapacity)
+	}
+}
+
+// withdraw returns true if a retry of the class is in the budget, which is then spent. A zero ratio disables the
+// budget.
+func (b *retryBudget) withdraw(c ErrorClass) bool {
+	if b.ratio <= 0 {
+		return true
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.tokens[c] < 1 {
+		return false
+	}
+	b.tokens[c]--
+	return true
+}
+
+type retryMetrics struct {
+	retriesCount    prometheus.Histogram
+	errors          *prometheus.CounterVec
+	retries         *prometheus.CounterVec
+	budgetExhausted *prometheus.CounterVec
+}
+
+func newRetryMetrics(reg prometheus.Registerer) *retryMetrics {
+	m := &retryMetrics{
+		retriesCount: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Namespace: "cortex",
+			Name:      "query_frontend_retries",
+			Help:      "Number of times a request is retried.",
+			Buckets:   []float64{0, 1, 2, 3, 4, 5},
+		}),
+		errors: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_query_frontend_downstream_errors_total",
+			Help: "Total number of failed downstream requests, by class of error.",
+		}, []string{"class"}),
+		retries: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_query_frontend_retries_total",
+			Help: "Total number of retried downstream requests, by class of error.",
+		}, []string{"class"}),
+		budgetExhausted: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_query_frontend_retry_budget_exhausted_total",
+			Help: "Total number of downstream requests not retried because the retry budget of their class of error was spent.",
+		}, []string{"class"}),
+	}
+	for _, name := range errorClassNames {
+		m.errors.WithLabelValues(name)
+		m.retries.WithLabelValues(name)
+		m.budgetExhausted.WithLabelValues(name)
+	}
+	return m
+}
+
+type retry struct {
+	logger     log.Logger
+	next       queryrange.Handler
+	maxRetries int
+	budget     *retryBudget
+	metrics    *retryMetrics
+}
+
+// RetryMiddleware returns a middleware that retries the requests failing with a retryable class of error, at most
+// max retries times in total, and within the retry budget of the class. The class of the error and the number of
+// retries of a failed request are set in its response headers.
+func RetryMiddleware(logger log.Logger, maxRetries int, budgetRatio float64, reg prometheus.Registerer) queryrange.Middleware {
+	budget := newRetryBudget(budgetRatio)
+	metrics := newRetryMetrics(reg)
+	return queryrange.MiddlewareFunc(func(next queryrange.Handler) queryrange.Handler {
+		return retry{
+			logger:     logger,
+			next:       next,
+			maxRetries: maxRetries,
+			budget:     budget,
+			metrics:    metrics,
+		}
+	})
+}
+
+func (r retry) Do(ctx context.Context, req queryrange.Request) (queryrange.Response, error) {
+	r.budget.deposit()
+
+	tries := 0
+	defer func() { r.metrics.retriesCount.Observe(float64(max(tries-1, 0))) }()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		tries++
+		resp, err := r.next.Do(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+
+		class := ClassifyError(err)
+		r.metrics.errors.WithLabelValues(class.String()).Inc()
+		if tries >= class.maxTries(r.maxRetries) {
+			return nil, withErrorClass(err, class, tries-1)
+		}
+		if !r.budget.withdraw(class) {
+			r.metrics.budgetExhausted.WithLabelValues(class.String()).Inc()
+			return nil, withErrorClass(err, class, tries-1)
+		}
+		r.metrics.retries.WithLabelValues(class.String()).Inc()
+		level.Error(util_log.WithContext(ctx, r.logger)).Log("msg", "error processing request", "try", tries, "class", class, "err", err)
+	}
+}
+
+// withErrorClass sets the class of the error and the number of retries in the headers of the HTTP response of the
+// error.
+func withErrorClass(err error, class ErrorClass, retries int) error {
+	if err == context.DeadlineExceeded {
+		return err
+	}
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	if !ok {
+		resp = &httpgrpc.HTTPResponse{Code: http.StatusInternalServerError, Body: []byte(err.Error())}
+	}
+	resp.Headers = append(resp.Headers,
+		&httpgrpc.Header{Key: ErrorClassHeader, Values: []string{class.String()}},
+		&httpgrpc.Header{Key: RetriesHeader, Values: []string{strconv.Itoa(retries)}},
+	)
+	return httpgrpc.ErrorFromHTTPResponse(resp)
+}
@@ -0,0 +1,125 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+)
+
+func apiError(code int, errorType, msg string) error {
+	return httpgrpc.ErrorFromHTTPResponse(&httpgrpc.HTTPResponse{
+		Code: int32(code),
+		Body: []byte(`{"status":"error","errorType":"` + errorType + `","error":"` + msg + `"}`),
+	})
+}
+
+func TestClassifyError(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		err      error
+		expected ErrorClass
+	}{
+		{err: context.DeadlineExceeded, expected: ErrorClassTimeout},
+		{err: errors.Wrap(context.Canceled, "query"), expected: ErrorClassCanceled},
+		{err: errors.New("dial tcp: connection refused"), expected: ErrorClassStoreUnavailable},
+		{err: apiError(http.StatusServiceUnavailable, "timeout", "query timed out in expression evaluation"), expected: ErrorClassTimeout},
+		{err: apiError(http.StatusServiceUnavailable, "canceled", "query was canceled"), expected: ErrorClassCanceled},
+		{err: apiError(http.StatusInternalServerError, "internal", "rpc error: code = DeadlineExceeded desc = context deadline exceeded"), expected: ErrorClassTimeout},
+		{err: apiError(http.StatusGatewayTimeout, "", ""), expected: ErrorClassTimeout},
+		{err: apiError(http.StatusInternalServerError, "internal", "expanding series: limit 10 violated (got 11)"), expected: ErrorClassLimitExceeded},
+		{err: apiError(422, "execution", "query processing would load too many samples into memory"), expected: ErrorClassLimitExceeded},
+		{err: apiError(http.StatusTooManyRequests, "", ""), expected: ErrorClassLimitExceeded},
+		{err: apiError(http.StatusInternalServerError, "internal", "No StoreAPIs matched for this query"), expected: ErrorClassStoreUnavailable},
+		{err: apiError(http.StatusInternalServerError, "internal", "receive series: rpc error: code = Unavailable desc = transport is closing"), expected: ErrorClassStoreUnavailable},
+		{err: apiError(http.StatusBadGateway, "", ""), expected: ErrorClassStoreUnavailable},
+		{err: apiError(http.StatusBadRequest, "bad_data", "1:5: parse error: unexpected end of input"), expected: ErrorClassPromQL},
+		{err: apiError(422, "execution", "vector cannot contain metrics with the same labelset"), expected: ErrorClassPromQL},
+		{err: apiError(http.StatusInternalServerError, "internal", "unexpected error"), expected: ErrorClassInternal},
+		{err: httpgrpc.Errorf(http.StatusInternalServerError, "not a JSON body"), expected: ErrorClassInternal},
+	} {
+		testutil.Equals(t, tc.expected, ClassifyError(tc.err), "%v", tc.err)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	t.Parallel()
+
+	b := newRetryBudget(0.5)
+	for i := 0; i < retryBudgetCapacity; i++ {
+		testutil.Assert(t, b.withdraw(ErrorClassInternal))
+	}
+	testutil.Assert(t, !b.withdraw(ErrorClassInternal))
+	// The classes have their own budget.
+	testutil.Assert(t, b.withdraw(ErrorClassTimeout))
+
+	b.deposit()
+	testutil.Assert(t, !b.withdraw(ErrorClassInternal))
+	b.deposit()
+	testutil.Assert(t, b.withdraw(ErrorClassInternal))
+
+	// A zero ratio disables the budget.
+	b = newRetryBudget(0)
+	for i := 0; i < 2*retryBudgetCapacity; i++ {
+		testutil.Assert(t, b.withdraw(ErrorClassInternal))
+	}
+}
+
+func TestRetryMiddleware(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name          string
+		err           error
+		budgetRatio   float64
+		requests      int
+		expectedTries int
+		expectedClass string
+	}{
+		{name: "internal errors are retried", err: apiError(http.StatusInternalServerError, "internal", "unexpected error"), requests: 1, expectedTries: 5, expectedClass: "internal"},
+		{name: "timeouts are retried once", err: apiError(http.StatusServiceUnavailable, "timeout", "query timed out"), requests: 1, expectedTries: 2, expectedClass: "timeout"},
+		{name: "PromQL errors are not retried", err: apiError(http.StatusBadRequest, "bad_data", "parse error"), requests: 1, expectedTries: 1, expectedClass: "promql"},
+		{name: "limits are not retried", err: apiError(http.StatusTooManyRequests, "", ""), requests: 1, expectedTries: 1, expectedClass: "limit_exceeded"},
+		// The 10 retries saved up and the half retry saved by each request but the first, as the budget is full.
+		{name: "retry budget", err: errors.New("connection refused"), budgetRatio: 0.5, requests: 20, expectedTries: 20 + 10 + 9, expectedClass: "store_unavailable"},
+		{name: "retry budget disabled", err: errors.New("connection refused"), requests: 20, expectedTries: 20 * 5, expectedClass: "store_unavailable"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tries := 0
+			reg := prometheus.NewRegistry()
+			h := RetryMiddleware(log.NewNopLogger(), 5, tc.budgetRatio, reg).Wrap(queryrange.HandlerFunc(func(context.Context, queryrange.Request) (queryrange.Response, error) {
+				tries++
+				return nil, tc.err
+			}))
+			var err error
+			for i := 0; i < tc.requests; i++ {
+				_, err = h.Do(context.Background(), &ThanosQueryRangeRequest{})
+				testutil.NotOk(t, err)
+			}
+			testutil.Equals(t, tc.expectedTries, tries)
+			testutil.Equals(t, float64(tc.expectedTries), promtest.ToFloat64(h.(retry).metrics.errors.WithLabelValues(tc.expectedClass)))
+
+			resp, ok := httpgrpc.HTTPResponseFromError(err)
+			testutil.Assert(t, ok)
+			headers := map[string][]string{}
+			for _, h := range resp.Headers {
+				headers[h.Key] = h.Values
+			}
+			testutil.Equals(t, []string{tc.expectedClass}, headers[ErrorClassHeader])
+		})
+	}
+}
@@ -63,30 +63,34 @@ func NewTripperware(config Config, reg prometheus.Registerer, logger log.Logger)
 		queryRangeCodec,
 		config.NumShards,
 		config.CortexHandlerConfig.QueryStatsEnabled,
+		config.RetryBudgetRatio,
 		prometheus.WrapRegistererWith(prometheus.Labels{"tripperware": "query_range"}, reg), logger, config.ForwardHeaders)
 	if err != nil {
 		return nil, err
 	}
 
-	labelsTripperware, err := newLabelsTripperware(config.LabelsConfig, labelsLimits, labelsCodec,
+	labelsTripperware, err := newLabelsTripperware(config.LabelsConfig, labelsLimits, labelsCodec, config.RetryBudgetRatio,
 		prometheus.WrapRegistererWith(prometheus.Labels{"tripperware": "labels"}, reg), logger, config.ForwardHeaders)
 	if err != nil {
 		return nil, err
 	}
 	queryInstantTripperware := newInstantQueryTripperware(
 		config.NumShards,
+		config.InstantSubquerySplitMinRange,
 		queryRangeLimits,
 		queryInstantCodec,
+		queryRangeCodec,
 		prometheus.WrapRegistererWith(prometheus.Labels{"tripperware": "query_instant"}, reg),
 		config.ForwardHeaders,
 		config.CortexHandlerConfig.QueryStatsEnabled,
 	)
 	return func(next http.RoundTripper) http.RoundTripper {
+		queryRange := queryRangeTripperware(next)
 		tripper := newRoundTripper(
 			next,
-			queryRangeTripperware(next),
+			queryRange,
 			labelsTripperware(next),
-			queryInstantTripperware(next),
+			queryInstantTripperware(next, queryRange),
 			reg,
 		)
 		return tenancy.InternalTenancyConversionTripper(config.TenantHeader, config.TenantCertField, tripper)
@@ -165,6 +169,7 @@ func newQueryRangeTripperware(
 	codec *queryRangeCodec,
 	numShards int,
 	forceStats bool,
+	retryBudgetRatio float64,
 	reg prometheus.Registerer,
 	logger log.Logger,
 	forwardHeaders []string,
@@ -239,7 +244,7 @@ func newQueryRangeTripperware(
 		queryRangeMiddleware = append(
 			queryRangeMiddleware,
 			queryrange.InstrumentMiddleware("retry", m),
-			queryrange.NewRetryMiddleware(logger, config.MaxRetries, queryrange.NewRetryMiddlewareMetrics(reg)),
+			RetryMiddleware(logger, config.MaxRetries, retryBudgetRatio, reg),
 		)
 	}
 
@@ -279,6 +284,7 @@ func newLabelsTripperware(
 	config LabelsConfig,
 	limits queryrange.Limits,
 	codec *labelsCodec,
+	retryBudgetRatio float64,
 	reg prometheus.Registerer,
 	logger log.Logger,
 	forwardHeaders []string,
@@ -325,7 +331,7 @@ func newLabelsTripperware(
 		labelsMiddleware = append(
 			labelsMiddleware,
 			queryrange.InstrumentMiddleware("retry", m),
-			queryrange.NewRetryMiddleware(logger, config.MaxRetries, queryrange.NewRetryMiddlewareMetrics(reg)),
+			RetryMiddleware(logger, config.MaxRetries, retryBudgetRatio, reg),
 		)
 	}
 	return func(next http.RoundTripper) http.RoundTripper {
@@ -336,14 +342,18 @@ func newLabelsTripperware(
 	}, nil
 }
 
+// newInstantQueryTripperware returns a tripperware for instant queries configured with middlewares of subquery
+// splitting, sending the range queries of the subqueries to the query range round tripper, and sharding.
 func newInstantQueryTripperware(
 	numShards int,
+	subquerySplitMinRange time.Duration,
 	limits queryrange.Limits,
 	codec queryrange.Codec,
+	queryRangeCodec queryrange.Codec,
 	reg prometheus.Registerer,
 	forwardHeaders []string,
 	forceStats bool,
-) queryrange.Tripperware {
+) func(next, queryRange http.RoundTripper) http.RoundTripper {
 	var instantQueryMiddlewares []queryrange.Middleware
 	m := queryrange.NewInstrumentMiddlewareMetrics(reg)
 	if numShards > 0 {
@@ -360,8 +370,15 @@ func newInstantQueryTripperware(
 		queryrange.NewStatsMiddleware(forceStats),
 	)
 
-	return func(next http.RoundTripper) http.RoundTripper {
-		rt := queryrange.NewRoundTripper(next, codec, forwardHeaders, instantQueryMiddlewares...)
+	return func(next, queryRange http.RoundTripper) http.RoundTripper {
+		middlewares := instantQueryMiddlewares
+		if subquerySplitMinRange > 0 {
+			middlewares = append([]queryrange.Middleware{
+				queryrange.InstrumentMiddleware("split_subquery", m),
+				SplitSubqueryMiddleware(subquerySplitMinRange, queryRange, queryRangeCodec, reg),
+			}, middlewares...)
+		}
+		rt := queryrange.NewRoundTripper(next, codec, forwardHeaders, middlewares...)
 		return queryrange.RoundTripFunc(func(r *http.Request) (*http.Response, error) {
 			return rt.RoundTrip(r)
 		})
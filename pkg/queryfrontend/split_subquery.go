@@ -0,0 +1,266 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/thanos-io/thanos/internal/cortex/cortexpb"
+	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+)
+
+// overTimeFunctions are the functions over the samples of a subquery the instant queries can be split for.
+var overTimeFunctions = map[string]func(samples []cortexpb.Sample) float64{
+	"sum_over_time": func(samples []cortexpb.Sample) float64 {
+		return kahanSum(samples)
+	},
+	// The mean is computed from the sum, unlike in PromQL once the sum overflows.
+	"avg_over_time": func(samples []cortexpb.Sample) float64 {
+		return kahanSum(samples) / float64(len(samples))
+	},
+	"count_over_time": func(samples []cortexpb.Sample) float64 {
+		return float64(len(samples))
+	},
+	"min_over_time": func(samples []cortexpb.Sample) float64 {
+		minVal := samples[0].Value
+		for _, s := range samples {
+			if s.Value < minVal || math.IsNaN(minVal) {
+				minVal = s.Value
+			}
+		}
+		return minVal
+	},
+	"max_over_time": func(samples []cortexpb.Sample) float64 {
+		maxVal := samples[0].Value
+		for _, s := range samples {
+			if s.Value > maxVal || math.IsNaN(maxVal) {
+				maxVal = s.Value
+			}
+		}
+		return maxVal
+	},
+	"last_over_time": func(samples []cortexpb.Sample) float64 {
+		return samples[len(samples)-1].Value
+	},
+	"present_over_time": func([]cortexpb.Sample) float64 {
+		return 1
+	},
+}
+
+func kahanSum(samples []cortexpb.Sample) float64 {
+	var sum, c float64
+	for _, s := range samples {
+		t := sum + s.Value
+		switch {
+		case math.IsInf(t, 0):
+			c = 0
+		case math.Abs(sum) >= math.Abs(s.Value):
+			c += (sum - t) + s.Value
+		default:
+			c += (s.Value - t) + sum
+		}
+		sum = t
+	}
+	if math.IsInf(sum, 0) {
+		return sum
+	}
+	return sum + c
+}
+
+// SplitSubqueryMiddleware returns a middleware that splits the instant queries of a function over a subquery of at
+// least the min range, e.g. max_over_time(rate(x[5m])[30d:1m]), into the range query of the subquery, sent to the
+// range query round tripper to be split and cached, and the function applied to its result. This way the alerting
+// rules of long subqueries do not evaluate the whole subquery at every evaluation.
+func SplitSubqueryMiddleware(minRange time.Duration, queryRange http.RoundTripper, codec queryrange.Codec, reg prometheus.Registerer) queryrange.Middleware {
+	splitTotal := promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_frontend_split_subqueries_total",
+		Help: "Total number of instant queries split into the range query of their subquery.",
+	})
+	return queryrange.MiddlewareFunc(func(next queryrange.Handler) queryrange.Handler {
+		return subquerySplitter{
+			next:       next,
+			minRange:   minRange,
+			queryRange: queryRange,
+			codec:      codec,
+			splitTotal: splitTotal,
+		}
+	})
+}
+
+type subquerySplitter struct {
+	next       queryrange.Handler
+	minRange   time.Duration
+	queryRange http.RoundTripper
+	codec      queryrange.Codec
+
+	splitTotal prometheus.Counter
+}
+
+func (s subquerySplitter) Do(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+	req, ok := r.(*ThanosQueryInstantRequest)
+	if !ok || req.Stats != "" || req.Analyze {
+		return s.next.Do(ctx, r)
+	}
+	call, sq, ok := s.splittableSubquery(req.Query)
+	if !ok {
+		return s.next.Do(ctx, r)
+	}
+
+	// The subquery is evaluated at the multiples of its step in (t - offset - range, t - offset].
+	step := sq.Step.Milliseconds()
+	end := req.Time - sq.OriginalOffset.Milliseconds()
+	start := step * ((end - sq.Range.Milliseconds()) / step)
+	if start <= end-sq.Range.Milliseconds() {
+		start += step
+	}
+	if start > end {
+		return s.next.Do(ctx, r)
+	}
+
+	resp, err := s.doRangeQuery(ctx, &ThanosQueryRangeRequest{
+		Path:                strings.TrimSuffix(req.Path, "query") + "query_range",
+		Start:               start,
+		End:                 end,
+		Step:                step,
+		Timeout:             req.Timeout,
+		Query:               sq.Expr.String(),
+		Dedup:               req.Dedup,
+		PartialResponse:     req.PartialResponse,
+		AutoDownsampling:    req.AutoDownsampling,
+		MaxSourceResolution: req.MaxSourceResolution,
+		ReplicaLabels:       req.ReplicaLabels,
+		StoreMatchers:       req.StoreMatchers,
+		Headers:             req.Headers,
+		LookbackDelta:       req.LookbackDelta,
+		Engine:              req.Engine,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	samples, ok := applyOverTime(call.Func.Name, req.Time, resp.Data.Result)
+	if !ok {
+		// Let the querier evaluate the queries it would fail, or those of native histograms.
+		return s.next.Do(ctx, r)
+	}
+	s.splitTotal.Inc()
+	return &queryrange.PrometheusInstantQueryResponse{
+		Status: queryrange.StatusSuccess,
+		Data: queryrange.PrometheusInstantQueryData{
+			ResultType: string(parser.ValueTypeVector),
+			Result: queryrange.PrometheusInstantQueryResult{
+				Result: &queryrange.PrometheusInstantQueryResult_Vector{Vector: &queryrange.Vector{Samples: samples}},
+			},
+		},
+		Warnings: resp.Warnings,
+	}, nil
+}
+
+// splittableSubquery returns the function and its subquery if the query is a supported function over a subquery of
+// at least the min range, with a step and without @ modifiers.
+func (s subquerySplitter) splittableSubquery(query string) (*parser.Call, *parser.SubqueryExpr, bool) {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return nil, nil, false
+	}
+	for {
+		p, ok := expr.(*parser.ParenExpr)
+		if !ok {
+			break
+		}
+		expr = p.Expr
+	}
+	call, ok := expr.(*parser.Call)
+	if !ok || overTimeFunctions[call.Func.Name] == nil || len(call.Args) != 1 {
+		return nil, nil, false
+	}
+	sq, ok := call.Args[0].(*parser.SubqueryExpr)
+	if !ok || sq.Step == 0 || sq.Range < s.minRange {
+		return nil, nil, false
+	}
+
+	atModifier := false
+	parser.Inspect(sq, func(node parser.Node, _ []parser.Node) error {
+		switch n := node.(type) {
+		case *parser.VectorSelector:
+			atModifier = atModifier || n.Timestamp != nil || n.StartOrEnd != 0
+		case *parser.SubqueryExpr:
+			atModifier = atModifier || n.Timestamp != nil || n.StartOrEnd != 0
+		}
+		return nil
+	})
+	return call, sq, !atModifier
+}
+
+func (s subquerySplitter) doRangeQuery(ctx context.Context, req *ThanosQueryRangeRequest) (*queryrange.PrometheusResponse, error) {
+	httpReq, err := s.codec.EncodeRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := user.InjectOrgIDIntoHTTPRequest(ctx, httpReq); err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, "%s", err.Error())
+	}
+	httpResp, err := s.queryRange.RoundTrip(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, io.LimitReader(httpResp.Body, 1024))
+		_ = httpResp.Body.Close()
+	}()
+
+	resp, err := s.codec.DecodeResponse(ctx, httpResp, req)
+	if err != nil {
+		return nil, err
+	}
+	promResp, ok := resp.(*queryrange.PrometheusResponse)
+	if !ok {
+		return nil, httpgrpc.Errorf(http.StatusInternalServerError, "invalid range query response format")
+	}
+	return promResp, nil
+}
+
+// applyOverTime applies the function to the samples of the series of the subquery, and returns false if the series
+// have native histograms, or the same labels once their metric name is dropped.
+func applyOverTime(fn string, ts int64, series []queryrange.SampleStream) ([]*queryrange.Sample, bool) {
+	dropName := fn != "last_over_time"
+	samples := make([]*queryrange.Sample, 0, len(series))
+	seen := make(map[uint64]struct{}, len(series))
+	for _, s := range series {
+		if len(s.Histograms) > 0 {
+			return nil, false
+		}
+		if len(s.Samples) == 0 {
+			continue
+		}
+		lset := cortexpb.FromLabelAdaptersToLabels(s.Labels)
+		if dropName {
+			lset = labels.NewBuilder(lset).Del(labels.MetricName).Labels()
+		}
+		h := lset.Hash()
+		if _, ok := seen[h]; ok {
+			return nil, false
+		}
+		seen[h] = struct{}{}
+
+		samples = append(samples, &queryrange.Sample{
+			Labels:      cortexpb.FromLabelsToLabelAdapters(lset),
+			SampleValue: overTimeFunctions[fn](s.Samples),
+			Timestamp:   ts,
+		})
+	}
+	return samples, true
+}
@@ -0,0 +1,181 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/weaveworks/common/user"
+
+	"github.com/thanos-io/thanos/internal/cortex/cortexpb"
+	"github.com/thanos-io/thanos/internal/cortex/frontend/transport"
+	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+)
+
+func sampleStream(lset []cortexpb.LabelAdapter, values ...float64) queryrange.SampleStream {
+	s := queryrange.SampleStream{Labels: lset}
+	for i, v := range values {
+		s.Samples = append(s.Samples, cortexpb.Sample{Value: v, TimestampMs: int64(i) * 60000})
+	}
+	return s
+}
+
+func TestSplitSubqueryMiddleware(t *testing.T) {
+	t.Parallel()
+
+	codec := NewThanosQueryRangeCodec(true)
+	var (
+		rangeReq *ThanosQueryRangeRequest
+		result   []queryrange.SampleStream
+	)
+	queryRange := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		req, err := codec.DecodeRequest(r.Context(), r, nil)
+		if err != nil {
+			return nil, err
+		}
+		rangeReq = req.(*ThanosQueryRangeRequest)
+		return codec.EncodeResponse(r.Context(), &queryrange.PrometheusResponse{
+			Status: queryrange.StatusSuccess,
+			Data:   queryrange.PrometheusData{ResultType: "matrix", Result: result},
+		})
+	})
+	fallback := &queryrange.PrometheusInstantQueryResponse{Status: "fallback"}
+	h := SplitSubqueryMiddleware(time.Hour, queryRange, codec, prometheus.NewRegistry()).Wrap(
+		queryrange.HandlerFunc(func(context.Context, queryrange.Request) (queryrange.Response, error) {
+			return fallback, nil
+		}),
+	)
+	ctx := user.InjectOrgID(context.Background(), "tenant")
+	a := []cortexpb.LabelAdapter{{Name: "__name__", Value: "x"}, {Name: "job", Value: "a"}}
+	b := []cortexpb.LabelAdapter{{Name: "__name__", Value: "x"}, {Name: "job", Value: "b"}}
+	ts := (2*time.Hour + 30*time.Second).Milliseconds()
+
+	t.Run("split", func(t *testing.T) {
+		result = []queryrange.SampleStream{sampleStream(a, 1, 3, 2), sampleStream(b, 5)}
+		resp, err := h.Do(ctx, &ThanosQueryInstantRequest{
+			Path:  "/api/v1/query",
+			Time:  ts,
+			Query: "max_over_time(x[1h:1m] offset 10m)",
+			Dedup: true,
+		})
+		testutil.Ok(t, err)
+
+		// The multiples of the step in (t - 10m - 1h, t - 10m].
+		testutil.Equals(t, "/api/v1/query_range", rangeReq.Path)
+		testutil.Equals(t, "x", rangeReq.Query)
+		testutil.Equals(t, (51 * time.Minute).Milliseconds(), rangeReq.Start)
+		testutil.Equals(t, ts-(10*time.Minute).Milliseconds(), rangeReq.End)
+		testutil.Equals(t, time.Minute.Milliseconds(), rangeReq.Step)
+		testutil.Assert(t, rangeReq.Dedup)
+
+		testutil.Equals(t, []*queryrange.Sample{
+			{Labels: []cortexpb.LabelAdapter{{Name: "job", Value: "a"}}, SampleValue: 3, Timestamp: ts},
+			{Labels: []cortexpb.LabelAdapter{{Name: "job", Value: "b"}}, SampleValue: 5, Timestamp: ts},
+		}, resp.(*queryrange.PrometheusInstantQueryResponse).Data.Result.GetVector().Samples)
+	})
+	t.Run("functions", func(t *testing.T) {
+		result = []queryrange.SampleStream{sampleStream(a, 1, 3, 2)}
+		for fn, expected := range map[string]float64{
+			"sum_over_time":     6,
+			"avg_over_time":     2,
+			"count_over_time":   3,
+			"min_over_time":     1,
+			"last_over_time":    2,
+			"present_over_time": 1,
+		} {
+			resp, err := h.Do(ctx, &ThanosQueryInstantRequest{Path: "/api/v1/query", Time: ts, Query: fn + "(x[1h:1m])"})
+			testutil.Ok(t, err)
+			samples := resp.(*queryrange.PrometheusInstantQueryResponse).Data.Result.GetVector().Samples
+			testutil.Equals(t, 1, len(samples), fn)
+			testutil.Equals(t, expected, samples[0].SampleValue, fn)
+			// Only last_over_time keeps the metric name.
+			testutil.Equals(t, fn == "last_over_time", len(samples[0].Labels) == 2, fn)
+		}
+	})
+	t.Run("not split", func(t *testing.T) {
+		result = []queryrange.SampleStream{sampleStream(a, 1)}
+		for _, query := range []string{
+			"max_over_time(x[30m:1m])",
+			"max_over_time(x[1h:])",
+			"max_over_time(x[1h:1m] @ 100)",
+			"max_over_time(x[1h:1m] @ end())",
+			"max_over_time((x @ 100)[1h:1m])",
+			"rate(x[1h:1m])",
+			"max(max_over_time(x[1h:1m]))",
+			"max_over_time(x[1h])",
+		} {
+			resp, err := h.Do(ctx, &ThanosQueryInstantRequest{Path: "/api/v1/query", Time: ts, Query: query})
+			testutil.Ok(t, err)
+			testutil.Equals(t, fallback, resp, query)
+		}
+
+		// The querier evaluates the queries of the series with the same labels once their metric name is dropped.
+		result = []queryrange.SampleStream{
+			sampleStream(a, 1),
+			sampleStream([]cortexpb.LabelAdapter{{Name: "__name__", Value: "y"}, {Name: "job", Value: "a"}}, 2),
+		}
+		resp, err := h.Do(ctx, &ThanosQueryInstantRequest{Path: "/api/v1/query", Time: ts, Query: "max_over_time({job=\"a\"}[1h:1m])"})
+		testutil.Ok(t, err)
+		testutil.Equals(t, fallback, resp)
+	})
+}
+
+func TestRoundTripSplitSubquery(t *testing.T) {
+	t.Parallel()
+
+	tpw, err := NewTripperware(Config{
+		CortexHandlerConfig:          &transport.HandlerConfig{},
+		QueryRangeConfig:             QueryRangeConfig{Limits: defaultLimits, SplitQueriesByInterval: time.Hour},
+		LabelsConfig:                 LabelsConfig{Limits: defaultLimits},
+		InstantSubquerySplitMinRange: time.Hour,
+	}, nil, log.NewNopLogger())
+	testutil.Ok(t, err)
+
+	rt, err := newFakeRoundTripper()
+	testutil.Ok(t, err)
+	defer rt.Close()
+
+	var (
+		mtx   sync.Mutex
+		paths []string
+	)
+	codec := NewThanosQueryRangeCodec(true)
+	rt.setHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		paths = append(paths, r.URL.Path)
+		mtx.Unlock()
+		resp, err := codec.EncodeResponse(r.Context(), &queryrange.PrometheusResponse{
+			Status: queryrange.StatusSuccess,
+			Data: queryrange.PrometheusData{ResultType: "matrix", Result: []queryrange.SampleStream{
+				sampleStream([]cortexpb.LabelAdapter{{Name: "job", Value: "a"}}, 1),
+			}},
+		})
+		testutil.Ok(t, err)
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+	}))
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+	httpReq, err := NewThanosQueryInstantCodec(true).EncodeRequest(ctx, &ThanosQueryInstantRequest{
+		Path:  "/api/v1/query",
+		Time:  (3 * time.Hour).Milliseconds(),
+		Query: "max_over_time(rate(x[5m])[2h:1m])",
+	})
+	testutil.Ok(t, err)
+	resp, err := tpw(rt).RoundTrip(httpReq)
+	testutil.Ok(t, err)
+	testutil.Equals(t, http.StatusOK, resp.StatusCode)
+	testutil.Ok(t, resp.Body.Close())
+
+	// Only the range query of the subquery is sent, split by interval.
+	testutil.Equals(t, []string{"/api/v1/query_range", "/api/v1/query_range"}, paths)
+}
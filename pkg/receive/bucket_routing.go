@@ -0,0 +1,100 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/client"
+	"gopkg.in/yaml.v2"
+)
+
+// BucketRoutingConfig configures a routing domain of the write path, whose tenants have their blocks uploaded to the
+// bucket, or the prefix of a bucket, of the domain, e.g. for the data residency of the tenants or their storage tier.
+type BucketRoutingConfig struct {
+	// Name identifies the routing domain, recorded in the meta of the blocks of its tenants.
+	Name string `yaml:"name"`
+	// Tenants routed to the domain. A tenant matching several domains is routed to the first one.
+	Tenants           []string      `yaml:"tenants"`
+	TenantMatcherType tenantMatcher `yaml:"tenant_matcher_type"`
+	// Bucket is the object storage configuration of the bucket of the domain. The bucket of the receiver is used if
+	// empty, e.g. to route the domain to a prefix of it.
+	Bucket client.BucketConfig `yaml:"bucket"`
+	// Prefix of the blocks of the domain in its bucket, if any.
+	Prefix string `yaml:"prefix"`
+}
+
+// ParseBucketRoutingConfig parses the routing domains of the write path.
+func ParseBucketRoutingConfig(content []byte) ([]BucketRoutingConfig, error) {
+	var confs []BucketRoutingConfig
+	if err := yaml.UnmarshalStrict(content, &confs); err != nil {
+		return nil, errors.Wrap(err, "parsing bucket routing config YAML")
+	}
+	names := map[string]struct{}{}
+	for i, c := range confs {
+		if c.Name == "" {
+			return nil, errors.Errorf("routing domain %d has no name", i)
+		}
+		if _, ok := names[c.Name]; ok {
+			return nil, errors.Errorf("routing domain name %q is not unique", c.Name)
+		}
+		names[c.Name] = struct{}{}
+		if len(c.Tenants) == 0 {
+			return nil, errors.Errorf("routing domain %q has no tenants", c.Name)
+		}
+		switch c.TenantMatcherType {
+		case "", TenantMatcherTypeExact:
+		case TenantMatcherGlob:
+			for _, pattern := range c.Tenants {
+				if _, err := filepath.Match(pattern, ""); err != nil {
+					return nil, errors.Wrapf(err, "routing domain %q has an invalid tenant pattern %q", c.Name, pattern)
+				}
+			}
+		default:
+			return nil, errors.Errorf("routing domain %q has an unknown tenant matcher type %q", c.Name, c.TenantMatcherType)
+		}
+		if c.Bucket.Type == "" && strings.Trim(c.Prefix, "/") == "" {
+			return nil, errors.Errorf("routing domain %q has neither a bucket nor a prefix", c.Name)
+		}
+	}
+	return confs, nil
+}
+
+// BucketRoute uploads the blocks of the tenants of a routing domain to its bucket.
+type BucketRoute struct {
+	domain  string
+	tenants tenantSet
+	bucket  objstore.Bucket
+}
+
+// NewBucketRoute returns the route of the tenants of the routing domain to the bucket, below the prefix of the
+// domain if any.
+func NewBucketRoute(conf BucketRoutingConfig, bkt objstore.Bucket) BucketRoute {
+	tenants := make(tenantSet, len(conf.Tenants))
+	for _, t := range conf.Tenants {
+		tenants[t] = conf.TenantMatcherType
+	}
+	if prefix := strings.Trim(conf.Prefix, "/"); prefix != "" {
+		bkt = objstore.NewPrefixedBucket(bkt, prefix)
+	}
+	return BucketRoute{domain: conf.Name, tenants: tenants, bucket: bkt}
+}
+
+// routeBucket returns the routing domain and the bucket of the blocks of the tenant, the default bucket without
+// routing domain if no route matches it.
+func routeBucket(routes []BucketRoute, defaultBkt objstore.Bucket, tenantID string) (string, objstore.Bucket, error) {
+	for _, r := range routes {
+		ok, err := r.tenants.match(tenantID)
+		if err != nil {
+			return "", nil, err
+		}
+		if ok {
+			return r.domain, r.bucket, nil
+		}
+	}
+	return "", defaultBkt, nil
+}
@@ -0,0 +1,127 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+func TestParseBucketRoutingConfig(t *testing.T) {
+	t.Parallel()
+
+	confs, err := ParseBucketRoutingConfig([]byte(`
+- name: eu
+  tenants: ["eu-*"]
+  tenant_matcher_type: glob
+  bucket:
+    type: FILESYSTEM
+    config:
+      directory: /tmp/eu
+- name: cold
+  tenants: [archive]
+  prefix: cold
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(confs))
+	testutil.Equals(t, "FILESYSTEM", string(confs[0].Bucket.Type))
+	testutil.Equals(t, "cold", confs[1].Prefix)
+
+	for _, tcase := range []struct {
+		conf string
+		err  string
+	}{
+		{conf: `[{tenants: [a], prefix: a}]`, err: "has no name"},
+		{conf: `[{name: a, tenants: [a], prefix: a}, {name: a, tenants: [b], prefix: b}]`, err: "is not unique"},
+		{conf: `[{name: a, prefix: a}]`, err: "has no tenants"},
+		{conf: `[{name: a, tenants: [a]}]`, err: "neither a bucket nor a prefix"},
+		{conf: `[{name: a, tenants: ["["], tenant_matcher_type: glob, prefix: a}]`, err: "invalid tenant pattern"},
+		{conf: `[{name: a, tenants: [a], tenant_matcher_type: regex, prefix: a}]`, err: "unknown tenant matcher type"},
+		{conf: `[{name: a, tenants: [a], prefix: a, unknown: true}]`, err: "parsing bucket routing config YAML"},
+	} {
+		_, err := ParseBucketRoutingConfig([]byte(tcase.conf))
+		testutil.NotOk(t, err)
+		testutil.Assert(t, strings.Contains(err.Error(), tcase.err), "%s: %v", tcase.conf, err)
+	}
+}
+
+func TestMultiTSDBBucketRouting(t *testing.T) {
+	t.Parallel()
+
+	var (
+		defaultBkt = objstore.NewInMemBucket()
+		euBkt      = objstore.NewInMemBucket()
+	)
+	m := NewMultiTSDB(t.TempDir(), log.NewNopLogger(), prometheus.NewRegistry(),
+		&tsdb.Options{
+			MinBlockDuration:  (2 * time.Hour).Milliseconds(),
+			MaxBlockDuration:  (2 * time.Hour).Milliseconds(),
+			RetentionDuration: (6 * time.Hour).Milliseconds(),
+		},
+		labels.FromStrings("replica", "test"),
+		"tenant_id",
+		defaultBkt,
+		false,
+		false,
+		metadata.NoneFunc,
+		WithBucketRoutes(
+			NewBucketRoute(BucketRoutingConfig{Name: "eu", Tenants: []string{"eu-*"}, TenantMatcherType: TenantMatcherGlob}, euBkt),
+			NewBucketRoute(BucketRoutingConfig{Name: "cold", Tenants: []string{"archive"}, Prefix: "/cold/"}, defaultBkt),
+		),
+	)
+	defer func() { testutil.Ok(t, m.Close()) }()
+
+	for _, tenant := range []string{"eu-a", "archive", "other"} {
+		testutil.Ok(t, appendSample(m, tenant, time.UnixMilli(0)))
+	}
+	testutil.Ok(t, m.Flush())
+
+	ctx := context.Background()
+	uploaded, err := m.Sync(ctx)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, uploaded)
+
+	blockMeta := func(bkt objstore.Bucket, dir string) *metadata.Meta {
+		t.Helper()
+
+		var id ulid.ULID
+		testutil.Ok(t, bkt.Iter(ctx, dir, func(name string) error {
+			if bid, ok := block.IsBlockDir(name); ok {
+				id = bid
+			}
+			return nil
+		}))
+		meta, err := block.DownloadMeta(ctx, log.NewNopLogger(), objstore.NewPrefixedBucket(bkt, dir), id)
+		testutil.Ok(t, err)
+		return &meta
+	}
+
+	eu := blockMeta(euBkt, "")
+	testutil.Equals(t, "eu", eu.Thanos.RoutingDomain)
+	testutil.Equals(t, "eu-a", eu.Thanos.Labels["tenant_id"])
+
+	cold := blockMeta(defaultBkt, "cold")
+	testutil.Equals(t, "cold", cold.Thanos.RoutingDomain)
+	testutil.Equals(t, "archive", cold.Thanos.Labels["tenant_id"])
+
+	other := blockMeta(defaultBkt, "")
+	testutil.Equals(t, "", other.Thanos.RoutingDomain)
+	testutil.Equals(t, "other", other.Thanos.Labels["tenant_id"])
+
+	// The blocks of the routing domains are compacted separately.
+	testutil.Assert(t, cold.Thanos.GroupKey() != other.Thanos.GroupKey())
+}
@@ -125,6 +125,9 @@ type HashringConfig struct {
 	Endpoints         []Endpoint        `json:"endpoints"`
 	Algorithm         HashringAlgorithm `json:"algorithm,omitempty"`
 	ExternalLabels    labels.Labels     `json:"external_labels,omitempty"`
+	// If true, the endpoints of the hashring must not be in any other hashring, so that its
+	// tenants are isolated from the other tenants on dedicated receivers.
+	Isolated bool `json:"isolated,omitempty"`
 	// If non-zero then enable shuffle sharding.
 	ShuffleShardingConfig ShuffleShardingConfig `json:"shuffle_sharding_config,omitempty"`
 }
@@ -22,15 +22,14 @@ import (
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
-	"github.com/gogo/protobuf/proto"
 	"github.com/jpillora/backoff"
-	"github.com/klauspost/compress/s2"
 	"github.com/mwitkow/go-conntrack"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/route"
+	"github.com/prometheus/prometheus/config"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/prometheus/prometheus/storage"
@@ -43,8 +42,10 @@ import (
 	"google.golang.org/grpc/status"
 
 	"github.com/thanos-io/thanos/pkg/api"
+	cardinalityapi "github.com/thanos-io/thanos/pkg/api/cardinality"
 	statusapi "github.com/thanos-io/thanos/pkg/api/status"
 	"github.com/thanos-io/thanos/pkg/logging"
+	"github.com/thanos-io/thanos/pkg/metering"
 	"github.com/thanos-io/thanos/pkg/receive/writecapnp"
 
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
@@ -56,6 +57,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
 	"github.com/thanos-io/thanos/pkg/tenancy"
 	"github.com/thanos-io/thanos/pkg/tracing"
+	"github.com/thanos-io/thanos/pkg/walquota"
 )
 
 const (
@@ -77,6 +79,9 @@ type ReplicationProtocol string
 const (
 	ProtobufReplication  ReplicationProtocol = "protobuf"
 	CapNProtoReplication ReplicationProtocol = "capnproto"
+	// ProtobufV2Replication forwards series over gRPC as remote write 2.0 requests, which intern their label names
+	// and values. Receivers not supporting it yet are forwarded series with ProtobufReplication.
+	ProtobufV2Replication ReplicationProtocol = "protobuf-v2"
 )
 
 var (
@@ -105,10 +110,14 @@ type Options struct {
 	ReplicaHeader           string
 	Endpoint                string
 	ReplicationFactor       uint64
+	WriteQuorum             uint64
+	ReplicationRepair       ReplicationRepairOptions
 	SplitTenantLabelName    string
 	ReceiverMode            ReceiverMode
 	Tracer                  opentracing.Tracer
 	TLSConfig               *tls.Config
+	RBAC                    *middleware.RBAC
+	Meter                   *metering.Meter
 	DialOpts                []grpc.DialOption
 	ForwardTimeout          time.Duration
 	MaxBackoff              time.Duration
@@ -119,6 +128,7 @@ type Options struct {
 	ReplicationProtocol     ReplicationProtocol
 	OtlpEnableTargetInfo    bool
 	OtlpResourceAttributes  []string
+	OtlpDeltaToCumulative   OTLPDeltaToCumulativeOptions
 }
 
 // Handler serves a Prometheus remote write receiving HTTP endpoint.
@@ -145,6 +155,9 @@ type Handler struct {
 	pendingWriteRequests        prometheus.Gauge
 	pendingWriteRequestsCounter atomic.Int32
 
+	repairer          *replicationRepairer
+	deltaToCumulative *deltaToCumulative
+
 	Limiter *Limiter
 }
 
@@ -245,6 +258,14 @@ func NewHandler(logger log.Logger, o *Options) *Handler {
 		h.replicationFactor.Set(1)
 	}
 
+	if o.ReplicationRepair.QueueSize > 0 {
+		h.repairer = newReplicationRepairer(log.With(logger, "component", "replication-repair"), registerer, o.ReplicationRepair, h.repairWrite)
+	}
+
+	if o.OtlpDeltaToCumulative.Enabled {
+		h.deltaToCumulative = newDeltaToCumulative(registerer, o.OtlpDeltaToCumulative)
+	}
+
 	ins := extpromhttp.NewNopInstrumentationMiddleware()
 	if o.Registry != nil {
 		var buckets = []float64{0.001, 0.005, 0.01, 0.02, 0.03, 0.04, 0.05, 0.06, 0.07, 0.08, 0.09, 0.1, 0.25, 0.5, 0.75, 1, 2, 3, 4, 5}
@@ -305,10 +326,20 @@ func NewHandler(logger log.Logger, o *Options) *Handler {
 	})
 	statusAPI.Register(h.router, o.Tracer, logger, ins, logging.NewHTTPServerMiddleware(logger))
 
+	if o.TSDBStats != nil {
+		cardinalityAPI := cardinalityapi.NewCardinalityAPI(o.TSDBStats.Cardinality, o.TenantHeader, o.DefaultTenantID, o.TenantField)
+		cardinalityAPI.Register(h.router, o.Tracer, logger, ins, logging.NewHTTPServerMiddleware(logger))
+	}
+
 	errlog := stdlog.New(log.NewStdlibAdapter(level.Error(h.logger)), "", 0)
 
+	var handler http.Handler = h.router
+	if h.options.RBAC != nil {
+		// The remote write server does not verify basic auth passwords.
+		handler = h.options.RBAC.WrapCertificatesOnly(handler)
+	}
 	h.httpSrv = &http.Server{
-		Handler:   h.router,
+		Handler:   handler,
 		ErrorLog:  errlog,
 		TLSConfig: h.options.TLSConfig,
 	}
@@ -440,6 +471,9 @@ func (h *Handler) getStats(r *http.Request, statsByLabelName string) ([]statusap
 
 // Close stops the Handler.
 func (h *Handler) Close() {
+	if h.repairer != nil {
+		h.repairer.Close()
+	}
 	_ = h.peers.Close()
 	runutil.CloseWithLogOnErr(h.logger, h.httpSrv, "receive HTTP server")
 }
@@ -538,6 +572,13 @@ func (h *Handler) receiveHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	protoMsg, err := parseRemoteWriteProtoMsg(r.Header.Get("Content-Type"))
+	if err != nil {
+		level.Debug(tLogger).Log("msg", "unsupported remote write content type", "err", err)
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
 	requestLimiter := h.Limiter.RequestLimiter()
 	// io.ReadAll dynamically adjust the byte slice for read data, starting from 512B.
 	// Since this is receive hot path, grow upfront saving allocations and CPU time.
@@ -556,15 +597,20 @@ func (h *Handler) receiveHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, errors.Wrap(err, "read compressed request body").Error(), http.StatusInternalServerError)
 		return
 	}
-	reqBuf, err := s2.Decode(nil, compressed.Bytes())
+	reqBuf, err := decodeRemoteWriteBody(r.Header.Get("Content-Encoding"), compressed.Bytes(), func(size int64) bool {
+		return requestLimiter.AllowSizeBytes(tenantHTTP, size)
+	})
 	if err != nil {
-		level.Error(tLogger).Log("msg", "snappy decode error", "err", err)
-		http.Error(w, errors.Wrap(err, "snappy decode error").Error(), http.StatusBadRequest)
-		return
-	}
-
-	if !requestLimiter.AllowSizeBytes(tenantHTTP, int64(len(reqBuf))) {
-		http.Error(w, "write request too large", http.StatusRequestEntityTooLarge)
+		if errors.Is(err, errUnsupportedContentEncoding) {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		if errors.Is(err, errRequestTooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		level.Error(tLogger).Log("msg", "decode error", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -572,10 +618,12 @@ func (h *Handler) receiveHTTP(w http.ResponseWriter, r *http.Request) {
 	// from the whole request. Ensure that we always copy those when we want to
 	// store them for longer time.
 	var wreq prompb.WriteRequest
-	if err := proto.Unmarshal(reqBuf, &wreq); err != nil {
+	if err := unmarshalRemoteWriteRequest(protoMsg, reqBuf, &wreq); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	remoteWriteV2 := protoMsg == config.RemoteWriteProtoMsgV2
+	span.SetTag("remote_write.proto", string(protoMsg))
 
 	rep := uint64(0)
 	// If the header is empty, we assume the request is not yet replicated.
@@ -589,6 +637,9 @@ func (h *Handler) receiveHTTP(w http.ResponseWriter, r *http.Request) {
 	// Exit early if the request contained no data. We don't support metadata yet. We also cannot fail here, because
 	// this would mean lack of forward compatibility for remote write proto.
 	if len(wreq.Timeseries) == 0 {
+		if remoteWriteV2 {
+			setRemoteWriteV2WrittenHeaders(w.Header(), &wreq)
+		}
 		// TODO(yeya24): Handle remote write metadata.
 		if len(wreq.Metadata) > 0 {
 			// TODO(bwplotka): Do we need this error message?
@@ -631,6 +682,8 @@ func (h *Handler) receiveHTTP(w http.ResponseWriter, r *http.Request) {
 			responseStatusCode = http.StatusServiceUnavailable
 		case errConflict:
 			responseStatusCode = http.StatusConflict
+		case walquota.ErrExceeded:
+			responseStatusCode = http.StatusTooManyRequests
 		case errBadReplica:
 			responseStatusCode = http.StatusBadRequest
 		default:
@@ -638,11 +691,16 @@ func (h *Handler) receiveHTTP(w http.ResponseWriter, r *http.Request) {
 			responseStatusCode = http.StatusInternalServerError
 		}
 		http.Error(w, err.Error(), responseStatusCode)
+	} else if remoteWriteV2 {
+		setRemoteWriteV2WrittenHeaders(w.Header(), &wreq)
 	}
 
 	for tenant, stats := range tenantStats {
 		h.writeTimeseriesTotal.WithLabelValues(strconv.Itoa(responseStatusCode), tenant).Observe(float64(stats.timeseries))
 		h.writeSamplesTotal.WithLabelValues(strconv.Itoa(responseStatusCode), tenant).Observe(float64(stats.totalSamples))
+		if responseStatusCode == http.StatusOK {
+			h.options.Meter.AddIngestedSamples(tenant, stats.totalSamples)
+		}
 	}
 }
 
@@ -777,7 +835,7 @@ func (h *Handler) fanoutForward(ctx context.Context, params remoteWriteParams) (
 
 	logTags := []interface{}{"tenant", params.tenant}
 	if id, ok := middleware.RequestIDFromContext(ctx); ok {
-		logTags = append(logTags, "request-id", id)
+		logTags = append(logTags, logging.RequestIDKey, id)
 	}
 	requestLogger := log.With(h.logger, logTags...)
 
@@ -809,13 +867,27 @@ func (h *Handler) fanoutForward(ctx context.Context, params remoteWriteParams) (
 		close(responses)
 	}()
 
+	// failed holds the failed writes which have to be repaired in the background once the quorum is reached.
+	var (
+		failed         []writeResponse
+		quorumAchieved bool
+	)
+
 	// At the end, make sure to exhaust the channel, letting remaining unnecessary requests finish asynchronously.
 	// This is needed if context is canceled or if we reached success of fail quorum faster.
 	defer func() {
+		if quorumAchieved {
+			for _, resp := range failed {
+				h.scheduleRepair(resp, localWrites, remoteWrites)
+			}
+		}
 		go func() {
 			for resp := range responses {
 				if resp.err != nil {
 					level.Debug(requestLogger).Log("msg", "request failed, but not needed to achieve quorum", "err", resp.err)
+					if quorumAchieved {
+						h.scheduleRepair(resp, localWrites, remoteWrites)
+					}
 				}
 			}
 		}()
@@ -844,6 +916,9 @@ func (h *Handler) fanoutForward(ctx context.Context, params remoteWriteParams) (
 				for _, seriesID := range resp.seriesIDs {
 					seriesErrs[seriesID].Add(resp.err)
 				}
+				if h.repairer != nil && !params.alreadyReplicated {
+					failed = append(failed, resp)
+				}
 
 				continue
 			}
@@ -852,6 +927,7 @@ func (h *Handler) fanoutForward(ctx context.Context, params remoteWriteParams) (
 				successes[seriesID]++
 			}
 			if quorumReached(successes, quorum) {
+				quorumAchieved = h.repairer != nil && !params.alreadyReplicated
 				return stats, nil
 			}
 		}
@@ -1043,8 +1119,40 @@ func (h *Handler) sendRemoteWrite(
 	})
 }
 
+// scheduleRepair enqueues the series of a failed replica write for asynchronous repair.
+// Conflicts are not repaired as they indicate that the replica already has the data.
+func (h *Handler) scheduleRepair(resp writeResponse, writes ...map[endpointReplica]map[string]trackedSeries) {
+	if isConflict(errors.Cause(resp.err)) {
+		return
+	}
+	for _, req := range repairRequestsFor(resp, writes...) {
+		h.repairer.enqueue(req)
+	}
+}
+
+// repairWrite writes the series of a repair request to its endpoint replica.
+func (h *Handler) repairWrite(ctx context.Context, req repairRequest) error {
+	if req.er.endpoint.HasAddress(h.options.Endpoint) {
+		return h.writer.Write(ctx, req.tenant, req.timeSeries)
+	}
+	cl, err := h.peers.getConnection(ctx, req.er.endpoint)
+	if err != nil {
+		return err
+	}
+	_, err = cl.RemoteWrite(ctx, &storepb.WriteRequest{
+		Timeseries: req.timeSeries,
+		Tenant:     req.tenant,
+		// Increment replica since on-the-wire format is 1-indexed and 0 indicates un-replicated.
+		Replica: int64(req.er.replica + 1),
+	})
+	return err
+}
+
 // writeQuorum returns minimum number of replicas that has to confirm write success before claiming replication success.
 func (h *Handler) writeQuorum() int {
+	if h.options.WriteQuorum > 0 {
+		return int(min(h.options.WriteQuorum, max(h.options.ReplicationFactor, 1)))
+	}
 	// NOTE(GiedriusS): this is here because otherwise RF=2 doesn't make sense as all writes
 	// would need to succeed all the time. Another way to think about it is when migrating
 	// from a Sidecar based setup with 2 Prometheus nodes to a Receiver setup, we want to
@@ -1067,13 +1175,35 @@ func quorumReached(successes []int, successThreshold int) bool {
 
 // RemoteWrite implements the gRPC remote write handler for storepb.WriteableStore.
 func (h *Handler) RemoteWrite(ctx context.Context, r *storepb.WriteRequest) (*storepb.WriteResponse, error) {
+	return h.remoteWrite(ctx, r.Replica, r.Tenant, &prompb.WriteRequest{Timeseries: r.Timeseries})
+}
+
+// WriteableStoreV2 returns the gRPC remote write handler for storepb.WriteableStoreV2, which receives the series
+// forwarded by receivers using ProtobufV2Replication.
+func (h *Handler) WriteableStoreV2() storepb.WriteableStoreV2Server {
+	return writeableStoreV2{h: h}
+}
+
+type writeableStoreV2 struct {
+	h *Handler
+}
+
+func (s writeableStoreV2) RemoteWrite(ctx context.Context, r *storepb.WriteV2Request) (*storepb.WriteResponse, error) {
+	var wreq prompb.WriteRequest
+	if err := writeRequestFromV2(&r.Request, &wreq); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return s.h.remoteWrite(ctx, r.Replica, r.Tenant, &wreq)
+}
+
+func (h *Handler) remoteWrite(ctx context.Context, replica int64, tenant string, wreq *prompb.WriteRequest) (*storepb.WriteResponse, error) {
 	span, ctx := tracing.StartSpan(ctx, "receive_grpc")
 	defer span.Finish()
 
 	h.pendingWriteRequests.Set(float64(h.pendingWriteRequestsCounter.Add(1)))
 	defer h.pendingWriteRequestsCounter.Add(-1)
 
-	_, err := h.handleRequest(ctx, uint64(r.Replica), r.Tenant, &prompb.WriteRequest{Timeseries: r.Timeseries})
+	_, err := h.handleRequest(ctx, uint64(replica), tenant, wreq)
 	if err != nil {
 		level.Debug(h.logger).Log("msg", "failed to handle request", "err", err)
 	}
@@ -1086,6 +1216,8 @@ func (h *Handler) RemoteWrite(ctx context.Context, r *storepb.WriteRequest) (*st
 		return nil, status.Error(codes.Unavailable, err.Error())
 	case errConflict:
 		return nil, status.Error(codes.AlreadyExists, err.Error())
+	case walquota.ErrExceeded:
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
 	case errBadReplica:
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	default:
@@ -1161,6 +1293,13 @@ func isUnavailable(err error) bool {
 		status.Code(err) == codes.Unavailable
 }
 
+// isWALQuotaExceeded returns whether or not the given error represents a write to a tenant above its WAL disk quota.
+// Other resource exhausted errors of peers, e.g. of gRPC message size limits, are not.
+func isWALQuotaExceeded(err error) bool {
+	return err == walquota.ErrExceeded ||
+		(status.Code(err) == codes.ResourceExhausted && strings.Contains(status.Convert(err).Message(), walquota.ErrExceeded.Error()))
+}
+
 // retryState encapsulates the number of request attempt made against a peer and,
 // next allowed time for the next attempt.
 type retryState struct {
@@ -1271,6 +1410,7 @@ func (es *writeErrors) Cause() error {
 		{err: errUnavailable, cause: isUnavailable},
 		{err: errNotReady, cause: isNotReady},
 		{err: errConflict, cause: isConflict},
+		{err: walquota.ErrExceeded, cause: isWALQuotaExceeded},
 	}
 
 	var (
@@ -1321,6 +1461,7 @@ func (es *replicationErrors) Cause() error {
 		{err: errConflict, cause: isConflict},
 		{err: errNotReady, cause: isNotReady},
 		{err: errUnavailable, cause: isUnavailable},
+		{err: walquota.ErrExceeded, cause: isWALQuotaExceeded},
 	}
 	for _, exp := range expErrs {
 		exp.count = 0
@@ -1372,16 +1513,39 @@ type peerClient interface {
 type protobufPeer struct {
 	storepb.WriteableStoreClient
 	conn *grpc.ClientConn
+
+	// v2 forwards series as remote write 2.0 requests when set.
+	v2 storepb.WriteableStoreV2Client
+	// v2Unimplemented is set once the peer turned out not to support remote write 2.0 requests, e.g. because
+	// it was not upgraded yet.
+	v2Unimplemented atomic.Bool
 }
 
-func newProtobufPeer(conn *grpc.ClientConn) *protobufPeer {
-	return &protobufPeer{
+func newProtobufPeer(conn *grpc.ClientConn, v2 bool) *protobufPeer {
+	p := &protobufPeer{
 		WriteableStoreClient: storepb.NewWriteableStoreClient(conn),
 		conn:                 conn,
 	}
+	if v2 {
+		p.v2 = storepb.NewWriteableStoreV2Client(conn)
+	}
+	return p
+}
+
+// RemoteWrite forwards the series as a remote write 2.0 request if enabled and supported by the peer.
+func (p *protobufPeer) RemoteWrite(ctx context.Context, in *storepb.WriteRequest, opts ...grpc.CallOption) (*storepb.WriteResponse, error) {
+	if p.v2 == nil || p.v2Unimplemented.Load() {
+		return p.WriteableStoreClient.RemoteWrite(ctx, in, opts...)
+	}
+	resp, err := p.v2.RemoteWrite(ctx, writeV2RequestFromV1(in), opts...)
+	if status.Code(err) != codes.Unimplemented {
+		return resp, err
+	}
+	p.v2Unimplemented.Store(true)
+	return p.WriteableStoreClient.RemoteWrite(ctx, in, opts...)
 }
 
-func (p protobufPeer) Close() error {
+func (p *protobufPeer) Close() error {
 	return p.conn.Close()
 }
 
@@ -1517,14 +1681,14 @@ func (p *peerGroup) getConnection(ctx context.Context, endpoint Endpoint) (Write
 	case CapNProtoReplication:
 		client = writecapnp.NewRemoteWriteClient(writecapnp.NewTCPDialer(endpoint.CapNProtoAddress), p.logger)
 
-	case ProtobufReplication:
+	case ProtobufReplication, ProtobufV2Replication:
 		conn, err := p.dialer(endpoint.Address, p.dialOpts...)
 		if err != nil {
 			p.markPeerUnavailableUnlocked(endpoint)
 			dialError := errors.Wrap(err, "failed to dial peer")
 			return nil, errors.Wrap(dialError, errUnavailable.Error())
 		}
-		client = newProtobufPeer(conn)
+		client = newProtobufPeer(conn, p.replicationProtocol == ProtobufV2Replication)
 	default:
 		return nil, errors.Errorf("unknown replication protocol %v", p.replicationProtocol)
 	}
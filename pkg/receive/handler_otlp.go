@@ -16,6 +16,7 @@ import (
 	tprompb "github.com/thanos-io/thanos/pkg/store/storepb/prompb"
 	"github.com/thanos-io/thanos/pkg/tenancy"
 	"github.com/thanos-io/thanos/pkg/tracing"
+	"github.com/thanos-io/thanos/pkg/walquota"
 	"go.opentelemetry.io/collector/pdata/pmetric"
 )
 
@@ -73,6 +74,10 @@ func (h *Handler) receiveOTLPHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.deltaToCumulative != nil {
+		h.deltaToCumulative.translate(tenant, req.Metrics())
+	}
+
 	metrics, _, err := h.convertToPrometheusFormat(ctx, req.Metrics())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -139,6 +144,8 @@ func (h *Handler) receiveOTLPHTTP(w http.ResponseWriter, r *http.Request) {
 			responseStatusCode = http.StatusServiceUnavailable
 		case errConflict:
 			responseStatusCode = http.StatusConflict
+		case walquota.ErrExceeded:
+			responseStatusCode = http.StatusTooManyRequests
 		case errBadReplica:
 			responseStatusCode = http.StatusBadRequest
 		default:
@@ -151,6 +158,9 @@ func (h *Handler) receiveOTLPHTTP(w http.ResponseWriter, r *http.Request) {
 	for tenant, stats := range tenantStats {
 		h.writeTimeseriesTotal.WithLabelValues(strconv.Itoa(responseStatusCode), tenant).Observe(float64(stats.timeseries))
 		h.writeSamplesTotal.WithLabelValues(strconv.Itoa(responseStatusCode), tenant).Observe(float64(stats.totalSamples))
+		if responseStatusCode == http.StatusOK {
+			h.options.Meter.AddIngestedSamples(tenant, stats.totalSamples)
+		}
 	}
 
 }
@@ -0,0 +1,302 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+const (
+	appProtoContentType = "application/x-protobuf"
+
+	remoteWriteEncodingSnappy = "snappy"
+	remoteWriteEncodingZstd   = "zstd"
+
+	rw20WrittenSamplesHeader    = "X-Prometheus-Remote-Write-Samples-Written"
+	rw20WrittenHistogramsHeader = "X-Prometheus-Remote-Write-Histograms-Written"
+	rw20WrittenExemplarsHeader  = "X-Prometheus-Remote-Write-Exemplars-Written"
+)
+
+var (
+	errUnsupportedContentType     = errors.New("unsupported content type")
+	errUnsupportedContentEncoding = errors.New("unsupported content encoding")
+	errRequestTooLarge            = errors.New("write request too large")
+
+	// zstdDecoders pools zstd decoders, which decode streams synchronously with a concurrency of 1.
+	zstdDecoders = sync.Pool{New: func() any {
+		d, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		return d
+	}}
+)
+
+// parseRemoteWriteProtoMsg returns the remote write protobuf message announced by the given content type.
+// An empty content type or one without the proto parameter is treated as remote write 1.0 for backward compatibility.
+func parseRemoteWriteProtoMsg(contentType string) (config.RemoteWriteProtoMsg, error) {
+	contentType = strings.TrimSpace(contentType)
+	if contentType == "" {
+		return config.RemoteWriteProtoMsgV1, nil
+	}
+
+	parts := strings.Split(contentType, ";")
+	if strings.TrimSpace(parts[0]) != appProtoContentType {
+		return "", errors.Wrapf(errUnsupportedContentType, "expected %v media type, got %v", appProtoContentType, contentType)
+	}
+	for _, p := range parts[1:] {
+		key, value, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok {
+			return "", errors.Wrapf(errUnsupportedContentType, "malformed parameter %q in %v", p, contentType)
+		}
+		if key != "proto" {
+			continue
+		}
+		msg := config.RemoteWriteProtoMsg(value)
+		if err := msg.Validate(); err != nil {
+			return "", errors.Wrap(errUnsupportedContentType, err.Error())
+		}
+		return msg, nil
+	}
+	return config.RemoteWriteProtoMsgV1, nil
+}
+
+// decodeRemoteWriteBody decompresses the request body according to its content encoding.
+// Snappy is assumed when no encoding is provided, as required by remote write 1.0.
+// The decompressed size is checked with allowSize before it is all allocated, as the size of snappy bodies is
+// known upfront and zstd bodies are decompressed as a stream, and errRequestTooLarge is returned if it is not allowed.
+func decodeRemoteWriteBody(contentEncoding string, body []byte, allowSize func(int64) bool) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "", remoteWriteEncodingSnappy:
+		n, err := s2.DecodedLen(body)
+		if err != nil {
+			return nil, errors.Wrap(err, "snappy decode error")
+		}
+		if !allowSize(int64(n)) {
+			return nil, errRequestTooLarge
+		}
+		out, err := s2.Decode(nil, body)
+		return out, errors.Wrap(err, "snappy decode error")
+	case remoteWriteEncodingZstd:
+		return decodeZstd(body, allowSize)
+	default:
+		return nil, errors.Wrapf(errUnsupportedContentEncoding, "%v", contentEncoding)
+	}
+}
+
+// decodeZstd decompresses the zstd body as a stream, until its decompressed size is not allowed anymore.
+func decodeZstd(body []byte, allowSize func(int64) bool) ([]byte, error) {
+	d := zstdDecoders.Get().(*zstd.Decoder)
+	defer func() {
+		// Do not keep a reference to the body in the pool.
+		_ = d.Reset(nil)
+		zstdDecoders.Put(d)
+	}()
+	if err := d.Reset(bytes.NewReader(body)); err != nil {
+		return nil, errors.Wrap(err, "zstd decode error")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, 2*len(body)))
+	for {
+		// Decompress in chunks of about the size of the compressed body, at least 32KiB, so that a small body cannot
+		// expand into a lot of memory before its size is checked.
+		n, err := io.CopyN(out, d, int64(max(len(body), 32*1024)))
+		if n > 0 && !allowSize(int64(out.Len())) {
+			return nil, errRequestTooLarge
+		}
+		if err == io.EOF {
+			return out.Bytes(), nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "zstd decode error")
+		}
+	}
+}
+
+// unmarshalRemoteWriteRequest unmarshals the given decompressed body into a write request.
+// Remote write 2.0 requests are translated into the internal representation, resolving interned strings.
+func unmarshalRemoteWriteRequest(msg config.RemoteWriteProtoMsg, buf []byte, wreq *prompb.WriteRequest) error {
+	switch msg {
+	case config.RemoteWriteProtoMsgV1:
+		return proto.Unmarshal(buf, wreq)
+	case config.RemoteWriteProtoMsgV2:
+		var req writev2.Request
+		if err := proto.Unmarshal(buf, &req); err != nil {
+			return err
+		}
+		return writeRequestFromV2(&req, wreq)
+	default:
+		return errors.Wrapf(errUnsupportedContentType, "unknown remote write message %v", msg)
+	}
+}
+
+// writeRequestFromV2 converts a remote write 2.0 request into a remote write 1.0 like request, with the exemplars
+// of the series and their metadata as the metadata of their metric families. Metadata is then handled as for 1.0
+// requests.
+func writeRequestFromV2(req *writev2.Request, wreq *prompb.WriteRequest) error {
+	var (
+		b        = labels.NewScratchBuilder(0)
+		symbols  = req.Symbols
+		families = map[string]struct{}{}
+	)
+	wreq.Timeseries = make([]prompb.TimeSeries, 0, len(req.Timeseries))
+	for _, ts := range req.Timeseries {
+		if err := validateSymbolRefs(ts.LabelsRefs, symbols); err != nil {
+			return err
+		}
+		lset := ts.ToLabels(&b, symbols)
+		out := prompb.TimeSeries{
+			Labels: labelpb.ZLabelsFromPromLabels(lset),
+		}
+		if md, ok, err := metricMetadataFromV2(lset, ts.Metadata, symbols); err != nil {
+			return err
+		} else if ok {
+			if _, seen := families[md.MetricFamilyName]; !seen {
+				families[md.MetricFamilyName] = struct{}{}
+				wreq.Metadata = append(wreq.Metadata, md)
+			}
+		}
+		if len(ts.Samples) > 0 {
+			out.Samples = make([]prompb.Sample, 0, len(ts.Samples))
+			for _, s := range ts.Samples {
+				out.Samples = append(out.Samples, prompb.Sample{Value: s.Value, Timestamp: s.Timestamp})
+			}
+		}
+		if len(ts.Histograms) > 0 {
+			out.Histograms = make([]prompb.Histogram, 0, len(ts.Histograms))
+			for _, h := range ts.Histograms {
+				if h.IsFloatHistogram() {
+					out.Histograms = append(out.Histograms, prompb.FloatHistogramToHistogramProto(h.Timestamp, h.ToFloatHistogram()))
+				} else {
+					out.Histograms = append(out.Histograms, prompb.HistogramToHistogramProto(h.Timestamp, h.ToIntHistogram()))
+				}
+			}
+		}
+		if len(ts.Exemplars) > 0 {
+			out.Exemplars = make([]prompb.Exemplar, 0, len(ts.Exemplars))
+			for _, e := range ts.Exemplars {
+				if err := validateSymbolRefs(e.LabelsRefs, symbols); err != nil {
+					return err
+				}
+				ex := e.ToExemplar(&b, symbols)
+				out.Exemplars = append(out.Exemplars, prompb.Exemplar{
+					Labels:    labelpb.ZLabelsFromPromLabels(ex.Labels),
+					Value:     ex.Value,
+					Timestamp: ex.Ts,
+				})
+			}
+		}
+		wreq.Timeseries = append(wreq.Timeseries, out)
+	}
+	return nil
+}
+
+// writeV2RequestFromV1 converts a request forwarded to other receivers into a remote write 2.0 request, interning
+// the label names and values of its series and exemplars into a single symbol table.
+func writeV2RequestFromV1(req *storepb.WriteRequest) *storepb.WriteV2Request {
+	var (
+		st  = writev2.NewSymbolTable()
+		out = &storepb.WriteV2Request{Tenant: req.Tenant, Replica: req.Replica}
+	)
+	out.Request.Timeseries = make([]writev2.TimeSeries, 0, len(req.Timeseries))
+	for _, ts := range req.Timeseries {
+		v2 := writev2.TimeSeries{LabelsRefs: symbolizeZLabels(&st, ts.Labels)}
+		if len(ts.Samples) > 0 {
+			v2.Samples = make([]writev2.Sample, 0, len(ts.Samples))
+			for _, s := range ts.Samples {
+				v2.Samples = append(v2.Samples, writev2.Sample{Value: s.Value, Timestamp: s.Timestamp})
+			}
+		}
+		if len(ts.Histograms) > 0 {
+			v2.Histograms = make([]writev2.Histogram, 0, len(ts.Histograms))
+			for _, h := range ts.Histograms {
+				if h.IsFloatHistogram() {
+					v2.Histograms = append(v2.Histograms, writev2.FromFloatHistogram(h.Timestamp, prompb.FloatHistogramProtoToFloatHistogram(h)))
+				} else {
+					v2.Histograms = append(v2.Histograms, writev2.FromIntHistogram(h.Timestamp, prompb.HistogramProtoToHistogram(h)))
+				}
+			}
+		}
+		if len(ts.Exemplars) > 0 {
+			v2.Exemplars = make([]writev2.Exemplar, 0, len(ts.Exemplars))
+			for _, e := range ts.Exemplars {
+				v2.Exemplars = append(v2.Exemplars, writev2.Exemplar{
+					LabelsRefs: symbolizeZLabels(&st, e.Labels),
+					Value:      e.Value,
+					Timestamp:  e.Timestamp,
+				})
+			}
+		}
+		out.Request.Timeseries = append(out.Request.Timeseries, v2)
+	}
+	out.Request.Symbols = st.Symbols()
+	return out
+}
+
+// symbolizeZLabels returns the references of the label names and values in the symbol table.
+func symbolizeZLabels(st *writev2.SymbolsTable, lset []labelpb.ZLabel) []uint32 {
+	refs := make([]uint32, 0, 2*len(lset))
+	for _, l := range lset {
+		refs = append(refs, st.Symbolize(l.Name), st.Symbolize(l.Value))
+	}
+	return refs
+}
+
+// metricMetadataFromV2 returns the metadata of the metric family of the series, if any.
+func metricMetadataFromV2(lset labels.Labels, md writev2.Metadata, symbols []string) (prompb.MetricMetadata, bool, error) {
+	name := lset.Get(labels.MetricName)
+	if name == "" || (md.Type == writev2.Metadata_METRIC_TYPE_UNSPECIFIED && md.HelpRef == 0 && md.UnitRef == 0) {
+		return prompb.MetricMetadata{}, false, nil
+	}
+	if err := validateSymbolRefs([]uint32{md.HelpRef, md.UnitRef}, symbols); err != nil {
+		return prompb.MetricMetadata{}, false, err
+	}
+	return prompb.MetricMetadata{
+		Type:             prompb.MetricMetadata_MetricType(md.Type),
+		MetricFamilyName: name,
+		Help:             symbols[md.HelpRef],
+		Unit:             symbols[md.UnitRef],
+	}, true, nil
+}
+
+// validateSymbolRefs makes sure that label references point into the symbols table,
+// as desymbolizing malformed requests would panic otherwise.
+func validateSymbolRefs(refs []uint32, symbols []string) error {
+	if len(refs)%2 != 0 {
+		return errors.Errorf("invalid number of label references %d, must be even", len(refs))
+	}
+	for _, ref := range refs {
+		if int(ref) >= len(symbols) {
+			return errors.Errorf("label reference %d out of symbols table of size %d", ref, len(symbols))
+		}
+	}
+	return nil
+}
+
+// setRemoteWriteV2WrittenHeaders sets the remote write 2.0 response headers reporting what was written.
+func setRemoteWriteV2WrittenHeaders(h http.Header, wreq *prompb.WriteRequest) {
+	var samples, histograms, exemplars int
+	for _, ts := range wreq.Timeseries {
+		samples += len(ts.Samples)
+		histograms += len(ts.Histograms)
+		exemplars += len(ts.Exemplars)
+	}
+	h.Set(rw20WrittenSamplesHeader, strconv.Itoa(samples))
+	h.Set(rw20WrittenHistogramsHeader, strconv.Itoa(histograms))
+	h.Set(rw20WrittenExemplarsHeader, strconv.Itoa(exemplars))
+}
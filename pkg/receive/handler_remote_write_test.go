@@ -0,0 +1,398 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+	"github.com/prometheus/prometheus/storage"
+	"google.golang.org/grpc"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+func TestParseRemoteWriteProtoMsg(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		contentType string
+		expected    config.RemoteWriteProtoMsg
+		err         bool
+	}{
+		{contentType: "", expected: config.RemoteWriteProtoMsgV1},
+		{contentType: "application/x-protobuf", expected: config.RemoteWriteProtoMsgV1},
+		{contentType: "application/x-protobuf;proto=prometheus.WriteRequest", expected: config.RemoteWriteProtoMsgV1},
+		{contentType: "application/x-protobuf; proto=io.prometheus.write.v2.Request", expected: config.RemoteWriteProtoMsgV2},
+		{contentType: "application/x-protobuf;proto=io.prometheus.write.v3.Request", err: true},
+		{contentType: "application/json", err: true},
+		{contentType: "application/x-protobuf;proto", err: true},
+	} {
+		t.Run(tc.contentType, func(t *testing.T) {
+			msg, err := parseRemoteWriteProtoMsg(tc.contentType)
+			if tc.err {
+				testutil.NotOk(t, err)
+				testutil.Assert(t, errors.Is(err, errUnsupportedContentType))
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equals(t, tc.expected, msg)
+		})
+	}
+}
+
+func TestDecodeRemoteWriteBody(t *testing.T) {
+	t.Parallel()
+
+	payload := []byte("remote write payload")
+	allowAll := func(int64) bool { return true }
+
+	out, err := decodeRemoteWriteBody("", snappy.Encode(nil, payload), allowAll)
+	testutil.Ok(t, err)
+	testutil.Equals(t, payload, out)
+
+	out, err = decodeRemoteWriteBody("snappy", snappy.Encode(nil, payload), allowAll)
+	testutil.Ok(t, err)
+	testutil.Equals(t, payload, out)
+
+	enc, err := zstd.NewWriter(nil)
+	testutil.Ok(t, err)
+	out, err = decodeRemoteWriteBody("zstd", enc.EncodeAll(payload, nil), allowAll)
+	testutil.Ok(t, err)
+	testutil.Equals(t, payload, out)
+
+	_, err = decodeRemoteWriteBody("snappy", payload, allowAll)
+	testutil.NotOk(t, err)
+
+	_, err = decodeRemoteWriteBody("zstd", payload, allowAll)
+	testutil.NotOk(t, err)
+
+	_, err = decodeRemoteWriteBody("gzip", payload, allowAll)
+	testutil.Assert(t, errors.Is(err, errUnsupportedContentEncoding))
+
+	t.Run("decompressed size limit", func(t *testing.T) {
+		// Highly compressible bodies are rejected once they decompress beyond the limit.
+		large := make([]byte, 10<<20)
+		var decoded int64
+		allow := func(size int64) bool {
+			decoded = size
+			return size <= 1<<20
+		}
+
+		_, err := decodeRemoteWriteBody("snappy", snappy.Encode(nil, large), allow)
+		testutil.Assert(t, errors.Is(err, errRequestTooLarge), "unexpected error %v", err)
+		testutil.Equals(t, int64(len(large)), decoded)
+
+		compressed := enc.EncodeAll(large, nil)
+		_, err = decodeRemoteWriteBody("zstd", compressed, allow)
+		testutil.Assert(t, errors.Is(err, errRequestTooLarge), "unexpected error %v", err)
+		testutil.Assert(t, decoded < 2<<20, "decompressed %d bytes beyond the limit", decoded)
+
+		out, err := decodeRemoteWriteBody("zstd", compressed, func(size int64) bool { return size <= int64(len(large)) })
+		testutil.Ok(t, err)
+		testutil.Equals(t, large, out)
+	})
+}
+
+func TestWriteRequestFromV2(t *testing.T) {
+	t.Parallel()
+
+	st := writev2.NewSymbolTable()
+	h := &histogram.Histogram{
+		Count:           3,
+		Sum:             5,
+		Schema:          1,
+		ZeroThreshold:   0.001,
+		PositiveSpans:   []histogram.Span{{Offset: 0, Length: 1}},
+		PositiveBuckets: []int64{3},
+	}
+	req := &writev2.Request{
+		Timeseries: []writev2.TimeSeries{
+			{
+				LabelsRefs: st.SymbolizeLabels(labels.FromStrings("__name__", "up", "job", "foo"), nil),
+				Samples:    []writev2.Sample{{Value: 1, Timestamp: 10}, {Value: 2, Timestamp: 20}},
+				Exemplars: []writev2.Exemplar{{
+					LabelsRefs: st.SymbolizeLabels(labels.FromStrings("trace_id", "abc"), nil),
+					Value:      1,
+					Timestamp:  10,
+				}},
+				Metadata: writev2.Metadata{Type: writev2.Metadata_METRIC_TYPE_GAUGE, HelpRef: st.Symbolize("help")},
+			},
+			{
+				LabelsRefs: st.SymbolizeLabels(labels.FromStrings("__name__", "latency", "job", "foo"), nil),
+				Histograms: []writev2.Histogram{writev2.FromIntHistogram(30, h)},
+			},
+		},
+		Symbols: st.Symbols(),
+	}
+
+	var wreq prompb.WriteRequest
+	testutil.Ok(t, writeRequestFromV2(req, &wreq))
+	testutil.Equals(t, 2, len(wreq.Timeseries))
+
+	testutil.Equals(t, labels.FromStrings("__name__", "up", "job", "foo"), labelpb.ZLabelsToPromLabels(wreq.Timeseries[0].Labels))
+	testutil.Equals(t, []prompb.Sample{{Value: 1, Timestamp: 10}, {Value: 2, Timestamp: 20}}, wreq.Timeseries[0].Samples)
+	testutil.Equals(t, 1, len(wreq.Timeseries[0].Exemplars))
+	testutil.Equals(t, labels.FromStrings("trace_id", "abc"), labelpb.ZLabelsToPromLabels(wreq.Timeseries[0].Exemplars[0].Labels))
+
+	testutil.Equals(t, []prompb.MetricMetadata{{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "up", Help: "help"}}, wreq.Metadata)
+
+	testutil.Equals(t, 1, len(wreq.Timeseries[1].Histograms))
+	testutil.Equals(t, int64(30), wreq.Timeseries[1].Histograms[0].Timestamp)
+	testutil.Equals(t, h.String(), prompb.HistogramProtoToHistogram(wreq.Timeseries[1].Histograms[0]).String())
+
+	t.Run("out of range symbol reference", func(t *testing.T) {
+		bad := &writev2.Request{
+			Timeseries: []writev2.TimeSeries{{LabelsRefs: []uint32{0, 5}}},
+			Symbols:    []string{""},
+		}
+		testutil.NotOk(t, writeRequestFromV2(bad, &prompb.WriteRequest{}))
+
+		bad = &writev2.Request{
+			Timeseries: []writev2.TimeSeries{{LabelsRefs: []uint32{1, 2}, Metadata: writev2.Metadata{HelpRef: 5}}},
+			Symbols:    []string{"", "__name__", "up"},
+		}
+		testutil.NotOk(t, writeRequestFromV2(bad, &prompb.WriteRequest{}))
+	})
+}
+
+func TestWriteV2RequestFromV1(t *testing.T) {
+	t.Parallel()
+
+	h := &histogram.Histogram{
+		Count:           3,
+		Sum:             5,
+		Schema:          1,
+		ZeroThreshold:   0.001,
+		PositiveSpans:   []histogram.Span{{Offset: 0, Length: 1}},
+		PositiveBuckets: []int64{3},
+	}
+	req := &storepb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up", "job", "foo")),
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 10}, {Value: 2, Timestamp: 20}},
+				Exemplars: []prompb.Exemplar{{
+					Labels:    labelpb.ZLabelsFromPromLabels(labels.FromStrings("trace_id", "abc")),
+					Value:     1,
+					Timestamp: 10,
+				}},
+			},
+			{
+				Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "latency", "job", "foo")),
+				Histograms: []prompb.Histogram{
+					prompb.HistogramToHistogramProto(30, h),
+					prompb.FloatHistogramToHistogramProto(40, h.ToFloat(nil)),
+				},
+			},
+		},
+		Tenant:  "tenant",
+		Replica: 2,
+	}
+
+	buf, err := proto.Marshal(writeV2RequestFromV1(req))
+	testutil.Ok(t, err)
+
+	var v2 storepb.WriteV2Request
+	testutil.Ok(t, proto.Unmarshal(buf, &v2))
+	testutil.Equals(t, "tenant", v2.Tenant)
+	testutil.Equals(t, int64(2), v2.Replica)
+	// The label names and values shared by the series are interned once.
+	testutil.Equals(t, []string{"", "__name__", "up", "job", "foo", "trace_id", "abc", "latency"}, v2.Request.Symbols)
+
+	var wreq prompb.WriteRequest
+	testutil.Ok(t, writeRequestFromV2(&v2.Request, &wreq))
+	testutil.Equals(t, req.Timeseries, wreq.Timeseries)
+}
+
+func TestProtobufPeer_RemoteWriteV2(t *testing.T) {
+	t.Parallel()
+
+	appendable := &fakeAppendable{appender: newFakeAppender(nil, nil, nil)}
+	handlers, _, closeFn, err := newTestHandlerHashring([]*fakeAppendable{appendable}, 1, AlgorithmHashmod, false)
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, closeFn()) })
+	h := handlers[0]
+
+	write := func(t *testing.T, p *protobufPeer, lset labels.Labels) {
+		_, err := p.RemoteWrite(context.Background(), &storepb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{{
+				Labels:  labelpb.ZLabelsFromPromLabels(lset),
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 10}},
+			}},
+			Tenant: "tenant",
+		})
+		testutil.Ok(t, err)
+		testutil.Equals(t, []prompb.Sample{{Value: 1, Timestamp: 10}}, appendable.appender.(*fakeAppender).Get(lset))
+	}
+
+	t.Run("remote write 2.0", func(t *testing.T) {
+		v1 := &fakeWriteableStoreClient{h: h}
+		v2 := &fakeWriteableStoreV2Client{h: h.WriteableStoreV2()}
+		p := &protobufPeer{WriteableStoreClient: v1, v2: v2}
+
+		write(t, p, labels.FromStrings("__name__", "up", "job", "v2"))
+		testutil.Equals(t, 0, v1.calls)
+		testutil.Equals(t, 1, v2.calls)
+	})
+	t.Run("fall back to protobuf if unimplemented", func(t *testing.T) {
+		v1 := &fakeWriteableStoreClient{h: h}
+		v2 := &fakeWriteableStoreV2Client{h: &storepb.UnimplementedWriteableStoreV2Server{}}
+		p := &protobufPeer{WriteableStoreClient: v1, v2: v2}
+
+		write(t, p, labels.FromStrings("__name__", "up", "job", "v1"))
+		write(t, p, labels.FromStrings("__name__", "up", "job", "v1-again"))
+		testutil.Equals(t, 2, v1.calls)
+		testutil.Equals(t, 1, v2.calls)
+	})
+}
+
+type fakeWriteableStoreClient struct {
+	h     storepb.WriteableStoreServer
+	calls int
+}
+
+func (f *fakeWriteableStoreClient) RemoteWrite(ctx context.Context, in *storepb.WriteRequest, _ ...grpc.CallOption) (*storepb.WriteResponse, error) {
+	f.calls++
+	return f.h.RemoteWrite(ctx, in)
+}
+
+type fakeWriteableStoreV2Client struct {
+	h     storepb.WriteableStoreV2Server
+	calls int
+}
+
+func (f *fakeWriteableStoreV2Client) RemoteWrite(ctx context.Context, in *storepb.WriteV2Request, _ ...grpc.CallOption) (*storepb.WriteResponse, error) {
+	f.calls++
+	return f.h.RemoteWrite(ctx, in)
+}
+
+func TestReceiveHTTPRemoteWriteV2(t *testing.T) {
+	t.Parallel()
+
+	appendable := &fakeAppendable{appender: newFakeAppender(nil, nil, nil)}
+	handlers, _, closeFn, err := newTestHandlerHashring([]*fakeAppendable{appendable}, 1, AlgorithmHashmod, false)
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, closeFn()) })
+	h := handlers[0]
+
+	st := writev2.NewSymbolTable()
+	lset := labels.FromStrings("__name__", "up", "job", "foo")
+	req := &writev2.Request{
+		Timeseries: []writev2.TimeSeries{{
+			LabelsRefs: st.SymbolizeLabels(lset, nil),
+			Samples:    []writev2.Sample{{Value: 1, Timestamp: 10}},
+		}},
+		Symbols: st.Symbols(),
+	}
+	buf, err := proto.Marshal(req)
+	testutil.Ok(t, err)
+
+	enc, err := zstd.NewWriter(nil)
+	testutil.Ok(t, err)
+
+	httpReq, err := http.NewRequest("POST", h.options.Endpoint, bytes.NewBuffer(enc.EncodeAll(buf, nil)))
+	testutil.Ok(t, err)
+	httpReq.Header.Set("Content-Type", "application/x-protobuf;proto=io.prometheus.write.v2.Request")
+	httpReq.Header.Set("Content-Encoding", "zstd")
+	httpReq.Header.Set(tenancy.DefaultTenantHeader, "tenant")
+
+	rec := httptest.NewRecorder()
+	h.receiveHTTP(rec, httpReq)
+	testutil.Equals(t, http.StatusOK, rec.Code, rec.Body.String())
+	testutil.Equals(t, "1", rec.Header().Get(rw20WrittenSamplesHeader))
+	testutil.Equals(t, "0", rec.Header().Get(rw20WrittenHistogramsHeader))
+	testutil.Equals(t, []prompb.Sample{{Value: 1, Timestamp: 10}}, appendable.appender.(*fakeAppender).Get(lset))
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		httpReq, err := http.NewRequest("POST", h.options.Endpoint, bytes.NewBuffer(nil))
+		testutil.Ok(t, err)
+		httpReq.Header.Set("Content-Type", "application/x-protobuf;proto=io.prometheus.write.v3.Request")
+		httpReq.Header.Set(tenancy.DefaultTenantHeader, "tenant")
+
+		rec := httptest.NewRecorder()
+		h.receiveHTTP(rec, httpReq)
+		testutil.Equals(t, http.StatusUnsupportedMediaType, rec.Code)
+	})
+}
+
+func TestReceiveHTTPRemoteWriteV2_Replication(t *testing.T) {
+	t.Parallel()
+
+	appendables := []*fakeAppendable{
+		{appender: newFakeAppender(nil, nil, nil)},
+		{appender: newFakeAppender(nil, nil, nil)},
+		{appender: newFakeAppender(nil, nil, nil)},
+	}
+	handlers, _, closeFn, err := newTestHandlerHashring(appendables, 3, AlgorithmHashmod, false)
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, closeFn()) })
+	h := handlers[0]
+
+	st := writev2.NewSymbolTable()
+	lset := labels.FromStrings("__name__", "up", "job", "foo")
+	req := &writev2.Request{
+		Timeseries: []writev2.TimeSeries{{
+			LabelsRefs: st.SymbolizeLabels(lset, nil),
+			Samples:    []writev2.Sample{{Value: 1, Timestamp: 10}},
+			Exemplars: []writev2.Exemplar{{
+				LabelsRefs: st.SymbolizeLabels(labels.FromStrings("trace_id", "abc"), nil),
+				Value:      1,
+				Timestamp:  10,
+			}},
+			Metadata: writev2.Metadata{Type: writev2.Metadata_METRIC_TYPE_GAUGE, HelpRef: st.Symbolize("help")},
+		}},
+		Symbols: st.Symbols(),
+	}
+	buf, err := proto.Marshal(req)
+	testutil.Ok(t, err)
+
+	httpReq, err := http.NewRequest("POST", h.options.Endpoint, bytes.NewBuffer(snappy.Encode(nil, buf)))
+	testutil.Ok(t, err)
+	httpReq.Header.Set("Content-Type", "application/x-protobuf;proto=io.prometheus.write.v2.Request")
+	httpReq.Header.Set(tenancy.DefaultTenantHeader, "tenant")
+
+	rec := httptest.NewRecorder()
+	h.receiveHTTP(rec, httpReq)
+	testutil.Equals(t, http.StatusOK, rec.Code, rec.Body.String())
+	testutil.Equals(t, "1", rec.Header().Get(rw20WrittenExemplarsHeader))
+
+	// The samples and exemplars are forwarded to all the replicas. The request returns
+	// once the write quorum is reached, so the last replica may be written asynchronously.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for i, a := range appendables {
+		app := a.appender.(*fakeAppender)
+		var exemplars []exemplar.Exemplar
+		testutil.Ok(t, runutil.Retry(100*time.Millisecond, ctx.Done(), func() error {
+			app.Lock()
+			exemplars = app.exemplars[storage.SeriesRef(lset.Hash())]
+			app.Unlock()
+			if len(exemplars) != 1 {
+				return errors.Errorf("replica %d: expected 1 exemplar, got %d", i, len(exemplars))
+			}
+			return nil
+		}))
+		testutil.Equals(t, []prompb.Sample{{Value: 1, Timestamp: 10}}, app.Get(lset), "replica %d", i)
+		testutil.Equals(t, labels.FromStrings("trace_id", "abc"), exemplars[0].Labels)
+	}
+}
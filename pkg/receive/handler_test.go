@@ -42,6 +42,8 @@ import (
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 
 	"github.com/thanos-io/thanos/pkg/block/metadata"
@@ -53,6 +55,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
 	"github.com/thanos-io/thanos/pkg/tenancy"
+	"github.com/thanos-io/thanos/pkg/walquota"
 )
 
 type fakeTenantAppendable struct {
@@ -1956,3 +1959,10 @@ func TestHandlerFlippingHashrings(t *testing.T) {
 	cancel()
 	wg.Wait()
 }
+
+func TestIsWALQuotaExceeded(t *testing.T) {
+	testutil.Assert(t, isWALQuotaExceeded(walquota.ErrExceeded))
+	testutil.Assert(t, isWALQuotaExceeded(status.Error(codes.ResourceExhausted, errors.Wrap(walquota.ErrExceeded, "tenant a").Error())))
+	testutil.Assert(t, !isWALQuotaExceeded(status.Error(codes.ResourceExhausted, "grpc: received message larger than max")))
+	testutil.Assert(t, !isWALQuotaExceeded(status.Error(codes.Unavailable, walquota.ErrExceeded.Error())))
+}
@@ -649,6 +649,9 @@ func (s *shuffleShardHashring) GetN(tenant string, ts *prompb.TimeSeries, n uint
 // Which hashring to use for a tenant is determined
 // by the tenants field of the hashring configuration.
 func NewMultiHashring(algorithm HashringAlgorithm, replicationFactor uint64, cfg []HashringConfig, reg prometheus.Registerer) (Hashring, error) {
+	if err := validateIsolatedHashrings(cfg); err != nil {
+		return nil, err
+	}
 	m := &multiHashring{
 		cache: make(map[string]Hashring),
 	}
@@ -681,6 +684,28 @@ func NewMultiHashring(algorithm HashringAlgorithm, replicationFactor uint64, cfg
 	return m, nil
 }
 
+// validateIsolatedHashrings returns an error if an endpoint of an isolated hashring is in any other hashring.
+func validateIsolatedHashrings(cfg []HashringConfig) error {
+	for i, h := range cfg {
+		if !h.Isolated {
+			continue
+		}
+		for j, other := range cfg {
+			if i == j {
+				continue
+			}
+			for _, e := range h.Endpoints {
+				for _, o := range other.Endpoints {
+					if o.Address == e.Address {
+						return fmt.Errorf("endpoint %s of isolated hashring %s is also in hashring %s", e.Address, h.Hashring, other.Hashring)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func newHashring(algorithm HashringAlgorithm, endpoints []Endpoint, replicationFactor uint64, hashring string, tenants []string, shuffleShardingConfig ShuffleShardingConfig, reg prometheus.Registerer) (Hashring, error) {
 
 	switch algorithm {
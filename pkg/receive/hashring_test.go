@@ -673,6 +673,43 @@ func TestInvalidAZHashringCfg(t *testing.T) {
 	}
 }
 
+func TestIsolatedHashringCfg(t *testing.T) {
+	t.Parallel()
+
+	isolated := HashringConfig{Hashring: "premium", Tenants: []string{"noisy"}, Isolated: true, Endpoints: []Endpoint{{Address: "a"}, {Address: "b"}}}
+	for _, tt := range []struct {
+		name          string
+		cfg           []HashringConfig
+		expectedError string
+	}{
+		{
+			name: "separate endpoints",
+			cfg:  []HashringConfig{isolated, {Hashring: "shared", Endpoints: []Endpoint{{Address: "c"}, {Address: "d"}}}},
+		},
+		{
+			name: "shared endpoints of not isolated hashrings",
+			cfg: []HashringConfig{
+				{Hashring: "premium", Tenants: []string{"noisy"}, Endpoints: []Endpoint{{Address: "a"}}},
+				{Hashring: "shared", Endpoints: []Endpoint{{Address: "a"}, {Address: "b"}}},
+			},
+		},
+		{
+			name:          "shared endpoints",
+			cfg:           []HashringConfig{isolated, {Hashring: "shared", Endpoints: []Endpoint{{Address: "b"}, {Address: "c"}}}},
+			expectedError: "endpoint b of isolated hashring premium is also in hashring shared",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMultiHashring(AlgorithmKetama, 1, tt.cfg, prometheus.NewRegistry())
+			if tt.expectedError == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tt.expectedError)
+		})
+	}
+}
+
 func TestShuffleShardHashring(t *testing.T) {
 	t.Parallel()
 
@@ -7,6 +7,7 @@ import (
 	"context"
 	"net/http"
 	"net/url"
+	"path"
 	"sync"
 	"time"
 
@@ -14,10 +15,12 @@ import (
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/expfmt"
 
 	"github.com/thanos-io/thanos/pkg/clientconfig"
 	"github.com/thanos-io/thanos/pkg/errors"
 	"github.com/thanos-io/thanos/pkg/promclient"
+	"github.com/thanos-io/thanos/pkg/runutil"
 )
 
 // headSeriesLimit implements headSeriesLimiter interface.
@@ -30,6 +33,7 @@ type headSeriesLimit struct {
 	metaMonitoringURL    *url.URL
 	metaMonitoringClient *http.Client
 	metaMonitoringQuery  string
+	peerURLs             []*url.URL
 
 	configuredTenantLimit *prometheus.GaugeVec
 	limitedRequests       *prometheus.CounterVec
@@ -42,6 +46,7 @@ func NewHeadSeriesLimit(w WriteLimitsConfig, registerer prometheus.Registerer, l
 	limit := &headSeriesLimit{
 		metaMonitoringURL:   w.GlobalLimits.metaMonitoringURL,
 		metaMonitoringQuery: w.GlobalLimits.MetaMonitoringLimitQuery,
+		peerURLs:            w.GlobalLimits.peerURLs,
 		defaultLimit:        w.DefaultLimits.HeadSeriesLimit,
 		configuredTenantLimit: promauto.With(registerer).NewGaugeVec(
 			prometheus.GaugeOpts{
@@ -102,9 +107,14 @@ func NewHeadSeriesLimit(w WriteLimitsConfig, registerer prometheus.Registerer, l
 }
 
 // QueryMetaMonitoring queries any Prometheus Query API compatible meta-monitoring
-// solution with the configured query for getting current active (head) series of all tenants.
+// solution with the configured query for getting current active (head) series of all tenants,
+// or the metrics of the peers if configured.
 // It then populates tenantCurrentSeries map with result.
 func (h *headSeriesLimit) QueryMetaMonitoring(ctx context.Context) error {
+	if len(h.peerURLs) > 0 {
+		return h.queryPeers(ctx)
+	}
+
 	c := promclient.NewWithTracingClient(h.logger, h.metaMonitoringClient, clientconfig.ThanosUserAgent)
 
 	vectorRes, _, _, err := c.QueryInstant(ctx, h.metaMonitoringURL, h.metaMonitoringQuery, time.Now(), promclient.QueryOptions{Deduplicate: true})
@@ -130,6 +140,60 @@ func (h *headSeriesLimit) QueryMetaMonitoring(ctx context.Context) error {
 	return nil
 }
 
+// queryPeers sums up the active (head) series of all tenants exposed in the metrics of all the peers, so that
+// receivers enforce the limits across all replicas without meta-monitoring. The map is left as it is if any
+// peer fails, as the sum would be partial.
+func (h *headSeriesLimit) queryPeers(ctx context.Context) error {
+	tenantCurrentSeries := map[string]float64{}
+	for _, peer := range h.peerURLs {
+		if err := h.queryPeer(ctx, peer, tenantCurrentSeries); err != nil {
+			h.metaMonitoringErr.Inc()
+			return err
+		}
+	}
+
+	level.Debug(h.logger).Log("msg", "successfully queried peers", "peers", len(h.peerURLs), "tenants", len(tenantCurrentSeries))
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	for tenant, v := range tenantCurrentSeries {
+		h.tenantCurrentSeriesMap[tenant] = v
+	}
+	return nil
+}
+
+func (h *headSeriesLimit) queryPeer(ctx context.Context, peer *url.URL, tenantCurrentSeries map[string]float64) error {
+	u := *peer
+	u.Path = path.Join(u.Path, "/metrics")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return errors.Wrapf(err, "create request")
+	}
+	resp, err := h.metaMonitoringClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "request metrics of peer %s", u.String())
+	}
+	defer runutil.ExhaustCloseWithLogOnErr(h.logger, resp.Body, "peer metrics body")
+	if resp.StatusCode != http.StatusOK {
+		return errors.Newf("request metrics of peer %s: unexpected status %s", u.String(), resp.Status)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "parse metrics of peer %s", u.String())
+	}
+	for _, m := range families["prometheus_tsdb_head_series"].GetMetric() {
+		for _, l := range m.GetLabel() {
+			if l.GetName() == "tenant" {
+				tenantCurrentSeries[l.GetValue()] += m.GetGauge().GetValue()
+			}
+		}
+	}
+	return nil
+}
+
 // isUnderLimit ensures that the current number of active series for a tenant does not exceed given limit.
 // It does so in a best-effort way, i.e, in case meta-monitoring is unreachable, it does not impose limits.
 func (h *headSeriesLimit) isUnderLimit(tenant string) (bool, error) {
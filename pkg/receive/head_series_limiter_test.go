@@ -0,0 +1,79 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestHeadSeriesLimit_QueryPeers(t *testing.T) {
+	t.Parallel()
+
+	newPeer := func(metrics string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			testutil.Equals(t, "/metrics", r.URL.Path)
+			fmt.Fprint(w, metrics)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	peer0 := newPeer(`# TYPE prometheus_tsdb_head_series gauge
+prometheus_tsdb_head_series{tenant="a"} 600
+prometheus_tsdb_head_series{tenant="b"} 10
+`)
+	peer1 := newPeer(`# TYPE prometheus_tsdb_head_series gauge
+prometheus_tsdb_head_series{tenant="a"} 600
+`)
+
+	newLimit := func(peers ...string) *headSeriesLimit {
+		content := "write:\n  global:\n    peer_urls:\n"
+		for _, p := range peers {
+			content += fmt.Sprintf("    - %s\n", p)
+		}
+		content += "  default:\n    head_series_limit: 1000\n"
+		cfg, err := ParseRootLimitConfig([]byte(content))
+		testutil.Ok(t, err)
+		testutil.Assert(t, cfg.AreHeadSeriesLimitsConfigured())
+		return NewHeadSeriesLimit(cfg.WriteLimits, prometheus.NewRegistry(), log.NewNopLogger())
+	}
+
+	l := newLimit(peer0.URL, peer1.URL)
+	testutil.Ok(t, l.QueryMetaMonitoring(context.Background()))
+
+	// Tenant a is above the limit across both peers, while each peer is under it.
+	under, err := l.isUnderLimit("a")
+	testutil.Ok(t, err)
+	testutil.Assert(t, !under)
+	under, err = l.isUnderLimit("b")
+	testutil.Ok(t, err)
+	testutil.Assert(t, under)
+
+	// A partial sum is not used.
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(unavailable.Close)
+	l = newLimit(peer0.URL, peer1.URL, unavailable.URL)
+	testutil.NotOk(t, l.QueryMetaMonitoring(context.Background()))
+	_, err = l.isUnderLimit("a")
+	testutil.NotOk(t, err)
+}
+
+func TestParseLimiterConfig_PeerURLs(t *testing.T) {
+	t.Parallel()
+
+	_, err := ParseRootLimitConfig([]byte("write:\n  global:\n    peer_urls: [\"receive-0:10902\"]\n"))
+	testutil.NotOk(t, err)
+
+	_, err = ParseRootLimitConfig([]byte("write:\n  global:\n    meta_monitoring_url: \"http://localhost:9090\"\n    peer_urls: [\"http://receive-0:10902\"]\n"))
+	testutil.NotOk(t, err)
+}
@@ -40,6 +40,20 @@ func ParseRootLimitConfig(content []byte) (*RootLimitsConfig, error) {
 		root.WriteLimits.GlobalLimits.metaMonitoringURL = u
 	}
 
+	if len(root.WriteLimits.GlobalLimits.PeerURLs) > 0 && root.WriteLimits.GlobalLimits.MetaMonitoringURL != "" {
+		return nil, errors.Newf("only one of meta-monitoring URL and peer URLs can be set")
+	}
+	for _, peer := range root.WriteLimits.GlobalLimits.PeerURLs {
+		u, err := url.Parse(peer)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing peer URL")
+		}
+		if u.Host == "" || u.Scheme == "" {
+			return nil, errors.Newf("%s is not a valid peer URL (scheme: %s,host: %s)", u, u.Scheme, u.Host)
+		}
+		root.WriteLimits.GlobalLimits.peerURLs = append(root.WriteLimits.GlobalLimits.peerURLs, u)
+	}
+
 	// Set default query if none specified.
 	if root.WriteLimits.GlobalLimits.MetaMonitoringLimitQuery == "" {
 		root.WriteLimits.GlobalLimits.MetaMonitoringLimitQuery = "sum(prometheus_tsdb_head_series) by (tenant)"
@@ -49,7 +63,7 @@ func ParseRootLimitConfig(content []byte) (*RootLimitsConfig, error) {
 }
 
 func (r RootLimitsConfig) AreHeadSeriesLimitsConfigured() bool {
-	return r.WriteLimits.GlobalLimits.MetaMonitoringURL != "" && (len(r.WriteLimits.TenantsLimits) != 0 || r.WriteLimits.DefaultLimits.HeadSeriesLimit != 0)
+	return (r.WriteLimits.GlobalLimits.MetaMonitoringURL != "" || len(r.WriteLimits.GlobalLimits.PeerURLs) != 0) && (len(r.WriteLimits.TenantsLimits) != 0 || r.WriteLimits.DefaultLimits.HeadSeriesLimit != 0)
 }
 
 type WriteLimitsConfig struct {
@@ -68,8 +82,12 @@ type GlobalLimitsConfig struct {
 	MetaMonitoringURL        string                         `yaml:"meta_monitoring_url"`
 	MetaMonitoringHTTPClient *clientconfig.HTTPClientConfig `yaml:"meta_monitoring_http_client"`
 	MetaMonitoringLimitQuery string                         `yaml:"meta_monitoring_limit_query"`
+	// PeerURLs are the HTTP addresses of all the receivers, whose head series are summed up in head series limiting
+	// instead of querying meta-monitoring. The meta-monitoring HTTP client is used to request them.
+	PeerURLs []string `yaml:"peer_urls"`
 
 	metaMonitoringURL *url.URL
+	peerURLs          []*url.URL
 }
 
 type DefaultLimitsConfig struct {
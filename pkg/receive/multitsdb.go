@@ -6,11 +6,13 @@ package receive
 import (
 	"context"
 	"fmt"
+	"maps"
 	"os"
 	"path"
 	"path/filepath"
 	"slices"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,6 +22,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb"
@@ -30,24 +33,31 @@ import (
 
 	"github.com/thanos-io/thanos/pkg/api/status"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/cardinality"
 	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/errutil"
 	"github.com/thanos-io/thanos/pkg/exemplars"
+	"github.com/thanos-io/thanos/pkg/exemplars/exemplarspb"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	"github.com/thanos-io/thanos/pkg/info/infopb"
 	"github.com/thanos-io/thanos/pkg/logutil"
 	"github.com/thanos-io/thanos/pkg/receive/expandedpostingscache"
+	"github.com/thanos-io/thanos/pkg/runutil"
 	"github.com/thanos-io/thanos/pkg/shipper"
 	"github.com/thanos-io/thanos/pkg/store"
 	storecache "github.com/thanos-io/thanos/pkg/store/cache"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/walquota"
 )
 
 type TSDBStats interface {
 	// TenantStats returns TSDB head stats for the given tenants.
 	// If no tenantIDs are provided, stats for all tenants are returned.
 	TenantStats(limit int, statsByLabelName string, tenantIDs ...string) []status.TenantStats
+	// Cardinality returns the cardinality of the series of the head of the tenant, if it overlaps with mint
+	// and maxt.
+	Cardinality(ctx context.Context, tenantID string, mint, maxt int64, limit int) (cardinality.Stats, error)
 }
 
 type MultiTSDB struct {
@@ -75,6 +85,22 @@ type MultiTSDB struct {
 
 	headExpandedPostingsCacheSize  uint64
 	blockExpandedPostingsCacheSize uint64
+
+	shipperOptions []shipper.Option
+	shipExemplars  bool
+	bucketRoutes   []BucketRoute
+
+	fastRecoveryMaxDataLoss time.Duration
+	fastRecoveryMetrics     *fastRecoveryMetrics
+	now                     func() time.Time
+
+	walQuota *walquota.Quota
+}
+
+type fastRecoveryMetrics struct {
+	skipped        *prometheus.CounterVec
+	skippedMinTime *prometheus.GaugeVec
+	skippedMaxTime *prometheus.GaugeVec
 }
 
 // MultiTSDBOption is a functional option for MultiTSDB.
@@ -99,6 +125,45 @@ func WithBlockExpandedPostingsCacheSize(size uint64) MultiTSDBOption {
 	}
 }
 
+// WithShipperOptions sets additional options of the shippers of the tenants.
+func WithShipperOptions(opts ...shipper.Option) MultiTSDBOption {
+	return func(s *MultiTSDB) {
+		s.shipperOptions = append(s.shipperOptions, opts...)
+	}
+}
+
+// WithBucketRoutes uploads the blocks of the tenants of the routes to the buckets of their routing domains, instead of
+// the bucket of the MultiTSDB, recording the routing domain in their meta.
+func WithBucketRoutes(routes ...BucketRoute) MultiTSDBOption {
+	return func(s *MultiTSDB) {
+		s.bucketRoutes = append(s.bucketRoutes, routes...)
+	}
+}
+
+// WithShippedExemplars persists the exemplars of the tenants into the blocks their shippers upload.
+func WithShippedExemplars() MultiTSDBOption {
+	return func(s *MultiTSDB) {
+		s.shipExemplars = true
+	}
+}
+
+// WithFastRecovery skips the WAL replay of the tenants whose newest block on disk ends at most maxDataLoss ago, so that
+// receivers restart quickly instead of replaying huge WALs, losing the samples of the WAL past that block, which are
+// expected to be held by the other replicas.
+func WithFastRecovery(maxDataLoss time.Duration) MultiTSDBOption {
+	return func(s *MultiTSDB) {
+		s.fastRecoveryMaxDataLoss = maxDataLoss
+	}
+}
+
+// WithWALQuota enforces the disk quota on the WAL of every tenant, when checked by CheckWALQuota. Writes to tenants
+// above the quota fail with walquota.ErrExceeded, with the reject action of the quota.
+func WithWALQuota(quota *walquota.Quota) MultiTSDBOption {
+	return func(s *MultiTSDB) {
+		s.walQuota = quota
+	}
+}
+
 func WithMatchersCache(cache storecache.MatchersCache) MultiTSDBOption {
 	return func(s *MultiTSDB) {
 		s.matcherCache = cache
@@ -140,12 +205,30 @@ func NewMultiTSDB(
 		skipCorruptedBlocks:   skipCorruptedBlocks,
 		hashFunc:              hashFunc,
 		matcherCache:          storecache.NoopMatchersCache,
+		now:                   time.Now,
 	}
 
 	for _, option := range options {
 		option(mt)
 	}
 
+	if mt.fastRecoveryMaxDataLoss > 0 {
+		mt.fastRecoveryMetrics = &fastRecoveryMetrics{
+			skipped: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+				Name: "thanos_receive_fast_recovery_skipped_wal_replays_total",
+				Help: "Total number of WAL replays skipped by fast recovery.",
+			}, []string{"tenant"}),
+			skippedMinTime: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+				Name: "thanos_receive_fast_recovery_skipped_min_time_seconds",
+				Help: "Start of the time range whose samples were lost by the last WAL replay skipped by fast recovery, as the max time of the newest block on disk.",
+			}, []string{"tenant"}),
+			skippedMaxTime: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+				Name: "thanos_receive_fast_recovery_skipped_max_time_seconds",
+				Help: "End of the time range whose samples were lost by the last WAL replay skipped by fast recovery, as the time of the skip.",
+			}, []string{"tenant"}),
+		}
+	}
+
 	return mt
 }
 
@@ -184,6 +267,7 @@ func (t *MultiTSDB) removeTenantUnlocked(tenantID string) {
 	delete(t.tenants, tenantID)
 	delete(t.exemplarClients, tenantID)
 	t.updateTSDBClients()
+	t.walQuota.Remove(tenantID)
 }
 
 func (t *MultiTSDB) removeTenantLocked(tenantID string) {
@@ -583,6 +667,33 @@ func (t *MultiTSDB) pruneTSDB(ctx context.Context, logger log.Logger, tenantInst
 	return true, nil
 }
 
+// CheckWALQuota checks the WAL of every tenant against the WAL disk quota, if any.
+func (t *MultiTSDB) CheckWALQuota() error {
+	if t.walQuota == nil {
+		return nil
+	}
+	// Checks may compact heads, so they are done on a snapshot of the tenants, without blocking the tenants from
+	// being added or pruned in the meantime.
+	t.mtx.RLock()
+	tenants := make(map[string]*tenant, len(t.tenants))
+	maps.Copy(tenants, t.tenants)
+	t.mtx.RUnlock()
+
+	merr := errutil.MultiError{}
+	for id, tenant := range tenants {
+		s := tenant.readyStorage()
+		// The TSDB is not closed by pruning while it is checked.
+		s.mtx.RLock()
+		if s.a != nil && s.a.db != nil {
+			if err := t.walQuota.Check(id, s.a.db); err != nil {
+				merr.Add(errors.Wrapf(err, "check WAL quota of tenant %s", id))
+			}
+		}
+		s.mtx.RUnlock()
+	}
+	return merr.Err()
+}
+
 func (t *MultiTSDB) Sync(ctx context.Context) (int, error) {
 	if t.bucket == nil {
 		return 0, errors.New("bucket is not specified, Sync should not be invoked")
@@ -705,6 +816,29 @@ func (t *MultiTSDB) TenantStats(limit int, statsByLabelName string, tenantIDs ..
 	return result
 }
 
+func (t *MultiTSDB) Cardinality(ctx context.Context, tenantID string, mint, maxt int64, limit int) (cardinality.Stats, error) {
+	t.mtx.RLock()
+	tenantInstance, ok := t.tenants[tenantID]
+	t.mtx.RUnlock()
+	if !ok {
+		return cardinality.Stats{Tenant: tenantID}, nil
+	}
+	db := tenantInstance.readyS.Get()
+	if db == nil {
+		return cardinality.Stats{Tenant: tenantID}, nil
+	}
+	head := db.Head()
+	if head.MaxTime() < mint || head.MinTime() > maxt {
+		return cardinality.Stats{Tenant: tenantID}, nil
+	}
+	ir, err := head.Index()
+	if err != nil {
+		return cardinality.Stats{}, errors.Wrap(err, "head index")
+	}
+	defer runutil.CloseWithLogOnErr(t.logger, ir, "head index reader")
+	return cardinality.Analyze(ctx, tenantID, cardinality.NewTSDBIndex(ir, head.NumSeries()), limit)
+}
+
 func (t *MultiTSDB) startTSDB(logger log.Logger, tenantID string, tenant *tenant) error {
 	reg := prometheus.WrapRegistererWith(prometheus.Labels{"tenant": tenantID}, t.reg)
 	reg = NewUnRegisterer(reg)
@@ -712,6 +846,11 @@ func (t *MultiTSDB) startTSDB(logger log.Logger, tenantID string, tenant *tenant
 	initialLset := labelpb.ExtendSortedLabels(t.labels, labels.FromStrings(t.tenantLabelName, tenantID))
 	lset := t.extractTenantsLabels(tenantID, initialLset)
 	dataDir := t.defaultTenantDataDir(tenantID)
+	domain, bkt, err := routeBucket(t.bucketRoutes, t.bucket, tenantID)
+	if err != nil {
+		t.removeTenantLocked(tenantID)
+		return errors.Wrap(err, "route the bucket of the tenant")
+	}
 
 	level.Info(logger).Log("msg", "opening TSDB")
 
@@ -743,6 +882,12 @@ func (t *MultiTSDB) startTSDB(logger log.Logger, tenantID string, tenant *tenant
 	// into other ones. This presents a race between compaction and the shipper (if it is configured to upload compacted blocks).
 	// Hence, avoid this situation by disabling overlapping compaction. Vertical compaction must be enabled on the compactor.
 	opts.EnableOverlappingCompaction = false
+
+	if t.fastRecoveryMaxDataLoss > 0 {
+		if err := t.skipWALReplay(logger, tenantID, dataDir); err != nil {
+			level.Warn(logger).Log("msg", "failed to skip WAL replay, replaying it", "err", err)
+		}
+	}
 	s, err := tsdb.Open(
 		dataDir,
 		logutil.GoKitLogToSlog(logger),
@@ -756,17 +901,29 @@ func (t *MultiTSDB) startTSDB(logger log.Logger, tenantID string, tenant *tenant
 	}
 	var ship *shipper.Shipper
 	if t.bucket != nil {
+		shipperOptions := t.shipperOptions
+		if t.shipExemplars {
+			shipperOptions = append(slices.Clone(shipperOptions), shipper.WithExemplars(func(ctx context.Context, mint, maxt int64) ([]*exemplarspb.ExemplarData, error) {
+				return exemplars.SelectAll(ctx, s, mint, maxt)
+			}))
+		}
+		if domain != "" {
+			level.Info(logger).Log("msg", "routing the blocks of the tenant", "routing_domain", domain)
+			shipperOptions = append(slices.Clone(shipperOptions), shipper.WithRoutingDomain(domain))
+		}
 		ship = shipper.New(
-			t.bucket,
+			bkt,
 			dataDir,
-			shipper.WithLogger(logger),
-			shipper.WithRegisterer(reg),
-			shipper.WithSource(metadata.ReceiveSource),
-			shipper.WithHashFunc(t.hashFunc),
-			shipper.WithMetaFileName(shipper.DefaultMetaFilename),
-			shipper.WithLabels(func() labels.Labels { return lset }),
-			shipper.WithAllowOutOfOrderUploads(t.allowOutOfOrderUpload),
-			shipper.WithSkipCorruptedBlocks(t.skipCorruptedBlocks),
+			append([]shipper.Option{
+				shipper.WithLogger(logger),
+				shipper.WithRegisterer(reg),
+				shipper.WithSource(metadata.ReceiveSource),
+				shipper.WithHashFunc(t.hashFunc),
+				shipper.WithMetaFileName(shipper.DefaultMetaFilename),
+				shipper.WithLabels(func() labels.Labels { return lset }),
+				shipper.WithAllowOutOfOrderUploads(t.allowOutOfOrderUpload),
+				shipper.WithSkipCorruptedBlocks(t.skipCorruptedBlocks),
+			}, shipperOptions...)...,
 		)
 	}
 	var options []store.TSDBStoreOption
@@ -782,6 +939,64 @@ func (t *MultiTSDB) startTSDB(logger log.Logger, tenantID string, tenant *tenant
 	return nil
 }
 
+// skipWALReplay removes the WAL of the tenant, along with the head chunks and snapshots built from it, if the newest
+// block on disk ends at most the max data loss of fast recovery ago. The WAL is left as it is if there are no blocks,
+// as the samples it would lose are unknown.
+func (t *MultiTSDB) skipWALReplay(logger log.Logger, tenantID, dataDir string) error {
+	walDir := filepath.Join(dataDir, "wal")
+	segments, err := os.ReadDir(walDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "read WAL dir")
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+
+	files, err := os.ReadDir(dataDir)
+	if err != nil {
+		return errors.Wrap(err, "read data dir")
+	}
+	maxTime := int64(-1)
+	for _, f := range files {
+		if _, err := ulid.Parse(f.Name()); err != nil || !f.IsDir() {
+			continue
+		}
+		m, err := metadata.ReadFromDir(filepath.Join(dataDir, f.Name()))
+		if err != nil {
+			return errors.Wrapf(err, "read meta of block %s", f.Name())
+		}
+		maxTime = max(maxTime, m.MaxTime)
+	}
+	if maxTime < 0 {
+		level.Info(logger).Log("msg", "no blocks on disk, replaying WAL")
+		return nil
+	}
+
+	now := t.now()
+	dataLoss := now.Sub(time.UnixMilli(maxTime))
+	if dataLoss > t.fastRecoveryMaxDataLoss {
+		level.Info(logger).Log("msg", "newest block on disk is older than the max data loss of fast recovery, replaying WAL", "maxTime", maxTime, "dataLoss", dataLoss)
+		return nil
+	}
+
+	for _, f := range files {
+		switch name := f.Name(); {
+		case name == "wal", name == "wbl", name == "chunks_head", strings.HasPrefix(name, "chunk_snapshot."):
+			if err := os.RemoveAll(filepath.Join(dataDir, name)); err != nil {
+				return errors.Wrapf(err, "remove %s", name)
+			}
+		}
+	}
+	level.Warn(logger).Log("msg", "skipped WAL replay, samples past the newest block on disk are lost", "minTime", maxTime, "maxTime", now.UnixMilli(), "dataLoss", dataLoss)
+	t.fastRecoveryMetrics.skipped.WithLabelValues(tenantID).Inc()
+	t.fastRecoveryMetrics.skippedMinTime.WithLabelValues(tenantID).Set(float64(maxTime) / 1000)
+	t.fastRecoveryMetrics.skippedMaxTime.WithLabelValues(tenantID).Set(float64(now.UnixMilli()) / 1000)
+	return nil
+}
+
 func (t *MultiTSDB) defaultTenantDataDir(tenantID string) string {
 	return path.Join(t.dataDir, tenantID)
 }
@@ -822,6 +1037,9 @@ func (t *MultiTSDB) getOrLoadTenant(tenantID string, blockingStart bool) (*tenan
 }
 
 func (t *MultiTSDB) TenantAppendable(tenantID string) (Appendable, error) {
+	if !t.walQuota.Allow(tenantID) {
+		return nil, walquota.ErrExceeded
+	}
 	tenant, err := t.getOrLoadTenant(tenantID, false)
 	if err != nil {
 		return nil, err
@@ -21,6 +21,7 @@ import (
 	"github.com/oklog/ulid/v2"
 
 	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/prometheus/model/exemplar"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
@@ -31,6 +32,7 @@ import (
 	"google.golang.org/grpc"
 
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/cardinality"
 	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/exemplars/exemplarspb"
 	"github.com/thanos-io/thanos/pkg/runutil"
@@ -718,6 +720,44 @@ func TestMultiTSDBStats(t *testing.T) {
 	}
 }
 
+func TestMultiTSDBCardinality(t *testing.T) {
+	t.Parallel()
+
+	m := NewMultiTSDB(t.TempDir(), log.NewNopLogger(), prometheus.NewRegistry(),
+		&tsdb.Options{
+			MinBlockDuration:  (2 * time.Hour).Milliseconds(),
+			MaxBlockDuration:  (2 * time.Hour).Milliseconds(),
+			RetentionDuration: (6 * time.Hour).Milliseconds(),
+		},
+		labels.FromStrings("replica", "test"),
+		"tenant_id",
+		nil,
+		false,
+		false,
+		metadata.NoneFunc,
+	)
+	defer func() { testutil.Ok(t, m.Close()) }()
+
+	now := time.Now()
+	testutil.Ok(t, appendSampleWithLabels(m, "foo", labels.FromStrings("__name__", "up", "pod", "a"), now))
+	testutil.Ok(t, appendSampleWithLabels(m, "foo", labels.FromStrings("__name__", "up", "pod", "b"), now))
+	testutil.Ok(t, appendSampleWithLabels(m, "bar", labels.FromStrings("__name__", "up", "pod", "c"), now))
+
+	stats, err := m.Cardinality(context.Background(), "foo", 0, now.UnixMilli(), 10)
+	testutil.Ok(t, err)
+	testutil.Equals(t, uint64(2), stats.NumSeries)
+	testutil.Equals(t, []cardinality.Stat{{Name: "up", Value: 2}}, stats.SeriesCountByMetricName)
+	testutil.Equals(t, []cardinality.Stat{{Name: "pod", Value: 2}, {Name: "__name__", Value: 1}}, stats.LabelValueCountByLabelName)
+
+	// Heads outside of the range and missing tenants have no series.
+	stats, err = m.Cardinality(context.Background(), "foo", 0, now.Add(-time.Hour).UnixMilli(), 10)
+	testutil.Ok(t, err)
+	testutil.Equals(t, cardinality.Stats{Tenant: "foo"}, stats)
+	stats, err = m.Cardinality(context.Background(), "missing-foo", 0, now.UnixMilli(), 10)
+	testutil.Ok(t, err)
+	testutil.Equals(t, cardinality.Stats{Tenant: "missing-foo"}, stats)
+}
+
 // Regression test for https://github.com/thanos-io/thanos/issues/6047.
 func TestMultiTSDBWithNilStore(t *testing.T) {
 	t.Parallel()
@@ -965,3 +1005,59 @@ func TestMultiTSDBDoesNotDeleteNotUploadedBlocks(t *testing.T) {
 		}, tenant.blocksToDelete(nil))
 	})
 }
+
+func TestMultiTSDBFastRecovery(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1000000, 0)
+	newTenantDir := func(t *testing.T, blockMaxTime time.Time) (*MultiTSDB, string) {
+		dir := t.TempDir()
+		reg := prometheus.NewRegistry()
+		m := NewMultiTSDB(dir, log.NewNopLogger(), reg, &tsdb.Options{}, labels.EmptyLabels(), "tenant_id", nil, false, false, metadata.NoneFunc, WithFastRecovery(time.Hour))
+		m.now = func() time.Time { return now }
+
+		dataDir := m.defaultTenantDataDir("foo")
+		testutil.Ok(t, os.MkdirAll(filepath.Join(dataDir, "wal"), 0750))
+		testutil.Ok(t, os.WriteFile(filepath.Join(dataDir, "wal", "00000000"), []byte("segment"), 0600))
+		testutil.Ok(t, os.MkdirAll(filepath.Join(dataDir, "chunks_head"), 0750))
+		if !blockMaxTime.IsZero() {
+			id := ulid.MustNew(1, nil)
+			meta := metadata.Meta{BlockMeta: tsdb.BlockMeta{ULID: id, MinTime: blockMaxTime.Add(-2 * time.Hour).UnixMilli(), MaxTime: blockMaxTime.UnixMilli(), Version: metadata.TSDBVersion1}}
+			meta.Thanos.Version = metadata.ThanosVersion1
+			testutil.Ok(t, os.MkdirAll(filepath.Join(dataDir, id.String()), 0750))
+			testutil.Ok(t, meta.WriteToDir(log.NewNopLogger(), filepath.Join(dataDir, id.String())))
+		}
+		return m, dataDir
+	}
+
+	t.Run("within max data loss", func(t *testing.T) {
+		m, dataDir := newTenantDir(t, now.Add(-30*time.Minute))
+		testutil.Ok(t, m.skipWALReplay(log.NewNopLogger(), "foo", dataDir))
+		testutil.Assert(t, !dirExists(t, filepath.Join(dataDir, "wal")))
+		testutil.Assert(t, !dirExists(t, filepath.Join(dataDir, "chunks_head")))
+		testutil.Equals(t, 1.0, promtestutil.ToFloat64(m.fastRecoveryMetrics.skipped.WithLabelValues("foo")))
+		testutil.Equals(t, float64(now.Add(-30*time.Minute).Unix()), promtestutil.ToFloat64(m.fastRecoveryMetrics.skippedMinTime.WithLabelValues("foo")))
+		testutil.Equals(t, float64(now.Unix()), promtestutil.ToFloat64(m.fastRecoveryMetrics.skippedMaxTime.WithLabelValues("foo")))
+	})
+	t.Run("past max data loss", func(t *testing.T) {
+		m, dataDir := newTenantDir(t, now.Add(-2*time.Hour))
+		testutil.Ok(t, m.skipWALReplay(log.NewNopLogger(), "foo", dataDir))
+		testutil.Assert(t, dirExists(t, filepath.Join(dataDir, "wal")))
+		testutil.Equals(t, 0.0, promtestutil.ToFloat64(m.fastRecoveryMetrics.skipped.WithLabelValues("foo")))
+	})
+	t.Run("no blocks", func(t *testing.T) {
+		m, dataDir := newTenantDir(t, time.Time{})
+		testutil.Ok(t, m.skipWALReplay(log.NewNopLogger(), "foo", dataDir))
+		testutil.Assert(t, dirExists(t, filepath.Join(dataDir, "wal")))
+		testutil.Assert(t, dirExists(t, filepath.Join(dataDir, "chunks_head")))
+	})
+}
+
+func dirExists(t *testing.T, dir string) bool {
+	_, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return false
+	}
+	testutil.Ok(t, err)
+	return true
+}
@@ -0,0 +1,300 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+const (
+	labelOutOfOrder  = "out_of_order"
+	labelStreamLimit = "stream_limit"
+
+	streamSep = '\xff'
+)
+
+// OTLPDeltaToCumulativeOptions configures the translation of OTLP delta sums and histograms to cumulative ones.
+type OTLPDeltaToCumulativeOptions struct {
+	// Enabled translates the delta sums and histograms, which are dropped otherwise.
+	Enabled bool
+	// MaxStale is the duration after which a stream not written to is evicted. Its accumulation starts anew from the
+	// next data point.
+	MaxStale time.Duration
+	// MaxStreams is the maximum number of streams accumulated. The data points of new streams are dropped above it.
+	// Zero is no limit.
+	MaxStreams int
+}
+
+// deltaToCumulative translates the OTLP delta sums and histograms to cumulative ones, accumulating the data points of
+// every stream, i.e. of every tenant, resource, scope, metric and data point attributes. The state is local to the
+// receiver, so the data points of a stream have to be sent to the same receiver.
+//
+// Data points not newer than the last data point of their stream are dropped, so retried requests do not accumulate
+// the same deltas twice.
+type deltaToCumulative struct {
+	opts OTLPDeltaToCumulativeOptions
+	now  func() time.Time
+
+	mtx       sync.Mutex
+	streams   map[uint64]*deltaStream
+	lastSweep time.Time
+	buf       []byte
+
+	active  prometheus.Gauge
+	evicted prometheus.Counter
+	dropped *prometheus.CounterVec
+}
+
+// deltaStream is the accumulated state of a stream.
+type deltaStream struct {
+	start, last pcommon.Timestamp
+	lastSeen    time.Time
+
+	intValue   int64
+	floatValue float64
+
+	count            uint64
+	sum              float64
+	min, max         float64
+	hasMin, hasMax   bool
+	bounds           []float64
+	bucketCounts     []uint64
+	histogramStarted bool
+}
+
+func newDeltaToCumulative(reg prometheus.Registerer, opts OTLPDeltaToCumulativeOptions) *deltaToCumulative {
+	if opts.MaxStale <= 0 {
+		opts.MaxStale = 5 * time.Minute
+	}
+	d := &deltaToCumulative{
+		opts:      opts,
+		now:       time.Now,
+		streams:   map[uint64]*deltaStream{},
+		lastSweep: time.Now(),
+		active: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_receive_otlp_delta_streams",
+			Help: "The number of OTLP delta streams accumulated into cumulative ones.",
+		}),
+		evicted: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_receive_otlp_delta_streams_evicted_total",
+			Help: "The number of OTLP delta streams evicted after not being written to for the max stale duration.",
+		}),
+		dropped: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_receive_otlp_delta_datapoints_dropped_total",
+			Help: "The number of OTLP delta data points dropped instead of being accumulated, by reason.",
+		}, []string{"reason"}),
+	}
+	d.dropped.WithLabelValues(labelOutOfOrder)
+	d.dropped.WithLabelValues(labelStreamLimit)
+	return d
+}
+
+// translate translates the delta sums and histograms of the metrics of the tenant to cumulative ones, in place.
+// Exponential histograms are left as they are.
+func (d *deltaToCumulative) translate(tenant string, md pmetric.Metrics) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	now := d.now()
+	d.sweep(now)
+
+	rms := md.ResourceMetrics()
+	for i := 0; i < rms.Len(); i++ {
+		rm := rms.At(i)
+		sms := rm.ScopeMetrics()
+		for j := 0; j < sms.Len(); j++ {
+			sm := sms.At(j)
+			ms := sm.Metrics()
+			for k := 0; k < ms.Len(); k++ {
+				m := ms.At(k)
+				switch m.Type() {
+				case pmetric.MetricTypeSum:
+					if m.Sum().AggregationTemporality() != pmetric.AggregationTemporalityDelta {
+						continue
+					}
+					prefix := d.streamPrefix(tenant, rm.Resource().Attributes(), sm.Scope(), m)
+					m.Sum().DataPoints().RemoveIf(func(dp pmetric.NumberDataPoint) bool {
+						return !d.accumulateNumber(now, d.streamKey(prefix, dp.Attributes()), dp)
+					})
+					m.Sum().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+				case pmetric.MetricTypeHistogram:
+					if m.Histogram().AggregationTemporality() != pmetric.AggregationTemporalityDelta {
+						continue
+					}
+					prefix := d.streamPrefix(tenant, rm.Resource().Attributes(), sm.Scope(), m)
+					m.Histogram().DataPoints().RemoveIf(func(dp pmetric.HistogramDataPoint) bool {
+						return !d.accumulateHistogram(now, d.streamKey(prefix, dp.Attributes()), dp)
+					})
+					m.Histogram().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+				}
+			}
+		}
+	}
+}
+
+// accumulateNumber accumulates the sum data point into its stream and replaces its value with the accumulated one.
+// It returns false if the data point has to be dropped.
+func (d *deltaToCumulative) accumulateNumber(now time.Time, key uint64, dp pmetric.NumberDataPoint) bool {
+	if dp.Flags().NoRecordedValue() {
+		return true
+	}
+	s, ok := d.stream(now, key, dp.StartTimestamp(), dp.Timestamp())
+	if !ok {
+		return false
+	}
+	switch dp.ValueType() {
+	case pmetric.NumberDataPointValueTypeInt:
+		s.intValue += dp.IntValue()
+		dp.SetIntValue(s.intValue)
+	case pmetric.NumberDataPointValueTypeDouble:
+		s.floatValue += dp.DoubleValue()
+		dp.SetDoubleValue(s.floatValue)
+	}
+	dp.SetStartTimestamp(s.start)
+	return true
+}
+
+// accumulateHistogram accumulates the histogram data point into its stream and replaces its counts with the
+// accumulated ones. The accumulation starts anew if the bucket boundaries change. It returns false if the data point
+// has to be dropped.
+func (d *deltaToCumulative) accumulateHistogram(now time.Time, key uint64, dp pmetric.HistogramDataPoint) bool {
+	if dp.Flags().NoRecordedValue() {
+		return true
+	}
+	s, ok := d.stream(now, key, dp.StartTimestamp(), dp.Timestamp())
+	if !ok {
+		return false
+	}
+	if !s.histogramStarted || !slices.Equal(s.bounds, dp.ExplicitBounds().AsRaw()) || len(s.bucketCounts) != dp.BucketCounts().Len() {
+		if s.histogramStarted {
+			s.start = startTimestamp(dp.StartTimestamp(), dp.Timestamp())
+		}
+		*s = deltaStream{
+			start:            s.start,
+			last:             s.last,
+			lastSeen:         s.lastSeen,
+			bounds:           dp.ExplicitBounds().AsRaw(),
+			bucketCounts:     make([]uint64, dp.BucketCounts().Len()),
+			histogramStarted: true,
+		}
+	}
+
+	s.count += dp.Count()
+	s.sum += dp.Sum()
+	for i := range s.bucketCounts {
+		s.bucketCounts[i] += dp.BucketCounts().At(i)
+	}
+	if dp.HasMin() && (!s.hasMin || dp.Min() < s.min) {
+		s.min, s.hasMin = dp.Min(), true
+	}
+	if dp.HasMax() && (!s.hasMax || dp.Max() > s.max) {
+		s.max, s.hasMax = dp.Max(), true
+	}
+
+	dp.SetStartTimestamp(s.start)
+	dp.SetCount(s.count)
+	if dp.HasSum() {
+		dp.SetSum(s.sum)
+	}
+	dp.BucketCounts().FromRaw(s.bucketCounts)
+	if s.hasMin {
+		dp.SetMin(s.min)
+	}
+	if s.hasMax {
+		dp.SetMax(s.max)
+	}
+	return true
+}
+
+// stream returns the stream of the key, creating it if needed, and records the data point at ts as its last one.
+// It returns false if the data point has to be dropped.
+func (d *deltaToCumulative) stream(now time.Time, key uint64, start, ts pcommon.Timestamp) (*deltaStream, bool) {
+	s, ok := d.streams[key]
+	if !ok {
+		if d.opts.MaxStreams > 0 && len(d.streams) >= d.opts.MaxStreams {
+			d.dropped.WithLabelValues(labelStreamLimit).Inc()
+			return nil, false
+		}
+		s = &deltaStream{start: startTimestamp(start, ts)}
+		d.streams[key] = s
+		d.active.Set(float64(len(d.streams)))
+	} else if ts <= s.last {
+		d.dropped.WithLabelValues(labelOutOfOrder).Inc()
+		return nil, false
+	}
+	s.last = ts
+	s.lastSeen = now
+	return s, true
+}
+
+// sweep evicts the streams not written to for the max stale duration. Streams are swept at most once per max stale
+// duration, so they are evicted up to twice the max stale duration after their last write.
+func (d *deltaToCumulative) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < d.opts.MaxStale {
+		return
+	}
+	d.lastSweep = now
+	for key, s := range d.streams {
+		if now.Sub(s.lastSeen) >= d.opts.MaxStale {
+			delete(d.streams, key)
+			d.evicted.Inc()
+		}
+	}
+	d.active.Set(float64(len(d.streams)))
+}
+
+// streamPrefix returns the identity of the streams of the metric, without their data point attributes.
+func (d *deltaToCumulative) streamPrefix(tenant string, resource pcommon.Map, scope pcommon.InstrumentationScope, m pmetric.Metric) []byte {
+	b := append([]byte(tenant), streamSep)
+	b = appendAttributes(b, resource)
+	b = append(b, scope.Name()...)
+	b = append(b, streamSep)
+	b = append(b, scope.Version()...)
+	b = append(b, streamSep)
+	b = append(b, m.Name()...)
+	b = append(b, streamSep)
+	b = append(b, m.Unit()...)
+	b = append(b, streamSep, byte(m.Type()), streamSep)
+	return b
+}
+
+// streamKey returns the key of the stream of the metric with the prefix and the data point attributes.
+func (d *deltaToCumulative) streamKey(prefix []byte, attrs pcommon.Map) uint64 {
+	d.buf = appendAttributes(append(d.buf[:0], prefix...), attrs)
+	return xxhash.Sum64(d.buf)
+}
+
+// appendAttributes appends the attributes sorted by key, as attributes are not ordered.
+func appendAttributes(b []byte, attrs pcommon.Map) []byte {
+	keys := make([]string, 0, attrs.Len())
+	attrs.Range(func(k string, _ pcommon.Value) bool {
+		keys = append(keys, k)
+		return true
+	})
+	slices.Sort(keys)
+	for _, k := range keys {
+		v, _ := attrs.Get(k)
+		b = append(b, k...)
+		b = append(b, streamSep)
+		b = append(b, v.AsString()...)
+		b = append(b, streamSep)
+	}
+	return append(b, streamSep)
+}
+
+// startTimestamp returns the start timestamp of a data point, or its timestamp if it has none.
+func startTimestamp(start, ts pcommon.Timestamp) pcommon.Timestamp {
+	if start == 0 {
+		return ts
+	}
+	return start
+}
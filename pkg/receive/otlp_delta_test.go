@@ -0,0 +1,124 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+func deltaMetrics(f func(ms pmetric.MetricSlice)) pmetric.Metrics {
+	md := pmetric.NewMetrics()
+	rm := md.ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().PutStr("service.name", "api")
+	f(rm.ScopeMetrics().AppendEmpty().Metrics())
+	return md
+}
+
+func deltaSum(start, ts pcommon.Timestamp, v float64, attrs ...string) pmetric.Metrics {
+	return deltaMetrics(func(ms pmetric.MetricSlice) {
+		m := ms.AppendEmpty()
+		m.SetName("requests")
+		m.SetEmptySum().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+		m.Sum().SetIsMonotonic(true)
+		dp := m.Sum().DataPoints().AppendEmpty()
+		dp.SetStartTimestamp(start)
+		dp.SetTimestamp(ts)
+		dp.SetDoubleValue(v)
+		for i := 0; i < len(attrs); i += 2 {
+			dp.Attributes().PutStr(attrs[i], attrs[i+1])
+		}
+	})
+}
+
+func deltaHistogram(start, ts pcommon.Timestamp, bounds []float64, counts []uint64) pmetric.Metrics {
+	return deltaMetrics(func(ms pmetric.MetricSlice) {
+		m := ms.AppendEmpty()
+		m.SetName("latency")
+		m.SetEmptyHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+		dp := m.Histogram().DataPoints().AppendEmpty()
+		dp.SetStartTimestamp(start)
+		dp.SetTimestamp(ts)
+		dp.ExplicitBounds().FromRaw(bounds)
+		dp.BucketCounts().FromRaw(counts)
+		var count uint64
+		for _, c := range counts {
+			count += c
+		}
+		dp.SetCount(count)
+		dp.SetSum(float64(count))
+	})
+}
+
+func TestDeltaToCumulative_Sum(t *testing.T) {
+	t.Parallel()
+
+	d := newDeltaToCumulative(nil, OTLPDeltaToCumulativeOptions{Enabled: true, MaxStreams: 2})
+	translate := func(tenant string, md pmetric.Metrics) pmetric.Sum {
+		d.translate(tenant, md)
+		return md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Sum()
+	}
+
+	sum := translate("a", deltaSum(0, 10, 1))
+	testutil.Equals(t, pmetric.AggregationTemporalityCumulative, sum.AggregationTemporality())
+	testutil.Equals(t, 1.0, sum.DataPoints().At(0).DoubleValue())
+	testutil.Equals(t, pcommon.Timestamp(10), sum.DataPoints().At(0).StartTimestamp())
+
+	sum = translate("a", deltaSum(10, 20, 2))
+	testutil.Equals(t, 3.0, sum.DataPoints().At(0).DoubleValue())
+	testutil.Equals(t, pcommon.Timestamp(10), sum.DataPoints().At(0).StartTimestamp())
+
+	// Retried data points are not accumulated twice.
+	sum = translate("a", deltaSum(10, 20, 2))
+	testutil.Equals(t, 0, sum.DataPoints().Len())
+	testutil.Equals(t, 1.0, promtest.ToFloat64(d.dropped.WithLabelValues(labelOutOfOrder)))
+
+	// Other tenants and attributes are other streams.
+	sum = translate("b", deltaSum(10, 20, 5))
+	testutil.Equals(t, 5.0, sum.DataPoints().At(0).DoubleValue())
+	sum = translate("a", deltaSum(10, 20, 5, "code", "500"))
+	testutil.Equals(t, 0, sum.DataPoints().Len())
+	testutil.Equals(t, 1.0, promtest.ToFloat64(d.dropped.WithLabelValues(labelStreamLimit)))
+	testutil.Equals(t, 2.0, promtest.ToFloat64(d.active))
+
+	// Stale streams are evicted, and accumulated anew.
+	now := time.Now().Add(time.Hour)
+	d.now = func() time.Time { return now }
+	sum = translate("a", deltaSum(20, 30, 4))
+	testutil.Equals(t, 4.0, sum.DataPoints().At(0).DoubleValue())
+	testutil.Equals(t, pcommon.Timestamp(20), sum.DataPoints().At(0).StartTimestamp())
+	testutil.Equals(t, 2.0, promtest.ToFloat64(d.evicted))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(d.active))
+}
+
+func TestDeltaToCumulative_Histogram(t *testing.T) {
+	t.Parallel()
+
+	d := newDeltaToCumulative(nil, OTLPDeltaToCumulativeOptions{Enabled: true})
+	translate := func(md pmetric.Metrics) pmetric.HistogramDataPoint {
+		d.translate("a", md)
+		h := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Histogram()
+		testutil.Equals(t, pmetric.AggregationTemporalityCumulative, h.AggregationTemporality())
+		return h.DataPoints().At(0)
+	}
+
+	dp := translate(deltaHistogram(5, 10, []float64{1, 2}, []uint64{1, 2, 3}))
+	testutil.Equals(t, []uint64{1, 2, 3}, dp.BucketCounts().AsRaw())
+
+	dp = translate(deltaHistogram(10, 20, []float64{1, 2}, []uint64{1, 0, 1}))
+	testutil.Equals(t, []uint64{2, 2, 4}, dp.BucketCounts().AsRaw())
+	testutil.Equals(t, uint64(8), dp.Count())
+	testutil.Equals(t, 8.0, dp.Sum())
+	testutil.Equals(t, pcommon.Timestamp(5), dp.StartTimestamp())
+
+	// Changed buckets start the accumulation anew.
+	dp = translate(deltaHistogram(20, 30, []float64{1, 5}, []uint64{1, 1, 1}))
+	testutil.Equals(t, []uint64{1, 1, 1}, dp.BucketCounts().AsRaw())
+	testutil.Equals(t, pcommon.Timestamp(20), dp.StartTimestamp())
+}
@@ -0,0 +1,192 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/jpillora/backoff"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+const (
+	labelRepaired = "repaired"
+	labelDropped  = "dropped"
+)
+
+// ReplicationRepairOptions configures the background repair of replica writes that failed
+// after the write quorum of the request had already been reached.
+type ReplicationRepairOptions struct {
+	// QueueSize is the maximum number of pending repair requests. Requests are dropped if the queue is full.
+	// Zero disables replication repair.
+	QueueSize int
+	// Workers is the number of concurrent repair workers.
+	Workers int
+	// MaxAttempts is the maximum number of times a single repair request is attempted before it is dropped.
+	MaxAttempts int
+	// Timeout is the timeout of a single repair attempt.
+	Timeout time.Duration
+	// MaxBackoff is the maximum delay between two attempts of the same repair request.
+	MaxBackoff time.Duration
+}
+
+// repairRequest is a write to a single replica that has to be retried.
+type repairRequest struct {
+	tenant     string
+	er         endpointReplica
+	timeSeries []prompb.TimeSeries
+	attempt    int
+}
+
+type repairWriteFunc func(ctx context.Context, req repairRequest) error
+
+// replicationRepairer retries failed replica writes asynchronously so that the handler can
+// acknowledge requests as soon as the write quorum is met. Series that never make it to the
+// replica are lost for that replica, which is the durability trade-off of a lower write quorum.
+type replicationRepairer struct {
+	logger  log.Logger
+	opts    ReplicationRepairOptions
+	write   repairWriteFunc
+	backoff backoff.Backoff
+
+	queue  chan repairRequest
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	pending prometheus.Gauge
+	repairs *prometheus.CounterVec
+}
+
+func newReplicationRepairer(logger log.Logger, reg prometheus.Registerer, opts ReplicationRepairOptions, write repairWriteFunc) *replicationRepairer {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 30 * time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &replicationRepairer{
+		logger: logger,
+		opts:   opts,
+		write:  write,
+		backoff: backoff.Backoff{
+			Factor: 2,
+			Min:    100 * time.Millisecond,
+			Max:    opts.MaxBackoff,
+			Jitter: true,
+		},
+		queue:  make(chan repairRequest, opts.QueueSize),
+		cancel: cancel,
+		pending: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_receive_replication_repair_pending",
+			Help: "The number of replica writes waiting to be repaired.",
+		}),
+		repairs: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_receive_replication_repairs_total",
+			Help: "The number of replica write repair attempts by result.",
+		}, []string{"result"}),
+	}
+	r.repairs.WithLabelValues(labelRepaired)
+	r.repairs.WithLabelValues(labelError)
+	r.repairs.WithLabelValues(labelDropped)
+
+	for i := 0; i < opts.Workers; i++ {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.run(ctx)
+		}()
+	}
+	return r
+}
+
+// enqueue schedules a repair request without blocking. It returns false if the request was dropped.
+func (r *replicationRepairer) enqueue(req repairRequest) bool {
+	select {
+	case r.queue <- req:
+		r.pending.Inc()
+		return true
+	default:
+		r.repairs.WithLabelValues(labelDropped).Inc()
+		level.Warn(r.logger).Log("msg", "replication repair queue is full, dropping repair", "tenant", req.tenant, "endpoint", req.er.endpoint, "series", len(req.timeSeries))
+		return false
+	}
+}
+
+func (r *replicationRepairer) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-r.queue:
+			r.pending.Dec()
+			r.repair(ctx, req)
+		}
+	}
+}
+
+func (r *replicationRepairer) repair(ctx context.Context, req repairRequest) {
+	for ; req.attempt < r.opts.MaxAttempts; req.attempt++ {
+		if req.attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(r.backoff.ForAttempt(float64(req.attempt - 1))):
+			}
+		}
+
+		wctx := ctx
+		cancel := func() {}
+		if r.opts.Timeout > 0 {
+			wctx, cancel = context.WithTimeout(ctx, r.opts.Timeout)
+		}
+		err := r.write(wctx, req)
+		cancel()
+		if err == nil || isConflict(err) {
+			// A conflict means the replica already has the data.
+			r.repairs.WithLabelValues(labelRepaired).Inc()
+			return
+		}
+		r.repairs.WithLabelValues(labelError).Inc()
+		level.Debug(r.logger).Log("msg", "replication repair attempt failed", "tenant", req.tenant, "endpoint", req.er.endpoint, "attempt", req.attempt+1, "err", err)
+	}
+	r.repairs.WithLabelValues(labelDropped).Inc()
+	level.Warn(r.logger).Log("msg", "giving up replication repair", "tenant", req.tenant, "endpoint", req.er.endpoint, "series", len(req.timeSeries))
+}
+
+// Close stops all workers. Pending repairs are discarded.
+func (r *replicationRepairer) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// repairRequestsFor returns the repair requests for a failed write response by looking up
+// the series that were sent to the response's endpoint replica.
+func repairRequestsFor(resp writeResponse, writes ...map[endpointReplica]map[string]trackedSeries) []repairRequest {
+	if len(resp.seriesIDs) == 0 {
+		return nil
+	}
+	var reqs []repairRequest
+	for _, w := range writes {
+		for tenant, ts := range w[resp.er] {
+			// Every series belongs to exactly one tenant per endpoint replica, so the first ID identifies the batch.
+			if len(ts.seriesIDs) == 0 || ts.seriesIDs[0] != resp.seriesIDs[0] {
+				continue
+			}
+			reqs = append(reqs, repairRequest{tenant: tenant, er: resp.er, timeSeries: ts.timeSeries})
+		}
+	}
+	return reqs
+}
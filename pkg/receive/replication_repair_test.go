@@ -0,0 +1,159 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+func TestReplicationRepairer(t *testing.T) {
+	t.Parallel()
+
+	t.Run("retries until success", func(t *testing.T) {
+		var attempts int
+		done := make(chan struct{})
+		r := newReplicationRepairer(log.NewNopLogger(), prometheus.NewRegistry(), ReplicationRepairOptions{
+			QueueSize:   1,
+			MaxAttempts: 3,
+			MaxBackoff:  time.Millisecond,
+		}, func(_ context.Context, _ repairRequest) error {
+			attempts++
+			if attempts < 3 {
+				return errUnavailable
+			}
+			close(done)
+			return nil
+		})
+		defer r.Close()
+
+		testutil.Assert(t, r.enqueue(repairRequest{tenant: "foo"}))
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("repair was not completed")
+		}
+		testutil.Ok(t, waitForCounter(r.repairs.WithLabelValues(labelRepaired), 1))
+		testutil.Equals(t, 2.0, promtestutil.ToFloat64(r.repairs.WithLabelValues(labelError)))
+	})
+
+	t.Run("conflicts count as repaired", func(t *testing.T) {
+		r := newReplicationRepairer(log.NewNopLogger(), prometheus.NewRegistry(), ReplicationRepairOptions{QueueSize: 1}, func(_ context.Context, _ repairRequest) error {
+			return errConflict
+		})
+		defer r.Close()
+
+		testutil.Assert(t, r.enqueue(repairRequest{tenant: "foo"}))
+		testutil.Ok(t, waitForCounter(r.repairs.WithLabelValues(labelRepaired), 1))
+	})
+
+	t.Run("drops after max attempts", func(t *testing.T) {
+		r := newReplicationRepairer(log.NewNopLogger(), prometheus.NewRegistry(), ReplicationRepairOptions{
+			QueueSize:   1,
+			MaxAttempts: 2,
+			MaxBackoff:  time.Millisecond,
+		}, func(_ context.Context, _ repairRequest) error {
+			return errors.New("boom")
+		})
+		defer r.Close()
+
+		testutil.Assert(t, r.enqueue(repairRequest{tenant: "foo"}))
+		testutil.Ok(t, waitForCounter(r.repairs.WithLabelValues(labelDropped), 1))
+		testutil.Equals(t, 2.0, promtestutil.ToFloat64(r.repairs.WithLabelValues(labelError)))
+	})
+
+	t.Run("drops when the queue is full", func(t *testing.T) {
+		block := make(chan struct{})
+		r := newReplicationRepairer(log.NewNopLogger(), prometheus.NewRegistry(), ReplicationRepairOptions{QueueSize: 1}, func(ctx context.Context, _ repairRequest) error {
+			select {
+			case <-block:
+			case <-ctx.Done():
+			}
+			return nil
+		})
+		defer r.Close()
+		defer close(block)
+
+		testutil.Assert(t, r.enqueue(repairRequest{tenant: "foo"}))
+		// Wait for the worker to pick up the first request so the queue is empty again.
+		testutil.Ok(t, waitForGauge(r.pending, 0))
+		testutil.Assert(t, r.enqueue(repairRequest{tenant: "foo"}))
+		testutil.Assert(t, !r.enqueue(repairRequest{tenant: "foo"}))
+		testutil.Equals(t, 1.0, promtestutil.ToFloat64(r.repairs.WithLabelValues(labelDropped)))
+	})
+}
+
+func TestRepairRequestsFor(t *testing.T) {
+	t.Parallel()
+
+	er := endpointReplica{endpoint: Endpoint{Address: "a"}, replica: 1}
+	other := endpointReplica{endpoint: Endpoint{Address: "b"}, replica: 0}
+	writes := map[endpointReplica]map[string]trackedSeries{
+		er: {
+			"tenant-a": {seriesIDs: []int{0, 2}, timeSeries: []prompb.TimeSeries{{}, {}}},
+			"tenant-b": {seriesIDs: []int{1}, timeSeries: []prompb.TimeSeries{{}}},
+		},
+		other: {
+			"tenant-a": {seriesIDs: []int{0, 2}, timeSeries: []prompb.TimeSeries{{}, {}}},
+		},
+	}
+
+	reqs := repairRequestsFor(newWriteResponse([]int{1}, errUnavailable, er), writes)
+	testutil.Equals(t, 1, len(reqs))
+	testutil.Equals(t, "tenant-b", reqs[0].tenant)
+	testutil.Equals(t, er, reqs[0].er)
+
+	reqs = repairRequestsFor(newWriteResponse([]int{0, 2}, errUnavailable, other), writes)
+	testutil.Equals(t, 1, len(reqs))
+	testutil.Equals(t, "tenant-a", reqs[0].tenant)
+	testutil.Equals(t, 2, len(reqs[0].timeSeries))
+}
+
+func TestWriteQuorum(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		rf, wq   uint64
+		expected int
+	}{
+		{rf: 1, expected: 1},
+		{rf: 2, expected: 1},
+		{rf: 3, expected: 2},
+		{rf: 5, expected: 3},
+		{rf: 3, wq: 1, expected: 1},
+		{rf: 3, wq: 3, expected: 3},
+		{rf: 3, wq: 5, expected: 3},
+	} {
+		h := &Handler{options: &Options{ReplicationFactor: tc.rf, WriteQuorum: tc.wq}}
+		testutil.Equals(t, tc.expected, h.writeQuorum())
+	}
+}
+
+func waitForCounter(c prometheus.Counter, expected float64) error {
+	return waitFor(func() bool { return promtestutil.ToFloat64(c) == expected })
+}
+
+func waitForGauge(g prometheus.Gauge, expected float64) error {
+	return waitFor(func() bool { return promtestutil.ToFloat64(g) == expected })
+}
+
+func waitFor(cond func() bool) error {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return nil
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return errors.New("timeout waiting for condition")
+}
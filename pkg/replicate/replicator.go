@@ -27,10 +27,12 @@ import (
 	objstoretracing "github.com/thanos-io/objstore/tracing/opentracing"
 
 	thanosblock "github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/attestation"
 	"github.com/thanos-io/thanos/pkg/compact"
 	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	thanosmodel "github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	"github.com/thanos-io/thanos/pkg/server/http"
@@ -73,10 +75,13 @@ func RunReplicate(
 	compactions []int,
 	fromObjStoreConfig *extflag.PathOrContent,
 	toObjStoreConfig *extflag.PathOrContent,
+	attestationConfig *extflag.PathOrContent,
 	singleRun bool,
 	minTime, maxTime *thanosmodel.TimeOrDurationValue,
 	blockIDs []ulid.ULID,
 	ignoreMarkedForDeletion bool,
+	rewriteLabels labels.Labels,
+	waitInterval time.Duration,
 ) error {
 	logger = log.With(logger, "component", "replicate")
 
@@ -141,6 +146,16 @@ func RunReplicate(
 	if err != nil {
 		return err
 	}
+	// Replicated blocks are attested again, as the attestation of the source block does not cover rewritten labels.
+	attestationConfContentYaml, err := attestationConfig.Content()
+	if err != nil {
+		return err
+	}
+	attestationConf, err := attestation.ParseConfig(attestationConfContentYaml)
+	if err != nil {
+		return err
+	}
+	toBkt = attestation.WrapWithConfig(logger, toBkt, attestationConf)
 	toBkt = objstoretracing.WrapWithTraces(
 		objstore.WrapWithMetrics(
 			toBkt,
@@ -149,6 +164,24 @@ func RunReplicate(
 		),
 	)
 
+	// Objects are copied on the server side when both buckets support it, e.g. between buckets of an S3 endpoint.
+	fromCopier, err := objstoreutil.NewServerSideCopier(context.Background(), logger, fromConfContentYaml, component.Replicate.String())
+	if err != nil {
+		return err
+	}
+	toCopier, err := objstoreutil.NewServerSideCopier(context.Background(), logger, toConfContentYaml, component.Replicate.String())
+	if err != nil {
+		return err
+	}
+	if fromCopier != nil && toCopier != nil && attestationConf != nil && attestationConf.SigningKey != "" {
+		// Server side copies bypass the attesting bucket, so that the copied files would not be attested.
+		level.Info(logger).Log("msg", "not copying objects on the server side, as replicated blocks are attested")
+		fromCopier, toCopier = nil, nil
+	}
+	if fromCopier != nil && toCopier != nil {
+		level.Info(logger).Log("msg", "copying objects on the server side where supported")
+	}
+
 	replicationRunCounter := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
 		Name: "thanos_replicate_replication_runs_total",
 		Help: "The number of replication runs split by success and error.",
@@ -175,6 +208,7 @@ func RunReplicate(
 		blockIDs,
 	).Filter
 	metrics := newReplicationMetrics(reg)
+	replicated := replicatedBlocks{}
 	ctx, cancel := context.WithCancel(context.Background())
 
 	replicateFn := func() error {
@@ -189,7 +223,7 @@ func RunReplicate(
 		logger := log.With(logger, "replication-run-id", runID.String())
 		level.Info(logger).Log("msg", "running replication attempt")
 
-		if err := newReplicationScheme(logger, metrics, blockFilter, fetcher, fromBkt, toBkt, reg).execute(ctx); err != nil {
+		if err := newReplicationScheme(logger, metrics, blockFilter, fetcher, fromBkt, toBkt, fromCopier, toCopier, rewriteLabels, replicated, reg).execute(ctx); err != nil {
 			return errors.Wrap(err, "replication execute")
 		}
 
@@ -199,13 +233,19 @@ func RunReplicate(
 	g.Add(func() error {
 		defer runutil.CloseWithLogOnErr(logger, fromBkt, "from bucket client")
 		defer runutil.CloseWithLogOnErr(logger, toBkt, "to bucket client")
+		if fromCopier != nil {
+			defer runutil.CloseWithLogOnErr(logger, fromCopier, "from server side copier")
+		}
+		if toCopier != nil {
+			defer runutil.CloseWithLogOnErr(logger, toCopier, "to server side copier")
+		}
 
 		statusProber.Ready()
 		if singleRun || len(blockIDs) > 0 {
 			return replicateFn()
 		}
 
-		return runutil.Repeat(time.Minute, ctx.Done(), func() error {
+		return runutil.Repeat(waitInterval, ctx.Done(), func() error {
 			start := time.Now()
 			if err := replicateFn(); err != nil {
 				level.Error(logger).Log("msg", "running replication failed", "err", err)
@@ -24,6 +24,7 @@ import (
 	thanosblock "github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/compact"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 )
@@ -107,14 +108,26 @@ func (bf *BlockFilter) Filter(b *metadata.Meta) bool {
 
 type blockFilterFunc func(b *metadata.Meta) bool
 
+// replicatedBlocks is the set of blocks known to be fully replicated, kept across replication runs so that
+// every run only replicates the blocks that appeared in the origin bucket since the previous one.
+type replicatedBlocks map[ulid.ULID]struct{}
+
 // TODO: Add filters field.
 type replicationScheme struct {
 	fromBkt objstore.InstrumentedBucketReader
 	toBkt   objstore.Bucket
 
+	// fromCopier and toCopier copy objects on the server side when both are set and support it.
+	fromCopier objstoreutil.ServerSideCopier
+	toCopier   objstoreutil.ServerSideCopier
+
 	blockFilter blockFilterFunc
 	fetcher     thanosblock.MetadataFetcher
 
+	// rewriteLabels are set in the external labels of replicated blocks, labels with an empty value are removed.
+	rewriteLabels labels.Labels
+	replicated    replicatedBlocks
+
 	logger  log.Logger
 	metrics *replicationMetrics
 
@@ -125,6 +138,7 @@ type replicationMetrics struct {
 	blocksAlreadyReplicated prometheus.Counter
 	blocksReplicated        prometheus.Counter
 	objectsReplicated       prometheus.Counter
+	blocksPending           prometheus.Gauge
 }
 
 func newReplicationMetrics(reg prometheus.Registerer) *replicationMetrics {
@@ -141,6 +155,10 @@ func newReplicationMetrics(reg prometheus.Registerer) *replicationMetrics {
 			Name: "thanos_replicate_objects_replicated_total",
 			Help: "Total number of objects replicated.",
 		}),
+		blocksPending: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_replicate_blocks_pending",
+			Help: "Number of selected blocks not replicated yet by the current replication run.",
+		}),
 	}
 	return m
 }
@@ -152,20 +170,30 @@ func newReplicationScheme(
 	fetcher thanosblock.MetadataFetcher,
 	from objstore.InstrumentedBucketReader,
 	to objstore.Bucket,
+	fromCopier, toCopier objstoreutil.ServerSideCopier,
+	rewriteLabels labels.Labels,
+	replicated replicatedBlocks,
 	reg prometheus.Registerer,
 ) *replicationScheme {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
+	if replicated == nil {
+		replicated = replicatedBlocks{}
+	}
 
 	return &replicationScheme{
-		logger:      logger,
-		blockFilter: blockFilter,
-		fetcher:     fetcher,
-		fromBkt:     from,
-		toBkt:       to,
-		metrics:     metrics,
-		reg:         reg,
+		logger:        logger,
+		blockFilter:   blockFilter,
+		fetcher:       fetcher,
+		fromBkt:       from,
+		toBkt:         to,
+		fromCopier:    fromCopier,
+		toCopier:      toCopier,
+		rewriteLabels: rewriteLabels,
+		replicated:    replicated,
+		metrics:       metrics,
+		reg:           reg,
 	}
 }
 
@@ -182,11 +210,20 @@ func (rs *replicationScheme) execute(ctx context.Context) error {
 	}
 
 	for id, meta := range metas {
+		if _, ok := rs.replicated[id]; ok {
+			continue
+		}
 		if rs.blockFilter(meta) {
 			level.Info(rs.logger).Log("msg", "adding block to be replicated", "block_uuid", id.String())
 			availableBlocks = append(availableBlocks, meta)
 		}
 	}
+	// Forget the blocks deleted from the origin bucket.
+	for id := range rs.replicated {
+		if _, ok := metas[id]; !ok {
+			delete(rs.replicated, id)
+		}
+	}
 
 	// In order to prevent races in compactions by the target environment, we
 	// need to replicate oldest start timestamp first.
@@ -194,10 +231,13 @@ func (rs *replicationScheme) execute(ctx context.Context) error {
 		return availableBlocks[i].BlockMeta.MinTime < availableBlocks[j].BlockMeta.MinTime
 	})
 
+	rs.metrics.blocksPending.Set(float64(len(availableBlocks)))
 	for _, b := range availableBlocks {
 		if err := rs.ensureBlockIsReplicated(ctx, b.BlockMeta.ULID); err != nil {
 			return errors.Wrapf(err, "ensure block %v is replicated", b.BlockMeta.ULID.String())
 		}
+		rs.replicated[b.BlockMeta.ULID] = struct{}{}
+		rs.metrics.blocksPending.Dec()
 	}
 
 	return nil
@@ -230,11 +270,16 @@ func (rs *replicationScheme) ensureBlockIsReplicated(ctx context.Context, id uli
 		return errors.Wrap(err, "get meta file from target bucket")
 	}
 
-	// TODO(bwplotka): Allow injecting custom labels as shipper does.
 	originMetaFileContent, err := io.ReadAll(originMetaFile)
 	if err != nil {
 		return errors.Wrap(err, "read origin meta file")
 	}
+	if !rs.rewriteLabels.IsEmpty() {
+		originMetaFileContent, err = rewriteMetaLabels(originMetaFileContent, rs.rewriteLabels)
+		if err != nil {
+			return errors.Wrap(err, "rewrite external labels")
+		}
+	}
 
 	if targetMetaFile != nil && !rs.toBkt.IsObjNotFoundErr(err) {
 		targetMetaFileContent, err := io.ReadAll(targetMetaFile)
@@ -297,15 +342,8 @@ func (rs *replicationScheme) ensureObjectReplicated(ctx context.Context, objectN
 
 	level.Debug(rs.logger).Log("msg", "object not present in target bucket, replicating", "object", objectName)
 
-	r, err := rs.fromBkt.Get(ctx, objectName)
-	if err != nil {
-		return errors.Wrapf(err, "get %v from origin bucket", objectName)
-	}
-
-	defer r.Close()
-
-	if err = rs.toBkt.Upload(ctx, objectName, r); err != nil {
-		return errors.Wrapf(err, "upload %v to target bucket", objectName)
+	if err := objstoreutil.CopyObject(ctx, rs.logger, rs.fromBkt, rs.fromCopier, rs.toBkt, rs.toCopier, objectName, objectName); err != nil {
+		return errors.Wrapf(err, "copy %v to target bucket", objectName)
 	}
 
 	level.Info(rs.logger).Log("msg", "object replicated", "object", objectName)
@@ -313,3 +351,34 @@ func (rs *replicationScheme) ensureObjectReplicated(ctx context.Context, objectN
 
 	return nil
 }
+
+// rewriteMetaLabels returns the given encoded meta with the given labels set in its external labels.
+// Labels with an empty value are removed.
+func rewriteMetaLabels(content []byte, rewrite labels.Labels) ([]byte, error) {
+	meta, err := metadata.Read(io.NopCloser(bytes.NewReader(content)))
+	if err != nil {
+		return nil, err
+	}
+
+	lbls := make(map[string]string, len(meta.Thanos.Labels))
+	for k, v := range meta.Thanos.Labels {
+		lbls[k] = v
+	}
+	rewrite.Range(func(l labels.Label) {
+		if l.Value == "" {
+			delete(lbls, l.Name)
+			return
+		}
+		lbls[l.Name] = l.Value
+	})
+	if len(lbls) == 0 {
+		return nil, errors.New("rewritten external labels are empty")
+	}
+	meta.Thanos.Labels = lbls
+
+	var buf bytes.Buffer
+	if err := meta.Write(&buf); err != nil {
+		return nil, errors.Wrap(err, "encode meta file")
+	}
+	return buf.Bytes(), nil
+}
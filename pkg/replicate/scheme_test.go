@@ -17,6 +17,7 @@ import (
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/oklog/ulid/v2"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/tsdb"
 
@@ -393,7 +394,7 @@ func TestReplicationSchemeAll(t *testing.T) {
 		)
 		testutil.Ok(t, err)
 
-		r := newReplicationScheme(logger, newReplicationMetrics(nil), filter, fetcher, objstore.WithNoopInstr(originBucket), targetBucket, nil)
+		r := newReplicationScheme(logger, newReplicationMetrics(nil), filter, fetcher, objstore.WithNoopInstr(originBucket), targetBucket, nil, nil, labels.EmptyLabels(), nil, nil)
 
 		err = r.execute(ctx)
 		testutil.Ok(t, err)
@@ -401,3 +402,57 @@ func TestReplicationSchemeAll(t *testing.T) {
 		c.assert(ctx, t, originBucket, targetBucket)
 	}
 }
+
+func TestReplicationSchemeIncrementalWithRewriteLabels(t *testing.T) {
+	ctx := context.Background()
+	originBucket := objstore.NewInMemBucket()
+	targetBucket := objstore.NewInMemBucket()
+	logger := testLogger(t.Name())
+
+	upload := func(id ulid.ULID) {
+		b, err := json.Marshal(testMeta(id))
+		testutil.Ok(t, err)
+		testutil.Ok(t, originBucket.Upload(ctx, path.Join(id.String(), "meta.json"), bytes.NewReader(b)))
+		testutil.Ok(t, originBucket.Upload(ctx, path.Join(id.String(), "chunks", "000001"), bytes.NewReader(nil)))
+		testutil.Ok(t, originBucket.Upload(ctx, path.Join(id.String(), "index"), bytes.NewReader(nil)))
+	}
+	upload(testULID(0))
+
+	selector := labels.Selector{labels.MustNewMatcher(labels.MatchEqual, "test-labelname", "test-labelvalue")}
+	filter := NewBlockFilter(logger, selector, []compact.ResolutionLevel{compact.ResolutionLevelRaw}, []int{1}, nil).Filter
+	fetcher, err := newMetaFetcher(logger, objstore.WithNoopInstr(originBucket), nil, minTimeDuration, maxTimeDuration, 32, false)
+	testutil.Ok(t, err)
+
+	metrics := newReplicationMetrics(nil)
+	replicated := replicatedBlocks{}
+	rewrite := labels.FromStrings("test-labelname", "", "tenant", "b")
+	run := func() {
+		testutil.Ok(t, newReplicationScheme(logger, metrics, filter, fetcher, objstore.WithNoopInstr(originBucket), targetBucket, nil, nil, rewrite, replicated, nil).execute(ctx))
+	}
+
+	run()
+	testutil.Equals(t, 3, len(targetBucket.Objects()))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(metrics.blocksReplicated))
+
+	r, err := targetBucket.Get(ctx, path.Join(testULID(0).String(), "meta.json"))
+	testutil.Ok(t, err)
+	meta, err := metadata.Read(r)
+	testutil.Ok(t, err)
+	testutil.Equals(t, map[string]string{"tenant": "b"}, meta.Thanos.Labels)
+
+	// The next run only replicates the new block.
+	upload(testULID(1))
+	run()
+	testutil.Equals(t, 6, len(targetBucket.Objects()))
+	testutil.Equals(t, 2.0, promtest.ToFloat64(metrics.blocksReplicated))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(metrics.blocksAlreadyReplicated))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(metrics.blocksPending))
+
+	// After a restart, blocks already in the target bucket with the rewritten labels are not replicated again.
+	run = func() {
+		testutil.Ok(t, newReplicationScheme(logger, metrics, filter, fetcher, objstore.WithNoopInstr(originBucket), targetBucket, nil, nil, rewrite, nil, nil).execute(ctx))
+	}
+	run()
+	testutil.Equals(t, 2.0, promtest.ToFloat64(metrics.blocksReplicated))
+	testutil.Equals(t, 2.0, promtest.ToFloat64(metrics.blocksAlreadyReplicated))
+}
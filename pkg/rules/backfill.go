@@ -0,0 +1,219 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/objstore"
+	"gopkg.in/yaml.v3"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/logutil"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// RangeQueryFunc evaluates the given expression over a time range, as a Thanos Querier would.
+type RangeQueryFunc func(ctx context.Context, query string, start, end time.Time, step time.Duration, partialResponseStrategy storepb.PartialResponseStrategy) (model.Matrix, error)
+
+// BackfillOptions configures a recording rule backfill.
+type BackfillOptions struct {
+	// Start and End are the time range to backfill.
+	Start, End time.Time
+	// EvalInterval is used for rule groups without an interval.
+	EvalInterval time.Duration
+	// BlockDuration is the maximum time range of a single produced block.
+	BlockDuration time.Duration
+	// ExternalLabels are set as Thanos external labels of the produced blocks. They have to match
+	// the external labels of the ruler that evaluates the rules going forward, so that the compactor
+	// merges backfilled and evaluated data into the same stream.
+	ExternalLabels labels.Labels
+	// TmpDir is the directory blocks are written to before being uploaded.
+	TmpDir string
+}
+
+// Backfiller evaluates recording rules over historical data and uploads the results as blocks to the bucket.
+// Alerting rules are skipped. Every rule is evaluated against the querier independently, so recording
+// rules that depend on other backfilled recording rules require those to be backfilled first.
+type Backfiller struct {
+	logger log.Logger
+	bkt    objstore.Bucket
+	query  RangeQueryFunc
+	opts   BackfillOptions
+}
+
+// NewBackfiller creates a new Backfiller.
+func NewBackfiller(logger log.Logger, bkt objstore.Bucket, query RangeQueryFunc, opts BackfillOptions) (*Backfiller, error) {
+	if !opts.Start.Before(opts.End) {
+		return nil, errors.Errorf("start %v has to be before end %v", opts.Start, opts.End)
+	}
+	if opts.EvalInterval <= 0 {
+		return nil, errors.New("evaluation interval has to be positive")
+	}
+	if opts.BlockDuration <= 0 {
+		return nil, errors.New("block duration has to be positive")
+	}
+	if opts.ExternalLabels.IsEmpty() {
+		return nil, errors.New("empty external labels are not allowed for Thanos block")
+	}
+	return &Backfiller{logger: logger, bkt: bkt, query: query, opts: opts}, nil
+}
+
+// backfillGroup is a rule group with its evaluation parameters resolved.
+type backfillGroup struct {
+	file     string
+	group    configRuleAdapter
+	interval time.Duration
+}
+
+// Backfill loads the recording rules from the given files and uploads one block per block duration window,
+// containing the results of evaluating all rules over the window. It returns the metas of the uploaded blocks.
+func (b *Backfiller) Backfill(ctx context.Context, files []string) ([]metadata.Meta, error) {
+	groups, err := b.loadGroups(files)
+	if err != nil {
+		return nil, err
+	}
+
+	var metas []metadata.Meta
+	bd := b.opts.BlockDuration.Milliseconds()
+	for mint := timestamp.FromTime(b.opts.Start) / bd * bd; mint < timestamp.FromTime(b.opts.End); mint += bd {
+		start := max(mint, timestamp.FromTime(b.opts.Start))
+		end := min(mint+bd-1, timestamp.FromTime(b.opts.End))
+
+		meta, ok, err := b.backfillWindow(ctx, groups, timestamp.Time(start), timestamp.Time(end))
+		if err != nil {
+			return metas, errors.Wrapf(err, "backfill %v - %v", timestamp.Time(start), timestamp.Time(end))
+		}
+		if ok {
+			metas = append(metas, meta)
+		}
+	}
+	return metas, nil
+}
+
+func (b *Backfiller) loadGroups(files []string) ([]backfillGroup, error) {
+	var groups []backfillGroup
+	for _, fn := range files {
+		f, err := os.ReadFile(filepath.Clean(fn))
+		if err != nil {
+			return nil, err
+		}
+		var rgs configGroups
+		if err := yaml.Unmarshal(f, &rgs); err != nil {
+			return nil, errors.Wrap(err, fn)
+		}
+		for _, rg := range rgs.Groups {
+			if errs := rg.validate(); len(errs) > 0 {
+				return nil, errors.Wrapf(errs[0], "%s: group %s", fn, rg.group.Name)
+			}
+			interval := time.Duration(rg.group.Interval)
+			if interval == 0 {
+				interval = b.opts.EvalInterval
+			}
+			groups = append(groups, backfillGroup{file: fn, group: rg, interval: interval})
+		}
+	}
+	return groups, nil
+}
+
+// backfillWindow writes a single block with the results of all recording rules between start and end (inclusive).
+// It returns false if no rule produced any sample in the window.
+func (b *Backfiller) backfillWindow(ctx context.Context, groups []backfillGroup, start, end time.Time) (metadata.Meta, bool, error) {
+	dir, err := os.MkdirTemp(b.opts.TmpDir, "backfill")
+	if err != nil {
+		return metadata.Meta{}, false, errors.Wrap(err, "create tmp dir")
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to remove tmp dir", "dir", dir, "err", err)
+		}
+	}()
+
+	w, err := tsdb.NewBlockWriter(logutil.GoKitLogToSlog(b.logger), dir, b.opts.BlockDuration.Milliseconds())
+	if err != nil {
+		return metadata.Meta{}, false, errors.Wrap(err, "create block writer")
+	}
+	defer runutil.CloseWithLogOnErr(b.logger, w, "block writer")
+
+	var samples int
+	app := w.Appender(ctx)
+	for _, g := range groups {
+		for _, r := range g.group.group.Rules {
+			if r.Record == "" {
+				continue
+			}
+			m, err := b.query(ctx, r.Expr, start, end, g.interval, *g.group.PartialResponseStrategy)
+			if err != nil {
+				_ = app.Rollback()
+				return metadata.Meta{}, false, errors.Wrapf(err, "%s: group %s: query rule %s", g.file, g.group.group.Name, r.Record)
+			}
+			for _, ss := range m {
+				lset := recordedLabels(ss.Metric, r.Record, g.group.group.Labels, r.Labels)
+				var ref storage.SeriesRef
+				for _, s := range ss.Values {
+					if ref, err = app.Append(ref, lset, int64(s.Timestamp), float64(s.Value)); err != nil {
+						_ = app.Rollback()
+						return metadata.Meta{}, false, errors.Wrapf(err, "append %s", lset)
+					}
+					samples++
+				}
+			}
+		}
+	}
+	if err := app.Commit(); err != nil {
+		return metadata.Meta{}, false, errors.Wrap(err, "commit")
+	}
+	if samples == 0 {
+		level.Info(b.logger).Log("msg", "no samples to backfill", "start", start, "end", end)
+		return metadata.Meta{}, false, nil
+	}
+
+	id, err := w.Flush(ctx)
+	if err != nil {
+		return metadata.Meta{}, false, errors.Wrap(err, "flush block")
+	}
+	bdir := filepath.Join(dir, id.String())
+	meta, err := metadata.InjectThanos(b.logger, bdir, metadata.Thanos{
+		Labels:     b.opts.ExternalLabels.Map(),
+		Downsample: metadata.ThanosDownsample{Resolution: 0},
+		Source:     metadata.RulerSource,
+	}, nil)
+	if err != nil {
+		return metadata.Meta{}, false, errors.Wrap(err, "inject thanos meta")
+	}
+	if err := block.Upload(ctx, b.logger, b.bkt, bdir, metadata.NoneFunc); err != nil {
+		return metadata.Meta{}, false, errors.Wrapf(err, "upload block %s", id)
+	}
+	level.Info(b.logger).Log("msg", "uploaded backfilled block", "block", id, "mint", meta.MinTime, "maxt", meta.MaxTime, "samples", samples)
+	return *meta, true, nil
+}
+
+// recordedLabels returns the labels of a recorded series, as the ruler would set them when evaluating the rule.
+func recordedLabels(metric model.Metric, record string, groupLabels, ruleLabels map[string]string) labels.Labels {
+	b := labels.NewBuilder(labels.EmptyLabels())
+	for k, v := range metric {
+		b.Set(string(k), string(v))
+	}
+	for k, v := range groupLabels {
+		b.Set(k, v)
+	}
+	for k, v := range ruleLabels {
+		b.Set(k, v)
+	}
+	b.Set(labels.MetricName, record)
+	return b.Labels()
+}
@@ -0,0 +1,148 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+func TestBackfiller(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "rules.yaml")
+	testutil.Ok(t, os.WriteFile(fn, []byte(`
+groups:
+- name: recording
+  interval: 30m
+  labels:
+    team: a
+  rules:
+  - record: job:up:sum
+    expr: sum by (job) (up)
+    labels:
+      team: b
+  - alert: Down
+    expr: up == 0
+- name: warn
+  partial_response_strategy: warn
+  rules:
+  - record: job:up:count
+    expr: count by (job) (up)
+`), os.ModePerm))
+
+	type call struct {
+		query      string
+		start, end time.Time
+		step       time.Duration
+		strategy   storepb.PartialResponseStrategy
+	}
+	var calls []call
+	query := func(_ context.Context, q string, start, end time.Time, step time.Duration, s storepb.PartialResponseStrategy) (model.Matrix, error) {
+		calls = append(calls, call{query: q, start: start, end: end, step: step, strategy: s})
+		var values []model.SamplePair
+		for ts := start; !ts.After(end); ts = ts.Add(step) {
+			values = append(values, model.SamplePair{Timestamp: model.TimeFromUnixNano(ts.UnixNano()), Value: 1})
+		}
+		return model.Matrix{{Metric: model.Metric{"job": "foo", "team": "c"}, Values: values}}, nil
+	}
+
+	bkt := objstore.NewInMemBucket()
+	start := time.Unix(0, 0).UTC().Add(time.Hour)
+	b, err := NewBackfiller(log.NewNopLogger(), bkt, query, BackfillOptions{
+		Start:          start,
+		End:            start.Add(3 * time.Hour),
+		EvalInterval:   time.Hour,
+		BlockDuration:  2 * time.Hour,
+		ExternalLabels: labels.FromStrings("replica", "a"),
+		TmpDir:         t.TempDir(),
+	})
+	testutil.Ok(t, err)
+
+	metas, err := b.Backfill(context.Background(), []string{fn})
+	testutil.Ok(t, err)
+	// The time range spans two aligned 2h windows, [0h, 2h) and [2h, 4h).
+	testutil.Equals(t, 2, len(metas))
+	testutil.Equals(t, 4, len(calls))
+
+	testutil.Equals(t, "sum by (job) (up)", calls[0].query)
+	testutil.Equals(t, 30*time.Minute, calls[0].step)
+	testutil.Equals(t, start, calls[0].start)
+	testutil.Equals(t, storepb.PartialResponseStrategy_ABORT, calls[0].strategy)
+	testutil.Equals(t, "count by (job) (up)", calls[1].query)
+	testutil.Equals(t, time.Hour, calls[1].step)
+	testutil.Equals(t, storepb.PartialResponseStrategy_WARN, calls[1].strategy)
+	testutil.Equals(t, start.Add(time.Hour), calls[2].start)
+
+	for _, m := range metas {
+		testutil.Equals(t, map[string]string{"replica": "a"}, m.Thanos.Labels)
+		testutil.Equals(t, metadata.RulerSource, m.Thanos.Source)
+
+		bm, err := block.DownloadMeta(context.Background(), log.NewNopLogger(), bkt, m.ULID)
+		testutil.Ok(t, err)
+		testutil.Equals(t, m.ULID, bm.ULID)
+	}
+
+	// Verify the recorded series of the first block.
+	bdir := filepath.Join(t.TempDir(), metas[0].ULID.String())
+	testutil.Ok(t, block.Download(context.Background(), log.NewNopLogger(), bkt, metas[0].ULID, bdir))
+	pb, err := tsdb.OpenBlock(nil, bdir, chunkenc.NewPool(), nil)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, pb.Close()) }()
+
+	q, err := tsdb.NewBlockQuerier(pb, 0, time.Hour.Milliseconds()*4)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	set := q.Select(context.Background(), true, nil, labels.MustNewMatcher(labels.MatchRegexp, "__name__", ".+"))
+	var series []labels.Labels
+	for set.Next() {
+		series = append(series, set.At().Labels())
+	}
+	testutil.Ok(t, set.Err())
+	testutil.Equals(t, []labels.Labels{
+		labels.FromStrings("__name__", "job:up:count", "job", "foo", "team", "c"),
+		labels.FromStrings("__name__", "job:up:sum", "job", "foo", "team", "b"),
+	}, series)
+}
+
+func TestNewBackfillerValidation(t *testing.T) {
+	t.Parallel()
+
+	opts := BackfillOptions{
+		Start:          time.Unix(0, 0),
+		End:            time.Unix(3600, 0),
+		EvalInterval:   time.Minute,
+		BlockDuration:  2 * time.Hour,
+		ExternalLabels: labels.FromStrings("replica", "a"),
+	}
+	_, err := NewBackfiller(log.NewNopLogger(), objstore.NewInMemBucket(), nil, opts)
+	testutil.Ok(t, err)
+
+	o := opts
+	o.End = o.Start
+	_, err = NewBackfiller(log.NewNopLogger(), objstore.NewInMemBucket(), nil, o)
+	testutil.NotOk(t, err)
+
+	o = opts
+	o.ExternalLabels = labels.EmptyLabels()
+	_, err = NewBackfiller(log.NewNopLogger(), objstore.NewInMemBucket(), nil, o)
+	testutil.NotOk(t, err)
+}
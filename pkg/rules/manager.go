@@ -122,6 +122,7 @@ type Manager struct {
 	mtx         sync.RWMutex
 	ruleFiles   map[string]string
 	externalURL string
+	shard       GroupShard
 }
 
 // NewManager creates new Manager.
@@ -134,6 +135,7 @@ func NewManager(
 	queryFuncCreator func(partialResponseStrategy storepb.PartialResponseStrategy) rules.QueryFunc,
 	extLset labels.Labels,
 	externalURL string,
+	opts ...ManagerOption,
 ) *Manager {
 	m := &Manager{
 		workDir:     filepath.Join(dataDir, tmpRuleDir),
@@ -142,6 +144,9 @@ func NewManager(
 		ruleFiles:   make(map[string]string),
 		externalURL: externalURL,
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
 	for _, strategy := range storepb.PartialResponseStrategy_value {
 		s := storepb.PartialResponseStrategy(strategy)
 
@@ -351,6 +356,9 @@ func (m *Manager) Update(evalInterval time.Duration, files []string) error {
 		// which is not supported, to be able to reuse rules.Manager. The problem is that it uses yaml.UnmarshalStrict.
 		groupsByStrategy := map[storepb.PartialResponseStrategy][]configRuleAdapter{}
 		for _, rg := range rg.Groups {
+			if !m.shard.Owns(rg.group.Name) {
+				continue
+			}
 			groupsByStrategy[*rg.PartialResponseStrategy] = append(groupsByStrategy[*rg.PartialResponseStrategy], rg)
 		}
 		for s, rg := range groupsByStrategy {
@@ -469,3 +469,50 @@ groups:
 	}))
 	testutil.Equals(t, "exceeded limit of 1 with 2 alerts", thanosRuleMgr.protoRuleGroups()[0].Rules[0].GetAlert().LastError)
 }
+
+func TestManagerUpdateWithGroupSharding(t *testing.T) {
+	dir := t.TempDir()
+
+	var rulesFile strings.Builder
+	rulesFile.WriteString("groups:\n")
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&rulesFile, "- name: group-%d\n  labels:\n    tenant_id: tenant-%d\n  rules:\n  - record: test\n    expr: up\n", i, i%2)
+	}
+	filename := filepath.Join(dir, "rules.yaml")
+	testutil.Ok(t, os.WriteFile(filename, []byte(rulesFile.String()), os.ModePerm))
+
+	const shards = 3
+	seen := map[string]struct{}{}
+	for i := uint64(0); i < shards; i++ {
+		thanosRuleMgr := NewManager(
+			context.Background(),
+			nil,
+			t.TempDir(),
+			rules.ManagerOptions{
+				Logger:    logutil.GoKitLogToSlog(log.NewNopLogger()),
+				Queryable: nopQueryable{},
+			},
+			func(partialResponseStrategy storepb.PartialResponseStrategy) rules.QueryFunc {
+				return func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+					return nil, nil
+				}
+			},
+			labels.EmptyLabels(),
+			"http://localhost",
+			WithGroupSharding(GroupShard{Index: i, Count: shards}),
+		)
+		thanosRuleMgr.Run()
+		testutil.Ok(t, thanosRuleMgr.Update(1*time.Second, []string{filename}))
+
+		groups := thanosRuleMgr.RuleGroups()
+		testutil.Assert(t, len(groups) > 0, "shard %d has no rule groups", i)
+		for _, g := range groups {
+			_, ok := seen[g.Name()]
+			testutil.Assert(t, !ok, "group %s evaluated by more than one shard", g.Name())
+			seen[g.Name()] = struct{}{}
+		}
+		testutil.Equals(t, []string{"tenant-0", "tenant-1"}, thanosRuleMgr.Tenants("tenant_id"))
+		thanosRuleMgr.Stop()
+	}
+	testutil.Equals(t, 20, len(seen))
+}
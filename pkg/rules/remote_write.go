@@ -0,0 +1,85 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/relabel"
+)
+
+// Tenants returns the sorted, distinct values of the given label set statically on the loaded rules,
+// either directly or through the labels of their rule group.
+func (m *Manager) Tenants(tenantLabel string) []string {
+	seen := map[string]struct{}{}
+	for _, g := range m.RuleGroups() {
+		for _, r := range g.Rules() {
+			if t := r.Labels().Get(tenantLabel); t != "" {
+				seen[t] = struct{}{}
+			}
+		}
+	}
+	tenants := make([]string, 0, len(seen))
+	for t := range seen {
+		tenants = append(tenants, t)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// TenantRemoteWriteConfigs expands every remote write config into one config per tenant, so that
+// evaluation results are sent with the tenancy header of the tenant they belong to. Each tenant config
+// keeps only series whose tenant label matches the tenant. The original config is kept for all
+// remaining series, in particular those whose tenant label is only known after evaluation, and is
+// sent without a tenancy header.
+func TenantRemoteWriteConfigs(cfgs []*config.RemoteWriteConfig, tenantLabel, tenantHeader string, tenants []string) []*config.RemoteWriteConfig {
+	if tenantLabel == "" || len(tenants) == 0 {
+		return cfgs
+	}
+
+	quoted := make([]string, 0, len(tenants))
+	for _, t := range tenants {
+		quoted = append(quoted, regexp.QuoteMeta(t))
+	}
+
+	res := make([]*config.RemoteWriteConfig, 0, len(cfgs)*(len(tenants)+1))
+	for _, cfg := range cfgs {
+		def := withWriteRelabelConfig(cfg, tenantLabel, relabel.Drop, strings.Join(quoted, "|"))
+		res = append(res, def)
+
+		for i, t := range tenants {
+			tc := withWriteRelabelConfig(cfg, tenantLabel, relabel.Keep, quoted[i])
+			if cfg.Name != "" {
+				tc.Name = fmt.Sprintf("%s-%s", cfg.Name, t)
+			}
+			tc.Headers = make(map[string]string, len(cfg.Headers)+1)
+			for k, v := range cfg.Headers {
+				tc.Headers[k] = v
+			}
+			tc.Headers[tenantHeader] = t
+			res = append(res, tc)
+		}
+	}
+	return res
+}
+
+// withWriteRelabelConfig returns a copy of the given config with an extra write relabel rule
+// applied before the user provided ones.
+func withWriteRelabelConfig(cfg *config.RemoteWriteConfig, tenantLabel string, action relabel.Action, regex string) *config.RemoteWriteConfig {
+	c := *cfg
+	rc := relabel.DefaultRelabelConfig
+	rc.SourceLabels = model.LabelNames{model.LabelName(tenantLabel)}
+	rc.Regex = relabel.MustNewRegexp(regex)
+	rc.Action = action
+
+	c.WriteRelabelConfigs = make([]*relabel.Config, 0, len(cfg.WriteRelabelConfigs)+1)
+	c.WriteRelabelConfigs = append(c.WriteRelabelConfigs, &rc)
+	c.WriteRelabelConfigs = append(c.WriteRelabelConfigs, cfg.WriteRelabelConfigs...)
+	return &c
+}
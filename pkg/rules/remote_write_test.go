@@ -0,0 +1,60 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+)
+
+func TestTenantRemoteWriteConfigs(t *testing.T) {
+	t.Parallel()
+
+	base := []*config.RemoteWriteConfig{{
+		Name:    "receive",
+		Headers: map[string]string{"X-Foo": "bar"},
+		WriteRelabelConfigs: []*relabel.Config{{
+			SourceLabels: []model.LabelName{"__name__"},
+			Regex:        relabel.MustNewRegexp("drop_me"),
+			Action:       relabel.Drop,
+		}},
+	}}
+
+	testutil.Equals(t, base, TenantRemoteWriteConfigs(base, "", "THANOS-TENANT", []string{"a"}))
+	testutil.Equals(t, base, TenantRemoteWriteConfigs(base, "tenant_id", "THANOS-TENANT", nil))
+
+	cfgs := TenantRemoteWriteConfigs(base, "tenant_id", "THANOS-TENANT", []string{"a", "b.c"})
+	testutil.Equals(t, 3, len(cfgs))
+	testutil.Equals(t, "receive", cfgs[0].Name)
+	testutil.Equals(t, "receive-a", cfgs[1].Name)
+	testutil.Equals(t, "receive-b.c", cfgs[2].Name)
+
+	testutil.Equals(t, map[string]string{"X-Foo": "bar"}, cfgs[0].Headers)
+	testutil.Equals(t, map[string]string{"X-Foo": "bar", "THANOS-TENANT": "a"}, cfgs[1].Headers)
+	testutil.Equals(t, map[string]string{"X-Foo": "bar", "THANOS-TENANT": "b.c"}, cfgs[2].Headers)
+	// The base config must not be modified.
+	testutil.Equals(t, 1, len(base[0].WriteRelabelConfigs))
+	testutil.Equals(t, map[string]string{"X-Foo": "bar"}, base[0].Headers)
+
+	for _, tc := range []struct {
+		lset     labels.Labels
+		expected []bool
+	}{
+		{lset: labels.FromStrings("__name__", "up", "tenant_id", "a"), expected: []bool{false, true, false}},
+		{lset: labels.FromStrings("__name__", "up", "tenant_id", "b.c"), expected: []bool{false, false, true}},
+		{lset: labels.FromStrings("__name__", "up", "tenant_id", "bxc"), expected: []bool{true, false, false}},
+		{lset: labels.FromStrings("__name__", "up"), expected: []bool{true, false, false}},
+		{lset: labels.FromStrings("__name__", "drop_me", "tenant_id", "a"), expected: []bool{false, false, false}},
+	} {
+		for i, cfg := range cfgs {
+			_, keep := relabel.Process(tc.lset, cfg.WriteRelabelConfigs...)
+			testutil.Equals(t, tc.expected[i], keep, "series %v, config %s", tc.lset, cfg.Name)
+		}
+	}
+}
@@ -9,9 +9,11 @@ import (
 	"sync"
 	"text/template"
 	"text/template/parse"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/rules"
 	"github.com/prometheus/prometheus/util/annotations"
 
 	"github.com/thanos-io/thanos/pkg/extpromql"
@@ -61,6 +63,11 @@ func (rr *GRPCClient) Rules(ctx context.Context, req *rulespb.RulesRequest) (*ru
 		return nil, nil, errors.Wrap(err, "proxy Rules")
 	}
 
+	// Groups of replicas are dropped before filtering, as the health of a replica is the one of all its rules.
+	if len(rr.replicaLabels) > 0 {
+		resp.groups = dedupReplicaGroups(resp.groups, rr.replicaLabels)
+	}
+
 	var err error
 	matcherSets := make([][]*labels.Matcher, len(req.MatcherString))
 	for i, s := range req.MatcherString {
@@ -243,6 +250,110 @@ func removeReplicaLabels(r *rulespb.Rule, replicaLabels map[string]struct{}) {
 	r.SetLabels(b.Labels())
 }
 
+// dedupReplicaGroups drops the rule groups evaluated by the replicas of HA rulers but the groups of the healthiest
+// replica, so that their rules and alerts are not listed once per replica. Rulers add their external labels to the
+// labels of all their rules, so the groups of replicas have the same name and the same labels shared by all their
+// rules once the replica labels are removed, but different replica labels. Their files are ignored, as replicas can
+// load the same rules from different paths. The healthiest replica has the fewest unhealthy rules, and then the most
+// recently evaluated groups.
+func dedupReplicaGroups(groups []*rulespb.RuleGroup, replicaLabels map[string]struct{}) []*rulespb.RuleGroup {
+	type replica struct {
+		groups         []*rulespb.RuleGroup
+		unhealthy      int
+		lastEvaluation time.Time
+	}
+	var (
+		keys     []string
+		replicas = map[string]map[string]*replica{}
+		res      = make([]*rulespb.RuleGroup, 0, len(groups))
+	)
+	for _, g := range groups {
+		glset := groupLabels(g)
+		replicaLset, lset := labels.NewBuilder(glset), labels.NewBuilder(glset)
+		glset.Range(func(l labels.Label) {
+			if _, ok := replicaLabels[l.Name]; ok {
+				lset.Del(l.Name)
+				return
+			}
+			replicaLset.Del(l.Name)
+		})
+		if replicaLset.Labels().IsEmpty() {
+			// Not evaluated by a replica.
+			res = append(res, g)
+			continue
+		}
+
+		key := g.Name + "\xff" + lset.Labels().String()
+		if _, ok := replicas[key]; !ok {
+			keys = append(keys, key)
+			replicas[key] = map[string]*replica{}
+		}
+		name := replicaLset.Labels().String()
+		r, ok := replicas[key][name]
+		if !ok {
+			r = &replica{}
+			replicas[key][name] = r
+		}
+		r.groups = append(r.groups, g)
+		for _, rule := range g.Rules {
+			if health(rule) != string(rules.HealthGood) {
+				r.unhealthy++
+			}
+		}
+		if g.LastEvaluation.After(r.lastEvaluation) {
+			r.lastEvaluation = g.LastEvaluation
+		}
+	}
+
+	for _, key := range keys {
+		names := make([]string, 0, len(replicas[key]))
+		for name := range replicas[key] {
+			names = append(names, name)
+		}
+		// Sort the replicas so that the same replica is kept across requests if they are equally healthy.
+		sort.Strings(names)
+
+		var healthiest *replica
+		for _, name := range names {
+			r := replicas[key][name]
+			if healthiest == nil || r.unhealthy < healthiest.unhealthy ||
+				(r.unhealthy == healthiest.unhealthy && r.lastEvaluation.After(healthiest.lastEvaluation)) {
+				healthiest = r
+			}
+		}
+		res = append(res, healthiest.groups...)
+	}
+	return res
+}
+
+// groupLabels returns the labels shared by all the rules of the group.
+func groupLabels(g *rulespb.RuleGroup) labels.Labels {
+	if len(g.Rules) == 0 {
+		return labels.EmptyLabels()
+	}
+	b := labels.NewBuilder(g.Rules[0].GetLabels())
+	for _, r := range g.Rules[1:] {
+		lset := r.GetLabels()
+		g.Rules[0].GetLabels().Range(func(l labels.Label) {
+			if lset.Get(l.Name) != l.Value {
+				b.Del(l.Name)
+			}
+		})
+	}
+	return b.Labels()
+}
+
+func health(r *rulespb.Rule) string {
+	switch {
+	case r.GetRecording() != nil:
+		return r.GetRecording().Health
+	case r.GetAlert() != nil:
+		return r.GetAlert().Health
+	default:
+		return ""
+	}
+}
+
 func dedupGroups(groups []*rulespb.RuleGroup) []*rulespb.RuleGroup {
 	if len(groups) == 0 {
 		return nil
@@ -966,6 +966,93 @@ func TestDedupGroups(t *testing.T) {
 	}
 }
 
+func TestDedupReplicaGroups(t *testing.T) {
+	rule := func(name, health string, lbls ...string) *rulespb.Rule {
+		return rulespb.NewAlertingRule(&rulespb.Alert{
+			Name:   name,
+			Health: health,
+			Labels: labelpb.ZLabelSet{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings(lbls...))},
+		})
+	}
+	group := func(name, file string, lastEvaluation int64, rules ...*rulespb.Rule) *rulespb.RuleGroup {
+		return &rulespb.RuleGroup{Name: name, File: file, LastEvaluation: time.Unix(lastEvaluation, 0), Rules: rules}
+	}
+
+	for _, tc := range []struct {
+		name         string
+		groups, want []*rulespb.RuleGroup
+	}{
+		{
+			name: "no groups",
+			want: []*rulespb.RuleGroup{},
+		},
+		{
+			name: "groups without replica labels",
+			groups: []*rulespb.RuleGroup{
+				group("a", "foo.yaml", 1, rule("a1", "ok", "cluster", "1")),
+				group("a", "foo.yaml", 2, rule("a1", "ok", "cluster", "1")),
+				group("b", "foo.yaml", 1),
+			},
+			want: []*rulespb.RuleGroup{
+				group("a", "foo.yaml", 1, rule("a1", "ok", "cluster", "1")),
+				group("a", "foo.yaml", 2, rule("a1", "ok", "cluster", "1")),
+				group("b", "foo.yaml", 1),
+			},
+		},
+		{
+			name: "healthiest replica",
+			groups: []*rulespb.RuleGroup{
+				group("a", "/replica-1/foo.yaml", 2, rule("a1", "err", "cluster", "1", "replica", "1"), rule("a2", "ok", "cluster", "1", "replica", "1")),
+				group("a", "/replica-2/foo.yaml", 1, rule("a1", "ok", "cluster", "1", "replica", "2"), rule("a2", "ok", "cluster", "1", "replica", "2")),
+			},
+			want: []*rulespb.RuleGroup{
+				group("a", "/replica-2/foo.yaml", 1, rule("a1", "ok", "cluster", "1", "replica", "2"), rule("a2", "ok", "cluster", "1", "replica", "2")),
+			},
+		},
+		{
+			name: "most recently evaluated replica",
+			groups: []*rulespb.RuleGroup{
+				group("a", "foo.yaml", 1, rule("a1", "ok", "replica", "1")),
+				group("a", "foo.yaml", 2, rule("a1", "ok", "replica", "2")),
+				group("a", "foo.yaml", 1, rule("a1", "ok", "replica", "3")),
+			},
+			want: []*rulespb.RuleGroup{
+				group("a", "foo.yaml", 2, rule("a1", "ok", "replica", "2")),
+			},
+		},
+		{
+			name: "all the groups of the replica are kept",
+			groups: []*rulespb.RuleGroup{
+				group("a", "foo.yaml", 1, rule("a1", "ok", "replica", "1")),
+				group("a", "bar.yaml", 1, rule("a2", "ok", "replica", "1")),
+				group("a", "foo.yaml", 1, rule("a1", "unknown", "replica", "2")),
+			},
+			want: []*rulespb.RuleGroup{
+				group("a", "foo.yaml", 1, rule("a1", "ok", "replica", "1")),
+				group("a", "bar.yaml", 1, rule("a2", "ok", "replica", "1")),
+			},
+		},
+		{
+			name: "groups of different rulers",
+			groups: []*rulespb.RuleGroup{
+				group("a", "foo.yaml", 1, rule("a1", "ok", "cluster", "1", "replica", "1")),
+				group("a", "foo.yaml", 1, rule("a1", "ok", "cluster", "2", "replica", "1")),
+				group("b", "foo.yaml", 1, rule("b1", "ok", "cluster", "1", "replica", "1")),
+				group("a", "foo.yaml", 1, rule("a1", "ok", "cluster", "1", "replica", "2")),
+			},
+			want: []*rulespb.RuleGroup{
+				group("a", "foo.yaml", 1, rule("a1", "ok", "cluster", "1", "replica", "1")),
+				group("a", "foo.yaml", 1, rule("a1", "ok", "cluster", "2", "replica", "1")),
+				group("b", "foo.yaml", 1, rule("b1", "ok", "cluster", "1", "replica", "1")),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.Equals(t, tc.want, dedupReplicaGroups(tc.groups, map[string]struct{}{"replica": {}}))
+		})
+	}
+}
+
 func TestFilterRules(t *testing.T) {
 	for _, tc := range []struct {
 		name            string
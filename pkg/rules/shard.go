@@ -0,0 +1,45 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"github.com/cespare/xxhash/v2"
+	"github.com/pkg/errors"
+)
+
+// ManagerOption configures optional behaviour of the Manager.
+type ManagerOption func(*Manager)
+
+// WithGroupSharding makes the Manager load only the rule groups owned by the given shard.
+// A rule group is owned by the shard equal to the hash of its name modulo the number of shards,
+// so replicas configured with the same shard count and distinct indexes evaluate disjoint sets of groups.
+func WithGroupSharding(shard GroupShard) ManagerOption {
+	return func(m *Manager) {
+		m.shard = shard
+	}
+}
+
+// GroupShard identifies the share of rule groups a ruler replica is responsible for.
+type GroupShard struct {
+	// Index is the index of this replica's shard, in the range [0, Count).
+	Index uint64
+	// Count is the total number of shards. Zero or one disables sharding.
+	Count uint64
+}
+
+// Validate returns an error if the shard index is out of range.
+func (s GroupShard) Validate() error {
+	if s.Count > 1 && s.Index >= s.Count {
+		return errors.Errorf("shard index %d must be lower than shard count %d", s.Index, s.Count)
+	}
+	return nil
+}
+
+// Owns returns true if the rule group with the given name belongs to the shard.
+func (s GroupShard) Owns(groupName string) bool {
+	if s.Count <= 1 {
+		return true
+	}
+	return xxhash.Sum64String(groupName)%s.Count == s.Index
+}
@@ -0,0 +1,31 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+func TestGroupShard(t *testing.T) {
+	t.Parallel()
+
+	testutil.Ok(t, GroupShard{}.Validate())
+	testutil.Ok(t, GroupShard{Index: 2, Count: 3}.Validate())
+	testutil.NotOk(t, GroupShard{Index: 3, Count: 3}.Validate())
+
+	testutil.Assert(t, GroupShard{}.Owns("foo"))
+	testutil.Assert(t, GroupShard{Index: 5, Count: 1}.Owns("foo"))
+
+	for _, name := range []string{"foo", "bar", "baz"} {
+		var owners int
+		for i := uint64(0); i < 4; i++ {
+			if (GroupShard{Index: i, Count: 4}).Owns(name) {
+				owners++
+			}
+		}
+		testutil.Equals(t, 1, owners)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package grpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v2"
+)
+
+// healthService is always allowed, for probes.
+const healthService = "/grpc.health.v1.Health/"
+
+// AuthConfig configures the authorization of gRPC requests by the identities of the verified client
+// certificates of mTLS connections.
+type AuthConfig struct {
+	Policies []AuthPolicy `yaml:"policies"`
+}
+
+// AuthPolicy allows clients with any of the identities to call any of the methods.
+type AuthPolicy struct {
+	// Identities are matched against the subject common name and the DNS, URI and email SANs of client
+	// certificates. "*" matches any client with a verified certificate.
+	Identities []string `yaml:"identities"`
+	// Methods are full gRPC method names like "/thanos.Store/Series", all methods of a service like
+	// "/thanos.Rules/*", or "*" for all methods.
+	Methods []string `yaml:"methods"`
+}
+
+// ParseAuthConfig parses the YAML authorization configuration. It returns nil if the content is empty.
+func ParseAuthConfig(content []byte) (*AuthConfig, error) {
+	if len(strings.TrimSpace(string(content))) == 0 {
+		return nil, nil
+	}
+	conf := &AuthConfig{}
+	if err := yaml.UnmarshalStrict(content, conf); err != nil {
+		return nil, errors.Wrap(err, "parsing gRPC server auth config YAML")
+	}
+	for i, p := range conf.Policies {
+		if len(p.Identities) == 0 || len(p.Methods) == 0 {
+			return nil, errors.Errorf("policy %d: identities and methods are required", i)
+		}
+		for _, m := range p.Methods {
+			if m != "*" && (!strings.HasPrefix(m, "/") || strings.Count(m, "/") != 2) {
+				return nil, errors.Errorf("policy %d: method %q is not a full method name like /thanos.Store/Series, a service wildcard like /thanos.Store/* or *", i, m)
+			}
+		}
+	}
+	return conf, nil
+}
+
+// Authorizer authorizes gRPC requests with per-method policies.
+type Authorizer struct {
+	policies []AuthPolicy
+	denied   *prometheus.CounterVec
+}
+
+// NewAuthorizer returns an authorizer enforcing the policies of the configuration. Requests are denied unless
+// a policy allows them.
+func NewAuthorizer(reg prometheus.Registerer, conf AuthConfig) *Authorizer {
+	return &Authorizer{
+		policies: conf.Policies,
+		denied: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_grpc_server_requests_denied_total",
+			Help: "Total number of gRPC requests denied by the authorization policies.",
+		}, []string{"grpc_method", "reason"}),
+	}
+}
+
+// ClientIdentities returns the identities of the verified client certificate of the request.
+func ClientIdentities(ctx context.Context) []string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := info.State.VerifiedChains[0][0]
+	var ids []string
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	ids = append(ids, cert.DNSNames...)
+	for _, u := range cert.URIs {
+		ids = append(ids, u.String())
+	}
+	return append(ids, cert.EmailAddresses...)
+}
+
+// Authorize returns an error with the Unauthenticated or PermissionDenied code if the request to the method
+// is not allowed.
+func (a *Authorizer) Authorize(ctx context.Context, fullMethod string) error {
+	if strings.HasPrefix(fullMethod, healthService) {
+		return nil
+	}
+	ids := ClientIdentities(ctx)
+	if len(ids) == 0 {
+		a.denied.WithLabelValues(fullMethod, "unauthenticated").Inc()
+		return status.Error(codes.Unauthenticated, "no verified client certificate")
+	}
+	for _, p := range a.policies {
+		if matchesIdentity(p.Identities, ids) && matchesMethod(p.Methods, fullMethod) {
+			return nil
+		}
+	}
+	a.denied.WithLabelValues(fullMethod, "permission_denied").Inc()
+	return status.Errorf(codes.PermissionDenied, "client %s is not allowed to call %s", ids[0], fullMethod)
+}
+
+func matchesIdentity(allowed, ids []string) bool {
+	for _, a := range allowed {
+		if a == "*" {
+			return true
+		}
+		for _, id := range ids {
+			if a == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesMethod(allowed []string, fullMethod string) bool {
+	for _, m := range allowed {
+		if m == "*" || m == fullMethod || (strings.HasSuffix(m, "/*") && strings.HasPrefix(fullMethod, strings.TrimSuffix(m, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnaryServerInterceptor returns an interceptor authorizing unary requests.
+func (a *Authorizer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := a.Authorize(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns an interceptor authorizing streaming requests.
+func (a *Authorizer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := a.Authorize(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func clientContext(cert *x509.Certificate) context.Context {
+	state := tls.ConnectionState{}
+	if cert != nil {
+		state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+}
+
+func TestAuthorizer(t *testing.T) {
+	t.Parallel()
+
+	conf, err := ParseAuthConfig([]byte(`
+policies:
+  - identities: ["querier-a", "spiffe://example.com/b"]
+    methods: ["/thanos.Store/*", "/thanos.info.Info/Info"]
+  - identities: ["*"]
+    methods: ["/thanos.Rules/Rules"]
+`))
+	testutil.Ok(t, err)
+	a := NewAuthorizer(prometheus.NewRegistry(), *conf)
+
+	querierA := clientContext(&x509.Certificate{Subject: pkix.Name{CommonName: "querier-a"}})
+	spiffeB := clientContext(&x509.Certificate{URIs: []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/b"}}})
+	other := clientContext(&x509.Certificate{DNSNames: []string{"other.example.com"}})
+
+	for _, tc := range []struct {
+		ctx    context.Context
+		method string
+		code   codes.Code
+	}{
+		{ctx: querierA, method: "/thanos.Store/Series", code: codes.OK},
+		{ctx: spiffeB, method: "/thanos.info.Info/Info", code: codes.OK},
+		{ctx: other, method: "/thanos.Rules/Rules", code: codes.OK},
+		{ctx: other, method: "/thanos.Store/Series", code: codes.PermissionDenied},
+		{ctx: querierA, method: "/thanos.Targets/Targets", code: codes.PermissionDenied},
+		{ctx: querierA, method: "/thanos.StoreX/Series", code: codes.PermissionDenied},
+		{ctx: clientContext(nil), method: "/thanos.Rules/Rules", code: codes.Unauthenticated},
+		{ctx: context.Background(), method: "/thanos.Rules/Rules", code: codes.Unauthenticated},
+		{ctx: context.Background(), method: "/grpc.health.v1.Health/Check", code: codes.OK},
+	} {
+		testutil.Equals(t, tc.code, status.Code(a.Authorize(tc.ctx, tc.method)), "%s", tc.method)
+	}
+}
+
+func TestParseAuthConfig(t *testing.T) {
+	t.Parallel()
+
+	conf, err := ParseAuthConfig(nil)
+	testutil.Ok(t, err)
+	testutil.Assert(t, conf == nil)
+
+	_, err = ParseAuthConfig([]byte(`policies: [{identities: ["a"], methods: ["thanos.Store/Series"]}]`))
+	testutil.NotOk(t, err)
+	_, err = ParseAuthConfig([]byte(`policies: [{identities: ["a"]}]`))
+	testutil.NotOk(t, err)
+}
@@ -129,6 +129,13 @@ func New(logger log.Logger, reg prometheus.Registerer, tracer opentracing.Tracer
 		),
 	}...)
 
+	if options.authorizer != nil {
+		// Chained after the interceptors above, so that denied requests are logged and measured.
+		options.grpcOpts = append(options.grpcOpts,
+			grpc.ChainUnaryInterceptor(options.authorizer.UnaryServerInterceptor()),
+			grpc.ChainStreamInterceptor(options.authorizer.StreamServerInterceptor()),
+		)
+	}
 	if options.tlsConfig != nil {
 		options.grpcOpts = append(options.grpcOpts, grpc.Creds(credentials.NewTLS(options.tlsConfig)))
 	}
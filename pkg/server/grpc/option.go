@@ -18,7 +18,8 @@ type options struct {
 	listen      string
 	network     string
 
-	tlsConfig *tls.Config
+	tlsConfig  *tls.Config
+	authorizer *Authorizer
 
 	grpcOpts []grpc.ServerOption
 }
@@ -82,6 +83,13 @@ func WithTLSConfig(cfg *tls.Config) Option {
 	})
 }
 
+// WithAuthorizer sets the authorizer of requests, allowing all requests if nil.
+func WithAuthorizer(a *Authorizer) Option {
+	return optionFunc(func(o *options) {
+		o.authorizer = a
+	})
+}
+
 // WithMaxConnAge sets the maximum connection age for gRPC server.
 func WithMaxConnAge(t time.Duration) Option {
 	return optionFunc(func(o *options) {
@@ -14,6 +14,15 @@ import (
 
 const requestIDKey = "request-id"
 
+// contextWithRequestID returns the context with the request ID of the client, or a new one if the client
+// did not pass any.
+func contextWithRequestID(ctx context.Context) context.Context {
+	if vals := metadata.ValueFromIncomingContext(ctx, requestIDKey); len(vals) == 1 {
+		return middleware.NewContextWithRequestID(ctx, vals[0])
+	}
+	return middleware.NewContextWithRequestID(ctx, middleware.NewRequestID())
+}
+
 func NewUnaryClientRequestIDInterceptor() grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
 		reqID, ok := middleware.RequestIDFromContext(ctx)
@@ -26,10 +35,7 @@ func NewUnaryClientRequestIDInterceptor() grpc.UnaryClientInterceptor {
 
 func NewUnaryServerRequestIDInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
-		if vals := metadata.ValueFromIncomingContext(ctx, requestIDKey); len(vals) == 1 {
-			ctx = middleware.NewContextWithRequestID(ctx, vals[0])
-		}
-		return handler(ctx, req)
+		return handler(contextWithRequestID(ctx), req)
 	}
 }
 
@@ -45,11 +51,7 @@ func NewStreamClientRequestIDInterceptor() grpc.StreamClientInterceptor {
 
 func NewStreamServerRequestIDInterceptor() grpc.StreamServerInterceptor {
 	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-		if vals := metadata.ValueFromIncomingContext(ss.Context(), requestIDKey); len(vals) == 1 {
-			ctx := middleware.NewContextWithRequestID(ss.Context(), vals[0])
-			return handler(srv, newStreamWithContext(ctx, ss))
-		}
-		return handler(srv, ss)
+		return handler(srv, newStreamWithContext(contextWithRequestID(ss.Context()), ss))
 	}
 }
 
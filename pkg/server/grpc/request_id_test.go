@@ -0,0 +1,35 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
+)
+
+func TestUnaryServerRequestIDInterceptor(t *testing.T) {
+	t.Parallel()
+
+	interceptor := NewUnaryServerRequestIDInterceptor()
+	requestID := func(ctx context.Context) string {
+		var reqID string
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, _ interface{}) (interface{}, error) {
+			reqID, _ = middleware.RequestIDFromContext(ctx)
+			return nil, nil
+		})
+		testutil.Ok(t, err)
+		return reqID
+	}
+
+	testutil.Equals(t, "01ABC", requestID(metadata.NewIncomingContext(context.Background(), metadata.Pairs(requestIDKey, "01ABC"))))
+	// Requests of clients not passing a request ID get a new one.
+	first, second := requestID(context.Background()), requestID(context.Background())
+	testutil.Assert(t, first != "" && first != second)
+}
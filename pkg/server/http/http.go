@@ -19,6 +19,7 @@ import (
 	"golang.org/x/net/http2/h2c"
 
 	"github.com/thanos-io/thanos/pkg/component"
+	"github.com/thanos-io/thanos/pkg/logging"
 	"github.com/thanos-io/thanos/pkg/logutil"
 	"github.com/thanos-io/thanos/pkg/prober"
 )
@@ -49,13 +50,15 @@ func New(logger log.Logger, reg *prometheus.Registry, comp component.Component,
 	registerMetrics(mux, reg)
 	registerProbes(mux, prober, logger)
 	registerProfiler(mux)
+	registerLogLevels(mux, options.logLevels)
 
-	var h http.Handler
+	var h http.Handler = mux
+	if options.rbac != nil {
+		h = options.rbac.Wrap(h)
+	}
 	if options.enableH2C {
 		h2s := &http2.Server{}
-		h = h2c.NewHandler(mux, h2s)
-	} else {
-		h = mux
+		h = h2c.NewHandler(h, h2s)
 	}
 
 	return &Server{
@@ -122,6 +125,12 @@ func registerProfiler(mux *http.ServeMux) {
 	mux.Handle("/debug/fgprof", fgprof.Handler())
 }
 
+func registerLogLevels(mux *http.ServeMux, levels *logging.Levels) {
+	if levels != nil {
+		mux.Handle("/-/log-level", levels)
+	}
+}
+
 func registerMetrics(mux *http.ServeMux, g prometheus.Gatherer) {
 	if g != nil {
 		mux.Handle("/metrics", promhttp.HandlerFor(g, promhttp.HandlerOpts{
@@ -0,0 +1,215 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+// Capability is an administrative capability of HTTP API clients.
+type Capability string
+
+const (
+	// CapabilityReload allows reloading the configuration, e.g. the rules of Ruler.
+	CapabilityReload Capability = "reload"
+	// CapabilityMarkBlocks allows marking blocks for deletion or no compaction, and requesting compaction groups to be
+	// planned again, from the blocks API.
+	CapabilityMarkBlocks Capability = "mark_blocks"
+	// CapabilityAdmin allows all administrative endpoints, and changing log levels.
+	CapabilityAdmin Capability = "admin"
+)
+
+// adminEndpoints are the administrative endpoints by path suffix, to support route prefixes.
+var adminEndpoints = map[string]Capability{
+	"/-/reload":                  CapabilityReload,
+	"/api/v1/blocks/mark":        CapabilityMarkBlocks,
+	"/api/v1/compactions/replan": CapabilityMarkBlocks,
+	"/-/log-level":               CapabilityAdmin,
+}
+
+// unauthenticatedEndpoints are always allowed, for probes and monitoring.
+var unauthenticatedEndpoints = map[string]struct{}{
+	"/-/healthy": {},
+	"/-/ready":   {},
+	"/metrics":   {},
+}
+
+// RBACConfig maps the identities of HTTP clients to the tenants and administrative capabilities they are
+// allowed.
+type RBACConfig struct {
+	// TrustBasicAuthUsername identifies clients by their basic auth username. Only enable it if the server
+	// verifies basic auth passwords, with basic_auth_users of --http.config.
+	TrustBasicAuthUsername bool       `yaml:"trust_basic_auth_username"`
+	Rules                  []RBACRule `yaml:"rules"`
+}
+
+// RBACRule allows clients with any of the identities to make requests for any of the tenants, and to call
+// the administrative endpoints of any of the capabilities.
+type RBACRule struct {
+	// Identities are matched against the basic auth username, and the subject common name and DNS, URI and
+	// email SANs of verified client certificates. "*" matches any authenticated client.
+	Identities   []string     `yaml:"identities"`
+	Tenants      []string     `yaml:"tenants"`
+	Capabilities []Capability `yaml:"capabilities"`
+}
+
+// ParseRBACConfig parses the YAML RBAC configuration. It returns nil if the content is empty.
+func ParseRBACConfig(content []byte) (*RBACConfig, error) {
+	if len(strings.TrimSpace(string(content))) == 0 {
+		return nil, nil
+	}
+	conf := &RBACConfig{}
+	if err := yaml.UnmarshalStrict(content, conf); err != nil {
+		return nil, errors.Wrap(err, "parsing HTTP RBAC config YAML")
+	}
+	for i, r := range conf.Rules {
+		if len(r.Identities) == 0 {
+			return nil, errors.Errorf("rule %d: identities are required", i)
+		}
+		for _, c := range r.Capabilities {
+			if c != CapabilityReload && c != CapabilityMarkBlocks && c != CapabilityAdmin {
+				return nil, errors.Errorf("rule %d: unknown capability %q", i, c)
+			}
+		}
+	}
+	return conf, nil
+}
+
+// RBAC authorizes HTTP requests by the tenant they are made for and the administrative endpoint they call.
+type RBAC struct {
+	conf RBACConfig
+
+	tenantHeader    string
+	defaultTenantID string
+	certTenantField string
+
+	denied *prometheus.CounterVec
+}
+
+// NewRBAC returns the RBAC middleware of the configuration, determining the tenant of requests like the
+// component does with the given tenancy settings.
+func NewRBAC(reg prometheus.Registerer, conf RBACConfig, tenantHeader, defaultTenantID, certTenantField string) *RBAC {
+	return &RBAC{
+		conf:            conf,
+		tenantHeader:    tenantHeader,
+		defaultTenantID: defaultTenantID,
+		certTenantField: certTenantField,
+		denied: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_http_requests_denied_total",
+			Help: "Total number of HTTP requests denied by the RBAC rules.",
+		}, []string{"reason"}),
+	}
+}
+
+// Wrap returns a handler serving the requests allowed by the RBAC rules with next.
+func (a *RBAC) Wrap(next http.Handler) http.Handler {
+	return a.wrap(next, a.conf.TrustBasicAuthUsername)
+}
+
+// WrapCertificatesOnly is like Wrap, for servers not verifying basic auth passwords: clients are only
+// identified by their certificates.
+func (a *RBAC) WrapCertificatesOnly(next http.Handler) http.Handler {
+	return a.wrap(next, false)
+}
+
+func (a *RBAC) wrap(next http.Handler, basicAuth bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := unauthenticatedEndpoints[r.URL.Path]; ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ids := requestIdentities(r, basicAuth)
+		if len(ids) == 0 {
+			a.denied.WithLabelValues("unauthenticated").Inc()
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+		tenant, err := tenancy.GetTenantFromHTTP(r, a.tenantHeader, a.defaultTenantID, a.certTenantField)
+		if err != nil {
+			a.denied.WithLabelValues("invalid_tenant").Inc()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		capability, admin := adminCapability(r.URL.Path)
+		var tenantAllowed, capabilityAllowed bool
+		for _, rule := range a.conf.Rules {
+			if !matchesAny(rule.Identities, ids) {
+				continue
+			}
+			tenantAllowed = tenantAllowed || contains(rule.Tenants, tenant)
+			for _, c := range rule.Capabilities {
+				capabilityAllowed = capabilityAllowed || c == capability || c == CapabilityAdmin
+			}
+		}
+		if !tenantAllowed {
+			a.denied.WithLabelValues("tenant").Inc()
+			http.Error(w, "client "+ids[0]+" is not allowed to access tenant "+tenant, http.StatusForbidden)
+			return
+		}
+		if admin && !capabilityAllowed {
+			a.denied.WithLabelValues("capability").Inc()
+			http.Error(w, "client "+ids[0]+" does not have the "+string(capability)+" capability", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func adminCapability(urlPath string) (Capability, bool) {
+	for suffix, c := range adminEndpoints {
+		if strings.HasSuffix(urlPath, suffix) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+func requestIdentities(r *http.Request, basicAuth bool) []string {
+	var ids []string
+	if basicAuth {
+		if user, _, ok := r.BasicAuth(); ok && user != "" {
+			ids = append(ids, user)
+		}
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ids
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	ids = append(ids, cert.DNSNames...)
+	for _, u := range cert.URIs {
+		ids = append(ids, u.String())
+	}
+	return append(ids, cert.EmailAddresses...)
+}
+
+func matchesAny(allowed, ids []string) bool {
+	for _, id := range ids {
+		if contains(allowed, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(allowed []string, v string) bool {
+	for _, a := range allowed {
+		if a == "*" || a == v {
+			return true
+		}
+	}
+	return false
+}
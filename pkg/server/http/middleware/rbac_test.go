@@ -0,0 +1,96 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+func TestRBAC(t *testing.T) {
+	t.Parallel()
+
+	conf, err := ParseRBACConfig([]byte(`
+trust_basic_auth_username: true
+rules:
+  - identities: ["team-a"]
+    tenants: ["a"]
+  - identities: ["ops"]
+    tenants: ["*"]
+    capabilities: ["reload"]
+  - identities: ["admin"]
+    tenants: ["default-tenant"]
+    capabilities: ["admin"]
+`))
+	testutil.Ok(t, err)
+	rbac := NewRBAC(prometheus.NewRegistry(), *conf, tenancy.DefaultTenantHeader, tenancy.DefaultTenant, "")
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	withCert := func(r *http.Request, cn string) *http.Request {
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: cn}}}}}
+		return r
+	}
+	withBasicAuth := func(r *http.Request, user string) *http.Request {
+		r.SetBasicAuth(user, "password")
+		return r
+	}
+	withTenant := func(r *http.Request, tenant string) *http.Request {
+		r.Header.Set(tenancy.DefaultTenantHeader, tenant)
+		return r
+	}
+
+	for _, tc := range []struct {
+		name     string
+		req      *http.Request
+		certOnly bool
+		code     int
+	}{
+		{name: "tenant allowed", req: withTenant(withCert(httptest.NewRequest(http.MethodGet, "/api/v1/query", nil), "team-a"), "a"), code: http.StatusOK},
+		{name: "tenant denied", req: withTenant(withCert(httptest.NewRequest(http.MethodGet, "/api/v1/query", nil), "team-a"), "b"), code: http.StatusForbidden},
+		{name: "default tenant denied", req: withCert(httptest.NewRequest(http.MethodGet, "/api/v1/query", nil), "team-a"), code: http.StatusForbidden},
+		{name: "default tenant allowed", req: withBasicAuth(httptest.NewRequest(http.MethodGet, "/api/v1/query", nil), "admin"), code: http.StatusOK},
+		{name: "invalid tenant", req: withTenant(withCert(httptest.NewRequest(http.MethodGet, "/api/v1/query", nil), "ops"), "../x"), code: http.StatusBadRequest},
+		{name: "capability allowed", req: withTenant(withCert(httptest.NewRequest(http.MethodPost, "/-/reload", nil), "ops"), "a"), code: http.StatusOK},
+		{name: "capability denied", req: withTenant(withCert(httptest.NewRequest(http.MethodPost, "/-/reload", nil), "team-a"), "a"), code: http.StatusForbidden},
+		{name: "capability denied with route prefix", req: withTenant(withCert(httptest.NewRequest(http.MethodPost, "/prefix/api/v1/blocks/mark", nil), "ops"), "a"), code: http.StatusForbidden},
+		{name: "replan capability denied", req: withTenant(withCert(httptest.NewRequest(http.MethodPost, "/api/v1/compactions/replan", nil), "ops"), "a"), code: http.StatusForbidden},
+		{name: "admin capability for replan", req: withBasicAuth(httptest.NewRequest(http.MethodPost, "/api/v1/compactions/replan", nil), "admin"), code: http.StatusOK},
+		{name: "admin capability", req: withBasicAuth(httptest.NewRequest(http.MethodPost, "/api/v1/blocks/mark", nil), "admin"), code: http.StatusOK},
+		{name: "unauthenticated", req: httptest.NewRequest(http.MethodGet, "/api/v1/query", nil), code: http.StatusUnauthorized},
+		{name: "unauthenticated endpoint", req: httptest.NewRequest(http.MethodGet, "/-/ready", nil), code: http.StatusOK},
+		{name: "basic auth not trusted", req: withBasicAuth(httptest.NewRequest(http.MethodGet, "/api/v1/receive", nil), "admin"), certOnly: true, code: http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := rbac.Wrap(next)
+			if tc.certOnly {
+				h = rbac.WrapCertificatesOnly(next)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, tc.req)
+			testutil.Equals(t, tc.code, rec.Code)
+		})
+	}
+}
+
+func TestParseRBACConfig(t *testing.T) {
+	t.Parallel()
+
+	conf, err := ParseRBACConfig([]byte(" \n"))
+	testutil.Ok(t, err)
+	testutil.Assert(t, conf == nil)
+
+	_, err = ParseRBACConfig([]byte(`rules: [{tenants: ["a"]}]`))
+	testutil.NotOk(t, err)
+	_, err = ParseRBACConfig([]byte(`rules: [{identities: ["a"], capabilities: ["delete"]}]`))
+	testutil.NotOk(t, err)
+}
@@ -16,6 +16,15 @@ type ctxKey int
 
 const reqIDKey = ctxKey(0)
 
+// RequestIDHeader is the HTTP header of request IDs. It is also set on responses.
+const RequestIDHeader = "X-Request-ID"
+
+// NewRequestID returns a new unique ID, for requests and jobs.
+func NewRequestID() string {
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(time.Now().UnixNano())), 0)
+	return ulid.MustNew(ulid.Timestamp(time.Now()), entropy).String()
+}
+
 // NewContextWithRequestID creates a context with a request id.
 func NewContextWithRequestID(ctx context.Context, rid string) context.Context {
 	return context.WithValue(ctx, reqIDKey, rid)
@@ -27,15 +36,16 @@ func RequestIDFromContext(ctx context.Context) (string, bool) {
 	return rid, ok
 }
 
-// RequestID sets a unique request id for each request.
+// RequestID sets a unique request id for each request, unless the client passed one, and returns it in the
+// response header.
 func RequestID(h http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		reqID := r.Header.Get("X-Request-ID")
+		reqID := r.Header.Get(RequestIDHeader)
 		if reqID == "" {
-			entropy := ulid.Monotonic(rand.New(rand.NewSource(time.Now().UnixNano())), 0)
-			reqID = ulid.MustNew(ulid.Timestamp(time.Now()), entropy).String()
-			r.Header.Set("X-Request-ID", reqID)
+			reqID = NewRequestID()
+			r.Header.Set(RequestIDHeader, reqID)
 		}
+		w.Header().Set(RequestIDHeader, reqID)
 		ctx := NewContextWithRequestID(r.Context(), reqID)
 		h.ServeHTTP(w, r.WithContext(ctx))
 	}
@@ -6,6 +6,9 @@ package http
 import (
 	"net/http"
 	"time"
+
+	"github.com/thanos-io/thanos/pkg/logging"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 )
 
 type options struct {
@@ -14,6 +17,8 @@ type options struct {
 	tlsConfigPath string
 	mux           *http.ServeMux
 	enableH2C     bool
+	rbac          *middleware.RBAC
+	logLevels     *logging.Levels
 }
 
 // Option overrides behavior of Server.
@@ -61,3 +66,17 @@ func WithMux(mux *http.ServeMux) Option {
 		o.mux = mux
 	})
 }
+
+// WithRBAC authorizes requests with the given RBAC middleware, allowing all requests if nil.
+func WithRBAC(rbac *middleware.RBAC) Option {
+	return optionFunc(func(o *options) {
+		o.rbac = rbac
+	})
+}
+
+// WithLogLevels serves the log levels on /-/log-level, to change them at runtime.
+func WithLogLevels(levels *logging.Levels) Option {
+	return optionFunc(func(o *options) {
+		o.logLevels = levels
+	})
+}
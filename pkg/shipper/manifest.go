@@ -0,0 +1,193 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package shipper
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// ManifestVersion1 represents 1 version of the upload manifest.
+const ManifestVersion1 = 1
+
+// Manifest defines the format of the upload manifest the shipper keeps for every block it is uploading.
+// It lists the files of the block that were uploaded so far, so that an upload interrupted by a restart
+// resumes without uploading them again.
+type Manifest struct {
+	Version int            `json:"version"`
+	Files   []ManifestFile `json:"files"`
+}
+
+// ManifestFile is an uploaded file of the upload manifest. Files of blocks are not modified once written, so that a
+// local file with the same size, modification time and hash as the uploaded one does not have to be uploaded again.
+type ManifestFile struct {
+	RelPath   string `json:"rel_path"`
+	SizeBytes int64  `json:"size_bytes"`
+	// ModTime is the modification time of the local file in Unix nanoseconds.
+	ModTime int64 `json:"mod_time"`
+	// Hash is the hash of the content of the local file, if the shipper has a hash function.
+	Hash *metadata.ObjectHash `json:"hash,omitempty"`
+}
+
+func newManifestFile(f metadata.File, fi os.FileInfo) ManifestFile {
+	return ManifestFile{RelPath: f.RelPath, SizeBytes: fi.Size(), ModTime: fi.ModTime().UnixNano(), Hash: f.Hash}
+}
+
+func (f ManifestFile) equal(o ManifestFile) bool {
+	if f.RelPath != o.RelPath || f.SizeBytes != o.SizeBytes || f.ModTime != o.ModTime || (f.Hash == nil) != (o.Hash == nil) {
+		return false
+	}
+	return f.Hash == nil || (f.Hash.Func == o.Hash.Func && f.Hash.Equal(o.Hash))
+}
+
+func (s *Shipper) manifestDir() string {
+	return filepath.Join(s.dir, "thanos", "manifests")
+}
+
+func (s *Shipper) manifestPath(id ulid.ULID) string {
+	return filepath.Join(s.manifestDir(), id.String()+".json")
+}
+
+// readManifest returns the upload manifest of the given block, or an empty one if there is none.
+func (s *Shipper) readManifest(id ulid.ULID) *Manifest {
+	b, err := os.ReadFile(s.manifestPath(id))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			level.Warn(s.logger).Log("msg", "failed to read upload manifest, uploading the whole block", "block", id, "err", err)
+		}
+		return &Manifest{Version: ManifestVersion1}
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil || m.Version != ManifestVersion1 {
+		level.Warn(s.logger).Log("msg", "failed to parse upload manifest, uploading the whole block", "block", id, "err", err)
+		return &Manifest{Version: ManifestVersion1}
+	}
+	return &m
+}
+
+func (s *Shipper) writeManifest(id ulid.ULID, m *Manifest) error {
+	if err := os.MkdirAll(s.manifestDir(), 0750); err != nil {
+		return errors.Wrap(err, "create manifest dir")
+	}
+
+	tmp := s.manifestPath(id) + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(m); err != nil {
+		runutil.CloseWithLogOnErr(s.logger, f, "write manifest close")
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		runutil.CloseWithLogOnErr(s.logger, f, "write manifest close")
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return renameFile(s.logger, tmp, s.manifestPath(id))
+}
+
+// cleanManifests removes the upload manifests of blocks that are not in the given set anymore,
+// e.g. because Prometheus deleted them before the upload finished.
+func (s *Shipper) cleanManifests(keep map[ulid.ULID]struct{}) {
+	entries, err := os.ReadDir(s.manifestDir())
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			level.Warn(s.logger).Log("msg", "failed to list upload manifests", "err", err)
+		}
+		return
+	}
+	for _, e := range entries {
+		id, err := ulid.Parse(strings.TrimSuffix(e.Name(), ".json"))
+		if err == nil {
+			if _, ok := keep[id]; ok {
+				continue
+			}
+		}
+		if err := os.Remove(filepath.Join(s.manifestDir(), e.Name())); err != nil {
+			level.Warn(s.logger).Log("msg", "failed to remove upload manifest", "file", e.Name(), "err", err)
+		}
+	}
+}
+
+// uploadBlock uploads the block in bdir, skipping the files the upload manifest records as already uploaded.
+// On error, uploaded files are kept to resume on the next attempt.
+func (s *Shipper) uploadBlock(ctx context.Context, bdir string, meta *metadata.Meta) error {
+	m := &uploadManifest{s: s, id: meta.ULID, manifest: s.readManifest(meta.ULID)}
+	m.uploaded = make(map[string]ManifestFile, len(m.manifest.Files))
+	for _, f := range m.manifest.Files {
+		m.uploaded[f.RelPath] = f
+	}
+	m.manifest.Files = nil
+
+	if err := block.Upload(block.WithUploadManifest(ctx, m), s.logger, s.bucket, bdir, s.hashFunc); err != nil {
+		return err
+	}
+	ok, err := s.bucket.Exists(ctx, path.Join(meta.ULID.String(), block.MetaFilename))
+	if err != nil {
+		return errors.Wrap(err, "verify meta file")
+	}
+	if !ok {
+		return errors.New("verify meta file: not found after upload")
+	}
+
+	s.removeManifest(meta.ULID)
+	return nil
+}
+
+// uploadManifest is the block.UploadManifest of the upload of a block, persisting the uploaded files to its
+// upload manifest.
+type uploadManifest struct {
+	s        *Shipper
+	id       ulid.ULID
+	manifest *Manifest
+	// uploaded are the files recorded by the manifest of the previous attempt.
+	uploaded map[string]ManifestFile
+}
+
+func (m *uploadManifest) Uploaded(ctx context.Context, f metadata.File, fi os.FileInfo) bool {
+	prev, ok := m.uploaded[f.RelPath]
+	if !ok || !prev.equal(newManifestFile(f, fi)) {
+		return false
+	}
+	attrs, err := m.s.bucket.Attributes(ctx, path.Join(m.id.String(), filepath.ToSlash(f.RelPath)))
+	if err != nil || attrs.Size != fi.Size() {
+		return false
+	}
+	m.manifest.Files = append(m.manifest.Files, prev)
+	m.s.metrics.resumedFiles.Inc()
+	return true
+}
+
+func (m *uploadManifest) Add(f metadata.File, fi os.FileInfo) {
+	m.manifest.Files = append(m.manifest.Files, newManifestFile(f, fi))
+	if err := m.s.writeManifest(m.id, m.manifest); err != nil {
+		level.Warn(m.s.logger).Log("msg", "failed to write upload manifest", "block", m.id, "err", err)
+	}
+}
+
+func (m *uploadManifest) Reset() {
+	m.s.removeManifest(m.id)
+}
+
+func (s *Shipper) removeManifest(id ulid.ULID) {
+	if err := os.Remove(s.manifestPath(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		level.Warn(s.logger).Log("msg", "failed to remove upload manifest", "block", id, "err", err)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package shipper
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+func TestShipperResumesUpload(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	inmemory := objstore.NewInMemBucket()
+	lbls := labels.FromStrings("test", "test")
+	s := New(
+		inmemory,
+		dir,
+		WithSource(metadata.TestSource),
+		WithHashFunc(metadata.NoneFunc),
+		WithLabels(func() labels.Labels { return lbls }),
+	)
+
+	id := ulid.MustNew(1, nil)
+	blockDir := path.Join(dir, id.String())
+	chunksDir := path.Join(blockDir, block.ChunksDirname)
+	testutil.Ok(t, os.MkdirAll(chunksDir, os.ModePerm))
+	testutil.Ok(t, metadata.Meta{
+		BlockMeta: tsdb.BlockMeta{
+			ULID:    id,
+			MaxTime: 2000,
+			MinTime: 1000,
+			Version: 1,
+			Stats: tsdb.BlockStats{
+				NumSamples: 1000, // Not really, but shipper needs nonzero value.
+			},
+		},
+	}.WriteToDir(log.NewNopLogger(), blockDir))
+	testutil.Ok(t, os.WriteFile(filepath.Join(blockDir, "index"), []byte("index file"), 0666))
+	testutil.Ok(t, os.WriteFile(filepath.Join(chunksDir, "00001"), []byte("chunks 1"), 0666))
+	testutil.Ok(t, os.WriteFile(filepath.Join(chunksDir, "00002"), []byte("chunks 2"), 0666))
+
+	// A previous upload was interrupted after the first two files. The second one did not make it to the bucket.
+	var files []ManifestFile
+	for _, name := range []string{"chunks/00001", "chunks/00002"} {
+		fi, err := os.Stat(filepath.Join(blockDir, name))
+		testutil.Ok(t, err)
+		files = append(files, newManifestFile(metadata.File{RelPath: name}, fi))
+	}
+	testutil.Ok(t, s.writeManifest(id, &Manifest{Version: ManifestVersion1, Files: files}))
+	testutil.Ok(t, objstore.UploadFile(context.Background(), log.NewNopLogger(), inmemory, filepath.Join(chunksDir, "00001"), path.Join(id.String(), "chunks/00001")))
+
+	uploaded, err := s.Sync(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, uploaded)
+	testutil.Equals(t, 1.0, promtest.ToFloat64(s.metrics.resumedFiles))
+
+	for _, name := range []string{"chunks/00001", "chunks/00002", "index", "meta.json"} {
+		ok, err := inmemory.Exists(context.Background(), path.Join(id.String(), name))
+		testutil.Ok(t, err)
+		testutil.Assert(t, ok, "%s not uploaded", name)
+	}
+	meta, err := block.DownloadMeta(context.Background(), log.NewNopLogger(), inmemory, id)
+	testutil.Ok(t, err)
+	for _, f := range meta.Thanos.Files {
+		testutil.Assert(t, f.Hash == nil, "unexpected hash for %s", f.RelPath)
+	}
+
+	// The manifest is removed once the block is shipped.
+	_, err = os.Stat(s.manifestPath(id))
+	testutil.Assert(t, os.IsNotExist(err), "manifest not removed: %v", err)
+}
+
+func TestShipperCleansStaleManifests(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	s := New(objstore.NewInMemBucket(), dir, WithLabels(func() labels.Labels { return labels.FromStrings("test", "test") }))
+
+	id := ulid.MustNew(1, nil)
+	testutil.Ok(t, s.writeManifest(id, &Manifest{Version: ManifestVersion1}))
+
+	_, err := s.Sync(context.Background())
+	testutil.Ok(t, err)
+
+	entries, err := os.ReadDir(s.manifestDir())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(entries))
+}
+
+func TestShipperVerifiesUploadHashes(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	inmemory := objstore.NewInMemBucket()
+	s := New(
+		inmemory,
+		dir,
+		WithSource(metadata.TestSource),
+		WithHashFunc(metadata.SHA256Func),
+		WithLabels(func() labels.Labels { return labels.FromStrings("test", "test") }),
+	)
+
+	id := ulid.MustNew(1, nil)
+	blockDir := path.Join(dir, id.String())
+	chunksDir := path.Join(blockDir, block.ChunksDirname)
+	testutil.Ok(t, os.MkdirAll(chunksDir, os.ModePerm))
+	testutil.Ok(t, metadata.Meta{
+		BlockMeta: tsdb.BlockMeta{
+			ULID:    id,
+			MaxTime: 2000,
+			MinTime: 1000,
+			Version: 1,
+			Stats: tsdb.BlockStats{
+				NumSamples: 1000, // Not really, but shipper needs nonzero value.
+			},
+		},
+	}.WriteToDir(log.NewNopLogger(), blockDir))
+	testutil.Ok(t, os.WriteFile(filepath.Join(blockDir, "index"), []byte("index file"), 0666))
+	testutil.Ok(t, os.WriteFile(filepath.Join(chunksDir, "00001"), []byte("chunks 1"), 0666))
+
+	// A previous upload recorded the chunks as uploaded, but the object has the same size and different content.
+	name := "chunks/00001"
+	fi, err := os.Stat(filepath.Join(blockDir, name))
+	testutil.Ok(t, err)
+	h, err := metadata.CalculateHash(filepath.Join(blockDir, name), metadata.SHA256Func, log.NewNopLogger())
+	testutil.Ok(t, err)
+	testutil.Ok(t, s.writeManifest(id, &Manifest{Version: ManifestVersion1, Files: []ManifestFile{newManifestFile(metadata.File{RelPath: name, Hash: &h}, fi)}}))
+	testutil.Ok(t, inmemory.Upload(context.Background(), path.Join(id.String(), name), strings.NewReader("chunks X")))
+
+	// The block is not shipped, and the manifest is reset.
+	_, err = s.Sync(context.Background())
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 1.0, promtest.ToFloat64(s.metrics.resumedFiles))
+	ok, err := inmemory.Exists(context.Background(), path.Join(id.String(), block.MetaFilename))
+	testutil.Ok(t, err)
+	testutil.Assert(t, !ok, "meta.json uploaded with corrupted chunks")
+	_, err = os.Stat(s.manifestPath(id))
+	testutil.Assert(t, os.IsNotExist(err), "manifest not reset: %v", err)
+
+	// The next attempt uploads the whole block again.
+	uploaded, err := s.Sync(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, uploaded)
+	rc, err := inmemory.Get(context.Background(), path.Join(id.String(), name))
+	testutil.Ok(t, err)
+	b, err := io.ReadAll(rc)
+	testutil.Ok(t, err)
+	testutil.Ok(t, rc.Close())
+	testutil.Equals(t, "chunks 1", string(b))
+}
@@ -0,0 +1,50 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package shipper
+
+import (
+	"context"
+	"io"
+
+	"github.com/thanos-io/objstore"
+	"golang.org/x/time/rate"
+)
+
+// rateLimitedBucket is a bucket limiting the bandwidth used by uploads.
+// All uploads share the same limit.
+type rateLimitedBucket struct {
+	objstore.Bucket
+	limiter *rate.Limiter
+}
+
+func newRateLimitedBucket(bkt objstore.Bucket, bytesPerSecond int64) *rateLimitedBucket {
+	return &rateLimitedBucket{
+		Bucket:  bkt,
+		limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond)),
+	}
+}
+
+func (b *rateLimitedBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	return b.Bucket.Upload(ctx, name, &rateLimitedReader{ctx: ctx, r: r, limiter: b.limiter})
+}
+
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	// Reads can't be bigger than the burst, otherwise WaitN fails.
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.limiter.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
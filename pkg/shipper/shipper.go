@@ -6,14 +6,17 @@
 package shipper
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"io/fs"
+	"math/rand/v2"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -21,6 +24,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/version"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/prometheus/prometheus/tsdb/fileutil"
@@ -29,6 +33,9 @@ import (
 
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/exemplars/exemplarspb"
+	"github.com/thanos-io/thanos/pkg/metadata/metadatapb"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
 	"github.com/thanos-io/thanos/pkg/runutil"
 )
 
@@ -39,6 +46,8 @@ type metrics struct {
 	uploadFailures    prometheus.Counter
 	corruptedBlocks   prometheus.Counter
 	uploadedCompacted prometheus.Gauge
+	backfillDone      prometheus.Gauge
+	resumedFiles      prometheus.Counter
 }
 
 func newMetrics(reg prometheus.Registerer) *metrics {
@@ -68,6 +77,14 @@ func newMetrics(reg prometheus.Registerer) *metrics {
 		Name: "thanos_shipper_upload_compacted_done",
 		Help: "If 1 it means shipper uploaded all compacted blocks from the filesystem.",
 	})
+	m.backfillDone = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_shipper_backfill_done",
+		Help: "If 1 it means shipper finished backfilling the blocks that existed in the filesystem before it started.",
+	})
+	m.resumedFiles = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_shipper_upload_resumed_files_total",
+		Help: "Total number of block files not uploaded again when resuming an interrupted block upload",
+	})
 	return &m
 }
 
@@ -79,12 +96,21 @@ type Shipper struct {
 	metrics          *metrics
 	bucket           objstore.Bucket
 	source           metadata.SourceType
+	cluster          string
+	routingDomain    string
+	annotations      map[string]string
+	extensions       map[string]any
 	metadataFilePath string
 
 	uploadCompacted        bool
 	allowOutOfOrderUploads bool
 	skipCorruptedBlocks    bool
+	backfill               bool
 	hashFunc               metadata.HashFunc
+	uploadCompletedMark    bool
+	uploadJitter           time.Duration
+	exemplars              ExemplarsFunc
+	metricMetadata         MetricMetadataFunc
 
 	labels func() labels.Labels
 	mtx    sync.RWMutex
@@ -98,12 +124,22 @@ type shipperOptions struct {
 	logger                 log.Logger
 	r                      prometheus.Registerer
 	source                 metadata.SourceType
+	cluster                string
+	routingDomain          string
+	annotations            map[string]string
+	extensions             map[string]any
 	hashFunc               metadata.HashFunc
 	metaFileName           string
 	lbls                   func() labels.Labels
 	uploadCompacted        bool
 	allowOutOfOrderUploads bool
 	skipCorruptedBlocks    bool
+	backfill               bool
+	uploadRateLimit        int64
+	uploadCompletedMark    bool
+	uploadJitter           time.Duration
+	exemplars              ExemplarsFunc
+	metricMetadata         MetricMetadataFunc
 }
 
 type Option func(*shipperOptions)
@@ -129,6 +165,36 @@ func WithSource(source metadata.SourceType) Option {
 	}
 }
 
+// WithCluster sets the cluster recorded in the provenance of the uploaded blocks.
+func WithCluster(cluster string) Option {
+	return func(o *shipperOptions) {
+		o.cluster = cluster
+	}
+}
+
+// WithRoutingDomain sets the routing domain recorded in the meta of the uploaded blocks.
+func WithRoutingDomain(domain string) Option {
+	return func(o *shipperOptions) {
+		o.routingDomain = domain
+	}
+}
+
+// WithMetaAnnotations sets the annotations injected into the meta of the uploaded blocks, overriding the annotations
+// of the blocks with the same keys.
+func WithMetaAnnotations(annotations map[string]string) Option {
+	return func(o *shipperOptions) {
+		o.annotations = annotations
+	}
+}
+
+// WithMetaExtensions sets the extensions injected into the meta of the uploaded blocks, as the keys of a JSON object
+// overriding the keys of the extensions of the blocks.
+func WithMetaExtensions(extensions map[string]any) Option {
+	return func(o *shipperOptions) {
+		o.extensions = extensions
+	}
+}
+
 // WithHashFunc sets the hash function.
 func WithHashFunc(hashFunc metadata.HashFunc) Option {
 	return func(o *shipperOptions) {
@@ -171,6 +237,60 @@ func WithSkipCorruptedBlocks(skip bool) Option {
 	}
 }
 
+// WithBackfill sets whether to upload all blocks found in the filesystem, including compacted ones, until
+// they all have been uploaded once. Progress is persisted in the meta file, so the backfill resumes after restarts.
+func WithBackfill(backfill bool) Option {
+	return func(o *shipperOptions) {
+		o.backfill = backfill
+	}
+}
+
+// WithUploadRateLimit limits the bandwidth used by uploads to the given number of bytes per second.
+// Zero disables the limit.
+func WithUploadRateLimit(bytesPerSecond int64) Option {
+	return func(o *shipperOptions) {
+		o.uploadRateLimit = bytesPerSecond
+	}
+}
+
+// WithUploadCompletedMark sets whether to upload an upload-completed mark into each block after its files were
+// uploaded and verified, so that the compactor can process the block before its consistency delay.
+func WithUploadCompletedMark(mark bool) Option {
+	return func(o *shipperOptions) {
+		o.uploadCompletedMark = mark
+	}
+}
+
+// WithUploadJitter delays the upload of each block by a random duration up to the given one, so that instances
+// shipping blocks cut at the same time do not upload them all at once. Zero disables the jitter.
+func WithUploadJitter(maxJitter time.Duration) Option {
+	return func(o *shipperOptions) {
+		o.uploadJitter = maxJitter
+	}
+}
+
+// ExemplarsFunc returns the exemplars of the series in [mint, maxt).
+type ExemplarsFunc func(ctx context.Context, mint, maxt int64) ([]*exemplarspb.ExemplarData, error)
+
+// WithExemplars sets the function returning the exemplars persisted into the exemplars file of each uploaded block,
+// within the time range of the block, so that they outlive the exemplar storage they are read from.
+func WithExemplars(f ExemplarsFunc) Option {
+	return func(o *shipperOptions) {
+		o.exemplars = f
+	}
+}
+
+// MetricMetadataFunc returns the metadata of the metrics.
+type MetricMetadataFunc func(ctx context.Context) (map[string][]metadatapb.Meta, error)
+
+// WithMetricMetadata sets the function returning the metric metadata persisted into the metric metadata file of each
+// uploaded block, so that the metadata can be read from the bucket once its source is gone.
+func WithMetricMetadata(f MetricMetadataFunc) Option {
+	return func(o *shipperOptions) {
+		o.metricMetadata = f
+	}
+}
+
 func applyOptions(opts []Option) *shipperOptions {
 	so := new(shipperOptions)
 	for _, o := range opts {
@@ -198,6 +318,9 @@ func applyOptions(opts []Option) *shipperOptions {
 func New(bucket objstore.Bucket, dir string, opts ...Option) *Shipper {
 	options := applyOptions(opts)
 
+	if options.uploadRateLimit > 0 {
+		bucket = newRateLimitedBucket(bucket, options.uploadRateLimit)
+	}
 	return &Shipper{
 		logger:                 options.logger,
 		dir:                    dir,
@@ -205,10 +328,19 @@ func New(bucket objstore.Bucket, dir string, opts ...Option) *Shipper {
 		labels:                 options.lbls,
 		metrics:                newMetrics(options.r),
 		source:                 options.source,
+		cluster:                options.cluster,
+		routingDomain:          options.routingDomain,
+		annotations:            options.annotations,
+		extensions:             options.extensions,
 		allowOutOfOrderUploads: options.allowOutOfOrderUploads,
 		skipCorruptedBlocks:    options.skipCorruptedBlocks,
 		uploadCompacted:        options.uploadCompacted,
+		backfill:               options.backfill,
 		hashFunc:               options.hashFunc,
+		uploadCompletedMark:    options.uploadCompletedMark,
+		uploadJitter:           options.uploadJitter,
+		exemplars:              options.exemplars,
+		metricMetadata:         options.metricMetadata,
 		metadataFilePath:       filepath.Join(dir, filepath.Clean(options.metaFileName)),
 	}
 }
@@ -256,8 +388,7 @@ func (c *lazyOverlapChecker) sync(ctx context.Context) error {
 			return nil
 		}
 
-		c.metas = append(c.metas, m.BlockMeta)
-		c.lookupMetas[m.ULID] = struct{}{}
+		c.add(m.BlockMeta)
 		return nil
 
 	}); err != nil {
@@ -268,24 +399,64 @@ func (c *lazyOverlapChecker) sync(ctx context.Context) error {
 	return nil
 }
 
-func (c *lazyOverlapChecker) IsOverlapping(ctx context.Context, newMeta tsdb.BlockMeta) error {
+// add registers a block present in the bucket, so that blocks shipped later in the same sync are checked against it.
+func (c *lazyOverlapChecker) add(m tsdb.BlockMeta) {
+	if _, ok := c.lookupMetas[m.ULID]; ok {
+		return
+	}
+	c.metas = append(c.metas, m)
+	c.lookupMetas[m.ULID] = struct{}{}
+}
+
+// Check verifies that the compacted block can be shipped without introducing an overlap the compactor cannot resolve.
+// Overlapping bucket blocks compacted from a subset of the sources of the new block are fine: the compactor
+// deduplicates them by sources and garbage collects them once the new block is uploaded. It returns true if the
+// data of the new block is already in the bucket, as a block compacted from the same sources or more.
+func (c *lazyOverlapChecker) Check(ctx context.Context, newMeta tsdb.BlockMeta) (covered bool, err error) {
 	if !c.synced {
 		level.Info(c.logger).Log("msg", "gathering all existing blocks from the remote bucket for check", "id", newMeta.ULID.String())
 		if err := c.sync(ctx); err != nil {
-			return err
+			return false, err
 		}
 	}
 
-	// TODO(bwplotka) so confusing! we need to sort it first. Add comment to TSDB code.
-	metas := append([]tsdb.BlockMeta{newMeta}, c.metas...)
-	sort.Slice(metas, func(i, j int) bool {
-		return metas[i].MinTime < metas[j].MinTime
-	})
-	if o := tsdb.OverlappingBlocks(metas); len(o) > 0 {
-		// TODO(bwplotka): Consider checking if overlaps relates to block in concern?
-		return errors.Errorf("shipping compacted block %s is blocked; overlap spotted: %s", newMeta.ULID, o.String())
+	newSources := sourcesOf(newMeta)
+	for _, m := range c.metas {
+		// Block time ranges are half-open, [MinTime, MaxTime).
+		if m.MinTime >= newMeta.MaxTime || newMeta.MinTime >= m.MaxTime {
+			continue
+		}
+		sources := sourcesOf(m)
+		if containsAll(sources, newSources) {
+			return true, nil
+		}
+		if !containsAll(newSources, sources) {
+			return false, errors.Errorf("shipping compacted block %s is blocked; overlap spotted with block %s compacted from different sources: [mint: %d, maxt: %d) vs [mint: %d, maxt: %d)",
+				newMeta.ULID, m.ULID, newMeta.MinTime, newMeta.MaxTime, m.MinTime, m.MaxTime)
+		}
 	}
-	return nil
+	return false, nil
+}
+
+// sourcesOf returns the ULIDs of the level 1 blocks the given block was compacted from.
+func sourcesOf(m tsdb.BlockMeta) map[ulid.ULID]struct{} {
+	if len(m.Compaction.Sources) == 0 {
+		return map[ulid.ULID]struct{}{m.ULID: {}}
+	}
+	res := make(map[ulid.ULID]struct{}, len(m.Compaction.Sources))
+	for _, id := range m.Compaction.Sources {
+		res[id] = struct{}{}
+	}
+	return res
+}
+
+func containsAll(set, sub map[ulid.ULID]struct{}) bool {
+	for id := range sub {
+		if _, ok := set[id]; !ok {
+			return false
+		}
+	}
+	return true
 }
 
 // Sync performs a single synchronization, which ensures all non-compacted local blocks have been uploaded
@@ -320,6 +491,8 @@ func (s *Shipper) Sync(ctx context.Context) (uploaded int, err error) {
 	// Reset the uploaded slice so we can rebuild it only with blocks that still exist locally.
 	meta.Uploaded = nil
 
+	backfilling := s.backfill && !meta.BackfillDone
+
 	var (
 		checker         = newLazyOverlapChecker(s.logger, s.bucket, func() labels.Labels { return s.labels() })
 		uploadErrs      int
@@ -355,7 +528,7 @@ func (s *Shipper) Sync(ctx context.Context) (uploaded int, err error) {
 
 		// We only ship of the first compacted block level as normal flow.
 		if m.Compaction.Level > 1 {
-			if !s.uploadCompacted {
+			if !s.uploadCompacted && !backfilling {
 				continue
 			}
 		}
@@ -372,11 +545,24 @@ func (s *Shipper) Sync(ctx context.Context) (uploaded int, err error) {
 
 		// Skip overlap check if out of order uploads is enabled.
 		if m.Compaction.Level > 1 && !s.allowOutOfOrderUploads {
-			if err := checker.IsOverlapping(ctx, m.BlockMeta); err != nil {
+			covered, err := checker.Check(ctx, m.BlockMeta)
+			if err != nil {
 				return uploaded, errors.Errorf("Found overlap or error during sync, cannot upload compacted block, details: %v", err)
 			}
+			if covered {
+				level.Info(s.logger).Log("msg", "skipping compacted block, its sources are already in the bucket", "block", m.ULID)
+				meta.Uploaded = append(meta.Uploaded, m.ULID)
+				continue
+			}
 		}
 
+		if s.uploadJitter > 0 {
+			select {
+			case <-time.After(time.Duration(rand.Int64N(int64(s.uploadJitter)))):
+			case <-ctx.Done():
+				return uploaded, ctx.Err()
+			}
+		}
 		if err := s.upload(ctx, m); err != nil {
 			if !s.allowOutOfOrderUploads {
 				return uploaded, errors.Wrapf(err, "upload %v", m.ULID)
@@ -391,6 +577,25 @@ func (s *Shipper) Sync(ctx context.Context) (uploaded int, err error) {
 		meta.Uploaded = append(meta.Uploaded, m.ULID)
 		uploaded++
 		s.metrics.uploads.Inc()
+		checker.add(m.BlockMeta)
+
+		if backfilling {
+			// Persist the progress after every block, so that a restart does not check uploaded blocks again.
+			hasUploaded[m.ULID] = struct{}{}
+			if err := WriteMetaFile(s.logger, s.metadataFilePath, progressMeta(hasUploaded)); err != nil {
+				level.Warn(s.logger).Log("msg", "updating meta file with backfill progress failed", "err", err)
+			}
+		}
+	}
+	local := make(map[ulid.ULID]struct{}, len(metas))
+	for _, m := range metas {
+		local[m.ULID] = struct{}{}
+	}
+	s.cleanManifests(local)
+
+	if backfilling && uploadErrs == 0 && len(failedBlocks) == 0 {
+		level.Info(s.logger).Log("msg", "backfill of existing blocks done", "blocks", len(meta.Uploaded))
+		meta.BackfillDone = true
 	}
 	if err := WriteMetaFile(s.logger, s.metadataFilePath, meta); err != nil {
 		level.Warn(s.logger).Log("msg", "updating meta file failed", "err", err)
@@ -408,6 +613,9 @@ func (s *Shipper) Sync(ctx context.Context) (uploaded int, err error) {
 	} else {
 		s.metrics.uploadedCompacted.Set(0)
 	}
+	if meta.BackfillDone {
+		s.metrics.backfillDone.Set(1)
+	}
 	return uploaded, nil
 }
 
@@ -459,11 +667,101 @@ func (s *Shipper) upload(ctx context.Context, meta *metadata.Meta) error {
 		})
 	}
 	meta.Thanos.Source = s.source
+	// The blocks compacted by Prometheus hold the data of this component alone as well.
+	meta.Thanos.Provenance = []metadata.Provenance{{Component: s.source, Version: version.Version, Cluster: s.cluster}}
+	meta.Thanos.RoutingDomain = s.routingDomain
+	if err := s.injectMeta(meta); err != nil {
+		return err
+	}
 	meta.Thanos.SegmentFiles = block.GetSegmentFiles(updir)
+	if s.exemplars != nil {
+		// Exemplars are best effort, the block is uploaded without them if they cannot be read.
+		if err := s.writeExemplars(ctx, updir, meta); err != nil {
+			level.Warn(s.logger).Log("msg", "failed to persist exemplars into block", "block", meta.ULID, "err", err)
+		}
+	}
+	if s.metricMetadata != nil {
+		// Like exemplars, the metric metadata is best effort.
+		if err := s.writeMetricMetadata(ctx, updir); err != nil {
+			level.Warn(s.logger).Log("msg", "failed to persist metric metadata into block", "block", meta.ULID, "err", err)
+		}
+	}
 	if err := meta.WriteToDir(s.logger, updir); err != nil {
 		return errors.Wrap(err, "write meta file")
 	}
-	return block.Upload(ctx, s.logger, s.bucket, updir, s.hashFunc)
+	// Route all the objects of the block, including the markers uploaded after meta.json, by its external labels.
+	ctx = objstoreutil.WithExternalLabels(ctx, meta.Thanos.Labels)
+	if err := s.uploadBlock(ctx, updir, meta); err != nil {
+		return err
+	}
+	if !s.uploadCompletedMark {
+		return nil
+	}
+	mark, err := json.Marshal(metadata.UploadCompletedMark{
+		ID:                  meta.ULID,
+		Version:             metadata.UploadCompletedMarkVersion1,
+		UploadCompletedTime: time.Now().Unix(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "encode upload-completed mark")
+	}
+	// The mark is uploaded after meta.json, so that it is only present in blocks whose upload is completed.
+	if err := s.bucket.Upload(ctx, path.Join(meta.ULID.String(), metadata.UploadCompletedMarkFilename), bytes.NewReader(mark)); err != nil {
+		return errors.Wrap(err, "upload upload-completed mark")
+	}
+	return nil
+}
+
+// injectMeta injects the static annotations and extensions into the meta of the block.
+func (s *Shipper) injectMeta(meta *metadata.Meta) error {
+	if len(s.annotations) > 0 {
+		if meta.Thanos.Annotations == nil {
+			meta.Thanos.Annotations = make(map[string]string, len(s.annotations))
+		}
+		for k, v := range s.annotations {
+			meta.Thanos.Annotations[k] = v
+		}
+	}
+	if len(s.extensions) == 0 {
+		return nil
+	}
+	ext := map[string]any{}
+	if meta.Thanos.Extensions != nil {
+		if _, err := meta.Thanos.ParseExtensions(&ext); err != nil {
+			return errors.Wrapf(err, "extensions of block %s are not a JSON object to inject extensions into", meta.ULID)
+		}
+	}
+	for k, v := range s.extensions {
+		ext[k] = v
+	}
+	meta.Thanos.Extensions = ext
+	return nil
+}
+
+// writeExemplars writes the exemplars within the time range of the block into its exemplars file, unless it has
+// one already.
+func (s *Shipper) writeExemplars(ctx context.Context, bdir string, meta *metadata.Meta) error {
+	if _, err := os.Stat(filepath.Join(bdir, block.ExemplarsFilename)); err == nil {
+		return nil
+	}
+	data, err := s.exemplars(ctx, meta.MinTime, meta.MaxTime)
+	if err != nil {
+		return errors.Wrap(err, "get exemplars")
+	}
+	return block.WriteExemplarsFile(bdir, block.MergeExemplars([][]*exemplarspb.ExemplarData{data}, meta.MinTime, meta.MaxTime, nil))
+}
+
+// writeMetricMetadata writes the current metric metadata into the metric metadata file of the block, unless it has one
+// already.
+func (s *Shipper) writeMetricMetadata(ctx context.Context, bdir string) error {
+	if _, err := os.Stat(filepath.Join(bdir, block.MetricMetadataFilename)); err == nil {
+		return nil
+	}
+	md, err := s.metricMetadata(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get metric metadata")
+	}
+	return block.WriteMetricMetadataFile(bdir, md)
 }
 
 // blockMetasFromOldest returns the block meta of each block found in dir
@@ -548,6 +846,20 @@ func hardlinkBlock(src, dst string) error {
 type Meta struct {
 	Version  int         `json:"version"`
 	Uploaded []ulid.ULID `json:"uploaded"`
+	// BackfillDone is true once all blocks found in the filesystem while backfilling have been uploaded.
+	BackfillDone bool `json:"backfill_done,omitempty"`
+}
+
+// progressMeta returns a meta with the given blocks as uploaded.
+func progressMeta(uploaded map[ulid.ULID]struct{}) *Meta {
+	meta := &Meta{Version: MetaVersion1, Uploaded: make([]ulid.ULID, 0, len(uploaded))}
+	for id := range uploaded {
+		meta.Uploaded = append(meta.Uploaded, id)
+	}
+	sort.Slice(meta.Uploaded, func(i, j int) bool {
+		return meta.Uploaded[i].Compare(meta.Uploaded[j]) < 0
+	})
+	return meta
 }
 
 const (
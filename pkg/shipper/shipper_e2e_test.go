@@ -22,6 +22,7 @@ import (
 	"github.com/oklog/ulid/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/version"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/timestamp"
 	"github.com/prometheus/prometheus/tsdb"
@@ -128,13 +129,14 @@ func TestShipper_SyncBlocks_e2e(t *testing.T) {
 				ids = append(ids, id)
 				testutil.Equals(t, 1, b)
 			} else {
-				// 5 blocks uploaded so far - 5 existence checks & 25 uploads (5 files each).
+				// 5 blocks uploaded so far - 5 existence checks, 20 uploads (4 files each),
+				// 15 size checks of the data files and 5 existence checks of the uploaded meta files.
 				testutil.Ok(t, promtest.GatherAndCompare(metrics, strings.NewReader(`
 				# HELP thanos_objstore_bucket_operations_total Total number of all attempted operations against a bucket.
 				# TYPE thanos_objstore_bucket_operations_total counter
-				thanos_objstore_bucket_operations_total{bucket="test",operation="attributes"} 0
+				thanos_objstore_bucket_operations_total{bucket="test",operation="attributes"} 15
 				thanos_objstore_bucket_operations_total{bucket="test",operation="delete"} 0
-				thanos_objstore_bucket_operations_total{bucket="test",operation="exists"} 5
+				thanos_objstore_bucket_operations_total{bucket="test",operation="exists"} 10
 				thanos_objstore_bucket_operations_total{bucket="test",operation="get"} 0
 				thanos_objstore_bucket_operations_total{bucket="test",operation="get_range"} 0
 				thanos_objstore_bucket_operations_total{bucket="test",operation="iter"} 0
@@ -146,6 +148,7 @@ func TestShipper_SyncBlocks_e2e(t *testing.T) {
 			// The external labels must be attached to the meta file on upload.
 			meta.Thanos.Labels = extLset.Map()
 			meta.Thanos.SegmentFiles = []string{"0001", "0002"}
+			meta.Thanos.Provenance = []metadata.Provenance{{Component: metadata.TestSource, Version: version.Version}}
 			meta.Thanos.Files = []metadata.File{
 				{RelPath: "chunks/0001", SizeBytes: 14},
 				{RelPath: "chunks/0002", SizeBytes: 14},
@@ -293,6 +296,7 @@ func TestShipper_SyncBlocksWithMigrating_e2e(t *testing.T) {
 			// The external labels must be attached to the meta file on upload.
 			meta.Thanos.Labels = extLset.Map()
 			meta.Thanos.SegmentFiles = []string{"0001", "0002"}
+			meta.Thanos.Provenance = []metadata.Provenance{{Component: metadata.TestSource, Version: version.Version}}
 			meta.Thanos.Files = []metadata.File{
 				{RelPath: "chunks/0001", SizeBytes: 14},
 				{RelPath: "chunks/0002", SizeBytes: 14},
@@ -450,6 +454,7 @@ func TestShipper_SyncOverlapBlocks_e2e(t *testing.T) {
 		// The external labels must be attached to the meta file on upload.
 		m[i].Thanos.Labels = extLset.Map()
 		m[i].Thanos.SegmentFiles = []string{"0001", "0002"}
+		m[i].Thanos.Provenance = []metadata.Provenance{{Component: metadata.TestSource, Version: version.Version}}
 		m[i].Thanos.Files = []metadata.File{
 			{RelPath: "chunks/0001", SizeBytes: 14},
 			{RelPath: "chunks/0002", SizeBytes: 14},
@@ -6,6 +6,7 @@ package shipper
 import (
 	"context"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
 	"path"
@@ -13,11 +14,13 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/oklog/ulid/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/version"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/tsdb"
 
@@ -26,6 +29,10 @@ import (
 	"github.com/efficientgo/core/testutil"
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/exemplars/exemplarspb"
+	"github.com/thanos-io/thanos/pkg/metadata/metadatapb"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
 )
 
 func TestIterBlockMetas(t *testing.T) {
@@ -168,6 +175,7 @@ func TestShipperAddsSegmentFiles(t *testing.T) {
 		WithSource(metadata.TestSource),
 		WithHashFunc(metadata.NoneFunc),
 		WithLabels(func() labels.Labels { return lbls }),
+		WithCluster("eu-1"),
 	)
 
 	id := ulid.MustNew(1, nil)
@@ -199,6 +207,7 @@ func TestShipperAddsSegmentFiles(t *testing.T) {
 	testutil.Ok(t, err)
 
 	testutil.Equals(t, []string{segmentFile}, meta.Thanos.SegmentFiles)
+	testutil.Equals(t, []metadata.Provenance{{Component: metadata.TestSource, Version: version.Version, Cluster: "eu-1"}}, meta.Thanos.Provenance)
 
 	testutil.Ok(t, promtest.GatherAndCompare(metrics, strings.NewReader(`
 				# HELP thanos_shipper_dir_syncs_total Total number of dir syncs
@@ -264,6 +273,162 @@ func TestShipperSkipCorruptedBlocks(t *testing.T) {
 				`), `thanos_shipper_corrupted_blocks_total`))
 }
 
+func TestShipperUploadCompletedMark(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	inmemory := objstore.NewInMemBucket()
+	lbls := labels.FromStrings("test", "test")
+	s := New(
+		inmemory,
+		dir,
+		WithSource(metadata.TestSource),
+		WithHashFunc(metadata.NoneFunc),
+		WithLabels(func() labels.Labels { return lbls }),
+		WithUploadCompletedMark(true),
+		WithUploadJitter(time.Millisecond),
+	)
+
+	id := ulid.MustNew(1, nil)
+	blockDir := path.Join(dir, id.String())
+	chunksDir := path.Join(blockDir, block.ChunksDirname)
+	testutil.Ok(t, os.MkdirAll(chunksDir, os.ModePerm))
+	testutil.Ok(t, metadata.Meta{
+		BlockMeta: tsdb.BlockMeta{
+			ULID:    id,
+			MaxTime: 2000,
+			MinTime: 1000,
+			Version: 1,
+			Stats: tsdb.BlockStats{
+				NumSamples: 1000, // Not really, but shipper needs nonzero value.
+			},
+		},
+	}.WriteToDir(log.NewNopLogger(), blockDir))
+	testutil.Ok(t, os.WriteFile(filepath.Join(blockDir, "index"), []byte("index file"), 0666))
+	testutil.Ok(t, os.WriteFile(filepath.Join(chunksDir, "00001"), []byte("hello world"), 0666))
+
+	before := time.Now().Unix()
+	uploaded, err := s.Sync(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, uploaded)
+
+	m := &metadata.UploadCompletedMark{}
+	testutil.Ok(t, metadata.ReadMarker(context.Background(), log.NewNopLogger(), objstore.WithNoopInstr(inmemory), id.String(), m))
+	testutil.Equals(t, id, m.ID)
+	testutil.Equals(t, metadata.UploadCompletedMarkVersion1, m.Version)
+	testutil.Assert(t, m.UploadCompletedTime >= before)
+}
+
+func TestShipperExemplars(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	inmemory := objstore.NewInMemBucket()
+	lbls := labels.FromStrings("test", "test")
+	series := labelpb.ZLabelSet{Labels: []labelpb.ZLabel{{Name: "__name__", Value: "a"}}}
+	var mint, maxt int64
+	s := New(
+		inmemory,
+		dir,
+		WithSource(metadata.TestSource),
+		WithHashFunc(metadata.NoneFunc),
+		WithLabels(func() labels.Labels { return lbls }),
+		WithExemplars(func(_ context.Context, start, end int64) ([]*exemplarspb.ExemplarData, error) {
+			mint, maxt = start, end
+			return []*exemplarspb.ExemplarData{{
+				SeriesLabels: series,
+				// Exemplars outside of the time range of the block are dropped.
+				Exemplars: []*exemplarspb.Exemplar{{Value: 1, Ts: 1000}, {Value: 2, Ts: 2000}},
+			}}, nil
+		}),
+	)
+
+	id := ulid.MustNew(1, nil)
+	blockDir := path.Join(dir, id.String())
+	chunksDir := path.Join(blockDir, block.ChunksDirname)
+	testutil.Ok(t, os.MkdirAll(chunksDir, os.ModePerm))
+	testutil.Ok(t, metadata.Meta{
+		BlockMeta: tsdb.BlockMeta{
+			ULID:    id,
+			MaxTime: 2000,
+			MinTime: 1000,
+			Version: 1,
+			Stats: tsdb.BlockStats{
+				NumSamples: 1000, // Not really, but shipper needs nonzero value.
+			},
+		},
+	}.WriteToDir(log.NewNopLogger(), blockDir))
+	testutil.Ok(t, os.WriteFile(filepath.Join(blockDir, "index"), []byte("index file"), 0666))
+	testutil.Ok(t, os.WriteFile(filepath.Join(chunksDir, "00001"), []byte("hello world"), 0666))
+
+	uploaded, err := s.Sync(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, uploaded)
+	testutil.Equals(t, int64(1000), mint)
+	testutil.Equals(t, int64(2000), maxt)
+
+	meta, err := block.DownloadMeta(context.Background(), log.NewNopLogger(), inmemory, id)
+	testutil.Ok(t, err)
+	testutil.Assert(t, block.HasExemplars(&meta))
+
+	r, err := inmemory.Get(context.Background(), path.Join(id.String(), block.ExemplarsFilename))
+	testutil.Ok(t, err)
+	data, err := block.ReadExemplarsFile(r)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []*exemplarspb.ExemplarData{{
+		SeriesLabels: series,
+		Exemplars:    []*exemplarspb.Exemplar{{Value: 1, Ts: 1000}},
+	}}, data)
+	_, err = os.Stat(filepath.Join(blockDir, block.ExemplarsFilename))
+	testutil.Assert(t, os.IsNotExist(err), "exemplars must only be written into the upload directory")
+}
+
+func TestShipperMetricMetadata(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	inmemory := objstore.NewInMemBucket()
+	lbls := labels.FromStrings("test", "test")
+	md := map[string][]metadatapb.Meta{"up": {{Type: "gauge", Help: "Up."}}}
+	s := New(
+		inmemory,
+		dir,
+		WithSource(metadata.TestSource),
+		WithHashFunc(metadata.NoneFunc),
+		WithLabels(func() labels.Labels { return lbls }),
+		WithMetricMetadata(func(context.Context) (map[string][]metadatapb.Meta, error) { return md, nil }),
+	)
+
+	id := ulid.MustNew(1, nil)
+	blockDir := path.Join(dir, id.String())
+	testutil.Ok(t, os.MkdirAll(path.Join(blockDir, block.ChunksDirname), os.ModePerm))
+	testutil.Ok(t, metadata.Meta{
+		BlockMeta: tsdb.BlockMeta{
+			ULID:    id,
+			MaxTime: 2000,
+			MinTime: 1000,
+			Version: 1,
+			Stats: tsdb.BlockStats{
+				NumSamples: 1000, // Not really, but shipper needs nonzero value.
+			},
+		},
+	}.WriteToDir(log.NewNopLogger(), blockDir))
+	testutil.Ok(t, os.WriteFile(filepath.Join(blockDir, "index"), []byte("index file"), 0666))
+
+	uploaded, err := s.Sync(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, uploaded)
+
+	meta, err := block.DownloadMeta(context.Background(), log.NewNopLogger(), inmemory, id)
+	testutil.Ok(t, err)
+	testutil.Assert(t, block.HasMetricMetadata(&meta))
+	r, err := inmemory.Get(context.Background(), path.Join(id.String(), block.MetricMetadataFilename))
+	testutil.Ok(t, err)
+	got, err := block.ReadMetricMetadataFile(r)
+	testutil.Ok(t, err)
+	testutil.Equals(t, md, got)
+}
+
 func TestShipperNotSkipCorruptedBlocks(t *testing.T) {
 	dir := t.TempDir()
 	inmemory := objstore.NewInMemBucket()
@@ -399,3 +564,268 @@ func TestShipperExistingThanosLabels(t *testing.T) {
 	testutil.Ok(t, err)
 	testutil.Equals(t, map[string]string{"cluster": "us-east-1", "test": "test"}, meta.Thanos.Labels)
 }
+
+func TestShipperMetaAnnotationsAndExtensions(t *testing.T) {
+	dir := t.TempDir()
+	inmemory := objstore.NewInMemBucket()
+	s := New(
+		inmemory,
+		dir,
+		WithSource(metadata.TestSource),
+		WithHashFunc(metadata.NoneFunc),
+		WithLabels(func() labels.Labels { return labels.FromStrings("test", "test") }),
+		WithMetaAnnotations(map[string]string{"environment": "prod", "team": "observability"}),
+		WithMetaExtensions(map[string]any{"version": "v1"}),
+	)
+
+	id := ulid.MustNew(1, nil)
+	blockDir := path.Join(dir, id.String())
+	testutil.Ok(t, os.MkdirAll(path.Join(blockDir, block.ChunksDirname), os.ModePerm))
+
+	// Prepare meta.json with annotations and extensions to merge with.
+	testutil.Ok(t, metadata.Meta{
+		Thanos: metadata.Thanos{
+			Annotations: map[string]string{"team": "storage", "owner": "alice"},
+			Extensions:  map[string]any{"version": "v0", "tier": "hot"},
+		},
+		BlockMeta: tsdb.BlockMeta{
+			ULID:    id,
+			MaxTime: 2000,
+			MinTime: 1000,
+			Version: 1,
+			Stats: tsdb.BlockStats{
+				NumSamples: 1000, // Not really, but shipper needs nonzero value.
+			},
+		},
+	}.WriteToDir(log.NewNopLogger(), blockDir))
+	testutil.Ok(t, os.WriteFile(filepath.Join(blockDir, "index"), []byte("index file"), 0666))
+
+	uploaded, err := s.Sync(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, uploaded)
+
+	meta, err := block.DownloadMeta(context.Background(), log.NewNopLogger(), inmemory, id)
+	testutil.Ok(t, err)
+	testutil.Equals(t, map[string]string{"environment": "prod", "team": "observability", "owner": "alice"}, meta.Thanos.Annotations)
+	testutil.Equals(t, map[string]any{"version": "v1", "tier": "hot"}, meta.Thanos.Extensions)
+}
+
+func TestShipperRoutingBucket(t *testing.T) {
+	dir := t.TempDir()
+	defDir, teamDir := t.TempDir(), t.TempDir()
+	bkt, err := objstoreutil.NewBucket(log.NewNopLogger(), []byte(`type: ROUTING
+config:
+  default:
+    bucket:
+      type: FILESYSTEM
+      config:
+        directory: `+defDir+`
+  routes:
+    - external_labels:
+        team: a
+      bucket:
+        type: FILESYSTEM
+        config:
+          directory: `+teamDir), "test", nil)
+	testutil.Ok(t, err)
+	s := New(
+		bkt,
+		dir,
+		WithSource(metadata.TestSource),
+		WithHashFunc(metadata.NoneFunc),
+		WithLabels(func() labels.Labels { return labels.FromStrings("team", "a") }),
+		WithUploadCompletedMark(true),
+	)
+
+	id := ulid.MustNew(1, nil)
+	blockDir := path.Join(dir, id.String())
+	testutil.Ok(t, os.MkdirAll(path.Join(blockDir, block.ChunksDirname), os.ModePerm))
+	testutil.Ok(t, metadata.Meta{
+		BlockMeta: tsdb.BlockMeta{
+			ULID:    id,
+			MaxTime: 2000,
+			MinTime: 1000,
+			Version: 1,
+			Stats: tsdb.BlockStats{
+				NumSamples: 1000, // Not really, but shipper needs nonzero value.
+			},
+		},
+	}.WriteToDir(log.NewNopLogger(), blockDir))
+	testutil.Ok(t, os.WriteFile(filepath.Join(blockDir, "index"), []byte("index file"), 0666))
+	testutil.Ok(t, os.WriteFile(filepath.Join(blockDir, block.ChunksDirname, "000001"), []byte("chunks"), 0666))
+
+	uploaded, err := s.Sync(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, uploaded)
+
+	// All the objects of the block are in the bucket of its route, none in the default one.
+	for _, name := range []string{block.MetaFilename, block.IndexFilename, "chunks/000001", metadata.UploadCompletedMarkFilename} {
+		_, err := os.Stat(filepath.Join(teamDir, id.String(), name))
+		testutil.Ok(t, err)
+	}
+	_, err = os.Stat(filepath.Join(defDir, id.String()))
+	testutil.Assert(t, os.IsNotExist(err), "block uploaded to the default bucket: %v", err)
+}
+
+func TestShipperUploadCompactedChecksSources(t *testing.T) {
+	dir := t.TempDir()
+	inmemory := objstore.NewInMemBucket()
+	lbls := labels.FromStrings("test", "test")
+	s := New(
+		inmemory,
+		dir,
+		WithSource(metadata.TestSource),
+		WithHashFunc(metadata.NoneFunc),
+		WithLabels(func() labels.Labels { return lbls }),
+		WithUploadCompacted(true),
+	)
+
+	writeBlock := func(id ulid.ULID, mint, maxt int64, sources ...ulid.ULID) {
+		blockDir := path.Join(dir, id.String())
+		testutil.Ok(t, os.MkdirAll(path.Join(blockDir, block.ChunksDirname), os.ModePerm))
+		compaction := tsdb.BlockMetaCompaction{Level: 1, Sources: []ulid.ULID{id}}
+		if len(sources) > 0 {
+			compaction = tsdb.BlockMetaCompaction{Level: 2, Sources: sources}
+		}
+		testutil.Ok(t, metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{
+				ULID:       id,
+				MinTime:    mint,
+				MaxTime:    maxt,
+				Version:    1,
+				Compaction: compaction,
+				Stats: tsdb.BlockStats{
+					NumSamples: 1000, // Not really, but shipper needs nonzero value.
+				},
+			},
+		}.WriteToDir(log.NewNopLogger(), blockDir))
+		testutil.Ok(t, os.WriteFile(filepath.Join(blockDir, "index"), []byte("index file"), 0666))
+	}
+	exists := func(id ulid.ULID) bool {
+		ok, err := inmemory.Exists(context.Background(), path.Join(id.String(), block.MetaFilename))
+		testutil.Ok(t, err)
+		return ok
+	}
+
+	var (
+		id1 = ulid.MustNew(1, nil)
+		id2 = ulid.MustNew(2, nil)
+		id3 = ulid.MustNew(3, nil)
+		id4 = ulid.MustNew(4, nil)
+		id5 = ulid.MustNew(5, nil)
+	)
+
+	// Level 1 blocks are shipped first, before Prometheus compacts them.
+	writeBlock(id1, 0, 10)
+	writeBlock(id2, 10, 20)
+	uploaded, err := s.Sync(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, uploaded)
+
+	// The compacted block overlaps only with the blocks it was compacted from.
+	testutil.Ok(t, os.RemoveAll(path.Join(dir, id1.String())))
+	testutil.Ok(t, os.RemoveAll(path.Join(dir, id2.String())))
+	writeBlock(id3, 0, 20, id1, id2)
+	uploaded, err = s.Sync(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, uploaded)
+	testutil.Assert(t, exists(id3))
+
+	// The sources of this block are already in the bucket, in the bigger compacted block.
+	writeBlock(id4, 0, 10, id1)
+	uploaded, err = s.Sync(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, uploaded)
+	testutil.Assert(t, !exists(id4))
+	_, ok := s.UploadedBlocks()[id4]
+	testutil.Assert(t, ok, "covered block should be marked as uploaded")
+
+	// This block overlaps with data from other sources, which the compactor cannot resolve.
+	writeBlock(id5, 5, 15, ulid.MustNew(10, nil), ulid.MustNew(11, nil))
+	_, err = s.Sync(context.Background())
+	testutil.NotOk(t, err)
+	testutil.Assert(t, !exists(id5))
+}
+
+func TestShipperBackfill(t *testing.T) {
+	dir := t.TempDir()
+	inmemory := objstore.NewInMemBucket()
+	metrics := prometheus.NewRegistry()
+	lbls := labels.FromStrings("test", "test")
+	newShipper := func() *Shipper {
+		return New(
+			inmemory,
+			dir,
+			WithRegisterer(metrics),
+			WithSource(metadata.TestSource),
+			WithHashFunc(metadata.NoneFunc),
+			WithLabels(func() labels.Labels { return lbls }),
+			WithBackfill(true),
+		)
+	}
+
+	writeBlock := func(id ulid.ULID, mint, maxt int64, level int) {
+		blockDir := path.Join(dir, id.String())
+		testutil.Ok(t, os.MkdirAll(path.Join(blockDir, block.ChunksDirname), os.ModePerm))
+		testutil.Ok(t, metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{
+				ULID:       id,
+				MinTime:    mint,
+				MaxTime:    maxt,
+				Version:    1,
+				Compaction: tsdb.BlockMetaCompaction{Level: level, Sources: []ulid.ULID{id}},
+				Stats: tsdb.BlockStats{
+					NumSamples: 1000, // Not really, but shipper needs nonzero value.
+				},
+			},
+		}.WriteToDir(log.NewNopLogger(), blockDir))
+		testutil.Ok(t, os.WriteFile(filepath.Join(blockDir, "index"), []byte("index file"), 0666))
+	}
+
+	var (
+		id1 = ulid.MustNew(1, nil)
+		id2 = ulid.MustNew(2, nil)
+		id3 = ulid.MustNew(3, nil)
+	)
+	writeBlock(id1, 0, 20, 3)
+	writeBlock(id2, 20, 30, 1)
+
+	s := newShipper()
+	uploaded, err := s.Sync(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, uploaded)
+
+	meta, err := ReadMetaFile(s.metadataFilePath)
+	testutil.Ok(t, err)
+	testutil.Equals(t, &Meta{Version: MetaVersion1, Uploaded: []ulid.ULID{id1, id2}, BackfillDone: true}, meta)
+	testutil.Equals(t, 1.0, promtest.ToFloat64(s.metrics.backfillDone))
+
+	// Once the backfill is done, compacted blocks are not uploaded anymore, also after a restart.
+	writeBlock(id3, 30, 50, 2)
+	metrics = prometheus.NewRegistry()
+	s = newShipper()
+	uploaded, err = s.Sync(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, uploaded)
+	ok, err := inmemory.Exists(context.Background(), path.Join(id3.String(), block.MetaFilename))
+	testutil.Ok(t, err)
+	testutil.Assert(t, !ok, "compacted block uploaded after backfill")
+}
+
+func TestRateLimitedBucket(t *testing.T) {
+	t.Parallel()
+
+	bkt := newRateLimitedBucket(objstore.NewInMemBucket(), 1000)
+	payload := strings.Repeat("a", 1500)
+
+	start := time.Now()
+	testutil.Ok(t, bkt.Upload(context.Background(), "obj", strings.NewReader(payload)))
+	// The first 1000 bytes are allowed by the burst, the remaining ones take half a second.
+	testutil.Assert(t, time.Since(start) >= 400*time.Millisecond, "upload was not rate limited: %v", time.Since(start))
+
+	r, err := bkt.Get(context.Background(), "obj")
+	testutil.Ok(t, err)
+	b, err := io.ReadAll(r)
+	testutil.Ok(t, err)
+	testutil.Equals(t, payload, string(b))
+}
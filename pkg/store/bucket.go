@@ -45,14 +45,19 @@ import (
 
 	"github.com/thanos-io/objstore"
 
+	"github.com/thanos-io/thanos/pkg/activetracker"
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/indexheader"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/cardinality"
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	"github.com/thanos-io/thanos/pkg/gate"
 	"github.com/thanos-io/thanos/pkg/info/infopb"
+	"github.com/thanos-io/thanos/pkg/metadata/metadatapb"
+	"github.com/thanos-io/thanos/pkg/metering"
 	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/objstoreutil"
 	"github.com/thanos-io/thanos/pkg/pool"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	storecache "github.com/thanos-io/thanos/pkg/store/cache"
@@ -102,6 +107,11 @@ const (
 	// TODO(bwplotka): Remove it at some point.
 	CompatibilityTypeLabelName = "@thanos_compatibility_store_type"
 
+	// BlockIDDebugLabelName is a virtual label of the series matchers restricting a query to the blocks whose ULID
+	// matches, e.g. {__name__="up", __block_id__="01H..."}. The series of the blocks queried with such matchers are
+	// annotated with the label, set to the ULID of their block, to trace wrong data back to the block holding it.
+	BlockIDDebugLabelName = "__block_id__"
+
 	// DefaultPostingOffsetInMemorySampling represents default value for --store.index-header-posting-offsets-in-mem-sampling.
 	// 32 value is chosen as it's a good balance for common setups. Sampling that is not too large (too many CPU cycles) and
 	// not too small (too much memory).
@@ -165,6 +175,8 @@ type bucketStoreMetrics struct {
 	seriesRefetches       *prometheus.CounterVec
 	chunkRefetches        *prometheus.CounterVec
 	emptyPostingCount     *prometheus.CounterVec
+	labelsBloomPruned     *prometheus.CounterVec
+	seriesHashes          *prometheus.CounterVec
 
 	lazyExpandedPostingsCount                     prometheus.Counter
 	lazyExpandedPostingGroupsByReason             *prometheus.CounterVec
@@ -357,6 +369,16 @@ func newBucketStoreMetrics(reg prometheus.Registerer) *bucketStoreMetrics {
 		Help: "Total number of empty postings when fetching block series.",
 	}, []string{tenancy.MetricLabel})
 
+	m.labelsBloomPruned = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_labels_bloom_pruned_blocks_total",
+		Help: "Total number of blocks not queried because their labels bloom filter does not contain the label pairs of the request matchers.",
+	}, []string{tenancy.MetricLabel})
+
+	m.seriesHashes = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_series_hashes_total",
+		Help: "Total number of shard hashes of series of sharded requests, by source: precomputed by the compactor, from the series hash cache or computed.",
+	}, []string{"source"})
+
 	m.lazyExpandedPostingsCount = promauto.With(reg).NewCounter(prometheus.CounterOpts{
 		Name: "thanos_bucket_store_lazy_expanded_postings_total",
 		Help: "Total number of times when lazy expanded posting optimization applies.",
@@ -419,6 +441,10 @@ type BucketStore struct {
 
 	// Query gate which limits the maximum amount of concurrent queries.
 	queryGate gate.Gate
+	// activeTracker records the requests in flight, to log them on the next start after a crash.
+	activeTracker *activetracker.Tracker
+	// meter meters the bytes and series queried by tenants.
+	meter *metering.Meter
 
 	// chunksLimiterFactory creates a new limiter used to limit the number of chunks fetched by each Series() call.
 	chunksLimiterFactory ChunksLimiterFactory
@@ -458,6 +484,13 @@ type BucketStore struct {
 	requestLoggerFunc RequestLoggerFunc
 
 	blockLifecycleCallback BlockLifecycleCallback
+
+	enableLabelsBloom bool
+
+	prebuiltIndexHeaders bool
+
+	seriesHashCache    *SeriesHashCache
+	enableSeriesHashes bool
 }
 
 func (s *BucketStore) validate() error {
@@ -536,6 +569,20 @@ func WithQueryGate(queryGate gate.Gate) BucketStoreOption {
 	}
 }
 
+// WithActiveTracker sets a tracker recording the requests in flight.
+func WithActiveTracker(tracker *activetracker.Tracker) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.activeTracker = tracker
+	}
+}
+
+// WithMeter sets a meter of the bytes and series queried by tenants.
+func WithMeter(meter *metering.Meter) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.meter = meter
+	}
+}
+
 // WithChunkPool sets a pool.Bytes to use for chunks.
 func WithChunkPool(chunkPool pool.Pool[byte]) BucketStoreOption {
 	return func(s *BucketStore) {
@@ -625,6 +672,37 @@ func WithIndexHeaderLazyDownloadStrategy(strategy indexheader.LazyDownloadIndexH
 	}
 }
 
+// WithLabelsBloom enables loading the optional labels bloom filters of blocks, written by the compactor, to skip
+// blocks without series with the label pairs of the request matchers.
+func WithLabelsBloom(enabled bool) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.enableLabelsBloom = enabled
+	}
+}
+
+// WithPrebuiltIndexHeaders enables downloading the index-headers built by the compactor next to the blocks, instead
+// of building them from the index of the blocks.
+func WithPrebuiltIndexHeaders(enabled bool) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.prebuiltIndexHeaders = enabled
+	}
+}
+
+// WithSeriesHashCache sets the cache of the shard hashes of the series of sharded requests.
+func WithSeriesHashCache(cache *SeriesHashCache) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.seriesHashCache = cache
+	}
+}
+
+// WithPrecomputedSeriesHashes enables loading the optional series hashes of blocks, written by the compactor, not to
+// hash the labels of the series of sharded requests.
+func WithPrecomputedSeriesHashes(enabled bool) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.enableSeriesHashes = enabled
+	}
+}
+
 // BlockLifecycleCallback specifies callbacks that will be called during the lifecycle of a block.
 type BlockLifecycleCallback interface {
 	// PreAdd is called before adding a block to indicate if the block needs to be added.
@@ -701,6 +779,7 @@ func NewBucketStore(
 	// Depend on the options
 	indexReaderPoolMetrics := indexheader.NewReaderPoolMetrics(extprom.WrapRegistererWithPrefix("thanos_bucket_store_", s.reg))
 	s.indexReaderPool = indexheader.NewReaderPool(s.logger, lazyIndexReaderEnabled, lazyIndexReaderIdleTimeout, indexReaderPoolMetrics, s.indexHeaderLazyDownloadStrategy)
+	s.indexReaderPool.SetPrebuiltIndexHeaders(s.prebuiltIndexHeaders)
 	s.metrics = newBucketStoreMetrics(s.reg) // TODO(metalmatze): Might be possible via Option too
 
 	if err := s.validate(); err != nil {
@@ -852,6 +931,7 @@ func (s *BucketStore) getBlock(id ulid.ULID) *bucketBlock {
 }
 
 func (s *BucketStore) addBlock(ctx context.Context, meta *metadata.Meta) (err error) {
+	ctx = objstoreutil.WithCaller(ctx, objstoreutil.CallerIndexHeader)
 	var dir string
 	if s.dir != "" {
 		dir = path.Join(s.dir, meta.ULID.String())
@@ -918,6 +998,22 @@ func (s *BucketStore) addBlock(ctx context.Context, meta *metadata.Meta) (err er
 		}
 	}()
 
+	if s.enableLabelsBloom {
+		// The filter is optional, so the block is still queried without it.
+		if b.labelsBloom, err = readLabelsBloom(ctx, s.logger, s.bkt, meta.ULID); err != nil {
+			level.Warn(s.logger).Log("msg", "failed to read labels bloom filter, querying block without it", "id", meta.ULID, "err", err)
+			err = nil
+		}
+	}
+	b.seriesHashCache = s.seriesHashCache
+	if s.enableSeriesHashes {
+		// The series hashes are optional, so the labels of series are hashed without them.
+		if b.seriesHashes, err = readSeriesHashes(ctx, s.logger, s.bkt, meta.ULID); err != nil {
+			level.Warn(s.logger).Log("msg", "failed to read series hashes, querying block without them", "id", meta.ULID, "err", err)
+			err = nil
+		}
+	}
+
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
@@ -952,6 +1048,7 @@ func (s *BucketStore) removeBlock(id ulid.ULID) error {
 	}
 
 	s.metrics.blocksLoaded.Dec()
+	s.seriesHashCache.dropBlock(id)
 	if err := b.Close(); err != nil {
 		return errors.Wrap(err, "close block")
 	}
@@ -1024,6 +1121,45 @@ func (s *BucketStore) TSDBInfos() []infopb.TSDBInfo {
 	return res
 }
 
+// Cardinality returns the cardinality of the series of the blocks of the tenant overlapping with mint and maxt,
+// from the index headers of the blocks. The tenant of a block is the value of its tenantLabel external label, or
+// the default tenant without it.
+func (s *BucketStore) Cardinality(ctx context.Context, tenantLabel, tenant string, mint, maxt int64, limit int) (cardinality.Stats, error) {
+	var indexrs []*bucketIndexReader
+	s.mtx.RLock()
+	for _, b := range s.blocks {
+		if !b.overlapsClosedInterval(mint, maxt) {
+			continue
+		}
+		blockTenant := b.extLset.Get(tenantLabel)
+		if blockTenant == "" {
+			blockTenant = tenancy.DefaultTenant
+		}
+		if blockTenant != tenant {
+			continue
+		}
+		// The reader registers as pending, so that the block is not closed while analyzing it.
+		indexrs = append(indexrs, b.indexReader(s.logger))
+	}
+	s.mtx.RUnlock()
+	defer func() {
+		for _, indexr := range indexrs {
+			runutil.CloseWithLogOnErr(s.logger, indexr, "cardinality index reader")
+		}
+	}()
+
+	stats := make([]cardinality.Stats, 0, len(indexrs))
+	for _, indexr := range indexrs {
+		b := indexr.block
+		st, err := cardinality.Analyze(ctx, tenant, cardinality.NewIndexHeaderIndex(b.indexHeaderReader, b.meta.Stats.NumSeries), limit)
+		if err != nil {
+			return cardinality.Stats{}, errors.Wrapf(err, "analyze cardinality of block %s", b.meta.ULID)
+		}
+		stats = append(stats, st)
+	}
+	return cardinality.Merge(tenant, limit, stats...), nil
+}
+
 func (s *BucketStore) LabelSet() []labelpb.ZLabelSet {
 	s.mtx.RLock()
 	labelSets := s.advLabelSets
@@ -1101,6 +1237,7 @@ type blockSeriesClient struct {
 
 	skipChunks             bool
 	shardMatcher           *storepb.ShardMatcher
+	seriesHasher           *seriesHasher
 	blockMatchers          []*labels.Matcher
 	calculateChunkHash     bool
 	seriesFetchDurationSum *prometheus.HistogramVec
@@ -1137,6 +1274,7 @@ func newBlockSeriesClient(
 	chunkFetchDuration *prometheus.HistogramVec,
 	chunkFetchDurationSum *prometheus.HistogramVec,
 	extLsetToRemove map[string]struct{},
+	withBlockIDLabel bool,
 	lazyExpandedPostingEnabled bool,
 	seriesMatchRatio float64,
 	postingGroupMaxKeySeriesRatio float64,
@@ -1155,6 +1293,9 @@ func newBlockSeriesClient(
 	if extLsetToRemove != nil {
 		extLset = rmLabels(extLset.Copy(), extLsetToRemove)
 	}
+	if withBlockIDLabel {
+		extLset = labels.NewBuilder(extLset).Set(BlockIDDebugLabelName, b.meta.ULID.String()).Labels()
+	}
 
 	return &blockSeriesClient{
 		ctx:             ctx,
@@ -1183,8 +1324,9 @@ func newBlockSeriesClient(
 		lazyExpandedPostingSizeBytes:                  lazyExpandedPostingSizeBytes,
 		lazyExpandedPostingSeriesOverfetchedSizeBytes: lazyExpandedPostingSeriesOverfetchedSizeBytes,
 
-		loadAggregates:     req.Aggregates,
+		loadAggregates:     seriesAggregates(req),
 		shardMatcher:       shardMatcher,
+		seriesHasher:       newSeriesHasher(b, shardMatcher, extLset, extLsetToRemove),
 		blockMatchers:      blockMatchers,
 		calculateChunkHash: calculateChunkHash,
 		hasMorePostings:    true,
@@ -1201,6 +1343,7 @@ func (b *blockSeriesClient) Close() {
 	}
 
 	runutil.CloseWithLogOnErr(b.logger, b.indexr, "series block")
+	b.seriesHasher.flush()
 }
 
 func (b *blockSeriesClient) MergeStats(stats *queryStats) *queryStats {
@@ -1377,7 +1520,7 @@ OUTER:
 			completeLabelset = rmLabels(completeLabelset, b.extLsetToRemove)
 		}
 
-		if !b.shardMatcher.MatchesLabels(completeLabelset) {
+		if !b.seriesHasher.matches(postingsBatch[i], completeLabelset) {
 			continue
 		}
 
@@ -1432,6 +1575,16 @@ OUTER:
 	return nil
 }
 
+// seriesAggregates returns the aggregates of downsampled chunks to load for the request. Functions of the query hints
+// evaluated from a single aggregate, like max_over_time, are pushed down: only their aggregate is sent, instead of
+// the requested ones.
+func seriesAggregates(req *storepb.SeriesRequest) []storepb.Aggr {
+	if aggr, ok := req.QueryHints.DownsampledAggr(); ok {
+		return []storepb.Aggr{aggr}
+	}
+	return req.Aggregates
+}
+
 func populateChunk(out *storepb.AggrChunk, in chunkenc.Chunk, aggrs []storepb.Aggr, save func([]byte) ([]byte, error), calculateChecksum bool) error {
 	hasher := hashPool.Get().(hash.Hash64)
 	defer hashPool.Put(hasher)
@@ -1455,6 +1608,11 @@ func populateChunk(out *storepb.AggrChunk, in chunkenc.Chunk, aggrs []storepb.Ag
 
 	ac := downsample.AggrChunk(in.Bytes())
 
+	if ac.IsHistogram() && (slices.Contains(aggrs, storepb.Aggr_MIN) || slices.Contains(aggrs, storepb.Aggr_MAX)) {
+		// Native histograms have no min and max aggregates, their average is returned instead.
+		aggrs = []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM}
+	}
+
 	for _, at := range aggrs {
 		switch at {
 		case storepb.Aggr_COUNT:
@@ -1586,6 +1744,7 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, seriesSrv storepb.Store
 	}
 
 	tenant, _ := tenancy.GetTenantFromGRPCMetadata(srv.Context())
+	defer s.activeTracker.Insert(srv.Context(), fmt.Sprintf("Series tenant=%s mint=%d maxt=%d matchers=%s", tenant, req.MinTime, req.MaxTime, storepb.MatchersToString(req.Matchers...)))()
 
 	matchers, err := storecache.MatchersToPromMatchersCached(s.matcherCache, req.Matchers...)
 	if err != nil {
@@ -1596,7 +1755,7 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, seriesSrv storepb.Store
 
 	var (
 		bytesLimiter     = s.bytesLimiterFactory(s.metrics.queriesDropped.WithLabelValues("bytes", tenant))
-		ctx              = srv.Context()
+		ctx              = objstoreutil.WithCaller(srv.Context(), objstoreutil.CallerStoreGateway)
 		stats            = &queryStats{}
 		respSets         []respSet
 		mtx              sync.Mutex
@@ -1624,6 +1783,8 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, seriesSrv storepb.Store
 			return status.Error(codes.InvalidArgument, errors.Wrap(err, "translate request hints labels matchers").Error())
 		}
 	}
+	matchers, blockIDMatchers := splitBlockIDMatchers(matchers)
+	reqBlockMatchers = append(reqBlockMatchers, blockIDMatchers...)
 
 	var extLsetToRemove map[string]struct{}
 	if len(req.WithoutReplicaLabels) > 0 {
@@ -1653,6 +1814,11 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, seriesSrv storepb.Store
 			blk := b
 			gctx := gctx
 
+			if !blk.mayMatch(blockMatchers) {
+				s.metrics.labelsBloomPruned.WithLabelValues(tenant).Inc()
+				continue
+			}
+
 			if s.enableSeriesResponseHints {
 				// Keep track of queried blocks.
 				resHints.AddQueriedBlock(blk.meta.ULID)
@@ -1676,6 +1842,7 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, seriesSrv storepb.Store
 				s.metrics.chunkFetchDuration,
 				s.metrics.chunkFetchDurationSum,
 				extLsetToRemove,
+				len(blockIDMatchers) > 0,
 				s.enabledLazyExpandedPostings,
 				s.seriesMatchRatio,
 				s.postingGroupMaxKeySeriesRatio,
@@ -1725,6 +1892,7 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, seriesSrv storepb.Store
 						false,
 						s.metrics.emptyPostingCount.WithLabelValues(tenant),
 						nil,
+						nil,
 					)
 				} else {
 					resp = newLazyRespSet(
@@ -1738,6 +1906,8 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, seriesSrv storepb.Store
 						false,
 						s.metrics.emptyPostingCount.WithLabelValues(tenant),
 						max(s.lazyRetrievalMaxBufferedResponses, 1),
+						0,
+						nil,
 					)
 				}
 
@@ -1775,6 +1945,7 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, seriesSrv storepb.Store
 		s.metrics.cachedPostingsOriginalSizeBytes.WithLabelValues(tenant).Add(float64(stats.CachedPostingsOriginalSizeSum))
 		s.metrics.cachedPostingsCompressedSizeBytes.WithLabelValues(tenant).Add(float64(stats.CachedPostingsCompressedSizeSum))
 		s.metrics.postingsSizeBytes.WithLabelValues(tenant).Observe(float64(int(stats.PostingsFetchedSizeSum) + int(stats.PostingsTouchedSizeSum)))
+		s.meter.AddQueried(tenant, int(stats.PostingsTouchedSizeSum+stats.SeriesTouchedSizeSum+stats.ChunksTouchedSizeSum), stats.mergedSeriesCount)
 
 		if s.debugLogging {
 			level.Debug(logger).Log("msg", "stats query processed",
@@ -1879,12 +2050,14 @@ func chunksSize(chks []storepb.AggrChunk) (size int) {
 
 // LabelNames implements the storepb.StoreServer interface.
 func (s *BucketStore) LabelNames(ctx context.Context, req *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	ctx = objstoreutil.WithCaller(ctx, objstoreutil.CallerStoreGateway)
 	reqSeriesMatchers, err := storepb.MatchersToPromMatchers(req.Matchers...)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, errors.Wrap(err, "translate request labels matchers").Error())
 	}
 
 	tenant, _ := tenancy.GetTenantFromGRPCMetadata(ctx)
+	defer s.activeTracker.Insert(ctx, fmt.Sprintf("LabelNames tenant=%s start=%d end=%d matchers=%s", tenant, req.Start, req.End, storepb.MatchersToString(req.Matchers...)))()
 
 	resHints := &hintspb.LabelNamesResponseHints{}
 
@@ -1901,6 +2074,8 @@ func (s *BucketStore) LabelNames(ctx context.Context, req *storepb.LabelNamesReq
 			return nil, status.Error(codes.InvalidArgument, errors.Wrap(err, "translate request hints labels matchers").Error())
 		}
 	}
+	reqSeriesMatchers, blockIDMatchers := splitBlockIDMatchers(reqSeriesMatchers)
+	reqBlockMatchers = append(reqBlockMatchers, blockIDMatchers...)
 	extLsetToRemove := make(map[string]struct{})
 	if len(req.WithoutReplicaLabels) > 0 {
 		for _, l := range req.WithoutReplicaLabels {
@@ -1933,6 +2108,10 @@ func (s *BucketStore) LabelNames(ctx context.Context, req *storepb.LabelNamesReq
 		if !ok {
 			continue
 		}
+		if !b.mayMatch(reqSeriesMatchersNoExtLabels) {
+			s.metrics.labelsBloomPruned.WithLabelValues(tenant).Inc()
+			continue
+		}
 
 		sortedReqSeriesMatchersNoExtLabels := newSortedMatchers(reqSeriesMatchersNoExtLabels)
 
@@ -1951,6 +2130,14 @@ func (s *BucketStore) LabelNames(ctx context.Context, req *storepb.LabelNamesReq
 			defer span.Finish()
 			defer runutil.CloseWithLogOnErr(blockLogger, indexr, "label names")
 
+			// b.extLset is already sorted by label name, no need to sort it again.
+			extRes := make([]string, 0, b.extLset.Len())
+			b.extLset.Range(func(l labels.Label) {
+				if _, ok := extLsetToRemove[l.Name]; !ok {
+					extRes = append(extRes, l.Name)
+				}
+			})
+
 			var result []string
 			if len(reqSeriesMatchersNoExtLabels) == 0 {
 				// Do it via index reader to have pending reader registered correctly.
@@ -1962,14 +2149,6 @@ func (s *BucketStore) LabelNames(ctx context.Context, req *storepb.LabelNamesReq
 
 				// Add  a set for the external labels as well.
 				// We're not adding them directly to refs because there could be duplicates.
-				// b.extLset is already sorted by label name, no need to sort it again.
-				extRes := make([]string, 0, b.extLset.Len())
-				b.extLset.Range(func(l labels.Label) {
-					if _, ok := extLsetToRemove[l.Name]; !ok {
-						extRes = append(extRes, l.Name)
-					}
-				})
-
 				result = strutil.MergeSlices(int(req.Limit), res, extRes)
 			} else {
 				seriesReq := &storepb.SeriesRequest{
@@ -1994,6 +2173,7 @@ func (s *BucketStore) LabelNames(ctx context.Context, req *storepb.LabelNamesReq
 					nil,
 					nil,
 					extLsetToRemove,
+					false,
 					s.enabledLazyExpandedPostings,
 					s.seriesMatchRatio,
 					s.postingGroupMaxKeySeriesRatio,
@@ -2012,11 +2192,21 @@ func (s *BucketStore) LabelNames(ctx context.Context, req *storepb.LabelNamesReq
 					return err
 				}
 
+				// All the label names of the block, to stop fetching series once they can't change the result.
+				blockNames, err := indexr.block.indexHeaderReader.LabelNames()
+				if err != nil {
+					return errors.Wrapf(err, "label names for block %s", b.meta.ULID)
+				}
+				blockNames = strutil.MergeSlices(0, blockNames, extRes)
+
 				// Extract label names from all series. Many label names will be the same, so we need to deduplicate them.
 				// Note that label names will already include external labels (passed to blockSeries), so we don't need
 				// to add them again.
 				labelNames := map[string]struct{}{}
-				for {
+				for i := 1; ; i++ {
+					if i%labelNamesFoundCheckInterval == 0 && allLabelNamesFound(blockNames, labelNames, int(req.Limit)) {
+						break
+					}
 					ls, err := blockClient.Recv()
 					if err == io.EOF {
 						break
@@ -2041,6 +2231,9 @@ func (s *BucketStore) LabelNames(ctx context.Context, req *storepb.LabelNamesReq
 					result = append(result, n)
 				}
 				sort.Strings(result)
+				if req.Limit > 0 && len(result) > int(req.Limit) {
+					result = result[:req.Limit]
+				}
 			}
 
 			if len(result) > 0 {
@@ -2096,6 +2289,7 @@ func (b *bucketBlock) FilterExtLabelsMatchers(matchers []*labels.Matcher) ([]*la
 
 // LabelValues implements the storepb.StoreServer interface.
 func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	ctx = objstoreutil.WithCaller(ctx, objstoreutil.CallerStoreGateway)
 	reqSeriesMatchers, err := storepb.MatchersToPromMatchers(req.Matchers...)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, errors.Wrap(err, "translate request labels matchers").Error())
@@ -2107,6 +2301,7 @@ func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesR
 	}
 
 	tenant, _ := tenancy.GetTenantFromGRPCMetadata(ctx)
+	defer s.activeTracker.Insert(ctx, fmt.Sprintf("LabelValues label=%s tenant=%s start=%d end=%d matchers=%s", req.Label, tenant, req.Start, req.End, storepb.MatchersToString(req.Matchers...)))()
 
 	resHints := &hintspb.LabelValuesResponseHints{}
 
@@ -2133,6 +2328,8 @@ func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesR
 			return nil, status.Error(codes.InvalidArgument, errors.Wrap(err, "translate request hints labels matchers").Error())
 		}
 	}
+	reqSeriesMatchers, blockIDMatchers := splitBlockIDMatchers(reqSeriesMatchers)
+	reqBlockMatchers = append(reqBlockMatchers, blockIDMatchers...)
 
 	s.mtx.RLock()
 
@@ -2157,6 +2354,10 @@ func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesR
 		if !ok {
 			continue
 		}
+		if !b.mayMatch(reqSeriesMatchersNoExtLabels) {
+			s.metrics.labelsBloomPruned.WithLabelValues(tenant).Inc()
+			continue
+		}
 
 		// If we have series matchers and the Label is not an external one, add <labelName> != "" matcher
 		// to only select series that have given label name.
@@ -2223,6 +2424,7 @@ func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesR
 					nil,
 					nil,
 					nil,
+					false,
 					s.enabledLazyExpandedPostings,
 					s.seriesMatchRatio,
 					s.postingGroupMaxKeySeriesRatio,
@@ -2246,6 +2448,19 @@ func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesR
 					return err
 				}
 
+				res, ok, err := blockClient.labelValuesFromPostings(req.Label, reqSeriesMatchersNoExtLabels, req.Start, req.End, int(req.Limit))
+				if err != nil {
+					return errors.Wrapf(err, "label values from postings for block %s", b.meta.ULID)
+				}
+				if ok {
+					if len(res) > 0 {
+						mtx.Lock()
+						sets = append(sets, res)
+						mtx.Unlock()
+					}
+					return nil
+				}
+
 				// Extract given label's value from all series and deduplicate them.
 				// We don't need to deal with external labels, since they are already added by blockSeries.
 				values := map[string]struct{}{}
@@ -2455,6 +2670,27 @@ func (s *bucketBlockSet) labelMatchers(matchers ...*labels.Matcher) ([]*labels.M
 	return res, true
 }
 
+// splitBlockIDMatchers returns the matchers without the ones of the block ID debug label, and these translated into
+// block matchers of the block ID label. The matchers of the label alone select all the series of the blocks.
+func splitBlockIDMatchers(matchers []*labels.Matcher) ([]*labels.Matcher, []*labels.Matcher) {
+	if !slices.ContainsFunc(matchers, func(m *labels.Matcher) bool { return m.Name == BlockIDDebugLabelName }) {
+		return matchers, nil
+	}
+	var seriesMatchers, blockMatchers []*labels.Matcher
+	for _, m := range matchers {
+		if m.Name != BlockIDDebugLabelName {
+			seriesMatchers = append(seriesMatchers, m)
+			continue
+		}
+		// The value was already parsed by the matcher of the debug label.
+		blockMatchers = append(blockMatchers, labels.MustNewMatcher(m.Type, block.BlockIDLabel, m.Value))
+	}
+	if len(seriesMatchers) == 0 {
+		seriesMatchers = append(seriesMatchers, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".*"))
+	}
+	return seriesMatchers, blockMatchers
+}
+
 // bucketBlock represents a block that is located in a bucket. It holds intermediate
 // state for the block on local disk.
 type bucketBlock struct {
@@ -2480,6 +2716,17 @@ type bucketBlock struct {
 
 	estimatedMaxChunkSize  int
 	estimatedMaxSeriesSize int
+
+	// labelsBloom is the bloom filter of the label pairs of the block, if loaded.
+	labelsBloom *block.LabelsBloom
+
+	// seriesHashes are the shard hashes of the series of the block precomputed by the compactor, if loaded.
+	seriesHashes    *block.SeriesHashes
+	seriesHashCache *SeriesHashCache
+
+	// metricMetadata is the metric metadata of the block, once read.
+	metricMetadataMtx sync.Mutex
+	metricMetadata    map[string][]metadatapb.Meta
 }
 
 func newBucketBlock(
@@ -2542,6 +2789,24 @@ func newBucketBlock(
 	return b, nil
 }
 
+// readLabelsBloom returns the labels bloom filter of the block, or nil if the block has none.
+func readLabelsBloom(ctx context.Context, logger log.Logger, bkt objstore.BucketReader, id ulid.ULID) (*block.LabelsBloom, error) {
+	r, err := bkt.Get(ctx, path.Join(id.String(), block.LabelsBloomFilename))
+	if bkt.IsObjNotFoundErr(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get labels bloom filter")
+	}
+	defer runutil.CloseWithLogOnErr(logger, r, "labels bloom filter reader")
+
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read labels bloom filter")
+	}
+	return block.DecodeLabelsBloom(buf)
+}
+
 func (b *bucketBlock) indexFilename() string {
 	return path.Join(b.meta.ULID.String(), block.IndexFilename)
 }
@@ -2609,6 +2874,31 @@ func (b *bucketBlock) chunkReader(logger log.Logger) *bucketChunkReader {
 }
 
 // matchRelabelLabels verifies whether the block matches the given matchers.
+// mayMatch returns false if the labels bloom filter of the block tells that no series of the block has the label pair
+// of an equality matcher or any label pair of a set matcher. Matchers of the empty value also match series without
+// the label, so they are not checked.
+func (b *bucketBlock) mayMatch(matchers []*labels.Matcher) bool {
+	if b.labelsBloom == nil {
+		return true
+	}
+	for _, m := range matchers {
+		var values []string
+		switch m.Type {
+		case labels.MatchEqual:
+			values = []string{m.Value}
+		case labels.MatchRegexp:
+			values = m.SetMatches()
+		}
+		if len(values) == 0 || slices.Contains(values, "") {
+			continue
+		}
+		if !slices.ContainsFunc(values, func(v string) bool { return b.labelsBloom.MayContain(m.Name, v) }) {
+			return false
+		}
+	}
+	return true
+}
+
 func (b *bucketBlock) matchRelabelLabels(matchers []*labels.Matcher) bool {
 	for _, m := range matchers {
 		if !m.Matches(b.relabelLabels.Get(m.Name)) {
@@ -2625,6 +2915,11 @@ func (b *bucketBlock) overlapsClosedInterval(mint, maxt int64) bool {
 	return b.meta.MinTime <= maxt && mint < b.meta.MaxTime
 }
 
+// withinClosedInterval returns true if the block is within [mint, maxt], so that all its series are within it.
+func (b *bucketBlock) withinClosedInterval(mint, maxt int64) bool {
+	return mint <= b.meta.MinTime && b.meta.MaxTime-1 <= maxt
+}
+
 // Close waits for all pending readers to finish and then closes all underlying resources.
 func (b *bucketBlock) Close() error {
 	b.pendingReaders.Wait()
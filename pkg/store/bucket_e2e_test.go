@@ -784,6 +784,21 @@ func TestBucketStore_LabelNames_e2e(t *testing.T) {
 				},
 				expected: []string{"a", "b", "ext1"},
 			},
+			"b=1 matcher, limit": {
+				req: &storepb.LabelNamesRequest{
+					Start: timestamp.FromTime(minTime),
+					End:   timestamp.FromTime(maxTime),
+					Limit: 2,
+					Matchers: []storepb.LabelMatcher{
+						{
+							Type:  storepb.LabelMatcher_EQ,
+							Name:  "b",
+							Value: "1",
+						},
+					},
+				},
+				expected: []string{"a", "b"},
+			},
 
 			"b='' matcher": {
 				req: &storepb.LabelNamesRequest{
@@ -950,6 +965,52 @@ func TestBucketStore_LabelValues_e2e(t *testing.T) {
 				},
 				expected: []string{"2"},
 			},
+			"label b, a=2": {
+				req: &storepb.LabelValuesRequest{
+					Label: "b",
+					Start: timestamp.FromTime(minTime),
+					End:   timestamp.FromTime(maxTime),
+					Matchers: []storepb.LabelMatcher{
+						{
+							Type:  storepb.LabelMatcher_EQ,
+							Name:  "a",
+							Value: "2",
+						},
+					},
+				},
+				expected: []string{"1", "2"},
+			},
+			"label a, c=~1|2, limit": {
+				req: &storepb.LabelValuesRequest{
+					Label: "a",
+					Start: timestamp.FromTime(minTime),
+					End:   timestamp.FromTime(maxTime),
+					Limit: 1,
+					Matchers: []storepb.LabelMatcher{
+						{
+							Type:  storepb.LabelMatcher_RE,
+							Name:  "c",
+							Value: "1|2",
+						},
+					},
+				},
+				expected: []string{"1"},
+			},
+			"label a, c=1, partially outside the time range": {
+				req: &storepb.LabelValuesRequest{
+					Label: "a",
+					Start: s.minTime,
+					End:   s.minTime + 1,
+					Matchers: []storepb.LabelMatcher{
+						{
+							Type:  storepb.LabelMatcher_EQ,
+							Name:  "c",
+							Value: "1",
+						},
+					},
+				},
+				expected: []string{"1", "2"},
+			},
 			"label ext1": {
 				req: &storepb.LabelValuesRequest{
 					Label: "ext1",
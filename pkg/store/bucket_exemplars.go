@@ -0,0 +1,102 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"path"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/exemplars/exemplarspb"
+	"github.com/thanos-io/thanos/pkg/extpromql"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+)
+
+var _ exemplarspb.ExemplarsServer = &BucketStore{}
+
+// Exemplars returns the exemplars of the series matching the selectors of the query from the exemplars files of the
+// blocks overlapping with the time range of the request, with the external labels of their blocks.
+func (s *BucketStore) Exemplars(req *exemplarspb.ExemplarsRequest, srv exemplarspb.Exemplars_ExemplarsServer) error {
+	expr, err := extpromql.ParseExpr(req.Query)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	selectors := parser.ExtractSelectors(expr)
+	if len(selectors) == 0 {
+		return status.Error(codes.InvalidArgument, "no matchers specified")
+	}
+	start, end := s.limitMinTime(req.Start), s.limitMaxTime(req.End)
+
+	var blocks []*bucketBlock
+	s.mtx.RLock()
+	for _, b := range s.blocks {
+		if block.HasExemplars(b.meta) && b.overlapsClosedInterval(start, end) {
+			blocks = append(blocks, b)
+		}
+	}
+	s.mtx.RUnlock()
+
+	for _, b := range blocks {
+		data, err := b.readExemplars(srv.Context())
+		if err != nil {
+			return status.Error(codes.Internal, errors.Wrapf(err, "read exemplars of block %s", b.meta.ULID).Error())
+		}
+		for _, d := range data {
+			lset := labelpb.ExtendSortedLabels(d.SeriesLabels.PromLabels(), b.extLset)
+			if !matchesAnySelector(lset, selectors) {
+				continue
+			}
+			var exemplars []*exemplarspb.Exemplar
+			for _, e := range d.Exemplars {
+				if e.Ts >= start && e.Ts <= end {
+					exemplars = append(exemplars, e)
+				}
+			}
+			if len(exemplars) == 0 {
+				continue
+			}
+			if err := srv.Send(exemplarspb.NewExemplarsResponse(&exemplarspb.ExemplarData{
+				SeriesLabels: labelpb.ZLabelSet{Labels: labelpb.ZLabelsFromPromLabels(lset)},
+				Exemplars:    exemplars,
+			})); err != nil {
+				return status.Error(codes.Aborted, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// matchesAnySelector returns true if the labels match all the matchers of any of the selectors.
+func matchesAnySelector(lset labels.Labels, selectors [][]*labels.Matcher) bool {
+	for _, ms := range selectors {
+		matches := true
+		for _, m := range ms {
+			if !m.Matches(lset.Get(m.Name)) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *bucketBlock) readExemplars(ctx context.Context) (_ []*exemplarspb.ExemplarData, err error) {
+	r, err := b.bkt.Get(ctx, path.Join(b.meta.ULID.String(), block.ExemplarsFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer runutil.CloseWithErrCapture(&err, r, "close exemplars file")
+
+	return block.ReadExemplarsFile(r)
+}
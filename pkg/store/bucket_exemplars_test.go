@@ -0,0 +1,107 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/exemplars/exemplarspb"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+type exemplarsServer struct {
+	exemplarspb.Exemplars_ExemplarsServer
+	ctx context.Context
+
+	data []*exemplarspb.ExemplarData
+}
+
+func (s *exemplarsServer) Send(r *exemplarspb.ExemplarsResponse) error {
+	s.data = append(s.data, r.GetData())
+	return nil
+}
+
+func (s *exemplarsServer) Context() context.Context { return s.ctx }
+
+func TestBucketStore_Exemplars(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := log.NewNopLogger()
+	dir := t.TempDir()
+	bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
+
+	a, b := labels.FromStrings("__name__", "a"), labels.FromStrings("__name__", "b")
+	exemplar := func(ts int64) *exemplarspb.Exemplar {
+		return &exemplarspb.Exemplar{Labels: labelpb.ZLabelSet{Labels: []labelpb.ZLabel{{Name: "trace_id", Value: "1"}}}, Value: 1, Ts: ts}
+	}
+	for _, tt := range []struct {
+		mint, maxt int64
+		extLabels  labels.Labels
+		exemplars  []*exemplarspb.ExemplarData
+	}{
+		{
+			mint: 0, maxt: 1000, extLabels: labels.FromStrings("replica", "1"),
+			exemplars: []*exemplarspb.ExemplarData{
+				{SeriesLabels: labelpb.ZLabelSet{Labels: labelpb.ZLabelsFromPromLabels(a)}, Exemplars: []*exemplarspb.Exemplar{exemplar(100), exemplar(900)}},
+				{SeriesLabels: labelpb.ZLabelSet{Labels: labelpb.ZLabelsFromPromLabels(b)}, Exemplars: []*exemplarspb.Exemplar{exemplar(200)}},
+			},
+		},
+		// Blocks without exemplars are skipped.
+		{mint: 1000, maxt: 2000, extLabels: labels.FromStrings("replica", "1")},
+	} {
+		id, err := e2eutil.CreateBlock(ctx, dir, []labels.Labels{a, b}, 10, tt.mint, tt.maxt, tt.extLabels, 0, metadata.NoneFunc, nil)
+		testutil.Ok(t, err)
+		testutil.Ok(t, block.WriteExemplarsFile(filepath.Join(dir, id.String()), tt.exemplars))
+		testutil.Ok(t, block.Upload(ctx, logger, bkt, filepath.Join(dir, id.String()), metadata.NoneFunc))
+	}
+
+	metaFetcher, err := block.NewMetaFetcher(logger, 20, bkt, block.NewConcurrentLister(logger, bkt), dir, nil, nil)
+	testutil.Ok(t, err)
+	bucketStore, err := NewBucketStore(
+		bkt,
+		metaFetcher,
+		dir,
+		NewChunksLimiterFactory(0),
+		NewSeriesLimiterFactory(0),
+		NewBytesLimiterFactory(0),
+		NewGapBasedPartitioner(PartitionerMaxGapSize),
+		20,
+		true,
+		DefaultPostingOffsetInMemorySampling,
+		false,
+		false,
+		0,
+		WithFilterConfig(allowAllFilterConf),
+	)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, bucketStore.Close()) }()
+	testutil.Ok(t, bucketStore.SyncBlocks(ctx))
+
+	srv := &exemplarsServer{ctx: ctx}
+	testutil.Ok(t, bucketStore.Exemplars(&exemplarspb.ExemplarsRequest{Query: `{__name__="a", replica="1"}`, Start: 500, End: 1500}, srv))
+	testutil.Equals(t, []*exemplarspb.ExemplarData{{
+		SeriesLabels: labelpb.ZLabelSet{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "a", "replica", "1"))},
+		Exemplars:    []*exemplarspb.Exemplar{exemplar(900)},
+	}}, srv.data)
+
+	// The external labels of the blocks are matched too.
+	srv = &exemplarsServer{ctx: ctx}
+	testutil.Ok(t, bucketStore.Exemplars(&exemplarspb.ExemplarsRequest{Query: `{__name__=~"a|b", replica="2"}`, Start: 0, End: 2000}, srv))
+	testutil.Equals(t, 0, len(srv.data))
+
+	testutil.NotOk(t, bucketStore.Exemplars(&exemplarspb.ExemplarsRequest{Query: `1`, Start: 0, End: 2000}, &exemplarsServer{ctx: ctx}))
+}
@@ -0,0 +1,93 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"path"
+	"sort"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/metadata/metadatapb"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+var _ metadatapb.MetadataServer = &BucketStore{}
+
+// MetricMetadata returns the metric metadata of the metric metadata files of the blocks, merged. The metadata of a
+// block is kept in memory once read.
+func (s *BucketStore) MetricMetadata(req *metadatapb.MetricMetadataRequest, srv metadatapb.Metadata_MetricMetadataServer) error {
+	if req.Limit == 0 {
+		return nil
+	}
+
+	var blocks []*bucketBlock
+	s.mtx.RLock()
+	for _, b := range s.blocks {
+		if block.HasMetricMetadata(b.meta) {
+			blocks = append(blocks, b)
+		}
+	}
+	s.mtx.RUnlock()
+
+	mds := make([]map[string][]metadatapb.Meta, 0, len(blocks))
+	for _, b := range blocks {
+		md, err := b.readMetricMetadata(srv.Context())
+		if err != nil {
+			return status.Error(codes.Internal, errors.Wrapf(err, "read metric metadata of block %s", b.meta.ULID).Error())
+		}
+		if req.Metric != "" {
+			metas, ok := md[req.Metric]
+			if !ok {
+				continue
+			}
+			md = map[string][]metadatapb.Meta{req.Metric: metas}
+		}
+		mds = append(mds, md)
+	}
+	md := block.MergeMetricMetadata(mds...)
+	if req.Limit > 0 && len(md) > int(req.Limit) {
+		metrics := make([]string, 0, len(md))
+		for metric := range md {
+			metrics = append(metrics, metric)
+		}
+		sort.Strings(metrics)
+		for _, metric := range metrics[req.Limit:] {
+			delete(md, metric)
+		}
+	}
+	if len(md) == 0 {
+		return nil
+	}
+
+	if err := srv.Send(metadatapb.NewMetricMetadataResponse(metadatapb.FromMetadataMap(md))); err != nil {
+		return status.Error(codes.Aborted, err.Error())
+	}
+	return nil
+}
+
+func (b *bucketBlock) readMetricMetadata(ctx context.Context) (_ map[string][]metadatapb.Meta, err error) {
+	b.metricMetadataMtx.Lock()
+	defer b.metricMetadataMtx.Unlock()
+
+	if b.metricMetadata != nil {
+		return b.metricMetadata, nil
+	}
+	r, err := b.bkt.Get(ctx, path.Join(b.meta.ULID.String(), block.MetricMetadataFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer runutil.CloseWithErrCapture(&err, r, "close metric metadata file")
+
+	md, err := block.ReadMetricMetadataFile(r)
+	if err != nil {
+		return nil, err
+	}
+	b.metricMetadata = md
+	return md, nil
+}
@@ -0,0 +1,122 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/metadata/metadatapb"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+type metricMetadataServer struct {
+	metadatapb.Metadata_MetricMetadataServer
+	ctx context.Context
+
+	md []*metadatapb.MetricMetadata
+}
+
+func (s *metricMetadataServer) Send(r *metadatapb.MetricMetadataResponse) error {
+	s.md = append(s.md, r.GetMetadata())
+	return nil
+}
+
+func (s *metricMetadataServer) Context() context.Context { return s.ctx }
+
+func TestBucketStore_MetricMetadata(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := log.NewNopLogger()
+	dir := t.TempDir()
+	bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
+
+	for i, md := range []map[string][]metadatapb.Meta{
+		{"a": {{Type: "counter", Help: "A."}}, "b": {{Type: "gauge", Help: "B."}}},
+		{"a": {{Type: "counter", Help: "A."}}, "c": {{Type: "gauge", Help: "C."}}},
+		// Blocks without metadata are skipped.
+		nil,
+	} {
+		id, err := e2eutil.CreateBlock(ctx, dir, []labels.Labels{labels.FromStrings("__name__", "a")}, 10, int64(i)*1000, int64(i+1)*1000, labels.FromStrings("replica", "1"), 0, metadata.NoneFunc, nil)
+		testutil.Ok(t, err)
+		testutil.Ok(t, block.WriteMetricMetadataFile(filepath.Join(dir, id.String()), md))
+		testutil.Ok(t, block.Upload(ctx, logger, bkt, filepath.Join(dir, id.String()), metadata.NoneFunc))
+	}
+
+	metaFetcher, err := block.NewMetaFetcher(logger, 20, bkt, block.NewConcurrentLister(logger, bkt), dir, nil, nil)
+	testutil.Ok(t, err)
+	bucketStore, err := NewBucketStore(
+		bkt,
+		metaFetcher,
+		dir,
+		NewChunksLimiterFactory(0),
+		NewSeriesLimiterFactory(0),
+		NewBytesLimiterFactory(0),
+		NewGapBasedPartitioner(PartitionerMaxGapSize),
+		20,
+		true,
+		DefaultPostingOffsetInMemorySampling,
+		false,
+		false,
+		0,
+		WithFilterConfig(allowAllFilterConf),
+	)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, bucketStore.Close()) }()
+	testutil.Ok(t, bucketStore.SyncBlocks(ctx))
+
+	for _, tc := range []struct {
+		name     string
+		req      *metadatapb.MetricMetadataRequest
+		expected map[string][]metadatapb.Meta
+	}{
+		{
+			name: "all metrics",
+			req:  &metadatapb.MetricMetadataRequest{Limit: -1},
+			expected: map[string][]metadatapb.Meta{
+				"a": {{Type: "counter", Help: "A."}},
+				"b": {{Type: "gauge", Help: "B."}},
+				"c": {{Type: "gauge", Help: "C."}},
+			},
+		},
+		{
+			name:     "single metric",
+			req:      &metadatapb.MetricMetadataRequest{Metric: "c", Limit: -1},
+			expected: map[string][]metadatapb.Meta{"c": {{Type: "gauge", Help: "C."}}},
+		},
+		{
+			name: "limit",
+			req:  &metadatapb.MetricMetadataRequest{Limit: 2},
+			expected: map[string][]metadatapb.Meta{
+				"a": {{Type: "counter", Help: "A."}},
+				"b": {{Type: "gauge", Help: "B."}},
+			},
+		},
+		{
+			name: "unknown metric",
+			req:  &metadatapb.MetricMetadataRequest{Metric: "d", Limit: -1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := &metricMetadataServer{ctx: ctx}
+			testutil.Ok(t, bucketStore.MetricMetadata(tc.req, srv))
+			if tc.expected == nil {
+				testutil.Equals(t, 0, len(srv.md))
+				return
+			}
+			testutil.Equals(t, []*metadatapb.MetricMetadata{metadatapb.FromMetadataMap(tc.expected)}, srv.md)
+		})
+	}
+}
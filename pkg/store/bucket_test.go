@@ -44,6 +44,7 @@ import (
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"github.com/prometheus/prometheus/tsdb/encoding"
 	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
 	"go.uber.org/atomic"
 
 	"github.com/thanos-io/objstore"
@@ -77,6 +78,44 @@ func TestRawChunkReset(t *testing.T) {
 	testutil.Equals(t, []byte(r), []byte{3, 4})
 }
 
+func TestPopulateChunk_HistogramMinMax(t *testing.T) {
+	t.Parallel()
+
+	var chks [5]chunkenc.Chunk
+	chks[downsample.AggrCount] = chunkenc.NewXORChunk()
+	chks[downsample.AggrSum] = chunkenc.NewFloatHistogramChunk()
+	chks[downsample.AggrCounter] = chunkenc.NewFloatHistogramChunk()
+	for i, c := range chks {
+		if c == nil {
+			continue
+		}
+		app, err := c.Appender()
+		testutil.Ok(t, err)
+		if i == int(downsample.AggrCount) {
+			app.Append(1000, 2)
+			continue
+		}
+		_, _, _, err = app.AppendFloatHistogram(nil, 1000, tsdbutil.GenerateTestFloatHistogram(1), true)
+		testutil.Ok(t, err)
+	}
+	in := downsample.EncodeAggrChunk(chks)
+	save := func(b []byte) ([]byte, error) { return b, nil }
+
+	// Native histograms have no min and max aggregates, their sum and count are returned to average them instead.
+	for _, aggr := range []storepb.Aggr{storepb.Aggr_MIN, storepb.Aggr_MAX} {
+		var out storepb.AggrChunk
+		testutil.Ok(t, populateChunk(&out, in, []storepb.Aggr{aggr}, save, false))
+		testutil.Assert(t, out.Min == nil && out.Max == nil)
+		testutil.Equals(t, storepb.Chunk_XOR, out.Count.Type)
+		testutil.Equals(t, storepb.Chunk_FLOAT_HISTOGRAM, out.Sum.Type)
+	}
+
+	var out storepb.AggrChunk
+	testutil.Ok(t, populateChunk(&out, in, []storepb.Aggr{storepb.Aggr_COUNTER}, save, false))
+	testutil.Equals(t, storepb.Chunk_FLOAT_HISTOGRAM, out.Counter.Type)
+	testutil.Assert(t, out.Count == nil && out.Sum == nil)
+}
+
 func TestBucketBlock_Property(t *testing.T) {
 	t.Parallel()
 
@@ -363,6 +402,48 @@ func TestBucketBlock_matchLabels(t *testing.T) {
 	}, meta.Thanos.Labels)
 }
 
+func TestBucketBlock_mayMatch(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	blockID := ulid.MustNew(1, nil)
+
+	// Blocks without a labels bloom filter may match anything.
+	bloom, err := readLabelsBloom(ctx, log.NewNopLogger(), bkt, blockID)
+	testutil.Ok(t, err)
+	testutil.Assert(t, bloom == nil)
+	testutil.Assert(t, (&bucketBlock{labelsBloom: bloom}).mayMatch([]*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "a", "1")}))
+
+	bloom = block.NewLabelsBloom(3, 0.001)
+	bloom.Add("__name__", "up")
+	bloom.Add("a", "1")
+	bloom.Add("a", "2")
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(blockID.String(), block.LabelsBloomFilename), bytes.NewReader(bloom.Encode())))
+	bloom, err = readLabelsBloom(ctx, log.NewNopLogger(), bkt, blockID)
+	testutil.Ok(t, err)
+	b := &bucketBlock{labelsBloom: bloom}
+
+	for _, c := range []struct {
+		in    []*labels.Matcher
+		match bool
+	}{
+		{in: nil, match: true},
+		{in: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up"), labels.MustNewMatcher(labels.MatchEqual, "a", "2")}, match: true},
+		{in: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up"), labels.MustNewMatcher(labels.MatchEqual, "a", "3")}, match: false},
+		{in: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "down")}, match: false},
+		// Matchers of the empty value match series without the label.
+		{in: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "b", "")}, match: true},
+		{in: []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "a", "3|2")}, match: true},
+		{in: []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "a", "3|4")}, match: false},
+		{in: []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "a", "3|")}, match: true},
+		{in: []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "a", "3.*")}, match: true},
+		{in: []*labels.Matcher{labels.MustNewMatcher(labels.MatchNotEqual, "a", "1")}, match: true},
+	} {
+		testutil.Equals(t, c.match, b.mayMatch(c.in), "%v", c.in)
+	}
+}
+
 func TestBucketBlockSet_addGet(t *testing.T) {
 	t.Parallel()
 
@@ -1967,6 +2048,64 @@ func TestSeries_RequestAndResponseHints(t *testing.T) {
 	storetestutil.TestServerSeries(tb, store, testCases...)
 }
 
+func TestSeries_BlockIDDebugLabel(t *testing.T) {
+	t.Parallel()
+
+	tb, store, seriesSet1, _, block1, _, close := setupStoreForHintsTest(t)
+	defer close()
+
+	// The series of the selected blocks are annotated with the ULID of their block.
+	var annotatedSeriesSet1 []*storepb.Series
+	for _, s := range seriesSet1 {
+		lset := labels.NewBuilder(labelpb.ZLabelsToPromLabels(s.Labels)).Set(BlockIDDebugLabelName, block1.String()).Labels()
+		annotatedSeriesSet1 = append(annotatedSeriesSet1, &storepb.Series{Labels: labelpb.ZLabelsFromPromLabels(lset), Chunks: s.Chunks})
+	}
+
+	storetestutil.TestServerSeries(tb, store,
+		&storetestutil.SeriesCase{
+			Name: "querying a block by ID",
+			Req: &storepb.SeriesRequest{
+				MinTime: 0,
+				MaxTime: 3,
+				Matchers: []storepb.LabelMatcher{
+					{Type: storepb.LabelMatcher_EQ, Name: "foo", Value: "bar"},
+					{Type: storepb.LabelMatcher_EQ, Name: BlockIDDebugLabelName, Value: block1.String()},
+				},
+			},
+			ExpectedSeries: annotatedSeriesSet1,
+			ExpectedHints: []hintspb.SeriesResponseHints{
+				{QueriedBlocks: []hintspb.Block{{Id: block1.String()}}},
+			},
+		},
+		&storetestutil.SeriesCase{
+			Name: "querying all the series of a block by ID",
+			Req: &storepb.SeriesRequest{
+				MinTime: 0,
+				MaxTime: 3,
+				Matchers: []storepb.LabelMatcher{
+					{Type: storepb.LabelMatcher_RE, Name: BlockIDDebugLabelName, Value: block1.String() + "|unknown"},
+				},
+			},
+			ExpectedSeries: annotatedSeriesSet1,
+			ExpectedHints: []hintspb.SeriesResponseHints{
+				{QueriedBlocks: []hintspb.Block{{Id: block1.String()}}},
+			},
+		},
+		&storetestutil.SeriesCase{
+			Name: "querying an unknown block ID",
+			Req: &storepb.SeriesRequest{
+				MinTime: 0,
+				MaxTime: 3,
+				Matchers: []storepb.LabelMatcher{
+					{Type: storepb.LabelMatcher_EQ, Name: "foo", Value: "bar"},
+					{Type: storepb.LabelMatcher_EQ, Name: BlockIDDebugLabelName, Value: "unknown"},
+				},
+			},
+			ExpectedHints: []hintspb.SeriesResponseHints{{}},
+		},
+	)
+}
+
 func TestSeries_ErrorUnmarshallingRequestHints(t *testing.T) {
 	t.Parallel()
 
@@ -2934,6 +3073,7 @@ func benchmarkBlockSeriesWithConcurrency(b *testing.B, concurrency int, blockMet
 					dummyHistogram,
 					nil,
 					false,
+					false,
 					0.5,
 					0,
 					dummyCounter,
@@ -4247,3 +4387,16 @@ func (c *mockBlockLifecycleCallback) PreAdd(meta metadata.Meta) error {
 	}
 	return nil
 }
+
+func TestSeriesAggregates(t *testing.T) {
+	t.Parallel()
+
+	req := &storepb.SeriesRequest{Aggregates: []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM}}
+	testutil.Equals(t, req.Aggregates, seriesAggregates(req))
+
+	req.QueryHints = &storepb.QueryHints{Func: &storepb.Func{Name: "rate"}}
+	testutil.Equals(t, req.Aggregates, seriesAggregates(req))
+
+	req.QueryHints.Func.Name = "max_over_time"
+	testutil.Equals(t, []storepb.Aggr{storepb.Aggr_MAX}, seriesAggregates(req))
+}
@@ -0,0 +1,213 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+const (
+	// hedgingLatencyWindow is the number of recent first response latencies of each store the hedging delay is
+	// computed from.
+	hedgingLatencyWindow = 128
+	// hedgingMinLatencies is the number of first response latencies of a store needed to hedge its requests.
+	hedgingMinLatencies = 16
+)
+
+// SeriesHedger hedges the Series requests to slow stores: if a store does not send its first response within a
+// quantile of its recent first response latencies, the request is sent to it again, and whichever request responds
+// first is used while the other is canceled. The Series requests to an endpoint group are balanced across its
+// replicas, so the hedged request is served by another replica.
+type SeriesHedger struct {
+	quantile  float64
+	minDelay  time.Duration
+	hedgeable func(st Client) bool
+
+	mtx       sync.Mutex
+	latencies map[string]*latencyWindow
+
+	hedged    prometheus.Counter
+	hedgedWon prometheus.Counter
+}
+
+// NewSeriesHedger returns a hedger of the Series requests to the hedgeable stores that are slower than the quantile
+// of their first response latencies, and than the min delay.
+func NewSeriesHedger(reg prometheus.Registerer, quantile float64, minDelay time.Duration, hedgeable func(st Client) bool) *SeriesHedger {
+	return &SeriesHedger{
+		quantile:  quantile,
+		minDelay:  minDelay,
+		hedgeable: hedgeable,
+		latencies: map[string]*latencyWindow{},
+		hedged: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_proxy_store_hedged_series_requests_total",
+			Help: "Total number of Series requests sent again to a store slower than its usual first response latency.",
+		}),
+		hedgedWon: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_proxy_store_hedged_series_requests_won_total",
+			Help: "Total number of hedged Series requests that responded before the requests they hedged.",
+		}),
+	}
+}
+
+// series sends the Series request to the store, hedged if the store is hedgeable.
+func (h *SeriesHedger) series(ctx context.Context, st Client, req *storepb.SeriesRequest) (storepb.Store_SeriesClient, error) {
+	if !h.hedgeable(st) {
+		return st.Series(ctx, req)
+	}
+	reqCtx, cancel := context.WithCancel(ctx)
+	cl, err := st.Series(reqCtx, req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	addr, _ := st.Addr()
+	return &hedgedSeriesClient{
+		Store_SeriesClient: cl,
+		cancel:             cancel,
+		h:                  h,
+		ctx:                ctx,
+		st:                 st,
+		addr:               addr,
+		req:                req,
+		start:              time.Now(),
+	}, nil
+}
+
+// delay returns the delay after which the requests to the store with the address are hedged, and false if not enough
+// latencies of the store are known yet.
+func (h *SeriesHedger) delay(addr string) (time.Duration, bool) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	w, ok := h.latencies[addr]
+	if !ok || len(w.latencies) < hedgingMinLatencies {
+		return 0, false
+	}
+	return max(w.quantile(h.quantile), h.minDelay), true
+}
+
+func (h *SeriesHedger) observe(addr string, latency time.Duration) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	w, ok := h.latencies[addr]
+	if !ok {
+		w = &latencyWindow{}
+		h.latencies[addr] = w
+	}
+	w.add(latency)
+}
+
+// latencyWindow holds the most recent latencies.
+type latencyWindow struct {
+	latencies []time.Duration
+	next      int
+}
+
+func (w *latencyWindow) add(latency time.Duration) {
+	if len(w.latencies) < hedgingLatencyWindow {
+		w.latencies = append(w.latencies, latency)
+		return
+	}
+	w.latencies[w.next] = latency
+	w.next = (w.next + 1) % hedgingLatencyWindow
+}
+
+func (w *latencyWindow) quantile(q float64) time.Duration {
+	sorted := slices.Clone(w.latencies)
+	slices.Sort(sorted)
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+// hedgedSeriesClient races the Series request with a hedged one on the first response, and then reads the responses
+// of the request that won.
+type hedgedSeriesClient struct {
+	storepb.Store_SeriesClient
+	cancel context.CancelFunc
+
+	h     *SeriesHedger
+	ctx   context.Context
+	st    Client
+	addr  string
+	req   *storepb.SeriesRequest
+	start time.Time
+	raced bool
+}
+
+type hedgedRecv struct {
+	cl     storepb.Store_SeriesClient
+	cancel context.CancelFunc
+	hedge  bool
+	resp   *storepb.SeriesResponse
+	err    error
+}
+
+func (c *hedgedSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	if c.raced {
+		return c.Store_SeriesClient.Recv()
+	}
+	c.raced = true
+
+	recvs := make(chan hedgedRecv, 2)
+	recv := func(cl storepb.Store_SeriesClient, cancel context.CancelFunc, hedge bool) {
+		resp, err := cl.Recv()
+		recvs <- hedgedRecv{cl: cl, cancel: cancel, hedge: hedge, resp: resp, err: err}
+	}
+	go recv(c.Store_SeriesClient, c.cancel, false)
+	cancels := []context.CancelFunc{c.cancel}
+	pending := 1
+
+	var hedgeTimer <-chan time.Time
+	if delay, ok := c.h.delay(c.addr); ok {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		hedgeTimer = t.C
+	}
+	for {
+		select {
+		case <-hedgeTimer:
+			hedgeTimer = nil
+			hedgeCtx, cancel := context.WithCancel(c.ctx)
+			cl, err := c.st.Series(hedgeCtx, c.req)
+			if err != nil {
+				cancel()
+				continue
+			}
+			c.h.hedged.Inc()
+			cancels = append(cancels, cancel)
+			pending++
+			go recv(cl, cancel, true)
+		case r := <-recvs:
+			pending--
+			if r.err != nil && r.err != io.EOF && pending > 0 {
+				// Wait for the other request.
+				r.cancel()
+				continue
+			}
+			if r.err == nil {
+				c.h.observe(c.addr, time.Since(c.start))
+			}
+			if r.hedge {
+				c.h.hedgedWon.Inc()
+			}
+			// The first cancel is the one of the request, the second the one of the hedged request.
+			for i, cancel := range cancels {
+				if (i == 1) != r.hedge {
+					cancel()
+				}
+			}
+			c.Store_SeriesClient, c.cancel = r.cl, r.cancel
+			return r.resp, r.err
+		}
+	}
+}
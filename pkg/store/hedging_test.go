@@ -0,0 +1,161 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"google.golang.org/grpc"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	storetestutil "github.com/thanos-io/thanos/pkg/store/storepb/testutil"
+)
+
+// replicatedStoreAPI serves the Series requests with the replica of its call, like an endpoint group balancing its
+// requests across the replicas.
+type replicatedStoreAPI struct {
+	storepb.StoreClient
+
+	mtx      sync.Mutex
+	calls    int
+	replicas []*mockedStoreAPI
+}
+
+func (s *replicatedStoreAPI) Series(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	s.mtx.Lock()
+	replica := s.replicas[s.calls%len(s.replicas)]
+	s.calls++
+	s.mtx.Unlock()
+	return replica.Series(ctx, req, opts...)
+}
+
+func TestLatencyWindow_Quantile(t *testing.T) {
+	t.Parallel()
+
+	w := &latencyWindow{}
+	for i := 1; i <= 10; i++ {
+		w.add(time.Duration(i) * time.Second)
+	}
+	testutil.Equals(t, 1*time.Second, w.quantile(0))
+	testutil.Equals(t, 9*time.Second, w.quantile(0.9))
+	testutil.Equals(t, 10*time.Second, w.quantile(1))
+
+	// Only the most recent latencies are kept.
+	for i := 0; i < hedgingLatencyWindow; i++ {
+		w.add(time.Millisecond)
+	}
+	testutil.Equals(t, hedgingLatencyWindow, len(w.latencies))
+	testutil.Equals(t, time.Millisecond, w.quantile(1))
+}
+
+func TestSeriesHedger_Delay(t *testing.T) {
+	t.Parallel()
+
+	h := NewSeriesHedger(nil, 0.5, 10*time.Millisecond, func(Client) bool { return true })
+	_, ok := h.delay("a")
+	testutil.Assert(t, !ok)
+
+	for i := 0; i < hedgingMinLatencies; i++ {
+		h.observe("a", time.Millisecond)
+		h.observe("b", time.Second)
+	}
+	d, ok := h.delay("a")
+	testutil.Assert(t, ok)
+	testutil.Equals(t, 10*time.Millisecond, d)
+	d, ok = h.delay("b")
+	testutil.Assert(t, ok)
+	testutil.Equals(t, time.Second, d)
+}
+
+func TestSeriesHedger_Series(t *testing.T) {
+	t.Parallel()
+
+	resp := storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{0, 0}})
+	newHedger := func() *SeriesHedger {
+		h := NewSeriesHedger(prometheus.NewRegistry(), 0.9, time.Millisecond, func(st Client) bool {
+			addr, _ := st.Addr()
+			return addr != "not-hedged"
+		})
+		for i := 0; i < hedgingMinLatencies; i++ {
+			h.observe("hedged", time.Millisecond)
+			h.observe("not-hedged", time.Millisecond)
+		}
+		return h
+	}
+	recvAll := func(t *testing.T, cl storepb.Store_SeriesClient) []*storepb.SeriesResponse {
+		var resps []*storepb.SeriesResponse
+		for {
+			r, err := cl.Recv()
+			if err == io.EOF {
+				return resps
+			}
+			testutil.Ok(t, err)
+			resps = append(resps, r)
+		}
+	}
+
+	t.Run("slow replica is hedged", func(t *testing.T) {
+		t.Parallel()
+
+		h := newHedger()
+		st := &replicatedStoreAPI{replicas: []*mockedStoreAPI{
+			{RespSeries: []*storepb.SeriesResponse{resp}, RespDuration: time.Minute},
+			{RespSeries: []*storepb.SeriesResponse{resp}},
+		}}
+		cl, err := h.series(context.Background(), storetestutil.TestClient{Name: "hedged", StoreClient: st}, &storepb.SeriesRequest{})
+		testutil.Ok(t, err)
+		testutil.Equals(t, []*storepb.SeriesResponse{resp}, recvAll(t, cl))
+		testutil.Equals(t, 2, st.calls)
+		testutil.Equals(t, 1.0, promtest.ToFloat64(h.hedged))
+		testutil.Equals(t, 1.0, promtest.ToFloat64(h.hedgedWon))
+	})
+	t.Run("fast replica is not hedged", func(t *testing.T) {
+		t.Parallel()
+
+		h := newHedger()
+		st := &replicatedStoreAPI{replicas: []*mockedStoreAPI{
+			{RespSeries: []*storepb.SeriesResponse{resp, resp}},
+		}}
+		cl, err := h.series(context.Background(), storetestutil.TestClient{Name: "hedged", StoreClient: st}, &storepb.SeriesRequest{})
+		testutil.Ok(t, err)
+		testutil.Equals(t, []*storepb.SeriesResponse{resp, resp}, recvAll(t, cl))
+		testutil.Equals(t, 1, st.calls)
+		testutil.Equals(t, 0.0, promtest.ToFloat64(h.hedged))
+	})
+	t.Run("failed replica falls back to its hedge", func(t *testing.T) {
+		t.Parallel()
+
+		h := newHedger()
+		st := &replicatedStoreAPI{replicas: []*mockedStoreAPI{
+			{RespDuration: 20 * time.Millisecond, injectedError: errors.New("unavailable")},
+			{RespSeries: []*storepb.SeriesResponse{resp}, RespDuration: 40 * time.Millisecond},
+		}}
+		cl, err := h.series(context.Background(), storetestutil.TestClient{Name: "hedged", StoreClient: st}, &storepb.SeriesRequest{})
+		testutil.Ok(t, err)
+		testutil.Equals(t, []*storepb.SeriesResponse{resp}, recvAll(t, cl))
+		testutil.Equals(t, 1.0, promtest.ToFloat64(h.hedgedWon))
+	})
+	t.Run("store not hedgeable", func(t *testing.T) {
+		t.Parallel()
+
+		h := newHedger()
+		st := &replicatedStoreAPI{replicas: []*mockedStoreAPI{
+			{RespSeries: []*storepb.SeriesResponse{resp}, RespDuration: 50 * time.Millisecond},
+		}}
+		cl, err := h.series(context.Background(), storetestutil.TestClient{Name: "not-hedged", StoreClient: st}, &storepb.SeriesRequest{})
+		testutil.Ok(t, err)
+		testutil.Equals(t, []*storepb.SeriesResponse{resp}, recvAll(t, cl))
+		testutil.Equals(t, 1, st.calls)
+		testutil.Equals(t, 0.0, promtest.ToFloat64(h.hedged))
+	})
+}
@@ -0,0 +1,148 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/index"
+)
+
+// labelValuesPostingsBatchSize is the number of label values whose postings are fetched at once to find the values of
+// the series of expanded postings, so that limited requests fetch the postings of the first values only.
+const labelValuesPostingsBatchSize = 1024
+
+// labelNamesFoundCheckInterval is the number of series after which the label names found in the series of a block are
+// checked against the label names of the block, to stop fetching series once they can't change the result.
+const labelNamesFoundCheckInterval = 64
+
+// filterLabelValues returns the values matching all the matchers of the label name.
+func filterLabelValues(name string, values []string, matchers []*labels.Matcher) []string {
+	res := make([]string, 0, len(values))
+Values:
+	for _, v := range values {
+		for _, m := range matchers {
+			if m.Name == name && !m.Matches(v) {
+				continue Values
+			}
+		}
+		res = append(res, v)
+	}
+	return res
+}
+
+// labelValuesFromPostings returns the sorted values of the label name of the series of the expanded postings, up to
+// limit if positive, without fetching the series. It returns false if the series have to be fetched instead: if the
+// block is not within [mint, maxt], as series have to be filtered by the time of their chunks, if the postings are
+// expanded lazily, or if the label has more values matching the matchers than the postings have series.
+func (b *blockSeriesClient) labelValuesFromPostings(name string, matchers []*labels.Matcher, mint, maxt int64, limit int) ([]string, bool, error) {
+	blk := b.indexr.block
+	ps := b.lazyPostings
+	if !blk.withinClosedInterval(mint, maxt) || ps.lazyExpanded() {
+		return nil, false, nil
+	}
+	if len(ps.postings) == 0 {
+		return nil, true, nil
+	}
+	// External labels are not in the index, all the series of the block have their value.
+	if v := blk.extLset.Get(name); v != "" {
+		return []string{v}, true, nil
+	}
+
+	values, err := blk.indexHeaderReader.LabelValues(name)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "index header label values")
+	}
+	values = filterLabelValues(name, values, matchers)
+	if len(values) > len(ps.postings) {
+		return nil, false, nil
+	}
+	res, err := b.indexr.labelValuesFromPostings(b.ctx, name, values, ps.postings, limit, b.bytesLimiter, b.tenant)
+	if err != nil {
+		return nil, false, err
+	}
+	return res, true, nil
+}
+
+// labelValuesFromPostings returns the sorted values of the label name of the series of the postings, up to limit if
+// positive. The postings of the candidate values of the label are intersected with the postings instead of fetching
+// the series, in batches so that the postings of the values past the limit are not fetched.
+func (r *bucketIndexReader) labelValuesFromPostings(ctx context.Context, name string, values []string, ps []storage.SeriesRef, limit int, bytesLimiter BytesLimiter, tenant string) ([]string, error) {
+	// Expanded postings are references of series, which are 16 byte padded as of version two of the index, while the
+	// postings of the index are their IDs.
+	version, err := r.IndexVersion()
+	if err != nil {
+		return nil, errors.Wrap(err, "get index version")
+	}
+	if version >= 2 {
+		ids := make([]storage.SeriesRef, 0, len(ps))
+		for _, ref := range ps {
+			ids = append(ids, ref/16)
+		}
+		ps = ids
+	}
+
+	var res []string
+	for len(values) > 0 && len(ps) > 0 && (limit <= 0 || len(res) < limit) {
+		batch := values[:min(len(values), labelValuesPostingsBatchSize)]
+		values = values[len(batch):]
+
+		found, err := r.intersectLabelValues(ctx, name, batch, ps, bytesLimiter, tenant)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, found...)
+	}
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+// intersectLabelValues returns the sorted values of the label name whose postings intersect with the postings.
+func (r *bucketIndexReader) intersectLabelValues(ctx context.Context, name string, values []string, ps []storage.SeriesRef, bytesLimiter BytesLimiter, tenant string) ([]string, error) {
+	keys := make([]labels.Label, 0, len(values))
+	for _, v := range values {
+		keys = append(keys, labels.Label{Name: name, Value: v})
+	}
+	fetched, closeFns, err := r.fetchPostings(ctx, keys, bytesLimiter, tenant)
+	defer func() {
+		for _, closeFn := range closeFns {
+			closeFn()
+		}
+	}()
+	if err != nil {
+		return nil, errors.Wrap(err, "get postings")
+	}
+
+	idxs, err := index.FindIntersectingPostings(index.NewListPostings(ps), fetched)
+	if err != nil {
+		return nil, errors.Wrap(err, "intersect postings")
+	}
+	sort.Ints(idxs)
+
+	res := make([]string, 0, len(idxs))
+	for _, i := range idxs {
+		res = append(res, values[i])
+	}
+	return res, nil
+}
+
+// allLabelNamesFound returns true if the names found in the series of a block are its first sorted label names, up to
+// limit if positive, so that the other series can't change its label names.
+func allLabelNamesFound(names []string, found map[string]struct{}, limit int) bool {
+	for i, name := range names {
+		if limit > 0 && i >= limit {
+			return true
+		}
+		if _, ok := found[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+func TestFilterLabelValues(t *testing.T) {
+	t.Parallel()
+
+	values := []string{"a", "b", "c", "d"}
+	testutil.Equals(t, []string{"b", "c"}, filterLabelValues("l", values, []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchRegexp, "l", "b|c|e"),
+		labels.MustNewMatcher(labels.MatchNotEqual, "l", ""),
+		// Matchers of other labels are ignored.
+		labels.MustNewMatcher(labels.MatchEqual, "other", "a"),
+	}))
+	testutil.Equals(t, []string{"a", "b", "c", "d"}, values)
+}
+
+func TestAllLabelNamesFound(t *testing.T) {
+	t.Parallel()
+
+	names := []string{"a", "b", "c"}
+	for _, tc := range []struct {
+		found    []string
+		limit    int
+		expected bool
+	}{
+		{found: []string{"a", "b", "c"}, expected: true},
+		{found: []string{"a", "c"}, expected: false},
+		{found: []string{"a", "c"}, limit: 1, expected: true},
+		{found: []string{"b", "c"}, limit: 1, expected: false},
+		{found: []string{"a", "b"}, limit: 2, expected: true},
+		{found: []string{"a", "b"}, limit: 5, expected: false},
+	} {
+		found := map[string]struct{}{}
+		for _, n := range tc.found {
+			found[n] = struct{}{}
+		}
+		testutil.Equals(t, tc.expected, allLabelNamesFound(names, found, tc.limit), "found %v, limit %d", tc.found, tc.limit)
+	}
+}
@@ -234,10 +234,15 @@ func fetchLazyExpandedPostings(
 	*/
 	if lazyExpandedPostingEnabled && !addAllPostings &&
 		r.block.estimatedMaxSeriesSize > 0 && len(postingGroups) > 1 {
+		// The average series size of the block, if recorded, estimates the series bytes to download better.
+		seriesSize := int64(r.block.estimatedMaxSeriesSize)
+		if avg := r.block.meta.Thanos.IndexStats.SeriesAvgSize(); avg > 0 {
+			seriesSize = min(seriesSize, avg)
+		}
 		postingGroups, emptyPostingGroup, err = optimizePostingsFetchByDownloadedBytes(
 			r,
 			postingGroups,
-			int64(r.block.estimatedMaxSeriesSize),
+			seriesSize,
 			seriesMatchRatio,
 			postingGroupMaxKeySeriesRatio,
 			lazyExpandedPostingSizeBytes,
@@ -25,6 +25,7 @@ import (
 
 	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/info/infopb"
+	"github.com/thanos-io/thanos/pkg/logging"
 	storecache "github.com/thanos-io/thanos/pkg/store/cache"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
@@ -94,6 +95,8 @@ type ProxyStore struct {
 	enableDedup       bool
 
 	lazyRetrievalMaxBufferedResponses int
+	lazyRetrievalMaxBufferedBytes     int
+	hedger                            *SeriesHedger
 }
 
 type proxyStoreMetrics struct {
@@ -111,9 +114,13 @@ func newProxyStoreMetrics(reg prometheus.Registerer) *proxyStoreMetrics {
 	return &m
 }
 
+// RegisterStoreServer registers the store server for the Store API and for the SeriesStream API, which serves the
+// Series requests of clients using flow control.
 func RegisterStoreServer(storeSrv storepb.StoreServer, logger log.Logger) func(*grpc.Server) {
 	return func(s *grpc.Server) {
-		storepb.RegisterStoreServer(s, NewRecoverableStoreServer(logger, storeSrv))
+		recoverable := NewRecoverableStoreServer(logger, storeSrv)
+		storepb.RegisterStoreServer(s, recoverable)
+		storepb.RegisterSeriesStreamServer(s, storepb.NewSeriesStreamServer(recoverable))
 	}
 }
 
@@ -126,6 +133,22 @@ func WithLazyRetrievalMaxBufferedResponsesForProxy(bufferSize int) ProxyStoreOpt
 	}
 }
 
+// WithLazyRetrievalMaxBufferedBytesForProxy limits the size of the responses the lazy retrieval strategy buffers for
+// each store, on top of their number. Reading from a store is paused while its buffer is full, so that fast stores
+// do not fill the memory of the querier while it waits for the slow ones. Zero disables the limit.
+func WithLazyRetrievalMaxBufferedBytesForProxy(maxBytes int) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.lazyRetrievalMaxBufferedBytes = maxBytes
+	}
+}
+
+// WithSeriesHedging hedges the Series requests to slow stores with the hedger.
+func WithSeriesHedging(hedger *SeriesHedger) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.hedger = hedger
+	}
+}
+
 // WithProxyStoreDebugLogging toggles debug logging.
 func WithProxyStoreDebugLogging(enable bool) ProxyStoreOption {
 	return func(s *ProxyStore) {
@@ -260,7 +283,7 @@ func (s *ProxyStore) TSDBInfos() []infopb.TSDBInfo {
 func (s *ProxyStore) Series(originalRequest *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
 	// TODO(bwplotka): This should be part of request logger, otherwise it does not make much sense. Also, could be
 	// triggered by tracing span to reduce cognitive load.
-	reqLogger := log.With(s.logger, "component", "proxy")
+	reqLogger := logging.WithRequestID(srv.Context(), log.With(s.logger, "component", "proxy"))
 	if s.debugLogging {
 		reqLogger = log.With(reqLogger, "request", originalRequest.String())
 	}
@@ -311,12 +334,15 @@ func (s *ProxyStore) Series(originalRequest *storepb.SeriesRequest, srv storepb.
 		ShardInfo:               originalRequest.ShardInfo,
 		WithoutReplicaLabels:    originalRequest.WithoutReplicaLabels,
 	}
+	if SeriesStatsCollectorFromContext(ctx) != nil {
+		r.Hints = queryStatsRequestHints()
+	}
 
 	storeResponses := make([]respSet, 0, len(stores))
 	for _, st := range stores {
 		st := st
 
-		respSet, err := newAsyncRespSet(ctx, st, r, s.responseTimeout, s.retrievalStrategy, &s.buffers, r.ShardInfo, reqLogger, s.metrics.emptyStreamResponses, s.lazyRetrievalMaxBufferedResponses)
+		respSet, err := newAsyncRespSet(ctx, st, r, s.responseTimeout, s.retrievalStrategy, &s.buffers, r.ShardInfo, reqLogger, s.metrics.emptyStreamResponses, s.lazyRetrievalMaxBufferedResponses, s.lazyRetrievalMaxBufferedBytes, s.hedger)
 		if err != nil {
 			level.Error(reqLogger).Log("err", err)
 
@@ -366,7 +392,7 @@ func (s *ProxyStore) Series(originalRequest *storepb.SeriesRequest, srv storepb.
 func (s *ProxyStore) LabelNames(ctx context.Context, originalRequest *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
 	// TODO(bwplotka): This should be part of request logger, otherwise it does not make much sense. Also, could be
 	// triggered by tracing span to reduce cognitive load.
-	reqLogger := log.With(s.logger, "component", "proxy")
+	reqLogger := logging.WithRequestID(ctx, log.With(s.logger, "component", "proxy"))
 	if s.debugLogging {
 		reqLogger = log.With(reqLogger, "request", originalRequest.String())
 	}
@@ -464,7 +490,7 @@ func (s *ProxyStore) LabelValues(ctx context.Context, originalRequest *storepb.L
 ) {
 	// TODO(bwplotka): This should be part of request logger, otherwise it does not make much sense. Also, could be
 	// triggered by tracing span to reduce cognitive load.
-	reqLogger := log.With(s.logger, "component", "proxy")
+	reqLogger := logging.WithRequestID(ctx, log.With(s.logger, "component", "proxy"))
 	if s.debugLogging {
 		reqLogger = log.With(reqLogger, "request", originalRequest.String())
 	}
@@ -22,6 +22,7 @@ import (
 	grpc_opentracing "github.com/thanos-io/thanos/pkg/tracing/tracing_middleware"
 
 	"github.com/thanos-io/thanos/pkg/losertree"
+	"github.com/thanos-io/thanos/pkg/store/hintspb"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/thanos-io/thanos/pkg/tracing"
@@ -225,20 +226,27 @@ type ringBuffer struct {
 	// This a ring buffer of size fixedBufferSize.
 	// A ring buffer of size N can hold N - 1 elements at most in order to distinguish being empty from being full.
 	bufferedResponses []*storepb.SeriesResponse
+	bufferedSizes     []int
 	ringHead          int
 	ringTail          int
 	closed            bool
+
+	// The buffer is also full once it holds maxBufferedBytes of responses, unless it is zero.
+	maxBufferedBytes int
+	bufferedBytes    int
 }
 
 // NB: A call site of any method of ringBuffer must hold the mtx lock.
-func newRingBuffer(fixedBufferSize int, mtx *sync.Mutex) *ringBuffer {
+func newRingBuffer(fixedBufferSize, maxBufferedBytes int, mtx *sync.Mutex) *ringBuffer {
 	return &ringBuffer{
 		bufferedResponses: make([]*storepb.SeriesResponse, fixedBufferSize+1),
+		bufferedSizes:     make([]int, fixedBufferSize+1),
 		fixedBufferSize:   fixedBufferSize + 1,
 		bufferSlotEvent:   sync.NewCond(mtx),
 		ringHead:          0,
 		ringTail:          0,
 		closed:            false,
+		maxBufferedBytes:  maxBufferedBytes,
 	}
 }
 
@@ -248,7 +256,13 @@ func (rb *ringBuffer) append(resp *storepb.SeriesResponse) bool {
 		rb.bufferSlotEvent.Wait()
 	}
 	if !rb.closed {
+		size := 0
+		if rb.maxBufferedBytes > 0 {
+			size = resp.Size()
+		}
 		rb.bufferedResponses[rb.ringTail] = resp
+		rb.bufferedSizes[rb.ringTail] = size
+		rb.bufferedBytes += size
 		rb.ringTail = (rb.ringTail + 1) % rb.fixedBufferSize
 		return true
 	}
@@ -264,6 +278,8 @@ func (rb *ringBuffer) pop() *storepb.SeriesResponse {
 	defer rb.bufferSlotEvent.Signal()
 
 	resp := rb.bufferedResponses[rb.ringHead]
+	rb.bufferedResponses[rb.ringHead] = nil
+	rb.bufferedBytes -= rb.bufferedSizes[rb.ringHead]
 	rb.ringHead = (rb.ringHead + 1) % rb.fixedBufferSize
 	return resp
 }
@@ -272,7 +288,12 @@ func (rb *ringBuffer) isEmpty() bool {
 	return rb.ringHead == rb.ringTail
 }
 
+// isFull returns true if the buffer holds as many responses or bytes as it can. A buffer holding no responses is
+// never full, so that responses bigger than the max buffered bytes are still received.
 func (rb *ringBuffer) isFull() bool {
+	if rb.maxBufferedBytes > 0 && rb.bufferedBytes >= rb.maxBufferedBytes && !rb.isEmpty() {
+		return true
+	}
 	return (rb.ringTail+1)%rb.fixedBufferSize == rb.ringHead
 }
 
@@ -388,6 +409,8 @@ func newLazyRespSet(
 	applySharding bool,
 	emptyStreamResponses prometheus.Counter,
 	fixedBufferSize int,
+	maxBufferedBytes int,
+	onFinish respSetStatsFunc,
 ) respSet {
 	bufferedResponsesMtx := &sync.Mutex{}
 
@@ -400,7 +423,7 @@ func newLazyRespSet(
 		span:                 span,
 		dataOrFinishEvent:    sync.NewCond(bufferedResponsesMtx),
 		bufferedResponsesMtx: bufferedResponsesMtx,
-		rb:                   newRingBuffer(fixedBufferSize, bufferedResponsesMtx),
+		rb:                   newRingBuffer(fixedBufferSize, maxBufferedBytes, bufferedResponsesMtx),
 		initialized:          false,
 		noMoreData:           false,
 		shardMatcher:         shardMatcher,
@@ -415,6 +438,7 @@ func newLazyRespSet(
 	go func(st string, l *lazyRespSet) {
 		bytesProcessed := 0
 		seriesStats := &storepb.SeriesStatsCounter{}
+		var queryStats *hintspb.QueryStats
 
 		defer func() {
 			l.span.SetTag("processed.series", seriesStats.Series)
@@ -422,6 +446,9 @@ func newLazyRespSet(
 			l.span.SetTag("processed.samples", seriesStats.Samples)
 			l.span.SetTag("processed.bytes", bytesProcessed)
 			l.span.Finish()
+			if onFinish != nil {
+				onFinish(*seriesStats, bytesProcessed, queryStats)
+			}
 		}()
 
 		numResponses := 0
@@ -481,6 +508,9 @@ func newLazyRespSet(
 			if resp.GetSeries() != nil {
 				seriesStats.Count(resp.GetSeries())
 			}
+			if onFinish != nil && resp.GetHints() != nil {
+				queryStats = queryStatsFromHints(resp.GetHints())
+			}
 
 			l.bufferedResponsesMtx.Lock()
 			if l.rb.append(resp) {
@@ -530,6 +560,8 @@ func newAsyncRespSet(
 	logger log.Logger,
 	emptyStreamResponses prometheus.Counter,
 	lazyRetrievalMaxBufferedResponses int,
+	lazyRetrievalMaxBufferedBytes int,
+	hedger *SeriesHedger,
 ) (respSet, error) {
 
 	var (
@@ -537,6 +569,14 @@ func newAsyncRespSet(
 		cancel context.CancelFunc
 	)
 
+	var onFinish respSetStatsFunc
+	if c := SeriesStatsCollectorFromContext(ctx); c != nil {
+		start := time.Now()
+		onFinish = func(series storepb.SeriesStatsCounter, bytes int, queryStats *hintspb.QueryStats) {
+			c.addSeriesCall(st, time.Since(start), series, bytes, queryStats)
+		}
+	}
+
 	storeID, storeAddr, isLocalStore := storeInfo(st)
 	seriesCtx := grpc_opentracing.ClientAddContextTags(ctx, opentracing.Tags{
 		"target": storeAddr,
@@ -557,7 +597,15 @@ func newAsyncRespSet(
 		level.Debug(logger).Log("msg", "Applying series sharding in the proxy since there is not support in the underlying store", "store", st.String())
 	}
 
-	cl, err := st.Series(seriesCtx, req)
+	var (
+		cl  storepb.Store_SeriesClient
+		err error
+	)
+	if hedger != nil {
+		cl, err = hedger.series(seriesCtx, st, req)
+	} else {
+		cl, err = st.Series(seriesCtx, req)
+	}
 	if err != nil {
 		err = errors.Wrapf(err, "fetch series for %s %s", storeID, st)
 
@@ -597,6 +645,8 @@ func newAsyncRespSet(
 			applySharding,
 			emptyStreamResponses,
 			lazyRetrievalMaxBufferedResponses,
+			lazyRetrievalMaxBufferedBytes,
+			onFinish,
 		), nil
 	case EagerRetrieval:
 		return newEagerRespSet(
@@ -610,6 +660,7 @@ func newAsyncRespSet(
 			applySharding,
 			emptyStreamResponses,
 			labelsToRemove,
+			onFinish,
 		), nil
 	default:
 		panic(fmt.Sprintf("unsupported retrieval strategy %s", retrievalStrategy))
@@ -651,6 +702,7 @@ func newEagerRespSet(
 	applySharding bool,
 	emptyStreamResponses prometheus.Counter,
 	removeLabels map[string]struct{},
+	onFinish respSetStatsFunc,
 ) respSet {
 	ret := &eagerRespSet{
 		span:              span,
@@ -677,6 +729,7 @@ func newEagerRespSet(
 	go func(l *eagerRespSet) {
 		seriesStats := &storepb.SeriesStatsCounter{}
 		bytesProcessed := 0
+		var queryStats *hintspb.QueryStats
 
 		defer func() {
 			l.span.SetTag("processed.series", seriesStats.Series)
@@ -684,6 +737,9 @@ func newEagerRespSet(
 			l.span.SetTag("processed.samples", seriesStats.Samples)
 			l.span.SetTag("processed.bytes", bytesProcessed)
 			l.span.Finish()
+			if onFinish != nil {
+				onFinish(*seriesStats, bytesProcessed, queryStats)
+			}
 			ret.wg.Done()
 		}()
 
@@ -733,6 +789,9 @@ func newEagerRespSet(
 			if resp.GetSeries() != nil {
 				seriesStats.Count(resp.GetSeries())
 			}
+			if onFinish != nil && resp.GetHints() != nil {
+				queryStats = queryStatsFromHints(resp.GetHints())
+			}
 
 			l.bufferedResponses = append(l.bufferedResponses, resp)
 			return true
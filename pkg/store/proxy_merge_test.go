@@ -385,3 +385,27 @@ func BenchmarkKWayMerge(b *testing.B) {
 		var _ = got
 	}
 }
+
+func TestRingBuffer_MaxBufferedBytes(t *testing.T) {
+	t.Parallel()
+
+	resp := storeSeriesResponse(t, labelsFromStrings("a", "1"))
+	rb := newRingBuffer(10, resp.Size()*2, &sync.Mutex{})
+
+	testutil.Assert(t, !rb.isFull(), "empty buffer is full")
+	testutil.Assert(t, rb.append(resp), "not appended")
+	testutil.Assert(t, !rb.isFull(), "buffer with one response is full")
+	testutil.Assert(t, rb.append(resp), "not appended")
+	testutil.Assert(t, rb.isFull(), "buffer over the max bytes is not full")
+
+	testutil.Equals(t, resp, rb.pop())
+	testutil.Assert(t, !rb.isFull(), "buffer under the max bytes is full")
+	testutil.Equals(t, resp, rb.pop())
+	testutil.Assert(t, rb.isEmpty(), "buffer is not empty")
+	testutil.Equals(t, 0, rb.bufferedBytes)
+
+	// A response bigger than the max bytes is buffered in an empty buffer.
+	big := newRingBuffer(10, 1, &sync.Mutex{})
+	testutil.Assert(t, big.append(resp), "not appended")
+	testutil.Assert(t, big.isFull(), "buffer over the max bytes is not full")
+}
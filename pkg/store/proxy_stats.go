@@ -0,0 +1,182 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+
+	"github.com/thanos-io/thanos/pkg/store/hintspb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// SeriesStatsCollectorKey is the context key for the collector of the statistics of the Series calls of the proxy.
+const SeriesStatsCollectorKey = ctxKey(1)
+
+// StoreStats are the statistics of the Series calls of a query to a StoreAPI.
+type StoreStats struct {
+	// Address is the address of the StoreAPI.
+	Address string `json:"address"`
+	// LabelSets are the external label sets of the StoreAPI.
+	LabelSets string `json:"labelSets,omitempty"`
+	// Calls is the number of Series calls to the StoreAPI.
+	Calls int `json:"calls"`
+	// DurationSeconds is the sum of the durations of the Series calls, until their last response was received.
+	DurationSeconds float64 `json:"durationSeconds"`
+	Series          int     `json:"series"`
+	Chunks          int     `json:"chunks"`
+	Samples         int     `json:"samples"`
+	Bytes           int     `json:"bytes"`
+
+	// The following statistics are only reported by store gateways.
+	BlocksQueried       int64 `json:"blocksQueried,omitempty"`
+	DataDownloadedBytes int64 `json:"dataDownloadedBytes,omitempty"`
+	// The cache hit ratios are the ratios of the touched index and chunk data that was not fetched from the object
+	// storage.
+	PostingsCacheHitRatio *float64 `json:"postingsCacheHitRatio,omitempty"`
+	SeriesCacheHitRatio   *float64 `json:"seriesCacheHitRatio,omitempty"`
+	ChunksCacheHitRatio   *float64 `json:"chunksCacheHitRatio,omitempty"`
+
+	queryStats *hintspb.QueryStats
+}
+
+// DeduplicationStats are the statistics of the deduplication of the series of a query.
+type DeduplicationStats struct {
+	// InputSeries is the number of series fetched from all StoreAPIs, including the series of every replica.
+	InputSeries int `json:"inputSeries"`
+	// OutputSeries is the number of series after the deduplication.
+	OutputSeries int `json:"outputSeries"`
+}
+
+// SeriesStatsCollector collects the statistics of the Series calls of a query by StoreAPI, and of their
+// deduplication. It is passed to the proxy in the context of the Series calls; a nil collector collects nothing.
+type SeriesStatsCollector struct {
+	mtx          sync.Mutex
+	stores       map[string]*StoreStats
+	deduplicated bool
+	dedupSeries  int
+}
+
+// NewSeriesStatsCollector returns a new collector of the statistics of the Series calls of a query.
+func NewSeriesStatsCollector() *SeriesStatsCollector {
+	return &SeriesStatsCollector{stores: map[string]*StoreStats{}}
+}
+
+// SeriesStatsCollectorFromContext returns the collector of the context, or nil if it has none.
+func SeriesStatsCollectorFromContext(ctx context.Context) *SeriesStatsCollector {
+	c, _ := ctx.Value(SeriesStatsCollectorKey).(*SeriesStatsCollector)
+	return c
+}
+
+// respSetStatsFunc is called with the statistics of a Series call once its last response was received.
+type respSetStatsFunc func(series storepb.SeriesStatsCounter, bytes int, queryStats *hintspb.QueryStats)
+
+func (c *SeriesStatsCollector) addSeriesCall(st Client, duration time.Duration, series storepb.SeriesStatsCounter, bytes int, queryStats *hintspb.QueryStats) {
+	if c == nil {
+		return
+	}
+	storeID, storeAddr, _ := storeInfo(st)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	s, ok := c.stores[storeAddr]
+	if !ok {
+		s = &StoreStats{Address: storeAddr, LabelSets: storeID}
+		c.stores[storeAddr] = s
+	}
+	s.Calls++
+	s.DurationSeconds += duration.Seconds()
+	s.Series += series.Series
+	s.Chunks += series.Chunks
+	s.Samples += series.Samples
+	s.Bytes += bytes
+	if queryStats != nil {
+		if s.queryStats == nil {
+			s.queryStats = &hintspb.QueryStats{}
+		}
+		s.queryStats.Merge(queryStats)
+	}
+}
+
+// AddDeduplicatedSeries records the number of series of a deduplicated series set.
+func (c *SeriesStatsCollector) AddDeduplicatedSeries(series int) {
+	if c == nil {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.deduplicated = true
+	c.dedupSeries += series
+}
+
+// Stores returns the statistics of the StoreAPIs called, sorted by address.
+func (c *SeriesStatsCollector) Stores() []StoreStats {
+	if c == nil {
+		return nil
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	res := make([]StoreStats, 0, len(c.stores))
+	for _, s := range c.stores {
+		r := *s
+		if qs := s.queryStats; qs != nil {
+			r.BlocksQueried = qs.BlocksQueried
+			r.DataDownloadedBytes = qs.DataDownloadedSizeSum
+			r.PostingsCacheHitRatio = cacheHitRatio(qs.PostingsTouched, qs.PostingsFetched)
+			r.SeriesCacheHitRatio = cacheHitRatio(qs.SeriesTouched, qs.SeriesFetched)
+			r.ChunksCacheHitRatio = cacheHitRatio(qs.ChunksTouched, qs.ChunksFetched)
+		}
+		res = append(res, r)
+	}
+	slices.SortFunc(res, func(a, b StoreStats) int { return strings.Compare(a.Address, b.Address) })
+	return res
+}
+
+// Deduplication returns the statistics of the deduplication, or nil if the series were not deduplicated.
+func (c *SeriesStatsCollector) Deduplication() *DeduplicationStats {
+	if c == nil {
+		return nil
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if !c.deduplicated {
+		return nil
+	}
+	d := &DeduplicationStats{OutputSeries: c.dedupSeries}
+	for _, s := range c.stores {
+		d.InputSeries += s.Series
+	}
+	return d
+}
+
+func cacheHitRatio(touched, fetched int64) *float64 {
+	if touched <= 0 {
+		return nil
+	}
+	r := max(0, 1-float64(fetched)/float64(touched))
+	return &r
+}
+
+// queryStatsRequestHints returns the series request hints enabling the query stats of the store gateways.
+func queryStatsRequestHints() *types.Any {
+	hints, err := types.MarshalAny(&hintspb.SeriesRequestHints{EnableQueryStats: true})
+	if err != nil {
+		return nil
+	}
+	return hints
+}
+
+// queryStatsFromHints returns the query stats of the hints of a series response, if any.
+func queryStatsFromHints(hints *types.Any) *hintspb.QueryStats {
+	resHints := &hintspb.SeriesResponseHints{}
+	if err := types.UnmarshalAny(hints, resHints); err != nil {
+		return nil
+	}
+	return resHints.QueryStats
+}
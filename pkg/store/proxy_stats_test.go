@@ -0,0 +1,106 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/gogo/protobuf/types"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/component"
+	"github.com/thanos-io/thanos/pkg/store/hintspb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	storetestutil "github.com/thanos-io/thanos/pkg/store/storepb/testutil"
+)
+
+func TestProxyStore_SeriesStatsCollector(t *testing.T) {
+	t.Parallel()
+
+	hints, err := types.MarshalAny(&hintspb.SeriesResponseHints{QueryStats: &hintspb.QueryStats{
+		BlocksQueried:   2,
+		PostingsTouched: 10,
+		PostingsFetched: 4,
+		ChunksTouched:   5,
+		ChunksFetched:   5,
+	}})
+	testutil.Ok(t, err)
+
+	gateway := &mockedStoreAPI{
+		RespSeries: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}, {2, 2}}),
+			storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}}),
+			storepb.NewHintsSeriesResponse(hints),
+		},
+	}
+	sidecar := &mockedStoreAPI{
+		RespSeries: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}}),
+		},
+	}
+	stores := []Client{
+		&storetestutil.TestClient{Name: "sidecar", StoreClient: sidecar, ExtLset: []labels.Labels{labels.FromStrings("ext", "1")}, MinTime: 0, MaxTime: 10},
+		&storetestutil.TestClient{Name: "gateway", StoreClient: gateway, MinTime: 0, MaxTime: 10},
+	}
+	req := &storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  10,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+	}
+
+	for _, strategy := range []RetrievalStrategy{EagerRetrieval, LazyRetrieval} {
+		t.Run(string(strategy), func(t *testing.T) {
+			q := NewProxyStore(nil, nil, func() []Client { return stores }, component.Query, labels.EmptyLabels(), 5*time.Second, strategy)
+
+			// Without a collector, the query stats of the store gateways are not requested.
+			testutil.Ok(t, q.Series(req, newStoreSeriesServer(context.Background())))
+			testutil.Assert(t, gateway.LastSeriesReq.Hints == nil)
+
+			c := NewSeriesStatsCollector()
+			testutil.Ok(t, q.Series(req, newStoreSeriesServer(context.WithValue(context.Background(), SeriesStatsCollectorKey, c))))
+			testutil.Assert(t, gateway.LastSeriesReq.Hints != nil)
+
+			got := c.Stores()
+			testutil.Equals(t, 2, len(got))
+
+			testutil.Equals(t, "gateway", got[0].Address)
+			testutil.Equals(t, 1, got[0].Calls)
+			testutil.Equals(t, 2, got[0].Series)
+			testutil.Equals(t, 2, got[0].Chunks)
+			testutil.Equals(t, 3, got[0].Samples)
+			testutil.Equals(t, int64(2), got[0].BlocksQueried)
+			testutil.Equals(t, 0.6, *got[0].PostingsCacheHitRatio)
+			testutil.Equals(t, 0.0, *got[0].ChunksCacheHitRatio)
+			testutil.Assert(t, got[0].SeriesCacheHitRatio == nil)
+
+			testutil.Equals(t, "sidecar", got[1].Address)
+			testutil.Equals(t, `{ext="1"}`, got[1].LabelSets)
+			testutil.Equals(t, 1, got[1].Series)
+			testutil.Assert(t, got[1].PostingsCacheHitRatio == nil)
+		})
+	}
+}
+
+func TestSeriesStatsCollector_Deduplication(t *testing.T) {
+	t.Parallel()
+
+	var nilCollector *SeriesStatsCollector
+	nilCollector.AddDeduplicatedSeries(1)
+	testutil.Assert(t, nilCollector.Deduplication() == nil)
+
+	c := NewSeriesStatsCollector()
+	st := &storetestutil.TestClient{Name: "sidecar"}
+	c.addSeriesCall(st, time.Second, storepb.SeriesStatsCounter{Series: 4}, 100, nil)
+	c.addSeriesCall(st, time.Second, storepb.SeriesStatsCounter{Series: 2}, 100, nil)
+	testutil.Assert(t, c.Deduplication() == nil)
+
+	c.AddDeduplicatedSeries(2)
+	c.AddDeduplicatedSeries(1)
+	testutil.Equals(t, &DeduplicationStats{InputSeries: 6, OutputSeries: 3}, c.Deduplication())
+	testutil.Equals(t, 2, c.Stores()[0].Calls)
+	testutil.Equals(t, 2.0, c.Stores()[0].DurationSeconds)
+}
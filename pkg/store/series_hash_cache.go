@@ -0,0 +1,250 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"io"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-io/objstore"
+	"go.uber.org/atomic"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+const (
+	seriesHashSourcePrecomputed = "precomputed"
+	seriesHashSourceCache       = "cache"
+	seriesHashSourceComputed    = "computed"
+)
+
+// SeriesHashCache caches the shard hashes of the series of blocks by the labels the series are sharded by, so that
+// sharded requests, e.g. of the query frontend splitting a query into shards, don't hash the labels of the same
+// series again. The cache holds up to a maximum number of hashes; once full, the hashes of blocks not cached yet
+// are computed for every request until blocks are unloaded.
+type SeriesHashCache struct {
+	maxItems uint64
+	items    atomic.Uint64
+
+	mtx    sync.Mutex
+	blocks map[ulid.ULID]map[string]*blockSeriesHashCache
+}
+
+// NewSeriesHashCache returns a cache of up to maxItems series hashes.
+func NewSeriesHashCache(reg prometheus.Registerer, maxItems uint64) *SeriesHashCache {
+	c := &SeriesHashCache{
+		maxItems: maxItems,
+		blocks:   map[ulid.ULID]map[string]*blockSeriesHashCache{},
+	}
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "thanos_bucket_store_series_hash_cache_items",
+		Help: "Number of series hashes in the series hash cache.",
+	}, func() float64 { return float64(c.items.Load()) })
+	return c
+}
+
+// blockCache returns the cache of the hashes of the series of the block for the key. A nil cache caches nothing.
+func (c *SeriesHashCache) blockCache(id ulid.ULID, key string) *blockSeriesHashCache {
+	if c == nil {
+		return nil
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	keys, ok := c.blocks[id]
+	if !ok {
+		keys = map[string]*blockSeriesHashCache{}
+		c.blocks[id] = keys
+	}
+	bc, ok := keys[key]
+	if !ok {
+		bc = &blockSeriesHashCache{parent: c, hashes: map[storage.SeriesRef]uint64{}}
+		keys[key] = bc
+	}
+	return bc
+}
+
+// dropBlock removes the hashes of the series of the unloaded block.
+func (c *SeriesHashCache) dropBlock(id ulid.ULID) {
+	if c == nil {
+		return
+	}
+	c.mtx.Lock()
+	keys := c.blocks[id]
+	delete(c.blocks, id)
+	c.mtx.Unlock()
+
+	for _, bc := range keys {
+		bc.mtx.Lock()
+		c.items.Sub(uint64(len(bc.hashes)))
+		bc.hashes = nil
+		bc.mtx.Unlock()
+	}
+}
+
+type blockSeriesHashCache struct {
+	parent *SeriesHashCache
+
+	mtx    sync.RWMutex
+	hashes map[storage.SeriesRef]uint64
+}
+
+func (c *blockSeriesHashCache) fetch(ref storage.SeriesRef) (uint64, bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	h, ok := c.hashes[ref]
+	return h, ok
+}
+
+func (c *blockSeriesHashCache) store(ref storage.SeriesRef, hash uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	// The cache of a dropped block is not used anymore.
+	if c.hashes == nil {
+		return
+	}
+	if _, ok := c.hashes[ref]; ok {
+		return
+	}
+	if c.parent.items.Inc() > c.parent.maxItems {
+		c.parent.items.Dec()
+		return
+	}
+	c.hashes[ref] = hash
+}
+
+// seriesHasher returns the shard hashes of the series of a block for a request, from the hashes precomputed by the
+// compactor, the series hash cache or by hashing their labels, and counts the hashes of each source.
+type seriesHasher struct {
+	matcher     *storepb.ShardMatcher
+	precomputed *block.SeriesHashes
+	cache       *blockSeriesHashCache
+	hashes      *prometheus.CounterVec
+	counts      [3]int
+}
+
+// newSeriesHasher returns the hasher of the series of the block for the shard matcher, with the external labels of
+// the block once the labels removed from the series of the request are removed. A nil hasher is returned for
+// matchers which are not sharding.
+func newSeriesHasher(b *bucketBlock, matcher *storepb.ShardMatcher, extLset labels.Labels, extLsetToRemove map[string]struct{}) *seriesHasher {
+	if !matcher.IsSharded() {
+		return nil
+	}
+	h := &seriesHasher{matcher: matcher, hashes: b.metrics.seriesHashes}
+	if b.seriesHashes != nil && matcher.Key() == storepb.AllLabelsShardKey && b.precomputedHashesApply(extLset, extLsetToRemove) {
+		h.precomputed = b.seriesHashes
+		return h
+	}
+	if b.seriesHashCache == nil {
+		return h
+	}
+
+	// The hashes of series depend on the labels removed from them, and of the external labels merged into them.
+	removed := make([]string, 0, len(extLsetToRemove))
+	for n := range extLsetToRemove {
+		removed = append(removed, n)
+	}
+	sort.Strings(removed)
+	key := matcher.Key() + ";" + strings.Join(removed, ",") + ";" + strings.Join(labelNames(extLset), ",")
+	h.cache = b.seriesHashCache.blockCache(b.meta.ULID, key)
+	return h
+}
+
+// matches returns true if the series with the labels belongs to the shard.
+func (h *seriesHasher) matches(ref storage.SeriesRef, lset labels.Labels) bool {
+	if h == nil {
+		return true
+	}
+	if h.precomputed != nil {
+		if hash, ok := h.precomputed.Get(ref); ok {
+			h.counts[0]++
+			return h.matcher.MatchesHash(hash)
+		}
+	}
+	if h.cache != nil {
+		if hash, ok := h.cache.fetch(ref); ok {
+			h.counts[1]++
+			return h.matcher.MatchesHash(hash)
+		}
+	}
+	h.counts[2]++
+	hash := h.matcher.Hash(labelpb.ZLabelsFromPromLabels(lset))
+	if h.cache != nil {
+		h.cache.store(ref, hash)
+	}
+	return h.matcher.MatchesHash(hash)
+}
+
+func (h *seriesHasher) flush() {
+	if h == nil {
+		return
+	}
+	for i, source := range []string{seriesHashSourcePrecomputed, seriesHashSourceCache, seriesHashSourceComputed} {
+		if h.counts[i] > 0 {
+			h.hashes.WithLabelValues(source).Add(float64(h.counts[i]))
+		}
+	}
+	h.counts = [3]int{}
+}
+
+// precomputedHashesApply returns true if the precomputed hashes of the series of the block are the hashes of the
+// series with the external labels, once the labels are removed from the series.
+func (b *bucketBlock) precomputedHashesApply(extLset labels.Labels, extLsetToRemove map[string]struct{}) bool {
+	if !slices.Equal(labelNames(extLset), b.seriesHashes.ExtLabelNames) {
+		return false
+	}
+	if len(extLsetToRemove) == 0 {
+		return true
+	}
+	// Labels are removed from the series too, so none of the series may have them.
+	names, err := b.indexHeaderReader.LabelNames()
+	if err != nil {
+		return false
+	}
+	for _, n := range names {
+		if _, ok := extLsetToRemove[n]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+func labelNames(lset labels.Labels) []string {
+	names := make([]string, 0, lset.Len())
+	lset.Range(func(l labels.Label) { names = append(names, l.Name) })
+	return names
+}
+
+// readSeriesHashes returns the series hashes of the block, or nil if the block has none.
+func readSeriesHashes(ctx context.Context, logger log.Logger, bkt objstore.BucketReader, id ulid.ULID) (*block.SeriesHashes, error) {
+	r, err := bkt.Get(ctx, path.Join(id.String(), block.SeriesHashesFilename))
+	if bkt.IsObjNotFoundErr(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get series hashes")
+	}
+	defer runutil.CloseWithLogOnErr(logger, r, "series hashes reader")
+
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read series hashes")
+	}
+	return block.DecodeSeriesHashes(buf)
+}
@@ -0,0 +1,157 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/providers/filesystem"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	storecache "github.com/thanos-io/thanos/pkg/store/cache"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestBucketStore_SeriesHashes(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	logger := log.NewNopLogger()
+
+	var series []labels.Labels
+	for i := range 50 {
+		series = append(series, labels.FromStrings("__name__", "up", "pod", fmt.Sprintf("pod-%d", i), "job", fmt.Sprintf("job-%d", i%5)))
+	}
+	extLset := labels.FromStrings("ext1", "1", "replica", "a")
+	id, err := e2eutil.CreateBlock(ctx, tmpDir, series, 10, 0, 1000, extLset, 0, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+
+	// The compactor leaves the replica label out of the series hashes, as queriers deduplicate by it.
+	h, err := block.BuildSeriesHashes(ctx, filepath.Join(tmpDir, id.String(), block.IndexFilename), labels.FromStrings("ext1", "1"))
+	testutil.Ok(t, err)
+
+	bkt, err := filesystem.NewBucket(filepath.Join(tmpDir, "bucket"))
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, bkt.Close()) }()
+	testutil.Ok(t, block.Upload(ctx, logger, bkt, filepath.Join(tmpDir, id.String()), metadata.NoneFunc))
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), block.SeriesHashesFilename), bytes.NewReader(h.Encode())))
+
+	instrBkt := objstore.WithNoopInstr(bkt)
+	fetcher, err := block.NewMetaFetcher(logger, 10, instrBkt, block.NewConcurrentLister(logger, instrBkt), tmpDir, nil, nil)
+	testutil.Ok(t, err)
+	indexCache, err := storecache.NewInMemoryIndexCacheWithConfig(logger, nil, nil, storecache.InMemoryIndexCacheConfig{})
+	testutil.Ok(t, err)
+
+	reg := prometheus.NewRegistry()
+	cache := NewSeriesHashCache(nil, 1000)
+	store, err := NewBucketStore(
+		instrBkt,
+		fetcher,
+		filepath.Join(tmpDir, "store"),
+		NewChunksLimiterFactory(0),
+		NewSeriesLimiterFactory(0),
+		NewBytesLimiterFactory(0),
+		NewGapBasedPartitioner(PartitionerMaxGapSize),
+		10,
+		false,
+		DefaultPostingOffsetInMemorySampling,
+		true,
+		false,
+		0,
+		WithLogger(logger),
+		WithRegistry(reg),
+		WithIndexCache(indexCache),
+		WithSeriesHashCache(cache),
+		WithPrecomputedSeriesHashes(true),
+	)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, store.Close()) }()
+	testutil.Ok(t, store.SyncBlocks(ctx))
+
+	// shardedSeries returns the number of series of all the shards, and checks that no series is in two shards.
+	shardedSeries := func(shardInfo storepb.ShardInfo, withoutReplicaLabels []string) int {
+		seen := map[string]struct{}{}
+		for i := int64(0); i < shardInfo.TotalShards; i++ {
+			shardInfo.ShardIndex = i
+			srv := newStoreSeriesServer(ctx)
+			testutil.Ok(t, store.Series(&storepb.SeriesRequest{
+				MinTime:              math.MinInt64,
+				MaxTime:              math.MaxInt64,
+				Matchers:             []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"}},
+				ShardInfo:            &shardInfo,
+				WithoutReplicaLabels: withoutReplicaLabels,
+				SkipChunks:           true,
+			}, srv))
+			for _, s := range srv.SeriesSet {
+				lset := s.PromLabels()
+				m := shardInfo.Matcher(&store.buffers)
+				testutil.Assert(t, m.MatchesLabels(lset), "series %s not in shard %d", lset, i)
+				m.Close()
+				_, ok := seen[lset.String()]
+				testutil.Assert(t, !ok, "series %s in two shards", lset)
+				seen[lset.String()] = struct{}{}
+			}
+		}
+		return len(seen)
+	}
+	hashes := func(source string) float64 {
+		return promtest.ToFloat64(store.metrics.seriesHashes.WithLabelValues(source))
+	}
+
+	// Sharding by all the labels of deduplicated series uses the precomputed hashes.
+	testutil.Equals(t, 50, shardedSeries(storepb.ShardInfo{TotalShards: 3}, []string{"replica"}))
+	testutil.Equals(t, 150.0, hashes(seriesHashSourcePrecomputed))
+	testutil.Equals(t, 0.0, hashes(seriesHashSourceComputed))
+
+	// The precomputed hashes do not include the replica label, so the other hashes are computed by the first shard, and
+	// then cached for the other shards and requests with the same sharding labels.
+	testutil.Equals(t, 50, shardedSeries(storepb.ShardInfo{TotalShards: 3}, nil))
+	testutil.Equals(t, 50.0, hashes(seriesHashSourceComputed))
+	testutil.Equals(t, 100.0, hashes(seriesHashSourceCache))
+	testutil.Equals(t, 50, shardedSeries(storepb.ShardInfo{TotalShards: 2}, nil))
+	testutil.Equals(t, 200.0, hashes(seriesHashSourceCache))
+	testutil.Equals(t, 50, shardedSeries(storepb.ShardInfo{TotalShards: 2, By: true, Labels: []string{"job"}}, nil))
+	testutil.Equals(t, 100.0, hashes(seriesHashSourceComputed))
+	testutil.Equals(t, uint64(100), cache.items.Load())
+
+	// Hashes of unloaded blocks are dropped.
+	testutil.Ok(t, store.removeBlock(id))
+	testutil.Equals(t, uint64(0), cache.items.Load())
+}
+
+func TestSeriesHashCache_MaxItems(t *testing.T) {
+	c := NewSeriesHashCache(nil, 2)
+	id := ulid.MustNew(1, nil)
+	bc := c.blockCache(id, storepb.AllLabelsShardKey)
+	bc.store(1, 10)
+	bc.store(1, 10)
+	bc.store(2, 20)
+	bc.store(3, 30)
+	testutil.Equals(t, uint64(2), c.items.Load())
+
+	h, ok := bc.fetch(2)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, uint64(20), h)
+	_, ok = bc.fetch(3)
+	testutil.Assert(t, !ok)
+
+	c.dropBlock(id)
+	testutil.Equals(t, uint64(0), c.items.Load())
+	bc.store(3, 30)
+	testutil.Equals(t, uint64(0), c.items.Load())
+}
@@ -504,6 +504,8 @@ type SeriesStatsCounter struct {
 	Series  int
 	Chunks  int
 	Samples int
+	// Bytes is the size of the series responses.
+	Bytes int
 }
 
 func (c *SeriesStatsCounter) CountSeries(seriesLabels []labelpb.ZLabel) {
@@ -516,6 +518,7 @@ func (c *SeriesStatsCounter) CountSeries(seriesLabels []labelpb.ZLabel) {
 
 func (c *SeriesStatsCounter) Count(series *Series) {
 	c.CountSeries(series.Labels)
+	c.Bytes += series.Size()
 	for _, chk := range series.Chunks {
 		if chk.Raw != nil {
 			c.Chunks++
@@ -672,3 +672,23 @@ func TestSeriesRequestToPromQL(t *testing.T) {
 		})
 	}
 }
+
+func TestQueryHints_DownsampledAggr(t *testing.T) {
+	for _, tc := range []struct {
+		hints *QueryHints
+		aggr  Aggr
+		ok    bool
+	}{
+		{hints: nil},
+		{hints: &QueryHints{}},
+		{hints: &QueryHints{Func: &Func{Name: "max_over_time"}}, aggr: Aggr_MAX, ok: true},
+		{hints: &QueryHints{Func: &Func{Name: "min_over_time"}}, aggr: Aggr_MIN, ok: true},
+		{hints: &QueryHints{Func: &Func{Name: "count_over_time"}}, aggr: Aggr_COUNT, ok: true},
+		{hints: &QueryHints{Func: &Func{Name: "avg_over_time"}}},
+		{hints: &QueryHints{Func: &Func{Name: "max"}}},
+	} {
+		aggr, ok := tc.hints.DownsampledAggr()
+		testutil.Equals(t, tc.ok, ok)
+		testutil.Equals(t, tc.aggr, aggr)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewSeriesStreamServer returns a SeriesStream server answering the Series requests with the store server. It sends
+// no more than the window granted by the client and batches the series into frames of up to the requested size.
+func NewSeriesStreamServer(srv StoreServer) SeriesStreamServer {
+	return &seriesStreamServer{srv: srv}
+}
+
+type seriesStreamServer struct {
+	srv StoreServer
+}
+
+func (s *seriesStreamServer) Series(stream SeriesStream_SeriesServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if first.Request == nil {
+		return status.Error(codes.InvalidArgument, "the first message of the stream has no request")
+	}
+
+	var window *sendWindow
+	if first.WindowBytes > 0 {
+		window = newSendWindow(int64(first.WindowBytes))
+		go func() {
+			for {
+				msg, err := stream.Recv()
+				if err != nil {
+					if err == io.EOF {
+						err = errors.New("the client closed the stream without granting more window")
+					}
+					window.close(err)
+					return
+				}
+				window.grant(int64(msg.WindowBytes))
+			}
+		}()
+	}
+
+	srv := &flowControlledSeriesServer{
+		ServerStream:  stream,
+		stream:        stream,
+		window:        window,
+		maxBatchBytes: int(first.MaxBatchBytes),
+	}
+	if err := s.srv.Series(first.Request, srv); err != nil {
+		return err
+	}
+	return srv.flush()
+}
+
+// flowControlledSeriesServer is the server passed to the store server. It batches the series and waits for the
+// window before sending each frame.
+type flowControlledSeriesServer struct {
+	grpc.ServerStream
+
+	stream        SeriesStream_SeriesServer
+	window        *sendWindow
+	maxBatchBytes int
+
+	batch      []Series
+	batchBytes int
+}
+
+func (s *flowControlledSeriesServer) Send(resp *SeriesResponse) error {
+	series := resp.GetSeries()
+	if series == nil || s.maxBatchBytes <= 0 {
+		if err := s.flush(); err != nil {
+			return err
+		}
+		return s.send(resp)
+	}
+
+	// Stores may reuse the memory of the series once they are sent, so batched series are copied.
+	b, err := series.Marshal()
+	if err != nil {
+		return errors.Wrap(err, "marshal series")
+	}
+	var c Series
+	if err := c.Unmarshal(b); err != nil {
+		return errors.Wrap(err, "unmarshal series")
+	}
+	s.batch = append(s.batch, c)
+	s.batchBytes += len(b)
+	if s.batchBytes < s.maxBatchBytes {
+		return nil
+	}
+	return s.flush()
+}
+
+// flush sends the batched series, if any.
+func (s *flowControlledSeriesServer) flush() error {
+	if len(s.batch) == 0 {
+		return nil
+	}
+	resp := &SeriesResponse{Result: &SeriesResponse_Batch{Batch: &SeriesBatch{Series: s.batch}}}
+	s.batch, s.batchBytes = nil, 0
+	return s.send(resp)
+}
+
+func (s *flowControlledSeriesServer) send(resp *SeriesResponse) error {
+	if s.window != nil {
+		if err := s.window.acquire(s.stream.Context(), int64(resp.Size())); err != nil {
+			return err
+		}
+	}
+	return s.stream.Send(resp)
+}
+
+// sendWindow is the size of the responses a server may still send. A frame is sent as long as the window is
+// positive, even if it is bigger than the window, so the window may become negative.
+type sendWindow struct {
+	mtx     sync.Mutex
+	size    int64
+	err     error
+	granted chan struct{}
+}
+
+func newSendWindow(size int64) *sendWindow {
+	return &sendWindow{size: size, granted: make(chan struct{}, 1)}
+}
+
+// grant adds the size to the window.
+func (w *sendWindow) grant(size int64) {
+	w.mtx.Lock()
+	w.size += size
+	w.mtx.Unlock()
+	w.signal()
+}
+
+// close makes acquire fail with the error once the window is used up, as no more window will be granted.
+func (w *sendWindow) close(err error) {
+	w.mtx.Lock()
+	w.err = err
+	w.mtx.Unlock()
+	w.signal()
+}
+
+func (w *sendWindow) signal() {
+	select {
+	case w.granted <- struct{}{}:
+	default:
+	}
+}
+
+// acquire waits until the window is positive and takes the size out of it.
+func (w *sendWindow) acquire(ctx context.Context, size int64) error {
+	for {
+		w.mtx.Lock()
+		if w.size > 0 {
+			w.size -= size
+			w.mtx.Unlock()
+			return nil
+		}
+		err := w.err
+		w.mtx.Unlock()
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.granted:
+		}
+	}
+}
+
+// NewFlowControlledStoreClient returns a store client calling Series through the SeriesStream API, granting the
+// server a window of windowBytes and requesting series batched into frames of up to maxBatchBytes. A window of 0
+// disables the flow control and a batch size of 0 disables the batching. Series falls back to the Store API for
+// servers without the SeriesStream API.
+func NewFlowControlledStoreClient(cc *grpc.ClientConn, windowBytes, maxBatchBytes int) StoreClient {
+	return &flowControlledStoreClient{
+		StoreClient:   NewStoreClient(cc),
+		seriesStream:  NewSeriesStreamClient(cc),
+		windowBytes:   windowBytes,
+		maxBatchBytes: maxBatchBytes,
+	}
+}
+
+type flowControlledStoreClient struct {
+	StoreClient
+
+	seriesStream  SeriesStreamClient
+	windowBytes   int
+	maxBatchBytes int
+}
+
+func (c *flowControlledStoreClient) Series(ctx context.Context, in *SeriesRequest, opts ...grpc.CallOption) (Store_SeriesClient, error) {
+	stream, err := c.seriesStream.Series(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// A failed send means the stream is over. Its status is returned by Recv.
+	if err := stream.Send(&SeriesStreamRequest{
+		Request:       in,
+		WindowBytes:   uint64(c.windowBytes),
+		MaxBatchBytes: uint64(c.maxBatchBytes),
+	}); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return &flowControlledSeriesClient{
+		ClientStream: stream,
+		stream:       stream,
+		window:       c.windowBytes,
+		fallback: func() (Store_SeriesClient, error) {
+			return c.StoreClient.Series(ctx, in, opts...)
+		},
+	}, nil
+}
+
+// flowControlledSeriesClient unbatches the series of the frames and grants the server the size of the received
+// frames, once they amount to half of the window.
+type flowControlledSeriesClient struct {
+	grpc.ClientStream
+
+	stream   SeriesStream_SeriesClient
+	window   int
+	consumed int
+	batch    []Series
+	received bool
+
+	fallback func() (Store_SeriesClient, error)
+	plain    Store_SeriesClient
+}
+
+func (c *flowControlledSeriesClient) Recv() (*SeriesResponse, error) {
+	for {
+		if c.plain != nil {
+			return c.plain.Recv()
+		}
+		if len(c.batch) > 0 {
+			resp := NewSeriesResponse(&c.batch[0])
+			c.batch = c.batch[1:]
+			return resp, nil
+		}
+
+		resp, err := c.stream.Recv()
+		if err != nil {
+			if c.received || status.Code(err) != codes.Unimplemented {
+				return nil, err
+			}
+			plain, err := c.fallback()
+			if err != nil {
+				return nil, err
+			}
+			c.plain, c.ClientStream = plain, plain
+			continue
+		}
+		c.received = true
+
+		if c.window > 0 {
+			c.consumed += resp.Size()
+			if c.consumed >= c.window/2 {
+				// A failed send means the stream is over. Its status is returned by Recv.
+				if err := c.stream.Send(&SeriesStreamRequest{WindowBytes: uint64(c.consumed)}); err != nil && err != io.EOF {
+					return nil, err
+				}
+				c.consumed = 0
+			}
+		}
+
+		batch := resp.GetBatch()
+		if batch == nil {
+			return resp, nil
+		}
+		c.batch = batch.Series
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+)
+
+func testSeriesResponses(n int) []*SeriesResponse {
+	var resps []*SeriesResponse
+	for i := 0; i < n; i++ {
+		resps = append(resps, NewSeriesResponse(&Series{
+			Labels: []labelpb.ZLabel{{Name: "a", Value: string(rune('a' + i))}},
+			Chunks: []AggrChunk{{MinTime: int64(i), MaxTime: int64(i + 1), Raw: &Chunk{Data: []byte{byte(i)}}}},
+		}))
+		if i == n/2 {
+			resps = append(resps, NewWarnSeriesResponse(errors.New("warning")))
+		}
+	}
+	return resps
+}
+
+func dialTestStoreServer(t *testing.T, srv StoreServer, seriesStream bool) *grpc.ClientConn {
+	t.Helper()
+
+	listener := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	RegisterStoreServer(s, srv)
+	if seriesStream {
+		RegisterSeriesStreamServer(s, NewSeriesStreamServer(srv))
+	}
+	go func() { _ = s.Serve(listener) }()
+	t.Cleanup(s.Stop)
+
+	cc, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, cc.Close()) })
+	return cc
+}
+
+func recvAll(t *testing.T, cl Store_SeriesClient) []*SeriesResponse {
+	t.Helper()
+
+	var resps []*SeriesResponse
+	for {
+		resp, err := cl.Recv()
+		if err == io.EOF {
+			return resps
+		}
+		testutil.Ok(t, err)
+		resps = append(resps, resp)
+	}
+}
+
+func TestFlowControlledStoreClient(t *testing.T) {
+	t.Parallel()
+
+	expected := testSeriesResponses(20)
+	for _, tcase := range []struct {
+		name                       string
+		windowBytes, maxBatchBytes int
+		seriesStream               bool
+	}{
+		{name: "window and batches", windowBytes: 64, maxBatchBytes: 48, seriesStream: true},
+		{name: "window smaller than the frames", windowBytes: 1, maxBatchBytes: 1024, seriesStream: true},
+		{name: "window only", windowBytes: 32, seriesStream: true},
+		{name: "batches only", maxBatchBytes: 48, seriesStream: true},
+		{name: "fallback to the Store API", windowBytes: 64, maxBatchBytes: 48},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			srv := &testStoreServer{series: expected}
+			cc := dialTestStoreServer(t, srv, tcase.seriesStream)
+
+			req := &SeriesRequest{MinTime: 1, MaxTime: 2}
+			cl, err := NewFlowControlledStoreClient(cc, tcase.windowBytes, tcase.maxBatchBytes).Series(context.Background(), req)
+			testutil.Ok(t, err)
+			testutil.Equals(t, expected, recvAll(t, cl))
+			testutil.Equals(t, req, srv.seriesLastReq)
+		})
+	}
+}
+
+func TestFlowControlledStoreClient_Error(t *testing.T) {
+	t.Parallel()
+
+	srv := &testStoreServer{series: testSeriesResponses(10), err: errors.New("store error")}
+	cc := dialTestStoreServer(t, srv, true)
+
+	cl, err := NewFlowControlledStoreClient(cc, 64, 48).Series(context.Background(), &SeriesRequest{})
+	testutil.Ok(t, err)
+	for {
+		_, err = cl.Recv()
+		if err != nil {
+			break
+		}
+	}
+	testutil.NotOk(t, err)
+	testutil.Assert(t, err != io.EOF, "error of the store not returned")
+}
+
+type testSeriesStreamServer struct {
+	grpc.ServerStream
+
+	ctx  context.Context
+	sent []*SeriesResponse
+}
+
+func (s *testSeriesStreamServer) Context() context.Context { return s.ctx }
+
+func (s *testSeriesStreamServer) Send(resp *SeriesResponse) error {
+	s.sent = append(s.sent, resp)
+	return nil
+}
+
+func (s *testSeriesStreamServer) Recv() (*SeriesStreamRequest, error) {
+	return nil, io.EOF
+}
+
+func TestFlowControlledSeriesServer_Batches(t *testing.T) {
+	t.Parallel()
+
+	resps := testSeriesResponses(4)
+	seriesBytes := resps[0].GetSeries().Size()
+
+	stream := &testSeriesStreamServer{ctx: context.Background()}
+	srv := &flowControlledSeriesServer{stream: stream, maxBatchBytes: 2 * seriesBytes}
+	for _, resp := range resps {
+		testutil.Ok(t, srv.Send(resp))
+	}
+	testutil.Ok(t, srv.flush())
+
+	// The warning, after the third series, flushes the batch of the third series first.
+	testutil.Equals(t, 4, len(stream.sent))
+	testutil.Equals(t, []Series{*resps[0].GetSeries(), *resps[1].GetSeries()}, stream.sent[0].GetBatch().Series)
+	testutil.Equals(t, []Series{*resps[2].GetSeries()}, stream.sent[1].GetBatch().Series)
+	testutil.Equals(t, "warning", stream.sent[2].GetWarning())
+	testutil.Equals(t, []Series{*resps[4].GetSeries()}, stream.sent[3].GetBatch().Series)
+
+	// Batched series are copies.
+	resps[0].GetSeries().Chunks[0].Raw.Data[0] = 42
+	testutil.Equals(t, byte(0), stream.sent[0].GetBatch().Series[0].Chunks[0].Raw.Data[0])
+}
+
+func TestSendWindow(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	w := newSendWindow(10)
+
+	// A frame bigger than the window is sent as long as the window is positive.
+	testutil.Ok(t, w.acquire(ctx, 15))
+
+	acquired := make(chan error)
+	go func() { acquired <- w.acquire(ctx, 5) }()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a negative window")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The window stays negative.
+	w.grant(5)
+	select {
+	case <-acquired:
+		t.Fatal("acquired an empty window")
+	case <-time.After(100 * time.Millisecond):
+	}
+	w.grant(1)
+	testutil.Ok(t, <-acquired)
+
+	// Once no more window is granted, acquire fails.
+	w.close(errors.New("closed"))
+	testutil.NotOk(t, w.acquire(ctx, 1))
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	testutil.Equals(t, context.Canceled, newSendWindow(0).acquire(cancelled, 1))
+}
@@ -46,3 +46,21 @@ func (m *Range) toPromQL() string {
 	}
 	return fmt.Sprintf("[%dms]", m.Millis)
 }
+
+// DownsampledAggr returns the aggregate of downsampled chunks the function of the hints can be evaluated from directly,
+// and false if the function has to be evaluated from the samples of other aggregates, like the averages of the count
+// and sum aggregates.
+func (m *QueryHints) DownsampledAggr() (Aggr, bool) {
+	if m == nil || m.Func == nil {
+		return Aggr_RAW, false
+	}
+	switch m.Func.Name {
+	case "max_over_time":
+		return Aggr_MAX, true
+	case "min_over_time":
+		return Aggr_MIN, true
+	case "count_over_time":
+		return Aggr_COUNT, true
+	}
+	return Aggr_RAW, false
+}
@@ -13,6 +13,7 @@ import (
 	_ "github.com/gogo/protobuf/gogoproto"
 	proto "github.com/gogo/protobuf/proto"
 	types "github.com/gogo/protobuf/types"
+	github_com_prometheus_prometheus_prompb_io_prometheus_write_v2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
 	prompb "github.com/thanos-io/thanos/pkg/store/storepb/prompb"
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
@@ -430,6 +431,7 @@ type SeriesResponse struct {
 	//	*SeriesResponse_Series
 	//	*SeriesResponse_Warning
 	//	*SeriesResponse_Hints
+	//	*SeriesResponse_Batch
 	Result isSeriesResponse_Result `protobuf_oneof:"result"`
 }
 
@@ -481,10 +483,14 @@ type SeriesResponse_Warning struct {
 type SeriesResponse_Hints struct {
 	Hints *types.Any `protobuf:"bytes,3,opt,name=hints,proto3,oneof" json:"hints,omitempty"`
 }
+type SeriesResponse_Batch struct {
+	Batch *SeriesBatch `protobuf:"bytes,4,opt,name=batch,proto3,oneof" json:"batch,omitempty"`
+}
 
 func (*SeriesResponse_Series) isSeriesResponse_Result()  {}
 func (*SeriesResponse_Warning) isSeriesResponse_Result() {}
 func (*SeriesResponse_Hints) isSeriesResponse_Result()   {}
+func (*SeriesResponse_Batch) isSeriesResponse_Result()   {}
 
 func (m *SeriesResponse) GetResult() isSeriesResponse_Result {
 	if m != nil {
@@ -514,12 +520,20 @@ func (m *SeriesResponse) GetHints() *types.Any {
 	return nil
 }
 
+func (m *SeriesResponse) GetBatch() *SeriesBatch {
+	if x, ok := m.GetResult().(*SeriesResponse_Batch); ok {
+		return x.Batch
+	}
+	return nil
+}
+
 // XXX_OneofWrappers is for the internal use of the proto package.
 func (*SeriesResponse) XXX_OneofWrappers() []interface{} {
 	return []interface{}{
 		(*SeriesResponse_Series)(nil),
 		(*SeriesResponse_Warning)(nil),
 		(*SeriesResponse_Hints)(nil),
+		(*SeriesResponse_Batch)(nil),
 	}
 }
 
@@ -708,6 +722,132 @@ func (m *LabelValuesResponse) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_LabelValuesResponse proto.InternalMessageInfo
 
+// SeriesBatch is a batch of series sent in a single frame.
+type SeriesBatch struct {
+	Series []Series `protobuf:"bytes,1,rep,name=series,proto3" json:"series"`
+}
+
+func (m *SeriesBatch) Reset()         { *m = SeriesBatch{} }
+func (m *SeriesBatch) String() string { return proto.CompactTextString(m) }
+func (*SeriesBatch) ProtoMessage()    {}
+func (*SeriesBatch) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a938d55a388af629, []int{13}
+}
+func (m *SeriesBatch) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *SeriesBatch) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_SeriesBatch.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *SeriesBatch) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SeriesBatch.Merge(m, src)
+}
+func (m *SeriesBatch) XXX_Size() int {
+	return m.Size()
+}
+func (m *SeriesBatch) XXX_DiscardUnknown() {
+	xxx_messageInfo_SeriesBatch.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SeriesBatch proto.InternalMessageInfo
+
+// SeriesStreamRequest is a message of the client of SeriesStream.Series. The first message carries the request,
+// the next ones grant more window to the server.
+type SeriesStreamRequest struct {
+	// request is the Series request. It is only read from the first message.
+	Request *SeriesRequest `protobuf:"bytes,1,opt,name=request,proto3" json:"request,omitempty"`
+	// window_bytes is the size of the responses the server may send in addition to the windows granted so far.
+	// A window of 0 in the first message disables the flow control.
+	WindowBytes uint64 `protobuf:"varint,2,opt,name=window_bytes,json=windowBytes,proto3" json:"window_bytes,omitempty"`
+	// max_batch_bytes is the maximum size of the series batched into a single frame. 0 disables batching.
+	// It is only read from the first message.
+	MaxBatchBytes uint64 `protobuf:"varint,3,opt,name=max_batch_bytes,json=maxBatchBytes,proto3" json:"max_batch_bytes,omitempty"`
+}
+
+func (m *SeriesStreamRequest) Reset()         { *m = SeriesStreamRequest{} }
+func (m *SeriesStreamRequest) String() string { return proto.CompactTextString(m) }
+func (*SeriesStreamRequest) ProtoMessage()    {}
+func (*SeriesStreamRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a938d55a388af629, []int{14}
+}
+func (m *SeriesStreamRequest) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *SeriesStreamRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_SeriesStreamRequest.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *SeriesStreamRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SeriesStreamRequest.Merge(m, src)
+}
+func (m *SeriesStreamRequest) XXX_Size() int {
+	return m.Size()
+}
+func (m *SeriesStreamRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SeriesStreamRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SeriesStreamRequest proto.InternalMessageInfo
+
+// WriteV2Request is a write request with the series of a remote write 2.0 request.
+type WriteV2Request struct {
+	// request is an encoded io.prometheus.write.v2.Request, whose label names and values are interned into
+	// a single symbol table.
+	Request github_com_prometheus_prometheus_prompb_io_prometheus_write_v2.Request `protobuf:"bytes,1,opt,name=request,proto3,customtype=github.com/prometheus/prometheus/prompb/io/prometheus/write/v2.Request" json:"request"`
+	Tenant  string                                                                 `protobuf:"bytes,2,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	Replica int64                                                                  `protobuf:"varint,3,opt,name=replica,proto3" json:"replica,omitempty"`
+}
+
+func (m *WriteV2Request) Reset()         { *m = WriteV2Request{} }
+func (m *WriteV2Request) String() string { return proto.CompactTextString(m) }
+func (*WriteV2Request) ProtoMessage()    {}
+func (*WriteV2Request) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a938d55a388af629, []int{15}
+}
+func (m *WriteV2Request) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *WriteV2Request) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_WriteV2Request.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *WriteV2Request) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WriteV2Request.Merge(m, src)
+}
+func (m *WriteV2Request) XXX_Size() int {
+	return m.Size()
+}
+func (m *WriteV2Request) XXX_DiscardUnknown() {
+	xxx_messageInfo_WriteV2Request.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WriteV2Request proto.InternalMessageInfo
+
 func init() {
 	proto.RegisterEnum("thanos.Aggr", Aggr_name, Aggr_value)
 	proto.RegisterType((*WriteResponse)(nil), "thanos.WriteResponse")
@@ -723,84 +863,99 @@ func init() {
 	proto.RegisterType((*LabelNamesResponse)(nil), "thanos.LabelNamesResponse")
 	proto.RegisterType((*LabelValuesRequest)(nil), "thanos.LabelValuesRequest")
 	proto.RegisterType((*LabelValuesResponse)(nil), "thanos.LabelValuesResponse")
+	proto.RegisterType((*SeriesBatch)(nil), "thanos.SeriesBatch")
+	proto.RegisterType((*SeriesStreamRequest)(nil), "thanos.SeriesStreamRequest")
+	proto.RegisterType((*WriteV2Request)(nil), "thanos.WriteV2Request")
 }
 
 func init() { proto.RegisterFile("store/storepb/rpc.proto", fileDescriptor_a938d55a388af629) }
 
 var fileDescriptor_a938d55a388af629 = []byte{
-	// 1149 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x56, 0x4b, 0x6f, 0x23, 0x45,
-	0x10, 0xf6, 0x78, 0x3c, 0x7e, 0x94, 0x13, 0xaf, 0xb7, 0xd7, 0xc9, 0x4e, 0xbc, 0x92, 0x63, 0x8c,
-	0x90, 0xac, 0x55, 0xe4, 0xac, 0xbc, 0x08, 0x09, 0xc4, 0x25, 0x09, 0x2c, 0x59, 0x89, 0x04, 0xe8,
-	0xec, 0x12, 0x04, 0x87, 0x51, 0xdb, 0xee, 0x8c, 0x47, 0x3b, 0xaf, 0x4c, 0xf7, 0x90, 0xf8, 0x0c,
-	0x67, 0xc4, 0x9d, 0xdb, 0xfe, 0x9a, 0xdc, 0xd8, 0x23, 0x27, 0x04, 0xc9, 0x1f, 0x41, 0xfd, 0x18,
-	0x3f, 0x82, 0xf7, 0xa5, 0xe4, 0x62, 0x75, 0x7d, 0x5f, 0x75, 0x4d, 0x75, 0xf5, 0x57, 0xe5, 0x86,
-	0xfb, 0x8c, 0x47, 0x09, 0xdd, 0x96, 0xbf, 0xf1, 0x60, 0x3b, 0x89, 0x87, 0xbd, 0x38, 0x89, 0x78,
-	0x84, 0x8a, 0x7c, 0x4c, 0xc2, 0x88, 0x35, 0x37, 0x16, 0x1d, 0xf8, 0x24, 0xa6, 0x4c, 0xb9, 0x34,
-	0x1b, 0x6e, 0xe4, 0x46, 0x72, 0xb9, 0x2d, 0x56, 0x1a, 0x6d, 0x2f, 0x6e, 0x88, 0x93, 0x28, 0xb8,
-	0xb6, 0x6f, 0xc3, 0x8d, 0x22, 0xd7, 0xa7, 0xdb, 0xd2, 0x1a, 0xa4, 0x27, 0xdb, 0x24, 0x9c, 0x28,
-	0xaa, 0x73, 0x07, 0x56, 0x8f, 0x13, 0x8f, 0x53, 0x4c, 0x59, 0x1c, 0x85, 0x8c, 0x76, 0x7e, 0x31,
-	0x60, 0x45, 0x23, 0xa7, 0x29, 0x65, 0x1c, 0xed, 0x00, 0x70, 0x2f, 0xa0, 0x8c, 0x26, 0x1e, 0x65,
-	0xb6, 0xd1, 0x36, 0xbb, 0xd5, 0xfe, 0x03, 0xb1, 0x3b, 0xa0, 0x7c, 0x4c, 0x53, 0xe6, 0x0c, 0xa3,
-	0x78, 0xd2, 0x7b, 0xe6, 0x05, 0xf4, 0x48, 0xba, 0xec, 0x16, 0x2e, 0xfe, 0xde, 0xcc, 0xe1, 0xb9,
-	0x4d, 0x68, 0x1d, 0x8a, 0x9c, 0x86, 0x24, 0xe4, 0x76, 0xbe, 0x6d, 0x74, 0x2b, 0x58, 0x5b, 0xc8,
-	0x86, 0x52, 0x42, 0x63, 0xdf, 0x1b, 0x12, 0xdb, 0x6c, 0x1b, 0x5d, 0x13, 0x67, 0x66, 0xe7, 0xa5,
-	0x05, 0xab, 0x2a, 0x5c, 0x96, 0xc6, 0x06, 0x94, 0x03, 0x2f, 0x74, 0x44, 0x54, 0xdb, 0x50, 0xce,
-	0x81, 0x17, 0x8a, 0xcf, 0x4a, 0x8a, 0x9c, 0x2b, 0x2a, 0xaf, 0x29, 0x72, 0x2e, 0xa9, 0x4f, 0x04,
-	0xc5, 0x87, 0x63, 0x9a, 0x30, 0xdb, 0x94, 0xa9, 0x37, 0x7a, 0xaa, 0xce, 0xbd, 0xaf, 0xc9, 0x80,
-	0xfa, 0x07, 0x8a, 0xd4, 0x39, 0x4f, 0x7d, 0x51, 0x1f, 0xd6, 0x44, 0xc8, 0x84, 0xb2, 0xc8, 0x4f,
-	0xb9, 0x17, 0x85, 0xce, 0x99, 0x17, 0x8e, 0xa2, 0x33, 0xbb, 0x20, 0xe3, 0xdf, 0x0b, 0xc8, 0x39,
-	0x9e, 0x72, 0xc7, 0x92, 0x42, 0x5b, 0x00, 0xc4, 0x75, 0x13, 0xea, 0x12, 0x4e, 0x99, 0x6d, 0xb5,
-	0xcd, 0x6e, 0xad, 0xbf, 0x92, 0x7d, 0x6d, 0xc7, 0x75, 0x13, 0x3c, 0xc7, 0xa3, 0xcf, 0x60, 0x23,
-	0x26, 0x09, 0xf7, 0x88, 0x2f, 0xbe, 0x22, 0x6b, 0xef, 0x8c, 0x3c, 0x46, 0x06, 0x3e, 0x1d, 0xd9,
-	0xc5, 0xb6, 0xd1, 0x2d, 0xe3, 0xfb, 0xda, 0x21, 0xbb, 0x9b, 0x2f, 0x34, 0x8d, 0x7e, 0x5a, 0xb2,
-	0x97, 0xf1, 0x84, 0x70, 0xea, 0x4e, 0xec, 0x52, 0xdb, 0xe8, 0xd6, 0xfa, 0x9b, 0xd9, 0x87, 0xbf,
-	0x5d, 0x8c, 0x71, 0xa4, 0xdd, 0xfe, 0x17, 0x3c, 0x23, 0xd0, 0x26, 0x54, 0xd9, 0x0b, 0x2f, 0x76,
-	0x86, 0xe3, 0x34, 0x7c, 0xc1, 0xec, 0xb2, 0x4c, 0x05, 0x04, 0xb4, 0x27, 0x11, 0xf4, 0x10, 0xac,
-	0xb1, 0x17, 0x72, 0x66, 0x57, 0xda, 0x86, 0x2c, 0xa8, 0x52, 0x57, 0x2f, 0x53, 0x57, 0x6f, 0x27,
-	0x9c, 0x60, 0xe5, 0x82, 0x10, 0x14, 0x18, 0xa7, 0xb1, 0x0d, 0xb2, 0x6c, 0x72, 0x8d, 0x1a, 0x60,
-	0x25, 0x24, 0x74, 0xa9, 0x5d, 0x95, 0xa0, 0x32, 0xd0, 0x63, 0xa8, 0x9e, 0xa6, 0x34, 0x99, 0x38,
-	0x2a, 0xf6, 0x8a, 0x8c, 0x8d, 0xb2, 0x53, 0x7c, 0x27, 0xa8, 0x7d, 0xc1, 0x60, 0x38, 0x9d, 0xae,
-	0xd1, 0x23, 0x00, 0x36, 0x26, 0xc9, 0xc8, 0xf1, 0xc2, 0x93, 0xc8, 0x5e, 0x95, 0x7b, 0xee, 0x66,
-	0x7b, 0x8e, 0x04, 0xf3, 0x34, 0x3c, 0x89, 0x70, 0x85, 0x65, 0x4b, 0xf4, 0x31, 0xac, 0x9f, 0x79,
-	0x7c, 0x1c, 0xa5, 0xdc, 0xd1, 0x5a, 0x73, 0x7c, 0x21, 0x04, 0x66, 0xd7, 0xda, 0x66, 0xb7, 0x82,
-	0x1b, 0x9a, 0xc5, 0x8a, 0x94, 0x22, 0x61, 0x22, 0x65, 0xdf, 0x0b, 0x3c, 0x6e, 0xdf, 0x51, 0x29,
-	0x4b, 0xa3, 0xf3, 0xd2, 0x00, 0x98, 0x25, 0x26, 0x0b, 0xc7, 0x69, 0xec, 0x04, 0x9e, 0xef, 0x7b,
-	0x4c, 0x8b, 0x14, 0x04, 0x74, 0x20, 0x11, 0xd4, 0x86, 0xc2, 0x49, 0x1a, 0x0e, 0xa5, 0x46, 0xab,
-	0x33, 0x69, 0x3c, 0x49, 0xc3, 0x21, 0x96, 0x0c, 0xda, 0x82, 0xb2, 0x9b, 0x44, 0x69, 0xec, 0x85,
-	0xae, 0x54, 0x5a, 0xb5, 0x5f, 0xcf, 0xbc, 0xbe, 0xd2, 0x38, 0x9e, 0x7a, 0xa0, 0x0f, 0xb3, 0x42,
-	0x5a, 0xd2, 0x75, 0x35, 0x73, 0xc5, 0x02, 0xd4, 0x75, 0xed, 0x9c, 0x41, 0x65, 0x5a, 0x08, 0x99,
-	0xa2, 0xae, 0xd7, 0x88, 0x9e, 0x4f, 0x53, 0x54, 0xfc, 0x88, 0x9e, 0xa3, 0x0f, 0x60, 0x85, 0x47,
-	0x9c, 0xf8, 0x8e, 0xc4, 0x98, 0x6e, 0xa7, 0xaa, 0xc4, 0x64, 0x18, 0x86, 0x6a, 0x90, 0x1f, 0x4c,
-	0x64, 0xbf, 0x96, 0x71, 0x7e, 0x30, 0x11, 0xcd, 0xad, 0x2b, 0x58, 0x90, 0x15, 0xd4, 0x56, 0xa7,
-	0x09, 0x05, 0x71, 0x32, 0x21, 0x81, 0x90, 0xe8, 0xa6, 0xad, 0x60, 0xb9, 0xee, 0xf4, 0xa1, 0x9c,
-	0x9d, 0x47, 0xc7, 0x33, 0x96, 0xc4, 0x33, 0x17, 0xe2, 0x6d, 0x82, 0x25, 0x0f, 0x26, 0x1c, 0x16,
-	0x4a, 0xac, 0xad, 0xce, 0x6f, 0x06, 0xd4, 0xb2, 0x99, 0xa1, 0x34, 0x8d, 0xba, 0x50, 0x9c, 0xce,
-	0x2d, 0x51, 0xa2, 0xda, 0x54, 0x1b, 0x12, 0xdd, 0xcf, 0x61, 0xcd, 0xa3, 0x26, 0x94, 0xce, 0x48,
-	0x12, 0x8a, 0xc2, 0xcb, 0x19, 0xb5, 0x9f, 0xc3, 0x19, 0x80, 0xb6, 0x32, 0xc1, 0x9b, 0xaf, 0x17,
-	0xfc, 0x7e, 0x4e, 0x4b, 0x7e, 0xb7, 0x0c, 0xc5, 0x84, 0xb2, 0xd4, 0xe7, 0x9d, 0x5f, 0x4d, 0xb8,
-	0x2b, 0x05, 0x74, 0x48, 0x82, 0xd9, 0x20, 0x7b, 0x63, 0xe3, 0x1b, 0x37, 0x68, 0xfc, 0xfc, 0x0d,
-	0x1b, 0xbf, 0x01, 0x16, 0xe3, 0x24, 0xe1, 0x7a, 0x16, 0x2b, 0x03, 0xd5, 0xc1, 0xa4, 0xe1, 0x48,
-	0xcf, 0x3d, 0xb1, 0x9c, 0xf5, 0xbf, 0xf5, 0xf6, 0xfe, 0x9f, 0x9f, 0xbf, 0xc5, 0xf7, 0x98, 0xbf,
-	0xaf, 0x6f, 0xd3, 0xd2, 0xbb, 0xb4, 0x69, 0x79, 0xbe, 0x4d, 0x13, 0x40, 0xf3, 0xb7, 0xa0, 0xa5,
-	0xd1, 0x00, 0x4b, 0x48, 0x51, 0xfd, 0xa3, 0x55, 0xb0, 0x32, 0x50, 0x13, 0xca, 0xfa, 0xd6, 0x85,
-	0xf6, 0x05, 0x31, 0xb5, 0x67, 0xe7, 0x36, 0xdf, 0x7a, 0xee, 0xce, 0x1f, 0xa6, 0xfe, 0xe8, 0xf7,
-	0xc4, 0x4f, 0x67, 0x77, 0x2f, 0x12, 0x14, 0xa8, 0x6e, 0x06, 0x65, 0xbc, 0x59, 0x11, 0xf9, 0x1b,
-	0x28, 0xc2, 0xbc, 0x2d, 0x45, 0x14, 0x96, 0x28, 0xc2, 0x5a, 0xa2, 0x88, 0xe2, 0xfb, 0x29, 0xa2,
-	0x74, 0x2b, 0x8a, 0x28, 0xbf, 0x8b, 0x22, 0x2a, 0xf3, 0x8a, 0x48, 0xe1, 0xde, 0xc2, 0xe5, 0x68,
-	0x49, 0xac, 0x43, 0xf1, 0x67, 0x89, 0x68, 0x4d, 0x68, 0xeb, 0xb6, 0x44, 0xf1, 0x70, 0x17, 0x0a,
-	0xe2, 0x19, 0x80, 0x4a, 0x60, 0xe2, 0x9d, 0xe3, 0x7a, 0x0e, 0x55, 0xc0, 0xda, 0xfb, 0xe6, 0xf9,
-	0xe1, 0xb3, 0xba, 0x21, 0xb0, 0xa3, 0xe7, 0x07, 0xf5, 0xbc, 0x58, 0x1c, 0x3c, 0x3d, 0xac, 0x9b,
-	0x72, 0xb1, 0xf3, 0x43, 0xbd, 0x80, 0xaa, 0x50, 0x92, 0x5e, 0x5f, 0xe2, 0xba, 0xd5, 0xff, 0xd3,
-	0x00, 0xeb, 0x48, 0xbc, 0xf4, 0xd0, 0xa7, 0x50, 0x54, 0x53, 0x0c, 0xad, 0x2d, 0x4e, 0x35, 0x2d,
-	0xb6, 0xe6, 0xfa, 0x75, 0x58, 0x1d, 0xf3, 0x91, 0x81, 0xf6, 0x00, 0x66, 0x1d, 0x81, 0x36, 0x16,
-	0xea, 0x3f, 0x3f, 0xab, 0x9a, 0xcd, 0x65, 0x94, 0xae, 0xd6, 0x13, 0xa8, 0xce, 0x15, 0x11, 0x2d,
-	0xba, 0x2e, 0xc8, 0xbe, 0xf9, 0x60, 0x29, 0xa7, 0xe2, 0xf4, 0x0f, 0xa1, 0x26, 0xdf, 0x9b, 0x42,
-	0xcf, 0xea, 0x64, 0x9f, 0x43, 0x15, 0xd3, 0x20, 0xe2, 0x54, 0xe2, 0x68, 0xaa, 0x8f, 0xf9, 0x67,
-	0x69, 0x73, 0xed, 0x1a, 0xaa, 0x9f, 0xaf, 0xb9, 0xdd, 0x8f, 0x2e, 0xfe, 0x6d, 0xe5, 0x2e, 0x2e,
-	0x5b, 0xc6, 0xab, 0xcb, 0x96, 0xf1, 0xcf, 0x65, 0xcb, 0xf8, 0xfd, 0xaa, 0x95, 0x7b, 0x75, 0xd5,
-	0xca, 0xfd, 0x75, 0xd5, 0xca, 0xfd, 0x58, 0xd2, 0xcf, 0xe4, 0x41, 0x51, 0xde, 0xd0, 0xe3, 0xff,
-	0x02, 0x00, 0x00, 0xff, 0xff, 0x84, 0xe1, 0x09, 0x34, 0x90, 0x0b, 0x00, 0x00,
+	// 1341 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x57, 0x4b, 0x6f, 0xdb, 0x46,
+	0x10, 0x16, 0x45, 0x51, 0x8f, 0x91, 0xad, 0x28, 0x6b, 0xc7, 0xa1, 0x15, 0x40, 0x56, 0x55, 0xb4,
+	0x10, 0x52, 0x43, 0x0a, 0x98, 0xa2, 0x40, 0x1f, 0x17, 0x2b, 0x6d, 0xea, 0x00, 0xb5, 0x9b, 0xd0,
+	0x89, 0x53, 0xb4, 0x07, 0x82, 0x92, 0xd6, 0x14, 0x11, 0xbe, 0xc2, 0x5d, 0xc6, 0xd2, 0xb9, 0xfd,
+	0x01, 0xed, 0xb9, 0xb7, 0x9c, 0xfb, 0x0f, 0xfa, 0x07, 0x72, 0x6b, 0x8e, 0x45, 0x0f, 0x41, 0x9b,
+	0xfc, 0x91, 0x62, 0x1f, 0x94, 0x48, 0x47, 0x79, 0x21, 0xbe, 0x08, 0x3b, 0xf3, 0x7d, 0x3b, 0x9c,
+	0x1d, 0x7e, 0x33, 0x5a, 0xc2, 0x65, 0x42, 0xc3, 0x18, 0x0f, 0xf8, 0x6f, 0x34, 0x1a, 0xc4, 0xd1,
+	0xb8, 0x1f, 0xc5, 0x21, 0x0d, 0x51, 0x99, 0x4e, 0xed, 0x20, 0x24, 0xad, 0xed, 0x3c, 0x81, 0xce,
+	0x23, 0x4c, 0x04, 0xa5, 0xb5, 0xe9, 0x84, 0x4e, 0xc8, 0x97, 0x03, 0xb6, 0x92, 0xde, 0x4e, 0x7e,
+	0x43, 0x14, 0x87, 0xfe, 0x99, 0x7d, 0xdb, 0x4e, 0x18, 0x3a, 0x1e, 0x1e, 0x70, 0x6b, 0x94, 0x9c,
+	0x0c, 0xec, 0x60, 0x2e, 0xa0, 0xee, 0x05, 0x58, 0xbf, 0x1f, 0xbb, 0x14, 0x9b, 0x98, 0x44, 0x61,
+	0x40, 0x70, 0xf7, 0x67, 0x05, 0xd6, 0xa4, 0xe7, 0x61, 0x82, 0x09, 0x45, 0x7b, 0x00, 0xd4, 0xf5,
+	0x31, 0xc1, 0xb1, 0x8b, 0x89, 0xae, 0x74, 0xd4, 0x5e, 0xdd, 0xb8, 0xc2, 0x76, 0xfb, 0x98, 0x4e,
+	0x71, 0x42, 0xac, 0x71, 0x18, 0xcd, 0xfb, 0x77, 0x5d, 0x1f, 0x1f, 0x71, 0xca, 0xb0, 0xf4, 0xe4,
+	0xd9, 0x4e, 0xc1, 0xcc, 0x6c, 0x42, 0x5b, 0x50, 0xa6, 0x38, 0xb0, 0x03, 0xaa, 0x17, 0x3b, 0x4a,
+	0xaf, 0x66, 0x4a, 0x0b, 0xe9, 0x50, 0x89, 0x71, 0xe4, 0xb9, 0x63, 0x5b, 0x57, 0x3b, 0x4a, 0x4f,
+	0x35, 0x53, 0xb3, 0xfb, 0x58, 0x83, 0x75, 0x11, 0x2e, 0x4d, 0x63, 0x1b, 0xaa, 0xbe, 0x1b, 0x58,
+	0x2c, 0xaa, 0xae, 0x08, 0xb2, 0xef, 0x06, 0xec, 0xb1, 0x1c, 0xb2, 0x67, 0x02, 0x2a, 0x4a, 0xc8,
+	0x9e, 0x71, 0xe8, 0x33, 0x06, 0xd1, 0xf1, 0x14, 0xc7, 0x44, 0x57, 0x79, 0xea, 0x9b, 0x7d, 0x51,
+	0xe7, 0xfe, 0x77, 0xf6, 0x08, 0x7b, 0x07, 0x02, 0x94, 0x39, 0x2f, 0xb8, 0xc8, 0x80, 0x4b, 0x2c,
+	0x64, 0x8c, 0x49, 0xe8, 0x25, 0xd4, 0x0d, 0x03, 0xeb, 0xd4, 0x0d, 0x26, 0xe1, 0xa9, 0x5e, 0xe2,
+	0xf1, 0x37, 0x7c, 0x7b, 0x66, 0x2e, 0xb0, 0xfb, 0x1c, 0x42, 0xbb, 0x00, 0xb6, 0xe3, 0xc4, 0xd8,
+	0xb1, 0x29, 0x26, 0xba, 0xd6, 0x51, 0x7b, 0x0d, 0x63, 0x2d, 0x7d, 0xda, 0x9e, 0xe3, 0xc4, 0x66,
+	0x06, 0x47, 0x5f, 0xc0, 0x76, 0x64, 0xc7, 0xd4, 0xb5, 0x3d, 0x2b, 0x96, 0xb5, 0xb7, 0x26, 0x2e,
+	0xb1, 0x47, 0x1e, 0x9e, 0xe8, 0xe5, 0x8e, 0xd2, 0xab, 0x9a, 0x97, 0x25, 0x21, 0x7d, 0x37, 0x5f,
+	0x4b, 0x18, 0xfd, 0xb4, 0x62, 0x2f, 0xa1, 0xb1, 0x4d, 0xb1, 0x33, 0xd7, 0x2b, 0x1d, 0xa5, 0xd7,
+	0x30, 0x76, 0xd2, 0x07, 0xdf, 0xce, 0xc7, 0x38, 0x92, 0xb4, 0x97, 0x82, 0xa7, 0x00, 0xda, 0x81,
+	0x3a, 0x79, 0xe0, 0x46, 0xd6, 0x78, 0x9a, 0x04, 0x0f, 0x88, 0x5e, 0xe5, 0xa9, 0x00, 0x73, 0xdd,
+	0xe0, 0x1e, 0x74, 0x15, 0xb4, 0xa9, 0x1b, 0x50, 0xa2, 0xd7, 0x3a, 0x0a, 0x2f, 0xa8, 0x50, 0x57,
+	0x3f, 0x55, 0x57, 0x7f, 0x2f, 0x98, 0x9b, 0x82, 0x82, 0x10, 0x94, 0x08, 0xc5, 0x91, 0x0e, 0xbc,
+	0x6c, 0x7c, 0x8d, 0x36, 0x41, 0x8b, 0xed, 0xc0, 0xc1, 0x7a, 0x9d, 0x3b, 0x85, 0x81, 0xae, 0x43,
+	0xfd, 0x61, 0x82, 0xe3, 0xb9, 0x25, 0x62, 0xaf, 0xf1, 0xd8, 0x28, 0x3d, 0xc5, 0x1d, 0x06, 0xed,
+	0x33, 0xc4, 0x84, 0x87, 0x8b, 0x35, 0xba, 0x06, 0x40, 0xa6, 0x76, 0x3c, 0xb1, 0xdc, 0xe0, 0x24,
+	0xd4, 0xd7, 0xf9, 0x9e, 0x8b, 0xe9, 0x9e, 0x23, 0x86, 0xdc, 0x0a, 0x4e, 0x42, 0xb3, 0x46, 0xd2,
+	0x25, 0xfa, 0x14, 0xb6, 0x4e, 0x5d, 0x3a, 0x0d, 0x13, 0x6a, 0x49, 0xad, 0x59, 0x1e, 0x13, 0x02,
+	0xd1, 0x1b, 0x1d, 0xb5, 0x57, 0x33, 0x37, 0x25, 0x6a, 0x0a, 0x90, 0x8b, 0x84, 0xb0, 0x94, 0x3d,
+	0xd7, 0x77, 0xa9, 0x7e, 0x41, 0xa4, 0xcc, 0x8d, 0xee, 0x63, 0x05, 0x60, 0x99, 0x18, 0x2f, 0x1c,
+	0xc5, 0x91, 0xe5, 0xbb, 0x9e, 0xe7, 0x12, 0x29, 0x52, 0x60, 0xae, 0x03, 0xee, 0x41, 0x1d, 0x28,
+	0x9d, 0x24, 0xc1, 0x98, 0x6b, 0xb4, 0xbe, 0x94, 0xc6, 0xcd, 0x24, 0x18, 0x9b, 0x1c, 0x41, 0xbb,
+	0x50, 0x75, 0xe2, 0x30, 0x89, 0xdc, 0xc0, 0xe1, 0x4a, 0xab, 0x1b, 0xcd, 0x94, 0xf5, 0xad, 0xf4,
+	0x9b, 0x0b, 0x06, 0xfa, 0x30, 0x2d, 0xa4, 0xc6, 0xa9, 0xeb, 0x29, 0xd5, 0x64, 0x4e, 0x59, 0xd7,
+	0xee, 0x29, 0xd4, 0x16, 0x85, 0xe0, 0x29, 0xca, 0x7a, 0x4d, 0xf0, 0x6c, 0x91, 0xa2, 0xc0, 0x27,
+	0x78, 0x86, 0x3e, 0x80, 0x35, 0x1a, 0x52, 0xdb, 0xb3, 0xb8, 0x8f, 0xc8, 0x76, 0xaa, 0x73, 0x1f,
+	0x0f, 0x43, 0x50, 0x03, 0x8a, 0xa3, 0x39, 0xef, 0xd7, 0xaa, 0x59, 0x1c, 0xcd, 0x59, 0x73, 0xcb,
+	0x0a, 0x96, 0x78, 0x05, 0xa5, 0xd5, 0x6d, 0x41, 0x89, 0x9d, 0x8c, 0x49, 0x20, 0xb0, 0x65, 0xd3,
+	0xd6, 0x4c, 0xbe, 0xee, 0x1a, 0x50, 0x4d, 0xcf, 0x23, 0xe3, 0x29, 0x2b, 0xe2, 0xa9, 0xb9, 0x78,
+	0x3b, 0xa0, 0xf1, 0x83, 0x31, 0x42, 0xae, 0xc4, 0xd2, 0xea, 0xfe, 0xa9, 0x40, 0x23, 0x9d, 0x19,
+	0x42, 0xd3, 0xa8, 0x07, 0xe5, 0xc5, 0xdc, 0x62, 0x25, 0x6a, 0x2c, 0xb4, 0xc1, 0xbd, 0xfb, 0x05,
+	0x53, 0xe2, 0xa8, 0x05, 0x95, 0x53, 0x3b, 0x0e, 0x58, 0xe1, 0xf9, 0x8c, 0xda, 0x2f, 0x98, 0xa9,
+	0x03, 0xed, 0xa6, 0x82, 0x57, 0x5f, 0x2d, 0xf8, 0xfd, 0x42, 0x2a, 0xf9, 0x4f, 0x40, 0x1b, 0xb1,
+	0x31, 0x22, 0x5f, 0xe0, 0x46, 0xfe, 0x91, 0x43, 0x06, 0x31, 0x32, 0xe7, 0x0c, 0xab, 0x50, 0x8e,
+	0x31, 0x49, 0x3c, 0xda, 0xfd, 0x45, 0x85, 0x8b, 0x5c, 0x6d, 0x87, 0xb6, 0xbf, 0x9c, 0x7a, 0xaf,
+	0x9d, 0x12, 0xca, 0x7b, 0x4c, 0x89, 0xe2, 0x7b, 0x4e, 0x89, 0x4d, 0xd0, 0x08, 0xb5, 0x63, 0x2a,
+	0x07, 0xb7, 0x30, 0x50, 0x13, 0x54, 0x1c, 0x4c, 0xe4, 0x90, 0x64, 0xcb, 0xe5, 0xb0, 0xd0, 0xde,
+	0x3c, 0x2c, 0xb2, 0xc3, 0xba, 0xfc, 0x0e, 0xc3, 0xfa, 0xd5, 0x3d, 0x5d, 0x79, 0x9b, 0x9e, 0xae,
+	0x66, 0x7b, 0x3a, 0x06, 0x94, 0x7d, 0x0b, 0x52, 0x47, 0x9b, 0xa0, 0x31, 0xdd, 0x8a, 0xbf, 0xbf,
+	0x9a, 0x29, 0x0c, 0xd4, 0x82, 0xaa, 0x94, 0x08, 0x6b, 0x14, 0x06, 0x2c, 0xec, 0xe5, 0xb9, 0xd5,
+	0x37, 0x9e, 0xbb, 0xfb, 0xbb, 0x2a, 0x1f, 0x7a, 0x6c, 0x7b, 0xc9, 0xf2, 0xdd, 0xb3, 0x04, 0x99,
+	0x57, 0x76, 0x8e, 0x30, 0x5e, 0xaf, 0x88, 0xe2, 0x7b, 0x28, 0x42, 0x3d, 0x2f, 0x45, 0x94, 0x56,
+	0x28, 0x42, 0x5b, 0xa1, 0x88, 0xf2, 0xbb, 0x29, 0xa2, 0x72, 0x2e, 0x8a, 0xa8, 0xbe, 0x8d, 0x22,
+	0x6a, 0x59, 0x45, 0x24, 0xb0, 0x91, 0x7b, 0x39, 0x52, 0x12, 0x5b, 0x50, 0x7e, 0xc4, 0x3d, 0x52,
+	0x13, 0xd2, 0x3a, 0x37, 0x51, 0x7c, 0x09, 0xf5, 0xcc, 0xc4, 0x40, 0xbb, 0x99, 0x49, 0xa6, 0xbe,
+	0x3c, 0xc9, 0x64, 0x05, 0x24, 0xa7, 0xfb, 0x9b, 0x02, 0x1b, 0x02, 0x38, 0xa2, 0x31, 0xb6, 0xfd,
+	0x54, 0x52, 0x03, 0x76, 0xe1, 0xe2, 0x4b, 0x39, 0x10, 0x2f, 0xe5, 0xc3, 0x48, 0x9e, 0x99, 0xb2,
+	0xd8, 0xff, 0x81, 0xb8, 0xf8, 0x58, 0xa3, 0x39, 0xbb, 0xd5, 0x30, 0x81, 0x95, 0xcc, 0xba, 0xf0,
+	0x0d, 0x99, 0x0b, 0x7d, 0x0c, 0x17, 0xd8, 0x55, 0x89, 0xcf, 0x33, 0xc9, 0x52, 0x39, 0x6b, 0xdd,
+	0xb7, 0x67, 0x3c, 0x79, 0xce, 0xeb, 0xfe, 0xa1, 0x40, 0x83, 0x5f, 0x2c, 0x8f, 0x8d, 0x34, 0x9d,
+	0x69, 0x3e, 0x9d, 0xb5, 0xe1, 0x21, 0x3b, 0xc5, 0x3f, 0xcf, 0x76, 0x6e, 0x3a, 0x2e, 0x9d, 0x26,
+	0xa3, 0xfe, 0x38, 0xf4, 0x07, 0xcb, 0x9b, 0xe6, 0xd9, 0x65, 0x34, 0x1a, 0xb8, 0x61, 0xd6, 0x79,
+	0xca, 0xc2, 0x0f, 0x1e, 0x19, 0xfd, 0x97, 0xce, 0xf1, 0xce, 0x37, 0xd0, 0xab, 0x43, 0x28, 0xb1,
+	0x3b, 0x1b, 0xaa, 0x80, 0x6a, 0xee, 0xdd, 0x6f, 0x16, 0x50, 0x0d, 0xb4, 0x1b, 0xdf, 0xdf, 0x3b,
+	0xbc, 0xdb, 0x54, 0x98, 0xef, 0xe8, 0xde, 0x41, 0xb3, 0xc8, 0x16, 0x07, 0xb7, 0x0e, 0x9b, 0x2a,
+	0x5f, 0xec, 0xfd, 0xd0, 0x2c, 0xa1, 0x3a, 0x54, 0x38, 0xeb, 0x1b, 0xb3, 0xa9, 0x19, 0x7f, 0x29,
+	0xa0, 0x1d, 0xb1, 0x6b, 0x39, 0xfa, 0x1c, 0xca, 0xa2, 0xc2, 0x68, 0x75, 0xc5, 0x5b, 0x5b, 0x67,
+	0xdd, 0x42, 0x66, 0xd7, 0x14, 0x74, 0x03, 0x60, 0x39, 0x91, 0xd0, 0x76, 0x4e, 0xff, 0xd9, 0xff,
+	0x8a, 0x56, 0x6b, 0x15, 0x24, 0xd5, 0x7a, 0x13, 0xea, 0x19, 0x11, 0xa3, 0x3c, 0x35, 0x37, 0x76,
+	0x5a, 0x57, 0x56, 0x62, 0x22, 0x8e, 0x71, 0x28, 0xdf, 0x21, 0x9b, 0x27, 0xe2, 0x64, 0x5f, 0x41,
+	0xdd, 0xc4, 0x7e, 0x48, 0x31, 0xf7, 0xa3, 0x45, 0x7f, 0x66, 0xbf, 0x21, 0x5a, 0x97, 0xce, 0x78,
+	0xe5, 0xb7, 0x46, 0xc1, 0xb8, 0x03, 0x6b, 0x59, 0x9d, 0xa2, 0xbd, 0x45, 0x9d, 0xae, 0xe4, 0x0b,
+	0x92, 0xd3, 0xf1, 0xab, 0xaa, 0xd5, 0x53, 0xae, 0x29, 0xc6, 0x6d, 0x68, 0xe6, 0x53, 0x3c, 0x36,
+	0xce, 0x26, 0xb9, 0x95, 0x4b, 0xe7, 0xd8, 0x78, 0x7d, 0x9a, 0xc3, 0x8f, 0x9e, 0xfc, 0xd7, 0x2e,
+	0x3c, 0x79, 0xde, 0x56, 0x9e, 0x3e, 0x6f, 0x2b, 0xff, 0x3e, 0x6f, 0x2b, 0xbf, 0xbe, 0x68, 0x17,
+	0x9e, 0xbe, 0x68, 0x17, 0xfe, 0x7e, 0xd1, 0x2e, 0xfc, 0x58, 0x91, 0x1f, 0x5e, 0xa3, 0x32, 0x6f,
+	0xe3, 0xeb, 0xff, 0x0f, 0x00, 0x41, 0xce, 0x19, 0x11, 0xe2, 0x0d, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -1085,6 +1240,188 @@ var _WriteableStore_serviceDesc = grpc.ServiceDesc{
 	Metadata: "store/storepb/rpc.proto",
 }
 
+// SeriesStreamClient is the client API for SeriesStream service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type SeriesStreamClient interface {
+	// Series streams the same responses as Store.Series, optionally batching series into single frames, without
+	// sending more than the window granted by the client.
+	Series(ctx context.Context, opts ...grpc.CallOption) (SeriesStream_SeriesClient, error)
+}
+
+type seriesStreamClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewSeriesStreamClient(cc *grpc.ClientConn) SeriesStreamClient {
+	return &seriesStreamClient{cc}
+}
+
+func (c *seriesStreamClient) Series(ctx context.Context, opts ...grpc.CallOption) (SeriesStream_SeriesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_SeriesStream_serviceDesc.Streams[0], "/thanos.SeriesStream/Series", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &seriesStreamSeriesClient{stream}
+	return x, nil
+}
+
+type SeriesStream_SeriesClient interface {
+	Send(*SeriesStreamRequest) error
+	Recv() (*SeriesResponse, error)
+	grpc.ClientStream
+}
+
+type seriesStreamSeriesClient struct {
+	grpc.ClientStream
+}
+
+func (x *seriesStreamSeriesClient) Send(m *SeriesStreamRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *seriesStreamSeriesClient) Recv() (*SeriesResponse, error) {
+	m := new(SeriesResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SeriesStreamServer is the server API for SeriesStream service.
+type SeriesStreamServer interface {
+	// Series streams the same responses as Store.Series, optionally batching series into single frames, without
+	// sending more than the window granted by the client.
+	Series(SeriesStream_SeriesServer) error
+}
+
+// UnimplementedSeriesStreamServer can be embedded to have forward compatible implementations.
+type UnimplementedSeriesStreamServer struct {
+}
+
+func (*UnimplementedSeriesStreamServer) Series(srv SeriesStream_SeriesServer) error {
+	return status.Errorf(codes.Unimplemented, "method Series not implemented")
+}
+
+func RegisterSeriesStreamServer(s *grpc.Server, srv SeriesStreamServer) {
+	s.RegisterService(&_SeriesStream_serviceDesc, srv)
+}
+
+func _SeriesStream_Series_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SeriesStreamServer).Series(&seriesStreamSeriesServer{stream})
+}
+
+type SeriesStream_SeriesServer interface {
+	Send(*SeriesResponse) error
+	Recv() (*SeriesStreamRequest, error)
+	grpc.ServerStream
+}
+
+type seriesStreamSeriesServer struct {
+	grpc.ServerStream
+}
+
+func (x *seriesStreamSeriesServer) Send(m *SeriesResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *seriesStreamSeriesServer) Recv() (*SeriesStreamRequest, error) {
+	m := new(SeriesStreamRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+var _SeriesStream_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "thanos.SeriesStream",
+	HandlerType: (*SeriesStreamServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Series",
+			Handler:       _SeriesStream_Series_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "store/storepb/rpc.proto",
+}
+
+// WriteableStoreV2Client is the client API for WriteableStoreV2 service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type WriteableStoreV2Client interface {
+	// RemoteWrite writes the series of the remote write 2.0 request to this store.
+	RemoteWrite(ctx context.Context, in *WriteV2Request, opts ...grpc.CallOption) (*WriteResponse, error)
+}
+
+type writeableStoreV2Client struct {
+	cc *grpc.ClientConn
+}
+
+func NewWriteableStoreV2Client(cc *grpc.ClientConn) WriteableStoreV2Client {
+	return &writeableStoreV2Client{cc}
+}
+
+func (c *writeableStoreV2Client) RemoteWrite(ctx context.Context, in *WriteV2Request, opts ...grpc.CallOption) (*WriteResponse, error) {
+	out := new(WriteResponse)
+	err := c.cc.Invoke(ctx, "/thanos.WriteableStoreV2/RemoteWrite", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WriteableStoreV2Server is the server API for WriteableStoreV2 service.
+type WriteableStoreV2Server interface {
+	// RemoteWrite writes the series of the remote write 2.0 request to this store.
+	RemoteWrite(context.Context, *WriteV2Request) (*WriteResponse, error)
+}
+
+// UnimplementedWriteableStoreV2Server can be embedded to have forward compatible implementations.
+type UnimplementedWriteableStoreV2Server struct {
+}
+
+func (*UnimplementedWriteableStoreV2Server) RemoteWrite(ctx context.Context, req *WriteV2Request) (*WriteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoteWrite not implemented")
+}
+
+func RegisterWriteableStoreV2Server(s *grpc.Server, srv WriteableStoreV2Server) {
+	s.RegisterService(&_WriteableStoreV2_serviceDesc, srv)
+}
+
+func _WriteableStoreV2_RemoteWrite_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WriteV2Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WriteableStoreV2Server).RemoteWrite(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/thanos.WriteableStoreV2/RemoteWrite",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WriteableStoreV2Server).RemoteWrite(ctx, req.(*WriteV2Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _WriteableStoreV2_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "thanos.WriteableStoreV2",
+	HandlerType: (*WriteableStoreV2Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RemoteWrite",
+			Handler:    _WriteableStoreV2_RemoteWrite_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "store/storepb/rpc.proto",
+}
+
 func (m *WriteResponse) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -1616,6 +1953,27 @@ func (m *SeriesResponse_Hints) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	}
 	return len(dAtA) - i, nil
 }
+func (m *SeriesResponse_Batch) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *SeriesResponse_Batch) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	if m.Batch != nil {
+		{
+			size, err := m.Batch.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintRpc(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0x22
+	}
+	return len(dAtA) - i, nil
+}
 func (m *LabelNamesRequest) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -1905,53 +2263,180 @@ func (m *LabelValuesResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	return len(dAtA) - i, nil
 }
 
-func encodeVarintRpc(dAtA []byte, offset int, v uint64) int {
-	offset -= sovRpc(v)
-	base := offset
-	for v >= 1<<7 {
-		dAtA[offset] = uint8(v&0x7f | 0x80)
-		v >>= 7
-		offset++
+func (m *SeriesBatch) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
 	}
-	dAtA[offset] = uint8(v)
-	return base
+	return dAtA[:n], nil
 }
-func (m *WriteResponse) Size() (n int) {
-	if m == nil {
-		return 0
-	}
-	var l int
-	_ = l
-	return n
+
+func (m *SeriesBatch) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
 }
 
-func (m *WriteRequest) Size() (n int) {
-	if m == nil {
-		return 0
-	}
+func (m *SeriesBatch) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
 	var l int
 	_ = l
-	if len(m.Timeseries) > 0 {
-		for _, e := range m.Timeseries {
-			l = e.Size()
-			n += 1 + l + sovRpc(uint64(l))
+	if len(m.Series) > 0 {
+		for iNdEx := len(m.Series) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Series[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintRpc(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0xa
 		}
 	}
-	l = len(m.Tenant)
-	if l > 0 {
-		n += 1 + l + sovRpc(uint64(l))
-	}
-	if m.Replica != 0 {
-		n += 1 + sovRpc(uint64(m.Replica))
-	}
-	return n
+	return len(dAtA) - i, nil
 }
 
-func (m *SeriesRequest) Size() (n int) {
-	if m == nil {
-		return 0
-	}
-	var l int
+func (m *SeriesStreamRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *SeriesStreamRequest) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *SeriesStreamRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.MaxBatchBytes != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.MaxBatchBytes))
+		i--
+		dAtA[i] = 0x18
+	}
+	if m.WindowBytes != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.WindowBytes))
+		i--
+		dAtA[i] = 0x10
+	}
+	if m.Request != nil {
+		{
+			size, err := m.Request.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintRpc(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *WriteV2Request) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *WriteV2Request) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *WriteV2Request) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.Replica != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.Replica))
+		i--
+		dAtA[i] = 0x18
+	}
+	if len(m.Tenant) > 0 {
+		i -= len(m.Tenant)
+		copy(dAtA[i:], m.Tenant)
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Tenant)))
+		i--
+		dAtA[i] = 0x12
+	}
+	{
+		size := m.Request.Size()
+		i -= size
+		if _, err := m.Request.MarshalTo(dAtA[i:]); err != nil {
+			return 0, err
+		}
+		i = encodeVarintRpc(dAtA, i, uint64(size))
+	}
+	i--
+	dAtA[i] = 0xa
+	return len(dAtA) - i, nil
+}
+
+func encodeVarintRpc(dAtA []byte, offset int, v uint64) int {
+	offset -= sovRpc(v)
+	base := offset
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return base
+}
+func (m *WriteResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	return n
+}
+
+func (m *WriteRequest) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if len(m.Timeseries) > 0 {
+		for _, e := range m.Timeseries {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	l = len(m.Tenant)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	if m.Replica != 0 {
+		n += 1 + sovRpc(uint64(m.Replica))
+	}
+	return n
+}
+
+func (m *SeriesRequest) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
 	_ = l
 	if m.MinTime != 0 {
 		n += 1 + sovRpc(uint64(m.MinTime))
@@ -2151,6 +2636,18 @@ func (m *SeriesResponse_Hints) Size() (n int) {
 	}
 	return n
 }
+func (m *SeriesResponse_Batch) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Batch != nil {
+		l = m.Batch.Size()
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	return n
+}
 func (m *LabelNamesRequest) Size() (n int) {
 	if m == nil {
 		return 0
@@ -2285,6 +2782,58 @@ func (m *LabelValuesResponse) Size() (n int) {
 	return n
 }
 
+func (m *SeriesBatch) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if len(m.Series) > 0 {
+		for _, e := range m.Series {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *SeriesStreamRequest) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Request != nil {
+		l = m.Request.Size()
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	if m.WindowBytes != 0 {
+		n += 1 + sovRpc(uint64(m.WindowBytes))
+	}
+	if m.MaxBatchBytes != 0 {
+		n += 1 + sovRpc(uint64(m.MaxBatchBytes))
+	}
+	return n
+}
+
+func (m *WriteV2Request) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = m.Request.Size()
+	n += 1 + l + sovRpc(uint64(l))
+	l = len(m.Tenant)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	if m.Replica != 0 {
+		n += 1 + sovRpc(uint64(m.Replica))
+	}
+	return n
+}
+
 func sovRpc(x uint64) (n int) {
 	return (math_bits.Len64(x|1) + 6) / 7
 }
@@ -3643,6 +4192,41 @@ func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
 			}
 			m.Result = &SeriesResponse_Hints{v}
 			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Batch", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			v := &SeriesBatch{}
+			if err := v.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			m.Result = &SeriesResponse_Batch{v}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -4492,6 +5076,348 @@ func (m *LabelValuesResponse) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *SeriesBatch) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: SeriesBatch: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: SeriesBatch: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Series", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Series = append(m.Series, Series{})
+			if err := m.Series[len(m.Series)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *SeriesStreamRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: SeriesStreamRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: SeriesStreamRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Request", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Request == nil {
+				m.Request = &SeriesRequest{}
+			}
+			if err := m.Request.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field WindowBytes", wireType)
+			}
+			m.WindowBytes = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.WindowBytes |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxBatchBytes", wireType)
+			}
+			m.MaxBatchBytes = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxBatchBytes |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *WriteV2Request) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: WriteV2Request: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: WriteV2Request: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Request", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if err := m.Request.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Tenant", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Tenant = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Replica", wireType)
+			}
+			m.Replica = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Replica |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func skipRpc(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
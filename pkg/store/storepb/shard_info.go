@@ -4,6 +4,8 @@
 package storepb
 
 import (
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/cespare/xxhash/v2"
@@ -13,6 +15,10 @@ import (
 
 var sep = []byte{'\xff'}
 
+// AllLabelsShardKey is the key of the shard matchers sharding series by all their labels, e.g. of sharded queries
+// without grouping. The hashes of series of such matchers are returned by AllLabelsShardHash.
+const AllLabelsShardKey = "without()"
+
 type ShardMatcher struct {
 	buf              *[]byte
 	buffers          *sync.Pool
@@ -25,7 +31,7 @@ type ShardMatcher struct {
 }
 
 func (s *ShardMatcher) IsSharded() bool {
-	return s.isSharded
+	return s != nil && s.isSharded
 }
 
 func (s *ShardMatcher) Close() {
@@ -42,7 +48,12 @@ func (s *ShardMatcher) MatchesZLabels(zLabels []labelpb.ZLabel) bool {
 	if s == nil || !s.isSharded {
 		return true
 	}
+	return s.MatchesHash(s.Hash(zLabels))
+}
 
+// Hash returns the hash of the labels of the series its shard is computed from. Series have the same hash with
+// matchers of the same Key.
+func (s *ShardMatcher) Hash(zLabels []labelpb.ZLabel) uint64 {
 	*s.buf = (*s.buf)[:0]
 	for _, lbl := range zLabels {
 		if shardByLabel(s.shardingLabelset, lbl, s.by) {
@@ -52,15 +63,48 @@ func (s *ShardMatcher) MatchesZLabels(zLabels []labelpb.ZLabel) bool {
 			*s.buf = append(*s.buf, sep[0])
 		}
 	}
+	return xxhash.Sum64(*s.buf)
+}
 
-	hash := xxhash.Sum64(*s.buf)
+// MatchesHash returns true if the series with the hash returned by Hash belongs to the shard.
+func (s *ShardMatcher) MatchesHash(hash uint64) bool {
+	if s == nil || !s.isSharded {
+		return true
+	}
 	return hash%uint64(s.totalShards) == uint64(s.shardIndex)
 }
 
+// Key identifies the labels the matcher shards series by, regardless of the number of shards and of the shard.
+func (s *ShardMatcher) Key() string {
+	names := make([]string, 0, len(s.shardingLabelset))
+	for n := range s.shardingLabelset {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	grouping := "without"
+	if s.by {
+		grouping = "by"
+	}
+	return grouping + "(" + strings.Join(names, ",") + ")"
+}
+
 func (s *ShardMatcher) MatchesLabels(lbls labels.Labels) bool {
 	return s.MatchesZLabels(labelpb.ZLabelsFromPromLabels(lbls))
 }
 
+// AllLabelsShardHash returns the hash of the series with the labels for the shard matchers of AllLabelsShardKey.
+func AllLabelsShardHash(lset labels.Labels) uint64 {
+	d := xxhash.New()
+	lset.Range(func(l labels.Label) {
+		_, _ = d.WriteString(l.Name)
+		_, _ = d.Write(sep)
+		_, _ = d.WriteString(l.Value)
+		_, _ = d.Write(sep)
+	})
+	return d.Sum64()
+}
+
 func shardByLabel(labelSet map[string]struct{}, zlabel labelpb.ZLabel, groupingBy bool) bool {
 	_, shardHasLabel := labelSet[zlabel.Name]
 	if groupingBy && shardHasLabel {
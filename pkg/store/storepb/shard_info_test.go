@@ -125,3 +125,27 @@ func TestShardInfo_MatchesSeries(t *testing.T) {
 		})
 	}
 }
+
+func TestShardMatcher_AllLabelsShardHash(t *testing.T) {
+	lset := labels.FromStrings("container", "nginx", "node", "node-1", "pod", "nginx")
+
+	buffers := sync.Pool{New: func() interface{} {
+		b := make([]byte, 0, 10*units.Kilobyte)
+		return &b
+	}}
+	matcher := (&ShardInfo{ShardIndex: 0, TotalShards: 3}).Matcher(&buffers)
+	defer matcher.Close()
+
+	if matcher.Key() != AllLabelsShardKey {
+		t.Fatalf("invalid key, got %s, want %s", matcher.Key(), AllLabelsShardKey)
+	}
+	if h := matcher.Hash(labelpb.ZLabelsFromPromLabels(lset)); h != AllLabelsShardHash(lset) {
+		t.Fatalf("invalid hash, got %d, want %d", AllLabelsShardHash(lset), h)
+	}
+
+	byMatcher := (&ShardInfo{ShardIndex: 0, TotalShards: 3, By: true, Labels: []string{"pod", "node"}}).Matcher(&buffers)
+	defer byMatcher.Close()
+	if byMatcher.Key() != "by(node,pod)" {
+		t.Fatalf("invalid key, got %s", byMatcher.Key())
+	}
+}
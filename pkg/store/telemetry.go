@@ -10,6 +10,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 
+	"github.com/thanos-io/thanos/pkg/metering"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 
 	"go.uber.org/atomic"
@@ -83,6 +84,7 @@ func (s *seriesStatsAggregator) Aggregate(stats storepb.SeriesStatsCounter) {
 	s.seriesStats.Series += stats.Series
 	s.seriesStats.Samples += stats.Samples
 	s.seriesStats.Chunks += stats.Chunks
+	s.seriesStats.Bytes += stats.Bytes
 }
 
 // Observe commits the aggregated SeriesStatsCounter as an observation.
@@ -135,6 +137,33 @@ func (s *NoopSeriesStatsAggregator) Aggregate(_ storepb.SeriesStatsCounter) {}
 
 func (s *NoopSeriesStatsAggregator) Observe(_ float64) {}
 
+// meteringSeriesStatsAggregator meters the bytes and series of the fanned-out queries of a tenant.
+type meteringSeriesStatsAggregator struct {
+	SeriesQueryPerformanceMetricsAggregator
+	meter  *metering.Meter
+	tenant string
+}
+
+func (s *meteringSeriesStatsAggregator) Aggregate(stats storepb.SeriesStatsCounter) {
+	s.meter.AddQueried(s.tenant, stats.Bytes, stats.Series)
+	s.SeriesQueryPerformanceMetricsAggregator.Aggregate(stats)
+}
+
+type meteringSeriesStatsAggregatorFactory struct {
+	next  SeriesQueryPerformanceMetricsAggregatorFactory
+	meter *metering.Meter
+}
+
+// NewMeteringSeriesStatsAggregatorFactory returns a factory of aggregators which meter the bytes and series
+// queried by the tenant in addition to the aggregators of the next factory.
+func NewMeteringSeriesStatsAggregatorFactory(next SeriesQueryPerformanceMetricsAggregatorFactory, meter *metering.Meter) SeriesQueryPerformanceMetricsAggregatorFactory {
+	return &meteringSeriesStatsAggregatorFactory{next: next, meter: meter}
+}
+
+func (f *meteringSeriesStatsAggregatorFactory) NewAggregator(tenant string) SeriesQueryPerformanceMetricsAggregator {
+	return &meteringSeriesStatsAggregator{SeriesQueryPerformanceMetricsAggregator: f.next.NewAggregator(tenant), meter: f.meter, tenant: tenant}
+}
+
 // NoopSeriesStatsAggregatorFactory is a query performance series aggregator factory that does nothing.
 type NoopSeriesStatsAggregatorFactory struct{}
 
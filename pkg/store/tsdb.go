@@ -89,6 +89,12 @@ func RegisterWritableStoreServer(storeSrv storepb.WriteableStoreServer) func(*gr
 	}
 }
 
+func RegisterWritableStoreV2Server(storeSrv storepb.WriteableStoreV2Server) func(*grpc.Server) {
+	return func(s *grpc.Server) {
+		storepb.RegisterWriteableStoreV2Server(s, storeSrv)
+	}
+}
+
 // ReadWriteTSDBStore is a TSDBStore that can also be written to.
 type ReadWriteTSDBStore struct {
 	storepb.StoreServer
@@ -0,0 +1,98 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package targets
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/util/annotations"
+
+	"github.com/thanos-io/thanos/pkg/targets/targetspb"
+)
+
+var _ UnaryClient = &CachedClient{}
+
+// CachedClient caches the responses of a UnaryClient for a TTL, so that refreshing the targets or the scrape configs
+// of large fleets does not fan out the requests to every Prometheus each time. Responses are cached per request, and
+// concurrent requests for the same expired response wait for a single request to the client. Errors are not cached.
+type CachedClient struct {
+	client UnaryClient
+
+	targets       *responseCache[*targetspb.TargetDiscovery]
+	scrapeConfigs *responseCache[[]*targetspb.ScrapeConfig]
+}
+
+// NewCachedClient returns a client caching the responses of the client for the TTL.
+func NewCachedClient(client UnaryClient, ttl time.Duration) *CachedClient {
+	return &CachedClient{
+		client:        client,
+		targets:       newResponseCache[*targetspb.TargetDiscovery](ttl),
+		scrapeConfigs: newResponseCache[[]*targetspb.ScrapeConfig](ttl),
+	}
+}
+
+// Targets returns the cached targets of the request, which must not be modified.
+func (c *CachedClient) Targets(ctx context.Context, req *targetspb.TargetsRequest) (*targetspb.TargetDiscovery, annotations.Annotations, error) {
+	return c.targets.get(req.String(), func() (*targetspb.TargetDiscovery, annotations.Annotations, error) {
+		return c.client.Targets(ctx, req)
+	})
+}
+
+// ScrapeConfigs returns the cached scrape configs of the request, which must not be modified.
+func (c *CachedClient) ScrapeConfigs(ctx context.Context, req *targetspb.ScrapeConfigsRequest) ([]*targetspb.ScrapeConfig, annotations.Annotations, error) {
+	return c.scrapeConfigs.get(req.String(), func() ([]*targetspb.ScrapeConfig, annotations.Annotations, error) {
+		return c.client.ScrapeConfigs(ctx, req)
+	})
+}
+
+type responseCache[T any] struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mtx       sync.Mutex
+	responses map[string]*cachedResponse[T]
+}
+
+type cachedResponse[T any] struct {
+	mtx      sync.Mutex
+	expires  time.Time
+	resp     T
+	warnings annotations.Annotations
+}
+
+func newResponseCache[T any](ttl time.Duration) *responseCache[T] {
+	return &responseCache[T]{
+		ttl:       ttl,
+		now:       time.Now,
+		responses: map[string]*cachedResponse[T]{},
+	}
+}
+
+// get returns the cached response of the key, fetching it if it expired.
+func (c *responseCache[T]) get(key string, fetch func() (T, annotations.Annotations, error)) (T, annotations.Annotations, error) {
+	// Requests only differ by a few parameters, so the number of keys is bounded.
+	c.mtx.Lock()
+	r, ok := c.responses[key]
+	if !ok {
+		r = &cachedResponse[T]{}
+		c.responses[key] = r
+	}
+	c.mtx.Unlock()
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if c.now().Before(r.expires) {
+		return r.resp, r.warnings, nil
+	}
+	resp, warnings, err := fetch()
+	if err != nil {
+		var zero T
+		return zero, nil, err
+	}
+	r.resp, r.warnings, r.expires = resp, warnings, c.now().Add(c.ttl)
+	return resp, warnings, nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package targets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/util/annotations"
+
+	"github.com/thanos-io/thanos/pkg/targets/targetspb"
+)
+
+type countingClient struct {
+	calls int
+	err   error
+}
+
+func (c *countingClient) Targets(context.Context, *targetspb.TargetsRequest) (*targetspb.TargetDiscovery, annotations.Annotations, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, nil, c.err
+	}
+	return &targetspb.TargetDiscovery{ActiveTargets: []*targetspb.ActiveTarget{{ScrapePool: "a"}}}, nil, nil
+}
+
+func (c *countingClient) ScrapeConfigs(context.Context, *targetspb.ScrapeConfigsRequest) ([]*targetspb.ScrapeConfig, annotations.Annotations, error) {
+	c.calls++
+	return []*targetspb.ScrapeConfig{{JobName: "a"}}, nil, c.err
+}
+
+func TestCachedClient(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	client := &countingClient{}
+	c := NewCachedClient(client, time.Minute)
+	c.targets.now = func() time.Time { return now }
+	c.scrapeConfigs.now = func() time.Time { return now }
+
+	active := &targetspb.TargetsRequest{State: targetspb.TargetsRequest_ACTIVE}
+	for i := 0; i < 3; i++ {
+		targets, _, err := c.Targets(ctx, active)
+		testutil.Ok(t, err)
+		testutil.Equals(t, 1, len(targets.ActiveTargets))
+	}
+	testutil.Equals(t, 1, client.calls)
+
+	// Responses are cached per request.
+	_, _, err := c.Targets(ctx, &targetspb.TargetsRequest{State: targetspb.TargetsRequest_DROPPED})
+	testutil.Ok(t, err)
+	_, _, err = c.ScrapeConfigs(ctx, &targetspb.ScrapeConfigsRequest{})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, client.calls)
+
+	now = now.Add(time.Minute)
+	_, _, err = c.Targets(ctx, active)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 4, client.calls)
+
+	// Errors are not cached.
+	now = now.Add(time.Minute)
+	client.err = errors.New("unavailable")
+	_, _, err = c.Targets(ctx, active)
+	testutil.NotOk(t, err)
+	client.err = nil
+	_, _, err = c.Targets(ctx, active)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 6, client.calls)
+}
@@ -52,6 +52,23 @@ func (p *Prometheus) Targets(r *targetspb.TargetsRequest, s targetspb.Targets_Ta
 	return nil
 }
 
+// ScrapeConfigs returns the scrape configs of Prometheus.
+func (p *Prometheus) ScrapeConfigs(_ *targetspb.ScrapeConfigsRequest, s targetspb.Targets_ScrapeConfigsServer) error {
+	scrapeConfigs, err := p.client.ScrapeConfigsInGRPC(s.Context(), p.base)
+	if err != nil {
+		return err
+	}
+
+	extLset := p.extLabels()
+	for _, c := range scrapeConfigs {
+		c.SetLabels(extLset)
+		if err := s.Send(targetspb.NewScrapeConfigsResponse(c)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func enrichTargetsWithExtLabels(targets *targetspb.TargetDiscovery, extLset labels.Labels) {
 	for i, target := range targets.ActiveTargets {
 		target.SetDiscoveredLabels(labelpb.ExtendSortedLabels(target.DiscoveredLabels.PromLabels(), extLset))
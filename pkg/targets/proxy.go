@@ -80,6 +80,46 @@ func (s *Proxy) Targets(req *targetspb.TargetsRequest, srv targetspb.Targets_Tar
 	return nil
 }
 
+func (s *Proxy) ScrapeConfigs(req *targetspb.ScrapeConfigsRequest, srv targetspb.Targets_ScrapeConfigsServer) error {
+	var (
+		g, gctx       = errgroup.WithContext(srv.Context())
+		respChan      = make(chan *targetspb.ScrapeConfig, 10)
+		scrapeConfigs []*targetspb.ScrapeConfig
+	)
+
+	for _, targetsClient := range s.targets() {
+		rs := &scrapeConfigsStream{
+			client:  targetsClient,
+			request: req,
+			channel: respChan,
+			server:  srv,
+		}
+		g.Go(func() error { return rs.receive(gctx) })
+	}
+
+	go func() {
+		_ = g.Wait()
+		close(respChan)
+	}()
+
+	for resp := range respChan {
+		scrapeConfigs = append(scrapeConfigs, resp)
+	}
+
+	if err := g.Wait(); err != nil {
+		level.Error(s.logger).Log("err", err)
+		return err
+	}
+
+	for _, c := range scrapeConfigs {
+		if err := srv.Send(targetspb.NewScrapeConfigsResponse(c)); err != nil {
+			return status.Error(codes.Unknown, errors.Wrap(err, "send scrape configs response").Error())
+		}
+	}
+
+	return nil
+}
+
 type targetsStream struct {
 	client  targetspb.TargetsClient
 	request *targetspb.TargetsRequest
@@ -137,3 +177,58 @@ func (stream *targetsStream) receive(ctx context.Context) error {
 		}
 	}
 }
+
+type scrapeConfigsStream struct {
+	client  targetspb.TargetsClient
+	request *targetspb.ScrapeConfigsRequest
+	channel chan<- *targetspb.ScrapeConfig
+	server  targetspb.Targets_ScrapeConfigsServer
+}
+
+func (stream *scrapeConfigsStream) receive(ctx context.Context) error {
+	scrapeConfigs, err := stream.client.ScrapeConfigs(ctx, stream.request)
+	if err != nil {
+		return stream.handleErr(errors.Wrapf(err, "fetching scrape configs from targets client %v", stream.client))
+	}
+
+	for {
+		resp, err := scrapeConfigs.Recv()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return stream.handleErr(errors.Wrapf(err, "receiving scrape configs from targets client %v", stream.client))
+		}
+
+		if w := resp.GetWarning(); w != "" {
+			if err := stream.server.Send(targetspb.NewWarningScrapeConfigsResponse(errors.New(w))); err != nil {
+				return errors.Wrapf(err, "sending scrape configs warning to server %v", stream.server)
+			}
+			continue
+		}
+
+		select {
+		case stream.channel <- resp.GetScrapeConfig():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (stream *scrapeConfigsStream) handleErr(err error) error {
+	// Targets servers older than the ScrapeConfigs API do not serve scrape configs, which is not an error.
+	if status.Code(errors.Cause(err)) == codes.Unimplemented {
+		return nil
+	}
+
+	if stream.request.PartialResponseStrategy == storepb.PartialResponseStrategy_ABORT {
+		return err
+	}
+
+	if serr := stream.server.Send(targetspb.NewWarningScrapeConfigsResponse(err)); serr != nil {
+		return errors.Wrapf(serr, "sending scrape configs error to server %v", stream.server)
+	}
+	// Not an error if response strategy is warning.
+	return nil
+}
@@ -9,9 +9,12 @@ import (
 	"os"
 	"testing"
 
+	"github.com/efficientgo/core/testutil"
 	"github.com/go-kit/log"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/thanos-io/thanos/pkg/targets/targetspb"
@@ -22,6 +25,9 @@ type testTargetsClient struct {
 	targetsErr, recvErr error
 	response            *targetspb.TargetsResponse
 	sentResponse        bool
+
+	scrapeConfigsErr error
+	scrapeConfigs    []*targetspb.ScrapeConfig
 }
 
 func (t *testTargetsClient) String() string {
@@ -46,8 +52,29 @@ func (t *testTargetsClient) Targets(ctx context.Context, in *targetspb.TargetsRe
 	return t, t.targetsErr
 }
 
+func (t *testTargetsClient) ScrapeConfigs(ctx context.Context, in *targetspb.ScrapeConfigsRequest, opts ...grpc.CallOption) (targetspb.Targets_ScrapeConfigsClient, error) {
+	if t.scrapeConfigsErr != nil {
+		return nil, t.scrapeConfigsErr
+	}
+	return &testScrapeConfigsClient{scrapeConfigs: t.scrapeConfigs}, nil
+}
+
 var _ targetspb.TargetsClient = &testTargetsClient{}
 
+type testScrapeConfigsClient struct {
+	grpc.ClientStream
+	scrapeConfigs []*targetspb.ScrapeConfig
+}
+
+func (c *testScrapeConfigsClient) Recv() (*targetspb.ScrapeConfigsResponse, error) {
+	if len(c.scrapeConfigs) == 0 {
+		return nil, io.EOF
+	}
+	resp := targetspb.NewScrapeConfigsResponse(c.scrapeConfigs[0])
+	c.scrapeConfigs = c.scrapeConfigs[1:]
+	return resp, nil
+}
+
 // TestProxyDataRace find the concurrent data race bug ( go test -race -run TestProxyDataRace -v ).
 func TestProxyDataRace(t *testing.T) {
 	logger := log.NewLogfmtLogger(os.Stderr)
@@ -71,3 +98,31 @@ func TestProxyDataRace(t *testing.T) {
 	}
 	_ = p.Targets(req, s)
 }
+
+func TestProxy_ScrapeConfigs(t *testing.T) {
+	a := &targetspb.ScrapeConfig{JobName: "a", Config: "job_name: a\n"}
+	b := &targetspb.ScrapeConfig{JobName: "b", Config: "job_name: b\n"}
+	p := NewProxy(log.NewNopLogger(), func() []targetspb.TargetsClient {
+		return []targetspb.TargetsClient{
+			&testTargetsClient{scrapeConfigs: []*targetspb.ScrapeConfig{a, b}},
+			// Targets servers older than the ScrapeConfigs API are skipped.
+			&testTargetsClient{scrapeConfigsErr: status.Error(codes.Unimplemented, "unknown method ScrapeConfigs")},
+		}
+	})
+
+	for _, strategy := range []storepb.PartialResponseStrategy{storepb.PartialResponseStrategy_ABORT, storepb.PartialResponseStrategy_WARN} {
+		s := &scrapeConfigsServer{ctx: context.Background()}
+		testutil.Ok(t, p.ScrapeConfigs(&targetspb.ScrapeConfigsRequest{PartialResponseStrategy: strategy}, s))
+		testutil.Equals(t, []*targetspb.ScrapeConfig{a, b}, s.scrapeConfigs)
+		testutil.Equals(t, 0, len(s.warnings))
+	}
+
+	p = NewProxy(log.NewNopLogger(), func() []targetspb.TargetsClient {
+		return []targetspb.TargetsClient{&testTargetsClient{scrapeConfigsErr: errors.New("unavailable")}}
+	})
+	testutil.NotOk(t, p.ScrapeConfigs(&targetspb.ScrapeConfigsRequest{PartialResponseStrategy: storepb.PartialResponseStrategy_ABORT}, &scrapeConfigsServer{ctx: context.Background()}))
+
+	s := &scrapeConfigsServer{ctx: context.Background()}
+	testutil.Ok(t, p.ScrapeConfigs(&targetspb.ScrapeConfigsRequest{PartialResponseStrategy: storepb.PartialResponseStrategy_WARN}, s))
+	testutil.Equals(t, 1, len(s.warnings))
+}
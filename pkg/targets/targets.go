@@ -21,6 +21,7 @@ var _ UnaryClient = &GRPCClient{}
 // support streaming.
 type UnaryClient interface {
 	Targets(ctx context.Context, req *targetspb.TargetsRequest) (*targetspb.TargetDiscovery, annotations.Annotations, error)
+	ScrapeConfigs(ctx context.Context, req *targetspb.ScrapeConfigsRequest) ([]*targetspb.ScrapeConfig, annotations.Annotations, error)
 }
 
 // GRPCClient allows to retrieve targets from local gRPC streaming server implementation.
@@ -62,6 +63,41 @@ func (rr *GRPCClient) Targets(ctx context.Context, req *targetspb.TargetsRequest
 	return resp.targets, resp.warnings, nil
 }
 
+func (rr *GRPCClient) ScrapeConfigs(ctx context.Context, req *targetspb.ScrapeConfigsRequest) ([]*targetspb.ScrapeConfig, annotations.Annotations, error) {
+	resp := &scrapeConfigsServer{ctx: ctx, scrapeConfigs: make([]*targetspb.ScrapeConfig, 0)}
+
+	if err := rr.proxy.ScrapeConfigs(req, resp); err != nil {
+		return nil, nil, errors.Wrap(err, "proxy ScrapeConfigs")
+	}
+
+	return dedupScrapeConfigs(resp.scrapeConfigs, rr.replicaLabels), resp.warnings, nil
+}
+
+// dedupScrapeConfigs removes the replica labels of the scrape configs, and then the scrape configs of the replicas
+// that are the same.
+func dedupScrapeConfigs(scrapeConfigs []*targetspb.ScrapeConfig, replicaLabels map[string]struct{}) []*targetspb.ScrapeConfig {
+	if len(scrapeConfigs) == 0 {
+		return scrapeConfigs
+	}
+
+	for _, c := range scrapeConfigs {
+		c.Labels.Labels = removeReplicaLabels(c.Labels.Labels, replicaLabels)
+	}
+
+	sort.Slice(scrapeConfigs, func(i, j int) bool {
+		return scrapeConfigs[i].Compare(scrapeConfigs[j]) < 0
+	})
+
+	i := 0
+	for j := 1; j < len(scrapeConfigs); j++ {
+		if scrapeConfigs[i].Compare(scrapeConfigs[j]) != 0 {
+			i++
+			scrapeConfigs[i] = scrapeConfigs[j]
+		}
+	}
+	return scrapeConfigs[:i+1]
+}
+
 // dedupTargets re-sorts the set so that the same target with different replica
 // labels are coming right after each other.
 func dedupTargets(targets *targetspb.TargetDiscovery, replicaLabels map[string]struct{}) *targetspb.TargetDiscovery {
@@ -212,3 +248,35 @@ func (srv *targetsServer) Send(res *targetspb.TargetsResponse) error {
 func (srv *targetsServer) Context() context.Context {
 	return srv.ctx
 }
+
+type scrapeConfigsServer struct {
+	// This field just exist to pseudo-implement the unused methods of the interface.
+	targetspb.Targets_ScrapeConfigsServer
+	ctx context.Context
+
+	warnings      annotations.Annotations
+	scrapeConfigs []*targetspb.ScrapeConfig
+	mu            sync.Mutex
+}
+
+func (srv *scrapeConfigsServer) Send(res *targetspb.ScrapeConfigsResponse) error {
+	if res.GetWarning() != "" {
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		srv.warnings.Add(errors.New(res.GetWarning()))
+		return nil
+	}
+
+	if res.GetScrapeConfig() == nil {
+		return errors.New("no scrape config")
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.scrapeConfigs = append(srv.scrapeConfigs, res.GetScrapeConfig())
+
+	return nil
+}
+
+func (srv *scrapeConfigsServer) Context() context.Context {
+	return srv.ctx
+}
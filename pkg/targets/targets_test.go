@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/targets/targetspb"
 )
@@ -278,3 +280,26 @@ func TestDedupTargets(t *testing.T) {
 		})
 	}
 }
+
+func TestDedupScrapeConfigs(t *testing.T) {
+	scrapeConfig := func(jobName, config string, lbls ...string) *targetspb.ScrapeConfig {
+		c := &targetspb.ScrapeConfig{JobName: jobName, Config: config}
+		c.SetLabels(labels.FromStrings(lbls...))
+		return c
+	}
+
+	testutil.Equals(t, []*targetspb.ScrapeConfig{
+		scrapeConfig("a", "job_name: a\n", "prometheus", "ha"),
+		scrapeConfig("a", "job_name: a\nscrape_interval: 1m\n", "prometheus", "ha"),
+		scrapeConfig("a", "job_name: a\n", "prometheus", "other"),
+		scrapeConfig("b", "job_name: b\n", "prometheus", "ha"),
+	}, dedupScrapeConfigs([]*targetspb.ScrapeConfig{
+		scrapeConfig("b", "job_name: b\n", "prometheus", "ha", "replica", "0"),
+		scrapeConfig("a", "job_name: a\n", "prometheus", "ha", "replica", "1"),
+		scrapeConfig("a", "job_name: a\n", "prometheus", "ha", "replica", "0"),
+		// Replicas with different configs, e.g. during a rollout, are kept.
+		scrapeConfig("a", "job_name: a\nscrape_interval: 1m\n", "prometheus", "ha", "replica", "2"),
+		scrapeConfig("a", "job_name: a\n", "prometheus", "other", "replica", "0"),
+		scrapeConfig("b", "job_name: b\n", "prometheus", "ha", "replica", "1"),
+	}, map[string]struct{}{"replica": {}}))
+}
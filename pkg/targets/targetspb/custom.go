@@ -29,6 +29,22 @@ func NewWarningTargetsResponse(warning error) *TargetsResponse {
 	}
 }
 
+func NewScrapeConfigsResponse(scrapeConfig *ScrapeConfig) *ScrapeConfigsResponse {
+	return &ScrapeConfigsResponse{
+		Result: &ScrapeConfigsResponse_ScrapeConfig{
+			ScrapeConfig: scrapeConfig,
+		},
+	}
+}
+
+func NewWarningScrapeConfigsResponse(warning error) *ScrapeConfigsResponse {
+	return &ScrapeConfigsResponse{
+		Result: &ScrapeConfigsResponse_Warning{
+			Warning: warning.Error(),
+		},
+	}
+}
+
 func (x *TargetHealth) UnmarshalJSON(entry []byte) error {
 	fieldStr, err := strconv.Unquote(string(entry))
 	if err != nil {
@@ -128,3 +144,25 @@ func (t *DroppedTarget) SetDiscoveredLabels(ls labels.Labels) {
 
 	t.DiscoveredLabels = result
 }
+
+func (c1 *ScrapeConfig) Compare(c2 *ScrapeConfig) int {
+	if d := strings.Compare(c1.JobName, c2.JobName); d != 0 {
+		return d
+	}
+
+	if d := labels.Compare(c1.Labels.PromLabels(), c2.Labels.PromLabels()); d != 0 {
+		return d
+	}
+
+	return strings.Compare(c1.Config, c2.Config)
+}
+
+func (c *ScrapeConfig) SetLabels(ls labels.Labels) {
+	var result labelpb.ZLabelSet
+
+	if !ls.IsEmpty() {
+		result = labelpb.ZLabelSet{Labels: labelpb.ZLabelsFromPromLabels(ls)}
+	}
+
+	c.Labels = result
+}
@@ -337,6 +337,169 @@ func (m *DroppedTarget) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_DroppedTarget proto.InternalMessageInfo
 
+type ScrapeConfigsRequest struct {
+	PartialResponseStrategy storepb.PartialResponseStrategy `protobuf:"varint,1,opt,name=partial_response_strategy,json=partialResponseStrategy,proto3,enum=thanos.PartialResponseStrategy" json:"partial_response_strategy,omitempty"`
+}
+
+func (m *ScrapeConfigsRequest) Reset()         { *m = ScrapeConfigsRequest{} }
+func (m *ScrapeConfigsRequest) String() string { return proto.CompactTextString(m) }
+func (*ScrapeConfigsRequest) ProtoMessage()    {}
+func (*ScrapeConfigsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b5cdaee03579e907, []int{5}
+}
+func (m *ScrapeConfigsRequest) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *ScrapeConfigsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_ScrapeConfigsRequest.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *ScrapeConfigsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ScrapeConfigsRequest.Merge(m, src)
+}
+func (m *ScrapeConfigsRequest) XXX_Size() int {
+	return m.Size()
+}
+func (m *ScrapeConfigsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ScrapeConfigsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ScrapeConfigsRequest proto.InternalMessageInfo
+
+type ScrapeConfigsResponse struct {
+	// Types that are valid to be assigned to Result:
+	//	*ScrapeConfigsResponse_ScrapeConfig
+	//	*ScrapeConfigsResponse_Warning
+	Result isScrapeConfigsResponse_Result `protobuf_oneof:"result"`
+}
+
+func (m *ScrapeConfigsResponse) Reset()         { *m = ScrapeConfigsResponse{} }
+func (m *ScrapeConfigsResponse) String() string { return proto.CompactTextString(m) }
+func (*ScrapeConfigsResponse) ProtoMessage()    {}
+func (*ScrapeConfigsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b5cdaee03579e907, []int{6}
+}
+func (m *ScrapeConfigsResponse) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *ScrapeConfigsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_ScrapeConfigsResponse.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *ScrapeConfigsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ScrapeConfigsResponse.Merge(m, src)
+}
+func (m *ScrapeConfigsResponse) XXX_Size() int {
+	return m.Size()
+}
+func (m *ScrapeConfigsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ScrapeConfigsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ScrapeConfigsResponse proto.InternalMessageInfo
+
+type isScrapeConfigsResponse_Result interface {
+	isScrapeConfigsResponse_Result()
+	MarshalTo([]byte) (int, error)
+	Size() int
+}
+
+type ScrapeConfigsResponse_ScrapeConfig struct {
+	ScrapeConfig *ScrapeConfig `protobuf:"bytes,1,opt,name=scrape_config,json=scrapeConfig,proto3,oneof" json:"scrape_config,omitempty"`
+}
+type ScrapeConfigsResponse_Warning struct {
+	Warning string `protobuf:"bytes,2,opt,name=warning,proto3,oneof" json:"warning,omitempty"`
+}
+
+func (*ScrapeConfigsResponse_ScrapeConfig) isScrapeConfigsResponse_Result() {}
+func (*ScrapeConfigsResponse_Warning) isScrapeConfigsResponse_Result()      {}
+
+func (m *ScrapeConfigsResponse) GetResult() isScrapeConfigsResponse_Result {
+	if m != nil {
+		return m.Result
+	}
+	return nil
+}
+
+func (m *ScrapeConfigsResponse) GetScrapeConfig() *ScrapeConfig {
+	if x, ok := m.GetResult().(*ScrapeConfigsResponse_ScrapeConfig); ok {
+		return x.ScrapeConfig
+	}
+	return nil
+}
+
+func (m *ScrapeConfigsResponse) GetWarning() string {
+	if x, ok := m.GetResult().(*ScrapeConfigsResponse_Warning); ok {
+		return x.Warning
+	}
+	return ""
+}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*ScrapeConfigsResponse) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*ScrapeConfigsResponse_ScrapeConfig)(nil),
+		(*ScrapeConfigsResponse_Warning)(nil),
+	}
+}
+
+type ScrapeConfig struct {
+	JobName string `protobuf:"bytes,1,opt,name=jobName,proto3" json:"jobName"`
+	/// config is the scrape config in YAML, with its secrets hidden by Prometheus.
+	Config string `protobuf:"bytes,2,opt,name=config,proto3" json:"config"`
+	/// labels are the external labels of the Prometheus server scraping with the config.
+	Labels labelpb.ZLabelSet `protobuf:"bytes,3,opt,name=labels,proto3" json:"labels"`
+}
+
+func (m *ScrapeConfig) Reset()         { *m = ScrapeConfig{} }
+func (m *ScrapeConfig) String() string { return proto.CompactTextString(m) }
+func (*ScrapeConfig) ProtoMessage()    {}
+func (*ScrapeConfig) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b5cdaee03579e907, []int{7}
+}
+func (m *ScrapeConfig) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *ScrapeConfig) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_ScrapeConfig.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *ScrapeConfig) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ScrapeConfig.Merge(m, src)
+}
+func (m *ScrapeConfig) XXX_Size() int {
+	return m.Size()
+}
+func (m *ScrapeConfig) XXX_DiscardUnknown() {
+	xxx_messageInfo_ScrapeConfig.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ScrapeConfig proto.InternalMessageInfo
+
 func init() {
 	proto.RegisterEnum("thanos.TargetHealth", TargetHealth_name, TargetHealth_value)
 	proto.RegisterEnum("thanos.TargetsRequest_State", TargetsRequest_State_name, TargetsRequest_State_value)
@@ -345,56 +508,67 @@ func init() {
 	proto.RegisterType((*TargetDiscovery)(nil), "thanos.TargetDiscovery")
 	proto.RegisterType((*ActiveTarget)(nil), "thanos.ActiveTarget")
 	proto.RegisterType((*DroppedTarget)(nil), "thanos.DroppedTarget")
+	proto.RegisterType((*ScrapeConfigsRequest)(nil), "thanos.ScrapeConfigsRequest")
+	proto.RegisterType((*ScrapeConfigsResponse)(nil), "thanos.ScrapeConfigsResponse")
+	proto.RegisterType((*ScrapeConfig)(nil), "thanos.ScrapeConfig")
 }
 
 func init() { proto.RegisterFile("targets/targetspb/rpc.proto", fileDescriptor_b5cdaee03579e907) }
 
 var fileDescriptor_b5cdaee03579e907 = []byte{
-	// 702 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x54, 0x41, 0x53, 0xdb, 0x3a,
-	0x10, 0xb6, 0x03, 0x38, 0x64, 0x81, 0xbc, 0xa0, 0xe1, 0x81, 0xc9, 0x7b, 0x13, 0x33, 0xb9, 0x3c,
-	0x5e, 0x3b, 0x75, 0x3a, 0xe1, 0xd2, 0xce, 0xf4, 0x82, 0x1b, 0x5a, 0x3a, 0x6d, 0x43, 0xaa, 0x84,
-	0x32, 0xa5, 0x07, 0x46, 0x49, 0x54, 0x27, 0x33, 0x26, 0x72, 0x25, 0x85, 0x0e, 0xff, 0x82, 0x7f,
-	0xd2, 0x43, 0xff, 0x04, 0x87, 0x1e, 0x38, 0xf6, 0x94, 0xb6, 0x70, 0xcb, 0xaf, 0xe8, 0x58, 0xb6,
-	0x63, 0x07, 0xd2, 0x63, 0x2f, 0xd6, 0xee, 0xb7, 0xdf, 0x7e, 0xbb, 0xb2, 0xb4, 0x82, 0x7f, 0x24,
-	0xe1, 0x2e, 0x95, 0xa2, 0x12, 0xad, 0x7e, 0xbb, 0xc2, 0xfd, 0x8e, 0xed, 0x73, 0x26, 0x19, 0x32,
-	0x64, 0x8f, 0x0c, 0x98, 0x28, 0x6e, 0x0a, 0xc9, 0x38, 0xad, 0xa8, 0xaf, 0xdf, 0xae, 0xc8, 0x73,
-	0x9f, 0x8a, 0x90, 0x52, 0x5c, 0x73, 0x99, 0xcb, 0x94, 0x59, 0x09, 0xac, 0x08, 0x8d, 0x12, 0x3c,
-	0xd2, 0xa6, 0xde, 0xad, 0x04, 0xcb, 0x65, 0xcc, 0xf5, 0x68, 0x45, 0x79, 0xed, 0xe1, 0x87, 0x8a,
-	0xec, 0x9f, 0x52, 0x21, 0xc9, 0xa9, 0x1f, 0x12, 0xca, 0x5f, 0x75, 0xc8, 0xb7, 0xc2, 0x66, 0x30,
-	0xfd, 0x38, 0xa4, 0x42, 0xa2, 0x2a, 0x2c, 0x08, 0x49, 0x24, 0x35, 0xf5, 0x2d, 0x7d, 0x3b, 0x5f,
-	0xfd, 0xd7, 0x0e, 0xfb, 0xb2, 0xa7, 0x69, 0x76, 0x33, 0xe0, 0xe0, 0x90, 0x8a, 0xde, 0xc3, 0xa6,
-	0x4f, 0xb8, 0xec, 0x13, 0xef, 0x84, 0x53, 0xe1, 0xb3, 0x81, 0xa0, 0x27, 0x42, 0x72, 0x22, 0xa9,
-	0x7b, 0x6e, 0x66, 0x94, 0x8e, 0x15, 0xeb, 0x34, 0x42, 0x22, 0x8e, 0x78, 0xcd, 0x88, 0x86, 0x37,
-	0xfc, 0xd9, 0x81, 0xf2, 0xff, 0xb0, 0xa0, 0x8a, 0xa1, 0x2c, 0xcc, 0xed, 0xd6, 0xdf, 0x15, 0x34,
-	0x04, 0x60, 0xec, 0x3e, 0x6d, 0xbd, 0x78, 0xbb, 0x57, 0xd0, 0xd1, 0x12, 0x64, 0x6b, 0xf8, 0xa0,
-	0xd1, 0xd8, 0xab, 0x15, 0x32, 0x65, 0x0f, 0xfe, 0x9a, 0xb4, 0x19, 0xaa, 0xa0, 0x1d, 0xc8, 0x46,
-	0x7f, 0x5b, 0x6d, 0x68, 0xa9, 0xba, 0x31, 0xbd, 0xa1, 0x5a, 0x5f, 0x74, 0xd8, 0x19, 0xe5, 0xe7,
-	0xfb, 0x1a, 0x8e, 0x99, 0xa8, 0x08, 0xd9, 0x4f, 0x84, 0x0f, 0xfa, 0x03, 0x57, 0x75, 0x9f, 0x0b,
-	0x62, 0x11, 0xe0, 0x2c, 0x82, 0xc1, 0xa9, 0x18, 0x7a, 0xb2, 0xfc, 0x45, 0x8f, 0xcb, 0x4d, 0x44,
-	0xd0, 0x6b, 0x58, 0x21, 0x1d, 0xd9, 0x3f, 0xa3, 0xad, 0x49, 0xd1, 0xb9, 0xed, 0xa5, 0xea, 0x5a,
-	0x5c, 0x74, 0x37, 0x15, 0x74, 0x56, 0xc7, 0x23, 0x6b, 0x9a, 0x8e, 0xa7, 0x5d, 0xf4, 0x06, 0xf2,
-	0x5d, 0xce, 0x7c, 0x9f, 0x76, 0x63, 0xbd, 0x8c, 0xd2, 0xfb, 0x3b, 0xd6, 0xab, 0xa5, 0xa3, 0x0e,
-	0x1a, 0x8f, 0xac, 0x5b, 0x09, 0xf8, 0x96, 0x5f, 0xfe, 0x3c, 0x0f, 0xcb, 0xe9, 0x2e, 0xd0, 0x11,
-	0x14, 0xba, 0x51, 0xff, 0xb4, 0xfb, 0x2a, 0xb8, 0x45, 0xf1, 0xaf, 0x5a, 0x8d, 0xab, 0x1c, 0x2b,
-	0xb8, 0x49, 0xa5, 0x63, 0x5e, 0x8e, 0x2c, 0x6d, 0x3c, 0xb2, 0xee, 0xa4, 0xe0, 0x3b, 0x08, 0x7a,
-	0x0c, 0x86, 0x17, 0xca, 0x65, 0x7e, 0x27, 0x97, 0x8f, 0xe4, 0x22, 0x22, 0x8e, 0x56, 0x64, 0x03,
-	0x88, 0x0e, 0x27, 0x3e, 0x6d, 0x30, 0xe6, 0x99, 0x73, 0xc1, 0x19, 0x38, 0xf9, 0xf1, 0xc8, 0x4a,
-	0xa1, 0x38, 0x65, 0xa3, 0xfb, 0x90, 0x0b, 0xbd, 0x43, 0xee, 0x99, 0xf3, 0x8a, 0xbe, 0x32, 0x1e,
-	0x59, 0x09, 0x88, 0x13, 0x33, 0x20, 0xbb, 0x1e, 0x6b, 0x13, 0x2f, 0x20, 0x2f, 0x24, 0xe4, 0x09,
-	0x88, 0x13, 0x33, 0x20, 0x7b, 0x44, 0xc8, 0x3d, 0xce, 0x19, 0x37, 0x8d, 0x84, 0x3c, 0x01, 0x71,
-	0x62, 0x22, 0x0c, 0x10, 0x38, 0x4d, 0x55, 0xca, 0xcc, 0xaa, 0x5d, 0x17, 0xed, 0x70, 0x08, 0xed,
-	0x78, 0x08, 0xed, 0x56, 0x3c, 0x84, 0xce, 0x7a, 0xb4, 0xfd, 0x54, 0xd6, 0xc5, 0x77, 0x4b, 0xc7,
-	0x29, 0x1f, 0x3d, 0x03, 0x94, 0x78, 0xb5, 0x21, 0x27, 0xb2, 0xcf, 0x06, 0xe6, 0xe2, 0x96, 0xbe,
-	0xad, 0x3b, 0xeb, 0xe3, 0x91, 0x35, 0x23, 0x8a, 0x67, 0x60, 0xe8, 0x11, 0x18, 0x3d, 0x4a, 0x3c,
-	0xd9, 0x33, 0x73, 0x6a, 0x20, 0xd7, 0xa6, 0xe7, 0x60, 0x5f, 0xc5, 0x1c, 0x08, 0x0e, 0x23, 0xe4,
-	0xe1, 0x68, 0x2d, 0xf7, 0x60, 0x65, 0xea, 0x9a, 0xfd, 0xb1, 0x1b, 0x73, 0xef, 0x01, 0x2c, 0xa7,
-	0xbb, 0x41, 0x8b, 0x30, 0x5f, 0x3b, 0x38, 0xaa, 0x17, 0x34, 0x64, 0x40, 0xe6, 0xb0, 0x11, 0x8e,
-	0xfb, 0x61, 0xfd, 0x65, 0x3d, 0x00, 0x33, 0xd5, 0xe7, 0x90, 0x8d, 0x07, 0xe5, 0x49, 0x62, 0xae,
-	0xcf, 0x7e, 0xb1, 0x8a, 0x1b, 0x77, 0xf0, 0xf0, 0x89, 0x78, 0xa8, 0x3b, 0xff, 0x5d, 0xfe, 0x2c,
-	0x69, 0x97, 0xd7, 0x25, 0xfd, 0xea, 0xba, 0xa4, 0xff, 0xb8, 0x2e, 0xe9, 0x17, 0x37, 0x25, 0xed,
-	0xea, 0xa6, 0xa4, 0x7d, 0xbb, 0x29, 0x69, 0xc7, 0xb9, 0xc9, 0x73, 0xdd, 0x36, 0xd4, 0x21, 0xee,
-	0xfc, 0x0a, 0x00, 0x00, 0xff, 0xff, 0x2a, 0x5c, 0x84, 0x60, 0xca, 0x05, 0x00, 0x00,
+	// 820 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x55, 0x4f, 0x6f, 0x1b, 0x45,
+	0x14, 0xdf, 0x75, 0x92, 0x75, 0xfc, 0x12, 0xbb, 0xee, 0x28, 0x4d, 0xb6, 0xa6, 0x78, 0x2b, 0x4b,
+	0x88, 0x00, 0xc2, 0x46, 0xee, 0x05, 0x04, 0x97, 0x6c, 0x1d, 0x14, 0x04, 0xb8, 0x66, 0x92, 0x50,
+	0x51, 0x0e, 0xd1, 0xd8, 0x99, 0x6e, 0x8c, 0x36, 0x9e, 0x65, 0x66, 0x5c, 0x14, 0x3e, 0x45, 0x0f,
+	0x48, 0x7c, 0x0c, 0x0e, 0x7c, 0x89, 0x1c, 0x38, 0xf4, 0xc8, 0x69, 0x81, 0xe4, 0xe6, 0x4f, 0x81,
+	0x76, 0x66, 0xf6, 0x8f, 0x1d, 0x07, 0x81, 0x50, 0x2f, 0x3b, 0xef, 0xfd, 0xde, 0x6f, 0x7e, 0xf3,
+	0xe6, 0xcf, 0x7b, 0x0b, 0x6f, 0x48, 0xc2, 0x03, 0x2a, 0x45, 0xc7, 0x8c, 0xd1, 0xb0, 0xc3, 0xa3,
+	0x51, 0x3b, 0xe2, 0x4c, 0x32, 0xe4, 0xc8, 0x33, 0x32, 0x61, 0xa2, 0x71, 0x5f, 0x48, 0xc6, 0x69,
+	0x47, 0x7d, 0xa3, 0x61, 0x47, 0x5e, 0x44, 0x54, 0x68, 0x4a, 0x63, 0x2b, 0x60, 0x01, 0x53, 0x66,
+	0x27, 0xb1, 0x0c, 0x6a, 0x26, 0x84, 0x64, 0x48, 0xc3, 0x85, 0x09, 0x5e, 0xc0, 0x58, 0x10, 0xd2,
+	0x8e, 0xf2, 0x86, 0xd3, 0xe7, 0x1d, 0x39, 0x3e, 0xa7, 0x42, 0x92, 0xf3, 0x48, 0x13, 0x5a, 0xbf,
+	0xd9, 0x50, 0x3b, 0xd2, 0xc9, 0x60, 0xfa, 0xfd, 0x94, 0x0a, 0x89, 0xba, 0xb0, 0x26, 0x24, 0x91,
+	0xd4, 0xb5, 0x1f, 0xda, 0xbb, 0xb5, 0xee, 0x83, 0xb6, 0xce, 0xab, 0x3d, 0x4f, 0x6b, 0x1f, 0x26,
+	0x1c, 0xac, 0xa9, 0xe8, 0x5b, 0xb8, 0x1f, 0x11, 0x2e, 0xc7, 0x24, 0x3c, 0xe1, 0x54, 0x44, 0x6c,
+	0x22, 0xe8, 0x89, 0x90, 0x9c, 0x48, 0x1a, 0x5c, 0xb8, 0x25, 0xa5, 0xe3, 0xa5, 0x3a, 0x03, 0x4d,
+	0xc4, 0x86, 0x77, 0x68, 0x68, 0x78, 0x27, 0x5a, 0x1e, 0x68, 0xbd, 0x03, 0x6b, 0x6a, 0x31, 0x54,
+	0x86, 0x95, 0xbd, 0xfe, 0x37, 0x75, 0x0b, 0x01, 0x38, 0x7b, 0x8f, 0x8f, 0x3e, 0xfb, 0x7a, 0xbf,
+	0x6e, 0xa3, 0x0d, 0x28, 0xf7, 0xf0, 0x93, 0xc1, 0x60, 0xbf, 0x57, 0x2f, 0xb5, 0x42, 0xb8, 0x93,
+	0xa5, 0xa9, 0x55, 0xd0, 0x23, 0x28, 0x9b, 0xd3, 0x56, 0x1b, 0xda, 0xe8, 0xee, 0xcc, 0x6f, 0xa8,
+	0x37, 0x16, 0x23, 0xf6, 0x82, 0xf2, 0x8b, 0x03, 0x0b, 0xa7, 0x4c, 0xd4, 0x80, 0xf2, 0x0f, 0x84,
+	0x4f, 0xc6, 0x93, 0x40, 0x65, 0x5f, 0x49, 0x62, 0x06, 0xf0, 0xd7, 0xc1, 0xe1, 0x54, 0x4c, 0x43,
+	0xd9, 0xfa, 0xd5, 0x86, 0x3b, 0x0b, 0x22, 0xe8, 0x4b, 0xa8, 0x92, 0x91, 0x1c, 0xbf, 0xa0, 0x47,
+	0xd9, 0xa2, 0x2b, 0xbb, 0x1b, 0xdd, 0xad, 0x74, 0xd1, 0xbd, 0x42, 0xd0, 0xbf, 0x3b, 0x8b, 0xbd,
+	0x79, 0x3a, 0x9e, 0x77, 0xd1, 0x57, 0x50, 0x3b, 0xe5, 0x2c, 0x8a, 0xe8, 0x69, 0xaa, 0x57, 0x52,
+	0x7a, 0xf7, 0x52, 0xbd, 0x5e, 0x31, 0xea, 0xa3, 0x59, 0xec, 0x2d, 0x4c, 0xc0, 0x0b, 0x7e, 0xeb,
+	0x97, 0x55, 0xd8, 0x2c, 0x66, 0x81, 0x9e, 0x42, 0xfd, 0xd4, 0xe4, 0x4f, 0x4f, 0xbf, 0x48, 0x5e,
+	0x51, 0x7a, 0x54, 0x77, 0xd3, 0x55, 0x9e, 0x29, 0xf8, 0x90, 0x4a, 0xdf, 0xbd, 0x8c, 0x3d, 0x6b,
+	0x16, 0x7b, 0x37, 0xa6, 0xe0, 0x1b, 0x08, 0xfa, 0x08, 0x9c, 0x50, 0xcb, 0x95, 0x6e, 0x93, 0xab,
+	0x19, 0x39, 0x43, 0xc4, 0x66, 0x44, 0x6d, 0x00, 0x31, 0xe2, 0x24, 0xa2, 0x03, 0xc6, 0x42, 0x77,
+	0x25, 0xb9, 0x03, 0xbf, 0x36, 0x8b, 0xbd, 0x02, 0x8a, 0x0b, 0x36, 0x7a, 0x0f, 0x2a, 0xda, 0x3b,
+	0xe6, 0xa1, 0xbb, 0xaa, 0xe8, 0xd5, 0x59, 0xec, 0xe5, 0x20, 0xce, 0xcd, 0x84, 0x1c, 0x84, 0x6c,
+	0x48, 0xc2, 0x84, 0xbc, 0x96, 0x93, 0x33, 0x10, 0xe7, 0x66, 0x42, 0x0e, 0x89, 0x90, 0xfb, 0x9c,
+	0x33, 0xee, 0x3a, 0x39, 0x39, 0x03, 0x71, 0x6e, 0x22, 0x0c, 0x90, 0x38, 0x87, 0x6a, 0x29, 0xb7,
+	0xac, 0x76, 0xdd, 0x68, 0xeb, 0x22, 0x6c, 0xa7, 0x45, 0xd8, 0x3e, 0x4a, 0x8b, 0xd0, 0xdf, 0x36,
+	0xdb, 0x2f, 0xcc, 0x7a, 0xf9, 0x87, 0x67, 0xe3, 0x82, 0x8f, 0x3e, 0x05, 0x94, 0x7b, 0xbd, 0x29,
+	0x27, 0x72, 0xcc, 0x26, 0xee, 0xfa, 0x43, 0x7b, 0xd7, 0xf6, 0xb7, 0x67, 0xb1, 0xb7, 0x24, 0x8a,
+	0x97, 0x60, 0xe8, 0x43, 0x70, 0xce, 0x28, 0x09, 0xe5, 0x99, 0x5b, 0x51, 0x05, 0xb9, 0x35, 0x5f,
+	0x07, 0x07, 0x2a, 0xe6, 0x43, 0x72, 0x19, 0x9a, 0x87, 0xcd, 0xd8, 0x3a, 0x83, 0xea, 0xdc, 0x33,
+	0x7b, 0x6d, 0x2f, 0xa6, 0x25, 0x60, 0x4b, 0x67, 0xfd, 0x98, 0x4d, 0x9e, 0x8f, 0x83, 0xac, 0x27,
+	0xfd, 0x63, 0x7f, 0xb1, 0xff, 0x67, 0x7f, 0xf9, 0x11, 0xee, 0x2d, 0x2c, 0x6a, 0x5a, 0xc7, 0xc7,
+	0x50, 0xd5, 0x8f, 0xe6, 0x64, 0xa4, 0x22, 0x66, 0x8f, 0xd9, 0xc1, 0x15, 0x67, 0x1d, 0x58, 0x78,
+	0x53, 0x14, 0xfc, 0x7f, 0xd9, 0x42, 0x7e, 0xb2, 0x61, 0xb3, 0x28, 0x83, 0xde, 0x82, 0xf2, 0x77,
+	0x6c, 0xd8, 0x27, 0xe7, 0xba, 0xff, 0x56, 0xfc, 0x8d, 0x59, 0xec, 0xa5, 0x10, 0x4e, 0x0d, 0xd4,
+	0x02, 0xc7, 0xe4, 0xa4, 0xc4, 0xf5, 0xb5, 0x69, 0x04, 0x9b, 0xb1, 0x50, 0x7e, 0x2b, 0xff, 0xb1,
+	0xfc, 0xde, 0x7d, 0x1f, 0x36, 0x8b, 0xaf, 0x02, 0xad, 0xc3, 0x6a, 0xef, 0xc9, 0xd3, 0x7e, 0xdd,
+	0x42, 0x0e, 0x94, 0x8e, 0x07, 0xba, 0xed, 0x1e, 0xf7, 0x3f, 0xef, 0x27, 0x60, 0xa9, 0xfb, 0xb3,
+	0x0d, 0xe5, 0xb4, 0x63, 0x7d, 0x92, 0x9b, 0xdb, 0xcb, 0x7f, 0x1d, 0x8d, 0x9d, 0x1b, 0xb8, 0x3e,
+	0xf0, 0x0f, 0x6c, 0xd4, 0x87, 0xea, 0xdc, 0x5d, 0xa0, 0x07, 0xcb, 0x0e, 0x3b, 0x53, 0x7a, 0xf3,
+	0x96, 0x68, 0xaa, 0xe7, 0xbf, 0x7d, 0xf9, 0x57, 0xd3, 0xba, 0xbc, 0x6a, 0xda, 0xaf, 0xae, 0x9a,
+	0xf6, 0x9f, 0x57, 0x4d, 0xfb, 0xe5, 0x75, 0xd3, 0x7a, 0x75, 0xdd, 0xb4, 0x7e, 0xbf, 0x6e, 0x5a,
+	0xcf, 0x2a, 0xd9, 0x7f, 0x78, 0xe8, 0xa8, 0xea, 0x7c, 0xf4, 0xf7, 0x00, 0xc2, 0x2f, 0x91, 0x4a,
+	0xa3, 0x07, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -412,6 +586,9 @@ type TargetsClient interface {
 	/// Targets has info for all targets.
 	/// Returned targets are expected to include external labels.
 	Targets(ctx context.Context, in *TargetsRequest, opts ...grpc.CallOption) (Targets_TargetsClient, error)
+	/// ScrapeConfigs has the scrape configs of all the Prometheus servers.
+	/// Returned scrape configs are expected to include external labels.
+	ScrapeConfigs(ctx context.Context, in *ScrapeConfigsRequest, opts ...grpc.CallOption) (Targets_ScrapeConfigsClient, error)
 }
 
 type targetsClient struct {
@@ -454,11 +631,46 @@ func (x *targetsTargetsClient) Recv() (*TargetsResponse, error) {
 	return m, nil
 }
 
+func (c *targetsClient) ScrapeConfigs(ctx context.Context, in *ScrapeConfigsRequest, opts ...grpc.CallOption) (Targets_ScrapeConfigsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Targets_serviceDesc.Streams[1], "/thanos.Targets/ScrapeConfigs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &targetsScrapeConfigsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Targets_ScrapeConfigsClient interface {
+	Recv() (*ScrapeConfigsResponse, error)
+	grpc.ClientStream
+}
+
+type targetsScrapeConfigsClient struct {
+	grpc.ClientStream
+}
+
+func (x *targetsScrapeConfigsClient) Recv() (*ScrapeConfigsResponse, error) {
+	m := new(ScrapeConfigsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // TargetsServer is the server API for Targets service.
 type TargetsServer interface {
 	/// Targets has info for all targets.
 	/// Returned targets are expected to include external labels.
 	Targets(*TargetsRequest, Targets_TargetsServer) error
+	/// ScrapeConfigs has the scrape configs of all the Prometheus servers.
+	/// Returned scrape configs are expected to include external labels.
+	ScrapeConfigs(*ScrapeConfigsRequest, Targets_ScrapeConfigsServer) error
 }
 
 // UnimplementedTargetsServer can be embedded to have forward compatible implementations.
@@ -468,6 +680,9 @@ type UnimplementedTargetsServer struct {
 func (*UnimplementedTargetsServer) Targets(req *TargetsRequest, srv Targets_TargetsServer) error {
 	return status.Errorf(codes.Unimplemented, "method Targets not implemented")
 }
+func (*UnimplementedTargetsServer) ScrapeConfigs(req *ScrapeConfigsRequest, srv Targets_ScrapeConfigsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ScrapeConfigs not implemented")
+}
 
 func RegisterTargetsServer(s *grpc.Server, srv TargetsServer) {
 	s.RegisterService(&_Targets_serviceDesc, srv)
@@ -494,6 +709,27 @@ func (x *targetsTargetsServer) Send(m *TargetsResponse) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _Targets_ScrapeConfigs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ScrapeConfigsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TargetsServer).ScrapeConfigs(m, &targetsScrapeConfigsServer{stream})
+}
+
+type Targets_ScrapeConfigsServer interface {
+	Send(*ScrapeConfigsResponse) error
+	grpc.ServerStream
+}
+
+type targetsScrapeConfigsServer struct {
+	grpc.ServerStream
+}
+
+func (x *targetsScrapeConfigsServer) Send(m *ScrapeConfigsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _Targets_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "thanos.Targets",
 	HandlerType: (*TargetsServer)(nil),
@@ -504,6 +740,11 @@ var _Targets_serviceDesc = grpc.ServiceDesc{
 			Handler:       _Targets_Targets_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "ScrapeConfigs",
+			Handler:       _Targets_ScrapeConfigs_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "targets/targetspb/rpc.proto",
 }
@@ -782,6 +1023,148 @@ func (m *DroppedTarget) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	return len(dAtA) - i, nil
 }
 
+func (m *ScrapeConfigsRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ScrapeConfigsRequest) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *ScrapeConfigsRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.PartialResponseStrategy != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.PartialResponseStrategy))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *ScrapeConfigsResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ScrapeConfigsResponse) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *ScrapeConfigsResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.Result != nil {
+		{
+			size := m.Result.Size()
+			i -= size
+			if _, err := m.Result.MarshalTo(dAtA[i:]); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *ScrapeConfigsResponse_ScrapeConfig) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *ScrapeConfigsResponse_ScrapeConfig) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	if m.ScrapeConfig != nil {
+		{
+			size, err := m.ScrapeConfig.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintRpc(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+func (m *ScrapeConfigsResponse_Warning) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *ScrapeConfigsResponse_Warning) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	i -= len(m.Warning)
+	copy(dAtA[i:], m.Warning)
+	i = encodeVarintRpc(dAtA, i, uint64(len(m.Warning)))
+	i--
+	dAtA[i] = 0x12
+	return len(dAtA) - i, nil
+}
+func (m *ScrapeConfig) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ScrapeConfig) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *ScrapeConfig) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	{
+		size, err := m.Labels.MarshalToSizedBuffer(dAtA[:i])
+		if err != nil {
+			return 0, err
+		}
+		i -= size
+		i = encodeVarintRpc(dAtA, i, uint64(size))
+	}
+	i--
+	dAtA[i] = 0x1a
+	if len(m.Config) > 0 {
+		i -= len(m.Config)
+		copy(dAtA[i:], m.Config)
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Config)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if len(m.JobName) > 0 {
+		i -= len(m.JobName)
+		copy(dAtA[i:], m.JobName)
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.JobName)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
 func encodeVarintRpc(dAtA []byte, offset int, v uint64) int {
 	offset -= sovRpc(v)
 	base := offset
@@ -911,10 +1294,75 @@ func (m *DroppedTarget) Size() (n int) {
 	return n
 }
 
-func sovRpc(x uint64) (n int) {
-	return (math_bits.Len64(x|1) + 6) / 7
-}
-func sozRpc(x uint64) (n int) {
+func (m *ScrapeConfigsRequest) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.PartialResponseStrategy != 0 {
+		n += 1 + sovRpc(uint64(m.PartialResponseStrategy))
+	}
+	return n
+}
+
+func (m *ScrapeConfigsResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Result != nil {
+		n += m.Result.Size()
+	}
+	return n
+}
+
+func (m *ScrapeConfigsResponse_ScrapeConfig) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.ScrapeConfig != nil {
+		l = m.ScrapeConfig.Size()
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	return n
+}
+func (m *ScrapeConfigsResponse_Warning) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Warning)
+	n += 1 + l + sovRpc(uint64(l))
+	return n
+}
+func (m *ScrapeConfig) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.JobName)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	l = len(m.Config)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	l = m.Labels.Size()
+	n += 1 + l + sovRpc(uint64(l))
+	return n
+}
+
+func sovRpc(x uint64) (n int) {
+	return (math_bits.Len64(x|1) + 6) / 7
+}
+func sozRpc(x uint64) (n int) {
 	return sovRpc(uint64((x << 1) ^ uint64((int64(x) >> 63))))
 }
 func (m *TargetsRequest) Unmarshal(dAtA []byte) error {
@@ -1630,6 +2078,339 @@ func (m *DroppedTarget) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *ScrapeConfigsRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: ScrapeConfigsRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: ScrapeConfigsRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PartialResponseStrategy", wireType)
+			}
+			m.PartialResponseStrategy = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.PartialResponseStrategy |= storepb.PartialResponseStrategy(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *ScrapeConfigsResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: ScrapeConfigsResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: ScrapeConfigsResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ScrapeConfig", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			v := &ScrapeConfig{}
+			if err := v.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			m.Result = &ScrapeConfigsResponse_ScrapeConfig{v}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Warning", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Result = &ScrapeConfigsResponse_Warning{string(dAtA[iNdEx:postIndex])}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *ScrapeConfig) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: ScrapeConfig: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: ScrapeConfig: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field JobName", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.JobName = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Config", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Config = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Labels", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if err := m.Labels.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func skipRpc(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
@@ -161,6 +161,17 @@ func EnforceQueryTenancy(tenantLabel string, tenant string, query string) (strin
 	return expr.String(), nil
 }
 
+// EnforceMatchersTenancy returns the label matchers with the matcher of the tenant label, and an error if the
+// matchers select series of other tenants.
+func EnforceMatchersTenancy(tenantLabel string, tenant string, matchers []*labels.Matcher) ([]*labels.Matcher, error) {
+	e := injectproxy.NewPromQLEnforcer(false, &labels.Matcher{
+		Name:  tenantLabel,
+		Type:  labels.MatchEqual,
+		Value: tenant,
+	})
+	return e.EnforceMatchers(matchers)
+}
+
 func getLabelMatchers(formMatchers []string, tenant string, enforceTenancy bool, tenantLabel string) ([][]*labels.Matcher, error) {
 	tenantLabelMatcher := &labels.Matcher{
 		Name:  tenantLabel,
@@ -185,8 +196,7 @@ func getLabelMatchers(formMatchers []string, tenant string, enforceTenancy bool,
 		}
 
 		if enforceTenancy {
-			e := injectproxy.NewPromQLEnforcer(false, tenantLabelMatcher)
-			matchers, err = e.EnforceMatchers(matchers)
+			matchers, err = EnforceMatchersTenancy(tenantLabel, tenant, matchers)
 			if err != nil {
 				return nil, err
 			}
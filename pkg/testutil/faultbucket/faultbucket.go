@@ -0,0 +1,482 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package faultbucket provides an objstore.Bucket injecting faults into the operations of another bucket: latency,
+// errors, partial reads, uploads failing after some bytes and eventual consistency. It is meant to test components
+// using the object storage, e.g. compaction, custom planners or compaction callbacks, against the failures of
+// object storages.
+package faultbucket
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+)
+
+// ErrInjected is the error of the operations failed by faults without error.
+var ErrInjected = errors.New("injected fault")
+
+// errNotVisible is the error of reads of objects not visible yet, or anymore.
+var errNotVisible = errors.New("object not visible yet")
+
+// Fault is a fault injected into the operations of a bucket.
+type Fault struct {
+	// Ops the fault applies to, e.g. objstore.OpUpload. All operations if empty.
+	Ops []string
+	// Match selects the objects the fault applies to, the directory for Iter. All objects if nil.
+	Match func(name string) bool
+	// Probability of the fault for every matching operation. Zero means always.
+	Probability float64
+	// Times the fault is injected before it is disabled. Unlimited if zero.
+	Times int
+
+	// Latency is added to the operation, or until its context is canceled.
+	Latency time.Duration
+	// Err makes the operation fail with the error, without performing it.
+	Err error
+	// PartialRead makes the readers returned by Get and GetRange fail.
+	PartialRead *PartialRead
+	// PartialUpload makes Upload fail.
+	PartialUpload *PartialUpload
+}
+
+// PartialRead configures readers failing after some bytes.
+type PartialRead struct {
+	// AfterBytes is the number of bytes read before the failure.
+	AfterBytes int64
+	// Err is returned after AfterBytes bytes. The reader silently ends if nil, like a truncated object.
+	Err error
+}
+
+// PartialUpload configures uploads failing after reading some bytes.
+type PartialUpload struct {
+	// AfterBytes is the number of bytes read from the uploaded reader before the failure.
+	AfterBytes int64
+	// Keep stores the bytes read before the failure as the object, like object storages without atomic uploads.
+	Keep bool
+}
+
+// Latency returns a fault adding latency to all operations.
+func Latency(d time.Duration) Fault {
+	return Fault{Latency: d}
+}
+
+// FailOps returns a fault failing the given operations of the objects matching the function with ErrInjected.
+func FailOps(match func(string) bool, ops ...string) Fault {
+	return Fault{Ops: ops, Match: match, Err: ErrInjected}
+}
+
+// FailReadsAfter returns a fault failing Get and GetRange readers of the objects matching the function with
+// io.ErrUnexpectedEOF, like a reset connection, after the given bytes.
+func FailReadsAfter(match func(string) bool, afterBytes int64) Fault {
+	return Fault{
+		Ops:         []string{objstore.OpGet, objstore.OpGetRange},
+		Match:       match,
+		PartialRead: &PartialRead{AfterBytes: afterBytes, Err: io.ErrUnexpectedEOF},
+	}
+}
+
+// FailUploadsAfter returns a fault failing the uploads of the objects matching the function after reading the
+// given bytes.
+func FailUploadsAfter(match func(string) bool, afterBytes int64) Fault {
+	return Fault{Ops: []string{objstore.OpUpload}, Match: match, PartialUpload: &PartialUpload{AfterBytes: afterBytes}}
+}
+
+// MatchPrefix returns a function matching the names with the prefix.
+func MatchPrefix(prefix string) func(string) bool {
+	return func(name string) bool { return strings.HasPrefix(name, prefix) }
+}
+
+// MatchSuffix returns a function matching the names with the suffix.
+func MatchSuffix(suffix string) func(string) bool {
+	return func(name string) bool { return strings.HasSuffix(name, suffix) }
+}
+
+// Option configures a Bucket.
+type Option func(*Bucket)
+
+// WithSeed seeds the injection of faults with a probability, for reproducible tests.
+func WithSeed(seed int64) Option {
+	return func(b *Bucket) { b.rand = rand.New(rand.NewSource(seed)) }
+}
+
+// WithClock sets the clock of the eventual consistency, time.Now by default.
+func WithClock(now func() time.Time) Option {
+	return func(b *Bucket) { b.now = now }
+}
+
+// WithConsistencyDelay emulates an eventually consistent object storage: uploaded objects are only visible to
+// Iter, Get, GetRange, Exists and Attributes after the delay, and deleted objects are still listed by Iter during
+// the delay.
+func WithConsistencyDelay(d time.Duration) Option {
+	return func(b *Bucket) { b.consistencyDelay = d }
+}
+
+type injected struct {
+	Fault
+	remaining int
+}
+
+// Bucket is an objstore.Bucket injecting faults into the operations of the wrapped bucket. Faults are injected in
+// the order they were added, and all the applicable ones are injected in every operation.
+type Bucket struct {
+	objstore.Bucket
+
+	now              func() time.Time
+	consistencyDelay time.Duration
+
+	mtx       sync.Mutex
+	rand      *rand.Rand
+	faults    []*injected
+	triggered int
+	uploaded  map[string]time.Time
+	deleted   map[string]time.Time
+}
+
+// NewBucket wraps the bucket to inject faults into its operations.
+func NewBucket(bkt objstore.Bucket, opts ...Option) *Bucket {
+	b := &Bucket{
+		Bucket:   bkt,
+		now:      time.Now,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		uploaded: map[string]time.Time{},
+		deleted:  map[string]time.Time{},
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	return b
+}
+
+// Inject adds the faults to the operations of the bucket.
+func (b *Bucket) Inject(faults ...Fault) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	for _, f := range faults {
+		b.faults = append(b.faults, &injected{Fault: f, remaining: f.Times})
+	}
+}
+
+// Reset removes all faults. The eventual consistency is kept.
+func (b *Bucket) Reset() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.faults = nil
+}
+
+// Triggered returns the number of faults injected so far.
+func (b *Bucket) Triggered() int {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.triggered
+}
+
+// faultsFor returns the faults to inject into the operation.
+func (b *Bucket) faultsFor(op, name string) []Fault {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	var out []Fault
+	for _, f := range b.faults {
+		if f.Times > 0 && f.remaining == 0 {
+			continue
+		}
+		if len(f.Ops) > 0 && !contains(f.Ops, op) {
+			continue
+		}
+		if f.Match != nil && !f.Match(name) {
+			continue
+		}
+		if f.Probability > 0 && b.rand.Float64() >= f.Probability {
+			continue
+		}
+		if f.Times > 0 {
+			f.remaining--
+		}
+		b.triggered++
+		out = append(out, f.Fault)
+	}
+	return out
+}
+
+func contains(ops []string, op string) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// inject injects the faults into the operation, returning the error of the operation if it fails.
+func inject(ctx context.Context, faults []Fault) error {
+	for _, f := range faults {
+		if f.Latency > 0 {
+			select {
+			case <-time.After(f.Latency):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	for _, f := range faults {
+		if f.Err != nil {
+			return f.Err
+		}
+	}
+	return nil
+}
+
+// visible returns whether the object is visible, given the eventual consistency.
+func (b *Bucket) visible(name string) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	t, ok := b.uploaded[name]
+	if !ok {
+		return true
+	}
+	if b.now().Sub(t) >= b.consistencyDelay {
+		delete(b.uploaded, name)
+		return true
+	}
+	return false
+}
+
+// Iter calls f for each visible entry of the directory.
+func (b *Bucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	return b.IterWithAttributes(ctx, dir, func(attrs objstore.IterObjectAttributes) error { return f(attrs.Name) }, options...)
+}
+
+// IterWithAttributes calls f for each visible entry of the directory, with its attributes.
+func (b *Bucket) IterWithAttributes(ctx context.Context, dir string, f func(objstore.IterObjectAttributes) error, options ...objstore.IterOption) error {
+	if err := inject(ctx, b.faultsFor(objstore.OpIter, dir)); err != nil {
+		return err
+	}
+	if b.consistencyDelay <= 0 {
+		return b.Bucket.IterWithAttributes(ctx, dir, f, options...)
+	}
+
+	var (
+		recursive = objstore.ApplyIterOptions(options...).Recursive
+		entries   = map[string]objstore.IterObjectAttributes{}
+	)
+	if err := b.Bucket.IterWithAttributes(ctx, dir, func(attrs objstore.IterObjectAttributes) error {
+		if strings.HasSuffix(attrs.Name, objstore.DirDelim) {
+			ok, err := b.dirVisible(ctx, attrs.Name)
+			if err != nil || !ok {
+				return err
+			}
+		} else if !b.visible(attrs.Name) {
+			return nil
+		}
+		entries[attrs.Name] = attrs
+		return nil
+	}, options...); err != nil {
+		return err
+	}
+
+	// Deleted objects are still listed during the consistency delay.
+	prefix := strings.TrimSuffix(dir, objstore.DirDelim)
+	if prefix != "" {
+		prefix += objstore.DirDelim
+	}
+	b.mtx.Lock()
+	for name, t := range b.deleted {
+		if b.now().Sub(t) >= b.consistencyDelay {
+			delete(b.deleted, name)
+			continue
+		}
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		entry := name
+		if rest := strings.TrimPrefix(name, prefix); !recursive && strings.Contains(rest, objstore.DirDelim) {
+			entry = prefix + rest[:strings.Index(rest, objstore.DirDelim)+1]
+		}
+		if _, ok := entries[entry]; !ok {
+			entries[entry] = objstore.IterObjectAttributes{Name: entry}
+		}
+	}
+	b.mtx.Unlock()
+
+	names := make([]string, 0, len(entries))
+	for n := range entries {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if err := f(entries[n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirVisible returns whether the directory has visible objects.
+func (b *Bucket) dirVisible(ctx context.Context, dir string) (bool, error) {
+	found := false
+	err := b.Bucket.Iter(ctx, dir, func(name string) error {
+		if b.visible(name) {
+			found = true
+			return errStopIter
+		}
+		return nil
+	}, objstore.WithRecursiveIter())
+	if err == errStopIter {
+		err = nil
+	}
+	return found, err
+}
+
+var errStopIter = errors.New("stop iteration")
+
+// Get returns a reader of the object, failing after some bytes if a partial read is injected.
+func (b *Bucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	faults := b.faultsFor(objstore.OpGet, name)
+	if err := inject(ctx, faults); err != nil {
+		return nil, err
+	}
+	if !b.visible(name) {
+		return nil, errNotVisible
+	}
+	rc, err := b.Bucket.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return partialReader(rc, faults), nil
+}
+
+// GetRange returns a reader of the range of the object, failing after some bytes if a partial read is injected.
+func (b *Bucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	faults := b.faultsFor(objstore.OpGetRange, name)
+	if err := inject(ctx, faults); err != nil {
+		return nil, err
+	}
+	if !b.visible(name) {
+		return nil, errNotVisible
+	}
+	rc, err := b.Bucket.GetRange(ctx, name, off, length)
+	if err != nil {
+		return nil, err
+	}
+	return partialReader(rc, faults), nil
+}
+
+func partialReader(rc io.ReadCloser, faults []Fault) io.ReadCloser {
+	for _, f := range faults {
+		if f.PartialRead != nil {
+			return &failingReader{ReadCloser: rc, remaining: f.PartialRead.AfterBytes, err: f.PartialRead.Err}
+		}
+	}
+	return rc
+}
+
+type failingReader struct {
+	io.ReadCloser
+	remaining int64
+	err       error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
+
+// Exists checks if the object exists and is visible.
+func (b *Bucket) Exists(ctx context.Context, name string) (bool, error) {
+	if err := inject(ctx, b.faultsFor(objstore.OpExists, name)); err != nil {
+		return false, err
+	}
+	if !b.visible(name) {
+		return false, nil
+	}
+	return b.Bucket.Exists(ctx, name)
+}
+
+// Attributes returns the attributes of the object if it is visible.
+func (b *Bucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	if err := inject(ctx, b.faultsFor(objstore.OpAttributes, name)); err != nil {
+		return objstore.ObjectAttributes{}, err
+	}
+	if !b.visible(name) {
+		return objstore.ObjectAttributes{}, errNotVisible
+	}
+	return b.Bucket.Attributes(ctx, name)
+}
+
+// IsObjNotFoundErr returns true if the error means that the object is not found, or not visible.
+func (b *Bucket) IsObjNotFoundErr(err error) bool {
+	return errors.Is(err, errNotVisible) || b.Bucket.IsObjNotFoundErr(err)
+}
+
+// Upload uploads the object, failing after some bytes if a partial upload is injected.
+func (b *Bucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	faults := b.faultsFor(objstore.OpUpload, name)
+	if err := inject(ctx, faults); err != nil {
+		return err
+	}
+	for _, f := range faults {
+		if f.PartialUpload == nil {
+			continue
+		}
+		read, err := io.ReadAll(io.LimitReader(r, f.PartialUpload.AfterBytes))
+		if err != nil {
+			return err
+		}
+		if f.PartialUpload.Keep {
+			if err := b.upload(ctx, name, bytes.NewReader(read)); err != nil {
+				return err
+			}
+		}
+		return errors.Wrapf(ErrInjected, "upload of %s failed after %d bytes", name, len(read))
+	}
+	return b.upload(ctx, name, r)
+}
+
+func (b *Bucket) upload(ctx context.Context, name string, r io.Reader) error {
+	if err := b.Bucket.Upload(ctx, name, r); err != nil {
+		return err
+	}
+	if b.consistencyDelay > 0 {
+		b.mtx.Lock()
+		b.uploaded[name] = b.now()
+		delete(b.deleted, name)
+		b.mtx.Unlock()
+	}
+	return nil
+}
+
+// Delete deletes the object, which is still listed during the consistency delay.
+func (b *Bucket) Delete(ctx context.Context, name string) error {
+	if err := inject(ctx, b.faultsFor(objstore.OpDelete, name)); err != nil {
+		return err
+	}
+	if err := b.Bucket.Delete(ctx, name); err != nil {
+		return err
+	}
+	if b.consistencyDelay > 0 {
+		b.mtx.Lock()
+		b.deleted[name] = b.now()
+		delete(b.uploaded, name)
+		b.mtx.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package faultbucket
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+)
+
+func TestBucket_Faults(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	inner := objstore.NewInMemBucket()
+	b := NewBucket(inner, WithSeed(1))
+	testutil.Ok(t, b.Upload(ctx, "a/1", strings.NewReader("0123456789")))
+
+	// Errors of the matching operations, a limited number of times.
+	f := FailOps(MatchPrefix("a/"), objstore.OpExists)
+	f.Times = 2
+	b.Inject(f)
+	for i := 0; i < 2; i++ {
+		_, err := b.Exists(ctx, "a/1")
+		testutil.Equals(t, ErrInjected, err)
+	}
+	ok, err := b.Exists(ctx, "a/1")
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, 2, b.Triggered())
+	b.Reset()
+
+	// Partial reads.
+	b.Inject(FailReadsAfter(MatchSuffix("/1"), 4))
+	rc, err := b.Get(ctx, "a/1")
+	testutil.Ok(t, err)
+	content, err := io.ReadAll(rc)
+	testutil.Equals(t, io.ErrUnexpectedEOF, err)
+	testutil.Equals(t, "0123", string(content))
+	testutil.Ok(t, rc.Close())
+
+	rc, err = b.GetRange(ctx, "a/1", 2, 6)
+	testutil.Ok(t, err)
+	content, err = io.ReadAll(rc)
+	testutil.Equals(t, io.ErrUnexpectedEOF, err)
+	testutil.Equals(t, "2345", string(content))
+	testutil.Ok(t, rc.Close())
+	b.Reset()
+
+	// Partial uploads, keeping the bytes read or not.
+	b.Inject(FailUploadsAfter(MatchPrefix("b/"), 3))
+	testutil.Assert(t, errors.Is(b.Upload(ctx, "b/1", strings.NewReader("0123456789")), ErrInjected))
+	ok, err = inner.Exists(ctx, "b/1")
+	testutil.Ok(t, err)
+	testutil.Assert(t, !ok)
+	b.Reset()
+
+	b.Inject(Fault{Ops: []string{objstore.OpUpload}, PartialUpload: &PartialUpload{AfterBytes: 3, Keep: true}})
+	testutil.Assert(t, errors.Is(b.Upload(ctx, "b/1", strings.NewReader("0123456789")), ErrInjected))
+	attrs, err := inner.Attributes(ctx, "b/1")
+	testutil.Ok(t, err)
+	testutil.Equals(t, int64(3), attrs.Size)
+	b.Reset()
+
+	// Latency is interrupted by the context.
+	b.Inject(Latency(time.Hour))
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = b.Get(cctx, "a/1")
+	testutil.Equals(t, context.DeadlineExceeded, err)
+	b.Reset()
+
+	// Probabilistic faults are injected for some operations only.
+	b.Inject(Fault{Ops: []string{objstore.OpAttributes}, Probability: 0.5, Err: ErrInjected})
+	failed := 0
+	for i := 0; i < 100; i++ {
+		if _, err := b.Attributes(ctx, "a/1"); err != nil {
+			failed++
+		}
+	}
+	testutil.Assert(t, failed > 0 && failed < 100, "failed %d times", failed)
+}
+
+func TestBucket_ConsistencyDelay(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx = context.Background()
+		now = time.Unix(0, 0)
+		b   = NewBucket(objstore.NewInMemBucket(), WithConsistencyDelay(time.Minute), WithClock(func() time.Time { return now }))
+	)
+	list := func(dir string, opts ...objstore.IterOption) []string {
+		t.Helper()
+
+		var names []string
+		testutil.Ok(t, b.Iter(ctx, dir, func(name string) error {
+			names = append(names, name)
+			return nil
+		}, opts...))
+		return names
+	}
+
+	testutil.Ok(t, b.Upload(ctx, "a/1", strings.NewReader("1")))
+	testutil.Ok(t, b.Upload(ctx, "b/1", strings.NewReader("1")))
+	now = now.Add(time.Minute)
+	testutil.Ok(t, b.Upload(ctx, "a/2", strings.NewReader("2")))
+	testutil.Ok(t, b.Upload(ctx, "c/1", strings.NewReader("1")))
+	testutil.Ok(t, b.Delete(ctx, "b/1"))
+
+	// New objects are not visible yet, deleted objects are still listed.
+	testutil.Equals(t, []string{"a/", "b/"}, list(""))
+	testutil.Equals(t, []string{"a/1"}, list("a"))
+	testutil.Equals(t, []string{"a/1", "b/1"}, list("", objstore.WithRecursiveIter()))
+	ok, err := b.Exists(ctx, "a/2")
+	testutil.Ok(t, err)
+	testutil.Assert(t, !ok)
+	_, err = b.Get(ctx, "a/2")
+	testutil.Assert(t, b.IsObjNotFoundErr(err))
+
+	now = now.Add(time.Minute)
+	testutil.Equals(t, []string{"a/", "c/"}, list(""))
+	testutil.Equals(t, []string{"a/1", "a/2"}, list("a/"))
+	ok, err = b.Exists(ctx, "a/2")
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok)
+}
@@ -54,8 +54,8 @@ func (it *HistogramIterator) AtHistogram(*histogram.Histogram) (int64, *histogra
 	return it.l[it.i].T, it.l[it.i].H
 }
 
-func (it *HistogramIterator) AtFloatHistogram(*histogram.FloatHistogram) (int64, *histogram.FloatHistogram) {
-	panic("not implemented")
+func (it *HistogramIterator) AtFloatHistogram(fh *histogram.FloatHistogram) (int64, *histogram.FloatHistogram) {
+	return it.l[it.i].T, it.l[it.i].H.ToFloat(fh)
 }
 
 func (it *HistogramIterator) AtT() int64 {
@@ -8,8 +8,10 @@ import (
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware/v2"
 	"github.com/opentracing/opentracing-go"
-	grpc_opentracing "github.com/thanos-io/thanos/pkg/tracing/tracing_middleware"
 	"google.golang.org/grpc"
+
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
+	grpc_opentracing "github.com/thanos-io/thanos/pkg/tracing/tracing_middleware"
 )
 
 // UnaryClientInterceptor returns a new unary client interceptor for OpenTracing.
@@ -27,7 +29,10 @@ func UnaryServerInterceptor(tracer opentracing.Tracer) grpc.UnaryServerIntercept
 	interceptor := grpc_opentracing.UnaryServerInterceptor(grpc_opentracing.WithTracer(tracer))
 	return func(parentCtx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 		// Add our own tracer.
-		return interceptor(ContextWithTracer(parentCtx, tracer), req, info, handler)
+		return interceptor(ContextWithTracer(parentCtx, tracer), req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			tagRequestID(ctx)
+			return handler(ctx, req)
+		})
 	}
 }
 
@@ -39,6 +44,20 @@ func StreamServerInterceptor(tracer opentracing.Tracer) grpc.StreamServerInterce
 		wrappedStream := grpc_middleware.WrapServerStream(stream)
 		wrappedStream.WrappedContext = ContextWithTracer(stream.Context(), tracer)
 
-		return interceptor(srv, wrappedStream, info, handler)
+		return interceptor(srv, wrappedStream, info, func(srv interface{}, stream grpc.ServerStream) error {
+			tagRequestID(stream.Context())
+			return handler(srv, stream)
+		})
+	}
+}
+
+// tagRequestID tags the server span of the context with the request ID of the context.
+func tagRequestID(ctx context.Context) {
+	reqID, ok := middleware.RequestIDFromContext(ctx)
+	if !ok {
+		return
+	}
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.SetTag("request_id", reqID)
 	}
 }
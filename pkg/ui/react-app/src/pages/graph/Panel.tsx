@@ -213,6 +213,7 @@ class Panel extends Component<PanelProps & PathPrefixProps, PanelState> {
       query: expr,
       dedup: this.props.options.useDeduplication.toString(),
       partial_response: this.props.options.usePartialResponse.toString(),
+      stats: 'true',
     });
 
     // Add storeMatches to query params.
@@ -304,6 +305,8 @@ class Panel extends Component<PanelProps & PathPrefixProps, PanelState> {
             resolution,
             resultSeries,
             traceID,
+            stores: json.data?.stats?.stores,
+            deduplication: json.data?.stats?.deduplication,
           },
           loading: false,
           analysis: analysis,
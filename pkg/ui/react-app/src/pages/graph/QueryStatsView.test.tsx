@@ -17,4 +17,30 @@ describe('QueryStatsView', () => {
       `<span class="float-right">Load time: ${queryStatsProps.loadTime}ms   Resolution: ${queryStatsProps.resolution}s   Result series: ${queryStatsProps.resultSeries}   Trace ID: ${queryStatsProps.traceID}</span>`
     );
   });
+
+  it('renders the deduplication and store stats', () => {
+    const queryStatsProps = {
+      loadTime: 100,
+      resolution: 5,
+      resultSeries: 2,
+      traceID: null,
+      deduplication: { inputSeries: 4, outputSeries: 2 },
+      stores: [
+        {
+          address: 'store-gateway:10901',
+          calls: 1,
+          durationSeconds: 0.25,
+          series: 4,
+          chunks: 8,
+          samples: 960,
+          bytes: 2048,
+          postingsCacheHitRatio: 0.5,
+        },
+      ],
+    };
+    const queryStatsView = mount(<QueryStatsView {...queryStatsProps} />);
+    expect(queryStatsView.find('.float-right').text()).toContain('Deduplicated series: 4 → 2');
+    const cells = queryStatsView.find('tbody td').map((td) => td.text());
+    expect(cells).toEqual(['store-gateway:10901', '250ms', '4', '8', '960', '2048', '50.0%', '-', '-']);
+  });
 });
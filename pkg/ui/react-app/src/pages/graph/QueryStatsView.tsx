@@ -1,20 +1,84 @@
 import React, { FC } from 'react';
+import { Table } from 'reactstrap';
+
+export interface StoreStats {
+  address: string;
+  labelSets?: string;
+  calls: number;
+  durationSeconds: number;
+  series: number;
+  chunks: number;
+  samples: number;
+  bytes: number;
+  postingsCacheHitRatio?: number;
+  seriesCacheHitRatio?: number;
+  chunksCacheHitRatio?: number;
+}
+
+export interface DeduplicationStats {
+  inputSeries: number;
+  outputSeries: number;
+}
 
 export interface QueryStats {
   loadTime: number;
   resolution: number;
   resultSeries: number;
   traceID: string | null;
+  stores?: StoreStats[];
+  deduplication?: DeduplicationStats;
 }
 
+const formatRatio = (ratio?: number): string => (ratio === undefined ? '-' : `${(ratio * 100).toFixed(1)}%`);
+
 const QueryStatsView: FC<QueryStats> = (props) => {
-  const { loadTime, resolution, resultSeries, traceID } = props;
-  const prev = `Load time: ${loadTime}ms &ensp; Resolution: ${resolution}s &ensp; Result series: ${resultSeries}`;
-  const str = traceID ? prev + ` &ensp; Trace ID: ${traceID}` : prev;
+  const { loadTime, resolution, resultSeries, traceID, stores, deduplication } = props;
+  let str = `Load time: ${loadTime}ms &ensp; Resolution: ${resolution}s &ensp; Result series: ${resultSeries}`;
+  if (deduplication) {
+    str += ` &ensp; Deduplicated series: ${deduplication.inputSeries} → ${deduplication.outputSeries}`;
+  }
+  if (traceID) {
+    str += ` &ensp; Trace ID: ${traceID}`;
+  }
 
   return (
     <div className="query-stats">
       <span className="float-right" dangerouslySetInnerHTML={{ __html: str }}></span>
+      {stores && stores.length > 0 && (
+        <details className="store-stats">
+          <summary>Store statistics</summary>
+          <Table size="sm" bordered>
+            <thead>
+              <tr>
+                <th>Store</th>
+                <th>Duration</th>
+                <th>Series</th>
+                <th>Chunks</th>
+                <th>Samples</th>
+                <th>Bytes</th>
+                <th>Postings cache hits</th>
+                <th>Series cache hits</th>
+                <th>Chunks cache hits</th>
+              </tr>
+            </thead>
+            <tbody>
+              {stores.map((s) => (
+                <tr key={s.address}>
+                  <td title={s.labelSets}>{s.address}</td>
+                  <td>{Math.round(s.durationSeconds * 1000)}ms</td>
+                  <td>{s.series}</td>
+                  <td>{s.chunks}</td>
+                  <td>{s.samples}</td>
+                  <td>{s.bytes}</td>
+                  <td>{formatRatio(s.postingsCacheHitRatio)}</td>
+                  <td>{formatRatio(s.seriesCacheHitRatio)}</td>
+                  <td>{formatRatio(s.chunksCacheHitRatio)}</td>
+                </tr>
+              ))}
+            </tbody>
+          </Table>
+        </details>
+      )}
     </div>
   );
 };
@@ -153,3 +153,54 @@ describe('BlockDetailsWithSize', () => {
     expect(div.find('span').text()).toBe('144.11 GiB / day');
   });
 });
+
+describe('BlockDetailsWithMarks', () => {
+  const parent = sampleAPIResponse.data.blocks[1];
+  const block = {
+    ...sampleBlock,
+    compaction: {
+      ...sampleBlock.compaction,
+      parents: [{ ulid: parent.ulid, minTime: parent.minTime, maxTime: parent.maxTime }],
+    },
+  };
+  const defaultProps: BlockDetailsProps = {
+    block,
+    selectBlock: (): void => {
+      // do nothing
+    },
+    disableAdminOperations: false,
+    blocks: [block, parent],
+    marks: {
+      deletionMarks: {
+        [block.ulid]: { id: block.ulid, details: 'compacted', deletion_time: 1594629445 },
+      },
+      noCompactMarks: {
+        [block.ulid]: { id: block.ulid, reason: 'manual', details: 'broken', no_compact_time: 1594629445 },
+      },
+    },
+  };
+  window.URL.createObjectURL = jest.fn();
+  const blockDetails = mount(<BlockDetails {...defaultProps} />);
+
+  it('renders the deletion mark', () => {
+    const div = blockDetails.find({ 'data-testid': 'deletion-mark' });
+    expect(div).toHaveLength(1);
+    expect(div.text()).toBe(`Marked for deletion: ${moment.unix(1594629445).format('LLL')} (compacted)`);
+  });
+
+  it('renders the no compact mark', () => {
+    const div = blockDetails.find({ 'data-testid': 'no-compact-mark' });
+    expect(div).toHaveLength(1);
+    expect(div.text()).toBe(`Marked for no compaction: ${moment.unix(1594629445).format('LLL')} (manual: broken)`);
+  });
+
+  it('renders the parents of the block', () => {
+    const div = blockDetails.find({ 'data-testid': 'parents' });
+    expect(div).toHaveLength(1);
+    expect(div.find('li').text()).toBe(parent.ulid);
+  });
+
+  it("doesn't render the children of the block when it has none", () => {
+    expect(blockDetails.find({ 'data-testid': 'children' })).toHaveLength(0);
+  });
+});
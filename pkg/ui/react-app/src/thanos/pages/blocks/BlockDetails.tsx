@@ -1,15 +1,17 @@
 import React, { FC, useState } from 'react';
-import { Block } from './block';
+import { Block, BlockMarks } from './block';
 import styles from './blocks.module.css';
 import moment from 'moment';
 import PathPrefixProps from '../../../types/PathPrefixProps';
 import { Button, Form, Input, Modal, ModalBody, ModalFooter, ModalHeader } from 'reactstrap';
-import { download, getBlockSizeStats, humanizeBytes } from './helpers';
+import { download, getBlockLineage, getBlockSizeStats, humanizeBytes } from './helpers';
 
 export interface BlockDetailsProps {
   block: Block | undefined;
   selectBlock: React.Dispatch<React.SetStateAction<Block | undefined>>;
   disableAdminOperations: boolean;
+  blocks?: Block[];
+  marks?: BlockMarks;
 }
 
 export const BlockDetails: FC<BlockDetailsProps & PathPrefixProps> = ({
@@ -17,11 +19,16 @@ export const BlockDetails: FC<BlockDetailsProps & PathPrefixProps> = ({
   block,
   selectBlock,
   disableAdminOperations,
+  blocks = [],
+  marks = {},
 }) => {
   const [modalAction, setModalAction] = useState<string>('');
   const [detailValue, setDetailValue] = useState<string | null>(null);
 
   const sizeStats = getBlockSizeStats(block);
+  const lineage = block && getBlockLineage(block, blocks);
+  const deletionMark = block && marks.deletionMarks?.[block.ulid];
+  const noCompactMark = block && marks.noCompactMarks?.[block.ulid];
 
   const submitMarkBlock = async (action: string, ulid: string, detail: string | null) => {
     try {
@@ -124,7 +131,65 @@ export const BlockDetails: FC<BlockDetailsProps & PathPrefixProps> = ({
           <div data-testid="source">
             <b>Source:</b> <span>{block.thanos.source}</span>
           </div>
+          {deletionMark && (
+            <div data-testid="deletion-mark">
+              <b>Marked for deletion:</b> <span>{moment.unix(deletionMark.deletion_time).format('LLL')}</span>
+              {deletionMark.details && <span> ({deletionMark.details})</span>}
+            </div>
+          )}
+          {noCompactMark && (
+            <div data-testid="no-compact-mark">
+              <b>Marked for no compaction:</b> <span>{moment.unix(noCompactMark.no_compact_time).format('LLL')}</span>
+              <span>
+                {' '}
+                ({noCompactMark.reason}
+                {noCompactMark.details && `: ${noCompactMark.details}`})
+              </span>
+            </div>
+          )}
           <hr />
+          {lineage && (
+            <>
+              <div data-testid="lineage">
+                <div>
+                  <b>Sources:</b> <span>{block.compaction.sources.length}</span>
+                </div>
+                {lineage.parents.length > 0 && (
+                  <div data-testid="parents">
+                    <b>Compacted from:</b>
+                    <ul>
+                      {lineage.parents.map((p) => (
+                        <li key={p.ulid}>
+                          {p.block ? (
+                            <Button color="link" size="sm" className="p-0" onClick={(): void => selectBlock(p.block)}>
+                              {p.ulid}
+                            </Button>
+                          ) : (
+                            <span title="The block was deleted or is not loaded.">{p.ulid} (gone)</span>
+                          )}
+                        </li>
+                      ))}
+                    </ul>
+                  </div>
+                )}
+                {lineage.children.length > 0 && (
+                  <div data-testid="children">
+                    <b>Compacted into:</b>
+                    <ul>
+                      {lineage.children.map((c) => (
+                        <li key={c.ulid}>
+                          <Button color="link" size="sm" className="p-0" onClick={(): void => selectBlock(c)}>
+                            {c.ulid}
+                          </Button>
+                        </li>
+                      ))}
+                    </ul>
+                  </div>
+                )}
+              </div>
+              <hr />
+            </>
+          )}
           <div data-testid="labels">
             <b>Labels:</b>
             <ul>
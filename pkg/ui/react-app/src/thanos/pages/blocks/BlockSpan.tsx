@@ -1,5 +1,5 @@
 import React, { FC } from 'react';
-import { Block } from './block';
+import { Block, BlockMarks } from './block';
 import styles from './blocks.module.css';
 
 interface BlockSpanProps {
@@ -7,9 +7,10 @@ interface BlockSpanProps {
   gridMinTime: number;
   gridMaxTime: number;
   selectBlock: React.Dispatch<React.SetStateAction<Block | undefined>>;
+  marks?: BlockMarks;
 }
 
-export const BlockSpan: FC<BlockSpanProps> = ({ block, gridMaxTime, gridMinTime, selectBlock }) => {
+export const BlockSpan: FC<BlockSpanProps> = ({ block, gridMaxTime, gridMinTime, selectBlock, marks }) => {
   const viewWidth = gridMaxTime - gridMinTime;
   const spanWidth = ((block.maxTime - block.minTime) / viewWidth) * 100;
   const spanOffset = ((block.minTime - gridMinTime) / viewWidth) * 100;
@@ -19,6 +20,8 @@ export const BlockSpan: FC<BlockSpanProps> = ({ block, gridMaxTime, gridMinTime,
       onClick={(): void => selectBlock(block)}
       className={`${styles.blockSpan} ${styles[`res-${block.thanos.downsample.resolution}`]} ${
         styles[`level-${block.compaction.level}`]
+      } ${marks?.deletionMarks?.[block.ulid] ? styles.deletionMarked : ''} ${
+        marks?.noCompactMarks?.[block.ulid] ? styles.noCompactMarked : ''
       }`}
       style={{
         width: `calc(${spanWidth.toFixed(4)}% + 1px)`,
@@ -5,7 +5,7 @@ import { useQueryParams, withDefault, NumberParam, StringParam, BooleanParam } f
 import { withStatusIndicator } from '../../../components/withStatusIndicator';
 import { useFetch } from '../../../hooks/useFetch';
 import PathPrefixProps from '../../../types/PathPrefixProps';
-import { Block } from './block';
+import { Block, BlockMarks } from './block';
 import { SourceView } from './SourceView';
 import { BlockDetails } from './BlockDetails';
 import { BlockSearchInput } from './BlockSearchInput';
@@ -16,7 +16,7 @@ import TimeRange from './TimeRange';
 import Checkbox from '../../../components/Checkbox';
 import { FlagMap } from '../../../pages/flags/Flags';
 
-export interface BlockListProps {
+export interface BlockListProps extends BlockMarks {
   blocks: Block[];
   err: string | null;
   label: string;
@@ -27,7 +27,8 @@ export const BlocksContent: FC<{ data: BlockListProps } & PathPrefixProps> = ({
   const [selectedBlock, selectBlock] = useState<Block>();
   const [searchState, setSearchState] = useState<string>('');
 
-  const { blocks, label, err } = data;
+  const { blocks, label, err, deletionMarks, noCompactMarks } = data;
+  const marks = useMemo(() => ({ deletionMarks, noCompactMarks }), [deletionMarks, noCompactMarks]);
 
   const [gridMinTime, gridMaxTime] = useMemo(() => {
     if (!err && blocks.length > 0) {
@@ -168,6 +169,7 @@ export const BlocksContent: FC<{ data: BlockListProps } & PathPrefixProps> = ({
                       gridMaxTime={viewMaxTime}
                       blockSearch={blockSearch}
                       compactionLevel={compactionLevel}
+                      marks={marks}
                     />
                   ))
                 ) : (
@@ -189,6 +191,8 @@ export const BlocksContent: FC<{ data: BlockListProps } & PathPrefixProps> = ({
               selectBlock={selectBlock}
               block={selectedBlock}
               disableAdminOperations={disableAdminOperations}
+              blocks={blocks}
+              marks={marks}
             />
           </div>
         </>
@@ -1,8 +1,15 @@
 import React, { FC } from 'react';
-import { Block, BlocksPool } from './block';
+import moment from 'moment';
+import { Block, BlockMarks, BlocksPool } from './block';
 import { BlockSpan } from './BlockSpan';
 import styles from './blocks.module.css';
-import { getBlockByUlid, getBlocksByCompactionLevel, humanizeBytes, sumBlockSizeStats } from './helpers';
+import {
+  getBlockByUlid,
+  getBlocksByCompactionLevel,
+  getGroupTimeline,
+  humanizeBytes,
+  sumBlockSizeStats,
+} from './helpers';
 
 export const BlocksRow: FC<{
   blocks: Block[];
@@ -11,14 +18,22 @@ export const BlocksRow: FC<{
   selectBlock: React.Dispatch<React.SetStateAction<Block | undefined>>;
   blockSearch: string;
   compactionLevel: number;
-}> = ({ blocks, gridMinTime, gridMaxTime, selectBlock, blockSearch, compactionLevel }) => {
+  marks?: BlockMarks;
+}> = ({ blocks, gridMinTime, gridMaxTime, selectBlock, blockSearch, compactionLevel, marks }) => {
   let filteredBlocks = getBlockByUlid(blocks, blockSearch);
   filteredBlocks = getBlocksByCompactionLevel(filteredBlocks, compactionLevel);
 
   return (
     <div className={styles.row}>
       {filteredBlocks.map<JSX.Element>((b) => (
-        <BlockSpan selectBlock={selectBlock} block={b} gridMaxTime={gridMaxTime} gridMinTime={gridMinTime} key={b.ulid} />
+        <BlockSpan
+          selectBlock={selectBlock}
+          block={b}
+          gridMaxTime={gridMaxTime}
+          gridMinTime={gridMinTime}
+          marks={marks}
+          key={b.ulid}
+        />
       ))}
     </div>
   );
@@ -32,6 +47,7 @@ export interface SourceViewProps {
   selectBlock: React.Dispatch<React.SetStateAction<Block | undefined>>;
   blockSearch: string;
   compactionLevel: number;
+  marks?: BlockMarks;
 }
 
 export const SourceView: FC<SourceViewProps> = ({
@@ -42,8 +58,10 @@ export const SourceView: FC<SourceViewProps> = ({
   selectBlock,
   blockSearch,
   compactionLevel,
+  marks = {},
 }) => {
   const blockSizeStats = sumBlockSizeStats(data, compactionLevel);
+  const timeline = getGroupTimeline(data, marks);
   return (
     <>
       <div className={styles.source}>
@@ -63,12 +81,21 @@ export const SourceView: FC<SourceViewProps> = ({
                   gridMinTime={gridMinTime}
                   blockSearch={blockSearch}
                   compactionLevel={compactionLevel}
+                  marks={marks}
                 />
               ))}
             </React.Fragment>
           ))}
         </div>
       </div>
+      <div className={styles.timeline} data-testid="group-timeline">
+        <span>{timeline.blocks} blocks, up to level {timeline.maxLevel}</span>
+        <span>
+          {moment.unix(timeline.minTime / 1000).format('LLL')} - {moment.unix(timeline.maxTime / 1000).format('LLL')}
+        </span>
+        {timeline.deletionMarked > 0 && <span>{timeline.deletionMarked} marked for deletion</span>}
+        {timeline.noCompactMarked > 0 && <span>{timeline.noCompactMarked} marked for no compaction</span>}
+      </div>
       <hr />
     </>
   );
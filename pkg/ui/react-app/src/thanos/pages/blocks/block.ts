@@ -30,6 +30,40 @@ export interface Block {
   version: number;
 }
 
+export interface DeletionMark {
+  id: string;
+  details?: string;
+  deletion_time: number;
+}
+
+export interface NoCompactMark {
+  id: string;
+  details?: string;
+  no_compact_time: number;
+  reason: string;
+}
+
+export interface BlockMarks {
+  deletionMarks?: { [ulid: string]: DeletionMark };
+  noCompactMarks?: { [ulid: string]: NoCompactMark };
+}
+
+export interface BlockLineage {
+  // The parents of the block, with their block if known.
+  parents: { ulid: string; block?: Block }[];
+  // The blocks compacted from the block.
+  children: Block[];
+}
+
+export interface GroupTimeline {
+  blocks: number;
+  minTime: number;
+  maxTime: number;
+  maxLevel: number;
+  deletionMarked: number;
+  noCompactMarked: number;
+}
+
 export interface LabelSet {
   [labelName: string]: string;
 }
@@ -1,4 +1,13 @@
-import { getBlockSizeStats, getFilteredBlockPools, humanizeBytes, isOverlapping, sortBlocks } from './helpers';
+import {
+  getBlockLineage,
+  getBlockSizeStats,
+  getFilteredBlockPools,
+  getGroupTimeline,
+  humanizeBytes,
+  isOverlapping,
+  sortBlocks,
+} from './helpers';
+import { Block } from './block';
 import { sizeBlock } from './__testdata__/testdata';
 
 // Number of blocks in data: 8.
@@ -705,3 +714,57 @@ describe('humanizeBytes', () => {
     expect(humanizeBytes(Math.pow(2, 60) * 2)).toEqual('2048.00 PiB');
   });
 });
+
+const lineageBlock = (ulid: string, level: number, minTime: number, maxTime: number, parents: string[] = []): Block => ({
+  compaction: {
+    level,
+    sources: [ulid],
+    parents: parents.map((p) => ({ ulid: p, minTime, maxTime })),
+  },
+  minTime,
+  maxTime,
+  stats: { numChunks: 0, numSamples: 0, numSeries: 0 },
+  thanos: { downsample: { resolution: 0 }, labels: { monitor: 'prometheus_one' }, source: 'compactor' },
+  ulid,
+  version: 1,
+});
+
+describe('Block lineage', () => {
+  const parent = lineageBlock('01EWZCKPP4K0WYRTZC9RPRM5QK', 1, 0, 7200000);
+  const child = lineageBlock('01EWZCA2CFC5CPJE8CF9TXBW9H', 2, 0, 14400000, [parent.ulid, '01ESK5B1WQB6QEZQ4P0YCQXEC4']);
+  const lineage = getBlockLineage(child, [parent, child]);
+
+  it('should return the parents with their block if known', () => {
+    expect(lineage.parents).toEqual([
+      { ulid: parent.ulid, block: parent },
+      { ulid: '01ESK5B1WQB6QEZQ4P0YCQXEC4', block: undefined },
+    ]);
+  });
+  it('should return the blocks compacted from the block', () => {
+    expect(getBlockLineage(parent, [parent, child]).children).toEqual([child]);
+    expect(lineage.children).toEqual([]);
+  });
+});
+
+describe('Group timeline', () => {
+  const blocks = [
+    lineageBlock('01EWZCKPP4K0WYRTZC9RPRM5QK', 1, 7200000, 14400000),
+    lineageBlock('01EWZCA2CFC5CPJE8CF9TXBW9H', 2, 0, 7200000),
+  ];
+  const timeline = getGroupTimeline(sortBlocks(blocks, '', false)['1'], {
+    deletionMarks: {
+      '01EWZCKPP4K0WYRTZC9RPRM5QK': { id: '01EWZCKPP4K0WYRTZC9RPRM5QK', deletion_time: 1 },
+    },
+  });
+
+  it('should summarize the blocks of the group', () => {
+    expect(timeline).toEqual({
+      blocks: 2,
+      minTime: 0,
+      maxTime: 14400000,
+      maxLevel: 2,
+      deletionMarked: 1,
+      noCompactMarked: 0,
+    });
+  });
+});
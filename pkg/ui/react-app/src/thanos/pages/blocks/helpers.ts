@@ -1,4 +1,4 @@
-import { Block, BlockSizeStats, BlocksPool, LabelSet } from './block';
+import { Block, BlockLineage, BlockMarks, BlockSizeStats, BlocksPool, GroupTimeline, LabelSet } from './block';
 import { Fuzzy, FuzzyResult } from '@nexucis/fuzzy';
 
 const stringify = (map: LabelSet): string => {
@@ -219,3 +219,40 @@ export const humanizeBytes = (bytes?: number): string => {
   const unitIndex = Math.min(Math.floor(Math.log(bytes) / Math.log(1024)), units.length - 1);
   return `${(bytes / Math.pow(1024, unitIndex)).toFixed(2)} ${units[unitIndex]}`;
 };
+
+export const getBlockLineage = (block: Block, blocks: Block[]): BlockLineage => {
+  const byUlid: { [ulid: string]: Block } = {};
+  blocks.forEach((b) => (byUlid[b.ulid] = b));
+
+  return {
+    parents: (block.compaction.parents || []).map((p) => ({ ulid: p.ulid, block: byUlid[p.ulid] })),
+    children: blocks.filter((b) => b.compaction.parents?.some((p) => p.ulid === block.ulid)),
+  };
+};
+
+export const getGroupTimeline = (bp: BlocksPool, marks: BlockMarks): GroupTimeline => {
+  const timeline: GroupTimeline = {
+    blocks: 0,
+    minTime: Number.MAX_SAFE_INTEGER,
+    maxTime: 0,
+    maxLevel: 0,
+    deletionMarked: 0,
+    noCompactMarked: 0,
+  };
+
+  Object.values(bp).forEach((rows) => {
+    rows.forEach((row) => {
+      row.forEach((block) => {
+        timeline.blocks++;
+        timeline.minTime = Math.min(timeline.minTime, block.minTime);
+        timeline.maxTime = Math.max(timeline.maxTime, block.maxTime);
+        timeline.maxLevel = Math.max(timeline.maxLevel, block.compaction.level);
+        if (marks.deletionMarks?.[block.ulid]) timeline.deletionMarked++;
+        if (marks.noCompactMarks?.[block.ulid]) timeline.noCompactMarked++;
+      });
+    });
+  });
+  if (timeline.blocks === 0) timeline.minTime = 0;
+
+  return timeline;
+};
@@ -0,0 +1,124 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package verifier
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+)
+
+// DownsampleSourcesIssue checks that downsampled blocks are consistent with the blocks they were downsampled from:
+// the sources of a block downsampled to 5m have to be sources of raw blocks, and the ones of a block downsampled
+// to 1h sources of 5m blocks. Blocks are downsampled from a single block, whose sources stay in the blocks it is
+// compacted into until retention deletes them, so a downsampled block with only some of its sources left, or
+// without any of them while blocks of the previous resolution still overlap it, was left behind by blocks deleted
+// or rewritten at one resolution only. Queries then return different data depending on the resolution they use.
+// No repair is available for this issue.
+type DownsampleSourcesIssue struct{}
+
+func (DownsampleSourcesIssue) IssueID() string { return "downsample_sources" }
+
+func (DownsampleSourcesIssue) Verify(ctx Context, idMatcher func(ulid.ULID) bool) error {
+	if idMatcher != nil {
+		return errors.Errorf("id matching is not supported")
+	}
+
+	level.Info(ctx.Logger).Log("msg", "started verifying issue")
+
+	metas, _, err := ctx.Fetcher.Fetch(ctx)
+	if err != nil {
+		return errors.Wrap(err, "fetch metas")
+	}
+
+	for _, f := range downsampleSourcesFindings(metas) {
+		level.Warn(ctx.Logger).Log("msg", "found downsampled block inconsistent with its sources", "group", f.Group, "block", f.Blocks[0], "issue", f.Message)
+		ctx.AddFinding(f)
+	}
+	return nil
+}
+
+func downsampleSourcesFindings(metas map[ulid.ULID]*metadata.Meta) []Finding {
+	type groupKey struct {
+		labels     uint64
+		resolution int64
+	}
+	groups := map[groupKey][]*metadata.Meta{}
+	for _, m := range metas {
+		k := groupKey{labels: labels.FromMap(m.Thanos.Labels).Hash(), resolution: m.Thanos.Downsample.Resolution}
+		groups[k] = append(groups[k], m)
+	}
+	downsampledFrom := map[int64]int64{
+		downsample.ResLevel1: downsample.ResLevel0,
+		downsample.ResLevel2: downsample.ResLevel1,
+	}
+
+	var findings []Finding
+	for k, downsampled := range groups {
+		from, ok := downsampledFrom[k.resolution]
+		if !ok {
+			continue
+		}
+		fromMetas := groups[groupKey{labels: k.labels, resolution: from}]
+		sources := map[ulid.ULID]struct{}{}
+		for _, m := range fromMetas {
+			for _, id := range m.Compaction.Sources {
+				sources[id] = struct{}{}
+			}
+		}
+
+		for _, m := range downsampled {
+			missing := 0
+			for _, id := range m.Compaction.Sources {
+				if _, ok := sources[id]; !ok {
+					missing++
+				}
+			}
+
+			var msg string
+			switch {
+			case missing == 0:
+				continue
+			case missing < len(m.Compaction.Sources):
+				msg = fmt.Sprintf("%d of %d sources of the downsampled block are not in any block of the resolution it was downsampled from",
+					missing, len(m.Compaction.Sources))
+			case overlapsAny(m, fromMetas):
+				msg = "no source of the downsampled block is in the blocks of the resolution it was downsampled from overlapping it"
+			default:
+				// The blocks it was downsampled from were deleted by retention.
+				continue
+			}
+			findings = append(findings, Finding{
+				Issue:   DownsampleSourcesIssue{}.IssueID(),
+				Group:   m.Thanos.GroupKey(),
+				Blocks:  []ulid.ULID{m.ULID},
+				Message: msg,
+			})
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Group != findings[j].Group {
+			return findings[i].Group < findings[j].Group
+		}
+		return findings[i].Blocks[0].Compare(findings[j].Blocks[0]) < 0
+	})
+	return findings
+}
+
+func overlapsAny(m *metadata.Meta, metas []*metadata.Meta) bool {
+	for _, o := range metas {
+		if o.MinTime < m.MaxTime && m.MinTime < o.MaxTime {
+			return true
+		}
+	}
+	return false
+}
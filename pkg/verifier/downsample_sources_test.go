@@ -0,0 +1,65 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package verifier
+
+import (
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/prometheus/tsdb"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+)
+
+func TestDownsampleSourcesFindings(t *testing.T) {
+	t.Parallel()
+
+	var (
+		nextID uint64
+		metas  = map[ulid.ULID]*metadata.Meta{}
+	)
+	block := func(tenant string, resolution, minTime, maxTime int64, sources ...uint64) ulid.ULID {
+		nextID++
+		m := &metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{ULID: ulid.MustNew(100+nextID, nil), MinTime: minTime, MaxTime: maxTime},
+			Thanos: metadata.Thanos{
+				Labels:     map[string]string{"tenant": tenant},
+				Downsample: metadata.ThanosDownsample{Resolution: resolution},
+			},
+		}
+		for _, s := range sources {
+			m.Compaction.Sources = append(m.Compaction.Sources, ulid.MustNew(s, nil))
+		}
+		metas[m.ULID] = m
+		return m.ULID
+	}
+
+	// Consistent: raw blocks compacted after downsampling keep the sources.
+	block("a", downsample.ResLevel0, 0, 300, 1, 2, 3)
+	block("a", downsample.ResLevel1, 0, 200, 1, 2)
+	block("a", downsample.ResLevel2, 0, 200, 1, 2)
+	// Raw blocks deleted by retention.
+	block("a", downsample.ResLevel1, -200, 0, 4, 5)
+	// Some sources deleted.
+	partial := block("a", downsample.ResLevel1, 200, 300, 3, 6)
+	// Sources replaced while raw blocks still overlap it.
+	block("b", downsample.ResLevel0, 0, 100, 7)
+	orphan := block("b", downsample.ResLevel1, 0, 100, 8)
+	// The same sources in blocks of other labels do not count.
+	otherLabels := block("c", downsample.ResLevel2, 0, 100, 7)
+	block("c", downsample.ResLevel1, 0, 100, 9)
+
+	findings := downsampleSourcesFindings(metas)
+	testutil.Equals(t, 3, len(findings))
+	var blocks []ulid.ULID
+	for _, f := range findings {
+		testutil.Equals(t, DownsampleSourcesIssue{}.IssueID(), f.Issue)
+		testutil.Equals(t, metas[f.Blocks[0]].Thanos.GroupKey(), f.Group)
+		blocks = append(blocks, f.Blocks...)
+	}
+	testutil.Equals(t, map[ulid.ULID]struct{}{partial: {}, orphan: {}, otherLabels: {}}, map[ulid.ULID]struct{}{blocks[0]: {}, blocks[1]: {}, blocks[2]: {}})
+	testutil.Equals(t, "1 of 2 sources of the downsampled block are not in any block of the resolution it was downsampled from", findings[0].Message)
+}
@@ -19,16 +19,12 @@ import (
 // Bug resulted in source block not being removed immediately after compaction, so we were compacting again and again same sources
 // until sync-delay passes.
 // The expected print of this are same overlapped blocks with exactly the same sources, time ranges and stats.
-// If repair is enabled, all but one duplicates are safely deleted.
+// If repair is enabled, all but one duplicates are safely deleted. If ids are matched, only matching duplicates are deleted.
 type DuplicatedCompactionBlocks struct{}
 
 func (DuplicatedCompactionBlocks) IssueID() string { return "duplicated_compaction" }
 
 func (DuplicatedCompactionBlocks) VerifyRepair(ctx Context, idMatcher func(ulid.ULID) bool, repair bool) error {
-	if idMatcher != nil {
-		return errors.Errorf("id matching is not supported")
-	}
-
 	level.Info(ctx.Logger).Log("msg", "started verifying issue", "with-repair", repair)
 
 	overlaps, err := fetchOverlaps(ctx, ctx.Fetcher)
@@ -57,7 +53,12 @@ func (DuplicatedCompactionBlocks) VerifyRepair(ctx Context, idMatcher func(ulid.
 			for _, d := range dups {
 				level.Warn(ctx.Logger).Log("msg", "found duplicated blocks", "group", k, "range-min", r.Min, "range-max", r.Max, "kill", sprintMetas(d[1:]))
 
+				var ids []ulid.ULID
 				for _, m := range d[1:] {
+					if idMatcher != nil && !idMatcher(m.ULID) {
+						continue
+					}
+					ids = append(ids, m.ULID)
 					if _, ok := toKillLookup[m.ULID]; ok {
 						continue
 					}
@@ -65,6 +66,15 @@ func (DuplicatedCompactionBlocks) VerifyRepair(ctx Context, idMatcher func(ulid.
 					toKillLookup[m.ULID] = struct{}{}
 					toKill = append(toKill, m.ULID)
 				}
+				if len(ids) > 0 {
+					ctx.AddFinding(Finding{
+						Issue:      DuplicatedCompactionBlocks{}.IssueID(),
+						Group:      k,
+						Blocks:     ids,
+						Message:    fmt.Sprintf("duplicates of block %s", d[0].ULID),
+						Repairable: true,
+					})
+				}
 			}
 
 			if len(dups) == 0 {
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sync"
 
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 
@@ -17,6 +18,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/thanos-io/objstore"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/thanos-io/thanos/pkg/block"
 )
@@ -31,42 +33,72 @@ type IndexKnownIssues struct{}
 func (IndexKnownIssues) IssueID() string { return "index_known_issues" }
 
 func (IndexKnownIssues) VerifyRepair(ctx Context, idMatcher func(ulid.ULID) bool, repair bool) error {
-	level.Info(ctx.Logger).Log("msg", "started verifying issue", "with-repair", repair)
+	level.Info(ctx.Logger).Log("msg", "started verifying issue", "with-repair", repair, "concurrency", ctx.Concurrency)
 
 	metas, _, err := ctx.Fetcher.Fetch(ctx)
 	if err != nil {
 		return err
 	}
 
+	// Verify blocks concurrently, but repair them one by one.
+	var (
+		mtx    sync.Mutex
+		broken = map[ulid.ULID]block.HealthStats{}
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(ctx.Concurrency, 1))
 	for id, meta := range metas {
 		if idMatcher != nil && !idMatcher(id) {
 			continue
 		}
 
-		tmpdir, err := os.MkdirTemp("", fmt.Sprintf("index-issue-block-%s-", id))
-		if err != nil {
-			return err
-		}
-		defer func() {
-			if err := os.RemoveAll(tmpdir); err != nil {
-				level.Warn(ctx.Logger).Log("msg", "failed to delete dir", "tmpdir", tmpdir, "err", err)
-			}
-		}()
+		g.Go(func() error {
+			vCtx := ctx
+			vCtx.Context = gctx
 
-		stats, err := verifyIndex(ctx, id, tmpdir, meta)
-		if err == nil {
-			level.Debug(ctx.Logger).Log("msg", "no issue", "id", id)
-			continue
-		}
+			tmpdir, err := os.MkdirTemp("", fmt.Sprintf("index-issue-block-%s-", id))
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := os.RemoveAll(tmpdir); err != nil {
+					level.Warn(ctx.Logger).Log("msg", "failed to delete dir", "tmpdir", tmpdir, "err", err)
+				}
+			}()
+
+			stats, err := verifyIndex(vCtx, id, tmpdir, meta)
+			if err == nil {
+				level.Debug(ctx.Logger).Log("msg", "no issue", "id", id)
+				return nil
+			}
 
-		level.Warn(ctx.Logger).Log("msg", "detected issue", "id", id, "err", err)
+			level.Warn(ctx.Logger).Log("msg", "detected issue", "id", id, "err", err)
+			ctx.AddFinding(Finding{
+				Issue:      IndexKnownIssues{}.IssueID(),
+				Group:      meta.Thanos.GroupKey(),
+				Blocks:     []ulid.ULID{id},
+				Message:    err.Error(),
+				Repairable: meta.Thanos.Downsample.Resolution == 0,
+			})
+
+			mtx.Lock()
+			broken[id] = stats
+			mtx.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
 
-		if !repair {
-			// Only verify.
-			continue
-		}
+	if !repair {
+		// Only verify.
+		level.Info(ctx.Logger).Log("msg", "verified issue", "with-repair", repair)
+		return nil
+	}
 
-		if err = repairIndex(stats, ctx, id, meta, tmpdir); err != nil {
+	for id, stats := range broken {
+		if err := repairBrokenIndex(ctx, id, metas[id], stats); err != nil {
 			level.Error(ctx.Logger).Log("msg", "could not repair index", "err", err)
 			continue
 		}
@@ -77,6 +109,19 @@ func (IndexKnownIssues) VerifyRepair(ctx Context, idMatcher func(ulid.ULID) bool
 	return nil
 }
 
+func repairBrokenIndex(ctx Context, id ulid.ULID, meta *metadata.Meta, stats block.HealthStats) error {
+	tmpdir, err := os.MkdirTemp("", fmt.Sprintf("index-issue-block-%s-", id))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpdir); err != nil {
+			level.Warn(ctx.Logger).Log("msg", "failed to delete dir", "tmpdir", tmpdir, "err", err)
+		}
+	}()
+	return repairIndex(stats, ctx, id, meta, tmpdir)
+}
+
 func repairIndex(stats block.HealthStats, ctx Context, id ulid.ULID, meta *metadata.Meta, dir string) (err error) {
 	if stats.OutOfOrderChunks > stats.DuplicatedChunks {
 		level.Warn(ctx.Logger).Log("msg", "detected overlaps are not entirely by duplicated chunks. We are able to repair only duplicates", "id", id)
@@ -5,6 +5,7 @@ package verifier
 
 import (
 	"context"
+	"fmt"
 	"sort"
 
 	"github.com/go-kit/log/level"
@@ -41,6 +42,18 @@ func (OverlappedBlocksIssue) Verify(ctx Context, idMatcher func(ulid.ULID) bool)
 
 	for k, o := range overlaps {
 		level.Warn(ctx.Logger).Log("msg", "found overlapped blocks", "group", k, "overlap", o)
+		for r, blocks := range o {
+			ids := make([]ulid.ULID, 0, len(blocks))
+			for _, b := range blocks {
+				ids = append(ids, b.ULID)
+			}
+			ctx.AddFinding(Finding{
+				Issue:   OverlappedBlocksIssue{}.IssueID(),
+				Group:   k,
+				Blocks:  ids,
+				Message: fmt.Sprintf("blocks overlap in range [%d, %d)", r.Min, r.Max),
+			})
+		}
 	}
 	return nil
 }
@@ -60,8 +73,12 @@ func fetchOverlaps(ctx context.Context, fetcher block.MetadataFetcher) (map[stri
 	overlaps := map[string]tsdb.Overlaps{}
 	for k, groupMetas := range groupMetasMap {
 
+		// Sort by ULID as well, so that repairs applied in a second pass see duplicates in the same order.
 		sort.Slice(groupMetas, func(i, j int) bool {
-			return groupMetas[i].MinTime < groupMetas[j].MinTime
+			if groupMetas[i].MinTime != groupMetas[j].MinTime {
+				return groupMetas[i].MinTime < groupMetas[j].MinTime
+			}
+			return groupMetas[i].ULID.Compare(groupMetas[j].ULID) < 0
 		})
 
 		o := tsdb.OverlappingBlocks(groupMetas)
@@ -0,0 +1,120 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package verifier
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+)
+
+// Finding is an issue detected by a verifier.
+type Finding struct {
+	Issue string `json:"issue"`
+	// Group is the compaction group of the blocks, if the issue concerns blocks of a group.
+	Group   string      `json:"group,omitempty"`
+	Blocks  []ulid.ULID `json:"blocks"`
+	Message string      `json:"message"`
+	// Repairable is true if the verifier of the issue can repair the blocks.
+	Repairable bool `json:"repairable"`
+}
+
+// RepairStep tells to run the repairer of the given issue on the given blocks.
+type RepairStep struct {
+	Issue  string      `json:"issue"`
+	Blocks []ulid.ULID `json:"blocks"`
+}
+
+// RepairPlan lists the repairs that fix the repairable findings of a verification, in order.
+type RepairPlan struct {
+	Steps []RepairStep `json:"steps"`
+}
+
+// Report is the result of a verification.
+type Report struct {
+	Findings   []Finding  `json:"findings"`
+	RepairPlan RepairPlan `json:"repair_plan"`
+}
+
+// NewRepairPlan returns a plan with one step per issue with repairable findings, in the order issues were first found.
+func NewRepairPlan(findings []Finding) RepairPlan {
+	var (
+		plan   = RepairPlan{Steps: []RepairStep{}}
+		index  = map[string]int{}
+		blocks = map[string]map[ulid.ULID]struct{}{}
+	)
+	for _, f := range findings {
+		if !f.Repairable {
+			continue
+		}
+		i, ok := index[f.Issue]
+		if !ok {
+			i = len(plan.Steps)
+			index[f.Issue] = i
+			blocks[f.Issue] = map[ulid.ULID]struct{}{}
+			plan.Steps = append(plan.Steps, RepairStep{Issue: f.Issue})
+		}
+		for _, id := range f.Blocks {
+			if _, ok := blocks[f.Issue][id]; ok {
+				continue
+			}
+			blocks[f.Issue][id] = struct{}{}
+			plan.Steps[i].Blocks = append(plan.Steps[i].Blocks, id)
+		}
+	}
+	for _, s := range plan.Steps {
+		sort.Slice(s.Blocks, func(i, j int) bool { return s.Blocks[i].Compare(s.Blocks[j]) < 0 })
+	}
+	return plan
+}
+
+// ReadRepairPlan decodes a repair plan, as encoded in JSON by a previous verification.
+func ReadRepairPlan(r io.Reader) (RepairPlan, error) {
+	var plan RepairPlan
+	if err := json.NewDecoder(r).Decode(&plan); err != nil {
+		return RepairPlan{}, errors.Wrap(err, "decode repair plan")
+	}
+	for _, s := range plan.Steps {
+		if s.Issue == "" {
+			return RepairPlan{}, errors.New("repair plan step without issue")
+		}
+	}
+	return plan, nil
+}
+
+// Issues returns the distinct issues of the plan, in order.
+func (p RepairPlan) Issues() []string {
+	var ids []string
+	seen := map[string]struct{}{}
+	for _, s := range p.Steps {
+		if _, ok := seen[s.Issue]; ok {
+			continue
+		}
+		seen[s.Issue] = struct{}{}
+		ids = append(ids, s.Issue)
+	}
+	return ids
+}
+
+// findings collects the findings reported by verifiers, possibly concurrently.
+type findings struct {
+	mtx sync.Mutex
+	fs  []Finding
+}
+
+func (f *findings) add(finding Finding) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.fs = append(f.fs, finding)
+}
+
+func (f *findings) get() []Finding {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return append([]Finding{}, f.fs...)
+}
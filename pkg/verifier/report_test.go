@@ -0,0 +1,108 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package verifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+func TestNewRepairPlan(t *testing.T) {
+	t.Parallel()
+
+	var (
+		id1 = ulid.MustNew(1, nil)
+		id2 = ulid.MustNew(2, nil)
+		id3 = ulid.MustNew(3, nil)
+	)
+	plan := NewRepairPlan([]Finding{
+		{Issue: "b", Blocks: []ulid.ULID{id3}, Repairable: true},
+		{Issue: "a", Blocks: []ulid.ULID{id1, id2}},
+		{Issue: "a", Blocks: []ulid.ULID{id2}, Repairable: true},
+		{Issue: "b", Blocks: []ulid.ULID{id1, id3}, Repairable: true},
+	})
+	testutil.Equals(t, RepairPlan{Steps: []RepairStep{
+		{Issue: "b", Blocks: []ulid.ULID{id1, id3}},
+		{Issue: "a", Blocks: []ulid.ULID{id2}},
+	}}, plan)
+	testutil.Equals(t, []string{"b", "a"}, plan.Issues())
+
+	b, err := json.Marshal(plan)
+	testutil.Ok(t, err)
+	read, err := ReadRepairPlan(bytes.NewReader(b))
+	testutil.Ok(t, err)
+	testutil.Equals(t, plan, read)
+
+	_, err = ReadRepairPlan(bytes.NewReader([]byte(`{"steps": [{"blocks": []}]}`)))
+	testutil.NotOk(t, err)
+}
+
+func TestManagerVerifyReport(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
+
+	var (
+		id1 = ulid.MustNew(1, nil)
+		id2 = ulid.MustNew(2, nil)
+		id3 = ulid.MustNew(3, nil)
+	)
+	for _, id := range []ulid.ULID{id1, id2, id3} {
+		m := metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{
+				ULID:       id,
+				MinTime:    0,
+				MaxTime:    100,
+				Version:    metadata.TSDBVersion1,
+				Compaction: tsdb.BlockMetaCompaction{Level: 2, Sources: []ulid.ULID{ulid.MustNew(10, nil), ulid.MustNew(11, nil)}},
+			},
+			Thanos: metadata.Thanos{
+				Version: metadata.ThanosVersion1,
+				Labels:  map[string]string{"a": "b"},
+			},
+		}
+		var buf bytes.Buffer
+		testutil.Ok(t, m.Write(&buf))
+		testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), metadata.MetaFilename), &buf))
+	}
+
+	fetcher, err := block.NewMetaFetcher(log.NewNopLogger(), 1, bkt, block.NewConcurrentLister(log.NewNopLogger(), bkt), "", nil, nil)
+	testutil.Ok(t, err)
+
+	m := NewManager(prometheus.NewRegistry(), log.NewNopLogger(), bkt, nil, fetcher, 0, 2, Registry{
+		Verifiers:         []Verifier{OverlappedBlocksIssue{}},
+		VerifierRepairers: []VerifierRepairer{DuplicatedCompactionBlocks{}},
+	})
+	report, err := m.Verify(ctx, nil)
+	testutil.Ok(t, err)
+
+	testutil.Equals(t, 2, len(report.Findings))
+	testutil.Equals(t, OverlappedBlocksIssue{}.IssueID(), report.Findings[0].Issue)
+	testutil.Equals(t, []ulid.ULID{id1, id2, id3}, report.Findings[0].Blocks)
+	testutil.Assert(t, !report.Findings[0].Repairable)
+	testutil.Equals(t, DuplicatedCompactionBlocks{}.IssueID(), report.Findings[1].Issue)
+	testutil.Assert(t, report.Findings[1].Repairable)
+
+	// The first of the duplicates is kept.
+	testutil.Equals(t, RepairPlan{Steps: []RepairStep{
+		{Issue: DuplicatedCompactionBlocks{}.IssueID(), Blocks: []ulid.ULID{id2, id3}},
+	}}, report.RepairPlan)
+
+	_, err = m.ApplyRepairPlan(ctx, RepairPlan{Steps: []RepairStep{{Issue: IndexKnownIssues{}.IssueID(), Blocks: []ulid.ULID{id1}}}})
+	testutil.NotOk(t, err)
+}
@@ -40,12 +40,27 @@ type Context struct {
 	BackupBkt   objstore.Bucket
 	Fetcher     block.MetadataFetcher
 	DeleteDelay time.Duration
+	// Concurrency is the number of blocks verified concurrently by verifiers supporting it.
+	Concurrency int
 
-	metrics *metrics
+	metrics  *metrics
+	findings *findings
+}
+
+// AddFinding records an issue found by a verifier, to be included in the verification report.
+// It is safe to call concurrently.
+func (ctx Context) AddFinding(f Finding) {
+	if ctx.findings != nil {
+		ctx.findings.add(f)
+	}
+	if ctx.metrics != nil {
+		ctx.metrics.findings.WithLabelValues(f.Issue).Inc()
+	}
 }
 
 type metrics struct {
 	blocksMarkedForDeletion prometheus.Counter
+	findings                *prometheus.CounterVec
 }
 
 func newVerifierMetrics(reg prometheus.Registerer) *metrics {
@@ -54,6 +69,10 @@ func newVerifierMetrics(reg prometheus.Registerer) *metrics {
 		Name: "thanos_verify_blocks_marked_for_deletion_total",
 		Help: "Total number of blocks marked for deletion by verify.",
 	})
+	m.findings = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_verify_findings_total",
+		Help: "Total number of issues found by verify, by issue.",
+	}, []string{"issue"})
 	return &m
 }
 
@@ -113,7 +132,7 @@ idLoop:
 }
 
 // New returns verifier's manager.
-func NewManager(reg prometheus.Registerer, logger log.Logger, bkt, backupBkt objstore.Bucket, fetcher block.MetadataFetcher, deleteDelay time.Duration, vs Registry) *Manager {
+func NewManager(reg prometheus.Registerer, logger log.Logger, bkt, backupBkt objstore.Bucket, fetcher block.MetadataFetcher, deleteDelay time.Duration, concurrency int, vs Registry) *Manager {
 	return &Manager{
 		Context: Context{
 			Logger:      logger,
@@ -121,6 +140,7 @@ func NewManager(reg prometheus.Registerer, logger log.Logger, bkt, backupBkt obj
 			BackupBkt:   backupBkt,
 			Fetcher:     fetcher,
 			DeleteDelay: deleteDelay,
+			Concurrency: max(concurrency, 1),
 
 			metrics: newVerifierMetrics(reg),
 		},
@@ -129,56 +149,112 @@ func NewManager(reg prometheus.Registerer, logger log.Logger, bkt, backupBkt obj
 }
 
 // Verify verifies matching blocks using registered list of Verifier and VerifierRepairer.
+// It returns the found issues, with a plan to repair those that can be repaired.
 // TODO(blotka): Wrap bucket with WrapWithMetrics and print metrics after each issue (e.g how many blocks where touched).
-func (m *Manager) Verify(ctx context.Context, idMatcher func(ulid.ULID) bool) error {
+func (m *Manager) Verify(ctx context.Context, idMatcher func(ulid.ULID) bool) (Report, error) {
 	if len(m.vs.Verifiers)+len(m.vs.VerifierRepairers) == 0 {
-		return errors.New("nothing to verify. No verifiers and verifierRepairers registered")
+		return Report{}, errors.New("nothing to verify. No verifiers and verifierRepairers registered")
 	}
 
 	logger := log.With(m.Logger, "verifiers", strings.Join(append(m.vs.VerifiersIDs(), m.vs.VerifierRepairersIDs()...), ","))
 	level.Info(logger).Log("msg", "Starting verify task")
 
+	fs := &findings{}
 	for _, v := range m.vs.Verifiers {
 		vCtx := m.Context
 		vCtx.Logger = log.With(logger, "verifier", v.IssueID())
 		vCtx.Context = ctx
+		vCtx.findings = fs
 		if err := v.Verify(vCtx, idMatcher); err != nil {
-			return errors.Wrapf(err, "verify %s", v.IssueID())
+			return Report{}, errors.Wrapf(err, "verify %s", v.IssueID())
 		}
 	}
 	for _, vr := range m.vs.VerifierRepairers {
 		vCtx := m.Context
 		vCtx.Context = ctx
 		vCtx.Logger = log.With(logger, "verifier", vr.IssueID())
+		vCtx.findings = fs
 		if err := vr.VerifyRepair(vCtx, idMatcher, false); err != nil {
-			return errors.Wrapf(err, "verify %s", vr.IssueID())
+			return Report{}, errors.Wrapf(err, "verify %s", vr.IssueID())
 		}
 	}
 
 	level.Info(logger).Log("msg", "verify task completed")
-	return nil
+	return newReport(fs), nil
 }
 
 // VerifyAndRepair verifies and repairs matching blocks using registered list of VerifierRepairer.
+// It returns the found issues.
 // TODO(blotka): Wrap bucket with WrapWithMetrics and print metrics after each issue (e.g how many blocks where touched).
-func (m *Manager) VerifyAndRepair(ctx context.Context, idMatcher func(ulid.ULID) bool) error {
+func (m *Manager) VerifyAndRepair(ctx context.Context, idMatcher func(ulid.ULID) bool) (Report, error) {
 	if len(m.vs.Verifiers)+len(m.vs.VerifierRepairers) == 0 {
-		return errors.New("nothing to verify. No verifierRepairers registered")
+		return Report{}, errors.New("nothing to verify. No verifierRepairers registered")
 	}
 
 	logger := log.With(m.Logger, "verifiers", strings.Join(m.vs.VerifierRepairersIDs(), ","))
 	level.Warn(logger).Log("msg", "GLOBAL COMPACTOR SHOULD __NOT__ BE RUNNING ON THE SAME BUCKET")
 	level.Info(logger).Log("msg", "Starting verify and repair task")
 
+	fs := &findings{}
 	for _, vr := range m.vs.VerifierRepairers {
 		vCtx := m.Context
 		vCtx.Logger = log.With(logger, "verifier", vr.IssueID())
 		vCtx.Context = ctx
+		vCtx.findings = fs
 		if err := vr.VerifyRepair(vCtx, idMatcher, true); err != nil {
-			return errors.Wrapf(err, "verify and repair %s", vr.IssueID())
+			return Report{}, errors.Wrapf(err, "verify and repair %s", vr.IssueID())
 		}
 	}
 
 	level.Info(logger).Log("msg", "verify and repair task completed")
-	return nil
+	return newReport(fs), nil
+}
+
+// ApplyRepairPlan runs the repairers of the plan steps, in order, on the blocks of each step only.
+// All issues of the plan have to be registered as VerifierRepairer.
+func (m *Manager) ApplyRepairPlan(ctx context.Context, plan RepairPlan) (Report, error) {
+	repairers := make(map[string]VerifierRepairer, len(m.vs.VerifierRepairers))
+	for _, vr := range m.vs.VerifierRepairers {
+		repairers[vr.IssueID()] = vr
+	}
+	for _, s := range plan.Steps {
+		if _, ok := repairers[s.Issue]; !ok {
+			return Report{}, errors.Errorf("no repairer registered for issue %s", s.Issue)
+		}
+	}
+
+	logger := log.With(m.Logger, "verifiers", strings.Join(plan.Issues(), ","))
+	level.Warn(logger).Log("msg", "GLOBAL COMPACTOR SHOULD __NOT__ BE RUNNING ON THE SAME BUCKET")
+	level.Info(logger).Log("msg", "Starting repair plan", "steps", len(plan.Steps))
+
+	fs := &findings{}
+	for i, s := range plan.Steps {
+		if len(s.Blocks) == 0 {
+			continue
+		}
+		ids := make(map[ulid.ULID]struct{}, len(s.Blocks))
+		for _, id := range s.Blocks {
+			ids[id] = struct{}{}
+		}
+
+		vCtx := m.Context
+		vCtx.Logger = log.With(logger, "verifier", s.Issue, "step", i)
+		vCtx.Context = ctx
+		vCtx.findings = fs
+		if err := repairers[s.Issue].VerifyRepair(vCtx, func(id ulid.ULID) bool {
+			_, ok := ids[id]
+			return ok
+		}, true); err != nil {
+			return Report{}, errors.Wrapf(err, "repair step %d: %s", i, s.Issue)
+		}
+	}
+
+	level.Info(logger).Log("msg", "repair plan completed")
+	return newReport(fs), nil
+}
+
+func newReport(fs *findings) Report {
+	r := Report{Findings: fs.get()}
+	r.RepairPlan = NewRepairPlan(r.Findings)
+	return r
 }
@@ -0,0 +1,270 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package walquota enforces a disk quota on the WAL of TSDBs, e.g. of every tenant of a receiver, so that the churn of
+// a single TSDB does not fill the disk shared with the others.
+package walquota
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/metadata"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+)
+
+// Action is the action taken on a TSDB whose WAL is above the quota.
+type Action string
+
+const (
+	// ActionReject compacts the head of the TSDB into a block, truncating its WAL, and rejects the appends to the TSDB
+	// until its WAL is below the quota again.
+	ActionReject Action = "reject"
+	// ActionDropOldest compacts the oldest half of the head of the TSDB into a block and drops it from the head,
+	// checkpointing the oldest WAL segments without its samples, without rejecting appends.
+	ActionDropOldest Action = "drop-oldest"
+)
+
+// ErrExceeded is returned for appends to TSDBs whose WAL is above the quota, with the reject action.
+var ErrExceeded = errors.New("WAL disk quota exceeded")
+
+// Quota is a disk quota on the WAL of TSDBs, identified by their tenant. The WALs are measured by Check, so they may
+// exceed the quota between checks.
+type Quota struct {
+	logger   log.Logger
+	maxBytes int64
+	action   Action
+
+	mtx      sync.RWMutex
+	exceeded map[string]struct{}
+
+	walBytes    *prometheus.GaugeVec
+	breaches    *prometheus.CounterVec
+	rejected    *prometheus.CounterVec
+	compactions *prometheus.CounterVec
+	truncations *prometheus.CounterVec
+}
+
+// New returns a quota of maxBytes on the WAL of every TSDB, with the action taken on the TSDBs above it.
+func New(logger log.Logger, reg prometheus.Registerer, maxBytes int64, action Action) (*Quota, error) {
+	if maxBytes <= 0 {
+		return nil, errors.Errorf("WAL disk quota must be positive, got %d", maxBytes)
+	}
+	if action != ActionReject && action != ActionDropOldest {
+		return nil, errors.Errorf("unknown WAL disk quota action %q", action)
+	}
+	q := &Quota{
+		logger:   logger,
+		maxBytes: maxBytes,
+		action:   action,
+		exceeded: map[string]struct{}{},
+		walBytes: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_wal_quota_wal_bytes",
+			Help: "Size of the WAL of the TSDB of the tenant, as of the last WAL disk quota check.",
+		}, []string{"tenant"}),
+		breaches: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_wal_quota_breaches_total",
+			Help: "Total number of WAL disk quota checks which found the WAL of the TSDB of the tenant above the quota.",
+		}, []string{"tenant"}),
+		rejected: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_wal_quota_rejected_appends_total",
+			Help: "Total number of appends to the TSDB of the tenant rejected as its WAL is above the quota.",
+		}, []string{"tenant"}),
+		compactions: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_wal_quota_head_compactions_total",
+			Help: "Total number of compactions of the head of the TSDB of the tenant into a block as its WAL is above the quota.",
+		}, []string{"tenant"}),
+		truncations: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_wal_quota_head_truncations_total",
+			Help: "Total number of truncations of the oldest data of the head of the TSDB of the tenant as its WAL is above the quota.",
+		}, []string{"tenant"}),
+	}
+	promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_wal_quota_max_bytes",
+		Help: "WAL disk quota of the TSDB of every tenant.",
+	}).Set(float64(maxBytes))
+	return q, nil
+}
+
+// Check measures the WAL of the TSDB of the tenant and, if it is above the quota, compacts the head of the TSDB and
+// rejects its appends, or compacts and drops the oldest data of its head, depending on the action of the quota.
+// Heads are compacted with the compaction lock of the TSDB held, so that they are not truncated concurrently with
+// the compactions of the TSDB.
+func (q *Quota) Check(tenant string, db *tsdb.DB) error {
+	size, err := walSize(db.Dir())
+	if err != nil {
+		return errors.Wrap(err, "measure WAL")
+	}
+	q.walBytes.WithLabelValues(tenant).Set(float64(size))
+
+	if size <= q.maxBytes {
+		q.allow(tenant)
+		return nil
+	}
+	q.breaches.WithLabelValues(tenant).Inc()
+
+	head := db.Head()
+	empty := head.MinTime() == math.MaxInt64 || head.MaxTime() < head.MinTime()
+
+	if q.action == ActionReject {
+		q.mtx.Lock()
+		_, ok := q.exceeded[tenant]
+		q.exceeded[tenant] = struct{}{}
+		q.mtx.Unlock()
+		if !ok {
+			level.Warn(q.logger).Log("msg", "WAL above disk quota, rejecting appends and compacting head", "tenant", tenant, "wal_bytes", size, "max_bytes", q.maxBytes)
+		}
+		if empty {
+			return nil
+		}
+		// Appends are rejected, so that the head is compacted whole, and its WAL truncated up to its max time.
+		if err := db.CompactHead(tsdb.NewRangeHead(head, head.MinTime(), head.MaxTime())); err != nil {
+			return errors.Wrap(err, "compact head")
+		}
+		q.compactions.WithLabelValues(tenant).Inc()
+
+		size, err := walSize(db.Dir())
+		if err != nil {
+			return errors.Wrap(err, "measure WAL")
+		}
+		q.walBytes.WithLabelValues(tenant).Set(float64(size))
+		if size <= q.maxBytes {
+			q.allow(tenant)
+		}
+		return nil
+	}
+
+	if empty || head.MaxTime() == head.MinTime() {
+		return nil
+	}
+	mint := head.MinTime() + (head.MaxTime()-head.MinTime())/2
+	level.Warn(q.logger).Log("msg", "WAL above disk quota, compacting and dropping oldest head data", "tenant", tenant, "wal_bytes", size, "max_bytes", q.maxBytes, "mint", mint)
+	// The head and its WAL are truncated up to mint by the compaction, like by head.Truncate(mint).
+	if err := db.CompactHead(tsdb.NewRangeHead(head, head.MinTime(), mint-1)); err != nil {
+		return errors.Wrap(err, "compact oldest head data")
+	}
+	q.truncations.WithLabelValues(tenant).Inc()
+	return nil
+}
+
+// allow allows the appends to the TSDB of the tenant again.
+func (q *Quota) allow(tenant string) {
+	q.mtx.Lock()
+	delete(q.exceeded, tenant)
+	q.mtx.Unlock()
+}
+
+// Allow returns false, counting the rejection, if appends to the TSDB of the tenant are rejected as of the last check.
+// A nil quota allows all appends.
+func (q *Quota) Allow(tenant string) bool {
+	if q == nil {
+		return true
+	}
+	q.mtx.RLock()
+	_, exceeded := q.exceeded[tenant]
+	q.mtx.RUnlock()
+	if exceeded {
+		q.rejected.WithLabelValues(tenant).Inc()
+	}
+	return !exceeded
+}
+
+// Remove forgets the TSDB of the tenant, once closed.
+func (q *Quota) Remove(tenant string) {
+	if q == nil {
+		return
+	}
+	q.mtx.Lock()
+	delete(q.exceeded, tenant)
+	q.mtx.Unlock()
+	q.walBytes.DeleteLabelValues(tenant)
+	q.breaches.DeleteLabelValues(tenant)
+	q.rejected.DeleteLabelValues(tenant)
+	q.compactions.DeleteLabelValues(tenant)
+	q.truncations.DeleteLabelValues(tenant)
+}
+
+// Appendable returns the appendable, with appends failing with ErrExceeded while the TSDB of the tenant is above the
+// quota.
+func (q *Quota) Appendable(tenant string, a storage.Appendable) storage.Appendable {
+	if q == nil {
+		return a
+	}
+	return &appendable{Appendable: a, quota: q, tenant: tenant}
+}
+
+type appendable struct {
+	storage.Appendable
+	quota  *Quota
+	tenant string
+}
+
+func (a *appendable) Appender(ctx context.Context) storage.Appender {
+	if !a.quota.Allow(a.tenant) {
+		return rejectingAppender{}
+	}
+	return a.Appendable.Appender(ctx)
+}
+
+// rejectingAppender fails all the appends with ErrExceeded.
+type rejectingAppender struct{}
+
+func (rejectingAppender) Append(storage.SeriesRef, labels.Labels, int64, float64) (storage.SeriesRef, error) {
+	return 0, ErrExceeded
+}
+
+func (rejectingAppender) AppendExemplar(storage.SeriesRef, labels.Labels, exemplar.Exemplar) (storage.SeriesRef, error) {
+	return 0, ErrExceeded
+}
+
+func (rejectingAppender) AppendHistogram(storage.SeriesRef, labels.Labels, int64, *histogram.Histogram, *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	return 0, ErrExceeded
+}
+
+func (rejectingAppender) AppendHistogramCTZeroSample(storage.SeriesRef, labels.Labels, int64, int64, *histogram.Histogram, *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	return 0, ErrExceeded
+}
+
+func (rejectingAppender) UpdateMetadata(storage.SeriesRef, labels.Labels, metadata.Metadata) (storage.SeriesRef, error) {
+	return 0, ErrExceeded
+}
+
+func (rejectingAppender) AppendCTZeroSample(storage.SeriesRef, labels.Labels, int64, int64) (storage.SeriesRef, error) {
+	return 0, ErrExceeded
+}
+
+func (rejectingAppender) SetOptions(*storage.AppendOptions) {}
+
+func (rejectingAppender) Commit() error { return nil }
+
+func (rejectingAppender) Rollback() error { return nil }
+
+// walSize returns the size of the WAL of the TSDB of the directory, with its checkpoints and its out-of-order WAL.
+func walSize(dir string) (int64, error) {
+	var size int64
+	for _, d := range []string{filepath.Join(dir, "wal"), filepath.Join(dir, wlog.WblDirName)} {
+		s, err := fileutil.DirSize(d)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		size += s
+	}
+	return size, nil
+}
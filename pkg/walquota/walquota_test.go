@@ -0,0 +1,96 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package walquota
+
+import (
+	"context"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+)
+
+func openTSDB(t *testing.T) *tsdb.DB {
+	t.Helper()
+
+	db, err := tsdb.Open(t.TempDir(), nil, nil, tsdb.DefaultOptions(), nil)
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, db.Close()) })
+
+	app := db.Appender(context.Background())
+	for i := int64(0); i <= 100; i++ {
+		_, err := app.Append(0, labels.FromStrings("__name__", "up", "job", "a"), i*1000, float64(i))
+		testutil.Ok(t, err)
+	}
+	testutil.Ok(t, app.Commit())
+	return db
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(log.NewNopLogger(), nil, 0, ActionReject)
+	testutil.NotOk(t, err)
+	_, err = New(log.NewNopLogger(), nil, 1, Action("drop-all"))
+	testutil.NotOk(t, err)
+}
+
+func TestQuota_Reject(t *testing.T) {
+	db := openTSDB(t)
+
+	// The WAL is far below the quota.
+	q, err := New(log.NewNopLogger(), nil, 1<<30, ActionReject)
+	testutil.Ok(t, err)
+	testutil.Ok(t, q.Check("a", db))
+	testutil.Assert(t, q.Allow("a"))
+
+	reg := prometheus.NewRegistry()
+	q, err = New(log.NewNopLogger(), reg, 1, ActionReject)
+	testutil.Ok(t, err)
+	testutil.Ok(t, q.Check("a", db))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(q.breaches.WithLabelValues("a")))
+
+	// The head is compacted into a block to truncate the WAL, which stays above the quota of a single byte.
+	testutil.Equals(t, 1, len(db.Blocks()))
+	testutil.Equals(t, int64(100000), db.Blocks()[0].Meta().MaxTime-1)
+	testutil.Equals(t, 1.0, promtest.ToFloat64(q.compactions.WithLabelValues("a")))
+
+	// Appends of the tenant above the quota are rejected, the others are not.
+	app := q.Appendable("a", db).Appender(context.Background())
+	_, err = app.Append(0, labels.FromStrings("__name__", "up", "job", "a"), 101000, 1)
+	testutil.Equals(t, ErrExceeded, err)
+	testutil.Ok(t, app.Rollback())
+	testutil.Assert(t, q.Allow("b"))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(q.rejected.WithLabelValues("a")))
+
+	app = q.Appendable("b", db).Appender(context.Background())
+	_, err = app.Append(0, labels.FromStrings("__name__", "up", "job", "b"), 101000, 1)
+	testutil.Ok(t, err)
+	testutil.Ok(t, app.Commit())
+
+	// Removed tenants are forgotten.
+	q.Remove("a")
+	testutil.Assert(t, q.Allow("a"))
+	testutil.Equals(t, 0, promtest.CollectAndCount(q.breaches))
+}
+
+func TestQuota_DropOldest(t *testing.T) {
+	db := openTSDB(t)
+
+	reg := prometheus.NewRegistry()
+	q, err := New(log.NewNopLogger(), reg, 1, ActionDropOldest)
+	testutil.Ok(t, err)
+	testutil.Ok(t, q.Check("a", db))
+
+	// Appends are not rejected, the oldest half of the head is compacted into a block and dropped instead.
+	testutil.Assert(t, q.Allow("a"))
+	testutil.Equals(t, 1, len(db.Blocks()))
+	testutil.Equals(t, int64(0), db.Blocks()[0].Meta().MinTime)
+	testutil.Equals(t, int64(50000), db.Blocks()[0].Meta().MaxTime)
+	testutil.Equals(t, int64(50000), db.Head().MinTime())
+	testutil.Equals(t, int64(100000), db.Head().MaxTime())
+	testutil.Equals(t, 1.0, promtest.ToFloat64(q.truncations.WithLabelValues("a")))
+}